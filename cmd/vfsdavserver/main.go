@@ -8,10 +8,15 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 
+	"github.com/freeflowuniverse/herolauncher/pkg/system/stats/metrics"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
 	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfslocal"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfsnested"
 	"github.com/freeflowuniverse/herolauncher/pkg/vfsdav"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -19,7 +24,23 @@ func main() {
 	port := flag.Int("port", 8080, "WebDAV server port")
 	host := flag.String("host", "localhost", "WebDAV server host")
 	rootDir := flag.String("dir", "", "Root directory for WebDAV server (defaults to temp directory if not specified)")
-	verbose := flag.Bool("verbose", true, "Enable verbose logging")
+	verbose := flag.Bool("verbose", true, "Write an access log to stdout unless another access log is configured")
+	accessLogFile := flag.String("access-log", "", "Write a JSON access log to this file (\"-\" for stdout)")
+	accessLogRedis := flag.String("access-log-redis", "", "Add access log entries to a Redis stream on this server, e.g. localhost:6379")
+	accessLogStream := flag.String("access-log-stream", "vfsdav:access", "Redis stream for the access log")
+	useHTTPS := flag.Bool("https", false, "Serve over HTTPS")
+	certFile := flag.String("cert", "", "TLS certificate file (a self-signed certificate is generated if missing)")
+	keyFile := flag.String("key", "", "TLS key file")
+	corsOrigins := flag.String("cors-origins", "*", "Comma separated origins allowed to make cross-origin requests (empty disables CORS)")
+	readOnly := flag.Bool("readonly", false, "Reject all requests that modify files")
+	readOnlyPaths := flag.String("readonly-paths", "", "Comma separated path prefixes that may not be modified, e.g. /public")
+	bandwidthLimit := flag.Int64("bandwidth-limit", 0, "Total bandwidth limit in bytes per second (0 for unlimited)")
+	connBandwidthLimit := flag.Int64("conn-bandwidth-limit", 0, "Bandwidth limit per connection in bytes per second (0 for unlimited)")
+	requestRate := flag.Float64("rate-limit", 0, "Maximum requests per second per client (0 for unlimited)")
+	requestBurst := flag.Int("rate-burst", 0, "Request burst allowed per client (defaults to the rate limit)")
+	compress := flag.Bool("compress", false, "Compress GET and PROPFIND responses with brotli or gzip")
+	metricsPath := flag.String("metrics-path", "", "Serve Prometheus metrics at this path, e.g. /metrics (empty disables metrics)")
+	usersSpec := flag.String("users", "", "Comma separated name:password users; each user is rooted into their own home directory under <dir>/home")
 	flag.Parse()
 
 	// Set up the root directory
@@ -61,27 +82,75 @@ func main() {
 	addr := fmt.Sprintf("%s:%d", *host, *port)
 	
 	// Create and configure the WebDAV server
-	server := vfsdav.NewServer(vfsImpl, addr)
+	options := vfsdav.DefaultOptions()
+	if *corsOrigins == "" {
+		options.CORS = nil
+	} else {
+		options.CORS.AllowedOrigins = nil
+		for _, origin := range strings.Split(*corsOrigins, ",") {
+			options.CORS.AllowedOrigins = append(options.CORS.AllowedOrigins, strings.TrimSpace(origin))
+		}
+	}
+	options.ReadOnly = *readOnly
+	for _, prefix := range strings.Split(*readOnlyPaths, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" {
+			options.ReadOnlyPaths = append(options.ReadOnlyPaths, prefix)
+		}
+	}
+	options.BandwidthLimit = *bandwidthLimit
+	options.ConnectionBandwidthLimit = *connBandwidthLimit
+	options.RequestsPerSecond = *requestRate
+	options.RequestBurst = *requestBurst
+	if *compress {
+		compression := vfsdav.DefaultCompressionOptions()
+		options.Compression = &compression
+	}
+	if *metricsPath != "" {
+		options.Metrics = metrics.Default
+		options.MetricsPath = *metricsPath
+	}
 	
-	// Set up a logger for WebDAV requests if verbose mode is enabled
-	if *verbose {
-		// Wrap the handler to add logging
-		originalHandler := server.Handler()
-		loggingHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			log.Printf("WebDAV: %s %s", r.Method, r.URL.Path)
-			originalHandler.ServeHTTP(w, r)
-		})
-		
-		// Replace the server's handler with our logging handler
-		http.Handle("/", loggingHandler)
+	// Set up the access log
+	switch {
+	case *accessLogRedis != "":
+		redisClient := redis.NewClient(&redis.Options{Addr: *accessLogRedis})
+		defer redisClient.Close()
+		options.AccessLog = vfsdav.NewRedisAccessLogger(redisClient, *accessLogStream, 100000)
+		log.Printf("Writing access log to Redis stream %s on %s", *accessLogStream, *accessLogRedis)
+	case *accessLogFile != "" && *accessLogFile != "-":
+		logFile, err := os.OpenFile(*accessLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Fatalf("Failed to open access log: %v", err)
+		}
+		defer logFile.Close()
+		options.AccessLog = vfsdav.NewJSONAccessLogger(logFile)
+		log.Printf("Writing access log to %s", *accessLogFile)
+	case *accessLogFile == "-" || *verbose:
+		options.AccessLog = vfsdav.NewJSONAccessLogger(os.Stdout)
+	}
+	var server *vfsdav.Server
+	if *usersSpec != "" {
+		server = newMultiUserServer(rootPath, *usersSpec, addr, options)
+	} else {
+		server = vfsdav.NewServerWithOptions(vfsImpl, addr, options)
 	}
 	
 	// Start the server in a goroutine
 	go func() {
-		log.Printf("Starting WebDAV server at http://%s", addr)
 		log.Printf("Serving files from: %s", rootPath)
 		
-		if err := server.ListenAndServe(); err != nil {
+		var err error
+		if *useHTTPS {
+			tlsOptions := vfsdav.DefaultTLSOptions()
+			tlsOptions.CertFile = *certFile
+			tlsOptions.KeyFile = *keyFile
+			log.Printf("Starting WebDAV server at https://%s", addr)
+			err = server.ListenAndServeTLS(tlsOptions)
+		} else {
+			log.Printf("Starting WebDAV server at http://%s", addr)
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
 	}()
@@ -99,9 +168,38 @@ func main() {
 	log.Println("Server is running. Press Ctrl+C to stop.")
 	<-stop
 	
+	server.Shutdown()
+	
 	log.Println("Server stopped")
 }
 
+// newMultiUserServer creates a server giving every user a home directory
+// under rootPath/home, created on first login
+func newMultiUserServer(rootPath, usersSpec, addr string, options vfsdav.Options) *vfsdav.Server {
+	parsed, err := vfsdav.ParseUsers(usersSpec)
+	if err != nil {
+		log.Fatalf("Invalid users: %v", err)
+	}
+	users := vfsdav.NewUserStore()
+	for _, user := range parsed {
+		if err := users.Add(user); err != nil {
+			log.Fatalf("Invalid users: %v", err)
+		}
+	}
+
+	newHome := func(user string) (vfs.VFSImplementation, error) {
+		homeDir := filepath.Join(rootPath, "home", user)
+		if err := os.MkdirAll(homeDir, 0755); err != nil {
+			return nil, err
+		}
+		log.Printf("Using home directory %s for user %s", homeDir, user)
+		return vfslocal.New(homeDir)
+	}
+
+	log.Printf("Serving home directories of %d users", len(parsed))
+	return vfsdav.NewMultiUserServer(vfsnested.New(), users, newHome, addr, options)
+}
+
 // createExampleFiles creates some example files in the root directory for testing
 func createExampleFiles(rootPath string) error {
 	// Create a text file
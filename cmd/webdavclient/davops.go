@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// lockBody requests an exclusive write lock
+const lockBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:">
+<D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype>
+<D:owner><D:href>webdavclient</D:href></D:owner>
+</D:lockinfo>`
+
+// lockedMethods are the methods that send LockToken in an If header
+var lockedMethods = map[string]bool{
+	"PUT": true, "DELETE": true, "MKCOL": true, "PROPPATCH": true, "MOVE": true, "COPY": true, "LOCK": true,
+}
+
+// MoveFile moves a file or directory to dest on the same server
+func (c *WebDAVClient) MoveFile(src, dest string, overwrite bool) error {
+	if err := c.moveOrCopy("MOVE", src, dest, overwrite); err != nil {
+		return err
+	}
+
+	c.printResult("move", src, fmt.Sprintf("Moved successfully: %s -> %s", src, dest))
+	return nil
+}
+
+// CopyFile copies a file or directory tree to dest on the same server
+func (c *WebDAVClient) CopyFile(src, dest string, overwrite bool) error {
+	if err := c.moveOrCopy("COPY", src, dest, overwrite); err != nil {
+		return err
+	}
+
+	c.printResult("copy", src, fmt.Sprintf("Copied successfully: %s -> %s", src, dest))
+	return nil
+}
+
+// moveOrCopy sends a MOVE or COPY request
+func (c *WebDAVClient) moveOrCopy(method, src, dest string, overwrite bool) error {
+	req, err := c.newRequest(method, src, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Destination", c.URL+(&url.URL{Path: cleanPath(dest)}).EscapedPath())
+	req.Header.Set("Depth", "infinity")
+	if overwrite {
+		req.Header.Set("Overwrite", "T")
+	} else {
+		req.Header.Set("Overwrite", "F")
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return &StatusError{Code: resp.StatusCode}
+	}
+	return nil
+}
+
+// LockFile takes an exclusive write lock on a path and returns its token. A
+// timeout of 0 asks for a lock that does not expire.
+func (c *WebDAVClient) LockFile(path string, timeout time.Duration) (string, error) {
+	req, err := c.newRequest("LOCK", path, strings.NewReader(lockBody))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", `application/xml; charset="utf-8"`)
+	req.Header.Set("Depth", "infinity")
+	if timeout > 0 {
+		req.Header.Set("Timeout", fmt.Sprintf("Second-%d", int64(timeout.Seconds())))
+	} else {
+		req.Header.Set("Timeout", "Infinite")
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return "", &StatusError{Code: resp.StatusCode}
+	}
+	token := strings.Trim(resp.Header.Get("Lock-Token"), "<>")
+	if token == "" {
+		return "", fmt.Errorf("server did not return a lock token")
+	}
+
+	if c.Format == "json" {
+		return token, printJSON(map[string]string{"action": "lock", "path": path, "status": "ok", "token": token})
+	}
+	fmt.Printf("Locked successfully: %s\nLock-Token: %s\n", path, token)
+	return token, nil
+}
+
+// UnlockFile releases the lock with the given token
+func (c *WebDAVClient) UnlockFile(path, token string) error {
+	req, err := c.newRequest("UNLOCK", path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Lock-Token", "<"+strings.Trim(token, "<>")+">")
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return &StatusError{Code: resp.StatusCode}
+	}
+
+	c.printResult("unlock", path, fmt.Sprintf("Unlocked successfully: %s", path))
+	return nil
+}
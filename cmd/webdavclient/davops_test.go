@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMoveAndCopy(t *testing.T) {
+	server := &putServer{}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client := NewClient(httpServer.URL, "", "")
+
+	tests := []struct {
+		method    string
+		src, dest string
+		overwrite bool
+		status    int
+	}{
+		{"MOVE", "/file", "/my notes", false, 0},
+		{"COPY", "/file", "/other", true, 0},
+		{"COPY", "/file", "/file", true, 0},
+		{"COPY", "/file", "/file", false, http.StatusPreconditionFailed},
+		{"MOVE", "/missing", "/other", false, http.StatusNotFound},
+	}
+	for _, test := range tests {
+		var err error
+		if test.method == "MOVE" {
+			err = client.MoveFile(test.src, test.dest, test.overwrite)
+		} else {
+			err = client.CopyFile(test.src, test.dest, test.overwrite)
+		}
+		if (test.status == 0 && err != nil) || (test.status != 0 && !isStatus(err, test.status)) {
+			t.Errorf("%s %s %s: expected status %d, got %v", test.method, test.src, test.dest, test.status, err)
+		}
+
+		// The destination is a URL on the same server, and trees are moved
+		// and copied as a whole
+		overwrite := "F"
+		if test.overwrite {
+			overwrite = "T"
+		}
+		destination := httpServer.URL + strings.ReplaceAll(test.dest, " ", "%20")
+		if server.header.Get("Destination") != destination || server.header.Get("Overwrite") != overwrite || server.header.Get("Depth") != "infinity" {
+			t.Errorf("%s %s %s: unexpected headers %v", test.method, test.src, test.dest, server.header)
+		}
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	server := &putServer{}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client := NewClient(httpServer.URL, "", "")
+
+	tests := []struct {
+		timeout time.Duration
+		header  string
+	}{
+		{0, "Infinite"},
+		{10 * time.Minute, "Second-600"},
+	}
+	for _, test := range tests {
+		token, err := client.LockFile("/file", test.timeout)
+		if err != nil || token != putServerLock {
+			t.Fatalf("Expected the lock token, got %q, %v", token, err)
+		}
+		if got := server.header.Get("Timeout"); got != test.header {
+			t.Errorf("Expected the timeout %s, got %s", test.header, got)
+		}
+	}
+
+	// The token is sent with requests that modify the locked file, but not
+	// with reads
+	client.LockToken = putServerLock
+	if err := client.CopyFile("/file", "/other", true); err != nil {
+		t.Fatalf("Failed to copy: %v", err)
+	}
+	if got, want := server.header.Get("If"), "(<"+putServerLock+">)"; got != want {
+		t.Errorf("Expected the If header %s, got %q", want, got)
+	}
+	if _, err := client.Propfind("/file", "0"); err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+	if got := server.header.Get("If"); got != "" {
+		t.Errorf("Expected no If header with PROPFIND, got %q", got)
+	}
+
+	if err := client.UnlockFile("/file", "<"+putServerLock+">"); err != nil {
+		t.Errorf("Failed to unlock: %v", err)
+	}
+	if err := client.UnlockFile("/file", "opaquelocktoken:other"); !isStatus(err, http.StatusConflict) {
+		t.Errorf("Expected the unlock with another token to fail, got %v", err)
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -17,6 +19,26 @@ type WebDAVClient struct {
 	Username string
 	Password string
 	Client   *http.Client
+	// Format is "text" for human readable output or "json"
+	Format string
+	// Concurrency is the number of parallel transfers of UploadTree and
+	// DownloadTree, which retry transient failures up to Retries times
+	Concurrency int
+	Retries     int
+	// ChunkSize splits larger uploads into partial PUT requests, so failed
+	// uploads can be resumed; 0 uploads files in a single request
+	ChunkSize int64
+	// Resume continues uploads of files that already exist partially on the
+	// server, when they were interrupted uploads of the same local file
+	Resume bool
+	// StateDir keeps the records of running uploads that Resume needs; empty
+	// uses a directory in the user cache directory
+	StateDir string
+	// Quiet disables the progress output of uploads and downloads on stderr
+	Quiet bool
+	// LockToken is sent in an If header with requests that modify resources,
+	// so paths locked with LockFile can be changed
+	LockToken string
 }
 
 // NewClient creates a new WebDAV client
@@ -28,109 +50,93 @@ func NewClient(url, username, password string) *WebDAVClient {
 		Client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		Format:      "text",
+		Concurrency: 4,
+		Retries:     3,
+		ChunkSize:   8 << 20,
 	}
 }
 
-// ListFiles lists files and directories at the specified path
-func (c *WebDAVClient) ListFiles(path string) error {
-	req, err := http.NewRequest("PROPFIND", c.URL+path, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
+// StatusError is returned when the server answers with an unexpected status
+type StatusError struct {
+	Code int
+}
 
-	// Set depth to 1 to list immediate children
-	req.Header.Add("Depth", "1")
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.Code)
+}
 
-	// Add basic authentication if credentials are provided
+// newRequest creates a request for a path on the server, with basic
+// authentication if credentials are provided
+func (c *WebDAVClient) newRequest(method, remotePath string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.URL+(&url.URL{Path: remotePath}).EscapedPath(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
 	if c.Username != "" && c.Password != "" {
 		req.SetBasicAuth(c.Username, c.Password)
 	}
+	if c.LockToken != "" && lockedMethods[method] {
+		req.Header.Set("If", "(<"+strings.Trim(c.LockToken, "<>")+">)")
+	}
+	return req, nil
+}
 
-	resp, err := c.Client.Do(req)
+// printResult reports a completed action, as JSON when requested
+func (c *WebDAVClient) printResult(action, path, message string) {
+	if c.Format == "json" {
+		printJSON(map[string]string{"action": action, "path": path, "status": "ok"})
+		return
+	}
+	fmt.Println(message)
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// ListFiles lists files and directories at the specified path
+func (c *WebDAVClient) ListFiles(path string) error {
+	files, err := c.Propfind(path, "1")
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusMultiStatus && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	// Leave out the listed directory itself
+	entries := make([]FileInfo, 0, len(files))
+	for _, file := range files {
+		if file.IsDir && file.Path == cleanPath(path) {
+			continue
+		}
+		entries = append(entries, file)
 	}
 
-	// Read the response
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+	if c.Format == "json" {
+		return printJSON(entries)
 	}
 
 	fmt.Printf("Connected to WebDAV server at %s\n\n", c.URL)
 	fmt.Printf("Directory listing for: %s\n", path)
 	fmt.Println("----------------------------------------")
 
-	// Parse the XML response to extract file information
-	if strings.Contains(string(body), "<D:multistatus") {
-		// Simple parsing of the XML response to extract file names and properties
-		responses := strings.Split(string(body), "<D:response>")
-		
-		// Skip the first split result as it's the XML header
-		for i, response := range responses {
-			if i == 0 {
-				continue
-			}
-			
-			// Extract the href (path)
-			hrefStart := strings.Index(response, "<D:href>")
-			hrefEnd := strings.Index(response, "</D:href>")
-			if hrefStart == -1 || hrefEnd == -1 {
-				continue
-			}
-			
-			href := response[hrefStart+8:hrefEnd]
-			
-			// Skip the current directory entry
-			if href == path || href+"/" == path {
-				continue
-			}
-			
-			// Extract file name from href
-			name := filepath.Base(href)
-			if name == "." || name == "" {
-				name = href
-			}
-			
-			// Check if it's a directory
-			isDir := strings.Contains(response, "<D:collection")
-			
-			// Extract last modified time
-			lastModified := "Unknown"
-			lmStart := strings.Index(response, "<D:getlastmodified>")
-			lmEnd := strings.Index(response, "</D:getlastmodified>")
-			if lmStart != -1 && lmEnd != -1 {
-				lastModified = response[lmStart+19:lmEnd]
-			}
-			
-			// Extract content length for files
-			size := "-"
-			if !isDir {
-				clStart := strings.Index(response, "<D:getcontentlength>")
-				clEnd := strings.Index(response, "</D:getcontentlength>")
-				if clStart != -1 && clEnd != -1 {
-					size = response[clStart+20:clEnd] + " bytes"
-				}
-			}
-			
-			// Format and print the entry
-			fileType := "File"
-			if isDir {
-				fileType = "Directory"
-				name += "/"
-			}
-			
-			fmt.Printf("%-12s %-30s %-20s %s\n", fileType, name, size, lastModified)
+	for _, entry := range entries {
+		fileType := "File"
+		name := entry.Name
+		size := fmt.Sprintf("%d bytes", entry.Size)
+		if entry.IsDir {
+			fileType = "Directory"
+			name += "/"
+			size = "-"
 		}
-	} else {
-		// Fallback to displaying the raw response
-		fmt.Printf("Status: %s\n", resp.Status)
-		fmt.Printf("Response:\n%s\n", string(body))
+		lastModified := "Unknown"
+		if !entry.ModTime.IsZero() {
+			lastModified = entry.ModTime.Format(http.TimeFormat)
+		}
+
+		fmt.Printf("%-12s %-30s %-20s %s\n", fileType, name, size, lastModified)
 	}
 
 	fmt.Println("\nUse -action upload to upload files or -action mkdir to create directories")
@@ -139,90 +145,50 @@ func (c *WebDAVClient) ListFiles(path string) error {
 
 // UploadFile uploads a file to the WebDAV server
 func (c *WebDAVClient) UploadFile(localPath, remotePath string) error {
-	file, err := os.Open(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
-
-	req, err := http.NewRequest("PUT", c.URL+remotePath, file)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Add basic authentication if credentials are provided
-	if c.Username != "" && c.Password != "" {
-		req.SetBasicAuth(c.Username, c.Password)
-	}
-
-	resp, err := c.Client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+	size := int64(-1)
+	if info, err := os.Stat(localPath); err == nil {
+		size = info.Size()
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	progress := c.newProgress()
+	result := c.transfer(transferJob{upload: true, local: localPath, remote: remotePath, size: size, progress: progress.file(remotePath, size)})
+	progress.close()
+	if result.err != nil {
+		return result.err
 	}
 
-	fmt.Printf("File uploaded successfully: %s -> %s\n", localPath, remotePath)
+	c.printResult("upload", remotePath, fmt.Sprintf("File uploaded successfully: %s -> %s", localPath, remotePath))
 	return nil
 }
 
 // DownloadFile downloads a file from the WebDAV server
 func (c *WebDAVClient) DownloadFile(remotePath, localPath string) error {
-	req, err := http.NewRequest("GET", c.URL+remotePath, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Add basic authentication if credentials are provided
-	if c.Username != "" && c.Password != "" {
-		req.SetBasicAuth(c.Username, c.Password)
+	progress := c.newProgress()
+	result := c.transfer(transferJob{local: localPath, remote: remotePath, size: -1, progress: progress.file(remotePath, -1)})
+	progress.close()
+	if result.err != nil {
+		return result.err
 	}
 
-	resp, err := c.Client.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
-	// Create the directory if it doesn't exist
-	dir := filepath.Dir(localPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
-	}
-
-	// Create the file
-	file, err := os.Create(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
-	}
-	defer file.Close()
-
-	// Copy the response body to the file
-	if _, err := io.Copy(file, resp.Body); err != nil {
-		return fmt.Errorf("failed to write file: %w", err)
-	}
-
-	fmt.Printf("File downloaded successfully: %s -> %s\n", remotePath, localPath)
+	c.printResult("download", remotePath, fmt.Sprintf("File downloaded successfully: %s -> %s", remotePath, localPath))
 	return nil
 }
 
 // CreateDirectory creates a directory on the WebDAV server
 func (c *WebDAVClient) CreateDirectory(path string) error {
-	req, err := http.NewRequest("MKCOL", c.URL+path, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+	if err := c.mkcol(path); err != nil {
+		return err
 	}
 
-	// Add basic authentication if credentials are provided
-	if c.Username != "" && c.Password != "" {
-		req.SetBasicAuth(c.Username, c.Password)
+	c.printResult("mkdir", path, fmt.Sprintf("Directory created successfully: %s", path))
+	return nil
+}
+
+// mkcol creates a single directory
+func (c *WebDAVClient) mkcol(path string) error {
+	req, err := c.newRequest("MKCOL", path, nil)
+	if err != nil {
+		return err
 	}
 
 	resp, err := c.Client.Do(req)
@@ -232,23 +198,26 @@ func (c *WebDAVClient) CreateDirectory(path string) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return &StatusError{Code: resp.StatusCode}
 	}
-
-	fmt.Printf("Directory created successfully: %s\n", path)
 	return nil
 }
 
 // DeleteFile deletes a file or directory from the WebDAV server
 func (c *WebDAVClient) DeleteFile(path string) error {
-	req, err := http.NewRequest("DELETE", c.URL+path, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+	if err := c.delete(path); err != nil {
+		return err
 	}
 
-	// Add basic authentication if credentials are provided
-	if c.Username != "" && c.Password != "" {
-		req.SetBasicAuth(c.Username, c.Password)
+	c.printResult("delete", path, fmt.Sprintf("File or directory deleted successfully: %s", path))
+	return nil
+}
+
+// delete removes a single file or directory
+func (c *WebDAVClient) delete(path string) error {
+	req, err := c.newRequest("DELETE", path, nil)
+	if err != nil {
+		return err
 	}
 
 	resp, err := c.Client.Do(req)
@@ -258,10 +227,8 @@ func (c *WebDAVClient) DeleteFile(path string) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return &StatusError{Code: resp.StatusCode}
 	}
-
-	fmt.Printf("File or directory deleted successfully: %s\n", path)
 	return nil
 }
 
@@ -371,19 +338,75 @@ func main() {
 	url := flag.String("url", "http://localhost:9999", "WebDAV server URL")
 	username := flag.String("username", "", "Username for basic authentication")
 	password := flag.String("password", "", "Password for basic authentication")
-	action := flag.String("action", "test", "Action to perform: test, list, upload, download, mkdir, delete")
+	action := flag.String("action", "test", "Action to perform: test, list, upload, download, mirror, mkdir, delete, move, copy, lock, unlock")
 	path := flag.String("path", "/", "Path on the WebDAV server")
-	localFile := flag.String("local", "", "Local file path for upload/download")
+	localFile := flag.String("local", "", "Local file or directory path for upload/download")
 	debug := flag.Bool("debug", false, "Enable debug mode")
+	format := flag.String("format", "text", "Output format: text or json")
+	concurrency := flag.Int("concurrency", 4, "Number of parallel transfers when uploading or downloading a directory")
+	retries := flag.Int("retries", 3, "Retries of a file transfer failing with a transient error")
+	chunkSize := flag.Int64("chunk-size", 8<<20, "Upload files larger than this in resumable chunks of this many bytes (0 disables chunking)")
+	resume := flag.Bool("resume", false, "Resume interrupted uploads of the same files that are partially present on the server")
+	quiet := flag.Bool("quiet", false, "Do not report transfer progress on stderr")
+	deleteExtra := flag.Bool("delete", false, "Delete remote files missing locally when mirroring")
+	profileName := flag.String("profile", "", "Server profile to connect with, overriding -url, -username and -password unless given")
+	configPath := flag.String("config", defaultConfigPath(), "YAML file with the server profiles")
+	dest := flag.String("dest", "", "Destination path on the WebDAV server for move and copy")
+	overwrite := flag.Bool("overwrite", true, "Replace an existing destination when moving or copying")
+	lockToken := flag.String("lock-token", "", "Lock token to unlock, or to modify a locked path with")
+	lockTimeout := flag.Duration("lock-timeout", 0, "Duration of a lock, 0 for no expiry")
 
 	flag.Parse()
 
-	if *debug {
-		log.Printf("Connecting to WebDAV server at %s", *url)
+	// The action, path and destination can also be given as arguments, e.g. "list /"
+	if flag.NArg() > 0 {
+		*action = flag.Arg(0)
+	}
+	if flag.NArg() > 1 {
+		*path = flag.Arg(1)
+	}
+	if flag.NArg() > 2 {
+		*dest = flag.Arg(2)
 	}
 
 	// Create WebDAV client
-	client := NewClient(*url, *username, *password)
+	var client *WebDAVClient
+	if *profileName != "" {
+		profile, err := LoadProfile(*configPath, *profileName)
+		if err != nil {
+			log.Fatalf("Failed to load profile: %v", err)
+		}
+		// Flags given on the command line take precedence over the profile
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "url":
+				profile.URL = *url
+			case "username":
+				profile.Username = *username
+			case "password":
+				profile.Password = *password
+			}
+		})
+		if client, err = NewClientFromProfile(profile); err != nil {
+			log.Fatalf("Failed to create client: %v", err)
+		}
+	} else {
+		client = NewClient(*url, *username, *password)
+	}
+
+	if *debug {
+		log.Printf("Connecting to WebDAV server at %s", client.URL)
+	}
+	if *format != "text" && *format != "json" {
+		log.Fatalf("Unknown output format: %s", *format)
+	}
+	client.Format = *format
+	client.Concurrency = *concurrency
+	client.Retries = *retries
+	client.ChunkSize = *chunkSize
+	client.Resume = *resume
+	client.Quiet = *quiet
+	client.LockToken = *lockToken
 
 	// Create a test file if we're uploading and no local file is specified
 	if *action == "upload" && *localFile == "" {
@@ -418,6 +441,13 @@ func main() {
 		if remotePath == "/" {
 			remotePath = "/" + filepath.Base(*localFile)
 		}
+		if info, statErr := os.Stat(*localFile); statErr == nil && info.IsDir() {
+			var summary *TransferSummary
+			if summary, err = client.UploadTree(*localFile, remotePath); err == nil {
+				err = client.printSummary(summary)
+			}
+			break
+		}
 		err = client.UploadFile(*localFile, remotePath)
 	case "download":
 		if *localFile == "" {
@@ -426,7 +456,25 @@ func main() {
 		if *debug {
 			log.Printf("Downloading %s to %s", *path, *localFile)
 		}
+		if files, statErr := client.Propfind(*path, "0"); statErr == nil && len(files) == 1 && files[0].IsDir {
+			var summary *TransferSummary
+			if summary, err = client.DownloadTree(*path, *localFile); err == nil {
+				err = client.printSummary(summary)
+			}
+			break
+		}
 		err = client.DownloadFile(*path, *localFile)
+	case "mirror":
+		if *localFile == "" {
+			log.Fatalf("Local directory path is required for mirror")
+		}
+		if *debug {
+			log.Printf("Mirroring %s to %s", *localFile, *path)
+		}
+		var summary *MirrorSummary
+		if summary, err = client.Mirror(*localFile, *path, *deleteExtra); err == nil {
+			err = client.printMirrorSummary(summary)
+		}
 	case "mkdir":
 		if *debug {
 			log.Printf("Creating directory %s", *path)
@@ -437,6 +485,31 @@ func main() {
 			log.Printf("Deleting %s", *path)
 		}
 		err = client.DeleteFile(*path)
+	case "move", "copy":
+		if *dest == "" {
+			log.Fatalf("Destination path is required for %s", *action)
+		}
+		if *debug {
+			log.Printf("Running %s of %s to %s", *action, *path, *dest)
+		}
+		if *action == "move" {
+			err = client.MoveFile(*path, *dest, *overwrite)
+		} else {
+			err = client.CopyFile(*path, *dest, *overwrite)
+		}
+	case "lock":
+		if *debug {
+			log.Printf("Locking %s", *path)
+		}
+		_, err = client.LockFile(*path, *lockTimeout)
+	case "unlock":
+		if *lockToken == "" {
+			log.Fatalf("Lock token is required for unlock")
+		}
+		if *debug {
+			log.Printf("Unlocking %s", *path)
+		}
+		err = client.UnlockFile(*path, *lockToken)
 	default:
 		log.Fatalf("Unknown action: %s", *action)
 	}
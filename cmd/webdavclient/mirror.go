@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// MirrorSummary reports the outcome of a mirror operation
+type MirrorSummary struct {
+	*TransferSummary
+	Unchanged int      `json:"unchanged"`
+	Deleted   []string `json:"deleted"`
+}
+
+// Mirror makes remoteDir a copy of localDir, uploading only the files that
+// are missing or changed on the server. A file is unchanged when a checksum
+// the server exposes matches the local file, or, without checksums, when the
+// size matches and the local file is not newer than the remote one. With
+// deleteExtra, remote files and directories missing locally are deleted.
+func (c *WebDAVClient) Mirror(localDir, remoteDir string, deleteExtra bool) (*MirrorSummary, error) {
+	dirs, jobs, err := walkLocal(localDir, remoteDir)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteFiles, err := c.walkRemote(remoteDir)
+	if err != nil && !isStatus(err, http.StatusNotFound) {
+		return nil, err
+	}
+	remote := make(map[string]FileInfo, len(remoteFiles))
+	for _, file := range remoteFiles {
+		remote[file.Path] = file
+	}
+
+	start := time.Now()
+	summary := &MirrorSummary{Deleted: []string{}}
+	local := make(map[string]bool, len(dirs)+len(jobs))
+
+	for _, dir := range dirs {
+		local[dir] = true
+		if file, ok := remote[dir]; ok && !file.IsDir {
+			if err := c.remove(dir, summary); err != nil {
+				return nil, err
+			}
+		} else if ok {
+			continue
+		}
+		if err := c.mkcol(dir); err != nil && !isStatus(err, http.StatusMethodNotAllowed) {
+			return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+	}
+
+	var changed []transferJob
+	for _, job := range jobs {
+		local[job.remote] = true
+		file, ok := remote[job.remote]
+		switch {
+		case !ok:
+		case file.IsDir:
+			if err := c.remove(job.remote, summary); err != nil {
+				return nil, err
+			}
+		case unchanged(job, file):
+			summary.Unchanged++
+			continue
+		}
+		changed = append(changed, job)
+	}
+
+	summary.TransferSummary = c.runTransfers(changed)
+
+	if deleteExtra {
+		// walkRemote lists parents first, so deleting a directory skips
+		// everything it contained
+		deleted := make(map[string]bool)
+		for _, file := range remoteFiles {
+			if local[file.Path] || deleted[path.Dir(file.Path)] {
+				deleted[file.Path] = deleted[path.Dir(file.Path)]
+				continue
+			}
+			if err := c.delete(file.Path); err != nil {
+				summary.Failed = append(summary.Failed, TransferResult{Remote: file.Path, Attempts: 1, Error: err.Error(), err: err})
+				continue
+			}
+			summary.Deleted = append(summary.Deleted, file.Path)
+			deleted[file.Path] = true
+		}
+	}
+
+	summary.Duration = time.Since(start)
+	return summary, nil
+}
+
+// remove deletes a remote path that is replaced by a local one of another type
+func (c *WebDAVClient) remove(remotePath string, summary *MirrorSummary) error {
+	if err := c.delete(remotePath); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", remotePath, err)
+	}
+	summary.Deleted = append(summary.Deleted, remotePath)
+	return nil
+}
+
+// unchanged reports whether the remote file holds the same content as the
+// local file of the upload job
+func unchanged(job transferJob, file FileInfo) bool {
+	if file.Size != job.size {
+		return false
+	}
+	if match, ok := checksumMatches(job.local, file.Checksum); ok {
+		return match
+	}
+	// getlastmodified has a resolution of one second
+	return !job.modTime.Truncate(time.Second).After(file.ModTime)
+}
+
+// checksumMatches compares a local file against the first supported checksum
+// of a list like "SHA1:<hex> MD5:<hex>". ok is false when the list has no
+// supported checksum or the file cannot be read.
+func checksumMatches(localPath, checksums string) (match, ok bool) {
+	for _, checksum := range strings.Fields(checksums) {
+		algorithm, sum, found := strings.Cut(checksum, ":")
+		if !found {
+			continue
+		}
+
+		var h hash.Hash
+		switch strings.ToUpper(algorithm) {
+		case "SHA256":
+			h = sha256.New()
+		case "SHA1":
+			h = sha1.New()
+		case "MD5":
+			h = md5.New()
+		default:
+			continue
+		}
+
+		file, err := os.Open(localPath)
+		if err != nil {
+			return false, false
+		}
+		_, err = io.Copy(h, file)
+		file.Close()
+		if err != nil {
+			return false, false
+		}
+		return strings.EqualFold(hex.EncodeToString(h.Sum(nil)), sum), true
+	}
+	return false, false
+}
+
+// printMirrorSummary reports the outcome of a mirror operation
+func (c *WebDAVClient) printMirrorSummary(summary *MirrorSummary) error {
+	if c.Format == "json" {
+		return printJSON(summary)
+	}
+
+	fmt.Printf("%d files unchanged, %d deleted\n", summary.Unchanged, len(summary.Deleted))
+	for _, deleted := range summary.Deleted {
+		fmt.Printf("  deleted %s\n", deleted)
+	}
+	return c.printSummary(summary.TransferSummary)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnchanged(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(localPath, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	job := transferJob{upload: true, local: localPath, size: 5, modTime: modTime}
+
+	const (
+		sha256Hello = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+		sha1Hello   = "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+		md5Hello    = "5d41402abc4b2a76b9719d911017c592"
+	)
+	tests := []struct {
+		name string
+		file FileInfo
+		want bool
+	}{
+		{"other size", FileInfo{Size: 4, ModTime: modTime}, false},
+		{"same modification time", FileInfo{Size: 5, ModTime: modTime.Truncate(time.Second)}, true},
+		{"newer remote file", FileInfo{Size: 5, ModTime: modTime.Add(time.Hour)}, true},
+		{"older remote file", FileInfo{Size: 5, ModTime: modTime.Add(-time.Second)}, false},
+		// A checksum decides over the modification time
+		{"matching SHA256", FileInfo{Size: 5, Checksum: "SHA256:" + sha256Hello}, true},
+		{"matching SHA1", FileInfo{Size: 5, Checksum: "sha1:" + sha1Hello}, true},
+		{"matching MD5", FileInfo{Size: 5, Checksum: "MD5:" + md5Hello}, true},
+		{"other checksum", FileInfo{Size: 5, ModTime: modTime.Add(time.Hour), Checksum: "SHA1:" + md5Hello}, false},
+		{"first supported checksum", FileInfo{Size: 5, Checksum: "ADLER32:062c0215 MD5:" + md5Hello}, true},
+		{"unsupported checksum", FileInfo{Size: 5, ModTime: modTime.Add(-time.Hour), Checksum: "ADLER32:062c0215"}, false},
+	}
+	for _, test := range tests {
+		if got := unchanged(job, test.file); got != test.want {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestChecksumMatches(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(localPath, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path      string
+		checksums string
+		match, ok bool
+	}{
+		{localPath, "MD5:5D41402ABC4B2A76B9719D911017C592", true, true},
+		{localPath, "MD5:00000000000000000000000000000000", false, true},
+		{localPath, "MD5", false, false},
+		{localPath, "", false, false},
+		{filepath.Join(filepath.Dir(localPath), "missing"), "MD5:5d41402abc4b2a76b9719d911017c592", false, false},
+	}
+	for _, test := range tests {
+		match, ok := checksumMatches(test.path, test.checksums)
+		if match != test.match || ok != test.ok {
+			t.Errorf("%q: expected %v, %v, got %v, %v", test.checksums, test.match, test.ok, match, ok)
+		}
+	}
+}
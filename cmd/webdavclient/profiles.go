@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile holds the connection settings of a named server. Profiles are
+// defined in a YAML file:
+//
+//	profiles:
+//	  work:
+//	    url: https://dav.example.com
+//	    username: alice
+//	    password_env: WORK_DAV_PASSWORD
+//	    ca_cert: /etc/ssl/work-ca.pem
+//
+// The password is taken from password, the environment variable named by
+// password_env, or the output of password_cmd, which can read it from a
+// secrets store like `pass show dav/work`.
+type Profile struct {
+	Name        string `yaml:"-"`
+	URL         string `yaml:"url"`
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	PasswordEnv string `yaml:"password_env"`
+	PasswordCmd string `yaml:"password_cmd"`
+	// Insecure skips verification of the server certificate
+	Insecure bool `yaml:"insecure"`
+	// CACert is a PEM file with the certificates that sign the server certificate
+	CACert string `yaml:"ca_cert"`
+	// ClientCert and ClientKey are PEM files for TLS client authentication
+	ClientCert string `yaml:"client_cert"`
+	ClientKey  string `yaml:"client_key"`
+}
+
+// defaultConfigPath returns the profiles file used when -config is not given
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "webdavclient.yaml"
+	}
+	return filepath.Join(dir, "hero", "webdavclient.yaml")
+}
+
+// LoadProfiles reads the profiles of a YAML config file
+func LoadProfiles(configPath string) (map[string]*Profile, error) {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var config struct {
+		Profiles map[string]*Profile `yaml:"profiles"`
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", configPath, err)
+	}
+
+	for name, profile := range config.Profiles {
+		if profile == nil || profile.URL == "" {
+			return nil, fmt.Errorf("profile %s in %s needs a url", name, configPath)
+		}
+		if (profile.ClientCert == "") != (profile.ClientKey == "") {
+			return nil, fmt.Errorf("profile %s needs both client_cert and client_key", name)
+		}
+		profile.Name = name
+	}
+	return config.Profiles, nil
+}
+
+// LoadProfile returns the named profile of a config file
+func LoadProfile(configPath, name string) (*Profile, error) {
+	profiles, err := LoadProfiles(configPath)
+	if err != nil {
+		return nil, err
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("profile %s not found in %s (available: %s)", name, configPath, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+// ResolvePassword returns the password of the profile from the first source
+// that is set
+func (p *Profile) ResolvePassword() (string, error) {
+	switch {
+	case p.Password != "":
+		return p.Password, nil
+	case p.PasswordEnv != "":
+		password, ok := os.LookupEnv(p.PasswordEnv)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", p.PasswordEnv)
+		}
+		return password, nil
+	case p.PasswordCmd != "":
+		args := strings.Fields(p.PasswordCmd)
+		if len(args) == 0 {
+			return "", fmt.Errorf("password_cmd is empty")
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("password command failed: %w", err)
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	}
+	return "", nil
+}
+
+// TLSConfig returns the TLS settings of the profile, or nil for the defaults
+func (p *Profile) TLSConfig() (*tls.Config, error) {
+	if !p.Insecure && p.CACert == "" && p.ClientCert == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: p.Insecure}
+	if p.CACert != "" {
+		pem, err := os.ReadFile(p.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", p.CACert)
+		}
+	}
+	if p.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(p.ClientCert, p.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// NewClientFromProfile creates a client with the settings of a profile
+func NewClientFromProfile(profile *Profile) (*WebDAVClient, error) {
+	password, err := profile.ResolvePassword()
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %w", profile.Name, err)
+	}
+	config, err := profile.TLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("profile %s: %w", profile.Name, err)
+	}
+
+	client := NewClient(profile.URL, profile.Username, password)
+	if config != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = config
+		client.Client.Transport = transport
+	}
+	return client, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a profiles file and returns its path
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "webdavclient.yaml")
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return configPath
+}
+
+func TestLoadProfiles(t *testing.T) {
+	configPath := writeConfig(t, `profiles:
+  work:
+    url: https://dav.example.com
+    username: alice
+    password_env: WORK_DAV_PASSWORD
+  home:
+    url: http://nas.local/dav
+    insecure: true
+`)
+	profiles, err := LoadProfiles(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load profiles: %v", err)
+	}
+	work := profiles["work"]
+	if len(profiles) != 2 || work == nil || work.Name != "work" || work.URL != "https://dav.example.com" ||
+		work.Username != "alice" || work.PasswordEnv != "WORK_DAV_PASSWORD" || !profiles["home"].Insecure {
+		t.Errorf("Unexpected profiles %+v", profiles)
+	}
+
+	if _, err := LoadProfile(configPath, "other"); err == nil || !strings.Contains(err.Error(), "available: home, work") {
+		t.Errorf("Expected the available profiles in the error, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"missing url", "profiles:\n  work:\n    username: alice\n", "needs a url"},
+		{"empty profile", "profiles:\n  work:\n", "needs a url"},
+		{"client certificate without key", "profiles:\n  work:\n    url: https://dav.example.com\n    client_cert: cert.pem\n", "needs both client_cert and client_key"},
+		{"invalid YAML", "profiles: [", "failed to parse config"},
+	}
+	for _, test := range tests {
+		if _, err := LoadProfiles(writeConfig(t, test.content)); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", test.name, test.want, err)
+		}
+	}
+	if _, err := LoadProfiles(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Expected an error for a missing config file")
+	}
+}
+
+func TestResolvePassword(t *testing.T) {
+	t.Setenv("WEBDAVCLIENT_TEST_PASSWORD", "from env")
+
+	tests := []struct {
+		name    string
+		profile Profile
+		want    string
+		wantErr bool
+	}{
+		{"password", Profile{Password: "plain", PasswordEnv: "WEBDAVCLIENT_TEST_PASSWORD"}, "plain", false},
+		{"environment variable", Profile{PasswordEnv: "WEBDAVCLIENT_TEST_PASSWORD", PasswordCmd: "echo other"}, "from env", false},
+		{"missing environment variable", Profile{PasswordEnv: "WEBDAVCLIENT_TEST_MISSING"}, "", true},
+		{"command", Profile{PasswordCmd: "echo  from  command"}, "from command", false},
+		{"failing command", Profile{PasswordCmd: "false"}, "", true},
+		{"blank command", Profile{PasswordCmd: "  "}, "", true},
+		{"no password", Profile{}, "", false},
+	}
+	for _, test := range tests {
+		password, err := test.profile.ResolvePassword()
+		if password != test.want || (err != nil) != test.wantErr {
+			t.Errorf("%s: expected %q, error %v, got %q, %v", test.name, test.want, test.wantErr, password, err)
+		}
+	}
+}
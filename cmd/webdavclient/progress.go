@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// progressInterval is how often the progress line is redrawn on a terminal
+const progressInterval = 200 * time.Millisecond
+
+// progress reports the bytes transferred by one upload or download operation
+// on stderr. On a terminal a status line with the total progress, speed and
+// ETA is redrawn; otherwise only a line per finished file is written.
+type progress struct {
+	out  io.Writer
+	live bool
+
+	mu          sync.Mutex
+	start       time.Time
+	files       int
+	finished    int
+	total       int64
+	done        int64
+	transferred int64
+	current     string
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// fileProgress tracks a single file of an operation
+type fileProgress struct {
+	p    *progress
+	name string
+	size int64
+	done int64
+}
+
+// newProgress starts reporting progress, or returns nil when Quiet is set
+func (c *WebDAVClient) newProgress() *progress {
+	if c.Quiet {
+		return nil
+	}
+
+	p := &progress{out: os.Stderr, start: time.Now(), stop: make(chan struct{})}
+	if info, err := os.Stderr.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		p.live = true
+		p.wg.Add(1)
+		go p.redraw()
+	}
+	return p
+}
+
+// file registers a file of the operation; size is -1 when not known yet
+func (p *progress) file(name string, size int64) *fileProgress {
+	if p == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.files++
+	if size > 0 {
+		p.total += size
+	}
+	return &fileProgress{p: p, name: name, size: size}
+}
+
+// close stops redrawing and prints the totals
+func (p *progress) close() {
+	if p == nil {
+		return
+	}
+	if p.live {
+		close(p.stop)
+		p.wg.Wait()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.files > 1 || p.live {
+		p.clearLine()
+		fmt.Fprintf(p.out, "%d/%d files, %s in %s (%s/s)\n", p.finished, p.files, formatBytes(p.done),
+			time.Since(p.start).Round(time.Millisecond), formatBytes(p.speed()))
+	}
+}
+
+// redraw updates the status line until the operation is closed
+func (p *progress) redraw() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			p.clearLine()
+			fmt.Fprint(p.out, p.status())
+			p.mu.Unlock()
+		}
+	}
+}
+
+// status formats the progress line; the caller must hold p.mu
+func (p *progress) status() string {
+	line := formatBytes(p.done)
+	if p.total > 0 {
+		line = fmt.Sprintf("%5.1f%%  %s / %s", min(100, float64(p.done)*100/float64(p.total)), line, formatBytes(p.total))
+	}
+	speed := p.speed()
+	line += fmt.Sprintf("  %s/s", formatBytes(speed))
+	if p.total > 0 && speed > 0 && p.done < p.total {
+		eta := time.Duration(float64(p.total-p.done) / float64(speed) * float64(time.Second))
+		line += fmt.Sprintf("  ETA %s", eta.Round(time.Second))
+	}
+	if p.files > 1 {
+		line += fmt.Sprintf("  %d/%d files", p.finished, p.files)
+	}
+	if p.current != "" {
+		line += "  " + p.current
+	}
+	return line
+}
+
+// speed returns the bytes per second transferred so far; the caller must hold p.mu
+func (p *progress) speed() int64 {
+	elapsed := time.Since(p.start).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(p.transferred) / elapsed)
+}
+
+// clearLine erases the status line; the caller must hold p.mu
+func (p *progress) clearLine() {
+	if p.live {
+		fmt.Fprint(p.out, "\r\033[K")
+	}
+}
+
+// setSize records the size of a file once it is known
+func (f *fileProgress) setSize(size int64) {
+	if f == nil || f.size >= 0 {
+		return
+	}
+	f.p.mu.Lock()
+	defer f.p.mu.Unlock()
+	f.size = size
+	f.p.total += size
+}
+
+// begin starts an attempt at offset, the bytes that need not be sent again
+func (f *fileProgress) begin(offset int64) {
+	if f == nil {
+		return
+	}
+	f.p.mu.Lock()
+	defer f.p.mu.Unlock()
+	f.p.done += offset - f.done
+	f.done = offset
+	f.p.current = f.name
+}
+
+// add records n transferred bytes
+func (f *fileProgress) add(n int64) {
+	if f == nil {
+		return
+	}
+	f.p.mu.Lock()
+	defer f.p.mu.Unlock()
+	f.done += n
+	f.p.done += n
+	f.p.transferred += n
+}
+
+// reader counts the bytes read from r
+func (f *fileProgress) reader(r io.Reader) io.Reader {
+	if f == nil {
+		return r
+	}
+	return &progressReader{Reader: r, file: f}
+}
+
+// finish records the outcome of the file after its last attempt. Operations
+// on several files print a line per file.
+func (f *fileProgress) finish(action string, err error) {
+	if f == nil {
+		return
+	}
+	p := f.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err != nil {
+		p.done -= f.done
+		f.done = 0
+	} else {
+		if f.size >= 0 {
+			p.done += f.size - f.done
+			f.done = f.size
+		}
+		p.finished++
+	}
+	if p.files == 1 {
+		return
+	}
+
+	p.clearLine()
+	if err != nil {
+		fmt.Fprintf(p.out, "failed      %s: %v\n", f.name, err)
+		return
+	}
+	fmt.Fprintf(p.out, "%-11s %s (%s)\n", action, f.name, formatBytes(f.done))
+}
+
+// progressReader counts the bytes read through it
+type progressReader struct {
+	io.Reader
+	file *fileProgress
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.file.add(int64(n))
+	return n, err
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. "1.5 MiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
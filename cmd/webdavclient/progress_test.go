@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestProgress returns a progress that writes to out as if it was not a
+// terminal
+func newTestProgress(out *strings.Builder) *progress {
+	return &progress{out: out, start: time.Now(), stop: make(chan struct{})}
+}
+
+func TestProgress(t *testing.T) {
+	var out strings.Builder
+	p := newTestProgress(&out)
+	a := p.file("/a", 10)
+	b := p.file("/b", -1)
+
+	// A failed attempt is started over, and the size of a download is set
+	// once it is known
+	a.begin(0)
+	a.add(4)
+	a.begin(0)
+	a.add(10)
+	a.finish("uploaded", nil)
+	b.setSize(2048)
+	b.begin(0)
+	b.add(3)
+	b.finish("downloaded", errors.New("connection reset"))
+	if p.total != 2058 || p.done != 10 || p.transferred != 17 || p.finished != 1 {
+		t.Errorf("Expected 10 of 2058 bytes done, got %d of %d, %d transferred, %d files finished", p.done, p.total, p.transferred, p.finished)
+	}
+	p.close()
+
+	lines := strings.Split(out.String(), "\n")
+	if len(lines) != 4 || lines[0] != "uploaded    /a (10 B)" || lines[1] != "failed      /b: connection reset" ||
+		!strings.HasPrefix(lines[2], "1/2 files, 10 B in ") {
+		t.Errorf("Expected a line per file and the totals, got:\n%s", out.String())
+	}
+
+	// A single file only reports when the output is a terminal
+	out.Reset()
+	p = newTestProgress(&out)
+	f := p.file("/a", 5)
+	f.begin(0)
+	f.add(5)
+	f.finish("uploaded", nil)
+	p.close()
+	if out.String() != "" {
+		t.Errorf("Expected no output for a single file, got %q", out.String())
+	}
+
+	// Without progress nothing is reported
+	var none *progress
+	none.file("/a", 5).add(5)
+	none.close()
+}
+
+func TestProgressStatus(t *testing.T) {
+	p := &progress{start: time.Now().Add(-100 * time.Second), files: 2, total: 1 << 20, done: 512 << 10, transferred: 512 << 10, current: "/a"}
+	if got, want := p.status(), " 50.0%  512.0 KiB / 1.0 MiB  5.1 KiB/s  ETA 1m40s  0/2 files  /a"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	p = &progress{start: time.Now().Add(-time.Second), files: 1}
+	if got, want := p.status(), "0 B  0 B/s"; got != want {
+		t.Errorf("Expected %q for a file of unknown size, got %q", want, got)
+	}
+}
+
+func TestPutFileProgress(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(localPath, []byte(strings.Repeat("0123456789", 10)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := &putServer{failAt: 3}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client := NewClient(httpServer.URL, "", "")
+	client.ChunkSize = 40
+	client.StateDir = t.TempDir()
+
+	// The resumed upload counts the chunks already on the server as done
+	var out strings.Builder
+	p := newTestProgress(&out)
+	f := p.file("/file", 100)
+	if _, err := client.putFile(localPath, "/file", false, f); !isStatus(err, http.StatusServiceUnavailable) {
+		t.Fatalf("Expected the upload to fail, got %v", err)
+	}
+	if _, err := client.putFile(localPath, "/file", true, f); err != nil {
+		t.Fatalf("Failed to resume upload: %v", err)
+	}
+	if p.done != 100 || f.done != 100 {
+		t.Errorf("Expected 100 bytes done, got %d", p.done)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	}
+	for _, test := range tests {
+		if got := formatBytes(test.n); got != test.want {
+			t.Errorf("%d: expected %s, got %s", test.n, test.want, got)
+		}
+	}
+}
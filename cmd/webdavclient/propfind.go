@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// propfindBody asks for the properties shown in listings, and the checksums
+// ownCloud compatible servers expose, which mirror uses when present
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<D:propfind xmlns:D="DAV:" xmlns:oc="http://owncloud.org/ns"><D:prop>
+<D:resourcetype/><D:getcontentlength/><D:getlastmodified/><D:getcontenttype/><D:getetag/><oc:checksums/>
+</D:prop></D:propfind>`
+
+// multistatus is the body of a PROPFIND response. Elements are matched by
+// namespace, so any prefix the server uses for DAV: works.
+type multistatus struct {
+	XMLName   xml.Name      `xml:"DAV: multistatus"`
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+// davResponse holds the properties of a single resource
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+// davPropstat groups properties returned with the same status
+type davPropstat struct {
+	Prop   davProp `xml:"DAV: prop"`
+	Status string  `xml:"DAV: status"`
+}
+
+// davProp holds the live properties the client understands
+type davProp struct {
+	ResourceType struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+	ContentLength string `xml:"DAV: getcontentlength"`
+	LastModified  string `xml:"DAV: getlastmodified"`
+	ContentType   string `xml:"DAV: getcontenttype"`
+	ETag          string `xml:"DAV: getetag"`
+	Checksums     struct {
+		Checksum []string `xml:"http://owncloud.org/ns checksum"`
+	} `xml:"http://owncloud.org/ns checksums"`
+}
+
+// FileInfo describes a file or directory on the server
+type FileInfo struct {
+	Name        string    `json:"name"`
+	Path        string    `json:"path"`
+	IsDir       bool      `json:"is_dir"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	ContentType string    `json:"content_type,omitempty"`
+	ETag        string    `json:"etag,omitempty"`
+	// Checksum lists checksums like "SHA1:<hex> MD5:<hex>" when the server has them
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// Propfind returns the resources found at path with the given depth ("0" or "1")
+func (c *WebDAVClient) Propfind(remotePath, depth string) ([]FileInfo, error) {
+	req, err := c.newRequest("PROPFIND", remotePath, strings.NewReader(propfindBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Depth", depth)
+	req.Header.Set("Content-Type", `application/xml; charset="utf-8"`)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, &StatusError{Code: resp.StatusCode}
+	}
+
+	return c.parseMultistatus(resp.Body)
+}
+
+// parseMultistatus decodes a multistatus body into file infos
+func (c *WebDAVClient) parseMultistatus(r io.Reader) ([]FileInfo, error) {
+	var ms multistatus
+	if err := xml.NewDecoder(r).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("failed to parse multistatus response: %w", err)
+	}
+
+	files := make([]FileInfo, 0, len(ms.Responses))
+	for _, response := range ms.Responses {
+		remotePath, err := c.hrefPath(response.Href)
+		if err != nil {
+			return nil, err
+		}
+		info := FileInfo{Path: remotePath, Name: path.Base(remotePath)}
+
+		for _, propstat := range response.Propstats {
+			if !statusOK(propstat.Status) {
+				continue
+			}
+			prop := propstat.Prop
+			if prop.ResourceType.Collection != nil {
+				info.IsDir = true
+			}
+			if prop.ContentLength != "" {
+				info.Size, _ = strconv.ParseInt(strings.TrimSpace(prop.ContentLength), 10, 64)
+			}
+			if prop.LastModified != "" {
+				info.ModTime, _ = http.ParseTime(strings.TrimSpace(prop.LastModified))
+			}
+			if prop.ContentType != "" {
+				info.ContentType = prop.ContentType
+			}
+			if prop.ETag != "" {
+				info.ETag = prop.ETag
+			}
+			if len(prop.Checksums.Checksum) > 0 {
+				info.Checksum = strings.Join(strings.Fields(strings.Join(prop.Checksums.Checksum, " ")), " ")
+			}
+		}
+		files = append(files, info)
+	}
+	return files, nil
+}
+
+// hrefPath turns an href, which may be a full URL and is percent-encoded,
+// into a path relative to the client URL
+func (c *WebDAVClient) hrefPath(href string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return "", fmt.Errorf("invalid href %q: %w", href, err)
+	}
+
+	remotePath := u.Path
+	if base, err := url.Parse(c.URL); err == nil && base.Path != "" && base.Path != "/" {
+		remotePath = strings.TrimPrefix(remotePath, strings.TrimSuffix(base.Path, "/"))
+	}
+	return cleanPath(remotePath), nil
+}
+
+// statusOK reports whether a status line like "HTTP/1.1 200 OK" is a success.
+// A missing status is treated as success.
+func statusOK(status string) bool {
+	fields := strings.Fields(status)
+	if len(fields) < 2 {
+		return true
+	}
+	code, err := strconv.Atoi(fields[1])
+	return err == nil && code >= 200 && code < 300
+}
+
+// cleanPath normalizes a remote path to start with a slash and have no trailing slash
+func cleanPath(remotePath string) string {
+	return path.Clean("/" + remotePath)
+}
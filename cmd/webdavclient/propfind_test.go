@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMultistatus(t *testing.T) {
+	// A server with its own prefix for DAV: and a base path, which reports
+	// a missing property with its own status
+	body := `<?xml version="1.0" encoding="utf-8"?>
+<d:multistatus xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+  <d:response>
+    <d:href>https://dav.example.com/remote/docs/</d:href>
+    <d:propstat>
+      <d:prop><d:resourcetype><d:collection/></d:resourcetype><d:getlastmodified>Mon, 02 Jan 2006 15:04:05 GMT</d:getlastmodified></d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+  <d:response>
+    <d:href>/remote/docs/my%20notes.txt</d:href>
+    <d:propstat>
+      <d:prop>
+        <d:resourcetype/>
+        <d:getcontentlength> 42 </d:getcontentlength>
+        <d:getcontenttype>text/plain</d:getcontenttype>
+        <d:getetag>"abc"</d:getetag>
+        <oc:checksums><oc:checksum>SHA1:da39a3ee MD5:d41d8cd9</oc:checksum></oc:checksums>
+      </d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+    <d:propstat>
+      <d:prop><d:getcontentlength>99</d:getcontentlength></d:prop>
+      <d:status>HTTP/1.1 404 Not Found</d:status>
+    </d:propstat>
+  </d:response>
+</d:multistatus>`
+
+	c := NewClient("https://dav.example.com/remote/", "", "")
+	files, err := c.parseMultistatus(strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to parse multistatus: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 files, got %d", len(files))
+	}
+
+	dir := files[0]
+	modTime := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	if dir.Path != "/docs" || dir.Name != "docs" || !dir.IsDir || !dir.ModTime.Equal(modTime) {
+		t.Errorf("Unexpected directory %+v", dir)
+	}
+	file := files[1]
+	want := FileInfo{Name: "my notes.txt", Path: "/docs/my notes.txt", Size: 42, ContentType: "text/plain", ETag: `"abc"`, Checksum: "SHA1:da39a3ee MD5:d41d8cd9"}
+	if file != want {
+		t.Errorf("Expected %+v, got %+v", want, file)
+	}
+
+	if _, err := c.parseMultistatus(strings.NewReader(`<multistatus xmlns="other:"/>`)); err == nil {
+		t.Error("Expected an error for a body that is not a DAV: multistatus")
+	}
+}
+
+func TestStatusOK(t *testing.T) {
+	tests := map[string]bool{
+		"HTTP/1.1 200 OK":        true,
+		"HTTP/1.1 207 Multi":     true,
+		"HTTP/1.1 404 Not Found": false,
+		"HTTP/1.1 403":           false,
+		"":                       true,
+		"HTTP/1.1 abc":           false,
+	}
+	for status, want := range tests {
+		if got := statusOK(status); got != want {
+			t.Errorf("%q: expected %v, got %v", status, want, got)
+		}
+	}
+}
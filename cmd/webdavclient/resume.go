@@ -0,0 +1,305 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// partialSuffix is appended to files while they are downloaded. The ETag of
+// the partial download is kept next to it, so it is only resumed while the
+// remote file is unchanged.
+const partialSuffix = ".partial"
+
+// errIncomplete reports a transfer that ended before all bytes were sent
+var errIncomplete = errors.New("transfer incomplete")
+
+// uploadState records the source of a chunked upload while it is running,
+// so an interrupted upload is only resumed from the same local file
+type uploadState struct {
+	URL     string    `json:"url"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+}
+
+// uploadStatePath returns where the state of an upload to remotePath is
+// kept, below StateDir or the user cache directory
+func (c *WebDAVClient) uploadStatePath(remotePath string) (string, error) {
+	dir := c.StateDir
+	if dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(cacheDir, "webdavclient")
+	}
+	sum := sha256.Sum256([]byte(c.URL + cleanPath(remotePath)))
+	return filepath.Join(dir, "uploads", hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// fileSHA256 returns the hex encoded SHA-256 of the first size bytes of a file
+func fileSHA256(file *os.File, size int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(file, 0, size)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// putFile uploads a file and returns the number of bytes sent. Files larger
+// than ChunkSize are sent as partial PUT requests, and their source is
+// recorded until the upload completes; with resume, an upload of the same
+// source continues after the bytes already on the server. Servers that
+// reject partial PUT requests get the file in a single request. Progress is
+// reported to fp, which may be nil.
+func (c *WebDAVClient) putFile(localPath, remotePath string, resume bool, fp *fileProgress) (int64, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	size := info.Size()
+	fp.setSize(size)
+	if c.ChunkSize <= 0 || size <= c.ChunkSize {
+		fp.begin(0)
+		return size, c.put(remotePath, fp.reader(io.NewSectionReader(file, 0, size)), size, "")
+	}
+
+	// The bytes on the server only belong to this file when the recorded
+	// source of the interrupted upload matches it
+	sum, err := fileSHA256(file, size)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read file: %w", err)
+	}
+	state := uploadState{URL: c.URL + cleanPath(remotePath), Size: size, ModTime: info.ModTime(), SHA256: sum}
+	statePath, err := c.uploadStatePath(remotePath)
+	var offset int64
+	if err == nil {
+		var recorded uploadState
+		if data, err := os.ReadFile(statePath); resume && err == nil && json.Unmarshal(data, &recorded) == nil &&
+			recorded.URL == state.URL && recorded.Size == size && recorded.ModTime.Equal(state.ModTime) && recorded.SHA256 == sum {
+			if files, err := c.Propfind(remotePath, "0"); err == nil && len(files) == 1 && !files[0].IsDir && files[0].Size <= size {
+				offset = files[0].Size
+			}
+		}
+		// Without a record the upload can still complete, it just cannot
+		// be resumed
+		if data, err := json.Marshal(state); err == nil && os.MkdirAll(filepath.Dir(statePath), 0700) == nil {
+			os.WriteFile(statePath, data, 0600)
+		}
+	}
+
+	sent, err := c.putChunks(file, remotePath, offset, size, fp)
+	if errors.Is(err, errPartialPutUnsupported) {
+		fp.begin(0)
+		sent, err = size, c.put(remotePath, fp.reader(io.NewSectionReader(file, 0, size)), size, "")
+	}
+	if err == nil && statePath != "" {
+		os.Remove(statePath)
+	}
+	return sent, err
+}
+
+// errPartialPutUnsupported reports a server that does not support PUT
+// requests with a Content-Range header
+var errPartialPutUnsupported = errors.New("partial PUT not supported")
+
+// putChunks uploads the bytes of file from offset as partial PUT requests
+// and returns the number of bytes sent
+func (c *WebDAVClient) putChunks(file *os.File, remotePath string, offset, size int64, fp *fileProgress) (int64, error) {
+	fp.begin(offset)
+	var sent int64
+	verified := false
+	for offset < size {
+		end := min(offset+c.ChunkSize, size) - 1
+		contentRange := fmt.Sprintf("bytes %d-%d/%d", offset, end, size)
+		if err := c.put(remotePath, fp.reader(io.NewSectionReader(file, offset, end-offset+1)), end-offset+1, contentRange); err != nil {
+			if isStatus(err, http.StatusBadRequest) || isStatus(err, http.StatusNotImplemented) {
+				return sent, errPartialPutUnsupported
+			}
+			return sent, err
+		}
+		sent += end - offset + 1
+
+		// Servers that ignore the Content-Range header replace the file
+		// with the chunk; detect that once
+		if offset > 0 && !verified {
+			files, err := c.Propfind(remotePath, "0")
+			if err != nil {
+				return sent, err
+			}
+			if len(files) != 1 || files[0].Size != end+1 {
+				return sent, errPartialPutUnsupported
+			}
+			verified = true
+		}
+		offset = end + 1
+	}
+	return sent, nil
+}
+
+// put sends a single PUT request, with a Content-Range header when given
+func (c *WebDAVClient) put(remotePath string, body io.Reader, length int64, contentRange string) error {
+	req, err := c.newRequest("PUT", remotePath, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = length
+	if contentRange != "" {
+		req.Header.Set("Content-Range", contentRange)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return &StatusError{Code: resp.StatusCode}
+	}
+	return nil
+}
+
+// getFile downloads a file and returns the number of bytes received. An
+// earlier partial download of the same version of the file is resumed with
+// a Range request. Progress is reported to fp, which may be nil.
+func (c *WebDAVClient) getFile(remotePath, localPath string, fp *fileProgress) (int64, error) {
+	partPath := localPath + partialSuffix
+	etagPath := partPath + ".etag"
+
+	var offset int64
+	data, _ := os.ReadFile(etagPath)
+	etag := strings.TrimSpace(string(data))
+	if info, err := os.Stat(partPath); err == nil && etag != "" {
+		offset = info.Size()
+	}
+
+	req, err := c.newRequest("GET", remotePath, nil)
+	if err != nil {
+		return 0, err
+	}
+	// Byte offsets refer to the stored file, not a compressed response
+	req.Header.Set("Accept-Encoding", "identity")
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", etag)
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	total := resp.ContentLength
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// The file changed or the server ignored the range, start over
+		offset = 0
+	case http.StatusPartialContent:
+		start, size, ok := parseResponseRange(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			return 0, fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+		total = size
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file may already hold the whole file
+		if _, size, ok := parseResponseRange(resp.Header.Get("Content-Range")); ok && size == offset {
+			return 0, finishDownload(partPath, localPath)
+		}
+		os.Remove(partPath)
+		return 0, &StatusError{Code: resp.StatusCode}
+	default:
+		return 0, &StatusError{Code: resp.StatusCode}
+	}
+
+	// Create the directory if it doesn't exist
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	file, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
+
+	// Only a strong ETag can be used to resume with If-Range
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		os.WriteFile(etagPath, []byte(etag), 0644)
+	} else {
+		os.Remove(etagPath)
+	}
+
+	if total >= 0 {
+		fp.setSize(total)
+	}
+	fp.begin(offset)
+	n, err := io.Copy(file, fp.reader(resp.Body))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, fmt.Errorf("failed to write file: %w", err)
+	}
+	if total >= 0 && offset+n != total {
+		return n, fmt.Errorf("%w: received %d of %d bytes", errIncomplete, offset+n, total)
+	}
+
+	return n, finishDownload(partPath, localPath)
+}
+
+// finishDownload moves a complete partial download into place
+func finishDownload(partPath, localPath string) error {
+	if err := os.Rename(partPath, localPath); err != nil {
+		return fmt.Errorf("failed to move download into place: %w", err)
+	}
+	os.Remove(partPath + ".etag")
+	return nil
+}
+
+// parseResponseRange parses the start and complete length of a response
+// Content-Range header like "bytes 100-199/1000" or "bytes */1000"
+func parseResponseRange(header string) (start, size int64, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	byteRange, total, found := strings.Cut(spec, "/")
+	if !found {
+		return 0, 0, false
+	}
+
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	if byteRange == "*" {
+		return 0, size, true
+	}
+	first, _, _ := strings.Cut(byteRange, "-")
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, size, true
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// putServer is a WebDAV server for a single file that supports partial PUT
+// requests unless rejectRanges is set, and fails the PUT request failAt. It
+// records the method and path of every request in requests, and the headers
+// of the last one in header.
+type putServer struct {
+	mu           sync.Mutex
+	data         []byte
+	ranges       []string
+	rejectRanges int
+	failAt       int
+	requests     []string
+	header       http.Header
+}
+
+// putServerLock is the token of the locks of putServer
+const putServerLock = "opaquelocktoken:e71d4fae-5dec-22d6-fea5-00a0c91e6be4"
+
+func (s *putServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	s.header = r.Header
+	switch r.Method {
+	case "MOVE", "COPY":
+		// Only the file exists, so only it can be moved or overwritten
+		switch {
+		case r.URL.Path != "/file":
+			w.WriteHeader(http.StatusNotFound)
+		case strings.HasSuffix(r.Header.Get("Destination"), "/file") && r.Header.Get("Overwrite") == "F":
+			w.WriteHeader(http.StatusPreconditionFailed)
+		default:
+			w.WriteHeader(http.StatusCreated)
+		}
+	case "LOCK":
+		w.Header().Set("Lock-Token", "<"+putServerLock+">")
+		w.WriteHeader(http.StatusOK)
+	case "UNLOCK":
+		if r.Header.Get("Lock-Token") != "<"+putServerLock+">" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "GET":
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(s.data))
+	case "PUT":
+		contentRange := r.Header.Get("Content-Range")
+		s.ranges = append(s.ranges, contentRange)
+		if len(s.ranges) == s.failAt {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if contentRange == "" {
+			s.data = body
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		if s.rejectRanges != 0 {
+			w.WriteHeader(s.rejectRanges)
+			return
+		}
+		var start, end, size int64
+		fmt.Sscanf(contentRange, "bytes %d-%d/%d", &start, &end, &size)
+		if start > int64(len(s.data)) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		s.data = append(s.data[:start], body...)
+		w.WriteHeader(http.StatusNoContent)
+	case "PROPFIND":
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<D:multistatus xmlns:D="DAV:"><D:response><D:href>/file</D:href>
+<D:propstat><D:prop><D:getcontentlength>%d</D:getcontentlength></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat>
+</D:response></D:multistatus>`, len(s.data))
+	}
+}
+
+func TestPutFileResume(t *testing.T) {
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "file")
+	content := []byte(strings.Repeat("0123456789", 10))
+	if err := os.WriteFile(localPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// change modifies the local file after the interrupted upload
+		change func()
+		want   []string
+	}{
+		{"same file", func() {}, []string{"bytes 80-99/100"}},
+		{"changed file", func() {
+			os.WriteFile(localPath, bytes.ToUpper(content), 0644)
+		}, []string{"bytes 0-39/100", "bytes 40-79/100", "bytes 80-99/100"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			os.WriteFile(localPath, content, 0644)
+			server := &putServer{failAt: 3}
+			httpServer := httptest.NewServer(server)
+			defer httpServer.Close()
+			client := NewClient(httpServer.URL, "", "")
+			client.ChunkSize = 40
+			client.StateDir = t.TempDir()
+
+			// The third chunk fails, leaving 80 bytes on the server
+			if _, err := client.putFile(localPath, "/file", false, nil); !isStatus(err, http.StatusServiceUnavailable) {
+				t.Fatalf("Expected the upload to fail, got %v", err)
+			}
+			test.change()
+			server.ranges, server.failAt = nil, 0
+
+			if _, err := client.putFile(localPath, "/file", true, nil); err != nil {
+				t.Fatalf("Failed to resume upload: %v", err)
+			}
+			if strings.Join(server.ranges, ",") != strings.Join(test.want, ",") {
+				t.Errorf("Expected the requests %q, got %q", test.want, server.ranges)
+			}
+			local, _ := os.ReadFile(localPath)
+			if !bytes.Equal(server.data, local) {
+				t.Errorf("Expected the server to have %q, got %q", local, server.data)
+			}
+			if entries, _ := os.ReadDir(filepath.Join(client.StateDir, "uploads")); len(entries) != 0 {
+				t.Errorf("Expected the upload state to be removed, got %d files", len(entries))
+			}
+		})
+	}
+
+	// Without a record of the interrupted upload, the bytes already on the
+	// server are not trusted
+	server := &putServer{data: content[:80]}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client := NewClient(httpServer.URL, "", "")
+	client.ChunkSize = 40
+	client.StateDir = t.TempDir()
+	if _, err := client.putFile(localPath, "/file", true, nil); err != nil {
+		t.Fatalf("Failed to upload: %v", err)
+	}
+	if len(server.ranges) != 3 || !bytes.Equal(server.data, content) {
+		t.Errorf("Expected the whole file to be uploaded, got %q", server.ranges)
+	}
+}
+
+func TestPutFileRejectedRanges(t *testing.T) {
+	localPath := filepath.Join(t.TempDir(), "file")
+	content := []byte(strings.Repeat("0123456789", 10))
+	if err := os.WriteFile(localPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, status := range []int{http.StatusBadRequest, http.StatusNotImplemented} {
+		server := &putServer{rejectRanges: status}
+		httpServer := httptest.NewServer(server)
+		client := NewClient(httpServer.URL, "", "")
+		client.ChunkSize = 40
+		client.StateDir = t.TempDir()
+
+		n, err := client.putFile(localPath, "/file", false, nil)
+		httpServer.Close()
+		if err != nil || n != int64(len(content)) {
+			t.Fatalf("%d: expected a plain PUT, got %d, %v", status, n, err)
+		}
+		if len(server.ranges) != 2 || server.ranges[1] != "" || !bytes.Equal(server.data, content) {
+			t.Errorf("%d: expected a plain PUT after the rejected chunk, got %q", status, server.ranges)
+		}
+	}
+}
+
+func TestParseResponseRange(t *testing.T) {
+	tests := []struct {
+		header      string
+		start, size int64
+		ok          bool
+	}{
+		{"bytes 100-199/1000", 100, 1000, true},
+		{"bytes 0-0/1", 0, 1, true},
+		{"bytes */1000", 0, 1000, true},
+		{"bytes 100-199/*", 0, 0, false},
+		{"bytes 100-199", 0, 0, false},
+		{"items 100-199/1000", 0, 0, false},
+		{"bytes x-199/1000", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, test := range tests {
+		start, size, ok := parseResponseRange(test.header)
+		if start != test.start || size != test.size || ok != test.ok {
+			t.Errorf("%q: expected %d, %d, %v, got %d, %d, %v", test.header, test.start, test.size, test.ok, start, size, ok)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// retryDelay is the wait before the first retry; it doubles with every attempt
+const retryDelay = 500 * time.Millisecond
+
+// transferJob is a single file to upload or download
+type transferJob struct {
+	upload bool
+	local  string
+	remote string
+	// size is -1 when not known before the transfer
+	size     int64
+	modTime  time.Time
+	progress *fileProgress
+}
+
+// TransferResult is the outcome of a single file transfer
+type TransferResult struct {
+	Local    string `json:"local"`
+	Remote   string `json:"remote"`
+	Bytes    int64  `json:"bytes"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+
+	err error
+}
+
+// TransferSummary reports the outcome of a bulk transfer
+type TransferSummary struct {
+	Succeeded []TransferResult `json:"succeeded"`
+	Failed    []TransferResult `json:"failed"`
+	Bytes     int64            `json:"bytes"`
+	Duration  time.Duration    `json:"duration_ns"`
+}
+
+// UploadTree uploads a local directory tree to remoteDir
+func (c *WebDAVClient) UploadTree(localDir, remoteDir string) (*TransferSummary, error) {
+	dirs, jobs, err := walkLocal(localDir, remoteDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Directories are created in walk order, so parents exist before their children
+	for _, dir := range dirs {
+		if err := c.mkcol(dir); err != nil && !isStatus(err, http.StatusMethodNotAllowed) {
+			return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+	}
+
+	return c.runTransfers(jobs), nil
+}
+
+// DownloadTree downloads a remote directory tree to localDir
+func (c *WebDAVClient) DownloadTree(remoteDir, localDir string) (*TransferSummary, error) {
+	files, err := c.walkRemote(remoteDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []transferJob
+	for _, file := range files {
+		rel := filepath.FromSlash(file.Path[len(cleanPath(remoteDir)):])
+		if file.IsDir {
+			if err := os.MkdirAll(filepath.Join(localDir, rel), 0755); err != nil {
+				return nil, fmt.Errorf("failed to create directory: %w", err)
+			}
+			continue
+		}
+		jobs = append(jobs, transferJob{local: filepath.Join(localDir, rel), remote: file.Path, size: file.Size})
+	}
+
+	return c.runTransfers(jobs), nil
+}
+
+// walkLocal returns the remote directories to create, in walk order, and the
+// upload jobs for the regular files below localDir
+func walkLocal(localDir, remoteDir string) ([]string, []transferJob, error) {
+	var dirs []string
+	var jobs []transferJob
+	err := filepath.WalkDir(localDir, func(localPath string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, localPath)
+		if err != nil {
+			return err
+		}
+		remotePath := cleanPath(path.Join(remoteDir, filepath.ToSlash(rel)))
+
+		switch {
+		case entry.IsDir():
+			dirs = append(dirs, remotePath)
+		case entry.Type().IsRegular():
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			jobs = append(jobs, transferJob{upload: true, local: localPath, remote: remotePath, size: info.Size(), modTime: info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to walk %s: %w", localDir, err)
+	}
+	return dirs, jobs, nil
+}
+
+// walkRemote lists the files and directories below remoteDir, parents before
+// their children
+func (c *WebDAVClient) walkRemote(remoteDir string) ([]FileInfo, error) {
+	var all []FileInfo
+	pending := []string{cleanPath(remoteDir)}
+	for len(pending) > 0 {
+		dir := pending[0]
+		pending = pending[1:]
+
+		files, err := c.Propfind(dir, "1")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+		}
+		for _, file := range files {
+			if file.Path == dir {
+				continue
+			}
+			if file.IsDir {
+				pending = append(pending, file.Path)
+			}
+			all = append(all, file)
+		}
+	}
+	return all, nil
+}
+
+// runTransfers runs the jobs on Concurrency workers, retrying transient failures
+func (c *WebDAVClient) runTransfers(jobs []transferJob) *TransferSummary {
+	start := time.Now()
+	progress := c.newProgress()
+	for i := range jobs {
+		jobs[i].progress = progress.file(jobs[i].remote, jobs[i].size)
+	}
+	defer progress.close()
+
+	workers := max(1, c.Concurrency)
+	queue := make(chan transferJob)
+	results := make(chan TransferResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				results <- c.transfer(job)
+			}
+		}()
+	}
+	go func() {
+		for _, job := range jobs {
+			queue <- job
+		}
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+
+	summary := &TransferSummary{Succeeded: []TransferResult{}, Failed: []TransferResult{}}
+	for result := range results {
+		if result.Error != "" {
+			summary.Failed = append(summary.Failed, result)
+			continue
+		}
+		summary.Succeeded = append(summary.Succeeded, result)
+		summary.Bytes += result.Bytes
+	}
+	summary.Duration = time.Since(start)
+	return summary
+}
+
+// transfer moves a single file, retrying up to Retries times on transient errors
+func (c *WebDAVClient) transfer(job transferJob) TransferResult {
+	result := TransferResult{Local: job.local, Remote: job.remote}
+	for {
+		result.Attempts++
+
+		var n int64
+		var err error
+		if job.upload {
+			// A retry continues the chunks the previous attempt uploaded
+			n, err = c.putFile(job.local, job.remote, c.Resume || result.Attempts > 1, job.progress)
+		} else {
+			n, err = c.getFile(job.remote, job.local, job.progress)
+		}
+		if err == nil {
+			result.Bytes = n
+			job.progress.finish(job.action(), nil)
+			return result
+		}
+
+		if result.Attempts > c.Retries || !isTransient(err) {
+			result.Error = err.Error()
+			result.err = err
+			job.progress.finish(job.action(), err)
+			return result
+		}
+		time.Sleep(retryDelay << (result.Attempts - 1))
+	}
+}
+
+// action names the direction of the job in progress output
+func (job transferJob) action() string {
+	if job.upload {
+		return "uploaded"
+	}
+	return "downloaded"
+}
+
+// isTransient reports whether a failed transfer may succeed when retried
+func isTransient(err error) bool {
+	if errors.Is(err, errIncomplete) {
+		return true
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code == http.StatusTooManyRequests || statusErr.Code == http.StatusLocked ||
+			statusErr.Code == http.StatusRequestedRangeNotSatisfiable || statusErr.Code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+// isStatus reports whether err is a StatusError with the given code
+func isStatus(err error, code int) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.Code == code
+}
+
+// printSummary reports the outcome of a bulk transfer
+func (c *WebDAVClient) printSummary(summary *TransferSummary) error {
+	if c.Format == "json" {
+		return printJSON(summary)
+	}
+
+	fmt.Printf("Transferred %d files (%d bytes) in %s\n",
+		len(summary.Succeeded), summary.Bytes, summary.Duration.Round(time.Millisecond))
+	if len(summary.Failed) == 0 {
+		return nil
+	}
+
+	fmt.Printf("%d files failed:\n", len(summary.Failed))
+	for _, result := range summary.Failed {
+		fmt.Printf("  %s -> %s: %s (after %d attempts)\n", result.Local, result.Remote, result.Error, result.Attempts)
+	}
+	return fmt.Errorf("%d of %d transfers failed", len(summary.Failed), len(summary.Failed)+len(summary.Succeeded))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestUploadTree(t *testing.T) {
+	localDir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "docs/c.txt", "docs/d.txt"} {
+		os.MkdirAll(filepath.Join(localDir, filepath.Dir(name)), 0755)
+		if err := os.WriteFile(filepath.Join(localDir, name), []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := &putServer{}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client := NewClient(httpServer.URL, "", "")
+	client.Quiet = true
+	client.StateDir = t.TempDir()
+
+	summary, err := client.UploadTree(localDir, "/backup")
+	if err != nil {
+		t.Fatalf("Failed to upload: %v", err)
+	}
+	if len(summary.Succeeded) != 4 || len(summary.Failed) != 0 || summary.Bytes != 20 {
+		t.Errorf("Expected 4 files of 5 bytes to be uploaded, got %+v", summary)
+	}
+
+	// The directories are created before the files are uploaded in parallel
+	want := []string{"MKCOL /backup", "MKCOL /backup/docs"}
+	if !reflect.DeepEqual(server.requests[:2], want) {
+		t.Errorf("Expected the requests to start with %q, got %q", want, server.requests)
+	}
+	puts := server.requests[2:]
+	sort.Strings(puts)
+	want = []string{"PUT /backup/a.txt", "PUT /backup/b.txt", "PUT /backup/docs/c.txt", "PUT /backup/docs/d.txt"}
+	if !reflect.DeepEqual(puts, want) {
+		t.Errorf("Expected the files to be uploaded, got %q", puts)
+	}
+}
+
+func TestTransferRetries(t *testing.T) {
+	localDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(localDir, "file"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		retries   int
+		succeeded int
+		attempts  int
+	}{
+		// The first PUT fails with 503, which is retried if retries are left
+		{0, 0, 1},
+		{1, 1, 2},
+	}
+	for _, test := range tests {
+		server := &putServer{failAt: 1}
+		httpServer := httptest.NewServer(server)
+		client := NewClient(httpServer.URL, "", "")
+		client.Quiet = true
+		client.Retries = test.retries
+		client.StateDir = t.TempDir()
+
+		summary, err := client.UploadTree(localDir, "/")
+		httpServer.Close()
+		if err != nil {
+			t.Fatalf("Retries %d: failed to upload: %v", test.retries, err)
+		}
+		if len(summary.Succeeded) != test.succeeded {
+			t.Errorf("Retries %d: expected %d uploaded files, got %+v", test.retries, test.succeeded, summary)
+		}
+		results := append(summary.Succeeded, summary.Failed...)
+		if len(results) != 1 || results[0].Attempts != test.attempts {
+			t.Errorf("Retries %d: expected %d attempts, got %+v", test.retries, test.attempts, results)
+		}
+		if test.succeeded == 0 && results[0].Error != "unexpected status code: 503" {
+			t.Errorf("Retries %d: expected the error of the last attempt, got %q", test.retries, results[0].Error)
+		}
+	}
+}
+
+func TestDownloadTree(t *testing.T) {
+	server := &putServer{data: []byte("hello")}
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+	client := NewClient(httpServer.URL, "", "")
+	client.Quiet = true
+
+	localDir := filepath.Join(t.TempDir(), "download")
+	summary, err := client.DownloadTree("/", localDir)
+	if err != nil {
+		t.Fatalf("Failed to download: %v", err)
+	}
+	if len(summary.Succeeded) != 1 || summary.Bytes != 5 {
+		t.Errorf("Expected a file of 5 bytes to be downloaded, got %+v", summary)
+	}
+	if data, err := os.ReadFile(filepath.Join(localDir, "file")); err != nil || string(data) != "hello" {
+		t.Errorf("Expected the downloaded file, got %q, %v", data, err)
+	}
+	if want := "PROPFIND /,GET /file"; strings.Join(server.requests, ",") != want {
+		t.Errorf("Expected the requests %s, got %q", want, server.requests)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&StatusError{Code: http.StatusServiceUnavailable}, true},
+		{&StatusError{Code: http.StatusTooManyRequests}, true},
+		{&StatusError{Code: http.StatusLocked}, true},
+		{&StatusError{Code: http.StatusRequestedRangeNotSatisfiable}, true},
+		{&StatusError{Code: http.StatusForbidden}, false},
+		{&StatusError{Code: http.StatusNotFound}, false},
+		{errIncomplete, true},
+		{os.ErrDeadlineExceeded, true},
+		{os.ErrNotExist, false},
+	}
+	for _, test := range tests {
+		if got := isTransient(test.err); got != test.want {
+			t.Errorf("%v: expected %v, got %v", test.err, test.want, got)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"syscall"
 
+	"github.com/freeflowuniverse/herolauncher/pkg/system/stats/metrics"
 	"github.com/freeflowuniverse/herolauncher/pkg/webdavserver"
 )
 
@@ -35,6 +36,9 @@ func main() {
 	certValidityDays := flag.Int("cert-validity", 365, "Validity period in days for auto-generated certificates")
 	certOrg := flag.String("cert-org", "HeroLauncher WebDAV Server", "Organization name for auto-generated certificates")
 
+	// Metrics options
+	metricsPath := flag.String("metrics-path", "", "Serve Prometheus metrics at this path, e.g. /metrics (empty disables metrics)")
+
 	flag.Parse()
 
 	// Create WebDAV server configuration
@@ -58,6 +62,12 @@ func main() {
 	config.CertValidityDays = *certValidityDays
 	config.CertOrganization = *certOrg
 
+	// Configure metrics if enabled
+	if *metricsPath != "" {
+		config.Metrics = metrics.Default
+		config.MetricsPath = *metricsPath
+	}
+
 	// Log configuration details
 	if *debugMode {
 		log.Printf("Debug mode enabled")
@@ -0,0 +1,202 @@
+package groupware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/emersion/go-ical"
+	"github.com/emersion/go-webdav"
+	"github.com/emersion/go-webdav/caldav"
+)
+
+// calendarBackend is the CalDAV backend of the server
+type calendarBackend struct {
+	store *store
+}
+
+var _ caldav.Backend = (*calendarBackend)(nil)
+
+// CurrentUserPrincipal returns the path of the logged in user
+func (b *calendarBackend) CurrentUserPrincipal(ctx context.Context) (string, error) {
+	return homePath(ctx, CalDAVPrefix)
+}
+
+// CalendarHomeSetPath returns the path of the calendars of the logged in user
+func (b *calendarBackend) CalendarHomeSetPath(ctx context.Context) (string, error) {
+	home, err := homePath(ctx, CalDAVPrefix)
+	if err != nil {
+		return "", err
+	}
+	return home + calendarsDir + "/", nil
+}
+
+// CreateCalendar creates a calendar
+func (b *calendarBackend) CreateCalendar(ctx context.Context, calendar *caldav.Calendar) error {
+	p, err := resolve(ctx, CalDAVPrefix, calendar.Path, calendarsDir, 2)
+	if err != nil {
+		return err
+	}
+	return b.store.createCollection(p, calendar.Name, calendar.Description)
+}
+
+// ListCalendars returns the calendars of the logged in user
+func (b *calendarBackend) ListCalendars(ctx context.Context) ([]caldav.Calendar, error) {
+	home, err := b.CalendarHomeSetPath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p, err := resolve(ctx, CalDAVPrefix, home, calendarsDir, 1)
+	if err != nil {
+		return nil, err
+	}
+	collections, err := b.store.listCollections(p)
+	if err != nil {
+		return nil, err
+	}
+	calendars := make([]caldav.Calendar, 0, len(collections))
+	for _, c := range collections {
+		calendars = append(calendars, *newCalendar(&c))
+	}
+	return calendars, nil
+}
+
+// GetCalendar returns a calendar
+func (b *calendarBackend) GetCalendar(ctx context.Context, urlPath string) (*caldav.Calendar, error) {
+	p, err := resolve(ctx, CalDAVPrefix, urlPath, calendarsDir, 2)
+	if err != nil {
+		return nil, err
+	}
+	c, err := b.store.getCollection(p)
+	if err != nil {
+		return nil, err
+	}
+	return newCalendar(c), nil
+}
+
+// newCalendar returns the CalDAV calendar of a collection
+func newCalendar(c *collection) *caldav.Calendar {
+	return &caldav.Calendar{
+		Path:                  CalDAVPrefix + c.path + "/",
+		Name:                  c.name,
+		Description:           c.description,
+		SupportedComponentSet: []string{ical.CompEvent, ical.CompToDo},
+	}
+}
+
+// GetCalendarObject returns an event or task. The whole object is returned
+// regardless of the requested components.
+func (b *calendarBackend) GetCalendarObject(ctx context.Context, urlPath string, req *caldav.CalendarCompRequest) (*caldav.CalendarObject, error) {
+	p, err := resolve(ctx, CalDAVPrefix, urlPath, calendarsDir, 3)
+	if err != nil {
+		return nil, err
+	}
+	o, err := b.store.getObject(p)
+	if err != nil {
+		return nil, err
+	}
+	return newCalendarObject(o)
+}
+
+// ListCalendarObjects returns the events and tasks of a calendar
+func (b *calendarBackend) ListCalendarObjects(ctx context.Context, urlPath string, req *caldav.CalendarCompRequest) ([]caldav.CalendarObject, error) {
+	p, err := resolve(ctx, CalDAVPrefix, urlPath, calendarsDir, 2)
+	if err != nil {
+		return nil, err
+	}
+	return b.listCalendarObjects(p)
+}
+
+// listCalendarObjects returns the events and tasks of the calendar at a VFS
+// path, leaving out files that are not iCalendar
+func (b *calendarBackend) listCalendarObjects(p string) ([]caldav.CalendarObject, error) {
+	objects, err := b.store.listObjects(p)
+	if err != nil {
+		return nil, err
+	}
+	calendarObjects := make([]caldav.CalendarObject, 0, len(objects))
+	for i := range objects {
+		co, err := newCalendarObject(&objects[i])
+		if err != nil {
+			continue
+		}
+		calendarObjects = append(calendarObjects, *co)
+	}
+	return calendarObjects, nil
+}
+
+// QueryCalendarObjects returns the events and tasks of a calendar matching
+// a query
+func (b *calendarBackend) QueryCalendarObjects(ctx context.Context, urlPath string, query *caldav.CalendarQuery) ([]caldav.CalendarObject, error) {
+	calendarObjects, err := b.ListCalendarObjects(ctx, urlPath, &query.CompRequest)
+	if err != nil {
+		return nil, err
+	}
+	return caldav.Filter(query, calendarObjects)
+}
+
+// PutCalendarObject stores an event or task. Its UID may not be used by
+// another object of the calendar.
+func (b *calendarBackend) PutCalendarObject(ctx context.Context, urlPath string, calendar *ical.Calendar, opts *caldav.PutCalendarObjectOptions) (*caldav.CalendarObject, error) {
+	p, err := resolve(ctx, CalDAVPrefix, urlPath, calendarsDir, 3)
+	if err != nil {
+		return nil, err
+	}
+	_, uid, err := caldav.ValidateCalendarObject(calendar)
+	if err != nil {
+		return nil, caldav.NewPreconditionError(caldav.PreconditionValidCalendarObjectResource)
+	}
+
+	others, err := b.listCalendarObjects(path.Dir(p))
+	if err != nil {
+		return nil, webdav.NewHTTPError(http.StatusConflict, err)
+	}
+	for _, other := range others {
+		if other.Path == CalDAVPrefix+p {
+			continue
+		}
+		if _, otherUID, err := caldav.ValidateCalendarObject(other.Data); err == nil && otherUID == uid {
+			return nil, caldav.NewPreconditionError(caldav.PreconditionNoUIDConflict)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := ical.NewEncoder(&buf).Encode(calendar); err != nil {
+		return nil, webdav.NewHTTPError(http.StatusBadRequest, fmt.Errorf("failed to encode calendar: %w", err))
+	}
+	o, err := b.store.putObject(p, buf.Bytes(), opts.IfNoneMatch, opts.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+	return newCalendarObject(o)
+}
+
+// DeleteCalendarObject deletes an event or task, or a calendar when the
+// path is one
+func (b *calendarBackend) DeleteCalendarObject(ctx context.Context, urlPath string) error {
+	if p, err := resolve(ctx, CalDAVPrefix, urlPath, calendarsDir, 2); err == nil {
+		return b.store.deleteCollection(p)
+	}
+	p, err := resolve(ctx, CalDAVPrefix, urlPath, calendarsDir, 3)
+	if err != nil {
+		return err
+	}
+	return b.store.deleteObject(p)
+}
+
+// newCalendarObject returns the CalDAV object of a stored iCalendar file
+func newCalendarObject(o *object) (*caldav.CalendarObject, error) {
+	calendar, err := ical.NewDecoder(bytes.NewReader(o.data)).Decode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", o.path, err)
+	}
+	return &caldav.CalendarObject{
+		Path:          CalDAVPrefix + o.path,
+		ModTime:       o.modTime(),
+		ContentLength: int64(len(o.data)),
+		ETag:          o.etag(),
+		Data:          calendar,
+	}, nil
+}
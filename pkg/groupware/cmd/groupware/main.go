@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/groupware"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfsdb"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfslocal"
+	"github.com/redis/go-redis/v9"
+)
+
+func main() {
+	// Parse command line flags
+	redisAddr := flag.String("redis-addr", "localhost:6378", "Redis server address")
+	addr := flag.String("addr", ":5232", "CalDAV and CardDAV server address")
+	dbPath := flag.String("db", filepath.Join(os.TempDir(), "herolauncher", "groupware"), "Directory of the vfsdb database holding the calendars and contacts")
+	dir := flag.String("dir", "", "Store the calendars and contacts as files in this directory instead of a vfsdb database")
+	flag.Parse()
+
+	var fs vfs.VFSImplementation
+	var err error
+	if *dir != "" {
+		if err := os.MkdirAll(*dir, 0700); err != nil {
+			log.Fatalf("Failed to create directory: %v", err)
+		}
+		fs, err = vfslocal.New(*dir)
+	} else {
+		if err := os.MkdirAll(*dbPath, 0700); err != nil {
+			log.Fatalf("Failed to create database directory: %v", err)
+		}
+		fs, err = vfsdb.NewFromPath(*dbPath)
+	}
+	if err != nil {
+		log.Fatalf("Failed to open storage: %v", err)
+	}
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: *redisAddr,
+	})
+	server := groupware.NewServer(fs, redisClient, *addr)
+
+	// Set up signal handling for graceful shutdown
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	// Start the server in a goroutine
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("Starting CalDAV and CardDAV server on %s with Redis at %s", *addr, *redisAddr)
+		if err := server.ListenAndServe(); err != nil {
+			errCh <- err
+		}
+	}()
+
+	// Wait for either an error or a signal
+	select {
+	case err := <-errCh:
+		log.Fatalf("Groupware server error: %v", err)
+	case sig := <-sigs:
+		log.Printf("Received signal %v, shutting down", sig)
+		server.Shutdown()
+	}
+}
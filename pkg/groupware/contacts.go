@@ -0,0 +1,206 @@
+package groupware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/emersion/go-vcard"
+	"github.com/emersion/go-webdav"
+	"github.com/emersion/go-webdav/carddav"
+)
+
+// contactBackend is the CardDAV backend of the server
+type contactBackend struct {
+	store *store
+}
+
+var _ carddav.Backend = (*contactBackend)(nil)
+
+// CurrentUserPrincipal returns the path of the logged in user
+func (b *contactBackend) CurrentUserPrincipal(ctx context.Context) (string, error) {
+	return homePath(ctx, CardDAVPrefix)
+}
+
+// AddressBookHomeSetPath returns the path of the address books of the
+// logged in user
+func (b *contactBackend) AddressBookHomeSetPath(ctx context.Context) (string, error) {
+	home, err := homePath(ctx, CardDAVPrefix)
+	if err != nil {
+		return "", err
+	}
+	return home + contactsDir + "/", nil
+}
+
+// ListAddressBooks returns the address books of the logged in user
+func (b *contactBackend) ListAddressBooks(ctx context.Context) ([]carddav.AddressBook, error) {
+	home, err := b.AddressBookHomeSetPath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p, err := resolve(ctx, CardDAVPrefix, home, contactsDir, 1)
+	if err != nil {
+		return nil, err
+	}
+	collections, err := b.store.listCollections(p)
+	if err != nil {
+		return nil, err
+	}
+	addressBooks := make([]carddav.AddressBook, 0, len(collections))
+	for _, c := range collections {
+		addressBooks = append(addressBooks, *newAddressBook(&c))
+	}
+	return addressBooks, nil
+}
+
+// GetAddressBook returns an address book
+func (b *contactBackend) GetAddressBook(ctx context.Context, urlPath string) (*carddav.AddressBook, error) {
+	p, err := resolve(ctx, CardDAVPrefix, urlPath, contactsDir, 2)
+	if err != nil {
+		return nil, err
+	}
+	c, err := b.store.getCollection(p)
+	if err != nil {
+		return nil, err
+	}
+	return newAddressBook(c), nil
+}
+
+// CreateAddressBook creates an address book
+func (b *contactBackend) CreateAddressBook(ctx context.Context, addressBook *carddav.AddressBook) error {
+	p, err := resolve(ctx, CardDAVPrefix, addressBook.Path, contactsDir, 2)
+	if err != nil {
+		return err
+	}
+	return b.store.createCollection(p, addressBook.Name, addressBook.Description)
+}
+
+// DeleteAddressBook deletes an address book with its contacts
+func (b *contactBackend) DeleteAddressBook(ctx context.Context, urlPath string) error {
+	p, err := resolve(ctx, CardDAVPrefix, urlPath, contactsDir, 2)
+	if err != nil {
+		return err
+	}
+	return b.store.deleteCollection(p)
+}
+
+// newAddressBook returns the CardDAV address book of a collection
+func newAddressBook(c *collection) *carddav.AddressBook {
+	return &carddav.AddressBook{
+		Path:        CardDAVPrefix + c.path + "/",
+		Name:        c.name,
+		Description: c.description,
+		SupportedAddressData: []carddav.AddressDataType{
+			{ContentType: vcard.MIMEType, Version: "3.0"},
+			{ContentType: vcard.MIMEType, Version: "4.0"},
+		},
+	}
+}
+
+// GetAddressObject returns a contact. The whole vCard is returned regardless
+// of the requested properties.
+func (b *contactBackend) GetAddressObject(ctx context.Context, urlPath string, req *carddav.AddressDataRequest) (*carddav.AddressObject, error) {
+	p, err := resolve(ctx, CardDAVPrefix, urlPath, contactsDir, 3)
+	if err != nil {
+		return nil, err
+	}
+	o, err := b.store.getObject(p)
+	if err != nil {
+		return nil, err
+	}
+	return newAddressObject(o)
+}
+
+// ListAddressObjects returns the contacts of an address book
+func (b *contactBackend) ListAddressObjects(ctx context.Context, urlPath string, req *carddav.AddressDataRequest) ([]carddav.AddressObject, error) {
+	p, err := resolve(ctx, CardDAVPrefix, urlPath, contactsDir, 2)
+	if err != nil {
+		return nil, err
+	}
+	return b.listAddressObjects(p)
+}
+
+// listAddressObjects returns the contacts of the address book at a VFS
+// path, leaving out files that are not vCards
+func (b *contactBackend) listAddressObjects(p string) ([]carddav.AddressObject, error) {
+	objects, err := b.store.listObjects(p)
+	if err != nil {
+		return nil, err
+	}
+	addressObjects := make([]carddav.AddressObject, 0, len(objects))
+	for i := range objects {
+		ao, err := newAddressObject(&objects[i])
+		if err != nil {
+			continue
+		}
+		addressObjects = append(addressObjects, *ao)
+	}
+	return addressObjects, nil
+}
+
+// QueryAddressObjects returns the contacts of an address book matching a
+// query
+func (b *contactBackend) QueryAddressObjects(ctx context.Context, urlPath string, query *carddav.AddressBookQuery) ([]carddav.AddressObject, error) {
+	addressObjects, err := b.ListAddressObjects(ctx, urlPath, &query.DataRequest)
+	if err != nil {
+		return nil, err
+	}
+	return carddav.Filter(query, addressObjects)
+}
+
+// PutAddressObject stores a contact. Its UID may not be used by another
+// contact of the address book.
+func (b *contactBackend) PutAddressObject(ctx context.Context, urlPath string, card vcard.Card, opts *carddav.PutAddressObjectOptions) (*carddav.AddressObject, error) {
+	p, err := resolve(ctx, CardDAVPrefix, urlPath, contactsDir, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	if uid := card.Value(vcard.FieldUID); uid != "" {
+		others, err := b.listAddressObjects(path.Dir(p))
+		if err != nil {
+			return nil, webdav.NewHTTPError(http.StatusConflict, err)
+		}
+		for _, other := range others {
+			if other.Path != CardDAVPrefix+p && other.Card.Value(vcard.FieldUID) == uid {
+				return nil, carddav.NewPreconditionError(carddav.PreconditionNoUIDConflict)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := vcard.NewEncoder(&buf).Encode(card); err != nil {
+		return nil, carddav.NewPreconditionError(carddav.PreconditionValidAddressData)
+	}
+	o, err := b.store.putObject(p, buf.Bytes(), opts.IfNoneMatch, opts.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+	return newAddressObject(o)
+}
+
+// DeleteAddressObject deletes a contact
+func (b *contactBackend) DeleteAddressObject(ctx context.Context, urlPath string) error {
+	p, err := resolve(ctx, CardDAVPrefix, urlPath, contactsDir, 3)
+	if err != nil {
+		return err
+	}
+	return b.store.deleteObject(p)
+}
+
+// newAddressObject returns the CardDAV object of a stored vCard file
+func newAddressObject(o *object) (*carddav.AddressObject, error) {
+	card, err := vcard.NewDecoder(bytes.NewReader(o.data)).Decode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", o.path, err)
+	}
+	return &carddav.AddressObject{
+		Path:          CardDAVPrefix + o.path,
+		ModTime:       o.modTime(),
+		ContentLength: int64(len(o.data)),
+		ETag:          o.etag(),
+		Card:          card,
+	}, nil
+}
@@ -0,0 +1,133 @@
+// Package groupware serves calendars and contacts of the mail users over
+// CalDAV (RFC 4791) and CardDAV (RFC 6352), built on the go-webdav library,
+// so a personal groupware stack can run next to the IMAP and SMTP servers.
+//
+// Users log in with HTTP basic authentication as the mail users of
+// pkg/mailauth. Their calendars and address books are directories of a VFS,
+// like vfsdb, holding one iCalendar or vCard file per object:
+//
+//	/<username>/calendars/<calendar>/<object>.ics
+//	/<username>/contacts/<address book>/<object>.vcf
+//
+// The display name and description of a collection are kept in its
+// extended attributes. Every user gets a calendar and an address book named
+// "default" when they first connect.
+package groupware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-webdav/caldav"
+	"github.com/emersion/go-webdav/carddav"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailauth"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// CalDAVPrefix is the path the calendars are served below
+	CalDAVPrefix = "/caldav"
+	// CardDAVPrefix is the path the address books are served below
+	CardDAVPrefix = "/carddav"
+)
+
+// Server serves CalDAV and CardDAV on top of a VFS
+type Server struct {
+	store      *store
+	users      *mailauth.Store
+	caldav     *caldav.Handler
+	carddav    *carddav.Handler
+	addr       string
+	httpServer *http.Server
+}
+
+// NewServer creates a new CalDAV and CardDAV server storing its data in a
+// VFS, for the mail users in Redis
+func NewServer(fs vfs.VFSImplementation, redisClient *redis.Client, addr string) *Server {
+	store := &store{fs: fs}
+	return &Server{
+		store: store,
+		users: mailauth.NewStore(redisClient),
+		caldav: &caldav.Handler{
+			Backend: &calendarBackend{store: store},
+			Prefix:  CalDAVPrefix,
+		},
+		carddav: &carddav.Handler{
+			Backend: &contactBackend{store: store},
+			Prefix:  CardDAVPrefix,
+		},
+		addr: addr,
+	}
+}
+
+// Handler returns the HTTP handler of the server
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(s.serve)
+}
+
+// serve authenticates a request and passes it on to the CalDAV or CardDAV
+// handler
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		unauthorized(w)
+		return
+	}
+	username, err := s.users.Authenticate(name, password)
+	if err != nil {
+		unauthorized(w)
+		return
+	}
+	if err := s.store.ensureHome(username); err != nil {
+		log.Printf("ERROR: Failed to create the home of %s: %v", username, err)
+		http.Error(w, "failed to create home", http.StatusInternalServerError)
+		return
+	}
+	r = r.WithContext(context.WithValue(r.Context(), userKey{}, username))
+
+	switch {
+	case r.URL.Path == "/.well-known/caldav" || hasPathPrefix(r.URL.Path, CalDAVPrefix):
+		s.caldav.ServeHTTP(w, r)
+	case r.URL.Path == "/.well-known/carddav" || hasPathPrefix(r.URL.Path, CardDAVPrefix):
+		s.carddav.ServeHTTP(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// hasPathPrefix returns whether a path is prefix or below it
+func hasPathPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// unauthorized asks the client to log in
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="HeroLauncher groupware"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// ListenAndServe starts the server
+func (s *Server) ListenAndServe() error {
+	s.httpServer = &http.Server{
+		Addr:    s.addr,
+		Handler: s.Handler(),
+	}
+
+	log.Printf("Starting CalDAV and CardDAV server on %s", s.addr)
+	return s.httpServer.ListenAndServe()
+}
+
+// Shutdown gracefully stops a server started with ListenAndServe
+func (s *Server) Shutdown() error {
+	if s.httpServer == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.httpServer.Shutdown(ctx)
+}
@@ -0,0 +1,135 @@
+package groupware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/mailauth"
+	"github.com/freeflowuniverse/herolauncher/pkg/redisserver/redistest"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfsdb"
+)
+
+const testEvent = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//herolauncher//test//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:%s\r\n" +
+	"DTSTAMP:20240101T090000Z\r\n" +
+	"DTSTART:20240102T100000Z\r\n" +
+	"DTEND:20240102T110000Z\r\n" +
+	"SUMMARY:Planning\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+const testCard = "BEGIN:VCARD\r\n" +
+	"VERSION:3.0\r\n" +
+	"UID:bob-1\r\n" +
+	"FN:Bob Builder\r\n" +
+	"EMAIL:bob@example.com\r\n" +
+	"END:VCARD\r\n"
+
+func newTestServer(t *testing.T) *httptest.Server {
+	client := redistest.NewClient(t)
+	users := mailauth.NewStore(client)
+	for _, name := range []string{"alice", "bob"} {
+		if err := users.Add(name, "secret"); err != nil {
+			t.Fatalf("Failed to add user: %v", err)
+		}
+	}
+
+	fs, err := vfsdb.NewFromPath(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create VFS: %v", err)
+	}
+	httpServer := httptest.NewServer(NewServer(fs, client, "127.0.0.1:0").Handler())
+	t.Cleanup(httpServer.Close)
+	return httpServer
+}
+
+func TestServer(t *testing.T) {
+	httpServer := newTestServer(t)
+
+	request := func(method, user, urlPath, contentType, body string, header ...string) (int, string) {
+		req, err := http.NewRequest(method, httpServer.URL+urlPath, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		if user != "" {
+			req.SetBasicAuth(user, "secret")
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, urlPath, err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(data)
+	}
+	event := func(uid string) string {
+		return strings.Replace(testEvent, "%s", uid, 1)
+	}
+
+	if status, _ := request("PROPFIND", "", "/caldav/alice/calendars/", "", ""); status != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without login, got %d", status)
+	}
+
+	// The default calendar is created when the user first connects
+	status, body := request("PROPFIND", "alice", "/caldav/alice/calendars/", "", "", "Depth", "1")
+	if status != http.StatusMultiStatus || !strings.Contains(body, "/caldav/alice/calendars/default/") {
+		t.Fatalf("Expected the default calendar, got %d: %s", status, body)
+	}
+
+	eventPath := "/caldav/alice/calendars/default/planning.ics"
+	if status, _ := request(http.MethodPut, "alice", eventPath, "text/calendar", event("planning-1")); status != http.StatusCreated {
+		t.Fatalf("Expected the event to be created, got %d", status)
+	}
+	status, body = request(http.MethodGet, "alice", eventPath, "", "")
+	if status != http.StatusOK || !strings.Contains(body, "UID:planning-1") {
+		t.Errorf("Expected the event, got %d: %s", status, body)
+	}
+	if status, _ := request(http.MethodPut, "alice", eventPath, "text/calendar", event("planning-1"), "If-None-Match", "*"); status != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 when overwriting with If-None-Match, got %d", status)
+	}
+	if status, _ := request(http.MethodPut, "alice", "/caldav/alice/calendars/default/copy.ics", "text/calendar", event("planning-1")); status != http.StatusConflict {
+		t.Errorf("Expected 409 for a duplicate UID, got %d", status)
+	}
+
+	// Users only see their own calendars
+	if status, _ := request(http.MethodGet, "bob", eventPath, "", ""); status != http.StatusForbidden {
+		t.Errorf("Expected 403 for the calendar of another user, got %d", status)
+	}
+
+	if status, _ := request("MKCOL", "alice", "/caldav/alice/calendars/work/", "", ""); status != http.StatusCreated {
+		t.Errorf("Expected the calendar to be created, got %d", status)
+	}
+	if status, _ := request(http.MethodDelete, "alice", "/caldav/alice/calendars/work/", "", ""); status != http.StatusNoContent {
+		t.Errorf("Expected the calendar to be deleted, got %d", status)
+	}
+	if status, _ := request(http.MethodDelete, "alice", eventPath, "", ""); status != http.StatusNoContent {
+		t.Errorf("Expected the event to be deleted, got %d", status)
+	}
+	if status, _ := request(http.MethodGet, "alice", eventPath, "", ""); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for the deleted event, got %d", status)
+	}
+
+	cardPath := "/carddav/alice/contacts/default/bob.vcf"
+	if status, _ := request(http.MethodPut, "alice", cardPath, "text/vcard", testCard); status != http.StatusCreated {
+		t.Fatalf("Expected the contact to be created, got %d", status)
+	}
+	status, body = request(http.MethodGet, "alice", cardPath, "", "")
+	if status != http.StatusOK || !strings.Contains(body, "FN:Bob Builder") {
+		t.Errorf("Expected the contact, got %d: %s", status, body)
+	}
+	if status, _ := request(http.MethodPut, "alice", "/carddav/alice/contacts/default/bob2.vcf", "text/vcard", testCard); status != http.StatusConflict {
+		t.Errorf("Expected 409 for a duplicate UID, got %d", status)
+	}
+}
@@ -0,0 +1,293 @@
+package groupware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-webdav"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+)
+
+const (
+	// calendarsDir and contactsDir are the directories of a user's home
+	// holding the calendars and the address books
+	calendarsDir = "calendars"
+	contactsDir  = "contacts"
+
+	// defaultCollection is the name of the calendar and the address book
+	// every user gets
+	defaultCollection = "default"
+
+	// nameAttribute and descriptionAttribute are the extended attributes
+	// holding the display name and description of a collection
+	nameAttribute        = "displayname"
+	descriptionAttribute = "description"
+)
+
+// userKey is the context key of the name of the logged in user
+type userKey struct{}
+
+// currentUser returns the name of the user a request is made by
+func currentUser(ctx context.Context) (string, error) {
+	username, ok := ctx.Value(userKey{}).(string)
+	if !ok || username == "" {
+		return "", webdav.NewHTTPError(http.StatusUnauthorized, fmt.Errorf("not logged in"))
+	}
+	return username, nil
+}
+
+// object is a stored calendar object or vCard
+type object struct {
+	path     string
+	data     []byte
+	metadata *vfs.Metadata
+}
+
+// etag returns the ETag of the object, the hash of its data
+func (o *object) etag() string {
+	sum := sha256.Sum256(o.data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// modTime returns when the object was last written
+func (o *object) modTime() time.Time {
+	return time.Unix(o.metadata.ModifiedAt, 0)
+}
+
+// store keeps the collections of the users in a VFS
+type store struct {
+	fs vfs.VFSImplementation
+}
+
+// ensureHome creates the home of a user with a default calendar and address
+// book when the user connects for the first time
+func (s *store) ensureHome(username string) error {
+	home := "/" + username
+	for _, dir := range []string{calendarsDir, contactsDir} {
+		if s.fs.Exists(path.Join(home, dir)) {
+			continue
+		}
+		for _, p := range []string{home, path.Join(home, dir), path.Join(home, dir, defaultCollection)} {
+			if err := s.mkdir(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mkdir creates a directory unless it exists
+func (s *store) mkdir(p string) error {
+	if s.fs.Exists(p) {
+		return nil
+	}
+	// Another request may have created it in the meantime
+	if _, err := s.fs.DirCreate(p); err != nil && !s.fs.Exists(p) {
+		return fmt.Errorf("failed to create directory %s: %w", p, err)
+	}
+	return nil
+}
+
+// resolve returns the VFS path of a URL path below prefix, which must be in
+// the collections of kind of the logged in user and have depth elements
+// below the home: 2 for a collection, 3 for an object
+func resolve(ctx context.Context, prefix, urlPath, kind string, depth int) (string, error) {
+	username, err := currentUser(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !hasPathPrefix(urlPath, prefix) {
+		return "", webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("no such resource: %s", urlPath))
+	}
+	p := path.Clean("/" + strings.TrimPrefix(urlPath, prefix))
+	parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	if parts[0] != username {
+		return "", webdav.NewHTTPError(http.StatusForbidden, fmt.Errorf("%s is not in the home of %s", urlPath, username))
+	}
+	if len(parts) != depth+1 || parts[1] != kind {
+		return "", webdav.NewHTTPError(http.StatusForbidden, fmt.Errorf("not a %s resource: %s", kind, urlPath))
+	}
+	return p, nil
+}
+
+// homePath returns the URL path of the home of the logged in user
+func homePath(ctx context.Context, prefix string) (string, error) {
+	username, err := currentUser(ctx)
+	if err != nil {
+		return "", err
+	}
+	return prefix + "/" + username + "/", nil
+}
+
+// collection is a stored calendar or address book
+type collection struct {
+	path        string
+	name        string
+	description string
+}
+
+// createCollection creates a calendar or address book
+func (s *store) createCollection(p, name, description string) error {
+	if s.fs.Exists(p) {
+		return webdav.NewHTTPError(http.StatusMethodNotAllowed, fmt.Errorf("%s already exists", p))
+	}
+	if _, err := s.fs.DirCreate(p); err != nil {
+		return fmt.Errorf("failed to create %s: %w", p, err)
+	}
+	if name != "" {
+		if err := s.fs.AttrSet(p, nameAttribute, name); err != nil {
+			return fmt.Errorf("failed to set the name of %s: %w", p, err)
+		}
+	}
+	if description != "" {
+		if err := s.fs.AttrSet(p, descriptionAttribute, description); err != nil {
+			return fmt.Errorf("failed to set the description of %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// getCollection returns a calendar or address book
+func (s *store) getCollection(p string) (*collection, error) {
+	entry, err := s.fs.Get(p)
+	if err != nil || !entry.IsDir() {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("no such collection: %s", p))
+	}
+	return newCollection(p, entry.GetMetadata()), nil
+}
+
+// newCollection returns the collection at a path with its metadata
+func newCollection(p string, metadata *vfs.Metadata) *collection {
+	c := &collection{
+		path: p,
+		name: metadata.Name,
+	}
+	if name, ok := metadata.Attribute(nameAttribute); ok {
+		c.name = name
+	}
+	c.description, _ = metadata.Attribute(descriptionAttribute)
+	return c
+}
+
+// listCollections returns the calendars or address books in a directory
+func (s *store) listCollections(dir string) ([]collection, error) {
+	entries, err := s.fs.DirList(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	var collections []collection
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		metadata := entry.GetMetadata()
+		collections = append(collections, *newCollection(path.Join(dir, metadata.Name), metadata))
+	}
+	return collections, nil
+}
+
+// deleteCollection deletes a calendar or address book with its objects
+func (s *store) deleteCollection(p string) error {
+	objects, err := s.listObjects(p)
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		if err := s.fs.FileDelete(o.path); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", o.path, err)
+		}
+	}
+	if err := s.fs.Delete(p); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", p, err)
+	}
+	return nil
+}
+
+// getObject returns a stored object
+func (s *store) getObject(p string) (*object, error) {
+	entry, err := s.fs.Get(p)
+	if err != nil || !entry.IsFile() {
+		return nil, webdav.NewHTTPError(http.StatusNotFound, fmt.Errorf("no such object: %s", p))
+	}
+	data, err := s.fs.FileRead(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", p, err)
+	}
+	return &object{path: p, data: data, metadata: entry.GetMetadata()}, nil
+}
+
+// listObjects returns the objects of a collection
+func (s *store) listObjects(dir string) ([]object, error) {
+	if _, err := s.getCollection(dir); err != nil {
+		return nil, err
+	}
+	entries, err := s.fs.DirList(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+	var objects []object
+	for _, entry := range entries {
+		if !entry.IsFile() {
+			continue
+		}
+		p := path.Join(dir, entry.GetMetadata().Name)
+		data, err := s.fs.FileRead(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", p, err)
+		}
+		objects = append(objects, object{path: p, data: data, metadata: entry.GetMetadata()})
+	}
+	return objects, nil
+}
+
+// putObject stores an object in an existing collection, if the conditional
+// headers of the request allow it
+func (s *store) putObject(p string, data []byte, ifNoneMatch, ifMatch webdav.ConditionalMatch) (*object, error) {
+	if _, err := s.getCollection(path.Dir(p)); err != nil {
+		return nil, webdav.NewHTTPError(http.StatusConflict, err)
+	}
+
+	existing, err := s.getObject(p)
+	exists := err == nil
+	if ifNoneMatch.IsWildcard() && exists {
+		return nil, webdav.NewHTTPError(http.StatusPreconditionFailed, fmt.Errorf("%s already exists", p))
+	}
+	if ifMatch.IsSet() {
+		if !exists {
+			return nil, webdav.NewHTTPError(http.StatusPreconditionFailed, fmt.Errorf("%s does not exist", p))
+		}
+		if !ifMatch.IsWildcard() {
+			etag, err := ifMatch.ETag()
+			if err != nil || etag != existing.etag() {
+				return nil, webdav.NewHTTPError(http.StatusPreconditionFailed, fmt.Errorf("%s was changed", p))
+			}
+		}
+	}
+
+	if !exists {
+		if _, err := s.fs.FileCreate(p); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", p, err)
+		}
+	}
+	if err := s.fs.FileWrite(p, data); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", p, err)
+	}
+	return s.getObject(p)
+}
+
+// deleteObject deletes a stored object
+func (s *store) deleteObject(p string) error {
+	if _, err := s.getObject(p); err != nil {
+		return err
+	}
+	if err := s.fs.FileDelete(p); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", p, err)
+	}
+	return nil
+}
@@ -0,0 +1,467 @@
+package handlerfactory
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AuditEvent is what an entry of the audit log records
+type AuditEvent string
+
+const (
+	// AuditLogin is a session that authenticated
+	AuditLogin AuditEvent = "login"
+	// AuditCommand is a command that a session issued, with its result
+	AuditCommand AuditEvent = "command"
+	// AuditLogout is a session that ended
+	AuditLogout AuditEvent = "logout"
+)
+
+// maxAuditResult is the number of bytes of a result that the audit log
+// keeps
+const maxAuditResult = 4096
+
+// AuditEntry is an entry of the audit log of the sessions of the telnet
+// server
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Session is the ID of the session, which its entries have in common
+	Session   string     `json:"session"`
+	Event     AuditEvent `json:"event"`
+	Remote    string     `json:"remote"`
+	Transport string     `json:"transport"`
+	// User is the user of SSH sessions, and Key the fingerprint of the key
+	// they logged in with
+	User string `json:"user,omitempty"`
+	Key  string `json:"key,omitempty"`
+	// Mode is how the command was handled, run, plan or undo for scripts
+	Mode    string `json:"mode,omitempty"`
+	Command string `json:"command,omitempty"`
+	// Result is the result of the command, cut at 4 KB
+	Result string `json:"result,omitempty"`
+	Failed bool   `json:"failed,omitempty"`
+	// Duration is how long the command took, or the session for logouts
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// AuditQuery selects entries of the audit log. Empty fields select all
+// entries.
+type AuditQuery struct {
+	Session string
+	Event   AuditEvent
+	// Remote and User select the entries whose remote address or user
+	// contain them
+	Remote string
+	User   string
+	// Contains selects the commands that contain it
+	Contains     string
+	Since, Until time.Time
+	// Limit returns the last Limit entries that match, if it is not 0
+	Limit int
+}
+
+// Match reports whether the query selects an entry, not counting its limit
+func (q AuditQuery) Match(entry *AuditEntry) bool {
+	switch {
+	case q.Session != "" && entry.Session != q.Session:
+		return false
+	case q.Event != "" && entry.Event != q.Event:
+		return false
+	case !strings.Contains(entry.Remote, q.Remote) || !strings.Contains(entry.User, q.User):
+		return false
+	case q.Contains != "" && !strings.Contains(entry.Command, q.Contains):
+		return false
+	case !q.Since.IsZero() && entry.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && entry.Time.After(q.Until):
+		return false
+	}
+	return true
+}
+
+// limit returns the last entries of the limit of the query
+func (q AuditQuery) limit(entries []*AuditEntry) []*AuditEntry {
+	if q.Limit > 0 && len(entries) > q.Limit {
+		return entries[len(entries)-q.Limit:]
+	}
+	return entries
+}
+
+// AuditStore stores the audit log of the sessions of the telnet server
+type AuditStore interface {
+	// AddAuditEntry adds an entry to the end of the log
+	AddAuditEntry(ctx context.Context, entry *AuditEntry) error
+	// QueryAudit returns the entries that match a query, the oldest first
+	QueryAudit(ctx context.Context, query AuditQuery) ([]*AuditEntry, error)
+}
+
+// EnableAudit records the sessions of the telnet servers of the factory that
+// authenticate in an audit log: when they log in and out, and the commands
+// they issue with their results and how long they took. The scripts are
+// recorded as they are sent, with their params, so the log must be kept as
+// safe as the secrets. It registers the audit actor to query the log:
+//
+//	!!audit.query session:'4f1c2a9e8b7d6c5a'
+//	!!audit.query remote:'10.0.0.5' since:24h event:command
+//	!!audit.query contains:'vm.delete' limit:10 format:json
+func (f *HandlerFactory) EnableAudit(store AuditStore) error {
+	if f.audit != nil {
+		return fmt.Errorf("the audit log is enabled already")
+	}
+	if store == nil {
+		return fmt.Errorf("no store for the audit log")
+	}
+	if err := f.RegisterHandler(newAuditHandler(store)); err != nil {
+		return err
+	}
+	f.audit = store
+	return nil
+}
+
+// newAuditEntry returns an entry of the audit log with a result that is cut
+// at maxAuditResult
+func newAuditEntry(entry AuditEntry) *AuditEntry {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	if len(entry.Result) > maxAuditResult {
+		cut := maxAuditResult
+		for cut > 0 && !utf8.RuneStart(entry.Result[cut]) {
+			cut--
+		}
+		entry.Result = entry.Result[:cut] + "..."
+	}
+	return &entry
+}
+
+// FileAuditStore stores the audit log in a file, with an entry as JSON per
+// line, which it only appends to
+type FileAuditStore struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// NewFileAuditStore opens an audit log in a file, which is created with
+// permissions 0600 if it does not exist
+func NewFileAuditStore(path string) (*FileAuditStore, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &FileAuditStore{path: path, file: file}, nil
+}
+
+// AddAuditEntry implements AuditStore
+func (s *FileAuditStore) AddAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(data, '\n'))
+	return err
+}
+
+// QueryAudit implements AuditStore. It reads the whole file.
+func (s *FileAuditStore) QueryAudit(ctx context.Context, query AuditQuery) ([]*AuditEntry, error) {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	var entries []*AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*maxAuditResult+1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse line %d of audit log: %w", line, err)
+		}
+		if query.Match(&entry) {
+			entries = append(entries, &entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return query.limit(entries), nil
+}
+
+// Close closes the file
+func (s *FileAuditStore) Close() error {
+	return s.file.Close()
+}
+
+// redisAuditStream is the key of the stream of the audit log in Redis
+const redisAuditStream = "handlerfactory:audit"
+
+// RedisAuditStore stores the audit log in a Redis stream, with an entry as
+// JSON in the entry field of a message. It needs a Redis server with
+// streams, which the redisserver package does not have.
+type RedisAuditStore struct {
+	client *redis.Client
+	maxLen int64
+}
+
+// NewRedisAuditStore creates an audit log in Redis that keeps about the last
+// maxLen entries, or all if maxLen is 0
+func NewRedisAuditStore(client *redis.Client, maxLen int64) *RedisAuditStore {
+	return &RedisAuditStore{client: client, maxLen: maxLen}
+}
+
+// AddAuditEntry implements AuditStore
+func (s *RedisAuditStore) AddAuditEntry(ctx context.Context, entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: redisAuditStream,
+		MaxLen: s.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{"entry": string(data)},
+	}).Err()
+}
+
+// QueryAudit implements AuditStore. The messages are read from the time of
+// Since to Until, by the IDs that Redis gives them.
+func (s *RedisAuditStore) QueryAudit(ctx context.Context, query AuditQuery) ([]*AuditEntry, error) {
+	start, stop := "-", "+"
+	if !query.Since.IsZero() {
+		start = strconv.FormatInt(query.Since.UnixMilli(), 10)
+	}
+	if !query.Until.IsZero() {
+		stop = strconv.FormatInt(query.Until.UnixMilli(), 10)
+	}
+	messages, err := s.client.XRange(ctx, redisAuditStream, start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*AuditEntry
+	for _, message := range messages {
+		data, ok := message.Values["entry"].(string)
+		if !ok {
+			return nil, fmt.Errorf("audit message %s has no entry", message.ID)
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse audit message %s: %w", message.ID, err)
+		}
+		if query.Match(&entry) {
+			entries = append(entries, &entry)
+		}
+	}
+	return query.limit(entries), nil
+}
+
+// auditHandler is the handler of the audit actor, which queries the audit
+// log
+type auditHandler struct {
+	BaseHandler
+	store AuditStore
+}
+
+// newAuditHandler creates the handler of the audit actor
+func newAuditHandler(store AuditStore) *auditHandler {
+	return &auditHandler{
+		BaseHandler: BaseHandler{ActorName: "audit"},
+		store:       store,
+	}
+}
+
+// ActionSchemas implements SchemaProvider
+func (h *auditHandler) ActionSchemas() map[string]ActionSchema {
+	return map[string]ActionSchema{
+		"query": {Description: "Show the entries of the audit log of the sessions", Params: []ParamSchema{
+			{Name: "session", Description: "ID of the session"},
+			{Name: "event", Enum: []string{string(AuditLogin), string(AuditCommand), string(AuditLogout)}, Description: "Kind of the entries"},
+			{Name: "remote", Description: "Part of the remote address of the sessions"},
+			{Name: "user", Description: "Part of the SSH user of the sessions"},
+			{Name: "contains", Description: "Part of the commands"},
+			{Name: "since", Description: "Time of the first entry, like 2006-01-02 15:04:05, or a duration before now, like 24h"},
+			{Name: "until", Description: "Time of the last entry, or a duration before now"},
+			{Name: "limit", Type: ParamTypeInt, Default: "50", Description: "Number of entries, the last ones; 0 for all"},
+			{Name: "format", Enum: []string{"text", "json"}, Default: "text", Description: "Format of the entries"},
+		}},
+	}
+}
+
+// Query shows the entries of the audit log that match the params
+func (h *auditHandler) Query(script string) string {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+	query := AuditQuery{
+		Session:  params.Get("session"),
+		Event:    AuditEvent(strings.ToLower(params.Get("event"))),
+		Remote:   params.Get("remote"),
+		User:     params.GetVerbatim("user"),
+		Contains: params.Get("contains"),
+		Limit:    params.GetIntDefault("limit", 50),
+	}
+	for name, t := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if value := params.Get(name); value != "" {
+			if *t, err = parseAuditTime(value); err != nil {
+				return fmt.Sprintf("Error: invalid %s: %v", name, err)
+			}
+		}
+	}
+
+	entries, err := h.store.QueryAudit(context.Background(), query)
+	if err != nil {
+		return fmt.Sprintf("Error: failed to query audit log: %v", err)
+	}
+	if strings.EqualFold(params.Get("format"), "json") {
+		if entries == nil {
+			entries = []*AuditEntry{}
+		}
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return fmt.Sprintf("Error: %v", err)
+		}
+		return string(data)
+	}
+	if len(entries) == 0 {
+		return "No audit entries"
+	}
+	var lines []string
+	for _, entry := range entries {
+		lines = append(lines, formatAuditEntry(entry))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseAuditTime parses a time of a query, as a duration before now or a
+// local time with or without seconds, or in RFC 3339
+func parseAuditTime(value string) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-duration), nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, errors.New("expected a time like 2006-01-02 15:04:05 or a duration like 24h")
+}
+
+// formatAuditEntry formats an entry as a line with its time, session and
+// event, followed by the lines of its command and result, indented
+func formatAuditEntry(entry *AuditEntry) string {
+	line := fmt.Sprintf("%s %s %s", entry.Time.Format("2006-01-02 15:04:05"), entry.Session, entry.Event)
+	switch entry.Event {
+	case AuditLogin, AuditLogout:
+		line += fmt.Sprintf(" %s %s", entry.Transport, entry.Remote)
+		if entry.User != "" {
+			line += " user=" + entry.User
+		}
+		if entry.Key != "" {
+			line += " key=" + entry.Key
+		}
+	case AuditCommand:
+		if entry.Mode != "" {
+			line += " " + entry.Mode
+		}
+		if entry.Failed {
+			line += " failed"
+		}
+	}
+	if entry.Duration > 0 {
+		line += fmt.Sprintf(" (%s)", entry.Duration.Round(time.Millisecond))
+	}
+	for _, command := range strings.Split(entry.Command, "\n") {
+		if command != "" {
+			line += "\n  > " + command
+		}
+	}
+	for _, result := range strings.Split(entry.Result, "\n") {
+		if result != "" {
+			line += "\n    " + result
+		}
+	}
+	return line
+}
+
+// auditSession records a session of a telnet server in the audit log. Its
+// methods do nothing on a nil session, which sessions are when the audit log
+// is not enabled.
+type auditSession struct {
+	store AuditStore
+	// entry has the fields that the entries of the session have in common
+	entry AuditEntry
+	start time.Time
+}
+
+// newAuditSession records the login of a session, if the audit log is
+// enabled
+func (f *HandlerFactory) newAuditSession(remote, transport, user, key string) *auditSession {
+	if f.audit == nil {
+		return nil
+	}
+	id, err := newID()
+	if err != nil {
+		fmt.Printf("Failed to start audit session: %v\n", err)
+		return nil
+	}
+	s := &auditSession{
+		store: f.audit,
+		entry: AuditEntry{Session: id, Remote: remote, Transport: transport, User: user, Key: key},
+		start: time.Now(),
+	}
+	s.add(AuditEntry{Event: AuditLogin})
+	return s
+}
+
+// command records a command of the session with its result
+func (s *auditSession) command(mode, command, result string, duration time.Duration) {
+	if s == nil {
+		return
+	}
+	s.add(AuditEntry{
+		Event:    AuditCommand,
+		Mode:     mode,
+		Command:  command,
+		Result:   result,
+		Failed:   strings.HasPrefix(result, "Error"),
+		Duration: duration,
+	})
+}
+
+// logout records the end of the session
+func (s *auditSession) logout() {
+	if s == nil {
+		return
+	}
+	s.add(AuditEntry{Event: AuditLogout, Duration: time.Since(s.start)})
+}
+
+// add adds an entry with the fields of the session to the audit log
+func (s *auditSession) add(entry AuditEntry) {
+	entry.Session, entry.Remote, entry.Transport = s.entry.Session, s.entry.Remote, s.entry.Transport
+	entry.User, entry.Key = s.entry.User, s.entry.Key
+	if err := s.store.AddAuditEntry(context.Background(), newAuditEntry(entry)); err != nil {
+		fmt.Printf("Failed to add audit entry: %v\n", err)
+	}
+}
@@ -0,0 +1,189 @@
+package handlerfactory
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestNewAuditEntry(t *testing.T) {
+	ascii := strings.Repeat("a", maxAuditResult-1)
+	tests := []struct {
+		name   string
+		result string
+		want   string
+	}{
+		{"short", "done", "done"},
+		{"at the limit", ascii + "b", ascii + "b"},
+		{"over the limit", ascii + "bc", ascii + "b..."},
+		// The cut falls in the middle of the two bytes of é, which is left
+		// out whole
+		{"in a rune", ascii + "é", ascii + "..."},
+		{"in a longer rune", strings.Repeat("a", maxAuditResult-2) + "€", strings.Repeat("a", maxAuditResult-2) + "..."},
+	}
+	for _, test := range tests {
+		entry := newAuditEntry(AuditEntry{Event: AuditCommand, Result: test.result})
+		if entry.Result != test.want {
+			t.Errorf("%s: expected %d bytes, got %d", test.name, len(test.want), len(entry.Result))
+		}
+		if !utf8.ValidString(entry.Result) {
+			t.Errorf("%s: expected a valid UTF-8 result", test.name)
+		}
+		if entry.Time.IsZero() {
+			t.Errorf("%s: expected the entry to get a time", test.name)
+		}
+	}
+}
+
+func TestAuditQuery(t *testing.T) {
+	now := time.Now()
+	entry := &AuditEntry{
+		Time:    now,
+		Session: "4f1c2a9e8b7d6c5a",
+		Event:   AuditCommand,
+		Remote:  "10.0.0.5:4000",
+		User:    "alice",
+		Command: "!!vm.delete name:'web'",
+	}
+	tests := []struct {
+		name  string
+		query AuditQuery
+		want  bool
+	}{
+		{"empty", AuditQuery{}, true},
+		{"session", AuditQuery{Session: "4f1c2a9e8b7d6c5a"}, true},
+		{"other session", AuditQuery{Session: "4f1c2a9e8b7d6c5b"}, false},
+		{"event", AuditQuery{Event: AuditCommand}, true},
+		{"other event", AuditQuery{Event: AuditLogin}, false},
+		{"part of remote", AuditQuery{Remote: "10.0.0."}, true},
+		{"other remote", AuditQuery{Remote: "10.0.1."}, false},
+		{"part of user", AuditQuery{User: "ali"}, true},
+		{"other user", AuditQuery{User: "bob"}, false},
+		{"contains", AuditQuery{Contains: "vm.delete"}, true},
+		{"does not contain", AuditQuery{Contains: "vm.create"}, false},
+		{"since before", AuditQuery{Since: now.Add(-time.Minute)}, true},
+		{"since after", AuditQuery{Since: now.Add(time.Minute)}, false},
+		{"until after", AuditQuery{Until: now.Add(time.Minute)}, true},
+		{"until before", AuditQuery{Until: now.Add(-time.Minute)}, false},
+		{"all fields", AuditQuery{Session: "4f1c2a9e8b7d6c5a", Event: AuditCommand, Remote: "10.0.0.5", User: "alice", Contains: "web"}, true},
+		{"one field differs", AuditQuery{Session: "4f1c2a9e8b7d6c5a", Event: AuditCommand, User: "bob"}, false},
+	}
+	for _, test := range tests {
+		if got := test.query.Match(entry); got != test.want {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+}
+
+func TestFileAuditStore(t *testing.T) {
+	store, err := NewFileAuditStore(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer store.Close()
+	f, _ := newTestFactory(t)
+	if err := f.EnableAudit(store); err != nil {
+		t.Fatalf("Failed to enable audit log: %v", err)
+	}
+
+	start := time.Now().Add(-time.Hour)
+	for i, entry := range []AuditEntry{
+		{Session: "one", Event: AuditLogin, Remote: "10.0.0.5:4000", Transport: "telnet"},
+		{Session: "one", Event: AuditCommand, Remote: "10.0.0.5:4000", Transport: "telnet", Command: "!!test.add name:'a'", Result: "added a"},
+		{Session: "two", Event: AuditLogin, Remote: "10.0.0.6:4000", Transport: "ssh", User: "bob"},
+		{Session: "two", Event: AuditCommand, Remote: "10.0.0.6:4000", Transport: "ssh", User: "bob", Command: "!!test.fail", Result: "Error: failed on purpose", Failed: true},
+		{Session: "one", Event: AuditCommand, Remote: "10.0.0.5:4000", Transport: "telnet", Command: "!!test.add name:'b'", Result: "added b"},
+		{Session: "one", Event: AuditLogout, Remote: "10.0.0.5:4000", Transport: "telnet", Duration: time.Minute},
+	} {
+		entry.Time = start.Add(time.Duration(i) * time.Minute)
+		if err := store.AddAuditEntry(context.Background(), newAuditEntry(entry)); err != nil {
+			t.Fatalf("Failed to add audit entry: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query AuditQuery
+		want  []string
+	}{
+		{"all", AuditQuery{}, []string{"one login", "one command", "two login", "two command", "one command", "one logout"}},
+		{"session", AuditQuery{Session: "two"}, []string{"two login", "two command"}},
+		{"commands", AuditQuery{Event: AuditCommand}, []string{"one command", "two command", "one command"}},
+		{"last commands", AuditQuery{Event: AuditCommand, Limit: 2}, []string{"two command", "one command"}},
+		{"limit over the entries", AuditQuery{Session: "two", Limit: 10}, []string{"two login", "two command"}},
+		{"since", AuditQuery{Since: start.Add(3 * time.Minute)}, []string{"two command", "one command", "one logout"}},
+		{"none", AuditQuery{User: "carol"}, nil},
+	}
+	for _, test := range tests {
+		entries, err := store.QueryAudit(context.Background(), test.query)
+		if err != nil {
+			t.Fatalf("%s: failed to query audit log: %v", test.name, err)
+		}
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.Session+" "+string(entry.Event))
+		}
+		if strings.Join(got, ", ") != strings.Join(test.want, ", ") {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+
+	// The audit actor queries the log
+	result, err := f.ProcessHeroscript("!!audit.query user:'bob' event:command format:json")
+	if err != nil {
+		t.Fatalf("Failed to query audit log: %v", err)
+	}
+	var entries []*AuditEntry
+	if err := json.Unmarshal([]byte(result), &entries); err != nil {
+		t.Fatalf("Failed to parse %q: %v", result, err)
+	}
+	if len(entries) != 1 || entries[0].Command != "!!test.fail" || !entries[0].Failed {
+		t.Errorf("Expected the failed command of bob, got %q", result)
+	}
+	if result, err := f.ProcessHeroscript("!!audit.query user:'carol' format:json"); err != nil || result != "[]" {
+		t.Errorf("Expected no entries, got %q, %v", result, err)
+	}
+	if result, err := f.ProcessHeroscript("!!audit.query user:'carol'"); err != nil || result != "No audit entries" {
+		t.Errorf("Expected no entries, got %q, %v", result, err)
+	}
+	result, err = f.ProcessHeroscript("!!audit.query session:'one' limit:1")
+	if err != nil || !strings.Contains(result, " one logout telnet 10.0.0.5:4000 (1m0s)") {
+		t.Errorf("Expected the logout of session one, got %q, %v", result, err)
+	}
+	if _, err := f.ProcessHeroscript("!!audit.query since:'yesterday'"); err == nil || !strings.Contains(err.Error(), "invalid since") {
+		t.Errorf("Expected an invalid time to be rejected, got %v", err)
+	}
+}
+
+func TestParseAuditTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"2024-03-01 12:30:15", time.Date(2024, 3, 1, 12, 30, 15, 0, time.Local), true},
+		{"2024-03-01 12:30", time.Date(2024, 3, 1, 12, 30, 0, 0, time.Local), true},
+		{"2024-03-01", time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local), true},
+		{"2024-03-01T12:30:15Z", time.Date(2024, 3, 1, 12, 30, 15, 0, time.UTC), true},
+		{"2024-03-01T12:30:15+02:00", time.Date(2024, 3, 1, 10, 30, 15, 0, time.UTC), true},
+		{"01/03/2024", time.Time{}, false},
+		{"yesterday", time.Time{}, false},
+		{"", time.Time{}, false},
+	}
+	for _, test := range tests {
+		got, err := parseAuditTime(test.value)
+		if (err == nil) != test.ok || !got.Equal(test.want) {
+			t.Errorf("%q: expected %v, %v, got %v, %v", test.value, test.want, test.ok, got, err)
+		}
+	}
+
+	// Durations are before now
+	got, err := parseAuditTime("24h")
+	if want := time.Now().Add(-24 * time.Hour); err != nil || got.Sub(want).Abs() > time.Minute {
+		t.Errorf("Expected 24h ago, got %v, %v", got, err)
+	}
+}
@@ -0,0 +1,345 @@
+package handlerfactory
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+)
+
+// DefaultClientTimeout is how long an action may take on the server when the
+// Timeout of a Client is not set
+const DefaultClientTimeout = 30 * time.Second
+
+// Client runs heroscript on the telnet server of a HandlerFactory on another
+// host. A client runs one script at a time.
+type Client struct {
+	target string
+	secret string
+	// Timeout is how long an action may take, DefaultClientTimeout if it is 0
+	Timeout time.Duration
+
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// ActionResult is the result of an action of a playbook that a Client ran
+type ActionResult struct {
+	ID     int    `json:"id"`
+	Actor  string `json:"actor"`
+	Name   string `json:"name"`
+	Result string `json:"result,omitempty"`
+	// Skipped actions did not run because their if condition did not hold
+	Skipped bool `json:"skipped,omitempty"`
+	// Undone actions ran and were rolled back as a later action failed
+	Undone bool   `json:"undone,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// NewClient creates a client for the telnet server at target, a TCP address
+// given as tcp://host:port or the path of a Unix socket, optionally given as
+// unix:///path
+func NewClient(target, secret string) *Client {
+	return &Client{
+		target: target,
+		secret: secret,
+	}
+}
+
+// targetNetwork returns the network and address of the target of a client
+func targetNetwork(target string) (network, address string) {
+	if address, ok := strings.CutPrefix(target, "tcp://"); ok {
+		return "tcp", address
+	}
+	return "unix", strings.TrimPrefix(target, "unix://")
+}
+
+// Connect connects to the telnet server and authenticates with the secret
+func (c *Client) Connect(ctx context.Context) error {
+	if c.conn != nil {
+		return nil
+	}
+
+	network, address := targetNetwork(c.target)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", c.target, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(c.timeout()))
+	}
+	defer conn.SetDeadline(time.Time{})
+	reader := bufio.NewReader(conn)
+
+	welcome, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to read welcome message: %v", err)
+	}
+	if !strings.Contains(welcome, "not authenticated") {
+		conn.Close()
+		return fmt.Errorf("unexpected welcome message: %s", strings.TrimSpace(welcome))
+	}
+
+	if _, err := fmt.Fprintf(conn, "!!core.auth secret:'%s'\n", c.secret); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to send secret: %v", err)
+	}
+	response, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to read authentication response: %v", err)
+	}
+	if !strings.Contains(response, "Authentication successful") {
+		conn.Close()
+		return fmt.Errorf("authentication failed: %s", strings.TrimSpace(response))
+	}
+
+	c.conn = conn
+	c.reader = reader
+	return nil
+}
+
+// Close closes the connection to the telnet server
+func (c *Client) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	c.conn.Write([]byte("!!quit\n"))
+	err := c.conn.Close()
+	c.conn = nil
+	c.reader = nil
+	return err
+}
+
+// timeout returns how long an action may take
+func (c *Client) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return DefaultClientTimeout
+}
+
+// Send sends a heroscript to the telnet server and returns its result. An
+// error that the server reports is returned as error.
+func (c *Client) Send(ctx context.Context, script string) (string, error) {
+	if err := c.Connect(ctx); err != nil {
+		return "", err
+	}
+
+	deadline := time.Now().Add(c.timeout())
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	c.conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	// The server runs the script at the empty line. The script must not end
+	// with an empty line, which makes the server run it again.
+	_, err := c.conn.Write([]byte(strings.TrimRight(script, "\n") + "\n\n"))
+	if err == nil {
+		var result string
+		if result, err = c.readResult(); err == nil {
+			c.conn.SetDeadline(time.Time{})
+			if message, ok := strings.CutPrefix(result, "Error"); ok {
+				message = strings.TrimPrefix(message, ":")
+				return "", errors.New(strings.TrimSpace(message))
+			}
+			return result, nil
+		}
+	}
+
+	// The rest of the result would be read as the next one
+	c.Close()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	return "", err
+}
+
+// readResult reads the lines of a result up to its end marker
+func (c *Client) readResult() (string, error) {
+	var result strings.Builder
+	inResult := false
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("failed to read response: %v", err)
+		}
+		switch {
+		case strings.HasPrefix(line, "**RESULT**"):
+			inResult = true
+		case strings.HasPrefix(line, "**ENDRESULT**"):
+			return strings.TrimSuffix(result.String(), "\n"), nil
+		case inResult:
+			result.WriteString(line)
+		}
+	}
+}
+
+// Run runs the actions of a playbook on the telnet server one by one and
+// returns their results. The if, foreach and as params of the actions are
+// evaluated by the client, with the results of the server. Run stops at the
+// first action that fails, whose error is in its result, and undoes the
+// actions that ran before it on the server unless options.NoRollback is set;
+// the error is then a *playbook.RollbackError.
+func (c *Client) Run(ctx context.Context, pb *playbook.PlayBook, options RunOptions) ([]ActionResult, error) {
+	failed := 0
+	var failure error
+	err := pb.ExecuteWithRollback(func(action *playbook.Action) (string, playbook.UndoFunc, error) {
+		// The server numbers the actions itself
+		script := *action
+		script.ID = 0
+		result, err := c.Send(ctx, script.HeroScript())
+		if err != nil {
+			failed, failure = action.ID, trimActionPrefix(err, action)
+			return "", nil, failure
+		}
+		return result, func() (string, error) {
+			result, err := c.Undo(ctx, script.HeroScript())
+			if err != nil {
+				return "", trimActionPrefix(err, action)
+			}
+			return result, nil
+		}, nil
+	}, !options.NoRollback)
+
+	actions, sortErr := pb.ActionsSorted(false)
+	if sortErr != nil {
+		return nil, sortErr
+	}
+	var results []ActionResult
+	for _, action := range actions {
+		result := ActionResult{ID: action.ID, Actor: action.Actor, Name: action.Name}
+		switch {
+		case action.Done:
+			result.Result = action.Result.Get("result")
+		case action.Result.GetBool("skipped"):
+			result.Skipped = true
+		case action.Result.GetBool("undone"):
+			result.Undone = true
+		case action.ID == failed:
+			result.Error = failure.Error()
+		default:
+			// Actions after a failure did not run
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, err
+}
+
+// trimActionPrefix removes the name of an action from an error of the
+// server, which names the action already, as Execute does
+func trimActionPrefix(err error, action *playbook.Action) error {
+	prefix := fmt.Sprintf("action %s.%s: ", action.Actor, action.Name)
+	if message, ok := strings.CutPrefix(err.Error(), prefix); ok {
+		return errors.New(message)
+	}
+	return err
+}
+
+// Actions returns the actions of the handlers of the telnet server with their
+// schemas
+func (c *Client) Actions(ctx context.Context) ([]ActionInfo, error) {
+	if err := c.Connect(ctx); err != nil {
+		return nil, err
+	}
+	c.conn.SetDeadline(time.Now().Add(c.timeout()))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if _, err := c.conn.Write([]byte("!!schemas\n")); err != nil {
+		return nil, fmt.Errorf("failed to request schemas: %v", err)
+	}
+	result, err := c.readResult()
+	if err != nil {
+		// The rest of the result would be read as the next one
+		c.Close()
+		return nil, err
+	}
+	if message, ok := strings.CutPrefix(result, "Error"); ok {
+		return nil, errors.New(strings.TrimSpace(strings.TrimPrefix(message, ":")))
+	}
+	var actions []ActionInfo
+	if err := json.Unmarshal([]byte(result), &actions); err != nil {
+		return nil, fmt.Errorf("invalid schemas: %v", err)
+	}
+	return actions, nil
+}
+
+// Plan returns what the actions of a playbook would do on the telnet server,
+// without running any, as the server formats its plan. The includes of the
+// playbook are resolved by the client. A plan with invalid actions is
+// returned as error, followed by the plan.
+func (c *Client) Plan(ctx context.Context, pb *playbook.PlayBook) (string, error) {
+	actions, err := pb.ActionsSorted(false)
+	if err != nil {
+		return "", err
+	}
+	var script strings.Builder
+	for _, action := range actions {
+		if action.Done {
+			continue
+		}
+		// The server numbers the actions itself
+		a := *action
+		a.ID = 0
+		script.WriteString(strings.TrimRight(a.HeroScript(), "\n") + "\n")
+	}
+
+	return c.sendInMode(ctx, "plan", script.String())
+}
+
+// Undo undoes the actions of a heroscript on the telnet server in reverse
+// order, and returns what was undone
+func (c *Client) Undo(ctx context.Context, script string) (string, error) {
+	return c.sendInMode(ctx, "undo", script)
+}
+
+// sendInMode sends a heroscript to the telnet server in the plan or undo
+// mode, and turns the mode off again
+func (c *Client) sendInMode(ctx context.Context, mode, script string) (string, error) {
+	if err := c.setMode(ctx, mode, true); err != nil {
+		return "", err
+	}
+	result, err := c.Send(ctx, script)
+	if c.conn != nil {
+		if modeErr := c.setMode(ctx, mode, false); err == nil {
+			err = modeErr
+		}
+	}
+	return result, err
+}
+
+// setMode turns the plan or undo mode of the connection on or off
+func (c *Client) setMode(ctx context.Context, mode string, on bool) error {
+	if err := c.Connect(ctx); err != nil {
+		return err
+	}
+	c.conn.SetDeadline(time.Now().Add(c.timeout()))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if _, err := c.conn.Write([]byte("!!" + mode + "\n")); err != nil {
+		return fmt.Errorf("failed to toggle %s mode: %v", mode, err)
+	}
+	response, err := c.reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to toggle %s mode: %v", mode, err)
+	}
+	if strings.Contains(response, "mode enabled") != on {
+		return fmt.Errorf("failed to toggle %s mode: %s", mode, strings.TrimSpace(response))
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package handlerfactory
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+)
+
+// newTestServer starts a telnet server with the secret "secret" for the
+// handlers of a test factory, on a Unix socket and on a TCP port, and
+// returns the targets of both
+func newTestServer(t *testing.T) (h *testHandler, unixTarget, tcpTarget string) {
+	t.Helper()
+	f, h := newTestFactory(t)
+	ts := NewTelnetServer(f, "secret")
+	socket := filepath.Join(t.TempDir(), "hero.sock")
+	if err := ts.Start(socket); err != nil {
+		t.Fatalf("Failed to start the server: %v", err)
+	}
+	if err := ts.StartTCP("127.0.0.1:0"); err != nil {
+		t.Fatalf("Failed to start the server on TCP: %v", err)
+	}
+	t.Cleanup(func() { ts.Stop() })
+	return h, "unix://" + socket, "tcp://" + ts.tcpListener.Addr().String()
+}
+
+// newConnectedClient returns a client of a target that is closed when the
+// test ends
+func newConnectedClient(t *testing.T, target string) *Client {
+	t.Helper()
+	client := NewClient(target, "secret")
+	if err := client.Connect(context.Background()); err != nil {
+		t.Fatalf("Failed to connect to %s: %v", target, err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestClientSend(t *testing.T) {
+	h, unixTarget, tcpTarget := newTestServer(t)
+	for _, target := range []string{unixTarget, tcpTarget, strings.TrimPrefix(unixTarget, "unix://")} {
+		client := newConnectedClient(t, target)
+		result, err := client.Send(context.Background(), "!!test.add name:'a'\n\n")
+		if err != nil || result != "added a" {
+			t.Errorf("%s: expected the result of the action, got %q, %v", target, result, err)
+		}
+	}
+	if got := h.actions(); !reflect.DeepEqual(got, []string{"add a", "add a", "add a"}) {
+		t.Errorf("Expected the action to run once per target, got %v", got)
+	}
+
+	// Errors of the server are returned as errors, and the connection can be
+	// used on
+	client := newConnectedClient(t, unixTarget)
+	if _, err := client.Send(context.Background(), "!!test.fail"); err == nil || !strings.Contains(err.Error(), "failed on purpose") {
+		t.Errorf("Expected the error of the action, got %v", err)
+	}
+	if _, err := client.Send(context.Background(), "!!unknown.add"); err == nil || !strings.Contains(err.Error(), "no handler registered for actor: unknown") {
+		t.Errorf("Expected the error of the unknown actor, got %v", err)
+	}
+	if result, err := client.Send(context.Background(), "!!test.add name:'b'"); err != nil || result != "added b" {
+		t.Errorf("Expected the connection to be usable after an error, got %q, %v", result, err)
+	}
+}
+
+func TestClientConnectErrors(t *testing.T) {
+	_, unixTarget, _ := newTestServer(t)
+	tests := []struct {
+		target string
+		secret string
+		error  string
+	}{
+		{unixTarget, "wrong", "authentication failed"},
+		{"unix://" + filepath.Join(t.TempDir(), "missing.sock"), "secret", "failed to connect to unix://"},
+		{"tcp://127.0.0.1:1", "secret", "failed to connect to tcp://127.0.0.1:1"},
+	}
+	for _, test := range tests {
+		client := NewClient(test.target, test.secret)
+		err := client.Connect(context.Background())
+		if err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("%s: expected an error with %q, got %v", test.target, test.error, err)
+		}
+		if _, sendErr := client.Send(context.Background(), "!!test.add name:'a'"); sendErr == nil {
+			t.Errorf("%s: expected Send to fail to connect too", test.target)
+		}
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	h, unixTarget, _ := newTestServer(t)
+	client := newConnectedClient(t, unixTarget)
+	client.Timeout = 100 * time.Millisecond
+
+	start := time.Now()
+	if _, err := client.Send(context.Background(), "!!test.wait"); err == nil {
+		t.Fatalf("Expected the action to time out")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the timeout to end the wait, took %v", elapsed)
+	}
+	// The connection is dropped as the rest of the result would be read as
+	// the next one, and the next script connects again
+	if client.conn != nil {
+		t.Errorf("Expected the connection to be closed")
+	}
+	close(h.release)
+	if result, err := client.Send(context.Background(), "!!test.add name:'a'"); err != nil || result != "added a" {
+		t.Errorf("Expected the client to connect again, got %q, %v", result, err)
+	}
+
+	// A canceled context ends a script, with the error of the context
+	h, unixTarget, _ = newTestServer(t)
+	defer close(h.release)
+	client = newConnectedClient(t, unixTarget)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if _, err := client.Send(ctx, "!!test.wait"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the script to be canceled, got %v", err)
+	}
+}
+
+func TestClientRun(t *testing.T) {
+	script := `!!test.add name:'a' as:'first'
+!!plain.add if:'first == nothing'
+!!test.add name:'b'
+!!test.fail
+!!test.add name:'c'`
+
+	tests := []struct {
+		options RunOptions
+		results []ActionResult
+		ran     []string
+	}{
+		{
+			RunOptions{},
+			[]ActionResult{
+				{ID: 1, Actor: "test", Name: "add", Undone: true},
+				{ID: 2, Actor: "plain", Name: "add", Skipped: true},
+				{ID: 3, Actor: "test", Name: "add", Undone: true},
+				{ID: 4, Actor: "test", Name: "fail", Error: "failed on purpose"},
+			},
+			[]string{"add a", "add b", "fail", "undo add b", "undo add a"},
+		},
+		{
+			RunOptions{NoRollback: true},
+			[]ActionResult{
+				{ID: 1, Actor: "test", Name: "add", Result: "added a"},
+				{ID: 2, Actor: "plain", Name: "add", Skipped: true},
+				{ID: 3, Actor: "test", Name: "add", Result: "added b"},
+				{ID: 4, Actor: "test", Name: "fail", Error: "failed on purpose"},
+			},
+			[]string{"add a", "add b", "fail"},
+		},
+	}
+	for _, test := range tests {
+		h, unixTarget, _ := newTestServer(t)
+		client := newConnectedClient(t, unixTarget)
+		pb, err := playbook.NewFromText(script)
+		if err != nil {
+			t.Fatalf("Failed to parse the playbook: %v", err)
+		}
+
+		results, err := client.Run(context.Background(), pb, test.options)
+		var rollbackErr *playbook.RollbackError
+		if err == nil || errors.As(err, &rollbackErr) == test.options.NoRollback {
+			t.Errorf("NoRollback %v: unexpected error %v", test.options.NoRollback, err)
+		}
+		if !reflect.DeepEqual(results, test.results) {
+			t.Errorf("NoRollback %v: expected %+v, got %+v", test.options.NoRollback, test.results, results)
+		}
+		if got := h.actions(); !reflect.DeepEqual(got, test.ran) {
+			t.Errorf("NoRollback %v: expected %v to run on the server, got %v", test.options.NoRollback, test.ran, got)
+		}
+	}
+}
+
+func TestClientActions(t *testing.T) {
+	_, unixTarget, _ := newTestServer(t)
+	client := newConnectedClient(t, unixTarget)
+	actions, err := client.Actions(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get the actions: %v", err)
+	}
+	var names []string
+	for _, action := range actions {
+		names = append(names, action.Actor+"."+action.Name)
+	}
+	if want := []string{"plain.add", "test.add", "test.fail", "test.wait"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Expected the actions %v, got %v", want, names)
+	}
+
+	// The connection is still in the run mode after the actions
+	if result, err := client.Send(context.Background(), "!!test.add name:'a'"); err != nil || result != "added a" {
+		t.Errorf("Expected the connection to run scripts, got %q, %v", result, err)
+	}
+}
+
+func TestClientUndo(t *testing.T) {
+	h, unixTarget, _ := newTestServer(t)
+	client := newConnectedClient(t, unixTarget)
+	result, err := client.Undo(context.Background(), "!!test.add name:'a'\n!!test.add name:'b'")
+	if err != nil || !strings.Contains(result, "removed b") || !strings.Contains(result, "removed a") {
+		t.Errorf("Expected the actions to be undone, got %q, %v", result, err)
+	}
+	if got := h.actions(); !reflect.DeepEqual(got, []string{"undo add b", "undo add a"}) {
+		t.Errorf("Expected the actions to be undone in reverse order, got %v", got)
+	}
+
+	// The undo mode is turned off again
+	if result, err := client.Send(context.Background(), "!!test.add name:'c'"); err != nil || result != "added c" {
+		t.Errorf("Expected the connection to run scripts, got %q, %v", result, err)
+	}
+}
+
+func TestTargetNetwork(t *testing.T) {
+	tests := []struct {
+		target, network, address string
+	}{
+		{"tcp://host:8024", "tcp", "host:8024"},
+		{"unix:///tmp/hero.sock", "unix", "/tmp/hero.sock"},
+		{"/tmp/hero.sock", "unix", "/tmp/hero.sock"},
+	}
+	for _, test := range tests {
+		if network, address := targetNetwork(test.target); network != test.network || address != test.address {
+			t.Errorf("%s: expected %s %s, got %s %s", test.target, test.network, test.address, network, address)
+		}
+	}
+}
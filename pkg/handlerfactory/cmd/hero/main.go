@@ -0,0 +1,208 @@
+// Command hero runs heroscript on the telnet server of a HandlerFactory,
+// like the one of the vmhandler example, on this or another host, or shows
+// what it would do without running it. hero repl is an interactive shell
+// that completes the actions of the server.
+//
+// Usage:
+//
+//	hero run [-target tcp://localhost:8024] [-secret secret] [-timeout 30s] [-json] [-no-rollback] script.hero
+//	hero plan [-target tcp://localhost:8024] [-secret secret] [-timeout 30s] script.hero
+//	hero repl [-target tcp://localhost:8024] [-secret secret] [-timeout 30s] [-no-color]
+//
+// The script is read from stdin if it is -. The secret can also be given
+// with the HERO_SECRET environment variable, which keeps it out of the
+// process list.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory/repl"
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+)
+
+func main() {
+	if len(os.Args) < 2 || (os.Args[1] != "run" && os.Args[1] != "plan" && os.Args[1] != "repl") {
+		fmt.Fprintln(os.Stderr, "Usage: hero run [-target tcp://host:port|socket] [-secret secret] [-timeout duration] [-json] [-no-rollback] <script.hero|->")
+		fmt.Fprintln(os.Stderr, "       hero plan [-target tcp://host:port|socket] [-secret secret] [-timeout duration] <script.hero|->")
+		fmt.Fprintln(os.Stderr, "       hero repl [-target tcp://host:port|socket] [-secret secret] [-timeout duration] [-no-color]")
+		os.Exit(2)
+	}
+	command := os.Args[1]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	target := flags.String("target", "tcp://localhost:8024", "telnet server as tcp://host:port or the path of a Unix socket")
+	secret := flags.String("secret", os.Getenv("HERO_SECRET"), "secret to authenticate with, HERO_SECRET by default")
+	timeout := flags.Duration("timeout", handlerfactory.DefaultClientTimeout, "how long an action may take")
+	var jsonOutput, noRollback, noColor *bool
+	if command == "run" {
+		jsonOutput = flags.Bool("json", false, "print the results of the actions as JSON")
+		noRollback = flags.Bool("no-rollback", false, "keep the actions that ran when a later one fails, instead of undoing them")
+	}
+	if command == "repl" {
+		noColor = flags.Bool("no-color", false, "print without colors")
+	}
+	flags.Parse(os.Args[2:])
+
+	if command == "repl" {
+		if err := shell(*target, *secret, *timeout, *noColor); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "Error: expected the heroscript file to %s, or - for stdin\n", command)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	if command == "plan" {
+		err = plan(ctx, flags.Arg(0), *target, *secret, *timeout)
+	} else {
+		options := handlerfactory.RunOptions{NoRollback: *noRollback}
+		err = run(ctx, flags.Arg(0), *target, *secret, *timeout, *jsonOutput, options)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the playbook in file on the telnet server at target and prints
+// the result of every action
+func run(ctx context.Context, file, target, secret string, timeout time.Duration, jsonOutput bool, options handlerfactory.RunOptions) error {
+	pb, err := loadPlayBook(file)
+	if err != nil {
+		return err
+	}
+
+	client := handlerfactory.NewClient(target, secret)
+	client.Timeout = timeout
+	defer client.Close()
+	if err := client.Connect(ctx); err != nil {
+		return err
+	}
+
+	results, err := client.Run(ctx, pb, options)
+	if jsonOutput {
+		out, jsonErr := json.MarshalIndent(results, "", "  ")
+		if jsonErr != nil {
+			return jsonErr
+		}
+		fmt.Println(string(out))
+		return err
+	}
+
+	for _, result := range results {
+		fmt.Printf("== %s.%s (%d)", result.Actor, result.Name, result.ID)
+		switch {
+		case result.Skipped:
+			fmt.Println(" skipped")
+		case result.Undone:
+			fmt.Println(" undone")
+		case result.Error != "":
+			fmt.Println(" failed")
+		default:
+			fmt.Println()
+			if result.Result != "" {
+				fmt.Println(result.Result)
+			}
+		}
+	}
+	return err
+}
+
+// plan prints what the playbook in file would do on the telnet server at
+// target, without running it
+func plan(ctx context.Context, file, target, secret string, timeout time.Duration) error {
+	pb, err := loadPlayBook(file)
+	if err != nil {
+		return err
+	}
+
+	client := handlerfactory.NewClient(target, secret)
+	client.Timeout = timeout
+	defer client.Close()
+
+	plan, err := client.Plan(ctx, pb)
+	if err != nil {
+		return err
+	}
+	fmt.Println(plan)
+	return nil
+}
+
+// shell runs an interactive heroscript shell on the telnet server at target,
+// which completes the actions of the server. Ctrl-C cancels the script that
+// runs.
+func shell(target, secret string, timeout time.Duration, noColor bool) error {
+	client := handlerfactory.NewClient(target, secret)
+	client.Timeout = timeout
+	defer client.Close()
+
+	interruptible := func(send func(ctx context.Context) (string, error)) (string, error) {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		return send(ctx)
+	}
+
+	var actions []handlerfactory.ActionInfo
+	_, err := interruptible(func(ctx context.Context) (string, error) {
+		var err error
+		actions, err = client.Actions(ctx)
+		return "", err
+	})
+	if err != nil {
+		return err
+	}
+
+	var historyFile string
+	if home, err := os.UserHomeDir(); err == nil {
+		historyFile = filepath.Join(home, ".hero_history")
+	}
+	return repl.New(repl.Config{
+		Run: func(script string) (string, error) {
+			return interruptible(func(ctx context.Context) (string, error) {
+				return client.Send(ctx, script)
+			})
+		},
+		Plan: func(script string) (string, error) {
+			pb, err := playbook.NewFromText(script)
+			if err != nil {
+				return "", err
+			}
+			return interruptible(func(ctx context.Context) (string, error) {
+				return client.Plan(ctx, pb)
+			})
+		},
+		Actions:     actions,
+		HistoryFile: historyFile,
+		NoColor:     noColor,
+	}).Run()
+}
+
+// loadPlayBook parses the playbook in file, or in stdin if file is -
+func loadPlayBook(file string) (*playbook.PlayBook, error) {
+	if file != "-" {
+		return playbook.NewFromFile(file, 10)
+	}
+	script, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read stdin: %w", err)
+	}
+	return playbook.NewFromText(string(script))
+}
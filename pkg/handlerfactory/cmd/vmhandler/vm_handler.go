@@ -5,6 +5,8 @@ import (
 	"strings"
 
 	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/paramsparser"
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
 )
 
 // VMHandler handles VM-related actions
@@ -40,6 +42,160 @@ func NewVMHandler() *VMHandler {
 	}
 }
 
+// vmName is the name param of the actions on a VM
+var vmName = handlerfactory.ParamSchema{Name: "name", Required: true, Description: "Name of the VM"}
+
+// ActionSchemas returns the schemas of the vm actions, which the handler
+// factory validates the actions against
+func (h *VMHandler) ActionSchemas() map[string]handlerfactory.ActionSchema {
+	return map[string]handlerfactory.ActionSchema{
+		"define": {
+			Description: "Define a new VM",
+			Params: []handlerfactory.ParamSchema{
+				vmName,
+				{Name: "cpu", Type: handlerfactory.ParamTypeInt, Default: "1", Description: "Number of CPUs"},
+				{Name: "memory", Default: "1GB", Description: "Memory size"},
+				{Name: "storage", Default: "10GB", Description: "Storage size"},
+				{Name: "description", Description: "Description of the VM"},
+			},
+		},
+		"start":  {Description: "Start a VM", Params: []handlerfactory.ParamSchema{vmName}},
+		"stop":   {Description: "Stop a VM", Params: []handlerfactory.ParamSchema{vmName}},
+		"status": {Description: "Show the status of a VM", Params: []handlerfactory.ParamSchema{vmName}},
+		"disk_add": {
+			Description: "Add a disk to a VM",
+			Params: []handlerfactory.ParamSchema{
+				vmName,
+				{Name: "size", Default: "10GB", Description: "Size of the disk"},
+				{Name: "type", Enum: []string{"SSD", "HDD"}, Default: "HDD", Description: "Type of the disk"},
+			},
+		},
+		"delete": {
+			Description: "Delete a VM",
+			Params: []handlerfactory.ParamSchema{
+				vmName,
+				{Name: "force", Type: handlerfactory.ParamTypeBool, Description: "Delete the VM even if it is running"},
+			},
+		},
+		"list": {Description: "List the VMs"},
+		"help": {Description: "Show the tutorial", AllowUnknown: true},
+	}
+}
+
+// PlanAction returns what a vm action would change, which makes the handler
+// a handlerfactory.Planner
+func (h *VMHandler) PlanAction(action *playbook.Action) (string, error) {
+	name := action.Params.Get("name")
+	vm, exists := h.vms[name]
+
+	switch action.Name {
+	case "define":
+		if exists {
+			return "", fmt.Errorf("VM '%s' already exists", name)
+		}
+		memory, err := sizeParam(action.Params, "memory", 1<<30)
+		if err != nil {
+			return "", err
+		}
+		storage, err := sizeParam(action.Params, "storage", 10<<30)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("+ vm %s: %d CPU, %s memory, %s storage", name,
+			action.Params.GetIntDefault("cpu", 1), memory, storage), nil
+	case "start":
+		if exists && vm.Running {
+			return "", nil
+		}
+		return fmt.Sprintf("~ vm %s: stopped -> running", name), nil
+	case "stop":
+		// VMs that are not defined yet are stopped
+		if !exists || !vm.Running {
+			return "", nil
+		}
+		return fmt.Sprintf("~ vm %s: running -> stopped", name), nil
+	case "disk_add":
+		size, err := sizeParam(action.Params, "size", 10<<30)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("+ vm %s: %s %s disk", name, size, action.Params.Get("type")), nil
+	case "delete":
+		if !exists {
+			return "", nil
+		}
+		if vm.Running && !action.Params.GetBool("force") {
+			return "", fmt.Errorf("VM '%s' is running. Use force:true to delete anyway", name)
+		}
+		return fmt.Sprintf("- vm %s", name), nil
+	}
+	// The other actions only read
+	return "", nil
+}
+
+// UndoAction undoes a vm action that ran, which makes the handler a
+// handlerfactory.Undoer, so the factory rolls back the VM actions of a
+// script when a later action fails
+func (h *VMHandler) UndoAction(action *playbook.Action) (string, error) {
+	name := action.Params.Get("name")
+	vm, exists := h.vms[name]
+
+	switch action.Name {
+	case "define":
+		if !exists {
+			return "", nil
+		}
+		delete(h.vms, name)
+		return fmt.Sprintf("VM '%s' deleted", name), nil
+	case "start":
+		if !exists || !vm.Running {
+			return "", nil
+		}
+		vm.Running = false
+		return fmt.Sprintf("VM '%s' stopped", name), nil
+	case "stop":
+		if !exists || vm.Running {
+			return "", nil
+		}
+		vm.Running = true
+		return fmt.Sprintf("VM '%s' started", name), nil
+	case "disk_add":
+		if !exists {
+			return "", nil
+		}
+		size, err := sizeParam(action.Params, "size", 10<<30)
+		if err != nil {
+			return "", err
+		}
+		// Remove the last disk that matches the action
+		for i := len(vm.Disks) - 1; i >= 0; i-- {
+			disk := vm.Disks[i]
+			if disk.Size == size && strings.EqualFold(disk.Type, action.Params.Get("type")) {
+				vm.Disks = append(vm.Disks[:i], vm.Disks[i+1:]...)
+				return fmt.Sprintf("Removed %s %s disk from VM '%s'", disk.Size, disk.Type, name), nil
+			}
+		}
+		return "", nil
+	case "delete":
+		return "", fmt.Errorf("VM '%s' was deleted and cannot be restored", name)
+	}
+	// The other actions only read
+	return "", nil
+}
+
+// sizeParam returns a size param formatted like 8GB, or defaultSize if it is
+// not given
+func sizeParam(params *paramsparser.ParamsParser, key string, defaultSize int64) (string, error) {
+	if !params.Has(key) {
+		return paramsparser.FormatSize(defaultSize), nil
+	}
+	size, err := params.GetSize(key)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s: %v", key, err)
+	}
+	return paramsparser.FormatSize(size), nil
+}
+
 // Define handles the vm.define action
 func (h *VMHandler) Define(script string) string {
 	params, err := h.ParseParams(script)
@@ -59,13 +215,13 @@ func (h *VMHandler) Define(script string) string {
 
 	// Create new VM
 	cpu := params.GetIntDefault("cpu", 1)
-	memory := params.Get("memory")
-	if memory == "" {
-		memory = "1GB"
+	memory, err := sizeParam(params, "memory", 1<<30)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
 	}
-	storage := params.Get("storage")
-	if storage == "" {
-		storage = "10GB"
+	storage, err := sizeParam(params, "storage", 10<<30)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
 	}
 	description := params.Get("description")
 
@@ -159,9 +315,9 @@ func (h *VMHandler) DiskAdd(script string) string {
 	}
 
 	// Add disk
-	size := params.Get("size")
-	if size == "" {
-		size = "10GB"
+	size, err := sizeParam(params, "size", 10<<30)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
 	}
 	diskType := params.Get("type")
 	if diskType == "" {
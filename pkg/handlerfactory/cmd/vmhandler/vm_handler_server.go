@@ -9,6 +9,9 @@ import (
 	"syscall"
 
 	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory/httpapi"
+	"github.com/freeflowuniverse/herolauncher/pkg/sshserver"
+	"github.com/gofiber/fiber/v2"
 )
 
 // The tutorial functions are defined in tutorial.go
@@ -29,6 +32,15 @@ func main() {
 		log.Fatalf("Failed to register VM handler: %v", err)
 	}
 
+	// Let actions run in the background with async:true
+	err = factory.EnableJobs(handlerfactory.JobConfig{})
+	if err != nil {
+		log.Fatalf("Failed to enable jobs: %v", err)
+	}
+
+	// Log every action that runs, with who ran it
+	factory.Use(handlerfactory.AuditLog(log.New(os.Stdout, "audit: ", log.LstdFlags)))
+
 	// Create a telnet server with the handler factory
 	server := handlerfactory.NewTelnetServer(factory, "1234")
 
@@ -39,6 +51,20 @@ func main() {
 		log.Fatalf("Failed to create socket directory: %v", err)
 	}
 
+	// Record the sessions of the telnet and SSH servers, which are queried
+	// with !!audit.query
+	auditPath := filepath.Join(socketDir, "vmhandler_audit.log")
+	auditStore, err := handlerfactory.NewFileAuditStore(auditPath)
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	defer auditStore.Close()
+	err = factory.EnableAudit(auditStore)
+	if err != nil {
+		log.Fatalf("Failed to enable audit log: %v", err)
+	}
+	fmt.Printf("Audit log of the sessions: %s\n", auditPath)
+
 	// Start the telnet server on a Unix socket
 	socketPath := filepath.Join(socketDir, "vmhandler.sock")
 	err = server.Start(socketPath)
@@ -56,6 +82,29 @@ func main() {
 	fmt.Println("Telnet server started on TCP: localhost:8024")
 	fmt.Println("Connect with: telnet localhost 8024")
 
+	// And over SSH, with the secret as password
+	err = server.StartSSH("localhost:8026", sshserver.Config{HostKeyFile: filepath.Join(socketDir, "vmhandler_host_key")})
+	if err != nil {
+		log.Fatalf("Failed to start SSH server: %v", err)
+	}
+	fmt.Println("SSH server started on: localhost:8026")
+	fmt.Println("Connect with: ssh -p 8026 admin@localhost")
+
+	// Serve the actions over HTTP too, with the same secret
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	api := httpapi.NewAPI(factory, httpapi.Config{
+		Info:   httpapi.Info{Title: "VM Handler", Version: "1.0.0", Description: "Manages virtual machines"},
+		Secret: "1234",
+	})
+	api.RegisterRoutes(app.Group("/api"))
+	go func() {
+		if err := app.Listen("localhost:8025"); err != nil {
+			log.Printf("HTTP API stopped: %v", err)
+		}
+	}()
+	fmt.Println("HTTP API started on: http://localhost:8025/api")
+	fmt.Println("OpenAPI document: http://localhost:8025/api/openapi.json")
+
 	// Print available commands
 	fmt.Println("\nVM Handler started. Type '!!vm.help' to see available commands.")
 	fmt.Println("Authentication secret: 1234")
@@ -72,4 +121,7 @@ func main() {
 		log.Fatalf("Failed to stop telnet server: %v", err)
 	}
 	fmt.Println("Telnet server stopped")
+	if err := app.Shutdown(); err != nil {
+		log.Fatalf("Failed to stop HTTP API: %v", err)
+	}
 }
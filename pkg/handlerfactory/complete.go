@@ -0,0 +1,166 @@
+package handlerfactory
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Completer completes heroscript with the actors, actions and params of the
+// schemas of actions, and the commands of a shell, like the REPL of hero or
+// the telnet server
+type Completer struct {
+	Actions []ActionInfo
+	// Commands are completed at the start of a script, like :help
+	Commands []string
+	// ActorCommands are the commands whose argument is an actor, which is
+	// completed after them, like !!help
+	ActorCommands []string
+}
+
+var (
+	// actionPattern matches the actions of a script, like !!vm.define
+	actionPattern = regexp.MustCompile(`!!([a-z0-9_]+)\.([a-z0-9_]+)`)
+	// paramPattern matches the params of a script, like name:
+	paramPattern = regexp.MustCompile(`(?:^|\s)([A-Za-z0-9_]+)\s*:`)
+)
+
+// Complete returns the completions of the text of a line up to the cursor,
+// and the byte offset in the text of the word that they replace. script are
+// the lines of the script that were entered before the line, each ending
+// with a newline. At the start of a script or a word with !!, actors,
+// actions and commands are completed; after an action, the params of its
+// schema that are not set yet and the values of params that are enums or
+// bools.
+func (c *Completer) Complete(script, text string) ([]string, int) {
+	start := strings.LastIndexAny(text, " \t") + 1
+	word := text[start:]
+	if script == "" && start > 0 {
+		if fields := strings.Fields(text[:start]); len(fields) == 1 && contains(c.ActorCommands, fields[0]) {
+			return matching(c.actors(), word), start
+		}
+	}
+	if strings.HasPrefix(word, "!") || (script == "" && strings.TrimSpace(text[:start]) == "") {
+		candidates := c.completeAction(word)
+		if script == "" && start == 0 && word != "" {
+			candidates = append(matching(c.Commands, word), candidates...)
+		}
+		return candidates, start
+	}
+
+	before := script + text[:start]
+	matches := actionPattern.FindAllStringSubmatch(before, -1)
+	if len(matches) == 0 {
+		return nil, start
+	}
+	last := matches[len(matches)-1]
+	action, ok := c.find(last[1], last[2])
+	if !ok {
+		return nil, start
+	}
+	// The params of the action are the ones after its name
+	set := make(map[string]bool)
+	for _, match := range paramPattern.FindAllStringSubmatch(before[strings.LastIndex(before, last[0]):], -1) {
+		set[match[1]] = true
+	}
+
+	if name, value, ok := strings.Cut(word, ":"); ok {
+		return completeValue(action.Schema, name, value), start
+	}
+	var candidates []string
+	for _, param := range action.Schema.Params {
+		if !set[param.Name] && strings.HasPrefix(param.Name, word) {
+			candidates = append(candidates, param.Name+":")
+		}
+	}
+	return candidates, start
+}
+
+// completeAction completes an actor or an action, written with or without
+// the !! prefix
+func (c *Completer) completeAction(word string) []string {
+	name := strings.TrimLeft(word, "!")
+	var candidates []string
+	seen := make(map[string]bool)
+	for _, action := range c.Actions {
+		if actor := action.Actor + "."; strings.HasPrefix(actor, name) && !strings.Contains(name, ".") {
+			if !seen[actor] {
+				seen[actor] = true
+				candidates = append(candidates, "!!"+actor)
+			}
+		} else if full := action.Actor + "." + action.Name; strings.HasPrefix(full, name) && strings.Contains(name, ".") {
+			candidates = append(candidates, "!!"+full+" ")
+		}
+	}
+	return candidates
+}
+
+// actors returns the actors of the actions
+func (c *Completer) actors() []string {
+	var actors []string
+	for _, action := range c.Actions {
+		if !contains(actors, action.Actor) {
+			actors = append(actors, action.Actor)
+		}
+	}
+	return actors
+}
+
+// find returns the action of an actor
+func (c *Completer) find(actor, name string) (ActionInfo, bool) {
+	for _, action := range c.Actions {
+		if action.Actor == actor && action.Name == name {
+			return action, true
+		}
+	}
+	return ActionInfo{}, false
+}
+
+// completeValue completes the value of a param, if its schema has an enum
+// or it is a bool. The value is quoted if it was started with a quote.
+func completeValue(schema ActionSchema, name, value string) []string {
+	var values []string
+	for _, param := range schema.Params {
+		if param.Name != name {
+			continue
+		}
+		values = param.Enum
+		if param.Type == ParamTypeBool {
+			values = []string{"true", "false"}
+		}
+	}
+
+	quote := ""
+	if strings.HasPrefix(value, "'") {
+		quote = "'"
+	}
+	var candidates []string
+	for _, v := range values {
+		if strings.HasPrefix(v, strings.TrimPrefix(value, quote)) {
+			candidates = append(candidates, name+":"+quote+v+quote+" ")
+		}
+	}
+	return candidates
+}
+
+// matching returns the values that start with a prefix, sorted
+func matching(values []string, prefix string) []string {
+	var result []string
+	for _, value := range values {
+		if strings.HasPrefix(value, prefix) {
+			result = append(result, value)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// contains reports whether values has a value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
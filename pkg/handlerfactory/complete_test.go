@@ -0,0 +1,177 @@
+package handlerfactory
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComplete(t *testing.T) {
+	completer := &Completer{
+		Actions: []ActionInfo{
+			{Actor: "disk", Name: "create", Schema: diskSchema, HasSchema: true},
+			{Actor: "disk", Name: "list"},
+			{Actor: "dns", Name: "add"},
+		},
+		Commands:      shellCommands,
+		ActorCommands: actorCommands,
+	}
+	tests := []struct {
+		script string
+		text   string
+		want   []string
+		start  int
+	}{
+		// Actors, actions and commands at the start of a script
+		{"", "", []string{"!!disk.", "!!dns."}, 0},
+		{"", "!!d", []string{"!!disk.", "!!dns."}, 0},
+		{"", "di", []string{"!!disk."}, 0},
+		{"", "!!h", []string{"!!help"}, 0},
+		{"", "!!disk.", []string{"!!disk.create ", "!!disk.list "}, 0},
+		{"", "!!disk.l", []string{"!!disk.list "}, 0},
+		{"", "!!help d", []string{"disk", "dns"}, 7},
+		{"", "!!help disk", []string{"disk"}, 7},
+		// Commands are not completed after a script was started
+		{"!!disk.create name:'a'\n", "!!h", nil, 0},
+		// The params that are not set yet
+		{"", "!!disk.create n", []string{"name:"}, 14},
+		{"", "!!disk.create name:'a' ", []string{"size:", "ratio:", "encrypted:", "type:", "mount:"}, 23},
+		{"!!disk.create name:'a' size:1\n", "  r", []string{"ratio:"}, 2},
+		{"!!disk.create name:'a'\n!!disk.create size:1\n", "  n", []string{"name:"}, 2},
+		// The values of enums and bools, quoted if the value is
+		{"", "!!disk.create type:", []string{"type:SSD ", "type:HDD "}, 14},
+		{"", "!!disk.create type:'H", []string{"type:'HDD' "}, 14},
+		{"", "!!disk.create encrypted:t", []string{"encrypted:true "}, 14},
+		{"", "!!disk.create name:", nil, 14},
+		// Actions without a schema, unknown actions and text without actions
+		{"", "!!disk.list a", nil, 12},
+		{"", "!!vm.create a", nil, 12},
+		{"!!disk.create name:'a'\n!!disk.list\n", "  n", nil, 2},
+		{"", "hello ", nil, 6},
+	}
+	for _, test := range tests {
+		got, start := completer.Complete(test.script, test.text)
+		if !reflect.DeepEqual(got, test.want) || start != test.start {
+			t.Errorf("%q after %q: expected %v at %d, got %v at %d", test.text, test.script, test.want, test.start, got, start)
+		}
+	}
+}
+
+func TestActorHelp(t *testing.T) {
+	f, _ := newTestFactory(t)
+	if err := f.RegisterHandler(&schemaHandler{BaseHandler: BaseHandler{ActorName: "disk"}}); err != nil {
+		t.Fatalf("Failed to register handler: %v", err)
+	}
+	ts := NewTelnetServer(f, "secret")
+
+	want := `Actions of disk:
+  !!disk.create - Create a disk
+      name*
+      size*:int
+      ratio:float
+      encrypted:bool
+      type:SSD|HDD=HDD
+      mount:/mnt/Data Disk
+  !!disk.list
+      The params are not known
+`
+	if got := ts.generateActorHelp("disk"); got != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+	if got, want := ts.generateActorHelp("dsik"), "Unknown actor dsik, did you mean disk?\nActors: disk, plain, test\n"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+// vmHandler is a handler with a schema for define
+type vmHandler struct {
+	BaseHandler
+	schema ActionSchema
+}
+
+// ActionSchemas implements SchemaProvider
+func (h *vmHandler) ActionSchemas() map[string]ActionSchema {
+	return map[string]ActionSchema{"define": h.schema}
+}
+
+// Define does nothing
+func (h *vmHandler) Define(script string) string {
+	return "defined"
+}
+
+func TestActorHelpDescriptions(t *testing.T) {
+	schema := ActionSchema{
+		AllowUnknown: true,
+		Params: []ParamSchema{
+			{Name: "name", Required: true, Description: "The name of the VM"},
+			{Name: "cpu", Type: ParamTypeInt, Default: "1", Description: "The number of CPUs"},
+		},
+	}
+	f := NewHandlerFactory()
+	f.RegisterHandler(&vmHandler{BaseHandler: BaseHandler{ActorName: "vm"}, schema: schema})
+	ts := NewTelnetServer(f, "secret")
+
+	// The descriptions of the params are aligned
+	want := `Actions of vm:
+  !!vm.define
+      name*      The name of the VM
+      cpu:int=1  The number of CPUs
+      Other params are accepted too
+`
+	if got := ts.generateActorHelp("vm"); got != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestTelnetCompletion(t *testing.T) {
+	f := NewHandlerFactory()
+	h := &schemaHandler{BaseHandler: BaseHandler{ActorName: "disk"}}
+	if err := f.RegisterHandler(h); err != nil {
+		t.Fatalf("Failed to register handler: %v", err)
+	}
+	ts := NewTelnetServer(f, "secret")
+	socket := filepath.Join(t.TempDir(), "hero.sock")
+	if err := ts.Start(socket); err != nil {
+		t.Fatalf("Failed to start the server: %v", err)
+	}
+	defer ts.Stop()
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	// readUntil reads the output up to a text
+	readUntil := func(text string) {
+		t.Helper()
+		var output strings.Builder
+		for !strings.Contains(output.String(), text) {
+			b, err := reader.ReadByte()
+			if err != nil {
+				t.Fatalf("Expected %q, got %q: %v", text, output.String(), err)
+			}
+			output.WriteByte(b)
+		}
+	}
+
+	conn.Write([]byte("!!core.auth secret:'secret'\n"))
+	readUntil("Authentication successful")
+	conn.Write([]byte("!!terminal\n"))
+	readUntil("Line editing enabled")
+
+	// Tab completes the actions and params, and the actors of !!help
+	conn.Write([]byte("!!disk.cr\tna\t'data' si\t10 ty\tS\t\r\r"))
+	readUntil("created data of type ssd")
+	conn.Write([]byte("!!help di\t\r"))
+	readUntil("Actions of disk:")
+	if !reflect.DeepEqual(h.created, []string{"data"}) {
+		t.Errorf("Expected the completed action to run once, got %v", h.created)
+	}
+}
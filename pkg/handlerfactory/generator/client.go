@@ -0,0 +1,309 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+)
+
+// Languages of the clients that GenerateClient generates
+const (
+	LanguageRust   = "rust"
+	LanguagePython = "python"
+)
+
+// rustKeywords are the names that are written as raw identifiers in Rust
+var rustKeywords = map[string]bool{
+	"as": true, "async": true, "await": true, "break": true, "const": true,
+	"continue": true, "dyn": true, "else": true, "enum": true,
+	"extern": true, "false": true, "fn": true, "for": true, "if": true,
+	"impl": true, "in": true, "let": true, "loop": true, "match": true,
+	"mod": true, "move": true, "mut": true, "pub": true, "ref": true,
+	"return": true, "static": true, "struct": true, "trait": true,
+	"true": true, "type": true, "unsafe": true, "use": true, "where": true,
+	"while": true, "abstract": true, "become": true, "box": true, "do": true,
+	"final": true, "macro": true, "override": true, "priv": true, "try": true,
+	"typeof": true, "unsized": true, "virtual": true, "yield": true,
+}
+
+// rustReserved are the names that cannot be raw identifiers in Rust, and
+// the names of the variables of the generated methods, which get an
+// underscore appended
+var rustReserved = map[string]bool{
+	"self": true, "super": true, "crate": true,
+	"script": true, "options": true, "params": true,
+}
+
+// pythonKeywords are the names that get an underscore appended in Python
+var pythonKeywords = map[string]bool{
+	"and": true, "as": true, "assert": true, "async": true, "await": true,
+	"break": true, "class": true, "continue": true, "def": true, "del": true,
+	"elif": true, "else": true, "except": true, "finally": true, "for": true,
+	"from": true, "global": true, "if": true, "import": true, "in": true,
+	"is": true, "lambda": true, "nonlocal": true, "not": true, "or": true,
+	"pass": true, "raise": true, "return": true, "try": true, "while": true,
+	"with": true, "yield": true, "self": true,
+}
+
+// clientMethods are the methods of the clients that are not actors
+var clientMethods = map[string]bool{
+	"connect": true, "connect_timeout": true, "run": true, "close": true,
+}
+
+// clientData is the data of the client templates
+type clientData struct {
+	Source string
+	Name   string
+	Actors []clientActor
+}
+
+// clientActor is the data of an actor in the client templates
+type clientActor struct {
+	Name string
+	// Type is the name of the type of the actor, like VMActor
+	Type string
+	// Rust and Python are the names of the accessor of the actor
+	Rust, Python string
+	Actions      []clientAction
+	Enums        []clientEnum
+}
+
+// clientAction is the data of an action in the client templates
+type clientAction struct {
+	Name        string
+	Description string
+	Rust        string
+	Python      string
+	// Options is the name of the Rust struct of the optional params
+	Options  string
+	Required []clientParam
+	Optional []clientParam
+	// Documented are the params with a description
+	Documented []clientParam
+	// Free is set for actions without a schema, which take any params
+	Free bool
+}
+
+// clientParam is the data of a param in the client templates
+type clientParam struct {
+	Name        string
+	Description string
+	Rust        string
+	// RustType is the type of the param, and RustArg the type of it as
+	// argument of a method
+	RustType, RustArg string
+	Python            string
+	PythonType        string
+}
+
+// clientEnum is an enum of the values of a param, which is a type in Rust
+type clientEnum struct {
+	Type   string
+	Action string
+	Param  string
+	Values []clientEnumValue
+}
+
+// clientEnumValue is a value of an enum with its Rust variant
+type clientEnumValue struct {
+	// Value is the value as a string literal
+	Value   string
+	Variant string
+}
+
+// ActionInfos returns the actions of the actor as a HandlerFactory lists them,
+// to generate clients for
+func (a *Actor) ActionInfos() []handlerfactory.ActionInfo {
+	actions := make([]handlerfactory.ActionInfo, len(a.Actions))
+	for i, action := range a.Actions {
+		actions[i] = handlerfactory.ActionInfo{
+			Actor:     a.Name,
+			Name:      action.Name,
+			Schema:    handlerfactory.ActionSchema{Description: action.Description, Params: action.Params},
+			HasSchema: true,
+		}
+	}
+	return actions
+}
+
+// GenerateClient returns the source of a client of actions in a language,
+// by file name: a crate with Cargo.toml and src/lib.rs for Rust, and a
+// module name.py for Python. The client has a type per actor with a typed
+// method per action, which sends the action as heroscript to the telnet
+// server of a HandlerFactory over TCP or a Unix socket. Actions without a
+// schema take any params. source is where the actions come from, which the
+// client mentions.
+func GenerateClient(language, name, source string, actions []handlerfactory.ActionInfo) (map[string][]byte, error) {
+	if !identifier.MatchString(name) {
+		return nil, fmt.Errorf("invalid client name: '%s'", name)
+	}
+	if len(actions) == 0 {
+		return nil, fmt.Errorf("no actions to generate a client for")
+	}
+	data := clientData{Source: source, Name: name}
+	actors := make(map[string]bool)
+	for _, action := range actions {
+		if !identifier.MatchString(action.Actor) || !identifier.MatchString(action.Name) {
+			return nil, fmt.Errorf("invalid action name: '%s.%s'", action.Actor, action.Name)
+		}
+		if len(data.Actors) == 0 || data.Actors[len(data.Actors)-1].Name != action.Actor {
+			if actors[action.Actor] {
+				return nil, fmt.Errorf("duplicate actor: %s", action.Actor)
+			}
+			if clientMethods[action.Actor] {
+				return nil, fmt.Errorf("actor %s has the name of a method of the client", action.Actor)
+			}
+			actors[action.Actor] = true
+			data.Actors = append(data.Actors, clientActor{
+				Name:   action.Actor,
+				Type:   goName(action.Actor) + "Actor",
+				Rust:   rustName(action.Actor),
+				Python: pythonName(action.Actor),
+			})
+		}
+		actor := &data.Actors[len(data.Actors)-1]
+		clientAction, err := newClientAction(actor, action)
+		if err != nil {
+			return nil, err
+		}
+		actor.Actions = append(actor.Actions, clientAction)
+	}
+
+	files := map[string]string{name + ".py": "client.py.tmpl"}
+	if language == LanguageRust {
+		files = map[string]string{"Cargo.toml": "cargo.toml.tmpl", "src/lib.rs": "client.rs.tmpl"}
+	} else if language != LanguagePython {
+		return nil, fmt.Errorf("unknown language: '%s', expected %s or %s", language, LanguageRust, LanguagePython)
+	}
+	sources := make(map[string][]byte)
+	for file, tmpl := range files {
+		var buf bytes.Buffer
+		if err := templates.ExecuteTemplate(&buf, tmpl, data); err != nil {
+			return nil, fmt.Errorf("failed to generate %s: %w", file, err)
+		}
+		sources[file] = buf.Bytes()
+	}
+	return sources, nil
+}
+
+// newClientAction returns the template data of an action of an actor, and
+// adds the enums of its params to the actor
+func newClientAction(actor *clientActor, action handlerfactory.ActionInfo) (clientAction, error) {
+	method := goName(action.Name)
+	data := clientAction{
+		Name:        action.Name,
+		Description: doc(action.Schema.Description),
+		Rust:        rustName(action.Name),
+		Python:      pythonName(action.Name),
+		Options:     goName(action.Actor) + method + "Options",
+		Free:        !action.HasSchema,
+	}
+	for _, param := range action.Schema.Params {
+		if !identifier.MatchString(param.Name) {
+			return clientAction{}, fmt.Errorf("invalid param name of action %s.%s: '%s'", action.Actor, action.Name, param.Name)
+		}
+		p := clientParam{
+			Name:        param.Name,
+			Description: doc(param.Description),
+			Rust:        rustName(param.Name),
+			Python:      pythonName(param.Name),
+		}
+		switch param.Type {
+		case handlerfactory.ParamTypeInt:
+			p.RustType, p.PythonType = "i64", "int"
+		case handlerfactory.ParamTypeFloat:
+			p.RustType, p.PythonType = "f64", "float"
+		case handlerfactory.ParamTypeBool:
+			p.RustType, p.PythonType = "bool", "bool"
+		default:
+			p.RustType, p.PythonType = "String", "str"
+		}
+		p.RustArg = p.RustType
+		if p.RustType == "String" {
+			p.RustArg = "&str"
+		}
+		if len(param.Enum) > 0 {
+			enum := clientEnum{Type: goName(action.Actor) + method + goName(param.Name), Action: action.Name, Param: param.Name}
+			quoted := make([]string, len(param.Enum))
+			variants := make(map[string]bool)
+			for i, value := range param.Enum {
+				variant := rustVariant(value)
+				if variants[variant] || strings.ContainsAny(value, "'\n") {
+					return clientAction{}, fmt.Errorf("invalid enum value of param %s of action %s.%s: '%s'", param.Name, action.Actor, action.Name, value)
+				}
+				variants[variant] = true
+				quoted[i] = fmt.Sprintf("%q", value)
+				enum.Values = append(enum.Values, clientEnumValue{Value: quoted[i], Variant: variant})
+			}
+			actor.Enums = append(actor.Enums, enum)
+			p.RustType, p.RustArg = enum.Type, enum.Type
+			p.PythonType = "Literal[" + strings.Join(quoted, ", ") + "]"
+		}
+
+		if p.Description != "" {
+			data.Documented = append(data.Documented, p)
+		}
+		if param.Required {
+			data.Required = append(data.Required, p)
+		} else {
+			data.Optional = append(data.Optional, p)
+		}
+	}
+	return data, nil
+}
+
+// doc returns a description as one line, which can be written in the
+// comments and docstrings of the clients
+func doc(description string) string {
+	return strings.ReplaceAll(strings.Join(strings.Fields(description), " "), `"""`, `'''`)
+}
+
+// rustName returns the Rust name of a heroscript name, which is a raw
+// identifier if it is a keyword
+func rustName(name string) string {
+	if rustReserved[name] {
+		return name + "_"
+	}
+	if rustKeywords[name] {
+		return "r#" + name
+	}
+	return name
+}
+
+// pythonName returns the Python name of a heroscript name, with an
+// underscore appended if it is a keyword
+func pythonName(name string) string {
+	if pythonKeywords[name] {
+		return name + "_"
+	}
+	return name
+}
+
+// rustVariant returns the name of the Rust enum variant of a value, like
+// OnFailure for on-failure
+func rustVariant(value string) string {
+	var out strings.Builder
+	upper := true
+	for _, r := range strings.ToLower(value) {
+		switch {
+		case r >= 'a' && r <= 'z':
+			if upper {
+				r -= 'a' - 'A'
+			}
+			out.WriteRune(r)
+			upper = false
+		case r >= '0' && r <= '9':
+			out.WriteRune(r)
+			upper = true
+		default:
+			upper = true
+		}
+	}
+	variant := out.String()
+	if variant == "" || variant[0] >= '0' && variant[0] <= '9' {
+		variant = "V" + variant
+	}
+	return variant
+}
@@ -0,0 +1,115 @@
+package generator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+)
+
+// clientActions are the actions of the vm actor in testdata and an action
+// without a schema, as a HandlerFactory lists them
+func clientActions(t *testing.T) []handlerfactory.ActionInfo {
+	return append(loadVMActor(t).ActionInfos(), handlerfactory.ActionInfo{Actor: "shell", Name: "exec"})
+}
+
+func TestGenerateClient(t *testing.T) {
+	tests := []struct {
+		language string
+		files    []string
+	}{
+		{LanguageRust, []string{"Cargo.toml", "src/lib.rs"}},
+		{LanguagePython, []string{"vmclient.py"}},
+	}
+	for _, test := range tests {
+		files, err := GenerateClient(test.language, "vmclient", "vm.hero", clientActions(t))
+		if err != nil {
+			t.Fatalf("%s: failed to generate: %v", test.language, err)
+		}
+		if len(files) != len(test.files) {
+			t.Errorf("%s: expected %v, got %d files", test.language, test.files, len(files))
+		}
+		for _, name := range test.files {
+			checkGolden(t, test.language+"/"+name, files[name])
+		}
+	}
+}
+
+func TestGenerateClientErrors(t *testing.T) {
+	actions := func(actor, name string, params ...handlerfactory.ParamSchema) []handlerfactory.ActionInfo {
+		return []handlerfactory.ActionInfo{{Actor: actor, Name: name, HasSchema: true,
+			Schema: handlerfactory.ActionSchema{Params: params}}}
+	}
+	tests := []struct {
+		language string
+		name     string
+		actions  []handlerfactory.ActionInfo
+		error    string
+	}{
+		{LanguageRust, "vm-client", actions("vm", "start"), "invalid client name: 'vm-client'"},
+		{LanguageRust, "vmclient", nil, "no actions"},
+		{"go", "vmclient", actions("vm", "start"), "unknown language: 'go'"},
+		{LanguagePython, "vmclient", actions("vm", "disk-add"), "invalid action name: 'vm.disk-add'"},
+		{LanguagePython, "vmclient", actions("run", "start"), "actor run has the name of a method of the client"},
+		{LanguagePython, "vmclient", append(append(actions("vm", "start"), actions("disk", "add")...), actions("vm", "stop")...),
+			"duplicate actor: vm"},
+		{LanguagePython, "vmclient", actions("vm", "start", handlerfactory.ParamSchema{Name: "Name"}),
+			"invalid param name of action vm.start: 'Name'"},
+		// Values that are the same variant in Rust, or cannot be quoted
+		{LanguageRust, "vmclient", actions("vm", "start", handlerfactory.ParamSchema{Name: "mode", Enum: []string{"on-failure", "on_failure"}}),
+			"invalid enum value of param mode of action vm.start: 'on_failure'"},
+		{LanguageRust, "vmclient", actions("vm", "start", handlerfactory.ParamSchema{Name: "mode", Enum: []string{"it's"}}),
+			"invalid enum value"},
+	}
+	for _, test := range tests {
+		if _, err := GenerateClient(test.language, test.name, "vm.hero", test.actions); err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("Expected an error with %q, got %v", test.error, err)
+		}
+	}
+}
+
+func TestClientNames(t *testing.T) {
+	tests := []struct {
+		name, rust, python string
+	}{
+		{"name", "name", "name"},
+		{"type", "r#type", "type"},
+		{"from", "from", "from_"},
+		{"async", "r#async", "async_"},
+		{"self", "self_", "self_"},
+		{"options", "options_", "options"},
+	}
+	for _, test := range tests {
+		if got := rustName(test.name); got != test.rust {
+			t.Errorf("%s: expected the Rust name %s, got %s", test.name, test.rust, got)
+		}
+		if got := pythonName(test.name); got != test.python {
+			t.Errorf("%s: expected the Python name %s, got %s", test.name, test.python, got)
+		}
+	}
+}
+
+func TestRustVariant(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"HDD", "Hdd"},
+		{"on-failure", "OnFailure"},
+		{"ubuntu 24.04", "Ubuntu2404"},
+		{"x86_64", "X8664"},
+		{"2fa", "V2Fa"},
+		{"", "V"},
+		{"---", "V"},
+	}
+	for _, test := range tests {
+		if got := rustVariant(test.value); got != test.want {
+			t.Errorf("%q: expected %s, got %s", test.value, test.want, got)
+		}
+	}
+}
+
+func TestDoc(t *testing.T) {
+	if got := doc("Define a new\n  VM with \"\"\"quotes\"\"\""); got != `Define a new VM with '''quotes'''` {
+		t.Errorf("Expected the description on one line, got %q", got)
+	}
+}
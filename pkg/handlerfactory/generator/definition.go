@@ -0,0 +1,218 @@
+// Package generator generates the skeleton of a handlerfactory handler from
+// the definition of an actor and its actions, with a method and a params
+// struct per action, the schemas of the actions and tests. It also generates
+// Rust and Python clients of the actions of actors.
+package generator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+	"gopkg.in/yaml.v3"
+)
+
+// Actor is the definition of an actor to generate a handler for
+type Actor struct {
+	Name string `yaml:"name"`
+	// Package is the Go package of the handler, the name of the actor with
+	// "handler" appended if it is empty
+	Package     string   `yaml:"package"`
+	Description string   `yaml:"description"`
+	Actions     []Action `yaml:"actions"`
+}
+
+// Action is the definition of an action of an actor
+type Action struct {
+	Name        string                       `yaml:"name"`
+	Description string                       `yaml:"description"`
+	Params      []handlerfactory.ParamSchema `yaml:"params"`
+}
+
+var (
+	// identifier matches the names of actors, actions and params, as they
+	// are normalized by the heroscript parser
+	identifier = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+	// packageName matches the names of Go packages
+	packageName = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+)
+
+// reservedMethods are the methods of handlers that are not actions
+var reservedMethods = map[string]bool{
+	"GetActorName":  true,
+	"Play":          true,
+	"ParseParams":   true,
+	"ActionSchemas": true,
+	"PlanAction":    true,
+	"UndoAction":    true,
+}
+
+// LoadActor reads the definition of an actor from a YAML file, if it has the
+// .yaml or .yml extension, or from a heroscript file
+func LoadActor(path string) (*Actor, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read actor definition: %w", err)
+		}
+		return ParseYAML(data)
+	default:
+		pb, err := playbook.NewFromFile(path, 10)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read actor definition: %w", err)
+		}
+		return actorFromPlayBook(pb)
+	}
+}
+
+// ParseYAML parses the definition of an actor from YAML:
+//
+//	name: vm
+//	description: Manages virtual machines
+//	actions:
+//	  - name: define
+//	    description: Define a new VM
+//	    params:
+//	      - name: name
+//	        required: true
+//	      - name: cpu
+//	        type: int
+//	        default: "1"
+func ParseYAML(data []byte) (*Actor, error) {
+	var actor Actor
+	if err := yaml.Unmarshal(data, &actor); err != nil {
+		return nil, fmt.Errorf("failed to parse actor definition: %w", err)
+	}
+	if err := actor.Validate(); err != nil {
+		return nil, err
+	}
+	return &actor, nil
+}
+
+// ParseHeroScript parses the definition of an actor from heroscript, where
+// the params follow their action or name it with action, and enums are
+// comma separated:
+//
+//	!!handler.actor name:'vm' description:'Manages virtual machines'
+//	!!handler.action name:'define' description:'Define a new VM'
+//	!!handler.param name:'name' required:true
+//	!!handler.param name:'cpu' type:'int' default:'1'
+//	!!handler.param action:'define' name:'disk' enum:'ssd,hdd'
+//	!!handler.param action:'define' name:'image' verbatim:true
+func ParseHeroScript(script string) (*Actor, error) {
+	pb, err := playbook.NewFromText(script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse actor definition: %w", err)
+	}
+	return actorFromPlayBook(pb)
+}
+
+// actorFromPlayBook returns the actor defined by the handler actions of a
+// playbook
+func actorFromPlayBook(pb *playbook.PlayBook) (*Actor, error) {
+	actor := &Actor{}
+	actions := make(map[string]int)
+	for _, action := range pb.Actions {
+		if action.Actor != "handler" {
+			continue
+		}
+		params := action.Params
+		switch action.Name {
+		case "actor":
+			actor.Name = params.Get("name")
+			actor.Package = params.Get("package")
+			actor.Description = params.Get("description")
+		case "action":
+			name := params.Get("name")
+			actions[name] = len(actor.Actions)
+			actor.Actions = append(actor.Actions, Action{
+				Name:        name,
+				Description: params.Get("description"),
+			})
+		case "param":
+			i := len(actor.Actions) - 1
+			if name := params.Get("action"); name != "" {
+				var ok bool
+				if i, ok = actions[name]; !ok {
+					return nil, fmt.Errorf("param %s of unknown action %s", params.Get("name"), name)
+				}
+			}
+			if i < 0 {
+				return nil, fmt.Errorf("param %s is not of an action", params.Get("name"))
+			}
+			param := handlerfactory.ParamSchema{
+				Name:        params.Get("name"),
+				Type:        handlerfactory.ParamType(params.Get("type")),
+				Required:    params.GetBool("required"),
+				Default:     params.GetVerbatim("default"),
+				Verbatim:    params.GetBool("verbatim"),
+				Description: params.Get("description"),
+			}
+			if enum := params.GetVerbatim("enum"); enum != "" {
+				for _, value := range strings.Split(enum, ",") {
+					param.Enum = append(param.Enum, strings.TrimSpace(value))
+				}
+			}
+			actor.Actions[i].Params = append(actor.Actions[i].Params, param)
+		default:
+			return nil, fmt.Errorf("unknown action handler.%s, expected handler.actor, handler.action or handler.param", action.Name)
+		}
+	}
+
+	if err := actor.Validate(); err != nil {
+		return nil, err
+	}
+	return actor, nil
+}
+
+// Validate checks that the names of the actor, its actions and their params
+// are valid and unique, and that the params have known types
+func (a *Actor) Validate() error {
+	if !identifier.MatchString(a.Name) {
+		return fmt.Errorf("invalid actor name: '%s'", a.Name)
+	}
+	if a.Package != "" && !packageName.MatchString(a.Package) {
+		return fmt.Errorf("invalid package name: '%s'", a.Package)
+	}
+	if len(a.Actions) == 0 {
+		return fmt.Errorf("actor %s has no actions", a.Name)
+	}
+
+	actions := make(map[string]bool)
+	for _, action := range a.Actions {
+		if !identifier.MatchString(action.Name) {
+			return fmt.Errorf("invalid action name: '%s'", action.Name)
+		}
+		if actions[action.Name] {
+			return fmt.Errorf("duplicate action: %s", action.Name)
+		}
+		if reservedMethods[methodName(action.Name)] {
+			return fmt.Errorf("action %s has the name of a method of the handler", action.Name)
+		}
+		actions[action.Name] = true
+
+		params := make(map[string]bool)
+		for _, param := range action.Params {
+			if !identifier.MatchString(param.Name) {
+				return fmt.Errorf("invalid param name of action %s: '%s'", action.Name, param.Name)
+			}
+			if params[param.Name] {
+				return fmt.Errorf("duplicate param of action %s: %s", action.Name, param.Name)
+			}
+			params[param.Name] = true
+
+			switch param.Type {
+			case "", handlerfactory.ParamTypeString, handlerfactory.ParamTypeInt,
+				handlerfactory.ParamTypeFloat, handlerfactory.ParamTypeBool:
+			default:
+				return fmt.Errorf("invalid type of param %s of action %s: '%s'", param.Name, action.Name, param.Type)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package generator
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"go/format"
+	"strings"
+	"text/template"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+)
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+var templates = template.Must(template.ParseFS(templateFiles, "templates/*.tmpl"))
+
+// initialisms are the parts of names that are written in upper case in the
+// names of types and fields, like VM in VMHandler
+var initialisms = map[string]bool{
+	"api": true, "cpu": true, "dns": true, "gpu": true, "http": true,
+	"id": true, "ip": true, "json": true, "ssh": true, "tcp": true,
+	"udp": true, "url": true, "uuid": true, "vm": true,
+}
+
+// handlerData is the data of the templates
+type handlerData struct {
+	Source      string
+	Package     string
+	Actor       string
+	Description string
+	Type        string
+	Actions     []actionData
+}
+
+// actionData is the data of an action in the templates
+type actionData struct {
+	Name        string
+	Description string
+	Method      string
+	Params      []paramData
+	// Script runs the action with the required params set
+	Script string
+}
+
+// paramData is the data of a param in the templates
+type paramData struct {
+	Name        string
+	Description string
+	Field       string
+	GoType      string
+	// Tag is the hero struct tag that params.Decode reads the param by
+	Tag string
+	// Schema is the handlerfactory.ParamSchema of the param as Go
+	Schema string
+}
+
+// Generate returns the Go source of the handler of an actor and its tests
+// by file name. source is the file the actor is defined in, which the
+// handler mentions.
+func Generate(actor *Actor, source string) (map[string][]byte, error) {
+	if err := actor.Validate(); err != nil {
+		return nil, err
+	}
+
+	data := handlerData{
+		Source:      source,
+		Package:     actor.Package,
+		Actor:       actor.Name,
+		Description: actor.Description,
+		Type:        goName(actor.Name) + "Handler",
+	}
+	if data.Package == "" {
+		data.Package = strings.ReplaceAll(actor.Name, "_", "") + "handler"
+	}
+	for _, action := range actor.Actions {
+		data.Actions = append(data.Actions, newActionData(actor.Name, action))
+	}
+
+	files := make(map[string][]byte)
+	for file, tmpl := range map[string]string{
+		actor.Name + "_handler.go":      "handler.tmpl",
+		actor.Name + "_handler_test.go": "handler_test.tmpl",
+	} {
+		var buf bytes.Buffer
+		if err := templates.ExecuteTemplate(&buf, tmpl, data); err != nil {
+			return nil, fmt.Errorf("failed to generate %s: %w", file, err)
+		}
+		src, err := format.Source(buf.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("failed to format %s: %w", file, err)
+		}
+		files[file] = src
+	}
+	return files, nil
+}
+
+// newActionData returns the template data of an action of an actor
+func newActionData(actor string, action Action) actionData {
+	data := actionData{
+		Name:        action.Name,
+		Description: action.Description,
+		Method:      methodName(action.Name),
+	}
+	script := []string{"!!" + actor + "." + action.Name}
+	for _, param := range action.Params {
+		data.Params = append(data.Params, newParamData(param))
+		if param.Required {
+			script = append(script, fmt.Sprintf("%s:'%s'", param.Name, exampleValue(param)))
+		}
+	}
+	data.Script = strings.Join(script, " ")
+	return data
+}
+
+// newParamData returns the template data of a param
+func newParamData(param handlerfactory.ParamSchema) paramData {
+	data := paramData{
+		Name:        param.Name,
+		Description: param.Description,
+		Field:       goName(param.Name),
+	}
+	switch param.Type {
+	case handlerfactory.ParamTypeInt:
+		data.GoType = "int"
+	case handlerfactory.ParamTypeFloat:
+		data.GoType = "float64"
+	case handlerfactory.ParamTypeBool:
+		data.GoType = "bool"
+	default:
+		data.GoType = "string"
+	}
+	tag := param.Name
+	if param.Required {
+		tag += ",required"
+	}
+	if param.Verbatim {
+		tag += ",verbatim"
+	}
+	data.Tag = fmt.Sprintf("`hero:%q`", tag)
+
+	fields := []string{fmt.Sprintf("Name: %q", param.Name)}
+	if typ := paramTypeConst(param.Type); typ != "" {
+		fields = append(fields, "Type: handlerfactory."+typ)
+	}
+	if param.Required {
+		fields = append(fields, "Required: true")
+	}
+	if len(param.Enum) > 0 {
+		enum := make([]string, len(param.Enum))
+		for i, value := range param.Enum {
+			enum[i] = fmt.Sprintf("%q", value)
+		}
+		fields = append(fields, "Enum: []string{"+strings.Join(enum, ", ")+"}")
+	}
+	if param.Default != "" {
+		fields = append(fields, fmt.Sprintf("Default: %q", param.Default))
+	}
+	if param.Verbatim {
+		fields = append(fields, "Verbatim: true")
+	}
+	if param.Description != "" {
+		fields = append(fields, fmt.Sprintf("Description: %q", param.Description))
+	}
+	data.Schema = "{" + strings.Join(fields, ", ") + "}"
+	return data
+}
+
+// paramTypeConst returns the name of the handlerfactory constant of a param
+// type, or "" for strings, which need none
+func paramTypeConst(paramType handlerfactory.ParamType) string {
+	switch paramType {
+	case handlerfactory.ParamTypeInt:
+		return "ParamTypeInt"
+	case handlerfactory.ParamTypeFloat:
+		return "ParamTypeFloat"
+	case handlerfactory.ParamTypeBool:
+		return "ParamTypeBool"
+	}
+	return ""
+}
+
+// exampleValue returns a valid value of a param for the generated tests
+func exampleValue(param handlerfactory.ParamSchema) string {
+	switch {
+	case len(param.Enum) > 0:
+		return param.Enum[0]
+	case param.Default != "":
+		return param.Default
+	case param.Type == handlerfactory.ParamTypeInt:
+		return "1"
+	case param.Type == handlerfactory.ParamTypeFloat:
+		return "1.5"
+	case param.Type == handlerfactory.ParamTypeBool:
+		return "true"
+	}
+	return "example"
+}
+
+// methodName returns the name of the method of an action, like DiskAdd for
+// disk_add, which is how the handlerfactory finds it
+func methodName(action string) string {
+	var out strings.Builder
+	for _, part := range strings.Split(action, "_") {
+		if part != "" {
+			out.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return out.String()
+}
+
+// goName returns the exported Go name of a heroscript name, like VMHandler
+// for vm_handler
+func goName(name string) string {
+	var out strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if part == "" {
+			continue
+		}
+		if initialisms[part] {
+			out.WriteString(strings.ToUpper(part))
+		} else {
+			out.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,187 @@
+package generator
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// checkGolden compares generated source with the golden file of name in
+// testdata, or updates the golden file with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatalf("Failed to update %s: %v", path, err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from %s, run go test -update if the change is intended:\n%s", name, path, got)
+	}
+}
+
+// loadVMActor loads the definition of the vm actor in testdata
+func loadVMActor(t *testing.T) *Actor {
+	t.Helper()
+	actor, err := LoadActor(filepath.Join("testdata", "vm.hero"))
+	if err != nil {
+		t.Fatalf("Failed to load the actor: %v", err)
+	}
+	return actor
+}
+
+func TestGenerate(t *testing.T) {
+	files, err := Generate(loadVMActor(t), "vm.hero")
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+	if len(files) != 2 {
+		t.Errorf("Expected the handler and its tests, got %d files", len(files))
+	}
+	for _, name := range []string{"vm_handler.go", "vm_handler_test.go"} {
+		checkGolden(t, name, files[name])
+	}
+}
+
+func TestParseDefinitions(t *testing.T) {
+	yaml := `
+name: vm
+package: vmhandler
+description: Manages virtual machines
+actions:
+  - name: define
+    description: Define a new VM
+    params:
+      - name: name
+        required: true
+      - name: cpu
+        type: int
+        default: "1"
+  - name: disk_add
+    params:
+      - name: type
+        enum: [SSD, HDD]
+        default: HDD
+      - name: image
+        verbatim: true
+`
+	script := `!!handler.actor name:'vm' package:'vmhandler' description:'Manages virtual machines'
+!!handler.action name:'define' description:'Define a new VM'
+!!handler.action name:'disk_add'
+!!handler.param name:'type' enum:'SSD, HDD' default:'HDD'
+!!handler.param action:'define' name:'name' required:true
+!!handler.param action:'define' name:'cpu' type:'int' default:'1'
+!!handler.param action:'disk_add' name:'image' verbatim:true`
+	want := &Actor{
+		Name:        "vm",
+		Package:     "vmhandler",
+		Description: "Manages virtual machines",
+		Actions: []Action{
+			{Name: "define", Description: "Define a new VM", Params: []handlerfactory.ParamSchema{
+				{Name: "name", Required: true},
+				{Name: "cpu", Type: handlerfactory.ParamTypeInt, Default: "1"},
+			}},
+			{Name: "disk_add", Params: []handlerfactory.ParamSchema{
+				{Name: "type", Enum: []string{"SSD", "HDD"}, Default: "HDD"},
+				{Name: "image", Verbatim: true},
+			}},
+		},
+	}
+
+	fromYAML, err := ParseYAML([]byte(yaml))
+	if err != nil || !reflect.DeepEqual(fromYAML, want) {
+		t.Errorf("Expected %+v from YAML, got %+v, %v", want, fromYAML, err)
+	}
+	fromScript, err := ParseHeroScript(script)
+	if err != nil || !reflect.DeepEqual(fromScript, want) {
+		t.Errorf("Expected %+v from heroscript, got %+v, %v", want, fromScript, err)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "vm.yaml"), []byte(yaml), 0644)
+	if actor, err := LoadActor(filepath.Join(dir, "vm.yaml")); err != nil || !reflect.DeepEqual(actor, want) {
+		t.Errorf("Expected %+v from the YAML file, got %+v, %v", want, actor, err)
+	}
+}
+
+func TestParseHeroScriptErrors(t *testing.T) {
+	tests := []struct {
+		script string
+		error  string
+	}{
+		{"!!handler.param name:'cpu'", "param cpu is not of an action"},
+		{"!!handler.actor name:'vm'\n!!handler.action name:'define'\n!!handler.param action:'start' name:'cpu'", "param cpu of unknown action start"},
+		{"!!handler.actor name:'vm'\n!!handler.method name:'define'", "unknown action handler.method"},
+		{"!!handler.actor name:'vm'", "actor vm has no actions"},
+	}
+	for _, test := range tests {
+		if _, err := ParseHeroScript(test.script); err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("%s: expected an error with %q, got %v", test.script, test.error, err)
+		}
+	}
+}
+
+func TestValidateActor(t *testing.T) {
+	action := func(name string, params ...handlerfactory.ParamSchema) Action {
+		return Action{Name: name, Params: params}
+	}
+	tests := []struct {
+		actor Actor
+		error string
+	}{
+		{Actor{Name: "vm", Actions: []Action{action("define")}}, ""},
+		{Actor{Name: "VM", Actions: []Action{action("define")}}, "invalid actor name: 'VM'"},
+		{Actor{Name: "vm", Package: "vm_handler", Actions: []Action{action("define")}}, "invalid package name"},
+		{Actor{Name: "vm"}, "has no actions"},
+		{Actor{Name: "vm", Actions: []Action{action("disk-add")}}, "invalid action name: 'disk-add'"},
+		{Actor{Name: "vm", Actions: []Action{action("define"), action("define")}}, "duplicate action: define"},
+		{Actor{Name: "vm", Actions: []Action{action("play")}}, "action play has the name of a method of the handler"},
+		{Actor{Name: "vm", Actions: []Action{action("define", handlerfactory.ParamSchema{Name: "2cpu"})}}, "invalid param name of action define: '2cpu'"},
+		{Actor{Name: "vm", Actions: []Action{action("define", handlerfactory.ParamSchema{Name: "cpu"}, handlerfactory.ParamSchema{Name: "cpu"})}},
+			"duplicate param of action define: cpu"},
+		{Actor{Name: "vm", Actions: []Action{action("define", handlerfactory.ParamSchema{Name: "cpu", Type: "number"})}},
+			"invalid type of param cpu of action define: 'number'"},
+	}
+	for _, test := range tests {
+		err := test.actor.Validate()
+		if test.error == "" && err != nil {
+			t.Errorf("%+v: expected the actor to be valid, got %v", test.actor, err)
+		} else if test.error != "" && (err == nil || !strings.Contains(err.Error(), test.error)) {
+			t.Errorf("%+v: expected an error with %q, got %v", test.actor, test.error, err)
+		}
+	}
+}
+
+func TestGoNames(t *testing.T) {
+	tests := []struct {
+		name, goName, method string
+	}{
+		{"vm", "VM", "Vm"},
+		{"disk_add", "DiskAdd", "DiskAdd"},
+		{"vm_ip_list", "VMIPList", "VmIpList"},
+		{"get__id", "GetID", "GetId"},
+	}
+	for _, test := range tests {
+		if got := goName(test.name); got != test.goName {
+			t.Errorf("%s: expected the Go name %s, got %s", test.name, test.goName, got)
+		}
+		if got := methodName(test.name); got != test.method {
+			t.Errorf("%s: expected the method %s, got %s", test.name, test.method, got)
+		}
+	}
+}
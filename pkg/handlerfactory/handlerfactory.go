@@ -1,6 +1,7 @@
 package handlerfactory
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
@@ -97,6 +98,12 @@ func (h *BaseHandler) ParseParams(script string) (*paramsparser.ParamsParser, er
 // HandlerFactory manages a collection of handlers
 type HandlerFactory struct {
 	handlers map[string]Handler
+	// jobs runs actions in the background, if they are enabled
+	jobs *jobRunner
+	// middleware wraps the calls of handlers, see Use
+	middleware []Middleware
+	// audit records the sessions of the telnet servers, if it is enabled
+	audit AuditStore
 }
 
 // NewHandlerFactory creates a new handler factory
@@ -130,8 +137,14 @@ func (f *HandlerFactory) GetHandler(actorName string) (Handler, error) {
 	return handler, nil
 }
 
-// ProcessHeroscript processes a heroscript command
+// ProcessHeroscript processes a heroscript command, and rolls back the
+// actions that ran when one fails
 func (f *HandlerFactory) ProcessHeroscript(script string) (string, error) {
+	return f.Run(script, RunOptions{})
+}
+
+// Run processes a heroscript command with options
+func (f *HandlerFactory) Run(script string, options RunOptions) (string, error) {
 	pb, err := playbook.NewFromText(script)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse heroscript: %v", err)
@@ -141,40 +154,49 @@ func (f *HandlerFactory) ProcessHeroscript(script string) (string, error) {
 		return "", fmt.Errorf("no actions found in script")
 	}
 
-	// Group actions by actor
-	actorActions := make(map[string][]*playbook.Action)
-	for _, action := range pb.Actions {
-		actorActions[action.Actor] = append(actorActions[action.Actor], action)
-	}
-
 	var results []string
 
-	// Process actions for each actor
-	for actorName, actions := range actorActions {
-		handler, err := f.GetHandler(actorName)
+	// Process the actions in order, each by the handler of its actor, with
+	// their conditions and loops
+	err = pb.ExecuteWithRollback(func(action *playbook.Action) (string, playbook.UndoFunc, error) {
+		handler, err := f.GetHandler(action.Actor)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
-
-		// Create a playbook with just this actor's actions
-		actorPB := playbook.New()
-		for _, action := range actions {
-			actorAction := actorPB.NewAction(action.CID, action.Name, action.Actor, action.Priority, action.ActionType)
-			actorAction.Params = action.Params
+		async := action.Params.GetBool(ParamAsync)
+		action.Params.Delete(ParamAsync)
+		if err := ValidateAction(handler, action); err != nil {
+			return "", nil, err
 		}
 
-		// Process the actions
-		result, err := handler.Play(actorPB.HeroScript(true), handler)
+		call := &Call{Caller: options.Caller, Handler: handler, Action: action, Async: async}
+		result, err := f.call(call, f.runCall)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
 
 		results = append(results, result)
+		return result, f.undoFunc(call), nil
+	}, !options.NoRollback)
+	if err != nil {
+		return "", err
 	}
 
 	return strings.Join(results, "\n"), nil
 }
 
+// runCall runs the action of a call, or submits it as job if it is async
+func (f *HandlerFactory) runCall(call *Call) (string, error) {
+	if call.Async {
+		return f.submitJob(call.Handler, call.Action)
+	}
+	result, err := call.Handler.Play(call.Action.HeroScript(), call.Handler)
+	if err == nil {
+		err = resultError(result)
+	}
+	return result, err
+}
+
 // GetSupportedActions returns a map of supported actions for each registered actor
 func (f *HandlerFactory) GetSupportedActions() map[string][]string {
 	result := make(map[string][]string)
@@ -188,7 +210,7 @@ func (f *HandlerFactory) GetSupportedActions() map[string][]string {
 			method := handlerType.Method(i)
 			
 			// Skip methods from BaseHandler and other non-action methods
-			if method.Name == "GetActorName" || method.Name == "Play" || method.Name == "ParseParams" {
+			if method.Name == "GetActorName" || method.Name == "Play" || method.Name == "ParseParams" || method.Name == "ActionSchemas" || method.Name == "PlanAction" || method.Name == "UndoAction" {
 				continue
 			}
 			
@@ -203,6 +225,16 @@ func (f *HandlerFactory) GetSupportedActions() map[string][]string {
 	return result
 }
 
+// resultError returns the error of a result of a handler, which reports
+// errors as results that start with Error, or nil
+func resultError(result string) error {
+	message, ok := strings.CutPrefix(result, "Error")
+	if !ok {
+		return nil
+	}
+	return errors.New(strings.TrimSpace(strings.TrimPrefix(message, ":")))
+}
+
 // Helper functions for name conversion
 
 // convertToMethodName converts an action name to a method name
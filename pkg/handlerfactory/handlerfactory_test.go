@@ -0,0 +1,92 @@
+package handlerfactory
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+)
+
+// testHandler is a handler whose actions record that they ran, and which
+// can undo add
+type testHandler struct {
+	BaseHandler
+	mu  sync.Mutex
+	ran []string
+	// release lets the wait actions finish when it is closed
+	release chan struct{}
+}
+
+// newTestHandler creates a test handler of an actor
+func newTestHandler(actor string) *testHandler {
+	return &testHandler{BaseHandler: BaseHandler{ActorName: actor}, release: make(chan struct{})}
+}
+
+// record records that an action ran
+func (h *testHandler) record(format string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ran = append(h.ran, format)
+}
+
+// actions returns the actions that ran
+func (h *testHandler) actions() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.ran...)
+}
+
+// Add adds a named thing
+func (h *testHandler) Add(script string) string {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	h.record("add " + params.Get("name"))
+	return "added " + params.Get("name")
+}
+
+// Fail fails
+func (h *testHandler) Fail(script string) string {
+	h.record("fail")
+	return "Error: failed on purpose"
+}
+
+// Wait waits until the handler is released
+func (h *testHandler) Wait(script string) string {
+	h.record("wait")
+	<-h.release
+	return "waited"
+}
+
+// UndoAction implements Undoer, for add
+func (h *testHandler) UndoAction(action *playbook.Action) (string, error) {
+	name := action.Params.Get("name")
+	h.record("undo add " + name)
+	return "removed " + name, nil
+}
+
+// plainHandler is a handler that cannot undo its actions
+type plainHandler struct {
+	BaseHandler
+}
+
+// Add does nothing
+func (h *plainHandler) Add(script string) string {
+	return "added"
+}
+
+// newTestFactory creates a factory with a test handler of the test actor and
+// a plain handler of the plain actor
+func newTestFactory(t *testing.T) (*HandlerFactory, *testHandler) {
+	t.Helper()
+	f := NewHandlerFactory()
+	h := newTestHandler("test")
+	if err := f.RegisterHandler(h); err != nil {
+		t.Fatalf("Failed to register handler: %v", err)
+	}
+	if err := f.RegisterHandler(&plainHandler{BaseHandler: BaseHandler{ActorName: "plain"}}); err != nil {
+		t.Fatalf("Failed to register handler: %v", err)
+	}
+	return f, h
+}
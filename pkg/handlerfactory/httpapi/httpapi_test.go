@@ -0,0 +1,467 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+	"github.com/gofiber/fiber/v2"
+)
+
+// diskHandler is a handler with schemas for create and fail, and none for
+// list
+type diskHandler struct {
+	handlerfactory.BaseHandler
+	created []string
+}
+
+// ActionSchemas implements handlerfactory.SchemaProvider
+func (h *diskHandler) ActionSchemas() map[string]handlerfactory.ActionSchema {
+	return map[string]handlerfactory.ActionSchema{
+		"create": {
+			Description: "Create a disk",
+			Params: []handlerfactory.ParamSchema{
+				{Name: "name", Required: true},
+				{Name: "size", Type: handlerfactory.ParamTypeInt, Required: true, Description: "The size in GB"},
+				{Name: "ratio", Type: handlerfactory.ParamTypeFloat, Default: "0.5"},
+				{Name: "encrypted", Type: handlerfactory.ParamTypeBool, Default: "false"},
+				{Name: "type", Enum: []string{"SSD", "HDD"}, Default: "HDD"},
+			},
+		},
+		"fail": {Description: "Fail to do anything"},
+	}
+}
+
+// Create creates a disk
+func (h *diskHandler) Create(script string) string {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	h.created = append(h.created, params.Get("name"))
+	return "created " + params.Get("name") + " of " + params.Get("size") + "GB"
+}
+
+// Fail fails
+func (h *diskHandler) Fail(script string) string {
+	return "Error: the disk is broken"
+}
+
+// List lists the disks
+func (h *diskHandler) List(script string) string {
+	return strings.Join(h.created, ",")
+}
+
+// newTestApp creates an app with the API of a factory with the disk handler
+// at /api
+func newTestApp(t *testing.T, config Config) (*fiber.App, *handlerfactory.HandlerFactory, *diskHandler) {
+	t.Helper()
+	f := handlerfactory.NewHandlerFactory()
+	h := &diskHandler{BaseHandler: handlerfactory.BaseHandler{ActorName: "disk"}}
+	if err := f.RegisterHandler(h); err != nil {
+		t.Fatalf("Failed to register handler: %v", err)
+	}
+	app := fiber.New()
+	NewAPI(f, config).RegisterRoutes(app.Group("/api"))
+	return app, f, h
+}
+
+// request sends a request with a body and an optional bearer token to app,
+// and decodes the JSON response into out, if given
+func request(t *testing.T, app *fiber.App, method, path, token, body string, out interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}
+	resp, err := app.Test(req, 5000)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode != fiber.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("Failed to decode the response of %s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// exampleValue returns a value of the type of a schema
+func exampleValue(schema *Schema) interface{} {
+	if len(schema.Enum) > 0 {
+		return schema.Enum[len(schema.Enum)-1]
+	}
+	switch schema.Type {
+	case "integer":
+		return 10
+	case "number":
+		return 1.5
+	case "boolean":
+		return true
+	default:
+		return "example"
+	}
+}
+
+// testRPCResponse is a JSON-RPC response with the invalid params as data
+type testRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  string          `json:"result"`
+	Error   *struct {
+		Code    int                         `json:"code"`
+		Message string                      `json:"message"`
+		Data    []handlerfactory.ParamError `json:"data"`
+	} `json:"error"`
+}
+
+func TestOpenAPIRoundTrip(t *testing.T) {
+	app, f, h := newTestApp(t, Config{Info: Info{Title: "Disks"}})
+
+	var doc OpenAPIDocument
+	if status := request(t, app, "GET", "/api/openapi.json", "", "", &doc); status != fiber.StatusOK {
+		t.Fatalf("Expected the document, got status %d", status)
+	}
+	if doc.OpenAPI != "3.0.3" || doc.Info.Title != "Disks" || doc.Info.Version != "1.0.0" {
+		t.Errorf("Expected an OpenAPI 3 document of the disks API, got %s %+v", doc.OpenAPI, doc.Info)
+	}
+	if len(doc.Servers) != 1 || doc.Servers[0].URL != "http://example.com/api" {
+		t.Fatalf("Expected the server at /api, got %+v", doc.Servers)
+	}
+
+	// The document is the one of the factory, with its server
+	want := OpenAPI(f, Info{Title: "Disks", Version: "1.0.0"})
+	want.Servers = doc.Servers
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(doc)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("Expected the document to survive JSON:\n%s\ngot:\n%s", wantJSON, gotJSON)
+	}
+
+	if len(doc.Paths) != 3 {
+		t.Errorf("Expected a path per action, got %d paths", len(doc.Paths))
+	}
+	create := doc.Paths["/disk/create"].Post
+	schema := create.RequestBody.Content["application/json"].Schema
+	if create.OperationID != "disk_create" || create.Summary != "Create a disk" || !create.RequestBody.Required {
+		t.Errorf("Unexpected operation of disk.create: %+v", create)
+	}
+	if !reflect.DeepEqual(schema.Required, []string{"name", "size"}) || *schema.AdditionalProperties {
+		t.Errorf("Expected name and size to be required, and no other params, got %v, %v", schema.Required, *schema.AdditionalProperties)
+	}
+	types := map[string]string{"name": "string", "size": "integer", "ratio": "number", "encrypted": "boolean", "type": "string"}
+	for name, jsonType := range types {
+		if property := schema.Properties[name]; property == nil || property.Type != jsonType {
+			t.Errorf("Expected %s to be of type %s, got %+v", name, jsonType, property)
+		}
+	}
+	if _, ok := schema.Properties["async"]; ok {
+		t.Errorf("Expected no async param without jobs")
+	}
+	// Defaults are JSON values of the type of their param
+	if schema.Properties["ratio"].Default != 0.5 || schema.Properties["encrypted"].Default != false || schema.Properties["type"].Default != "HDD" {
+		t.Errorf("Expected typed defaults, got %v, %v, %v", schema.Properties["ratio"].Default,
+			schema.Properties["encrypted"].Default, schema.Properties["type"].Default)
+	}
+	if list := doc.Paths["/disk/list"].Post.RequestBody; list.Required || !*list.Content["application/json"].Schema.AdditionalProperties {
+		t.Errorf("Expected the params of disk.list to be optional and free, got %+v", list)
+	}
+
+	// Every operation of the document is served at its server, and responds
+	// with one of the responses of the document
+	server, _ := url.Parse(doc.Servers[0].URL)
+	for path, item := range doc.Paths {
+		params := make(map[string]interface{})
+		for name, property := range item.Post.RequestBody.Content["application/json"].Schema.Properties {
+			params[name] = exampleValue(property)
+		}
+		body, _ := json.Marshal(params)
+		var resp struct {
+			Result *string `json:"result"`
+			Error  string  `json:"error"`
+		}
+		status := request(t, app, "POST", server.Path+path, "", string(body), &resp)
+		if _, ok := item.Post.Responses[strconv.Itoa(status)]; !ok {
+			t.Errorf("%s: status %d is not in the document", path, status)
+		}
+		if (status == fiber.StatusOK) != (resp.Result != nil) || (status != fiber.StatusOK) != (resp.Error != "") {
+			t.Errorf("%s: expected a result or an error, got %d %+v", path, status, resp)
+		}
+	}
+	if !reflect.DeepEqual(h.created, []string{"example"}) {
+		t.Errorf("Expected the disk of the example to be created, got %v", h.created)
+	}
+}
+
+func TestOpenRPCRoundTrip(t *testing.T) {
+	app, f, h := newTestApp(t, Config{})
+
+	var doc OpenRPCDocument
+	if status := request(t, app, "GET", "/api/openrpc.json", "", "", &doc); status != fiber.StatusOK {
+		t.Fatalf("Expected the document, got status %d", status)
+	}
+	if len(doc.Servers) != 1 || doc.Servers[0].URL != "http://example.com/api/rpc" {
+		t.Fatalf("Expected the server at /api/rpc, got %+v", doc.Servers)
+	}
+	want := OpenRPC(f, Info{Title: "Heroscript API", Version: "1.0.0"})
+	want.Servers = doc.Servers
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(doc)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("Expected the document to survive JSON:\n%s\ngot:\n%s", wantJSON, gotJSON)
+	}
+
+	methods := make(map[string]Method)
+	for _, method := range doc.Methods {
+		methods[method.Name] = method
+	}
+	create, ok := methods["disk.create"]
+	if !ok || len(methods) != 3 || create.ParamStructure != "by-name" {
+		t.Fatalf("Expected a method per action with params by name, got %+v", doc.Methods)
+	}
+	var names []string
+	for _, param := range create.Params {
+		names = append(names, param.Name)
+		if param.Required != (param.Name == "name" || param.Name == "size") {
+			t.Errorf("%s: unexpected required %v", param.Name, param.Required)
+		}
+	}
+	if !reflect.DeepEqual(names, []string{"encrypted", "name", "ratio", "size", "type"}) {
+		t.Errorf("Expected the params sorted by name, got %v", names)
+	}
+
+	// Every method of the document can be called at its server
+	server, _ := url.Parse(doc.Servers[0].URL)
+	for i, method := range doc.Methods {
+		params := make(map[string]interface{})
+		for _, param := range method.Params {
+			params[param.Name] = exampleValue(param.Schema)
+		}
+		body, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": i, "method": method.Name, "params": params})
+		var resp testRPCResponse
+		if status := request(t, app, "POST", server.Path, "", string(body), &resp); status != fiber.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", method.Name, status)
+		}
+		if string(resp.ID) != strconv.Itoa(i) || resp.JSONRPC != "2.0" {
+			t.Errorf("%s: expected the response to request %d, got %+v", method.Name, i, resp)
+		}
+		if method.Name == "disk.fail" {
+			if resp.Error == nil || resp.Error.Code != rpcActionFailed {
+				t.Errorf("Expected disk.fail to fail, got %+v", resp)
+			}
+		} else if resp.Error != nil {
+			t.Errorf("%s: expected a result, got %+v", method.Name, resp.Error)
+		}
+	}
+	if !reflect.DeepEqual(h.created, []string{"example"}) {
+		t.Errorf("Expected the disk of the example to be created, got %v", h.created)
+	}
+}
+
+func TestRunActionErrors(t *testing.T) {
+	app, _, _ := newTestApp(t, Config{})
+	tests := []struct {
+		path   string
+		body   string
+		status int
+		error  string
+		errors []handlerfactory.ParamError
+	}{
+		{"/api/disk/create", `{"name":"data","size":10}`, fiber.StatusOK, "", nil},
+		{"/api/disk/list", ``, fiber.StatusOK, "", nil},
+		{"/api/disk/list", `{"anything":"goes"}`, fiber.StatusOK, "", nil},
+		{"/api/disk/resize", `{}`, fiber.StatusNotFound, "unknown action disk.resize", nil},
+		{"/api/vm/create", `{}`, fiber.StatusNotFound, "unknown action vm.create", nil},
+		{"/api/disk/create", `["data",10]`, fiber.StatusBadRequest, "the body must be a JSON object of params", nil},
+		{"/api/disk/create", `{"name":"data","size":"ten"}`, fiber.StatusBadRequest, "invalid params",
+			[]handlerfactory.ParamError{{Param: "size", Message: "must be an int, got 'ten'"}}},
+		{"/api/disk/create", `{"size":10,"color":"red"}`, fiber.StatusBadRequest, "invalid params", []handlerfactory.ParamError{
+			{Param: "name", Message: "is required"},
+			{Param: "color", Message: "is not a param of the action"},
+		}},
+		// Params that cannot be written to a heroscript are rejected before
+		// the action is parsed
+		{"/api/disk/create", `{"name":"it's","size":10,"bad-name":1,"tags":["a"]}`, fiber.StatusBadRequest, "invalid params", []handlerfactory.ParamError{
+			{Param: "bad-name", Message: "is not a valid param name"},
+			{Param: "name", Message: "cannot contain quotes or new lines"},
+			{Param: "tags", Message: "must be a string, number or bool"},
+		}},
+		{"/api/disk/fail", ``, fiber.StatusInternalServerError, "the disk is broken", nil},
+	}
+	for _, test := range tests {
+		var resp errorResponse
+		status := request(t, app, "POST", test.path, "", test.body, &resp)
+		if status != test.status || !strings.Contains(resp.Error, test.error) {
+			t.Errorf("%s %s: expected %d %q, got %d %q", test.path, test.body, test.status, test.error, status, resp.Error)
+		}
+		if !reflect.DeepEqual(resp.Errors, test.errors) {
+			t.Errorf("%s %s: expected the errors %+v, got %+v", test.path, test.body, test.errors, resp.Errors)
+		}
+	}
+}
+
+func TestRPCErrors(t *testing.T) {
+	app, _, h := newTestApp(t, Config{})
+	tests := []struct {
+		body string
+		id   string
+		code int
+		data []handlerfactory.ParamError
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"disk.create","params":{"name":"data","size":10}}`, "1", 0, nil},
+		{`{"jsonrpc":"2.0","id":"a","method":"disk.list"}`, `"a"`, 0, nil},
+		{`{"jsonrpc":"2.0","id":1,"method":"disk.list","params":null}`, "1", 0, nil},
+		{`{"jsonrpc":"2.0",`, "null", rpcParseError, nil},
+		{`{"jsonrpc":"1.0","id":1,"method":"disk.list"}`, "1", rpcInvalidRequest, nil},
+		{`{"jsonrpc":"2.0","id":1,"method":"disklist"}`, "1", rpcInvalidRequest, nil},
+		{`{"jsonrpc":"2.0","id":1,"method":"disk.resize"}`, "1", rpcMethodNotFound, nil},
+		{`{"jsonrpc":"2.0","id":1,"method":"disk.create","params":["data",10]}`, "1", rpcInvalidParams, nil},
+		{`{"jsonrpc":"2.0","id":1,"method":"disk.create","params":{"name":"data"}}`, "1", rpcInvalidParams,
+			[]handlerfactory.ParamError{{Param: "size", Message: "is required"}}},
+		{`{"jsonrpc":"2.0","id":1,"method":"disk.fail"}`, "1", rpcActionFailed, nil},
+	}
+	for _, test := range tests {
+		var resp testRPCResponse
+		if status := request(t, app, "POST", "/api/rpc", "", test.body, &resp); status != fiber.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", test.body, status)
+		}
+		if string(resp.ID) != test.id {
+			t.Errorf("%s: expected the ID %s, got %s", test.body, test.id, resp.ID)
+		}
+		code := 0
+		var data []handlerfactory.ParamError
+		if resp.Error != nil {
+			code, data = resp.Error.Code, resp.Error.Data
+		}
+		if code != test.code || !reflect.DeepEqual(data, test.data) {
+			t.Errorf("%s: expected the code %d and %+v, got %d and %+v", test.body, test.code, test.data, code, data)
+		}
+	}
+
+	// Notifications run without a response
+	notification := `{"jsonrpc":"2.0","method":"disk.create","params":{"name":"quiet","size":1}}`
+	if status := request(t, app, "POST", "/api/rpc", "", notification, nil); status != fiber.StatusNoContent {
+		t.Errorf("Expected no content for a notification, got %d", status)
+	}
+	if !reflect.DeepEqual(h.created, []string{"data", "quiet"}) {
+		t.Errorf("Expected the notification to create a disk, got %v", h.created)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	app, _, _ := newTestApp(t, Config{Secret: "secret"})
+	for _, token := range []string{"", "wrong"} {
+		for _, route := range []struct{ method, path string }{
+			{"GET", "/api/openapi.json"},
+			{"GET", "/api/openrpc.json"},
+			{"POST", "/api/rpc"},
+			{"POST", "/api/disk/list"},
+		} {
+			var resp errorResponse
+			if status := request(t, app, route.method, route.path, token, "", &resp); status != fiber.StatusUnauthorized || resp.Error == "" {
+				t.Errorf("%s %s with %q: expected 401, got %d %+v", route.method, route.path, token, status, resp)
+			}
+		}
+	}
+
+	// The document of an API with a secret requires it
+	var doc OpenAPIDocument
+	if status := request(t, app, "GET", "/api/openapi.json", "secret", "", &doc); status != fiber.StatusOK {
+		t.Fatalf("Expected the document, got status %d", status)
+	}
+	if scheme := doc.Components.SecuritySchemes["bearer"]; scheme == nil || scheme.Type != "http" || scheme.Scheme != "bearer" {
+		t.Errorf("Expected the bearer security scheme, got %+v", doc.Components.SecuritySchemes)
+	}
+	if len(doc.Security) != 1 || doc.Security[0]["bearer"] == nil {
+		t.Errorf("Expected the API to require the bearer token, got %+v", doc.Security)
+	}
+	for path, item := range doc.Paths {
+		if item.Post.Responses["401"] == nil {
+			t.Errorf("%s: expected a 401 response", path)
+		}
+	}
+
+	// Callers that the middleware of the factory rejects get the status and
+	// code of the rejection
+	app, f, _ := newTestApp(t, Config{})
+	f.Use(handlerfactory.Authenticate(map[string]string{"alice-token": "alice"}))
+	var resp errorResponse
+	if status := request(t, app, "POST", "/api/disk/list", "bob-token", "", &resp); status != fiber.StatusUnauthorized {
+		t.Errorf("Expected an unknown token to be rejected, got %d %+v", status, resp)
+	}
+	var rpcResp testRPCResponse
+	request(t, app, "POST", "/api/rpc", "bob-token", `{"jsonrpc":"2.0","id":1,"method":"disk.list"}`, &rpcResp)
+	if rpcResp.Error == nil || rpcResp.Error.Code != rpcUnauthenticated {
+		t.Errorf("Expected an unknown token to be rejected, got %+v", rpcResp)
+	}
+	if status := request(t, app, "POST", "/api/disk/list", "alice-token", "", nil); status != fiber.StatusOK {
+		t.Errorf("Expected a known token to be let in, got %d", status)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	app, f, h := newTestApp(t, Config{})
+	if err := f.EnableJobs(handlerfactory.JobConfig{}); err != nil {
+		t.Fatalf("Failed to enable jobs: %v", err)
+	}
+
+	// Actions can run async, except for the actions of jobs
+	doc := OpenAPI(f, Info{})
+	for path, item := range doc.Paths {
+		async := item.Post.RequestBody.Content["application/json"].Schema.Properties["async"]
+		if strings.HasPrefix(path, "/job/") != (async == nil) {
+			t.Errorf("%s: unexpected async param %+v", path, async)
+		}
+	}
+
+	var resp resultResponse
+	if status := request(t, app, "POST", "/api/disk/create", "", `{"name":"data","size":10,"async":true}`, &resp); status != fiber.StatusOK || resp.Result == "" {
+		t.Fatalf("Expected the ID of a job, got %d %+v", status, resp)
+	}
+	f.WaitJobs()
+	if !reflect.DeepEqual(h.created, []string{"data"}) {
+		t.Errorf("Expected the job to create the disk, got %v", h.created)
+	}
+}
+
+func TestActionScript(t *testing.T) {
+	params := map[string]interface{}{
+		"size":      json.Number("10"),
+		"name":      "data disk",
+		"ratio":     0.25,
+		"encrypted": true,
+	}
+	script, errs := actionScript("disk", "create", params)
+	if want := "!!disk.create encrypted:'true' name:'data disk' ratio:'0.25' size:'10'"; script != want || len(errs) != 0 {
+		t.Errorf("Expected %q, got %q, %+v", want, script, errs)
+	}
+
+	tests := []struct {
+		value interface{}
+		want  string
+		error string
+	}{
+		{"text", "text", ""},
+		{json.Number("1e3"), "1e3", ""},
+		{1e21, "1000000000000000000000", ""},
+		{false, "false", ""},
+		{"line\nbreak", "", "cannot contain quotes or new lines"},
+		{nil, "", "must be a string, number or bool"},
+		{map[string]interface{}{}, "", "must be a string, number or bool"},
+	}
+	for _, test := range tests {
+		got, err := paramValue(test.value)
+		if test.error == "" && (err != nil || got != test.want) {
+			t.Errorf("%v: expected %q, got %q, %v", test.value, test.want, got, err)
+		} else if test.error != "" && (err == nil || err.Error() != test.error) {
+			t.Errorf("%v: expected the error %q, got %v", test.value, test.error, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package httpapi
+
+import (
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+)
+
+// OpenAPIDocument is an OpenAPI 3 document of the actions of handlers
+type OpenAPIDocument struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Servers    []Server              `json:"servers,omitempty"`
+	Paths      map[string]*PathItem  `json:"paths"`
+	Components Components            `json:"components"`
+	Security   []map[string][]string `json:"security,omitempty"`
+}
+
+// Server is a server that serves the API
+type Server struct {
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url"`
+}
+
+// PathItem is the operation of a path, a POST per action
+type PathItem struct {
+	Post *Operation `json:"post,omitempty"`
+}
+
+// Operation is an action as HTTP operation
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// RequestBody is the body of an operation, the params of its action
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response is a response of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType is the schema of a body
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components are the schemas that the operations refer to, and the security
+// scheme of the API when it has a secret
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme is how requests are authenticated
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme,omitempty"`
+}
+
+// OpenAPI returns the OpenAPI document of the actions of the handlers of a
+// factory, with a POST operation per action at /<actor>/<action>, which
+// takes the params as JSON object and returns the result of the action
+func OpenAPI(f *handlerfactory.HandlerFactory, info Info) *OpenAPIDocument {
+	doc := &OpenAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    info,
+		Paths:   make(map[string]*PathItem),
+		Components: Components{
+			Schemas: map[string]*Schema{
+				"Result": {
+					Type:       "object",
+					Properties: map[string]*Schema{"result": resultSchema()},
+					Required:   []string{"result"},
+				},
+				"Error": errorSchema(),
+			},
+		},
+	}
+
+	jobs := jobsEnabled(f)
+	for _, a := range f.Actions() {
+		async := jobs && a.Actor != "job"
+		doc.Paths["/"+a.Actor+"/"+a.Name] = &PathItem{Post: &Operation{
+			OperationID: a.Actor + "_" + a.Name,
+			Summary:     summary(a),
+			Tags:        []string{a.Actor},
+			RequestBody: &RequestBody{
+				Required: len(a.Schema.Params) > 0,
+				Content: map[string]MediaType{
+					"application/json": {Schema: paramsSchema(a, async)},
+				},
+			},
+			Responses: map[string]*Response{
+				"200": jsonResponse("The result of the action", "Result"),
+				"400": jsonResponse("The params are invalid", "Error"),
+				"500": jsonResponse("The action failed", "Error"),
+			},
+		}}
+	}
+	return doc
+}
+
+// jsonResponse returns a response with a schema of the components
+func jsonResponse(description, schema string) *Response {
+	return &Response{
+		Description: description,
+		Content: map[string]MediaType{
+			"application/json": {Schema: &Schema{Ref: "#/components/schemas/" + schema}},
+		},
+	}
+}
+
+// requireBearer sets that the operations of the document need a bearer
+// token
+func (doc *OpenAPIDocument) requireBearer() {
+	doc.Components.SecuritySchemes = map[string]*SecurityScheme{
+		"bearer": {Type: "http", Scheme: "bearer"},
+	}
+	doc.Security = []map[string][]string{{"bearer": {}}}
+	for _, item := range doc.Paths {
+		item.Post.Responses["401"] = jsonResponse("The secret is missing or wrong", "Error")
+	}
+}
@@ -0,0 +1,86 @@
+package httpapi
+
+import (
+	"sort"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+)
+
+// OpenRPCDocument is an OpenRPC document of the actions of handlers
+type OpenRPCDocument struct {
+	OpenRPC string   `json:"openrpc"`
+	Info    Info     `json:"info"`
+	Servers []Server `json:"servers,omitempty"`
+	Methods []Method `json:"methods"`
+}
+
+// Method is an action as JSON-RPC method
+type Method struct {
+	Name    string `json:"name"`
+	Summary string `json:"summary,omitempty"`
+	Tags    []Tag  `json:"tags,omitempty"`
+	// ParamStructure is by-name, as the params of actions have names
+	ParamStructure string              `json:"paramStructure"`
+	Params         []ContentDescriptor `json:"params"`
+	Result         ContentDescriptor   `json:"result"`
+}
+
+// Tag groups the methods of an actor
+type Tag struct {
+	Name string `json:"name"`
+}
+
+// ContentDescriptor is a param or the result of a method
+type ContentDescriptor struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// OpenRPC returns the OpenRPC document of the actions of the handlers of a
+// factory, with a method per action named <actor>.<action>, which takes the
+// params by name and returns the result of the action
+func OpenRPC(f *handlerfactory.HandlerFactory, info Info) *OpenRPCDocument {
+	doc := &OpenRPCDocument{
+		OpenRPC: "1.2.6",
+		Info:    info,
+		Methods: []Method{},
+	}
+
+	jobs := jobsEnabled(f)
+	for _, a := range f.Actions() {
+		async := jobs && a.Actor != "job"
+		method := Method{
+			Name:           a.Actor + "." + a.Name,
+			Summary:        summary(a),
+			Tags:           []Tag{{Name: a.Actor}},
+			ParamStructure: "by-name",
+			Params:         []ContentDescriptor{},
+			Result:         ContentDescriptor{Name: "result", Schema: resultSchema()},
+		}
+
+		// The params are sorted like the properties of the OpenAPI document
+		params := paramsSchema(a, async)
+		names := make([]string, 0, len(params.Properties))
+		for name := range params.Properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		required := make(map[string]bool)
+		for _, name := range params.Required {
+			required[name] = true
+		}
+		for _, name := range names {
+			schema := params.Properties[name]
+			method.Params = append(method.Params, ContentDescriptor{
+				Name:        name,
+				Description: schema.Description,
+				Required:    required[name],
+				Schema:      schema,
+			})
+		}
+		doc.Methods = append(doc.Methods, method)
+	}
+	return doc
+}
@@ -0,0 +1,205 @@
+package httpapi
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Config configures the API
+type Config struct {
+	Info Info
+	// Secret is the bearer token that requests need, like the secret of the
+	// telnet server; requests are not authenticated if it is empty
+	Secret string
+}
+
+// API serves the actions of the handlers of a factory over HTTP
+type API struct {
+	factory *handlerfactory.HandlerFactory
+	config  Config
+}
+
+// paramName matches the names of params in requests, which are written to a
+// heroscript as they are
+var paramName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// errUnknownAction is returned for actions that no handler has
+var errUnknownAction = errors.New("unknown action")
+
+// NewAPI creates the API of the handlers of a factory. Handlers that are
+// registered later are served too.
+func NewAPI(factory *handlerfactory.HandlerFactory, config Config) *API {
+	if config.Info.Title == "" {
+		config.Info.Title = "Heroscript API"
+	}
+	if config.Info.Version == "" {
+		config.Info.Version = "1.0.0"
+	}
+	return &API{factory: factory, config: config}
+}
+
+// RegisterRoutes registers the routes of the API to a fiber app or group:
+//
+//	GET  /openapi.json     the OpenAPI document of the actions
+//	GET  /openrpc.json     the OpenRPC document of the actions
+//	POST /rpc              runs an action as JSON-RPC 2.0 method <actor>.<action>
+//	POST /:actor/:action   runs an action with the params of the JSON body
+func (api *API) RegisterRoutes(router fiber.Router) {
+	router.Get("/openapi.json", api.authenticate, api.openAPI)
+	router.Get("/openrpc.json", api.authenticate, api.openRPC)
+	router.Post("/rpc", api.authenticate, api.rpc)
+	router.Post("/:actor/:action", api.authenticate, api.runAction)
+}
+
+// errorResponse is the body of the errors of the API
+type errorResponse struct {
+	Error  string                      `json:"error"`
+	Errors []handlerfactory.ParamError `json:"errors,omitempty"`
+}
+
+// resultResponse is the body of an action that ran
+type resultResponse struct {
+	Result string `json:"result"`
+}
+
+// authenticate checks the bearer token of a request, if the API has a secret
+func (api *API) authenticate(c *fiber.Ctx) error {
+	if api.config.Secret == "" {
+		return c.Next()
+	}
+	token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(api.config.Secret)) != 1 {
+		return c.Status(fiber.StatusUnauthorized).JSON(errorResponse{Error: "authentication required"})
+	}
+	return c.Next()
+}
+
+// openAPI returns the OpenAPI document, with the URL it was requested at as
+// server
+func (api *API) openAPI(c *fiber.Ctx) error {
+	doc := OpenAPI(api.factory, api.config.Info)
+	doc.Servers = []Server{{URL: c.BaseURL() + strings.TrimSuffix(c.Path(), "/openapi.json")}}
+	if api.config.Secret != "" {
+		doc.requireBearer()
+	}
+	return c.JSON(doc)
+}
+
+// openRPC returns the OpenRPC document, with the URL of the rpc route as
+// server
+func (api *API) openRPC(c *fiber.Ctx) error {
+	doc := OpenRPC(api.factory, api.config.Info)
+	doc.Servers = []Server{{Name: "http", URL: c.BaseURL() + strings.TrimSuffix(c.Path(), "/openrpc.json") + "/rpc"}}
+	return c.JSON(doc)
+}
+
+// runAction runs the action of the path with the params of the body
+func (api *API) runAction(c *fiber.Ctx) error {
+	actor, name := c.Params("actor"), c.Params("action")
+	var params map[string]interface{}
+	if body := bytes.TrimSpace(c.Body()); len(body) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&params); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(errorResponse{
+				Error: "invalid request: the body must be a JSON object of params: " + err.Error(),
+			})
+		}
+	}
+
+	result, err := api.run(caller(c), actor, name, params)
+	var validationErr *handlerfactory.ValidationError
+	switch {
+	case errors.Is(err, errUnknownAction):
+		return c.Status(fiber.StatusNotFound).JSON(errorResponse{Error: err.Error()})
+	case errors.As(err, &validationErr):
+		return c.Status(fiber.StatusBadRequest).JSON(errorResponse{Error: err.Error(), Errors: validationErr.Errors})
+	case errors.Is(err, handlerfactory.ErrUnauthenticated):
+		return c.Status(fiber.StatusUnauthorized).JSON(errorResponse{Error: err.Error()})
+	case errors.Is(err, handlerfactory.ErrForbidden):
+		return c.Status(fiber.StatusForbidden).JSON(errorResponse{Error: err.Error()})
+	case errors.Is(err, handlerfactory.ErrRateLimited):
+		return c.Status(fiber.StatusTooManyRequests).JSON(errorResponse{Error: err.Error()})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(errorResponse{Error: err.Error()})
+	}
+	return c.JSON(resultResponse{Result: result})
+}
+
+// caller returns the caller of a request for the middleware of the factory,
+// with its bearer token
+func caller(c *fiber.Ctx) handlerfactory.Caller {
+	token, _ := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	return handlerfactory.Caller{Name: c.IP(), Transport: "http", Token: token}
+}
+
+// run runs an action of the factory for a caller with params, which are
+// validated by the schema of the action and can have async set to run it as
+// a job
+func (api *API) run(caller handlerfactory.Caller, actor, name string, params map[string]interface{}) (string, error) {
+	if _, ok := findAction(api.factory, actor, name); !ok {
+		return "", fmt.Errorf("%w %s.%s", errUnknownAction, actor, name)
+	}
+	script, paramErrors := actionScript(actor, name, params)
+	if len(paramErrors) > 0 {
+		return "", &handlerfactory.ValidationError{Actor: actor, Action: name, Errors: paramErrors}
+	}
+	return api.factory.Run(script, handlerfactory.RunOptions{Caller: caller})
+}
+
+// actionScript returns the heroscript of an action with the params of a
+// request, which can be strings, numbers or bools. Params that cannot be
+// written to a heroscript are returned as errors.
+func actionScript(actor, name string, params map[string]interface{}) (string, []handlerfactory.ParamError) {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	script := "!!" + actor + "." + name
+	var paramErrors []handlerfactory.ParamError
+	for _, key := range keys {
+		value, err := paramValue(params[key])
+		if err == nil && !paramName.MatchString(key) {
+			err = errors.New("is not a valid param name")
+		}
+		if err != nil {
+			paramErrors = append(paramErrors, handlerfactory.ParamError{Param: key, Message: err.Error()})
+			continue
+		}
+		script += fmt.Sprintf(" %s:'%s'", key, value)
+	}
+	return script, paramErrors
+}
+
+// paramValue returns a JSON value of a param as heroscript value
+func paramValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		// Heroscript has no escapes, so quotes and new lines would end the
+		// value or the action
+		if strings.ContainsAny(v, "'\n") {
+			return "", errors.New("cannot contain quotes or new lines")
+		}
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", errors.New("must be a string, number or bool")
+	}
+}
@@ -0,0 +1,108 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+	"github.com/gofiber/fiber/v2"
+)
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	// rpcActionFailed is the code of actions that fail
+	rpcActionFailed = -32000
+	// The codes of actions that the middleware of the factory rejects
+	rpcUnauthenticated = -32001
+	rpcForbidden       = -32003
+	rpcRateLimited     = -32029
+)
+
+// rpcRequest is a JSON-RPC 2.0 request
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError is the error of a JSON-RPC response, with the invalid params as
+// data for invalid params
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// rpc runs an action as JSON-RPC method <actor>.<action>, with its params by
+// name. Notifications, which have no ID, run without a response.
+func (api *API) rpc(c *fiber.Ctx) error {
+	var req rpcRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.JSON(rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{
+			Code: rpcParseError, Message: "parse error: " + err.Error(),
+		}})
+	}
+	id := req.ID
+	if len(id) == 0 {
+		id = json.RawMessage("null")
+	}
+
+	result, rpcErr := api.call(caller(c), req)
+	if len(req.ID) == 0 && req.JSONRPC == "2.0" {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+	if rpcErr != nil {
+		return c.JSON(rpcResponse{JSONRPC: "2.0", ID: id, Error: rpcErr})
+	}
+	return c.JSON(rpcResponse{JSONRPC: "2.0", ID: id, Result: result})
+}
+
+// call runs the action of a JSON-RPC request
+func (api *API) call(caller handlerfactory.Caller, req rpcRequest) (string, *rpcError) {
+	actor, name, ok := strings.Cut(req.Method, ".")
+	if req.JSONRPC != "2.0" || !ok {
+		return "", &rpcError{Code: rpcInvalidRequest, Message: "invalid request: expected jsonrpc 2.0 and a method <actor>.<action>"}
+	}
+
+	var params map[string]interface{}
+	if raw := bytes.TrimSpace(req.Params); len(raw) > 0 && !bytes.Equal(raw, []byte("null")) {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.UseNumber()
+		if err := decoder.Decode(&params); err != nil {
+			return "", &rpcError{Code: rpcInvalidParams, Message: "invalid params: params must be passed by name"}
+		}
+	}
+
+	result, err := api.run(caller, actor, name, params)
+	var validationErr *handlerfactory.ValidationError
+	switch {
+	case errors.Is(err, errUnknownAction):
+		return "", &rpcError{Code: rpcMethodNotFound, Message: "method not found: " + req.Method}
+	case errors.As(err, &validationErr):
+		return "", &rpcError{Code: rpcInvalidParams, Message: err.Error(), Data: validationErr.Errors}
+	case errors.Is(err, handlerfactory.ErrUnauthenticated):
+		return "", &rpcError{Code: rpcUnauthenticated, Message: err.Error()}
+	case errors.Is(err, handlerfactory.ErrForbidden):
+		return "", &rpcError{Code: rpcForbidden, Message: err.Error()}
+	case errors.Is(err, handlerfactory.ErrRateLimited):
+		return "", &rpcError{Code: rpcRateLimited, Message: err.Error()}
+	case err != nil:
+		return "", &rpcError{Code: rpcActionFailed, Message: err.Error()}
+	}
+	return result, nil
+}
@@ -0,0 +1,158 @@
+// Package httpapi serves the actions of the handlers of a handlerfactory over
+// HTTP, with an OpenAPI and an OpenRPC document that are generated from the
+// actors, actions and param schemas of the handlers.
+package httpapi
+
+import (
+	"strconv"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+)
+
+// Info describes the API in the documents
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Schema is the JSON schema of a value in the documents
+type Schema struct {
+	// Ref refers to a schema of the components of an OpenAPI document
+	Ref         string             `json:"$ref,omitempty"`
+	Type        string             `json:"type,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Default     interface{}        `json:"default,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	// AdditionalProperties is false for actions that only accept the params
+	// of their schema, and true for actions without a schema
+	AdditionalProperties *bool `json:"additionalProperties,omitempty"`
+}
+
+// findAction returns an action of the handlers of a factory
+func findAction(f *handlerfactory.HandlerFactory, actor, name string) (handlerfactory.ActionInfo, bool) {
+	for _, a := range f.Actions() {
+		if a.Actor == actor && a.Name == name {
+			return a, true
+		}
+	}
+	return handlerfactory.ActionInfo{}, false
+}
+
+// jobsEnabled reports whether the actions of a factory can run as jobs, as
+// the job actor is registered when jobs are enabled
+func jobsEnabled(f *handlerfactory.HandlerFactory) bool {
+	_, err := f.GetHandler("job")
+	return err == nil
+}
+
+// paramsSchema returns the schema of the params of an action, as an object
+// with a property per param
+func paramsSchema(a handlerfactory.ActionInfo, async bool) *Schema {
+	allowUnknown := !a.HasSchema || a.Schema.AllowUnknown
+	schema := &Schema{
+		Type:                 "object",
+		Properties:           make(map[string]*Schema),
+		AdditionalProperties: &allowUnknown,
+	}
+	for _, param := range a.Schema.Params {
+		schema.Properties[param.Name] = paramSchema(param)
+		if param.Required {
+			schema.Required = append(schema.Required, param.Name)
+		}
+	}
+	if async {
+		schema.Properties[handlerfactory.ParamAsync] = &Schema{
+			Type:        "boolean",
+			Description: "Run the action in the background as a job, and return its ID",
+		}
+	}
+	return schema
+}
+
+// paramSchema returns the JSON schema of a param
+func paramSchema(param handlerfactory.ParamSchema) *Schema {
+	schema := &Schema{
+		Type:        jsonType(param.Type),
+		Description: param.Description,
+		Enum:        param.Enum,
+	}
+	if param.Default != "" {
+		schema.Default = jsonValue(param.Type, param.Default)
+	}
+	return schema
+}
+
+// jsonType returns the JSON schema type of a param type
+func jsonType(paramType handlerfactory.ParamType) string {
+	switch paramType {
+	case handlerfactory.ParamTypeInt:
+		return "integer"
+	case handlerfactory.ParamTypeFloat:
+		return "number"
+	case handlerfactory.ParamTypeBool:
+		return "boolean"
+	default:
+		return "string"
+	}
+}
+
+// jsonValue returns a value of a param as the JSON value of its type, or as
+// string if it is not of its type
+func jsonValue(paramType handlerfactory.ParamType, value string) interface{} {
+	switch paramType {
+	case handlerfactory.ParamTypeInt:
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	case handlerfactory.ParamTypeFloat:
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	case handlerfactory.ParamTypeBool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// summary returns the summary of an action in the documents
+func summary(a handlerfactory.ActionInfo) string {
+	if a.Schema.Description != "" {
+		return a.Schema.Description
+	}
+	return "Run the " + a.Actor + "." + a.Name + " action"
+}
+
+// resultSchema is the schema of the result of an action
+func resultSchema() *Schema {
+	return &Schema{Type: "string", Description: "The result of the action, or the ID of its job when it runs async"}
+}
+
+// errorSchema is the schema of the errors of the API
+func errorSchema() *Schema {
+	no := false
+	return &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"error": {Type: "string"},
+			"errors": {
+				Type:        "array",
+				Description: "The invalid params, for actions that are rejected by their schema",
+				Items: &Schema{
+					Type: "object",
+					Properties: map[string]*Schema{
+						"param":   {Type: "string"},
+						"message": {Type: "string"},
+					},
+				},
+			},
+		},
+		Required:             []string{"error"},
+		AdditionalProperties: &no,
+	}
+}
@@ -0,0 +1,526 @@
+package handlerfactory
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+	"github.com/redis/go-redis/v9"
+)
+
+// ParamAsync is the param that runs an action as a job in the background,
+// async:true, instead of waiting for its result. The result of the action
+// is the ID of the job.
+const ParamAsync = "async"
+
+// JobStatus is the status of a job
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobDone      JobStatus = "done"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+// ErrJobNotFound is returned for jobs that do not exist or expired
+var ErrJobNotFound = errors.New("job not found")
+
+// Job is an action that runs in the background
+type Job struct {
+	ID       string    `json:"id"`
+	Actor    string    `json:"actor"`
+	Action   string    `json:"action"`
+	Script   string    `json:"script"`
+	Status   JobStatus `json:"status"`
+	Result   string    `json:"result,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Logs     []string  `json:"logs"`
+	Created  time.Time `json:"created"`
+	Started  time.Time `json:"started,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+}
+
+// Done reports whether the job is done, failed or was cancelled
+func (j *Job) Done() bool {
+	return j.Status == JobDone || j.Status == JobFailed || j.Status == JobCancelled
+}
+
+// log adds a line to the logs of the job
+func (j *Job) log(format string, args ...interface{}) {
+	line := time.Now().Format("2006-01-02 15:04:05") + " " + fmt.Sprintf(format, args...)
+	j.Logs = append(j.Logs, line)
+}
+
+// JobStore stores jobs with their results and logs
+type JobStore interface {
+	SaveJob(ctx context.Context, job *Job) error
+	// GetJob returns ErrJobNotFound for jobs that do not exist
+	GetJob(ctx context.Context, id string) (*Job, error)
+	// ListJobs returns the jobs, the oldest first
+	ListJobs(ctx context.Context) ([]*Job, error)
+}
+
+// JobConfig configures the jobs of a HandlerFactory
+type JobConfig struct {
+	// Store stores the jobs, in memory if it is nil
+	Store JobStore
+	// Concurrency is the number of jobs of an actor that run at the same
+	// time, by actor, DefaultConcurrency for other actors
+	Concurrency map[string]int
+	// DefaultConcurrency is 1 if it is 0, so the jobs of an actor run one
+	// after the other
+	DefaultConcurrency int
+}
+
+// jobRunner runs the jobs of a HandlerFactory
+type jobRunner struct {
+	store  JobStore
+	config JobConfig
+
+	mu sync.Mutex
+	// slots limit the jobs that run at the same time, by actor
+	slots map[string]chan struct{}
+	// queued has the jobs that wait for a slot, which are closed to cancel
+	// them, by ID
+	queued map[string]chan struct{}
+	wg     sync.WaitGroup
+}
+
+// EnableJobs lets actions run in the background with the async param, and
+// registers the job actor to get their status and results:
+//
+//	!!job.status id:'4f1c2a9e8b7d6c5a'
+//	!!job.result id:'4f1c2a9e8b7d6c5a' wait:30
+//	!!job.logs id:'4f1c2a9e8b7d6c5a'
+//	!!job.cancel id:'4f1c2a9e8b7d6c5a'
+//	!!job.list
+func (f *HandlerFactory) EnableJobs(config JobConfig) error {
+	if f.jobs != nil {
+		return fmt.Errorf("jobs are enabled already")
+	}
+	if config.Store == nil {
+		config.Store = NewMemoryJobStore()
+	}
+	if config.DefaultConcurrency <= 0 {
+		config.DefaultConcurrency = 1
+	}
+
+	runner := &jobRunner{
+		store:  config.Store,
+		config: config,
+		slots:  make(map[string]chan struct{}),
+		queued: make(map[string]chan struct{}),
+	}
+	if err := f.RegisterHandler(newJobHandler(runner)); err != nil {
+		return err
+	}
+	f.jobs = runner
+	return nil
+}
+
+// WaitJobs waits until the jobs that were submitted finished
+func (f *HandlerFactory) WaitJobs() {
+	if f.jobs != nil {
+		f.jobs.wg.Wait()
+	}
+}
+
+// submitJob runs an action of a handler in the background and returns the
+// ID of its job
+func (f *HandlerFactory) submitJob(handler Handler, action *playbook.Action) (string, error) {
+	if f.jobs == nil {
+		return "", fmt.Errorf("jobs are not enabled, so actions cannot run with %s", ParamAsync)
+	}
+	return f.jobs.submit(handler, action)
+}
+
+// newID returns a random ID, of a job or a session
+func newID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to create ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// submit stores a job for an action and runs it once a slot of its actor is
+// free
+func (r *jobRunner) submit(handler Handler, action *playbook.Action) (string, error) {
+	id, err := newID()
+	if err != nil {
+		return "", err
+	}
+	job := &Job{
+		ID:      id,
+		Actor:   action.Actor,
+		Action:  action.Name,
+		Script:  action.HeroScript(),
+		Status:  JobQueued,
+		Created: time.Now(),
+	}
+	job.log("queued")
+	if err := r.store.SaveJob(context.Background(), job); err != nil {
+		return "", fmt.Errorf("failed to save job: %w", err)
+	}
+
+	cancel := make(chan struct{})
+	r.mu.Lock()
+	r.queued[id] = cancel
+	r.mu.Unlock()
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		slot := r.slot(job.Actor)
+		select {
+		case slot <- struct{}{}:
+		case <-cancel:
+			return
+		}
+		defer func() { <-slot }()
+
+		// The job may have been cancelled while it got its slot
+		r.mu.Lock()
+		_, queued := r.queued[id]
+		delete(r.queued, id)
+		r.mu.Unlock()
+		if queued {
+			r.run(handler, job)
+		}
+	}()
+	return id, nil
+}
+
+// cancel cancels a job that is queued, so it does not run. Jobs that run
+// cannot be cancelled.
+func (r *jobRunner) cancel(id string) (*Job, error) {
+	ctx := context.Background()
+	r.mu.Lock()
+	cancel, queued := r.queued[id]
+	delete(r.queued, id)
+	r.mu.Unlock()
+	if !queued {
+		job, err := r.store.GetJob(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("job %s is %s, only queued jobs can be cancelled", id, job.Status)
+	}
+	close(cancel)
+
+	job, err := r.store.GetJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	job.Status = JobCancelled
+	job.Finished = time.Now()
+	job.log("cancelled")
+	if err := r.store.SaveJob(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to save job: %w", err)
+	}
+	return job, nil
+}
+
+// slot returns the slots of the jobs of an actor
+func (r *jobRunner) slot(actor string) chan struct{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	slot, ok := r.slots[actor]
+	if !ok {
+		concurrency := r.config.Concurrency[actor]
+		if concurrency <= 0 {
+			concurrency = r.config.DefaultConcurrency
+		}
+		slot = make(chan struct{}, concurrency)
+		r.slots[actor] = slot
+	}
+	return slot
+}
+
+// run runs a job and stores its result. Results that start with Error, as
+// handlers report errors, fail the job.
+func (r *jobRunner) run(handler Handler, job *Job) {
+	ctx := context.Background()
+	job.Status = JobRunning
+	job.Started = time.Now()
+	job.log("started")
+	if err := r.store.SaveJob(ctx, job); err != nil {
+		fmt.Printf("Failed to save job %s: %v\n", job.ID, err)
+	}
+
+	result, err := handler.Play(job.Script, handler)
+	if err == nil {
+		err = resultError(result)
+	}
+
+	job.Finished = time.Now()
+	duration := job.Finished.Sub(job.Started).Round(time.Millisecond)
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = err.Error()
+		job.log("failed after %v: %v", duration, err)
+	} else {
+		job.Status = JobDone
+		job.Result = result
+		job.log("done after %v", duration)
+	}
+	if err := r.store.SaveJob(ctx, job); err != nil {
+		fmt.Printf("Failed to save job %s: %v\n", job.ID, err)
+	}
+}
+
+// MemoryJobStore stores jobs in memory, so they are lost when the process
+// exits
+type MemoryJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]Job
+}
+
+// NewMemoryJobStore creates a job store in memory
+func NewMemoryJobStore() *MemoryJobStore {
+	return &MemoryJobStore{jobs: make(map[string]Job)}
+}
+
+// SaveJob implements JobStore
+func (s *MemoryJobStore) SaveJob(ctx context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved := *job
+	saved.Logs = append([]string(nil), job.Logs...)
+	s.jobs[job.ID] = saved
+	return nil
+}
+
+// GetJob implements JobStore
+func (s *MemoryJobStore) GetJob(ctx context.Context, id string) (*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return &job, nil
+}
+
+// ListJobs implements JobStore
+func (s *MemoryJobStore) ListJobs(ctx context.Context) ([]*Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		job := job
+		jobs = append(jobs, &job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.Before(jobs[j].Created) })
+	return jobs, nil
+}
+
+// The keys of the jobs in Redis: the JSON of a job by ID, and a set of the
+// IDs of the jobs
+const (
+	redisJobKey  = "handlerfactory:job:"
+	redisJobList = "handlerfactory:jobs"
+)
+
+// RedisJobStore stores jobs in Redis, which keeps them for a time to live.
+// It only uses commands that the redisserver package supports.
+type RedisJobStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// NewRedisJobStore creates a job store in Redis that keeps the jobs for ttl,
+// in whole seconds, after they were saved last, or forever if ttl is 0
+func NewRedisJobStore(client *redis.Client, ttl time.Duration) *RedisJobStore {
+	if ttl > 0 && ttl < time.Second {
+		ttl = time.Second
+	}
+	return &RedisJobStore{client: client, ttl: ttl.Truncate(time.Second)}
+}
+
+// SaveJob implements JobStore
+func (s *RedisJobStore) SaveJob(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Set(ctx, redisJobKey+job.ID, data, s.ttl).Err(); err != nil {
+		return err
+	}
+	return s.client.SAdd(ctx, redisJobList, job.ID).Err()
+}
+
+// GetJob implements JobStore
+func (s *RedisJobStore) GetJob(ctx context.Context, id string) (*Job, error) {
+	data, err := s.client.Get(ctx, redisJobKey+id).Bytes()
+	if err == redis.Nil {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("failed to parse job %s: %w", id, err)
+	}
+	return &job, nil
+}
+
+// ListJobs implements JobStore. Jobs that expired are removed from the set.
+func (s *RedisJobStore) ListJobs(ctx context.Context) ([]*Job, error) {
+	ids, err := s.client.SMembers(ctx, redisJobList).Result()
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*Job
+	for _, id := range ids {
+		job, err := s.GetJob(ctx, id)
+		if errors.Is(err, ErrJobNotFound) {
+			s.client.SRem(ctx, redisJobList, id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.Before(jobs[j].Created) })
+	return jobs, nil
+}
+
+// jobHandler is the handler of the job actor, which reports the status and
+// results of jobs
+type jobHandler struct {
+	BaseHandler
+	runner *jobRunner
+}
+
+// newJobHandler creates the handler of the job actor
+func newJobHandler(runner *jobRunner) *jobHandler {
+	return &jobHandler{
+		BaseHandler: BaseHandler{ActorName: "job"},
+		runner:      runner,
+	}
+}
+
+// ActionSchemas implements SchemaProvider
+func (h *jobHandler) ActionSchemas() map[string]ActionSchema {
+	id := ParamSchema{Name: "id", Required: true, Description: "ID of the job"}
+	return map[string]ActionSchema{
+		"status": {Description: "Show the status of a job", Params: []ParamSchema{id}},
+		"result": {Description: "Show the result of a job", Params: []ParamSchema{
+			id,
+			{Name: "wait", Type: ParamTypeInt, Default: "0", Description: "Seconds to wait for the job to finish"},
+		}},
+		"logs":   {Description: "Show the logs of a job", Params: []ParamSchema{id}},
+		"cancel": {Description: "Cancel a job that is queued", Params: []ParamSchema{id}},
+		"list":   {Description: "List the jobs"},
+	}
+}
+
+// job returns the job of the id param of an action
+func (h *jobHandler) job(script string) (*Job, error) {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return nil, err
+	}
+	id := params.Get("id")
+	job, err := h.runner.store.GetJob(context.Background(), id)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, id)
+	}
+	return job, nil
+}
+
+// Status shows the status of a job
+func (h *jobHandler) Status(script string) string {
+	job, err := h.job(script)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+	status := fmt.Sprintf("Job %s (%s.%s): %s", job.ID, job.Actor, job.Action, job.Status)
+	if job.Error != "" {
+		status += ": " + job.Error
+	}
+	return status
+}
+
+// Result shows the result of a job, waiting up to wait seconds for it to
+// finish
+func (h *jobHandler) Result(script string) string {
+	job, err := h.job(script)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+	params, _ := h.ParseParams(script)
+	deadline := time.Now().Add(time.Duration(params.GetIntDefault("wait", 0)) * time.Second)
+	for id := job.ID; !job.Done() && time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+		if job, err = h.runner.store.GetJob(context.Background(), id); err != nil {
+			return fmt.Sprintf("Error: %v: %s", err, id)
+		}
+	}
+
+	switch job.Status {
+	case JobDone:
+		return job.Result
+	case JobFailed:
+		return fmt.Sprintf("Error: job %s failed: %s", job.ID, job.Error)
+	case JobCancelled:
+		return fmt.Sprintf("Error: job %s was cancelled", job.ID)
+	default:
+		return fmt.Sprintf("Error: job %s is %s", job.ID, job.Status)
+	}
+}
+
+// Logs shows the logs of a job
+func (h *jobHandler) Logs(script string) string {
+	job, err := h.job(script)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+	return strings.Join(job.Logs, "\n")
+}
+
+// Cancel cancels a job that is queued
+func (h *jobHandler) Cancel(script string) string {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+	id := params.Get("id")
+	if _, err := h.runner.cancel(id); err != nil {
+		if errors.Is(err, ErrJobNotFound) {
+			return fmt.Sprintf("Error: %v: %s", err, id)
+		}
+		return fmt.Sprintf("Error: %v", err)
+	}
+	return fmt.Sprintf("Job %s cancelled", id)
+}
+
+// List lists the jobs
+func (h *jobHandler) List(script string) string {
+	jobs, err := h.runner.store.ListJobs(context.Background())
+	if err != nil {
+		return fmt.Sprintf("Error: failed to list jobs: %v", err)
+	}
+	if len(jobs) == 0 {
+		return "No jobs"
+	}
+	var lines []string
+	for _, job := range jobs {
+		lines = append(lines, fmt.Sprintf("%s %s.%s %s (created %s)",
+			job.ID, job.Actor, job.Action, job.Status, job.Created.Format("2006-01-02 15:04:05")))
+	}
+	return strings.Join(lines, "\n")
+}
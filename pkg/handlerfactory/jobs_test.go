@@ -0,0 +1,152 @@
+package handlerfactory
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitJob waits until the status of a job contains status
+func waitJob(t *testing.T, f *HandlerFactory, id string, status JobStatus) {
+	t.Helper()
+	for i := 0; ; i++ {
+		got, err := f.ProcessHeroscript("!!job.status id:'" + id + "'")
+		if err == nil && strings.Contains(got, ": "+string(status)) {
+			return
+		} else if i == 100 {
+			t.Fatalf("Expected job %s to be %s, got %q, %v", id, status, got, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestJobLifecycle(t *testing.T) {
+	f, h := newTestFactory(t)
+	if err := f.EnableJobs(JobConfig{}); err != nil {
+		t.Fatalf("Failed to enable jobs: %v", err)
+	}
+	if err := f.EnableJobs(JobConfig{}); err == nil {
+		t.Error("Expected jobs to be enabled only once")
+	}
+
+	// The jobs of an actor run one at a time, so the others stay queued
+	// while the first one waits
+	submit := func(script string) string {
+		t.Helper()
+		id, err := f.ProcessHeroscript(script)
+		if err != nil || len(id) != 16 {
+			t.Fatalf("Failed to submit %s: %q, %v", script, id, err)
+		}
+		return id
+	}
+	waiting := submit("!!test.wait async:true")
+	waitJob(t, f, waiting, JobRunning)
+	queued := submit("!!test.add name:'a' async:true")
+	cancelled := submit("!!test.add name:'b' async:true")
+	failing := submit("!!test.fail async:true")
+	waitJob(t, f, queued, JobQueued)
+
+	steps := []struct {
+		script string
+		want   string
+	}{
+		{"!!job.status id:'" + waiting + "'", "Job " + waiting + " (test.wait): running"},
+		{"!!job.status id:'" + queued + "'", "Job " + queued + " (test.add): queued"},
+		{"!!job.cancel id:'" + cancelled + "'", "Job " + cancelled + " cancelled"},
+		{"!!job.status id:'" + cancelled + "'", "Job " + cancelled + " (test.add): cancelled"},
+		{"!!job.result id:'" + cancelled + "'", "action job.result: job " + cancelled + " was cancelled"},
+		{"!!job.cancel id:'" + cancelled + "'", "action job.cancel: job " + cancelled + " is cancelled, only queued jobs can be cancelled"},
+		{"!!job.cancel id:'" + waiting + "'", "action job.cancel: job " + waiting + " is running, only queued jobs can be cancelled"},
+		{"!!job.result id:'" + queued + "'", "action job.result: job " + queued + " is queued"},
+		{"!!job.status id:'unknown'", "action job.status: job not found: unknown"},
+		{"!!job.cancel id:'unknown'", "action job.cancel: job not found: unknown"},
+	}
+	for _, step := range steps {
+		got, err := f.Run(step.script, RunOptions{NoRollback: true})
+		if err != nil {
+			got = err.Error()
+		}
+		if got != step.want {
+			t.Errorf("%s: expected %q, got %q", step.script, step.want, got)
+		}
+	}
+
+	// Once the first job is done, the queued ones run, but not the
+	// cancelled one
+	close(h.release)
+	f.WaitJobs()
+	for _, step := range []struct {
+		script string
+		want   string
+	}{
+		{"!!job.result id:'" + waiting + "'", "waited"},
+		{"!!job.result id:'" + queued + "'", "added a"},
+		{"!!job.status id:'" + failing + "'", "Job " + failing + " (test.fail): failed: failed on purpose"},
+		{"!!job.status id:'" + cancelled + "'", "Job " + cancelled + " (test.add): cancelled"},
+	} {
+		if got, err := f.ProcessHeroscript(step.script); err != nil || got != step.want {
+			t.Errorf("%s: expected %q, got %q, %v", step.script, step.want, got, err)
+		}
+	}
+	// Queued jobs get a free slot in any order
+	actions := h.actions()
+	sort.Strings(actions[1:])
+	if got := strings.Join(actions, ", "); got != "wait, add a, fail" {
+		t.Errorf("Expected the actions of the jobs that were not cancelled to run, got %s", got)
+	}
+
+	logs, err := f.ProcessHeroscript("!!job.logs id:'" + queued + "'")
+	if err != nil {
+		t.Fatalf("Failed to get logs: %v", err)
+	}
+	if lines := strings.Split(logs, "\n"); len(lines) != 3 || !strings.HasSuffix(lines[0], " queued") ||
+		!strings.HasSuffix(lines[1], " started") || !strings.Contains(lines[2], " done after ") {
+		t.Errorf("Expected the logs to show the job queued, started and done, got %q", logs)
+	}
+	if list, err := f.ProcessHeroscript("!!job.list"); err != nil || len(strings.Split(list, "\n")) != 4 ||
+		!strings.HasPrefix(list, waiting+" test.wait done") {
+		t.Errorf("Expected the 4 jobs, the oldest first, got %q, %v", list, err)
+	}
+}
+
+func TestJobConcurrency(t *testing.T) {
+	f, h := newTestFactory(t)
+	if err := f.EnableJobs(JobConfig{Concurrency: map[string]int{"test": 2}}); err != nil {
+		t.Fatalf("Failed to enable jobs: %v", err)
+	}
+
+	// Two jobs of the actor run at the same time, the third one waits
+	var ids []string
+	for i := 0; i < 3; i++ {
+		id, err := f.ProcessHeroscript("!!test.wait async:true")
+		if err != nil {
+			t.Fatalf("Failed to submit job: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	for i := 0; len(h.actions()) < 2; i++ {
+		if i == 100 {
+			t.Fatalf("Expected two jobs to run, got %q", h.actions())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	list, err := f.ProcessHeroscript("!!job.list")
+	if err != nil || strings.Count(list, " running ") != 2 || strings.Count(list, " queued ") != 1 {
+		t.Errorf("Expected two running jobs and a queued one, got %q, %v", list, err)
+	}
+
+	close(h.release)
+	f.WaitJobs()
+	for _, id := range ids {
+		waitJob(t, f, id, JobDone)
+	}
+}
+
+func TestAsyncWithoutJobs(t *testing.T) {
+	f, _ := newTestFactory(t)
+	if _, err := f.ProcessHeroscript("!!test.add name:'a' async:true"); err == nil || !strings.Contains(err.Error(), "jobs are not enabled") {
+		t.Errorf("Expected async actions to fail without jobs, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package handlerfactory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/paramsparser"
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+)
+
+// Lint checks a heroscript without running it, like playbook.Lint, and
+// checks its actions against the actions of handlers, as Actions returns
+// them or a Client gets them from a server
+func Lint(script string, actions []ActionInfo) []playbook.Diagnostic {
+	return playbook.Lint(script, SchemaChecker(actions))
+}
+
+// SchemaChecker returns a playbook.Checker that reports actions of actors
+// and names that are not in actions, and params that do not match the
+// schemas of the actions as they would be validated when they run
+func SchemaChecker(actions []ActionInfo) playbook.Checker {
+	byActor := make(map[string]map[string]ActionInfo)
+	for _, action := range actions {
+		if byActor[action.Actor] == nil {
+			byActor[action.Actor] = make(map[string]ActionInfo)
+		}
+		byActor[action.Actor][action.Name] = action
+	}
+	_, jobsEnabled := byActor["job"]
+
+	return func(action *playbook.Action) []playbook.Diagnostic {
+		names, ok := byActor[action.Actor]
+		if !ok {
+			message := "unknown actor " + action.Actor
+			if similar := similarName(action.Actor, keys(byActor)); similar != "" {
+				message += fmt.Sprintf(", did you mean %s?", similar)
+			}
+			return []playbook.Diagnostic{{Severity: playbook.SeverityError, Message: message}}
+		}
+		info, ok := names[action.Name]
+		if !ok {
+			message := fmt.Sprintf("unknown action %s.%s", action.Actor, action.Name)
+			if similar := similarName(action.Name, keys(names)); similar != "" {
+				message += fmt.Sprintf(", did you mean %s?", similar)
+			}
+			return []playbook.Diagnostic{{Severity: playbook.SeverityError, Message: message}}
+		}
+
+		var diagnostics []playbook.Diagnostic
+		if action.Params.Has(ParamAsync) && !jobsEnabled {
+			diagnostics = append(diagnostics, playbook.Diagnostic{
+				Severity: playbook.SeverityError,
+				Param:    ParamAsync,
+				Message:  "jobs are not enabled, so actions cannot run with " + ParamAsync,
+			})
+		}
+		if !info.HasSchema {
+			return diagnostics
+		}
+		for _, paramError := range info.Schema.Validate(runnable(action)) {
+			diagnostics = append(diagnostics, playbook.Diagnostic{
+				Severity: playbook.SeverityError,
+				Param:    paramError.Param,
+				Message:  paramError.Param + " " + paramError.Message,
+			})
+		}
+		return diagnostics
+	}
+}
+
+// runnable returns a copy of an action as its handler would get it: without
+// control params, and with the first item of its foreach list
+func runnable(action *playbook.Action) *playbook.Action {
+	a := *action
+	a.Params = paramsparser.New()
+	for key, value := range action.Params.GetAll() {
+		a.Params.Set(key, value)
+	}
+	if param := a.Params.Get(playbook.ParamForeach); param != "" {
+		item, _, _ := strings.Cut(a.Params.Get(param), ",")
+		a.Params.Set(param, strings.TrimSpace(item))
+	}
+	for _, param := range []string{playbook.ParamAs, playbook.ParamIf, playbook.ParamForeach, playbook.ParamDependsOn, ParamAsync} {
+		a.Params.Delete(param)
+	}
+	return &a
+}
+
+// keys returns the keys of a map, sorted
+func keys[V any](m map[string]V) []string {
+	result := make([]string, 0, len(m))
+	for key := range m {
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result
+}
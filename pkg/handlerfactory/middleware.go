@@ -0,0 +1,265 @@
+package handlerfactory
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+)
+
+// Errors of the middleware, which the telnet server and the HTTP API report
+// to their callers
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrForbidden       = errors.New("forbidden")
+	ErrRateLimited     = errors.New("rate limited")
+)
+
+// Caller is who runs actions
+type Caller struct {
+	// Name identifies the caller, like the remote address of a connection
+	// or the name that Authenticate found for its token
+	Name string
+	// Transport is how the caller connected, telnet, ssh or http, or empty
+	// for Go code
+	Transport string
+	// Token is the secret that the caller authenticated with, or the
+	// fingerprint of the SSH key it logged in with
+	Token string
+}
+
+// Call is an action that a handler is called with
+type Call struct {
+	Caller  Caller
+	Handler Handler
+	// Action is the validated action, with the defaults of its schema set
+	Action *playbook.Action
+	// Async is set for actions that are submitted as job
+	Async bool
+	// Undo is set for actions that are undone, by a rollback or Undo
+	Undo bool
+}
+
+// Name returns the name of the action of the call, like vm.define
+func (c *Call) Name() string {
+	return c.Action.Actor + "." + c.Action.Name
+}
+
+// CallFunc calls a handler with an action and returns its result
+type CallFunc func(call *Call) (string, error)
+
+// Middleware wraps the calls of handlers, to check them before they run or
+// to observe their results. It returns a CallFunc that calls next to run
+// the action, or returns an error to reject it.
+type Middleware func(next CallFunc) CallFunc
+
+// Use adds middleware that wraps every call of a handler that the factory
+// makes: actions that run, are submitted as job or are undone. The first
+// middleware wraps the others, so it runs first.
+func (f *HandlerFactory) Use(middleware ...Middleware) {
+	f.middleware = append(f.middleware, middleware...)
+}
+
+// call calls a handler through the middleware of the factory
+func (f *HandlerFactory) call(call *Call, run CallFunc) (string, error) {
+	for i := len(f.middleware) - 1; i >= 0; i-- {
+		run = f.middleware[i](run)
+	}
+	return run(call)
+}
+
+// Hook returns middleware that calls before before an action runs, which
+// rejects the action if it returns an error, and after after it ran. Either
+// can be nil.
+func Hook(before func(call *Call) error, after func(call *Call, result string, err error)) Middleware {
+	return func(next CallFunc) CallFunc {
+		return func(call *Call) (string, error) {
+			if before != nil {
+				if err := before(call); err != nil {
+					return "", err
+				}
+			}
+			result, err := next(call)
+			if after != nil {
+				after(call, result, err)
+			}
+			return result, err
+		}
+	}
+}
+
+// Authenticate returns middleware that only lets callers with one of the
+// tokens run actions, and sets the name of the caller to the name of its
+// token. The telnet server and the HTTP API set the token of their callers
+// to the secret they authenticated with, and to the fingerprint of their key
+// for SSH sessions that logged in with one.
+func Authenticate(tokens map[string]string) Middleware {
+	return Hook(func(call *Call) error {
+		name, ok := tokens[call.Caller.Token]
+		if call.Caller.Token == "" || !ok {
+			return fmt.Errorf("%w: unknown token", ErrUnauthenticated)
+		}
+		call.Caller.Name = name
+		return nil
+	}, nil)
+}
+
+// Authorize returns middleware that only lets callers run the actions that
+// the policy allows them by name, like "vm.*" or "vm.list" or "*" for all
+// actions. Undoing an action is allowed to callers that may run it.
+func Authorize(policy map[string][]string) Middleware {
+	return Hook(func(call *Call) error {
+		for _, pattern := range policy[call.Caller.Name] {
+			if ok, _ := path.Match(pattern, call.Name()); ok {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %s may not run %s", ErrForbidden, callerName(call.Caller), call.Name())
+	}, nil)
+}
+
+// AuditLog returns middleware that logs every call with its caller, how long
+// it took and whether it failed. The params are not logged, as they can have
+// secrets.
+func AuditLog(logger *log.Logger) Middleware {
+	return func(next CallFunc) CallFunc {
+		return func(call *Call) (string, error) {
+			start := time.Now()
+			result, err := next(call)
+
+			kind := "run"
+			switch {
+			case call.Undo:
+				kind = "undo"
+			case call.Async:
+				kind = "job"
+			}
+			outcome := "ok"
+			if err != nil {
+				outcome = fmt.Sprintf("error=%q", err.Error())
+			}
+			logger.Printf("caller=%s transport=%s %s=%s duration=%s %s",
+				callerName(call.Caller), transportName(call.Caller), kind, call.Name(),
+				time.Since(start).Round(time.Microsecond), outcome)
+			return result, err
+		}
+	}
+}
+
+// RateLimit returns middleware that lets every caller run rate actions per
+// second on average, and burst at once. Callers are told apart by name.
+func RateLimit(rate float64, burst int) Middleware {
+	type bucket struct {
+		tokens float64
+		last   time.Time
+	}
+	var mu sync.Mutex
+	buckets := make(map[string]*bucket)
+
+	return Hook(func(call *Call) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		now := time.Now()
+		b, ok := buckets[call.Caller.Name]
+		if !ok {
+			b = &bucket{tokens: float64(burst), last: now}
+			buckets[call.Caller.Name] = b
+		}
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+		b.last = now
+		if b.tokens < 1 {
+			return fmt.Errorf("%w: %s may run %g actions per second", ErrRateLimited, callerName(call.Caller), rate)
+		}
+		b.tokens--
+		return nil
+	}, nil)
+}
+
+// ActionMetrics are the metrics of the calls of an action
+type ActionMetrics struct {
+	Action string        `json:"action"`
+	Calls  int           `json:"calls"`
+	Errors int           `json:"errors"`
+	Total  time.Duration `json:"total"`
+	Max    time.Duration `json:"max"`
+}
+
+// Metrics counts the calls of actions, their errors and how long they took
+type Metrics struct {
+	mu      sync.Mutex
+	actions map[string]*ActionMetrics
+}
+
+// NewMetrics creates metrics, which count the calls of the factories that
+// use their Middleware
+func NewMetrics() *Metrics {
+	return &Metrics{actions: make(map[string]*ActionMetrics)}
+}
+
+// Middleware returns the middleware that counts the calls
+func (m *Metrics) Middleware() Middleware {
+	return func(next CallFunc) CallFunc {
+		return func(call *Call) (string, error) {
+			start := time.Now()
+			result, err := next(call)
+			elapsed := time.Since(start)
+
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			metrics, ok := m.actions[call.Name()]
+			if !ok {
+				metrics = &ActionMetrics{Action: call.Name()}
+				m.actions[call.Name()] = metrics
+			}
+			metrics.Calls++
+			if err != nil {
+				metrics.Errors++
+			}
+			metrics.Total += elapsed
+			if elapsed > metrics.Max {
+				metrics.Max = elapsed
+			}
+			return result, err
+		}
+	}
+}
+
+// Snapshot returns the metrics of the actions that were called, sorted by
+// action
+func (m *Metrics) Snapshot() []ActionMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make([]ActionMetrics, 0, len(m.actions))
+	for _, metrics := range m.actions {
+		snapshot = append(snapshot, *metrics)
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].Action < snapshot[j].Action
+	})
+	return snapshot
+}
+
+// callerName returns the name of a caller for messages
+func callerName(caller Caller) string {
+	if caller.Name == "" {
+		return "anonymous"
+	}
+	return caller.Name
+}
+
+// transportName returns the transport of a caller for messages
+func transportName(caller Caller) string {
+	if caller.Transport == "" {
+		return "go"
+	}
+	return caller.Transport
+}
@@ -0,0 +1,105 @@
+package handlerfactory
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAuthenticateAuthorize(t *testing.T) {
+	f, _ := newTestFactory(t)
+	var mu sync.Mutex
+	var calls []string
+	f.Use(
+		Authenticate(map[string]string{"alice-secret": "alice", "bob-secret": "bob"}),
+		Authorize(map[string][]string{"alice": {"*"}, "bob": {"test.add", "plain.*"}}),
+		// Records the calls that were let through, with the names that
+		// Authenticate found for their tokens
+		Hook(func(call *Call) error {
+			kind := "run"
+			if call.Undo {
+				kind = "undo"
+			}
+			mu.Lock()
+			calls = append(calls, call.Caller.Name+" "+kind+" "+call.Name())
+			mu.Unlock()
+			return nil
+		}, nil),
+	)
+
+	tests := []struct {
+		name   string
+		token  string
+		script string
+		want   error
+	}{
+		{"no token", "", "!!test.add name:'a'", ErrUnauthenticated},
+		{"unknown token", "eve-secret", "!!test.add name:'a'", ErrUnauthenticated},
+		{"allowed action", "bob-secret", "!!test.add name:'a'", nil},
+		{"allowed by pattern", "bob-secret", "!!plain.add", nil},
+		{"forbidden action", "bob-secret", "!!test.fail", ErrForbidden},
+		{"all actions", "alice-secret", "!!test.add name:'a'\n!!plain.add", nil},
+	}
+	for _, test := range tests {
+		calls = nil
+		_, err := f.Run(test.script, RunOptions{Caller: Caller{Name: "10.0.0.5:4000", Transport: "telnet", Token: test.token}})
+		if test.want == nil && err != nil || test.want != nil && !errors.Is(err, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, err)
+		}
+		if test.want != nil && len(calls) != 0 {
+			t.Errorf("%s: expected no calls to be let through, got %q", test.name, calls)
+		}
+	}
+
+	// Callers are told they are forbidden by name
+	_, err := f.Run("!!test.fail", RunOptions{Caller: Caller{Token: "bob-secret"}})
+	if err == nil || !strings.Contains(err.Error(), "forbidden: bob may not run test.fail") {
+		t.Errorf("Expected bob to be forbidden to run test.fail, got %v", err)
+	}
+
+	// The rollback of the actions that ran before a forbidden one goes
+	// through the middleware too, as the caller
+	calls = nil
+	f.Run("!!test.add name:'a'\n!!test.fail", RunOptions{Caller: Caller{Token: "bob-secret"}})
+	if got := strings.Join(calls, ", "); got != "bob run test.add, bob undo test.add" {
+		t.Errorf("Unexpected calls %s", got)
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	f, _ := newTestFactory(t)
+	var order []string
+	trace := func(name string) Middleware {
+		return Hook(func(call *Call) error {
+			order = append(order, name+" before")
+			return nil
+		}, func(call *Call, result string, err error) {
+			order = append(order, name+" after: "+result)
+		})
+	}
+	f.Use(trace("first"), trace("second"))
+
+	if _, err := f.ProcessHeroscript("!!test.add name:'a'"); err != nil {
+		t.Fatalf("Failed to run action: %v", err)
+	}
+	if got := strings.Join(order, ", "); got != "first before, second before, second after: added a, first after: added a" {
+		t.Errorf("Expected the first middleware to wrap the second, got %s", got)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	f, _ := newTestFactory(t)
+	f.Use(RateLimit(0.001, 2))
+
+	for i, want := range []error{nil, nil, ErrRateLimited} {
+		_, err := f.Run("!!plain.add", RunOptions{Caller: Caller{Name: "alice"}})
+		if want == nil && err != nil || want != nil && !errors.Is(err, want) {
+			t.Errorf("Call %d: expected %v, got %v", i+1, want, err)
+		}
+	}
+	// Callers have their own limits
+	if _, err := f.Run("!!plain.add", RunOptions{Caller: Caller{Name: "bob"}}); err != nil {
+		t.Errorf("Expected bob not to be limited, got %v", err)
+	}
+}
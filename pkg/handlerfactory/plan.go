@@ -0,0 +1,182 @@
+package handlerfactory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+)
+
+// Planner is implemented by handlers that can tell what an action would
+// change without running it, like idempotent handlers that compare the
+// action with the current state
+type Planner interface {
+	// PlanAction returns the changes that the action would make, one per
+	// line, or "" if it would change nothing. The action is validated and
+	// has the defaults of its schema set.
+	PlanAction(action *playbook.Action) (string, error)
+}
+
+// PlanStep is an action that a playbook would run
+type PlanStep struct {
+	// ID is the ID of the action in the playbook, which foreach actions
+	// have a step per item with
+	ID     int    `json:"id"`
+	Actor  string `json:"actor"`
+	Name   string `json:"name"`
+	Script string `json:"script"`
+	// Condition is the if param that the step depends on
+	Condition string `json:"condition,omitempty"`
+	Async     bool   `json:"async,omitempty"`
+	// Planned is set when the handler is a Planner, which reported Changes
+	Planned bool   `json:"planned,omitempty"`
+	Changes string `json:"changes,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Plan is what a playbook would do, step by step
+type Plan struct {
+	Steps []PlanStep `json:"steps"`
+}
+
+// Plan returns what a heroscript would do, without running any action. The
+// includes of the script are resolved, foreach actions are expanded and
+// every action is validated against the schema of its handler, and handlers
+// that are a Planner report the changes of their actions. Errors of actions
+// are kept in their steps, so that all of them are reported; Plan only
+// fails if the script cannot be parsed or its control params are invalid.
+func (f *HandlerFactory) Plan(script string) (*Plan, error) {
+	pb, err := playbook.NewFromText(script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse heroscript: %v", err)
+	}
+	return f.PlanPlayBook(pb)
+}
+
+// PlanPlayBook returns what a playbook would do, like Plan
+func (f *HandlerFactory) PlanPlayBook(pb *playbook.PlayBook) (*Plan, error) {
+	planned, err := pb.Plan()
+	if err != nil {
+		return nil, err
+	}
+	if len(planned) == 0 {
+		return nil, fmt.Errorf("no actions found in script")
+	}
+
+	plan := &Plan{}
+	for _, p := range planned {
+		plan.Steps = append(plan.Steps, f.planStep(p))
+	}
+	return plan, nil
+}
+
+// planStep validates a planned action and asks its handler for its changes
+func (f *HandlerFactory) planStep(p playbook.PlannedAction) (step PlanStep) {
+	action := p.Action
+	step = PlanStep{
+		ID:        p.Source.ID,
+		Actor:     action.Actor,
+		Name:      action.Name,
+		Condition: p.Condition,
+		Async:     action.Params.GetBool(ParamAsync),
+	}
+	action.Params.Delete(ParamAsync)
+	// The script has the defaults that validation sets
+	defer func() { step.Script = formatAction(action) }()
+
+	handler, err := f.GetHandler(action.Actor)
+	if err != nil {
+		step.Error = err.Error()
+		return step
+	}
+	if err := ValidateAction(handler, action); err != nil {
+		step.Error = err.Error()
+		return step
+	}
+	if step.Async && f.jobs == nil {
+		step.Error = fmt.Sprintf("jobs are not enabled, so actions cannot run with %s", ParamAsync)
+		return step
+	}
+
+	if planner, ok := handler.(Planner); ok {
+		step.Planned = true
+		if step.Changes, err = planner.PlanAction(action); err != nil {
+			step.Error = err.Error()
+		}
+	}
+	return step
+}
+
+// formatAction returns an action on one line, with its params sorted
+func formatAction(action *playbook.Action) string {
+	params := action.Params.GetAll()
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	line := "!!" + action.Actor + "." + action.Name
+	for _, key := range keys {
+		line += fmt.Sprintf(" %s:'%s'", key, params[key])
+	}
+	return line
+}
+
+// Err returns an error if steps of the plan are invalid
+func (p *Plan) Err() error {
+	invalid := 0
+	for _, step := range p.Steps {
+		if step.Error != "" {
+			invalid++
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("plan has %d invalid actions of %d", invalid, len(p.Steps))
+	}
+	return nil
+}
+
+// String returns the plan as text, a block per step with its script and its
+// changes, and a summary:
+//
+//	1 !!vm.define cpu:'2' memory:'1GB' name:'web' storage:'10GB'
+//	    + vm web: 2 CPU, 1GB memory, 10GB storage
+//	2 !!vm.start name:'web'
+//	    if: defined
+//	    ~ vm web: stopped -> running
+//
+//	Plan: 2 actions, 2 to change, 0 unchanged, 0 unknown, 0 invalid.
+func (p *Plan) String() string {
+	var out strings.Builder
+	var change, unchanged, unknown, invalid int
+	for _, step := range p.Steps {
+		out.WriteString(fmt.Sprintf("%d %s\n", step.ID, step.Script))
+		if step.Condition != "" {
+			out.WriteString("    if: " + step.Condition + "\n")
+		}
+		if step.Async {
+			out.WriteString("    runs as a job\n")
+		}
+		switch {
+		case step.Error != "":
+			invalid++
+			out.WriteString("    error: " + step.Error + "\n")
+		case !step.Planned:
+			unknown++
+			out.WriteString("    changes unknown\n")
+		case step.Changes == "":
+			unchanged++
+			out.WriteString("    no changes\n")
+		default:
+			change++
+			for _, line := range strings.Split(strings.TrimRight(step.Changes, "\n"), "\n") {
+				out.WriteString("    " + line + "\n")
+			}
+		}
+	}
+	out.WriteString(fmt.Sprintf("\nPlan: %d actions, %d to change, %d unchanged, %d unknown, %d invalid.\n",
+		len(p.Steps), change, unchanged, unknown, invalid))
+	return out.String()
+}
@@ -0,0 +1,49 @@
+package repl
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/telnet"
+)
+
+// editor reads lines with the line editing, history, search and completion
+// of telnet.Editor when its input is a terminal, and line by line otherwise
+type editor struct {
+	*telnet.Editor
+	in       *bufio.Reader
+	fd       int
+	terminal bool
+}
+
+// newEditor creates an editor of the lines of in, which it echoes to out if
+// fd is a terminal, or -1 if in is not a terminal
+func newEditor(in io.Reader, out io.Writer, fd int) *editor {
+	reader := bufio.NewReader(in)
+	e := &editor{Editor: telnet.NewEditor(reader, out), in: reader, fd: fd, terminal: fd >= 0}
+	e.Width = func() int {
+		return terminalWidth(e.fd)
+	}
+	return e
+}
+
+// readLine reads a line after showing the prompt. It returns io.EOF for
+// Ctrl-D on an empty line or the end of the input, and telnet.ErrInterrupt
+// for Ctrl-C.
+func (e *editor) readLine(prompt string) (string, error) {
+	if !e.terminal {
+		line, err := e.in.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	state, err := makeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore(e.fd, state)
+	return e.ReadLine(prompt)
+}
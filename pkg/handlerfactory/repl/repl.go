@@ -0,0 +1,318 @@
+// Package repl is an interactive heroscript shell, which runs the scripts
+// that are entered with tab completion of the actors, actions and params of
+// the schemas of the handlers, multi-line scripts, history and colors.
+package repl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+	"github.com/freeflowuniverse/herolauncher/pkg/telnet"
+)
+
+// Config configures a REPL
+type Config struct {
+	// Run runs a script and returns its result
+	Run func(script string) (string, error)
+	// Plan returns what a script would do without running it, for the plan
+	// mode; the REPL has no plan mode if it is nil
+	Plan func(script string) (string, error)
+	// Actions are the actions that are completed, with their schemas
+	Actions []handlerfactory.ActionInfo
+	// HistoryFile keeps the lines that were entered across sessions, if set
+	HistoryFile string
+	// NoColor writes the output without colors, which are also left out
+	// when the output is not a terminal
+	NoColor bool
+	// In and Out are the input and output, os.Stdin and os.Stdout if nil.
+	// Lines are edited if In is a terminal.
+	In  io.Reader
+	Out io.Writer
+}
+
+// REPL reads heroscripts, runs them and prints their results
+type REPL struct {
+	config    Config
+	editor    *editor
+	completer *handlerfactory.Completer
+	// pending are the lines of a script that is not complete yet
+	pending strings.Builder
+	// last is the last script that ran, which :edit edits
+	last string
+	plan bool
+}
+
+// commands are the commands of the REPL, and actorCommands those whose
+// argument is an actor
+var (
+	commands      = []string{":actions", ":edit", ":help", ":history", ":plan", ":quit"}
+	actorCommands = []string{":actions"}
+)
+
+// New creates a REPL
+func New(config Config) *REPL {
+	if config.In == nil {
+		config.In = os.Stdin
+	}
+	if config.Out == nil {
+		config.Out = os.Stdout
+	}
+
+	fd := -1
+	if file, ok := config.In.(*os.File); ok && isTerminal(int(file.Fd())) {
+		fd = int(file.Fd())
+	}
+	r := &REPL{
+		config:    config,
+		editor:    newEditor(config.In, config.Out, fd),
+		completer: &handlerfactory.Completer{Actions: config.Actions, Commands: commands, ActorCommands: actorCommands},
+	}
+	if file, ok := config.Out.(*os.File); !ok || !isTerminal(int(file.Fd())) {
+		r.config.NoColor = true
+	}
+	r.editor.Complete = func(text string) ([]string, int) {
+		return r.completer.Complete(r.pending.String(), text)
+	}
+	r.loadHistory()
+	return r
+}
+
+// Run reads and runs scripts until the input ends, Ctrl-D is pressed or
+// :quit is entered. A script runs when its line is entered, unless the line
+// ends with a backslash or a quote is still open, which continue the script
+// on the next line. Ctrl-C drops the script that is entered.
+func (r *REPL) Run() error {
+	if r.editor.terminal {
+		r.printf(handlerfactory.ColorCyan, "Heroscript REPL. Tab completes actions and params, :help shows the commands.\n")
+	}
+	for {
+		line, err := r.editor.readLine(r.prompt())
+		switch {
+		case errors.Is(err, telnet.ErrInterrupt):
+			r.pending.Reset()
+			continue
+		case err == io.EOF:
+			return nil
+		case err != nil:
+			return err
+		}
+
+		if strings.TrimSpace(line) != "" {
+			r.addHistory(line)
+		}
+		if r.pending.Len() == 0 {
+			trimmed := strings.TrimSpace(line)
+			if trimmed == "" {
+				continue
+			}
+			if strings.HasPrefix(trimmed, ":") {
+				if quit := r.command(trimmed); quit {
+					return nil
+				}
+				continue
+			}
+		}
+
+		if continued, ok := strings.CutSuffix(line, `\`); ok {
+			r.pending.WriteString(continued + "\n")
+			continue
+		}
+		r.pending.WriteString(line)
+		if strings.Count(r.pending.String(), "'")%2 == 1 {
+			r.pending.WriteString("\n")
+			continue
+		}
+
+		script := r.pending.String()
+		r.pending.Reset()
+		r.execute(script)
+	}
+}
+
+// prompt returns the prompt of the next line
+func (r *REPL) prompt() string {
+	prompt, color := "hero> ", handlerfactory.ColorCyan
+	if r.plan {
+		prompt, color = "plan> ", handlerfactory.ColorYellow
+	}
+	if r.pending.Len() > 0 {
+		prompt = "....> "
+	}
+	if r.config.NoColor {
+		return prompt
+	}
+	return color + prompt + handlerfactory.ColorReset
+}
+
+// execute runs or plans a script and prints its result
+func (r *REPL) execute(script string) {
+	r.last = script
+	run := r.config.Run
+	if r.plan {
+		run = r.config.Plan
+	}
+	result, err := run(script)
+	if err != nil {
+		r.printf(handlerfactory.ColorRed, "Error: %v\n", err)
+		return
+	}
+	if result != "" {
+		r.printf(handlerfactory.ColorGreen, "%s\n", strings.TrimRight(result, "\n"))
+	}
+}
+
+// command runs a command of the REPL and reports whether it quits
+func (r *REPL) command(line string) bool {
+	command, arg, _ := strings.Cut(line, " ")
+	switch command {
+	case ":quit", ":q", ":exit":
+		return true
+	case ":help":
+		r.printf("", "%s", help)
+	case ":actions":
+		r.printActions(strings.TrimSpace(arg))
+	case ":history":
+		for i, entry := range r.editor.History() {
+			r.printf("", "%4d  %s\n", i+1, entry)
+		}
+	case ":edit":
+		script, err := r.edit(r.last)
+		if err != nil {
+			r.printf(handlerfactory.ColorRed, "Error: %v\n", err)
+		} else if strings.TrimSpace(script) != "" {
+			r.printf("", "%s\n", strings.TrimRight(script, "\n"))
+			r.execute(script)
+		}
+	case ":plan":
+		if r.config.Plan == nil {
+			r.printf(handlerfactory.ColorRed, "Error: scripts cannot be planned here\n")
+			break
+		}
+		r.plan = !r.plan
+		if r.plan {
+			r.printf(handlerfactory.ColorYellow, "Plan mode enabled. Scripts are planned, not run.\n")
+		} else {
+			r.printf(handlerfactory.ColorYellow, "Plan mode disabled. Scripts are run.\n")
+		}
+	default:
+		r.printf(handlerfactory.ColorRed, "Error: unknown command %s, :help shows the commands\n", command)
+	}
+	return false
+}
+
+// help is the help of the REPL
+const help = `Enter heroscript actions, like !!vm.list, to run them.
+Tab completes actors, actions, params and the values of enum and bool params.
+A line that ends with \ or has an open quote continues on the next line.
+
+Commands:
+  :actions [actor]  Show the actions and their params
+  :edit             Edit the last script in $EDITOR and run it
+  :history          Show the lines that were entered
+  :plan             Toggle plan mode, which shows what scripts would do
+  :help             Show this help
+  :quit             Quit, like Ctrl-D
+
+Keys: arrows, Ctrl-A/E, Ctrl-K/U/W, Ctrl-P/N for history, Ctrl-R searches it,
+Ctrl-L clears the screen, Ctrl-C drops the script.
+`
+
+// printActions prints the actions, or those of an actor, with their params:
+// required params are marked with *, and the types and defaults are shown
+func (r *REPL) printActions(actor string) {
+	for _, action := range r.config.Actions {
+		if actor != "" && action.Actor != actor {
+			continue
+		}
+		r.printf(handlerfactory.ColorCyan, "!!%s.%s", action.Actor, action.Name)
+		var params []string
+		for _, param := range action.Schema.Params {
+			params = append(params, param.Usage())
+		}
+		if len(params) > 0 {
+			r.printf("", " %s", strings.Join(params, " "))
+		}
+		if action.Schema.Description != "" {
+			r.printf(handlerfactory.ColorBlue, "  %s", action.Schema.Description)
+		}
+		r.printf("", "\n")
+	}
+}
+
+// edit edits a script in the editor of $EDITOR, vi by default, and returns
+// the edited script
+func (r *REPL) edit(script string) (string, error) {
+	file, err := os.CreateTemp("", "hero-*.hero")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(script); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	editorCommand := os.Getenv("EDITOR")
+	if editorCommand == "" {
+		editorCommand = "vi"
+	}
+	cmd := exec.Command("sh", "-c", editorCommand+` "$1"`, "sh", file.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("editor failed: %v", err)
+	}
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// printf prints in a color, unless colors are disabled
+func (r *REPL) printf(color, format string, args ...interface{}) {
+	text := fmt.Sprintf(format, args...)
+	if color != "" && !r.config.NoColor {
+		text = color + text + handlerfactory.ColorReset
+	}
+	fmt.Fprint(r.config.Out, text)
+}
+
+// loadHistory reads the history file, if there is one
+func (r *REPL) loadHistory() {
+	if r.config.HistoryFile == "" {
+		return
+	}
+	data, err := os.ReadFile(r.config.HistoryFile)
+	if err != nil {
+		return
+	}
+	var history []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			history = append(history, line)
+		}
+	}
+	r.editor.SetHistory(history)
+}
+
+// addHistory adds a line to the history and the history file, which keeps
+// the last telnet.MaxHistory lines
+func (r *REPL) addHistory(line string) {
+	history := r.editor.History()
+	if len(history) > 0 && history[len(history)-1] == line {
+		return
+	}
+	r.editor.AddHistory(line)
+
+	if r.config.HistoryFile != "" {
+		os.WriteFile(r.config.HistoryFile, []byte(strings.Join(r.editor.History(), "\n")+"\n"), 0600)
+	}
+}
@@ -0,0 +1,185 @@
+package repl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+)
+
+// testActions are the actions of the vm actor that the tests complete
+var testActions = []handlerfactory.ActionInfo{
+	{Actor: "vm", Name: "define", HasSchema: true, Schema: handlerfactory.ActionSchema{
+		Description: "Define a VM",
+		Params: []handlerfactory.ParamSchema{
+			{Name: "name", Required: true},
+			{Name: "cpu", Type: handlerfactory.ParamTypeInt, Default: "1"},
+		},
+	}},
+	{Actor: "vm", Name: "list"},
+	{Actor: "disk", Name: "add"},
+}
+
+// fakeRunner records the scripts that a REPL runs and plans, and fails
+// scripts with vm.fail
+type fakeRunner struct {
+	ran     []string
+	planned []string
+}
+
+func (f *fakeRunner) run(script string) (string, error) {
+	if strings.Contains(script, "!!vm.fail") {
+		return "", errors.New("vm failed")
+	}
+	f.ran = append(f.ran, script)
+	return "ran " + strings.ReplaceAll(script, "\n", " "), nil
+}
+
+func (f *fakeRunner) plan(script string) (string, error) {
+	f.planned = append(f.planned, script)
+	return "would run " + script, nil
+}
+
+// runREPL runs a REPL on input, and returns its output
+func runREPL(t *testing.T, config Config, input string) string {
+	t.Helper()
+	var out strings.Builder
+	config.In, config.Out = strings.NewReader(input), &out
+	if config.Actions == nil {
+		config.Actions = testActions
+	}
+	if err := New(config).Run(); err != nil {
+		t.Fatalf("Failed to run: %v", err)
+	}
+	return out.String()
+}
+
+func TestRun(t *testing.T) {
+	runner := &fakeRunner{}
+	input := `!!vm.define name:'a'
+:plan
+!!vm.define name:'p'
+:plan
+!!vm.define name:'b' \
+  cpu:2
+!!vm.define name:'multi
+line'
+!!vm.fail
+
+:nope
+:actions vm
+:quit
+!!vm.define name:'after'
+`
+	out := runREPL(t, Config{Run: runner.run, Plan: runner.plan}, input)
+
+	// Lines that end with a backslash or have an open quote continue the
+	// script, and nothing runs after :quit
+	wantRan := []string{"!!vm.define name:'a'", "!!vm.define name:'b' \n  cpu:2", "!!vm.define name:'multi\nline'"}
+	if !reflect.DeepEqual(runner.ran, wantRan) {
+		t.Errorf("Expected %q to run, got %q", wantRan, runner.ran)
+	}
+	if !reflect.DeepEqual(runner.planned, []string{"!!vm.define name:'p'"}) {
+		t.Errorf("Expected a script to be planned, got %q", runner.planned)
+	}
+	for _, want := range []string{
+		"ran !!vm.define name:'a'\n",
+		"Plan mode enabled. Scripts are planned, not run.\nwould run !!vm.define name:'p'\nPlan mode disabled. Scripts are run.\n",
+		"ran !!vm.define name:'b'    cpu:2\n",
+		"Error: vm failed\n",
+		"Error: unknown command :nope, :help shows the commands\n",
+		"!!vm.define name* cpu:int=1  Define a VM\n!!vm.list\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected the output to contain %q, got:\n%s", want, out)
+		}
+	}
+	// The output is not a terminal
+	if strings.Contains(out, "\x1b[") || strings.Contains(out, "!!disk.add") {
+		t.Errorf("Expected the actions of vm without colors, got:\n%s", out)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	runner := &fakeRunner{}
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{":help\n", help},
+		{":actions\n", "!!vm.list\n!!disk.add\n"},
+		{":plan\n!!vm.list\n", "Error: scripts cannot be planned here\nran !!vm.list\n"},
+		{":history\n", "   1  :history\n"},
+		{"!!vm.list\n:exit\n:actions\n", "ran !!vm.list\n"},
+	}
+	for _, test := range tests {
+		if out := runREPL(t, Config{Run: runner.run}, test.input); !strings.HasSuffix(out, test.want) {
+			t.Errorf("%q: expected the output to end with %q, got:\n%s", test.input, test.want, out)
+		}
+	}
+}
+
+func TestEdit(t *testing.T) {
+	runner := &fakeRunner{}
+	t.Setenv("EDITOR", "sed -i s/one/two/")
+	out := runREPL(t, Config{Run: runner.run}, "!!vm.define name:'one'\n:edit\n")
+	if want := []string{"!!vm.define name:'one'", "!!vm.define name:'two'"}; !reflect.DeepEqual(runner.ran, want) {
+		t.Errorf("Expected the edited script to run, got %q", runner.ran)
+	}
+	if !strings.Contains(out, "!!vm.define name:'two'\nran !!vm.define name:'two'\n") {
+		t.Errorf("Expected the edited script to be shown, got:\n%s", out)
+	}
+
+	t.Setenv("EDITOR", "false")
+	if out := runREPL(t, Config{Run: runner.run}, ":edit\n"); out != "Error: editor failed: exit status 1\n" {
+		t.Errorf("Expected the editor to fail, got %q", out)
+	}
+}
+
+func TestHistoryFile(t *testing.T) {
+	runner := &fakeRunner{}
+	historyFile := filepath.Join(t.TempDir(), "history")
+	os.WriteFile(historyFile, []byte("!!vm.define name:'old'\n"), 0600)
+
+	// Lines that repeat the last one are not added again
+	out := runREPL(t, Config{Run: runner.run, HistoryFile: historyFile}, "!!vm.list\n!!vm.list\n\n:history\n")
+	if !strings.Contains(out, "   1  !!vm.define name:'old'\n   2  !!vm.list\n   3  :history\n") {
+		t.Errorf("Expected the history with the lines of the file, got:\n%s", out)
+	}
+	data, err := os.ReadFile(historyFile)
+	if err != nil {
+		t.Fatalf("Failed to read the history file: %v", err)
+	}
+	if want := "!!vm.define name:'old'\n!!vm.list\n:history\n"; string(data) != want {
+		t.Errorf("Expected the history file %q, got %q", want, data)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	r := New(Config{Actions: testActions, In: strings.NewReader(""), Out: &strings.Builder{}})
+	tests := []struct {
+		pending string
+		text    string
+		want    []string
+		start   int
+	}{
+		{"", ":a", []string{":actions"}, 0},
+		{"", ":actions v", []string{"vm"}, 9},
+		{"", "!!vm.d", []string{"!!vm.define "}, 0},
+		{"", "!!vm.define name:'a' c", []string{"cpu:"}, 21},
+		// The params of the lines that were entered are left out
+		{"!!vm.define name:'a' \n", "  ", []string{"cpu:"}, 2},
+	}
+	for _, test := range tests {
+		r.pending.Reset()
+		r.pending.WriteString(test.pending)
+		got, start := r.editor.Complete(test.text)
+		if !reflect.DeepEqual(got, test.want) || start != test.start {
+			t.Errorf("%q after %q: expected %v at %d, got %v at %d", test.text, test.pending, test.want, test.start, got, start)
+		}
+	}
+}
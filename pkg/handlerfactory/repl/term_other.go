@@ -0,0 +1,29 @@
+//go:build !linux && !darwin
+
+package repl
+
+import "errors"
+
+// termState is the state of a terminal before it was put in raw mode
+type termState struct{}
+
+// isTerminal reports whether fd is a terminal; line editing is only
+// supported on Linux and macOS, so input is read line by line elsewhere
+func isTerminal(fd int) bool {
+	return false
+}
+
+// makeRaw is not supported on this platform
+func makeRaw(fd int) (*termState, error) {
+	return nil, errors.New("raw terminal mode is not supported on this platform")
+}
+
+// restore is not supported on this platform
+func restore(fd int, state *termState) error {
+	return nil
+}
+
+// terminalWidth returns the default number of columns
+func terminalWidth(fd int) int {
+	return 80
+}
@@ -0,0 +1,53 @@
+//go:build linux || darwin
+
+package repl
+
+import "golang.org/x/sys/unix"
+
+// termState is the state of a terminal before it was put in raw mode
+type termState struct {
+	termios unix.Termios
+}
+
+// isTerminal reports whether fd is a terminal
+func isTerminal(fd int) bool {
+	_, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	return err == nil
+}
+
+// makeRaw puts a terminal in raw mode, in which keys are read one by one
+// without echo and Ctrl-C is read as key, and returns the state to restore.
+// The output is processed as before, so new lines still return the cursor.
+func makeRaw(fd int) (*termState, error) {
+	termios, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+	state := &termState{termios: *termios}
+
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	termios.Cflag &^= unix.CSIZE | unix.PARENB
+	termios.Cflag |= unix.CS8
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, termios); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// restore restores the state of a terminal
+func restore(fd int, state *termState) error {
+	return unix.IoctlSetTermios(fd, ioctlSetTermios, &state.termios)
+}
+
+// terminalWidth returns the number of columns of a terminal, or 80 if it is
+// not known
+func terminalWidth(fd int) int {
+	size, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil || size.Col == 0 {
+		return 80
+	}
+	return int(size.Col)
+}
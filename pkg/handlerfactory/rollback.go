@@ -0,0 +1,82 @@
+package handlerfactory
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+)
+
+// Undoer is implemented by handlers that can undo their actions, which the
+// HandlerFactory rolls back when a later action of a script fails
+type Undoer interface {
+	// UndoAction undoes an action that ran and returns what was undone. The
+	// action has the defaults of its schema set.
+	UndoAction(action *playbook.Action) (string, error)
+}
+
+// RunOptions are the options of running a heroscript
+type RunOptions struct {
+	// NoRollback keeps the actions that ran when a later action fails,
+	// instead of undoing the ones whose handler is an Undoer
+	NoRollback bool
+	// Caller is who runs the script, which the middleware of the factory
+	// checks
+	Caller Caller
+}
+
+// undoFunc returns the function that undoes the action of a call that ran,
+// through the middleware, or nil if its handler cannot undo it. Jobs are not
+// undone, as they may still run.
+func (f *HandlerFactory) undoFunc(call *Call) playbook.UndoFunc {
+	if _, ok := call.Handler.(Undoer); !ok || call.Async {
+		return nil
+	}
+	return func() (string, error) {
+		return f.call(&Call{Caller: call.Caller, Handler: call.Handler, Action: call.Action, Undo: true}, undoCall)
+	}
+}
+
+// undoCall undoes the action of a call, whose handler is an Undoer
+func undoCall(call *Call) (string, error) {
+	return call.Handler.(Undoer).UndoAction(call.Action)
+}
+
+// Undo undoes the actions of a heroscript in reverse order, as if they ran,
+// and returns what was undone, for the caller of the options. It is how
+// clients that run the actions of a script one by one roll them back.
+func (f *HandlerFactory) Undo(script string, options RunOptions) (string, error) {
+	pb, err := playbook.NewFromText(script)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse heroscript: %v", err)
+	}
+	if len(pb.Actions) == 0 {
+		return "", fmt.Errorf("no actions found in script")
+	}
+	actions, err := pb.ActionsSorted(false)
+	if err != nil {
+		return "", err
+	}
+
+	var results []string
+	for i := len(actions) - 1; i >= 0; i-- {
+		action := actions[i]
+		handler, err := f.GetHandler(action.Actor)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := handler.(Undoer); !ok {
+			return "", fmt.Errorf("action %s.%s cannot be undone", action.Actor, action.Name)
+		}
+		action.Params.Delete(ParamAsync)
+		if err := ValidateAction(handler, action); err != nil {
+			return "", fmt.Errorf("action %s.%s: %w", action.Actor, action.Name, err)
+		}
+		result, err := f.call(&Call{Caller: options.Caller, Handler: handler, Action: action, Undo: true}, undoCall)
+		if err != nil {
+			return "", fmt.Errorf("action %s.%s: %w", action.Actor, action.Name, err)
+		}
+		results = append(results, result)
+	}
+	return strings.Join(results, "\n"), nil
+}
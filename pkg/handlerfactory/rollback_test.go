@@ -0,0 +1,79 @@
+package handlerfactory
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+)
+
+func TestRollback(t *testing.T) {
+	script := `!!test.add name:'a'
+!!plain.add
+!!test.add name:'b'
+!!test.fail
+!!test.add name:'c'`
+
+	// The actions that ran before the failing one are undone, the last one
+	// first, and the ones that cannot be undone are reported
+	f, h := newTestFactory(t)
+	_, err := f.ProcessHeroscript(script)
+	var rollbackErr *playbook.RollbackError
+	if !errors.As(err, &rollbackErr) {
+		t.Fatalf("Expected a rollback error, got %v", err)
+	}
+	if !strings.Contains(rollbackErr.Err.Error(), "failed on purpose") {
+		t.Errorf("Expected the error of the failing action, got %v", rollbackErr.Err)
+	}
+	var undone []string
+	for _, action := range rollbackErr.Undone {
+		result := action.Undone
+		if action.Irreversible {
+			result = "irreversible"
+		}
+		undone = append(undone, action.Action.Actor+"."+action.Action.Name+": "+result)
+	}
+	if got := strings.Join(undone, ", "); got != "test.add: removed b, plain.add: irreversible, test.add: removed a" {
+		t.Errorf("Unexpected rollback %s", got)
+	}
+	if got := strings.Join(h.actions(), ", "); got != "add a, add b, fail, undo add b, undo add a" {
+		t.Errorf("Unexpected actions %s", got)
+	}
+
+	// Without rollback the actions stay done
+	f, h = newTestFactory(t)
+	if _, err := f.Run(script, RunOptions{NoRollback: true}); err == nil || errors.As(err, &rollbackErr) {
+		t.Errorf("Expected the error of the failing action only, got %v", err)
+	}
+	if got := strings.Join(h.actions(), ", "); got != "add a, add b, fail" {
+		t.Errorf("Unexpected actions %s", got)
+	}
+
+	// Scripts that succeed are not rolled back
+	f, h = newTestFactory(t)
+	if result, err := f.ProcessHeroscript("!!test.add name:'a'\n!!test.add name:'b'"); err != nil || result != "added a\nadded b" {
+		t.Errorf("Unexpected result %q, %v", result, err)
+	}
+	if got := strings.Join(h.actions(), ", "); got != "add a, add b" {
+		t.Errorf("Unexpected actions %s", got)
+	}
+}
+
+func TestUndo(t *testing.T) {
+	f, h := newTestFactory(t)
+	result, err := f.Undo("!!test.add name:'a'\n!!test.add name:'b'", RunOptions{})
+	if err != nil || result != "removed b\nremoved a" {
+		t.Errorf("Expected the actions to be undone in reverse order, got %q, %v", result, err)
+	}
+	if got := strings.Join(h.actions(), ", "); got != "undo add b, undo add a" {
+		t.Errorf("Unexpected actions %s", got)
+	}
+
+	if _, err := f.Undo("!!test.add name:'a'\n!!plain.add", RunOptions{}); err == nil || !strings.Contains(err.Error(), "plain.add cannot be undone") {
+		t.Errorf("Expected actions of handlers that cannot undo to be rejected, got %v", err)
+	}
+	if _, err := f.Undo("!!other.add", RunOptions{}); err == nil {
+		t.Error("Expected actions of unknown actors to be rejected")
+	}
+}
@@ -0,0 +1,276 @@
+package handlerfactory
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+)
+
+// ParamType is the type of the value of a param
+type ParamType string
+
+const (
+	ParamTypeString ParamType = "string"
+	ParamTypeInt    ParamType = "int"
+	ParamTypeFloat  ParamType = "float"
+	ParamTypeBool   ParamType = "bool"
+)
+
+// boolValues are the values of bool params, which the ParamsParser reads as
+// true or false
+var boolValues = map[string]bool{
+	"true": true, "yes": true, "1": true, "on": true,
+	"false": true, "no": true, "0": true, "off": true,
+}
+
+// ParamSchema describes a param of an action
+type ParamSchema struct {
+	Name string `json:"name"`
+	// Type is the type of the value, a string if it is empty
+	Type     ParamType `json:"type,omitempty"`
+	Required bool      `json:"required,omitempty"`
+	// Enum are the values the param can have, if it is not empty, in any
+	// case
+	Enum []string `json:"enum,omitempty"`
+	// Default is set as the value of the param if it is not given
+	Default string `json:"default,omitempty"`
+	// Verbatim params, like commands or paths, are validated as they were
+	// written instead of normalized by the parser, and handlers read them
+	// with GetVerbatim or a hero tag with the verbatim option
+	Verbatim    bool   `json:"verbatim,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// ActionSchema describes the params of an action
+type ActionSchema struct {
+	Description string        `json:"description,omitempty"`
+	Params      []ParamSchema `json:"params"`
+	// AllowUnknown accepts params that are not in Params, which are an error
+	// otherwise as they are usually typos
+	AllowUnknown bool `json:"allow_unknown,omitempty"`
+}
+
+// SchemaProvider is implemented by handlers that declare the schemas of
+// their actions, by action name. The HandlerFactory validates the actions
+// that have a schema before they are dispatched to the handler.
+type SchemaProvider interface {
+	ActionSchemas() map[string]ActionSchema
+}
+
+// ActionInfo is an action of a handler of a factory with its schema
+type ActionInfo struct {
+	Actor  string       `json:"actor"`
+	Name   string       `json:"name"`
+	Schema ActionSchema `json:"schema"`
+	// HasSchema is set for actions whose handler has a schema for them; the
+	// params of the others are not known
+	HasSchema bool `json:"has_schema,omitempty"`
+}
+
+// Actions returns the actions of the handlers of the factory with their
+// schemas, sorted by actor and name
+func (f *HandlerFactory) Actions() []ActionInfo {
+	var actions []ActionInfo
+	for actor, names := range f.GetSupportedActions() {
+		var schemas map[string]ActionSchema
+		if provider, ok := f.handlers[actor].(SchemaProvider); ok {
+			schemas = provider.ActionSchemas()
+		}
+		for _, name := range names {
+			schema, ok := schemas[name]
+			actions = append(actions, ActionInfo{Actor: actor, Name: name, Schema: schema, HasSchema: ok})
+		}
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		if actions[i].Actor != actions[j].Actor {
+			return actions[i].Actor < actions[j].Actor
+		}
+		return actions[i].Name < actions[j].Name
+	})
+	return actions
+}
+
+// ParamError is a param of an action that does not match its schema
+type ParamError struct {
+	Param   string `json:"param"`
+	Message string `json:"message"`
+}
+
+// ValidationError is an action whose params do not match its schema, with
+// an error for every param
+type ValidationError struct {
+	Actor  string       `json:"actor"`
+	Action string       `json:"action"`
+	Errors []ParamError `json:"errors"`
+}
+
+// Error implements the error interface
+func (e *ValidationError) Error() string {
+	var messages []string
+	for _, paramError := range e.Errors {
+		messages = append(messages, paramError.Param+": "+paramError.Message)
+	}
+	return "invalid params: " + strings.Join(messages, "; ")
+}
+
+// ValidateAction validates the params of an action against the schema that
+// the handler declares for it, and sets the defaults of params that are not
+// given. Actions without a schema are not validated.
+func ValidateAction(handler Handler, action *playbook.Action) error {
+	provider, ok := handler.(SchemaProvider)
+	if !ok {
+		return nil
+	}
+	schema, ok := provider.ActionSchemas()[action.Name]
+	if !ok {
+		return nil
+	}
+
+	errs := schema.Validate(action)
+	if len(errs) > 0 {
+		return &ValidationError{Actor: action.Actor, Action: action.Name, Errors: errs}
+	}
+	return nil
+}
+
+// Validate validates the params of an action against the schema, and sets
+// the defaults of params that are not given
+func (s ActionSchema) Validate(action *playbook.Action) []ParamError {
+	var errs []ParamError
+	known := make(map[string]bool)
+	for _, param := range s.Params {
+		known[param.Name] = true
+		if !action.Params.Has(param.Name) {
+			if param.Required {
+				errs = append(errs, ParamError{Param: param.Name, Message: "is required"})
+			} else if param.Default != "" {
+				action.Params.Set(param.Name, param.Default)
+			}
+			continue
+		}
+		value := action.Params.Get(param.Name)
+		if param.Verbatim {
+			value = action.Params.GetVerbatim(param.Name)
+		}
+		if message := param.check(value); message != "" {
+			errs = append(errs, ParamError{Param: param.Name, Message: message})
+		}
+	}
+
+	if !s.AllowUnknown {
+		params := action.Params.GetAll()
+		names := make([]string, 0, len(params))
+		for name := range params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if known[name] {
+				continue
+			}
+			message := "is not a param of the action"
+			if similar := s.similarParam(name); similar != "" {
+				message += fmt.Sprintf(", did you mean %s?", similar)
+			}
+			errs = append(errs, ParamError{Param: name, Message: message})
+		}
+	}
+	return errs
+}
+
+// Usage returns the param as help shows it: its name, marked with * if it is
+// required, with its type if it is not a string, its values if it has an
+// enum and its default, like cpu:int=1 or type:SSD|HDD=HDD
+func (p ParamSchema) Usage() string {
+	usage := p.Name
+	if p.Required {
+		usage += "*"
+	}
+	if p.Type != "" && p.Type != ParamTypeString {
+		usage += ":" + string(p.Type)
+	}
+	if len(p.Enum) > 0 {
+		usage += ":" + strings.Join(p.Enum, "|")
+	}
+	if p.Default != "" {
+		usage += "=" + p.Default
+	}
+	return usage
+}
+
+// check returns why a value does not match the param, or "" if it does
+func (p ParamSchema) check(value string) string {
+	switch p.Type {
+	case ParamTypeInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Sprintf("must be an int, got '%s'", value)
+		}
+	case ParamTypeFloat:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Sprintf("must be a float, got '%s'", value)
+		}
+	case ParamTypeBool:
+		if !boolValues[strings.ToLower(value)] {
+			return fmt.Sprintf("must be a bool, got '%s'", value)
+		}
+	}
+
+	// Values are compared ignoring case, as most are normalized to lower
+	// case by the parser
+	if len(p.Enum) > 0 {
+		for _, allowed := range p.Enum {
+			if strings.EqualFold(value, allowed) {
+				return ""
+			}
+		}
+		return fmt.Sprintf("must be one of %s, got '%s'", strings.Join(p.Enum, ", "), value)
+	}
+	return ""
+}
+
+// similarParam returns the param of the schema that name is most likely a
+// typo of, or "" if there is none
+func (s ActionSchema) similarParam(name string) string {
+	names := make([]string, len(s.Params))
+	for i, param := range s.Params {
+		names[i] = param.Name
+	}
+	return similarName(name, names)
+}
+
+// similarName returns the name of names that name is most likely a typo of,
+// or "" if there is none
+func similarName(name string, names []string) string {
+	similar, best := "", 3
+	for _, candidate := range names {
+		if distance := editDistance(name, candidate); distance < best {
+			similar, best = candidate, distance
+		}
+	}
+	return similar
+}
+
+// editDistance returns the number of characters to insert, delete or
+// replace to change a into b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,166 @@
+package handlerfactory
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+)
+
+// diskSchema is the schema of the create action of the schema handler
+var diskSchema = ActionSchema{
+	Description: "Create a disk",
+	Params: []ParamSchema{
+		{Name: "name", Required: true},
+		{Name: "size", Type: ParamTypeInt, Required: true},
+		{Name: "ratio", Type: ParamTypeFloat},
+		{Name: "encrypted", Type: ParamTypeBool},
+		{Name: "type", Enum: []string{"SSD", "HDD"}, Default: "HDD"},
+		{Name: "mount", Verbatim: true, Enum: []string{"/mnt/Data Disk"}},
+	},
+}
+
+// schemaHandler is a handler with a schema for create but not for list
+type schemaHandler struct {
+	BaseHandler
+	created []string
+}
+
+// ActionSchemas implements SchemaProvider
+func (h *schemaHandler) ActionSchemas() map[string]ActionSchema {
+	return map[string]ActionSchema{"create": diskSchema}
+}
+
+// Create creates a disk
+func (h *schemaHandler) Create(script string) string {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	h.created = append(h.created, params.Get("name"))
+	return "created " + params.Get("name") + " of type " + params.Get("type")
+}
+
+// List lists the disks
+func (h *schemaHandler) List(script string) string {
+	return strings.Join(h.created, ",")
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		script string
+		errors string
+	}{
+		{"!!disk.create name:'data' size:10", ""},
+		{"!!disk.create name:'data' size:10 ratio:1.5 encrypted:yes type:ssd mount:'/mnt/Data Disk'", ""},
+		{"!!disk.create size:10", "name: is required"},
+		{"!!disk.create", "name: is required; size: is required"},
+		{"!!disk.create name:'data' size:ten", "size: must be an int, got 'ten'"},
+		{"!!disk.create name:'data' size:10 ratio:half", "ratio: must be a float, got 'half'"},
+		{"!!disk.create name:'data' size:10 encrypted:maybe", "encrypted: must be a bool, got 'maybe'"},
+		{"!!disk.create name:'data' size:10 type:nvme", "type: must be one of SSD, HDD, got 'nvme'"},
+		// Verbatim params are checked as they were written
+		{"!!disk.create name:'data' size:10 mount:'/mnt/Other Disk'", "mount: must be one of /mnt/Data Disk, got '/mnt/Other Disk'"},
+		// Unknown params are reported in order, with the param they are a
+		// typo of
+		{"!!disk.create name:'data' size:10 tpye:ssd color:red", "color: is not a param of the action; tpye: is not a param of the action, did you mean type?"},
+	}
+	for _, test := range tests {
+		pb, err := playbook.NewFromText(test.script)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", test.script, err)
+		}
+		var messages []string
+		for _, paramError := range diskSchema.Validate(pb.Actions[0]) {
+			messages = append(messages, paramError.Param+": "+paramError.Message)
+		}
+		if got := strings.Join(messages, "; "); got != test.errors {
+			t.Errorf("%s: expected %q, got %q", test.script, test.errors, got)
+		}
+	}
+
+	// Defaults are set for params that are not given, unknown params are
+	// accepted on request
+	pb, _ := playbook.NewFromText("!!disk.create name:'data' size:10 color:red")
+	schema := diskSchema
+	schema.AllowUnknown = true
+	if errs := schema.Validate(pb.Actions[0]); len(errs) != 0 {
+		t.Errorf("Expected unknown params to be accepted, got %+v", errs)
+	}
+	if got := pb.Actions[0].Params.Get("type"); got != "HDD" {
+		t.Errorf("Expected the default type, got %q", got)
+	}
+}
+
+func TestValidateAction(t *testing.T) {
+	f := NewHandlerFactory()
+	h := &schemaHandler{BaseHandler: BaseHandler{ActorName: "disk"}}
+	if err := f.RegisterHandler(h); err != nil {
+		t.Fatalf("Failed to register handler: %v", err)
+	}
+
+	// An invalid action is not dispatched to the handler
+	_, err := f.ProcessHeroscript("!!disk.create name:'data' size:ten")
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a validation error, got %v", err)
+	}
+	if validationErr.Actor != "disk" || validationErr.Action != "create" || len(validationErr.Errors) != 1 {
+		t.Errorf("Expected the error of the size of disk.create, got %+v", validationErr)
+	}
+	if validationErr.Error() != "invalid params: size: must be an int, got 'ten'" {
+		t.Errorf("Unexpected message %q", validationErr.Error())
+	}
+	if len(h.created) != 0 {
+		t.Errorf("Expected the handler not to be called, got %v", h.created)
+	}
+
+	// The handler gets the defaults, normalized like the other params, and
+	// actions without a schema are not validated
+	if result, err := f.ProcessHeroscript("!!disk.create name:'data' size:10"); err != nil || result != "created data of type hdd" {
+		t.Errorf("Expected the disk to be created with the default type, got %q, %v", result, err)
+	}
+	if result, err := f.ProcessHeroscript("!!disk.list anything:'goes'"); err != nil || result != "data" {
+		t.Errorf("Expected list not to be validated, got %q, %v", result, err)
+	}
+}
+
+func TestSimilarName(t *testing.T) {
+	names := []string{"name", "size", "type", "encrypted"}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"nmae", "name"},
+		{"sizes", "size"},
+		{"encripted", "encrypted"},
+		{"tpye", "type"},
+		{"color", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := similarName(test.name, names); got != test.want {
+			t.Errorf("%q: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"type", "tpye", 2},
+		{"size", "size", 0},
+	}
+	for _, test := range tests {
+		if got := editDistance(test.a, test.b); got != test.want {
+			t.Errorf("%q to %q: expected %d, got %d", test.a, test.b, test.want, got)
+		}
+	}
+}
@@ -2,13 +2,19 @@ package handlerfactory
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+	"github.com/freeflowuniverse/herolauncher/pkg/sshserver"
+	"github.com/freeflowuniverse/herolauncher/pkg/telnet"
 )
 
 // ANSI color codes for terminal output
@@ -24,12 +30,41 @@ const (
 	Bold        = "\033[1m"
 )
 
+// scriptMode is what a connection does with the scripts it receives
+type scriptMode int
+
+const (
+	modeRun scriptMode = iota
+	modePlan
+	modeUndo
+)
+
+// String returns the name of the mode, as it is recorded in the audit log
+func (m scriptMode) String() string {
+	switch m {
+	case modePlan:
+		return "plan"
+	case modeUndo:
+		return "undo"
+	default:
+		return "run"
+	}
+}
+
+// shellCommands are the commands of the telnet server, which are completed
+// like the actions, and actorCommands those whose argument is an actor
+var (
+	shellCommands = []string{"!!core.auth", "!!exit", "!!help", "!!interactive", "!!plan", "!!quit", "!!rollback", "!!schemas", "!!terminal", "!!undo"}
+	actorCommands = []string{"!!help"}
+)
+
 // TelnetServer represents a telnet server for processing HeroScript commands
 type TelnetServer struct {
 	factory      *HandlerFactory
 	secrets      []string
 	unixListener net.Listener
 	tcpListener  net.Listener
+	sshListener  net.Listener
 	clients      map[net.Conn]bool // map of client connections to authentication status
 	clientsMutex sync.RWMutex
 	running      bool
@@ -87,6 +122,33 @@ func (ts *TelnetServer) StartTCP(address string) error {
 	return nil
 }
 
+// StartSSH starts the server over SSH on a TCP address, with the same
+// commands as over telnet. Users log in with a key of
+// config.AuthorizedKeysFile, or with one of the secrets of the server as
+// password unless config.Password checks passwords otherwise, and are
+// authenticated when their session starts. The token of the callers is the
+// password, or the SHA256 fingerprint of the key, like SHA256:..., so
+// Authenticate can name them.
+func (ts *TelnetServer) StartSSH(address string, config sshserver.Config) error {
+	if config.Password == nil {
+		config.Password = func(user, password string) bool {
+			return ts.isValidSecret(password)
+		}
+	}
+	listener, err := sshserver.Listen(address, config)
+	if err != nil {
+		return err
+	}
+
+	ts.sshListener = listener
+	ts.running = true
+
+	// Accept sessions in a goroutine
+	go ts.acceptConnections(listener)
+
+	return nil
+}
+
 // Stop stops the telnet server
 func (ts *TelnetServer) Stop() error {
 	if !ts.running {
@@ -108,6 +170,12 @@ func (ts *TelnetServer) Stop() error {
 		}
 	}
 
+	if ts.sshListener != nil {
+		if err := ts.sshListener.Close(); err != nil {
+			return fmt.Errorf("failed to close SSH listener: %v", err)
+		}
+	}
+
 	// Close all client connections
 	ts.clientsMutex.Lock()
 	for conn := range ts.clients {
@@ -142,56 +210,148 @@ func (ts *TelnetServer) handleConnection(conn net.Conn) {
 	ts.clients[conn] = false
 	ts.clientsMutex.Unlock()
 
+	// audit records the session once it is authenticated, if the audit log
+	// is enabled
+	var audit *auditSession
+
 	// Ensure client is removed when connection closes
 	defer func() {
+		audit.logout()
 		conn.Close()
 		ts.clientsMutex.Lock()
 		delete(ts.clients, conn)
 		ts.clientsMutex.Unlock()
 	}()
 
-	// Welcome message
-	conn.Write([]byte(" ** Welcome: you are not authenticated, please authenticate with !!core.auth secret:1234\n"))
+	// Scripts are run, planned or undone, with the options of the connection
+	mode := modeRun
+	runOptions := RunOptions{Caller: Caller{Name: conn.RemoteAddr().String(), Transport: "telnet"}}
+
+	// The input of telnet connections is read without the commands of the
+	// telnet protocol
+	session, isSSH := conn.(*sshserver.Conn)
+	var input io.Reader = conn
+	if !isSSH {
+		input = telnet.NewReader(conn)
+	}
+	reader := bufio.NewReader(input)
 
-	// Create a scanner for reading input
-	scanner := bufio.NewScanner(conn)
 	var heroscriptBuffer strings.Builder
 	var lastCommand string
-	commandHistory := []string{}
-	historyPos := 0
 	interactiveMode := true
 
-	// Process client input
-	for scanner.Scan() {
-		line := scanner.Text()
+	// With line editing, the lines are read by an editor, which keeps the
+	// history and completes the commands, actions and params, and telnet
+	// clients are written to in character mode
+	var out io.Writer = conn
+	var editor *telnet.Editor
+	var history []string
+	setEditing := func(enabled bool) {
+		if editor != nil {
+			history = editor.History()
+		}
+		switch {
+		case isSSH:
+			session.SetRaw(enabled)
+		case enabled:
+			telnet.CharacterMode(conn)
+			out = telnet.NewWriter(conn)
+		default:
+			telnet.LineMode(conn)
+			out = conn
+		}
+		editor = nil
+		if enabled {
+			editor = telnet.NewEditor(reader, out)
+			editor.SetHistory(history)
+			editor.Complete = func(text string) ([]string, int) {
+				script := heroscriptBuffer.String()
+				if script != "" {
+					script += "\n"
+				}
+				completer := &Completer{Actions: ts.factory.Actions(), Commands: shellCommands, ActorCommands: actorCommands}
+				return completer.Complete(script, text)
+			}
+		}
+	}
 
-		// Check for Ctrl+C (ASCII value 3)
-		if line == "\x03" {
-			conn.Write([]byte("Goodbye!\n"))
-			return
+	// Welcome message
+	if isSSH {
+		// SSH sessions are authenticated by the SSH server
+		ts.clientsMutex.Lock()
+		ts.clients[conn] = true
+		ts.clientsMutex.Unlock()
+		runOptions.Caller.Transport = "ssh"
+		runOptions.Caller.Token = session.Password()
+		if runOptions.Caller.Token == "" {
+			runOptions.Caller.Token = session.KeyFingerprint()
 		}
+		audit = ts.factory.newAuditSession(runOptions.Caller.Name, "ssh", session.User(), session.KeyFingerprint())
+		out.Write([]byte(fmt.Sprintf(" ** Welcome %s: you are authenticated. You can now send commands.\n", session.User())))
+		// Lines typed on a terminal are edited
+		if session.Terminal() {
+			setEditing(true)
+		}
+	} else {
+		out.Write([]byte(" ** Welcome: you are not authenticated, please authenticate with !!core.auth secret:1234\n"))
+	}
 
-		// Check for arrow up (ANSI escape sequence for up arrow: "\x1b[A")
-		if line == "\x1b[A" && len(commandHistory) > 0 {
-			if historyPos > 0 {
-				historyPos--
+	// readLine reads a line with the editor, with a prompt that shows
+	// whether a script is pending, or as it is sent
+	readLine := func() (string, error) {
+		if editor != nil {
+			prompt := "hero> "
+			if heroscriptBuffer.Len() > 0 {
+				prompt = "....> "
 			}
-			if historyPos < len(commandHistory) {
-				conn.Write([]byte(commandHistory[historyPos]))
-				line = commandHistory[historyPos]
+			return editor.ReadLine(prompt)
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	// Process client input
+	for {
+		line, err := readLine()
+		if errors.Is(err, telnet.ErrInterrupt) {
+			// Ctrl+C drops the script that is entered
+			heroscriptBuffer.Reset()
+			continue
+		}
+		if err != nil {
+			if err != io.EOF {
+				fmt.Printf("Error reading from connection: %v\n", err)
 			}
+			return
+		}
+		// The history is kept without the secrets of auth commands
+		if editor != nil && !isAuthCommand(line) {
+			editor.AddHistory(line)
+		}
+
+		// Check for Ctrl+C (ASCII value 3)
+		if line == "\x03" {
+			out.Write([]byte("Goodbye!\n"))
+			return
 		}
 
 		// Handle quit/exit commands
 		if line == "!!quit" || line == "!!exit" || line == "q" {
-			conn.Write([]byte("Goodbye!\n"))
+			out.Write([]byte("Goodbye!\n"))
 			return
 		}
 
 		// Handle help command
 		if line == "!!help" || line == "h" || line == "?" {
 			helpText := ts.generateHelpText(interactiveMode)
-			conn.Write([]byte(helpText))
+			out.Write([]byte(helpText))
+			continue
+		}
+		if actor, ok := strings.CutPrefix(line, "!!help "); ok {
+			out.Write([]byte(ts.generateActorHelp(strings.TrimSpace(actor))))
 			continue
 		}
 
@@ -201,11 +361,55 @@ func (ts *TelnetServer) handleConnection(conn net.Conn) {
 			if interactiveMode {
 				// Only use colors in terminal output, not in telnet
 				fmt.Println(ColorGreen + "Interactive mode enabled for client. Using colors for console output." + ColorReset)
-				conn.Write([]byte("Interactive mode enabled. Using formatted output.\n"))
+				out.Write([]byte("Interactive mode enabled. Using formatted output.\n"))
 			} else {
 				fmt.Println("Interactive mode disabled for client. Plain text console output.")
-				conn.Write([]byte("Interactive mode disabled. Plain text output.\n"))
+				out.Write([]byte("Interactive mode disabled. Plain text output.\n"))
+			}
+			continue
+		}
+
+		// Handle line editing toggle
+		if line == "!!terminal" {
+			if isSSH && !session.Terminal() {
+				out.Write([]byte("Line editing needs a terminal, connect with ssh -t.\n"))
+			} else if editor == nil {
+				setEditing(true)
+				out.Write([]byte("Line editing enabled. The arrows browse the history, Ctrl+R searches it.\n"))
+			} else {
+				setEditing(false)
+				out.Write([]byte("Line editing disabled.\n"))
+			}
+			continue
+		}
+
+		// Handle plan and undo mode toggles
+		if line == "!!plan" || line == "!!undo" {
+			toggled, name, description := modePlan, "Plan", "planned"
+			if line == "!!undo" {
+				toggled, name, description = modeUndo, "Undo", "undone"
+			}
+			message := fmt.Sprintf("%s mode enabled. Scripts are %s, not run.", name, description)
+			if mode != toggled {
+				mode = toggled
+			} else {
+				mode = modeRun
+				message = fmt.Sprintf("%s mode disabled. Scripts are run.", name)
+			}
+			audit.command("", line, message, 0)
+			out.Write([]byte(message + "\n"))
+			continue
+		}
+
+		// Handle rollback toggle
+		if line == "!!rollback" {
+			runOptions.NoRollback = !runOptions.NoRollback
+			message := "Rollback enabled. Actions that ran are undone when a later one fails."
+			if runOptions.NoRollback {
+				message = "Rollback disabled. Actions that ran are kept when a later one fails."
 			}
+			audit.command("", line, message, 0)
+			out.Write([]byte(message + "\n"))
 			continue
 		}
 
@@ -215,10 +419,10 @@ func (ts *TelnetServer) handleConnection(conn net.Conn) {
 		// Handle authentication
 		if !isAuthenticated {
 			// Check if this is an auth command
-			if strings.HasPrefix(strings.TrimSpace(line), "!!core.auth") || strings.HasPrefix(strings.TrimSpace(line), "!!auth") {
+			if isAuthCommand(line) {
 				pb, err := playbook.NewFromText(line)
 				if err != nil {
-					conn.Write([]byte("Authentication syntax error. Use !!core.auth secret:'your_secret'\n"))
+					out.Write([]byte("Authentication syntax error. Use !!core.auth secret:'your_secret'\n"))
 					continue
 				}
 
@@ -229,45 +433,49 @@ func (ts *TelnetServer) handleConnection(conn net.Conn) {
 					validAction := action.Name == "auth"
 
 					if validActor && validAction {
-						secret := action.Params.Get("secret")
+						secret := action.Params.GetVerbatim("secret")
 						if ts.isValidSecret(secret) {
 							ts.clientsMutex.Lock()
 							ts.clients[conn] = true
 							ts.clientsMutex.Unlock()
-							conn.Write([]byte(" ** Authentication successful. You can now send commands.\n"))
+							runOptions.Caller.Token = secret
+							audit = ts.factory.newAuditSession(runOptions.Caller.Name, "telnet", "", "")
+							out.Write([]byte(" ** Authentication successful. You can now send commands.\n"))
 							continue
 						} else {
-							conn.Write([]byte("Authentication failed: Invalid secret provided.\n"))
+							out.Write([]byte("Authentication failed: Invalid secret provided.\n"))
 							continue
 						}
 					}
 				}
-				conn.Write([]byte("Invalid authentication format. Use !!core.auth secret:'your_secret'\n"))
+				out.Write([]byte("Invalid authentication format. Use !!core.auth secret:'your_secret'\n"))
 			} else {
-				conn.Write([]byte("You must authenticate first. Use !!core.auth secret:'your_secret'\n"))
+				out.Write([]byte("You must authenticate first. Use !!core.auth secret:'your_secret'\n"))
 			}
 			continue
 		}
 
+		// Handle the schemas of the actions, which clients complete with
+		if line == "!!schemas" {
+			out.Write([]byte(ts.schemasResult() + "\n"))
+			continue
+		}
+
 		// Empty line executes pending command or repeats last command
 		if line == "" {
 			if heroscriptBuffer.Len() > 0 {
 				// Execute pending command
 				commandText := heroscriptBuffer.String()
-				result := ts.executeHeroscript(commandText, interactiveMode)
-				conn.Write([]byte(result + "\n"))
-
-				// Add to history
-				commandHistory = append(commandHistory, commandText)
-				historyPos = len(commandHistory)
+				result := ts.executeHeroscript(commandText, interactiveMode, mode, runOptions, audit)
+				out.Write([]byte(result + "\n"))
 
 				// Reset buffer
 				heroscriptBuffer.Reset()
 				lastCommand = commandText
 			} else if lastCommand != "" {
 				// Repeat last command
-				result := ts.executeHeroscript(lastCommand, interactiveMode)
-				conn.Write([]byte(result + "\n"))
+				result := ts.executeHeroscript(lastCommand, interactiveMode, mode, runOptions, audit)
+				out.Write([]byte(result + "\n"))
 			}
 			continue
 		}
@@ -278,11 +486,6 @@ func (ts *TelnetServer) handleConnection(conn net.Conn) {
 		}
 		heroscriptBuffer.WriteString(line)
 	}
-
-	// Handle scanner errors
-	if err := scanner.Err(); err != nil {
-		fmt.Printf("Error reading from connection: %v\n", err)
-	}
 }
 
 // isClientAuthenticated checks if a client is authenticated
@@ -294,6 +497,11 @@ func (ts *TelnetServer) isClientAuthenticated(conn net.Conn) bool {
 	return exists && authenticated
 }
 
+// isAuthCommand checks if a line is an auth command
+func isAuthCommand(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "!!core.auth") || strings.HasPrefix(strings.TrimSpace(line), "!!auth")
+}
+
 // isValidSecret checks if a secret is valid
 func (ts *TelnetServer) isValidSecret(secret string) bool {
 	for _, validSecret := range ts.secrets {
@@ -304,8 +512,30 @@ func (ts *TelnetServer) isValidSecret(secret string) bool {
 	return false
 }
 
-// executeHeroscript executes a heroscript and returns the result
-func (ts *TelnetServer) executeHeroscript(script string, interactive bool) string {
+// executeHeroscript executes, plans or undoes a heroscript and returns the
+// result between the result markers, so clients can tell where it ends. The
+// script and its result are recorded in the audit log of the session.
+func (ts *TelnetServer) executeHeroscript(script string, interactive bool, mode scriptMode, options RunOptions, audit *auditSession) string {
+	start := time.Now()
+	var result string
+	switch mode {
+	case modePlan:
+		result = ts.planHeroscript(script)
+	case modeUndo:
+		result = ts.undoHeroscript(script, options)
+	default:
+		result = ts.processHeroscript(script, interactive, options)
+	}
+	audit.command(mode.String(), script, result, time.Since(start))
+	if !strings.HasSuffix(result, "\n") {
+		result += "\n"
+	}
+	return "**RESULT**\n" + result + "**ENDRESULT**"
+}
+
+// processHeroscript processes a heroscript and returns the result or the
+// error
+func (ts *TelnetServer) processHeroscript(script string, interactive bool, options RunOptions) string {
 	if interactive {
 		// Format the script with colors
 		formattedScript := formatHeroscript(script)
@@ -315,7 +545,7 @@ func (ts *TelnetServer) executeHeroscript(script string, interactive bool) strin
 	}
 
 	// Process the heroscript
-	result, err := ts.factory.ProcessHeroscript(script)
+	result, err := ts.factory.Run(script, options)
 	if err != nil {
 		errorMsg := fmt.Sprintf("Error: %v", err)
 		if interactive {
@@ -332,6 +562,43 @@ func (ts *TelnetServer) executeHeroscript(script string, interactive bool) strin
 	return result
 }
 
+// planHeroscript plans a heroscript and returns the plan. A plan with
+// invalid actions is returned as error, followed by the plan.
+func (ts *TelnetServer) planHeroscript(script string) string {
+	fmt.Println("Planning heroscript:\n" + script)
+
+	plan, err := ts.factory.Plan(script)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+	if err := plan.Err(); err != nil {
+		return fmt.Sprintf("Error: %v\n%s", err, plan)
+	}
+	return plan.String()
+}
+
+// undoHeroscript undoes the actions of a heroscript and returns what was
+// undone or the error
+func (ts *TelnetServer) undoHeroscript(script string, options RunOptions) string {
+	fmt.Println("Undoing heroscript:\n" + script)
+
+	result, err := ts.factory.Undo(script, options)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+	return result
+}
+
+// schemasResult returns the actions of the factory with their schemas as
+// JSON, between the result markers
+func (ts *TelnetServer) schemasResult() string {
+	data, err := json.Marshal(ts.factory.Actions())
+	if err != nil {
+		return fmt.Sprintf("**RESULT**\nError: %v\n**ENDRESULT**", err)
+	}
+	return "**RESULT**\n" + string(data) + "\n**ENDRESULT**"
+}
+
 // formatHeroscript formats heroscript with colors for console output only
 // This is not used for telnet responses, only for server-side logging
 func formatHeroscript(script string) string {
@@ -402,7 +669,13 @@ func (ts *TelnetServer) generateHelpText(interactive bool) string {
 	// System commands
 	help.WriteString("  System Commands:\n")
 	help.WriteString("    !!help, h, ?      - Show this help\n")
+	help.WriteString("    !!help <actor>    - Show the actions of an actor with their params\n")
 	help.WriteString("    !!interactive, i  - Toggle interactive mode\n")
+	help.WriteString("    !!terminal        - Toggle line editing, for telnet clients in a terminal\n")
+	help.WriteString("    !!plan            - Toggle plan mode, which shows what scripts would do\n")
+	help.WriteString("    !!undo            - Toggle undo mode, which undoes the actions of scripts\n")
+	help.WriteString("    !!rollback        - Toggle undoing the actions that ran when one fails\n")
+	help.WriteString("    !!schemas         - Show the actions and their params as JSON\n")
 	help.WriteString("    !!quit, q         - Disconnect\n")
 	help.WriteString("    !!exit            - Disconnect\n")
 	help.WriteString("\n")
@@ -427,7 +700,56 @@ func (ts *TelnetServer) generateHelpText(interactive bool) string {
 	help.WriteString("  Usage Tips:\n")
 	help.WriteString("    - Enter an empty line to execute a command\n")
 	help.WriteString("    - Commands can span multiple lines\n")
-	help.WriteString("    - Use arrow up to access command history\n")
+	help.WriteString("    - With line editing, the arrows browse the history, Ctrl+R searches it,\n")
+	help.WriteString("      Ctrl+L clears the screen and Ctrl+C drops the command\n")
+	help.WriteString("    - With line editing, Tab completes the commands, actions and params\n")
+	help.WriteString("    - Results are written between **RESULT** and **ENDRESULT**\n")
 
 	return help.String()
 }
+
+// generateActorHelp generates the help of the actions of an actor with their
+// params: required params are marked with *, and their types, values and
+// defaults are shown
+func (ts *TelnetServer) generateActorHelp(actor string) string {
+	var help strings.Builder
+	var actors []string
+	for _, action := range ts.factory.Actions() {
+		if !contains(actors, action.Actor) {
+			actors = append(actors, action.Actor)
+		}
+		if action.Actor != actor {
+			continue
+		}
+
+		help.WriteString(fmt.Sprintf("  !!%s.%s", action.Actor, action.Name))
+		if action.Schema.Description != "" {
+			help.WriteString(" - " + action.Schema.Description)
+		}
+		help.WriteString("\n")
+
+		width := 0
+		for _, param := range action.Schema.Params {
+			width = max(width, len(param.Usage()))
+		}
+		for _, param := range action.Schema.Params {
+			line := fmt.Sprintf("      %-*s  %s", width, param.Usage(), param.Description)
+			help.WriteString(strings.TrimRight(line, " ") + "\n")
+		}
+		switch {
+		case !action.HasSchema:
+			help.WriteString("      The params are not known\n")
+		case action.Schema.AllowUnknown:
+			help.WriteString("      Other params are accepted too\n")
+		}
+	}
+
+	if help.Len() == 0 {
+		message := fmt.Sprintf("Unknown actor %s", actor)
+		if similar := similarName(actor, actors); similar != "" {
+			message += fmt.Sprintf(", did you mean %s?", similar)
+		}
+		return message + "\nActors: " + strings.Join(actors, ", ") + "\n"
+	}
+	return fmt.Sprintf("Actions of %s:\n%s", actor, help.String())
+}
@@ -135,7 +135,103 @@ type HLenResponse struct {
 	Length int `json:"length"`
 }
 
+// HGetAllResponse represents the response from getting all fields of a hash
+type HGetAllResponse struct {
+	Fields map[string]string `json:"fields"`
+}
+
+// HMSetKeyRequest represents a request to set several hash fields
+type HMSetKeyRequest struct {
+	Key    string            `json:"key"`
+	Fields map[string]string `json:"fields"`
+}
+
+// HMSetKeyResponse represents the response from setting several hash fields
+type HMSetKeyResponse struct {
+	Added int `json:"added"`
+}
+
+// HExistsResponse represents the response from checking a hash field
+type HExistsResponse struct {
+	Exists bool `json:"exists"`
+}
+
+// HIncrByRequest represents a request to increment a hash field
+type HIncrByRequest struct {
+	Key       string `json:"key"`
+	Field     string `json:"field"`
+	Increment int64  `json:"increment"`
+}
+
 // IncrKeyResponse represents the response from incrementing a key
 type IncrKeyResponse struct {
 	Value int64 `json:"value"`
 }
+
+// Process Manager Models
+
+// StartProcessRequest represents a request to start a managed process
+type StartProcessRequest struct {
+	Name     string `json:"name"`
+	Command  string `json:"command"`
+	Log      bool   `json:"log"`
+	Deadline int    `json:"deadline"`
+	Cron     string `json:"cron"`
+	JobID    string `json:"job_id"`
+	// Timezone, Jitter in seconds and Overlap, which is skip, queue or
+	// kill-previous, apply to processes with a Cron schedule
+	Timezone string `json:"timezone"`
+	Jitter   int    `json:"jitter"`
+	Overlap  string `json:"overlap"`
+	// Restart is never, on-failure or always; empty means never
+	Restart     string `json:"restart"`
+	MaxRestarts int    `json:"max_restarts"`
+	// Requires and After name processes to start first, see
+	// processmanager.ProcessDefinition
+	Requires    []string `json:"requires"`
+	After       []string `json:"after"`
+	HealthCheck string   `json:"health_check"`
+	// HealthInterval is in seconds
+	HealthInterval int `json:"health_interval"`
+	HealthRetries  int `json:"health_retries"`
+	// Env, Dir, Umask and User set up the environment of the process, see
+	// processmanager.ProcessDefinition
+	Env   map[string]string `json:"env"`
+	Dir   string            `json:"dir"`
+	Umask string            `json:"umask"`
+	User  string            `json:"user"`
+	// Stdin keeps the input of the process open, see SendInputRequest
+	Stdin bool `json:"stdin"`
+	// Listen is a tcp://host:port address or unix:// socket path passed
+	// to the process, see processmanager.ProcessDefinition
+	Listen string `json:"listen"`
+}
+
+// SendInputRequest represents a request to write to the input of a process
+// started with stdin
+type SendInputRequest struct {
+	// Input is written as is, without a newline added
+	Input string `json:"input"`
+	// EOF closes the input after Input is written
+	EOF bool `json:"eof"`
+}
+
+// ImportProcessesResponse represents the response from importing process
+// definitions from heroscript
+type ImportProcessesResponse struct {
+	// Started lists the processes that were started or replaced
+	Started []string `json:"started"`
+	// Error lists the processes that could not be started, if any
+	Error string `json:"error,omitempty"`
+}
+
+// DeleteProcessResponse represents the response from deleting a process
+type DeleteProcessResponse struct {
+	Success bool `json:"success"`
+}
+
+// ProcessLogsResponse represents the response from getting the logs of a process
+type ProcessLogsResponse struct {
+	Name string `json:"name"`
+	Logs string `json:"logs"`
+}
@@ -5,9 +5,10 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/freeflowuniverse/herolauncher/pkg/processmanager"
 	"github.com/freeflowuniverse/herolauncher/pkg/system/stats"
 	"github.com/gofiber/fiber/v2"
-	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/process"
 )
 
 // UptimeProvider defines an interface for getting system uptime
@@ -66,6 +67,11 @@ func (h *AdminHandler) RegisterRoutes(app *fiber.App) {
 	// API endpoints
 	admin.Get("/api/hardware-stats", h.getHardwareStatsJSON)
 	admin.Get("/api/process-stats", h.getProcessStatsJSON)
+	admin.Get("/api/managed-process-stats", h.getManagedProcessStatsJSON)
+	admin.Get("/api/alerts", h.getAlertsJSON)
+	admin.Get("/api/network-stats", h.getNetworkStatsJSON)
+	admin.Get("/api/ports", h.getPortsJSON)
+	admin.Get("/api/stats", h.getAllStatsJSON)
 	admin.Get("/system/settings", h.getSystemSettings)
 
 	// Redirect root to admin
@@ -102,8 +108,13 @@ func (h *AdminHandler) getSystemInfo(c *fiber.Ctx) error {
 	memoryInfo := "Unknown"
 	diskInfo := "Unknown"
 	networkInfo := "Unknown"
+	gpuInfo := "Unknown"
 	osInfo := "Unknown"
 	uptimeInfo := "Unknown"
+	kernelInfo := "Unknown"
+	systemUptimeInfo := "Unknown"
+	bootTimeInfo := "Unknown"
+	loadInfo := "Unknown"
 
 	// Get hardware stats from the StatsManager
 	var hardwareStats map[string]interface{}
@@ -119,22 +130,27 @@ func (h *AdminHandler) getSystemInfo(c *fiber.Ctx) error {
 	memoryInfo = hardwareStats["memory"].(string)
 	diskInfo = hardwareStats["disk"].(string)
 	networkInfo = hardwareStats["network"].(string)
+	if gpu, ok := hardwareStats["gpu"].(string); ok {
+		gpuInfo = gpu
+	}
 
 	// Software information
-	// OS and Uptime
-	try := func() {
-		hostInfo, err := host.Info()
-		if err == nil {
-			osInfo = fmt.Sprintf("%s %s", hostInfo.Platform, hostInfo.PlatformVersion)
-
-			// Format uptime from seconds to days and hours
-			uptime := hostInfo.Uptime
-			days := uptime / (60 * 60 * 24)
-			hours := (uptime % (60 * 60 * 24)) / (60 * 60)
-			uptimeInfo = fmt.Sprintf("%d days, %d hours", days, hours)
-		}
+	// OS, kernel, uptime and boot time of the host
+	if hostInfo, err := stats.GetHostInfo(); err == nil {
+		osInfo = fmt.Sprintf("%s %s", hostInfo.Platform, hostInfo.PlatformVersion)
+		kernelInfo = fmt.Sprintf("%s (%s)", hostInfo.KernelVersion, hostInfo.KernelArch)
+		systemUptimeInfo = stats.FormatUptime(hostInfo.Uptime)
+		bootTimeInfo = time.Unix(int64(hostInfo.BootTime), 0).Format("2006-01-02 15:04:05")
+
+		// Format uptime from seconds to days and hours
+		uptime := hostInfo.Uptime
+		days := uptime / (60 * 60 * 24)
+		hours := (uptime % (60 * 60 * 24)) / (60 * 60)
+		uptimeInfo = fmt.Sprintf("%d days, %d hours", days, hours)
+	}
+	if load, err := stats.GetLoadInfo(); err == nil {
+		loadInfo = fmt.Sprintf("%.2f, %.2f, %.2f", load.Load1, load.Load5, load.Load15)
 	}
-	try()
 
 	// If OS info couldn't be retrieved, use runtime info
 	if osInfo == "Unknown" {
@@ -166,14 +182,19 @@ func (h *AdminHandler) getSystemInfo(c *fiber.Ctx) error {
 		"memory":  memoryInfo,
 		"disk":    diskInfo,
 		"network": networkInfo,
+		"gpu":     gpuInfo,
 	}
 
 	// Create software info map
 	software := fiber.Map{
-		"os":           osInfo,
-		"go_version":   goVersion,
-		"herolauncher": heroLauncherVersion,
-		"uptime":       uptimeInfo,
+		"os":            osInfo,
+		"go_version":    goVersion,
+		"herolauncher":  heroLauncherVersion,
+		"uptime":        uptimeInfo,
+		"kernel":        kernelInfo,
+		"system_uptime": systemUptimeInfo,
+		"boot_time":     bootTimeInfo,
+		"load":          loadInfo,
 	}
 
 	print(hardware)
@@ -287,6 +308,165 @@ func (h *AdminHandler) getProcessStatsJSON(c *fiber.Ctx) error {
 	})
 }
 
+// ManagedProcessStats is the stats type of the resources of the processes of
+// the process manager, registered with the StatsManager
+const ManagedProcessStats = "managed_process"
+
+// getManagedProcessStatsJSON returns the resources of the processes of the
+// process manager in JSON format for API consumption
+func (h *AdminHandler) getManagedProcessStatsJSON(c *fiber.Ctx) error {
+	if h.statsManager == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Stats are not available",
+		})
+	}
+
+	var metrics []processmanager.ProcessMetrics
+	if err := h.statsManager.GetStats(ManagedProcessStats, &metrics); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get managed process stats: " + err.Error(),
+		})
+	}
+
+	// Return JSON response
+	return c.JSON(fiber.Map{
+		"processes": metrics,
+		"timestamp": time.Now().Unix(),
+	})
+}
+
+// getNetworkStatsJSON returns the counters and speeds of the network
+// interfaces in JSON format for API consumption
+func (h *AdminHandler) getNetworkStatsJSON(c *fiber.Ctx) error {
+	var networkStats *stats.NetworkStats
+	var err error
+	if h.statsManager != nil {
+		networkStats, err = h.statsManager.GetNetworkStats()
+	} else {
+		// Fallback to direct function call if StatsManager is not available
+		networkStats, err = stats.GetNetworkStats()
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get network stats: " + err.Error(),
+		})
+	}
+
+	return c.JSON(networkStats)
+}
+
+// servicePort is a listening port with the managed process it belongs to
+type servicePort struct {
+	stats.ListeningPort
+	Service string `json:"service,omitempty"`
+}
+
+// getPortsJSON returns the listening ports and the TCP connections per state
+// in JSON format for API consumption. Ports of managed processes, or of
+// their children, have the name of the managed process as their service.
+func (h *AdminHandler) getPortsJSON(c *fiber.Ctx) error {
+	var portStats *stats.PortStats
+	var err error
+	if h.statsManager != nil {
+		portStats, err = h.statsManager.GetPortStats()
+	} else {
+		// Fallback to direct function call if StatsManager is not available
+		portStats, err = stats.GetPortStats()
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get ports: " + err.Error(),
+		})
+	}
+
+	// Without the managed process stats the ports have no services
+	services := make(map[int32]string)
+	if h.statsManager != nil {
+		var metrics []processmanager.ProcessMetrics
+		if err := h.statsManager.GetStats(ManagedProcessStats, &metrics); err == nil {
+			for _, m := range metrics {
+				if m.PID > 0 {
+					services[m.PID] = m.Name
+				}
+			}
+		}
+	}
+
+	ports := make([]servicePort, len(portStats.Listening))
+	for i, port := range portStats.Listening {
+		ports[i] = servicePort{
+			ListeningPort: port,
+			Service:       serviceOf(port.PID, services),
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"ports":             ports,
+		"connection_states": portStats.ConnectionStates,
+		"timestamp":         time.Now().Unix(),
+	})
+}
+
+// serviceOf returns the service of a process from the services by PID, which
+// is that of the process itself or of its closest ancestor with one, or ""
+func serviceOf(pid int32, services map[int32]string) string {
+	if len(services) == 0 {
+		return ""
+	}
+	// A process deeper than this in a service is not expected
+	for depth := 0; pid > 1 && depth < 8; depth++ {
+		if name, ok := services[pid]; ok {
+			return name
+		}
+		p, err := process.NewProcess(pid)
+		if err != nil {
+			return ""
+		}
+		if pid, err = p.Ppid(); err != nil {
+			return ""
+		}
+	}
+	return ""
+}
+
+// getAllStatsJSON returns the collectors and the stats of the enabled ones,
+// by stats type, in JSON format for API consumption
+func (h *AdminHandler) getAllStatsJSON(c *fiber.Ctx) error {
+	if h.statsManager == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Stats are not available",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"collectors": h.statsManager.Collectors(),
+		"stats":      h.statsManager.GetAllStats(),
+		"timestamp":  time.Now().Unix(),
+	})
+}
+
+// getAlertsJSON returns the alert rules and the pending, firing and resolved
+// alerts in JSON format for API consumption
+func (h *AdminHandler) getAlertsJSON(c *fiber.Ctx) error {
+	if h.statsManager == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": "Stats are not available",
+		})
+	}
+
+	// The secrets of the webhooks are not shown
+	rules := h.statsManager.AlertRules()
+	for i := range rules {
+		rules[i].Secret = ""
+	}
+
+	return c.JSON(fiber.Map{
+		"rules":     rules,
+		"alerts":    h.statsManager.Alerts(),
+		"timestamp": time.Now().Unix(),
+	})
+}
+
 // getHardwareStatsJSON returns hardware stats in JSON format for API consumption
 func (h *AdminHandler) getHardwareStatsJSON(c *fiber.Ctx) error {
 	// Get hardware stats from the StatsManager
@@ -0,0 +1,535 @@
+package routes
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/herolauncher/api"
+	"github.com/freeflowuniverse/herolauncher/pkg/processmanager"
+	"github.com/gofiber/fiber/v2"
+)
+
+// ProcessManagerHandler handles the endpoints of the process manager. Every
+// request must carry the secret of the process manager as a bearer token.
+type ProcessManagerHandler struct {
+	processManager *processmanager.ProcessManager
+}
+
+// NewProcessManagerHandler creates a new process manager handler
+func NewProcessManagerHandler(pm *processmanager.ProcessManager) *ProcessManagerHandler {
+	return &ProcessManagerHandler{
+		processManager: pm,
+	}
+}
+
+// RegisterRoutes registers process manager routes to the fiber app
+func (h *ProcessManagerHandler) RegisterRoutes(app *fiber.App) {
+	group := app.Group("/api/processes", h.authenticate)
+
+	group.Get("/", h.listProcesses)
+	group.Post("/", h.startProcess)
+	// Registered before /:name, which would match them too
+	group.Get("/events", h.streamEvents)
+	group.Get("/metrics", h.getMetrics)
+	group.Get("/export", h.exportProcesses)
+	group.Post("/import", h.importProcesses)
+	group.Get("/:name", h.getProcess)
+	group.Delete("/:name", h.deleteProcess)
+	group.Post("/:name/stop", h.stopProcess)
+	group.Post("/:name/restart", h.restartProcess)
+	group.Post("/:name/stdin", h.sendInput)
+	group.Get("/:name/logs", h.getProcessLogs)
+	group.Get("/:name/logfiles", h.listLogFiles)
+	group.Get("/:name/logfiles/:file", h.getLogFile)
+	group.Get("/:name/runs", h.listRuns)
+	group.Get("/:name/schedule", h.getSchedule)
+}
+
+// authenticate rejects requests without the secret of the process manager
+// in the Authorization header
+func (h *ProcessManagerHandler) authenticate(c *fiber.Ctx) error {
+	token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	secret := h.processManager.GetSecret()
+	if !ok || secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="processmanager"`)
+		return c.Status(fiber.StatusUnauthorized).JSON(api.ErrorResponse{
+			Error: "Invalid or missing token",
+		})
+	}
+	return c.Next()
+}
+
+// exists responds with 404 and returns false if the process named in the
+// path is not managed
+func (h *ProcessManagerHandler) exists(c *fiber.Ctx) bool {
+	if _, err := h.processManager.GetProcessStatus(c.Params("name")); err != nil {
+		c.Status(fiber.StatusNotFound).JSON(api.ErrorResponse{
+			Error: err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// @Summary List processes
+// @Description Get the status of all managed processes
+// @Tags processes
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} processmanager.ProcessInfo
+// @Failure 401 {object} api.ErrorResponse
+// @Router /api/processes [get]
+func (h *ProcessManagerHandler) listProcesses(c *fiber.Ctx) error {
+	return c.JSON(h.processManager.ListProcesses())
+}
+
+// @Summary Start a process
+// @Description Start a new managed process
+// @Tags processes
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param data body api.StartProcessRequest true "Process to start"
+// @Success 201 {object} processmanager.ProcessInfo
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Router /api/processes [post]
+func (h *ProcessManagerHandler) startProcess(c *fiber.Ctx) error {
+	var req api.StartProcessRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(api.ErrorResponse{
+			Error: "Invalid request: " + err.Error(),
+		})
+	}
+	if req.Name == "" || req.Command == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(api.ErrorResponse{
+			Error: "Invalid request: name and command are required",
+		})
+	}
+
+	restart, err := processmanager.ParseRestartPolicy(req.Restart)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(api.ErrorResponse{
+			Error: "Invalid request: " + err.Error(),
+		})
+	}
+	var overlap processmanager.OverlapPolicy
+	if req.Overlap != "" {
+		if overlap, err = processmanager.ParseOverlapPolicy(req.Overlap); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(api.ErrorResponse{
+				Error: "Invalid request: " + err.Error(),
+			})
+		}
+	}
+
+	err = h.processManager.StartProcessDefinition(processmanager.ProcessDefinition{
+		Name:        req.Name,
+		Command:     req.Command,
+		Log:         req.Log,
+		Deadline:    req.Deadline,
+		Cron:        req.Cron,
+		Timezone:    req.Timezone,
+		Jitter:      req.Jitter,
+		Overlap:     overlap,
+		JobID:       req.JobID,
+		Restart:     restart,
+		MaxRestarts: req.MaxRestarts,
+		Requires:    req.Requires,
+		After:       req.After,
+		HealthCheck: req.HealthCheck,
+
+		HealthInterval: req.HealthInterval,
+		HealthRetries:  req.HealthRetries,
+
+		Env:   req.Env,
+		Dir:   req.Dir,
+		Umask: req.Umask,
+		User:  req.User,
+		Stdin: req.Stdin,
+
+		Listen: req.Listen,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(api.ErrorResponse{
+			Error: "Failed to start process: " + err.Error(),
+		})
+	}
+
+	info, err := h.processManager.GetProcessStatus(req.Name)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(api.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusCreated).JSON(info)
+}
+
+// @Summary Get process status
+// @Description Get the status of a managed process
+// @Tags processes
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Process name"
+// @Success 200 {object} processmanager.ProcessInfo
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router /api/processes/{name} [get]
+func (h *ProcessManagerHandler) getProcess(c *fiber.Ctx) error {
+	info, err := h.processManager.GetProcessStatus(c.Params("name"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(api.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	return c.JSON(info)
+}
+
+// @Summary Stop a process
+// @Description Stop a running process; it stays managed and can be restarted
+// @Tags processes
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Process name"
+// @Success 200 {object} processmanager.ProcessInfo
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router /api/processes/{name}/stop [post]
+func (h *ProcessManagerHandler) stopProcess(c *fiber.Ctx) error {
+	if !h.exists(c) {
+		return nil
+	}
+	name := c.Params("name")
+	if err := h.processManager.StopProcess(name); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(api.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	return h.getProcess(c)
+}
+
+// @Summary Send input to a process
+// @Description Write to the input of a running process started with stdin, and optionally close it
+// @Tags processes
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Process name"
+// @Param input body api.SendInputRequest true "Input"
+// @Success 200 {object} processmanager.ProcessInfo
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router /api/processes/{name}/stdin [post]
+func (h *ProcessManagerHandler) sendInput(c *fiber.Ctx) error {
+	if !h.exists(c) {
+		return nil
+	}
+	var req api.SendInputRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(api.ErrorResponse{
+			Error: "Invalid request: " + err.Error(),
+		})
+	}
+
+	name := c.Params("name")
+	if req.Input != "" {
+		if err := h.processManager.SendInput(name, []byte(req.Input)); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(api.ErrorResponse{
+				Error: err.Error(),
+			})
+		}
+	}
+	if req.EOF {
+		if err := h.processManager.CloseInput(name); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(api.ErrorResponse{
+				Error: err.Error(),
+			})
+		}
+	}
+	return h.getProcess(c)
+}
+
+// @Summary Restart a process
+// @Description Stop a process if it is running and start it again with the same settings. With rolling, a new instance is started first and the old one is only stopped once the new one passed the health check of the process.
+// @Tags processes
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Process name"
+// @Param rolling query bool false "Restart without downtime"
+// @Success 200 {object} processmanager.ProcessInfo
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Failure 500 {object} api.ErrorResponse
+// @Router /api/processes/{name}/restart [post]
+func (h *ProcessManagerHandler) restartProcess(c *fiber.Ctx) error {
+	if !h.exists(c) {
+		return nil
+	}
+	name := c.Params("name")
+	restart := h.processManager.RestartProcess
+	if c.QueryBool("rolling") {
+		restart = h.processManager.RollingRestart
+	}
+	if err := restart(name); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(api.ErrorResponse{
+			Error: "Failed to restart process: " + err.Error(),
+		})
+	}
+	return h.getProcess(c)
+}
+
+// @Summary Delete a process
+// @Description Stop a process if it is running and remove it from the process manager
+// @Tags processes
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Process name"
+// @Success 200 {object} api.DeleteProcessResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router /api/processes/{name} [delete]
+func (h *ProcessManagerHandler) deleteProcess(c *fiber.Ctx) error {
+	if err := h.processManager.DeleteProcess(c.Params("name")); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(api.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	return c.JSON(api.DeleteProcessResponse{
+		Success: true,
+	})
+}
+
+// @Summary Get process logs
+// @Description Get the last lines of output of a process
+// @Tags processes
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Process name"
+// @Param lines query int false "Number of lines (default 20)"
+// @Success 200 {object} api.ProcessLogsResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router /api/processes/{name}/logs [get]
+func (h *ProcessManagerHandler) getProcessLogs(c *fiber.Ctx) error {
+	name := c.Params("name")
+	logs, err := h.processManager.GetProcessLogs(name, c.QueryInt("lines", 20))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(api.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	return c.JSON(api.ProcessLogsResponse{
+		Name: name,
+		Logs: logs,
+	})
+}
+
+// @Summary List process log files
+// @Description List the current and rotated log files of a process started with logging
+// @Tags processes
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Process name"
+// @Success 200 {array} processmanager.LogFile
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router /api/processes/{name}/logfiles [get]
+func (h *ProcessManagerHandler) listLogFiles(c *fiber.Ctx) error {
+	files, err := h.processManager.ListLogFiles(c.Params("name"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(api.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	return c.JSON(files)
+}
+
+// @Summary Get a process log file
+// @Description Get a current or rotated log file of a process, decompressed
+// @Tags processes
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Process name"
+// @Param file path string true "Log file name as listed"
+// @Param lines query int false "Number of last lines (default the whole file)"
+// @Success 200 {object} api.ProcessLogsResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router /api/processes/{name}/logfiles/{file} [get]
+func (h *ProcessManagerHandler) getLogFile(c *fiber.Ctx) error {
+	name := c.Params("name")
+	logs, err := h.processManager.ReadLogFile(name, c.Params("file"), c.QueryInt("lines", 0))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(api.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	return c.JSON(api.ProcessLogsResponse{
+		Name: name,
+		Logs: logs,
+	})
+}
+
+// @Summary List process runs
+// @Description List the last runs of a process with a cron schedule, from old to new
+// @Tags processes
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Process name"
+// @Success 200 {array} processmanager.CronRun
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router /api/processes/{name}/runs [get]
+func (h *ProcessManagerHandler) listRuns(c *fiber.Ctx) error {
+	if !h.exists(c) {
+		return nil
+	}
+	runs, err := h.processManager.ListRuns(c.Params("name"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(api.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	return c.JSON(runs)
+}
+
+// @Summary List upcoming process runs
+// @Description List the next times the cron schedule of a process gives
+// @Tags processes
+// @Produce json
+// @Security BearerAuth
+// @Param name path string true "Process name"
+// @Param count query int false "Number of upcoming runs (default 5)"
+// @Success 200 {array} string
+// @Failure 400 {object} api.ErrorResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Failure 404 {object} api.ErrorResponse
+// @Router /api/processes/{name}/schedule [get]
+func (h *ProcessManagerHandler) getSchedule(c *fiber.Ctx) error {
+	if !h.exists(c) {
+		return nil
+	}
+	times, err := h.processManager.UpcomingRuns(c.Params("name"), c.QueryInt("count", 5))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(api.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+	return c.JSON(times)
+}
+
+// @Summary Get process metrics
+// @Description Get the last samples of the CPU, memory, IO and child processes of all processes, ordered by name
+// @Tags processes
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} processmanager.ProcessMetrics
+// @Failure 401 {object} api.ErrorResponse
+// @Router /api/processes/metrics [get]
+func (h *ProcessManagerHandler) getMetrics(c *fiber.Ctx) error {
+	return c.JSON(h.processManager.Metrics())
+}
+
+// @Summary Export process definitions
+// @Description Get the definitions of all processes as process.start heroscript actions, ordered by name
+// @Tags processes
+// @Produce plain
+// @Security BearerAuth
+// @Success 200 {string} string
+// @Failure 401 {object} api.ErrorResponse
+// @Router /api/processes/export [get]
+func (h *ProcessManagerHandler) exportProcesses(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+	return c.SendString(h.processManager.Export())
+}
+
+// @Summary Import process definitions
+// @Description Start the processes defined by the process.start heroscript actions in the body. Processes that are defined the same already are left alone; others with the same name are replaced if replace is true.
+// @Tags processes
+// @Accept plain
+// @Produce json
+// @Security BearerAuth
+// @Param script body string true "Heroscript"
+// @Param replace query bool false "Replace processes that are defined otherwise"
+// @Success 200 {object} api.ImportProcessesResponse
+// @Failure 400 {object} api.ImportProcessesResponse
+// @Failure 401 {object} api.ErrorResponse
+// @Router /api/processes/import [post]
+func (h *ProcessManagerHandler) importProcesses(c *fiber.Ctx) error {
+	started, err := h.processManager.Import(string(c.Body()), c.QueryBool("replace"))
+	resp := api.ImportProcessesResponse{Started: started}
+	if resp.Started == nil {
+		resp.Started = []string{}
+	}
+	if err != nil {
+		resp.Error = err.Error()
+		return c.Status(fiber.StatusBadRequest).JSON(resp)
+	}
+	return c.JSON(resp)
+}
+
+// @Summary Stream process events
+// @Description Stream the events of processes, like started, exited, crashed and health-failed, as server-sent events with the event as JSON data
+// @Tags processes
+// @Produce text/event-stream
+// @Security BearerAuth
+// @Param name query string false "Comma separated names of the processes (default: all)"
+// @Success 200 {object} processmanager.Event
+// @Failure 401 {object} api.ErrorResponse
+// @Router /api/processes/events [get]
+func (h *ProcessManagerHandler) streamEvents(c *fiber.Ctx) error {
+	var names []string
+	for _, name := range strings.Split(c.Query("name"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := h.processManager.Events(ctx)
+
+		// The response only starts with the first write; tell the client
+		// right away that it is subscribed
+		fmt.Fprint(w, ": subscribed\n\n")
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		// A failed flush means the client is gone; the keep-alive comments
+		// notice that while no events happen
+		keepAlive := time.NewTicker(15 * time.Second)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case event := <-events:
+				if len(names) > 0 && !slices.Contains(names, event.Process) {
+					continue
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,376 @@
+package routes
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/herolauncher/api"
+	"github.com/freeflowuniverse/herolauncher/pkg/processmanager"
+	"github.com/gofiber/fiber/v2"
+)
+
+const testSecret = "secret"
+
+// processResponse holds the fields of processmanager.ProcessInfo the tests
+// check; the process info itself holds a mutex
+type processResponse struct {
+	Name    string                       `json:"name"`
+	Command string                       `json:"command"`
+	PID     int32                        `json:"pid"`
+	Status  processmanager.ProcessStatus `json:"status"`
+}
+
+// newProcessApp creates an app with the process manager routes and a
+// process manager that is stopped when the test ends
+func newProcessApp(t *testing.T) (*fiber.App, *processmanager.ProcessManager) {
+	t.Helper()
+	pm := processmanager.NewProcessManager(testSecret)
+	t.Cleanup(func() { pm.StopAll() })
+	app := fiber.New()
+	NewProcessManagerHandler(pm).RegisterRoutes(app)
+	return app, pm
+}
+
+// request sends an authenticated request to app and decodes the JSON
+// response into out, if given
+func request(t *testing.T, app *fiber.App, method, path, body string, out interface{}) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+testSecret)
+	if strings.HasPrefix(body, "{") {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	resp, err := app.Test(req, 5000)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("Failed to decode the response of %s %s: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestProcessRoutesAuthentication(t *testing.T) {
+	app, _ := newProcessApp(t)
+
+	for _, header := range []string{"", "Bearer wrong", "Basic " + testSecret, testSecret} {
+		req := httptest.NewRequest(http.MethodGet, "/api/processes", nil)
+		if header != "" {
+			req.Header.Set(fiber.HeaderAuthorization, header)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("GET failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != fiber.StatusUnauthorized {
+			t.Errorf("Authorization %q: status %d, want 401", header, resp.StatusCode)
+		}
+		if resp.Header.Get(fiber.HeaderWWWAuthenticate) == "" {
+			t.Errorf("Authorization %q: no WWW-Authenticate header", header)
+		}
+	}
+
+	if status := request(t, app, http.MethodGet, "/api/processes", "", nil); status != fiber.StatusOK {
+		t.Errorf("The secret as bearer token got status %d, want 200", status)
+	}
+
+	// Without a secret nothing is accepted
+	app = fiber.New()
+	NewProcessManagerHandler(processmanager.NewProcessManager("")).RegisterRoutes(app)
+	req := httptest.NewRequest(http.MethodGet, "/api/processes", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer ")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("A process manager without secret returned status %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestStartProcessRoute(t *testing.T) {
+	app, _ := newProcessApp(t)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "valid", body: `{"name": "sleeper", "command": "sleep 30"}`, status: fiber.StatusCreated},
+		{name: "duplicate", body: `{"name": "sleeper", "command": "sleep 30"}`, status: fiber.StatusBadRequest},
+		{name: "no name", body: `{"command": "sleep 30"}`, status: fiber.StatusBadRequest},
+		{name: "no command", body: `{"name": "other"}`, status: fiber.StatusBadRequest},
+		{name: "bad restart policy", body: `{"name": "other", "command": "true", "restart": "sometimes"}`, status: fiber.StatusBadRequest},
+		{name: "bad overlap policy", body: `{"name": "other", "command": "true", "cron": "* * * * *", "overlap": "maybe"}`, status: fiber.StatusBadRequest},
+		{name: "bad JSON", body: `{"name": `, status: fiber.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		var resp map[string]interface{}
+		status := request(t, app, http.MethodPost, "/api/processes", tt.body, &resp)
+		if status != tt.status {
+			t.Errorf("%s: status %d, want %d (%v)", tt.name, status, tt.status, resp)
+			continue
+		}
+		if status == fiber.StatusCreated && (resp["name"] != "sleeper" || resp["status"] != string(processmanager.ProcessStatusRunning)) {
+			t.Errorf("%s: started %v", tt.name, resp)
+		}
+		if status == fiber.StatusBadRequest && resp["error"] == "" {
+			t.Errorf("%s: no error message", tt.name)
+		}
+	}
+}
+
+func TestProcessLifecycleRoutes(t *testing.T) {
+	app, _ := newProcessApp(t)
+	if status := request(t, app, http.MethodPost, "/api/processes", `{"name": "sleeper", "command": "sleep 30"}`, nil); status != fiber.StatusCreated {
+		t.Fatalf("Starting the process returned status %d", status)
+	}
+
+	var list []processResponse
+	if status := request(t, app, http.MethodGet, "/api/processes", "", &list); status != fiber.StatusOK || len(list) != 1 || list[0].Name != "sleeper" {
+		t.Errorf("Listing returned status %d and %v", status, list)
+	}
+
+	var info processResponse
+	if status := request(t, app, http.MethodGet, "/api/processes/sleeper", "", &info); status != fiber.StatusOK || info.PID == 0 {
+		t.Errorf("Getting the process returned status %d and %+v", status, info)
+	}
+	pid := info.PID
+
+	if request(t, app, http.MethodPost, "/api/processes/sleeper/stop", "", &info); info.Status != processmanager.ProcessStatusStopped {
+		t.Errorf("After stopping the process is %s", info.Status)
+	}
+	if request(t, app, http.MethodPost, "/api/processes/sleeper/restart", "", &info); info.Status != processmanager.ProcessStatusRunning || info.PID == pid {
+		t.Errorf("After restarting the process is %s with PID %d", info.Status, info.PID)
+	}
+
+	// Routes on an unknown process
+	for _, route := range []struct{ method, path string }{
+		{http.MethodGet, "/api/processes/unknown"},
+		{http.MethodPost, "/api/processes/unknown/stop"},
+		{http.MethodPost, "/api/processes/unknown/restart"},
+		{http.MethodPost, "/api/processes/unknown/stdin"},
+		{http.MethodGet, "/api/processes/unknown/logs"},
+		{http.MethodGet, "/api/processes/unknown/logfiles"},
+		{http.MethodGet, "/api/processes/unknown/runs"},
+		{http.MethodGet, "/api/processes/unknown/schedule"},
+		{http.MethodDelete, "/api/processes/unknown"},
+	} {
+		if status := request(t, app, route.method, route.path, "", nil); status != fiber.StatusNotFound {
+			t.Errorf("%s %s: status %d, want 404", route.method, route.path, status)
+		}
+	}
+
+	var deleted api.DeleteProcessResponse
+	if status := request(t, app, http.MethodDelete, "/api/processes/sleeper", "", &deleted); status != fiber.StatusOK || !deleted.Success {
+		t.Errorf("Deleting returned status %d and %+v", status, deleted)
+	}
+	if status := request(t, app, http.MethodGet, "/api/processes/sleeper", "", nil); status != fiber.StatusNotFound {
+		t.Errorf("A deleted process got status %d, want 404", status)
+	}
+}
+
+func TestProcessInputRoutes(t *testing.T) {
+	app, _ := newProcessApp(t)
+	if status := request(t, app, http.MethodPost, "/api/processes", `{"name": "cat", "command": "cat", "stdin": true}`, nil); status != fiber.StatusCreated {
+		t.Fatalf("Starting the process returned status %d", status)
+	}
+	if status := request(t, app, http.MethodPost, "/api/processes/cat/stdin", `{"input": "hello\n"}`, nil); status != fiber.StatusOK {
+		t.Fatalf("Sending input returned status %d", status)
+	}
+
+	var logs api.ProcessLogsResponse
+	for i := 0; ; i++ {
+		request(t, app, http.MethodGet, "/api/processes/cat/logs?lines=5", "", &logs)
+		if strings.Contains(logs.Logs, "hello") {
+			break
+		}
+		if i == 50 {
+			t.Fatalf("The input is not in the logs: %q", logs.Logs)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Closing the input ends the process
+	if status := request(t, app, http.MethodPost, "/api/processes/cat/stdin", `{"eof": true}`, nil); status != fiber.StatusOK {
+		t.Fatalf("Closing the input returned status %d", status)
+	}
+	var info processResponse
+	for i := 0; ; i++ {
+		request(t, app, http.MethodGet, "/api/processes/cat", "", &info)
+		if info.Status != processmanager.ProcessStatusRunning {
+			break
+		}
+		if i == 50 {
+			t.Fatal("The process still runs after its input was closed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The input of a process without stdin cannot be written
+	request(t, app, http.MethodPost, "/api/processes", `{"name": "sleeper", "command": "sleep 30"}`, nil)
+	if status := request(t, app, http.MethodPost, "/api/processes/sleeper/stdin", `{"input": "hello\n"}`, nil); status != fiber.StatusBadRequest {
+		t.Errorf("Sending input to a process without stdin returned status %d, want 400", status)
+	}
+}
+
+func TestScheduleRoutes(t *testing.T) {
+	app, _ := newProcessApp(t)
+	request(t, app, http.MethodPost, "/api/processes", `{"name": "job", "command": "true", "cron": "0 3 * * *", "timezone": "UTC"}`, nil)
+	request(t, app, http.MethodPost, "/api/processes", `{"name": "sleeper", "command": "sleep 30"}`, nil)
+
+	var times []time.Time
+	if status := request(t, app, http.MethodGet, "/api/processes/job/schedule?count=3", "", &times); status != fiber.StatusOK || len(times) != 3 {
+		t.Fatalf("The schedule returned status %d and %v", status, times)
+	}
+	for i, next := range times {
+		if next.UTC().Hour() != 3 || next.Minute() != 0 {
+			t.Errorf("Run %d is at %v, want 03:00", i, next)
+		}
+		if i > 0 && next.Sub(times[i-1]) != 24*time.Hour {
+			t.Errorf("Run %d is %v after the one before", i, next.Sub(times[i-1]))
+		}
+	}
+	var runs []processmanager.CronRun
+	if status := request(t, app, http.MethodGet, "/api/processes/job/runs", "", &runs); status != fiber.StatusOK || len(runs) != 0 {
+		t.Errorf("The runs returned status %d and %v", status, runs)
+	}
+
+	// A process without a schedule
+	if status := request(t, app, http.MethodGet, "/api/processes/sleeper/schedule", "", nil); status != fiber.StatusBadRequest {
+		t.Errorf("The schedule of a process without cron returned status %d, want 400", status)
+	}
+	if status := request(t, app, http.MethodGet, "/api/processes/sleeper/runs", "", nil); status != fiber.StatusBadRequest {
+		t.Errorf("The runs of a process without cron returned status %d, want 400", status)
+	}
+}
+
+func TestExportImportRoutes(t *testing.T) {
+	app, _ := newProcessApp(t)
+	request(t, app, http.MethodPost, "/api/processes", `{"name": "sleeper", "command": "sleep 30", "restart": "always"}`, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/processes/export", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+testSecret)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Exporting failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	script := string(body)
+	if !strings.HasPrefix(resp.Header.Get(fiber.HeaderContentType), "text/plain") {
+		t.Errorf("The export has Content-Type %q", resp.Header.Get(fiber.HeaderContentType))
+	}
+	for _, want := range []string{"!!process.start", "name:'sleeper'", "restart:'always'"} {
+		if !strings.Contains(script, want) {
+			t.Errorf("The export does not contain %s:\n%s", want, script)
+		}
+	}
+
+	// Importing the export into another process manager starts the same
+	// processes, importing it again leaves them alone
+	other, _ := newProcessApp(t)
+	var imported api.ImportProcessesResponse
+	if status := request(t, other, http.MethodPost, "/api/processes/import", script, &imported); status != fiber.StatusOK ||
+		len(imported.Started) != 1 || imported.Started[0] != "sleeper" {
+		t.Errorf("Importing returned status %d and %+v", status, imported)
+	}
+	if status := request(t, other, http.MethodPost, "/api/processes/import", script, &imported); status != fiber.StatusOK || len(imported.Started) != 0 {
+		t.Errorf("Importing again returned status %d and %+v", status, imported)
+	}
+
+	// Another definition is only replaced on request
+	changed := strings.Replace(script, "sleep 30", "sleep 40", 1)
+	if status := request(t, other, http.MethodPost, "/api/processes/import", changed, &imported); status != fiber.StatusBadRequest || imported.Error == "" {
+		t.Errorf("Importing a changed definition returned status %d and %+v", status, imported)
+	}
+	if status := request(t, other, http.MethodPost, "/api/processes/import?replace=true", changed, &imported); status != fiber.StatusOK || len(imported.Started) != 1 {
+		t.Errorf("Replacing a changed definition returned status %d and %+v", status, imported)
+	}
+	var info processResponse
+	if request(t, other, http.MethodGet, "/api/processes/sleeper", "", &info); info.Command != "sleep 40" {
+		t.Errorf("The replaced process runs %q", info.Command)
+	}
+
+	var metrics []processmanager.ProcessMetrics
+	if status := request(t, other, http.MethodGet, "/api/processes/metrics", "", &metrics); status != fiber.StatusOK {
+		t.Errorf("The metrics returned status %d", status)
+	}
+}
+
+func TestEventRoute(t *testing.T) {
+	app, pm := newProcessApp(t)
+
+	// Events are streamed, which needs a real listener
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go app.Listener(listener)
+	// The stream only notices the client left with its next keep-alive
+	t.Cleanup(func() { app.ShutdownWithTimeout(100 * time.Millisecond) })
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+listener.Addr().String()+"/api/processes/events?name=watched", nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+testSecret)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to get the events: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get(fiber.HeaderContentType) != "text/event-stream" {
+		t.Errorf("The events have Content-Type %q", resp.Header.Get(fiber.HeaderContentType))
+	}
+
+	// The response starts once the stream subscribed
+	if err := pm.StartProcess("ignored", "sleep 30", false, 0, "", ""); err != nil {
+		t.Fatalf("Failed to start a process: %v", err)
+	}
+	if err := pm.StartProcess("watched", "sleep 30", false, 0, "", ""); err != nil {
+		t.Fatalf("Failed to start a process: %v", err)
+	}
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	var event, data string
+	for event == "" || data == "" {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("The event stream ended")
+			}
+			if value, ok := strings.CutPrefix(line, "event: "); ok {
+				event = value
+			}
+			if value, ok := strings.CutPrefix(line, "data: "); ok {
+				data = value
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("No event was streamed")
+		}
+	}
+	var got processmanager.Event
+	if err := json.Unmarshal([]byte(data), &got); err != nil {
+		t.Fatalf("The event data is not JSON: %v", err)
+	}
+	if event != string(processmanager.EventStarted) || got.Process != "watched" || got.Type != processmanager.EventStarted {
+		t.Errorf("Streamed event %s with %+v, want started for watched", event, got)
+	}
+}
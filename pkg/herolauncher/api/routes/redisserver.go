@@ -33,7 +33,12 @@ func (h *RedisHandler) RegisterRoutes(app *fiber.App) {
 	group.Post("/hdel", h.hdelKey)
 	group.Get("/hkeys/:key", h.hkeysKey)
 	group.Get("/hlen/:key", h.hlenKey)
+	group.Get("/hgetall/:key", h.hgetallKey)
+	group.Post("/hmset", h.hmsetKey)
+	group.Get("/hexists/:key/:field", h.hexistsKey)
+	group.Post("/hincrby", h.hincrbyKey)
 	group.Post("/incr/:key", h.incrKey)
+	group.Get("/stats", h.getStats)
 }
 
 // @Summary Set a key
@@ -236,6 +241,98 @@ func (h *RedisHandler) hlenKey(c *fiber.Ctx) error {
 	})
 }
 
+// @Summary Get a hash
+// @Description Get all fields and values of a hash stored at key
+// @Tags redis
+// @Produce json
+// @Param key path string true "Hash key"
+// @Success 200 {object} api.HGetAllResponse
+// @Router /api/redis/hgetall/{key} [get]
+func (h *RedisHandler) hgetallKey(c *fiber.Ctx) error {
+	key := c.Params("key")
+
+	fields := h.redisServer.HGetAll(key)
+
+	return c.JSON(api.HGetAllResponse{
+		Fields: fields,
+	})
+}
+
+// @Summary Set hash fields
+// @Description Set several fields of a hash stored at key
+// @Tags redis
+// @Accept json
+// @Produce json
+// @Param data body api.HMSetKeyRequest true "Hash fields to set"
+// @Success 200 {object} api.HMSetKeyResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Router /api/redis/hmset [post]
+func (h *RedisHandler) hmsetKey(c *fiber.Ctx) error {
+	var req api.HMSetKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(api.ErrorResponse{
+			Error: "Invalid request: " + err.Error(),
+		})
+	}
+	if len(req.Fields) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(api.ErrorResponse{
+			Error: "Invalid request: no fields",
+		})
+	}
+
+	added := h.redisServer.HMSet(req.Key, req.Fields)
+
+	return c.JSON(api.HMSetKeyResponse{
+		Added: added,
+	})
+}
+
+// @Summary Check a hash field
+// @Description Check whether a field exists in a hash stored at key
+// @Tags redis
+// @Produce json
+// @Param key path string true "Hash key"
+// @Param field path string true "Hash field"
+// @Success 200 {object} api.HExistsResponse
+// @Router /api/redis/hexists/{key}/{field} [get]
+func (h *RedisHandler) hexistsKey(c *fiber.Ctx) error {
+	key := c.Params("key")
+	field := c.Params("field")
+
+	return c.JSON(api.HExistsResponse{
+		Exists: h.redisServer.HExists(key, field),
+	})
+}
+
+// @Summary Increment a hash field
+// @Description Increment the integer value of a field in a hash stored at key
+// @Tags redis
+// @Accept json
+// @Produce json
+// @Param data body api.HIncrByRequest true "Hash field and increment"
+// @Success 200 {object} api.IncrKeyResponse
+// @Failure 400 {object} api.ErrorResponse
+// @Router /api/redis/hincrby [post]
+func (h *RedisHandler) hincrbyKey(c *fiber.Ctx) error {
+	var req api.HIncrByRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(api.ErrorResponse{
+			Error: "Invalid request: " + err.Error(),
+		})
+	}
+
+	value, err := h.redisServer.HIncrBy(req.Key, req.Field, req.Increment)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(api.ErrorResponse{
+			Error: err.Error(),
+		})
+	}
+
+	return c.JSON(api.IncrKeyResponse{
+		Value: value,
+	})
+}
+
 // @Summary Increment a key
 // @Description Increment the integer value of a key by one
 // @Tags redis
@@ -258,3 +355,13 @@ func (h *RedisHandler) incrKey(c *fiber.Ctx) error {
 		Value: value,
 	})
 }
+
+// @Summary Get server statistics
+// @Description Get the numbers reported by the INFO command: clients, commands, keyspace and memory usage
+// @Tags redis
+// @Produce json
+// @Success 200 {object} redisserver.Stats
+// @Router /api/redis/stats [get]
+func (h *RedisHandler) getStats(c *fiber.Ctx) error {
+	return c.JSON(h.redisServer.Stats())
+}
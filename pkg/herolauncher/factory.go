@@ -14,8 +14,10 @@ import (
 	"github.com/freeflowuniverse/herolauncher/pkg/herolauncher/api"
 	"github.com/freeflowuniverse/herolauncher/pkg/herolauncher/api/routes"
 	"github.com/freeflowuniverse/herolauncher/pkg/packagemanager"
+	"github.com/freeflowuniverse/herolauncher/pkg/processmanager"
 	"github.com/freeflowuniverse/herolauncher/pkg/redisserver"
 	"github.com/freeflowuniverse/herolauncher/pkg/system/stats"
+	"github.com/freeflowuniverse/herolauncher/pkg/system/stats/metrics"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -29,6 +31,28 @@ type Config struct {
 	Port            string
 	RedisTCPPort    string
 	RedisSocketPath string
+	// RedisDataDir is where the Redis data is saved, with a snapshot and an
+	// append-only file. The data is only kept in memory if it is empty.
+	RedisDataDir string
+	// ProcessManagerSecret is the token for the process manager API. The
+	// API is not served if it is empty.
+	ProcessManagerSecret string
+	// ProcessManagerStateFile is where the process manager saves the
+	// definitions of its processes, to start them again when the server
+	// restarts. They are not saved if it is empty.
+	ProcessManagerStateFile string
+	// ProcessManagerInitFile is a heroscript file of process.start actions
+	// that the process manager applies at startup, after restoring its
+	// state. Its definitions replace the restored ones of its processes.
+	ProcessManagerInitFile string
+	// AlertRulesFile is a JSON file with an array of stats.AlertRule that
+	// are evaluated on the stats of the system. There are no alerts if it is
+	// empty.
+	AlertRulesFile string
+	// StatsConfigFile is a heroscript file of stats.collector actions that
+	// disable or schedule the collectors of the stats. All collectors are
+	// enabled with their default expiration if it is empty.
+	StatsConfigFile string
 	TemplatesPath   string
 	StaticFilesPath string
 }
@@ -46,11 +70,17 @@ func DefaultConfig() Config {
 	}
 
 	return Config{
-		Port:            port,
-		RedisTCPPort:    "6379",
-		RedisSocketPath: "/tmp/herolauncher_new.sock",
-		TemplatesPath:   filepath.Join(projectRoot, "pkg/herolauncher/web/templates"),
-		StaticFilesPath: filepath.Join(projectRoot, "pkg/herolauncher/web/static"),
+		Port:                    port,
+		RedisTCPPort:            "6379",
+		RedisSocketPath:         "/tmp/herolauncher_new.sock",
+		RedisDataDir:            os.Getenv("REDIS_DATA_DIR"),
+		ProcessManagerSecret:    os.Getenv("PROCESS_MANAGER_SECRET"),
+		ProcessManagerStateFile: os.Getenv("PROCESS_MANAGER_STATE_FILE"),
+		ProcessManagerInitFile:  os.Getenv("PROCESS_MANAGER_INIT_FILE"),
+		AlertRulesFile:          os.Getenv("ALERT_RULES_FILE"),
+		StatsConfigFile:         os.Getenv("STATS_CONFIG_FILE"),
+		TemplatesPath:           filepath.Join(projectRoot, "pkg/herolauncher/web/templates"),
+		StaticFilesPath:         filepath.Join(projectRoot, "pkg/herolauncher/web/static"),
 	}
 }
 
@@ -60,6 +90,7 @@ type HeroLauncher struct {
 	redisServer     *redisserver.Server
 	executorService *executor.Executor
 	packageManager  *packagemanager.PackageManager
+	processManager  *processmanager.ProcessManager
 	config          Config
 	startTime       time.Time
 }
@@ -67,12 +98,29 @@ type HeroLauncher struct {
 // New creates a new instance of HeroLauncher with the provided configuration
 func New(config Config) *HeroLauncher {
 	// Initialize modules
-	redisServer := redisserver.NewServer(redisserver.ServerConfig{
+	redisConfig := redisserver.ServerConfig{
 		TCPPort:        config.RedisTCPPort,
 		UnixSocketPath: config.RedisSocketPath,
-	})
+		Metrics:        metrics.Default,
+	}
+	if config.RedisDataDir != "" {
+		redisConfig.SnapshotPath = filepath.Join(config.RedisDataDir, "dump.hrdb")
+		redisConfig.AOFPath = filepath.Join(config.RedisDataDir, "appendonly.aof")
+	}
+	redisServer := redisserver.NewServer(redisConfig)
 	executorService := executor.NewExecutor()
 	packageManagerService := packagemanager.NewPackageManager()
+	processManagerService := processmanager.NewProcessManager(config.ProcessManagerSecret)
+	if config.ProcessManagerStateFile != "" {
+		if err := processManagerService.LoadState(config.ProcessManagerStateFile); err != nil {
+			log.Printf("Failed to restore managed processes: %v", err)
+		}
+	}
+	if config.ProcessManagerInitFile != "" {
+		if _, err := processManagerService.ImportFile(config.ProcessManagerInitFile, true); err != nil {
+			log.Printf("Failed to apply %s: %v", config.ProcessManagerInitFile, err)
+		}
+	}
 
 	// Initialize template engine with debugging enabled
 	// Use absolute path for templates to avoid path resolution issues
@@ -104,6 +152,13 @@ func New(config Config) *HeroLauncher {
 	// Swagger documentation
 	app.Get("/swagger/*", swagger.HandlerDefault)
 
+	// Metrics of the embedded Redis server in the Prometheus text format
+	app.Get("/metrics", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+		_, err := metrics.Default.WriteTo(c)
+		return err
+	})
+
 	// Static files - serve all directories with proper paths
 	app.Static("/", config.StaticFilesPath)
 	app.Static("/css", config.StaticFilesPath+"/css")
@@ -117,6 +172,7 @@ func New(config Config) *HeroLauncher {
 		redisServer:     redisServer,
 		executorService: executorService,
 		packageManager:  packageManagerService,
+		processManager:  processManagerService,
 		config:          config,
 		startTime:       time.Now(),
 	}
@@ -133,11 +189,34 @@ func (hl *HeroLauncher) setupRoutes() {
 	executorHandler := routes.NewExecutorHandler(hl.executorService)
 	packageManagerHandler := routes.NewPackageManagerHandler(hl.packageManager)
 	redisHandler := routes.NewRedisHandler(hl.redisServer)
-	// Initialize StatsManager
-	statsManager, err := stats.NewStatsManagerWithDefaults()
+	// Initialize StatsManager. The actions of alerts are process actions,
+	// like restarting a process.
+	statsConfig := stats.DefaultConfig()
+	if hl.config.StatsConfigFile != "" {
+		collectors, err := stats.LoadCollectorConfig(hl.config.StatsConfigFile)
+		if err != nil {
+			log.Printf("Warning: Failed to load stats config: %v\n", err)
+		}
+		statsConfig.Collectors = collectors
+	}
+	statsConfig.RunAlertAction = func(script string) error {
+		_, err := hl.processManager.RunHeroscript(script)
+		return err
+	}
+	statsManager, err := stats.NewStatsManager(statsConfig)
 	if err != nil {
 		log.Printf("Warning: Failed to initialize StatsManager: %v\n", err)
 		statsManager = nil
+	} else {
+		// The dashboard charts the resources of the managed processes,
+		// which the process manager samples every 5 seconds
+		err = statsManager.RegisterSource(routes.ManagedProcessStats, func() (interface{}, error) {
+			return hl.processManager.Metrics(), nil
+		}, 5*time.Second)
+		if err != nil {
+			log.Printf("Warning: Failed to register process manager stats: %v\n", err)
+		}
+		hl.setupAlerts(statsManager)
 	}
 
 	// Pass HeroLauncher as an UptimeProvider and StatsManager
@@ -148,6 +227,53 @@ func (hl *HeroLauncher) setupRoutes() {
 	packageManagerHandler.RegisterRoutes(hl.app)
 	redisHandler.RegisterRoutes(hl.app)
 	adminHandler.RegisterRoutes(hl.app)
+
+	// The process manager can run any command, so it is only served with a
+	// secret to authenticate with
+	if hl.config.ProcessManagerSecret != "" {
+		routes.NewProcessManagerHandler(hl.processManager).RegisterRoutes(hl.app)
+	} else {
+		log.Println("PROCESS_MANAGER_SECRET is not set, the process manager API is disabled")
+	}
+}
+
+// setupAlerts adds the alert rules of the config to the StatsManager. Rules
+// can check that a managed process is running with "managed_process < 1",
+// which counts the running processes of the process manager with the name
+// of the rule.
+func (hl *HeroLauncher) setupAlerts(statsManager *stats.StatsManager) {
+	err := statsManager.RegisterAlertMetric(routes.ManagedProcessStats, func(rule stats.AlertRule) (float64, error) {
+		if rule.Process == "" {
+			return 0, fmt.Errorf("process is required for the %s metric", rule.Metric)
+		}
+		var metrics []processmanager.ProcessMetrics
+		if err := statsManager.GetStats(routes.ManagedProcessStats, &metrics); err != nil {
+			return 0, err
+		}
+		for _, m := range metrics {
+			if m.Name == rule.Process && m.Status == processmanager.ProcessStatusRunning {
+				return 1, nil
+			}
+		}
+		return 0, nil
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to register managed process alerts: %v\n", err)
+	}
+
+	if hl.config.AlertRulesFile == "" {
+		return
+	}
+	rules, err := stats.LoadAlertRules(hl.config.AlertRulesFile)
+	if err != nil {
+		log.Printf("Warning: Failed to load alert rules: %v\n", err)
+		return
+	}
+	for _, rule := range rules {
+		if err := statsManager.AddAlertRule(rule); err != nil {
+			log.Printf("Warning: Failed to add alert rule: %v\n", err)
+		}
+	}
 }
 
 // GetUptime returns the uptime of the HeroLauncher server as a formatted string
@@ -172,6 +298,9 @@ func (hl *HeroLauncher) Start() error {
 		<-c
 		log.Println("Shutting down server...")
 		_ = hl.app.Shutdown()
+		if err := hl.redisServer.Close(); err != nil {
+			log.Printf("Failed to save Redis data: %v", err)
+		}
 	}()
 
 	// Start server
@@ -3,7 +3,6 @@ package main
 import (
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"strings"
@@ -51,11 +50,7 @@ func handleParseCommand(file, text string, priority int) {
 
 	// Parse from file or text
 	if file != "" {
-		content, err := ioutil.ReadFile(file)
-		if err != nil {
-			log.Fatalf("Failed to read file: %v", err)
-		}
-		pb, err = playbook.NewFromText(string(content))
+		pb, err = playbook.NewFromFile(file, priority)
 	} else if text != "" {
 		pb, err = playbook.NewFromText(text)
 	} else {
@@ -102,11 +97,7 @@ func handleExecuteCommand(file, text string, priority int, actor, action string)
 
 	// Parse from file or text
 	if file != "" {
-		content, err := ioutil.ReadFile(file)
-		if err != nil {
-			log.Fatalf("Failed to read file: %v", err)
-		}
-		pb, err = playbook.NewFromText(string(content))
+		pb, err = playbook.NewFromFile(file, priority)
 	} else if text != "" {
 		pb, err = playbook.NewFromText(text)
 	} else {
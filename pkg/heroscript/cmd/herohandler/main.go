@@ -2,15 +2,38 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory/generator"
 	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/cmd/herohandler/internal"
 )
 
 func main() {
+	// Generate the skeleton of a handler from the definition of an actor
+	if len(os.Args) > 1 && os.Args[1] == "generate" {
+		if err := generate(os.Args[2:]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	// Generate a client of the actions of actors in another language
+	if len(os.Args) > 1 && os.Args[1] == "client" {
+		if err := client(os.Args[2:]); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Create a new example handler
 	handler := internal.NewExampleHandler()
 
@@ -51,6 +74,9 @@ func main() {
 func printUsage() {
 	fmt.Println("Usage: herohandler <action>")
 	fmt.Println("       cat script.hero | herohandler")
+	fmt.Println("       herohandler generate [-o dir] [-package name] [-force] <actor.hero|actor.yaml>")
+	fmt.Println("       herohandler client [-lang rust|python] [-o dir] [-name name] [-force] <actor.hero|actor.yaml ...>")
+	fmt.Println("       herohandler client [-lang rust|python] [-o dir] [-name name] [-force] -target tcp://host:port|socket [-secret secret]")
 	fmt.Println("\nExample commands:")
 	fmt.Println("  example.set key:mykey value:myvalue")
 	fmt.Println("  example.get key:mykey")
@@ -99,3 +125,104 @@ func processStdin(handler *internal.ExampleHandler) {
 	// Print the result
 	fmt.Println(result)
 }
+
+// generate writes the handler of the actor defined in a heroscript or YAML
+// file and its tests. Existing files are not overwritten without -force, as
+// the generated handler is implemented by hand.
+func generate(args []string) error {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	dir := flags.String("o", ".", "directory to write the handler to")
+	pkg := flags.String("package", "", "Go package of the handler, instead of the one of the definition")
+	force := flags.Bool("force", false, "overwrite existing files")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected the file with the definition of the actor")
+	}
+
+	path := flags.Arg(0)
+	actor, err := generator.LoadActor(path)
+	if err != nil {
+		return err
+	}
+	if *pkg != "" {
+		actor.Package = *pkg
+	}
+
+	files, err := generator.Generate(actor, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	return writeFiles(*dir, files, *force)
+}
+
+// client writes a Rust or Python client of the actions of the actors
+// defined in heroscript or YAML files, or of the handlers of the telnet
+// server at -target
+func client(args []string) error {
+	flags := flag.NewFlagSet("client", flag.ExitOnError)
+	lang := flags.String("lang", generator.LanguageRust, "language of the client, rust or python")
+	dir := flags.String("o", ".", "directory to write the client to")
+	name := flags.String("name", "heroclient", "name of the crate or module of the client")
+	target := flags.String("target", "", "telnet server to get the actions from, as tcp://host:port or the path of a Unix socket")
+	secret := flags.String("secret", os.Getenv("HERO_SECRET"), "secret to authenticate with, HERO_SECRET by default")
+	force := flags.Bool("force", false, "overwrite existing files")
+	flags.Parse(args)
+	if (*target == "") == (flags.NArg() == 0) {
+		return fmt.Errorf("expected the files with the definitions of the actors, or -target")
+	}
+
+	var actions []handlerfactory.ActionInfo
+	var sources []string
+	if *target != "" {
+		c := handlerfactory.NewClient(*target, *secret)
+		defer c.Close()
+		var err error
+		if actions, err = c.Actions(context.Background()); err != nil {
+			return err
+		}
+		sources = append(sources, *target)
+	}
+	for _, path := range flags.Args() {
+		actor, err := generator.LoadActor(path)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, actor.ActionInfos()...)
+		sources = append(sources, filepath.Base(path))
+	}
+
+	files, err := generator.GenerateClient(*lang, *name, strings.Join(sources, ", "), actions)
+	if err != nil {
+		return err
+	}
+	return writeFiles(*dir, files, *force)
+}
+
+// writeFiles writes generated files to a directory. Existing files are not
+// overwritten unless force is set.
+func writeFiles(dir string, files map[string][]byte, force bool) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if !force {
+		for _, name := range names {
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				return fmt.Errorf("%s exists, use -force to overwrite it", filepath.Join(dir, name))
+			}
+		}
+	}
+
+	for _, name := range names {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(file), err)
+		}
+		if err := os.WriteFile(file, files[name], 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file, err)
+		}
+		fmt.Printf("Generated %s\n", file)
+	}
+	return nil
+}
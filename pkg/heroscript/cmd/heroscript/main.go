@@ -0,0 +1,206 @@
+// Command heroscript formats and lints heroscript files, the way gofmt and
+// go vet do for Go, so it can be run by editors and in CI.
+//
+// Usage:
+//
+//	heroscript fmt [-w] [-l] [file ...]
+//	heroscript lint [-schemas actions.json] [-target tcp://localhost:8024] [-secret secret] [-json] [file ...]
+//
+// fmt prints the files in the standard layout of playbook.Format, or
+// rewrites them with -w. With -l it only lists the files that are not
+// formatted, and exits with status 1 if there are any.
+//
+// lint prints the problems of the files as file:line:column: severity:
+// message, or as JSON with -json, and exits with status 1 if there are
+// errors. The actions and their params are checked against the schemas of
+// the handlers, read from a JSON file as !!schemas on the telnet server of a
+// HandlerFactory shows them, or from the server at -target.
+//
+// The files are read from stdin if there are none or for -. The secret can
+// also be given with the HERO_SECRET environment variable.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+)
+
+func main() {
+	if len(os.Args) < 2 || (os.Args[1] != "fmt" && os.Args[1] != "lint") {
+		fmt.Fprintln(os.Stderr, "Usage: heroscript fmt [-w] [-l] [file ...]")
+		fmt.Fprintln(os.Stderr, "       heroscript lint [-schemas actions.json] [-target tcp://host:port|socket] [-secret secret] [-json] [file ...]")
+		os.Exit(2)
+	}
+	command := os.Args[1]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	var ok bool
+	var err error
+	if command == "fmt" {
+		write := flags.Bool("w", false, "write the formatted scripts to their files")
+		list := flags.Bool("l", false, "list the files that are not formatted")
+		flags.Parse(os.Args[2:])
+		ok, err = format(files(flags), *write, *list)
+	} else {
+		schemas := flags.String("schemas", "", "JSON file with the actions of the handlers and their schemas")
+		target := flags.String("target", "", "telnet server to get the actions from, as tcp://host:port or the path of a Unix socket")
+		secret := flags.String("secret", os.Getenv("HERO_SECRET"), "secret to authenticate with, HERO_SECRET by default")
+		jsonOutput := flags.Bool("json", false, "print the problems as JSON")
+		flags.Parse(os.Args[2:])
+		ok, err = lint(files(flags), *schemas, *target, *secret, *jsonOutput)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// files returns the files of the arguments, - for stdin if there are none
+func files(flags *flag.FlagSet) []string {
+	if flags.NArg() == 0 {
+		return []string{"-"}
+	}
+	return flags.Args()
+}
+
+// readFile reads a file, or stdin if it is -
+func readFile(file string) (string, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	return string(data), err
+}
+
+// displayName returns the name of a file in the output
+func displayName(file string) string {
+	if file == "-" {
+		return "<stdin>"
+	}
+	return file
+}
+
+// format formats the files and reports whether they were all formatted
+// already
+func format(files []string, write, list bool) (bool, error) {
+	formatted := true
+	for _, file := range files {
+		text, err := readFile(file)
+		if err != nil {
+			return false, err
+		}
+		out := playbook.Format(text)
+		changed := out != text
+		if changed {
+			formatted = false
+		}
+
+		switch {
+		case list:
+			if changed {
+				fmt.Println(displayName(file))
+			}
+		case write && file != "-":
+			if !changed {
+				continue
+			}
+			info, err := os.Stat(file)
+			if err != nil {
+				return false, err
+			}
+			if err := os.WriteFile(file, []byte(out), info.Mode().Perm()); err != nil {
+				return false, err
+			}
+		default:
+			fmt.Print(out)
+		}
+	}
+	// Formatting only fails for -l, which checks the files
+	return formatted || !list, nil
+}
+
+// fileDiagnostic is a problem of a file, as -json prints it
+type fileDiagnostic struct {
+	File string `json:"file"`
+	playbook.Diagnostic
+}
+
+// lint lints the files and reports whether they have no errors
+func lint(files []string, schemas, target, secret string, jsonOutput bool) (bool, error) {
+	actions, err := loadActions(schemas, target, secret)
+	if err != nil {
+		return false, err
+	}
+
+	ok := true
+	diagnostics := []fileDiagnostic{}
+	for _, file := range files {
+		text, err := readFile(file)
+		if err != nil {
+			return false, err
+		}
+		var found []playbook.Diagnostic
+		if actions != nil {
+			found = handlerfactory.Lint(text, actions)
+		} else {
+			found = playbook.Lint(text)
+		}
+		for _, d := range found {
+			if d.Severity == playbook.SeverityError {
+				ok = false
+			}
+			diagnostics = append(diagnostics, fileDiagnostic{File: displayName(file), Diagnostic: d})
+		}
+	}
+
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		return ok, encoder.Encode(diagnostics)
+	}
+	for _, d := range diagnostics {
+		fmt.Printf("%s:%s\n", d.File, d.Diagnostic)
+	}
+	return ok, nil
+}
+
+// loadActions returns the actions to check the scripts against, from the
+// schemas file or the server at target, or nil if neither is given
+func loadActions(schemas, target, secret string) ([]handlerfactory.ActionInfo, error) {
+	var actions []handlerfactory.ActionInfo
+	switch {
+	case schemas != "":
+		data, err := os.ReadFile(schemas)
+		if err != nil {
+			return nil, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&actions); err != nil {
+			return nil, fmt.Errorf("invalid schemas %s: %w", schemas, err)
+		}
+	case target != "":
+		client := handlerfactory.NewClient(target, secret)
+		defer client.Close()
+		var err error
+		if actions, err = client.Actions(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	return actions, nil
+}
@@ -31,7 +31,7 @@ func (h *AuthHandler) Auth(script string) string {
 		return fmt.Sprintf("Error parsing parameters: %v", err)
 	}
 
-	secret := params.Get("secret")
+	secret := params.GetVerbatim("secret")
 	if secret == "" {
 		return "Error: secret is required"
 	}
@@ -55,35 +55,29 @@ func (f *HandlerFactory) ProcessHeroscript(script string) (string, error) {
 		return "", fmt.Errorf("no actions found in script")
 	}
 
-	// Group actions by actor
-	actorActions := make(map[string][]*playbook.Action)
-	for _, action := range pb.Actions {
-		actorActions[action.Actor] = append(actorActions[action.Actor], action)
-	}
-
 	var results []string
 
-	// Process actions for each actor
-	for actorName, actions := range actorActions {
-		handler, err := f.GetHandler(actorName)
+	// Process the actions in order, each by the handler of its actor, with
+	// their conditions and loops
+	err = pb.Execute(func(action *playbook.Action) (string, error) {
+		handler, err := f.GetHandler(action.Actor)
 		if err != nil {
 			return "", err
 		}
-
-		// Create a playbook with just this actor's actions
-		actorPB := playbook.New()
-		for _, action := range actions {
-			actorAction := actorPB.NewAction(action.CID, action.Name, action.Actor, action.Priority, action.ActionType)
-			actorAction.Params = action.Params
+		if err := handlerfactory.ValidateAction(handler, action); err != nil {
+			return "", err
 		}
 
-		// Process the actions
-		result, err := handler.Play(actorPB.HeroScript(true), handler)
+		result, err := handler.Play(action.HeroScript(), handler)
 		if err != nil {
 			return "", err
 		}
 
 		results = append(results, result)
+		return result, nil
+	})
+	if err != nil {
+		return "", err
 	}
 
 	return strings.Join(results, "\n"), nil
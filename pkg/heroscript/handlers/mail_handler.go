@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailauth"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailsearch"
+)
+
+// MailHandler gives access to the messages of the mail store:
+//
+//	!!mail.search name:alice query:'invoice march' folder:'inbox'
+//	!!mail.reindex name:alice
+//
+// A search returns the keys of the messages of the user containing all
+// words of the query in their subject, addresses, body or attachment names,
+// in a folder or, without one, in all folders. Messages are indexed when
+// they are stored; reindex indexes all messages of a user again, like the
+// ones stored before the index existed.
+type MailHandler struct {
+	BaseHandler
+	index *mailsearch.Index
+}
+
+// NewMailHandler creates a new mail handler
+func NewMailHandler(index *mailsearch.Index) *MailHandler {
+	return &MailHandler{
+		BaseHandler: BaseHandler{
+			BaseHandler: handlerfactory.BaseHandler{
+				ActorName: "mail",
+			},
+		},
+		index: index,
+	}
+}
+
+// Search handles the mail.search action
+func (h *MailHandler) Search(script string) string {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return fmt.Sprintf("Error parsing parameters: %v", err)
+	}
+
+	name := params.Get("name")
+	query := params.GetVerbatim("query")
+	if name == "" || query == "" {
+		return "Error: name and query are required"
+	}
+	username := mailauth.NormalizeUsername(name)
+
+	keys, err := h.index.Search(username, query, params.GetVerbatim("folder"))
+	if err != nil {
+		return fmt.Sprintf("Error searching mail: %v", err)
+	}
+	if len(keys) == 0 {
+		return fmt.Sprintf("No messages of mail user %s match '%s'", username, query)
+	}
+	lines := []string{fmt.Sprintf("Messages of mail user %s matching '%s':", username, query)}
+	lines = append(lines, keys...)
+	return strings.Join(lines, "\n")
+}
+
+// Reindex handles the mail.reindex action
+func (h *MailHandler) Reindex(script string) string {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return fmt.Sprintf("Error parsing parameters: %v", err)
+	}
+
+	name := params.Get("name")
+	if name == "" {
+		return "Error: name is required"
+	}
+	username := mailauth.NormalizeUsername(name)
+
+	count, err := h.index.Reindex(username)
+	if err != nil {
+		return fmt.Sprintf("Error indexing mail: %v", err)
+	}
+	return fmt.Sprintf("Indexed %d messages of mail user %s", count, username)
+}
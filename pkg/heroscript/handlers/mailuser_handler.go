@@ -0,0 +1,355 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/handlerfactory"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailauth"
+)
+
+// MailUserHandler manages the users of the IMAP and SMTP servers:
+//
+//	!!mailuser.add name:alice password:'secret'
+//	!!mailuser.password name:alice password:'new secret'
+//	!!mailuser.delete name:alice
+//	!!mailuser.list
+//	!!mailuser.quota name:alice storage:500mb messages:10000
+//	!!mailuser.rules name:alice path:'/etc/mail/alice.hero'
+//	!!mailuser.alias address:'sales@example.com' to:'alice,bob'
+//	!!mailuser.unalias address:'sales@example.com'
+//	!!mailuser.aliases
+//	!!mailuser.webhook url:'https://example.com/hook' to:'alice' folder:'inbox' secret:'key'
+//	!!mailuser.webhook_delete id:3f2a9c0d1b4e5f60
+//	!!mailuser.webhooks
+//
+// The quota limits the size, in bytes or with a kb, mb or gb suffix, and the
+// number of the user's messages. A limit of 0 removes it. Without limits the
+// quota and usage are reported.
+//
+// The rules file holds the !!mailrule actions the SMTP server files,
+// forwards or discards the user's mail by, see package mailrules. With
+// clear:1 the rules are removed, without a path they are shown.
+//
+// An alias routes the mail for an address to the users and addresses in
+// to:, several make a distribution list. The alias '@example.com' is the
+// catch-all of the domain.
+//
+// A webhook is called with the email JSON whenever the SMTP server stores a
+// message, optionally only for a user, recipient address or '@domain' in
+// to: and a folder and its children in folder:.
+type MailUserHandler struct {
+	BaseHandler
+	store *mailauth.Store
+}
+
+// NewMailUserHandler creates a new mail user handler
+func NewMailUserHandler(store *mailauth.Store) *MailUserHandler {
+	return &MailUserHandler{
+		BaseHandler: BaseHandler{
+			BaseHandler: handlerfactory.BaseHandler{
+				ActorName: "mailuser",
+			},
+		},
+		store: store,
+	}
+}
+
+// Add handles the mailuser.add action
+func (h *MailUserHandler) Add(script string) string {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return fmt.Sprintf("Error parsing parameters: %v", err)
+	}
+
+	name := params.Get("name")
+	password := params.GetVerbatim("password")
+	if name == "" || password == "" {
+		return "Error: name and password are required"
+	}
+
+	if err := h.store.Add(name, password); err != nil {
+		return fmt.Sprintf("Error adding mail user: %v", err)
+	}
+	return fmt.Sprintf("Mail user %s added", mailauth.NormalizeUsername(name))
+}
+
+// Password handles the mailuser.password action
+func (h *MailUserHandler) Password(script string) string {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return fmt.Sprintf("Error parsing parameters: %v", err)
+	}
+
+	name := params.Get("name")
+	password := params.GetVerbatim("password")
+	if name == "" || password == "" {
+		return "Error: name and password are required"
+	}
+
+	if err := h.store.SetPassword(name, password); err != nil {
+		return fmt.Sprintf("Error changing password: %v", err)
+	}
+	return fmt.Sprintf("Password of mail user %s changed", mailauth.NormalizeUsername(name))
+}
+
+// Delete handles the mailuser.delete action
+func (h *MailUserHandler) Delete(script string) string {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return fmt.Sprintf("Error parsing parameters: %v", err)
+	}
+
+	name := params.Get("name")
+	if name == "" {
+		return "Error: name is required"
+	}
+
+	if err := h.store.Remove(name); err != nil {
+		return fmt.Sprintf("Error deleting mail user: %v", err)
+	}
+	return fmt.Sprintf("Mail user %s deleted", mailauth.NormalizeUsername(name))
+}
+
+// List handles the mailuser.list action
+func (h *MailUserHandler) List(script string) string {
+	users, err := h.store.List()
+	if err != nil {
+		return fmt.Sprintf("Error listing mail users: %v", err)
+	}
+	if len(users) == 0 {
+		return "No mail users"
+	}
+	return "Mail users: " + strings.Join(users, ", ")
+}
+
+// Quota handles the mailuser.quota action
+func (h *MailUserHandler) Quota(script string) string {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return fmt.Sprintf("Error parsing parameters: %v", err)
+	}
+
+	name := params.Get("name")
+	if name == "" {
+		return "Error: name is required"
+	}
+	username := mailauth.NormalizeUsername(name)
+
+	if params.Has("storage") || params.Has("messages") {
+		quota, err := h.store.Quota(name)
+		if err != nil {
+			return fmt.Sprintf("Error getting quota: %v", err)
+		}
+		if params.Has("storage") {
+			if quota.Storage, err = params.GetSize("storage"); err != nil {
+				return fmt.Sprintf("Error: invalid storage: %v", err)
+			}
+		}
+		if params.Has("messages") {
+			messages, err := params.GetInt("messages")
+			if err != nil {
+				return fmt.Sprintf("Error: invalid messages: %v", err)
+			}
+			quota.Messages = int64(messages)
+		}
+		if err := h.store.SetQuota(name, quota); err != nil {
+			return fmt.Sprintf("Error setting quota: %v", err)
+		}
+		return fmt.Sprintf("Quota of mail user %s set to %s", username, formatQuota(quota.Storage, quota.Messages))
+	}
+
+	quota, err := h.store.Quota(name)
+	if err != nil {
+		return fmt.Sprintf("Error getting quota: %v", err)
+	}
+	usage, err := h.store.Usage(name)
+	if err != nil {
+		return fmt.Sprintf("Error getting usage: %v", err)
+	}
+	return fmt.Sprintf("Mail user %s uses %d bytes in %d messages, quota %s",
+		username, usage.Storage, usage.Messages, formatQuota(quota.Storage, quota.Messages))
+}
+
+// Rules handles the mailuser.rules action
+func (h *MailUserHandler) Rules(script string) string {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return fmt.Sprintf("Error parsing parameters: %v", err)
+	}
+
+	name := params.Get("name")
+	if name == "" {
+		return "Error: name is required"
+	}
+	username := mailauth.NormalizeUsername(name)
+
+	if params.GetBool("clear") {
+		if err := h.store.SetRules(name, ""); err != nil {
+			return fmt.Sprintf("Error clearing rules: %v", err)
+		}
+		return fmt.Sprintf("Rules of mail user %s cleared", username)
+	}
+
+	if path := params.GetVerbatim("path"); path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Sprintf("Error reading rules: %v", err)
+		}
+		if err := h.store.SetRules(name, string(content)); err != nil {
+			return fmt.Sprintf("Error setting rules: %v", err)
+		}
+		return fmt.Sprintf("Rules of mail user %s set from %s", username, path)
+	}
+
+	rules, err := h.store.Rules(name)
+	if err != nil {
+		return fmt.Sprintf("Error getting rules: %v", err)
+	}
+	if strings.TrimSpace(rules) == "" {
+		return fmt.Sprintf("Mail user %s has no rules", username)
+	}
+	return fmt.Sprintf("Rules of mail user %s:\n%s", username, rules)
+}
+
+// Alias handles the mailuser.alias action
+func (h *MailUserHandler) Alias(script string) string {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return fmt.Sprintf("Error parsing parameters: %v", err)
+	}
+
+	address := params.GetVerbatim("address")
+	to := params.GetVerbatim("to")
+	if address == "" || to == "" {
+		return "Error: address and to are required"
+	}
+
+	if err := h.store.SetAlias(address, strings.Split(to, ",")); err != nil {
+		return fmt.Sprintf("Error setting alias: %v", err)
+	}
+	return fmt.Sprintf("Alias %s set to %s", strings.ToLower(address), to)
+}
+
+// Unalias handles the mailuser.unalias action
+func (h *MailUserHandler) Unalias(script string) string {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return fmt.Sprintf("Error parsing parameters: %v", err)
+	}
+
+	address := params.GetVerbatim("address")
+	if address == "" {
+		return "Error: address is required"
+	}
+
+	if err := h.store.RemoveAlias(address); err != nil {
+		return fmt.Sprintf("Error removing alias: %v", err)
+	}
+	return fmt.Sprintf("Alias %s removed", strings.ToLower(address))
+}
+
+// Aliases handles the mailuser.aliases action
+func (h *MailUserHandler) Aliases(script string) string {
+	aliases, err := h.store.Aliases()
+	if err != nil {
+		return fmt.Sprintf("Error listing aliases: %v", err)
+	}
+	if len(aliases) == 0 {
+		return "No aliases"
+	}
+
+	addresses := make([]string, 0, len(aliases))
+	for address := range aliases {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	lines := []string{"Aliases:"}
+	for _, address := range addresses {
+		lines = append(lines, fmt.Sprintf("%s: %s", address, strings.Join(aliases[address], ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Webhook handles the mailuser.webhook action
+func (h *MailUserHandler) Webhook(script string) string {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return fmt.Sprintf("Error parsing parameters: %v", err)
+	}
+
+	url := params.GetVerbatim("url")
+	if url == "" {
+		return "Error: url is required"
+	}
+
+	id, err := h.store.AddWebhook(mailauth.Webhook{
+		URL:       url,
+		Recipient: params.GetVerbatim("to"),
+		Folder:    params.GetVerbatim("folder"),
+		Secret:    params.GetVerbatim("secret"),
+	})
+	if err != nil {
+		return fmt.Sprintf("Error adding webhook: %v", err)
+	}
+	return fmt.Sprintf("Webhook %s added for %s", id, url)
+}
+
+// WebhookDelete handles the mailuser.webhook_delete action
+func (h *MailUserHandler) WebhookDelete(script string) string {
+	params, err := h.ParseParams(script)
+	if err != nil {
+		return fmt.Sprintf("Error parsing parameters: %v", err)
+	}
+
+	id := params.Get("id")
+	if id == "" {
+		return "Error: id is required"
+	}
+
+	if err := h.store.RemoveWebhook(id); err != nil {
+		return fmt.Sprintf("Error deleting webhook: %v", err)
+	}
+	return fmt.Sprintf("Webhook %s deleted", id)
+}
+
+// Webhooks handles the mailuser.webhooks action
+func (h *MailUserHandler) Webhooks(script string) string {
+	hooks, err := h.store.Webhooks()
+	if err != nil {
+		return fmt.Sprintf("Error listing webhooks: %v", err)
+	}
+	if len(hooks) == 0 {
+		return "No webhooks"
+	}
+
+	lines := []string{"Webhooks:"}
+	for _, hook := range hooks {
+		line := fmt.Sprintf("%s: %s", hook.ID, hook.URL)
+		if hook.Recipient != "" {
+			line += " to " + hook.Recipient
+		}
+		if hook.Folder != "" {
+			line += " in " + hook.Folder
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatQuota describes the limits of a quota
+func formatQuota(storage, messages int64) string {
+	limits := []string{}
+	if storage > 0 {
+		limits = append(limits, fmt.Sprintf("%d bytes", storage))
+	}
+	if messages > 0 {
+		limits = append(limits, fmt.Sprintf("%d messages", messages))
+	}
+	if len(limits) == 0 {
+		return "unlimited"
+	}
+	return strings.Join(limits, ", ")
+}
@@ -0,0 +1,266 @@
+package paramsparser
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// FieldError is a param that cannot be decoded into its field
+type FieldError struct {
+	Param   string `json:"param"`
+	Message string `json:"message"`
+}
+
+// DecodeError is returned by Decode for params that cannot be decoded, with
+// an error for every param
+type DecodeError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+// Error implements the error interface
+func (e *DecodeError) Error() string {
+	var messages []string
+	for _, fieldError := range e.Errors {
+		messages = append(messages, fieldError.Param+": "+fieldError.Message)
+	}
+	return "invalid params: " + strings.Join(messages, "; ")
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// fieldTag is the hero tag of a struct field
+type fieldTag struct {
+	name     string
+	required bool
+	// size decodes sizes like 8GB into integer fields
+	size bool
+	// verbatim decodes the value as it was written (see GetVerbatim)
+	verbatim     bool
+	defaultValue string
+}
+
+// Decode sets the fields of the struct that v points to from the params,
+// by the hero tags of the fields:
+//
+//	type DefineParams struct {
+//		Name    string        `hero:"name,required"`
+//		CPU     int           `hero:"cpu,default=1"`
+//		Memory  int64         `hero:"memory,size,default=1GB"`
+//		Timeout time.Duration `hero:"timeout"`
+//		Command string        `hero:"command,verbatim"`
+//		Tags    []string      `hero:"tags"`
+//		Env     map[string]string
+//		Debug   *bool         `hero:"debug"`
+//	}
+//
+// Fields without a tag have the name of the field in snake case, like env,
+// and fields tagged hero:"-" are skipped. Params that are not given or empty
+// leave their field as it is, unless the tag has a default, and pointer fields
+// are only set for params that are given. Strings, bools, integers, floats,
+// durations, lists of strings (see GetList), maps of strings (see GetMap)
+// and pointers to them are supported, and integers tagged size are read
+// like 8GB (see ParseSize). Fields tagged verbatim, and maps, are decoded
+// from the values as they were written (see GetVerbatim). Embedded structs are decoded as part of the
+// struct. Params that cannot be decoded are returned as a *DecodeError with
+// an error for every param.
+func (p *ParamsParser) Decode(v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Pointer || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("decode needs a pointer to a struct, got %T", v)
+	}
+
+	var errs []FieldError
+	if err := p.decodeStruct(value.Elem(), &errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return &DecodeError{Errors: errs}
+	}
+	return nil
+}
+
+// decodeStruct decodes the params into the fields of a struct, and adds the
+// params that cannot be decoded to errs. Fields of unsupported types are
+// returned as error.
+func (p *ParamsParser) decodeStruct(value reflect.Value, errs *[]FieldError) error {
+	structType := value.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tagValue, tagged := field.Tag.Lookup("hero")
+		if field.Anonymous && !tagged && field.Type.Kind() == reflect.Struct {
+			if err := p.decodeStruct(value.Field(i), errs); err != nil {
+				return err
+			}
+			continue
+		}
+		if !field.IsExported() || tagValue == "-" {
+			continue
+		}
+
+		tag := parseFieldTag(field.Name, tagValue)
+		raw := p.Get(tag.name)
+		if tag.verbatim || field.Type.Kind() == reflect.Map {
+			raw = p.GetVerbatim(tag.name)
+		}
+		if raw == "" {
+			raw = tag.defaultValue
+		}
+		if raw == "" {
+			if tag.required {
+				*errs = append(*errs, FieldError{Param: tag.name, Message: "is required"})
+			}
+			continue
+		}
+
+		target := value.Field(i)
+		if target.Kind() == reflect.Pointer {
+			target.Set(reflect.New(target.Type().Elem()))
+			target = target.Elem()
+		}
+		message, err := decodeValue(target, raw, tag)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", field.Name, err)
+		}
+		if message != "" {
+			*errs = append(*errs, FieldError{Param: tag.name, Message: message})
+		}
+	}
+	return nil
+}
+
+// parseFieldTag parses the hero tag of a field: its name, followed by the
+// options required, size, verbatim and default=value
+func parseFieldTag(fieldName, tagValue string) fieldTag {
+	parts := strings.Split(tagValue, ",")
+	tag := fieldTag{name: strings.TrimSpace(parts[0])}
+	if tag.name == "" {
+		tag.name = snakeCase(fieldName)
+	}
+	for _, option := range parts[1:] {
+		option = strings.TrimSpace(option)
+		switch {
+		case option == "required":
+			tag.required = true
+		case option == "size":
+			tag.size = true
+		case option == "verbatim":
+			tag.verbatim = true
+		case strings.HasPrefix(option, "default="):
+			tag.defaultValue = strings.TrimPrefix(option, "default=")
+		}
+	}
+	return tag
+}
+
+// decodeValue sets a field to the value of a param. Values that do not fit
+// the field are returned as message, and unsupported fields as error.
+func decodeValue(target reflect.Value, raw string, tag fieldTag) (string, error) {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case target.Type() == durationType:
+		duration, err := ParseDuration(raw)
+		if err != nil {
+			return fmt.Sprintf("must be a duration, got '%s'", raw), nil
+		}
+		target.SetInt(int64(duration))
+		return "", nil
+	case tag.size && (isInt(target.Kind()) || isUint(target.Kind())):
+		size, err := ParseSize(raw)
+		if err != nil {
+			return fmt.Sprintf("must be a size, got '%s'", raw), nil
+		}
+		if isInt(target.Kind()) && target.OverflowInt(size) || isUint(target.Kind()) && target.OverflowUint(uint64(size)) {
+			return fmt.Sprintf("is too large, got '%s'", raw), nil
+		}
+		if isInt(target.Kind()) {
+			target.SetInt(size)
+		} else {
+			target.SetUint(uint64(size))
+		}
+		return "", nil
+	}
+
+	switch kind := target.Kind(); {
+	case kind == reflect.String:
+		target.SetString(raw)
+	case kind == reflect.Bool:
+		switch strings.ToLower(raw) {
+		case "true", "yes", "1", "on":
+			target.SetBool(true)
+		case "false", "no", "0", "off":
+			target.SetBool(false)
+		default:
+			return fmt.Sprintf("must be a bool, got '%s'", raw), nil
+		}
+	case isInt(kind):
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || target.OverflowInt(n) {
+			return fmt.Sprintf("must be an int, got '%s'", raw), nil
+		}
+		target.SetInt(n)
+	case isUint(kind):
+		n, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil || target.OverflowUint(n) {
+			return fmt.Sprintf("must be a positive int, got '%s'", raw), nil
+		}
+		target.SetUint(n)
+	case kind == reflect.Float32 || kind == reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil || target.OverflowFloat(f) {
+			return fmt.Sprintf("must be a float, got '%s'", raw), nil
+		}
+		target.SetFloat(f)
+	case kind == reflect.Slice && target.Type().Elem().Kind() == reflect.String:
+		list := reflect.MakeSlice(target.Type(), 0, 0)
+		for _, item := range splitList(raw) {
+			list = reflect.Append(list, reflect.ValueOf(item).Convert(target.Type().Elem()))
+		}
+		target.Set(list)
+	case kind == reflect.Map && target.Type().Key().Kind() == reflect.String && target.Type().Elem().Kind() == reflect.String:
+		entries, err := parseMap(tag.name, raw)
+		if err != nil {
+			return err.Error(), nil
+		}
+		m := reflect.MakeMapWithSize(target.Type(), len(entries))
+		for k, v := range entries {
+			m.SetMapIndex(reflect.ValueOf(k).Convert(target.Type().Key()), reflect.ValueOf(v).Convert(target.Type().Elem()))
+		}
+		target.Set(m)
+	default:
+		return "", errors.New("unsupported type " + target.Type().String())
+	}
+	return "", nil
+}
+
+// isInt reports whether a kind is a signed integer
+func isInt(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Int64
+}
+
+// isUint reports whether a kind is an unsigned integer
+func isUint(kind reflect.Kind) bool {
+	return kind >= reflect.Uint && kind <= reflect.Uint64
+}
+
+// snakeCase returns the param name of a field name, like cpu_count for
+// CPUCount
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var out strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				out.WriteRune('_')
+			}
+		}
+		out.WriteRune(unicode.ToLower(r))
+	}
+	return out.String()
+}
@@ -16,6 +16,9 @@ import (
 type ParamsParser struct {
 	params        map[string]string
 	defaultParams map[string]string
+	// verbatim are the quoted values as they were written, before they were
+	// normalized with NameFix
+	verbatim map[string]string
 }
 
 // New creates a new ParamsParser instance
@@ -23,6 +26,7 @@ func New() *ParamsParser {
 	return &ParamsParser{
 		params:        make(map[string]string),
 		defaultParams: make(map[string]string),
+		verbatim:      make(map[string]string),
 	}
 }
 
@@ -60,7 +64,7 @@ func (p *ParamsParser) Parse(input string) error {
 			if strings.HasSuffix(line, "'") && !strings.HasSuffix(line, "\\'") {
 				// Add the line without the closing quote
 				currentValue.WriteString(line[:len(line)-1])
-				p.params[currentKey] = currentValue.String()
+				p.Set(currentKey, currentValue.String())
 				inMultilineString = false
 				currentKey = ""
 				currentValue.Reset()
@@ -115,7 +119,7 @@ func (p *ParamsParser) Parse(input string) error {
 			
 			if processedPos >= len(line) {
 				// End of line reached, store empty value
-				p.params[key] = ""
+				p.Set(key, "")
 				break
 			}
 			
@@ -126,7 +130,7 @@ func (p *ParamsParser) Parse(input string) error {
 			
 			if processedPos >= len(line) {
 				// End of line reached after whitespace, store empty value
-				p.params[key] = ""
+				p.Set(key, "")
 				break
 			}
 			
@@ -150,10 +154,7 @@ func (p *ParamsParser) Parse(input string) error {
 					value := line[processedPos:quoteEnd]
 					// For quoted values, we preserve the original formatting
 					// But for single-line values, we can apply NameFix if needed
-					if key != "description" {
-						value = tools.NameFix(value)
-					}
-					p.params[key] = value
+					p.SetVerbatim(key, value)
 					processedPos = quoteEnd + 1 // Move past the closing quote
 				} else {
 					// Start of multiline string
@@ -176,7 +177,7 @@ func (p *ParamsParser) Parse(input string) error {
 				value := line[valueStart:valueEnd]
 				// For unquoted values, use NameFix to standardize them
 				// This handles the 'without' keyword and other special cases
-				p.params[key] = tools.NameFix(value)
+				p.Set(key, tools.NameFix(value))
 				processedPos = valueEnd
 			}
 		}
@@ -240,7 +241,7 @@ func (p *ParamsParser) ParseString(input string) error {
 		
 		if processedPos >= len(input) {
 			// End of input reached, store empty value
-			p.params[key] = ""
+			p.Set(key, "")
 			break
 		}
 		
@@ -251,7 +252,7 @@ func (p *ParamsParser) ParseString(input string) error {
 		
 		if processedPos >= len(input) {
 			// End of input reached after whitespace, store empty value
-			p.params[key] = ""
+			p.Set(key, "")
 			break
 		}
 		
@@ -277,10 +278,7 @@ func (p *ParamsParser) ParseString(input string) error {
 			value := input[processedPos:quoteEnd]
 			// For quoted values in ParseString, we can apply NameFix
 			// since this method doesn't handle multiline strings
-			if key != "description" {
-				value = tools.NameFix(value)
-			}
-			p.params[key] = value
+			p.SetVerbatim(key, value)
 			processedPos = quoteEnd + 1 // Move past the closing quote
 		} else {
 			// This is an unquoted value
@@ -295,7 +293,7 @@ func (p *ParamsParser) ParseString(input string) error {
 			value := input[valueStart:valueEnd]
 			// For unquoted values, use NameFix to standardize them
 			// This handles the 'without' keyword and other special cases
-			p.params[key] = tools.NameFix(value)
+			p.Set(key, tools.NameFix(value))
 			processedPos = valueEnd
 		}
 	}
@@ -327,6 +325,26 @@ func (p *ParamsParser) SetDefaults(defaults map[string]string) {
 // Set explicitly sets a parameter value
 func (p *ParamsParser) Set(key, value string) {
 	p.params[key] = value
+	delete(p.verbatim, key)
+}
+
+// SetVerbatim sets a parameter the way the parser sets quoted values: Get
+// returns the value normalized with NameFix, except for description, and
+// GetVerbatim returns it as it is
+func (p *ParamsParser) SetVerbatim(key, value string) {
+	if key == "description" {
+		p.params[key] = value
+	} else {
+		p.params[key] = tools.NameFix(value)
+	}
+	p.verbatim[key] = value
+}
+
+// Delete removes a parameter and its default value
+func (p *ParamsParser) Delete(key string) {
+	delete(p.params, key)
+	delete(p.defaultParams, key)
+	delete(p.verbatim, key)
 }
 
 // Get retrieves a parameter value, returning the default if not found
@@ -340,6 +358,16 @@ func (p *ParamsParser) Get(key string) string {
 	return ""
 }
 
+// GetVerbatim retrieves a parameter value as it was written between quotes,
+// for values like commands, paths or passwords that Get returns normalized.
+// Values that were not quoted are returned as Get returns them.
+func (p *ParamsParser) GetVerbatim(key string) string {
+	if value, exists := p.verbatim[key]; exists {
+		return value
+	}
+	return p.Get(key)
+}
+
 // GetInt retrieves a parameter as an integer
 func (p *ParamsParser) GetInt(key string) (int, error) {
 	value := p.Get(key)
@@ -419,6 +447,22 @@ func (p *ParamsParser) GetAll() map[string]string {
 	return result
 }
 
+// Clone returns a copy of the parser with its parameters, defaults and
+// verbatim values
+func (p *ParamsParser) Clone() *ParamsParser {
+	clone := New()
+	for k, v := range p.params {
+		clone.params[k] = v
+	}
+	for k, v := range p.defaultParams {
+		clone.defaultParams[k] = v
+	}
+	for k, v := range p.verbatim {
+		clone.verbatim[k] = v
+	}
+	return clone
+}
+
 // MustGet retrieves a parameter value, panicking if not found
 func (p *ParamsParser) MustGet(key string) string {
 	value := p.Get(key)
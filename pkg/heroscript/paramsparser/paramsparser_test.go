@@ -1,7 +1,10 @@
 package paramsparser
 
 import (
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestParamsParserBasic(t *testing.T) {
@@ -54,6 +57,62 @@ func TestParamsParserMultiline(t *testing.T) {
 	}
 }
 
+func TestParamsParserVerbatimValues(t *testing.T) {
+	for _, parse := range []func(*ParamsParser, string) error{(*ParamsParser).Parse, (*ParamsParser).ParseString} {
+		parser := New()
+		if err := parse(parser, "name:'My Name' password:'Se cret-1!'"); err != nil {
+			t.Fatalf("Failed to parse input: %v", err)
+		}
+
+		if got := parser.Get("name"); got != "my_name" {
+			t.Errorf("ParamsParser.Get(\"name\") = %q, want \"my_name\"", got)
+		}
+		if got := parser.Get("password"); got != "se_cret_1_" {
+			t.Errorf("ParamsParser.Get(\"password\") = %q, want \"se_cret_1_\"", got)
+		}
+		if got := parser.GetVerbatim("password"); got != "Se cret-1!" {
+			t.Errorf("ParamsParser.GetVerbatim(\"password\") = %q, want \"Se cret-1!\"", got)
+		}
+		if got := parser.GetVerbatim("name"); got != "My Name" {
+			t.Errorf("ParamsParser.GetVerbatim(\"name\") = %q, want \"My Name\"", got)
+		}
+	}
+
+	parser := New()
+	if err := parser.Parse("command:'/usr/bin/web --port 80' port:80 notes:'\n  Two\n  lines\n'"); err != nil {
+		t.Fatalf("Failed to parse input: %v", err)
+	}
+	clone := parser.Clone()
+	tests := map[string]string{
+		"command": "/usr/bin/web --port 80",
+		// Values that are not quoted, or multiline, are returned like Get
+		"port":  "80",
+		"notes": "\n  Two\n  lines\n",
+	}
+	for key, want := range tests {
+		if got := clone.GetVerbatim(key); got != want {
+			t.Errorf("ParamsParser.GetVerbatim(%q) = %q, want %q", key, got, want)
+		}
+	}
+
+	// Set replaces the value as it was written, SetVerbatim sets it
+	clone.Set("command", "other")
+	if got := clone.GetVerbatim("command"); got != "other" {
+		t.Errorf("ParamsParser.GetVerbatim(\"command\") after Set = %q, want \"other\"", got)
+	}
+	if got := parser.GetVerbatim("command"); got != "/usr/bin/web --port 80" {
+		t.Errorf("Expected Set on a clone not to change the parser, got %q", got)
+	}
+	parser.SetVerbatim("check", "curl -f http://localhost")
+	if parser.Get("check") != "curl_f_http_localhost" || parser.GetVerbatim("check") != "curl -f http://localhost" {
+		t.Errorf("ParamsParser.SetVerbatim() = %q, %q", parser.Get("check"), parser.GetVerbatim("check"))
+	}
+	parser.Delete("check")
+	if got := parser.GetVerbatim("check"); got != "" {
+		t.Errorf("ParamsParser.GetVerbatim(\"check\") after Delete = %q, want \"\"", got)
+	}
+}
+
 func TestParamsParserDefaults(t *testing.T) {
 	parser := New()
 	parser.SetDefault("key1", "default1")
@@ -85,6 +144,23 @@ func TestParamsParserDefaults(t *testing.T) {
 	}
 }
 
+func TestParamsParserDelete(t *testing.T) {
+	parser := New()
+	parser.SetDefault("key1", "default1")
+	parser.Set("key1", "value1")
+	parser.Set("key2", "value2")
+
+	parser.Delete("key1")
+	parser.Delete("key3")
+
+	if parser.Has("key1") || parser.Get("key1") != "" {
+		t.Errorf("Expected key1 and its default to be deleted, got %q", parser.Get("key1"))
+	}
+	if got := parser.Get("key2"); got != "value2" {
+		t.Errorf("ParamsParser.Get(%q) = %q, want %q", "key2", got, "value2")
+	}
+}
+
 func TestParamsParserTypes(t *testing.T) {
 	parser := New()
 	parser.Set("int", "123")
@@ -172,6 +248,200 @@ func TestParamsParserTypes(t *testing.T) {
 	})
 }
 
+func TestParamsParserTypedGetters(t *testing.T) {
+	parser := New()
+	if err := parser.Parse("memory:'8GB' disk:1.5gb timeout:'1h30m' ttl:2d1h retry:30 names:'web, worker api' env:'HOME=/root,LANG=C' labels:'tier:web' bad:'8xb'"); err != nil {
+		t.Fatalf("Failed to parse params: %v", err)
+	}
+
+	t.Run("GetSize", func(t *testing.T) {
+		if val, err := parser.GetSize("memory"); err != nil || val != 8<<30 {
+			t.Errorf("GetSize(\"memory\") = %d, %v, want %d, nil", val, err, int64(8<<30))
+		}
+		if val, err := parser.GetSize("disk"); err != nil || val != 3<<29 {
+			t.Errorf("GetSize(\"disk\") = %d, %v, want %d, nil", val, err, int64(3<<29))
+		}
+		if val, err := parser.GetSize("bad"); err == nil {
+			t.Errorf("GetSize(\"bad\") = %d, %v, want error", val, err)
+		}
+		if val := parser.GetSizeDefault("nonexistent", 512); val != 512 {
+			t.Errorf("GetSizeDefault(\"nonexistent\", 512) = %d, want 512", val)
+		}
+	})
+
+	t.Run("GetDuration", func(t *testing.T) {
+		tests := map[string]time.Duration{
+			"timeout": 90 * time.Minute,
+			"ttl":     49 * time.Hour,
+			"retry":   30 * time.Second,
+		}
+		for key, want := range tests {
+			if val, err := parser.GetDuration(key); err != nil || val != want {
+				t.Errorf("GetDuration(%q) = %v, %v, want %v, nil", key, val, err, want)
+			}
+		}
+		if val, err := parser.GetDuration("memory"); err == nil {
+			t.Errorf("GetDuration(\"memory\") = %v, %v, want error", val, err)
+		}
+		if val := parser.GetDurationDefault("nonexistent", time.Minute); val != time.Minute {
+			t.Errorf("GetDurationDefault(\"nonexistent\", 1m) = %v, want 1m", val)
+		}
+	})
+
+	t.Run("GetList", func(t *testing.T) {
+		// The space is replaced by _ as names is not a verbatim key
+		if val := parser.GetList("names"); !reflect.DeepEqual(val, []string{"web", "_worker_api"}) {
+			t.Errorf("GetList(\"names\") = %q, want [web _worker_api]", val)
+		}
+		parser.Set("hosts", "a, b\tc,,d")
+		if val := parser.GetList("hosts"); !reflect.DeepEqual(val, []string{"a", "b", "c", "d"}) {
+			t.Errorf("GetList(\"hosts\") = %q, want [a b c d]", val)
+		}
+		if val := parser.GetList("nonexistent"); len(val) != 0 {
+			t.Errorf("GetList(\"nonexistent\") = %q, want empty", val)
+		}
+	})
+
+	t.Run("GetMap", func(t *testing.T) {
+		want := map[string]string{"HOME": "/root", "LANG": "C"}
+		if val, err := parser.GetMap("env"); err != nil || !reflect.DeepEqual(val, want) {
+			t.Errorf("GetMap(\"env\") = %v, %v, want %v, nil", val, err, want)
+		}
+		parser.Set("labels", "tier:web, url=http://x")
+		want = map[string]string{"tier": "web", "url": "http://x"}
+		if val, err := parser.GetMap("labels"); err != nil || !reflect.DeepEqual(val, want) {
+			t.Errorf("GetMap(\"labels\") = %v, %v, want %v, nil", val, err, want)
+		}
+		parser.Set("labels", "tier")
+		if val, err := parser.GetMap("labels"); err == nil {
+			t.Errorf("GetMap(\"labels\") = %v, %v, want error", val, err)
+		}
+	})
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int64]string{
+		0:          "0B",
+		512:        "512B",
+		8 << 30:    "8GB",
+		1536 << 20: "1536MB",
+		2 << 40:    "2TB",
+		1<<10 + 1:  "1025B",
+	}
+	for size, want := range tests {
+		if got := FormatSize(size); got != want {
+			t.Errorf("FormatSize(%d) = %s, want %s", size, got, want)
+		}
+		if parsed, err := ParseSize(want); err != nil || parsed != size {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", want, parsed, err, size)
+		}
+	}
+}
+
+func TestParamsParserDecode(t *testing.T) {
+	type base struct {
+		Name string `hero:"name,required"`
+	}
+	type config struct {
+		base
+		CPU      int           `hero:"cpu,default=1"`
+		Memory   int64         `hero:"memory,size,default=1GB"`
+		Timeout  time.Duration `hero:"timeout"`
+		Ratio    float64       `hero:"ratio"`
+		Ports    []string      `hero:"ports"`
+		Command  string        `hero:"command,verbatim"`
+		Env      map[string]string
+		Debug    *bool `hero:"debug"`
+		Replicas uint8
+		MaxCount int
+		Skipped  string `hero:"-"`
+	}
+
+	parser := New()
+	err := parser.ParseString("name:web memory:2GB timeout:5m ratio:0.5 ports:'80,443' command:'/usr/bin/Web -v' env:'HOME=/root,LANG=C' debug:yes replicas:3 max_count:7 skipped:x")
+	if err != nil {
+		t.Fatalf("Failed to parse input: %v", err)
+	}
+
+	var got config
+	got.Skipped = "kept"
+	if err := parser.Decode(&got); err != nil {
+		t.Fatalf("Decode() = %v", err)
+	}
+	debug := true
+	want := config{
+		base:     base{Name: "web"},
+		CPU:      1,
+		Memory:   2 << 30,
+		Timeout:  5 * time.Minute,
+		Ratio:    0.5,
+		Ports:    []string{"80", "443"},
+		Command:  "/usr/bin/Web -v",
+		Env:      map[string]string{"HOME": "/root", "LANG": "C"},
+		Debug:    &debug,
+		Replicas: 3,
+		MaxCount: 7,
+		Skipped:  "kept",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Decode() = %+v, want %+v", got, want)
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		parser := New()
+		if err := parser.ParseString("cpu:two timeout:soon replicas:300 debug:maybe"); err != nil {
+			t.Fatalf("Failed to parse input: %v", err)
+		}
+		var got config
+		err := parser.Decode(&got)
+		decodeErr, ok := err.(*DecodeError)
+		if !ok {
+			t.Fatalf("Decode() = %v, want *DecodeError", err)
+		}
+		want := []FieldError{
+			{Param: "name", Message: "is required"},
+			{Param: "cpu", Message: "must be an int, got 'two'"},
+			{Param: "timeout", Message: "must be a duration, got 'soon'"},
+			{Param: "debug", Message: "must be a bool, got 'maybe'"},
+			{Param: "replicas", Message: "must be a positive int, got '300'"},
+		}
+		if !reflect.DeepEqual(decodeErr.Errors, want) {
+			t.Errorf("Decode() errors = %+v, want %+v", decodeErr.Errors, want)
+		}
+		if !strings.HasPrefix(err.Error(), "invalid params: name: is required; cpu: ") {
+			t.Errorf("Decode() error = %q", err.Error())
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		var notStruct string
+		if err := parser.Decode(&notStruct); err == nil {
+			t.Error("Decode(*string) = nil, want error")
+		}
+		var unsupported struct {
+			Name []int `hero:"name"`
+		}
+		if err := parser.Decode(&unsupported); err == nil {
+			t.Error("Decode() of []int field = nil, want error")
+		}
+	})
+}
+
+func TestSnakeCase(t *testing.T) {
+	tests := map[string]string{
+		"Name":      "name",
+		"MaxCount":  "max_count",
+		"CPU":       "cpu",
+		"CPUCount":  "cpu_count",
+		"Disk2Size": "disk2_size",
+	}
+	for name, want := range tests {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
 func TestParamsParserGetAll(t *testing.T) {
 	parser := New()
 	parser.SetDefault("key1", "default1")
@@ -180,7 +450,7 @@ func TestParamsParserGetAll(t *testing.T) {
 	parser.Set("key3", "value3")
 
 	all := parser.GetAll()
-	
+
 	expected := map[string]string{
 		"key1": "default1",
 		"key2": "override",
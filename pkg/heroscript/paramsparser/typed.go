@@ -0,0 +1,170 @@
+package paramsparser
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// sizeUnits are the units of sizes, in powers of 1024 like the sizes of
+// memory and disks. The letter alone, like G, is the same unit.
+var sizeUnits = map[string]int64{
+	"":   1,
+	"b":  1,
+	"k":  1 << 10,
+	"kb": 1 << 10,
+	"m":  1 << 20,
+	"mb": 1 << 20,
+	"g":  1 << 30,
+	"gb": 1 << 30,
+	"t":  1 << 40,
+	"tb": 1 << 40,
+}
+
+// ParseSize parses a size in bytes, optionally with a unit: b, kb, mb, gb or
+// tb, in any case and with or without the b, as powers of 1024. Sizes with a
+// unit can have a fraction, like 1.5GB.
+func ParseSize(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	number := strings.TrimRightFunc(value, unicode.IsLetter)
+	unit, ok := sizeUnits[strings.TrimSpace(value[len(number):])]
+	number = strings.TrimSpace(number)
+	if !ok || number == "" {
+		return 0, fmt.Errorf("%q is not a size", value)
+	}
+
+	if size, err := strconv.ParseInt(number, 10, 64); err == nil {
+		if size < 0 || size > math.MaxInt64/unit {
+			return 0, fmt.Errorf("%q is not a size", value)
+		}
+		return size * unit, nil
+	}
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size < 0 || size*float64(unit) >= math.MaxInt64 {
+		return 0, fmt.Errorf("%q is not a size", value)
+	}
+	return int64(size * float64(unit)), nil
+}
+
+// FormatSize formats a size in bytes with the largest unit it is a whole
+// number of, like 8GB or 1536MB
+func FormatSize(size int64) string {
+	for _, unit := range []string{"tb", "gb", "mb", "kb"} {
+		if factor := sizeUnits[unit]; size != 0 && size%factor == 0 {
+			return strconv.FormatInt(size/factor, 10) + strings.ToUpper(unit)
+		}
+	}
+	return strconv.FormatInt(size, 10) + "B"
+}
+
+// ParseDuration parses a duration like time.ParseDuration, like 30s, 5m or
+// 1h30m, and also accepts days, like 2d, and seconds without a unit
+func ParseDuration(value string) (time.Duration, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+
+	var days time.Duration
+	if before, after, ok := strings.Cut(value, "d"); ok {
+		n, err := strconv.ParseInt(before, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a duration", value)
+		}
+		days = time.Duration(n) * 24 * time.Hour
+		if value = after; value == "" {
+			return days, nil
+		}
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a duration", value)
+	}
+	return days + duration, nil
+}
+
+// GetSize retrieves a parameter as a size in bytes, like 8GB or 100MB, see
+// ParseSize
+func (p *ParamsParser) GetSize(key string) (int64, error) {
+	value := p.Get(key)
+	if value == "" {
+		return 0, errors.New("parameter not found")
+	}
+	return ParseSize(value)
+}
+
+// GetSizeDefault retrieves a parameter as a size in bytes with a default
+// value
+func (p *ParamsParser) GetSizeDefault(key string, defaultValue int64) int64 {
+	value, err := p.GetSize(key)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// GetDuration retrieves a parameter as a duration, like 30s, 5m or 2d, see
+// ParseDuration
+func (p *ParamsParser) GetDuration(key string) (time.Duration, error) {
+	value := p.Get(key)
+	if value == "" {
+		return 0, errors.New("parameter not found")
+	}
+	return ParseDuration(value)
+}
+
+// GetDurationDefault retrieves a parameter as a duration with a default
+// value
+func (p *ParamsParser) GetDurationDefault(key string, defaultValue time.Duration) time.Duration {
+	value, err := p.GetDuration(key)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// GetList retrieves a parameter as a list of the items separated by commas
+// or whitespace, without empty items. The parser replaces whitespace by _,
+// so the items of quoted values are separated by commas.
+func (p *ParamsParser) GetList(key string) []string {
+	return splitList(p.Get(key))
+}
+
+// splitList splits a value into the items separated by commas or whitespace
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+}
+
+// GetMap retrieves a parameter as a map of comma separated key=value or
+// key:value entries, like env:'HOME=/root,LANG=C'. The map is read from the
+// value as it was written (see GetVerbatim), as the parser replaces the = and
+// : separators by _.
+func (p *ParamsParser) GetMap(key string) (map[string]string, error) {
+	return parseMap(key, p.GetVerbatim(key))
+}
+
+// parseMap parses the value of a param of comma separated key=value or
+// key:value entries
+func parseMap(key, value string) (map[string]string, error) {
+	result := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(entry, "=")
+		if i := strings.Index(entry, ":"); i >= 0 && (!ok || i < len(k)) {
+			k, v, ok = entry[:i], entry[i+1:], true
+		}
+		if k = strings.TrimSpace(k); !ok || k == "" {
+			return nil, fmt.Errorf("invalid entry '%s' of %s, expected key=value", entry, key)
+		}
+		result[k] = strings.TrimSpace(v)
+	}
+	return result, nil
+}
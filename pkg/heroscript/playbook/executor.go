@@ -0,0 +1,165 @@
+package playbook
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/paramsparser"
+	"github.com/freeflowuniverse/herolauncher/pkg/tools"
+)
+
+// Control params of actions, which Execute evaluates and does not pass on:
+//
+//   - as:'name' keeps the result of the action under name
+//   - if:'name == value' only runs the action if the result kept under name
+//     is value; the operators are ==, != and ~ for a regular expression,
+//     and a name alone holds if the action with that result ran
+//   - foreach:'param' runs the action for every item of the comma separated
+//     list of param, with param set to the item and ${param} replaced by it
+//     in the other params whose values are kept as written, like command
+//
+// ExecuteParallel also evaluates depends_on, which Execute drops as it runs
+// the actions in order.
+const (
+	ParamAs      = "as"
+	ParamIf      = "if"
+	ParamForeach = "foreach"
+)
+
+// ActionFunc runs an action and returns its result
+type ActionFunc func(action *Action) (string, error)
+
+// condition matches the if param: a name, optionally with an operator and a
+// value
+var condition = regexp.MustCompile(`^\s*(\w+)\s*(?:(==|!=|~)\s*(.*?))?\s*$`)
+
+// Execute runs the actions of the playbook in order with run, evaluating
+// their control params. The result of an action is kept in its Result as
+// "result", one line per item for foreach, and an action that was skipped
+// has "skipped" set. Execute stops at the first error.
+func (p *PlayBook) Execute(run ActionFunc) error {
+	results := make(map[string]string)
+	actions, err := p.ActionsSorted(false)
+	if err != nil {
+		return err
+	}
+
+	for _, action := range actions {
+		if action.Done {
+			continue
+		}
+		if cond := action.Params.GetVerbatim(ParamIf); cond != "" {
+			ok, err := evaluateCondition(cond, results)
+			if err != nil {
+				return fmt.Errorf("action %s.%s: %w", action.Actor, action.Name, err)
+			}
+			if !ok {
+				action.Result.Set("skipped", "true")
+				continue
+			}
+		}
+
+		expanded, err := expandAction(action)
+		if err != nil {
+			return fmt.Errorf("action %s.%s: %w", action.Actor, action.Name, err)
+		}
+		var outputs []string
+		for _, a := range expanded {
+			output, err := run(a)
+			if err != nil {
+				return fmt.Errorf("action %s.%s: %w", action.Actor, action.Name, err)
+			}
+			outputs = append(outputs, strings.TrimSpace(output))
+		}
+
+		result := strings.Join(outputs, "\n")
+		action.Result.Set("result", result)
+		action.Done = true
+		p.Done = append(p.Done, action.ID)
+		if name := action.Params.Get(ParamAs); name != "" {
+			results[name] = result
+		}
+	}
+	return nil
+}
+
+// evaluateCondition reports whether the condition of an if param holds for
+// the results kept so far. A condition on a result that was not kept, as its
+// action was skipped, does not hold.
+func evaluateCondition(cond string, results map[string]string) (bool, error) {
+	match := condition.FindStringSubmatch(cond)
+	if match == nil {
+		return false, fmt.Errorf("invalid condition: %s", cond)
+	}
+	// Names are normalized like the as params that keep the results
+	result, ok := results[tools.NameFix(match[1])]
+	if !ok {
+		return false, nil
+	}
+
+	value := strings.Trim(match[3], `"`)
+	switch match[2] {
+	case "==":
+		return result == value, nil
+	case "!=":
+		return result != value, nil
+	case "~":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return false, fmt.Errorf("invalid condition: %s: %w", cond, err)
+		}
+		return re.MatchString(result), nil
+	}
+	return true, nil
+}
+
+// expandAction returns the actions to run for an action without its control
+// params, one per item of its foreach param or the action itself
+func expandAction(action *Action) ([]*Action, error) {
+	param := action.Params.Get(ParamForeach)
+	if param == "" {
+		return []*Action{withParams(action, controlFree(action.Params.Clone()))}, nil
+	}
+	if !action.Params.Has(param) {
+		return nil, fmt.Errorf("foreach parameter %s is not set", param)
+	}
+
+	var actions []*Action
+	for _, item := range strings.Split(action.Params.Get(param), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		params := controlFree(action.Params.Clone())
+		for key, value := range params.GetAll() {
+			// The parser replaces the ${} of quoted values, so the item is
+			// substituted in the values as they were written
+			if verbatim := params.GetVerbatim(key); verbatim != value {
+				params.SetVerbatim(key, strings.ReplaceAll(verbatim, "${"+param+"}", item))
+			} else {
+				params.Set(key, strings.ReplaceAll(value, "${"+param+"}", item))
+			}
+		}
+		params.Set(param, item)
+		actions = append(actions, withParams(action, params))
+	}
+	return actions, nil
+}
+
+// controlFree returns params without the control params
+func controlFree(params *paramsparser.ParamsParser) *paramsparser.ParamsParser {
+	params.Delete(ParamAs)
+	params.Delete(ParamIf)
+	params.Delete(ParamForeach)
+	params.Delete(ParamDependsOn)
+	return params
+}
+
+// withParams returns a copy of an action with other params
+func withParams(action *Action, params *paramsparser.ParamsParser) *Action {
+	a := *action
+	a.Params = params
+	a.Result = paramsparser.New()
+	return &a
+}
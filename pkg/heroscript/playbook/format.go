@@ -0,0 +1,326 @@
+package playbook
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/tools"
+)
+
+// bareValue matches the values that Format writes without quotes, which
+// mean the same quoted or not
+var bareValue = regexp.MustCompile(`^([0-9]+(\.[0-9]+)?|true|false)$`)
+
+// blockKind is the kind of a block of a script
+type blockKind int
+
+const (
+	blockAction blockKind = iota
+	blockComment
+	blockText
+)
+
+// block is an action, a comment line or a line of other text of a script,
+// as scanned by scanScript
+type block struct {
+	kind blockKind
+	// line is the number of the first line of the block, from 1
+	line int
+	// blankBefore is set if there are blank lines before the block
+	blankBefore bool
+	// text is the line of a comment or other text, without spaces around it
+	text   string
+	action *sourceAction
+}
+
+// sourceAction is an action of a script as it is written
+type sourceAction struct {
+	line int
+	// prefix are the exclamation marks of the action, like !!
+	prefix string
+	// actor is empty for actions that are written without one, which are of
+	// the core actor
+	actor, name string
+	// lines are the lines of the action as written
+	lines []string
+	// paramsData are the params as the parser passes them to the
+	// ParamsParser
+	paramsData []string
+	params     []sourceParam
+	// rows is the number of lines that params start on
+	rows int
+	// multiline is the index of the param whose quoted value is not closed
+	// yet, or -1
+	multiline int
+	// problems are what is wrong with the way the action is written; an
+	// action with problems is formatted as written
+	problems []Diagnostic
+}
+
+// sourceParam is a param of an action as it is written
+type sourceParam struct {
+	// key is the normalized name of the param
+	key string
+	// value is the value as written, between the quotes if it is quoted
+	value  string
+	quoted bool
+	// row is the line of the action that the param starts on, 0 for the
+	// line of the action name
+	row          int
+	line, column int
+}
+
+// Format returns a script in the standard layout, without changing what it
+// does: action and param names are normalized, params are separated by a
+// space and continue on lines indented by four spaces, values are quoted
+// unless they are numbers or bools or quoting would change them, comments
+// start with "// ", tabs are expanded and blank lines are collapsed. Params
+// stay on the lines they are written on, and multiline values are kept as
+// written. Actions that are not written correctly, which Lint reports, are
+// kept as written.
+func Format(text string) string {
+	var out strings.Builder
+	for i, b := range scanScript(text) {
+		if b.blankBefore && i > 0 {
+			out.WriteString("\n")
+		}
+		switch b.kind {
+		case blockComment:
+			out.WriteString(strings.TrimRight("// "+strings.TrimLeft(b.text, "/ "), " ") + "\n")
+		case blockText:
+			out.WriteString(b.text + "\n")
+		case blockAction:
+			out.WriteString(b.action.format())
+		}
+	}
+	return out.String()
+}
+
+// format returns the action in the standard layout
+func (a *sourceAction) format() string {
+	if len(a.problems) > 0 {
+		return strings.TrimRight(strings.Join(a.lines, "\n"), "\n") + "\n"
+	}
+
+	rows := make([][]string, a.rows)
+	for _, param := range a.params {
+		rows[param.row] = append(rows[param.row], param.key+":"+param.formatValue())
+	}
+
+	var out strings.Builder
+	out.WriteString(a.prefix)
+	if a.actor != "" {
+		out.WriteString(tools.NameFix(a.actor) + ".")
+	}
+	out.WriteString(tools.NameFix(a.name))
+	for i, row := range rows {
+		if i == 0 {
+			if len(row) > 0 {
+				out.WriteString(" ")
+			}
+		} else {
+			out.WriteString("\n    ")
+		}
+		out.WriteString(strings.Join(row, " "))
+	}
+	out.WriteString("\n")
+	return out.String()
+}
+
+// formatValue returns the value of the param quoted, unless it is a number
+// or a bool, or quoting would change it
+func (p sourceParam) formatValue() string {
+	switch {
+	case bareValue.MatchString(p.value):
+		return p.value
+	case p.quoted:
+		return "'" + p.value + "'"
+	// Bare values are normalized with NameFix, but quoted values are also
+	// kept as written for GetVerbatim
+	case strings.Contains(p.value, "'"), tools.NameFix(p.value) != p.value:
+		return p.value
+	}
+	return "'" + p.value + "'"
+}
+
+// scanScript splits a script into blocks, the way AddText reads it
+func scanScript(text string) []block {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\t", "    ")
+
+	var blocks []block
+	var action *sourceAction
+	blank := false
+	for i, line := range strings.Split(text, "\n") {
+		number := i + 1
+		stripped := strings.TrimSpace(line)
+
+		if action != nil {
+			switch {
+			case stripped == "" && action.multiline >= 0:
+				// Blank lines in multiline values are kept, though the
+				// parser drops them
+				action.lines = append(action.lines, "")
+				action.params[action.multiline].value += "\n"
+				continue
+			case stripped == "":
+				blank = true
+				continue
+			case strings.HasPrefix(line, "  ") && !strings.HasPrefix(stripped, "!"):
+				// Blank lines between the lines of an action are dropped
+				action.addLine(line, number)
+				blank = false
+				continue
+			}
+			action.finish()
+			action = nil
+		}
+
+		switch {
+		case stripped == "":
+			blank = true
+			continue
+		case strings.HasPrefix(stripped, "!") && !strings.HasPrefix(stripped, "!["):
+			action = newSourceAction(line, number)
+			blocks = append(blocks, block{kind: blockAction, line: number, blankBefore: blank, action: action})
+		case strings.HasPrefix(stripped, "//"):
+			blocks = append(blocks, block{kind: blockComment, line: number, blankBefore: blank, text: stripped})
+		default:
+			blocks = append(blocks, block{kind: blockText, line: number, blankBefore: blank, text: strings.TrimRight(line, " ")})
+		}
+		blank = false
+	}
+	if action != nil {
+		action.finish()
+	}
+	return blocks
+}
+
+// newSourceAction scans the line of the name of an action
+func newSourceAction(line string, number int) *sourceAction {
+	a := &sourceAction{line: number, lines: []string{line}, multiline: -1}
+	indent := len(line) - len(strings.TrimLeft(line, " "))
+	name, _, _ := strings.Cut(line[indent:], " ")
+	a.name = strings.TrimLeft(name, "!")
+	a.prefix = name[:len(name)-len(a.name)]
+	if len(a.prefix) > 4 {
+		a.problem(number, indent+1, SeverityError, "invalid action prefix %s, which has more than 4 !", a.prefix)
+	}
+	if actor, action, ok := strings.Cut(a.name, "."); ok {
+		a.actor, a.name = actor, action
+		if strings.Contains(action, ".") {
+			a.problem(number, indent+1, SeverityError, "invalid action name %s, which has more than one .", name)
+		}
+	}
+	if a.name == "" {
+		a.problem(number, indent+1, SeverityError, "action without a name")
+	}
+
+	if params := strings.TrimSpace(line[indent+len(name):]); params != "" {
+		a.paramsData = append(a.paramsData, params)
+	}
+	a.scanParams(line, indent+len(name), number)
+	return a
+}
+
+// addLine adds a line of the params of the action
+func (a *sourceAction) addLine(line string, number int) {
+	a.lines = append(a.lines, line)
+	a.paramsData = append(a.paramsData, line)
+
+	if a.multiline >= 0 {
+		param := &a.params[a.multiline]
+		// The parser only ends a multiline value at the end of a line
+		if strings.HasSuffix(line, "'") && !strings.HasSuffix(line, `\'`) {
+			param.value += line[:len(line)-1]
+			a.multiline = -1
+		} else {
+			param.value += line + "\n"
+		}
+		return
+	}
+	a.scanParams(line, 0, number)
+}
+
+// scanParams scans the params of a line from pos, the way the ParamsParser
+// parses them
+func (a *sourceAction) scanParams(line string, pos, number int) {
+	row := a.rows
+	started := false
+	for pos < len(line) {
+		for pos < len(line) && line[pos] == ' ' {
+			pos++
+		}
+		if pos >= len(line) {
+			break
+		}
+		colon := strings.IndexByte(line[pos:], ':')
+		if colon < 0 {
+			a.problem(number, pos+1, SeverityWarning, "text without a param is ignored: %s", line[pos:])
+			break
+		}
+		colon += pos
+		rawKey := strings.TrimSpace(line[pos:colon])
+		key := tools.NameFix(rawKey)
+		switch {
+		case key == "":
+			a.problem(number, pos+1, SeverityWarning, "param without a name is ignored")
+			pos = colon + 1
+			continue
+		case strings.Contains(rawKey, " "):
+			a.problem(number, pos+1, SeverityWarning, "text before param is read as part of its name %s", key)
+		}
+
+		param := sourceParam{key: key, row: row, line: number, column: pos + 1}
+		started = true
+		pos = colon + 1
+		for pos < len(line) && line[pos] == ' ' {
+			pos++
+		}
+		switch {
+		case pos >= len(line):
+		case line[pos] == '\'':
+			param.quoted = true
+			end := -1
+			for j := pos + 1; j < len(line); j++ {
+				if line[j] == '\'' && line[j-1] != '\\' {
+					end = j
+					break
+				}
+			}
+			if end < 0 {
+				param.value = line[pos+1:] + "\n"
+				a.multiline = len(a.params)
+				pos = len(line)
+			} else {
+				param.value = line[pos+1 : end]
+				pos = end + 1
+			}
+		default:
+			end := strings.IndexByte(line[pos:], ' ')
+			if end < 0 {
+				end = len(line) - pos
+			}
+			param.value = line[pos : pos+end]
+			pos += end
+		}
+		a.params = append(a.params, param)
+	}
+	// The line of the action name is always a row
+	if started || row == 0 {
+		a.rows++
+	}
+}
+
+// finish checks the action at its end
+func (a *sourceAction) finish() {
+	if a.multiline >= 0 {
+		param := a.params[a.multiline]
+		a.problem(param.line, param.column, SeverityError, "quoted value of param %s is not closed", param.key)
+	}
+}
+
+// problem adds a problem of the way the action is written
+func (a *sourceAction) problem(line, column int, severity Severity, format string, args ...interface{}) {
+	a.problems = append(a.problems, newDiagnostic(line, column, severity, format, args...))
+}
@@ -0,0 +1,201 @@
+package playbook
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/paramsparser"
+	"github.com/freeflowuniverse/herolauncher/pkg/tools"
+)
+
+// Severity is how bad a problem that Lint found is
+type Severity string
+
+const (
+	// SeverityError is a problem that makes the script fail to parse or run
+	SeverityError Severity = "error"
+	// SeverityWarning is a problem that is likely a mistake, like text that
+	// the parser ignores
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a problem of a script that Lint found
+type Diagnostic struct {
+	// Line and Column are where the problem is, from 1
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Severity Severity `json:"severity"`
+	// Param is the param that the problem is about, if any
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// String returns the diagnostic as line:column: severity: message
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %s: %s", d.Line, d.Column, d.Severity, d.Message)
+}
+
+// Checker checks an action of a script for Lint, like against the schemas
+// of the handlers that run it. The diagnostics it returns are placed at the
+// param they are about, or at the action if they have no Param.
+type Checker func(action *Action) []Diagnostic
+
+// newDiagnostic returns a diagnostic at a position
+func newDiagnostic(line, column int, severity Severity, format string, args ...interface{}) Diagnostic {
+	return Diagnostic{Line: line, Column: column, Severity: severity, Message: fmt.Sprintf(format, args...)}
+}
+
+// Lint checks a script without running it, and returns its problems sorted
+// by position: actions and params that are not written correctly, params
+// that are set more than once and control params that cannot be evaluated,
+// as Plan checks them, and what the checkers find. Included files are not
+// read, so the results kept by their actions are not known.
+func Lint(text string, checkers ...Checker) []Diagnostic {
+	var diagnostics []Diagnostic
+	kept := make(map[string]bool)
+	included := false
+	id := 0
+	for _, b := range scanScript(text) {
+		if b.kind != blockAction {
+			continue
+		}
+		a := b.action
+		id++
+		diagnostics = append(diagnostics, a.problems...)
+		if a.hasErrors() {
+			continue
+		}
+
+		action := &Action{
+			ID:         id,
+			Actor:      tools.NameFix(a.actor),
+			Name:       tools.NameFix(a.name),
+			Params:     paramsparser.New(),
+			Result:     paramsparser.New(),
+			ActionType: actionType(a.prefix),
+		}
+		if a.actor == "" {
+			action.Actor = "core"
+		}
+		if err := action.Params.Parse(strings.Join(a.paramsData, "\n")); err != nil {
+			diagnostics = append(diagnostics, newDiagnostic(a.line, 1, SeverityError, "%v", err))
+			continue
+		}
+		action.Params.Delete("id")
+
+		seen := make(map[string]bool)
+		for _, param := range a.params {
+			if seen[param.key] {
+				diagnostics = append(diagnostics, a.at(Diagnostic{
+					Severity: SeverityWarning,
+					Param:    param.key,
+					Message:  fmt.Sprintf("param %s is set more than once, the last value is used", param.key),
+				}))
+			}
+			seen[param.key] = true
+		}
+
+		if action.IsInclude() {
+			included = true
+			if action.Params.Get("path") == "" {
+				diagnostics = append(diagnostics, a.at(Diagnostic{Severity: SeverityError, Message: ErrIncludeWithoutPath.Error()}))
+			}
+			continue
+		}
+		for _, d := range checkControl(action, kept, included) {
+			diagnostics = append(diagnostics, a.at(d))
+		}
+		for _, check := range checkers {
+			for _, d := range check(action) {
+				diagnostics = append(diagnostics, a.at(d))
+			}
+		}
+		if name := action.Params.Get(ParamAs); name != "" {
+			kept[name] = true
+		}
+	}
+
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		if diagnostics[i].Line != diagnostics[j].Line {
+			return diagnostics[i].Line < diagnostics[j].Line
+		}
+		return diagnostics[i].Column < diagnostics[j].Column
+	})
+	return diagnostics
+}
+
+// actionType returns the type of an action with a prefix, as the parser
+// reads it
+func actionType(prefix string) ActionType {
+	switch len(prefix) {
+	case 1:
+		return ActionTypeDAL
+	case 2:
+		return ActionTypeSAL
+	case 3:
+		return ActionTypeMacro
+	case 4:
+		return ActionTypeWAL
+	}
+	return ActionTypeUnknown
+}
+
+// checkControl checks the control params of an action like Plan does, with
+// the results kept by the actions before it. Results are not known after an
+// include.
+func checkControl(action *Action, kept map[string]bool, included bool) []Diagnostic {
+	var diagnostics []Diagnostic
+	if cond := action.Params.GetVerbatim(ParamIf); cond != "" {
+		known := kept
+		if match := condition.FindStringSubmatch(cond); included && match != nil {
+			// The result can be kept by an included action
+			known = map[string]bool{tools.NameFix(match[1]): true}
+		}
+		if err := checkCondition(cond, known); err != nil {
+			diagnostics = append(diagnostics, Diagnostic{Severity: SeverityError, Param: ParamIf, Message: err.Error()})
+		}
+	}
+	if !included {
+		for _, name := range strings.Split(action.Params.Get(ParamDependsOn), ",") {
+			if name = strings.TrimSpace(name); name != "" && !kept[tools.NameFix(name)] {
+				diagnostics = append(diagnostics, Diagnostic{
+					Severity: SeverityError,
+					Param:    ParamDependsOn,
+					Message:  "depends on unknown action " + name,
+				})
+			}
+		}
+	}
+	if param := action.Params.Get(ParamForeach); param != "" && !action.Params.Has(param) {
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: SeverityError,
+			Param:    ParamForeach,
+			Message:  fmt.Sprintf("foreach parameter %s is not set", param),
+		})
+	}
+	return diagnostics
+}
+
+// hasErrors reports whether the action is written so badly that it fails
+// to parse
+func (a *sourceAction) hasErrors() bool {
+	for _, problem := range a.problems {
+		if problem.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// at places a diagnostic of the action at the last time its param is set,
+// or at the action
+func (a *sourceAction) at(d Diagnostic) Diagnostic {
+	d.Line, d.Column = a.line, len(a.lines[0])-len(strings.TrimLeft(a.lines[0], " "))+1
+	for _, param := range a.params {
+		if d.Param != "" && param.key == d.Param {
+			d.Line, d.Column = param.line, param.column
+		}
+	}
+	return d
+}
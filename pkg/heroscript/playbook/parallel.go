@@ -0,0 +1,330 @@
+package playbook
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/tools"
+)
+
+// ParamDependsOn is the control param that makes an action wait for other
+// actions in ExecuteParallel: depends_on:'name1,name2' waits for the earlier
+// actions that keep their results under the names with as
+const ParamDependsOn = "depends_on"
+
+// defaultWorkers is the number of actions that ExecuteParallel runs at the
+// same time if ParallelOptions.Workers is not set
+const defaultWorkers = 4
+
+// ParallelOptions are the options of ExecuteParallel
+type ParallelOptions struct {
+	// Workers is the number of actions that run at the same time, 4 if 0
+	Workers int
+	// Logger gets a line per action when it finished, in the order of the
+	// actions in the playbook whatever order they finish in, if set
+	Logger *log.Logger
+}
+
+// ActionResult is the outcome of an action that ExecuteParallel was to run
+type ActionResult struct {
+	Action *Action
+	// Result is the result of the action, one line per item for foreach
+	Result string
+	// Skipped is set for actions whose condition did not hold
+	Skipped bool
+	// Err is why the action failed
+	Err error
+	// NotRun is set for actions that were not started as an action failed
+	NotRun bool
+}
+
+// node is an action in the graph of ExecuteParallel
+type node struct {
+	action *Action
+	// index is the position of the action in the playbook
+	index int
+	// deps are the actions to wait for, and dependents the actions that
+	// wait for this one
+	deps       []*node
+	dependents []*node
+	waiting    int
+	// keepers are the earlier actions that keep the result under the name
+	// of the condition of the action, the last one last
+	keepers []*node
+	result  ActionResult
+	ran     bool
+	done    bool
+}
+
+// ExecuteParallel runs the actions of the playbook like Execute, but runs
+// independent actions at the same time, by up to options.Workers. An action
+// waits for the actions of a lower priority, for the earlier actions that
+// its depends_on param names and for those that keep the result its if param
+// depends on; other actions of the same priority are independent. Actions
+// that are ready start in the order of the playbook. When an action fails,
+// no more actions are started and the actions that run are waited for.
+//
+// The results are returned in the order of the playbook, and also kept in
+// the Result of the actions like Execute does; actions that are done are
+// not run again and left out. The error joins the errors of all actions that
+// failed. run is called from several goroutines at the same time.
+func (p *PlayBook) ExecuteParallel(run ActionFunc, options ParallelOptions) ([]ActionResult, error) {
+	if options.Workers <= 0 {
+		options.Workers = defaultWorkers
+	}
+	actions, err := p.ActionsSorted(false)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := dependencyGraph(actions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Priorities run one after the other, each one in parallel
+	logged := 0
+	failed := false
+	for start := 0; start < len(nodes); {
+		end := start
+		for end < len(nodes) && nodes[end].action.Priority == nodes[start].action.Priority {
+			end++
+		}
+		if !failed {
+			failed = runNodes(nodes[start:end], run, options.Workers, func() {
+				logged = p.finish(nodes, logged, options.Logger)
+			})
+		}
+		start = end
+	}
+
+	var results []ActionResult
+	var errs []error
+	for _, n := range nodes {
+		if n.action.Done && !n.ran {
+			continue
+		}
+		if !n.done {
+			n.result.NotRun = true
+		}
+		results = append(results, n.result)
+		if n.result.Err != nil {
+			errs = append(errs, n.result.Err)
+		}
+	}
+	p.finish(nodes, logged, options.Logger)
+	return results, errors.Join(errs...)
+}
+
+// dependencyGraph returns the nodes of actions, in order, with the actions
+// they depend on by their depends_on and if params
+func dependencyGraph(actions []*Action) ([]*node, error) {
+	nodes := make([]*node, len(actions))
+	// keepers are the actions so far that keep their result under a name
+	keepers := make(map[string][]*node)
+	for i, action := range actions {
+		n := &node{action: action, index: i, result: ActionResult{Action: action}}
+		// Actions that are done count as finished, without a result
+		n.done = action.Done
+		nodes[i] = n
+
+		deps := make(map[*node]bool)
+		for _, name := range strings.Split(action.Params.Get(ParamDependsOn), ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			keeping, ok := keepers[tools.NameFix(name)]
+			if !ok {
+				return nil, fmt.Errorf("action %s.%s: depends on unknown action %s", action.Actor, action.Name, name)
+			}
+			for _, dep := range keeping {
+				deps[dep] = true
+			}
+		}
+		if cond := action.Params.GetVerbatim(ParamIf); cond != "" {
+			if match := condition.FindStringSubmatch(cond); match != nil {
+				n.keepers = keepers[tools.NameFix(match[1])]
+				for _, dep := range n.keepers {
+					deps[dep] = true
+				}
+			}
+		}
+		// Dependencies are added in order, so the graph is the same every run
+		for _, dep := range nodes[:i] {
+			if deps[dep] {
+				n.deps = append(n.deps, dep)
+				dep.dependents = append(dep.dependents, n)
+			}
+		}
+
+		if name := action.Params.Get(ParamAs); name != "" {
+			keepers[name] = append(keepers[name], n)
+		}
+	}
+	return nodes, nil
+}
+
+// runNodes runs the nodes of a priority with up to workers at the same time,
+// calling finished after every node that finished, and reports whether a
+// node failed. Dependencies on nodes of other priorities are finished.
+func runNodes(nodes []*node, run ActionFunc, workers int, finished func()) bool {
+	var ready []*node
+	for _, n := range nodes {
+		if n.done {
+			continue
+		}
+		for _, dep := range n.deps {
+			if !dep.done {
+				n.waiting++
+			}
+		}
+		if n.waiting == 0 {
+			ready = append(ready, n)
+		}
+	}
+
+	type outcome struct {
+		node    *node
+		result  string
+		skipped bool
+		err     error
+	}
+	outcomes := make(chan outcome)
+	running := 0
+	failed := false
+	for {
+		for !failed && running < workers && len(ready) > 0 {
+			n := ready[0]
+			ready = ready[1:]
+			results := n.conditionResults()
+			running++
+			go func() {
+				result, skipped, err := runAction(n.action, results, run)
+				outcomes <- outcome{node: n, result: result, skipped: skipped, err: err}
+			}()
+		}
+		if running == 0 {
+			return failed
+		}
+
+		o := <-outcomes
+		running--
+		n := o.node
+		n.done = true
+		switch {
+		case o.err != nil:
+			n.result.Err = o.err
+			failed = true
+		case o.skipped:
+			n.result.Skipped = true
+		default:
+			n.ran = true
+			n.result.Result = o.result
+		}
+		// Dependents of later priorities are not waiting yet, as they count
+		// what they wait for when their priority runs
+		for _, dependent := range n.dependents {
+			if dependent.waiting == 0 {
+				continue
+			}
+			if dependent.waiting--; dependent.waiting == 0 {
+				ready = insertOrdered(ready, dependent)
+			}
+		}
+		finished()
+	}
+}
+
+// conditionResults returns the result that the condition of a node depends
+// on, kept by the last of its keepers that ran, as Execute would have kept it
+func (n *node) conditionResults() map[string]string {
+	results := make(map[string]string)
+	for i := len(n.keepers) - 1; i >= 0; i-- {
+		if keeper := n.keepers[i]; keeper.ran {
+			results[keeper.action.Params.Get(ParamAs)] = keeper.result.Result
+			break
+		}
+	}
+	return results
+}
+
+// runAction runs an action with its control params evaluated like Execute
+// does, and returns its result or whether it was skipped
+func runAction(action *Action, results map[string]string, run ActionFunc) (string, bool, error) {
+	if cond := action.Params.GetVerbatim(ParamIf); cond != "" {
+		ok, err := evaluateCondition(cond, results)
+		if err != nil {
+			return "", false, fmt.Errorf("action %s.%s: %w", action.Actor, action.Name, err)
+		}
+		if !ok {
+			return "", true, nil
+		}
+	}
+
+	expanded, err := expandAction(action)
+	if err != nil {
+		return "", false, fmt.Errorf("action %s.%s: %w", action.Actor, action.Name, err)
+	}
+	var outputs []string
+	for _, a := range expanded {
+		output, err := run(a)
+		if err != nil {
+			return "", false, fmt.Errorf("action %s.%s: %w", action.Actor, action.Name, err)
+		}
+		outputs = append(outputs, strings.TrimSpace(output))
+	}
+	return strings.Join(outputs, "\n"), false, nil
+}
+
+// insertOrdered inserts a node into nodes that are in the order of the
+// playbook
+func insertOrdered(nodes []*node, n *node) []*node {
+	i := len(nodes)
+	for i > 0 && nodes[i-1].index > n.index {
+		i--
+	}
+	nodes = append(nodes, nil)
+	copy(nodes[i+1:], nodes[i:])
+	nodes[i] = n
+	return nodes
+}
+
+// finish keeps the outcomes of the nodes from logged on that finished in a
+// row in their actions, marks them done and logs them, and returns the node
+// up to which they were finished, so they are logged in order. After the
+// last priority, the nodes that did not run are logged too.
+func (p *PlayBook) finish(nodes []*node, logged int, logger *log.Logger) int {
+	for ; logged < len(nodes); logged++ {
+		n := nodes[logged]
+		if n.action.Done && !n.ran {
+			continue
+		}
+		if !n.done && !n.result.NotRun {
+			break
+		}
+		name := fmt.Sprintf("%s.%s (%d)", n.action.Actor, n.action.Name, n.action.ID)
+		switch {
+		case n.result.NotRun:
+			logf(logger, "%s: not run", name)
+		case n.result.Err != nil:
+			logf(logger, "%s: error: %v", name, n.result.Err)
+		case n.result.Skipped:
+			n.action.Result.Set("skipped", "true")
+			logf(logger, "%s: skipped", name)
+		default:
+			n.action.Result.Set("result", n.result.Result)
+			n.action.Done = true
+			p.Done = append(p.Done, n.action.ID)
+			logf(logger, "%s: done", name)
+		}
+	}
+	return logged
+}
+
+// logf logs a line if there is a logger
+func logf(logger *log.Logger, format string, args ...interface{}) {
+	if logger != nil {
+		logger.Printf(format, args...)
+	}
+}
@@ -1,6 +1,10 @@
 package playbook
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/paramsparser"
@@ -38,6 +42,27 @@ func (p *PlayBook) AddText(text string, priority int) error {
 	var comments []string
 	var paramsData []string
 
+	// finishAction parses the params of the current action, which is the
+	// last one, and replaces an include directive by the actions of the
+	// included file
+	finishAction := func() error {
+		if len(paramsData) > 0 {
+			params := strings.Join(paramsData, "\n")
+			err := action.Params.Parse(params)
+			if err != nil {
+				return err
+			}
+			// Remove ID from params if present
+			delete(action.Params.GetAll(), "id")
+		}
+		if action.IsInclude() {
+			p.Actions = p.Actions[:len(p.Actions)-1]
+			p.NrActions--
+			return p.include(action.Params.GetVerbatim("path"), priority)
+		}
+		return nil
+	}
+
 	// Process each line
 	lines := strings.Split(text, "\n")
 	for _, line := range lines {
@@ -52,14 +77,8 @@ func (p *PlayBook) AddText(text string, priority int) error {
 			if !strings.HasPrefix(line, "  ") || lineStrip == "" || strings.HasPrefix(lineStrip, "!") {
 				state = StateStart
 				// End of action, parse params
-				if len(paramsData) > 0 {
-					params := strings.Join(paramsData, "\n")
-					err := action.Params.Parse(params)
-					if err != nil {
-						return err
-					}
-					// Remove ID from params if present
-					delete(action.Params.GetAll(), "id")
+				if err := finishAction(); err != nil {
+					return err
 				}
 				comments = []string{}
 				paramsData = []string{}
@@ -158,14 +177,8 @@ func (p *PlayBook) AddText(text string, priority int) error {
 
 	// Process the last action if needed
 	if state == StateAction && action != nil && action.ID != 0 {
-		if len(paramsData) > 0 {
-			params := strings.Join(paramsData, "\n")
-			err := action.Params.Parse(params)
-			if err != nil {
-				return err
-			}
-			// Remove ID from params if present
-			delete(action.Params.GetAll(), "id")
+		if err := finishAction(); err != nil {
+			return err
 		}
 	}
 
@@ -177,23 +190,64 @@ func (p *PlayBook) AddText(text string, priority int) error {
 	return nil
 }
 
-// NewFromFile creates a new PlayBook from a file
+// NewFromFile creates a new PlayBook from a heroscript file. The files it
+// includes are relative to its directory.
 func NewFromFile(path string, priority int) (*PlayBook, error) {
-	// This is a simplified version - in a real implementation, you'd read the file
-	// and handle different file types (md, hero, etc.)
-
-	// For now, we'll just create an empty playbook
 	pb := New()
+	if err := pb.AddFile(path, priority); err != nil {
+		return nil, err
+	}
+	return pb, nil
+}
+
+// AddFile adds the heroscript of a file to the playbook, with the files it
+// includes relative to its directory
+func (p *PlayBook) AddFile(path string, priority int) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(p.including, absPath) {
+		return fmt.Errorf("%w: %s", ErrIncludeCycle, strings.Join(append(p.including, absPath), " -> "))
+	}
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
 
-	// TODO: Implement file reading and parsing
+	dir := p.dir
+	p.dir = filepath.Dir(absPath)
+	p.including = append(p.including, absPath)
+	defer func() {
+		p.dir = dir
+		p.including = p.including[:len(p.including)-1]
+	}()
 
-	return pb, nil
+	if err := p.AddText(string(data), priority); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// include adds the actions of the file of an include directive, whose path
+// is relative to the directory of the file with the directive, or to the
+// working directory for text
+func (p *PlayBook) include(path string, priority int) error {
+	if path == "" {
+		return ErrIncludeWithoutPath
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.dir, path)
+	}
+	return p.AddFile(path, priority)
 }
 
 // Errors
 var (
 	ErrInvalidActionPrefix = NewError("invalid action prefix")
 	ErrInvalidActionName   = NewError("invalid action name")
+	ErrIncludeWithoutPath  = NewError("include without path")
+	ErrIncludeCycle        = NewError("include cycle")
 )
 
 // NewError creates a new error
@@ -0,0 +1,82 @@
+package playbook
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/tools"
+)
+
+// PlannedAction is an action that Execute would run
+type PlannedAction struct {
+	// Action is the action as it would be passed to the ActionFunc, without
+	// its control params and with the item of its foreach set
+	Action *Action
+	// Source is the action of the playbook that Action was planned from
+	Source *Action
+	// Condition is the if param of the action, which is not evaluated as
+	// the results it depends on are not known without running
+	Condition string
+}
+
+// Plan returns the actions that Execute would run, in order, without
+// running any. The control params are checked: conditions must be valid and
+// depend on the result of an earlier action, like the names of depends_on
+// params, and foreach params must be set.
+// Actions with a condition are planned as if it holds.
+func (p *PlayBook) Plan() ([]PlannedAction, error) {
+	actions, err := p.ActionsSorted(false)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := make(map[string]bool)
+	var planned []PlannedAction
+	for _, action := range actions {
+		if action.Done {
+			continue
+		}
+		for _, name := range strings.Split(action.Params.Get(ParamDependsOn), ",") {
+			if name = strings.TrimSpace(name); name != "" && !kept[tools.NameFix(name)] {
+				return nil, fmt.Errorf("action %s.%s: depends on unknown action %s", action.Actor, action.Name, name)
+			}
+		}
+		cond := action.Params.GetVerbatim(ParamIf)
+		if cond != "" {
+			if err := checkCondition(cond, kept); err != nil {
+				return nil, fmt.Errorf("action %s.%s: %w", action.Actor, action.Name, err)
+			}
+		}
+
+		expanded, err := expandAction(action)
+		if err != nil {
+			return nil, fmt.Errorf("action %s.%s: %w", action.Actor, action.Name, err)
+		}
+		for _, a := range expanded {
+			planned = append(planned, PlannedAction{Action: a, Source: action, Condition: cond})
+		}
+		if name := action.Params.Get(ParamAs); name != "" {
+			kept[name] = true
+		}
+	}
+	return planned, nil
+}
+
+// checkCondition checks that the condition of an if param is valid and
+// depends on a result that is kept by an earlier action
+func checkCondition(cond string, kept map[string]bool) error {
+	match := condition.FindStringSubmatch(cond)
+	if match == nil {
+		return fmt.Errorf("invalid condition: %s", cond)
+	}
+	if name := tools.NameFix(match[1]); !kept[name] {
+		return fmt.Errorf("condition on unknown result %s: %s", name, cond)
+	}
+	if match[2] == "~" {
+		if _, err := regexp.Compile(strings.Trim(match[3], `"`)); err != nil {
+			return fmt.Errorf("invalid condition: %s: %w", cond, err)
+		}
+	}
+	return nil
+}
@@ -43,6 +43,12 @@ type PlayBook struct {
 	Result     string
 	NrActions  int
 	Done       []int
+
+	// dir is the directory of the file being added, which included files
+	// are relative to, and including the files being added, to detect
+	// include cycles
+	dir       string
+	including []string
 }
 
 // NewAction creates a new action and adds it to the playbook
@@ -131,11 +137,13 @@ func (a *Action) HeroScript() string {
 		out.WriteString(fmt.Sprintf("id:%d ", a.ID))
 	}
 
-	// Add parameters
+	// Add parameters, as they were written so that handlers parsing the
+	// script get the same values
 	if a.Params != nil && len(a.Params.GetAll()) > 0 {
 		params := a.Params.GetAll()
 		firstLine := true
-		for k, v := range params {
+		for k := range params {
+			v := a.Params.GetVerbatim(k)
 			if firstLine {
 				out.WriteString(k + ":'" + v + "'\n")
 				firstLine = false
@@ -148,6 +156,13 @@ func (a *Action) HeroScript() string {
 	return out.String()
 }
 
+// IsInclude reports whether the action is an include directive,
+// !!include path:'other.hero', which the parser replaces by the actions of
+// the file
+func (a *Action) IsInclude() bool {
+	return a.Actor == "core" && a.Name == "include"
+}
+
 // HashKey returns a unique hash for the action
 func (a *Action) HashKey() string {
 	h := sha1.New()
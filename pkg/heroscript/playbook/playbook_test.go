@@ -1,8 +1,16 @@
 package playbook
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 const testText1 = `
@@ -124,6 +132,21 @@ func TestHeroScript(t *testing.T) {
 			t.Errorf("Value for key %s doesn't match: '%s' vs '%s'", k, v1, v2)
 		}
 	}
+
+	// Quoted values are written as they were written, for handlers that
+	// parse the script of an action
+	pb, err = NewFromText("!!process.start name:'Web' command:'/usr/bin/Web --port 80'")
+	if err != nil {
+		t.Fatalf("Failed to parse text: %v", err)
+	}
+	pb2, err = NewFromText(pb.Actions[0].HeroScript())
+	if err != nil {
+		t.Fatalf("Failed to parse generated script: %v", err)
+	}
+	params := pb2.Actions[0].Params
+	if params.Get("name") != "web" || params.GetVerbatim("command") != "/usr/bin/Web --port 80" {
+		t.Errorf("Expected the params to be kept, got '%s' and '%s'", params.Get("name"), params.GetVerbatim("command"))
+	}
 }
 
 func TestSpacedValues(t *testing.T) {
@@ -209,3 +232,666 @@ func TestMultipleActions(t *testing.T) {
 		t.Errorf("Expected packages 'git,curl,wget', got '%s'", packages)
 	}
 }
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.hero": "!!system.update force:1\n\n!!include path:'services/web.hero'\n\n!!system.reboot\n",
+		// Includes are relative to the directory of the including file
+		"services/web.hero": "!!process.start name:'web'\n\n!!include path:'../common.hero'\n",
+		"common.hero":       "!!process.start name:'redis'\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pb, err := NewFromFile(filepath.Join(dir, "main.hero"), 10)
+	if err != nil {
+		t.Fatalf("Failed to parse file: %v", err)
+	}
+
+	expected := []string{"system.update", "process.start:web", "process.start:redis", "system.reboot"}
+	if len(pb.Actions) != len(expected) {
+		t.Fatalf("Expected %d actions, got %d", len(expected), len(pb.Actions))
+	}
+	for i, action := range pb.Actions {
+		got := action.Actor + "." + action.Name
+		if name := action.Params.Get("name"); name != "" {
+			got += ":" + name
+		}
+		if got != expected[i] {
+			t.Errorf("Expected action %d to be %s, got %s", i, expected[i], got)
+		}
+		if action.ID != i+1 {
+			t.Errorf("Expected action %d to have ID %d, got %d", i, i+1, action.ID)
+		}
+	}
+	if pb.NrActions != len(expected) {
+		t.Errorf("Expected %d actions counted, got %d", len(expected), pb.NrActions)
+	}
+
+	// Text includes files relative to the working directory
+	pb, err = NewFromText("!!include path:'" + filepath.Join(dir, "common.hero") + "'")
+	if err != nil {
+		t.Fatalf("Failed to parse text: %v", err)
+	}
+	if len(pb.Actions) != 1 || pb.Actions[0].Params.Get("name") != "redis" {
+		t.Errorf("Expected the action of the included file, got %v", pb.Actions)
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.hero": "!!include path:'b.hero'\n",
+		"b.hero": "!!process.start name:'web'\n!!include path:'a.hero'\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := NewFromFile(filepath.Join(dir, "a.hero"), 10)
+	if !errors.Is(err, ErrIncludeCycle) {
+		t.Errorf("Expected an include cycle, got %v", err)
+	}
+
+	_, err = NewFromText("!!include path:'" + filepath.Join(dir, "missing.hero") + "'")
+	if err == nil || !strings.Contains(err.Error(), "missing.hero") {
+		t.Errorf("Expected an error for the missing file, got %v", err)
+	}
+
+	_, err = NewFromText("!!include")
+	if !errors.Is(err, ErrIncludeWithoutPath) {
+		t.Errorf("Expected an include without path, got %v", err)
+	}
+}
+
+func TestExecuteConditions(t *testing.T) {
+	script := `
+!!system.check name:'redis' as:'Redis'
+
+!!process.start name:'redis' if:'Redis == stopped'
+
+!!process.stop name:'redis' if:'redis != stopped'
+
+!!process.logs name:'redis' if:'redis ~ ^stop'
+
+!!process.status name:'web' if:'web'
+`
+	pb, err := NewFromText(script)
+	if err != nil {
+		t.Fatalf("Failed to parse script: %v", err)
+	}
+
+	var ran []string
+	err = pb.Execute(func(action *Action) (string, error) {
+		if action.Params.Has(ParamAs) || action.Params.Has(ParamIf) {
+			t.Errorf("Expected action %s.%s without control params", action.Actor, action.Name)
+		}
+		ran = append(ran, action.Actor+"."+action.Name)
+		if action.Name == "check" {
+			return "stopped\n", nil
+		}
+		return "ok", nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to execute playbook: %v", err)
+	}
+
+	expected := []string{"system.check", "process.start", "process.logs"}
+	if strings.Join(ran, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v to run, got %v", expected, ran)
+	}
+	if result := pb.Actions[0].Result.Get("result"); result != "stopped" {
+		t.Errorf("Expected result 'stopped', got '%s'", result)
+	}
+	for _, i := range []int{2, 4} {
+		if !pb.Actions[i].Result.GetBoolDefault("skipped", false) {
+			t.Errorf("Expected action %d to be skipped", i)
+		}
+		if pb.Actions[i].Done {
+			t.Errorf("Expected skipped action %d not to be done", i)
+		}
+	}
+	if len(pb.Done) != 3 {
+		t.Errorf("Expected 3 done actions, got %v", pb.Done)
+	}
+
+	// Actions that are done do not run again
+	ran = nil
+	if err := pb.Execute(func(action *Action) (string, error) {
+		ran = append(ran, action.Name)
+		return "", nil
+	}); err != nil {
+		t.Fatalf("Failed to execute playbook again: %v", err)
+	}
+	if len(ran) != 0 {
+		t.Errorf("Expected no actions to run again, got %v", ran)
+	}
+}
+
+func TestExecuteForeach(t *testing.T) {
+	script := `
+!!process.start foreach:'name' name:'web,worker,api' command:'/usr/bin/${name} --log /var/log/${name}.log' as:'started'
+
+!!system.notify message:'done' if:'started ~ worker'
+`
+	pb, err := NewFromText(script)
+	if err != nil {
+		t.Fatalf("Failed to parse script: %v", err)
+	}
+
+	var commands []string
+	err = pb.Execute(func(action *Action) (string, error) {
+		if action.Name == "start" {
+			commands = append(commands, action.Params.GetVerbatim("command"))
+			return "started " + action.Params.Get("name"), nil
+		}
+		commands = append(commands, action.Params.Get("message"))
+		return "", nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to execute playbook: %v", err)
+	}
+
+	expected := []string{
+		"/usr/bin/web --log /var/log/web.log",
+		"/usr/bin/worker --log /var/log/worker.log",
+		"/usr/bin/api --log /var/log/api.log",
+		"done",
+	}
+	if strings.Join(commands, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected %v, got %v", expected, commands)
+	}
+	if result := pb.Actions[0].Result.Get("result"); result != "started web\nstarted worker\nstarted api" {
+		t.Errorf("Expected a result line per item, got '%s'", result)
+	}
+	// The action of the playbook keeps its params
+	if name := pb.Actions[0].Params.Get("name"); name != "web,worker,api" {
+		t.Errorf("Expected the params of the action to be kept, got name '%s'", name)
+	}
+}
+
+func TestExecuteErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{"invalid condition", "!!process.start name:'web' if:'web >= 1'"},
+		{"invalid regular expression", "!!system.check as:'web'\n\n!!process.start name:'web' if:'web ~ ['"},
+		{"foreach parameter not set", "!!process.start foreach:'name'"},
+		{"action fails", "!!process.fail name:'web'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pb, err := NewFromText(tt.script)
+			if err != nil {
+				t.Fatalf("Failed to parse script: %v", err)
+			}
+			err = pb.Execute(func(action *Action) (string, error) {
+				if action.Name == "fail" {
+					return "", fmt.Errorf("failed")
+				}
+				return "", nil
+			})
+			if err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+func TestExecuteWithRollback(t *testing.T) {
+	script := `
+!!vm.define name:'web'
+
+!!vm.disk_add foreach:'disk' name:'web' disk:'a,b'
+
+!!vm.list
+
+!!vm.start name:'web'
+`
+	run := func(action *Action) (string, UndoFunc, error) {
+		switch action.Name {
+		case "start":
+			return "", nil, fmt.Errorf("no capacity")
+		case "list":
+			return "web", nil, nil
+		}
+		what := action.Name + " " + action.Params.Get("disk")
+		return "", func() (string, error) {
+			if action.Params.Get("disk") == "b" {
+				return "", fmt.Errorf("disk busy")
+			}
+			return "undid " + strings.TrimSpace(what), nil
+		}, nil
+	}
+
+	pb, err := NewFromText(script)
+	if err != nil {
+		t.Fatalf("Failed to parse script: %v", err)
+	}
+	err = pb.ExecuteWithRollback(run, true)
+	var rollbackErr *RollbackError
+	if !errors.As(err, &rollbackErr) {
+		t.Fatalf("Expected a rollback error, got %v", err)
+	}
+	if !strings.Contains(rollbackErr.Err.Error(), "no capacity") {
+		t.Errorf("Expected the error of the failed action, got %v", rollbackErr.Err)
+	}
+
+	var undone []string
+	for _, u := range rollbackErr.Undone {
+		undone = append(undone, fmt.Sprintf("%s:%s:%t:%s", u.Action.Name, u.Undone, u.Irreversible, u.Error))
+	}
+	expected := []string{
+		"list::true:",
+		"disk_add::false:disk busy",
+		"disk_add:undid disk_add a:false:",
+		"define:undid define:false:",
+	}
+	if strings.Join(undone, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected undone %v, got %v", expected, undone)
+	}
+	if pb.Actions[0].Done || !pb.Actions[0].Result.GetBool("undone") {
+		t.Errorf("Expected the first action to be undone")
+	}
+	if !pb.Actions[2].Done || len(pb.Done) != 1 {
+		t.Errorf("Expected only the irreversible action to stay done, got %v", pb.Done)
+	}
+
+	// Without rollback the actions that ran stay done
+	pb, err = NewFromText(script)
+	if err != nil {
+		t.Fatalf("Failed to parse script: %v", err)
+	}
+	err = pb.ExecuteWithRollback(run, false)
+	if err == nil || errors.As(err, &rollbackErr) {
+		t.Fatalf("Expected an error without rollback, got %v", err)
+	}
+	if len(pb.Done) != 3 {
+		t.Errorf("Expected 3 actions to stay done, got %v", pb.Done)
+	}
+}
+
+func TestExecuteParallel(t *testing.T) {
+	pb, err := NewFromText(`
+!!vm.define name:'db' as:'db'
+
+!!vm.define name:'web' as:'web'
+
+!!vm.start name:'web' depends_on:'db, web'
+
+!!vm.backup name:'db' if:'db == define db'
+
+!!vm.backup name:'web' if:'web == other'
+`)
+	if err != nil {
+		t.Fatalf("Failed to parse script: %v", err)
+	}
+	if err := pb.AddText("!!vm.list", 20); err != nil {
+		t.Fatalf("Failed to add script: %v", err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	running, maxRunning := 0, 0
+	release := make(chan struct{})
+	run := func(action *Action) (string, error) {
+		if action.Params.Has(ParamDependsOn) {
+			t.Errorf("Expected depends_on not to be passed on")
+		}
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		order = append(order, action.Name+" "+action.Params.Get("name"))
+		mu.Unlock()
+		// The defines wait for each other, so they must run at the same time
+		if action.Name == "define" {
+			select {
+			case release <- struct{}{}:
+			case <-release:
+			case <-time.After(5 * time.Second):
+				t.Errorf("Expected the defines to run at the same time")
+			}
+		}
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return action.Name + " " + action.Params.Get("name"), nil
+	}
+
+	var logs strings.Builder
+	results, err := pb.ExecuteParallel(run, ParallelOptions{Workers: 2, Logger: log.New(&logs, "", 0)})
+	if err != nil {
+		t.Fatalf("Failed to execute playbook: %v", err)
+	}
+	if maxRunning != 2 {
+		t.Errorf("Expected 2 actions to run at the same time, got %d", maxRunning)
+	}
+	if len(order) != 5 || (order[2] != "start web" && order[3] != "start web") || order[4] != "list " {
+		t.Errorf("Expected start after the defines and list last, got %v", order)
+	}
+
+	var outcomes []string
+	for _, result := range results {
+		outcomes = append(outcomes, fmt.Sprintf("%d:%s:%t", result.Action.ID, result.Result, result.Skipped))
+	}
+	expected := "1:define db:false|2:define web:false|3:start web:false|4:backup db:false|5::true|6:list:false"
+	if strings.Join(outcomes, "|") != expected {
+		t.Errorf("Expected results %s, got %s", expected, strings.Join(outcomes, "|"))
+	}
+	expectedLogs := "vm.define (1): done\nvm.define (2): done\nvm.start (3): done\nvm.backup (4): done\nvm.backup (5): skipped\nvm.list (6): done\n"
+	if logs.String() != expectedLogs {
+		t.Errorf("Expected logs in order, got:\n%s", logs.String())
+	}
+	if len(pb.Done) != 5 || !pb.Actions[4].Result.GetBool("skipped") || pb.Actions[2].Result.Get("result") != "start web" {
+		t.Errorf("Expected the results to be kept in the actions, got done %v", pb.Done)
+	}
+
+	// Actions that are done are not run again
+	if results, err := pb.ExecuteParallel(run, ParallelOptions{}); err != nil || len(results) != 1 || results[0].Action.ID != 5 {
+		t.Errorf("Expected only the skipped action to run again, got %v, %v", results, err)
+	}
+}
+
+func TestExecuteParallelErrors(t *testing.T) {
+	pb, err := NewFromText(`
+!!vm.define name:'db' as:'db'
+
+!!vm.start name:'db' depends_on:'db'
+
+!!vm.define name:'web'
+`)
+	if err != nil {
+		t.Fatalf("Failed to parse script: %v", err)
+	}
+	if err := pb.AddText("!!vm.list", 20); err != nil {
+		t.Fatalf("Failed to add script: %v", err)
+	}
+	var logs strings.Builder
+	results, err := pb.ExecuteParallel(func(action *Action) (string, error) {
+		if action.Name == "define" {
+			return "", fmt.Errorf("no capacity for %s", action.Params.Get("name"))
+		}
+		return "", nil
+	}, ParallelOptions{Logger: log.New(&logs, "", 0)})
+	if err == nil || !strings.Contains(err.Error(), "no capacity for db") || !strings.Contains(err.Error(), "no capacity for web") {
+		t.Fatalf("Expected the errors of both defines, got %v", err)
+	}
+	if len(results) != 4 || !results[1].NotRun || !results[3].NotRun || results[2].Err == nil {
+		t.Errorf("Expected the actions after the failure not to run, got %v", results)
+	}
+	expectedLogs := "vm.define (1): error: action vm.define: no capacity for db\nvm.start (2): not run\n" +
+		"vm.define (3): error: action vm.define: no capacity for web\nvm.list (4): not run\n"
+	if logs.String() != expectedLogs {
+		t.Errorf("Expected logs in order, got:\n%s", logs.String())
+	}
+
+	for _, script := range []string{
+		"!!vm.start name:'web' depends_on:'web'",
+		"!!vm.start name:'web' depends_on:'db'\n\n!!vm.define name:'db' as:'db'",
+	} {
+		pb, err := NewFromText(script)
+		if err != nil {
+			t.Fatalf("Failed to parse script: %v", err)
+		}
+		if _, err := pb.ExecuteParallel(func(*Action) (string, error) { return "", nil }, ParallelOptions{}); err == nil {
+			t.Errorf("Expected an error executing %q", script)
+		}
+		if _, err := pb.Plan(); err == nil {
+			t.Errorf("Expected an error planning %q", script)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	script := `
+!!process.start foreach:'name' name:'web,worker' command:'/usr/bin/${name}' as:'started'
+
+!!system.notify message:'done' if:'started ~ worker'
+`
+	pb, err := NewFromText(script)
+	if err != nil {
+		t.Fatalf("Failed to parse script: %v", err)
+	}
+
+	planned, err := pb.Plan()
+	if err != nil {
+		t.Fatalf("Failed to plan playbook: %v", err)
+	}
+	if len(planned) != 3 {
+		t.Fatalf("Expected 3 planned actions, got %d", len(planned))
+	}
+	if command := planned[1].Action.Params.GetVerbatim("command"); command != "/usr/bin/worker" {
+		t.Errorf("Expected the foreach item to be substituted, got '%s'", command)
+	}
+	if planned[1].Source != pb.Actions[0] || planned[1].Action.Params.Has(ParamAs) {
+		t.Errorf("Expected a control free copy of the first action")
+	}
+	if planned[2].Condition != "started ~ worker" || planned[2].Action.Params.Has(ParamIf) {
+		t.Errorf("Expected the condition to be kept aside, got '%s'", planned[2].Condition)
+	}
+	// Nothing ran
+	for _, action := range pb.Actions {
+		if action.Done || len(action.Result.GetAll()) > 0 {
+			t.Errorf("Expected action %s.%s not to run", action.Actor, action.Name)
+		}
+	}
+
+	for _, script := range []string{
+		"!!process.start name:'web' if:'web >= 1'",
+		"!!process.start name:'web' if:'unknown == 1'",
+		"!!system.check as:'web'\n\n!!process.start name:'web' if:'web ~ ['",
+		"!!process.start foreach:'name'",
+	} {
+		pb, err := NewFromText(script)
+		if err != nil {
+			t.Fatalf("Failed to parse script: %v", err)
+		}
+		if _, err := pb.Plan(); err == nil {
+			t.Errorf("Expected an error planning %q", script)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	script := "!!Vm.Define  name : 'web'   cpu:'2'\n\tmemory:4GB\n\n\n//the database\n!!vm.define name:db\n" +
+		"    description:'\n        the main\n\n        database\n        '\nsome other text   \n!!process.start command:Foo env:'A=1'\n"
+	expected := `!!vm.define name:'web' cpu:2
+    memory:4GB
+
+// the database
+!!vm.define name:'db'
+    description:'
+        the main
+
+        database
+        '
+some other text
+!!process.start command:Foo env:'A=1'
+`
+	formatted := Format(script)
+	if formatted != expected {
+		t.Fatalf("Expected formatted script:\n%s\ngot:\n%s", expected, formatted)
+	}
+	if again := Format(formatted); again != formatted {
+		t.Errorf("Expected formatting to be idempotent, got:\n%s", again)
+	}
+
+	// The formatted script does the same
+	for _, text := range []string{script, testText1} {
+		original, err := NewFromText(text)
+		if err != nil {
+			t.Fatalf("Failed to parse script: %v", err)
+		}
+		formatted, err := NewFromText(Format(text))
+		if err != nil {
+			t.Fatalf("Failed to parse formatted script: %v", err)
+		}
+		if len(original.Actions) != len(formatted.Actions) {
+			t.Fatalf("Expected %d actions, got %d", len(original.Actions), len(formatted.Actions))
+		}
+		for i, action := range original.Actions {
+			other := formatted.Actions[i]
+			if action.Actor != other.Actor || action.Name != other.Name || !reflect.DeepEqual(action.Params.GetAll(), other.Params.GetAll()) {
+				t.Errorf("Expected action %s, got %s", action.HeroScript(), other.HeroScript())
+			}
+		}
+	}
+
+	// Actions that are not written correctly are kept as written
+	broken := "!!vm.define  name:'web\n  cpu:2\n"
+	if formatted := Format(broken); formatted != broken {
+		t.Errorf("Expected a broken action to be kept, got:\n%s", formatted)
+	}
+}
+
+func TestLint(t *testing.T) {
+	script := `!!vm.define name:'web' as:'web'
+    name:'other' some text
+
+!!vm.start name:'web' if:'db == 1' depends_on:'web'
+
+!!vm.stop foreach:'name'
+
+!!a.b.c
+
+!!vm.delete name:'web
+`
+	check := func(action *Action) []Diagnostic {
+		if action.Name == "start" {
+			return []Diagnostic{
+				{Severity: SeverityError, Param: "name", Message: "unknown vm"},
+				{Severity: SeverityWarning, Message: "starts a vm"},
+			}
+		}
+		return nil
+	}
+	var got []string
+	for _, d := range Lint(script, check) {
+		got = append(got, d.String())
+	}
+	expected := []string{
+		"2:5: warning: param name is set more than once, the last value is used",
+		"2:18: warning: text without a param is ignored: some text",
+		"4:1: warning: starts a vm",
+		"4:12: error: unknown vm",
+		"4:23: error: condition on unknown result db: db == 1",
+		"6:11: error: foreach parameter name is not set",
+		"8:1: error: invalid action name !!a.b.c, which has more than one .",
+		"10:13: error: quoted value of param name is not closed",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected diagnostics:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	// Results can be kept by included files
+	diagnostics := Lint("!!include path:'other.hero'\n\n!!vm.start if:'db' depends_on:'db'\n\n!!include\n")
+	if len(diagnostics) != 1 || diagnostics[0].Line != 5 || diagnostics[0].Message != ErrIncludeWithoutPath.Error() {
+		t.Errorf("Expected only the include without path, got %v", diagnostics)
+	}
+}
+
+func TestSerialize(t *testing.T) {
+	pb, err := NewFromText(testText1)
+	if err != nil {
+		t.Fatalf("Failed to parse text: %v", err)
+	}
+	pb.Priorities[10] = []int{2, 1}
+	pb.Actions[0].Done = true
+	pb.Actions[0].Result.Set("result", "configured")
+	pb.Actions[0].Params.SetVerbatim("password", "Se cret!")
+	pb.Done = []int{1}
+	pb.Result = "ok"
+
+	formats := []struct {
+		name  string
+		to    func(*PlayBook) ([]byte, error)
+		from  func([]byte) (*PlayBook, error)
+		check string
+	}{
+		{"json", (*PlayBook).ToJSON, FromJSON, `"type": "sal"`},
+		{"yaml", (*PlayBook).ToYAML, FromYAML, "type: sal"},
+	}
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			data, err := format.to(pb)
+			if err != nil {
+				t.Fatalf("Failed to serialize playbook: %v", err)
+			}
+			if !strings.Contains(string(data), format.check) {
+				t.Errorf("Expected %s in %s", format.check, data)
+			}
+
+			got, err := format.from(data)
+			if err != nil {
+				t.Fatalf("Failed to deserialize playbook: %v", err)
+			}
+			if len(got.Actions) != len(pb.Actions) {
+				t.Fatalf("Expected %d actions, got %d", len(pb.Actions), len(got.Actions))
+			}
+			if got.NrActions != pb.NrActions || got.Result != "ok" || len(got.Done) != 1 {
+				t.Errorf("Expected the state of the playbook to be kept, got %+v", got)
+			}
+			if len(got.Priorities[10]) != 2 || got.Priorities[10][0] != 2 {
+				t.Errorf("Expected the priorities to be kept, got %v", got.Priorities)
+			}
+			for i, action := range got.Actions {
+				want := pb.Actions[i]
+				if action.ID != want.ID || action.Actor != want.Actor || action.Name != want.Name ||
+					action.Comments != want.Comments || action.Priority != want.Priority ||
+					action.Done != want.Done || action.ActionType != want.ActionType {
+					t.Errorf("Expected action %d to be %+v, got %+v", i, want, action)
+				}
+				if !reflect.DeepEqual(action.Params.GetAll(), want.Params.GetAll()) {
+					t.Errorf("Expected params %v, got %v", want.Params.GetAll(), action.Params.GetAll())
+				}
+			}
+			if result := got.Actions[0].Result.Get("result"); result != "configured" {
+				t.Errorf("Expected the result to be kept, got '%s'", result)
+			}
+			if password := got.Actions[0].Params.GetVerbatim("password"); password != "Se cret!" {
+				t.Errorf("Expected the password to be kept as written, got '%s'", password)
+			}
+
+			// Serializing again gives the same document, so it can be diffed
+			again, err := format.to(got)
+			if err != nil {
+				t.Fatalf("Failed to serialize playbook again: %v", err)
+			}
+			if string(again) != string(data) {
+				t.Errorf("Expected\n%s\ngot\n%s", data, again)
+			}
+		})
+	}
+}
+
+func TestDeserializeErrors(t *testing.T) {
+	if _, err := FromJSON([]byte(`{"actions":[{"id":1,"actor":"a","name":"x"},{"id":1,"actor":"a","name":"y"}]}`)); err == nil {
+		t.Errorf("Expected an error for duplicate action ids")
+	}
+	if _, err := FromJSON([]byte(`{"actions":[{"actor":"a","name":"x","type":"other"}]}`)); err == nil {
+		t.Errorf("Expected an error for an invalid action type")
+	}
+	if _, err := FromYAML([]byte("actions: [")); err == nil {
+		t.Errorf("Expected an error for invalid YAML")
+	}
+
+	// Actions without an ID are numbered after the others
+	pb, err := FromYAML([]byte("actions:\n  - actor: a\n    name: x\n  - id: 5\n    actor: a\n    name: y\n"))
+	if err != nil {
+		t.Fatalf("Failed to deserialize playbook: %v", err)
+	}
+	if pb.Actions[0].ID != 6 || pb.NrActions != 6 || pb.Actions[0].Params == nil {
+		t.Errorf("Expected action 6 of 6 with params, got %+v", pb.Actions[0])
+	}
+}
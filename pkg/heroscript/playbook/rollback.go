@@ -0,0 +1,123 @@
+package playbook
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UndoFunc undoes an action that ran, and returns what was undone
+type UndoFunc func() (string, error)
+
+// UndoableFunc runs an action like an ActionFunc, and returns a function that
+// undoes it, or nil if the action cannot be undone
+type UndoableFunc func(action *Action) (string, UndoFunc, error)
+
+// UndoneAction is an action that ran and was rolled back
+type UndoneAction struct {
+	// Action is the action as it ran, a copy per item of a foreach action
+	Action *Action
+	// Undone is what was undone
+	Undone string
+	// Irreversible is set for actions that cannot be undone, which stay done
+	Irreversible bool
+	// Error is why undoing the action failed
+	Error string
+}
+
+// RollbackError is the error of an action that failed, after the actions
+// that ran before it were rolled back
+type RollbackError struct {
+	Err error
+	// Undone are the actions that were rolled back, the last one first
+	Undone []UndoneAction
+}
+
+// Error implements the error interface, with a line per action that was
+// rolled back
+func (e *RollbackError) Error() string {
+	var out strings.Builder
+	out.WriteString(e.Err.Error())
+	out.WriteString(fmt.Sprintf("\nrolled back %d actions:", len(e.Undone)))
+	for _, undone := range e.Undone {
+		out.WriteString(fmt.Sprintf("\n  %s.%s (%d): ", undone.Action.Actor, undone.Action.Name, undone.Action.ID))
+		switch {
+		case undone.Error != "":
+			out.WriteString("failed to undo: " + undone.Error)
+		case undone.Irreversible:
+			out.WriteString("cannot be undone")
+		case undone.Undone == "":
+			out.WriteString("nothing to undo")
+		default:
+			out.WriteString(undone.Undone)
+		}
+	}
+	return out.String()
+}
+
+// Unwrap returns the error of the action that failed
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// ExecuteWithRollback runs the actions of the playbook like Execute, with run
+// returning how to undo every action it ran. When an action fails and
+// rollback is set, the actions that ran before it are undone in reverse
+// order, the items of foreach actions one by one, and the error is a
+// *RollbackError with what was undone. Actions that were undone are not done
+// anymore and have "undone" set in their Result. Actions that fail to be
+// undone do not stop the rollback.
+func (p *PlayBook) ExecuteWithRollback(run UndoableFunc, rollback bool) error {
+	type ranAction struct {
+		action *Action
+		undo   UndoFunc
+	}
+	var ran []ranAction
+
+	err := p.Execute(func(action *Action) (string, error) {
+		result, undo, err := run(action)
+		if err == nil {
+			ran = append(ran, ranAction{action: action, undo: undo})
+		}
+		return result, err
+	})
+	if err == nil || !rollback || len(ran) == 0 {
+		return err
+	}
+
+	rollbackErr := &RollbackError{Err: err}
+	undoneIDs := make(map[int]bool)
+	for i := len(ran) - 1; i >= 0; i-- {
+		undone := UndoneAction{Action: ran[i].action}
+		if ran[i].undo == nil {
+			undone.Irreversible = true
+		} else if result, err := ran[i].undo(); err != nil {
+			undone.Error = err.Error()
+		} else {
+			undone.Undone = result
+			undoneIDs[ran[i].action.ID] = true
+		}
+		rollbackErr.Undone = append(rollbackErr.Undone, undone)
+	}
+	p.markUndone(undoneIDs)
+	return rollbackErr
+}
+
+// markUndone marks the actions with the IDs as not done, as they were
+// rolled back
+func (p *PlayBook) markUndone(ids map[int]bool) {
+	for _, action := range p.Actions {
+		if !ids[action.ID] {
+			continue
+		}
+		action.Done = false
+		action.Result.Delete("result")
+		action.Result.Set("undone", "true")
+	}
+	done := p.Done[:0]
+	for _, id := range p.Done {
+		if !ids[id] {
+			done = append(done, id)
+		}
+	}
+	p.Done = done
+}
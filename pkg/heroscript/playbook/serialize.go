@@ -0,0 +1,266 @@
+package playbook
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/paramsparser"
+	"gopkg.in/yaml.v3"
+)
+
+// actionTypeNames are the names of the action types in JSON and YAML
+var actionTypeNames = map[ActionType]string{
+	ActionTypeUnknown: "",
+	ActionTypeDAL:     "dal",
+	ActionTypeSAL:     "sal",
+	ActionTypeWAL:     "wal",
+	ActionTypeMacro:   "macro",
+}
+
+// String returns the name of the action type, or "" if it is unknown
+func (t ActionType) String() string {
+	return actionTypeNames[t]
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (t ActionType) MarshalText() ([]byte, error) {
+	name, ok := actionTypeNames[t]
+	if !ok {
+		return nil, fmt.Errorf("invalid action type: %d", int(t))
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (t *ActionType) UnmarshalText(text []byte) error {
+	for actionType, name := range actionTypeNames {
+		if name == string(text) {
+			*t = actionType
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid action type: %s", text)
+}
+
+// actionData is an action as it is serialized, with the params and results
+// as maps, which are written sorted by key so that playbooks can be diffed.
+// Verbatim has the quoted params as they were written, if the parser
+// normalized them.
+type actionData struct {
+	ID         int               `json:"id" yaml:"id"`
+	CID        string            `json:"cid,omitempty" yaml:"cid,omitempty"`
+	Actor      string            `json:"actor" yaml:"actor"`
+	Name       string            `json:"name" yaml:"name"`
+	ActionType ActionType        `json:"type,omitempty" yaml:"type,omitempty"`
+	Priority   int               `json:"priority" yaml:"priority"`
+	Comments   string            `json:"comments,omitempty" yaml:"comments,omitempty"`
+	Params     map[string]string `json:"params" yaml:"params"`
+	Verbatim   map[string]string `json:"verbatim,omitempty" yaml:"verbatim,omitempty"`
+	Result     map[string]string `json:"result,omitempty" yaml:"result,omitempty"`
+	Done       bool              `json:"done,omitempty" yaml:"done,omitempty"`
+}
+
+// playBookData is a playbook as it is serialized
+type playBookData struct {
+	Actions    []*Action     `json:"actions" yaml:"actions"`
+	Priorities map[int][]int `json:"priorities,omitempty" yaml:"priorities,omitempty"`
+	OtherText  string        `json:"other_text,omitempty" yaml:"other_text,omitempty"`
+	Result     string        `json:"result,omitempty" yaml:"result,omitempty"`
+	Done       []int         `json:"done,omitempty" yaml:"done,omitempty"`
+}
+
+// data returns the action as it is serialized
+func (a *Action) data() actionData {
+	data := actionData{
+		ID:         a.ID,
+		CID:        a.CID,
+		Actor:      a.Actor,
+		Name:       a.Name,
+		ActionType: a.ActionType,
+		Priority:   a.Priority,
+		Comments:   a.Comments,
+		Params:     map[string]string{},
+		Done:       a.Done,
+	}
+	if a.Params != nil {
+		data.Params = a.Params.GetAll()
+		for key, value := range data.Params {
+			if verbatim := a.Params.GetVerbatim(key); verbatim != value {
+				if data.Verbatim == nil {
+					data.Verbatim = map[string]string{}
+				}
+				data.Verbatim[key] = verbatim
+			}
+		}
+	}
+	if a.Result != nil {
+		if result := a.Result.GetAll(); len(result) > 0 {
+			data.Result = result
+		}
+	}
+	return data
+}
+
+// setData sets the action to the action that was serialized as data
+func (a *Action) setData(data actionData) {
+	*a = Action{
+		ID:         data.ID,
+		CID:        data.CID,
+		Actor:      data.Actor,
+		Name:       data.Name,
+		Priority:   data.Priority,
+		ActionType: data.ActionType,
+		Comments:   data.Comments,
+		Done:       data.Done,
+		Params:     paramsparser.New(),
+		Result:     paramsparser.New(),
+	}
+	for key, value := range data.Params {
+		a.Params.Set(key, value)
+	}
+	for key, value := range data.Verbatim {
+		a.Params.SetVerbatim(key, value)
+	}
+	for key, value := range data.Result {
+		a.Result.Set(key, value)
+	}
+}
+
+// MarshalJSON implements json.Marshaler
+func (a *Action) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.data())
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (a *Action) UnmarshalJSON(b []byte) error {
+	var data actionData
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	a.setData(data)
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler
+func (a *Action) MarshalYAML() (interface{}, error) {
+	return a.data(), nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (a *Action) UnmarshalYAML(value *yaml.Node) error {
+	var data actionData
+	if err := value.Decode(&data); err != nil {
+		return err
+	}
+	a.setData(data)
+	return nil
+}
+
+// data returns the playbook as it is serialized
+func (p *PlayBook) data() playBookData {
+	return playBookData{
+		Actions:    p.Actions,
+		Priorities: p.Priorities,
+		OtherText:  p.OtherText,
+		Result:     p.Result,
+		Done:       p.Done,
+	}
+}
+
+// setData sets the playbook to the playbook that was serialized as data,
+// checking that the IDs of its actions are unique
+func (p *PlayBook) setData(data playBookData) error {
+	pb := New()
+	pb.OtherText = data.OtherText
+	pb.Result = data.Result
+	if data.Priorities != nil {
+		pb.Priorities = data.Priorities
+	}
+	if data.Done != nil {
+		pb.Done = data.Done
+	}
+
+	ids := make(map[int]bool)
+	for _, action := range data.Actions {
+		if action == nil || action.ID == 0 {
+			continue
+		}
+		if ids[action.ID] {
+			return fmt.Errorf("duplicate action id: %d", action.ID)
+		}
+		ids[action.ID] = true
+		pb.NrActions = max(pb.NrActions, action.ID)
+	}
+	for _, action := range data.Actions {
+		if action == nil {
+			continue
+		}
+		// Actions without an ID are numbered after the others, like new
+		// actions
+		if action.ID == 0 {
+			pb.NrActions++
+			action.ID = pb.NrActions
+		}
+		pb.Actions = append(pb.Actions, action)
+	}
+
+	*p = *pb
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (p *PlayBook) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.data())
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (p *PlayBook) UnmarshalJSON(b []byte) error {
+	var data playBookData
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+	return p.setData(data)
+}
+
+// MarshalYAML implements yaml.Marshaler
+func (p *PlayBook) MarshalYAML() (interface{}, error) {
+	return p.data(), nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (p *PlayBook) UnmarshalYAML(value *yaml.Node) error {
+	var data playBookData
+	if err := value.Decode(&data); err != nil {
+		return err
+	}
+	return p.setData(data)
+}
+
+// ToJSON returns the playbook as indented JSON, with its actions, their
+// params, comments, priorities, done state and results
+func (p *PlayBook) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// FromJSON creates a PlayBook from the JSON of ToJSON
+func FromJSON(b []byte) (*PlayBook, error) {
+	pb := New()
+	if err := json.Unmarshal(b, pb); err != nil {
+		return nil, fmt.Errorf("failed to parse playbook JSON: %w", err)
+	}
+	return pb, nil
+}
+
+// ToYAML returns the playbook as YAML, like ToJSON
+func (p *PlayBook) ToYAML() ([]byte, error) {
+	return yaml.Marshal(p)
+}
+
+// FromYAML creates a PlayBook from the YAML of ToYAML
+func FromYAML(b []byte) (*PlayBook, error) {
+	pb := New()
+	if err := yaml.Unmarshal(b, pb); err != nil {
+		return nil, fmt.Errorf("failed to parse playbook YAML: %w", err)
+	}
+	return pb, nil
+}
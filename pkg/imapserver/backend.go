@@ -5,35 +5,70 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/backend"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailauth"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailblob"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailsearch"
 	"github.com/redis/go-redis/v9"
 )
 
 // Backend implements the go-imap backend interface
 type Backend struct {
 	redisClient *redis.Client
+	users       *mailauth.Store
+	index       *mailsearch.Index
+	// attachments holds the attachments stored outside of Redis
+	attachments *mailblob.Store
 	ctx         context.Context
 	debugMode   bool
+
+	// uidMu serializes picking and storing the UIDs of new messages
+	uidMu sync.Mutex
+
+	// sessions counts the logins of every user, limited to maxSessions
+	sessionsMu  sync.Mutex
+	sessions    map[string]int
+	maxSessions int
+	metrics     *imapMetrics
 }
 
-// NewBackend creates a new IMAP backend
+// NewBackend creates a new IMAP backend. Users are authenticated against
+// the mail users stored in the same Redis.
 func NewBackend(redisClient *redis.Client, debugMode bool) *Backend {
 	return &Backend{
 		redisClient: redisClient,
+		users:       mailauth.NewStore(redisClient),
+		index:       mailsearch.NewIndex(redisClient),
 		ctx:         context.Background(),
 		debugMode:   debugMode,
+		sessions:    make(map[string]int),
 	}
 }
 
-// Login authenticates a user. In this implementation, we accept any username/password
+// Login authenticates a user. The normalized username is the namespace of
+// the user's mailboxes in Redis.
 func (b *Backend) Login(_ *imap.ConnInfo, username, password string) (backend.User, error) {
 	log.Printf("Login attempt for user: %s", username)
-	// Accept any username/password combination
+	name, err := b.users.Authenticate(username, password)
+	if err == mailauth.ErrInvalidCredentials {
+		log.Printf("Login failed for user: %s", username)
+		return nil, backend.ErrInvalidCredentials
+	}
+	if err != nil {
+		log.Printf("ERROR: Login failed for user %s: %v", username, err)
+		return nil, err
+	}
+	if err := b.openSession(name); err != nil {
+		log.Printf("Login refused for user %s: too many connections", name)
+		return nil, err
+	}
+
 	return &User{
 		backend:  b,
-		username: username,
+		username: name,
 	}, nil
 }
 
@@ -41,6 +76,9 @@ func (b *Backend) Login(_ *imap.ConnInfo, username, password string) (backend.Us
 type User struct {
 	backend  *Backend
 	username string
+
+	// logout makes sure the login is only given up once
+	logout sync.Once
 }
 
 // Username returns the user's username
@@ -51,6 +89,19 @@ func (u *User) Username() string {
 // ListMailboxes returns a list of mailboxes available for this user
 func (u *User) ListMailboxes(subscribed bool) ([]backend.Mailbox, error) {
 	log.Printf("Listing mailboxes for user: %s", u.username)
+
+	// LSUB lists the subscriptions, even of mailboxes that no longer exist
+	if subscribed {
+		names, err := u.subscribedMailboxes()
+		if err != nil {
+			return nil, err
+		}
+		mailboxes := make([]backend.Mailbox, 0, len(names))
+		for _, name := range names {
+			mailboxes = append(mailboxes, &Mailbox{backend: u.backend, user: u, name: name})
+		}
+		return mailboxes, nil
+	}
 	
 	// Get all keys matching the pattern mail:in:username:*
 	pattern := fmt.Sprintf("mail:in:%s:*", u.username)
@@ -146,6 +197,39 @@ func (u *User) ListMailboxes(subscribed bool) ([]backend.Mailbox, error) {
 		}
 	}
 
+	// Add the mailboxes created without messages, with their parents
+	created, err := u.createdMailboxes()
+	if err != nil {
+		return nil, err
+	}
+	for _, folder := range created {
+		folderMap[folder] = true
+		for _, parent := range mailboxParents(folder) {
+			folderMap[parent] = true
+		}
+	}
+
+	// Only add standard mailboxes if there are no mailboxes found. They are
+	// recorded, so they keep existing once they are used.
+	if len(folderMap) == 0 {
+		if u.backend.debugMode {
+			log.Printf("DEBUG: No mailboxes found in Redis, adding standard mailboxes")
+		}
+
+		for _, stdBox := range standardMailboxes {
+			log.Printf("Adding standard mailbox (no mailboxes found): %s", stdBox)
+			if err := u.recordMailbox(stdBox); err != nil {
+				return nil, err
+			}
+			folderMap[stdBox] = true
+		}
+	} else if u.backend.debugMode {
+		log.Printf("DEBUG: Found %d mailboxes in Redis, not adding standard mailboxes", len(folderMap))
+	}
+
+	// The inbox always exists
+	folderMap[inboxName] = true
+
 	// Create mailbox objects for each unique folder
 	mailboxes := make([]backend.Mailbox, 0, len(folderMap))
 	for folder := range folderMap {
@@ -165,36 +249,23 @@ func (u *User) ListMailboxes(subscribed bool) ([]backend.Mailbox, error) {
 		}
 	}
 
-	// Only add standard mailboxes if there are no mailboxes found
-	if len(folderMap) == 0 {
-		if u.backend.debugMode {
-			log.Printf("DEBUG: No mailboxes found in Redis, adding standard mailboxes")
-		}
-		
-		// Use lowercase for standard mailboxes
-		standardMailboxes := []string{"inbox", "sent", "drafts", "trash"}
-		for _, stdBox := range standardMailboxes {
-			log.Printf("Adding standard mailbox (no mailboxes found): %s", stdBox)
-			mailboxes = append(mailboxes, &Mailbox{
-				backend:  u.backend,
-				user:     u,
-				name:     stdBox,
-				messages: nil,
-			})
-		}
-	} else if u.backend.debugMode {
-		log.Printf("DEBUG: Found %d mailboxes in Redis, not adding standard mailboxes", len(folderMap))
-	}
-
 	return mailboxes, nil
 }
 
 // GetMailbox returns a mailbox by name
 func (u *User) GetMailbox(name string) (backend.Mailbox, error) {
 	// Convert mailbox name to lowercase for consistency
-	lowerName := strings.ToLower(name)
+	lowerName := normalizeMailboxName(name)
 	log.Printf("Getting mailbox %s (lowercase: %s) for user: %s", name, lowerName, u.username)
-	
+
+	exists, err := u.mailboxExists(lowerName)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, backend.ErrNoSuchMailbox
+	}
+
 	// Create a new mailbox object with lowercase name
 	mailbox := &Mailbox{
 		backend:  u.backend,
@@ -211,82 +282,139 @@ func (u *User) GetMailbox(name string) (backend.Mailbox, error) {
 	return mailbox, nil
 }
 
-// CreateMailbox creates a new mailbox
+// CreateMailbox creates a new mailbox, and its parents if they do not
+// exist yet. It is recorded in Redis, so it exists before it has messages.
 func (u *User) CreateMailbox(name string) error {
 	// Convert mailbox name to lowercase for consistency
-	lowerName := strings.ToLower(name)
+	lowerName := normalizeMailboxName(name)
 	log.Printf("Creating mailbox %s (lowercase: %s) for user: %s", name, lowerName, u.username)
-	// Since we're using Redis as a key-value store, we don't need to explicitly create mailboxes
-	// They will be created implicitly when messages are added
-	return nil
+
+	if err := validateMailboxName(lowerName); err != nil {
+		return err
+	}
+	exists, err := u.mailboxExists(lowerName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return backend.ErrMailboxAlreadyExists
+	}
+
+	return u.recordMailbox(lowerName)
 }
 
-// DeleteMailbox deletes a mailbox
+// DeleteMailbox deletes a mailbox and its messages. Mailboxes nested in it
+// are kept, the inbox cannot be deleted.
 func (u *User) DeleteMailbox(name string) error {
 	// Convert mailbox name to lowercase for consistency
-	lowerName := strings.ToLower(name)
+	lowerName := normalizeMailboxName(name)
 	log.Printf("Deleting mailbox %s (lowercase: %s) for user: %s", name, lowerName, u.username)
-	
-	// Get all keys matching the pattern mail:in:username:mailbox:*
-	pattern := fmt.Sprintf("mail:in:%s:%s:*", u.username, lowerName)
-	keys, err := u.backend.redisClient.Keys(u.backend.ctx, pattern).Result()
+
+	if lowerName == inboxName {
+		return fmt.Errorf("the inbox cannot be deleted")
+	}
+	exists, err := u.mailboxExists(lowerName)
 	if err != nil {
 		return err
 	}
+	if !exists {
+		return backend.ErrNoSuchMailbox
+	}
 
-	// Delete all keys in this mailbox
+	// Delete the messages directly in this mailbox
+	keys, err := u.messageKeys(lowerName, false)
+	if err != nil {
+		return err
+	}
 	if len(keys) > 0 {
-		return u.backend.redisClient.Del(u.backend.ctx, keys...).Err()
+		if err := u.backend.redisClient.Del(u.backend.ctx, keys...).Err(); err != nil {
+			return fmt.Errorf("failed to delete messages: %w", err)
+		}
+		if err := u.backend.redisClient.HDel(u.backend.ctx, modSeqsKey(u.username), keys...).Err(); err != nil {
+			return fmt.Errorf("failed to delete modification sequences: %w", err)
+		}
+		for _, key := range keys {
+			if err := u.backend.index.Remove(u.username, key); err != nil {
+				return err
+			}
+		}
+	}
+	if err := u.backend.redisClient.Del(u.backend.ctx, vanishedKey(u.username, lowerName)).Err(); err != nil {
+		return fmt.Errorf("failed to delete expunged messages: %w", err)
 	}
 
+	if err := u.backend.redisClient.HDel(u.backend.ctx, mailboxesKey(u.username), lowerName).Err(); err != nil {
+		return fmt.Errorf("failed to delete mailbox: %w", err)
+	}
+	if err := u.backend.redisClient.HDel(u.backend.ctx, specialUseKey(u.username), lowerName).Err(); err != nil {
+		return fmt.Errorf("failed to delete special use: %w", err)
+	}
 	return nil
 }
 
-// RenameMailbox renames a mailbox
+// RenameMailbox renames a mailbox, moving its messages and the mailboxes
+// nested in it. Renaming the inbox moves its messages to a new mailbox and
+// leaves the inbox empty.
 func (u *User) RenameMailbox(existingName, newName string) error {
 	// Convert mailbox names to lowercase for consistency
-	lowerExistingName := strings.ToLower(existingName)
-	lowerNewName := strings.ToLower(newName)
-	log.Printf("Renaming mailbox %s (lowercase: %s) to %s (lowercase: %s) for user: %s", 
+	lowerExistingName := normalizeMailboxName(existingName)
+	lowerNewName := normalizeMailboxName(newName)
+	log.Printf("Renaming mailbox %s (lowercase: %s) to %s (lowercase: %s) for user: %s",
 		existingName, lowerExistingName, newName, lowerNewName, u.username)
-	
-	// Get all keys matching the pattern mail:in:username:oldmailbox:*
-	pattern := fmt.Sprintf("mail:in:%s:%s:*", u.username, lowerExistingName)
-	keys, err := u.backend.redisClient.Keys(u.backend.ctx, pattern).Result()
+
+	if err := validateMailboxName(lowerNewName); err != nil {
+		return err
+	}
+	if isInMailbox(lowerNewName, lowerExistingName) {
+		return fmt.Errorf("cannot rename mailbox %s into itself", lowerExistingName)
+	}
+
+	exists, err := u.mailboxExists(lowerExistingName)
 	if err != nil {
 		return err
 	}
+	if !exists {
+		return backend.ErrNoSuchMailbox
+	}
+	exists, err = u.mailboxExists(lowerNewName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return backend.ErrMailboxAlreadyExists
+	}
 
-	// For each key, create a new key with the new mailbox name and copy the value
-	for _, key := range keys {
-		// Get the value
-		value, err := u.backend.redisClient.Get(u.backend.ctx, key).Result()
-		if err != nil {
-			return err
-		}
+	// Record the new mailbox first, so it exists even without messages
+	if err := u.recordMailbox(lowerNewName); err != nil {
+		return err
+	}
 
-		// Create a new key with the new mailbox name
-		parts := strings.Split(key, ":")
-		if len(parts) >= 5 {
-			newKey := fmt.Sprintf("mail:in:%s:%s:%s", u.username, lowerNewName, parts[4])
-			
-			// Set the new key with the same value
-			if err := u.backend.redisClient.Set(u.backend.ctx, newKey, value, 0).Err(); err != nil {
-				return err
-			}
-			
-			// Delete the old key
-			if err := u.backend.redisClient.Del(u.backend.ctx, key).Err(); err != nil {
-				return err
-			}
-		}
+	inbox := lowerExistingName == inboxName
+	keys, err := u.messageKeys(lowerExistingName, !inbox)
+	if err != nil {
+		return err
+	}
+	if err := u.renameKeys(keys, lowerExistingName, lowerNewName); err != nil {
+		return err
+	}
+	if inbox {
+		return nil
 	}
 
-	return nil
+	if err := u.renameHashFields(mailboxesKey(u.username), lowerExistingName, lowerNewName); err != nil {
+		return err
+	}
+	if err := u.renameHashFields(specialUseKey(u.username), lowerExistingName, lowerNewName); err != nil {
+		return err
+	}
+	return u.renameHashFields(subscriptionsKey(u.username), lowerExistingName, lowerNewName)
 }
 
 // Logout is called when a user logs out
 func (u *User) Logout() error {
-	log.Printf("User logged out: %s", u.username)
+	u.logout.Do(func() {
+		u.backend.closeSession(u.username)
+		log.Printf("User logged out: %s", u.username)
+	})
 	return nil
 }
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/handlers"
 	"github.com/freeflowuniverse/herolauncher/pkg/imapserver"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailauth"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailblob"
+	"github.com/freeflowuniverse/herolauncher/pkg/system/stats/metrics"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfslocal"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -15,20 +24,95 @@ func main() {
 	redisAddr := flag.String("redis-addr", "localhost:6378", "Redis server address")
 	imapAddr := flag.String("imap-addr", ":1143", "IMAP server address")
 	debugMode := flag.Bool("debug", false, "Enable debug mode with verbose logging")
+	useTLS := flag.Bool("tls", false, "Offer STARTTLS on -imap-addr and serve IMAPS on -imaps-addr")
+	imapsAddr := flag.String("imaps-addr", ":1993", "IMAPS (implicit TLS) server address, empty to disable")
+	certFile := flag.String("cert", "", "TLS certificate file (a self-signed certificate is generated if missing)")
+	keyFile := flag.String("key", "", "TLS key file")
+	insecureAuth := flag.Bool("insecure-auth", false, "With -tls, still accept logins on connections without TLS")
+	usersScript := flag.String("users", "", "Heroscript file with !!mailuser actions to run at startup")
+	maxConnsPerIP := flag.Int("max-conns-per-ip", 0, "Maximum connections from one client address (0 for unlimited)")
+	maxConnsPerUser := flag.Int("max-conns-per-user", 0, "Maximum connections one user can be logged in on (0 for unlimited)")
+	idleTimeout := flag.Duration("idle-timeout", 30*time.Minute, "Close connections on which the client sent nothing for this long (0 disables)")
+	attachmentDir := flag.String("attachment-dir", "", "Directory the SMTP server stores large attachments in")
+	pruneInterval := flag.Duration("attachment-prune", time.Hour, "How often attachments no message refers to are removed from -attachment-dir")
+	metricsAddr := flag.String("metrics-addr", "", "Serve Prometheus metrics at /metrics on this address, e.g. :9143 (empty disables metrics)")
 	flag.Parse()
 
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: *redisAddr,
+	})
+	if *usersScript != "" {
+		script, err := os.ReadFile(*usersScript)
+		if err != nil {
+			log.Fatalf("Failed to read users script: %v", err)
+		}
+		handler := handlers.NewMailUserHandler(mailauth.NewStore(redisClient))
+		result, err := handler.Play(string(script), handler)
+		if err != nil {
+			log.Fatalf("Failed to run users script: %v", err)
+		}
+		log.Print(result)
+	}
+	server := imapserver.NewServer(redisClient, *imapAddr, *debugMode)
+	server.SetLimits(imapserver.Limits{
+		MaxConnsPerIP:   *maxConnsPerIP,
+		MaxConnsPerUser: *maxConnsPerUser,
+		IdleTimeout:     *idleTimeout,
+	})
+	if *useTLS {
+		options := imapserver.DefaultTLSOptions()
+		options.CertFile = *certFile
+		options.KeyFile = *keyFile
+		options.AllowInsecureAuth = *insecureAuth
+		if err := server.EnableTLS(options); err != nil {
+			log.Fatalf("Failed to enable TLS: %v", err)
+		}
+	}
+
+	if *metricsAddr != "" {
+		server.EnableMetrics(metrics.Default)
+	}
+
+	if *attachmentDir != "" {
+		fs, err := vfslocal.New(*attachmentDir)
+		if err != nil {
+			log.Fatalf("Failed to open attachment directory: %v", err)
+		}
+		attachments := mailblob.NewStore(fs, mailblob.DefaultThreshold)
+		server.SetAttachmentStore(attachments)
+		go pruneAttachments(attachments, redisClient, *pruneInterval)
+	}
+
 	// Set up signal handling for graceful shutdown
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
 	// Start the IMAP server in a goroutine
-	errCh := make(chan error, 1)
+	errCh := make(chan error, 3)
 	go func() {
 		log.Printf("Starting IMAP server on %s with Redis at %s (Debug mode: %v)", *imapAddr, *redisAddr, *debugMode)
-		if err := imapserver.ListenAndServe(*redisAddr, *imapAddr, *debugMode); err != nil {
+		if err := server.Start(); err != nil {
 			errCh <- err
 		}
 	}()
+	if *useTLS && *imapsAddr != "" {
+		go func() {
+			if err := server.StartTLS(*imapsAddr); err != nil {
+				errCh <- err
+			}
+		}()
+	}
+
+	if *metricsAddr != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Default.Handler())
+			log.Printf("Serving metrics at http://%s/metrics", *metricsAddr)
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				errCh <- err
+			}
+		}()
+	}
 
 	// Wait for either an error or a signal
 	select {
@@ -38,3 +122,21 @@ func main() {
 		log.Printf("Received signal %v, shutting down", sig)
 	}
 }
+
+// pruneAttachments removes the attachments of deleted messages from the
+// attachment store at every interval
+func pruneAttachments(attachments *mailblob.Store, redisClient *redis.Client, interval time.Duration) {
+	ctx := context.Background()
+	for range time.Tick(interval) {
+		users, err := mailauth.NewStore(redisClient).List()
+		if err != nil {
+			log.Printf("ERROR: Failed to list mail users: %v", err)
+			continue
+		}
+		for _, user := range users {
+			if _, err := attachments.Prune(ctx, redisClient, user); err != nil {
+				log.Printf("ERROR: Failed to prune attachments of %s: %v", user, err)
+			}
+		}
+	}
+}
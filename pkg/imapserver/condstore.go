@@ -0,0 +1,640 @@
+package imapserver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/server"
+)
+
+const (
+	// fetchModSeq is the MODSEQ fetch item
+	fetchModSeq imap.FetchItem = "MODSEQ"
+	// statusHighestModSeq is the HIGHESTMODSEQ status item
+	statusHighestModSeq imap.StatusItem = "HIGHESTMODSEQ"
+)
+
+// condStore implements the CONDSTORE and QRESYNC extensions (RFC 7162),
+// with ENABLE (RFC 5161) to turn them on. Clients can ask which messages
+// changed or vanished since a modification sequence instead of fetching the
+// flags of the whole mailbox, and can make flag changes conditional.
+type condStore struct{}
+
+// condStoreConn keeps the extensions a connection enabled
+type condStoreConn struct {
+	server.Conn
+
+	mu        sync.Mutex
+	condStore bool
+	qresync   bool
+}
+
+// NewConn wraps a connection to keep its state
+func (condStore) NewConn(c server.Conn) server.Conn {
+	return &condStoreConn{Conn: c}
+}
+
+// Capabilities advertises the extensions
+func (condStore) Capabilities(c server.Conn) []string {
+	return []string{"ENABLE", "CONDSTORE", "QRESYNC"}
+}
+
+// Command returns the handlers replacing the builtin commands
+func (condStore) Command(name string) server.HandlerFactory {
+	switch name {
+	case "ENABLE":
+		return func() server.Handler { return &enableCmd{} }
+	case "SELECT":
+		return func() server.Handler { return &condStoreSelect{} }
+	case "EXAMINE":
+		return func() server.Handler {
+			cmd := &condStoreSelect{}
+			cmd.ReadOnly = true
+			return cmd
+		}
+	case "FETCH":
+		return func() server.Handler { return &condStoreFetch{} }
+	case "STORE":
+		return func() server.Handler { return &condStoreStore{} }
+	case "SEARCH":
+		return func() server.Handler { return &condStoreSearch{} }
+	}
+	return nil
+}
+
+// enableCondStore turns on CONDSTORE, and QRESYNC if qresync is set, for a
+// connection. It returns false if the connection does not support it.
+func enableCondStore(conn server.Conn, qresync bool) bool {
+	c, ok := conn.(*condStoreConn)
+	if !ok {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.condStore = true
+	c.qresync = c.qresync || qresync
+	return true
+}
+
+// condStoreEnabled reports whether CONDSTORE and QRESYNC are on for a connection
+func condStoreEnabled(conn server.Conn) (condStore, qresync bool) {
+	c, ok := conn.(*condStoreConn)
+	if !ok {
+		return false, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.condStore, c.qresync
+}
+
+// selectedMailbox returns the selected mailbox of a connection
+func selectedMailbox(conn server.Conn) (*Mailbox, error) {
+	ctx := conn.Context()
+	if ctx.Mailbox == nil {
+		return nil, server.ErrNoMailboxSelected
+	}
+	mailbox, ok := ctx.Mailbox.(*Mailbox)
+	if !ok {
+		return nil, errors.New("CONDSTORE is not supported by this mailbox")
+	}
+	return mailbox, nil
+}
+
+// parseModSeq parses a modification sequence
+func parseModSeq(field interface{}) (uint64, error) {
+	s, ok := field.(string)
+	if !ok {
+		return 0, errors.New("modification sequence must be a number")
+	}
+	modSeq, err := strconv.ParseUint(s, 10, 63)
+	if err != nil {
+		return 0, fmt.Errorf("invalid modification sequence %q", s)
+	}
+	return modSeq, nil
+}
+
+// writeExpunged reports expunged messages: with VANISHED and their UIDs if
+// QRESYNC is enabled, otherwise with EXPUNGE and their sequence numbers
+func writeExpunged(conn server.Conn, seqNums, uids []uint32) error {
+	if _, qresync := condStoreEnabled(conn); qresync {
+		if len(uids) == 0 {
+			return nil
+		}
+		set := new(imap.SeqSet)
+		set.AddNum(uids...)
+		return conn.WriteResp(imap.NewUntaggedResp([]interface{}{imap.RawString("VANISHED"), set}))
+	}
+
+	// Report from the last to the first, as every expunge shifts the
+	// sequence numbers after it
+	ch := make(chan uint32, len(seqNums))
+	for i := len(seqNums) - 1; i >= 0; i-- {
+		ch <- seqNums[i]
+	}
+	close(ch)
+	return conn.WriteResp(&responses.Expunge{SeqNums: ch})
+}
+
+// writeVanishedEarlier reports the UIDs in uids that vanished after modSeq
+func writeVanishedEarlier(conn server.Conn, mailbox *Mailbox, modSeq uint64, uids *imap.SeqSet) error {
+	vanished, err := mailbox.vanishedSince(modSeq, uids)
+	if err != nil {
+		return err
+	}
+	if vanished.Empty() {
+		return nil
+	}
+	return conn.WriteResp(imap.NewUntaggedResp([]interface{}{
+		imap.RawString("VANISHED"),
+		[]interface{}{imap.RawString("EARLIER")},
+		vanished,
+	}))
+}
+
+// fetchChanged writes FETCH responses for the messages in seqSet that
+// changed after changedSince
+func fetchChanged(conn server.Conn, mailbox *Mailbox, uid bool, seqSet *imap.SeqSet, items []imap.FetchItem, changedSince uint64) error {
+	ch := make(chan *imap.Message)
+	done := make(chan error, 1)
+	go func() {
+		done <- conn.WriteResp(&responses.Fetch{Messages: ch})
+		// Make sure to drain the message channel
+		for range ch {
+		}
+	}()
+
+	if err := mailbox.ListMessagesChangedSince(uid, seqSet, items, changedSince, ch); err != nil {
+		return err
+	}
+	return <-done
+}
+
+// enableCmd is ENABLE, which turns on CONDSTORE and QRESYNC
+type enableCmd struct {
+	Capabilities []string
+}
+
+// Parse reads the capabilities to enable
+func (cmd *enableCmd) Parse(fields []interface{}) error {
+	if len(fields) == 0 {
+		return errors.New("No enough arguments")
+	}
+	for _, field := range fields {
+		capability, ok := field.(string)
+		if !ok {
+			return errors.New("Capability must be an atom")
+		}
+		cmd.Capabilities = append(cmd.Capabilities, strings.ToUpper(capability))
+	}
+	return nil
+}
+
+// Handle enables the supported capabilities and lists them in ENABLED
+func (cmd *enableCmd) Handle(conn server.Conn) error {
+	ctx := conn.Context()
+	if ctx.User == nil {
+		return server.ErrNotAuthenticated
+	}
+	if ctx.Mailbox != nil {
+		return errors.New("ENABLE is only allowed before a mailbox is selected")
+	}
+
+	fields := []interface{}{imap.RawString("ENABLED")}
+	for _, capability := range cmd.Capabilities {
+		switch capability {
+		case "CONDSTORE", "QRESYNC":
+			if enableCondStore(conn, capability == "QRESYNC") {
+				fields = append(fields, imap.RawString(capability))
+			}
+		}
+	}
+	return conn.WriteResp(imap.NewUntaggedResp(fields))
+}
+
+// qresyncParams are the QRESYNC parameters of SELECT
+type qresyncParams struct {
+	UidValidity uint32
+	ModSeq      uint64
+	KnownUIDs   *imap.SeqSet
+}
+
+// condStoreSelect is SELECT and EXAMINE with the (CONDSTORE) and
+// (QRESYNC (uidvalidity modseq [known-uids])) parameters
+type condStoreSelect struct {
+	commands.Select
+	CondStore bool
+	QResync   *qresyncParams
+}
+
+// Parse reads the mailbox and the parameters
+func (cmd *condStoreSelect) Parse(fields []interface{}) error {
+	if err := cmd.Select.Parse(fields); err != nil {
+		return err
+	}
+	if len(fields) < 2 {
+		return nil
+	}
+
+	params, ok := fields[1].([]interface{})
+	if !ok {
+		return errors.New("SELECT parameters must be a list")
+	}
+	for i := 0; i < len(params); i++ {
+		name, _ := params[i].(string)
+		switch strings.ToUpper(name) {
+		case "CONDSTORE":
+			cmd.CondStore = true
+		case "QRESYNC":
+			if i+1 >= len(params) {
+				return errors.New("Missing QRESYNC parameters")
+			}
+			i++
+			qresync, ok := params[i].([]interface{})
+			if !ok || len(qresync) < 2 {
+				return errors.New("QRESYNC parameters must be a list")
+			}
+			uidValidity, err := imap.ParseNumber(qresync[0])
+			if err != nil {
+				return err
+			}
+			modSeq, err := parseModSeq(qresync[1])
+			if err != nil {
+				return err
+			}
+			cmd.QResync = &qresyncParams{UidValidity: uidValidity, ModSeq: modSeq}
+			if len(qresync) > 2 {
+				if set, ok := qresync[2].(string); ok {
+					if cmd.QResync.KnownUIDs, err = imap.ParseSeqSet(set); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			return fmt.Errorf("Unknown SELECT parameter %v", params[i])
+		}
+	}
+	return nil
+}
+
+// Handle selects the mailbox, reporting HIGHESTMODSEQ and, with QRESYNC,
+// the messages that changed or vanished since the client last synced
+func (cmd *condStoreSelect) Handle(conn server.Conn) error {
+	if cmd.QResync != nil {
+		if _, qresync := condStoreEnabled(conn); !qresync {
+			return errors.New("QRESYNC must be enabled first")
+		}
+	}
+	if cmd.CondStore {
+		enableCondStore(conn, false)
+	}
+
+	// The builtin SELECT answers with its tagged response as error
+	result := (&server.Select{Select: cmd.Select}).Handle(conn)
+	if conn.Context().Mailbox == nil {
+		return result
+	}
+
+	condStore, _ := condStoreEnabled(conn)
+	if !condStore {
+		return result
+	}
+	mailbox, err := selectedMailbox(conn)
+	if err != nil {
+		return err
+	}
+
+	highest, err := mailbox.highestModSeq()
+	if err != nil {
+		return err
+	}
+	if err := conn.WriteResp(&imap.StatusResp{
+		Type:      imap.StatusRespOk,
+		Code:      "HIGHESTMODSEQ",
+		Arguments: []interface{}{formatModSeq(highest)},
+		Info:      "Highest",
+	}); err != nil {
+		return err
+	}
+
+	// Changes are only reported when the UIDs the client knows are still valid
+	if cmd.QResync != nil && cmd.QResync.UidValidity == uidValidity {
+		if err := writeVanishedEarlier(conn, mailbox, cmd.QResync.ModSeq, cmd.QResync.KnownUIDs); err != nil {
+			return err
+		}
+		all, _ := imap.ParseSeqSet("1:*")
+		items := []imap.FetchItem{imap.FetchUid, imap.FetchFlags, fetchModSeq}
+		if err := fetchChanged(conn, mailbox, true, all, items, cmd.QResync.ModSeq); err != nil {
+			return err
+		}
+	}
+
+	return result
+}
+
+// condStoreFetch is FETCH with the (CHANGEDSINCE modseq [VANISHED]) modifiers
+type condStoreFetch struct {
+	commands.Fetch
+	ChangedSince uint64
+	Vanished     bool
+}
+
+// Parse reads the sequence set, the items and the modifiers
+func (cmd *condStoreFetch) Parse(fields []interface{}) error {
+	if err := cmd.Fetch.Parse(fields); err != nil {
+		return err
+	}
+	if len(fields) < 3 {
+		return nil
+	}
+
+	modifiers, ok := fields[2].([]interface{})
+	if !ok {
+		return errors.New("FETCH modifiers must be a list")
+	}
+	for i := 0; i < len(modifiers); i++ {
+		name, _ := modifiers[i].(string)
+		switch strings.ToUpper(name) {
+		case "CHANGEDSINCE":
+			if i+1 >= len(modifiers) {
+				return errors.New("Missing CHANGEDSINCE value")
+			}
+			i++
+			modSeq, err := parseModSeq(modifiers[i])
+			if err != nil {
+				return err
+			}
+			cmd.ChangedSince = modSeq
+		case "VANISHED":
+			cmd.Vanished = true
+		default:
+			return fmt.Errorf("Unknown FETCH modifier %v", modifiers[i])
+		}
+	}
+	if cmd.Vanished && cmd.ChangedSince == 0 {
+		return errors.New("VANISHED requires CHANGEDSINCE")
+	}
+	return nil
+}
+
+// Handle fetches by sequence numbers
+func (cmd *condStoreFetch) Handle(conn server.Conn) error {
+	if cmd.Vanished {
+		return errors.New("VANISHED is only allowed with UID FETCH")
+	}
+	return cmd.handle(false, conn)
+}
+
+// UidHandle fetches by UIDs
+func (cmd *condStoreFetch) UidHandle(conn server.Conn) error {
+	if !containsFetchItem(cmd.Items, imap.FetchUid) {
+		cmd.Items = append(cmd.Items, imap.FetchUid)
+	}
+	return cmd.handle(true, conn)
+}
+
+func (cmd *condStoreFetch) handle(uid bool, conn server.Conn) error {
+	mailbox, err := selectedMailbox(conn)
+	if err != nil {
+		return err
+	}
+
+	// Asking for modification sequences turns CONDSTORE on, after which
+	// they are returned with the flags
+	if cmd.ChangedSince > 0 || containsFetchItem(cmd.Items, fetchModSeq) {
+		enableCondStore(conn, false)
+	}
+	condStore, qresync := condStoreEnabled(conn)
+	if condStore && (cmd.ChangedSince > 0 || containsFetchItem(cmd.Items, imap.FetchFlags)) &&
+		!containsFetchItem(cmd.Items, fetchModSeq) {
+		cmd.Items = append(cmd.Items, fetchModSeq)
+	}
+
+	if cmd.Vanished {
+		if !qresync {
+			return errors.New("QRESYNC must be enabled first")
+		}
+		if err := writeVanishedEarlier(conn, mailbox, cmd.ChangedSince, cmd.SeqSet); err != nil {
+			return err
+		}
+	}
+
+	return fetchChanged(conn, mailbox, uid, cmd.SeqSet, cmd.Items, cmd.ChangedSince)
+}
+
+// condStoreStore is STORE with the (UNCHANGEDSINCE modseq) modifier
+type condStoreStore struct {
+	commands.Store
+	UnchangedSince *uint64
+}
+
+// Parse reads the modifier and the flags
+func (cmd *condStoreStore) Parse(fields []interface{}) error {
+	if len(fields) > 1 {
+		if modifiers, ok := fields[1].([]interface{}); ok {
+			if len(modifiers) != 2 {
+				return errors.New("Invalid STORE modifiers")
+			}
+			if name, _ := modifiers[0].(string); !strings.EqualFold(name, "UNCHANGEDSINCE") {
+				return fmt.Errorf("Unknown STORE modifier %v", modifiers[0])
+			}
+			modSeq, err := parseModSeq(modifiers[1])
+			if err != nil {
+				return err
+			}
+			cmd.UnchangedSince = &modSeq
+			fields = append([]interface{}{fields[0]}, fields[2:]...)
+		}
+	}
+	return cmd.Store.Parse(fields)
+}
+
+// Handle stores flags by sequence numbers
+func (cmd *condStoreStore) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+// UidHandle stores flags by UIDs
+func (cmd *condStoreStore) UidHandle(conn server.Conn) error {
+	return cmd.handle(true, conn)
+}
+
+func (cmd *condStoreStore) handle(uid bool, conn server.Conn) error {
+	mailbox, err := selectedMailbox(conn)
+	if err != nil {
+		return err
+	}
+	if conn.Context().MailboxReadOnly {
+		return server.ErrMailboxReadOnly
+	}
+
+	// Only flags operations are supported
+	op, silent, err := imap.ParseFlagsOp(cmd.Item)
+	if err != nil {
+		return err
+	}
+	var flags []string
+	if flagsList, ok := cmd.Value.([]interface{}); ok {
+		flags, err = imap.ParseStringList(flagsList)
+	} else {
+		var flag string
+		flag, err = imap.ParseString(cmd.Value)
+		flags = []string{flag}
+	}
+	if err != nil {
+		return err
+	}
+
+	var modified []uint32
+	if cmd.UnchangedSince != nil {
+		enableCondStore(conn, false)
+		modified, err = mailbox.UpdateMessagesFlagsUnchangedSince(uid, cmd.SeqSet, op, flags, *cmd.UnchangedSince)
+	} else {
+		err = mailbox.UpdateMessagesFlags(uid, cmd.SeqSet, op, flags)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Report the new flags of the updated messages. With CONDSTORE the new
+	// modification sequences are reported even for .SILENT.
+	condStore, _ := condStoreEnabled(conn)
+	var items []imap.FetchItem
+	if !silent {
+		items = append(items, imap.FetchFlags)
+	}
+	if condStore {
+		items = append(items, fetchModSeq)
+	}
+	if uid {
+		items = append(items, imap.FetchUid)
+	}
+	if len(items) > 0 {
+		updated := new(imap.SeqSet)
+		updated.AddSet(cmd.SeqSet)
+		for _, id := range modified {
+			updated = removeFromSeqSet(updated, id)
+		}
+		if !updated.Empty() {
+			if err := fetchChanged(conn, mailbox, uid, updated, items, 0); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(modified) > 0 {
+		set := new(imap.SeqSet)
+		set.AddNum(modified...)
+		return server.ErrStatusResp(&imap.StatusResp{
+			Type:      imap.StatusRespOk,
+			Code:      "MODIFIED",
+			Arguments: []interface{}{set},
+			Info:      "Conditional STORE failed",
+		})
+	}
+	return nil
+}
+
+// removeFromSeqSet returns set without id
+func removeFromSeqSet(set *imap.SeqSet, id uint32) *imap.SeqSet {
+	result := new(imap.SeqSet)
+	for _, seq := range set.Set {
+		start, stop := seq.Start, seq.Stop
+		if start > stop && stop != 0 {
+			start, stop = stop, start
+		}
+		if !seq.Contains(id) {
+			result.AddRange(start, stop)
+			continue
+		}
+		if start != 0 && start < id {
+			result.AddRange(start, id-1)
+		}
+		if stop == 0 {
+			// Up to the last message
+			result.AddRange(id+1, 0)
+		} else if id < stop {
+			result.AddRange(id+1, stop)
+		}
+	}
+	return result
+}
+
+// condStoreSearch is SEARCH with the MODSEQ criterion
+type condStoreSearch struct {
+	commands.Search
+	ModSeq uint64
+}
+
+// Parse removes the MODSEQ criterion and parses the others
+func (cmd *condStoreSearch) Parse(fields []interface{}) error {
+	var rest []interface{}
+	for i := 0; i < len(fields); i++ {
+		name, _ := fields[i].(string)
+		if !strings.EqualFold(name, "MODSEQ") {
+			rest = append(rest, fields[i])
+			continue
+		}
+
+		// MODSEQ ["/flags/<flag>" all|shared|priv] <modseq>, the entry is ignored
+		if i+1 < len(fields) {
+			if _, err := parseModSeq(fields[i+1]); err != nil {
+				i += 2
+			}
+		}
+		if i+1 >= len(fields) {
+			return errors.New("Missing MODSEQ value")
+		}
+		i++
+		modSeq, err := parseModSeq(fields[i])
+		if err != nil {
+			return err
+		}
+		cmd.ModSeq = modSeq
+	}
+
+	if len(rest) == 0 {
+		rest = []interface{}{"ALL"}
+	}
+	return cmd.Search.Parse(rest)
+}
+
+// Handle searches by sequence numbers
+func (cmd *condStoreSearch) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+// UidHandle searches by UIDs
+func (cmd *condStoreSearch) UidHandle(conn server.Conn) error {
+	return cmd.handle(true, conn)
+}
+
+func (cmd *condStoreSearch) handle(uid bool, conn server.Conn) error {
+	mailbox, err := selectedMailbox(conn)
+	if err != nil {
+		return err
+	}
+
+	ids, highest, err := mailbox.SearchMessagesModSeq(uid, cmd.Criteria, cmd.ModSeq)
+	if err != nil {
+		return err
+	}
+	if cmd.ModSeq == 0 || len(ids) == 0 {
+		return conn.WriteResp(&responses.Search{Ids: ids})
+	}
+
+	// A search with MODSEQ turns CONDSTORE on and reports the highest
+	// modification sequence of the results
+	enableCondStore(conn, false)
+	fields := []interface{}{imap.RawString("SEARCH")}
+	for _, id := range ids {
+		fields = append(fields, id)
+	}
+	fields = append(fields, []interface{}{imap.RawString("MODSEQ"), formatModSeq(highest)})
+	return conn.WriteResp(imap.NewUntaggedResp(fields))
+}
@@ -0,0 +1,175 @@
+package imapserver
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	"github.com/freeflowuniverse/herolauncher/pkg/mail"
+)
+
+// newCondStoreServer starts a test server with three messages in the inbox
+// of alice, which get the modification sequences 1 to 3 when the inbox is
+// first selected
+func newCondStoreServer(t *testing.T) *testServer {
+	s := newTestServer(t)
+	for uid := uint32(1); uid <= 3; uid++ {
+		s.store("inbox", uid, &mail.Email{Message: "Hello", Envelope: &mail.Envelope{Subject: fmt.Sprintf("Message %d", uid)}})
+	}
+	return s
+}
+
+// modSeqLines returns the responses to SELECT about modification sequences
+// and changes, leaving out the ones every SELECT gets
+func modSeqLines(untagged []string) []string {
+	var lines []string
+	for _, line := range untagged {
+		if strings.Contains(line, "MODSEQ") || strings.Contains(line, "VANISHED") || strings.Contains(line, "FETCH") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func TestModSeq(t *testing.T) {
+	s := newCondStoreServer(t)
+	c := s.login()
+	if untagged, status := c.run("SELECT INBOX (CONDSTORE)"); !strings.HasPrefix(status, "OK") ||
+		strings.Join(modSeqLines(untagged), "\n") != "* OK [HIGHESTMODSEQ 3] Highest" {
+		t.Fatalf("Expected HIGHESTMODSEQ 3, got %q, %s", untagged, status)
+	}
+
+	c.runSteps([]step{
+		// Fetching the flags returns the modification sequences
+		{"UID FETCH 1:* (FLAGS)", []string{
+			"* 1 FETCH (FLAGS () UID 1 MODSEQ (1))",
+			"* 2 FETCH (FLAGS () UID 2 MODSEQ (2))",
+			"* 3 FETCH (FLAGS () UID 3 MODSEQ (3))",
+		}, "OK"},
+		// Every flag change gets a new modification sequence
+		{"UID STORE 2 +FLAGS (\\Seen)", []string{"* 2 FETCH (FLAGS (\\Seen) MODSEQ (4) UID 2)"}, "OK"},
+		{"STORE 3 +FLAGS.SILENT (\\Answered)", []string{"* 3 FETCH (MODSEQ (5))"}, "OK"},
+		// Storing the flags a message already has changes nothing
+		{"UID STORE 2 +FLAGS (\\Seen)", []string{"* 2 FETCH (FLAGS (\\Seen) MODSEQ (4) UID 2)"}, "OK"},
+		{"FETCH 1:* (MODSEQ)", []string{
+			"* 1 FETCH (MODSEQ (1))",
+			"* 2 FETCH (MODSEQ (4))",
+			"* 3 FETCH (MODSEQ (5))",
+		}, "OK"},
+		// FETCH CHANGEDSINCE only returns the messages that changed after it
+		{"UID FETCH 1:* (FLAGS) (CHANGEDSINCE 3)", []string{
+			"* 2 FETCH (FLAGS (\\Seen) UID 2 MODSEQ (4))",
+			"* 3 FETCH (FLAGS (\\Answered) UID 3 MODSEQ (5))",
+		}, "OK"},
+		{"FETCH 1:2 (FLAGS) (CHANGEDSINCE 3)", []string{"* 2 FETCH (FLAGS (\\Seen) MODSEQ (4))"}, "OK"},
+		{"UID FETCH 1:* (FLAGS) (CHANGEDSINCE 5)", nil, "OK"},
+		{"FETCH 1:* (FLAGS) (CHANGEDSINCE 1 VANISHED)", nil, "NO VANISHED is only allowed with UID FETCH"},
+		{"SEARCH MODSEQ 4", []string{"* SEARCH 2 3 (MODSEQ 5)"}, "OK"},
+		// STORE UNCHANGEDSINCE updates the messages that did not change
+		// after it, and reports the others as MODIFIED
+		{"UID STORE 1:3 (UNCHANGEDSINCE 4) +FLAGS (\\Flagged)", []string{
+			"* 1 FETCH (FLAGS (\\Flagged) MODSEQ (6) UID 1)",
+			"* 2 FETCH (FLAGS (\\Seen \\Flagged) MODSEQ (7) UID 2)",
+		}, "OK [MODIFIED 3] Conditional STORE failed"},
+		{"STORE 1:2 (UNCHANGEDSINCE 0) -FLAGS (\\Flagged)", nil, "OK [MODIFIED 1:2] Conditional STORE failed"},
+		{"STORE 1 (UNCHANGEDSINCE 6) -FLAGS.SILENT (\\Flagged)", []string{"* 1 FETCH (MODSEQ (8))"}, "OK"},
+		// An expunge gets a modification sequence too, so it raises the
+		// highest one of the mailbox
+		{"STORE 3 +FLAGS.SILENT (\\Deleted)", []string{"* 3 FETCH (MODSEQ (9))"}, "OK"},
+		{"EXPUNGE", []string{"* 3 EXPUNGE"}, "OK"},
+		{"STATUS INBOX (MESSAGES HIGHESTMODSEQ)", []string{"* STATUS inbox (MESSAGES 2 HIGHESTMODSEQ 10)"}, "OK"},
+	})
+}
+
+func TestQResync(t *testing.T) {
+	s := newCondStoreServer(t)
+
+	// Change a message and expunge another one after modification sequence 3
+	c := s.login()
+	c.runSteps([]step{{"ENABLE QRESYNC", []string{"* ENABLED QRESYNC"}, "OK"}})
+	if untagged, status := c.run("SELECT INBOX"); !strings.HasPrefix(status, "OK [READ-WRITE]") ||
+		strings.Join(modSeqLines(untagged), "\n") != "* OK [HIGHESTMODSEQ 3] Highest" {
+		t.Fatalf("Expected HIGHESTMODSEQ 3, got %q, %s", untagged, status)
+	}
+	c.runSteps([]step{
+		{"UID STORE 1 +FLAGS.SILENT (\\Seen)", []string{"* 1 FETCH (MODSEQ (4) UID 1)"}, "OK"},
+		{"UID STORE 2 +FLAGS.SILENT (\\Deleted)", []string{"* 2 FETCH (MODSEQ (5) UID 2)"}, "OK"},
+		// With QRESYNC expunges are reported by UID
+		{"EXPUNGE", []string{"* VANISHED 2"}, "OK"},
+		{"UID FETCH 1:* (FLAGS) (CHANGEDSINCE 3 VANISHED)", []string{
+			"* VANISHED (EARLIER) 2",
+			"* 1 FETCH (FLAGS (\\Seen) UID 1 MODSEQ (4))",
+		}, "OK"},
+		{"UID FETCH 3 (FLAGS) (CHANGEDSINCE 3 VANISHED)", nil, "OK"},
+	})
+
+	tests := []struct {
+		name   string
+		enable bool
+		params string
+		want   []string
+		status string
+	}{
+		{"changes since the last sync", true, fmt.Sprintf("(QRESYNC (%d 3))", uidValidity), []string{
+			"* OK [HIGHESTMODSEQ 6] Highest",
+			"* VANISHED (EARLIER) 2",
+			"* 1 FETCH (UID 1 FLAGS (\\Seen) MODSEQ (4))",
+		}, "OK"},
+		{"changes since the expunge", true, fmt.Sprintf("(QRESYNC (%d 5))", uidValidity), []string{
+			"* OK [HIGHESTMODSEQ 6] Highest",
+			"* VANISHED (EARLIER) 2",
+		}, "OK"},
+		{"in sync", true, fmt.Sprintf("(QRESYNC (%d 6))", uidValidity), []string{
+			"* OK [HIGHESTMODSEQ 6] Highest",
+		}, "OK"},
+		{"known UIDs", true, fmt.Sprintf("(QRESYNC (%d 3 3))", uidValidity), []string{
+			"* OK [HIGHESTMODSEQ 6] Highest",
+			"* 1 FETCH (UID 1 FLAGS (\\Seen) MODSEQ (4))",
+		}, "OK"},
+		{"other UIDVALIDITY", true, fmt.Sprintf("(QRESYNC (%d 3))", uidValidity+1), []string{
+			"* OK [HIGHESTMODSEQ 6] Highest",
+		}, "OK"},
+		{"not enabled", false, fmt.Sprintf("(QRESYNC (%d 3))", uidValidity), nil, "NO"},
+		{"missing modification sequence", true, fmt.Sprintf("(QRESYNC (%d))", uidValidity), nil, "BAD"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := s.login()
+			if test.enable {
+				c.run("ENABLE QRESYNC")
+			}
+			untagged, status := c.run("SELECT INBOX " + test.params)
+			if got := modSeqLines(untagged); strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("Expected\n%s\ngot\n%s", strings.Join(test.want, "\n"), strings.Join(got, "\n"))
+			}
+			if !strings.HasPrefix(status, test.status) {
+				t.Errorf("Expected status %s, got %q", test.status, status)
+			}
+		})
+	}
+}
+
+func TestRemoveFromSeqSet(t *testing.T) {
+	tests := []struct {
+		set  string
+		id   uint32
+		want string
+	}{
+		{"1:5", 3, "1:2,4:5"},
+		{"1:5", 1, "2:5"},
+		{"1:5", 5, "1:4"},
+		{"1,3,5", 3, "1,5"},
+		{"2:*", 4, "2:3,5:*"},
+		{"1:3", 7, "1:3"},
+	}
+	for _, test := range tests {
+		set, err := imap.ParseSeqSet(test.set)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", test.set, err)
+		}
+		if got := removeFromSeqSet(set, test.id).String(); got != test.want {
+			t.Errorf("Removing %d from %s: expected %s, got %s", test.id, test.set, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package imapserver
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/emersion/go-imap"
+	"github.com/freeflowuniverse/herolauncher/pkg/mail"
+)
+
+// systemFlags are the flags every mailbox supports. Custom keywords are
+// allowed as well, which is announced with \* in the permanent flags.
+var systemFlags = []string{imap.SeenFlag, imap.AnsweredFlag, imap.FlaggedFlag, imap.DeletedFlag, imap.DraftFlag}
+
+// normalizeFlags returns the canonical form of flags without duplicates.
+// \Recent is dropped, it belongs to a session and is never stored.
+func normalizeFlags(flags []string) []string {
+	result := []string{}
+	for _, flag := range flags {
+		flag = imap.CanonicalFlag(flag)
+		if flag == "" || flag == imap.RecentFlag || contains(result, flag) {
+			continue
+		}
+		result = append(result, flag)
+	}
+	return result
+}
+
+// applyFlagsOp returns the flags resulting from a STORE operation
+func applyFlagsOp(current []string, operation imap.FlagsOp, flags []string) []string {
+	switch operation {
+	case imap.SetFlags:
+		return normalizeFlags(flags)
+	case imap.AddFlags:
+		return addFlags(current, normalizeFlags(flags))
+	case imap.RemoveFlags:
+		return removeFlags(current, normalizeFlags(flags))
+	}
+	return current
+}
+
+// storeFlags applies a flag operation to a message and persists the result
+// in the email stored in Redis. The operation is applied to the flags
+// currently in Redis, so changes made by other sessions are not lost.
+// It reports whether the flags changed.
+func (m *Mailbox) storeFlags(msg *Message, operation imap.FlagsOp, flags []string) (bool, error) {
+	if msg.Key == "" {
+		return false, fmt.Errorf("message UID %d has no Redis key", msg.Uid)
+	}
+
+	emailJSON, err := m.backend.redisClient.Get(m.backend.ctx, msg.Key).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to get message %s: %w", msg.Key, err)
+	}
+
+	var email mail.Email
+	if err := json.Unmarshal([]byte(emailJSON), &email); err != nil {
+		return false, fmt.Errorf("failed to unmarshal message %s: %w", msg.Key, err)
+	}
+
+	current := normalizeFlags(email.Flags)
+	updated := applyFlagsOp(current, operation, flags)
+	msg.Flags = updated
+	msg.Email.Flags = updated
+	if equalFlags(current, updated) {
+		return false, nil
+	}
+
+	email.Flags = updated
+	updatedJSON, err := json.Marshal(email)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal message %s: %w", msg.Key, err)
+	}
+	if err := m.backend.redisClient.Set(m.backend.ctx, msg.Key, string(updatedJSON), 0).Err(); err != nil {
+		return false, fmt.Errorf("failed to store message %s: %w", msg.Key, err)
+	}
+	return true, m.touch(msg)
+}
+
+// setsSeen reports whether fetching items implicitly sets \Seen, which is
+// the case for body sections fetched without BODY.PEEK
+func setsSeen(items []imap.FetchItem) bool {
+	for _, item := range items {
+		if section, err := imap.ParseBodySectionName(item); err == nil && !section.Peek {
+			return true
+		}
+	}
+	return false
+}
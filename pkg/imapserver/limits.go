@@ -0,0 +1,196 @@
+package imapserver
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+)
+
+// codeLimit is the response code for a login refused because the user has
+// too many connections (RFC 5530)
+const codeLimit imap.StatusRespCode = "LIMIT"
+
+// Limits restricts the connections of the IMAP server, zero means unlimited
+type Limits struct {
+	// MaxConnsPerIP is the number of connections a client address can open
+	MaxConnsPerIP int
+	// MaxConnsPerUser is the number of connections a user can be logged in on
+	MaxConnsPerUser int
+	// IdleTimeout closes connections on which the client sent nothing for
+	// this long. RFC 3501 asks for at least 30 minutes, which also lets
+	// clients that refresh IDLE every 29 minutes stay connected.
+	IdleTimeout time.Duration
+}
+
+// SetLimits sets the connection limits. It must be called before the server
+// is started.
+func (s *Server) SetLimits(limits Limits) {
+	s.limits = limits
+	s.backend.maxSessions = limits.MaxConnsPerUser
+}
+
+// listen opens a TCP listener on addr of which the connections are limited
+// and counted
+func (s *Server) listen(addr string, plain bool) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &limitListener{Listener: listener, server: s, plain: plain}, nil
+}
+
+// limitListener refuses connections from addresses that have too many open
+// connections. On plain listeners the client is told so with a BYE
+// greeting, on TLS listeners the connection is just closed.
+type limitListener struct {
+	net.Listener
+	server *Server
+	plain  bool
+}
+
+// Accept returns the next connection that is within the limits
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if limited := l.server.track(conn); limited != nil {
+			return limited, nil
+		}
+		if l.plain {
+			io.WriteString(conn, "* BYE Too many connections from your address\r\n")
+		}
+		conn.Close()
+	}
+}
+
+// track registers a new connection, it returns nil if the client address
+// has too many connections already
+func (s *Server) track(conn net.Conn) net.Conn {
+	host := remoteHost(conn)
+
+	s.connMu.Lock()
+	if s.limits.MaxConnsPerIP > 0 && s.connsPerIP[host] >= s.limits.MaxConnsPerIP {
+		s.connMu.Unlock()
+		log.Printf("Refusing IMAP connection from %s: too many connections", host)
+		if s.metrics != nil {
+			s.metrics.rejected.Inc("ip")
+		}
+		return nil
+	}
+	s.connsPerIP[host]++
+	s.connMu.Unlock()
+
+	if s.metrics != nil {
+		s.metrics.connections.Inc()
+	}
+	return &limitedConn{Conn: conn, server: s, host: host, metrics: s.metrics}
+}
+
+// untrack forgets a closed connection
+func (s *Server) untrack(host string) {
+	s.connMu.Lock()
+	s.connsPerIP[host]--
+	if s.connsPerIP[host] <= 0 {
+		delete(s.connsPerIP, host)
+	}
+	s.connMu.Unlock()
+
+	if s.metrics != nil {
+		s.metrics.connections.Dec()
+	}
+}
+
+// remoteHost returns the address of the client without the port
+func remoteHost(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// limitedConn is a client connection that is closed when the client is idle
+// for too long, and of which the traffic is counted
+type limitedConn struct {
+	net.Conn
+	server  *Server
+	host    string
+	metrics *imapMetrics
+	closed  sync.Once
+}
+
+// Read reads from the client, ending the connection if it stays idle
+func (c *limitedConn) Read(p []byte) (int, error) {
+	if timeout := c.server.limits.IdleTimeout; timeout > 0 {
+		c.Conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+	n, err := c.Conn.Read(p)
+	if c.metrics != nil {
+		c.metrics.bytesReceived.Add(int64(n))
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		log.Printf("Closing idle IMAP connection from %s", c.host)
+		if c.metrics != nil {
+			c.metrics.idleTimeouts.Inc()
+		}
+		// The server ends the connection quietly on EOF
+		return n, io.EOF
+	}
+	return n, err
+}
+
+// Write writes to the client
+func (c *limitedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if c.metrics != nil {
+		c.metrics.bytesSent.Add(int64(n))
+	}
+	return n, err
+}
+
+// Close closes the connection, which go-imap may do more than once
+func (c *limitedConn) Close() error {
+	c.closed.Do(func() { c.server.untrack(c.host) })
+	return c.Conn.Close()
+}
+
+// openSession registers a login of a user, it fails with NO [LIMIT] if the
+// user has too many connections already
+func (b *Backend) openSession(username string) error {
+	b.sessionsMu.Lock()
+	defer b.sessionsMu.Unlock()
+
+	if b.maxSessions > 0 && b.sessions[username] >= b.maxSessions {
+		if b.metrics != nil {
+			b.metrics.rejected.Inc("user")
+		}
+		return server.ErrStatusResp(&imap.StatusResp{
+			Type: imap.StatusRespNo,
+			Code: codeLimit,
+			Info: "Too many connections for this user",
+		})
+	}
+	b.sessions[username]++
+	return nil
+}
+
+// closeSession forgets a login of a user
+func (b *Backend) closeSession(username string) {
+	b.sessionsMu.Lock()
+	defer b.sessionsMu.Unlock()
+
+	b.sessions[username]--
+	if b.sessions[username] <= 0 {
+		delete(b.sessions, username)
+	}
+}
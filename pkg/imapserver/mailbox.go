@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	netmail "net/mail"
 	"sort"
 	"strconv"
 	"strings"
@@ -41,26 +42,29 @@ func (m *Mailbox) Info() (*imap.MailboxInfo, error) {
 		info.Attributes = append(info.Attributes, "\\Inbox")
 	}
 
-	// Handle standard mailboxes
-	if strings.EqualFold(m.name, "sent") || strings.EqualFold(m.name, "sent items") {
-		info.Attributes = append(info.Attributes, "\\Sent")
-	} else if strings.EqualFold(m.name, "drafts") {
-		info.Attributes = append(info.Attributes, "\\Drafts")
-	} else if strings.EqualFold(m.name, "trash") {
-		info.Attributes = append(info.Attributes, "\\Trash")
-	} else if strings.EqualFold(m.name, "junk") || strings.EqualFold(m.name, "spam") {
-		info.Attributes = append(info.Attributes, "\\Junk")
+	// Add the special-use attribute, like \Sent for the sent mailbox
+	use, err := m.user.specialUse(m.name)
+	if err != nil {
+		return nil, err
+	}
+	if use != "" {
+		info.Attributes = append(info.Attributes, use)
 	}
 
 	// Handle nested folders
-	if strings.Contains(m.name, "/") {
-		// This is a child folder, mark it as such
-		info.Attributes = append(info.Attributes, "\\HasChildren")
+	hasChildren, err := m.user.hasChildren(m.name)
+	if err != nil {
+		return nil, err
+	}
+	if hasChildren {
+		info.Attributes = append(info.Attributes, imap.HasChildrenAttr)
 
 		// Log for debugging
 		if m.backend.debugMode {
-			log.Printf("DEBUG: Mailbox %s is a nested folder", m.name)
+			log.Printf("DEBUG: Mailbox %s has nested folders", m.name)
 		}
+	} else {
+		info.Attributes = append(info.Attributes, imap.HasNoChildrenAttr)
 	}
 
 	return info, nil
@@ -76,8 +80,8 @@ func (m *Mailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
 	}
 
 	status := imap.NewMailboxStatus(m.name, items)
-	status.Flags = []string{imap.SeenFlag, imap.AnsweredFlag, imap.FlaggedFlag, imap.DeletedFlag, imap.DraftFlag}
-	status.PermanentFlags = []string{imap.SeenFlag, imap.AnsweredFlag, imap.FlaggedFlag, imap.DeletedFlag, imap.DraftFlag}
+	status.Flags = systemFlags
+	status.PermanentFlags = append(append([]string{}, systemFlags...), imap.TryCreateFlag)
 
 	// Filter messages to only include direct messages for this mailbox (not in subfolders)
 	var directMessages []*Message
@@ -113,11 +117,15 @@ func (m *Mailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
 			}
 			status.Unseen = uint32(unseen)
 		case imap.StatusUidNext:
-			// Use current timestamp as next UID for simplicity
-			status.UidNext = uint32(time.Now().Unix())
+			status.UidNext = m.nextUID()
 		case imap.StatusUidValidity:
-			// Use a fixed value for simplicity
-			status.UidValidity = 1
+			status.UidValidity = uidValidity
+		case statusHighestModSeq:
+			highest, err := m.highestModSeq()
+			if err != nil {
+				return nil, err
+			}
+			status.Items[item] = formatModSeq(highest)
 		}
 	}
 
@@ -126,7 +134,16 @@ func (m *Mailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
 
 // SetSubscribed sets the mailbox subscription status
 func (m *Mailbox) SetSubscribed(subscribed bool) error {
-	// We accept all subscription changes but don't actually track them
+	key := subscriptionsKey(m.user.username)
+	var err error
+	if subscribed {
+		err = m.backend.redisClient.HSet(m.backend.ctx, key, m.name, time.Now().Unix()).Err()
+	} else {
+		err = m.backend.redisClient.HDel(m.backend.ctx, key, m.name).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update subscription of %s: %w", m.name, err)
+	}
 	return nil
 }
 
@@ -138,6 +155,13 @@ func (m *Mailbox) Check() error {
 
 // ListMessages returns a list of messages
 func (m *Mailbox) ListMessages(uid bool, seqSet *imap.SeqSet, items []imap.FetchItem, ch chan<- *imap.Message) error {
+	return m.ListMessagesChangedSince(uid, seqSet, items, 0, ch)
+}
+
+// ListMessagesChangedSince returns a list of messages, limited to the
+// messages changed after the modification sequence changedSince if it is
+// not 0, for FETCH (CHANGEDSINCE)
+func (m *Mailbox) ListMessagesChangedSince(uid bool, seqSet *imap.SeqSet, items []imap.FetchItem, changedSince uint64, ch chan<- *imap.Message) error {
 	defer close(ch)
 
 	// Make sure messages are loaded
@@ -145,10 +169,12 @@ func (m *Mailbox) ListMessages(uid bool, seqSet *imap.SeqSet, items []imap.Fetch
 		return err
 	}
 
-	// Sort messages by UID
-	sort.Slice(m.messages, func(i, j int) bool {
-		return m.messages[i].Uid < m.messages[j].Uid
-	})
+	// Fetching a body section without BODY.PEEK marks the message as seen,
+	// the new flags are then returned as well
+	markSeen := setsSeen(items)
+	if markSeen && !containsFetchItem(items, imap.FetchFlags) {
+		items = append(items, imap.FetchFlags)
+	}
 
 	for i, msg := range m.messages {
 		seqNum := uint32(i + 1)
@@ -160,10 +186,16 @@ func (m *Mailbox) ListMessages(uid bool, seqSet *imap.SeqSet, items []imap.Fetch
 		} else {
 			id = seqNum
 		}
-		if !seqSet.Contains(id) {
+		if !seqSet.Contains(id) || msg.ModSeq <= changedSince {
 			continue
 		}
 
+		if markSeen && !contains(msg.Flags, imap.SeenFlag) {
+			if _, err := m.storeFlags(msg, imap.AddFlags, []string{imap.SeenFlag}); err != nil {
+				log.Printf("ERROR: Failed to mark message UID %d as seen: %v", msg.Uid, err)
+			}
+		}
+
 		// Convert to IMAP message
 		imapMsg, err := msg.Fetch(seqNum, items)
 		if err != nil {
@@ -178,30 +210,51 @@ func (m *Mailbox) ListMessages(uid bool, seqSet *imap.SeqSet, items []imap.Fetch
 
 // SearchMessages searches for messages matching the given criteria
 func (m *Mailbox) SearchMessages(uid bool, criteria *imap.SearchCriteria) ([]uint32, error) {
+	ids, _, err := m.SearchMessagesModSeq(uid, criteria, 0)
+	return ids, err
+}
+
+// SearchMessagesModSeq searches for messages matching the given criteria
+// that changed at or after the modification sequence modSeq, for SEARCH
+// MODSEQ. It also returns the highest modification sequence of the
+// matching messages.
+func (m *Mailbox) SearchMessagesModSeq(uid bool, criteria *imap.SearchCriteria, modSeq uint64) ([]uint32, uint64, error) {
 	// Make sure messages are loaded
 	if err := m.loadMessages(); err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	match, err := m.matcher(criteria)
+	if err != nil {
+		return nil, 0, err
 	}
 
 	var ids []uint32
+	var highest uint64
 	for i, msg := range m.messages {
 		seqNum := uint32(i + 1)
 
 		// Check if message matches criteria
-		if msg.Match(seqNum, criteria) {
+		if msg.ModSeq >= modSeq && match(seqNum, msg) {
 			if uid {
 				ids = append(ids, msg.Uid)
 			} else {
 				ids = append(ids, seqNum)
 			}
+			highest = max(highest, msg.ModSeq)
 		}
 	}
 
-	return ids, nil
+	return ids, highest, nil
 }
 
 // CreateMessage adds a new message to the mailbox
 func (m *Mailbox) CreateMessage(flags []string, date time.Time, body imap.Literal) error {
+	_, err := m.CreateMessageUID(flags, date, body)
+	return err
+}
+
+// CreateMessageUID adds a new message to the mailbox and returns its UID
+func (m *Mailbox) CreateMessageUID(flags []string, date time.Time, body imap.Literal) (uint32, error) {
 	// Ensure mailbox name is lowercase
 	lowerName := strings.ToLower(m.name)
 	log.Printf("Creating message in mailbox %s (lowercase: %s) for user %s", m.name, lowerName, m.user.username)
@@ -209,7 +262,7 @@ func (m *Mailbox) CreateMessage(flags []string, date time.Time, body imap.Litera
 	// Read the message body
 	var buf bytes.Buffer
 	if _, err := buf.ReadFrom(body); err != nil {
-		return fmt.Errorf("failed to read message body: %w", err)
+		return 0, fmt.Errorf("failed to read message body: %w", err)
 	}
 
 	// Parse the message content
@@ -217,10 +270,17 @@ func (m *Mailbox) CreateMessage(flags []string, date time.Time, body imap.Litera
 	content := buf.String()
 	headers, messageBody := parseEmailContent(content)
 
+	// Without a date in APPEND the message arrives now
+	if date.IsZero() {
+		date = time.Now()
+	}
+
 	// Create a new Email object
 	email := &mail.Email{
-		Message:     messageBody,
-		Attachments: []mail.Attachment{}, // No attachments for now
+		Message:      messageBody,
+		Attachments:  []mail.Attachment{}, // No attachments for now
+		Flags:        normalizeFlags(flags),
+		InternalDate: date.Unix(),
 	}
 
 	// Set envelope fields
@@ -228,42 +288,50 @@ func (m *Mailbox) CreateMessage(flags []string, date time.Time, body imap.Litera
 	email.SetTo(strings.Split(headers["To"], ","))
 	email.SetSubject(headers["Subject"])
 
-	// Generate a UID for the email
-	uid, err := email.UID()
-	if err != nil {
-		return fmt.Errorf("failed to generate email UID: %w", err)
+	// Keep what SORT and THREAD use
+	email.Envelope.MessageId = headerValue(headers, "Message-Id")
+	email.Envelope.InReplyTo = headerValue(headers, "In-Reply-To")
+	if sent, err := netmail.ParseDate(headerValue(headers, "Date")); err == nil {
+		email.SetDate(sent.Unix())
 	}
 
-	// Serialize the email to JSON
-	emailJSON, err := json.Marshal(email)
-	if err != nil {
-		return fmt.Errorf("failed to marshal email: %w", err)
+	if err := m.user.checkQuota([]*mail.Email{email}); err != nil {
+		return 0, err
 	}
 
-	// Store the email in Redis
-	// Key format: mail:in:<username>:<mailbox>/<uid>
-	// Always use lowercase mailbox name for consistency
-	key := fmt.Sprintf("mail:in:%s:%s/%s", m.user.username, lowerName, uid)
-	err = m.backend.redisClient.Set(m.backend.ctx, key, string(emailJSON), 0).Err()
-	if err != nil {
-		return fmt.Errorf("failed to store email in Redis: %w", err)
+	// Make sure the new UID is above the UIDs of the existing messages
+	if err := m.loadMessages(); err != nil {
+		return 0, err
 	}
 
-	// If successful, reload the messages to include the new one
-	return m.loadMessages()
+	return m.storeNewMessage(email)
 }
 
 // UpdateMessagesFlags updates flags for the specified messages
 func (m *Mailbox) UpdateMessagesFlags(uid bool, seqSet *imap.SeqSet, operation imap.FlagsOp, flags []string) error {
+	_, err := m.updateMessagesFlags(uid, seqSet, operation, flags, nil)
+	return err
+}
+
+// UpdateMessagesFlagsUnchangedSince updates flags for the specified
+// messages that did not change after the modification sequence
+// unchangedSince, for STORE (UNCHANGEDSINCE). It returns the IDs of the
+// messages that changed and were not updated.
+func (m *Mailbox) UpdateMessagesFlagsUnchangedSince(uid bool, seqSet *imap.SeqSet, operation imap.FlagsOp, flags []string, unchangedSince uint64) ([]uint32, error) {
+	return m.updateMessagesFlags(uid, seqSet, operation, flags, &unchangedSince)
+}
+
+// updateMessagesFlags updates flags, only of the messages that did not
+// change after unchangedSince if it is not nil
+func (m *Mailbox) updateMessagesFlags(uid bool, seqSet *imap.SeqSet, operation imap.FlagsOp, flags []string, unchangedSince *uint64) ([]uint32, error) {
 	log.Printf("Updating flags for messages in mailbox %s, operation: %v, flags: %v", m.name, operation, flags)
 
 	// Make sure messages are loaded
 	if err := m.loadMessages(); err != nil {
-		return err
+		return nil, err
 	}
 
-	// Keep track of modified messages
-	var modifiedMessages []*Message
+	var modified []uint32
 
 	for i, msg := range m.messages {
 		seqNum := uint32(i + 1)
@@ -278,80 +346,23 @@ func (m *Mailbox) UpdateMessagesFlags(uid bool, seqSet *imap.SeqSet, operation i
 		if !seqSet.Contains(id) {
 			continue
 		}
-
-		// Store original flags to check if they changed
-		originalFlags := make([]string, len(msg.Flags))
-		copy(originalFlags, msg.Flags)
-
-		// Update flags
-		switch operation {
-		case imap.SetFlags:
-			msg.Flags = flags
-		case imap.AddFlags:
-			msg.Flags = addFlags(msg.Flags, flags)
-		case imap.RemoveFlags:
-			msg.Flags = removeFlags(msg.Flags, flags)
+		if unchangedSince != nil && msg.ModSeq > *unchangedSince {
+			modified = append(modified, id)
+			continue
 		}
 
-		// Check if flags have changed
-		if !equalFlags(originalFlags, msg.Flags) {
-			modifiedMessages = append(modifiedMessages, msg)
-			if m.backend.debugMode {
-				log.Printf("DEBUG: Message UID %d flags changed from %v to %v", msg.Uid, originalFlags, msg.Flags)
-			}
+		// Apply the change to the flags stored in Redis, so it survives
+		// reconnects and is seen by other sessions
+		changed, err := m.storeFlags(msg, operation, flags)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update flags: %w", err)
 		}
-	}
-
-	// Persist flag changes to Redis
-	if len(modifiedMessages) > 0 {
-		log.Printf("Persisting flag changes for %d messages to Redis", len(modifiedMessages))
-
-		for _, msg := range modifiedMessages {
-			// We need to update the message in Redis
-			if msg.Key == "" {
-				log.Printf("WARNING: Cannot update message in Redis, key is empty for UID %d", msg.Uid)
-				continue
-			}
-
-			// Get the current message data from Redis
-			emailJSON, err := m.backend.redisClient.Get(m.backend.ctx, msg.Key).Result()
-			if err != nil {
-				log.Printf("ERROR: Failed to get message data from Redis for key %s: %v", msg.Key, err)
-				continue
-			}
-
-			// Parse the email JSON
-			var email mail.Email
-			err = json.Unmarshal([]byte(emailJSON), &email)
-			if err != nil {
-				log.Printf("ERROR: Failed to unmarshal email JSON for key %s: %v", msg.Key, err)
-				continue
-			}
-
-			// Update the flags in the email object
-			email.Flags = msg.Flags
-
-			// Marshal the updated email back to JSON
-			updatedJSON, err := json.Marshal(email)
-			if err != nil {
-				log.Printf("ERROR: Failed to marshal updated email to JSON for key %s: %v", msg.Key, err)
-				continue
-			}
-
-			// Save the updated email back to Redis
-			_, err = m.backend.redisClient.Set(m.backend.ctx, msg.Key, string(updatedJSON), 0).Result()
-			if err != nil {
-				log.Printf("ERROR: Failed to save updated email to Redis for key %s: %v", msg.Key, err)
-				continue
-			}
-
-			if m.backend.debugMode {
-				log.Printf("DEBUG: Successfully updated flags in Redis for message UID %d, key %s", msg.Uid, msg.Key)
-			}
+		if changed && m.backend.debugMode {
+			log.Printf("DEBUG: Message UID %d flags changed to %v, key %s", msg.Uid, msg.Flags, msg.Key)
 		}
 	}
 
-	return nil
+	return modified, nil
 }
 
 // equalFlags checks if two flag slices contain the same flags (order doesn't matter)
@@ -384,24 +395,36 @@ func equalFlags(a, b []string) bool {
 
 // CopyMessages copies the specified messages to another mailbox
 func (m *Mailbox) CopyMessages(uid bool, seqSet *imap.SeqSet, destName string) error {
+	_, _, err := m.CopyMessagesUID(uid, seqSet, destName)
+	return err
+}
+
+// CopyMessagesUID copies the specified messages to another mailbox and
+// returns the UIDs of the copied messages and of their copies, in the same
+// order. The messages are copied as stored, with their flags.
+func (m *Mailbox) CopyMessagesUID(uid bool, seqSet *imap.SeqSet, destName string) ([]uint32, []uint32, error) {
+	return m.copyMessages(uid, seqSet, destName, true)
+}
+
+// copyMessages copies messages to another mailbox, checking first that the
+// copies fit the user's quota if checkQuota is set
+func (m *Mailbox) copyMessages(uid bool, seqSet *imap.SeqSet, destName string, checkQuota bool) ([]uint32, []uint32, error) {
 	log.Printf("Copying messages to mailbox %s", destName)
 
 	// Make sure messages are loaded
 	if err := m.loadMessages(); err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	// Find the destination mailbox
-	destMailbox, err := m.user.GetMailbox(destName)
+	mailbox, err := m.user.GetMailbox(destName)
 	if err != nil {
-		return fmt.Errorf("destination mailbox not found: %w", err)
+		return nil, nil, err
 	}
+	destMailbox := mailbox.(*Mailbox)
 
-	// Sort messages by UID
-	sort.Slice(m.messages, func(i, j int) bool {
-		return m.messages[i].Uid < m.messages[j].Uid
-	})
-
+	var messages []*Message
+	var emails []*mail.Email
 	for i, msg := range m.messages {
 		seqNum := uint32(i + 1)
 
@@ -416,23 +439,46 @@ func (m *Mailbox) CopyMessages(uid bool, seqSet *imap.SeqSet, destName string) e
 			continue
 		}
 
-		// Create a new message in the destination mailbox
-		// We need to convert the Email back to a literal for CreateMessage
-		emailContent := formatEmailContent(msg.Email)
-		literal := bytes.NewBufferString(emailContent)
+		email := *msg.Email
+		email.Flags = msg.Flags
+		messages = append(messages, msg)
+		emails = append(emails, &email)
+	}
+
+	if checkQuota && len(emails) > 0 {
+		if err := m.user.checkQuota(emails); err != nil {
+			return nil, nil, err
+		}
+	}
 
+	var srcUIDs, destUIDs []uint32
+	for i, msg := range messages {
 		// Copy the message to the destination mailbox
-		err := destMailbox.CreateMessage(msg.Flags, time.Now(), literal)
+		destUID, err := destMailbox.storeNewMessage(emails[i])
 		if err != nil {
-			return fmt.Errorf("failed to copy message: %w", err)
+			return nil, nil, fmt.Errorf("failed to copy message: %w", err)
 		}
+
+		srcUIDs = append(srcUIDs, msg.Uid)
+		destUIDs = append(destUIDs, destUID)
 	}
 
-	return nil
+	return srcUIDs, destUIDs, nil
 }
 
 // Expunge permanently removes messages marked for deletion
 func (m *Mailbox) Expunge() error {
+	return m.expunge(nil)
+}
+
+// ExpungeUIDs permanently removes the messages marked for deletion whose
+// UID is in uids, for UID EXPUNGE
+func (m *Mailbox) ExpungeUIDs(uids *imap.SeqSet) error {
+	return m.expunge(uids)
+}
+
+// expunge removes the messages marked for deletion, limited to uids if it is not nil
+func (m *Mailbox) expunge(uids *imap.SeqSet) error {
 	log.Printf("Expunging deleted messages from mailbox %s", m.name)
 
 	// Make sure messages are loaded
@@ -443,7 +489,7 @@ func (m *Mailbox) Expunge() error {
 	// Find messages marked for deletion
 	var messagesToDelete []*Message
 	for _, msg := range m.messages {
-		if contains(msg.Flags, imap.DeletedFlag) {
+		if contains(msg.Flags, imap.DeletedFlag) && (uids == nil || uids.Contains(msg.Uid)) {
 			messagesToDelete = append(messagesToDelete, msg)
 		}
 	}
@@ -501,6 +547,7 @@ func (m *Mailbox) Expunge() error {
 				}
 			}
 		}
+		m.forget(msg, true)
 
 		if !deleted && m.backend.debugMode {
 			log.Printf("WARNING: Could not find any Redis keys for message with UID %d", msg.Uid)
@@ -515,8 +562,9 @@ func (m *Mailbox) Expunge() error {
 func (m *Mailbox) MoveMessages(uid bool, seqSet *imap.SeqSet, destName string) error {
 	log.Printf("Moving messages from %s to %s", m.name, destName)
 
-	// First, copy the messages to the destination mailbox
-	err := m.CopyMessages(uid, seqSet, destName)
+	// First, copy the messages to the destination mailbox. The quota is not
+	// checked, the messages only change mailbox.
+	_, _, err := m.copyMessages(uid, seqSet, destName, false)
 	if err != nil {
 		return fmt.Errorf("failed to copy messages during move operation: %w", err)
 	}
@@ -602,6 +650,7 @@ func (m *Mailbox) MoveMessages(uid bool, seqSet *imap.SeqSet, destName string) e
 				}
 			}
 		}
+		m.forget(msg, true)
 
 		if !deleted && m.backend.debugMode {
 			log.Printf("WARNING: Could not find any Redis keys for message with UID %d during move", msg.Uid)
@@ -769,7 +818,7 @@ func (m *Mailbox) loadMessages() error {
 
 		if m.backend.debugMode {
 			log.Printf("DEBUG: Successfully parsed email: From=%s, To=%v, Subject=%s",
-				email.From, email.To, email.Subject)
+				email.From(), email.To(), email.Subject())
 		}
 
 		// Extract UID from the key
@@ -841,20 +890,27 @@ func (m *Mailbox) loadMessages() error {
 		msg := &Message{
 			Email: &email,
 			Uid:   parsedUID,
-			Flags: []string{}, // No flags by default
-			Key:   key,        // Store the Redis key
+			Flags: normalizeFlags(email.Flags), // Flags persisted with the email
+			Key:   key,                         // Store the Redis key
+
+			attachments: m.backend.attachments,
 		}
 
 		m.messages = append(m.messages, msg)
 
 		if m.backend.debugMode {
-			log.Printf("DEBUG: Added message with UID: %d, Subject: %s", msg.Uid, email.Subject)
+			log.Printf("DEBUG: Added message with UID: %d, Subject: %s", msg.Uid, email.Subject())
 		} else {
 			log.Printf("Added message with UID: %d", msg.Uid)
 		}
 	}
 
-	return nil
+	// Sort messages by UID, so sequence numbers are the same for every command
+	sort.Slice(m.messages, func(i, j int) bool {
+		return m.messages[i].Uid < m.messages[j].Uid
+	})
+
+	return m.loadModSeqs()
 }
 
 // parseUID converts a string UID to uint32
@@ -899,6 +955,16 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
+// containsFetchItem checks if a fetch item is requested
+func containsFetchItem(items []imap.FetchItem, item imap.FetchItem) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
 // Helper function to add flags to a slice
 func addFlags(slice []string, flags []string) []string {
 	result := make([]string, len(slice))
@@ -924,30 +990,6 @@ func removeFlags(slice []string, flags []string) []string {
 	return result
 }
 
-// formatEmailContent converts an Email struct back to a string representation
-func formatEmailContent(email *mail.Email) string {
-	// Create a simple email format
-	var buf strings.Builder
-
-	// Add headers
-	buf.WriteString(fmt.Sprintf("From: %s\r\n", email.From()))
-	buf.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(email.To(), ", ")))
-	buf.WriteString(fmt.Sprintf("Subject: %s\r\n", email.Subject()))
-	buf.WriteString(fmt.Sprintf("Date: %s\r\n", time.Now().Format(time.RFC1123Z)))
-	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
-
-	// Add blank line to separate headers from body
-	buf.WriteString("\r\n")
-
-	// Add message body
-	buf.WriteString(email.Message)
-
-	// Add attachments as base64 encoded content if needed
-	// (not implemented in this simple version)
-
-	return buf.String()
-}
-
 // parseEmailContent extracts headers and body from an email message
 func parseEmailContent(content string) (map[string]string, string) {
 	headers := make(map[string]string)
@@ -1019,3 +1061,14 @@ func parseEmailContent(content string) (map[string]string, string) {
 
 	return headers, bodyPart
 }
+
+// headerValue returns a header parsed by parseEmailContent, ignoring the
+// case of its name
+func headerValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
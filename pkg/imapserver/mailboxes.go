@@ -0,0 +1,242 @@
+package imapserver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Mailboxes exist implicitly through the keys of their messages,
+// mail:in:<username>:<mailbox>:<uid>, where nested mailboxes are written
+// folder/subfolder like redis_mail_feeder does. Mailboxes created without
+// messages are recorded in the mail:mailboxes:<username> hash and
+// subscriptions in the mail:subscribed:<username> hash.
+
+// mailboxDelimiter separates the levels of nested mailboxes
+const mailboxDelimiter = "/"
+
+// inboxName is the mailbox every user has, it cannot be deleted
+const inboxName = "inbox"
+
+// standardMailboxes are created for users that have no mailboxes yet
+var standardMailboxes = []string{"inbox", "sent", "drafts", "trash", "junk", "archive"}
+
+// mailboxesKey returns the hash of the mailboxes a user created
+func mailboxesKey(username string) string {
+	return fmt.Sprintf("mail:mailboxes:%s", username)
+}
+
+// subscriptionsKey returns the hash of the mailboxes a user subscribed to
+func subscriptionsKey(username string) string {
+	return fmt.Sprintf("mail:subscribed:%s", username)
+}
+
+// normalizeMailboxName returns the form a mailbox name is stored under:
+// lower case, without leading or trailing delimiters
+func normalizeMailboxName(name string) string {
+	return strings.Trim(strings.ToLower(name), mailboxDelimiter)
+}
+
+// validateMailboxName checks a normalized mailbox name can be used in Redis keys
+func validateMailboxName(name string) error {
+	if name == "" {
+		return fmt.Errorf("mailbox name must not be empty")
+	}
+	if strings.ContainsAny(name, ":*?[]%\\") {
+		return fmt.Errorf("mailbox name %q must not contain any of : * ? [ ] %% \\", name)
+	}
+	for _, level := range strings.Split(name, mailboxDelimiter) {
+		if strings.TrimSpace(level) == "" {
+			return fmt.Errorf("mailbox name %q must not contain empty levels", name)
+		}
+	}
+	return nil
+}
+
+// mailboxParents returns the parents of a nested mailbox, e.g. inbox and
+// inbox/work for inbox/work/projects
+func mailboxParents(name string) []string {
+	levels := strings.Split(name, mailboxDelimiter)
+	parents := make([]string, 0, len(levels)-1)
+	for i := 1; i < len(levels); i++ {
+		parents = append(parents, strings.Join(levels[:i], mailboxDelimiter))
+	}
+	return parents
+}
+
+// isInMailbox checks if name is mailbox itself or nested in it
+func isInMailbox(name, mailbox string) bool {
+	return name == mailbox || strings.HasPrefix(name, mailbox+mailboxDelimiter)
+}
+
+// messageKeys returns the keys of the messages in a mailbox and, if nested
+// is set, of the messages in the mailboxes below it
+func (u *User) messageKeys(name string, nested bool) ([]string, error) {
+	patterns := []string{fmt.Sprintf("mail:in:%s:%s:*", u.username, name)}
+	if nested {
+		patterns = append(patterns, fmt.Sprintf("mail:in:%s:%s/*", u.username, name))
+	}
+
+	var keys []string
+	for _, pattern := range patterns {
+		found, err := u.backend.redisClient.Keys(u.backend.ctx, pattern).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get keys with pattern %s: %w", pattern, err)
+		}
+		keys = append(keys, found...)
+	}
+	return keys, nil
+}
+
+// createdMailboxes returns the mailboxes recorded in the mailboxes hash
+func (u *User) createdMailboxes() ([]string, error) {
+	names, err := u.backend.redisClient.HKeys(u.backend.ctx, mailboxesKey(u.username)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list created mailboxes: %w", err)
+	}
+	return names, nil
+}
+
+// subscribedMailboxes returns the mailboxes the user subscribed to, sorted
+func (u *User) subscribedMailboxes() ([]string, error) {
+	names, err := u.backend.redisClient.HKeys(u.backend.ctx, subscriptionsKey(u.username)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subscriptions: %w", err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// recordMailbox records a mailbox and its parents in the mailboxes hash, so
+// they exist without messages
+func (u *User) recordMailbox(name string) error {
+	created := time.Now().Unix()
+	for _, mailbox := range append(mailboxParents(name), name) {
+		if err := u.backend.redisClient.HSet(u.backend.ctx, mailboxesKey(u.username), mailbox, created).Err(); err != nil {
+			return fmt.Errorf("failed to record mailbox %s: %w", mailbox, err)
+		}
+	}
+	return nil
+}
+
+// mailboxExists checks if a mailbox was created or contains messages,
+// directly or in a nested mailbox
+func (u *User) mailboxExists(name string) (bool, error) {
+	if name == inboxName {
+		return true, nil
+	}
+
+	created, err := u.createdMailboxes()
+	if err != nil {
+		return false, err
+	}
+	for _, mailbox := range created {
+		if isInMailbox(mailbox, name) {
+			return true, nil
+		}
+	}
+
+	keys, err := u.messageKeys(name, true)
+	if err != nil {
+		return false, err
+	}
+	return len(keys) > 0, nil
+}
+
+// hasChildren checks if there are mailboxes nested in a mailbox
+func (u *User) hasChildren(name string) (bool, error) {
+	created, err := u.createdMailboxes()
+	if err != nil {
+		return false, err
+	}
+	for _, mailbox := range created {
+		if strings.HasPrefix(mailbox, name+mailboxDelimiter) {
+			return true, nil
+		}
+	}
+
+	pattern := fmt.Sprintf("mail:in:%s:%s/*", u.username, name)
+	keys, err := u.backend.redisClient.Keys(u.backend.ctx, pattern).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to get keys with pattern %s: %w", pattern, err)
+	}
+	return len(keys) > 0, nil
+}
+
+// renameKeys moves the messages with the given keys from mailbox oldName
+// to newName, keeping the part of the key after the mailbox name
+func (u *User) renameKeys(keys []string, oldName, newName string) error {
+	oldPrefix := fmt.Sprintf("mail:in:%s:%s", u.username, oldName)
+	newPrefix := fmt.Sprintf("mail:in:%s:%s", u.username, newName)
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, oldPrefix) {
+			continue
+		}
+		newKey := newPrefix + strings.TrimPrefix(key, oldPrefix)
+
+		value, err := u.backend.redisClient.Get(u.backend.ctx, key).Result()
+		if err == redis.Nil {
+			// Removed in the meantime
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get message %s: %w", key, err)
+		}
+		if err := u.backend.redisClient.Set(u.backend.ctx, newKey, value, 0).Err(); err != nil {
+			return fmt.Errorf("failed to store message %s: %w", newKey, err)
+		}
+		if err := u.backend.redisClient.Del(u.backend.ctx, key).Err(); err != nil {
+			return fmt.Errorf("failed to delete message %s: %w", key, err)
+		}
+		if err := u.backend.index.Move(u.username, key, newKey); err != nil {
+			return err
+		}
+
+		// Keep the modification sequence with the message
+		modSeq, err := u.backend.redisClient.HGet(u.backend.ctx, modSeqsKey(u.username), key).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get modification sequence of %s: %w", key, err)
+		}
+		if err := u.backend.redisClient.HSet(u.backend.ctx, modSeqsKey(u.username), newKey, modSeq).Err(); err != nil {
+			return fmt.Errorf("failed to store modification sequence of %s: %w", newKey, err)
+		}
+		if err := u.backend.redisClient.HDel(u.backend.ctx, modSeqsKey(u.username), key).Err(); err != nil {
+			return fmt.Errorf("failed to delete modification sequence of %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// renameHashFields renames the fields of a hash for oldName and the
+// mailboxes nested in it
+func (u *User) renameHashFields(hash, oldName, newName string) error {
+	names, err := u.backend.redisClient.HKeys(u.backend.ctx, hash).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", hash, err)
+	}
+
+	for _, name := range names {
+		if !isInMailbox(name, oldName) {
+			continue
+		}
+		value, err := u.backend.redisClient.HGet(u.backend.ctx, hash, name).Result()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", hash, err)
+		}
+		renamed := newName + strings.TrimPrefix(name, oldName)
+		if err := u.backend.redisClient.HSet(u.backend.ctx, hash, renamed, value).Err(); err != nil {
+			return fmt.Errorf("failed to update %s: %w", hash, err)
+		}
+		if err := u.backend.redisClient.HDel(u.backend.ctx, hash, name).Err(); err != nil {
+			return fmt.Errorf("failed to update %s: %w", hash, err)
+		}
+	}
+	return nil
+}
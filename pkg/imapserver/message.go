@@ -1,13 +1,13 @@
 package imapserver
 
 import (
-	"bytes"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/freeflowuniverse/herolauncher/pkg/mail"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailblob"
 )
 
 // Message represents an email message
@@ -16,6 +16,14 @@ type Message struct {
 	Uid   uint32
 	Flags []string
 	Key   string // Redis key where this message is stored
+
+	// ModSeq is the modification sequence of the last change (RFC 7162)
+	ModSeq uint64
+
+	// raw is the message rendered as MIME message, see rfc822
+	raw []byte
+	// attachments holds the attachments stored outside of Redis
+	attachments *mailblob.Store
 }
 
 // Fetch converts a Message to an imap.Message
@@ -29,9 +37,15 @@ func (m *Message) Fetch(seqNum uint32, items []imap.FetchItem) (*imap.Message, e
 		case imap.FetchEnvelope:
 			msg.Envelope = m.createEnvelope()
 		case imap.FetchBody, imap.FetchBodyStructure:
-			msg.BodyStructure = m.createBodyStructure(item == imap.FetchBodyStructure)
+			bodyStructure, err := m.bodyStructure(item == imap.FetchBodyStructure)
+			if err != nil {
+				return nil, err
+			}
+			msg.BodyStructure = bodyStructure
 		case imap.FetchFlags:
 			// Flags already set above
+		case fetchModSeq:
+			msg.Items[item] = []interface{}{formatModSeq(m.ModSeq)}
 		case imap.FetchInternalDate:
 			// Use InternalDate from the Email struct if available, otherwise use current time
 			if m.Email.InternalDate > 0 {
@@ -40,17 +54,20 @@ func (m *Message) Fetch(seqNum uint32, items []imap.FetchItem) (*imap.Message, e
 				msg.InternalDate = time.Now()
 			}
 		case imap.FetchRFC822Size:
-			// Use Size from the Email struct if available, otherwise calculate it
-			if m.Email.Size > 0 {
-				msg.Size = m.Email.Size
-			} else {
-				// Calculate size using the CalculateSize method
-				msg.Size = m.Email.CalculateSize()
+			// The size of the message as fetched with BODY[]
+			raw, err := m.rfc822()
+			if err != nil {
+				return nil, err
 			}
+			msg.Size = uint32(len(raw))
 		default:
 			// Handle section fetch (BODY[...], BODY.PEEK[...])
 			if section, err := imap.ParseBodySectionName(item); err == nil {
-				msg.Body[section] = m.getBodySection(section)
+				literal, err := m.bodySection(section)
+				if err != nil {
+					return nil, err
+				}
+				msg.Body[section] = literal
 			}
 		}
 	}
@@ -119,9 +136,9 @@ func (m *Message) Match(seqNum uint32, criteria *imap.SearchCriteria) bool {
 	// Check text criteria (header + body)
 	if len(criteria.Text) > 0 {
 		allText := strings.ToLower(fmt.Sprintf("%s %s %s %s",
-			m.Email.From,
-			strings.Join(m.Email.To, " "),
-			m.Email.Subject,
+			m.Email.From(),
+			strings.Join(m.Email.To(), " "),
+			m.Email.Subject(),
 			m.Email.Message,
 		))
 		
@@ -219,123 +236,6 @@ func (m *Message) createEnvelope() *imap.Envelope {
 	return env
 }
 
-// createBodyStructure creates an IMAP body structure
-func (m *Message) createBodyStructure(extended bool) *imap.BodyStructure {
-	// Generate a body structure using the GetBodyStructure method
-	// and parse it into an imap.BodyStructure
-	// For now, we'll continue with the existing implementation
-	
-	// Create a simple text body structure
-	bs := &imap.BodyStructure{
-		MIMEType:    "text",
-		MIMESubType: "plain",
-		Params:      map[string]string{"charset": "utf-8"},
-		Size:        uint32(len(m.Email.Message)),
-		Extended:    extended,
-	}
-
-	// If there are attachments, create a multipart structure
-	if len(m.Email.Attachments) > 0 {
-		bs = &imap.BodyStructure{
-			MIMEType:    "multipart",
-			MIMESubType: "mixed",
-			Params:      map[string]string{},
-			Parts: []*imap.BodyStructure{
-				// Text part
-				{
-					MIMEType:    "text",
-					MIMESubType: "plain",
-					Params:      map[string]string{"charset": "utf-8"},
-					Size:        uint32(len(m.Email.Message)),
-					Extended:    extended,
-				},
-			},
-			Extended: extended,
-		}
-
-		// Add attachment parts
-		for _, att := range m.Email.Attachments {
-			attPart := &imap.BodyStructure{
-				MIMEType:    strings.Split(att.ContentType, "/")[0],
-				MIMESubType: strings.Split(att.ContentType, "/")[1],
-				Params: map[string]string{
-					"name": att.Filename,
-				},
-				Disposition:      "attachment",
-				DispositionParams: map[string]string{"filename": att.Filename},
-				Size:             uint32(len(att.Data)),
-				Extended:         extended,
-			}
-			bs.Parts = append(bs.Parts, attPart)
-		}
-	}
-
-	return bs
-}
-
-// getBodySection returns a specific section of the message body
-func (m *Message) getBodySection(section *imap.BodySectionName) imap.Literal {
-	// Create a buffer to store the message
-	var buf bytes.Buffer
-
-	// If no section specified or section is HEADER, return the header
-	if section.Specifier == imap.HeaderSpecifier || section.Specifier == imap.EntireSpecifier {
-		header := createHeader(m.Email)
-		if section.Specifier == imap.HeaderSpecifier {
-			buf.Write(header)
-			return bytes.NewReader(buf.Bytes())
-		}
-		
-		// For entire message, write header followed by body
-		buf.Write(header)
-		buf.WriteString("\r\n")
-	}
-
-	// If section is TEXT or ENTIRE, include the message body
-	if section.Specifier == imap.TextSpecifier || section.Specifier == imap.EntireSpecifier {
-		buf.WriteString(m.Email.Message)
-		
-		// Add attachments if any
-		if len(m.Email.Attachments) > 0 {
-			for _, att := range m.Email.Attachments {
-				buf.WriteString(fmt.Sprintf("\r\n\r\n--Attachment: %s--\r\n", att.Filename))
-			}
-		}
-	}
-
-	return bytes.NewReader(buf.Bytes())
-}
-
-// createHeader creates an RFC822 header from the email
-func createHeader(email *mail.Email) []byte {
-	var buf bytes.Buffer
-	
-	// Add From header
-	buf.WriteString(fmt.Sprintf("From: %s\r\n", email.From()))
-	
-	// Add To header
-	buf.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(email.To(), ", ")))
-	
-	// Add Subject header
-	buf.WriteString(fmt.Sprintf("Subject: %s\r\n", email.Subject()))
-	
-	// Add Date header
-	buf.WriteString(fmt.Sprintf("Date: %s\r\n", time.Now().Format(time.RFC1123Z)))
-	
-	// Add Message-ID header
-	buf.WriteString(fmt.Sprintf("Message-ID: <%d@example.com>\r\n", time.Now().UnixNano()))
-	
-	// Add MIME headers
-	if len(email.Attachments) > 0 {
-		buf.WriteString("MIME-Version: 1.0\r\n")
-		buf.WriteString("Content-Type: multipart/mixed; boundary=attachment-boundary\r\n")
-	} else {
-		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
-	}
-	
-	return buf.Bytes()
-}
-
 // parseAddress parses an email address into an IMAP address
 func parseAddress(addr string) *imap.Address {
 	// Simple parsing for "name <email>" format
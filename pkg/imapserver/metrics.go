@@ -0,0 +1,152 @@
+package imapserver
+
+import (
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+	"github.com/freeflowuniverse/herolauncher/pkg/system/stats/metrics"
+)
+
+// imapMetrics counts the commands, traffic, errors and connections of the
+// IMAP server
+type imapMetrics struct {
+	commands      *metrics.Metric
+	errors        *metrics.Metric
+	bytesReceived *metrics.Metric
+	bytesSent     *metrics.Metric
+	connections   *metrics.Metric
+	rejected      *metrics.Metric
+	idleTimeouts  *metrics.Metric
+}
+
+// newIMAPMetrics registers the metrics of the IMAP server in registry
+func newIMAPMetrics(registry *metrics.Registry) *imapMetrics {
+	return &imapMetrics{
+		commands:      registry.Counter("imapserver_commands_total", "Commands handled by name.", "command"),
+		errors:        registry.Counter("imapserver_errors_total", "Commands answered with NO or BAD.", "command", "status"),
+		bytesReceived: registry.Counter("imapserver_received_bytes_total", "Bytes read from clients."),
+		bytesSent:     registry.Counter("imapserver_sent_bytes_total", "Bytes written to clients."),
+		connections:   registry.Gauge("imapserver_active_connections", "Open client connections."),
+		rejected:      registry.Counter("imapserver_rejected_connections_total", "Connections and logins refused by a limit.", "limit"),
+		idleTimeouts:  registry.Counter("imapserver_idle_timeouts_total", "Connections closed because the client was idle."),
+	}
+}
+
+// EnableMetrics counts commands, traffic, errors and connections in
+// registry, with names starting with "imapserver_". It must be called
+// before the server is started.
+func (s *Server) EnableMetrics(registry *metrics.Registry) {
+	s.metrics = newIMAPMetrics(registry)
+	s.backend.metrics = s.metrics
+}
+
+// count counts a handled command and its error, if any
+func (m *imapMetrics) count(name string, err error) {
+	m.commands.Inc(name)
+	if err == nil {
+		return
+	}
+	status := string(imap.StatusRespNo)
+	if statusErr, ok := err.(*imap.ErrStatusResp); ok {
+		if statusErr.Resp.Type == imap.StatusRespOk {
+			return
+		}
+		status = string(statusErr.Resp.Type)
+	}
+	m.errors.Inc(name, status)
+}
+
+// commandCounter is an extension in front of all others that counts the
+// commands. go-imap cannot pass a command on to the next handler, so it
+// resolves the handler with a server of its own that has the same
+// extensions enabled.
+type commandCounter struct {
+	backend *Backend
+	next    *server.Server
+}
+
+// newCommandCounter creates the counter for a server with the given
+// extensions
+func newCommandCounter(backend *Backend, extensions []server.Extension) *commandCounter {
+	next := server.New(backend)
+	next.Enable(extensions...)
+	return &commandCounter{backend: backend, next: next}
+}
+
+// Capabilities adds no capabilities
+func (c *commandCounter) Capabilities(server.Conn) []string {
+	return nil
+}
+
+// Command wraps the handler of a command when metrics are enabled. They
+// are not while extensions are enabled, go-imap skips extensions that
+// handle built-in commands like IDLE. UID is not wrapped, its subcommand
+// is counted as e.g. "UID FETCH".
+func (c *commandCounter) Command(name string) server.HandlerFactory {
+	m := c.backend.metrics
+	if m == nil || name == "UID" {
+		return nil
+	}
+	newHandler := c.next.Command(name)
+	if newHandler == nil {
+		return nil
+	}
+
+	return func() server.Handler {
+		h := countedHandler{Handler: newHandler(), name: name, metrics: m}
+		switch inner := h.Handler.(type) {
+		case server.UidHandler:
+			return &countedUidHandler{countedHandler: h, uid: inner}
+		case server.Upgrader:
+			return &countedUpgrader{countedHandler: h, upgrader: inner}
+		}
+		return &h
+	}
+}
+
+// countedHandler counts a command when it is handled, or when its
+// arguments are invalid
+type countedHandler struct {
+	server.Handler
+	name    string
+	metrics *imapMetrics
+}
+
+// Parse parses the arguments, counting the command if they are invalid
+func (h *countedHandler) Parse(fields []interface{}) error {
+	err := h.Handler.Parse(fields)
+	if err != nil {
+		h.metrics.count(h.name, server.ErrStatusResp(&imap.StatusResp{Type: imap.StatusRespBad}))
+	}
+	return err
+}
+
+// Handle handles and counts the command
+func (h *countedHandler) Handle(conn server.Conn) error {
+	err := h.Handler.Handle(conn)
+	h.metrics.count(h.name, err)
+	return err
+}
+
+// countedUidHandler counts a command that can also be used with UID
+type countedUidHandler struct {
+	countedHandler
+	uid server.UidHandler
+}
+
+// UidHandle handles and counts the command used with UID
+func (h *countedUidHandler) UidHandle(conn server.Conn) error {
+	err := h.uid.UidHandle(conn)
+	h.metrics.count("UID "+h.name, err)
+	return err
+}
+
+// countedUpgrader counts a command that upgrades the connection, STARTTLS
+type countedUpgrader struct {
+	countedHandler
+	upgrader server.Upgrader
+}
+
+// Upgrade upgrades the connection
+func (h *countedUpgrader) Upgrade(conn server.Conn) error {
+	return h.upgrader.Upgrade(conn)
+}
@@ -0,0 +1,79 @@
+package imapserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/backendutil"
+	"github.com/emersion/go-message/textproto"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailblob"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailrender"
+)
+
+// For FETCH, emails are rendered as MIME messages by package mailrender.
+
+// SetAttachmentStore makes the server read the attachments that the SMTP
+// server stored outside of Redis from an attachment store, to render the
+// complete messages
+func (s *Server) SetAttachmentStore(store *mailblob.Store) {
+	s.backend.attachments = store
+}
+
+// rfc822 returns the message rendered as MIME message, rendering it the
+// first time. Attachments stored outside of Redis are read for this.
+func (m *Message) rfc822() ([]byte, error) {
+	if m.raw == nil {
+		email := m.Email
+		if m.attachments != nil {
+			resolved, err := m.attachments.Resolve(email)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read attachments of message UID %d: %w", m.Uid, err)
+			}
+			email = resolved
+		}
+		raw, err := mailrender.Render(email)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render message UID %d: %w", m.Uid, err)
+		}
+		m.raw = raw
+	}
+	return m.raw, nil
+}
+
+// entity returns the header and the body of the rendered message
+func (m *Message) entity() (textproto.Header, io.Reader, error) {
+	raw, err := m.rfc822()
+	if err != nil {
+		return textproto.Header{}, nil, err
+	}
+	r := bufio.NewReader(bytes.NewReader(raw))
+	header, err := textproto.ReadHeader(r)
+	if err != nil {
+		return textproto.Header{}, nil, fmt.Errorf("failed to read header of message UID %d: %w", m.Uid, err)
+	}
+	return header, r, nil
+}
+
+// bodyStructure returns the MIME structure of the message
+func (m *Message) bodyStructure(extended bool) (*imap.BodyStructure, error) {
+	header, body, err := m.entity()
+	if err != nil {
+		return nil, err
+	}
+	return backendutil.FetchBodyStructure(header, body, extended)
+}
+
+// bodySection returns a section of the message, like BODY[1] for the text
+// or BODY[2] for the first attachment. It returns nil for a part that does
+// not exist, which is sent as NIL.
+func (m *Message) bodySection(section *imap.BodySectionName) (imap.Literal, error) {
+	header, body, err := m.entity()
+	if err != nil {
+		return nil, err
+	}
+	literal, _ := backendutil.FetchBodySection(header, body, section)
+	return literal, nil
+}
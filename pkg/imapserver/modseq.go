@@ -0,0 +1,163 @@
+package imapserver
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/emersion/go-imap"
+	"github.com/redis/go-redis/v9"
+)
+
+// Every change to a message gets a new modification sequence (RFC 7162)
+// from a counter per user. The modification sequence of each message is
+// stored in a hash per user, by message key, and the UIDs of expunged
+// messages in a hash per mailbox, so QRESYNC clients can be told which
+// messages vanished.
+
+// highestModSeqKey returns the modification sequence counter of a user
+func highestModSeqKey(username string) string {
+	return fmt.Sprintf("mail:highestmodseq:%s", username)
+}
+
+// modSeqsKey returns the hash of the modification sequences of a user's messages
+func modSeqsKey(username string) string {
+	return fmt.Sprintf("mail:modseqs:%s", username)
+}
+
+// vanishedKey returns the hash of the expunged UIDs of a mailbox
+func vanishedKey(username, mailbox string) string {
+	return fmt.Sprintf("mail:vanished:%s:%s", username, mailbox)
+}
+
+// nextModSeq returns a new modification sequence for the user
+func (u *User) nextModSeq() (uint64, error) {
+	modSeq, err := u.backend.redisClient.Incr(u.backend.ctx, highestModSeqKey(u.username)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get a modification sequence: %w", err)
+	}
+	return uint64(modSeq), nil
+}
+
+// touch gives a message a new modification sequence after it changed
+func (m *Mailbox) touch(msg *Message) error {
+	modSeq, err := m.user.nextModSeq()
+	if err != nil {
+		return err
+	}
+	value := strconv.FormatUint(modSeq, 10)
+	if err := m.backend.redisClient.HSet(m.backend.ctx, modSeqsKey(m.user.username), msg.Key, value).Err(); err != nil {
+		return fmt.Errorf("failed to store modification sequence of %s: %w", msg.Key, err)
+	}
+	msg.ModSeq = modSeq
+	return nil
+}
+
+// loadModSeqs reads the modification sequences of the loaded messages.
+// Messages stored by others, like the SMTP server or redis_mail_feeder,
+// get one the first time they are seen.
+func (m *Mailbox) loadModSeqs() error {
+	for _, msg := range m.messages {
+		value, err := m.backend.redisClient.HGet(m.backend.ctx, modSeqsKey(m.user.username), msg.Key).Result()
+		if err == redis.Nil {
+			if err := m.touch(msg); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get modification sequence of %s: %w", msg.Key, err)
+		}
+		msg.ModSeq, _ = strconv.ParseUint(value, 10, 64)
+	}
+	return nil
+}
+
+// forget removes a deleted message from the modification sequences and the
+// search index and, if vanished is set, records its UID as expunged from
+// the mailbox
+func (m *Mailbox) forget(msg *Message, vanished bool) {
+	if err := m.backend.index.Remove(m.user.username, msg.Key); err != nil {
+		log.Printf("ERROR: Failed to remove %s from the search index: %v", msg.Key, err)
+	}
+	if err := m.backend.redisClient.HDel(m.backend.ctx, modSeqsKey(m.user.username), msg.Key).Err(); err != nil {
+		log.Printf("ERROR: Failed to remove modification sequence of %s: %v", msg.Key, err)
+	}
+	if !vanished {
+		return
+	}
+
+	modSeq, err := m.user.nextModSeq()
+	if err != nil {
+		log.Printf("ERROR: Failed to record expunge of UID %d: %v", msg.Uid, err)
+		return
+	}
+	uid := strconv.FormatUint(uint64(msg.Uid), 10)
+	value := strconv.FormatUint(modSeq, 10)
+	if err := m.backend.redisClient.HSet(m.backend.ctx, vanishedKey(m.user.username, m.name), uid, value).Err(); err != nil {
+		log.Printf("ERROR: Failed to record expunge of UID %d: %v", msg.Uid, err)
+	}
+}
+
+// vanished returns the UIDs expunged from the mailbox with their
+// modification sequences
+func (m *Mailbox) vanished() (map[uint32]uint64, error) {
+	key := vanishedKey(m.user.username, m.name)
+	uids, err := m.backend.redisClient.HKeys(m.backend.ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list expunged messages: %w", err)
+	}
+
+	result := make(map[uint32]uint64, len(uids))
+	for _, uid := range uids {
+		value, err := m.backend.redisClient.HGet(m.backend.ctx, key, uid).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list expunged messages: %w", err)
+		}
+		parsedUID, _ := strconv.ParseUint(uid, 10, 32)
+		modSeq, _ := strconv.ParseUint(value, 10, 64)
+		result[uint32(parsedUID)] = modSeq
+	}
+	return result, nil
+}
+
+// vanishedSince returns the UIDs in uids, or all if uids is nil, that were
+// expunged after modSeq
+func (m *Mailbox) vanishedSince(modSeq uint64, uids *imap.SeqSet) (*imap.SeqSet, error) {
+	vanished, err := m.vanished()
+	if err != nil {
+		return nil, err
+	}
+
+	set := new(imap.SeqSet)
+	for uid, expunged := range vanished {
+		if expunged > modSeq && (uids == nil || uids.Contains(uid)) {
+			set.AddNum(uid)
+		}
+	}
+	return set, nil
+}
+
+// highestModSeq returns the highest modification sequence of the messages
+// in the mailbox and of the expunges from it
+func (m *Mailbox) highestModSeq() (uint64, error) {
+	var highest uint64 = 1
+	for _, msg := range m.messages {
+		highest = max(highest, msg.ModSeq)
+	}
+
+	vanished, err := m.vanished()
+	if err != nil {
+		return 0, err
+	}
+	for _, modSeq := range vanished {
+		highest = max(highest, modSeq)
+	}
+	return highest, nil
+}
+
+// formatModSeq formats a modification sequence, which can be larger than
+// the numbers go-imap writes
+func formatModSeq(modSeq uint64) imap.RawString {
+	return imap.RawString(strconv.FormatUint(modSeq, 10))
+}
@@ -0,0 +1,223 @@
+package imapserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-imap/utf7"
+	"github.com/freeflowuniverse/herolauncher/pkg/mail"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailauth"
+)
+
+// quotaRoot is the single quota root of every user, covering all mailboxes
+const quotaRoot = ""
+
+// codeOverQuota is the response code for a message that does not fit the
+// quota (RFC 9208)
+const codeOverQuota imap.StatusRespCode = "OVERQUOTA"
+
+// checkQuota returns mailauth.ErrOverQuota if the emails do not fit the
+// user's quota. They are measured the way they are stored.
+func (u *User) checkQuota(emails []*mail.Email) error {
+	var size int64
+	for _, email := range emails {
+		emailJSON, err := json.Marshal(email)
+		if err != nil {
+			return fmt.Errorf("failed to marshal email: %w", err)
+		}
+		size += int64(len(emailJSON))
+	}
+	return u.backend.users.CheckQuota(u.username, int64(len(emails)), size)
+}
+
+// overQuotaResp turns an ErrOverQuota into a NO [OVERQUOTA] response
+func overQuotaResp(err error) error {
+	if !errors.Is(err, mailauth.ErrOverQuota) {
+		return err
+	}
+	return server.ErrStatusResp(&imap.StatusResp{
+		Type: imap.StatusRespNo,
+		Code: codeOverQuota,
+		Info: err.Error(),
+	})
+}
+
+// quota implements the QUOTA extension (RFC 9208) to report the usage and
+// limits of the user. The limits are set with the !!mailuser.quota
+// heroscript action, SETQUOTA is not allowed.
+type quota struct{}
+
+// Capabilities advertises QUOTA and the supported resources once the client
+// logged in
+func (quota) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{"QUOTA", "QUOTA=RES-STORAGE", "QUOTA=RES-MESSAGE"}
+	}
+	return nil
+}
+
+// Command returns the handlers of the QUOTA commands
+func (quota) Command(name string) server.HandlerFactory {
+	switch name {
+	case "GETQUOTA":
+		return func() server.Handler { return &getQuota{} }
+	case "GETQUOTAROOT":
+		return func() server.Handler { return &getQuotaRoot{} }
+	case "SETQUOTA":
+		return func() server.Handler { return &setQuota{} }
+	}
+	return nil
+}
+
+// quotaUser returns the user of an authenticated connection
+func quotaUser(conn server.Conn) (*User, error) {
+	ctx := conn.Context()
+	if ctx.User == nil {
+		return nil, server.ErrNotAuthenticated
+	}
+	user, ok := ctx.User.(*User)
+	if !ok {
+		return nil, errors.New("QUOTA is not supported by this user")
+	}
+	return user, nil
+}
+
+// writeQuota writes the QUOTA response of the user, with the resources that
+// have a limit. It reports whether a limit is set.
+func writeQuota(conn server.Conn, user *User) (bool, error) {
+	limits, err := user.backend.users.Quota(user.username)
+	if err != nil {
+		return false, err
+	}
+	if limits.Unlimited() {
+		return false, nil
+	}
+	usage, err := user.backend.users.Usage(user.username)
+	if err != nil {
+		return false, err
+	}
+
+	// STORAGE is counted in units of 1024 bytes
+	var resources []interface{}
+	if limits.Storage > 0 {
+		resources = append(resources, imap.RawString("STORAGE"),
+			imap.RawString(fmt.Sprint((usage.Storage+1023)/1024)),
+			imap.RawString(fmt.Sprint(limits.Storage/1024)))
+	}
+	if limits.Messages > 0 {
+		resources = append(resources, imap.RawString("MESSAGE"),
+			imap.RawString(fmt.Sprint(usage.Messages)),
+			imap.RawString(fmt.Sprint(limits.Messages)))
+	}
+	return true, conn.WriteResp(imap.NewUntaggedResp([]interface{}{imap.RawString("QUOTA"), quotaRoot, resources}))
+}
+
+// getQuota is GETQUOTA, reporting a quota root
+type getQuota struct {
+	Root string
+}
+
+// Parse reads the quota root
+func (cmd *getQuota) Parse(fields []interface{}) error {
+	if len(fields) < 1 {
+		return errors.New("No enough arguments")
+	}
+	root, err := imap.ParseString(fields[0])
+	if err != nil {
+		return err
+	}
+	cmd.Root = root
+	return nil
+}
+
+// Handle writes the QUOTA response of the root
+func (cmd *getQuota) Handle(conn server.Conn) error {
+	user, err := quotaUser(conn)
+	if err != nil {
+		return err
+	}
+	if cmd.Root != quotaRoot {
+		return errors.New("No such quota root")
+	}
+
+	limited, err := writeQuota(conn, user)
+	if err != nil {
+		return err
+	}
+	if !limited {
+		return errors.New("No quota set")
+	}
+	return nil
+}
+
+// getQuotaRoot is GETQUOTAROOT, reporting the quota roots of a mailbox
+type getQuotaRoot struct {
+	Mailbox string
+}
+
+// Parse reads the mailbox
+func (cmd *getQuotaRoot) Parse(fields []interface{}) error {
+	if len(fields) < 1 {
+		return errors.New("No enough arguments")
+	}
+	mailbox, err := imap.ParseString(fields[0])
+	if err != nil {
+		return err
+	}
+	if mailbox, err = utf7.Encoding.NewDecoder().String(mailbox); err != nil {
+		return err
+	}
+	cmd.Mailbox = imap.CanonicalMailboxName(mailbox)
+	return nil
+}
+
+// Handle writes the QUOTAROOT response and the QUOTA of the root, if the
+// user has a quota
+func (cmd *getQuotaRoot) Handle(conn server.Conn) error {
+	user, err := quotaUser(conn)
+	if err != nil {
+		return err
+	}
+	if _, err := user.GetMailbox(cmd.Mailbox); err != nil {
+		return err
+	}
+
+	limits, err := user.backend.users.Quota(user.username)
+	if err != nil {
+		return err
+	}
+	mailbox, _ := utf7.Encoding.NewEncoder().String(cmd.Mailbox)
+	fields := []interface{}{imap.RawString("QUOTAROOT"), imap.FormatMailboxName(mailbox)}
+	if !limits.Unlimited() {
+		fields = append(fields, quotaRoot)
+	}
+	if err := conn.WriteResp(imap.NewUntaggedResp(fields)); err != nil {
+		return err
+	}
+	_, err = writeQuota(conn, user)
+	return err
+}
+
+// setQuota is SETQUOTA, which is refused as the limits are administered
+// with heroscript
+type setQuota struct{}
+
+// Parse ignores the arguments
+func (cmd *setQuota) Parse(fields []interface{}) error {
+	return nil
+}
+
+// Handle refuses to change the quota
+func (cmd *setQuota) Handle(conn server.Conn) error {
+	if _, err := quotaUser(conn); err != nil {
+		return err
+	}
+	return server.ErrStatusResp(&imap.StatusResp{
+		Type: imap.StatusRespNo,
+		Code: "NOPERM",
+		Info: "Quotas are set by the administrator",
+	})
+}
@@ -0,0 +1,55 @@
+package imapserver
+
+import (
+	"github.com/emersion/go-imap"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailsearch"
+)
+
+// matcher returns what matches the messages of the mailbox against search
+// criteria. The BODY and TEXT criteria are looked up in the full-text index
+// of package mailsearch for the messages it indexed, matching whole words;
+// messages stored without updating the index are read instead.
+func (m *Mailbox) matcher(criteria *imap.SearchCriteria) (func(seqNum uint32, msg *Message) bool, error) {
+	match := func(seqNum uint32, msg *Message) bool {
+		return msg.Match(seqNum, criteria)
+	}
+	values := append(append([]string{}, criteria.Body...), criteria.Text...)
+	if len(values) == 0 {
+		return match, nil
+	}
+	// Values without words, like single letters, cannot be looked up
+	for _, value := range values {
+		if len(mailsearch.Words(value)) == 0 {
+			return match, nil
+		}
+	}
+
+	indexed, err := m.backend.index.IndexedKeys(m.user.username)
+	if err != nil {
+		return nil, err
+	}
+	var found map[string]bool
+	for _, value := range values {
+		keys, err := m.backend.index.Search(m.user.username, value, m.name)
+		if err != nil {
+			return nil, err
+		}
+		matches := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			if found == nil || found[key] {
+				matches[key] = true
+			}
+		}
+		found = matches
+	}
+
+	rest := *criteria
+	rest.Body = nil
+	rest.Text = nil
+	return func(seqNum uint32, msg *Message) bool {
+		if !indexed[msg.Key] {
+			return msg.Match(seqNum, criteria)
+		}
+		return found[msg.Key] && msg.Match(seqNum, &rest)
+	}, nil
+}
@@ -3,6 +3,7 @@ package imapserver
 import (
 	"log"
 	"os"
+	"sync"
 
 	"github.com/emersion/go-imap/server"
 	"github.com/redis/go-redis/v9"
@@ -14,15 +15,23 @@ type Server struct {
 	backend    *Backend
 	addr       string
 	debugMode  bool
+
+	limits  Limits
+	metrics *imapMetrics
+
+	// connsPerIP counts the open connections of every client address
+	connMu     sync.Mutex
+	connsPerIP map[string]int
 }
 
 // NewServer creates a new IMAP server
 func NewServer(redisClient *redis.Client, addr string, debugMode bool) *Server {
 	backend := NewBackend(redisClient, debugMode)
 	s := &Server{
-		backend:   backend,
-		addr:      addr,
-		debugMode: debugMode,
+		backend:    backend,
+		addr:       addr,
+		debugMode:  debugMode,
+		connsPerIP: make(map[string]int),
 	}
 
 	// Create a new IMAP server
@@ -33,6 +42,24 @@ func NewServer(redisClient *redis.Client, addr string, debugMode bool) *Server {
 	// The MOVE capability will be automatically advertised by the server
 	// since we've implemented the MoveMessages method in the Mailbox struct
 
+	extensions := []server.Extension{
+		// UIDPLUS replaces APPEND, COPY and EXPUNGE to report UIDs
+		uidPlus{},
+		// CONDSTORE and QRESYNC replace SELECT, EXAMINE, FETCH, STORE and
+		// SEARCH to report modification sequences
+		condStore{},
+		// QUOTA reports the usage and limits of the user
+		quota{},
+		// SPECIAL-USE and LIST-EXTENDED replace LIST and CREATE to report
+		// the roles of mailboxes
+		specialUse{},
+		// SORT and THREAD sort and thread messages on the server
+		sortThread{},
+	}
+	// The command counter goes first to see every command
+	s.imapServer.Enable(newCommandCounter(backend, extensions))
+	s.imapServer.Enable(extensions...)
+
 	// Set up logging
 	s.imapServer.ErrorLog = log.New(os.Stderr, "IMAP SERVER ERROR: ", log.LstdFlags)
 	// Debug logger is not set as it requires an io.Writer and log.Logger doesn't implement it
@@ -43,14 +70,11 @@ func NewServer(redisClient *redis.Client, addr string, debugMode bool) *Server {
 // Start starts the IMAP server
 func (s *Server) Start() error {
 	log.Printf("Starting IMAP server on %s", s.addr)
-	return s.imapServer.ListenAndServe()
-}
-
-// StartTLS starts the IMAP server with TLS
-// Note: This is not implemented correctly yet
-func (s *Server) StartTLS(certFile, keyFile string) error {
-	log.Printf("TLS not supported yet, starting without TLS on %s", s.addr)
-	return s.imapServer.ListenAndServe()
+	listener, err := s.listen(s.addr, true)
+	if err != nil {
+		return err
+	}
+	return s.imapServer.Serve(listener)
 }
 
 // Close stops the IMAP server
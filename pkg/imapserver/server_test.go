@@ -0,0 +1,570 @@
+package imapserver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/mail"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailblob"
+	"github.com/freeflowuniverse/herolauncher/pkg/redisserver/redistest"
+	"github.com/freeflowuniverse/herolauncher/pkg/system/stats/metrics"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfsdb"
+	"github.com/redis/go-redis/v9"
+)
+
+// testServer is an IMAP server on an in-memory Redis server, with the user
+// alice
+type testServer struct {
+	t      *testing.T
+	server *Server
+	client *redis.Client
+	addr   string
+}
+
+// newTestServer starts an IMAP server on an in-memory Redis server, after
+// configure set it up
+func newTestServer(t *testing.T, configure ...func(*Server)) *testServer {
+	client := redistest.NewClient(t)
+	server := NewServer(client, "127.0.0.1:0", false)
+	if err := server.backend.users.Add("alice", "secret"); err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+	for _, configure := range configure {
+		configure(server)
+	}
+	listener, err := server.listen("127.0.0.1:0", true)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go server.imapServer.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return &testServer{t: t, server: server, client: client, addr: listener.Addr().String()}
+}
+
+// store stores a message of alice in a mailbox, like the SMTP server does
+func (s *testServer) store(mailbox string, uid uint32, email *mail.Email) {
+	s.t.Helper()
+	data, err := json.Marshal(email)
+	if err != nil {
+		s.t.Fatalf("Failed to encode message: %v", err)
+	}
+	key := fmt.Sprintf("mail:in:alice:%s:%d", mailbox, uid)
+	if err := s.client.Set(context.Background(), key, string(data), 0).Err(); err != nil {
+		s.t.Fatalf("Failed to store message: %v", err)
+	}
+}
+
+// imapClient is a connection of alice to the test server
+type imapClient struct {
+	t    *testing.T
+	text *textproto.Conn
+	tag  int
+}
+
+// dial connects to the server, and returns the connection and the greeting
+func (s *testServer) dial() (*imapClient, string) {
+	s.t.Helper()
+	conn, err := net.Dial("tcp", s.addr)
+	if err != nil {
+		s.t.Fatalf("Failed to connect: %v", err)
+	}
+	c := &imapClient{t: s.t, text: textproto.NewConn(conn)}
+	s.t.Cleanup(func() { c.text.Close() })
+	greeting, err := c.text.ReadLine()
+	if err != nil {
+		s.t.Fatalf("Failed to read the greeting: %v", err)
+	}
+	return c, greeting
+}
+
+// login connects to the server and logs in as alice
+func (s *testServer) login() *imapClient {
+	s.t.Helper()
+	c, greeting := s.dial()
+	if !strings.HasPrefix(greeting, "* OK") {
+		s.t.Fatalf("Unexpected greeting %q", greeting)
+	}
+	if _, status := c.run("LOGIN alice secret"); !strings.HasPrefix(status, "OK") {
+		s.t.Fatalf("Failed to log in: %s", status)
+	}
+	return c
+}
+
+// run sends a command and returns its untagged responses and its tagged
+// status, without the tag
+func (c *imapClient) run(command string) ([]string, string) {
+	c.t.Helper()
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	if err := c.text.PrintfLine("%s %s", tag, command); err != nil {
+		c.t.Fatalf("Failed to send %q: %v", command, err)
+	}
+	var untagged []string
+	for {
+		line, err := c.text.ReadLine()
+		if err != nil {
+			c.t.Fatalf("Failed to read the response to %q: %v", command, err)
+		}
+		if status, ok := strings.CutPrefix(line, tag+" "); ok {
+			return untagged, status
+		}
+		untagged = append(untagged, line)
+	}
+}
+
+// step is a command with the responses it is expected to get
+type step struct {
+	command  string
+	untagged []string
+	status   string
+}
+
+// runSteps runs commands and checks that they get exactly the untagged
+// responses of their step, and a tagged status that starts with its status
+func (c *imapClient) runSteps(steps []step) {
+	c.t.Helper()
+	for _, step := range steps {
+		untagged, status := c.run(step.command)
+		if strings.Join(untagged, "\n") != strings.Join(step.untagged, "\n") {
+			c.t.Errorf("%s: expected\n%s\ngot\n%s", step.command, strings.Join(step.untagged, "\n"), strings.Join(untagged, "\n"))
+		}
+		if !strings.HasPrefix(status, step.status) {
+			c.t.Errorf("%s: expected status %q, got %q", step.command, step.status, status)
+		}
+	}
+}
+
+// storedEmail returns a message of alice as it is stored in Redis
+func (s *testServer) storedEmail(mailbox string, uid uint32) *mail.Email {
+	s.t.Helper()
+	data, err := s.client.Get(context.Background(), fmt.Sprintf("mail:in:alice:%s:%d", mailbox, uid)).Result()
+	if err != nil {
+		s.t.Fatalf("Failed to get message %d of %s: %v", uid, mailbox, err)
+	}
+	var email mail.Email
+	if err := json.Unmarshal([]byte(data), &email); err != nil {
+		s.t.Fatalf("Failed to decode message %d of %s: %v", uid, mailbox, err)
+	}
+	return &email
+}
+
+func TestFlags(t *testing.T) {
+	s := newTestServer(t)
+	s.store("inbox", 1, &mail.Email{Message: "Hello", Envelope: &mail.Envelope{Subject: "One"}})
+	s.store("inbox", 2, &mail.Email{Message: "Hello", Flags: []string{"\\Seen"}, Envelope: &mail.Envelope{Subject: "Two"}})
+
+	c := s.login()
+	untagged, status := c.run("SELECT INBOX")
+	if !strings.HasPrefix(status, "OK [READ-WRITE]") ||
+		!strings.Contains(strings.Join(untagged, "\n"), "* OK [PERMANENTFLAGS (\\Seen \\Answered \\Flagged \\Deleted \\Draft \\*)]") {
+		t.Fatalf("Expected the system flags and keywords to be permanent, got %q, %s", untagged, status)
+	}
+	c.runSteps([]step{
+		{"FETCH 1:* (FLAGS)", []string{"* 1 FETCH (FLAGS ())", "* 2 FETCH (FLAGS (\\Seen))"}, "OK"},
+		// Keywords are stored, \Recent is not
+		{"STORE 1 +FLAGS (\\Flagged $Important \\Recent)", []string{"* 1 FETCH (FLAGS (\\Flagged $important))"}, "OK"},
+		{"STORE 1 +FLAGS (\\flagged)", []string{"* 1 FETCH (FLAGS (\\Flagged $important))"}, "OK"},
+		{"STORE 2 -FLAGS.SILENT (\\Seen)", nil, "OK"},
+		{"FETCH 2 (FLAGS)", []string{"* 2 FETCH (FLAGS ())"}, "OK"},
+		{"STORE 1:2 FLAGS (\\Answered)", []string{"* 1 FETCH (FLAGS (\\Answered))", "* 2 FETCH (FLAGS (\\Answered))"}, "OK"},
+		{"UID STORE 2 +FLAGS (\\Draft)", []string{"* 2 FETCH (FLAGS (\\Answered \\Draft) UID 2)"}, "OK"},
+		// Fetching a body sets \Seen, unless it is peeked
+		{"FETCH 2 BODY.PEEK[TEXT]", []string{"* 2 FETCH (BODY[TEXT] {5}", "Hello)"}, "OK"},
+		{"FETCH 2 (FLAGS)", []string{"* 2 FETCH (FLAGS (\\Answered \\Draft))"}, "OK"},
+		{"FETCH 2 BODY[TEXT]", []string{"* 2 FETCH (BODY[TEXT] {5}", "Hello FLAGS (\\Answered \\Draft \\Seen))"}, "OK"},
+	})
+
+	// The flags are kept in Redis, so other sessions see them, and sessions
+	// that examine the mailbox cannot change them
+	if email := s.storedEmail("inbox", 2); strings.Join(email.Flags, " ") != "\\Answered \\Draft \\Seen" {
+		t.Errorf("Expected the flags to be stored, got %v", email.Flags)
+	}
+	other := s.login()
+	if _, status := other.run("EXAMINE INBOX"); !strings.HasPrefix(status, "OK [READ-ONLY]") {
+		t.Fatalf("Failed to examine the inbox: %s", status)
+	}
+	other.runSteps([]step{
+		{"FETCH 1:* (FLAGS)", []string{"* 1 FETCH (FLAGS (\\Answered))", "* 2 FETCH (FLAGS (\\Answered \\Draft \\Seen))"}, "OK"},
+		{"STORE 1 +FLAGS (\\Seen)", nil, "NO"},
+	})
+	if email := s.storedEmail("inbox", 1); strings.Join(email.Flags, " ") != "\\Answered" {
+		t.Errorf("Expected the flags not to change, got %v", email.Flags)
+	}
+}
+
+func TestMailboxes(t *testing.T) {
+	s := newTestServer(t)
+	s.store("inbox", 1, &mail.Email{Message: "Hello", Envelope: &mail.Envelope{Subject: "One"}})
+	s.store("work", 1, &mail.Email{Message: "Hello", Envelope: &mail.Envelope{Subject: "Work"}})
+
+	c := s.login()
+	c.runSteps([]step{
+		// Mailboxes exist through their messages, or when they are created
+		{`LIST "" *`, []string{
+			`* LIST (\Inbox \HasNoChildren) "/" inbox`,
+			`* LIST (\HasNoChildren) "/" "work"`,
+		}, "OK"},
+		{"CREATE projects/2024", nil, "OK"},
+		{"CREATE Projects", nil, "NO Mailbox already exists"},
+		{"CREATE INBOX", nil, "NO Mailbox already exists"},
+		{"CREATE bad:name", nil, `NO mailbox name "bad:name" must not contain any of`},
+		{"CREATE a//b", nil, `NO mailbox name "a//b" must not contain empty levels`},
+		{`LIST "" *`, []string{
+			`* LIST (\Inbox \HasNoChildren) "/" inbox`,
+			`* LIST (\HasChildren) "/" "projects"`,
+			`* LIST (\HasNoChildren) "/" "projects/2024"`,
+			`* LIST (\HasNoChildren) "/" "work"`,
+		}, "OK"},
+		{"SUBSCRIBE projects/2024", nil, "OK"},
+		{`LSUB "" *`, []string{`* LSUB (\HasNoChildren) "/" "projects/2024"`}, "OK"},
+
+		// Renaming moves the messages, the nested mailboxes and the
+		// subscriptions
+		{"RENAME work jobs", nil, "OK"},
+		{"RENAME projects old/projects", nil, "OK"},
+		{"RENAME missing other", nil, "NO No such mailbox"},
+		{"RENAME jobs old/projects", nil, "NO"},
+		{`LSUB "" *`, []string{`* LSUB (\HasNoChildren) "/" "old/projects/2024"`}, "OK"},
+		{"STATUS jobs (MESSAGES)", []string{`* STATUS "jobs" (MESSAGES 1)`}, "OK"},
+		{"STATUS work (MESSAGES)", nil, "NO"},
+		// Renaming the inbox moves its messages and leaves it empty
+		{"RENAME INBOX saved", nil, "OK"},
+		{"STATUS inbox (MESSAGES)", []string{"* STATUS inbox (MESSAGES 0)"}, "OK"},
+		{"STATUS saved (MESSAGES)", []string{`* STATUS "saved" (MESSAGES 1)`}, "OK"},
+
+		// Deleting removes the messages of a mailbox, which is kept as long
+		// as it has nested mailboxes
+		{"DELETE INBOX", nil, "NO the inbox cannot be deleted"},
+		{"DELETE missing", nil, "NO No such mailbox"},
+		{"DELETE jobs", nil, "OK"},
+		{"DELETE old/projects", nil, "OK"},
+		{`LIST "" *`, []string{
+			`* LIST (\Inbox \HasNoChildren) "/" inbox`,
+			`* LIST (\HasChildren) "/" "old"`,
+			`* LIST (\HasChildren) "/" "old/projects"`,
+			`* LIST (\HasNoChildren) "/" "old/projects/2024"`,
+			`* LIST (\HasNoChildren) "/" "saved"`,
+		}, "OK"},
+		{"UNSUBSCRIBE old/projects/2024", nil, "OK"},
+		{`LSUB "" *`, nil, "OK"},
+	})
+
+	keys, err := s.client.Keys(context.Background(), "mail:in:*").Result()
+	if err != nil || strings.Join(keys, " ") != "mail:in:alice:saved:1" {
+		t.Errorf("Expected only the message of the inbox to be left, got %v, %v", keys, err)
+	}
+}
+
+// appendCommand returns an APPEND of a message to a mailbox, with flags
+// written as "(\Seen) " or "", and the message as non-synchronizing literal
+func appendCommand(mailbox, flags, message string) string {
+	return fmt.Sprintf("APPEND %s %s{%d+}\r\n%s", mailbox, flags, len(message), message)
+}
+
+func TestUIDPlus(t *testing.T) {
+	// New UIDs are the current time or higher, so the messages get UIDs
+	// after it to predict the next ones
+	s := newTestServer(t)
+	for uid := uint32(4000000001); uid <= 4000000003; uid++ {
+		s.store("inbox", uid, &mail.Email{Message: "Hello", Envelope: &mail.Envelope{Subject: fmt.Sprintf("Message %d", uid)}})
+	}
+	s.store("archive", 4100000000, &mail.Email{Message: "Hello", Envelope: &mail.Envelope{Subject: "Archived"}})
+	message := "Subject: New\r\n\r\nHello!"
+
+	c := s.login()
+	if untagged, _ := c.run("CAPABILITY"); len(untagged) != 1 || !strings.Contains(untagged[0], " UIDPLUS ") {
+		t.Errorf("Expected UIDPLUS to be advertised, got %q", untagged)
+	}
+	c.runSteps([]step{
+		{appendCommand("inbox", "(\\Seen) ", message), nil, "OK [APPENDUID 1 4000000004] APPEND completed"},
+		{appendCommand("missing", "", message), nil, "NO [TRYCREATE]"},
+	})
+	if _, status := c.run("SELECT INBOX"); !strings.HasPrefix(status, "OK") {
+		t.Fatalf("Failed to select the inbox: %s", status)
+	}
+	c.runSteps([]step{
+		// Appending to the selected mailbox reports the new message
+		{appendCommand("inbox", "", message), []string{"* 5 EXISTS"}, "OK [APPENDUID 1 4000000005] APPEND completed"},
+		{"UID FETCH 4000000004:* (FLAGS BODY.PEEK[HEADER.FIELDS (SUBJECT)])", []string{
+			"* 4 FETCH (FLAGS (\\Seen) BODY[HEADER.FIELDS (SUBJECT)] {16}",
+			"Subject: New",
+			"",
+			" UID 4000000004)",
+			"* 5 FETCH (FLAGS () BODY[HEADER.FIELDS (SUBJECT)] {16}",
+			"Subject: New",
+			"",
+			" UID 4000000005)",
+		}, "OK"},
+
+		// The UIDs of copies are listed in the order of the originals
+		{"COPY 1:2 missing", nil, "NO [TRYCREATE]"},
+		{"COPY 1:2 Archive", nil, "OK [COPYUID 1 4000000001,4000000002 4100000001,4100000002] COPY completed"},
+		{"UID COPY 4000000005,4000000003 archive", nil, "OK [COPYUID 1 4000000003,4000000005 4100000003,4100000004] UID COPY completed"},
+		{"UID COPY 4200000000 archive", nil, "OK UID COPY completed"},
+		{"STATUS archive (MESSAGES)", []string{`* STATUS "archive" (MESSAGES 5)`}, "OK"},
+
+		// UID EXPUNGE only removes the deleted messages in its UID set
+		{"STORE 1:2 +FLAGS.SILENT (\\Deleted)", nil, "OK"},
+		{"UID EXPUNGE 4000000001,4000000003", []string{"* 1 EXPUNGE"}, "OK UID EXPUNGE completed"},
+		{"UID EXPUNGE", nil, "NO Missing UID set"},
+		{"FETCH 1:* (UID FLAGS)", []string{
+			"* 1 FETCH (UID 4000000002 FLAGS (\\Deleted))",
+			"* 2 FETCH (UID 4000000003 FLAGS ())",
+			"* 3 FETCH (UID 4000000004 FLAGS (\\Seen))",
+			"* 4 FETCH (UID 4000000005 FLAGS ())",
+		}, "OK"},
+		{"EXPUNGE", []string{"* 1 EXPUNGE"}, "OK"},
+	})
+
+	// Read-only sessions cannot expunge
+	other := s.login()
+	other.run("EXAMINE INBOX")
+	other.runSteps([]step{{"UID EXPUNGE 1:*", nil, "NO"}})
+}
+
+func TestBodyStructure(t *testing.T) {
+	s := newTestServer(t)
+	s.store("inbox", 1, &mail.Email{Message: "Hello", Envelope: &mail.Envelope{Subject: "Plain", From: []string{"bob@example.com"}}})
+	s.store("inbox", 2, &mail.Email{Message: "See the report", Envelope: &mail.Envelope{Subject: "Report"}, Attachments: []mail.Attachment{
+		{Filename: "report.txt", ContentType: "text/plain", Data: "aGVsbG8="},
+		{Filename: "logo.png", ContentType: "image/png", Data: "iVBORw0KGgo="},
+	}})
+
+	c := s.login()
+	c.run("SELECT INBOX")
+	c.runSteps([]step{
+		// Emails are rendered as MIME messages, with the attachments as parts
+		// after the text
+		{"FETCH 1:2 (BODYSTRUCTURE)", []string{
+			`* 1 FETCH (BODYSTRUCTURE ("text" "plain" ("charset" "utf-8") NIL NIL "7bit" 5 1 NIL NIL NIL NIL))`,
+			`* 2 FETCH (BODYSTRUCTURE (("text" "plain" ("charset" "utf-8") NIL NIL "7bit" 14 1 NIL NIL NIL NIL) ` +
+				`("text" "plain" ("name" "report.txt") NIL NIL "base64" 8 1 NIL ("attachment" ("filename" "report.txt")) NIL NIL) ` +
+				`("image" "png" ("name" "logo.png") NIL NIL "base64" 12 NIL ("attachment" ("filename" "logo.png")) NIL NIL) ` +
+				`"mixed" ("boundary" "herolauncher-50b0ab48e6f15281f61c6774") NIL NIL NIL))`,
+		}, "OK"},
+		{"FETCH 2 (BODY)", []string{
+			`* 2 FETCH (BODY (("text" "plain" ("charset" "utf-8") NIL NIL "7bit" 14 1) ` +
+				`("text" "plain" ("name" "report.txt") NIL NIL "base64" 8 1) ("image" "png" ("name" "logo.png") NIL NIL "base64" 12) "mixed"))`,
+		}, "OK"},
+		// Parts are fetched by their number, and parts that do not exist are
+		// NIL
+		{"FETCH 2 (BODY.PEEK[1] BODY.PEEK[2] BODY.PEEK[2.MIME] BODY.PEEK[4])", []string{
+			"* 2 FETCH (BODY[1] {14}",
+			"See the report BODY[2] {8}",
+			"aGVsbG8= BODY[2.MIME] {134}",
+			"Content-Type: text/plain; name=report.txt",
+			"Content-Disposition: attachment; filename=report.txt",
+			"Content-Transfer-Encoding: base64",
+			"",
+			" BODY[4] NIL)",
+		}, "OK"},
+		{"FETCH 2 (RFC822.SIZE BODY.PEEK[HEADER])", []string{
+			"* 2 FETCH (RFC822.SIZE 723 BODY[HEADER] {178}",
+			"Subject: Report",
+			"Message-Id: <50b0ab48e6f15281f61c6774a0280654@herolauncher>",
+			"Mime-Version: 1.0",
+			"Content-Type: multipart/mixed; boundary=herolauncher-50b0ab48e6f15281f61c6774",
+			"",
+			")",
+		}, "OK"},
+		{"FETCH 1 (RFC822)", []string{
+			"* 1 FETCH (RFC822 {200}",
+			"From: bob@example.com",
+			"Subject: Plain",
+			"Message-Id: <f7ff9e8b7bb2e09b70935a5d785e0cc5@herolauncher>",
+			"Mime-Version: 1.0",
+			"Content-Type: text/plain; charset=utf-8",
+			"Content-Transfer-Encoding: 7bit",
+			"",
+			"Hello FLAGS (\\Seen))",
+		}, "OK"},
+	})
+}
+
+func TestOffloadedAttachments(t *testing.T) {
+	s := newTestServer(t)
+	fs, err := vfsdb.NewFromPath(filepath.Join(t.TempDir(), "attachments"))
+	if err != nil {
+		t.Fatalf("Failed to create VFS: %v", err)
+	}
+	store := mailblob.NewStore(fs, 16)
+	s.server.SetAttachmentStore(store)
+
+	data := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("report ", 10)))
+	email := &mail.Email{Message: "See the report", Attachments: []mail.Attachment{{Filename: "report.txt", ContentType: "text/plain", Data: data}}}
+	if err := store.Offload("alice", email); err != nil || email.Attachments[0].Data != "" {
+		t.Fatalf("Expected the attachment to be offloaded, got %v", err)
+	}
+	s.store("inbox", 1, email)
+
+	// The attachments are read from the store to render the message, with
+	// the base64 in lines of 76 characters
+	c := s.login()
+	c.run("SELECT INBOX")
+	untagged, status := c.run("FETCH 1 (BODY.PEEK[2])")
+	if !strings.HasPrefix(status, "OK") || len(untagged) != 3 || untagged[0] != "* 1 FETCH (BODY[2] {98}" || strings.Join(untagged[1:], "") != data+")" {
+		t.Errorf("Expected the data of the attachment, got %q, %s", untagged, status)
+	}
+}
+
+func TestSpecialUse(t *testing.T) {
+	s := newTestServer(t)
+	s.store("inbox", 1, &mail.Email{Message: "Hello", Envelope: &mail.Envelope{Subject: "One"}})
+
+	c := s.login()
+	c.runSteps([]step{
+		// The standard mailboxes get their attribute by name, others by
+		// CREATE with USE
+		{"CREATE Sent", nil, "OK"},
+		{"CREATE Trash", nil, "OK"},
+		{`CREATE "Old Mail" (USE (\archive))`, nil, "OK"},
+		{`CREATE Both (USE (\Sent \Trash))`, nil, "NO [USEATTR]"},
+		{`CREATE Other (USE (\Flagged))`, nil, "NO [USEATTR]"},
+		{`CREATE Params (SIZE 10)`, nil, "BAD"},
+		{`LIST "" *`, []string{
+			`* LIST (\Inbox \HasNoChildren) "/" inbox`,
+			`* LIST (\Archive \HasNoChildren) "/" "old mail"`,
+			`* LIST (\Sent \HasNoChildren) "/" "sent"`,
+			`* LIST (\Trash \HasNoChildren) "/" "trash"`,
+		}, "OK"},
+		{`LIST (SPECIAL-USE) "" *`, []string{
+			`* LIST (\Archive \HasNoChildren) "/" "old mail"`,
+			`* LIST (\Sent \HasNoChildren) "/" "sent"`,
+			`* LIST (\Trash \HasNoChildren) "/" "trash"`,
+		}, "OK"},
+		{`LIST "" ("s*" "t*") RETURN (SPECIAL-USE)`, []string{
+			`* LIST (\Sent \HasNoChildren) "/" "sent"`,
+			`* LIST (\Trash \HasNoChildren) "/" "trash"`,
+		}, "OK"},
+		{`LIST "" ""`, []string{`* LIST (\Noselect) "/" ""`}, "OK"},
+
+		// The special use follows a renamed mailbox, and is dropped with a
+		// deleted one
+		{`RENAME "Old Mail" Archived`, nil, "OK"},
+		{"DELETE Trash", nil, "OK"},
+		{"CREATE Trash", nil, "OK"},
+		{`LIST (SPECIAL-USE) "" *`, []string{
+			`* LIST (\Archive \HasNoChildren) "/" "archived"`,
+			`* LIST (\Sent \HasNoChildren) "/" "sent"`,
+			`* LIST (\Trash \HasNoChildren) "/" "trash"`,
+		}, "OK"},
+
+		// Selection and return options of LIST-EXTENDED, where subscribed
+		// mailboxes are listed even after they were deleted
+		{"CREATE projects/2024", nil, "OK"},
+		{"SUBSCRIBE projects/2024", nil, "OK"},
+		{"CREATE gone", nil, "OK"},
+		{"SUBSCRIBE gone", nil, "OK"},
+		{"DELETE gone", nil, "OK"},
+		{`LIST (SUBSCRIBED) "" *`, []string{
+			`* LIST (\NonExistent \Subscribed) "/" "gone"`,
+			`* LIST (\HasNoChildren \Subscribed) "/" "projects/2024"`,
+		}, "OK"},
+		{`LIST (SUBSCRIBED RECURSIVEMATCH) "" projects`, []string{
+			`* LIST (\HasChildren) "/" "projects" ("CHILDINFO" ("SUBSCRIBED"))`,
+		}, "OK"},
+		{`LIST "" projects* RETURN (SUBSCRIBED CHILDREN)`, []string{
+			`* LIST (\HasChildren) "/" "projects"`,
+			`* LIST (\HasNoChildren \Subscribed) "/" "projects/2024"`,
+		}, "OK"},
+		{`LIST (RECURSIVEMATCH) "" *`, nil, "BAD RECURSIVEMATCH requires SUBSCRIBED"},
+		{`LIST (NOPE) "" *`, nil, "BAD Unknown LIST selection option NOPE"},
+		{`LIST "" * RETURN (NOPE)`, nil, "BAD Unknown LIST return option NOPE"},
+	})
+
+	untagged, _ := c.run("CAPABILITY")
+	if len(untagged) != 1 || !strings.Contains(untagged[0], " SPECIAL-USE CREATE-SPECIAL-USE LIST-EXTENDED ") {
+		t.Errorf("Expected the extensions to be advertised, got %q", untagged)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	registry := metrics.NewRegistry()
+	s := newTestServer(t, func(server *Server) {
+		server.SetLimits(Limits{MaxConnsPerIP: 2, MaxConnsPerUser: 1})
+		server.EnableMetrics(registry)
+	})
+	m := s.server.metrics
+
+	// The user can log in on one connection, and the address can open two
+	first := s.login()
+	second, greeting := s.dial()
+	if !strings.HasPrefix(greeting, "* OK") {
+		t.Fatalf("Expected the second connection to be accepted, got %q", greeting)
+	}
+	if _, status := second.run("LOGIN alice secret"); !strings.HasPrefix(status, "NO [LIMIT] Too many connections for this user") {
+		t.Errorf("Expected the second login to be refused, got %q", status)
+	}
+	if _, greeting := s.dial(); greeting != "* BYE Too many connections from your address" {
+		t.Errorf("Expected the third connection to be refused, got %q", greeting)
+	}
+
+	// Logging out ends the login of the user
+	first.run("LOGOUT")
+	if _, status := second.run("LOGIN alice secret"); !strings.HasPrefix(status, "OK") {
+		t.Errorf("Expected the login to be accepted after the logout, got %q", status)
+	}
+	second.run("NOOP")
+
+	tests := []struct {
+		name   string
+		metric *metrics.Metric
+		labels []string
+		want   int64
+	}{
+		{"logins", m.commands, []string{"LOGIN"}, 3},
+		{"refused logins", m.errors, []string{"LOGIN", "NO"}, 1},
+		{"NOOPs", m.commands, []string{"NOOP"}, 1},
+		{"connections refused by address", m.rejected, []string{"ip"}, 1},
+		{"logins refused by user", m.rejected, []string{"user"}, 1},
+	}
+	for _, test := range tests {
+		if got := test.metric.Value(test.labels...); got != test.want {
+			t.Errorf("Expected %d %s, got %d", test.want, test.name, got)
+		}
+	}
+	if m.bytesReceived.Value() == 0 || m.bytesSent.Value() == 0 {
+		t.Errorf("Expected the traffic to be counted, got %d received and %d sent", m.bytesReceived.Value(), m.bytesSent.Value())
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	registry := metrics.NewRegistry()
+	s := newTestServer(t, func(server *Server) {
+		server.SetLimits(Limits{MaxConnsPerUser: 1, IdleTimeout: 200 * time.Millisecond})
+		server.EnableMetrics(registry)
+	})
+	m := s.server.metrics
+
+	c := s.login()
+	if got := m.connections.Value(); got != 1 {
+		t.Errorf("Expected 1 open connection, got %d", got)
+	}
+
+	// Idle connections are closed quietly, which ends the login of the user
+	start := time.Now()
+	if line, err := c.text.ReadLine(); err == nil {
+		t.Errorf("Expected the idle connection to be closed, got %q", line)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the idle timeout to close the connection, took %v", elapsed)
+	}
+	for i := 0; i < 100 && m.connections.Value() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := m.connections.Value(); got != 0 {
+		t.Errorf("Expected no open connections, got %d", got)
+	}
+	if got := m.idleTimeouts.Value(); got != 1 {
+		t.Errorf("Expected 1 idle timeout, got %d", got)
+	}
+	s.login()
+}
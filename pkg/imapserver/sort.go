@@ -0,0 +1,319 @@
+package imapserver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/server"
+)
+
+// sortThread implements the SORT and THREAD extensions (RFC 5256), so
+// clients can show sorted and threaded views without fetching every
+// envelope. THREAD supports the REFERENCES algorithm.
+type sortThread struct{}
+
+// Capabilities advertises SORT and THREAD once the client logged in
+func (sortThread) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{"SORT", "THREAD=REFERENCES"}
+	}
+	return nil
+}
+
+// Command returns the handlers of SORT and THREAD
+func (sortThread) Command(name string) server.HandlerFactory {
+	switch name {
+	case "SORT":
+		return func() server.Handler { return &sortCmd{} }
+	case "THREAD":
+		return func() server.Handler { return &threadCmd{} }
+	}
+	return nil
+}
+
+// sortItem is a message that matched the search of SORT or THREAD
+type sortItem struct {
+	seqNum uint32
+	msg    *Message
+}
+
+// id returns the UID or the sequence number of the message
+func (item *sortItem) id(uid bool) uint32 {
+	if uid {
+		return item.msg.Uid
+	}
+	return item.seqNum
+}
+
+// sentDate returns when the message was sent, or when it arrived if it
+// has no date
+func (item *sortItem) sentDate() int64 {
+	if date := item.msg.Email.Date(); date != 0 {
+		return date
+	}
+	return item.msg.Email.InternalDate
+}
+
+// matchingMessages returns the messages matching the criteria, in mailbox
+// order
+func (m *Mailbox) matchingMessages(criteria *imap.SearchCriteria) ([]sortItem, error) {
+	if err := m.loadMessages(); err != nil {
+		return nil, err
+	}
+	match, err := m.matcher(criteria)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []sortItem
+	for i, msg := range m.messages {
+		seqNum := uint32(i + 1)
+		if match(seqNum, msg) {
+			items = append(items, sortItem{seqNum: seqNum, msg: msg})
+		}
+	}
+	return items, nil
+}
+
+// parseSearch parses the charset and the search criteria that follow the
+// arguments of SORT and THREAD
+func parseSearch(search *commands.Search, fields []interface{}) error {
+	if len(fields) < 2 {
+		return errors.New("Missing search criteria")
+	}
+	if _, ok := fields[0].(string); !ok {
+		return errors.New("Charset must be a string")
+	}
+	return search.Parse(append([]interface{}{"CHARSET"}, fields...))
+}
+
+// sortKey is one of the sort criteria of SORT
+type sortKey struct {
+	name    string
+	reverse bool
+}
+
+// sortKeys are the supported sort criteria
+var sortKeys = map[string]bool{
+	"ARRIVAL": true, "CC": true, "DATE": true, "FROM": true,
+	"SIZE": true, "SUBJECT": true, "TO": true,
+}
+
+// sortCmd is SORT, searching messages and returning them in order
+type sortCmd struct {
+	commands.Search
+	Keys []sortKey
+}
+
+// Parse reads the sort criteria, the charset and the search criteria
+func (cmd *sortCmd) Parse(fields []interface{}) error {
+	if len(fields) < 1 {
+		return errors.New("No enough arguments")
+	}
+	list, ok := fields[0].([]interface{})
+	if !ok || len(list) == 0 {
+		return errors.New("Sort criteria must be a non-empty list")
+	}
+
+	reverse := false
+	for _, field := range list {
+		name, _ := field.(string)
+		name = strings.ToUpper(name)
+		if name == "REVERSE" && !reverse {
+			reverse = true
+			continue
+		}
+		if !sortKeys[name] {
+			return fmt.Errorf("Unsupported sort criterion %v", field)
+		}
+		cmd.Keys = append(cmd.Keys, sortKey{name: name, reverse: reverse})
+		reverse = false
+	}
+	if reverse {
+		return errors.New("REVERSE must be followed by a sort criterion")
+	}
+
+	return parseSearch(&cmd.Search, fields[1:])
+}
+
+// Handle sorts by sequence numbers
+func (cmd *sortCmd) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+// UidHandle sorts by UIDs
+func (cmd *sortCmd) UidHandle(conn server.Conn) error {
+	return cmd.handle(true, conn)
+}
+
+func (cmd *sortCmd) handle(uid bool, conn server.Conn) error {
+	mailbox, err := selectedMailbox(conn)
+	if err != nil {
+		return err
+	}
+	items, err := mailbox.matchingMessages(cmd.Criteria)
+	if err != nil {
+		return err
+	}
+
+	// The values to compare by, per message and sort key
+	values := make(map[uint32][]sortValue, len(items))
+	for i := range items {
+		item := &items[i]
+		for _, key := range cmd.Keys {
+			values[item.seqNum] = append(values[item.seqNum], item.sortValue(key.name))
+		}
+	}
+
+	// Messages that compare equal stay in mailbox order
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := values[items[i].seqNum], values[items[j].seqNum]
+		for k, key := range cmd.Keys {
+			c := a[k].compare(b[k])
+			if key.reverse {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	fields := []interface{}{imap.RawString("SORT")}
+	for i := range items {
+		fields = append(fields, items[i].id(uid))
+	}
+	return conn.WriteResp(imap.NewUntaggedResp(fields))
+}
+
+// sortValue is the value of a message for a sort key, a number or a string
+type sortValue struct {
+	n int64
+	s string
+}
+
+// compare returns -1, 0 or 1 if v sorts before, with or after other
+func (v sortValue) compare(other sortValue) int {
+	switch {
+	case v.n < other.n:
+		return -1
+	case v.n > other.n:
+		return 1
+	}
+	return strings.Compare(v.s, other.s)
+}
+
+// sortValue returns the value of the message for a sort key
+func (item *sortItem) sortValue(key string) sortValue {
+	email := item.msg.Email
+	switch key {
+	case "ARRIVAL":
+		return sortValue{n: email.InternalDate}
+	case "DATE":
+		return sortValue{n: item.sentDate()}
+	case "SIZE":
+		raw, _ := item.msg.rfc822()
+		return sortValue{n: int64(len(raw))}
+	case "SUBJECT":
+		subject, _ := baseSubject(email.Subject())
+		return sortValue{s: subject}
+	}
+
+	if email.Envelope == nil {
+		return sortValue{}
+	}
+	var addresses []string
+	switch key {
+	case "FROM":
+		addresses = email.Envelope.From
+	case "TO":
+		addresses = email.Envelope.To
+	case "CC":
+		addresses = email.Envelope.Cc
+	}
+	for _, address := range addresses {
+		if address = strings.TrimSpace(address); address != "" {
+			return sortValue{s: strings.ToLower(parseAddress(address).MailboxName)}
+		}
+	}
+	return sortValue{}
+}
+
+// baseSubject returns the subject without reply and forward markers, in
+// lower case, and whether it had any (RFC 5256 section 2.1)
+func baseSubject(subject string) (string, bool) {
+	s := strings.Join(strings.Fields(subject), " ")
+	isReply := false
+	for {
+		// Remove trailing "(fwd)"
+		for {
+			trimmed := strings.TrimRight(s, " ")
+			if !strings.HasSuffix(strings.ToLower(trimmed), "(fwd)") {
+				s = trimmed
+				break
+			}
+			s = trimmed[:len(trimmed)-len("(fwd)")]
+			isReply = true
+		}
+
+		// Remove leading "Re:", "Fw:", "Fwd:" with an optional [blob], and
+		// leading [blobs] that are not the whole subject
+		for {
+			s = strings.TrimLeft(s, " ")
+			if rest, ok := trimReplyPrefix(s); ok {
+				s = rest
+				isReply = true
+				continue
+			}
+			if rest, ok := trimBlob(s); ok && rest != "" {
+				s = rest
+				continue
+			}
+			break
+		}
+
+		// Unwrap "[fwd: ...]" and start over
+		if len(s) > len("[fwd:") && strings.EqualFold(s[:len("[fwd:")], "[fwd:") && strings.HasSuffix(s, "]") {
+			s = s[len("[fwd:") : len(s)-1]
+			isReply = true
+			continue
+		}
+		return strings.ToLower(s), isReply
+	}
+}
+
+// trimReplyPrefix removes a leading "re", "fw" or "fwd" followed by an
+// optional [blob] and a colon
+func trimReplyPrefix(s string) (string, bool) {
+	lower := strings.ToLower(s)
+	for _, prefix := range []string{"re", "fwd", "fw"} {
+		if !strings.HasPrefix(lower, prefix) {
+			continue
+		}
+		rest := strings.TrimLeft(s[len(prefix):], " ")
+		if trimmed, ok := trimBlob(rest); ok {
+			rest = trimmed
+		}
+		if strings.HasPrefix(rest, ":") {
+			return rest[1:], true
+		}
+	}
+	return s, false
+}
+
+// trimBlob removes a leading "[...]" and the spaces after it
+func trimBlob(s string) (string, bool) {
+	if !strings.HasPrefix(s, "[") {
+		return s, false
+	}
+	end := strings.IndexAny(s[1:], "[]")
+	if end < 0 || s[1+end] != ']' {
+		return s, false
+	}
+	return strings.TrimLeft(s[end+2:], " "), true
+}
@@ -0,0 +1,94 @@
+package imapserver
+
+import (
+	"testing"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/mail"
+)
+
+func TestBaseSubject(t *testing.T) {
+	tests := []struct {
+		subject string
+		base    string
+		isReply bool
+	}{
+		{"Meeting", "meeting", false},
+		{"  Weekly   meeting  ", "weekly meeting", false},
+		{"Re: Meeting", "meeting", true},
+		{"RE: re: Meeting", "meeting", true},
+		{"Fwd: Meeting", "meeting", true},
+		{"Fw: Meeting", "meeting", true},
+		{"Re [team]: Meeting", "meeting", true},
+		{"[team] Re: Meeting", "meeting", true},
+		{"Re: [team] Meeting", "meeting", true},
+		{"[team] [dev] Meeting", "meeting", false},
+		{"Meeting (fwd)", "meeting", true},
+		{"Meeting (FWD) (fwd)", "meeting", true},
+		{"[Fwd: Meeting]", "meeting", true},
+		{"[fwd: Re: Meeting]", "meeting", true},
+		{"Re: [Fwd: [team] Meeting] (fwd)", "meeting", true},
+		// A blob that is the whole subject is kept
+		{"[team]", "[team]", false},
+		{"Re: [team]", "[team]", true},
+		// Words that merely start like a prefix are not one
+		{"Reply: Meeting", "reply: meeting", false},
+		{"Fwdx: Meeting", "fwdx: meeting", false},
+		{"Re:", "", true},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		base, isReply := baseSubject(test.subject)
+		if base != test.base || isReply != test.isReply {
+			t.Errorf("%q: expected %q, %v, got %q, %v", test.subject, test.base, test.isReply, base, isReply)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	s := newTestServer(t)
+	messages := []struct {
+		uid     uint32
+		subject string
+		from    string
+		date    int64
+		arrival int64
+		body    string
+	}{
+		{10, "Re: Budget", "Carol <carol@example.com>", 300, 1000, "short"},
+		{20, "agenda", "alice@example.com", 100, 3000, "a somewhat longer body"},
+		{30, "[team] Budget", "bob@example.com", 200, 2000, "a much, much longer body than the others"},
+		{40, "Fwd: Agenda", "alice@example.com", 0, 1500, "mid-sized body"},
+	}
+	for _, m := range messages {
+		s.store("inbox", m.uid, &mail.Email{
+			Message:      m.body,
+			InternalDate: m.arrival,
+			Envelope:     &mail.Envelope{Subject: m.subject, From: []string{m.from}, Date: m.date},
+		})
+	}
+	c := s.login()
+	c.run("SELECT INBOX")
+
+	c.runSteps([]step{
+		{"SORT (ARRIVAL) UTF-8 ALL", []string{"* SORT 1 4 3 2"}, "OK"},
+		{"SORT (REVERSE ARRIVAL) UTF-8 ALL", []string{"* SORT 2 3 4 1"}, "OK"},
+		// Messages without a date sort by their arrival
+		{"SORT (DATE) UTF-8 ALL", []string{"* SORT 2 3 1 4"}, "OK"},
+		// The base subject, then the sent date for equal subjects
+		{"SORT (SUBJECT DATE) UTF-8 ALL", []string{"* SORT 2 4 3 1"}, "OK"},
+		{"SORT (SUBJECT REVERSE DATE) UTF-8 ALL", []string{"* SORT 4 2 1 3"}, "OK"},
+		{"SORT (REVERSE SUBJECT DATE) UTF-8 ALL", []string{"* SORT 3 1 2 4"}, "OK"},
+		// Equal keys stay in mailbox order
+		{"SORT (FROM) UTF-8 ALL", []string{"* SORT 2 4 3 1"}, "OK"},
+		{"UID SORT (REVERSE FROM) UTF-8 ALL", []string{"* SORT 10 30 20 40"}, "OK"},
+		{"SORT (SIZE) UTF-8 ALL", []string{"* SORT 1 4 2 3"}, "OK"},
+		// Only the messages that match the search are sorted
+		{"SORT (ARRIVAL) UTF-8 FROM alice", []string{"* SORT 4 2"}, "OK"},
+		{"SORT (ARRIVAL) UTF-8 SUBJECT nothing", []string{"* SORT"}, "OK"},
+		{"SORT (REVERSE) UTF-8 ALL", nil, "BAD"},
+		{"SORT (REVERSE REVERSE DATE) UTF-8 ALL", nil, "BAD"},
+		{"SORT (COLOR) UTF-8 ALL", nil, "BAD"},
+		{"SORT () UTF-8 ALL", nil, "BAD"},
+		{"SORT (DATE) UTF-8", nil, "BAD"},
+	})
+}
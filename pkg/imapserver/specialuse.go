@@ -0,0 +1,411 @@
+package imapserver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/server"
+	"github.com/emersion/go-imap/utf7"
+	"github.com/redis/go-redis/v9"
+)
+
+// Special-use attributes (RFC 6154) tell clients which mailbox holds sent
+// mail, drafts and so on. The standard mailboxes get them by name, other
+// mailboxes can be given one with CREATE name (USE (\Sent)), which is
+// recorded in the mail:specialuse:<username> hash.
+
+// Special-use attributes of mailboxes
+const (
+	archiveAttr = "\\Archive"
+	draftsAttr  = "\\Drafts"
+	junkAttr    = "\\Junk"
+	sentAttr    = "\\Sent"
+	trashAttr   = "\\Trash"
+)
+
+// Attributes of the extended LIST responses (RFC 5258)
+const (
+	subscribedAttr  = "\\Subscribed"
+	nonExistentAttr = "\\NonExistent"
+)
+
+// codeUseAttr is the response code for an unsupported special-use attribute
+const codeUseAttr imap.StatusRespCode = "USEATTR"
+
+// specialUseAttrs are the special-use attributes that can be assigned
+var specialUseAttrs = []string{archiveAttr, draftsAttr, junkAttr, sentAttr, trashAttr}
+
+// defaultSpecialUses are the special uses of mailboxes by name
+var defaultSpecialUses = map[string]string{
+	"archive":       archiveAttr,
+	"drafts":        draftsAttr,
+	"junk":          junkAttr,
+	"spam":          junkAttr,
+	"sent":          sentAttr,
+	"sent items":    sentAttr,
+	"sent messages": sentAttr,
+	"trash":         trashAttr,
+	"deleted items": trashAttr,
+}
+
+// specialUseKey returns the hash of the special uses assigned by a user
+func specialUseKey(username string) string {
+	return fmt.Sprintf("mail:specialuse:%s", username)
+}
+
+// canonicalSpecialUse returns the canonical form of a special-use
+// attribute, or "" if it is not supported
+func canonicalSpecialUse(attr string) string {
+	for _, use := range specialUseAttrs {
+		if strings.EqualFold(use, attr) {
+			return use
+		}
+	}
+	return ""
+}
+
+// specialUse returns the special-use attribute of a mailbox, or "" if it
+// has none
+func (u *User) specialUse(name string) (string, error) {
+	use, err := u.backend.redisClient.HGet(u.backend.ctx, specialUseKey(u.username), name).Result()
+	if err == redis.Nil {
+		return defaultSpecialUses[name], nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get special use of %s: %w", name, err)
+	}
+	return use, nil
+}
+
+// setSpecialUse assigns a special-use attribute to a mailbox
+func (u *User) setSpecialUse(name, use string) error {
+	if err := u.backend.redisClient.HSet(u.backend.ctx, specialUseKey(u.username), name, use).Err(); err != nil {
+		return fmt.Errorf("failed to set special use of %s: %w", name, err)
+	}
+	return nil
+}
+
+// specialUse implements the SPECIAL-USE and CREATE-SPECIAL-USE extensions
+// (RFC 6154) and LIST-EXTENDED (RFC 5258), so clients find the mailboxes
+// for sent mail, drafts, trash, junk and archive by their attributes
+type specialUse struct{}
+
+// Capabilities advertises the extensions once the client logged in
+func (specialUse) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{"SPECIAL-USE", "CREATE-SPECIAL-USE", "LIST-EXTENDED"}
+	}
+	return nil
+}
+
+// Command returns the handlers replacing the builtin LIST and CREATE
+func (specialUse) Command(name string) server.HandlerFactory {
+	switch name {
+	case "LIST":
+		return func() server.Handler { return &listExtended{} }
+	case "CREATE":
+		return func() server.Handler { return &createSpecialUse{} }
+	}
+	return nil
+}
+
+// parseMailboxName parses and decodes a mailbox name sent by a client
+func parseMailboxName(field interface{}) (string, error) {
+	name, err := imap.ParseString(field)
+	if err != nil {
+		return "", err
+	}
+	if name, err = utf7.Encoding.NewDecoder().String(name); err != nil {
+		return "", err
+	}
+	return imap.CanonicalMailboxName(name), nil
+}
+
+// parseOptions parses a list of options to upper case
+func parseOptions(field interface{}) ([]string, error) {
+	list, ok := field.([]interface{})
+	if !ok {
+		return nil, errors.New("Options must be a list")
+	}
+	options := make([]string, 0, len(list))
+	for _, item := range list {
+		option, ok := item.(string)
+		if !ok {
+			return nil, errors.New("Option must be an atom")
+		}
+		options = append(options, strings.ToUpper(option))
+	}
+	return options, nil
+}
+
+// createSpecialUse is CREATE with the (USE (attributes)) parameter
+type createSpecialUse struct {
+	Mailbox string
+	Use     []string
+}
+
+// Parse reads the mailbox and the special-use attributes
+func (cmd *createSpecialUse) Parse(fields []interface{}) error {
+	if len(fields) < 1 {
+		return errors.New("No enough arguments")
+	}
+	mailbox, err := parseMailboxName(fields[0])
+	if err != nil {
+		return err
+	}
+	cmd.Mailbox = mailbox
+	if len(fields) < 2 {
+		return nil
+	}
+
+	params, ok := fields[1].([]interface{})
+	if !ok || len(params) != 2 {
+		return errors.New("Invalid CREATE parameters")
+	}
+	if name, _ := params[0].(string); !strings.EqualFold(name, "USE") {
+		return fmt.Errorf("Unknown CREATE parameter %v", params[0])
+	}
+	cmd.Use, err = parseOptions(params[1])
+	return err
+}
+
+// Handle creates the mailbox and assigns the special use
+func (cmd *createSpecialUse) Handle(conn server.Conn) error {
+	ctx := conn.Context()
+	if ctx.User == nil {
+		return server.ErrNotAuthenticated
+	}
+	user, ok := ctx.User.(*User)
+	if !ok {
+		return errors.New("SPECIAL-USE is not supported by this user")
+	}
+
+	var use string
+	for _, attr := range cmd.Use {
+		canonical := canonicalSpecialUse(attr)
+		if canonical == "" || (use != "" && use != canonical) {
+			return server.ErrStatusResp(&imap.StatusResp{
+				Type: imap.StatusRespNo,
+				Code: codeUseAttr,
+				Info: "Only one of " + strings.Join(specialUseAttrs, " ") + " can be used",
+			})
+		}
+		use = canonical
+	}
+
+	if err := user.CreateMailbox(cmd.Mailbox); err != nil {
+		return err
+	}
+	if use == "" {
+		return nil
+	}
+	return user.setSpecialUse(normalizeMailboxName(cmd.Mailbox), use)
+}
+
+// listExtended is LIST with the selection and return options of
+// LIST-EXTENDED: LIST (SUBSCRIBED RECURSIVEMATCH) "" "*" RETURN (CHILDREN)
+type listExtended struct {
+	Selection []string
+	Reference string
+	Patterns  []string
+	Return    []string
+}
+
+// Parse reads the options, the reference and the patterns
+func (cmd *listExtended) Parse(fields []interface{}) error {
+	if len(fields) > 0 {
+		if _, ok := fields[0].([]interface{}); ok {
+			selection, err := parseOptions(fields[0])
+			if err != nil {
+				return err
+			}
+			cmd.Selection = selection
+			fields = fields[1:]
+		}
+	}
+	if len(fields) < 2 {
+		return errors.New("No enough arguments")
+	}
+
+	reference, err := parseMailboxName(fields[0])
+	if err != nil {
+		return err
+	}
+	cmd.Reference = reference
+
+	patterns := []interface{}{fields[1]}
+	if list, ok := fields[1].([]interface{}); ok {
+		patterns = list
+	}
+	for _, field := range patterns {
+		pattern, err := parseMailboxName(field)
+		if err != nil {
+			return err
+		}
+		cmd.Patterns = append(cmd.Patterns, pattern)
+	}
+
+	if len(fields) > 2 {
+		if name, _ := fields[2].(string); !strings.EqualFold(name, "RETURN") || len(fields) < 4 {
+			return errors.New("Invalid LIST return options")
+		}
+		if cmd.Return, err = parseOptions(fields[3]); err != nil {
+			return err
+		}
+	}
+
+	for _, option := range cmd.Selection {
+		switch option {
+		case "SUBSCRIBED", "REMOTE", "RECURSIVEMATCH", "SPECIAL-USE":
+		default:
+			return fmt.Errorf("Unknown LIST selection option %s", option)
+		}
+	}
+	if contains(cmd.Selection, "RECURSIVEMATCH") && !contains(cmd.Selection, "SUBSCRIBED") {
+		return errors.New("RECURSIVEMATCH requires SUBSCRIBED")
+	}
+	for _, option := range cmd.Return {
+		switch option {
+		case "SUBSCRIBED", "CHILDREN", "SPECIAL-USE":
+		default:
+			return fmt.Errorf("Unknown LIST return option %s", option)
+		}
+	}
+	return nil
+}
+
+// match checks if a mailbox name matches any of the patterns. Mailbox names
+// are stored in lower case, so they match case-insensitively.
+func (cmd *listExtended) match(name string) bool {
+	info := &imap.MailboxInfo{Delimiter: mailboxDelimiter, Name: name}
+	for _, pattern := range cmd.Patterns {
+		if info.Match(strings.ToLower(cmd.Reference), strings.ToLower(pattern)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle lists the mailboxes
+func (cmd *listExtended) Handle(conn server.Conn) error {
+	ctx := conn.Context()
+	if ctx.User == nil {
+		return server.ErrNotAuthenticated
+	}
+	user, ok := ctx.User.(*User)
+	if !ok {
+		return errors.New("LIST-EXTENDED is not supported by this user")
+	}
+
+	// An empty pattern asks for the hierarchy delimiter
+	if len(cmd.Patterns) == 1 && cmd.Patterns[0] == "" {
+		return conn.WriteResp(imap.NewUntaggedResp([]interface{}{
+			imap.RawString("LIST"), []interface{}{imap.RawString(imap.NoSelectAttr)}, mailboxDelimiter, "",
+		}))
+	}
+
+	selectSubscribed := contains(cmd.Selection, "SUBSCRIBED")
+	recursive := contains(cmd.Selection, "RECURSIVEMATCH")
+	selectSpecialUse := contains(cmd.Selection, "SPECIAL-USE")
+	returnSubscribed := selectSubscribed || contains(cmd.Return, "SUBSCRIBED")
+
+	mailboxes, err := user.ListMailboxes(false)
+	if err != nil {
+		return err
+	}
+	infos := make(map[string]*imap.MailboxInfo, len(mailboxes))
+	for _, mbox := range mailboxes {
+		info, err := mbox.Info()
+		if err != nil {
+			return err
+		}
+		infos[info.Name] = info
+	}
+
+	subscribed := make(map[string]bool)
+	if returnSubscribed {
+		names, err := user.subscribedMailboxes()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			subscribed[name] = true
+		}
+	}
+
+	// Subscribed mailboxes are listed even if they no longer exist
+	if selectSubscribed {
+		for name := range subscribed {
+			if infos[name] == nil {
+				infos[name] = &imap.MailboxInfo{
+					Attributes: []string{nonExistentAttr},
+					Delimiter:  mailboxDelimiter,
+					Name:       name,
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(infos))
+	for name := range infos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		info := infos[name]
+		if !cmd.match(name) {
+			continue
+		}
+		if selectSpecialUse && !hasSpecialUse(info.Attributes) {
+			continue
+		}
+
+		// With RECURSIVEMATCH, mailboxes that are not subscribed are listed
+		// when mailboxes nested in them are
+		var extended []interface{}
+		if recursive && hasSubscribedChild(name, subscribed) {
+			extended = []interface{}{"CHILDINFO", []interface{}{"SUBSCRIBED"}}
+		}
+		if selectSubscribed && !subscribed[name] && extended == nil {
+			continue
+		}
+
+		attributes := info.Attributes
+		if returnSubscribed && subscribed[name] {
+			attributes = append(attributes, subscribedAttr)
+		}
+		fields := []interface{}{imap.RawString("LIST")}
+		fields = append(fields, (&imap.MailboxInfo{Attributes: attributes, Delimiter: info.Delimiter, Name: info.Name}).Format()...)
+		if extended != nil {
+			fields = append(fields, extended)
+		}
+		if err := conn.WriteResp(imap.NewUntaggedResp(fields)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hasSpecialUse checks if mailbox attributes include a special-use attribute
+func hasSpecialUse(attributes []string) bool {
+	for _, attr := range attributes {
+		if canonicalSpecialUse(attr) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSubscribedChild checks if a mailbox nested in name is subscribed
+func hasSubscribedChild(name string, subscribed map[string]bool) bool {
+	for mailbox := range subscribed {
+		if strings.HasPrefix(mailbox, name+mailboxDelimiter) {
+			return true
+		}
+	}
+	return false
+}
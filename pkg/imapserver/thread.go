@@ -0,0 +1,293 @@
+package imapserver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/server"
+)
+
+// threadCmd is THREAD, searching messages and returning them as threads
+type threadCmd struct {
+	commands.Search
+	Algorithm string
+}
+
+// Parse reads the algorithm, the charset and the search criteria
+func (cmd *threadCmd) Parse(fields []interface{}) error {
+	if len(fields) < 1 {
+		return errors.New("No enough arguments")
+	}
+	algorithm, _ := fields[0].(string)
+	if !strings.EqualFold(algorithm, "REFERENCES") {
+		return fmt.Errorf("Unsupported threading algorithm %v", fields[0])
+	}
+	cmd.Algorithm = "REFERENCES"
+	return parseSearch(&cmd.Search, fields[1:])
+}
+
+// Handle threads by sequence numbers
+func (cmd *threadCmd) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+// UidHandle threads by UIDs
+func (cmd *threadCmd) UidHandle(conn server.Conn) error {
+	return cmd.handle(true, conn)
+}
+
+func (cmd *threadCmd) handle(uid bool, conn server.Conn) error {
+	mailbox, err := selectedMailbox(conn)
+	if err != nil {
+		return err
+	}
+	items, err := mailbox.matchingMessages(cmd.Criteria)
+	if err != nil {
+		return err
+	}
+
+	fields := []interface{}{imap.RawString("THREAD")}
+	var threads strings.Builder
+	for _, root := range threadReferences(items) {
+		threads.WriteString("(" + root.format(uid) + ")")
+	}
+	if threads.Len() > 0 {
+		fields = append(fields, imap.RawString(threads.String()))
+	}
+	return conn.WriteResp(imap.NewUntaggedResp(fields))
+}
+
+// threadNode is a message in a thread, or a dummy holding messages that
+// belong together but of which the parent is missing
+type threadNode struct {
+	item     *sortItem
+	parent   *threadNode
+	children []*threadNode
+}
+
+// setParent makes n a child of parent
+func (n *threadNode) setParent(parent *threadNode) {
+	if n.parent != nil {
+		siblings := n.parent.children
+		for i, sibling := range siblings {
+			if sibling == n {
+				n.parent.children = append(siblings[:i:i], siblings[i+1:]...)
+				break
+			}
+		}
+	}
+	n.parent = parent
+	parent.children = append(parent.children, n)
+}
+
+// first returns the message that represents the node: its own, or that
+// of its first child for a dummy
+func (n *threadNode) first() *sortItem {
+	for n.item == nil {
+		n = n.children[0]
+	}
+	return n.item
+}
+
+// format writes the node and its children as in the THREAD response: a
+// message with a single reply is followed by that reply, multiple replies
+// are each put between parentheses
+func (n *threadNode) format(uid bool) string {
+	var parts []string
+	if n.item != nil {
+		parts = append(parts, strconv.FormatUint(uint64(n.item.id(uid)), 10))
+		if len(n.children) == 1 {
+			return strings.Join(append(parts, n.children[0].format(uid)), " ")
+		}
+	}
+	var nested strings.Builder
+	for _, child := range n.children {
+		nested.WriteString("(" + child.format(uid) + ")")
+	}
+	if nested.Len() > 0 {
+		parts = append(parts, nested.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// messageID returns the first message ID in a header value, without the
+// angle brackets
+func messageID(value string) string {
+	start := strings.Index(value, "<")
+	if start < 0 {
+		return strings.TrimSpace(value)
+	}
+	end := strings.Index(value[start:], ">")
+	if end < 0 {
+		return ""
+	}
+	return strings.TrimSpace(value[start+1 : start+end])
+}
+
+// threadReferences threads messages with the REFERENCES algorithm of RFC
+// 5256. Only In-Reply-To is stored, so it is the single reference of a
+// message.
+func threadReferences(items []sortItem) []*threadNode {
+	// Link the messages to their parents by message ID. Messages without an
+	// ID or with a duplicate one get a node of their own.
+	byID := make(map[string]*threadNode)
+	var nodes []*threadNode
+	for i := range items {
+		item := &items[i]
+		var id, inReplyTo string
+		if envelope := item.msg.Email.Envelope; envelope != nil {
+			id = messageID(envelope.MessageId)
+			inReplyTo = messageID(envelope.InReplyTo)
+		}
+
+		node := byID[id]
+		if id == "" || (node != nil && node.item != nil) {
+			node = &threadNode{}
+			nodes = append(nodes, node)
+		} else if node == nil {
+			node = &threadNode{}
+			byID[id] = node
+			nodes = append(nodes, node)
+		}
+		node.item = item
+
+		if inReplyTo == "" || inReplyTo == id {
+			continue
+		}
+		parent := byID[inReplyTo]
+		if parent == nil {
+			parent = &threadNode{}
+			byID[inReplyTo] = parent
+			nodes = append(nodes, parent)
+		}
+		// Do not link a message below one of its own replies
+		loop := false
+		for p := parent; p != nil; p = p.parent {
+			if p == node {
+				loop = true
+				break
+			}
+		}
+		if !loop {
+			node.setParent(parent)
+		}
+	}
+
+	var roots []*threadNode
+	for _, node := range nodes {
+		if node.parent == nil {
+			roots = append(roots, node)
+		}
+	}
+	roots = pruneThreads(roots, true)
+	sortThreads(roots)
+	roots = groupBySubject(roots)
+	sortThreads(roots)
+	return roots
+}
+
+// pruneThreads removes dummies without messages and replaces dummies by
+// their children, except dummies at the top that hold several threads
+func pruneThreads(nodes []*threadNode, top bool) []*threadNode {
+	var pruned []*threadNode
+	for _, node := range nodes {
+		node.children = pruneThreads(node.children, false)
+		if node.item != nil || (top && len(node.children) > 1) {
+			pruned = append(pruned, node)
+			continue
+		}
+		for _, child := range node.children {
+			child.parent = node.parent
+		}
+		pruned = append(pruned, node.children...)
+	}
+	return pruned
+}
+
+// sortThreads sorts siblings by the date they were sent, a dummy by the
+// date of its first child. Messages sent at the same time stay in mailbox
+// order.
+func sortThreads(nodes []*threadNode) {
+	for _, node := range nodes {
+		sortThreads(node.children)
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		a, b := nodes[i].first(), nodes[j].first()
+		if a.sentDate() != b.sentDate() {
+			return a.sentDate() < b.sentDate()
+		}
+		return a.seqNum < b.seqNum
+	})
+}
+
+// groupBySubject gathers the threads with the same base subject
+func groupBySubject(roots []*threadNode) []*threadNode {
+	subjectOf := func(node *threadNode) (string, bool) {
+		return baseSubject(node.first().msg.Email.Subject())
+	}
+
+	// Pick the thread to gather the others under: a dummy, or else a
+	// message that is not a reply
+	bySubject := make(map[string]*threadNode)
+	for _, root := range roots {
+		subject, isReply := subjectOf(root)
+		if subject == "" {
+			continue
+		}
+		old := bySubject[subject]
+		if old == nil || (old.item != nil && root.item == nil) {
+			bySubject[subject] = root
+		} else if old.item != nil {
+			if _, oldIsReply := subjectOf(old); oldIsReply && !isReply {
+				bySubject[subject] = root
+			}
+		}
+	}
+
+	replaced := make(map[*threadNode]*threadNode)
+	for _, root := range roots {
+		if root.parent != nil {
+			continue
+		}
+		subject, isReply := subjectOf(root)
+		other := bySubject[subject]
+		if subject == "" || other == root {
+			continue
+		}
+
+		_, otherIsReply := subjectOf(other)
+		switch {
+		case other.item == nil && root.item == nil:
+			for _, child := range append([]*threadNode(nil), root.children...) {
+				child.setParent(other)
+			}
+			// The emptied dummy is dropped
+			root.parent = other
+		case other.item == nil:
+			root.setParent(other)
+		case isReply && !otherIsReply:
+			root.setParent(other)
+		default:
+			dummy := &threadNode{}
+			replaced[other] = dummy
+			bySubject[subject] = dummy
+			other.setParent(dummy)
+			root.setParent(dummy)
+		}
+	}
+
+	var grouped []*threadNode
+	for _, root := range roots {
+		if dummy, ok := replaced[root]; ok {
+			grouped = append(grouped, dummy)
+		} else if root.parent == nil {
+			grouped = append(grouped, root)
+		}
+	}
+	return grouped
+}
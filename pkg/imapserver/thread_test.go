@@ -0,0 +1,147 @@
+package imapserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/mail"
+)
+
+// threadMessage is a message to thread, sent at its position in the
+// mailbox unless it has a date
+type threadMessage struct {
+	id, inReplyTo, subject string
+	date                   int64
+}
+
+// formatThreads threads messages and formats the threads as in the THREAD
+// response
+func formatThreads(messages []threadMessage) string {
+	items := make([]sortItem, len(messages))
+	for i, m := range messages {
+		date := m.date
+		if date == 0 {
+			date = int64(i + 1)
+		}
+		email := &mail.Email{Envelope: &mail.Envelope{Subject: m.subject, MessageId: m.id, InReplyTo: m.inReplyTo, Date: date}}
+		items[i] = sortItem{seqNum: uint32(i + 1), msg: &Message{Email: email}}
+	}
+	var threads strings.Builder
+	for _, root := range threadReferences(items) {
+		threads.WriteString("(" + root.format(false) + ")")
+	}
+	return threads.String()
+}
+
+func TestThreadReferences(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []threadMessage
+		want     string
+	}{
+		{"unrelated", []threadMessage{
+			{"<a@x>", "", "One", 0},
+			{"<b@x>", "", "Two", 0},
+		}, "(1)(2)"},
+		{"chain of replies", []threadMessage{
+			{"<a@x>", "", "Plan", 0},
+			{"<b@x>", "<a@x>", "Re: Plan", 0},
+			{"<c@x>", "<b@x>", "Re: Plan", 0},
+		}, "(1 2 3)"},
+		{"several replies", []threadMessage{
+			{"<a@x>", "", "Plan", 0},
+			{"<b@x>", "<a@x>", "Re: Plan", 0},
+			{"<c@x>", "<a@x>", "Re: Plan", 0},
+			{"<d@x>", "<b@x>", "Re: Plan", 0},
+		}, "(1 (2 4)(3))"},
+		{"reply before its parent", []threadMessage{
+			{"<b@x>", "<a@x>", "Re: Plan", 0},
+			{"<a@x>", "", "Plan", 0},
+		}, "(2 1)"},
+		{"replies to a missing message", []threadMessage{
+			{"<b@x>", "<a@x>", "Re: Plan", 0},
+			{"<c@x>", "<a@x>", "Re: Plan", 0},
+		}, "((1)(2))"},
+		{"single reply to a missing message", []threadMessage{
+			{"<b@x>", "<a@x>", "Re: Plan", 0},
+			{"<c@x>", "<b@x>", "Re: Plan", 0},
+		}, "(1 2)"},
+		{"threads sorted by date", []threadMessage{
+			{"<a@x>", "", "Late", 30},
+			{"<b@x>", "", "Early", 10},
+			{"<c@x>", "<b@x>", "Re: Early", 40},
+			{"<d@x>", "<b@x>", "Re: Early", 20},
+		}, "(2 (4)(3))(1)"},
+		{"reply grouped by subject", []threadMessage{
+			{"<a@x>", "", "Plan", 0},
+			{"<b@x>", "", "Re: [team] plan", 0},
+		}, "(1 2)"},
+		{"same subject grouped under a dummy", []threadMessage{
+			{"<a@x>", "", "Plan", 0},
+			{"<b@x>", "", "Other", 0},
+			{"<c@x>", "", "Plan", 0},
+		}, "((1)(3))(2)"},
+		{"replies grouped with the original", []threadMessage{
+			{"<b@x>", "", "Re: Plan", 0},
+			{"<a@x>", "", "Plan", 0},
+			{"<c@x>", "", "Fwd: Plan", 0},
+		}, "(2 (1)(3))"},
+		{"empty subjects are not grouped", []threadMessage{
+			{"<a@x>", "", "", 0},
+			{"<b@x>", "", "Re:", 0},
+		}, "(1)(2)"},
+		{"messages without or with the same ID", []threadMessage{
+			{"", "", "One", 0},
+			{"<a@x>", "", "Two", 0},
+			{"<a@x>", "", "Three", 0},
+		}, "(1)(2)(3)"},
+		{"reference loop", []threadMessage{
+			{"<a@x>", "<b@x>", "One", 0},
+			{"<b@x>", "<a@x>", "Two", 0},
+		}, "(2 1)"},
+		{"reply to itself", []threadMessage{
+			{"<a@x>", "<a@x>", "One", 0},
+		}, "(1)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := formatThreads(test.messages); got != test.want {
+				t.Errorf("Expected %s, got %s", test.want, got)
+			}
+		})
+	}
+}
+
+func TestThread(t *testing.T) {
+	s := newTestServer(t)
+	messages := []struct {
+		uid                    uint32
+		id, inReplyTo, subject string
+	}{
+		{11, "<a@x>", "", "Plan"},
+		{12, "<b@x>", "", "Lunch"},
+		{13, "<c@x>", "<a@x>", "Re: Plan"},
+		{14, "<d@x>", "<a@x>", "Re: Plan"},
+	}
+	for i, m := range messages {
+		s.store("inbox", m.uid, &mail.Email{
+			Message:  "Hello",
+			Envelope: &mail.Envelope{Subject: m.subject, MessageId: m.id, InReplyTo: m.inReplyTo, Date: int64(i + 1)},
+		})
+	}
+	c := s.login()
+	c.run("SELECT INBOX")
+
+	c.runSteps([]step{
+		{"THREAD REFERENCES UTF-8 ALL", []string{"* THREAD (1 (3)(4))(2)"}, "OK"},
+		{"UID THREAD REFERENCES UTF-8 ALL", []string{"* THREAD (11 (13)(14))(12)"}, "OK"},
+		{"THREAD references UTF-8 SUBJECT lunch", []string{"* THREAD (2)"}, "OK"},
+		{"THREAD REFERENCES UTF-8 SUBJECT nothing", []string{"* THREAD"}, "OK"},
+		// Only REFERENCES is supported
+		{"THREAD ORDEREDSUBJECT UTF-8 ALL", nil, "BAD"},
+		{"THREAD REFERENCES UTF-8", nil, "BAD"},
+	})
+	if untagged, _ := c.run("CAPABILITY"); len(untagged) != 1 || !strings.Contains(untagged[0], " SORT THREAD=REFERENCES") {
+		t.Errorf("Expected SORT and THREAD=REFERENCES to be advertised, got %q", untagged)
+	}
+}
@@ -0,0 +1,99 @@
+package imapserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/webdavserver"
+)
+
+// TLSOptions configures TLS for the IMAP server
+type TLSOptions struct {
+	// CertFile and KeyFile are the PEM encoded certificate and private key
+	CertFile string
+	KeyFile  string
+
+	// AutoGenerateCerts creates a self-signed certificate when the files are
+	// not given or do not exist yet
+	AutoGenerateCerts bool
+	// CertDir is where generated certificates are stored when no file names are given
+	CertDir          string
+	CertValidityDays int
+	CertOrganization string
+
+	// AllowInsecureAuth keeps accepting logins on plain connections that
+	// did not switch to TLS with STARTTLS
+	AllowInsecureAuth bool
+}
+
+// DefaultTLSOptions returns TLS options that auto-generate a self-signed certificate
+func DefaultTLSOptions() TLSOptions {
+	return TLSOptions{
+		AutoGenerateCerts: true,
+		CertDir:           filepath.Join(os.TempDir(), "herolauncher", "certificates"),
+		CertValidityDays:  365,
+		CertOrganization:  "HeroLauncher IMAP Server",
+	}
+}
+
+// EnableTLS loads the certificate, generating it if allowed and needed. The
+// plain listener then offers STARTTLS and StartTLS can serve IMAPS.
+func (s *Server) EnableTLS(options TLSOptions) error {
+	certFile, keyFile, err := webdavserver.PrepareCertificate(options.certificateOptions())
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	s.imapServer.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	s.imapServer.AllowInsecureAuth = options.AllowInsecureAuth
+	log.Printf("IMAP TLS enabled using certificates: %s, %s", certFile, keyFile)
+	return nil
+}
+
+// StartTLS serves IMAP over implicit TLS (IMAPS) on addr, e.g. ":993".
+// EnableTLS must be called first. It can run next to Start, which serves
+// plain connections that can be upgraded with STARTTLS.
+func (s *Server) StartTLS(addr string) error {
+	if s.imapServer.TLSConfig == nil {
+		return fmt.Errorf("TLS is not enabled, call EnableTLS first")
+	}
+
+	listener, err := s.listen(addr, false)
+	if err != nil {
+		return err
+	}
+	log.Printf("Starting IMAPS server on %s", addr)
+	return s.imapServer.Serve(tls.NewListener(listener, s.imapServer.TLSConfig))
+}
+
+// certificateOptions returns the options of the certificate, with the
+// defaults for the certificates directory and organization
+func (options TLSOptions) certificateOptions() webdavserver.CertificateOptions {
+	defaults := DefaultTLSOptions()
+	certificate := webdavserver.CertificateOptions{
+		CertFile:     options.CertFile,
+		KeyFile:      options.KeyFile,
+		AutoGenerate: options.AutoGenerateCerts,
+		CertDir:      options.CertDir,
+		Name:         "imap",
+		ValidityDays: options.CertValidityDays,
+		Organization: options.CertOrganization,
+	}
+	if certificate.CertDir == "" {
+		certificate.CertDir = defaults.CertDir
+	}
+	if certificate.Organization == "" {
+		certificate.Organization = defaults.CertOrganization
+	}
+	return certificate
+}
@@ -0,0 +1,286 @@
+package imapserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/server"
+	"github.com/freeflowuniverse/herolauncher/pkg/mail"
+)
+
+// uidValidity is the UIDVALIDITY of every mailbox. UIDs are never reused,
+// so it does not change.
+const uidValidity uint32 = 1
+
+// nextUID returns the UID for a new message: the current time in seconds,
+// as redis_mail_feeder uses, or one more than the highest UID in the
+// mailbox if that is higher, so UIDs keep ascending
+func (m *Mailbox) nextUID() uint32 {
+	uid := uint32(time.Now().Unix())
+	for _, msg := range m.messages {
+		if msg.Uid >= uid {
+			uid = msg.Uid + 1
+		}
+	}
+	return uid
+}
+
+// storeNewMessage stores an email in the mailbox under a new UID and
+// returns the UID. The messages of the mailbox must be loaded.
+func (m *Mailbox) storeNewMessage(email *mail.Email) (uint32, error) {
+	m.backend.uidMu.Lock()
+	defer m.backend.uidMu.Unlock()
+
+	// Skip UIDs that were taken in the meantime
+	uid := m.nextUID()
+	var key string
+	for {
+		key = fmt.Sprintf("mail:in:%s:%s:%d", m.user.username, m.name, uid)
+		exists, err := m.backend.redisClient.Exists(m.backend.ctx, key).Result()
+		if err != nil {
+			return 0, fmt.Errorf("failed to check key %s: %w", key, err)
+		}
+		if exists == 0 {
+			break
+		}
+		uid++
+	}
+
+	email.UID = uid
+	emailJSON, err := json.Marshal(email)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal email: %w", err)
+	}
+	if err := m.backend.redisClient.Set(m.backend.ctx, key, string(emailJSON), 0).Err(); err != nil {
+		return 0, fmt.Errorf("failed to store email in Redis: %w", err)
+	}
+	if err := m.backend.index.Add(m.user.username, key, emailJSON); err != nil {
+		log.Printf("ERROR: Failed to index message %s: %v", key, err)
+	}
+
+	msg := &Message{
+		Email: email,
+		Uid:   uid,
+		Flags: email.Flags,
+		Key:   key,
+
+		attachments: m.backend.attachments,
+	}
+	m.messages = append(m.messages, msg)
+	return uid, m.touch(msg)
+}
+
+// uidPlus implements the UIDPLUS extension (RFC 4315): UID EXPUNGE, and
+// the APPENDUID and COPYUID response codes that tell clients the UIDs of the
+// messages they appended or copied
+type uidPlus struct{}
+
+// Capabilities advertises UIDPLUS once the client logged in
+func (uidPlus) Capabilities(c server.Conn) []string {
+	if c.Context().State&imap.AuthenticatedState != 0 {
+		return []string{"UIDPLUS"}
+	}
+	return nil
+}
+
+// Command returns the handlers replacing the builtin APPEND, COPY and EXPUNGE
+func (uidPlus) Command(name string) server.HandlerFactory {
+	switch name {
+	case "APPEND":
+		return func() server.Handler { return &uidPlusAppend{} }
+	case "COPY":
+		return func() server.Handler { return &uidPlusCopy{} }
+	case "EXPUNGE":
+		return func() server.Handler { return &uidPlusExpunge{} }
+	}
+	return nil
+}
+
+// uidPlusAppend is APPEND answering with APPENDUID
+type uidPlusAppend struct {
+	commands.Append
+}
+
+// Handle appends the message
+func (cmd *uidPlusAppend) Handle(conn server.Conn) error {
+	ctx := conn.Context()
+	if ctx.User == nil {
+		return server.ErrNotAuthenticated
+	}
+
+	mbox, err := ctx.User.GetMailbox(cmd.Mailbox)
+	if err == backend.ErrNoSuchMailbox {
+		return server.ErrStatusResp(&imap.StatusResp{
+			Type: imap.StatusRespNo,
+			Code: imap.CodeTryCreate,
+			Info: err.Error(),
+		})
+	} else if err != nil {
+		return err
+	}
+	mailbox, ok := mbox.(*Mailbox)
+	if !ok {
+		return errors.New("UIDPLUS is not supported by this mailbox")
+	}
+
+	uid, err := mailbox.CreateMessageUID(cmd.Flags, cmd.Date, cmd.Message)
+	if err != nil {
+		return overQuotaResp(err)
+	}
+
+	// If APPEND targets the currently selected mailbox, send an untagged EXISTS
+	if ctx.Mailbox != nil && ctx.Mailbox.Name() == mailbox.Name() {
+		status, err := mailbox.Status([]imap.StatusItem{imap.StatusMessages})
+		if err != nil {
+			return err
+		}
+		status.Flags = nil
+		status.PermanentFlags = nil
+		status.UnseenSeqNum = 0
+
+		if err := conn.WriteResp(&responses.Select{Mailbox: status}); err != nil {
+			return err
+		}
+	}
+
+	return server.ErrStatusResp(&imap.StatusResp{
+		Type:      imap.StatusRespOk,
+		Code:      "APPENDUID",
+		Arguments: []interface{}{uidValidity, uid},
+		Info:      "APPEND completed",
+	})
+}
+
+// uidPlusCopy is COPY and UID COPY answering with COPYUID
+type uidPlusCopy struct {
+	commands.Copy
+}
+
+// Handle copies the messages in the sequence set
+func (cmd *uidPlusCopy) Handle(conn server.Conn) error {
+	return cmd.handle(false, conn)
+}
+
+// UidHandle copies the messages in the UID set
+func (cmd *uidPlusCopy) UidHandle(conn server.Conn) error {
+	return cmd.handle(true, conn)
+}
+
+func (cmd *uidPlusCopy) handle(uid bool, conn server.Conn) error {
+	ctx := conn.Context()
+	if ctx.Mailbox == nil {
+		return server.ErrNoMailboxSelected
+	}
+	mailbox, ok := ctx.Mailbox.(*Mailbox)
+	if !ok {
+		return errors.New("UIDPLUS is not supported by this mailbox")
+	}
+
+	srcUIDs, destUIDs, err := mailbox.CopyMessagesUID(uid, cmd.SeqSet, cmd.Mailbox)
+	if err == backend.ErrNoSuchMailbox {
+		return server.ErrStatusResp(&imap.StatusResp{
+			Type: imap.StatusRespNo,
+			Code: imap.CodeTryCreate,
+			Info: err.Error(),
+		})
+	} else if err != nil {
+		return overQuotaResp(err)
+	}
+
+	info := "COPY completed"
+	if uid {
+		info = "UID " + info
+	}
+	if len(srcUIDs) == 0 {
+		return server.ErrStatusResp(&imap.StatusResp{Type: imap.StatusRespOk, Info: info})
+	}
+
+	// The UID sets list the UIDs in the same order, so they are not compacted
+	src, dest := new(imap.SeqSet), new(imap.SeqSet)
+	for i := range srcUIDs {
+		src.Set = append(src.Set, imap.Seq{Start: srcUIDs[i], Stop: srcUIDs[i]})
+		dest.Set = append(dest.Set, imap.Seq{Start: destUIDs[i], Stop: destUIDs[i]})
+	}
+	return server.ErrStatusResp(&imap.StatusResp{
+		Type:      imap.StatusRespOk,
+		Code:      "COPYUID",
+		Arguments: []interface{}{uidValidity, src, dest},
+		Info:      info,
+	})
+}
+
+// uidPlusExpunge is EXPUNGE, and UID EXPUNGE which only removes the
+// deleted messages in a UID set
+type uidPlusExpunge struct {
+	commands.Expunge
+	SeqSet *imap.SeqSet
+}
+
+// Parse reads the UID set of UID EXPUNGE
+func (cmd *uidPlusExpunge) Parse(fields []interface{}) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	seqSet, ok := fields[0].(string)
+	if !ok {
+		return errors.New("Invalid sequence set")
+	}
+	var err error
+	cmd.SeqSet, err = imap.ParseSeqSet(seqSet)
+	return err
+}
+
+// Handle removes all deleted messages
+func (cmd *uidPlusExpunge) Handle(conn server.Conn) error {
+	return expungeMessages(conn, nil)
+}
+
+// UidHandle removes the deleted messages in the UID set
+func (cmd *uidPlusExpunge) UidHandle(conn server.Conn) error {
+	if cmd.SeqSet == nil {
+		return errors.New("Missing UID set")
+	}
+	return expungeMessages(conn, cmd.SeqSet)
+}
+
+// expungeMessages removes the deleted messages, limited to uids if it is
+// not nil, and reports them to the client
+func expungeMessages(conn server.Conn, uids *imap.SeqSet) error {
+	ctx := conn.Context()
+	if ctx.Mailbox == nil {
+		return server.ErrNoMailboxSelected
+	}
+	if ctx.MailboxReadOnly {
+		return server.ErrMailboxReadOnly
+	}
+	mailbox, ok := ctx.Mailbox.(*Mailbox)
+	if !ok {
+		return errors.New("UIDPLUS is not supported by this mailbox")
+	}
+
+	// Find the messages to report as expunged
+	criteria := &imap.SearchCriteria{
+		Uid:       uids,
+		WithFlags: []string{imap.DeletedFlag},
+	}
+	seqNums, err := mailbox.SearchMessages(false, criteria)
+	if err != nil {
+		return err
+	}
+	expunged, err := mailbox.SearchMessages(true, criteria)
+	if err != nil {
+		return err
+	}
+
+	if err := mailbox.expunge(uids); err != nil {
+		return err
+	}
+	return writeExpunged(conn, seqNums, expunged)
+}
@@ -1,3 +1,5 @@
+// Package mail is the model of the emails of the Redis mail store, which the
+// IMAP, SMTP and POP3 servers share
 package mail
 
 type Email struct {
@@ -20,6 +22,11 @@ type Attachment struct {
 	Filename    string `json:"filename"`
 	ContentType string `json:"content_type"`
 	Data        string `json:"data"` // Base64 encoded binary data
+
+	// Attachments above a size are stored outside of Redis, see package
+	// mailblob; Data is then empty
+	Path string `json:"path,omitempty"` // Path of the data in the attachment store
+	Size int64  `json:"size,omitempty"` // Size of the stored data in bytes
 }
 
 // Envelope represents an IMAP envelope structure
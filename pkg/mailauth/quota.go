@@ -0,0 +1,200 @@
+package mailauth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrOverQuota is returned when storing a message would exceed a user's quota
+var ErrOverQuota = errors.New("quota exceeded")
+
+// Quota holds the limits of a user, zero means unlimited
+type Quota struct {
+	// Storage is the maximum size of all messages in bytes
+	Storage int64
+	// Messages is the maximum number of messages
+	Messages int64
+}
+
+// Unlimited reports whether no limit is set
+func (q Quota) Unlimited() bool {
+	return q.Storage <= 0 && q.Messages <= 0
+}
+
+// Usage holds what a user's messages take
+type Usage struct {
+	// Storage is the size of all messages in bytes, as stored in Redis
+	// with the attachments stored outside of it
+	Storage int64
+	// Messages is the number of messages
+	Messages int64
+}
+
+// quotaKey returns the hash with the limits of a user
+func quotaKey(username string) string {
+	return fmt.Sprintf("mail:quota:%s", username)
+}
+
+// usageKey returns the hash with the size of every message of a user, by key
+func usageKey(username string) string {
+	return fmt.Sprintf("mail:usage:%s", username)
+}
+
+// SetQuota sets the limits of an existing user
+func (s *Store) SetQuota(username string, quota Quota) error {
+	username = NormalizeUsername(username)
+	exists, err := s.Exists(username)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	if quota.Storage < 0 || quota.Messages < 0 {
+		return fmt.Errorf("quota must not be negative")
+	}
+
+	limits := map[string]int64{"storage": quota.Storage, "messages": quota.Messages}
+	for field, limit := range limits {
+		if err := s.redisClient.HSet(s.ctx, quotaKey(username), field, limit).Err(); err != nil {
+			return fmt.Errorf("failed to store quota: %w", err)
+		}
+	}
+	return nil
+}
+
+// Quota returns the limits of a user
+func (s *Store) Quota(username string) (Quota, error) {
+	username = NormalizeUsername(username)
+	var quota Quota
+	limits := map[string]*int64{"storage": &quota.Storage, "messages": &quota.Messages}
+	for field, limit := range limits {
+		value, err := s.redisClient.HGet(s.ctx, quotaKey(username), field).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return Quota{}, fmt.Errorf("failed to look up quota: %w", err)
+		}
+		*limit, _ = strconv.ParseInt(value, 10, 64)
+	}
+	return quota, nil
+}
+
+// Usage returns what a user's messages take. The sizes of the messages are
+// kept in Redis and brought up to date with the messages stored under
+// mail:in:<username>:*, so messages stored or deleted by others are
+// accounted for too.
+func (s *Store) Usage(username string) (Usage, error) {
+	username = NormalizeUsername(username)
+	keys, err := s.redisClient.Keys(s.ctx, fmt.Sprintf("mail:in:%s:*", username)).Result()
+	if err != nil {
+		return Usage{}, fmt.Errorf("failed to list messages: %w", err)
+	}
+	known, err := s.redisClient.HKeys(s.ctx, usageKey(username)).Result()
+	if err != nil {
+		return Usage{}, fmt.Errorf("failed to read usage: %w", err)
+	}
+
+	// Forget the sizes of deleted messages
+	current := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		current[key] = true
+	}
+	for _, key := range known {
+		if current[key] {
+			continue
+		}
+		if err := s.redisClient.HDel(s.ctx, usageKey(username), key).Err(); err != nil {
+			return Usage{}, fmt.Errorf("failed to update usage: %w", err)
+		}
+	}
+
+	var usage Usage
+	for _, key := range keys {
+		size, err := s.messageSize(username, key)
+		if err != nil {
+			return Usage{}, err
+		}
+		usage.Storage += size
+		usage.Messages++
+	}
+	return usage, nil
+}
+
+// messageSize returns the size of a stored message, measuring and recording
+// it the first time. Attachments kept in an attachment store (see package
+// mailblob) count with their size.
+func (s *Store) messageSize(username, key string) (int64, error) {
+	value, err := s.redisClient.HGet(s.ctx, usageKey(username), key).Result()
+	if err == nil {
+		size, _ := strconv.ParseInt(value, 10, 64)
+		return size, nil
+	}
+	if err != redis.Nil {
+		return 0, fmt.Errorf("failed to read usage: %w", err)
+	}
+
+	message, err := s.redisClient.Get(s.ctx, key).Result()
+	if err == redis.Nil {
+		// Deleted in the meantime
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get message %s: %w", key, err)
+	}
+	size := int64(len(message)) + storedAttachments(message)
+	if err := s.redisClient.HSet(s.ctx, usageKey(username), key, size).Err(); err != nil {
+		return 0, fmt.Errorf("failed to update usage: %w", err)
+	}
+	return size, nil
+}
+
+// CheckQuota returns ErrOverQuota if storing the given number of messages
+// of size bytes in total would exceed the user's quota
+func (s *Store) CheckQuota(username string, messages, size int64) error {
+	quota, err := s.Quota(username)
+	if err != nil {
+		return err
+	}
+	if quota.Unlimited() {
+		return nil
+	}
+
+	usage, err := s.Usage(username)
+	if err != nil {
+		return err
+	}
+	if quota.Storage > 0 && usage.Storage+size > quota.Storage {
+		return fmt.Errorf("%w: %s uses %d of %d bytes", ErrOverQuota, NormalizeUsername(username), usage.Storage, quota.Storage)
+	}
+	if quota.Messages > 0 && usage.Messages+messages > quota.Messages {
+		return fmt.Errorf("%w: %s has %d of %d messages", ErrOverQuota, NormalizeUsername(username), usage.Messages, quota.Messages)
+	}
+	return nil
+}
+
+// storedAttachments returns the size of the attachments of a message that
+// are stored outside of Redis
+func storedAttachments(message string) int64 {
+	var email struct {
+		Attachments []struct {
+			Path string `json:"path"`
+			Size int64  `json:"size"`
+		} `json:"attachments"`
+	}
+	if json.Unmarshal([]byte(message), &email) != nil {
+		return 0
+	}
+	var size int64
+	for _, attachment := range email.Attachments {
+		if attachment.Path != "" {
+			size += attachment.Size
+		}
+	}
+	return size
+}
@@ -0,0 +1,72 @@
+package mailauth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	if err := store.SetQuota("nobody", Quota{Storage: 100}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	if err := store.Add("alice", "pw"); err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+
+	// Without a quota everything fits
+	if err := store.CheckQuota("alice", 1000, 1<<40); err != nil {
+		t.Errorf("Expected no limit, got %v", err)
+	}
+
+	if err := store.SetQuota("Alice", Quota{Storage: 20, Messages: 2}); err != nil {
+		t.Fatalf("Failed to set quota: %v", err)
+	}
+	quota, err := store.Quota("alice")
+	if err != nil || quota.Storage != 20 || quota.Messages != 2 {
+		t.Errorf("Expected quota {20 2}, got %+v, %v", quota, err)
+	}
+
+	store.redisClient.Set(ctx, "mail:in:alice:inbox:1", "0123456789", 0)
+	usage, err := store.Usage("alice")
+	if err != nil || usage.Storage != 10 || usage.Messages != 1 {
+		t.Errorf("Expected usage {10 1}, got %+v, %v", usage, err)
+	}
+
+	if err := store.CheckQuota("alice", 1, 10); err != nil {
+		t.Errorf("Expected a message of 10 bytes to fit, got %v", err)
+	}
+	if err := store.CheckQuota("alice", 1, 11); !errors.Is(err, ErrOverQuota) {
+		t.Errorf("Expected ErrOverQuota for the storage, got %v", err)
+	}
+	if err := store.CheckQuota("alice", 2, 0); !errors.Is(err, ErrOverQuota) {
+		t.Errorf("Expected ErrOverQuota for the messages, got %v", err)
+	}
+
+	// Deleted messages no longer count
+	store.redisClient.Del(ctx, "mail:in:alice:inbox:1")
+	store.redisClient.Set(ctx, "mail:in:alice:sent:2", "01234", 0)
+	usage, err = store.Usage("alice")
+	if err != nil || usage.Storage != 5 || usage.Messages != 1 {
+		t.Errorf("Expected usage {5 1}, got %+v, %v", usage, err)
+	}
+
+	// Attachments stored outside of Redis count with their size
+	message := `{"attachments":[{"path":"/alice/abc","size":100},{"data":"AAAA"}]}`
+	store.redisClient.Set(ctx, "mail:in:alice:sent:3", message, 0)
+	usage, err = store.Usage("alice")
+	if err != nil || usage.Storage != int64(5+len(message)+100) || usage.Messages != 2 {
+		t.Errorf("Expected usage {%d 2}, got %+v, %v", 5+len(message)+100, usage, err)
+	}
+
+	if err := store.Remove("alice"); err != nil {
+		t.Fatalf("Failed to remove user: %v", err)
+	}
+	quota, err = store.Quota("alice")
+	if err != nil || !quota.Unlimited() {
+		t.Errorf("Expected the quota to be removed, got %+v, %v", quota, err)
+	}
+}
@@ -0,0 +1,185 @@
+package mailauth
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AliasesKey is the Redis hash mapping addresses to the comma separated
+// users and addresses their mail is delivered to
+const AliasesKey = "mail:aliases"
+
+// maxAliasDepth limits how deep aliases may point to other aliases
+const maxAliasDepth = 10
+
+// ErrAliasNotFound is returned for operations on an unknown alias
+var ErrAliasNotFound = errors.New("alias not found")
+
+// normalizeAddress returns the form an address is stored under, lower case
+func normalizeAddress(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
+
+// SetAlias routes the mail for an address to targets, which are mail users
+// or other addresses that are routed in turn. An alias with several targets
+// is a distribution list, and an alias for "@domain" is the catch-all of
+// the domain, receiving the mail for addresses that are no user or alias.
+func (s *Store) SetAlias(address string, targets []string) error {
+	address = normalizeAddress(address)
+	at := strings.LastIndex(address, "@")
+	if at < 0 || at == len(address)-1 || strings.ContainsAny(address, ", ") {
+		return fmt.Errorf("invalid alias address %q", address)
+	}
+
+	var normalized []string
+	for _, target := range targets {
+		target = strings.TrimSpace(target)
+		switch {
+		case target == "":
+			continue
+		case strings.Contains(target, "@"):
+			target = normalizeAddress(target)
+			if target == address {
+				return fmt.Errorf("alias %s must not point to itself", address)
+			}
+		default:
+			exists, err := s.Exists(target)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return fmt.Errorf("%w: %s", ErrUserNotFound, NormalizeUsername(target))
+			}
+			target = NormalizeUsername(target)
+		}
+		normalized = appendOnce(normalized, target)
+	}
+	if len(normalized) == 0 {
+		return fmt.Errorf("alias %s needs at least one user or address", address)
+	}
+
+	if err := s.redisClient.HSet(s.ctx, AliasesKey, address, strings.Join(normalized, ",")).Err(); err != nil {
+		return fmt.Errorf("failed to store alias: %w", err)
+	}
+	return nil
+}
+
+// RemoveAlias deletes an alias
+func (s *Store) RemoveAlias(address string) error {
+	address = normalizeAddress(address)
+	removed, err := s.redisClient.HDel(s.ctx, AliasesKey, address).Result()
+	if err != nil {
+		return fmt.Errorf("failed to remove alias: %w", err)
+	}
+	if removed == 0 {
+		return fmt.Errorf("%w: %s", ErrAliasNotFound, address)
+	}
+	return nil
+}
+
+// Aliases returns the targets of every alias
+func (s *Store) Aliases() (map[string][]string, error) {
+	addresses, err := s.redisClient.HKeys(s.ctx, AliasesKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list aliases: %w", err)
+	}
+	aliases := make(map[string][]string, len(addresses))
+	for _, address := range addresses {
+		targets, err := s.alias(address)
+		if err != nil {
+			return nil, err
+		}
+		if targets != nil {
+			aliases[address] = targets
+		}
+	}
+	return aliases, nil
+}
+
+// alias returns the targets of an alias, nil if there is none
+func (s *Store) alias(address string) ([]string, error) {
+	targets, err := s.redisClient.HGet(s.ctx, AliasesKey, address).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up alias: %w", err)
+	}
+	return strings.Split(targets, ","), nil
+}
+
+// Resolve returns the mail users that mail for an address is delivered to,
+// sorted, none if the address is not local. An alias for the address is
+// used first, then the user named by the local part when the address is
+// of domain, then the catch-all of the address's domain. Targets that are
+// no longer users are skipped.
+func (s *Store) Resolve(address, domain string) ([]string, error) {
+	var users []string
+	if err := s.resolve(normalizeAddress(address), strings.ToLower(domain), 0, &users); err != nil {
+		return nil, err
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// resolve adds the users of an address to users
+func (s *Store) resolve(address, domain string, depth int, users *[]string) error {
+	if depth > maxAliasDepth {
+		return fmt.Errorf("aliases for %s nest more than %d levels deep", address, maxAliasDepth)
+	}
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return nil
+	}
+
+	targets, err := s.alias(address)
+	if err != nil {
+		return err
+	}
+	if targets == nil {
+		if address[at+1:] == domain {
+			exists, err := s.Exists(address[:at])
+			if err != nil {
+				return err
+			}
+			if exists {
+				*users = appendOnce(*users, NormalizeUsername(address[:at]))
+				return nil
+			}
+		}
+		if targets, err = s.alias(address[at:]); err != nil || targets == nil {
+			return err
+		}
+	}
+
+	for _, target := range targets {
+		if strings.Contains(target, "@") {
+			if err := s.resolve(target, domain, depth+1, users); err != nil {
+				return err
+			}
+			continue
+		}
+		exists, err := s.Exists(target)
+		if err != nil {
+			return err
+		}
+		if exists {
+			*users = appendOnce(*users, target)
+		}
+	}
+	return nil
+}
+
+// appendOnce appends a value that is not in the list yet
+func appendOnce(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
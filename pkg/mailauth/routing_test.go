@@ -0,0 +1,79 @@
+package mailauth
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRouting(t *testing.T) {
+	store := newTestStore(t)
+	for _, name := range []string{"alice", "bob", "carol"} {
+		if err := store.Add(name, "pw"); err != nil {
+			t.Fatalf("Failed to add user: %v", err)
+		}
+	}
+
+	if err := store.SetAlias("sales@example.org", []string{"nobody"}); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	if err := store.SetAlias("sales", []string{"alice"}); err == nil {
+		t.Error("Expected an error for an alias without domain")
+	}
+	if err := store.SetAlias("sales@example.org", nil); err == nil {
+		t.Error("Expected an error for an alias without targets")
+	}
+
+	aliases := map[string][]string{
+		"Postmaster@example.org": {"alice"},
+		"sales@example.org":      {"Bob", "carol", "postmaster@example.org"},
+		"info@other.org":         {"sales@example.org"},
+		"@example.org":           {"carol"},
+	}
+	for address, targets := range aliases {
+		if err := store.SetAlias(address, targets); err != nil {
+			t.Fatalf("Failed to set alias %s: %v", address, err)
+		}
+	}
+
+	tests := []struct {
+		address string
+		users   []string
+	}{
+		{"alice@example.org", []string{"alice"}},
+		{"POSTMASTER@example.org", []string{"alice"}},
+		{"sales@example.org", []string{"alice", "bob", "carol"}},
+		{"info@other.org", []string{"alice", "bob", "carol"}},
+		{"unknown@example.org", []string{"carol"}},
+		{"unknown@other.org", nil},
+		{"bob@elsewhere.org", nil},
+	}
+	for _, test := range tests {
+		users, err := store.Resolve(test.address, "Example.org")
+		if err != nil || !reflect.DeepEqual(users, test.users) {
+			t.Errorf("Resolve(%s): expected %v, got %v, %v", test.address, test.users, users, err)
+		}
+	}
+
+	list, err := store.Aliases()
+	if err != nil || len(list) != 4 || !reflect.DeepEqual(list["sales@example.org"], []string{"bob", "carol", "postmaster@example.org"}) {
+		t.Errorf("Expected 4 aliases, got %v, %v", list, err)
+	}
+
+	// Aliases pointing at each other are stopped
+	store.SetAlias("a@example.org", []string{"b@example.org"})
+	store.SetAlias("b@example.org", []string{"a@example.org"})
+	if _, err := store.Resolve("a@example.org", "example.org"); err == nil {
+		t.Error("Expected an error for an alias loop")
+	}
+
+	if err := store.RemoveAlias("@example.org"); err != nil {
+		t.Fatalf("Failed to remove alias: %v", err)
+	}
+	if err := store.RemoveAlias("@example.org"); !errors.Is(err, ErrAliasNotFound) {
+		t.Errorf("Expected ErrAliasNotFound, got %v", err)
+	}
+	if users, err := store.Resolve("unknown@example.org", "example.org"); err != nil || len(users) != 0 {
+		t.Errorf("Expected no users without catch-all, got %v, %v", users, err)
+	}
+}
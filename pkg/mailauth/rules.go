@@ -0,0 +1,52 @@
+package mailauth
+
+import (
+	"fmt"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/mailrules"
+	"github.com/redis/go-redis/v9"
+)
+
+// rulesKey returns the key with the delivery rules of a user
+func rulesKey(username string) string {
+	return fmt.Sprintf("mail:rules:%s", username)
+}
+
+// SetRules sets the delivery rules of an existing user, as a heroscript of
+// !!mailrule actions. Empty rules remove them, so all mail is delivered to
+// the inbox.
+func (s *Store) SetRules(username, script string) error {
+	username = NormalizeUsername(username)
+	exists, err := s.Exists(username)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	if _, err := mailrules.Parse(script); err != nil {
+		return err
+	}
+
+	if script == "" {
+		err = s.redisClient.Del(s.ctx, rulesKey(username)).Err()
+	} else {
+		err = s.redisClient.Set(s.ctx, rulesKey(username), script, 0).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store rules: %w", err)
+	}
+	return nil
+}
+
+// Rules returns the delivery rules of a user, empty if there are none
+func (s *Store) Rules(username string) (string, error) {
+	script, err := s.redisClient.Get(s.ctx, rulesKey(NormalizeUsername(username))).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up rules: %w", err)
+	}
+	return script, nil
+}
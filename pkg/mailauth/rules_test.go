@@ -0,0 +1,42 @@
+package mailauth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	store := newTestStore(t)
+
+	if err := store.SetRules("nobody", "!!mailrule.discard"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	if err := store.Add("alice", "pw"); err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+
+	rules, err := store.Rules("alice")
+	if err != nil || rules != "" {
+		t.Errorf("Expected no rules, got %q, %v", rules, err)
+	}
+	if err := store.SetRules("alice", "!!mailrule.bounce"); err == nil {
+		t.Error("Expected an error for an unknown rule")
+	}
+
+	script := "!!mailrule.file from:'@example.com' folder:'work'"
+	if err := store.SetRules("Alice", script); err != nil {
+		t.Fatalf("Failed to set rules: %v", err)
+	}
+	rules, err = store.Rules("alice")
+	if err != nil || rules != script {
+		t.Errorf("Expected %q, got %q, %v", script, rules, err)
+	}
+
+	if err := store.Remove("alice"); err != nil {
+		t.Fatalf("Failed to remove user: %v", err)
+	}
+	rules, err = store.Rules("alice")
+	if err != nil || rules != "" {
+		t.Errorf("Expected the rules to be removed, got %q, %v", rules, err)
+	}
+}
@@ -0,0 +1,165 @@
+// Package mailauth stores the users of the IMAP and SMTP servers in Redis,
+// with bcrypt hashed passwords.
+package mailauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/tools"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UsersKey is the Redis hash mapping every mail user to its password hash
+const UsersKey = "mail:users"
+
+var (
+	// ErrInvalidCredentials is returned for an unknown user or a wrong password
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	// ErrUserExists is returned when adding a user that already exists
+	ErrUserExists = errors.New("user already exists")
+	// ErrUserNotFound is returned for operations on an unknown user
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// dummyHash is compared against for unknown users, so a login takes as long
+// whether or not the user exists
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("mailauth"), bcrypt.DefaultCost)
+
+// Store manages mail users in Redis
+type Store struct {
+	redisClient *redis.Client
+	ctx         context.Context
+}
+
+// NewStore creates a store on a Redis connection
+func NewStore(redisClient *redis.Client) *Store {
+	return &Store{
+		redisClient: redisClient,
+		ctx:         context.Background(),
+	}
+}
+
+// NormalizeUsername returns the form a username is stored under: lower case,
+// with characters other than letters, digits, '.' and ',' replaced by '_',
+// the way heroscript normalizes names. The result is safe to use in the
+// Redis keys of the user's mailboxes, like mail:in:<username>:<mailbox>:<uid>.
+func NormalizeUsername(username string) string {
+	return tools.NameFix(username)
+}
+
+// validateUsername checks a normalized username
+func validateUsername(username string) error {
+	if strings.Trim(username, "_") == "" || len(username) > 128 {
+		return fmt.Errorf("username must have 1 to 128 characters")
+	}
+	return nil
+}
+
+// Add creates a user
+func (s *Store) Add(username, password string) error {
+	username = NormalizeUsername(username)
+	if err := validateUsername(username); err != nil {
+		return err
+	}
+	exists, err := s.Exists(username)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%w: %s", ErrUserExists, username)
+	}
+	return s.setPassword(username, password)
+}
+
+// SetPassword changes the password of an existing user
+func (s *Store) SetPassword(username, password string) error {
+	username = NormalizeUsername(username)
+	exists, err := s.Exists(username)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	return s.setPassword(username, password)
+}
+
+// setPassword stores the hash of a password
+func (s *Store) setPassword(username, password string) error {
+	if password == "" {
+		return fmt.Errorf("password must not be empty")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	if err := s.redisClient.HSet(s.ctx, UsersKey, username, string(hash)).Err(); err != nil {
+		return fmt.Errorf("failed to store user: %w", err)
+	}
+	return nil
+}
+
+// Remove deletes a user, its quota and its rules. The user's mail is kept.
+func (s *Store) Remove(username string) error {
+	username = NormalizeUsername(username)
+	removed, err := s.redisClient.HDel(s.ctx, UsersKey, username).Result()
+	if err != nil {
+		return fmt.Errorf("failed to remove user: %w", err)
+	}
+	if removed == 0 {
+		return fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	if err := s.redisClient.Del(s.ctx, quotaKey(username)).Err(); err != nil {
+		return fmt.Errorf("failed to remove quota: %w", err)
+	}
+	if err := s.redisClient.Del(s.ctx, rulesKey(username)).Err(); err != nil {
+		return fmt.Errorf("failed to remove rules: %w", err)
+	}
+	return nil
+}
+
+// Exists reports whether a user exists
+func (s *Store) Exists(username string) (bool, error) {
+	_, err := s.redisClient.HGet(s.ctx, UsersKey, NormalizeUsername(username)).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up user: %w", err)
+	}
+	return true, nil
+}
+
+// List returns the names of all users, sorted
+func (s *Store) List() ([]string, error) {
+	users, err := s.redisClient.HKeys(s.ctx, UsersKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// Authenticate checks a password and returns the normalized username, which
+// is the namespace of the user's mailboxes
+func (s *Store) Authenticate(username, password string) (string, error) {
+	username = NormalizeUsername(username)
+	hash, err := s.redisClient.HGet(s.ctx, UsersKey, username).Result()
+	if err == redis.Nil {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", ErrInvalidCredentials
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return "", ErrInvalidCredentials
+	}
+	return username, nil
+}
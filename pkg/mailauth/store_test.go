@@ -0,0 +1,69 @@
+package mailauth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/redisserver/redistest"
+)
+
+// newTestStore starts an in-memory Redis server on a unix socket
+func newTestStore(t *testing.T) *Store {
+	return NewStore(redistest.NewClient(t))
+}
+
+func TestStore(t *testing.T) {
+	store := newTestStore(t)
+
+	if err := store.Add("Alice", "Secret1"); err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+	if err := store.Add("alice", "other"); !errors.Is(err, ErrUserExists) {
+		t.Errorf("Expected ErrUserExists, got %v", err)
+	}
+	if err := store.Add("!!", "pw"); err == nil {
+		t.Errorf("Expected an error for an empty username")
+	}
+	if name := NormalizeUsername("Bob:Inbox*"); name != "bob_inbox_" {
+		t.Errorf("Expected bob_inbox_, got %q", name)
+	}
+
+	username, err := store.Authenticate("ALICE", "Secret1")
+	if err != nil || username != "alice" {
+		t.Errorf("Expected alice to authenticate, got %q, %v", username, err)
+	}
+	if _, err := store.Authenticate("alice", "secret1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials for a wrong password, got %v", err)
+	}
+	if _, err := store.Authenticate("nobody", "Secret1"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials for an unknown user, got %v", err)
+	}
+
+	if err := store.SetPassword("alice", "Secret2"); err != nil {
+		t.Fatalf("Failed to set password: %v", err)
+	}
+	if _, err := store.Authenticate("alice", "Secret2"); err != nil {
+		t.Errorf("Expected the new password to work, got %v", err)
+	}
+	if err := store.SetPassword("nobody", "pw"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+
+	if err := store.Add("bob", "pw"); err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+	users, err := store.List()
+	if err != nil || len(users) != 2 || users[0] != "alice" || users[1] != "bob" {
+		t.Errorf("Expected [alice bob], got %v, %v", users, err)
+	}
+
+	if err := store.Remove("bob"); err != nil {
+		t.Fatalf("Failed to remove user: %v", err)
+	}
+	if err := store.Remove("bob"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got %v", err)
+	}
+	if _, err := store.Authenticate("bob", "pw"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected a removed user to fail, got %v", err)
+	}
+}
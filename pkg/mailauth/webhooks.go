@@ -0,0 +1,119 @@
+package mailauth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// WebhooksKey is the Redis hash mapping the ID of every webhook to its JSON
+const WebhooksKey = "mail:webhooks"
+
+// ErrWebhookNotFound is returned for operations on an unknown webhook
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// Webhook is a URL that is called whenever a message it matches is stored
+type Webhook struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Recipient limits the webhook to mail for a user, a recipient address
+	// or, written "@domain", recipients of a domain
+	Recipient string `json:"recipient,omitempty"`
+	// Folder limits the webhook to mail stored in a mailbox or its children
+	Folder string `json:"folder,omitempty"`
+	// Secret signs the calls with HMAC-SHA256 when set
+	Secret string `json:"secret,omitempty"`
+}
+
+// Matches reports whether the webhook is called for a message stored for
+// a user in a mailbox, sent to recipients
+func (w Webhook) Matches(user string, recipients []string, mailbox string) bool {
+	if w.Folder != "" {
+		mailbox = strings.ToLower(mailbox)
+		if mailbox != w.Folder && !strings.HasPrefix(mailbox, w.Folder+"/") {
+			return false
+		}
+	}
+	if w.Recipient == "" || w.Recipient == user {
+		return true
+	}
+	for _, rcpt := range recipients {
+		rcpt = strings.ToLower(rcpt)
+		if rcpt == w.Recipient || strings.HasPrefix(w.Recipient, "@") && strings.HasSuffix(rcpt, w.Recipient) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddWebhook registers a webhook and returns its ID
+func (s *Store) AddWebhook(hook Webhook) (string, error) {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("webhook URL %q must be an http or https URL", hook.URL)
+	}
+	hook.Recipient = strings.ToLower(strings.TrimSpace(hook.Recipient))
+	if hook.Recipient != "" && !strings.Contains(hook.Recipient, "@") {
+		hook.Recipient = NormalizeUsername(hook.Recipient)
+	}
+	hook.Folder = strings.Trim(strings.ToLower(strings.TrimSpace(hook.Folder)), "/")
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", fmt.Errorf("failed to generate webhook ID: %w", err)
+	}
+	hook.ID = hex.EncodeToString(idBytes)
+
+	hookJSON, err := json.Marshal(hook)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal webhook: %w", err)
+	}
+	if err := s.redisClient.HSet(s.ctx, WebhooksKey, hook.ID, string(hookJSON)).Err(); err != nil {
+		return "", fmt.Errorf("failed to store webhook: %w", err)
+	}
+	return hook.ID, nil
+}
+
+// RemoveWebhook deletes a webhook
+func (s *Store) RemoveWebhook(id string) error {
+	removed, err := s.redisClient.HDel(s.ctx, WebhooksKey, id).Result()
+	if err != nil {
+		return fmt.Errorf("failed to remove webhook: %w", err)
+	}
+	if removed == 0 {
+		return fmt.Errorf("%w: %s", ErrWebhookNotFound, id)
+	}
+	return nil
+}
+
+// Webhooks returns all webhooks, sorted by URL
+func (s *Store) Webhooks() ([]Webhook, error) {
+	ids, err := s.redisClient.HKeys(s.ctx, WebhooksKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	hooks := make([]Webhook, 0, len(ids))
+	for _, id := range ids {
+		hookJSON, err := s.redisClient.HGet(s.ctx, WebhooksKey, id).Result()
+		if err != nil {
+			continue
+		}
+		var hook Webhook
+		if err := json.Unmarshal([]byte(hookJSON), &hook); err != nil {
+			return nil, fmt.Errorf("invalid webhook %s: %w", id, err)
+		}
+		hooks = append(hooks, hook)
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		if hooks[i].URL != hooks[j].URL {
+			return hooks[i].URL < hooks[j].URL
+		}
+		return hooks[i].ID < hooks[j].ID
+	})
+	return hooks, nil
+}
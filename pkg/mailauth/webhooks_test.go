@@ -0,0 +1,63 @@
+package mailauth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWebhooks(t *testing.T) {
+	store := newTestStore(t)
+
+	if _, err := store.AddWebhook(Webhook{URL: "ftp://example.com"}); err == nil {
+		t.Error("Expected an error for a URL that is not http")
+	}
+
+	id, err := store.AddWebhook(Webhook{URL: "https://hooks.example.com/mail", Recipient: "Alice", Folder: "/Work/"})
+	if err != nil {
+		t.Fatalf("Failed to add webhook: %v", err)
+	}
+	if _, err := store.AddWebhook(Webhook{URL: "http://localhost:8080/all"}); err != nil {
+		t.Fatalf("Failed to add webhook: %v", err)
+	}
+
+	hooks, err := store.Webhooks()
+	if err != nil || len(hooks) != 2 {
+		t.Fatalf("Expected 2 webhooks, got %v, %v", hooks, err)
+	}
+	hook := hooks[1]
+	if hook.ID != id || hook.Recipient != "alice" || hook.Folder != "work" {
+		t.Errorf("Expected the webhook to be normalized, got %+v", hook)
+	}
+
+	tests := []struct {
+		user       string
+		recipients []string
+		mailbox    string
+		matches    bool
+	}{
+		{"alice", nil, "work", true},
+		{"alice", nil, "Work/Reports", true},
+		{"alice", nil, "workshop", false},
+		{"alice", nil, "inbox", false},
+		{"bob", []string{"bob@example.org"}, "work", false},
+	}
+	for _, test := range tests {
+		if got := hook.Matches(test.user, test.recipients, test.mailbox); got != test.matches {
+			t.Errorf("Matches(%s, %v, %s): expected %v", test.user, test.recipients, test.mailbox, test.matches)
+		}
+	}
+	domain := Webhook{Recipient: "@example.org"}
+	if !domain.Matches("bob", []string{"Bob@Example.org"}, "inbox") || domain.Matches("bob", []string{"bob@other.org"}, "inbox") {
+		t.Error("Expected a domain webhook to match the recipients of the domain only")
+	}
+	if !hooks[0].Matches("bob", nil, "inbox") {
+		t.Error("Expected a webhook without filters to match everything")
+	}
+
+	if err := store.RemoveWebhook(id); err != nil {
+		t.Fatalf("Failed to remove webhook: %v", err)
+	}
+	if err := store.RemoveWebhook(id); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("Expected ErrWebhookNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+// Package mailblob keeps large attachments of the Redis mail store in a
+// VFS, like vfsdb, instead of inline in the email JSON.
+//
+// Attachments whose data is above a threshold are written to the VFS at
+// /<username>/<sha256 of the data>, and the email keeps their path and size
+// with empty data. Attachments with the same content share the file, so
+// copies of a message take no extra space. Resolve reads them back before
+// a message is rendered, so clients see the complete message. Files no
+// message refers to anymore are removed by Prune.
+package mailblob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/mail"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultThreshold is the size of attachment data in bytes above which it
+// is stored in the VFS
+const DefaultThreshold = 256 * 1024
+
+// pruneGrace is how old files must be before Prune removes them, so files
+// of messages that are being stored are kept
+const pruneGrace = time.Hour
+
+// Store stores attachments in a VFS
+type Store struct {
+	fs        vfs.VFSImplementation
+	threshold int
+	grace     time.Duration
+}
+
+// NewStore creates an attachment store on a VFS, storing attachments above
+// threshold bytes
+func NewStore(fs vfs.VFSImplementation, threshold int) *Store {
+	return &Store{
+		fs:        fs,
+		threshold: threshold,
+		grace:     pruneGrace,
+	}
+}
+
+// Offload moves the data of the large attachments of an email of a user to
+// the VFS
+func (s *Store) Offload(username string, email *mail.Email) error {
+	for i := range email.Attachments {
+		attachment := &email.Attachments[i]
+		if attachment.Path != "" || len(attachment.Data) <= s.threshold {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(attachment.Data)
+		if err != nil {
+			return fmt.Errorf("invalid data of attachment %s: %w", attachment.Filename, err)
+		}
+
+		sum := sha256.Sum256(data)
+		dir := "/" + username
+		path := dir + "/" + hex.EncodeToString(sum[:])
+		if !s.fs.Exists(dir) {
+			// Another delivery may have created it in the meantime
+			if _, err := s.fs.DirCreate(dir); err != nil && !s.fs.Exists(dir) {
+				return fmt.Errorf("failed to create attachment directory %s: %w", dir, err)
+			}
+		}
+		if err := s.write(path, data); err != nil {
+			return err
+		}
+
+		attachment.Path = path
+		attachment.Size = int64(len(data))
+		attachment.Data = ""
+	}
+	return nil
+}
+
+// write writes the data of an attachment, unless the file exists and was
+// written recently. Older files are written again, so Prune does not remove
+// a file a new message refers to before the message is stored.
+func (s *Store) write(path string, data []byte) error {
+	if entry, err := s.fs.Get(path); err == nil {
+		if entry.GetMetadata().ModifiedAt > time.Now().Add(-s.grace/2).Unix() {
+			return nil
+		}
+	} else if _, err := s.fs.FileCreate(path); err != nil {
+		return fmt.Errorf("failed to create attachment %s: %w", path, err)
+	}
+	if err := s.fs.FileWrite(path, data); err != nil {
+		return fmt.Errorf("failed to write attachment %s: %w", path, err)
+	}
+	return nil
+}
+
+// Resolve returns a copy of an email with the data of its attachments read
+// from the VFS. An email without stored attachments is returned as is.
+func (s *Store) Resolve(email *mail.Email) (*mail.Email, error) {
+	resolved := email
+	for i, attachment := range email.Attachments {
+		if attachment.Path == "" {
+			continue
+		}
+		if resolved == email {
+			copied := *email
+			copied.Attachments = append([]mail.Attachment(nil), email.Attachments...)
+			resolved = &copied
+		}
+		data, err := s.fs.FileRead(attachment.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read attachment %s: %w", attachment.Path, err)
+		}
+		resolved.Attachments[i].Data = base64.StdEncoding.EncodeToString(data)
+		resolved.Attachments[i].Path = ""
+		resolved.Attachments[i].Size = 0
+	}
+	return resolved, nil
+}
+
+// Prune removes the attachments of a user that none of the messages at
+// mail:in:<username>:* refers to, and returns their number
+func (s *Store) Prune(ctx context.Context, redisClient *redis.Client, username string) (int, error) {
+	dir := "/" + username
+	if !s.fs.Exists(dir) {
+		return 0, nil
+	}
+
+	keys, err := redisClient.Keys(ctx, fmt.Sprintf("mail:in:%s:*", username)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list messages: %w", err)
+	}
+	used := make(map[string]bool)
+	for _, key := range keys {
+		value, err := redisClient.Get(ctx, key).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to get message %s: %w", key, err)
+		}
+		var email mail.Email
+		if err := json.Unmarshal([]byte(value), &email); err != nil {
+			continue
+		}
+		for _, attachment := range email.Attachments {
+			if attachment.Path != "" {
+				used[attachment.Path] = true
+			}
+		}
+	}
+
+	entries, err := s.fs.DirList(dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list attachments: %w", err)
+	}
+	removed := 0
+	cutoff := time.Now().Add(-s.grace).Unix()
+	for _, entry := range entries {
+		metadata := entry.GetMetadata()
+		path := dir + "/" + metadata.Name
+		if !entry.IsFile() || used[path] || metadata.ModifiedAt > cutoff {
+			continue
+		}
+		if err := s.fs.FileDelete(path); err != nil {
+			return removed, fmt.Errorf("failed to delete attachment %s: %w", path, err)
+		}
+		log.Printf("Removed unused attachment %s", path)
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,85 @@
+package mailblob
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/mail"
+	"github.com/freeflowuniverse/herolauncher/pkg/redisserver/redistest"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfsdb"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestStore(t *testing.T) (*Store, *redis.Client) {
+	fs, err := vfsdb.NewFromPath(filepath.Join(t.TempDir(), "attachments"))
+	if err != nil {
+		t.Fatalf("Failed to create VFS: %v", err)
+	}
+
+	client := redistest.NewClient(t)
+	return NewStore(fs, 16), client
+}
+
+func TestStore(t *testing.T) {
+	store, client := newTestStore(t)
+	ctx := context.Background()
+
+	large := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("report ", 10)))
+	small := base64.StdEncoding.EncodeToString([]byte("hi"))
+	email := &mail.Email{
+		Message: "See attached",
+		Attachments: []mail.Attachment{
+			{Filename: "report.txt", ContentType: "text/plain", Data: large},
+			{Filename: "note.txt", ContentType: "text/plain", Data: small},
+		},
+	}
+	if err := store.Offload("alice", email); err != nil {
+		t.Fatalf("Failed to offload attachments: %v", err)
+	}
+	stored := email.Attachments[0]
+	if stored.Data != "" || !strings.HasPrefix(stored.Path, "/alice/") || stored.Size != 70 {
+		t.Errorf("Expected the large attachment to be stored in the VFS, got %+v", stored)
+	}
+	if email.Attachments[1].Data != small || email.Attachments[1].Path != "" {
+		t.Errorf("Expected the small attachment to stay inline, got %+v", email.Attachments[1])
+	}
+
+	resolved, err := store.Resolve(email)
+	if err != nil {
+		t.Fatalf("Failed to resolve attachments: %v", err)
+	}
+	if resolved.Attachments[0].Data != large || resolved.Attachments[0].Path != "" {
+		t.Errorf("Expected the attachment data to be read back, got %+v", resolved.Attachments[0])
+	}
+	if email.Attachments[0].Data != "" {
+		t.Error("Expected Resolve to leave the stored email unchanged")
+	}
+
+	// A copy of the attachment shares the file
+	other := &mail.Email{Attachments: []mail.Attachment{{Filename: "copy.txt", Data: large}}}
+	if err := store.Offload("alice", other); err != nil {
+		t.Fatalf("Failed to offload attachments: %v", err)
+	}
+	if other.Attachments[0].Path != stored.Path {
+		t.Errorf("Expected identical attachments to share %s, got %s", stored.Path, other.Attachments[0].Path)
+	}
+
+	// Files are kept while a message refers to them
+	store.grace = 0
+	emailJSON, _ := json.Marshal(email)
+	client.Set(ctx, "mail:in:alice:inbox:1", string(emailJSON), 0)
+	if removed, err := store.Prune(ctx, client, "alice"); err != nil || removed != 0 {
+		t.Errorf("Expected no attachments to be removed, got %d, %v", removed, err)
+	}
+	client.Del(ctx, "mail:in:alice:inbox:1")
+	if removed, err := store.Prune(ctx, client, "alice"); err != nil || removed != 1 {
+		t.Errorf("Expected the unused attachment to be removed, got %d, %v", removed, err)
+	}
+	if _, err := store.Resolve(email); err == nil {
+		t.Error("Expected an error for a removed attachment")
+	}
+}
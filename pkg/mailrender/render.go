@@ -0,0 +1,188 @@
+// Package mailrender renders the emails of the Redis mail store as MIME
+// messages, for the IMAP and POP3 servers.
+//
+// Emails are stored as their text and attachments, not as the message that
+// was received. They are rendered as a MIME message: a text/plain
+// message, or a multipart/mixed message with the text as first part and
+// every attachment as a base64 encoded part. The rendering only depends on
+// the stored email, so sizes, part numbers and sections are the same on
+// every fetch, over IMAP and POP3.
+package mailrender
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/freeflowuniverse/herolauncher/pkg/mail"
+)
+
+// contentHash returns a hash of the content of an email, used for the
+// multipart boundary and the Message-ID of emails without one
+func contentHash(email *mail.Email) string {
+	h := sha1.New()
+	io.WriteString(h, email.Message)
+	for _, att := range email.Attachments {
+		io.WriteString(h, att.Filename)
+		io.WriteString(h, att.ContentType)
+		io.WriteString(h, att.Data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Render writes an email as a MIME message
+func Render(email *mail.Email) ([]byte, error) {
+	hash := contentHash(email)
+
+	var h message.Header
+	date := email.Date()
+	if date == 0 {
+		date = email.InternalDate
+	}
+	if date > 0 {
+		h.Set("Date", time.Unix(date, 0).Format(time.RFC1123Z))
+	}
+	if email.Envelope != nil {
+		setAddresses(&h, "From", email.Envelope.From)
+		setAddresses(&h, "Sender", email.Envelope.Sender)
+		setAddresses(&h, "Reply-To", email.Envelope.ReplyTo)
+		setAddresses(&h, "To", email.Envelope.To)
+		setAddresses(&h, "Cc", email.Envelope.Cc)
+		setAddresses(&h, "Bcc", email.Envelope.Bcc)
+		if email.Envelope.InReplyTo != "" {
+			h.Set("In-Reply-To", email.Envelope.InReplyTo)
+		}
+	}
+	if subject := email.Subject(); subject != "" {
+		h.SetText("Subject", subject)
+	}
+	messageID := hash[:32] + "@herolauncher"
+	if email.Envelope != nil && email.Envelope.MessageId != "" {
+		messageID = strings.Trim(email.Envelope.MessageId, "<>")
+	}
+	h.Set("Message-Id", "<"+messageID+">")
+	h.Set("Mime-Version", "1.0")
+
+	var buf bytes.Buffer
+	if len(email.Attachments) == 0 {
+		setTextHeader(&h, email.Message)
+		w, err := message.CreateWriter(&buf, inOrder(h))
+		if err != nil {
+			return nil, err
+		}
+		if err := writeText(w, email.Message); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	h.SetContentType("multipart/mixed", map[string]string{"boundary": "herolauncher-" + hash[:24]})
+	w, err := message.CreateWriter(&buf, inOrder(h))
+	if err != nil {
+		return nil, err
+	}
+
+	var textHeader message.Header
+	setTextHeader(&textHeader, email.Message)
+	part, err := w.CreatePart(inOrder(textHeader))
+	if err != nil {
+		return nil, err
+	}
+	if err := writeText(part, email.Message); err != nil {
+		return nil, err
+	}
+
+	for _, att := range email.Attachments {
+		contentType := att.ContentType
+		if !strings.Contains(contentType, "/") {
+			contentType = "application/octet-stream"
+		}
+		var attHeader message.Header
+		attHeader.SetContentType(contentType, map[string]string{"name": att.Filename})
+		attHeader.SetContentDisposition("attachment", map[string]string{"filename": att.Filename})
+		attHeader.Set("Content-Transfer-Encoding", "base64")
+
+		part, err := w.CreatePart(inOrder(attHeader))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(attachmentData(att)); err != nil {
+			return nil, err
+		}
+		if err := part.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// inOrder returns a header that is written with the fields in the order
+// they were set, go-message writes the field set last first
+func inOrder(h message.Header) message.Header {
+	var ordered message.Header
+	fields := h.Fields()
+	for fields.Next() {
+		ordered.AddRaw([]byte(fields.Key() + ": " + fields.Value() + "\r\n"))
+	}
+	return ordered
+}
+
+// setAddresses sets an address header if there are addresses
+func setAddresses(h *message.Header, key string, addresses []string) {
+	var nonEmpty []string
+	for _, address := range addresses {
+		if address = strings.TrimSpace(address); address != "" {
+			nonEmpty = append(nonEmpty, address)
+		}
+	}
+	if len(nonEmpty) > 0 {
+		h.Set(key, strings.Join(nonEmpty, ", "))
+	}
+}
+
+// setTextHeader sets the header of a text/plain part. Text that is not
+// 7 bit ASCII in short lines is quoted-printable encoded.
+func setTextHeader(h *message.Header, text string) {
+	h.SetContentType("text/plain", map[string]string{"charset": "utf-8"})
+	encoding := "7bit"
+	for _, line := range strings.Split(text, "\n") {
+		if len(line) > 998 {
+			encoding = "quoted-printable"
+			break
+		}
+	}
+	for i := 0; i < len(text) && encoding == "7bit"; i++ {
+		if text[i] >= 0x80 || text[i] == 0 {
+			encoding = "quoted-printable"
+		}
+	}
+	h.Set("Content-Transfer-Encoding", encoding)
+}
+
+// writeText writes a text with CRLF line endings and closes the writer
+func writeText(w io.WriteCloser, text string) error {
+	text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+	if _, err := io.WriteString(w, text); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// attachmentData returns the content of an attachment, which is stored base64
+// encoded. Data that is not valid base64 is returned as is.
+func attachmentData(att mail.Attachment) []byte {
+	data, err := base64.StdEncoding.DecodeString(att.Data)
+	if err != nil {
+		return []byte(att.Data)
+	}
+	return data
+}
@@ -0,0 +1,133 @@
+package mailrender
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message"
+	"github.com/freeflowuniverse/herolauncher/pkg/mail"
+)
+
+func TestRenderText(t *testing.T) {
+	email := &mail.Email{
+		Message:      "Hello Bob,\nsee you tomorrow.",
+		InternalDate: 1700000000,
+		Envelope: &mail.Envelope{
+			Subject:   "Meeting",
+			From:      []string{"alice@example.com"},
+			To:        []string{"bob@example.com", " ", "carol@example.com"},
+			MessageId: "<abc@example.com>",
+		},
+	}
+	data, err := Render(email)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	header, body, _ := strings.Cut(string(data), "\r\n\r\n")
+	for _, expected := range []string{
+		"Date: " + time.Unix(1700000000, 0).Format(time.RFC1123Z),
+		"From: alice@example.com",
+		"To: bob@example.com, carol@example.com",
+		"Subject: Meeting",
+		"Message-Id: <abc@example.com>",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 7bit",
+	} {
+		if !strings.Contains(header+"\r\n", expected+"\r\n") {
+			t.Errorf("Expected %q in the header %q", expected, header)
+		}
+	}
+	if strings.Index(header, "Date:") > strings.Index(header, "From:") {
+		t.Errorf("Expected the fields in the order they are set, got %q", header)
+	}
+	if body != "Hello Bob,\r\nsee you tomorrow." {
+		t.Errorf("Expected the text with CRLF line endings, got %q", body)
+	}
+
+	// The rendering is the same on every fetch
+	again, _ := Render(email)
+	if !bytes.Equal(data, again) {
+		t.Error("Expected the same message when rendered twice")
+	}
+}
+
+func TestRenderEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		encoding string
+	}{
+		{"ascii", "plain text", "7bit"},
+		{"non-ascii", "café", "quoted-printable"},
+		{"long line", strings.Repeat("a", 999), "quoted-printable"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := Render(&mail.Email{Message: test.text})
+			if err != nil {
+				t.Fatalf("Render failed: %v", err)
+			}
+			if !strings.Contains(string(data), "Content-Transfer-Encoding: "+test.encoding+"\r\n") {
+				t.Errorf("Expected %s encoding, got %q", test.encoding, data)
+			}
+			entity, err := message.Read(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("Failed to read the message: %v", err)
+			}
+			body, _ := io.ReadAll(entity.Body)
+			if string(body) != test.text {
+				t.Errorf("Expected the text to be decoded as %q, got %q", test.text, body)
+			}
+		})
+	}
+}
+
+func TestRenderAttachments(t *testing.T) {
+	email := &mail.Email{
+		Message: "See attached",
+		Attachments: []mail.Attachment{
+			{Filename: "report.pdf", ContentType: "application/pdf", Data: base64.StdEncoding.EncodeToString([]byte("%PDF-1.4"))},
+			{Filename: "notes", ContentType: "unknown", Data: "not base64!"},
+		},
+	}
+	data, err := Render(email)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	if !strings.Contains(string(data), "Message-Id: <"+contentHash(email)[:32]+"@herolauncher>") {
+		t.Errorf("Expected a Message-ID from the content, got %q", data)
+	}
+
+	entity, err := message.Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to read the message: %v", err)
+	}
+	reader := entity.MultipartReader()
+	if reader == nil {
+		t.Fatal("Expected a multipart message")
+	}
+	expected := []struct{ contentType, filename, body string }{
+		{"text/plain", "", "See attached"},
+		{"application/pdf", "report.pdf", "%PDF-1.4"},
+		{"application/octet-stream", "notes", "not base64!"},
+	}
+	for i, want := range expected {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("Failed to read part %d: %v", i+1, err)
+		}
+		contentType, _, _ := part.Header.ContentType()
+		_, params, _ := part.Header.ContentDisposition()
+		body, _ := io.ReadAll(part.Body)
+		if contentType != want.contentType || params["filename"] != want.filename || string(body) != want.body {
+			t.Errorf("Part %d: expected %+v, got %s %q %q", i+1, want, contentType, params["filename"], body)
+		}
+	}
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("Expected 3 parts, got more: %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+// Package mailrules evaluates the delivery rules of mail users, a subset of
+// Sieve (RFC 5228) written as heroscript:
+//
+//	!!mailrule.file from:'@example.com' folder:'work'
+//	!!mailrule.read subject:'newsletter'
+//	!!mailrule.forward to:'me@example.org' copy:1
+//	!!mailrule.discard header:'X-Spam-Flag: YES' stop:1
+//
+// Every rule has an action and conditions that must all match. Without
+// conditions a rule always matches. The conditions are:
+//
+//   - from: text in the sender address
+//   - to: text in one of the recipient addresses
+//   - subject: text in the subject
+//   - header: 'Name: text' for text in a header field, or 'Name' for a
+//     field that is present
+//   - size_over: a size in bytes, or with a kb, mb or gb suffix
+//
+// Text is matched case-insensitively. The actions are:
+//
+//   - file: deliver to folder instead of the inbox
+//   - read: mark the message as read
+//   - forward: send the message on to the address in to:, instead of
+//     keeping it, unless copy:1 is given
+//   - discard: do not keep the message in the inbox
+//   - keep: keep the message in the inbox, also when other rules file it
+//
+// Like in Sieve, a message is kept in the inbox unless a matching rule
+// files, forwards or discards it, and stop:1 ends the evaluation when the
+// rule matches.
+package mailrules
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+)
+
+// Actor is the heroscript actor of rules
+const Actor = "mailrule"
+
+// Inbox is the folder messages are kept in
+const Inbox = "inbox"
+
+// Actions of rules
+const (
+	ActionFile    = "file"
+	ActionRead    = "read"
+	ActionForward = "forward"
+	ActionDiscard = "discard"
+	ActionKeep    = "keep"
+)
+
+// Rule is one rule of a rule set
+type Rule struct {
+	Action string
+	// Folder is where file delivers to
+	Folder string
+	// ForwardTo is where forward sends to, Copy also keeps the message
+	ForwardTo string
+	Copy      bool
+	// Stop ends the evaluation when the rule matches
+	Stop bool
+
+	From       string
+	To         string
+	Subject    string
+	HeaderName string
+	HeaderText string
+	SizeOver   int64
+}
+
+// RuleSet is the rules of a user, in order
+type RuleSet struct {
+	Rules []Rule
+}
+
+// Message is what rules are evaluated against
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	// Header holds the header fields by canonical name, as in
+	// net/textproto.MIMEHeader
+	Header map[string][]string
+	Size   int64
+}
+
+// Result is the outcome of evaluating rules
+type Result struct {
+	// Folders are the folders to deliver to, none if the message is discarded
+	Folders []string
+	// Seen marks the message as read
+	Seen bool
+	// Forward are the addresses to send the message on to
+	Forward []string
+}
+
+// Parse parses heroscript rules. Actions of other actors are not allowed.
+func Parse(script string) (*RuleSet, error) {
+	rules := &RuleSet{}
+	if strings.TrimSpace(script) == "" {
+		return rules, nil
+	}
+	pb, err := playbook.NewFromText(script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+
+	for _, action := range pb.Actions {
+		if action.Actor != Actor {
+			return nil, fmt.Errorf("unknown rule !!%s.%s, rules are !!%s actions", action.Actor, action.Name, Actor)
+		}
+		params := action.Params
+		rule := Rule{
+			Action:    action.Name,
+			Folder:    params.GetVerbatim("folder"),
+			ForwardTo: strings.TrimSpace(params.GetVerbatim("to")),
+			Copy:      params.GetBool("copy"),
+			Stop:      params.GetBool("stop"),
+			From:      strings.ToLower(params.GetVerbatim("from")),
+			Subject:   strings.ToLower(params.GetVerbatim("subject")),
+		}
+
+		switch rule.Action {
+		case ActionFile:
+			folder, err := normalizeFolder(rule.Folder)
+			if err != nil {
+				return nil, err
+			}
+			rule.Folder = folder
+		case ActionForward:
+			if !strings.Contains(rule.ForwardTo, "@") {
+				return nil, fmt.Errorf("!!%s.forward needs an address in to:", Actor)
+			}
+		case ActionRead, ActionDiscard, ActionKeep:
+		default:
+			return nil, fmt.Errorf("unknown rule !!%s.%s", Actor, rule.Action)
+		}
+		// The recipient condition of forward is its address
+		if rule.Action != ActionForward {
+			rule.To = strings.ToLower(params.GetVerbatim("to"))
+		}
+
+		if header := params.GetVerbatim("header"); header != "" {
+			name, text, _ := strings.Cut(header, ":")
+			rule.HeaderName = strings.TrimSpace(name)
+			rule.HeaderText = strings.ToLower(strings.TrimSpace(text))
+		}
+		if params.Has("size_over") {
+			size, err := params.GetSize("size_over")
+			if err != nil {
+				return nil, err
+			}
+			rule.SizeOver = size
+		}
+		rules.Rules = append(rules.Rules, rule)
+	}
+	return rules, nil
+}
+
+// Evaluate runs the rules for a message
+func (rs *RuleSet) Evaluate(msg *Message) Result {
+	var result Result
+	keep, explicitKeep := true, false
+	for _, rule := range rs.Rules {
+		if !rule.matches(msg) {
+			continue
+		}
+		switch rule.Action {
+		case ActionFile:
+			result.Folders = appendOnce(result.Folders, rule.Folder)
+			keep = false
+		case ActionRead:
+			result.Seen = true
+		case ActionForward:
+			result.Forward = appendOnce(result.Forward, rule.ForwardTo)
+			if !rule.Copy {
+				keep = false
+			}
+		case ActionDiscard:
+			keep = false
+		case ActionKeep:
+			explicitKeep = true
+		}
+		if rule.Stop {
+			break
+		}
+	}
+	if keep || explicitKeep {
+		result.Folders = appendOnce(result.Folders, Inbox)
+	}
+	return result
+}
+
+// matches checks the conditions of a rule
+func (r *Rule) matches(msg *Message) bool {
+	if r.From != "" && !strings.Contains(strings.ToLower(msg.From), r.From) {
+		return false
+	}
+	if r.To != "" {
+		found := false
+		for _, to := range msg.To {
+			if strings.Contains(strings.ToLower(to), r.To) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.Subject != "" && !strings.Contains(strings.ToLower(msg.Subject), r.Subject) {
+		return false
+	}
+	if r.HeaderName != "" {
+		values, ok := msg.Header[textproto.CanonicalMIMEHeaderKey(r.HeaderName)]
+		if !ok {
+			return false
+		}
+		found := r.HeaderText == ""
+		for _, value := range values {
+			if strings.Contains(strings.ToLower(value), r.HeaderText) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.SizeOver > 0 && msg.Size <= r.SizeOver {
+		return false
+	}
+	return true
+}
+
+// normalizeFolder returns the form a folder is stored under by the IMAP
+// server: lower case, without leading or trailing slashes
+func normalizeFolder(folder string) (string, error) {
+	folder = strings.Trim(strings.ToLower(strings.TrimSpace(folder)), "/")
+	if folder == "" {
+		return "", fmt.Errorf("!!%s.file needs a folder", Actor)
+	}
+	if strings.ContainsAny(folder, ":*?[]%\\") {
+		return "", fmt.Errorf("folder %q must not contain any of : * ? [ ] %% \\", folder)
+	}
+	return folder, nil
+}
+
+// appendOnce appends a value that is not in the list yet
+func appendOnce(list []string, value string) []string {
+	for _, v := range list {
+		if v == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
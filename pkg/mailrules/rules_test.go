@@ -0,0 +1,119 @@
+package mailrules
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testRules = `
+!!mailrule.file from:'@work.example' folder:'Work/Projects'
+!!mailrule.read subject:'newsletter'
+!!mailrule.file subject:'newsletter' folder:'news'
+!!mailrule.forward to:'me@example.org' subject:'urgent' copy:1
+!!mailrule.forward to:'archive@example.org' header:'X-Archive'
+!!mailrule.discard header:'X-Spam-Flag: yes' stop:1
+!!mailrule.file size_over:'1kb' folder:'large'
+`
+
+func TestParse(t *testing.T) {
+	rules, err := Parse(testRules)
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+	if len(rules.Rules) != 7 {
+		t.Fatalf("Expected 7 rules, got %d", len(rules.Rules))
+	}
+	if rule := rules.Rules[0]; rule.Action != ActionFile || rule.Folder != "work/projects" || rule.From != "@work.example" {
+		t.Errorf("Unexpected first rule: %+v", rule)
+	}
+	if rule := rules.Rules[3]; rule.ForwardTo != "me@example.org" || rule.To != "" || !rule.Copy {
+		t.Errorf("Unexpected forward rule: %+v", rule)
+	}
+	if rule := rules.Rules[5]; rule.HeaderName != "X-Spam-Flag" || rule.HeaderText != "yes" || !rule.Stop {
+		t.Errorf("Unexpected discard rule: %+v", rule)
+	}
+	if rule := rules.Rules[6]; rule.SizeOver != 1024 {
+		t.Errorf("Expected size_over of 1024, got %d", rule.SizeOver)
+	}
+
+	for _, script := range []string{
+		"!!mailrule.bounce from:'x'",
+		"!!mailuser.add name:alice",
+		"!!mailrule.file from:'x'",
+		"!!mailrule.file folder:'a:b'",
+		"!!mailrule.forward to:'nobody'",
+		"!!mailrule.read size_over:'big'",
+	} {
+		if _, err := Parse(script); err == nil {
+			t.Errorf("Expected an error for %q", script)
+		}
+	}
+
+	if rules, err := Parse(""); err != nil || len(rules.Rules) != 0 {
+		t.Errorf("Expected no rules for an empty script, got %v, %v", rules, err)
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	rules, err := Parse(testRules)
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		msg  Message
+		want Result
+	}{
+		{
+			name: "no rule matches",
+			msg:  Message{From: "friend@example.com", Subject: "Hello"},
+			want: Result{Folders: []string{Inbox}},
+		},
+		{
+			name: "filed",
+			msg:  Message{From: "Boss@Work.example", Subject: "Plans"},
+			want: Result{Folders: []string{"work/projects"}},
+		},
+		{
+			name: "read and filed",
+			msg:  Message{From: "news@example.com", Subject: "Weekly Newsletter"},
+			want: Result{Folders: []string{"news"}, Seen: true},
+		},
+		{
+			name: "forwarded with a copy",
+			msg:  Message{From: "friend@example.com", Subject: "URGENT"},
+			want: Result{Folders: []string{Inbox}, Forward: []string{"me@example.org"}},
+		},
+		{
+			name: "forwarded",
+			msg:  Message{From: "friend@example.com", Header: map[string][]string{"X-Archive": {""}}},
+			want: Result{Forward: []string{"archive@example.org"}},
+		},
+		{
+			name: "discarded and stopped",
+			msg:  Message{From: "spammer@example.com", Size: 4096, Header: map[string][]string{"X-Spam-Flag": {"YES"}}},
+			want: Result{},
+		},
+		{
+			name: "large",
+			msg:  Message{From: "friend@example.com", Size: 4096},
+			want: Result{Folders: []string{"large"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := rules.Evaluate(&test.msg); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Expected %+v, got %+v", test.want, got)
+			}
+		})
+	}
+
+	keep, err := Parse("!!mailrule.keep\n!!mailrule.file folder:'copy'")
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+	if got := keep.Evaluate(&Message{}); !reflect.DeepEqual(got.Folders, []string{"copy", Inbox}) {
+		t.Errorf("Expected the message to be filed and kept, got %v", got.Folders)
+	}
+}
@@ -0,0 +1,282 @@
+// Package mailsearch keeps a full-text index of the messages in the Redis
+// mail store, so they can be searched without reading every message.
+//
+// The index is an inverted index in Redis hashes. For every word of a
+// user's messages, the hash mail:index:<username>:<word> holds the keys of
+// the messages containing it, and the hash mail:indexed:<username> holds
+// the words of every indexed message by its key, to remove the message
+// again. Words are taken from the subject, the addresses, the body and the
+// attachment names, and are matched whole and case insensitively.
+//
+// Messages are indexed when they are stored. Entries of messages deleted
+// without updating the index are dropped when a search finds them.
+package mailsearch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// maxWordLength is the length in bytes above which words are not indexed,
+// as they are mostly encoded data rather than text
+const maxWordLength = 64
+
+// wordKey returns the hash of the messages of a user containing a word
+func wordKey(username, word string) string {
+	return fmt.Sprintf("mail:index:%s:%s", username, word)
+}
+
+// indexedKey returns the hash of the words of a user's indexed messages
+func indexedKey(username string) string {
+	return fmt.Sprintf("mail:indexed:%s", username)
+}
+
+// Index is the full-text index of the mail store
+type Index struct {
+	redisClient *redis.Client
+	ctx         context.Context
+}
+
+// NewIndex creates an index on top of a Redis client
+func NewIndex(redisClient *redis.Client) *Index {
+	return &Index{
+		redisClient: redisClient,
+		ctx:         context.Background(),
+	}
+}
+
+// Words splits text into the words the index uses: runs of letters and
+// digits, in lower case, each once and in the order they first appear.
+// Words of a single character are left out.
+func Words(text string) []string {
+	var words []string
+	seen := make(map[string]bool)
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range fields {
+		if utf8.RuneCountInString(word) < 2 || len(word) > maxWordLength || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+	}
+	return words
+}
+
+// email is what the index reads of the email JSON of the mail store
+type email struct {
+	Message     string `json:"message"`
+	Attachments []struct {
+		Filename string `json:"filename"`
+	} `json:"attachments"`
+	Envelope *struct {
+		Subject string   `json:"subject"`
+		From    []string `json:"from"`
+		To      []string `json:"to"`
+		Cc      []string `json:"cc"`
+	} `json:"envelope"`
+}
+
+// emailWords returns the words of the subject, addresses, body and
+// attachment names of an email
+func emailWords(emailJSON []byte) ([]string, error) {
+	var e email
+	if err := json.Unmarshal(emailJSON, &e); err != nil {
+		return nil, fmt.Errorf("failed to parse email: %w", err)
+	}
+	parts := []string{e.Message}
+	if e.Envelope != nil {
+		parts = append(parts, e.Envelope.Subject)
+		parts = append(parts, e.Envelope.From...)
+		parts = append(parts, e.Envelope.To...)
+		parts = append(parts, e.Envelope.Cc...)
+	}
+	for _, attachment := range e.Attachments {
+		parts = append(parts, attachment.Filename)
+	}
+	return Words(strings.Join(parts, " ")), nil
+}
+
+// Add indexes the email JSON of a message of a user stored at key,
+// replacing what was indexed for the key before
+func (x *Index) Add(username, key string, emailJSON []byte) error {
+	words, err := emailWords(emailJSON)
+	if err != nil {
+		return err
+	}
+	if err := x.Remove(username, key); err != nil {
+		return err
+	}
+	if len(words) == 0 {
+		return nil
+	}
+
+	_, err = x.redisClient.Pipelined(x.ctx, func(pipe redis.Pipeliner) error {
+		for _, word := range words {
+			pipe.HSet(x.ctx, wordKey(username, word), key, "1")
+		}
+		pipe.HSet(x.ctx, indexedKey(username), key, strings.Join(words, " "))
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to index message %s: %w", key, err)
+	}
+	return nil
+}
+
+// Remove removes a message of a user from the index
+func (x *Index) Remove(username, key string) error {
+	value, err := x.redisClient.HGet(x.ctx, indexedKey(username), key).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	_, err = x.redisClient.Pipelined(x.ctx, func(pipe redis.Pipeliner) error {
+		for _, word := range strings.Fields(value) {
+			pipe.HDel(x.ctx, wordKey(username, word), key)
+		}
+		pipe.HDel(x.ctx, indexedKey(username), key)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove message %s from the index: %w", key, err)
+	}
+	return nil
+}
+
+// Move moves the index entry of a message of a user stored under a new key,
+// like when its mailbox is renamed
+func (x *Index) Move(username, oldKey, newKey string) error {
+	value, err := x.redisClient.HGet(x.ctx, indexedKey(username), oldKey).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	_, err = x.redisClient.Pipelined(x.ctx, func(pipe redis.Pipeliner) error {
+		for _, word := range strings.Fields(value) {
+			pipe.HSet(x.ctx, wordKey(username, word), newKey, "1")
+			pipe.HDel(x.ctx, wordKey(username, word), oldKey)
+		}
+		pipe.HSet(x.ctx, indexedKey(username), newKey, value)
+		pipe.HDel(x.ctx, indexedKey(username), oldKey)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to move message %s in the index: %w", oldKey, err)
+	}
+	return nil
+}
+
+// IndexedKeys returns the keys of the indexed messages of a user
+func (x *Index) IndexedKeys(username string) (map[string]bool, error) {
+	keys, err := x.redisClient.HKeys(x.ctx, indexedKey(username)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	indexed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		indexed[key] = true
+	}
+	return indexed, nil
+}
+
+// Search returns the sorted keys of the messages of a user containing all
+// words of a query, in a mailbox or, if mailbox is empty, in all mailboxes
+func (x *Index) Search(username, query, mailbox string) ([]string, error) {
+	words := Words(query)
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	var matches map[string]bool
+	for _, word := range words {
+		keys, err := x.redisClient.HKeys(x.ctx, wordKey(username, word)).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to search index: %w", err)
+		}
+		found := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			if matches == nil || matches[key] {
+				found[key] = true
+			}
+		}
+		matches = found
+		if len(matches) == 0 {
+			return nil, nil
+		}
+	}
+
+	prefix := fmt.Sprintf("mail:in:%s:%s:", username, strings.Trim(strings.ToLower(mailbox), "/"))
+	var keys []string
+	for key := range matches {
+		if mailbox != "" && !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		// Drop the entries of messages deleted without updating the index
+		exists, err := x.redisClient.Exists(x.ctx, key).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to check message %s: %w", key, err)
+		}
+		if exists == 0 {
+			if err := x.Remove(username, key); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Reindex indexes all messages of a user again, for messages stored before
+// the index or by tools that do not update it, and returns their number
+func (x *Index) Reindex(username string) (int, error) {
+	indexed, err := x.IndexedKeys(username)
+	if err != nil {
+		return 0, err
+	}
+	for key := range indexed {
+		if err := x.Remove(username, key); err != nil {
+			return 0, err
+		}
+	}
+
+	keys, err := x.redisClient.Keys(x.ctx, fmt.Sprintf("mail:in:%s:*", username)).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list messages: %w", err)
+	}
+	count := 0
+	for _, key := range keys {
+		value, err := x.redisClient.Get(x.ctx, key).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return count, fmt.Errorf("failed to get message %s: %w", key, err)
+		}
+		if _, err := emailWords([]byte(value)); err != nil {
+			// Not an email
+			continue
+		}
+		if err := x.Add(username, key, []byte(value)); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,107 @@
+package mailsearch
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/redisserver/redistest"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestIndex(t *testing.T) (*Index, *redis.Client) {
+	client := redistest.NewClient(t)
+	return NewIndex(client), client
+}
+
+func TestWords(t *testing.T) {
+	got := Words("Invoice #42 for ACME-Corp: invoice, a résumé.pdf")
+	want := []string{"invoice", "42", "for", "acme", "corp", "résumé", "pdf"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	index, client := newTestIndex(t)
+	ctx := context.Background()
+
+	store := func(key, subject, body, attachment string) {
+		emailJSON := fmt.Sprintf(`{"message":%q,"attachments":[{"filename":%q}],"envelope":{"subject":%q,"from":["bob@example.com"],"to":["alice@example.org"]}}`, body, attachment, subject)
+		if err := client.Set(ctx, key, emailJSON, 0).Err(); err != nil {
+			t.Fatalf("Failed to store message: %v", err)
+		}
+		if err := index.Add("alice", key, []byte(emailJSON)); err != nil {
+			t.Fatalf("Failed to index message: %v", err)
+		}
+	}
+	store("mail:in:alice:inbox:1", "Invoice March", "Please pay the invoice.", "")
+	store("mail:in:alice:work:2", "Report", "The invoice is attached.", "march-invoice.pdf")
+	store("mail:in:alice:work/old:3", "Old invoice", "Paid in march.", "")
+
+	tests := []struct {
+		query   string
+		mailbox string
+		keys    []string
+	}{
+		{"invoice march", "", []string{"mail:in:alice:inbox:1", "mail:in:alice:work/old:3", "mail:in:alice:work:2"}},
+		{"INVOICE pdf", "", []string{"mail:in:alice:work:2"}},
+		{"invoice", "Work", []string{"mail:in:alice:work:2"}},
+		{"bob example", "inbox", []string{"mail:in:alice:inbox:1"}},
+		{"invo", "", nil},
+		{"", "", nil},
+	}
+	for _, test := range tests {
+		keys, err := index.Search("alice", test.query, test.mailbox)
+		if err != nil {
+			t.Fatalf("Search(%q) failed: %v", test.query, err)
+		}
+		if !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("Search(%q, %q): expected %v, got %v", test.query, test.mailbox, test.keys, keys)
+		}
+	}
+
+	// Indexing a key again replaces its words
+	store("mail:in:alice:inbox:1", "Lunch", "Pizza?", "")
+	if keys, _ := index.Search("alice", "invoice", "inbox"); len(keys) != 0 {
+		t.Errorf("Expected the old words to be removed, got %v", keys)
+	}
+
+	// Deleted messages are dropped from the index
+	client.Del(ctx, "mail:in:alice:work:2")
+	if keys, _ := index.Search("alice", "invoice", ""); !reflect.DeepEqual(keys, []string{"mail:in:alice:work/old:3"}) {
+		t.Errorf("Expected the deleted message to be left out, got %v", keys)
+	}
+	if indexed, _ := index.IndexedKeys("alice"); indexed["mail:in:alice:work:2"] {
+		t.Error("Expected the deleted message to be removed from the index")
+	}
+
+	// Moved messages are found under their new key
+	value, _ := client.Get(ctx, "mail:in:alice:work/old:3").Result()
+	client.Set(ctx, "mail:in:alice:archive:3", value, 0)
+	client.Del(ctx, "mail:in:alice:work/old:3")
+	if err := index.Move("alice", "mail:in:alice:work/old:3", "mail:in:alice:archive:3"); err != nil {
+		t.Fatalf("Failed to move message: %v", err)
+	}
+	if keys, _ := index.Search("alice", "paid", ""); !reflect.DeepEqual(keys, []string{"mail:in:alice:archive:3"}) {
+		t.Errorf("Expected the moved message, got %v", keys)
+	}
+
+	if err := index.Add("alice", "mail:in:alice:inbox:4", []byte("not json")); err == nil {
+		t.Error("Expected an error for a message that is not an email")
+	}
+	if count, err := index.Reindex("alice"); err != nil || count != 2 {
+		t.Errorf("Expected 2 messages to be indexed again, got %d, %v", count, err)
+	}
+	if keys, _ := index.Search("alice", "pizza", ""); !reflect.DeepEqual(keys, []string{"mail:in:alice:inbox:1"}) {
+		t.Errorf("Expected the reindexed message, got %v", keys)
+	}
+
+	if err := index.Remove("alice", "mail:in:alice:archive:3"); err != nil {
+		t.Fatalf("Failed to remove message: %v", err)
+	}
+	if keys, _ := index.Search("alice", "march", ""); len(keys) != 0 {
+		t.Errorf("Expected no matches after removing, got %v", keys)
+	}
+}
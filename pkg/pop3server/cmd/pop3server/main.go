@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/mailblob"
+	"github.com/freeflowuniverse/herolauncher/pkg/pop3server"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfslocal"
+	"github.com/redis/go-redis/v9"
+)
+
+func main() {
+	// Parse command line flags
+	redisAddr := flag.String("redis-addr", "localhost:6378", "Redis server address")
+	pop3Addr := flag.String("pop3-addr", ":1110", "POP3 server address")
+	attachmentDir := flag.String("attachment-dir", "", "Directory the SMTP server stores large attachments in")
+	idleTimeout := flag.Duration("idle-timeout", pop3server.DefaultIdleTimeout, "Close connections on which the client sent nothing for this long (0 disables)")
+	flag.Parse()
+
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: *redisAddr,
+	})
+	server := pop3server.NewServer(redisClient, *pop3Addr)
+	server.IdleTimeout = *idleTimeout
+	if *attachmentDir != "" {
+		fs, err := vfslocal.New(*attachmentDir)
+		if err != nil {
+			log.Fatalf("Failed to open attachment directory: %v", err)
+		}
+		server.SetAttachmentStore(mailblob.NewStore(fs, mailblob.DefaultThreshold))
+	}
+
+	// Set up signal handling for graceful shutdown
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	// Start the POP3 server in a goroutine
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("Starting POP3 server on %s with Redis at %s", *pop3Addr, *redisAddr)
+		if err := server.Start(); err != nil {
+			errCh <- err
+		}
+	}()
+
+	// Wait for either an error or a signal
+	select {
+	case err := <-errCh:
+		log.Fatalf("POP3 server error: %v", err)
+	case sig := <-sigs:
+		log.Printf("Received signal %v, shutting down", sig)
+		server.Close()
+	}
+}
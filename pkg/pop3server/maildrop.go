@@ -0,0 +1,159 @@
+package pop3server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/mail"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailrender"
+	"github.com/redis/go-redis/v9"
+)
+
+// message is a message of a maildrop, numbered from 1 in the order of
+// their UIDs
+type message struct {
+	key     string
+	uid     uint32
+	data    []byte
+	deleted bool
+}
+
+// maildrop is the inbox of a user as a POP3 session sees it: the messages
+// present when the session started, with the ones marked for deletion
+type maildrop struct {
+	server   *Server
+	username string
+	messages []*message
+}
+
+// loadMaildrop reads the inbox of a user. Messages the IMAP server marked
+// \Deleted but did not expunge yet are left out.
+func loadMaildrop(s *Server, username string) (*maildrop, error) {
+	pattern := fmt.Sprintf("mail:in:%s:inbox:*", username)
+	keys, err := s.redisClient.Keys(s.ctx, pattern).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+
+	drop := &maildrop{server: s, username: username}
+	for _, key := range keys {
+		uid, err := strconv.ParseUint(key[strings.LastIndex(key, ":")+1:], 10, 32)
+		if err != nil {
+			continue
+		}
+		value, err := s.redisClient.Get(s.ctx, key).Result()
+		if err == redis.Nil {
+			// Deleted in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get message %s: %w", key, err)
+		}
+
+		var email mail.Email
+		if err := json.Unmarshal([]byte(value), &email); err != nil {
+			log.Printf("ERROR: Failed to parse message %s: %v", key, err)
+			continue
+		}
+		if hasFlag(email.Flags, "\\Deleted") {
+			continue
+		}
+		resolved := &email
+		if s.attachments != nil {
+			if resolved, err = s.attachments.Resolve(&email); err != nil {
+				log.Printf("ERROR: Failed to read attachments of message %s: %v", key, err)
+				continue
+			}
+		}
+		data, err := mailrender.Render(resolved)
+		if err != nil {
+			log.Printf("ERROR: Failed to render message %s: %v", key, err)
+			continue
+		}
+		drop.messages = append(drop.messages, &message{key: key, uid: uint32(uid), data: data})
+	}
+	sort.Slice(drop.messages, func(i, j int) bool {
+		return drop.messages[i].uid < drop.messages[j].uid
+	})
+	return drop, nil
+}
+
+// message returns a message by its number, unless it is marked for deletion
+func (d *maildrop) message(arg string) (*message, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 || n > len(d.messages) {
+		return nil, fmt.Errorf("no such message")
+	}
+	msg := d.messages[n-1]
+	if msg.deleted {
+		return nil, fmt.Errorf("message %d already deleted", n)
+	}
+	return msg, nil
+}
+
+// stat returns the number and total size of the messages not marked for
+// deletion
+func (d *maildrop) stat() (int, int) {
+	count, size := 0, 0
+	for _, msg := range d.messages {
+		if !msg.deleted {
+			count++
+			size += len(msg.data)
+		}
+	}
+	return count, size
+}
+
+// reset unmarks the messages marked for deletion
+func (d *maildrop) reset() {
+	for _, msg := range d.messages {
+		msg.deleted = false
+	}
+}
+
+// update deletes the messages marked for deletion, removing them from the
+// search index and recording them as expunged like the IMAP server does,
+// so its QRESYNC clients see them go
+func (d *maildrop) update() error {
+	s := d.server
+	for _, msg := range d.messages {
+		if !msg.deleted {
+			continue
+		}
+		if err := s.redisClient.Del(s.ctx, msg.key).Err(); err != nil {
+			return fmt.Errorf("failed to delete message %s: %w", msg.key, err)
+		}
+		log.Printf("Deleted message %s", msg.key)
+		if err := s.index.Remove(d.username, msg.key); err != nil {
+			log.Printf("ERROR: Failed to remove %s from the search index: %v", msg.key, err)
+		}
+
+		if err := s.redisClient.HDel(s.ctx, fmt.Sprintf("mail:modseqs:%s", d.username), msg.key).Err(); err != nil {
+			log.Printf("ERROR: Failed to remove modification sequence of %s: %v", msg.key, err)
+		}
+		modSeq, err := s.redisClient.Incr(s.ctx, fmt.Sprintf("mail:highestmodseq:%s", d.username)).Result()
+		if err != nil {
+			log.Printf("ERROR: Failed to record expunge of %s: %v", msg.key, err)
+			continue
+		}
+		uid := strconv.FormatUint(uint64(msg.uid), 10)
+		if err := s.redisClient.HSet(s.ctx, fmt.Sprintf("mail:vanished:%s:inbox", d.username), uid, modSeq).Err(); err != nil {
+			log.Printf("ERROR: Failed to record expunge of %s: %v", msg.key, err)
+		}
+	}
+	return nil
+}
+
+// hasFlag reports whether a list of IMAP flags contains a flag
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if strings.EqualFold(f, flag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+// Package pop3server serves the inboxes of the Redis mail store over POP3
+// (RFC 1939), for clients and devices that do not speak IMAP. Users log in
+// with USER and PASS as the mail users shared with the IMAP and SMTP
+// servers, and see the messages stored at mail:in:<username>:inbox:<uid>,
+// rendered like the IMAP server renders them.
+package pop3server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/mailauth"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailblob"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailsearch"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultIdleTimeout is how long a client may send nothing before it is
+// disconnected, the minimum RFC 1939 allows
+const DefaultIdleTimeout = 10 * time.Minute
+
+// Server represents a POP3 server
+type Server struct {
+	redisClient *redis.Client
+	users       *mailauth.Store
+	index       *mailsearch.Index
+	// attachments holds the attachments stored outside of Redis
+	attachments *mailblob.Store
+	addr        string
+	ctx         context.Context
+
+	// IdleTimeout closes connections on which the client sent nothing for
+	// this long
+	IdleTimeout time.Duration
+
+	mu       sync.Mutex
+	listener net.Listener
+	// locked are the users whose inbox is open in a session, as POP3 gives
+	// a session exclusive access
+	locked map[string]bool
+}
+
+// NewServer creates a new POP3 server
+func NewServer(redisClient *redis.Client, addr string) *Server {
+	return &Server{
+		redisClient: redisClient,
+		users:       mailauth.NewStore(redisClient),
+		index:       mailsearch.NewIndex(redisClient),
+		addr:        addr,
+		ctx:         context.Background(),
+		IdleTimeout: DefaultIdleTimeout,
+		locked:      make(map[string]bool),
+	}
+}
+
+// Start starts the POP3 server
+func (s *Server) Start() error {
+	log.Printf("Starting POP3 server on %s", s.addr)
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts POP3 connections on a listener
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go newSession(s, conn).serve()
+	}
+}
+
+// Close stops the POP3 server
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// SetAttachmentStore makes the server read the attachments that the SMTP
+// server stored outside of Redis from an attachment store
+func (s *Server) SetAttachmentStore(store *mailblob.Store) {
+	s.attachments = store
+}
+
+// lock gives a session exclusive access to the inbox of a user
+func (s *Server) lock(username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locked[username] {
+		return fmt.Errorf("[IN-USE] maildrop of %s is already locked", username)
+	}
+	s.locked[username] = true
+	return nil
+}
+
+// unlock releases the inbox of a user
+func (s *Server) unlock(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.locked, username)
+}
+
+// ListenAndServe starts the POP3 server and listens for connections
+func ListenAndServe(redisAddr, pop3Addr string) error {
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+	})
+	return NewServer(redisClient, pop3Addr).Start()
+}
@@ -0,0 +1,306 @@
+package pop3server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/mailauth"
+)
+
+// maxAuthFailures is how many failed logins a connection gets before it is
+// closed
+const maxAuthFailures = 3
+
+// capabilities are the CAPA (RFC 2449) capabilities of the server
+var capabilities = []string{"USER", "TOP", "UIDL", "RESP-CODES", "AUTH-RESP-CODE", "PIPELINING", "IMPLEMENTATION herolauncher"}
+
+// session is a POP3 connection. It is in the AUTHORIZATION state until the
+// client logs in, then in the TRANSACTION state with the maildrop of the
+// user, which is updated when the client quits.
+type session struct {
+	server *Server
+	conn   net.Conn
+	text   *textproto.Conn
+
+	username string
+	failures int
+	drop     *maildrop
+}
+
+// newSession creates a session for a connection
+func newSession(s *Server, conn net.Conn) *session {
+	return &session{
+		server: s,
+		conn:   conn,
+		text:   textproto.NewConn(conn),
+	}
+}
+
+// serve reads commands until the client quits or disconnects
+func (c *session) serve() {
+	defer c.close()
+	log.Printf("POP3 connection from %s", c.conn.RemoteAddr())
+	c.ok("POP3 server ready")
+
+	for {
+		if c.server.IdleTimeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(c.server.IdleTimeout))
+		}
+		line, err := c.text.ReadLine()
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("POP3 connection from %s closed: %v", c.conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		command, arg, _ := strings.Cut(line, " ")
+		if !c.handle(strings.ToUpper(command), strings.TrimSpace(arg)) {
+			return
+		}
+	}
+}
+
+// close releases the maildrop of the session and closes the connection.
+// Messages marked for deletion are only deleted on QUIT.
+func (c *session) close() {
+	if c.drop != nil {
+		c.server.unlock(c.username)
+	}
+	c.text.Close()
+}
+
+// handle runs a command, returning false when the connection is to be closed
+func (c *session) handle(command, arg string) bool {
+	switch command {
+	case "CAPA":
+		c.ok("Capability list follows")
+		c.lines(capabilities)
+		return true
+	case "QUIT":
+		return c.quit()
+	}
+	if c.drop == nil {
+		return c.authorization(command, arg)
+	}
+	c.transaction(command, arg)
+	return true
+}
+
+// authorization handles the commands of the AUTHORIZATION state
+func (c *session) authorization(command, arg string) bool {
+	switch command {
+	case "USER":
+		if arg == "" {
+			c.err("USER needs a name")
+			return true
+		}
+		c.username = arg
+		c.ok("send PASS")
+	case "PASS":
+		if c.username == "" {
+			c.err("send USER first")
+			return true
+		}
+		return c.login(arg)
+	default:
+		c.err("unknown command or not logged in")
+	}
+	return true
+}
+
+// login checks the password of the user given with USER and opens the
+// user's maildrop
+func (c *session) login(password string) bool {
+	username, err := c.server.users.Authenticate(c.username, password)
+	c.username = ""
+	if err != nil {
+		if !errors.Is(err, mailauth.ErrInvalidCredentials) {
+			log.Printf("ERROR: Failed to authenticate: %v", err)
+			c.err("[SYS/TEMP] authentication failed")
+			return true
+		}
+		c.failures++
+		c.err("[AUTH] invalid username or password")
+		return c.failures < maxAuthFailures
+	}
+
+	if err := c.server.lock(username); err != nil {
+		c.err("%s", err)
+		return true
+	}
+	drop, err := loadMaildrop(c.server, username)
+	if err != nil {
+		c.server.unlock(username)
+		log.Printf("ERROR: Failed to open the maildrop of %s: %v", username, err)
+		c.err("[SYS/TEMP] failed to open maildrop")
+		return true
+	}
+	c.username = username
+	c.drop = drop
+	count, size := drop.stat()
+	log.Printf("POP3 user %s logged in from %s", username, c.conn.RemoteAddr())
+	c.ok("%s has %d messages (%d octets)", username, count, size)
+	return true
+}
+
+// transaction handles the commands of the TRANSACTION state
+func (c *session) transaction(command, arg string) {
+	switch command {
+	case "STAT":
+		count, size := c.drop.stat()
+		c.ok("%d %d", count, size)
+	case "LIST":
+		c.list(arg, func(n int, msg *message) string {
+			return fmt.Sprintf("%d %d", n, len(msg.data))
+		})
+	case "UIDL":
+		c.list(arg, func(n int, msg *message) string {
+			return fmt.Sprintf("%d %d", n, msg.uid)
+		})
+	case "RETR":
+		msg, err := c.drop.message(arg)
+		if err != nil {
+			c.err("%s", err)
+			return
+		}
+		c.ok("%d octets", len(msg.data))
+		c.message(msg.data, -1)
+	case "TOP":
+		fields := strings.Fields(arg)
+		if len(fields) != 2 {
+			c.err("TOP needs a message and a number of lines")
+			return
+		}
+		msg, err := c.drop.message(fields[0])
+		if err != nil {
+			c.err("%s", err)
+			return
+		}
+		lines, err := strconv.Atoi(fields[1])
+		if err != nil || lines < 0 {
+			c.err("invalid number of lines")
+			return
+		}
+		c.ok("top of message follows")
+		c.message(msg.data, lines)
+	case "DELE":
+		msg, err := c.drop.message(arg)
+		if err != nil {
+			c.err("%s", err)
+			return
+		}
+		msg.deleted = true
+		c.ok("message %s deleted", arg)
+	case "RSET":
+		c.drop.reset()
+		count, size := c.drop.stat()
+		c.ok("maildrop has %d messages (%d octets)", count, size)
+	case "NOOP":
+		c.ok("")
+	default:
+		c.err("unknown command")
+	}
+}
+
+// list answers LIST and UIDL, for one message or for all messages not
+// marked for deletion
+func (c *session) list(arg string, format func(n int, msg *message) string) {
+	if arg != "" {
+		msg, err := c.drop.message(arg)
+		if err != nil {
+			c.err("%s", err)
+			return
+		}
+		n, _ := strconv.Atoi(arg)
+		c.ok("%s", format(n, msg))
+		return
+	}
+
+	var lines []string
+	for i, msg := range c.drop.messages {
+		if !msg.deleted {
+			lines = append(lines, format(i+1, msg))
+		}
+	}
+	c.ok("%d messages", len(lines))
+	c.lines(lines)
+}
+
+// quit ends the session. In the TRANSACTION state the messages marked for
+// deletion are deleted first (the UPDATE state).
+func (c *session) quit() bool {
+	if c.drop == nil {
+		c.ok("bye")
+		return false
+	}
+	if err := c.drop.update(); err != nil {
+		log.Printf("ERROR: Failed to update the maildrop of %s: %v", c.username, err)
+		c.err("[SYS/TEMP] some deleted messages not removed")
+		return false
+	}
+	c.ok("bye")
+	return false
+}
+
+// message writes a message as a multi-line response, with only the header
+// and the first lines of the body if lines is not negative
+func (c *session) message(data []byte, lines int) {
+	w := c.text.DotWriter()
+	defer w.Close()
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	inBody := false
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if inBody && lines >= 0 {
+			if lines == 0 {
+				break
+			}
+			lines--
+		}
+		if line == "" {
+			inBody = true
+		}
+		fmt.Fprintf(w, "%s\n", line)
+	}
+}
+
+// lines writes a multi-line response
+func (c *session) lines(lines []string) {
+	w := c.text.DotWriter()
+	defer w.Close()
+	for _, line := range lines {
+		fmt.Fprintf(w, "%s\n", line)
+	}
+}
+
+// ok writes a positive response
+func (c *session) ok(format string, args ...interface{}) {
+	c.reply("+OK", format, args...)
+}
+
+// err writes a negative response
+func (c *session) err(format string, args ...interface{}) {
+	c.reply("-ERR", format, args...)
+}
+
+// reply writes a status line
+func (c *session) reply(status, format string, args ...interface{}) {
+	line := status
+	if text := fmt.Sprintf(format, args...); text != "" {
+		line += " " + text
+	}
+	if err := c.text.PrintfLine("%s", line); err != nil {
+		log.Printf("ERROR: Failed to write to %s: %v", c.conn.RemoteAddr(), err)
+	}
+}
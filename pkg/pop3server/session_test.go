@@ -0,0 +1,218 @@
+package pop3server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/mail"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailrender"
+	"github.com/freeflowuniverse/herolauncher/pkg/redisserver/redistest"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestServer starts a POP3 server on an in-memory Redis server, with
+// the user alice and the messages of her inbox
+func newTestServer(t *testing.T, messages map[string]*mail.Email) (*Server, *redis.Client) {
+	client := redistest.NewClient(t)
+	ctx := context.Background()
+
+	server := NewServer(client, "127.0.0.1:0")
+	if err := server.users.Add("alice", "secret"); err != nil {
+		t.Fatalf("Failed to add user: %v", err)
+	}
+	for key, email := range messages {
+		data, _ := json.Marshal(email)
+		client.Set(ctx, key, string(data), 0)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server.addr = listener.Addr().String()
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return server, client
+}
+
+// pop3Client is a connection to the test server
+type pop3Client struct {
+	t    *testing.T
+	text *textproto.Conn
+}
+
+// dial connects to the server and reads its greeting
+func dial(t *testing.T, server *Server) *pop3Client {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	c := &pop3Client{t: t, text: textproto.NewConn(conn)}
+	t.Cleanup(func() { c.text.Close() })
+	c.expect("+OK POP3 server ready")
+	return c
+}
+
+// cmd sends a command and returns its status line
+func (c *pop3Client) cmd(format string, args ...interface{}) string {
+	c.t.Helper()
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		c.t.Fatalf("Failed to send %q: %v", format, err)
+	}
+	line, err := c.text.ReadLine()
+	if err != nil {
+		c.t.Fatalf("Failed to read the response to %q: %v", format, err)
+	}
+	return line
+}
+
+// expect reads a status line
+func (c *pop3Client) expect(status string) {
+	c.t.Helper()
+	line, err := c.text.ReadLine()
+	if err != nil || line != status {
+		c.t.Fatalf("Expected %q, got %q, %v", status, line, err)
+	}
+}
+
+// lines reads a multi-line response
+func (c *pop3Client) lines() []string {
+	c.t.Helper()
+	lines, err := c.text.ReadDotLines()
+	if err != nil {
+		c.t.Fatalf("Failed to read the lines of the response: %v", err)
+	}
+	return lines
+}
+
+func TestSession(t *testing.T) {
+	first := &mail.Email{Message: "one\ntwo\nthree", Envelope: &mail.Envelope{Subject: "First"}}
+	second := &mail.Email{Message: "second", Envelope: &mail.Envelope{Subject: "Second"}}
+	messages := map[string]*mail.Email{
+		"mail:in:alice:inbox:7": second,
+		"mail:in:alice:inbox:3": first,
+		"mail:in:alice:inbox:5": {Message: "gone", Flags: []string{"\\Deleted"}},
+		"mail:in:bob:inbox:1":   {Message: "not alice's"},
+	}
+	server, client := newTestServer(t, messages)
+	firstData, _ := mailrender.Render(first)
+	secondData, _ := mailrender.Render(second)
+	size := len(firstData) + len(secondData)
+
+	c := dial(t, server)
+	steps := []struct {
+		command string
+		want    string
+		lines   []string
+	}{
+		{"STAT", "-ERR unknown command or not logged in", nil},
+		{"PASS secret", "-ERR send USER first", nil},
+		{"USER alice", "+OK send PASS", nil},
+		{"PASS wrong", "-ERR [AUTH] invalid username or password", nil},
+		{"USER alice", "+OK send PASS", nil},
+		{"PASS secret", "+OK alice has 2 messages (" + strconv.Itoa(size) + " octets)", nil},
+		{"STAT", "+OK 2 " + strconv.Itoa(size), nil},
+		{"LIST", "+OK 2 messages", []string{"1 " + strconv.Itoa(len(firstData)), "2 " + strconv.Itoa(len(secondData))}},
+		{"LIST 2", "+OK 2 " + strconv.Itoa(len(secondData)), nil},
+		{"LIST 3", "-ERR no such message", nil},
+		{"UIDL", "+OK 2 messages", []string{"1 3", "2 7"}},
+		{"UIDL 1", "+OK 1 3", nil},
+		{"DELE 1", "+OK message 1 deleted", nil},
+		{"DELE 1", "-ERR message 1 already deleted", nil},
+		{"RETR 1", "-ERR message 1 already deleted", nil},
+		{"STAT", "+OK 1 " + strconv.Itoa(len(secondData)), nil},
+		{"LIST", "+OK 1 messages", []string{"2 " + strconv.Itoa(len(secondData))}},
+		{"RSET", "+OK maildrop has 2 messages (" + strconv.Itoa(size) + " octets)", nil},
+		{"NOOP", "+OK", nil},
+		{"XYZZY", "-ERR unknown command", nil},
+		{"DELE 1", "+OK message 1 deleted", nil},
+	}
+	for _, step := range steps {
+		if got := c.cmd(step.command); got != step.want {
+			t.Fatalf("%s: expected %q, got %q", step.command, step.want, got)
+		}
+		if step.lines != nil {
+			if got := c.lines(); strings.Join(got, "|") != strings.Join(step.lines, "|") {
+				t.Errorf("%s: expected the lines %q, got %q", step.command, step.lines, got)
+			}
+		}
+	}
+
+	// RETR returns the rendered message, TOP its header and first lines
+	if got := c.cmd("RETR 2"); got != "+OK "+strconv.Itoa(len(secondData))+" octets" {
+		t.Fatalf("RETR: unexpected response %q", got)
+	}
+	if got := strings.Join(c.lines(), "\r\n") + "\r\n"; got != string(secondData)+"\r\n" {
+		t.Errorf("RETR: expected %q, got %q", secondData, got)
+	}
+	c.cmd("RSET")
+	if got := c.cmd("TOP 1 2"); got != "+OK top of message follows" {
+		t.Fatalf("TOP: unexpected response %q", got)
+	}
+	if lines := c.lines(); len(lines) < 3 || lines[len(lines)-2] != "one" || lines[len(lines)-1] != "two" {
+		t.Errorf("TOP: expected the header and two lines, got %q", lines)
+	}
+
+	// The maildrop is locked while the session has it open
+	other := dial(t, server)
+	other.cmd("USER alice")
+	if got := other.cmd("PASS secret"); got != "-ERR [IN-USE] maildrop of alice is already locked" {
+		t.Errorf("Expected the maildrop to be locked, got %q", got)
+	}
+
+	// The messages marked for deletion are deleted on QUIT
+	c.cmd("DELE 1")
+	if got := c.cmd("QUIT"); got != "+OK bye" {
+		t.Fatalf("QUIT: unexpected response %q", got)
+	}
+	ctx := context.Background()
+	if n, _ := client.Exists(ctx, "mail:in:alice:inbox:3").Result(); n != 0 {
+		t.Error("Expected the deleted message to be removed")
+	}
+	if n, _ := client.Exists(ctx, "mail:in:alice:inbox:7").Result(); n != 1 {
+		t.Error("Expected the other message to be kept")
+	}
+	if modSeq, err := client.HGet(ctx, "mail:vanished:alice:inbox", "3").Result(); err != nil || modSeq != "1" {
+		t.Errorf("Expected the expunge to be recorded for QRESYNC, got %q, %v", modSeq, err)
+	}
+
+	// The maildrop is unlocked once the session ended
+	for i := 0; ; i++ {
+		other.cmd("USER alice")
+		got := other.cmd("PASS secret")
+		if got == "+OK alice has 1 messages ("+strconv.Itoa(len(secondData))+" octets)" {
+			break
+		} else if i == 50 {
+			t.Fatalf("Expected the maildrop to be unlocked, got %q", got)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestAuthFailures(t *testing.T) {
+	server, _ := newTestServer(t, nil)
+	c := dial(t, server)
+	if got := c.cmd("CAPA"); got != "+OK Capability list follows" {
+		t.Fatalf("CAPA: unexpected response %q", got)
+	}
+	if lines := c.lines(); len(lines) != len(capabilities) || lines[0] != "USER" {
+		t.Errorf("CAPA: expected the capabilities, got %q", lines)
+	}
+
+	for i := 0; i < maxAuthFailures; i++ {
+		c.cmd("USER alice")
+		if got := c.cmd("PASS wrong"); got != "-ERR [AUTH] invalid username or password" {
+			t.Fatalf("Expected the login to fail, got %q", got)
+		}
+	}
+	if _, err := c.text.ReadLine(); err == nil {
+		t.Error("Expected the connection to be closed after too many failures")
+	}
+}
@@ -2,18 +2,36 @@ package processmanager
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 	"time"
 )
 
-// Client represents a client for the process manager telnet server
+const (
+	// clientTimeout is how long a command may take when its context has no
+	// deadline
+	clientTimeout = 5 * time.Second
+	// connectRetries is how often connecting is tried again, waiting
+	// connectRetryDelay and then twice as long every time, for a process
+	// manager that is starting up or restarting
+	connectRetries    = 5
+	connectRetryDelay = 200 * time.Millisecond
+)
+
+// Client represents a client for the process manager telnet server. Its
+// methods return the results as Go values and reconnect when the connection
+// was lost. A client runs one command at a time.
 type Client struct {
 	socketPath string
 	conn       net.Conn
 	reader     *bufio.Reader
 	secret     string
+	mutex      sync.Mutex
 }
 
 // NewClient creates a new process manager client
@@ -24,175 +42,550 @@ func NewClient(socketPath, secret string) *Client {
 	}
 }
 
-// Connect connects to the process manager telnet server
-func (c *Client) Connect() error {
-	// Connect to the Unix domain socket
-	conn, err := net.Dial("unix", c.socketPath)
+// Connect connects to the process manager telnet server. A process manager
+// that cannot be reached is tried again a few times, until ctx is done.
+func (c *Client) Connect(ctx context.Context) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.connect(ctx)
+}
+
+// connect connects to the process manager unless the client is connected.
+// It must be called with the lock of the client held.
+func (c *Client) connect(ctx context.Context) error {
+	if c.conn != nil {
+		return nil
+	}
+
+	delay := connectRetryDelay
+	for attempt := 0; ; attempt++ {
+		err := c.dial(ctx)
+		var authErr *authError
+		if err == nil || errors.As(err, &authErr) || attempt == connectRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%v: %v", ctx.Err(), err)
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// authError is returned when the process manager does not accept the
+// secret, which trying again does not change
+type authError struct {
+	response string
+}
+
+func (e *authError) Error() string {
+	return "authentication failed: " + e.response
+}
+
+// dial connects to the process manager and authenticates
+func (c *Client) dial(ctx context.Context) error {
+	network, address := socketNetwork(c.socketPath)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
 	if err != nil {
 		return fmt.Errorf("failed to connect to socket: %v", err)
 	}
-
-	c.conn = conn
-	c.reader = bufio.NewReader(conn)
+	stop := watchContext(ctx, conn, clientTimeout)
+	defer stop()
+	reader := bufio.NewReader(conn)
 
 	// Read welcome message
-	welcome, err := c.reader.ReadString('\n')
+	welcome, err := reader.ReadString('\n')
 	if err != nil {
-		c.conn.Close()
+		conn.Close()
 		return fmt.Errorf("failed to read welcome message: %v", err)
 	}
 
 	// Authenticate
 	if !strings.Contains(welcome, "not authenticated") {
-		c.conn.Close()
+		conn.Close()
 		return fmt.Errorf("unexpected welcome message: %s", welcome)
 	}
 
 	// Send secret
-	_, err = c.conn.Write([]byte(c.secret + "\n"))
-	if err != nil {
-		c.conn.Close()
+	if _, err := conn.Write([]byte(c.secret + "\n")); err != nil {
+		conn.Close()
 		return fmt.Errorf("failed to send secret: %v", err)
 	}
 
 	// Read authentication response
-	authResponse, err := c.reader.ReadString('\n')
+	authResponse, err := reader.ReadString('\n')
 	if err != nil {
-		c.conn.Close()
+		conn.Close()
 		return fmt.Errorf("failed to read authentication response: %v", err)
 	}
 
 	if !strings.Contains(authResponse, "authenticated") {
-		c.conn.Close()
-		return fmt.Errorf("authentication failed: %s", authResponse)
+		conn.Close()
+		return &authError{response: strings.TrimSpace(authResponse)}
 	}
 
+	c.conn = conn
+	c.reader = reader
 	return nil
 }
 
+// watchContext makes the reads and writes on a connection fail once ctx is
+// done, or after timeout if ctx has no earlier deadline. The returned
+// function clears the deadline again.
+func watchContext(ctx context.Context, conn net.Conn, timeout time.Duration) func() {
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	return func() {
+		stop()
+		conn.SetDeadline(time.Time{})
+	}
+}
+
 // Close closes the connection to the process manager
 func (c *Client) Close() error {
-	if c.conn != nil {
-		return c.conn.Close()
-	}
-	return nil
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.disconnect()
 }
 
-// SendCommand sends a command to the process manager and returns the result
-func (c *Client) SendCommand(command string) (string, error) {
+// disconnect closes the connection, after which the next command connects
+// again. It must be called with the lock of the client held.
+func (c *Client) disconnect() error {
 	if c.conn == nil {
-		return "", fmt.Errorf("not connected")
+		return nil
 	}
+	err := c.conn.Close()
+	c.conn = nil
+	c.reader = nil
+	return err
+}
 
-	// Send command with a trailing newline to execute it
-	_, err := c.conn.Write([]byte(command + "\n\n"))
-	if err != nil {
-		return "", fmt.Errorf("failed to send command: %v", err)
+// SendCommand sends a heroscript command to the process manager and returns
+// its result, without the result markers
+func (c *Client) SendCommand(ctx context.Context, command string) (string, error) {
+	return c.sendCommand(ctx, command, clientTimeout)
+}
+
+// sendCommand sends a heroscript command and waits up to timeout for its
+// result, unless ctx is done earlier
+func (c *Client) sendCommand(ctx context.Context, command string, timeout time.Duration) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// A command that could not be sent is sent again on a new connection
+	for attempt := 0; ; attempt++ {
+		if err := c.connect(ctx); err != nil {
+			return "", err
+		}
+		stop := watchContext(ctx, c.conn, timeout)
+		_, err := c.conn.Write([]byte(command + "\n\n"))
+		if err == nil {
+			result, err := c.readResult()
+			stop()
+			if err != nil {
+				// The rest of the result would be read as the next one
+				c.disconnect()
+				if ctx.Err() != nil {
+					return "", ctx.Err()
+				}
+				return "", err
+			}
+			return result, nil
+		}
+		stop()
+		c.disconnect()
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if attempt > 0 {
+			return "", fmt.Errorf("failed to send command: %v", err)
+		}
 	}
+}
 
-	// Read response
+// readResult reads the lines of a result up to its end marker
+func (c *Client) readResult() (string, error) {
 	var result strings.Builder
 	inResult := false
-	resultComplete := false
-	
-	// Set a timeout for reading the response
-	err = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	if err != nil {
-		return "", fmt.Errorf("failed to set read deadline: %v", err)
-	}
-	
-	// Read until we get the complete result or timeout
-	for !resultComplete {
+	for {
 		line, err := c.reader.ReadString('\n')
 		if err != nil {
-			// If we've already started receiving a result, return what we have
-			if inResult {
-				break
-			}
-			return result.String(), fmt.Errorf("failed to read response: %v", err)
+			return "", fmt.Errorf("failed to read response: %v", err)
 		}
-
-		if strings.HasPrefix(line, "**RESULT**") {
+		switch {
+		case strings.HasPrefix(line, "**RESULT**"):
 			inResult = true
+		case strings.HasPrefix(line, "**ENDRESULT**"):
+			return result.String(), nil
+		case inResult:
 			result.WriteString(line)
-			continue
+		case strings.HasPrefix(line, "Error"):
+			// A script that cannot be parsed has no result
+			return line, nil
 		}
+	}
+}
 
-		if strings.HasPrefix(line, "**ENDRESULT**") {
-			result.WriteString(line)
-			resultComplete = true
-			break
-		}
+// call sends a heroscript command and returns its result, or the error the
+// process manager reported instead
+func (c *Client) call(ctx context.Context, command string) (string, error) {
+	return c.callTimeout(ctx, command, clientTimeout)
+}
 
-		if inResult {
-			result.WriteString(line)
-		}
-	}
-	
-	// Reset the read deadline
-	err = c.conn.SetReadDeadline(time.Time{})
+// callTimeout is call with a timeout for commands that take long
+func (c *Client) callTimeout(ctx context.Context, command string, timeout time.Duration) (string, error) {
+	result, err := c.sendCommand(ctx, command, timeout)
 	if err != nil {
-		return result.String(), fmt.Errorf("failed to reset read deadline: %v", err)
+		return "", err
 	}
-
-	return result.String(), nil
+	if message, ok := strings.CutPrefix(result, "Error"); ok {
+		message = strings.TrimPrefix(message, ":")
+		return "", errors.New(strings.TrimSpace(message))
+	}
+	if strings.HasPrefix(result, "Unknown action") {
+		return "", errors.New(strings.TrimSpace(result))
+	}
+	return result, nil
 }
 
-// StartProcess starts a new process
-func (c *Client) StartProcess(name, command string, logEnabled bool, deadline int, cron, jobID string) (string, error) {
-	heroscript := fmt.Sprintf("!!process.start name:'%s' command:'%s' log:%t", name, command, logEnabled)
-	
-	if deadline > 0 {
-		heroscript += fmt.Sprintf(" deadline:%d", deadline)
-	}
-	
-	if cron != "" {
-		heroscript += fmt.Sprintf(" cron:'%s'", cron)
+// callJSON sends a heroscript command and decodes its JSON result into v
+func (c *Client) callJSON(ctx context.Context, command string, v interface{}) error {
+	result, err := c.call(ctx, command)
+	if err != nil {
+		return err
 	}
-	
-	if jobID != "" {
-		heroscript += fmt.Sprintf(" jobid:'%s'", jobID)
+	if err := json.Unmarshal([]byte(result), v); err != nil {
+		return fmt.Errorf("failed to parse result: %v", err)
 	}
-	
-	return c.SendCommand(heroscript)
+	return nil
+}
+
+// StartProcess starts a new process as defined
+func (c *Client) StartProcess(ctx context.Context, def ProcessDefinition) error {
+	_, err := c.call(ctx, def.HeroScript())
+	return err
 }
 
-// ListProcesses lists all processes
-func (c *Client) ListProcesses(format string) (string, error) {
-	heroscript := "!!process.list"
-	
-	if format != "" {
-		heroscript += fmt.Sprintf(" format:'%s'", format)
+// ListProcesses returns the information of all processes
+func (c *Client) ListProcesses(ctx context.Context) ([]*ProcessInfo, error) {
+	var processes []*ProcessInfo
+	if err := c.callJSON(ctx, "!!process.list format:'json'", &processes); err != nil {
+		return nil, err
 	}
-	
-	return c.SendCommand(heroscript)
+	return processes, nil
 }
 
 // DeleteProcess deletes a process
-func (c *Client) DeleteProcess(name string) (string, error) {
-	heroscript := fmt.Sprintf("!!process.delete name:'%s'", name)
-	return c.SendCommand(heroscript)
+func (c *Client) DeleteProcess(ctx context.Context, name string) error {
+	_, err := c.call(ctx, fmt.Sprintf("!!process.delete name:'%s'", name))
+	return err
 }
 
-// GetProcessStatus gets the status of a process
-func (c *Client) GetProcessStatus(name, format string) (string, error) {
-	heroscript := fmt.Sprintf("!!process.status name:'%s'", name)
-	
-	if format != "" {
-		heroscript += fmt.Sprintf(" format:'%s'", format)
+// GetProcessStatus returns the information of a process
+func (c *Client) GetProcessStatus(ctx context.Context, name string) (*ProcessInfo, error) {
+	var info ProcessInfo
+	if err := c.callJSON(ctx, fmt.Sprintf("!!process.status name:'%s' format:'json'", name), &info); err != nil {
+		return nil, err
 	}
-	
-	return c.SendCommand(heroscript)
+	return &info, nil
 }
 
 // RestartProcess restarts a process
-func (c *Client) RestartProcess(name string) (string, error) {
-	heroscript := fmt.Sprintf("!!process.restart name:'%s'", name)
-	return c.SendCommand(heroscript)
+func (c *Client) RestartProcess(ctx context.Context, name string) error {
+	_, err := c.call(ctx, fmt.Sprintf("!!process.restart name:'%s'", name))
+	return err
+}
+
+// RollingRestartProcess restarts a process without downtime, see
+// ProcessManager.RollingRestart. It waits for the new instance to pass its
+// health check, unless ctx is done earlier.
+func (c *Client) RollingRestartProcess(ctx context.Context, name string) error {
+	heroscript := fmt.Sprintf("!!process.restart name:'%s' rolling:true", name)
+	_, err := c.callTimeout(ctx, heroscript, rollingTimeout+healthCheckTimeout)
+	return err
 }
 
 // StopProcess stops a process
-func (c *Client) StopProcess(name string) (string, error) {
-	heroscript := fmt.Sprintf("!!process.stop name:'%s'", name)
-	return c.SendCommand(heroscript)
+func (c *Client) StopProcess(ctx context.Context, name string) error {
+	_, err := c.call(ctx, fmt.Sprintf("!!process.stop name:'%s'", name))
+	return err
+}
+
+// GetProcessLogs returns the last lines of output of a process
+func (c *Client) GetProcessLogs(ctx context.Context, name string, lines int) (string, error) {
+	heroscript := fmt.Sprintf("!!process.logs name:'%s'", name)
+
+	if lines > 0 {
+		heroscript += fmt.Sprintf(" lines:%d", lines)
+	}
+
+	return c.call(ctx, heroscript)
+}
+
+// ListLogFiles returns the current and rotated log files of a process
+func (c *Client) ListLogFiles(ctx context.Context, name string) ([]LogFile, error) {
+	var files []LogFile
+	if err := c.callJSON(ctx, fmt.Sprintf("!!process.logfiles name:'%s' format:'json'", name), &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// GetLogFile returns the last lines of a log file of a process, as listed
+// by ListLogFiles, or the whole file if lines is 0
+func (c *Client) GetLogFile(ctx context.Context, name, file string, lines int) (string, error) {
+	heroscript := fmt.Sprintf("!!process.logs name:'%s' file:'%s'", name, file)
+
+	if lines > 0 {
+		heroscript += fmt.Sprintf(" lines:%d", lines)
+	}
+
+	return c.call(ctx, heroscript)
+}
+
+// ListRuns returns the last runs of a scheduled process
+func (c *Client) ListRuns(ctx context.Context, name string) ([]CronRun, error) {
+	var runs []CronRun
+	if err := c.callJSON(ctx, fmt.Sprintf("!!process.runs name:'%s' format:'json'", name), &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// UpcomingRuns returns the next count times a scheduled process runs
+func (c *Client) UpcomingRuns(ctx context.Context, name string, count int) ([]time.Time, error) {
+	heroscript := fmt.Sprintf("!!process.schedule name:'%s' format:'json'", name)
+
+	if count > 0 {
+		heroscript += fmt.Sprintf(" count:%d", count)
+	}
+
+	var times []time.Time
+	if err := c.callJSON(ctx, heroscript, &times); err != nil {
+		return nil, err
+	}
+	return times, nil
+}
+
+// SendInput sends a line of input to a process started with stdin and
+// closes its input after it if eof is true. An empty input with eof only
+// closes the input.
+func (c *Client) SendInput(ctx context.Context, name, input string, eof bool) error {
+	heroscript := fmt.Sprintf("!!process.send name:'%s'", name)
+
+	if input != "" {
+		heroscript += fmt.Sprintf(" input:'%s'", input)
+	}
+
+	if eof {
+		heroscript += " eof:true"
+	}
+
+	_, err := c.call(ctx, heroscript)
+	return err
+}
+
+// Export returns the definitions of the processes as heroscript, so they
+// can be saved and imported again
+func (c *Client) Export(ctx context.Context) (string, error) {
+	return c.call(ctx, "!!process.export")
+}
+
+// ImportProcesses starts the processes defined in a heroscript file on the
+// host of the process manager, replacing processes defined otherwise if
+// replace is true. It returns the names of the processes that were started,
+// also when others could not be.
+func (c *Client) ImportProcesses(ctx context.Context, file string, replace bool) ([]string, error) {
+	heroscript := fmt.Sprintf("!!process.import file:'%s' format:'json'", file)
+
+	if replace {
+		heroscript += " replace:true"
+	}
+
+	var result ImportResult
+	if err := c.callJSON(ctx, heroscript, &result); err != nil {
+		return nil, err
+	}
+	if result.Error != "" {
+		return result.Started, errors.New(result.Error)
+	}
+	return result.Started, nil
+}
+
+// Attach sends the lines from input to a process started with stdin and
+// passes its output and events to handler, until input is closed or ctx is
+// done
+func (c *Client) Attach(ctx context.Context, name string, input <-chan string, handler func(line string)) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+
+	heroscript := fmt.Sprintf("!!process.attach name:'%s'", name)
+	if _, err := c.conn.Write([]byte(heroscript + "\n\n")); err != nil {
+		c.disconnect()
+		return fmt.Errorf("failed to send command: %v", err)
+	}
+
+	// The server confirms the attach before it reads input, and otherwise
+	// returns an error, after which input would be read as commands
+	var confirmation string
+	for confirmation == "" || strings.HasPrefix(confirmation, "**RESULT**") {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.disconnect()
+			return fmt.Errorf("failed to read result: %v", err)
+		}
+		confirmation = strings.TrimSuffix(line, "\n")
+	}
+	if !strings.HasPrefix(confirmation, "Attached") {
+		// Read the rest of the result
+		for {
+			line, err := c.reader.ReadString('\n')
+			if err != nil {
+				c.disconnect()
+				break
+			}
+			if strings.HasPrefix(line, "**ENDRESULT**") {
+				break
+			}
+		}
+		return errors.New(strings.TrimPrefix(confirmation, "Error attaching to process: "))
+	}
+
+	// Sending !!detach ends the attach; the server then ends the result
+	conn := c.conn
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case line, open := <-input:
+				if !open {
+					conn.Write([]byte("!!detach\n"))
+					return
+				}
+				conn.Write([]byte(line + "\n"))
+			case <-ctx.Done():
+				conn.Write([]byte("!!detach\n"))
+				return
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.disconnect()
+			return fmt.Errorf("failed to read result: %v", err)
+		}
+		if strings.HasPrefix(line, "**ENDRESULT**") {
+			return ctx.Err()
+		}
+		handler(strings.TrimSuffix(line, "\n"))
+	}
+}
+
+// FollowLogs streams the output of the named processes, or of all processes
+// when no names are given, starting with the last lines of each. Every line
+// is passed to handler until ctx is done; the last lines have no time.
+func (c *Client) FollowLogs(ctx context.Context, lines int, handler func(line LogLine), names ...string) error {
+	heroscript := "!!process.logs follow:true format:'json'"
+	if len(names) > 0 {
+		heroscript += fmt.Sprintf(" name:'%s'", strings.Join(names, ","))
+	}
+	if lines > 0 {
+		heroscript += fmt.Sprintf(" lines:%d", lines)
+	}
+	return followJSON(c, ctx, heroscript, handler)
+}
+
+// FollowEvents streams the events of the named processes, or of all
+// processes when no names are given, as they happen. Every event is passed
+// to handler until ctx is done.
+func (c *Client) FollowEvents(ctx context.Context, handler func(event Event), names ...string) error {
+	heroscript := "!!process.events format:'json'"
+	if len(names) > 0 {
+		heroscript += fmt.Sprintf(" name:'%s'", strings.Join(names, ","))
+	}
+	return followJSON(c, ctx, heroscript, handler)
+}
+
+// followJSON follows a streamed result of JSON objects and passes every
+// object to handler. An error the server reports instead stops following.
+func followJSON[T any](c *Client, ctx context.Context, heroscript string, handler func(T)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var reported error
+	err := c.follow(ctx, heroscript, func(line string) {
+		if reported != nil {
+			return
+		}
+		var v T
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			reported = errors.New(strings.TrimPrefix(line, "Error: "))
+			cancel()
+			return
+		}
+		handler(v)
+	})
+	if reported != nil {
+		return reported
+	}
+	return err
+}
+
+// follow sends a heroscript whose result is streamed and passes every line
+// of the result to handler until ctx is done
+func (c *Client) follow(ctx context.Context, heroscript string, handler func(line string)) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err := c.connect(ctx); err != nil {
+		return err
+	}
+
+	if _, err := c.conn.Write([]byte(heroscript + "\n\n")); err != nil {
+		c.disconnect()
+		return fmt.Errorf("failed to send command: %v", err)
+	}
+
+	// Sending a line stops following; the server then ends the result
+	conn := c.conn
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Write([]byte("\n"))
+		case <-done:
+		}
+	}()
+
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			c.disconnect()
+			return fmt.Errorf("failed to read result: %v", err)
+		}
+		switch {
+		case strings.HasPrefix(line, "**RESULT**"):
+		case strings.HasPrefix(line, "**ENDRESULT**"):
+			return ctx.Err()
+		default:
+			handler(strings.TrimSuffix(line, "\n"))
+		}
+	}
 }
@@ -0,0 +1,246 @@
+package processmanager
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestClient starts a process manager with a telnet server on a socket
+// in a temporary directory and returns a client for it
+func newTestClient(t *testing.T) (*ProcessManager, *Client) {
+	t.Helper()
+	pm := NewProcessManager("secret")
+	t.Cleanup(func() { pm.StopAll() })
+	server := NewTelnetServer(pm)
+	socket := filepath.Join(t.TempDir(), "pm.sock")
+	if err := server.Start(socket); err != nil {
+		t.Fatalf("Failed to start the server: %v", err)
+	}
+	t.Cleanup(func() { server.Stop() })
+
+	client := NewClient(socket, "secret")
+	t.Cleanup(func() { client.Close() })
+	return pm, client
+}
+
+func TestClient(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := context.Background()
+
+	if err := client.StartProcess(ctx, ProcessDefinition{Name: "web", Command: "sleep 30", Restart: RestartAlways}); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	info, err := client.GetProcessStatus(ctx, "web")
+	if err != nil || info.Name != "web" || info.Status != ProcessStatusRunning || info.RestartPolicy != RestartAlways {
+		t.Fatalf("Expected web to run, got %+v, %v", info, err)
+	}
+	processes, err := client.ListProcesses(ctx)
+	if err != nil || len(processes) != 1 || processes[0].PID != info.PID {
+		t.Errorf("Expected web to be listed, got %d processes, %v", len(processes), err)
+	}
+
+	if err := client.StopProcess(ctx, "web"); err != nil {
+		t.Fatalf("Failed to stop: %v", err)
+	}
+	if info, _ := client.GetProcessStatus(ctx, "web"); info.Status != ProcessStatusStopped {
+		t.Errorf("Expected web to be stopped, got %s", info.Status)
+	}
+	if err := client.RestartProcess(ctx, "web"); err != nil {
+		t.Fatalf("Failed to restart: %v", err)
+	}
+	if info, _ := client.GetProcessStatus(ctx, "web"); info.Status != ProcessStatusRunning {
+		t.Errorf("Expected web to run again, got %s", info.Status)
+	}
+
+	script, err := client.Export(ctx)
+	if err != nil || !strings.Contains(script, "!!process.start name:'web' command:'sleep 30'") {
+		t.Errorf("Expected the export of web, got %q, %v", script, err)
+	}
+
+	// Errors of the process manager are returned as errors
+	if _, err := client.GetProcessStatus(ctx, "unknown"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected an error for an unknown process, got %v", err)
+	}
+	if err := client.StartProcess(ctx, ProcessDefinition{Name: "web", Command: "sleep 30"}); err == nil {
+		t.Error("Expected an error starting a process twice")
+	}
+
+	if err := client.DeleteProcess(ctx, "web"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if processes, err := client.ListProcesses(ctx); err != nil || len(processes) != 0 {
+		t.Errorf("Expected no processes, got %d, %v", len(processes), err)
+	}
+}
+
+func TestClientScheduleAndInput(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := context.Background()
+
+	client.StartProcess(ctx, ProcessDefinition{Name: "job", Command: "true", Cron: "0 3 * * *", Timezone: "UTC"})
+	times, err := client.UpcomingRuns(ctx, "job", 2)
+	if err != nil || len(times) != 2 || times[0].UTC().Hour() != 3 || times[1].Sub(times[0]) != 24*time.Hour {
+		t.Errorf("Expected two runs at 03:00, got %v, %v", times, err)
+	}
+	if runs, err := client.ListRuns(ctx, "job"); err != nil || len(runs) != 0 {
+		t.Errorf("Expected no runs yet, got %v, %v", runs, err)
+	}
+
+	client.StartProcess(ctx, ProcessDefinition{Name: "cat", Command: "cat", Stdin: true})
+	if err := client.SendInput(ctx, "cat", "hello", true); err != nil {
+		t.Fatalf("Failed to send input: %v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		info, _ := client.GetProcessStatus(ctx, "cat")
+		if info != nil && info.Status == ProcessStatusCompleted {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Expected cat to exit at the end of its input, got %+v", info)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if logs, err := client.GetProcessLogs(ctx, "cat", 5); err != nil || !strings.Contains(logs, "hello") {
+		t.Errorf("Expected the input in the logs, got %q, %v", logs, err)
+	}
+}
+
+func TestClientReconnects(t *testing.T) {
+	_, client := newTestClient(t)
+	ctx := context.Background()
+	if _, err := client.ListProcesses(ctx); err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+
+	// The connection is lost, as when the process manager restarts
+	client.conn.Close()
+	if _, err := client.ListProcesses(ctx); err != nil {
+		t.Errorf("Expected the client to reconnect, got %v", err)
+	}
+}
+
+func TestClientConnectErrors(t *testing.T) {
+	// A wrong secret is not tried again
+	_, good := newTestClient(t)
+	bad := NewClient(good.socketPath, "wrong")
+	defer bad.Close()
+	start := time.Now()
+	err := bad.Connect(context.Background())
+	var authErr *authError
+	if !errors.As(err, &authErr) {
+		t.Errorf("Expected an authentication error, got %v", err)
+	}
+	if time.Since(start) >= connectRetryDelay {
+		t.Errorf("Expected no retries for a wrong secret, took %v", time.Since(start))
+	}
+
+	// A missing process manager is tried again until the context is done
+	missing := NewClient(filepath.Join(t.TempDir(), "missing.sock"), "secret")
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if _, err := missing.ListProcesses(ctx); err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("Expected the deadline to stop connecting, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected connecting to be tried until the deadline, took %v", elapsed)
+	}
+}
+
+func TestClientFollowEvents(t *testing.T) {
+	pm, client := newTestClient(t)
+	pm.StartProcess("web", "sleep 30", false, 0, "", "")
+	pm.StartProcess("db", "sleep 30", false, 0, "", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var mutex sync.Mutex
+	var events []Event
+	done := make(chan error, 1)
+	go func() {
+		done <- client.FollowEvents(ctx, func(event Event) {
+			mutex.Lock()
+			events = append(events, event)
+			mutex.Unlock()
+		}, "web")
+	}()
+
+	// Following starts at some point; restart both until web's events come
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		pm.RestartProcess("db")
+		pm.RestartProcess("web")
+		mutex.Lock()
+		n := len(events)
+		mutex.Unlock()
+		if n > 0 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("Expected the events of web")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected following to end with the context, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected following to end with the context")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, event := range events {
+		if event.Process != "web" {
+			t.Errorf("Expected only the events of web, got %+v", event)
+		}
+	}
+
+	// The client is usable again after following
+	if _, err := client.ListProcesses(context.Background()); err != nil {
+		t.Errorf("Failed to list after following: %v", err)
+	}
+}
+
+func TestClientAttach(t *testing.T) {
+	pm, client := newTestClient(t)
+	pm.StartProcessDefinition(ProcessDefinition{Name: "cat", Command: "cat", Stdin: true})
+
+	input := make(chan string)
+	lines := make(chan string, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Attach(context.Background(), "cat", input, func(line string) { lines <- line })
+	}()
+	input <- "hello"
+	timeout := time.After(10 * time.Second)
+	for found := false; !found; {
+		select {
+		case line := <-lines:
+			found = strings.Contains(line, "hello")
+		case <-timeout:
+			t.Fatal("Expected cat to echo the input")
+		}
+	}
+
+	close(input)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected detaching to end the attach, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected closing the input to detach")
+	}
+
+	if err := client.Attach(context.Background(), "unknown", make(chan string), func(string) {}); err == nil {
+		t.Error("Expected an error attaching to an unknown process")
+	}
+}
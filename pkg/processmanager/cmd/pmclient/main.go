@@ -1,18 +1,26 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/freeflowuniverse/herolauncher/pkg/processmanager"
 )
 
 func main() {
 	// Define common flags
-	socketPath := flag.String("socket", "/tmp/processmanager.sock", "Path to the Unix domain socket")
+	socketPath := flag.String("socket", processmanager.DefaultSocket, "Path to the Unix domain socket, or tcp://host:port")
 	secret := flag.String("secret", "", "Authentication secret for the telnet server")
+	timeout := flag.Duration("timeout", 0, "Give up after this long, like 30s (default: no limit)")
 
 	// Define command-specific flags
 	startCmd := flag.NewFlagSet("start", flag.ExitOnError)
@@ -21,7 +29,23 @@ func main() {
 	startLog := startCmd.Bool("log", false, "Enable logging")
 	startDeadline := startCmd.Int("deadline", 0, "Deadline in seconds (0 for no deadline)")
 	startCron := startCmd.String("cron", "", "Cron schedule")
+	startTimezone := startCmd.String("timezone", "", "Timezone of the cron schedule (default local)")
+	startJitter := startCmd.Int("jitter", 0, "Start scheduled runs up to this many seconds late")
+	startOverlap := startCmd.String("overlap", "", "When a run is due during the previous one: skip, queue or kill-previous")
 	startJobID := startCmd.String("jobid", "", "Job ID")
+	startRestart := startCmd.String("restart", "never", "Restart policy: never, on-failure or always")
+	startMaxRestarts := startCmd.Int("max-restarts", 0, "Restarts in a row before giving up (0 for no limit)")
+	startRequires := startCmd.String("requires", "", "Comma separated processes that must be ready first")
+	startAfter := startCmd.String("after", "", "Comma separated processes to start after")
+	startCheck := startCmd.String("check", "", "Health check: a command, tcp://address or http:// URL")
+	startCheckInterval := startCmd.Int("check-interval", 0, "Seconds between health checks (default 10)")
+	startCheckRetries := startCmd.Int("check-retries", 0, "Failed health checks in a row that restart the process (default 3)")
+	startEnv := startCmd.String("env", "", "Comma separated KEY=value environment variables")
+	startDir := startCmd.String("dir", "", "Working directory")
+	startUmask := startCmd.String("umask", "", "Octal file mode creation mask, like 022")
+	startUser := startCmd.String("user", "", "User to run as, optionally followed by :group")
+	startStdin := startCmd.Bool("stdin", false, "Keep the input of the process open for send and attach")
+	startListen := startCmd.String("listen", "", "Socket to pass to the process as file descriptor 3: tcp://host:port or unix://path")
 
 	listCmd := flag.NewFlagSet("list", flag.ExitOnError)
 	listFormat := listCmd.String("format", "", "Output format (json or empty for text)")
@@ -35,10 +59,49 @@ func main() {
 
 	restartCmd := flag.NewFlagSet("restart", flag.ExitOnError)
 	restartName := restartCmd.String("name", "", "Name of the process")
+	restartRolling := restartCmd.Bool("rolling", false, "Start the new instance and stop the old one once the new one passes its health check")
 
 	stopCmd := flag.NewFlagSet("stop", flag.ExitOnError)
 	stopName := stopCmd.String("name", "", "Name of the process")
 
+	logsCmd := flag.NewFlagSet("logs", flag.ExitOnError)
+	logsName := logsCmd.String("name", "", "Name of the process, or comma separated names to follow")
+	logsLines := logsCmd.Int("lines", 20, "Number of lines")
+	logsFollow := logsCmd.Bool("follow", false, "Stream new lines until interrupted")
+	logsFile := logsCmd.String("file", "", "Log file to read, as listed by logfiles")
+
+	logFilesCmd := flag.NewFlagSet("logfiles", flag.ExitOnError)
+	logFilesName := logFilesCmd.String("name", "", "Name of the process")
+	logFilesFormat := logFilesCmd.String("format", "", "Output format (json or empty for text)")
+
+	runsCmd := flag.NewFlagSet("runs", flag.ExitOnError)
+	runsName := runsCmd.String("name", "", "Name of the process")
+	runsFormat := runsCmd.String("format", "", "Output format (json or empty for text)")
+
+	scheduleCmd := flag.NewFlagSet("schedule", flag.ExitOnError)
+	scheduleName := scheduleCmd.String("name", "", "Name of the process")
+	scheduleCount := scheduleCmd.Int("count", 5, "Number of upcoming runs")
+	scheduleFormat := scheduleCmd.String("format", "", "Output format (json or empty for text)")
+
+	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	sendName := sendCmd.String("name", "", "Name of the process")
+	sendInput := sendCmd.String("input", "", "Line to send to the input of the process")
+	sendEOF := sendCmd.Bool("eof", false, "Close the input of the process after sending")
+
+	attachCmd := flag.NewFlagSet("attach", flag.ExitOnError)
+	attachName := attachCmd.String("name", "", "Name of the process")
+
+	eventsCmd := flag.NewFlagSet("events", flag.ExitOnError)
+	eventsName := eventsCmd.String("name", "", "Comma separated names of the processes (default: all)")
+	eventsFormat := eventsCmd.String("format", "", "Output format (json or empty for text)")
+
+	exportCmd := flag.NewFlagSet("export", flag.ExitOnError)
+	exportFile := exportCmd.String("file", "", "File to write the heroscript to (default: standard output)")
+
+	importCmd := flag.NewFlagSet("import", flag.ExitOnError)
+	importFile := importCmd.String("file", "", "Heroscript file with process.start actions")
+	importReplace := importCmd.Bool("replace", false, "Replace processes that are defined otherwise")
+
 	// Parse common flags
 	flag.Parse()
 
@@ -53,11 +116,20 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Every command stops on Ctrl+C, and after -timeout if given
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
 	// Create client
 	client := processmanager.NewClient(*socketPath, *secret)
 
 	// Connect to the process manager
-	err := client.Connect()
+	err := client.Connect(ctx)
 	if err != nil {
 		log.Fatalf("Failed to connect to process manager: %v", err)
 	}
@@ -70,18 +142,66 @@ func main() {
 		if *startName == "" || *startCommand == "" {
 			log.Fatal("Error: name and command are required for start")
 		}
-		result, err := client.StartProcess(*startName, *startCommand, *startLog, *startDeadline, *startCron, *startJobID)
+		restart, err := processmanager.ParseRestartPolicy(*startRestart)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		env, err := processmanager.ParseEnv(*startEnv)
 		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		var overlap processmanager.OverlapPolicy
+		if *startOverlap != "" {
+			if overlap, err = processmanager.ParseOverlapPolicy(*startOverlap); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+		}
+		def := processmanager.ProcessDefinition{
+			Name:        *startName,
+			Command:     *startCommand,
+			Log:         *startLog,
+			Deadline:    *startDeadline,
+			Cron:        *startCron,
+			Timezone:    *startTimezone,
+			Jitter:      *startJitter,
+			Overlap:     overlap,
+			JobID:       *startJobID,
+			Restart:     restart,
+			MaxRestarts: *startMaxRestarts,
+			HealthCheck: *startCheck,
+
+			HealthInterval: *startCheckInterval,
+			HealthRetries:  *startCheckRetries,
+
+			Env:   env,
+			Dir:   *startDir,
+			Umask: *startUmask,
+			User:  *startUser,
+			Stdin: *startStdin,
+
+			Listen: *startListen,
+		}
+		if *startRequires != "" {
+			def.Requires = strings.Split(*startRequires, ",")
+		}
+		if *startAfter != "" {
+			def.After = strings.Split(*startAfter, ",")
+		}
+		if err := client.StartProcess(ctx, def); err != nil {
 			log.Fatalf("Failed to start process: %v", err)
 		}
-		fmt.Println(result)
+		fmt.Printf("Process '%s' started successfully\n", def.Name)
 
 	case "list":
 		listCmd.Parse(flag.Args()[1:])
-		result, err := client.ListProcesses(*listFormat)
+		processes, err := client.ListProcesses(ctx)
 		if err != nil {
 			log.Fatalf("Failed to list processes: %v", err)
 		}
+		result, err := processmanager.FormatProcessList(processes, *listFormat)
+		if err != nil {
+			log.Fatalf("Failed to format processes: %v", err)
+		}
 		fmt.Println(result)
 
 	case "delete":
@@ -89,21 +209,24 @@ func main() {
 		if *deleteName == "" {
 			log.Fatal("Error: name is required for delete")
 		}
-		result, err := client.DeleteProcess(*deleteName)
-		if err != nil {
+		if err := client.DeleteProcess(ctx, *deleteName); err != nil {
 			log.Fatalf("Failed to delete process: %v", err)
 		}
-		fmt.Println(result)
+		fmt.Printf("Process '%s' deleted successfully\n", *deleteName)
 
 	case "status":
 		statusCmd.Parse(flag.Args()[1:])
 		if *statusName == "" {
 			log.Fatal("Error: name is required for status")
 		}
-		result, err := client.GetProcessStatus(*statusName, *statusFormat)
+		info, err := client.GetProcessStatus(ctx, *statusName)
 		if err != nil {
 			log.Fatalf("Failed to get process status: %v", err)
 		}
+		result, err := processmanager.FormatProcessInfo(info, *statusFormat)
+		if err != nil {
+			log.Fatalf("Failed to format process status: %v", err)
+		}
 		fmt.Println(result)
 
 	case "restart":
@@ -111,23 +234,198 @@ func main() {
 		if *restartName == "" {
 			log.Fatal("Error: name is required for restart")
 		}
-		result, err := client.RestartProcess(*restartName)
-		if err != nil {
+		if *restartRolling {
+			if err := client.RollingRestartProcess(ctx, *restartName); err != nil {
+				log.Fatalf("Failed to restart process: %v", err)
+			}
+			fmt.Printf("Process '%s' restarted without downtime\n", *restartName)
+			break
+		}
+		if err := client.RestartProcess(ctx, *restartName); err != nil {
 			log.Fatalf("Failed to restart process: %v", err)
 		}
-		fmt.Println(result)
+		fmt.Printf("Process '%s' restarted successfully\n", *restartName)
 
 	case "stop":
 		stopCmd.Parse(flag.Args()[1:])
 		if *stopName == "" {
 			log.Fatal("Error: name is required for stop")
 		}
-		result, err := client.StopProcess(*stopName)
-		if err != nil {
+		if err := client.StopProcess(ctx, *stopName); err != nil {
 			log.Fatalf("Failed to stop process: %v", err)
 		}
+		fmt.Printf("Process '%s' stopped successfully\n", *stopName)
+
+	case "logs":
+		logsCmd.Parse(flag.Args()[1:])
+		if *logsFile != "" {
+			if *logsName == "" {
+				log.Fatal("Error: name is required for logs")
+			}
+			// The whole file unless -lines is given
+			lines := 0
+			logsCmd.Visit(func(f *flag.Flag) {
+				if f.Name == "lines" {
+					lines = *logsLines
+				}
+			})
+			result, err := client.GetLogFile(ctx, *logsName, *logsFile, lines)
+			if err != nil {
+				log.Fatalf("Failed to get log file: %v", err)
+			}
+			fmt.Println(result)
+			break
+		}
+		if !*logsFollow {
+			if *logsName == "" {
+				log.Fatal("Error: name is required for logs")
+			}
+			result, err := client.GetProcessLogs(ctx, *logsName, *logsLines)
+			if err != nil {
+				log.Fatalf("Failed to get logs: %v", err)
+			}
+			fmt.Println(result)
+			break
+		}
+		// Follow until Ctrl+C
+		var names []string
+		if *logsName != "" {
+			names = strings.Split(*logsName, ",")
+		}
+		err := client.FollowLogs(ctx, *logsLines, func(line processmanager.LogLine) {
+			fmt.Printf("[%s] %s\n", line.Process, line.Line)
+		}, names...)
+		if err != nil && err != context.Canceled && err != context.DeadlineExceeded {
+			log.Fatalf("Failed to follow logs: %v", err)
+		}
+
+	case "logfiles":
+		logFilesCmd.Parse(flag.Args()[1:])
+		if *logFilesName == "" {
+			log.Fatal("Error: name is required for logfiles")
+		}
+		files, err := client.ListLogFiles(ctx, *logFilesName)
+		if err != nil {
+			log.Fatalf("Failed to list log files: %v", err)
+		}
+		result, err := processmanager.FormatLogFiles(files, *logFilesFormat)
+		if err != nil {
+			log.Fatalf("Failed to format log files: %v", err)
+		}
+		fmt.Println(result)
+
+	case "runs":
+		runsCmd.Parse(flag.Args()[1:])
+		if *runsName == "" {
+			log.Fatal("Error: name is required for runs")
+		}
+		runs, err := client.ListRuns(ctx, *runsName)
+		if err != nil {
+			log.Fatalf("Failed to list runs: %v", err)
+		}
+		result, err := processmanager.FormatRuns(runs, *runsFormat)
+		if err != nil {
+			log.Fatalf("Failed to format runs: %v", err)
+		}
+		fmt.Println(result)
+
+	case "schedule":
+		scheduleCmd.Parse(flag.Args()[1:])
+		if *scheduleName == "" {
+			log.Fatal("Error: name is required for schedule")
+		}
+		times, err := client.UpcomingRuns(ctx, *scheduleName, *scheduleCount)
+		if err != nil {
+			log.Fatalf("Failed to get upcoming runs: %v", err)
+		}
+		result, err := processmanager.FormatUpcomingRuns(times, *scheduleFormat)
+		if err != nil {
+			log.Fatalf("Failed to format upcoming runs: %v", err)
+		}
 		fmt.Println(result)
 
+	case "send":
+		sendCmd.Parse(flag.Args()[1:])
+		if *sendName == "" {
+			log.Fatal("Error: name is required for send")
+		}
+		if *sendInput == "" && !*sendEOF {
+			log.Fatal("Error: input or eof is required for send")
+		}
+		if err := client.SendInput(ctx, *sendName, *sendInput, *sendEOF); err != nil {
+			log.Fatalf("Failed to send input: %v", err)
+		}
+		if *sendInput != "" {
+			fmt.Printf("Input sent to process '%s'\n", *sendName)
+		} else {
+			fmt.Printf("Input of process '%s' closed\n", *sendName)
+		}
+
+	case "attach":
+		attachCmd.Parse(flag.Args()[1:])
+		if *attachName == "" {
+			log.Fatal("Error: name is required for attach")
+		}
+		// Attached until Ctrl+C or the end of our own input
+		input := make(chan string)
+		go func() {
+			defer close(input)
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				input <- scanner.Text()
+			}
+		}()
+		err := client.Attach(ctx, *attachName, input, func(line string) {
+			fmt.Println(line)
+		})
+		if err != nil && err != context.Canceled && err != context.DeadlineExceeded {
+			log.Fatalf("Failed to attach: %v", err)
+		}
+
+	case "events":
+		eventsCmd.Parse(flag.Args()[1:])
+		// Follow until Ctrl+C
+		var names []string
+		if *eventsName != "" {
+			names = strings.Split(*eventsName, ",")
+		}
+		err := client.FollowEvents(ctx, func(event processmanager.Event) {
+			printEvent(event, *eventsFormat)
+		}, names...)
+		if err != nil && err != context.Canceled && err != context.DeadlineExceeded {
+			log.Fatalf("Failed to follow events: %v", err)
+		}
+
+	case "export":
+		exportCmd.Parse(flag.Args()[1:])
+		script, err := client.Export(ctx)
+		if err != nil {
+			log.Fatalf("Failed to export processes: %v", err)
+		}
+		if *exportFile == "" {
+			fmt.Print(script)
+		} else if err := os.WriteFile(*exportFile, []byte(script), 0600); err != nil {
+			log.Fatalf("Failed to write %s: %v", *exportFile, err)
+		}
+
+	case "import":
+		importCmd.Parse(flag.Args()[1:])
+		if *importFile == "" {
+			log.Fatal("Error: file is required for import")
+		}
+		// The process manager reads the file, from its own working directory
+		path, err := filepath.Abs(*importFile)
+		if err != nil {
+			log.Fatalf("Failed to import processes: %v", err)
+		}
+		started, err := client.ImportProcesses(ctx, path, *importReplace)
+		for _, name := range started {
+			fmt.Printf("Process '%s' started successfully\n", name)
+		}
+		if err != nil {
+			log.Fatalf("Failed to import processes: %v", err)
+		}
+
 	default:
 		fmt.Printf("Unknown command: %s\n", flag.Arg(0))
 		printUsage()
@@ -135,11 +433,30 @@ func main() {
 	}
 }
 
+// printEvent prints an event as a line of text, or as a JSON object with
+// format json
+func printEvent(event processmanager.Event, format string) {
+	if format == "json" {
+		data, err := json.Marshal(event)
+		if err != nil {
+			log.Fatalf("Failed to format event: %v", err)
+		}
+		fmt.Println(string(data))
+		return
+	}
+	line := event.Time.Format(time.RFC3339) + " [" + event.Process + "] " + string(event.Type)
+	if event.Message != "" {
+		line += ": " + event.Message
+	}
+	fmt.Println(line)
+}
+
 func printUsage() {
 	fmt.Println("Usage: pmclient [global flags] command [command flags]")
 	fmt.Println("\nGlobal flags:")
-	fmt.Println("  -socket string   Path to the Unix domain socket (default \"/tmp/processmanager.sock\")")
+	fmt.Printf("  -socket string   Path to the Unix domain socket, or tcp://host:port (default %q)\n", processmanager.DefaultSocket)
 	fmt.Println("  -secret string   Authentication secret for the telnet server")
+	fmt.Println("  -timeout duration  Give up after this long, like 30s (default: no limit)")
 	
 	fmt.Println("\nCommands:")
 	fmt.Println("  start    Start a new process")
@@ -147,8 +464,24 @@ func printUsage() {
 	fmt.Println("    -command string   Command to run")
 	fmt.Println("    -log              Enable logging")
 	fmt.Println("    -deadline int     Deadline in seconds (0 for no deadline)")
-	fmt.Println("    -cron string      Cron schedule")
+	fmt.Println("    -cron string      Cron schedule of 5 or 6 fields, or @hourly, @daily, @weekly, @monthly or @yearly")
+	fmt.Println("    -timezone string  Timezone of the cron schedule, like Europe/Brussels (default local)")
+	fmt.Println("    -jitter int       Start scheduled runs up to this many seconds late")
+	fmt.Println("    -overlap string   When a run is due during the previous one: skip, queue or kill-previous (default skip)")
 	fmt.Println("    -jobid string     Job ID")
+	fmt.Println("    -restart string   Restart policy: never, on-failure or always (default never)")
+	fmt.Println("    -max-restarts int Restarts in a row before giving up in the crashloop state (0 for no limit)")
+	fmt.Println("    -requires string  Comma separated processes that must be running and ready first")
+	fmt.Println("    -after string     Comma separated processes to start after, if they are managed")
+	fmt.Println("    -check string     Health check: a command that exits with 0, tcp://address or http:// URL")
+	fmt.Println("    -check-interval int  Seconds between health checks once the process is ready (default 10)")
+	fmt.Println("    -check-retries int   Failed health checks in a row that restart the process (default 3)")
+	fmt.Println("    -env string       Comma separated KEY=value environment variables")
+	fmt.Println("    -dir string       Working directory")
+	fmt.Println("    -umask string     Octal file mode creation mask, like 022")
+	fmt.Println("    -user string      User to run as, by name or uid, optionally followed by :group")
+	fmt.Println("    -stdin            Keep the input of the process open for send and attach")
+	fmt.Println("    -listen string    Socket to pass to the process as file descriptor 3: tcp://host:port or unix://path")
 	fmt.Println("  list     List all processes")
 	fmt.Println("    -format string    Output format (json or empty for text)")
 	fmt.Println("  delete   Delete a process")
@@ -158,6 +491,36 @@ func printUsage() {
 	fmt.Println("    -format string    Output format (json or empty for text)")
 	fmt.Println("  restart  Restart a process")
 	fmt.Println("    -name string      Name of the process")
+	fmt.Println("    -rolling          Without downtime: stop the old instance once the new one passes its health check")
 	fmt.Println("  stop     Stop a process")
 	fmt.Println("    -name string      Name of the process")
+	fmt.Println("  logs     Show the output of a process")
+	fmt.Println("    -name string      Name of the process, or comma separated names to follow")
+	fmt.Println("    -lines int        Number of lines (default 20)")
+	fmt.Println("    -follow           Stream new lines until interrupted; all processes without -name")
+	fmt.Println("    -file string      Log file to read, as listed by logfiles; entirely unless -lines is given")
+	fmt.Println("  logfiles List the current and rotated log files of a process")
+	fmt.Println("    -name string      Name of the process")
+	fmt.Println("    -format string    Output format (json or empty for text)")
+	fmt.Println("  runs     List the last runs of a scheduled process")
+	fmt.Println("    -name string      Name of the process")
+	fmt.Println("    -format string    Output format (json or empty for text)")
+	fmt.Println("  schedule List the upcoming runs of a scheduled process")
+	fmt.Println("    -name string      Name of the process")
+	fmt.Println("    -count int        Number of upcoming runs (default 5)")
+	fmt.Println("    -format string    Output format (json or empty for text)")
+	fmt.Println("  send     Send a line to the input of a process started with -stdin")
+	fmt.Println("    -name string      Name of the process")
+	fmt.Println("    -input string     Line to send")
+	fmt.Println("    -eof              Close the input of the process after sending")
+	fmt.Println("  attach   Send our input to a process started with -stdin and show its output, until Ctrl+C")
+	fmt.Println("    -name string      Name of the process")
+	fmt.Println("  events   Stream the events of processes, like started, exited, crashed and health-failed, until interrupted")
+	fmt.Println("    -name string      Comma separated names of the processes (default: all)")
+	fmt.Println("    -format string    Output format (json or empty for text)")
+	fmt.Println("  export   Write the definitions of the processes as process.start heroscript")
+	fmt.Println("    -file string      File to write to (default: standard output)")
+	fmt.Println("  import   Start the processes of a heroscript file on the host of the process manager")
+	fmt.Println("    -file string      Heroscript file with process.start actions")
+	fmt.Println("    -replace          Replace processes that are defined otherwise")
 }
@@ -1,20 +1,35 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/freeflowuniverse/herolauncher/pkg/processmanager"
+	"github.com/freeflowuniverse/herolauncher/pkg/sshserver"
 )
 
 func main() {
 	// Parse command line flags
-	socketPath := flag.String("socket", "/tmp/processmanager.sock", "Path to the Unix domain socket")
+	socketPath := flag.String("socket", processmanager.DefaultSocket, "Path to the Unix domain socket, or tcp://host:port")
 	secret := flag.String("secret", "", "Authentication secret for the telnet server")
+	logDir := flag.String("logdir", "", "Directory of the process log files (default: working directory)")
+	logMaxSize := flag.Int64("log-max-size", processmanager.DefaultLogRotation.MaxSize/1024/1024, "Rotate a log file when it grows beyond this many MB (0 for no limit)")
+	logMaxAge := flag.Duration("log-max-age", processmanager.DefaultLogRotation.MaxAge, "Rotate a log file when it gets older than this (0 for no limit)")
+	logMaxFiles := flag.Int("log-max-files", processmanager.DefaultLogRotation.MaxFiles, "Number of compressed old log files to keep per process (0 to keep all)")
+	stateFile := flag.String("state", "", "Heroscript file to save the process definitions to and restore them from at startup")
+	initFile := flag.String("init", "", "Heroscript file of process.start actions to apply at startup, after the state is restored")
+	webhooks := flag.String("webhook", "", "Comma separated http or https URLs to post the process events to as JSON")
+	webhookEvents := flag.String("webhook-events", "", "Comma separated event types to post to the webhooks (default: all)")
+	webhookSecret := flag.String("webhook-secret", "", "Secret to sign the webhook calls with in the X-Webhook-Signature header")
+	sshAddress := flag.String("ssh", "", "TCP address to serve the telnet interface on over SSH, like :2222 (default: no SSH)")
+	sshHostKey := flag.String("ssh-host-key", "processmanager_host_key", "Private host key of the SSH server, generated if it does not exist")
+	sshAuthorizedKeys := flag.String("ssh-authorized-keys", "", "File of the public keys that may log in over SSH, besides the secret as password")
 	flag.Parse()
 
 	// Validate flags
@@ -24,6 +39,53 @@ func main() {
 
 	// Create process manager
 	pm := processmanager.NewProcessManager(*secret)
+	pm.SetLogRotation(processmanager.LogRotation{
+		Dir:      *logDir,
+		MaxSize:  *logMaxSize * 1024 * 1024,
+		MaxAge:   *logMaxAge,
+		MaxFiles: *logMaxFiles,
+	})
+
+	// Post the events of the processes to the webhooks
+	if *webhooks != "" {
+		var events []processmanager.EventType
+		if *webhookEvents != "" {
+			for _, name := range strings.Split(*webhookEvents, ",") {
+				eventType, err := processmanager.ParseEventType(strings.TrimSpace(name))
+				if err != nil {
+					log.Fatalf("Error: %v", err)
+				}
+				events = append(events, eventType)
+			}
+		}
+		for _, url := range strings.Split(*webhooks, ",") {
+			hook := processmanager.Webhook{URL: strings.TrimSpace(url), Events: events, Secret: *webhookSecret}
+			if err := pm.AddWebhook(hook); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+		}
+	}
+
+	// Log the events of the processes, like crashes and restarts
+	go func() {
+		for event := range pm.Events(context.Background()) {
+			fmt.Printf("Process %s %s: %s\n", event.Process, event.Type, event.Message)
+		}
+	}()
+
+	// Start the processes that were managed before the restart
+	if *stateFile != "" {
+		if err := pm.LoadState(*stateFile); err != nil {
+			log.Printf("Error restoring processes: %v", err)
+		}
+	}
+
+	// The init file wins over the restored definitions of its processes
+	if *initFile != "" {
+		if _, err := pm.ImportFile(*initFile, true); err != nil {
+			log.Printf("Error applying %s: %v", *initFile, err)
+		}
+	}
 
 	// Create telnet server
 	ts := processmanager.NewTelnetServer(pm)
@@ -35,6 +97,15 @@ func main() {
 		log.Fatalf("Failed to start telnet server: %v", err)
 	}
 
+	// Serve the telnet interface over SSH too
+	if *sshAddress != "" {
+		fmt.Printf("Starting process manager SSH server on: %s\n", *sshAddress)
+		err := ts.StartSSH(*sshAddress, sshserver.Config{HostKeyFile: *sshHostKey, AuthorizedKeysFile: *sshAuthorizedKeys})
+		if err != nil {
+			log.Fatalf("Failed to start SSH server: %v", err)
+		}
+	}
+
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -43,6 +114,11 @@ func main() {
 	sig := <-sigChan
 	fmt.Printf("Received signal %v, shutting down...\n", sig)
 
+	// Stop the processes, those that depend on others first
+	if err := pm.StopAll(); err != nil {
+		log.Printf("Error stopping processes: %v", err)
+	}
+
 	// Stop telnet server
 	err = ts.Stop()
 	if err != nil {
@@ -0,0 +1,213 @@
+package processmanager
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed cron expression, with 5 fields for the minute,
+// hour, day of the month, month and day of the week, or 6 fields with the
+// second first
+type CronSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+	// When both the day of the month and the day of the week are
+	// restricted, a day matches if either does, like in cron
+	domAny, dowAny bool
+	location       *time.Location
+}
+
+// cronField is the range and the names of the values of a cron field
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // names of the values from min, if any
+}
+
+var (
+	cronSecond = cronField{name: "second", min: 0, max: 59}
+	cronMinute = cronField{name: "minute", min: 0, max: 59}
+	cronHour   = cronField{name: "hour", min: 0, max: 23}
+	cronDom    = cronField{name: "day of month", min: 1, max: 31}
+	cronMonth  = cronField{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	// 7 is Sunday as well as 0
+	cronDow = cronField{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// cronDescriptors are the shorthands for common schedules
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a cron expression of 5 or 6 fields, or a shorthand like
+// @daily, in the named timezone, or in the local timezone if it is empty.
+// Fields hold *, values, ranges like 1-5, steps like */15 or 0-30/10 and
+// comma separated lists of those; months and days of the week may be given
+// by their first three letters.
+func ParseCron(expr, timezone string) (*CronSchedule, error) {
+	location := time.Local
+	if timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone '%s': %v", timezone, err)
+		}
+	}
+
+	spec := strings.TrimSpace(expr)
+	if descriptor, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = descriptor
+	}
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("invalid cron expression '%s', expected 5 or 6 fields", expr)
+	}
+
+	s := &CronSchedule{location: location}
+	var err error
+	for i, field := range []struct {
+		bits *uint64
+		def  cronField
+	}{
+		{&s.second, cronSecond},
+		{&s.minute, cronMinute},
+		{&s.hour, cronHour},
+		{&s.dom, cronDom},
+		{&s.month, cronMonth},
+		{&s.dow, cronDow},
+	} {
+		if *field.bits, err = parseCronField(fields[i], field.def); err != nil {
+			return nil, fmt.Errorf("invalid cron expression '%s': %v", expr, err)
+		}
+	}
+	// Sunday is day 0
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	s.domAny = isWildcard(fields[3])
+	s.dowAny = isWildcard(fields[5])
+	return s, nil
+}
+
+// isWildcard reports whether a field matches every value
+func isWildcard(field string) bool {
+	return field == "*" || field == "?"
+}
+
+// parseCronField returns the values of a field as bits
+func parseCronField(field string, def cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step '%s' in %s field", stepPart, def.name)
+			}
+		}
+
+		var from, to int
+		switch {
+		case isWildcard(rangePart):
+			from, to = def.min, def.max
+		case strings.Contains(rangePart, "-"):
+			fromPart, toPart, _ := strings.Cut(rangePart, "-")
+			var err error
+			if from, err = def.value(fromPart); err != nil {
+				return 0, err
+			}
+			if to, err = def.value(toPart); err != nil {
+				return 0, err
+			}
+			if from > to {
+				return 0, fmt.Errorf("invalid range '%s' in %s field", rangePart, def.name)
+			}
+		default:
+			var err error
+			if from, err = def.value(rangePart); err != nil {
+				return 0, err
+			}
+			to = from
+			// A value with a step, like 5/15, runs from the value on
+			if hasStep {
+				to = def.max
+			}
+		}
+
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// value parses a number or a name of the field
+func (def cronField) value(s string) (int, error) {
+	for i, name := range def.names {
+		if strings.EqualFold(s, name) {
+			return def.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < def.min || v > def.max {
+		return 0, fmt.Errorf("invalid value '%s' in %s field, expected %d-%d", s, def.name, def.min, def.max)
+	}
+	return v, nil
+}
+
+// Next returns the first time after t that matches the schedule, or the
+// zero time if there is none within five years
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.location).Truncate(time.Second).Add(time.Second)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		year, month, day := t.Date()
+		switch {
+		case s.month&(1<<uint(month)) == 0:
+			t = time.Date(year, month+1, 1, 0, 0, 0, 0, s.location)
+		case !s.dayMatches(t):
+			t = time.Date(year, month, day+1, 0, 0, 0, 0, s.location)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			next := time.Date(year, month, day, t.Hour()+1, 0, 0, 0, s.location)
+			// The next hour may be repeated when the clocks go back, and
+			// time.Date may then return its first occurrence, before t. The
+			// hour of t is truncated on the clock of the location rather
+			// than with Truncate, which rounds absolute time and is off in
+			// zones whose offset is not a whole number of hours.
+			if !next.After(t) {
+				next = t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second).Add(time.Hour)
+			}
+			t = next
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Truncate(time.Minute).Add(time.Minute)
+		case s.second&(1<<uint(t.Second())) == 0:
+			t = t.Add(time.Second)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches reports whether the day of t matches the day of the month and
+// the day of the week of the schedule
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,154 @@
+package processmanager
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr string
+		// err is part of the error, if the expression is invalid
+		err string
+	}{
+		{expr: "* * * * *"},
+		{expr: "*/15 * * * * *"},
+		{expr: "0 9-17/2 * * mon-fri"},
+		{expr: "0 0 1,15 jan,JUL ?"},
+		{expr: "5/20 * * * *"},
+		{expr: "0 0 * * 7"},
+		{expr: "@daily"},
+		{expr: " @Hourly "},
+		{expr: "* * * *", err: "expected 5 or 6 fields"},
+		{expr: "* * * * * * *", err: "expected 5 or 6 fields"},
+		{expr: "@often", err: "expected 5 or 6 fields"},
+		{expr: "60 * * * *", err: "invalid value '60' in minute field, expected 0-59"},
+		{expr: "* 24 * * *", err: "invalid value '24' in hour field"},
+		{expr: "* * 0 * *", err: "invalid value '0' in day of month field"},
+		{expr: "* * * 13 *", err: "invalid value '13' in month field"},
+		{expr: "* * * * 8", err: "invalid value '8' in day of week field"},
+		{expr: "* * * foo *", err: "invalid value 'foo' in month field"},
+		{expr: "*/0 * * * *", err: "invalid step '0' in minute field"},
+		{expr: "*/x * * * *", err: "invalid step 'x' in minute field"},
+		{expr: "30-10 * * * *", err: "invalid range '30-10' in minute field"},
+	}
+	for _, test := range tests {
+		_, err := ParseCron(test.expr, "UTC")
+		if test.err == "" && err != nil {
+			t.Errorf("%q: expected no error, got %v", test.expr, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%q: expected an error with %q, got %v", test.expr, test.err, err)
+		}
+	}
+
+	if _, err := ParseCron("@daily", "Nowhere/Special"); err == nil || !strings.Contains(err.Error(), "invalid timezone 'Nowhere/Special'") {
+		t.Errorf("Expected an invalid timezone to be rejected, got %v", err)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	// Wednesday
+	from := time.Date(2024, 5, 15, 10, 20, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", from, time.Date(2024, 5, 15, 10, 21, 0, 0, time.UTC)},
+		{"* * * * * *", from, from.Add(time.Second)},
+		{"*/15 * * * * *", from, time.Date(2024, 5, 15, 10, 20, 45, 0, time.UTC)},
+		// Times that match are not returned again
+		{"20 * * * *", time.Date(2024, 5, 15, 10, 20, 0, 0, time.UTC), time.Date(2024, 5, 15, 11, 20, 0, 0, time.UTC)},
+		{"0 9 * * *", from, time.Date(2024, 5, 16, 9, 0, 0, 0, time.UTC)},
+		{"5/20 * * * *", from, time.Date(2024, 5, 15, 10, 25, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", from, time.Date(2024, 5, 15, 13, 0, 0, 0, time.UTC)},
+		{"@hourly", from, time.Date(2024, 5, 15, 11, 0, 0, 0, time.UTC)},
+		{"@monthly", from, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", from, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * mon-fri", time.Date(2024, 5, 17, 12, 0, 0, 0, time.UTC), time.Date(2024, 5, 20, 0, 0, 0, 0, time.UTC)},
+		// 7 and 0 are both Sunday
+		{"0 0 * * 7", from, time.Date(2024, 5, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", from, time.Date(2024, 5, 19, 0, 0, 0, 0, time.UTC)},
+		// With both days restricted, either matches
+		{"0 0 1 * fri", from, time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * fri", time.Date(2024, 5, 25, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * fri", time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC), time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * fri", from, time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)},
+		// With one of them a wildcard, the other must match
+		{"0 0 13 * ?", from, time.Date(2024, 6, 13, 0, 0, 0, 0, time.UTC)},
+		// Months without the day are skipped
+		{"0 0 31 * *", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", from, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// There is none within five years
+		{"0 0 30 feb *", from, time.Time{}},
+	}
+	for _, test := range tests {
+		schedule, err := ParseCron(test.expr, "UTC")
+		if err != nil {
+			t.Fatalf("%q: failed to parse: %v", test.expr, err)
+		}
+		if got := schedule.Next(test.from); !got.Equal(test.want) {
+			t.Errorf("%q after %v: expected %v, got %v", test.expr, test.from, test.want, got)
+		}
+	}
+}
+
+func TestCronNextTimezone(t *testing.T) {
+	loadLocation := func(name string) *time.Location {
+		location, err := time.LoadLocation(name)
+		if err != nil {
+			t.Skipf("No timezone data for %s: %v", name, err)
+		}
+		return location
+	}
+	newYork := loadLocation("America/New_York")
+	stJohns := loadLocation("America/St_Johns")
+	troll := loadLocation("Antarctica/Troll")
+	kolkata := loadLocation("Asia/Kolkata")
+
+	tests := []struct {
+		name     string
+		expr     string
+		timezone string
+		from     time.Time
+		want     time.Time
+	}{
+		{"in the timezone", "0 9 * * *", "Asia/Kolkata",
+			time.Date(2024, 5, 15, 4, 0, 0, 0, time.UTC), time.Date(2024, 5, 16, 9, 0, 0, 0, kolkata)},
+		{"half hour offset", "0 * * * *", "Asia/Kolkata",
+			time.Date(2024, 5, 15, 10, 15, 0, 0, kolkata), time.Date(2024, 5, 15, 11, 0, 0, 0, kolkata)},
+		// The clocks go from 2:00 to 3:00, so a time in the gap runs at 3:00
+		{"spring forward", "30 2 * * *", "America/New_York",
+			time.Date(2024, 3, 10, 1, 0, 0, 0, newYork), time.Date(2024, 3, 11, 2, 30, 0, 0, newYork)},
+		{"after the gap", "0 * * * *", "America/New_York",
+			time.Date(2024, 3, 10, 1, 30, 0, 0, newYork), time.Date(2024, 3, 10, 3, 0, 0, 0, newYork)},
+		// The clocks go from 2:00 back to 1:00; an hourly schedule runs at
+		// the start of every hour, the repeated one included
+		{"fall back", "0 * * * *", "America/New_York",
+			time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC), time.Date(2024, 11, 3, 6, 0, 0, 0, time.UTC)},
+		{"after fall back", "0 * * * *", "America/New_York",
+			time.Date(2024, 11, 3, 6, 30, 0, 0, time.UTC), time.Date(2024, 11, 3, 7, 0, 0, 0, time.UTC)},
+		// From 1:30 the first time, the next hour is 1:00 the second time
+		{"fall back at a half hour offset", "0 * * * *", "America/St_Johns",
+			time.Date(2024, 11, 3, 4, 0, 0, 0, time.UTC), time.Date(2024, 11, 3, 4, 30, 0, 0, time.UTC)},
+		{"after fall back at a half hour offset", "0 5 * * *", "America/St_Johns",
+			time.Date(2024, 11, 3, 4, 45, 0, 0, time.UTC), time.Date(2024, 11, 3, 5, 0, 0, 0, stJohns)},
+		// The clocks go from 3:00 back to 1:00, so 1:00 and 2:00 are
+		// repeated and the next hour from 1:30 the second time, 2:00, is
+		// first found on its first occurrence, before 1:30
+		{"fall back two hours", "0 4 * * *", "Antarctica/Troll",
+			time.Date(2024, 10, 27, 1, 30, 0, 0, time.UTC), time.Date(2024, 10, 27, 4, 0, 0, 0, troll)},
+		{"repeated hours", "0 2 * * *", "Antarctica/Troll",
+			time.Date(2024, 10, 27, 1, 30, 0, 0, time.UTC), time.Date(2024, 10, 27, 2, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		schedule, err := ParseCron(test.expr, test.timezone)
+		if err != nil {
+			t.Fatalf("%s: failed to parse: %v", test.name, err)
+		}
+		if got := schedule.Next(test.from); !got.Equal(test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want.UTC(), got.UTC())
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package processmanager
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/paramsparser"
+)
+
+// HeroScript returns the process.start action that starts the process as
+// defined
+func (def ProcessDefinition) HeroScript() string {
+	heroscript := fmt.Sprintf("!!process.start name:'%s' command:'%s' log:%t", def.Name, def.Command, def.Log)
+
+	if def.Deadline > 0 {
+		heroscript += fmt.Sprintf(" deadline:%d", def.Deadline)
+	}
+
+	if def.Cron != "" {
+		heroscript += fmt.Sprintf(" cron:'%s'", def.Cron)
+	}
+
+	if def.Timezone != "" {
+		heroscript += fmt.Sprintf(" timezone:'%s'", def.Timezone)
+	}
+
+	if def.Jitter > 0 {
+		heroscript += fmt.Sprintf(" jitter:%d", def.Jitter)
+	}
+
+	if def.Overlap != "" {
+		heroscript += fmt.Sprintf(" overlap:'%s'", def.Overlap)
+	}
+
+	if def.JobID != "" {
+		heroscript += fmt.Sprintf(" jobid:'%s'", def.JobID)
+	}
+
+	// Never is the default, left out so definitions compare equal
+	if def.Restart != "" && def.Restart != RestartNever {
+		heroscript += fmt.Sprintf(" restart:'%s'", def.Restart)
+	}
+
+	if def.MaxRestarts > 0 {
+		heroscript += fmt.Sprintf(" maxrestarts:%d", def.MaxRestarts)
+	}
+
+	if len(def.Requires) > 0 {
+		heroscript += fmt.Sprintf(" requires:'%s'", strings.Join(def.Requires, ","))
+	}
+
+	if len(def.After) > 0 {
+		heroscript += fmt.Sprintf(" after:'%s'", strings.Join(def.After, ","))
+	}
+
+	if def.HealthCheck != "" {
+		heroscript += fmt.Sprintf(" check:'%s'", def.HealthCheck)
+	}
+
+	if def.HealthInterval > 0 {
+		heroscript += fmt.Sprintf(" checkinterval:%d", def.HealthInterval)
+	}
+
+	if def.HealthRetries > 0 {
+		heroscript += fmt.Sprintf(" checkretries:%d", def.HealthRetries)
+	}
+
+	if len(def.Env) > 0 {
+		heroscript += fmt.Sprintf(" env:'%s'", strings.Join(formatEnv(def.Env), ","))
+	}
+
+	if def.Dir != "" {
+		heroscript += fmt.Sprintf(" dir:'%s'", def.Dir)
+	}
+
+	if def.Umask != "" {
+		heroscript += fmt.Sprintf(" umask:'%s'", def.Umask)
+	}
+
+	if def.User != "" {
+		heroscript += fmt.Sprintf(" user:'%s'", def.User)
+	}
+
+	if def.Stdin {
+		heroscript += " stdin:true"
+	}
+
+	if def.Listen != "" {
+		heroscript += fmt.Sprintf(" listen:'%s'", def.Listen)
+	}
+
+	return heroscript
+}
+
+// parseDefinition returns the definition of a process from the parameters
+// of a process.start action
+func parseDefinition(params *paramsparser.ParamsParser) (ProcessDefinition, error) {
+	def := ProcessDefinition{
+		Name:        params.Get("name"),
+		Command:     params.GetVerbatim("command"),
+		Log:         params.GetBool("log"),
+		Cron:        params.GetVerbatim("cron"),
+		Timezone:    params.GetVerbatim("timezone"),
+		JobID:       params.GetVerbatim("jobid"),
+		Requires:    splitNames(params.Get("requires")),
+		After:       splitNames(params.Get("after")),
+		HealthCheck: params.GetVerbatim("check"),
+		Dir:         params.GetVerbatim("dir"),
+		Umask:       params.GetVerbatim("umask"),
+		User:        params.GetVerbatim("user"),
+		Stdin:       params.GetBool("stdin"),
+		Listen:      params.GetVerbatim("listen"),
+	}
+	if def.Name == "" {
+		return def, errors.New("name parameter is required")
+	}
+	if def.Command == "" {
+		return def, errors.New("command parameter is required")
+	}
+
+	def.Deadline, _ = params.GetInt("deadline")
+	def.Jitter, _ = params.GetInt("jitter")
+	def.MaxRestarts, _ = params.GetInt("maxrestarts")
+	def.HealthInterval, _ = params.GetInt("checkinterval")
+	def.HealthRetries, _ = params.GetInt("checkretries")
+
+	var err error
+	if def.Restart, err = ParseRestartPolicy(params.GetVerbatim("restart")); err != nil {
+		return def, err
+	}
+	if def.Env, err = ParseEnv(params.GetVerbatim("env")); err != nil {
+		return def, err
+	}
+	if overlap := params.GetVerbatim("overlap"); overlap != "" {
+		if def.Overlap, err = ParseOverlapPolicy(overlap); err != nil {
+			return def, err
+		}
+	}
+	return def, nil
+}
@@ -0,0 +1,197 @@
+package processmanager
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// checkDependencies rejects a definition that depends on itself or that
+// would make processes wait for each other in a cycle. It must be called
+// with the lock of the process manager held.
+func (pm *ProcessManager) checkDependencies(def ProcessDefinition) error {
+	dependencies := func(name string) []string {
+		if name == def.Name {
+			return append(slices.Clone(def.Requires), def.After...)
+		}
+		if procInfo, exists := pm.processes[name]; exists {
+			return append(slices.Clone(procInfo.Requires), procInfo.After...)
+		}
+		return nil
+	}
+
+	// Depth first search from the new process, with the path to report
+	var path []string
+	visited := make(map[string]bool)
+	var visit func(name string) error
+	visit = func(name string) error {
+		if i := slices.Index(path, name); i >= 0 {
+			return fmt.Errorf("dependency cycle: %s", strings.Join(append(path[i:], name), " -> "))
+		}
+		if visited[name] {
+			return nil
+		}
+		visited[name] = true
+		path = append(path, name)
+		for _, dependency := range dependencies(name) {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		return nil
+	}
+	return visit(def.Name)
+}
+
+// dependenciesReady reports whether the processes a process requires are
+// running and ready, and the managed processes it comes after are no
+// longer starting. It must be called with the lock of the process manager
+// held.
+func (pm *ProcessManager) dependenciesReady(procInfo *ProcessInfo) bool {
+	for _, name := range procInfo.Requires {
+		depInfo, exists := pm.processes[name]
+		if !exists {
+			return false
+		}
+		depInfo.mutex.Lock()
+		ready := depInfo.Status == ProcessStatusRunning && depInfo.Ready
+		depInfo.mutex.Unlock()
+		if !ready {
+			return false
+		}
+	}
+	for _, name := range procInfo.After {
+		depInfo, exists := pm.processes[name]
+		if !exists {
+			continue
+		}
+		depInfo.mutex.Lock()
+		starting := depInfo.Status == ProcessStatusWaiting || (depInfo.Status == ProcessStatusRunning && !depInfo.Ready)
+		depInfo.mutex.Unlock()
+		if starting {
+			return false
+		}
+	}
+	return true
+}
+
+// startWaiting starts the waiting processes whose dependencies are ready,
+// until no more can start. It must be called with the lock of the process
+// manager held.
+func (pm *ProcessManager) startWaiting() {
+	names := make([]string, 0, len(pm.processes))
+	for name := range pm.processes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for started := true; started; {
+		started = false
+		for _, name := range names {
+			procInfo := pm.processes[name]
+			procInfo.mutex.Lock()
+			waiting := procInfo.Status == ProcessStatusWaiting
+			procInfo.mutex.Unlock()
+			if !waiting || !pm.dependenciesReady(procInfo) {
+				continue
+			}
+
+			procInfo.mutex.Lock()
+			if err := pm.spawn(procInfo); err != nil {
+				procInfo.Status = ProcessStatusFailed
+				procInfo.Error = err.Error()
+				pm.scheduleRestart(procInfo, false)
+			} else {
+				started = true
+			}
+			procInfo.mutex.Unlock()
+		}
+	}
+}
+
+// dependents returns the processes that depend on the named processes,
+// directly or through other processes, ordered so that every process comes
+// before the processes it depends on. Only requires relationships are
+// followed unless after is true. It must be called with the lock of the
+// process manager held.
+func (pm *ProcessManager) dependents(names []string, after bool) []string {
+	var order []string
+	visited := make(map[string]bool)
+	for _, name := range names {
+		visited[name] = true
+	}
+
+	// Processes are added after their own dependents
+	var visit func(name string)
+	visit = func(name string) {
+		var direct []string
+		for other, procInfo := range pm.processes {
+			if slices.Contains(procInfo.Requires, name) || (after && slices.Contains(procInfo.After, name)) {
+				direct = append(direct, other)
+			}
+		}
+		sort.Strings(direct)
+		for _, other := range direct {
+			if visited[other] {
+				continue
+			}
+			visited[other] = true
+			visit(other)
+			order = append(order, other)
+		}
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	return order
+}
+
+// StopAll stops every process, the processes that depend on others first,
+// and returns the errors of processes that could not be stopped
+func (pm *ProcessManager) StopAll() error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	// Every process is stopped after the processes that depend on it
+	var roots []string
+	for name := range pm.processes {
+		roots = append(roots, name)
+	}
+	sort.Strings(roots)
+
+	var order []string
+	seen := make(map[string]bool)
+	for _, name := range roots {
+		if seen[name] {
+			continue
+		}
+		for _, dependent := range pm.dependents([]string{name}, true) {
+			if !seen[dependent] {
+				seen[dependent] = true
+				order = append(order, dependent)
+			}
+		}
+		seen[name] = true
+		order = append(order, name)
+	}
+
+	var errs []string
+	for _, name := range order {
+		procInfo := pm.processes[name]
+		procInfo.mutex.Lock()
+		active := procInfo.Status == ProcessStatusRunning || procInfo.Status == ProcessStatusWaiting || procInfo.restartTimer != nil || procInfo.cronTimer != nil
+		procInfo.mutex.Unlock()
+		if !active {
+			continue
+		}
+		if err := pm.stopProcess(procInfo); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to stop processes: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
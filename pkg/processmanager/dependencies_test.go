@@ -0,0 +1,150 @@
+package processmanager
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDependencyCycles(t *testing.T) {
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+
+	// Dependencies may name processes that are not managed yet
+	for _, def := range []ProcessDefinition{
+		{Name: "a", Command: "sleep 30", Requires: []string{"b"}},
+		{Name: "b", Command: "sleep 30", After: []string{"c"}},
+	} {
+		if err := pm.StartProcessDefinition(def); err != nil {
+			t.Fatalf("Failed to start %s: %v", def.Name, err)
+		}
+	}
+
+	tests := []struct {
+		def  ProcessDefinition
+		want string
+	}{
+		{ProcessDefinition{Name: "self", Command: "sleep 30", Requires: []string{"self"}}, "dependency cycle: self -> self"},
+		{ProcessDefinition{Name: "c", Command: "sleep 30", Requires: []string{"a"}}, "dependency cycle: c -> a -> b -> c"},
+		{ProcessDefinition{Name: "c", Command: "sleep 30", After: []string{"d", "b"}}, "dependency cycle: c -> b -> c"},
+	}
+	for _, test := range tests {
+		err := pm.StartProcessDefinition(test.def)
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%s: expected %q, got %v", test.def.Name, test.want, err)
+		}
+		if _, err := pm.GetProcessStatus(test.def.Name); err == nil {
+			t.Errorf("%s: expected the process not to be added", test.def.Name)
+		}
+	}
+
+	// Dependencies that do not close a cycle are fine
+	if err := pm.StartProcessDefinition(ProcessDefinition{Name: "c", Command: "sleep 30", After: []string{"d"}}); err != nil {
+		t.Errorf("Expected c to start, got %v", err)
+	}
+}
+
+func TestDependencyOrder(t *testing.T) {
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := pm.Events(ctx)
+
+	// The processes are started before the database they depend on, so
+	// they wait until it starts
+	for _, def := range []ProcessDefinition{
+		{Name: "app", Command: "sleep 30", Requires: []string{"db"}},
+		{Name: "worker", Command: "sleep 30", Requires: []string{"db"}},
+		{Name: "web", Command: "sleep 30", After: []string{"app"}},
+		{Name: "other", Command: "sleep 30"},
+	} {
+		if err := pm.StartProcessDefinition(def); err != nil {
+			t.Fatalf("Failed to start %s: %v", def.Name, err)
+		}
+	}
+	for _, name := range []string{"app", "worker"} {
+		if info, _ := pm.GetProcessStatus(name); info.Status != ProcessStatusWaiting {
+			t.Errorf("Expected %s to wait for db, got %s", name, info.Status)
+		}
+	}
+	// A process after another waits while the other waits
+	if info, _ := pm.GetProcessStatus("web"); info.Status != ProcessStatusWaiting {
+		t.Errorf("Expected web to wait for app, got %s", info.Status)
+	}
+	// but not for processes that are not managed
+	if err := pm.StartProcessDefinition(ProcessDefinition{Name: "cron", Command: "sleep 30", After: []string{"missing"}}); err != nil {
+		t.Fatalf("Failed to start cron: %v", err)
+	}
+	if info, _ := pm.GetProcessStatus("cron"); info.Status != ProcessStatusRunning {
+		t.Errorf("Expected cron to run, got %s", info.Status)
+	}
+	if err := pm.StartProcessDefinition(ProcessDefinition{Name: "db", Command: "sleep 30"}); err != nil {
+		t.Fatalf("Failed to start db: %v", err)
+	}
+	got := collectEvents(t, events, 6, EventStarted)
+	if want := "other:started cron:started db:started app:started web:started worker:started"; strings.Join(got, " ") != want {
+		t.Errorf("Expected the processes to start after their dependencies, got %q", got)
+	}
+
+	// Stopping a process stops the processes that require it first, but
+	// not those that only come after it
+	if err := pm.StopProcess("db"); err != nil {
+		t.Fatalf("Failed to stop db: %v", err)
+	}
+	got = collectEvents(t, events, 3, EventStopped)
+	if want := "app:stopped worker:stopped db:stopped"; strings.Join(got, " ") != want {
+		t.Errorf("Expected the processes that require db to stop first, got %q", got)
+	}
+	if info, _ := pm.GetProcessStatus("web"); info.Status != ProcessStatusRunning {
+		t.Errorf("Expected web to keep running, got %s", info.Status)
+	}
+}
+
+func TestStopAll(t *testing.T) {
+	pm := NewProcessManager("")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := pm.Events(ctx)
+
+	for _, def := range []ProcessDefinition{
+		{Name: "db", Command: "sleep 30"},
+		{Name: "app", Command: "sleep 30", Requires: []string{"db"}},
+		{Name: "web", Command: "sleep 30", After: []string{"app"}},
+		{Name: "cache", Command: "sleep 30"},
+		{Name: "api", Command: "sleep 30", Requires: []string{"cache", "db"}},
+		// Waits for a process that is not managed
+		{Name: "waiting", Command: "sleep 30", Requires: []string{"missing"}},
+		{Name: "done", Command: "exit 0"},
+	} {
+		if err := pm.StartProcessDefinition(def); err != nil {
+			t.Fatalf("Failed to start %s: %v", def.Name, err)
+		}
+	}
+	waitStatus(t, pm, "done", ProcessStatusCompleted)
+
+	// Every process stops after the processes that depend on it, those
+	// that come after it included, and the ones that are not active are
+	// left alone
+	if err := pm.StopAll(); err != nil {
+		t.Fatalf("Failed to stop all processes: %v", err)
+	}
+	got := collectEvents(t, events, 6, EventStopped)
+	if want := "api:stopped web:stopped app:stopped cache:stopped db:stopped waiting:stopped"; strings.Join(got, " ") != want {
+		t.Errorf("Expected the dependents to stop first, got %q", got)
+	}
+	for _, info := range pm.ListProcesses() {
+		want := ProcessStatusStopped
+		if info.Name == "done" {
+			want = ProcessStatusCompleted
+		}
+		if info.Status != want {
+			t.Errorf("Expected %s to be %s, got %s", info.Name, want, info.Status)
+		}
+	}
+
+	// Stopped processes are not stopped again
+	if err := pm.StopAll(); err != nil {
+		t.Errorf("Expected stopping the stopped processes to do nothing, got %v", err)
+	}
+}
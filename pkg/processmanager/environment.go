@@ -0,0 +1,78 @@
+package processmanager
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseUmask parses an octal umask like 022, where an empty string means
+// the umask of the daemon
+func parseUmask(s string) (uint32, error) {
+	if s == "" {
+		return 0, nil
+	}
+	umask, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || umask > 0777 {
+		return 0, fmt.Errorf("invalid umask '%s', expected an octal mode like 022", s)
+	}
+	return uint32(umask), nil
+}
+
+// ParseEnv parses a comma separated list of KEY=value variables
+func ParseEnv(list string) (map[string]string, error) {
+	env := make(map[string]string)
+	for _, variable := range strings.Split(list, ",") {
+		if variable = strings.TrimSpace(variable); variable == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(variable, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid environment variable '%s', expected KEY=value", variable)
+		}
+		env[key] = value
+	}
+	if len(env) == 0 {
+		return nil, nil
+	}
+	return env, nil
+}
+
+// formatEnv returns the variables of an environment as KEY=value, sorted
+// by key
+func formatEnv(env map[string]string) []string {
+	variables := make([]string, 0, len(env))
+	for key, value := range env {
+		variables = append(variables, key+"="+value)
+	}
+	sort.Strings(variables)
+	return variables
+}
+
+// environment makes the command of a process run as its user and returns
+// its environment: the environment of the daemon, the home and name of the
+// user it runs as, the variables describing its socket and then its own
+// variables. It returns nil when the process inherits the environment
+// unchanged.
+func environment(procInfo *ProcessInfo, cmd *exec.Cmd) ([]string, error) {
+	var vars []string
+	if procInfo.User != "" {
+		var err error
+		if vars, err = setUser(cmd, procInfo.User); err != nil {
+			return nil, fmt.Errorf("failed to run as user '%s': %v", procInfo.User, err)
+		}
+	}
+	// LISTEN_PID is set by the shell that runs the command
+	if procInfo.listener != nil {
+		vars = append(vars, "LISTEN_FDS=1", "LISTEN_FDNAMES="+procInfo.Name)
+	}
+	if len(vars) == 0 && len(procInfo.Env) == 0 {
+		return nil, nil
+	}
+	// Later variables replace earlier ones with the same key
+	env := append(os.Environ(), vars...)
+	return append(env, formatEnv(procInfo.Env)...), nil
+}
@@ -0,0 +1,120 @@
+package processmanager
+
+import (
+	"os"
+	"os/user"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnv(t *testing.T) {
+	tests := []struct {
+		list string
+		want map[string]string
+		ok   bool
+	}{
+		{"", nil, true},
+		{"A=1", map[string]string{"A": "1"}, true},
+		{" A=1 , B=x=y ,", map[string]string{"A": "1", "B": "x=y"}, true},
+		{"EMPTY=", map[string]string{"EMPTY": ""}, true},
+		{"A", nil, false},
+		{"=1", nil, false},
+	}
+	for _, test := range tests {
+		got, err := ParseEnv(test.list)
+		if !reflect.DeepEqual(got, test.want) || (err == nil) != test.ok {
+			t.Errorf("%q: expected %v, %v, got %v, %v", test.list, test.want, test.ok, got, err)
+		}
+	}
+}
+
+func TestParseUmask(t *testing.T) {
+	tests := []struct {
+		s    string
+		want uint32
+		ok   bool
+	}{
+		{"", 0, true},
+		{"022", 0o22, true},
+		{"0077", 0o77, true},
+		{"777", 0o777, true},
+		{"1000", 0, false},
+		{"089", 0, false},
+		{"rwx", 0, false},
+	}
+	for _, test := range tests {
+		got, err := parseUmask(test.s)
+		if got != test.want || (err == nil) != test.ok {
+			t.Errorf("%q: expected %o, %v, got %o, %v", test.s, test.want, test.ok, got, err)
+		}
+	}
+}
+
+// runOutput runs a process to completion and returns its output
+func runOutput(t *testing.T, pm *ProcessManager, def ProcessDefinition) string {
+	t.Helper()
+	if err := pm.StartProcessDefinition(def); err != nil {
+		t.Fatalf("%s: failed to start: %v", def.Name, err)
+	}
+	waitStatus(t, pm, def.Name, ProcessStatusCompleted)
+	logs, err := pm.GetProcessLogs(def.Name, 20)
+	if err != nil {
+		t.Fatalf("%s: failed to get the logs: %v", def.Name, err)
+	}
+	return logs
+}
+
+func TestProcessEnvironment(t *testing.T) {
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	t.Setenv("PM_INHERITED", "daemon")
+	dir := t.TempDir()
+
+	def := ProcessDefinition{
+		Name:    "env",
+		Command: `echo "$PM_INHERITED $GREETING"; pwd; umask`,
+		Env:     map[string]string{"GREETING": "hello", "PM_INHERITED": "process"},
+		Dir:     dir,
+		Umask:   "027",
+	}
+	want := "process hello\n" + dir + "\n0027"
+	if got := strings.TrimSpace(runOutput(t, pm, def)); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	// Without variables of its own a process inherits the environment
+	def = ProcessDefinition{Name: "inherit", Command: `echo "$PM_INHERITED"`}
+	if got := strings.TrimSpace(runOutput(t, pm, def)); got != "daemon" {
+		t.Errorf("Expected the variable of the daemon, got %q", got)
+	}
+
+	if err := pm.StartProcessDefinition(ProcessDefinition{Name: "bad", Command: "true", Umask: "999"}); err == nil {
+		t.Error("Expected an error starting a process with an invalid umask")
+	}
+	if err := pm.StartProcessDefinition(ProcessDefinition{Name: "missing", Command: "true", Dir: dir + "/missing"}); err == nil {
+		t.Error("Expected an error starting a process in a missing directory")
+	}
+}
+
+func TestProcessUser(t *testing.T) {
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+
+	if err := pm.StartProcessDefinition(ProcessDefinition{Name: "unknown", Command: "true", User: "no-such-user"}); err == nil {
+		t.Error("Expected an error starting a process as an unknown user")
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skip("Only root can run processes as other users")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("There is no user nobody")
+	}
+	def := ProcessDefinition{Name: "nobody", Command: `id -u; echo "$USER $HOME"`, User: "nobody", Dir: "/"}
+	want := nobody.Uid + "\nnobody " + nobody.HomeDir
+	if got := strings.TrimSpace(runOutput(t, pm, def)); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
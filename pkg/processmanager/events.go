@@ -0,0 +1,191 @@
+package processmanager
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+)
+
+// eventBufferSize is the number of events a subscriber can fall behind
+// before events are dropped for it
+const eventBufferSize = 64
+
+// EventType is the kind of an event of a managed process
+type EventType string
+
+const (
+	// EventStarted is emitted when the command of a process starts
+	EventStarted EventType = "started"
+	// EventExited is emitted when a process exits successfully
+	EventExited EventType = "exited"
+	// EventCrashed is emitted when a process exits with an error or is
+	// killed by the process manager, like for its deadline
+	EventCrashed EventType = "crashed"
+	// EventStopped is emitted when a process is stopped
+	EventStopped EventType = "stopped"
+	// EventRestarted is emitted when a process is started again, by its
+	// restart policy or on request
+	EventRestarted EventType = "restarted"
+	// EventCrashLoop is emitted when a process is no longer restarted
+	// because it kept exiting
+	EventCrashLoop EventType = "crashloop"
+	// EventHealthFailed is emitted for every failed health check of a
+	// process that was ready
+	EventHealthFailed EventType = "health-failed"
+	// EventUnhealthy is emitted when a process is restarted because its
+	// health check failed too many times in a row
+	EventUnhealthy EventType = "unhealthy"
+	// EventHealthy is emitted when the health check of a process passes
+	// again after it failed
+	EventHealthy EventType = "healthy"
+)
+
+// eventTypes are the types of events that are emitted
+var eventTypes = []EventType{
+	EventStarted, EventExited, EventCrashed, EventStopped, EventRestarted,
+	EventCrashLoop, EventHealthFailed, EventUnhealthy, EventHealthy,
+}
+
+// ParseEventType parses the type of an event
+func ParseEventType(s string) (EventType, error) {
+	if eventType := EventType(s); slices.Contains(eventTypes, eventType) {
+		return eventType, nil
+	}
+	return "", fmt.Errorf("invalid event type '%s'", s)
+}
+
+// Event is something that happened to a managed process
+type Event struct {
+	Process string    `json:"process"`
+	Type    EventType `json:"type"`
+	Time    time.Time `json:"time"`
+	Message string    `json:"message,omitempty"`
+}
+
+// Webhook is a URL that is called with every event it matches, as JSON
+type Webhook struct {
+	URL string `json:"url"`
+	// Events limits the webhook to events of these types, all if empty
+	Events []EventType `json:"events,omitempty"`
+	// Secret signs the calls with HMAC-SHA256 when set
+	Secret string `json:"secret,omitempty"`
+}
+
+// webhookClient calls the webhooks
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// eventStream sends the events of processes to their subscribers and
+// webhooks
+type eventStream struct {
+	subscribers map[chan Event]struct{}
+	webhooks    []Webhook
+	mutex       sync.Mutex
+}
+
+// emit sends an event to the subscribers and the webhooks that match it.
+// Events are dropped for subscribers that do not keep up, so they never
+// block the process manager.
+func (pm *ProcessManager) emit(process string, eventType EventType, message string) {
+	event := Event{Process: process, Type: eventType, Time: time.Now(), Message: message}
+
+	pm.events.mutex.Lock()
+	defer pm.events.mutex.Unlock()
+
+	for events := range pm.events.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+	// Webhooks are called in the background, so slow endpoints do not hold
+	// up the process manager
+	for _, hook := range pm.events.webhooks {
+		if len(hook.Events) == 0 || slices.Contains(hook.Events, eventType) {
+			go callWebhook(hook, event)
+		}
+	}
+}
+
+// AddWebhook adds a webhook that is called with the events of all processes
+// from now on
+func (pm *ProcessManager) AddWebhook(hook Webhook) error {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook URL '%s' must be an http or https URL", hook.URL)
+	}
+	for _, eventType := range hook.Events {
+		if _, err := ParseEventType(string(eventType)); err != nil {
+			return err
+		}
+	}
+
+	pm.events.mutex.Lock()
+	defer pm.events.mutex.Unlock()
+	pm.events.webhooks = append(pm.events.webhooks, hook)
+	return nil
+}
+
+// callWebhook posts an event to a webhook. With a secret the body is signed
+// in the X-Webhook-Signature header as sha256=<hex HMAC-SHA256>.
+func callWebhook(hook Webhook, event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("ERROR: Failed to marshal webhook event: %v", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("ERROR: Invalid webhook %s: %v", hook.URL, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", string(event.Type))
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		log.Printf("ERROR: Webhook to %s failed: %v", hook.URL, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Printf("ERROR: Webhook to %s failed: %s", hook.URL, resp.Status)
+	}
+}
+
+// Events returns the events of all processes from now on. The channel is
+// closed when ctx is done.
+func (pm *ProcessManager) Events(ctx context.Context) <-chan Event {
+	events := make(chan Event, eventBufferSize)
+
+	pm.events.mutex.Lock()
+	if pm.events.subscribers == nil {
+		pm.events.subscribers = make(map[chan Event]struct{})
+	}
+	pm.events.subscribers[events] = struct{}{}
+	pm.events.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		pm.events.mutex.Lock()
+		delete(pm.events.subscribers, events)
+		close(events)
+		pm.events.mutex.Unlock()
+	}()
+
+	return events
+}
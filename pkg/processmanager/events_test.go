@@ -0,0 +1,125 @@
+package processmanager
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEventType(t *testing.T) {
+	for _, eventType := range eventTypes {
+		if got, err := ParseEventType(string(eventType)); got != eventType || err != nil {
+			t.Errorf("%s: expected it back, got %q, %v", eventType, got, err)
+		}
+	}
+	if _, err := ParseEventType("Started"); err == nil {
+		t.Error("Expected an error for an unknown event type")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := pm.Events(ctx)
+
+	pm.StartProcess("ok", "true", false, 0, "", "")
+	waitStatus(t, pm, "ok", ProcessStatusCompleted)
+	pm.StartProcess("fail", "exit 2", false, 0, "", "")
+	waitStatus(t, pm, "fail", ProcessStatusFailed)
+	pm.StartProcess("sleeper", "sleep 30", false, 0, "", "")
+	pm.StopProcess("sleeper")
+	pm.RestartProcess("sleeper")
+
+	all := []EventType{EventStarted, EventExited, EventCrashed, EventStopped, EventRestarted}
+	got := collectEvents(t, events, 8, all...)
+	want := "ok:started,ok:exited,fail:started,fail:crashed,sleeper:started,sleeper:stopped,sleeper:started,sleeper:restarted"
+	if strings.Join(got, ",") != want {
+		t.Errorf("Expected %s, got %s", want, strings.Join(got, ","))
+	}
+
+	// The channel is closed after cancelling
+	cancel()
+	for range events {
+	}
+}
+
+func TestAddWebhook(t *testing.T) {
+	pm := NewProcessManager("")
+	tests := []struct {
+		hook Webhook
+		ok   bool
+	}{
+		{Webhook{URL: "http://localhost:8080/hook"}, true},
+		{Webhook{URL: "https://example.com/hook", Events: []EventType{EventCrashed}}, true},
+		{Webhook{URL: "ftp://example.com/hook"}, false},
+		{Webhook{URL: "/hook"}, false},
+		{Webhook{URL: "http://localhost/hook", Events: []EventType{"boom"}}, false},
+	}
+	for _, test := range tests {
+		if err := pm.AddWebhook(test.hook); (err == nil) != test.ok {
+			t.Errorf("%+v: expected ok %v, got %v", test.hook, test.ok, err)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	type call struct {
+		header http.Header
+		body   []byte
+	}
+	calls := make(chan call, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		calls <- call{r.Header, body}
+	}))
+	defer server.Close()
+
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	hook := Webhook{URL: server.URL, Events: []EventType{EventCrashed}, Secret: "shh"}
+	if err := pm.AddWebhook(hook); err != nil {
+		t.Fatalf("Failed to add the webhook: %v", err)
+	}
+	pm.StartProcess("ok", "true", false, 0, "", "")
+	waitStatus(t, pm, "ok", ProcessStatusCompleted)
+	pm.StartProcess("fail", "exit 2", false, 0, "", "")
+
+	var got call
+	select {
+	case got = <-calls:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the webhook to be called")
+	}
+	var event Event
+	if err := json.Unmarshal(got.body, &event); err != nil {
+		t.Fatalf("Expected an event as JSON, got %q", got.body)
+	}
+	if event.Process != "fail" || event.Type != EventCrashed || event.Message != "process exited with code 2" {
+		t.Errorf("Expected the crash of fail, got %+v", event)
+	}
+	if got.header.Get("X-Webhook-Event") != "crashed" || got.header.Get("Content-Type") != "application/json" {
+		t.Errorf("Expected the event type and JSON in the headers, got %v", got.header)
+	}
+	mac := hmac.New(sha256.New, []byte("shh"))
+	mac.Write(got.body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); got.header.Get("X-Webhook-Signature") != want {
+		t.Errorf("Expected signature %s, got %s", want, got.header.Get("X-Webhook-Signature"))
+	}
+
+	// Only the crash matched the webhook
+	select {
+	case extra := <-calls:
+		t.Errorf("Expected one call, also got %s", extra.body)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
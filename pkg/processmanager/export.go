@@ -0,0 +1,143 @@
+package processmanager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+)
+
+// ImportResult is the outcome of an import, as returned by process.import
+// with format:'json'
+type ImportResult struct {
+	// Started lists the processes that were started or replaced
+	Started []string `json:"started"`
+	// Error lists the processes that could not be started, if any
+	Error string `json:"error,omitempty"`
+}
+
+// heroScript returns the process.start actions of the definitions, one
+// paragraph each
+func heroScript(defs []ProcessDefinition) string {
+	var b strings.Builder
+	for _, def := range defs {
+		b.WriteString(def.HeroScript())
+		b.WriteString("\n\n")
+	}
+	return b.String()
+}
+
+// Export returns the definitions of the managed processes as process.start
+// heroscript actions, sorted by name, which Import applies again
+func (pm *ProcessManager) Export() string {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+	return heroScript(pm.definitions())
+}
+
+// Import starts the processes defined by the process.start actions of a
+// heroscript, like Export returns, and ignores other actions. A process
+// that is already managed with the same definition is left alone; with
+// another definition it is replaced if replace is true and an error
+// otherwise. It returns the names of the processes it started and the
+// errors of the processes it could not start.
+func (pm *ProcessManager) Import(script string, replace bool) ([]string, error) {
+	pb, err := playbook.NewFromText(script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse heroscript: %v", err)
+	}
+	return pm.importPlayBook(pb, replace)
+}
+
+// ImportFile imports the heroscript file at path, see Import. The files it
+// includes are relative to its directory.
+func (pm *ProcessManager) ImportFile(path string, replace bool) ([]string, error) {
+	pb, err := playbook.NewFromFile(path, 10)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse heroscript: %v", err)
+	}
+	return pm.importPlayBook(pb, replace)
+}
+
+// importPlayBook starts the processes of a parsed heroscript, see Import
+func (pm *ProcessManager) importPlayBook(pb *playbook.PlayBook, replace bool) ([]string, error) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	started, err := pm.applyPlayBook(pb, replace)
+	pm.closeListeners()
+	if len(started) > 0 {
+		pm.saveState()
+	}
+	return started, err
+}
+
+// apply starts the processes defined by the process.start actions of a
+// heroscript, see Import. It must be called with the lock of the process
+// manager held.
+func (pm *ProcessManager) apply(script string, replace bool) ([]string, error) {
+	pb, err := playbook.NewFromText(script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse heroscript: %v", err)
+	}
+	return pm.applyPlayBook(pb, replace)
+}
+
+// applyPlayBook starts the processes of a parsed heroscript, see apply
+func (pm *ProcessManager) applyPlayBook(pb *playbook.PlayBook, replace bool) ([]string, error) {
+	// Processes wait for the processes they depend on, so the order of the
+	// definitions does not matter
+	var started, errs []string
+	for _, action := range pb.Actions {
+		if action.Actor != "process" || action.Name != "start" || action.Params == nil {
+			continue
+		}
+		def, err := parseDefinition(action.Params)
+		if err == nil {
+			err = pm.applyDefinition(def, replace)
+			if err == nil {
+				started = append(started, def.Name)
+			} else if err == errUnchanged {
+				err = nil
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", def.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return started, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return started, nil
+}
+
+// errUnchanged is returned by applyDefinition for a process that is
+// managed as defined already
+var errUnchanged = fmt.Errorf("process is unchanged")
+
+// applyDefinition starts a process as defined, replacing a managed process
+// of the same name with another definition if replace is true. It must be
+// called with the lock of the process manager held.
+func (pm *ProcessManager) applyDefinition(def ProcessDefinition, replace bool) error {
+	procInfo, exists := pm.processes[def.Name]
+	if !exists {
+		return pm.startProcess(def)
+	}
+
+	procInfo.mutex.Lock()
+	current := procInfo.definition()
+	procInfo.mutex.Unlock()
+	if current.HeroScript() == def.HeroScript() {
+		return errUnchanged
+	}
+	if !replace {
+		return fmt.Errorf("process with name '%s' already exists with another definition", def.Name)
+	}
+
+	// The definition is checked before the running process is removed
+	if _, err := pm.checkDefinition(def); err != nil {
+		return err
+	}
+	pm.deleteProcess(procInfo)
+	return pm.startProcess(def)
+}
@@ -0,0 +1,135 @@
+package processmanager
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+)
+
+func TestDefinitionHeroScript(t *testing.T) {
+	// Parsing sets the default restart policy
+	defs := []ProcessDefinition{
+		{Name: "minimal", Command: "sleep 30", Restart: RestartNever},
+		{
+			Name:           "web",
+			Command:        `python3 -m http.server "$PORT" >/dev/null`,
+			Log:            true,
+			Deadline:       60,
+			Restart:        RestartOnFailure,
+			MaxRestarts:    5,
+			Requires:       []string{"db", "cache"},
+			After:          []string{"migrate"},
+			HealthCheck:    "http://localhost:8080/health",
+			HealthInterval: 5,
+			HealthRetries:  2,
+			Env:            map[string]string{"PORT": "8080", "MODE": "Production"},
+			Dir:            "/srv/Web",
+			Umask:          "027",
+			User:           "www-data:www-data",
+			Stdin:          true,
+			Listen:         "tcp://127.0.0.1:8080",
+		},
+		{
+			Name:     "backup",
+			Command:  "/usr/local/bin/Backup.sh --all",
+			Cron:     "30 2 * * MON-FRI",
+			Timezone: "Europe/Brussels",
+			Jitter:   30,
+			Overlap:  OverlapQueue,
+			JobID:    "Nightly",
+			Restart:  RestartNever,
+		},
+	}
+	for _, def := range defs {
+		pb, err := playbook.NewFromText(def.HeroScript())
+		if err != nil {
+			t.Errorf("%s: failed to parse %s: %v", def.Name, def.HeroScript(), err)
+			continue
+		}
+		if len(pb.Actions) != 1 {
+			t.Errorf("%s: expected one action, got %d", def.Name, len(pb.Actions))
+			continue
+		}
+		got, err := parseDefinition(pb.Actions[0].Params)
+		if err != nil {
+			t.Errorf("%s: failed to parse the definition: %v", def.Name, err)
+		} else if !reflect.DeepEqual(got, def) {
+			t.Errorf("%s: expected %+v, got %+v", def.Name, def, got)
+		}
+	}
+}
+
+func TestParseDefinitionErrors(t *testing.T) {
+	tests := []struct {
+		script string
+		error  string
+	}{
+		{"!!process.start command:'true'", "name parameter is required"},
+		{"!!process.start name:'web'", "command parameter is required"},
+		{"!!process.start name:'web' command:'true' restart:'sometimes'", "invalid restart policy"},
+		{"!!process.start name:'web' command:'true' env:'PORT'", "invalid environment variable"},
+		{"!!process.start name:'web' command:'true' cron:'* * * * *' overlap:'maybe'", "overlap"},
+	}
+	for _, test := range tests {
+		pb, err := playbook.NewFromText(test.script)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", test.script, err)
+		}
+		if _, err := parseDefinition(pb.Actions[0].Params); err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("%s: expected an error with %q, got %v", test.script, test.error, err)
+		}
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	pm.StartProcessDefinition(ProcessDefinition{Name: "web", Command: "sleep 30", Restart: RestartAlways})
+	pm.StartProcessDefinition(ProcessDefinition{Name: "db", Command: "sleep 40"})
+
+	script := pm.Export()
+	if !strings.HasPrefix(script, "!!process.start name:'db'") || strings.Count(script, "!!process.start") != 2 {
+		t.Errorf("Expected db and web in order, got:\n%s", script)
+	}
+
+	// Other actions are ignored, an unchanged process is left alone
+	other := NewProcessManager("")
+	defer other.StopAll()
+	started, err := other.Import("!!process.list\n\n"+script, false)
+	if err != nil || strings.Join(started, ",") != "db,web" {
+		t.Fatalf("Expected db and web to be started, got %v, %v", started, err)
+	}
+	web, _ := other.GetProcessStatus("web")
+	pid := web.PID
+	if started, err := other.Import(script, false); err != nil || len(started) != 0 {
+		t.Errorf("Expected nothing to be started again, got %v, %v", started, err)
+	}
+
+	// A changed process is only replaced on request, the others still start
+	changed := strings.Replace(script, "sleep 30", "sleep 50", 1) +
+		"!!process.start name:'cache' command:'sleep 30'\n\n!!process.start name:'broken'\n"
+	started, err = other.Import(changed, false)
+	if err == nil || !strings.Contains(err.Error(), "web: process with name 'web' already exists") || !strings.Contains(err.Error(), "command parameter is required") {
+		t.Errorf("Expected errors for web and broken, got %v", err)
+	}
+	if strings.Join(started, ",") != "cache" {
+		t.Errorf("Expected cache to be started, got %v", started)
+	}
+	if web, _ := other.GetProcessStatus("web"); web.PID != pid {
+		t.Error("Expected web to be left alone")
+	}
+
+	started, _ = other.Import(changed, true)
+	if strings.Join(started, ",") != "web" {
+		t.Errorf("Expected web to be replaced, got %v", started)
+	}
+	if web, _ := other.GetProcessStatus("web"); web.Command != "sleep 50" || web.PID == pid {
+		t.Errorf("Expected web to run the new command, got %q with PID %d", web.Command, web.PID)
+	}
+
+	if _, err := other.Import("!!process.start name:'unterminated", false); err == nil {
+		t.Error("Expected an error for an invalid heroscript")
+	}
+}
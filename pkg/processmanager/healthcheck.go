@@ -0,0 +1,175 @@
+package processmanager
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// HealthStatus is the outcome of the health checks of a process
+type HealthStatus string
+
+const (
+	// HealthStarting indicates the health check did not pass yet
+	HealthStarting HealthStatus = "starting"
+	// HealthHealthy indicates the last health check passed
+	HealthHealthy HealthStatus = "healthy"
+	// HealthUnhealthy indicates the last health check failed after the
+	// process was healthy
+	HealthUnhealthy HealthStatus = "unhealthy"
+)
+
+const (
+	// healthCheckStartInterval is the time between runs of the health check
+	// of a process that did not pass it yet
+	healthCheckStartInterval = time.Second
+	// defaultHealthInterval is the time between runs of the health check of
+	// a healthy process unless its definition sets one
+	defaultHealthInterval = 10 * time.Second
+	// defaultHealthRetries is the number of failed health checks in a row
+	// that restart a process unless its definition sets one
+	defaultHealthRetries = 3
+	// healthCheckTimeout is how long a single run of a health check may take
+	healthCheckTimeout = 10 * time.Second
+)
+
+// runHealthCheck runs a health check and returns an error unless it passes.
+// A check is a tcp:// address that must accept connections, an http:// or
+// https:// URL that must answer a GET with a status below 400, or else a
+// command that must exit with 0.
+func runHealthCheck(ctx context.Context, check string) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	switch {
+	case strings.HasPrefix(check, "tcp://"):
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", strings.TrimPrefix(check, "tcp://"))
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case strings.HasPrefix(check, "http://"), strings.HasPrefix(check, "https://"):
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, check, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("status %s", resp.Status)
+		}
+		return nil
+	default:
+		output, err := shellCommand(ctx, check).CombinedOutput()
+		if err != nil {
+			if out := strings.TrimSpace(string(output)); out != "" {
+				return fmt.Errorf("%v: %s", err, out)
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+// monitorHealth runs the health check of a process every second until it
+// passes and then on its interval, until ctx is done because the process
+// exited or was stopped
+func (pm *ProcessManager) monitorHealth(ctx context.Context, procInfo *ProcessInfo, cmd *exec.Cmd) {
+	interval := defaultHealthInterval
+	if procInfo.HealthInterval > 0 {
+		interval = time.Duration(procInfo.HealthInterval) * time.Second
+	}
+
+	for {
+		err := runHealthCheck(ctx, procInfo.HealthCheck)
+		if ctx.Err() != nil {
+			return
+		}
+		ready, ok := pm.recordHealth(procInfo, cmd, err)
+		if !ok {
+			return
+		}
+
+		wait := interval
+		if !ready {
+			wait = healthCheckStartInterval
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// recordHealth updates the health of a process with the result of a check.
+// The first passing check makes the process ready and starts the processes
+// waiting for it. After too many failures in a row the process is killed
+// to be restarted. It returns whether the process is ready and false for
+// ok when the checks should stop.
+func (pm *ProcessManager) recordHealth(procInfo *ProcessInfo, cmd *exec.Cmd, err error) (ready, ok bool) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	procInfo.mutex.Lock()
+	defer procInfo.mutex.Unlock()
+
+	if pm.processes[procInfo.Name] != procInfo || procInfo.cmd != cmd || procInfo.Status != ProcessStatusRunning {
+		return false, false
+	}
+
+	if err == nil {
+		recovered := procInfo.Health == HealthUnhealthy
+		procInfo.Health = HealthHealthy
+		procInfo.HealthFailures = 0
+		procInfo.HealthError = ""
+		if recovered {
+			pm.emit(procInfo.Name, EventHealthy, "health check passes again")
+		}
+		if !procInfo.Ready {
+			procInfo.Ready = true
+			// startWaiting locks the processes it starts
+			procInfo.mutex.Unlock()
+			pm.startWaiting()
+			procInfo.mutex.Lock()
+		}
+		return true, true
+	}
+
+	procInfo.HealthError = err.Error()
+	// Until it is ready a process is starting up and failures do not count
+	if !procInfo.Ready {
+		return false, true
+	}
+
+	procInfo.Health = HealthUnhealthy
+	procInfo.HealthFailures++
+	pm.emit(procInfo.Name, EventHealthFailed, fmt.Sprintf("health check failed %d times in a row: %v", procInfo.HealthFailures, err))
+	retries := defaultHealthRetries
+	if procInfo.HealthRetries > 0 {
+		retries = procInfo.HealthRetries
+	}
+	if procInfo.HealthFailures < retries {
+		return true, true
+	}
+
+	message := fmt.Sprintf("health check failed %d times in a row, restarting: %v", procInfo.HealthFailures, err)
+	pm.emit(procInfo.Name, EventUnhealthy, message)
+	procInfo.unhealthy = true
+	procInfo.killReason = message
+	if err := killProcess(cmd); err != nil {
+		procInfo.unhealthy = false
+		procInfo.killReason = ""
+		procInfo.Error = fmt.Sprintf("failed to kill unhealthy process: %v", err)
+		return true, true
+	}
+	return true, false
+}
@@ -0,0 +1,122 @@
+package processmanager
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunHealthCheck(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	closed.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		check string
+		ok    bool
+		error string
+	}{
+		{"tcp://" + listener.Addr().String(), true, ""},
+		{"tcp://" + closed.Addr().String(), false, "refused"},
+		{server.URL + "/health", true, ""},
+		{server.URL + "/other", false, "status 503"},
+		{"true", true, ""},
+		{"echo not ready; exit 1", false, "exit status 1: not ready"},
+	}
+	for _, test := range tests {
+		err := runHealthCheck(context.Background(), test.check)
+		if (err == nil) != test.ok || (err != nil && !strings.Contains(err.Error(), test.error)) {
+			t.Errorf("%s: expected ok %v and error %q, got %v", test.check, test.ok, test.error, err)
+		}
+	}
+}
+
+// waitHealth waits until a process has the health
+func waitHealth(t *testing.T, pm *ProcessManager, name string, health HealthStatus) *ProcessInfo {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		info, err := pm.GetProcessStatus(name)
+		if err == nil && info.Health == health {
+			return info
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Expected process %s to be %s, got %+v, %v", name, health, info, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := pm.Events(ctx)
+
+	file := filepath.Join(t.TempDir(), "healthy")
+	def := ProcessDefinition{
+		Name:           "web",
+		Command:        "sleep 30",
+		HealthCheck:    "test -f " + file,
+		HealthInterval: 1,
+		HealthRetries:  2,
+	}
+	if err := pm.StartProcessDefinition(def); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+
+	// Failures while starting up do not count
+	time.Sleep(1500 * time.Millisecond)
+	info, _ := pm.GetProcessStatus("web")
+	if info.Health != HealthStarting || info.Ready || info.HealthFailures != 0 || info.HealthError == "" {
+		t.Errorf("Expected the process to be starting with the error of the check, got %s, ready %v, %d failures, %q",
+			info.Health, info.Ready, info.HealthFailures, info.HealthError)
+	}
+	os.WriteFile(file, nil, 0644)
+	if info := waitHealth(t, pm, "web", HealthHealthy); !info.Ready {
+		t.Error("Expected a healthy process to be ready")
+	}
+
+	// A failure followed by a passing check recovers
+	os.Remove(file)
+	collectEvents(t, events, 1, EventHealthFailed)
+	os.WriteFile(file, nil, 0644)
+	collectEvents(t, events, 1, EventHealthy)
+	if info := waitHealth(t, pm, "web", HealthHealthy); info.HealthFailures != 0 {
+		t.Errorf("Expected the failures to be reset, got %d", info.HealthFailures)
+	}
+
+	// Too many failures in a row restart the process, though it never
+	// restarts by its policy
+	pid := info.PID
+	os.Remove(file)
+	got := collectEvents(t, events, 4, EventHealthFailed, EventUnhealthy, EventRestarted)
+	if strings.Join(got, ",") != "web:health-failed,web:health-failed,web:unhealthy,web:restarted" {
+		t.Errorf("Expected two failures, unhealthy and restarted, got %q", got)
+	}
+	info = waitStatus(t, pm, "web", ProcessStatusRunning)
+	if info.PID == pid || info.Restarts != 1 || info.Health != HealthStarting || info.Ready {
+		t.Errorf("Expected a new process that is starting, got PID %d after %d restarts, %s, ready %v",
+			info.PID, info.Restarts, info.Health, info.Ready)
+	}
+}
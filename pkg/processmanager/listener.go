@@ -0,0 +1,86 @@
+package processmanager
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// parseListen parses the Listen address of a process, a tcp://host:port
+// address or a unix:// socket path, into a network and an address
+func parseListen(listen string) (network, address string, err error) {
+	switch {
+	case strings.HasPrefix(listen, "tcp://"):
+		network, address = "tcp", strings.TrimPrefix(listen, "tcp://")
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return "", "", fmt.Errorf("invalid listen address '%s': %v", listen, err)
+		}
+	case strings.HasPrefix(listen, "unix://"):
+		network, address = "unix", strings.TrimPrefix(listen, "unix://")
+		if address == "" {
+			return "", "", fmt.Errorf("invalid listen address '%s', expected a socket path", listen)
+		}
+	default:
+		return "", "", fmt.Errorf("invalid listen address '%s', expected tcp://host:port or unix://path", listen)
+	}
+	return network, address, nil
+}
+
+// listen returns the socket for a Listen address, which is opened unless a
+// process listens on it already. It must be called with the lock of the
+// process manager held.
+func (pm *ProcessManager) listen(listen string) (*os.File, error) {
+	if file, ok := pm.listeners[listen]; ok {
+		return file, nil
+	}
+
+	network, address, err := parseListen(listen)
+	if err != nil {
+		return nil, err
+	}
+	// A socket left behind by an earlier run is in the way
+	if info, err := os.Stat(address); network == "unix" && err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(address)
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", listen, err)
+	}
+
+	// The processes get a copy of the socket, which the listener is not
+	// needed for anymore
+	var file *os.File
+	switch l := listener.(type) {
+	case *net.TCPListener:
+		file, err = l.File()
+	case *net.UnixListener:
+		l.SetUnlinkOnClose(false)
+		file, err = l.File()
+	}
+	listener.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", listen, err)
+	}
+	pm.listeners[listen] = file
+	return file, nil
+}
+
+// closeListeners closes the sockets no process listens on anymore. It must
+// be called with the lock of the process manager held.
+func (pm *ProcessManager) closeListeners() {
+	used := make(map[string]bool)
+	for _, procInfo := range pm.processes {
+		used[procInfo.Listen] = true
+	}
+	for listen, file := range pm.listeners {
+		if used[listen] {
+			continue
+		}
+		file.Close()
+		if network, address, _ := parseListen(listen); network == "unix" {
+			os.Remove(address)
+		}
+		delete(pm.listeners, listen)
+	}
+}
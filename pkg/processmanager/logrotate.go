@@ -0,0 +1,339 @@
+package processmanager
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedTimeFormat is the time in the names of rotated log files
+const rotatedTimeFormat = "20060102-150405.000"
+
+// LogRotation configures the log files of processes started with logging
+// enabled. A log file is rotated when a write would make it larger than
+// MaxSize or when it is older than MaxAge; the old file is compressed and
+// only the newest MaxFiles compressed files are kept. A zero value disables
+// the limit.
+type LogRotation struct {
+	// Dir is the directory of the log files, the working directory if empty
+	Dir      string
+	MaxSize  int64
+	MaxAge   time.Duration
+	MaxFiles int
+}
+
+// DefaultLogRotation rotates log files at 10MB or after a day and keeps
+// 5 old files per process
+var DefaultLogRotation = LogRotation{
+	MaxSize:  10 * 1024 * 1024,
+	MaxAge:   24 * time.Hour,
+	MaxFiles: 5,
+}
+
+// LogFile is a current or rotated log file of a process
+type LogFile struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	Compressed bool      `json:"compressed"`
+}
+
+// SetLogRotation changes how the log files of processes started from now on
+// are rotated
+func (pm *ProcessManager) SetLogRotation(rotation LogRotation) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	pm.logRotation = rotation
+}
+
+// logPath returns the path of the current log file of a process
+func (pm *ProcessManager) logPath(name string) string {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+	return filepath.Join(pm.logRotation.Dir, name+".log")
+}
+
+// ListLogFiles returns the log files of a process, the current one first
+// and then the rotated ones from new to old
+func (pm *ProcessManager) ListLogFiles(name string) ([]LogFile, error) {
+	path := pm.logPath(name)
+	var files []LogFile
+	if info, err := os.Stat(path); err == nil {
+		files = append(files, LogFile{Name: filepath.Base(path), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	rotated, err := rotatedFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list log files: %v", err)
+	}
+	for i := len(rotated) - 1; i >= 0; i-- {
+		info, err := os.Stat(rotated[i])
+		if err != nil {
+			continue
+		}
+		files = append(files, LogFile{
+			Name:       filepath.Base(rotated[i]),
+			Size:       info.Size(),
+			ModTime:    info.ModTime(),
+			Compressed: strings.HasSuffix(rotated[i], ".gz"),
+		})
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no log files for process '%s'", name)
+	}
+	return files, nil
+}
+
+// ReadLogFile returns the last lines of a log file of a process as listed
+// by ListLogFiles, or the whole file if lines is 0. Compressed files are
+// decompressed.
+func (pm *ProcessManager) ReadLogFile(name, file string, lines int) (string, error) {
+	path := pm.logPath(name)
+	// Only the files of the process can be read
+	base := filepath.Base(path)
+	if file != base && !isRotated(base, file) {
+		return "", fmt.Errorf("'%s' is not a log file of process '%s'", file, name)
+	}
+
+	f, err := os.Open(filepath.Join(filepath.Dir(path), file))
+	if err != nil {
+		return "", fmt.Errorf("failed to open log file: %v", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(file, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return "", fmt.Errorf("failed to decompress log file: %v", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read log file: %v", err)
+	}
+	content := strings.TrimSuffix(string(data), "\n")
+	if lines > 0 {
+		all := strings.Split(content, "\n")
+		if len(all) > lines {
+			content = strings.Join(all[len(all)-lines:], "\n")
+		}
+	}
+	return content, nil
+}
+
+// rotatedFiles returns the rotated files of a log file from old to new
+func rotatedFiles(path string) ([]string, error) {
+	matches, err := filepath.Glob(globEscape(path) + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, match := range matches {
+		if isRotated(filepath.Base(path), filepath.Base(match)) {
+			files = append(files, match)
+		}
+	}
+	// The time in the names sorts in the order of rotation
+	sort.Strings(files)
+	return files, nil
+}
+
+// isRotated reports whether file is a rotated file of the log file base,
+// compressed or not yet
+func isRotated(base, file string) bool {
+	suffix, ok := strings.CutPrefix(file, base+".")
+	if !ok {
+		return false
+	}
+	_, err := time.Parse(rotatedTimeFormat, strings.TrimSuffix(suffix, ".gz"))
+	return err == nil
+}
+
+// globEscape escapes the characters of a path that filepath.Glob treats
+// as a pattern
+func globEscape(path string) string {
+	var b strings.Builder
+	for _, c := range path {
+		if strings.ContainsRune(`*?[\`, c) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// rotatingFile is a log file that is rotated according to a LogRotation
+type rotatingFile struct {
+	path     string
+	rotation LogRotation
+	file     *os.File
+	size     int64
+	opened   time.Time
+	mutex    sync.Mutex
+	// compressing is done when the last rotated file is compressed
+	compressing sync.WaitGroup
+}
+
+// openRotatingFile opens a log file for appending, rotating it first if it
+// is too old already
+func openRotatingFile(path string, rotation LogRotation) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, rotation: rotation}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	if info, err := rf.file.Stat(); err == nil && rf.size > 0 && rotation.MaxAge > 0 && time.Since(info.ModTime()) > rotation.MaxAge {
+		if err := rf.rotate(); err != nil {
+			rf.Close()
+			return nil, err
+		}
+	}
+	return rf, nil
+}
+
+// open opens the current log file
+func (rf *rotatingFile) open() error {
+	if dir := filepath.Dir(rf.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	rf.size = info.Size()
+	rf.opened = time.Now()
+	return nil
+}
+
+// Write writes to the log file, rotating it first if needed
+func (rf *rotatingFile) Write(data []byte) (int, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	tooLarge := rf.rotation.MaxSize > 0 && rf.size+int64(len(data)) > rf.rotation.MaxSize
+	tooOld := rf.rotation.MaxAge > 0 && time.Since(rf.opened) > rf.rotation.MaxAge
+	if rf.size > 0 && (tooLarge || tooOld) {
+		if err := rf.rotate(); err != nil {
+			if rf.file == nil {
+				return 0, err
+			}
+			// Keep writing to the current file
+			fmt.Printf("Failed to rotate log file %s: %v\n", rf.path, err)
+		}
+	}
+	n, err := rf.file.Write(data)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file, opens a new one and compresses the old
+// one in the background. It must be called with the mutex held.
+func (rf *rotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+	rotated := rf.path + "." + time.Now().Format(rotatedTimeFormat)
+	renameErr := os.Rename(rf.path, rotated)
+	if err := rf.open(); err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	// Compressions run one after the other, so pruning sees every file
+	rf.compressing.Wait()
+	rf.compressing.Add(1)
+	go func() {
+		defer rf.compressing.Done()
+		if err := compressFile(rotated); err != nil {
+			fmt.Printf("Failed to compress log file %s: %v\n", rotated, err)
+		}
+		rf.prune()
+	}()
+	return nil
+}
+
+// prune removes the oldest rotated files beyond MaxFiles
+func (rf *rotatingFile) prune() {
+	if rf.rotation.MaxFiles <= 0 {
+		return
+	}
+	rotated, err := rotatedFiles(rf.path)
+	if err != nil {
+		return
+	}
+	for len(rotated) > rf.rotation.MaxFiles {
+		os.Remove(rotated[0])
+		rotated = rotated[1:]
+	}
+}
+
+// Close closes the log file after the last compression is done
+func (rf *rotatingFile) Close() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	rf.compressing.Wait()
+	return err
+}
+
+// compressFile replaces a file by a gzip compressed copy with the .gz
+// extension
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := path + ".gz.tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
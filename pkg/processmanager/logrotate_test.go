@@ -0,0 +1,114 @@
+package processmanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsRotated(t *testing.T) {
+	tests := []struct {
+		file string
+		want bool
+	}{
+		{"web.log.20240301-120000.000", true},
+		{"web.log.20240301-120000.000.gz", true},
+		{"web.log", false},
+		{"web.log.gz", false},
+		{"web.log.old", false},
+		{"other.log.20240301-120000.000", false},
+	}
+	for _, test := range tests {
+		if got := isRotated("web.log", test.file); got != test.want {
+			t.Errorf("%s: expected %v, got %v", test.file, test.want, got)
+		}
+	}
+}
+
+func TestLogRotation(t *testing.T) {
+	pm := NewProcessManager("")
+	pm.SetLogRotation(LogRotation{Dir: t.TempDir(), MaxSize: 10, MaxFiles: 2})
+
+	rf, err := openRotatingFile(pm.logPath("web"), pm.logRotation)
+	if err != nil {
+		t.Fatalf("Failed to open the log file: %v", err)
+	}
+	// Every line makes the file too large for the one before
+	for i := 1; i <= 5; i++ {
+		if _, err := fmt.Fprintf(rf, "line %d\n", i); err != nil {
+			t.Fatalf("Failed to write line %d: %v", i, err)
+		}
+		// The names of rotated files have milliseconds
+		time.Sleep(2 * time.Millisecond)
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatalf("Failed to close the log file: %v", err)
+	}
+
+	files, err := pm.ListLogFiles("web")
+	if err != nil {
+		t.Fatalf("Failed to list the log files: %v", err)
+	}
+	if len(files) != 3 || files[0].Name != "web.log" || files[0].Compressed || !files[1].Compressed || !files[2].Compressed {
+		t.Fatalf("Expected the current and 2 compressed files, got %+v", files)
+	}
+	for i, want := range []string{"line 5", "line 4", "line 3"} {
+		got, err := pm.ReadLogFile("web", files[i].Name, 0)
+		if err != nil || got != want {
+			t.Errorf("%s: expected %q, got %q, %v", files[i].Name, want, got, err)
+		}
+	}
+
+	if _, err := pm.ReadLogFile("web", "../web.log", 0); err == nil {
+		t.Error("Expected an error reading a file that is not a log file of the process")
+	}
+	if _, err := pm.ListLogFiles("db"); err == nil {
+		t.Error("Expected an error listing the log files of a process without any")
+	}
+}
+
+func TestLogRotationByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "web.log")
+	if err := os.WriteFile(path, []byte("yesterday\n"), 0644); err != nil {
+		t.Fatalf("Failed to write the log file: %v", err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(path, old, old)
+
+	// A file that is too old is rotated when it is opened
+	rf, err := openRotatingFile(path, LogRotation{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to open the log file: %v", err)
+	}
+	rf.Write([]byte("today\n"))
+	rf.Close()
+
+	data, _ := os.ReadFile(path)
+	rotated, _ := rotatedFiles(path)
+	if string(data) != "today\n" || len(rotated) != 1 || !strings.HasSuffix(rotated[0], ".gz") {
+		t.Errorf("Expected a new log file and a compressed old one, got %q and %q", data, rotated)
+	}
+}
+
+func TestReadLogFileLines(t *testing.T) {
+	pm := NewProcessManager("")
+	pm.SetLogRotation(LogRotation{Dir: t.TempDir()})
+	os.WriteFile(pm.logPath("web"), []byte("one\ntwo\nthree\n"), 0644)
+
+	tests := []struct {
+		lines int
+		want  string
+	}{
+		{0, "one\ntwo\nthree"},
+		{2, "two\nthree"},
+		{5, "one\ntwo\nthree"},
+	}
+	for _, test := range tests {
+		if got, err := pm.ReadLogFile("web", "web.log", test.lines); err != nil || got != test.want {
+			t.Errorf("%d lines: expected %q, got %q, %v", test.lines, test.want, got, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package processmanager
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// followBufferSize is the number of lines a follower can fall behind
+	// before lines are dropped for it
+	followBufferSize = 256
+	// maxPartialLine is the length at which a line is sent without its end
+	maxPartialLine = 64 * 1024
+)
+
+// LogLine is a line of output of a managed process
+type LogLine struct {
+	Process string    `json:"process"`
+	Time    time.Time `json:"time"`
+	Line    string    `json:"line"`
+}
+
+// follower receives the lines of the processes it follows
+type follower struct {
+	names map[string]bool // empty for all processes
+	lines chan LogLine
+}
+
+// logStream sends the lines written by processes to their followers
+type logStream struct {
+	followers map[*follower]struct{}
+	mutex     sync.Mutex
+}
+
+// publish sends a line to the followers of its process. Lines are dropped
+// for followers that do not keep up, so a slow client never blocks the
+// output of a process.
+func (ls *logStream) publish(line LogLine) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+
+	for f := range ls.followers {
+		if len(f.names) > 0 && !f.names[line.Process] {
+			continue
+		}
+		select {
+		case f.lines <- line:
+		default:
+		}
+	}
+}
+
+// FollowLogs returns the lines that processes write from now on, of the
+// processes named or of all processes when no names are given. The channel
+// is closed when ctx is done.
+func (pm *ProcessManager) FollowLogs(ctx context.Context, names ...string) <-chan LogLine {
+	f := &follower{
+		names: make(map[string]bool),
+		lines: make(chan LogLine, followBufferSize),
+	}
+	for _, name := range names {
+		f.names[name] = true
+	}
+
+	pm.logs.mutex.Lock()
+	if pm.logs.followers == nil {
+		pm.logs.followers = make(map[*follower]struct{})
+	}
+	pm.logs.followers[f] = struct{}{}
+	pm.logs.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		pm.logs.mutex.Lock()
+		delete(pm.logs.followers, f)
+		close(f.lines)
+		pm.logs.mutex.Unlock()
+	}()
+
+	return f.lines
+}
+
+// lineWriter splits the output of a process into lines for its followers
+type lineWriter struct {
+	process string
+	stream  *logStream
+	partial []byte
+	mutex   sync.Mutex
+}
+
+// Write publishes every complete line and keeps the rest for the next write
+func (lw *lineWriter) Write(data []byte) (int, error) {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+
+	lw.partial = append(lw.partial, data...)
+	for {
+		i := bytes.IndexByte(lw.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(bytes.TrimSuffix(lw.partial[:i], []byte{'\r'}))
+		lw.partial = lw.partial[i+1:]
+		lw.stream.publish(LogLine{Process: lw.process, Time: time.Now(), Line: line})
+	}
+	// Keep a line without an end from growing without bound
+	if len(lw.partial) > maxPartialLine {
+		lw.stream.publish(LogLine{Process: lw.process, Time: time.Now(), Line: string(lw.partial)})
+		lw.partial = nil
+	}
+	return len(data), nil
+}
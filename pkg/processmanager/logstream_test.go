@@ -0,0 +1,105 @@
+package processmanager
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineWriter(t *testing.T) {
+	stream := &logStream{}
+	f := &follower{names: map[string]bool{}, lines: make(chan LogLine, 10)}
+	stream.followers = map[*follower]struct{}{f: {}}
+	lw := &lineWriter{process: "web", stream: stream}
+
+	lw.Write([]byte("one\r\ntw"))
+	lw.Write([]byte("o\nthree"))
+	lw.Write([]byte(strings.Repeat("x", maxPartialLine)))
+
+	var got []string
+	for len(f.lines) > 0 {
+		line := <-f.lines
+		if line.Process != "web" {
+			t.Errorf("Expected lines of web, got %q", line.Process)
+		}
+		got = append(got, line.Line)
+	}
+	if len(got) != 3 || got[0] != "one" || got[1] != "two" || got[2] != "three"+strings.Repeat("x", maxPartialLine) {
+		t.Errorf("Expected one, two and the long line, got %d lines starting with %.20q", len(got), got)
+	}
+	if len(lw.partial) != 0 {
+		t.Errorf("Expected the long line to be sent, %d bytes are left", len(lw.partial))
+	}
+}
+
+func TestLogStreamDropsLinesForSlowFollowers(t *testing.T) {
+	stream := &logStream{}
+	slow := &follower{names: map[string]bool{}, lines: make(chan LogLine, 1)}
+	other := &follower{names: map[string]bool{"db": true}, lines: make(chan LogLine, 1)}
+	stream.followers = map[*follower]struct{}{slow: {}, other: {}}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			stream.publish(LogLine{Process: "web", Line: "line"})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publishing blocked on a slow follower")
+	}
+	if len(slow.lines) != 1 || len(other.lines) != 0 {
+		t.Errorf("Expected 1 line for the slow follower and none for the other process, got %d and %d", len(slow.lines), len(other.lines))
+	}
+}
+
+func TestFollowLogs(t *testing.T) {
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	web := pm.FollowLogs(ctx, "web")
+	all := pm.FollowLogs(ctx)
+
+	if err := pm.StartProcess("web", "sleep 0.2; echo one; echo two", false, 0, "", ""); err != nil {
+		t.Fatalf("Failed to start web: %v", err)
+	}
+	if err := pm.StartProcess("db", "sleep 0.2; echo ready", false, 0, "", ""); err != nil {
+		t.Fatalf("Failed to start db: %v", err)
+	}
+
+	read := func(lines <-chan LogLine, n int) []string {
+		var got []string
+		timeout := time.After(10 * time.Second)
+		for len(got) < n {
+			select {
+			case line := <-lines:
+				got = append(got, line.Process+":"+line.Line)
+			case <-timeout:
+				t.Fatalf("Expected %d lines, got %q", n, got)
+			}
+		}
+		return got
+	}
+	if got := strings.Join(read(web, 2), ","); got != "web:one,web:two" {
+		t.Errorf("Expected the lines of web, got %s", got)
+	}
+	got := read(all, 3)
+	if !strings.Contains(strings.Join(got, ","), "db:ready") || !strings.Contains(strings.Join(got, ","), "web:two") {
+		t.Errorf("Expected the lines of all processes, got %q", got)
+	}
+
+	// Lines are sent while following only
+	cancel()
+	select {
+	case _, ok := <-web:
+		if ok {
+			t.Error("Expected no more lines after cancelling")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the channel to be closed after cancelling")
+	}
+}
@@ -0,0 +1,139 @@
+package processmanager
+
+import (
+	"sort"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// ProcessMetrics is the last sample of the resources a managed process uses,
+// together with the processes it started
+type ProcessMetrics struct {
+	Name       string        `json:"name"`
+	PID        int32         `json:"pid"`
+	Status     ProcessStatus `json:"status"`
+	CPUPercent float64       `json:"cpu_percent"`
+	MemoryMB   float64       `json:"memory_mb"`
+	// ReadBytes and WriteBytes are the bytes read from and written to
+	// storage by the processes that run now
+	ReadBytes  uint64 `json:"read_bytes"`
+	WriteBytes uint64 `json:"write_bytes"`
+	// ReadRate and WriteRate are the bytes per second read and written
+	// since the previous sample
+	ReadRate  float64 `json:"read_rate"`
+	WriteRate float64 `json:"write_rate"`
+	Children  int     `json:"children"`
+	// Time is when the sample was taken, zero if the process was not
+	// sampled since it started
+	Time time.Time `json:"time"`
+}
+
+// resources is a sample of the resources of a process tree
+type resources struct {
+	cpuPercent            float64
+	rss                   uint64
+	readBytes, writeBytes uint64
+	children              int
+}
+
+// sampler measures the resources of the process tree of a managed process.
+// It keeps the processes it saw, as their CPU use is measured between
+// samples.
+type sampler struct {
+	processes map[int32]*process.Process
+	last      resources
+	lastTime  time.Time
+}
+
+// sample measures the process with the given PID and its descendants and
+// returns the sample with the IO rates since the previous one
+func (s *sampler) sample(pid int32) (sample resources, readRate, writeRate float64) {
+	seen := make(map[int32]*process.Process)
+	var visit func(pid int32)
+	visit = func(pid int32) {
+		if _, ok := seen[pid]; ok {
+			return
+		}
+		proc, ok := s.processes[pid]
+		if !ok {
+			var err error
+			if proc, err = process.NewProcess(pid); err != nil {
+				return
+			}
+		}
+		seen[pid] = proc
+
+		// The first sample of a process only sets the start of its CPU use
+		if cpuPercent, err := proc.Percent(0); err == nil {
+			sample.cpuPercent += cpuPercent
+		}
+		if memInfo, err := proc.MemoryInfo(); err == nil && memInfo != nil {
+			sample.rss += memInfo.RSS
+		}
+		if io, err := proc.IOCounters(); err == nil && io != nil {
+			sample.readBytes += io.ReadBytes
+			sample.writeBytes += io.WriteBytes
+		}
+		if children, err := proc.Children(); err == nil {
+			for _, child := range children {
+				visit(child.Pid)
+			}
+		}
+	}
+	visit(pid)
+	if len(seen) > 0 {
+		sample.children = len(seen) - 1
+	}
+
+	// Processes that exited take their IO with them, which is no negative
+	// rate
+	now := time.Now()
+	if !s.lastTime.IsZero() {
+		if elapsed := now.Sub(s.lastTime).Seconds(); elapsed > 0 {
+			if sample.readBytes > s.last.readBytes {
+				readRate = float64(sample.readBytes-s.last.readBytes) / elapsed
+			}
+			if sample.writeBytes > s.last.writeBytes {
+				writeRate = float64(sample.writeBytes-s.last.writeBytes) / elapsed
+			}
+		}
+	}
+	s.processes = seen
+	s.last = sample
+	s.lastTime = now
+	return sample, readRate, writeRate
+}
+
+// Metrics returns the last samples of the resources of all processes,
+// ordered by name. Processes that do not run have no resources.
+func (pm *ProcessManager) Metrics() []ProcessMetrics {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	metrics := make([]ProcessMetrics, 0, len(pm.processes))
+	for _, procInfo := range pm.processes {
+		procInfo.mutex.Lock()
+		m := ProcessMetrics{
+			Name:   procInfo.Name,
+			PID:    procInfo.PID,
+			Status: procInfo.Status,
+		}
+		if procInfo.Status == ProcessStatusRunning {
+			m.CPUPercent = procInfo.CPUPercent
+			m.MemoryMB = procInfo.MemoryMB
+			m.ReadBytes = procInfo.ReadBytes
+			m.WriteBytes = procInfo.WriteBytes
+			m.ReadRate = procInfo.ReadRate
+			m.WriteRate = procInfo.WriteRate
+			m.Children = procInfo.Children
+			m.Time = procInfo.sampled
+		}
+		procInfo.mutex.Unlock()
+		metrics = append(metrics, m)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].Name < metrics[j].Name
+	})
+	return metrics
+}
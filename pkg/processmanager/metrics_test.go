@@ -0,0 +1,81 @@
+package processmanager
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSampler(t *testing.T) {
+	cmd := shellCommand(context.Background(), "sleep 30 & sleep 30 & wait")
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	defer func() {
+		killProcess(cmd)
+		cmd.Wait()
+	}()
+
+	var s sampler
+	pid := int32(cmd.Process.Pid)
+	deadline := time.Now().Add(5 * time.Second)
+	var sample resources
+	for {
+		sample, _, _ = s.sample(pid)
+		if sample.children == 2 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Expected the shell with 2 children, got %d", sample.children)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if sample.rss == 0 {
+		t.Error("Expected the memory of the processes")
+	}
+	if len(s.processes) != 3 {
+		t.Errorf("Expected the sampler to keep 3 processes, got %d", len(s.processes))
+	}
+
+	// Less IO than before, as when a process exited, is no negative rate
+	s.last.readBytes = sample.readBytes + 1<<30
+	s.last.writeBytes = sample.writeBytes + 1<<30
+	if _, readRate, writeRate := s.sample(pid); readRate != 0 || writeRate != 0 {
+		t.Errorf("Expected no IO rates, got %v and %v", readRate, writeRate)
+	}
+
+	// A process that is gone has no resources
+	var gone sampler
+	if sample, _, _ := gone.sample(1 << 30); sample != (resources{}) {
+		t.Errorf("Expected no resources for a missing process, got %+v", sample)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	pm.StartProcess("web", "sleep 30", false, 0, "", "")
+	pm.StartProcess("db", "sleep 30", false, 0, "", "")
+	pm.StopProcess("db")
+
+	// Pretend web was sampled
+	pm.mutex.RLock()
+	web := pm.processes["web"]
+	pm.mutex.RUnlock()
+	web.mutex.Lock()
+	web.MemoryMB = 12.5
+	web.Children = 1
+	web.sampled = time.Now()
+	web.mutex.Unlock()
+
+	metrics := pm.Metrics()
+	if len(metrics) != 2 || metrics[0].Name != "db" || metrics[1].Name != "web" {
+		t.Fatalf("Expected db and web, got %+v", metrics)
+	}
+	if metrics[0].Status != ProcessStatusStopped || !metrics[0].Time.IsZero() || metrics[0].MemoryMB != 0 {
+		t.Errorf("Expected no resources for the stopped db, got %+v", metrics[0])
+	}
+	if metrics[1].PID == 0 || metrics[1].MemoryMB != 12.5 || metrics[1].Children != 1 || metrics[1].Time.IsZero() {
+		t.Errorf("Expected the sample of web, got %+v", metrics[1])
+	}
+}
@@ -0,0 +1,122 @@
+//go:build !windows
+
+package processmanager
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// DefaultSocket is where the process manager listens for clients unless
+// told otherwise
+const DefaultSocket = "/tmp/processmanager.sock"
+
+// shellCommand returns a command that runs a command line in the shell
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// checkPlatform rejects what a definition asks that the platform cannot do;
+// Unix can do it all
+func checkPlatform(def ProcessDefinition) error {
+	return nil
+}
+
+// setProcessGroup makes a command the leader of a new process group, so the
+// processes it starts are stopped with it
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return killProcess(cmd)
+	}
+}
+
+// killProcess kills the process group of a command started with
+// setProcessGroup
+func killProcess(cmd *exec.Cmd) error {
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
+
+// containProcess does nothing on Unix, where the process group of a command
+// holds the processes it starts
+func containProcess(cmd *exec.Cmd) error {
+	return nil
+}
+
+// releaseProcess does nothing on Unix, see containProcess
+func releaseProcess(cmd *exec.Cmd) {}
+
+// terminateProcess asks the process group of a command started with
+// setProcessGroup to stop
+func terminateProcess(cmd *exec.Cmd) error {
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM); err != nil {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	return nil
+}
+
+// setUser makes a command run as a user, given by name or uid and optionally
+// followed by :group, with the groups of the user, and returns the home and
+// name of the user for its environment. Only root can run commands as other
+// users.
+func setUser(cmd *exec.Cmd, spec string) ([]string, error) {
+	name, group, hasGroup := strings.Cut(spec, ":")
+	u, err := user.Lookup(name)
+	if err != nil {
+		var idErr error
+		if u, idErr = user.LookupId(name); idErr != nil {
+			return nil, err
+		}
+	}
+
+	gid := u.Gid
+	if hasGroup {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			var idErr error
+			if g, idErr = user.LookupGroupId(group); idErr != nil {
+				return nil, err
+			}
+		}
+		gid = g.Gid
+	}
+
+	credential := &syscall.Credential{}
+	if credential.Uid, err = parseID(u.Uid); err != nil {
+		return nil, err
+	}
+	if credential.Gid, err = parseID(gid); err != nil {
+		return nil, err
+	}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list groups: %v", err)
+	}
+	for _, groupID := range groupIDs {
+		id, err := parseID(groupID)
+		if err != nil {
+			return nil, err
+		}
+		credential.Groups = append(credential.Groups, id)
+	}
+	cmd.SysProcAttr.Credential = credential
+
+	return []string{"HOME=" + u.HomeDir, "USER=" + u.Username, "LOGNAME=" + u.Username}, nil
+}
+
+// parseID parses a numeric user or group id
+func parseID(id string) (uint32, error) {
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id '%s'", id)
+	}
+	return uint32(n), nil
+}
@@ -0,0 +1,121 @@
+//go:build windows
+
+package processmanager
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// DefaultSocket is where the process manager listens for clients unless
+// told otherwise, a local TCP port as Windows has no Unix domain sockets
+// everywhere
+const DefaultSocket = "tcp://127.0.0.1:9021"
+
+// jobs are the job objects holding the processes that commands started, see
+// containProcess
+var (
+	jobs      = make(map[*exec.Cmd]windows.Handle)
+	jobsMutex sync.Mutex
+)
+
+// shellCommand returns a command that runs a command line with cmd.exe,
+// which gets the command line as written
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "cmd")
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: "cmd /C " + command}
+	return cmd
+}
+
+// checkPlatform rejects what a definition asks that Windows cannot do
+func checkPlatform(def ProcessDefinition) error {
+	switch {
+	case def.Umask != "":
+		return errors.New("umasks are not supported on Windows")
+	case def.Listen != "":
+		return errors.New("passing sockets to processes is not supported on Windows")
+	case def.User != "":
+		return errors.New("running processes as another user is not supported on Windows")
+	}
+	return nil
+}
+
+// setProcessGroup starts a command in a new process group, so it can be
+// asked to stop with CTRL_BREAK without stopping the process manager
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= windows.CREATE_NEW_PROCESS_GROUP
+	cmd.Cancel = func() error {
+		return killProcess(cmd)
+	}
+}
+
+// containProcess puts a started command in a job object, so the processes
+// it starts from then on are stopped with it. Processes it started before
+// are not in the job.
+func containProcess(cmd *exec.Cmd) error {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return err
+	}
+	process, err := windows.OpenProcess(windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE, false, uint32(cmd.Process.Pid))
+	if err != nil {
+		windows.CloseHandle(job)
+		return err
+	}
+	defer windows.CloseHandle(process)
+	if err := windows.AssignProcessToJobObject(job, process); err != nil {
+		windows.CloseHandle(job)
+		return err
+	}
+
+	jobsMutex.Lock()
+	jobs[cmd] = job
+	jobsMutex.Unlock()
+	return nil
+}
+
+// releaseProcess closes the job object of a command that exited. The
+// processes it started keep running, like they do on Unix.
+func releaseProcess(cmd *exec.Cmd) {
+	jobsMutex.Lock()
+	job, ok := jobs[cmd]
+	delete(jobs, cmd)
+	jobsMutex.Unlock()
+	if ok {
+		windows.CloseHandle(job)
+	}
+}
+
+// killProcess kills the processes in the job object of a command, or else
+// only its own process
+func killProcess(cmd *exec.Cmd) error {
+	// The job stays open while it is terminated, see releaseProcess
+	jobsMutex.Lock()
+	job, ok := jobs[cmd]
+	if ok && windows.TerminateJobObject(job, 1) == nil {
+		jobsMutex.Unlock()
+		return nil
+	}
+	jobsMutex.Unlock()
+	return cmd.Process.Kill()
+}
+
+// terminateProcess asks the process group of a command started with
+// setProcessGroup to stop with CTRL_BREAK, which console programs get like
+// SIGTERM on Unix
+func terminateProcess(cmd *exec.Cmd) error {
+	return windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(cmd.Process.Pid))
+}
+
+// setUser is not supported on Windows
+func setUser(cmd *exec.Cmd, spec string) ([]string, error) {
+	return nil, errors.New("running processes as another user is not supported on Windows")
+}
@@ -0,0 +1,93 @@
+//go:build windows
+
+package processmanager
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPlatform(t *testing.T) {
+	tests := []struct {
+		def ProcessDefinition
+		ok  bool
+	}{
+		{ProcessDefinition{Name: "web", Command: "web.exe", Env: map[string]string{"PORT": "8080"}, Dir: `C:\srv`}, true},
+		{ProcessDefinition{Name: "web", Command: "web.exe", Umask: "022"}, false},
+		{ProcessDefinition{Name: "web", Command: "web.exe", Listen: "tcp://127.0.0.1:8080"}, false},
+		{ProcessDefinition{Name: "web", Command: "web.exe", User: "www"}, false},
+	}
+	for _, test := range tests {
+		if err := checkPlatform(test.def); (err == nil) != test.ok {
+			t.Errorf("%+v: expected ok %v, got %v", test.def, test.ok, err)
+		}
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	output, err := shellCommand(context.Background(), `echo "hello world"`).Output()
+	if err != nil {
+		t.Fatalf("Failed to run cmd.exe: %v", err)
+	}
+	// cmd.exe gets the command line as written, quotes included
+	if got := strings.TrimSpace(string(output)); got != `"hello world"` {
+		t.Errorf("Expected the quoted words, got %q", got)
+	}
+}
+
+func TestKillProcessTree(t *testing.T) {
+	// cmd.exe starts ping, which the job object holds too
+	cmd := shellCommand(context.Background(), "ping -n 30 127.0.0.1 >NUL")
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	if err := containProcess(cmd); err != nil {
+		t.Fatalf("Failed to put the process in a job object: %v", err)
+	}
+	var s sampler
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if sample, _, _ := s.sample(int32(cmd.Process.Pid)); sample.children == 1 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("Expected cmd.exe to start ping")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	tree := s.processes
+
+	if err := killProcess(cmd); err != nil {
+		t.Fatalf("Failed to kill: %v", err)
+	}
+	cmd.Wait()
+	releaseProcess(cmd)
+	for pid, proc := range tree {
+		if running, _ := proc.IsRunning(); running {
+			t.Errorf("Expected process %d to be killed with the job", pid)
+		}
+	}
+	jobsMutex.Lock()
+	defer jobsMutex.Unlock()
+	if len(jobs) != 0 {
+		t.Errorf("Expected the job object to be released, %d are left", len(jobs))
+	}
+}
+
+func TestStopProcessWithCtrlBreak(t *testing.T) {
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	if err := pm.StartProcess("ping", "ping -n 30 127.0.0.1", false, 0, "", ""); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	start := time.Now()
+	if err := pm.StopProcess("ping"); err != nil {
+		t.Fatalf("Failed to stop: %v", err)
+	}
+	waitStatus(t, pm, "ping", ProcessStatusStopped)
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Expected ping to stop on CTRL_BREAK, took %v", time.Since(start))
+	}
+}
@@ -4,15 +4,15 @@ package processmanager
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
-
-	"github.com/shirou/gopsutil/v3/process"
 )
 
 // ProcessStatus represents the status of a process
@@ -27,8 +27,75 @@ const (
 	ProcessStatusFailed ProcessStatus = "failed"
 	// ProcessStatusCompleted indicates the process completed successfully
 	ProcessStatusCompleted ProcessStatus = "completed"
+	// ProcessStatusCrashLoop indicates the process kept exiting and is no
+	// longer restarted
+	ProcessStatusCrashLoop ProcessStatus = "crashloop"
+	// ProcessStatusWaiting indicates the process waits for the processes it
+	// depends on to be ready before it starts
+	ProcessStatusWaiting ProcessStatus = "waiting"
+	// ProcessStatusScheduled indicates the process waits for its next
+	// scheduled run
+	ProcessStatusScheduled ProcessStatus = "scheduled"
 )
 
+// waitDelay is how long a process that exited or was killed may keep its
+// output open, through processes it started, before it is closed
+const waitDelay = 5 * time.Second
+
+// ProcessDefinition describes a process and how the process manager runs it
+type ProcessDefinition struct {
+	Name     string `json:"name"`
+	Command  string `json:"command"`
+	Log      bool   `json:"log"`
+	Deadline int    `json:"deadline,omitempty"`
+	JobID    string `json:"job_id,omitempty"`
+	// Cron is the schedule of a process that runs at set times instead of
+	// once, in Timezone or else the local timezone, see ParseCron. Every run
+	// starts up to Jitter seconds late, and Overlap says what happens when
+	// a run is due while the previous run still runs. Deadline applies to
+	// every run; the restart policy does not apply.
+	Cron     string        `json:"cron,omitempty"`
+	Timezone string        `json:"timezone,omitempty"`
+	Jitter   int           `json:"jitter,omitempty"`
+	Overlap  OverlapPolicy `json:"overlap,omitempty"`
+	// Restart and MaxRestarts are the restart policy of the process, see
+	// StartProcessWithRestart
+	Restart     RestartPolicy `json:"restart,omitempty"`
+	MaxRestarts int           `json:"max_restarts,omitempty"`
+	// Requires are the processes that must be running and ready before the
+	// process starts; they stop only after it. After are the processes that
+	// start before it if they are managed.
+	Requires []string `json:"requires,omitempty"`
+	After    []string `json:"after,omitempty"`
+	// HealthCheck is a command that exits with 0, a tcp:// address that
+	// accepts connections or an http:// URL that answers when the process
+	// is healthy. It runs every second until it passes, which makes the
+	// process ready, and then every HealthInterval seconds; after
+	// HealthRetries failures in a row the process is restarted.
+	HealthCheck    string `json:"health_check,omitempty"`
+	HealthInterval int    `json:"health_interval,omitempty"`
+	HealthRetries  int    `json:"health_retries,omitempty"`
+	// Env are variables added to the environment of the daemon, Dir is the
+	// working directory, Umask is the octal file mode creation mask, like
+	// 022, and User is the user to run as, by name or uid and optionally
+	// followed by :group. Left empty, the process inherits them from the
+	// daemon.
+	Env   map[string]string `json:"env,omitempty"`
+	Dir   string            `json:"dir,omitempty"`
+	Umask string            `json:"umask,omitempty"`
+	User  string            `json:"user,omitempty"`
+	// Stdin keeps the standard input of the process open, to send it input
+	// with SendInput. Otherwise the process reads nothing from it.
+	Stdin bool `json:"stdin,omitempty"`
+	// Listen is a tcp://host:port address or unix:// socket path that the
+	// process manager listens on and passes to the process as file
+	// descriptor 3, with LISTEN_FDS and LISTEN_PID set like systemd socket
+	// activation does. The socket stays open across restarts, so
+	// connections wait instead of being refused, and is shared by both
+	// instances during a RollingRestart.
+	Listen string `json:"listen,omitempty"`
+}
+
 // ProcessInfo represents information about a managed process
 type ProcessInfo struct {
 	Name       string        `json:"name"`
@@ -37,19 +104,71 @@ type ProcessInfo struct {
 	Status     ProcessStatus `json:"status"`
 	CPUPercent float64       `json:"cpu_percent"`
 	MemoryMB   float64       `json:"memory_mb"`
+	// The resources include the processes the process started, see
+	// ProcessMetrics
+	ReadBytes  uint64        `json:"read_bytes,omitempty"`
+	WriteBytes uint64        `json:"write_bytes,omitempty"`
+	ReadRate   float64       `json:"read_rate,omitempty"`
+	WriteRate  float64       `json:"write_rate,omitempty"`
+	Children   int           `json:"children,omitempty"`
 	StartTime  time.Time     `json:"start_time"`
 	LogEnabled bool          `json:"log_enabled"`
 	Cron       string        `json:"cron,omitempty"`
 	JobID      string        `json:"job_id,omitempty"`
 	Deadline   int           `json:"deadline,omitempty"`
 	Error      string        `json:"error,omitempty"`
+
+	Timezone string        `json:"timezone,omitempty"`
+	Jitter   int           `json:"jitter,omitempty"`
+	Overlap  OverlapPolicy `json:"overlap,omitempty"`
+	NextRun  *time.Time    `json:"next_run,omitempty"`
+	LastRun  *CronRun      `json:"last_run,omitempty"`
+
+	RestartPolicy RestartPolicy `json:"restart_policy,omitempty"`
+	MaxRestarts   int           `json:"max_restarts,omitempty"`
+	Restarts      int           `json:"restarts"`
+	NextRestart   *time.Time    `json:"next_restart,omitempty"`
+
+	Requires       []string `json:"requires,omitempty"`
+	After          []string `json:"after,omitempty"`
+	HealthCheck    string   `json:"health_check,omitempty"`
+	HealthInterval int      `json:"health_interval,omitempty"`
+	HealthRetries  int      `json:"health_retries,omitempty"`
+	// Ready is true while the process runs and passed its health check
+	Ready          bool         `json:"ready"`
+	Health         HealthStatus `json:"health,omitempty"`
+	HealthFailures int          `json:"health_failures,omitempty"`
+	HealthError    string       `json:"health_error,omitempty"`
+
+	Env   map[string]string `json:"env,omitempty"`
+	Dir   string            `json:"dir,omitempty"`
+	Umask string            `json:"umask,omitempty"`
+	User  string            `json:"user,omitempty"`
+	Stdin bool              `json:"stdin,omitempty"`
+	Listen string           `json:"listen,omitempty"`
 	
 	cmd        *exec.Cmd
 	ctx        context.Context
 	cancel     context.CancelFunc
-	logFile    *os.File
+	logFile    *rotatingFile
 	logBuffer  *RingBuffer   // Ring buffer to store logs
 	mutex      sync.Mutex
+	sampled    time.Time     // when the resources were last sampled
+	stdin      *os.File      // write end of the standard input, if kept open
+	inputMutex sync.Mutex    // keeps input sent at the same time apart
+	listener   *os.File      // socket passed to the process, see Listen
+	rolling    bool          // a rolling restart is in progress
+
+	crashes      int         // restarts since the process last ran for restartResetAfter
+	restartTimer *time.Timer // pending restart
+	unhealthy    bool        // killed because of its health check
+	killReason   string      // why the process was killed, as its error
+
+	schedule  *CronSchedule // nil unless the process has a cron schedule
+	cronTimer *time.Timer   // next scheduled run
+	queued    *time.Time    // scheduled time of the run after the current one
+	runs      []*CronRun    // last runs, from old to new
+	current   *CronRun      // run that is running
 }
 
 // ProcessManager manages multiple processes
@@ -57,159 +176,470 @@ type ProcessManager struct {
 	processes map[string]*ProcessInfo
 	mutex     sync.RWMutex
 	secret    string
+	logs      logStream
+	events    eventStream
+	// logRotation applies to the log files of processes started with
+	// logging enabled
+	logRotation LogRotation
+	// stateFile is where the definitions of the processes are saved, see
+	// LoadState
+	stateFile string
+	// listeners are the sockets the processes listen on, by their Listen
+	// address, kept open while a process uses them
+	listeners map[string]*os.File
 }
 
 // NewProcessManager creates a new process manager
 func NewProcessManager(secret string) *ProcessManager {
 	return &ProcessManager{
-		processes: make(map[string]*ProcessInfo),
-		secret:    secret,
+		processes:   make(map[string]*ProcessInfo),
+		secret:      secret,
+		logRotation: DefaultLogRotation,
+		listeners:   make(map[string]*os.File),
 	}
 }
 
 // StartProcess starts a new process with the given name and command
 func (pm *ProcessManager) StartProcess(name, command string, logEnabled bool, deadline int, cron, jobID string) error {
+	return pm.StartProcessWithRestart(name, command, logEnabled, deadline, cron, jobID, RestartNever, 0)
+}
+
+// StartProcessWithRestart starts a new process that is restarted after it
+// exits according to the restart policy, waiting longer after every restart
+// in a row. After maxRestarts restarts in a row, or never if it is 0, the
+// process is left in the crashloop state.
+func (pm *ProcessManager) StartProcessWithRestart(name, command string, logEnabled bool, deadline int, cron, jobID string, restart RestartPolicy, maxRestarts int) error {
+	return pm.StartProcessDefinition(ProcessDefinition{
+		Name:        name,
+		Command:     command,
+		Log:         logEnabled,
+		Deadline:    deadline,
+		Cron:        cron,
+		JobID:       jobID,
+		Restart:     restart,
+		MaxRestarts: maxRestarts,
+	})
+}
+
+// StartProcessDefinition starts a new process as defined. A process that
+// depends on processes that are not ready yet waits for them in the waiting
+// state and starts as soon as they are.
+func (pm *ProcessManager) StartProcessDefinition(def ProcessDefinition) error {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
 
+	if err := pm.startProcess(def); err != nil {
+		return err
+	}
+	pm.saveState()
+	return nil
+}
+
+// startProcess starts a new process as defined. It must be called with the
+// lock of the process manager held.
+func (pm *ProcessManager) startProcess(def ProcessDefinition) error {
+	name := def.Name
+
 	// Check if process already exists
 	if _, exists := pm.processes[name]; exists {
 		return fmt.Errorf("process with name '%s' already exists", name)
 	}
+	schedule, err := pm.checkDefinition(def)
+	if err != nil {
+		return err
+	}
+	var listener *os.File
+	if def.Listen != "" {
+		if listener, err = pm.listen(def.Listen); err != nil {
+			return err
+		}
+	}
 
 	// Create process info
-	ctx, cancel := context.WithCancel(context.Background())
 	procInfo := &ProcessInfo{
-		Name:       name,
-		Command:    command,
-		Status:     ProcessStatusStopped,
-		LogEnabled: logEnabled,
-		Cron:       cron,
-		JobID:      jobID,
-		Deadline:   deadline,
-		StartTime:  time.Now(),
-		ctx:        ctx,
-		cancel:     cancel,
+		Name:          name,
+		Command:       def.Command,
+		Status:        ProcessStatusWaiting,
+		LogEnabled:    def.Log,
+		Cron:          def.Cron,
+		JobID:         def.JobID,
+		Deadline:      def.Deadline,
+		Timezone:      def.Timezone,
+		Jitter:        def.Jitter,
+		Overlap:       def.Overlap,
+		RestartPolicy: def.Restart,
+		MaxRestarts:   def.MaxRestarts,
+		Requires:      def.Requires,
+		After:         def.After,
+		HealthCheck:   def.HealthCheck,
+
+		HealthInterval: def.HealthInterval,
+		HealthRetries:  def.HealthRetries,
+
+		Env:   def.Env,
+		Dir:   def.Dir,
+		Umask: def.Umask,
+		User:  def.User,
+		Stdin: def.Stdin,
+
+		Listen:   def.Listen,
+		listener: listener,
 	}
+	
+	// Create log buffer (20KB capacity), kept across restarts
+	procInfo.logBuffer = NewRingBuffer(20 * 1024)
+
+	if schedule != nil {
+		// A scheduled process only runs at its times
+		procInfo.schedule = schedule
+		procInfo.Status = ProcessStatusScheduled
+		pm.scheduleRun(procInfo)
+	} else if pm.dependenciesReady(procInfo) {
+		if err := pm.spawn(procInfo); err != nil {
+			return err
+		}
+	}
+
+	// Store the process
+	pm.processes[name] = procInfo
+
+	// Processes waiting for this one may start now
+	pm.startWaiting()
+
+	return nil
+}
+
+// definition returns the definition a process was started with. It must be
+// called with the lock of the process held.
+func (procInfo *ProcessInfo) definition() ProcessDefinition {
+	return ProcessDefinition{
+		Name:        procInfo.Name,
+		Command:     procInfo.Command,
+		Log:         procInfo.LogEnabled,
+		Deadline:    procInfo.Deadline,
+		Cron:        procInfo.Cron,
+		Timezone:    procInfo.Timezone,
+		Jitter:      procInfo.Jitter,
+		Overlap:     procInfo.Overlap,
+		JobID:       procInfo.JobID,
+		Restart:     procInfo.RestartPolicy,
+		MaxRestarts: procInfo.MaxRestarts,
+		Requires:    procInfo.Requires,
+		After:       procInfo.After,
+		HealthCheck: procInfo.HealthCheck,
+
+		HealthInterval: procInfo.HealthInterval,
+		HealthRetries:  procInfo.HealthRetries,
+
+		Env:   procInfo.Env,
+		Dir:   procInfo.Dir,
+		Umask: procInfo.Umask,
+		User:  procInfo.User,
+		Stdin: procInfo.Stdin,
+
+		Listen: procInfo.Listen,
+	}
+}
+
+// checkDefinition checks that a process can be started as defined and
+// returns its cron schedule, if any
+func (pm *ProcessManager) checkDefinition(def ProcessDefinition) (*CronSchedule, error) {
+	if err := pm.checkDependencies(def); err != nil {
+		return nil, err
+	}
+	if _, err := parseUmask(def.Umask); err != nil {
+		return nil, err
+	}
+	if err := checkPlatform(def); err != nil {
+		return nil, err
+	}
+	if def.Listen != "" {
+		if _, _, err := parseListen(def.Listen); err != nil {
+			return nil, err
+		}
+	}
+	if def.Cron == "" {
+		return nil, nil
+	}
+	schedule, err := ParseCron(def.Cron, def.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ParseOverlapPolicy(string(def.Overlap)); err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+// instance is a started command of a process
+type instance struct {
+	cmd     *exec.Cmd
+	ctx     context.Context
+	cancel  context.CancelFunc
+	logFile *rotatingFile
+	stdin   *os.File
+}
+
+// spawn starts the command of a process. It must be called with the locks
+// of the process manager and the process held.
+func (pm *ProcessManager) spawn(procInfo *ProcessInfo) error {
+	inst, err := pm.startInstance(procInfo)
+	if err != nil {
+		return err
+	}
+	pm.adopt(procInfo, inst)
+	return nil
+}
+
+// startInstance starts the command of a process without making it the
+// command the process runs, see adopt. Its context is done once the command
+// exited. It must be called with the locks of the process manager and the
+// process held.
+func (pm *ProcessManager) startInstance(procInfo *ProcessInfo) (instance, error) {
+	name := procInfo.Name
+	ctx, cancel := context.WithCancel(context.Background())
 
 	// Set up logging if enabled
-	if logEnabled {
-		logFile, err := os.OpenFile(fmt.Sprintf("%s.log", name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	var logFile *rotatingFile
+	if procInfo.LogEnabled {
+		var err error
+		logFile, err = openRotatingFile(filepath.Join(pm.logRotation.Dir, name+".log"), pm.logRotation)
 		if err != nil {
-			return fmt.Errorf("failed to create log file: %v", err)
+			cancel()
+			return instance{}, fmt.Errorf("failed to create log file: %v", err)
 		}
-		procInfo.logFile = logFile
 	}
-	
-	// Create log buffer (20KB capacity)
-	procInfo.logBuffer = NewRingBuffer(20 * 1024)
 
-	// Start the process
-	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	// Start the process, setting its umask in the shell that runs it
+	command := procInfo.Command
+	if procInfo.Umask != "" {
+		umask, _ := parseUmask(procInfo.Umask)
+		command = fmt.Sprintf("umask %04o\n%s", umask, command)
+	}
+	// The command keeps the PID of the shell when the shell execs it
+	if procInfo.listener != nil {
+		command = "export LISTEN_PID=$$\n" + command
+	}
+	cmd := shellCommand(ctx, command)
+	cmd.Dir = procInfo.Dir
+	if procInfo.listener != nil {
+		cmd.ExtraFiles = []*os.File{procInfo.listener}
+	}
 	
-	// Set up output redirection
-	if logEnabled {
-		// Create a multi-writer to write to both log file and ring buffer
-		var writers []io.Writer
-		
-		// Add log file if enabled
-		if procInfo.logFile != nil {
-			writers = append(writers, procInfo.logFile)
+	// Output goes to the ring buffer, to the followers of the logs and, if
+	// logging is enabled, to the log file
+	writers := []io.Writer{procInfo.logBuffer, &lineWriter{process: name, stream: &pm.logs}}
+	if logFile != nil {
+		writers = append(writers, logFile)
+	}
+	multiWriter := io.MultiWriter(writers...)
+	cmd.Stdout = multiWriter
+	cmd.Stderr = multiWriter
+	cmd.WaitDelay = waitDelay
+	setProcessGroup(cmd)
+
+	env, err := environment(procInfo, cmd)
+	if err != nil {
+		cancel()
+		if logFile != nil {
+			logFile.Close()
 		}
-		
-		// Always add the ring buffer
-		writers = append(writers, procInfo.logBuffer)
-		
-		// Create multi-writer for stdout and stderr
-		multiWriter := io.MultiWriter(writers...)
-		cmd.Stdout = multiWriter
-		cmd.Stderr = multiWriter
-	} else {
-		// If logging is disabled, still capture to ring buffer
-		cmd.Stdout = procInfo.logBuffer
-		cmd.Stderr = procInfo.logBuffer
+		return instance{}, err
+	}
+	cmd.Env = env
+
+	// The process keeps the read end of its input, the process manager
+	// writes to the other end
+	var stdin *os.File
+	if procInfo.Stdin {
+		var stdinReader *os.File
+		if stdinReader, stdin, err = os.Pipe(); err != nil {
+			cancel()
+			if logFile != nil {
+				logFile.Close()
+			}
+			return instance{}, fmt.Errorf("failed to create stdin: %v", err)
+		}
+		defer stdinReader.Close()
+		cmd.Stdin = stdinReader
 	}
 	
-	procInfo.cmd = cmd
-	err := cmd.Start()
+	err = cmd.Start()
 	if err != nil {
-		if logEnabled && procInfo.logFile != nil {
-			procInfo.logFile.Close()
+		cancel()
+		if logFile != nil {
+			logFile.Close()
+		}
+		if stdin != nil {
+			stdin.Close()
 		}
-		return fmt.Errorf("failed to start process: %v", err)
+		return instance{}, fmt.Errorf("failed to start process: %v", err)
 	}
+	// A process that cannot be contained is stopped without the processes
+	// it started
+	_ = containProcess(cmd)
+
+	// waitProcess only records the exit of the command the process runs
+	go pm.waitProcess(procInfo, cmd, cancel, logFile, stdin)
+
+	return instance{cmd: cmd, ctx: ctx, cancel: cancel, logFile: logFile, stdin: stdin}, nil
+}
 
+// adopt makes a started instance the command a process runs. It must be
+// called with the locks of the process manager and the process held.
+func (pm *ProcessManager) adopt(procInfo *ProcessInfo, inst instance) {
+	name := procInfo.Name
+	cmd, ctx := inst.cmd, inst.ctx
+
+	procInfo.cmd = cmd
+	procInfo.ctx = ctx
+	procInfo.cancel = inst.cancel
+	procInfo.logFile = inst.logFile
+	procInfo.stdin = inst.stdin
 	procInfo.PID = int32(cmd.Process.Pid)
 	procInfo.Status = ProcessStatusRunning
-
-	// Store the process
-	pm.processes[name] = procInfo
+	procInfo.StartTime = time.Now()
+	procInfo.Error = ""
+	procInfo.CPUPercent = 0
+	procInfo.MemoryMB = 0
+	procInfo.ReadBytes = 0
+	procInfo.WriteBytes = 0
+	procInfo.ReadRate = 0
+	procInfo.WriteRate = 0
+	procInfo.Children = 0
+	procInfo.sampled = time.Time{}
+	// Without a health check a process is ready once it started
+	procInfo.Ready = procInfo.HealthCheck == ""
+	procInfo.Health = ""
+	if procInfo.HealthCheck != "" {
+		procInfo.Health = HealthStarting
+	}
+	procInfo.HealthFailures = 0
+	procInfo.HealthError = ""
+	pm.emit(name, EventStarted, fmt.Sprintf("started with PID %d", procInfo.PID))
 
 	// Set up deadline if specified
-	if deadline > 0 {
+	if deadline := procInfo.Deadline; deadline > 0 {
 		go func() {
 			select {
 			case <-time.After(time.Duration(deadline) * time.Second):
-				pm.StopProcess(name)
+				// A scheduled process only stops its run
+				if procInfo.schedule != nil {
+					pm.killRun(procInfo, cmd, fmt.Sprintf("deadline of %d seconds exceeded", deadline))
+				} else {
+					pm.StopProcess(name)
+				}
 			case <-ctx.Done():
-				// Process was stopped or completed
+				// Process was stopped or exited
 			}
 		}()
 	}
 
 	// Monitor the process in a goroutine
-	go pm.monitorProcess(name)
+	go pm.monitorProcess(ctx, procInfo, cmd)
+	if procInfo.HealthCheck != "" {
+		go pm.monitorHealth(ctx, procInfo, cmd)
+	}
+}
 
-	return nil
+// waitProcess waits for the command of a process to exit, records how it
+// exited and restarts it if its restart policy says so
+func (pm *ProcessManager) waitProcess(procInfo *ProcessInfo, cmd *exec.Cmd, cancel context.CancelFunc, logFile *rotatingFile, stdin *os.File) {
+	err := cmd.Wait()
+	releaseProcess(cmd)
+	cancel()
+	if logFile != nil {
+		logFile.Close()
+	}
+	if stdin != nil {
+		stdin.Close()
+	}
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	procInfo.mutex.Lock()
+	defer procInfo.mutex.Unlock()
+
+	// A process that was stopped or deleted keeps its status
+	if pm.processes[procInfo.Name] != procInfo || procInfo.cmd != cmd || procInfo.Status != ProcessStatusRunning {
+		return
+	}
+	procInfo.Ready = false
+
+	if procInfo.killReason != "" {
+		procInfo.Status = ProcessStatusFailed
+		procInfo.Error = procInfo.killReason
+	} else if err == nil {
+		procInfo.Status = ProcessStatusCompleted
+	} else {
+		procInfo.Status = ProcessStatusFailed
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+			procInfo.Error = fmt.Sprintf("process exited with code %d", exitErr.ExitCode())
+		} else {
+			procInfo.Error = fmt.Sprintf("process exited: %v", err)
+		}
+	}
+	if procInfo.Status == ProcessStatusCompleted {
+		pm.emit(procInfo.Name, EventExited, "process exited successfully")
+	} else {
+		pm.emit(procInfo.Name, EventCrashed, procInfo.Error)
+	}
+
+	// A scheduled process runs again at its next time
+	if procInfo.schedule != nil {
+		procInfo.unhealthy = false
+		procInfo.killReason = ""
+		pm.endRun(procInfo)
+		return
+	}
+
+	// Restarts only count as a crash loop while the process keeps exiting
+	// soon after it started
+	if time.Since(procInfo.StartTime) >= restartResetAfter {
+		procInfo.crashes = 0
+	}
+	pm.scheduleRestart(procInfo, err == nil)
+	procInfo.unhealthy = false
+	procInfo.killReason = ""
 }
 
-// monitorProcess monitors a process's status and resources
-func (pm *ProcessManager) monitorProcess(name string) {
+// monitorProcess samples the resources of a process and the processes it
+// started until ctx is done because the process exited or was stopped
+func (pm *ProcessManager) monitorProcess(ctx context.Context, procInfo *ProcessInfo, cmd *exec.Cmd) {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
+	var s sampler
 	for {
 		select {
+		case <-ctx.Done():
+			// The process exited or was stopped
+			return
 		case <-ticker.C:
-			pm.mutex.RLock()
-			procInfo, exists := pm.processes[name]
-			pm.mutex.RUnlock()
+			// Sampling runs commands, so it runs without the lock
+			sample, readRate, writeRate := s.sample(int32(cmd.Process.Pid))
 
-			if !exists || procInfo.Status != ProcessStatusRunning {
-				return
-			}
-
-			// Update process info
 			procInfo.mutex.Lock()
-			
-			// Check if process is still running
-			if procInfo.cmd.ProcessState != nil && procInfo.cmd.ProcessState.Exited() {
-				if procInfo.cmd.ProcessState.Success() {
-					procInfo.Status = ProcessStatusCompleted
-				} else {
-					procInfo.Status = ProcessStatusFailed
-					procInfo.Error = fmt.Sprintf("process exited with code %d", procInfo.cmd.ProcessState.ExitCode())
-				}
+			if procInfo.cmd != cmd || procInfo.Status != ProcessStatusRunning {
 				procInfo.mutex.Unlock()
 				return
 			}
-
-			// Update CPU and memory usage
-			if proc, err := process.NewProcess(procInfo.PID); err == nil {
-				if cpuPercent, err := proc.CPUPercent(); err == nil {
-					procInfo.CPUPercent = cpuPercent
-				}
-				if memInfo, err := proc.MemoryInfo(); err == nil && memInfo != nil {
-					procInfo.MemoryMB = float64(memInfo.RSS) / 1024 / 1024
-				}
-			}
-			
+			procInfo.CPUPercent = sample.cpuPercent
+			procInfo.MemoryMB = float64(sample.rss) / 1024 / 1024
+			procInfo.ReadBytes = sample.readBytes
+			procInfo.WriteBytes = sample.writeBytes
+			procInfo.ReadRate = readRate
+			procInfo.WriteRate = writeRate
+			procInfo.Children = sample.children
+			procInfo.sampled = time.Now()
 			procInfo.mutex.Unlock()
 		}
 	}
 }
 
-// StopProcess stops a running process
+// StopProcess stops a running process, or cancels the pending restart of a
+// process that exited. The processes that require it are stopped first.
 func (pm *ProcessManager) StopProcess(name string) error {
 	pm.mutex.Lock()
 	defer pm.mutex.Unlock()
@@ -219,61 +649,86 @@ func (pm *ProcessManager) StopProcess(name string) error {
 		return fmt.Errorf("process '%s' not found", name)
 	}
 
-	if procInfo.Status != ProcessStatusRunning {
-		return fmt.Errorf("process '%s' is not running", name)
+	for _, dependent := range pm.dependents([]string{name}, false) {
+		pm.stopProcess(pm.processes[dependent])
 	}
+	return pm.stopProcess(procInfo)
+}
 
+// stopProcess stops a process without its dependents. It must be called
+// with the lock of the process manager held.
+func (pm *ProcessManager) stopProcess(procInfo *ProcessInfo) error {
 	procInfo.mutex.Lock()
 	defer procInfo.mutex.Unlock()
 
+	scheduled := procInfo.cancelSchedule()
+	if procInfo.cancelRestart() || procInfo.Status == ProcessStatusWaiting || (scheduled && procInfo.Status != ProcessStatusRunning) {
+		procInfo.Status = ProcessStatusStopped
+		pm.emit(procInfo.Name, EventStopped, "")
+		return nil
+	}
+
+	if procInfo.Status != ProcessStatusRunning {
+		return fmt.Errorf("process '%s' is not running", procInfo.Name)
+	}
+
 	// Cancel the context to stop the process
 	procInfo.cancel()
 	
-	// Wait for the process to exit
-	err := procInfo.cmd.Process.Kill()
-	if err != nil {
+	// Kill the process; its log file is closed once it has exited
+	err := killProcess(procInfo.cmd)
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
 		return fmt.Errorf("failed to kill process: %v", err)
 	}
 
 	procInfo.Status = ProcessStatusStopped
-
-	// Close log file if it exists
-	if procInfo.logFile != nil {
-		procInfo.logFile.Close()
-		procInfo.logFile = nil
-	}
+	procInfo.Ready = false
+	procInfo.finishRun(RunStopped, "")
+	pm.emit(procInfo.Name, EventStopped, "")
 
 	return nil
 }
 
-// RestartProcess restarts a process
+// RestartProcess restarts a process. The processes that require it are
+// stopped first and start again once it is ready.
 func (pm *ProcessManager) RestartProcess(name string) error {
 	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
 	procInfo, exists := pm.processes[name]
 	if !exists {
-		pm.mutex.Unlock()
 		return fmt.Errorf("process '%s' not found", name)
 	}
 
+	for _, dependent := range pm.dependents([]string{name}, false) {
+		depInfo := pm.processes[dependent]
+		if err := pm.stopProcess(depInfo); err == nil {
+			depInfo.mutex.Lock()
+			depInfo.Status = ProcessStatusWaiting
+			depInfo.mutex.Unlock()
+		}
+	}
+
 	// Save the process configuration
-	command := procInfo.Command
-	logEnabled := procInfo.LogEnabled
-	deadline := procInfo.Deadline
-	cron := procInfo.Cron
-	jobID := procInfo.JobID
-	pm.mutex.Unlock()
+	procInfo.mutex.Lock()
+	def := procInfo.definition()
+	procInfo.mutex.Unlock()
 
 	// Stop the process
-	err := pm.StopProcess(name)
+	err := pm.stopProcess(procInfo)
 	if err != nil && err.Error() != fmt.Sprintf("process '%s' is not running", name) {
 		return fmt.Errorf("failed to stop process: %v", err)
 	}
 
 	// Delete the process
-	pm.DeleteProcess(name)
+	pm.deleteProcess(procInfo)
 
-	// Start the process again
-	return pm.StartProcess(name, command, logEnabled, deadline, cron, jobID)
+	// Start the process again, with its restart counters reset
+	if err := pm.startProcess(def); err != nil {
+		return err
+	}
+	pm.emit(name, EventRestarted, "restarted on request")
+	return nil
 }
 
 // DeleteProcess removes a process from the manager
@@ -286,51 +741,99 @@ func (pm *ProcessManager) DeleteProcess(name string) error {
 		return fmt.Errorf("process '%s' not found", name)
 	}
 
-	// Stop the process if it's running
-	if procInfo.Status == ProcessStatusRunning {
-		procInfo.mutex.Lock()
-		procInfo.cancel()
-		_ = procInfo.cmd.Process.Kill()
-		
-		// Close log file if it exists
-		if procInfo.logFile != nil {
-			procInfo.logFile.Close()
-		}
-		procInfo.mutex.Unlock()
-	}
-
-	// Remove the process from the map
-	delete(pm.processes, name)
+	pm.deleteProcess(procInfo)
+	pm.closeListeners()
+	pm.saveState()
 
 	return nil
 }
 
-// GetProcessStatus returns the status of a process
-func (pm *ProcessManager) GetProcessStatus(name string) (*ProcessInfo, error) {
-	pm.mutex.RLock()
-	defer pm.mutex.RUnlock()
+// deleteProcess stops a process if it's running and removes it. It must be
+// called with the lock of the process manager held.
+func (pm *ProcessManager) deleteProcess(procInfo *ProcessInfo) {
+	procInfo.mutex.Lock()
+	procInfo.cancelRestart()
+	procInfo.cancelSchedule()
 
-	procInfo, exists := pm.processes[name]
-	if !exists {
-		return nil, fmt.Errorf("process '%s' not found", name)
+	// Stop the process if it's running; its log file is closed once it
+	// has exited
+	if procInfo.Status == ProcessStatusRunning {
+		procInfo.cancel()
+		_ = killProcess(procInfo.cmd)
 	}
+	procInfo.mutex.Unlock()
 
-	// Make a copy to avoid race conditions
-	procInfo.mutex.Lock()
-	infoCopy := &ProcessInfo{
+	// Remove the process from the map
+	delete(pm.processes, procInfo.Name)
+}
+
+// copy returns a copy of the information of a process without its internal
+// state. It must be called with the lock of the process held.
+func (procInfo *ProcessInfo) copy() *ProcessInfo {
+	return &ProcessInfo{
 		Name:       procInfo.Name,
 		Command:    procInfo.Command,
 		PID:        procInfo.PID,
 		Status:     procInfo.Status,
 		CPUPercent: procInfo.CPUPercent,
 		MemoryMB:   procInfo.MemoryMB,
+		ReadBytes:  procInfo.ReadBytes,
+		WriteBytes: procInfo.WriteBytes,
+		ReadRate:   procInfo.ReadRate,
+		WriteRate:  procInfo.WriteRate,
+		Children:   procInfo.Children,
 		StartTime:  procInfo.StartTime,
 		LogEnabled: procInfo.LogEnabled,
 		Cron:       procInfo.Cron,
 		JobID:      procInfo.JobID,
 		Deadline:   procInfo.Deadline,
 		Error:      procInfo.Error,
+
+		Timezone: procInfo.Timezone,
+		Jitter:   procInfo.Jitter,
+		Overlap:  procInfo.Overlap,
+		NextRun:  procInfo.NextRun,
+		LastRun:  procInfo.lastRun(),
+
+		RestartPolicy: procInfo.RestartPolicy,
+		MaxRestarts:   procInfo.MaxRestarts,
+		Restarts:      procInfo.Restarts,
+		NextRestart:   procInfo.NextRestart,
+
+		Requires:    procInfo.Requires,
+		After:       procInfo.After,
+		HealthCheck: procInfo.HealthCheck,
+		Ready:       procInfo.Ready,
+
+		HealthInterval: procInfo.HealthInterval,
+		HealthRetries:  procInfo.HealthRetries,
+		Health:         procInfo.Health,
+		HealthFailures: procInfo.HealthFailures,
+		HealthError:    procInfo.HealthError,
+
+		Env:   procInfo.Env,
+		Dir:   procInfo.Dir,
+		Umask: procInfo.Umask,
+		User:  procInfo.User,
+		Stdin: procInfo.Stdin,
+
+		Listen: procInfo.Listen,
+	}
+}
+
+// GetProcessStatus returns the status of a process
+func (pm *ProcessManager) GetProcessStatus(name string) (*ProcessInfo, error) {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	procInfo, exists := pm.processes[name]
+	if !exists {
+		return nil, fmt.Errorf("process '%s' not found", name)
 	}
+
+	// Make a copy to avoid race conditions
+	procInfo.mutex.Lock()
+	infoCopy := procInfo.copy()
 	procInfo.mutex.Unlock()
 
 	return infoCopy, nil
@@ -344,20 +847,7 @@ func (pm *ProcessManager) ListProcesses() []*ProcessInfo {
 	processes := make([]*ProcessInfo, 0, len(pm.processes))
 	for _, procInfo := range pm.processes {
 		procInfo.mutex.Lock()
-		infoCopy := &ProcessInfo{
-			Name:       procInfo.Name,
-			Command:    procInfo.Command,
-			PID:        procInfo.PID,
-			Status:     procInfo.Status,
-			CPUPercent: procInfo.CPUPercent,
-			MemoryMB:   procInfo.MemoryMB,
-			StartTime:  procInfo.StartTime,
-			LogEnabled: procInfo.LogEnabled,
-			Cron:       procInfo.Cron,
-			JobID:      procInfo.JobID,
-			Deadline:   procInfo.Deadline,
-			Error:      procInfo.Error,
-		}
+		infoCopy := procInfo.copy()
 		procInfo.mutex.Unlock()
 		processes = append(processes, infoCopy)
 	}
@@ -422,9 +912,68 @@ func FormatProcessInfo(procInfo *ProcessInfo, format string) (string, error) {
 		return string(data), nil
 	default:
 		// Default to a simple text format
-		return fmt.Sprintf("Name: %s\nStatus: %s\nPID: %d\nCPU: %.2f%%\nMemory: %.2f MB\nStarted: %s\n",
+		result := fmt.Sprintf("Name: %s\nStatus: %s\nPID: %d\nCPU: %.2f%%\nMemory: %.2f MB\nStarted: %s\n",
 			procInfo.Name, procInfo.Status, procInfo.PID, procInfo.CPUPercent, 
-			procInfo.MemoryMB, procInfo.StartTime.Format(time.RFC3339)), nil
+			procInfo.MemoryMB, procInfo.StartTime.Format(time.RFC3339))
+		if procInfo.Status == ProcessStatusRunning {
+			result += fmt.Sprintf("IO: %d bytes read, %d bytes written (%.0f B/s read, %.0f B/s written)\nChildren: %d\n",
+				procInfo.ReadBytes, procInfo.WriteBytes, procInfo.ReadRate, procInfo.WriteRate, procInfo.Children)
+		}
+		if procInfo.Cron != "" {
+			result += fmt.Sprintf("Cron: %s\n", procInfo.Cron)
+			if procInfo.Timezone != "" {
+				result += fmt.Sprintf("Timezone: %s\n", procInfo.Timezone)
+			}
+			if procInfo.NextRun != nil {
+				result += fmt.Sprintf("Next run: %s\n", procInfo.NextRun.Format(time.RFC3339))
+			}
+			if run := procInfo.LastRun; run != nil {
+				result += fmt.Sprintf("Last run: %s, %s\n", run.Scheduled.Format(time.RFC3339), run.Status)
+			}
+		}
+		if procInfo.RestartPolicy != "" && procInfo.RestartPolicy != RestartNever {
+			result += fmt.Sprintf("Restart: %s\nRestarts: %d\n", procInfo.RestartPolicy, procInfo.Restarts)
+			if procInfo.NextRestart != nil {
+				result += fmt.Sprintf("Next restart: %s\n", procInfo.NextRestart.Format(time.RFC3339))
+			}
+		}
+		if len(procInfo.Requires) > 0 {
+			result += fmt.Sprintf("Requires: %s\n", strings.Join(procInfo.Requires, ", "))
+		}
+		if len(procInfo.After) > 0 {
+			result += fmt.Sprintf("After: %s\n", strings.Join(procInfo.After, ", "))
+		}
+		if procInfo.HealthCheck != "" {
+			result += fmt.Sprintf("Health check: %s\nHealth: %s\nReady: %t\n", procInfo.HealthCheck, procInfo.Health, procInfo.Ready)
+			if procInfo.HealthFailures > 0 {
+				result += fmt.Sprintf("Health failures: %d\n", procInfo.HealthFailures)
+			}
+			if procInfo.HealthError != "" {
+				result += fmt.Sprintf("Health error: %s\n", procInfo.HealthError)
+			}
+		}
+		if procInfo.Dir != "" {
+			result += fmt.Sprintf("Directory: %s\n", procInfo.Dir)
+		}
+		if procInfo.User != "" {
+			result += fmt.Sprintf("User: %s\n", procInfo.User)
+		}
+		if procInfo.Umask != "" {
+			result += fmt.Sprintf("Umask: %s\n", procInfo.Umask)
+		}
+		if procInfo.Stdin {
+			result += "Stdin: open\n"
+		}
+		if procInfo.Listen != "" {
+			result += fmt.Sprintf("Listen: %s\n", procInfo.Listen)
+		}
+		if len(procInfo.Env) > 0 {
+			result += fmt.Sprintf("Environment: %s\n", strings.Join(formatEnv(procInfo.Env), ", "))
+		}
+		if procInfo.Error != "" {
+			result += fmt.Sprintf("Error: %s\n", procInfo.Error)
+		}
+		return result, nil
 	}
 }
 
@@ -441,8 +990,28 @@ func FormatProcessList(processes []*ProcessInfo, format string) (string, error)
 		// Default to a simple text format
 		result := ""
 		for _, proc := range processes {
-			result += fmt.Sprintf("Name: %s, Status: %s, PID: %d, CPU: %.2f%%, Memory: %.2f MB\n",
-				proc.Name, proc.Status, proc.PID, proc.CPUPercent, proc.MemoryMB)
+			result += fmt.Sprintf("Name: %s, Status: %s, PID: %d, CPU: %.2f%%, Memory: %.2f MB, Restarts: %d\n",
+				proc.Name, proc.Status, proc.PID, proc.CPUPercent, proc.MemoryMB, proc.Restarts)
+		}
+		return result, nil
+	}
+}
+
+// FormatLogFiles formats a list of log files based on the specified format
+func FormatLogFiles(files []LogFile, format string) (string, error) {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(files, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal log files: %v", err)
+		}
+		return string(data), nil
+	default:
+		// Default to a simple text format
+		result := ""
+		for _, file := range files {
+			result += fmt.Sprintf("%s, Size: %d bytes, Modified: %s\n",
+				file.Name, file.Size, file.ModTime.Format(time.RFC3339))
 		}
 		return result, nil
 	}
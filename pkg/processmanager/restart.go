@@ -0,0 +1,139 @@
+package processmanager
+
+import (
+	"fmt"
+	"time"
+)
+
+// RestartPolicy says whether a process is started again after it exits
+type RestartPolicy string
+
+const (
+	// RestartNever leaves a process that exited alone
+	RestartNever RestartPolicy = "never"
+	// RestartOnFailure restarts a process that exited with an error
+	RestartOnFailure RestartPolicy = "on-failure"
+	// RestartAlways restarts a process however it exited
+	RestartAlways RestartPolicy = "always"
+)
+
+const (
+	// restartBackoffMin is the wait before the first restart, doubled for
+	// every further restart up to restartBackoffMax
+	restartBackoffMin = time.Second
+	restartBackoffMax = 5 * time.Minute
+	// restartResetAfter is how long a process must run before its earlier
+	// restarts stop counting against its backoff and maximum
+	restartResetAfter = time.Minute
+)
+
+// ParseRestartPolicy parses a restart policy, where an empty string means
+// RestartNever
+func ParseRestartPolicy(s string) (RestartPolicy, error) {
+	switch policy := RestartPolicy(s); policy {
+	case "":
+		return RestartNever, nil
+	case RestartNever, RestartOnFailure, RestartAlways:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid restart policy '%s', expected never, on-failure or always", s)
+	}
+}
+
+// restartBackoff returns the wait before a restart after the given number
+// of restarts in a row
+func restartBackoff(crashes int) time.Duration {
+	backoff := restartBackoffMin
+	for i := 0; i < crashes && backoff < restartBackoffMax; i++ {
+		backoff *= 2
+	}
+	return min(backoff, restartBackoffMax)
+}
+
+// scheduleRestart restarts a process that exited after a backoff if its
+// policy says so, or puts it in the crashloop state once it was restarted
+// MaxRestarts times in a row. It must be called with the locks of the
+// process manager and the process held.
+func (pm *ProcessManager) scheduleRestart(procInfo *ProcessInfo, success bool) {
+	switch {
+	case procInfo.unhealthy:
+		// A process killed because it failed its health check is restarted
+		// whatever its policy
+	case procInfo.RestartPolicy == RestartAlways:
+	case procInfo.RestartPolicy == RestartOnFailure && !success:
+	default:
+		return
+	}
+
+	if procInfo.MaxRestarts > 0 && procInfo.crashes >= procInfo.MaxRestarts {
+		reason := "it exited"
+		if procInfo.Error != "" {
+			reason = procInfo.Error
+		}
+		procInfo.Status = ProcessStatusCrashLoop
+		procInfo.Error = fmt.Sprintf("gave up after %d restarts in a row: %s", procInfo.crashes, reason)
+		pm.emit(procInfo.Name, EventCrashLoop, procInfo.Error)
+		return
+	}
+
+	backoff := restartBackoff(procInfo.crashes)
+	procInfo.crashes++
+	next := time.Now().Add(backoff)
+	procInfo.NextRestart = &next
+
+	procInfo.restartTimer = time.AfterFunc(backoff, func() {
+		pm.restart(procInfo, &next)
+	})
+}
+
+// restart starts a process again when the restart scheduled for next is
+// due, unless the process was stopped or deleted in the meantime
+func (pm *ProcessManager) restart(procInfo *ProcessInfo, next *time.Time) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if pm.processes[procInfo.Name] != procInfo {
+		return
+	}
+
+	procInfo.mutex.Lock()
+	if procInfo.NextRestart != next {
+		procInfo.mutex.Unlock()
+		return
+	}
+	procInfo.restartTimer = nil
+	procInfo.NextRestart = nil
+	procInfo.Restarts++
+
+	// A process whose dependencies are gone waits for them again
+	if !pm.dependenciesReady(procInfo) {
+		procInfo.Status = ProcessStatusWaiting
+		procInfo.mutex.Unlock()
+		return
+	}
+
+	if err := pm.spawn(procInfo); err != nil {
+		procInfo.Status = ProcessStatusFailed
+		procInfo.Error = err.Error()
+		pm.scheduleRestart(procInfo, false)
+		procInfo.mutex.Unlock()
+		return
+	}
+	pm.emit(procInfo.Name, EventRestarted, fmt.Sprintf("restart %d", procInfo.Restarts))
+	procInfo.mutex.Unlock()
+
+	// startWaiting locks the processes it starts, this one included
+	pm.startWaiting()
+}
+
+// cancelRestart stops the pending restart of a process and returns whether
+// there was one. It must be called with the lock of the process held.
+func (procInfo *ProcessInfo) cancelRestart() bool {
+	if procInfo.restartTimer == nil {
+		return false
+	}
+	procInfo.restartTimer.Stop()
+	procInfo.restartTimer = nil
+	procInfo.NextRestart = nil
+	return true
+}
@@ -0,0 +1,155 @@
+package processmanager
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	tests := []struct {
+		s    string
+		want RestartPolicy
+		ok   bool
+	}{
+		{"", RestartNever, true},
+		{"never", RestartNever, true},
+		{"on-failure", RestartOnFailure, true},
+		{"always", RestartAlways, true},
+		{"Always", "", false},
+		{"sometimes", "", false},
+	}
+	for _, test := range tests {
+		got, err := ParseRestartPolicy(test.s)
+		if got != test.want || (err == nil) != test.ok {
+			t.Errorf("%q: expected %q, %v, got %q, %v", test.s, test.want, test.ok, got, err)
+		}
+	}
+}
+
+func TestRestartBackoff(t *testing.T) {
+	tests := []struct {
+		crashes int
+		want    time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{8, 256 * time.Second},
+		{9, restartBackoffMax},
+		{100, restartBackoffMax},
+	}
+	for _, test := range tests {
+		if got := restartBackoff(test.crashes); got != test.want {
+			t.Errorf("%d crashes: expected %v, got %v", test.crashes, test.want, got)
+		}
+	}
+}
+
+// waitStatus waits until a process has the status
+func waitStatus(t *testing.T, pm *ProcessManager, name string, status ProcessStatus) *ProcessInfo {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		info, err := pm.GetProcessStatus(name)
+		if err == nil && info.Status == status {
+			return info
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Expected process %s to be %s, got %+v, %v", name, status, info, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// collectEvents returns the events of the types, as process:type, until n
+// of them were emitted
+func collectEvents(t *testing.T, events <-chan Event, n int, types ...EventType) []string {
+	t.Helper()
+	var got []string
+	timeout := time.After(10 * time.Second)
+	for len(got) < n {
+		select {
+		case event := <-events:
+			for _, eventType := range types {
+				if event.Type == eventType {
+					got = append(got, event.Process+":"+string(event.Type))
+				}
+			}
+		case <-timeout:
+			t.Fatalf("Expected %d events, got %q", n, got)
+		}
+	}
+	return got
+}
+
+func TestRestartPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		restart RestartPolicy
+		want    ProcessStatus
+	}{
+		{"never after failure", "exit 3", RestartNever, ProcessStatusFailed},
+		{"on failure after success", "exit 0", RestartOnFailure, ProcessStatusCompleted},
+		{"never after success", "exit 0", "", ProcessStatusCompleted},
+	}
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	for _, test := range tests {
+		def := ProcessDefinition{Name: test.name, Command: test.command, Restart: test.restart}
+		if err := pm.StartProcessDefinition(def); err != nil {
+			t.Fatalf("%s: failed to start: %v", test.name, err)
+		}
+		info := waitStatus(t, pm, test.name, test.want)
+		if info.NextRestart != nil || info.Restarts != 0 {
+			t.Errorf("%s: expected no restart, got %v after %d restarts", test.name, info.NextRestart, info.Restarts)
+		}
+	}
+	if info, _ := pm.GetProcessStatus("never after failure"); info.Error != "process exited with code 3" {
+		t.Errorf("Expected the exit code as error, got %q", info.Error)
+	}
+}
+
+func TestCrashLoop(t *testing.T) {
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := pm.Events(ctx)
+
+	// The process is restarted after the first backoff, then given up on
+	def := ProcessDefinition{Name: "crasher", Command: "exit 1", Restart: RestartOnFailure, MaxRestarts: 1}
+	if err := pm.StartProcessDefinition(def); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	got := collectEvents(t, events, 6, EventStarted, EventCrashed, EventRestarted, EventCrashLoop)
+	if want := "crasher:started crasher:crashed crasher:started crasher:restarted crasher:crashed crasher:crashloop"; strings.Join(got, " ") != want {
+		t.Errorf("Expected the events %s, got %q", want, got)
+	}
+	info := waitStatus(t, pm, "crasher", ProcessStatusCrashLoop)
+	if info.Restarts != 1 || info.NextRestart != nil || info.Error != "gave up after 1 restarts in a row: process exited with code 1" {
+		t.Errorf("Expected the process to be given up on after a restart, got %d restarts, %q", info.Restarts, info.Error)
+	}
+
+	// A pending restart is cancelled when the process is stopped
+	def = ProcessDefinition{Name: "stopped", Command: "exit 1", Restart: RestartAlways}
+	if err := pm.StartProcessDefinition(def); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	for i := 0; ; i++ {
+		if info, _ := pm.GetProcessStatus("stopped"); info.NextRestart != nil {
+			break
+		} else if i == 500 {
+			t.Fatalf("Expected a restart to be scheduled, got %s", info.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := pm.StopProcess("stopped"); err != nil {
+		t.Fatalf("Failed to stop: %v", err)
+	}
+	time.Sleep(restartBackoffMin + 200*time.Millisecond)
+	if info, _ := pm.GetProcessStatus("stopped"); info.Status != ProcessStatusStopped || info.Restarts != 0 {
+		t.Errorf("Expected the process to stay stopped, got %s after %d restarts", info.Status, info.Restarts)
+	}
+}
@@ -0,0 +1,139 @@
+package processmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// rollingTimeout is how long the new instance of a process may take to
+	// pass its health check in a rolling restart
+	rollingTimeout = time.Minute
+	// stopTimeout is how long the old instance of a process may take to
+	// exit after a rolling restart asked it to, before it is killed
+	stopTimeout = 10 * time.Second
+)
+
+// errInstanceExited is returned when the new instance of a rolling restart
+// exits before it passed its health check
+var errInstanceExited = errors.New("the new instance exited")
+
+// RollingRestart restarts a running process without downtime: it starts a
+// new instance next to the old one and only stops the old instance, asking
+// it to stop first, once the new instance passed the health check of the
+// process. The old instance keeps running if the new one does not pass the
+// check within rollingTimeout. The processes that require the process keep
+// running. With Listen, both instances accept connections on the same
+// socket, so none are refused; the health check may then be answered by
+// either instance.
+func (pm *ProcessManager) RollingRestart(name string) error {
+	pm.mutex.Lock()
+	procInfo, exists := pm.processes[name]
+	if !exists {
+		pm.mutex.Unlock()
+		return fmt.Errorf("process '%s' not found", name)
+	}
+	procInfo.mutex.Lock()
+	old := procInfo.cmd
+	next, err := pm.startRolling(procInfo)
+	procInfo.mutex.Unlock()
+	pm.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// The check runs without the locks, as both instances keep running
+	err = waitHealthy(next.ctx, procInfo.HealthCheck)
+
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	procInfo.mutex.Lock()
+	defer procInfo.mutex.Unlock()
+
+	procInfo.rolling = false
+	if err == nil && (pm.processes[name] != procInfo || procInfo.cmd != old || procInfo.Status != ProcessStatusRunning) {
+		err = errors.New("the process was stopped or restarted meanwhile")
+	}
+	if err == nil && next.ctx.Err() != nil {
+		err = errInstanceExited
+	}
+	if err != nil {
+		// Killing the new instance leaves the old one in place
+		next.cancel()
+		return fmt.Errorf("rolling restart of '%s' failed: %v", name, err)
+	}
+
+	previous := instance{cmd: procInfo.cmd, ctx: procInfo.ctx, cancel: procInfo.cancel}
+	pid := procInfo.PID
+	pm.adopt(procInfo, next)
+	// The new instance passed its health check, so the process stays ready
+	procInfo.Ready = true
+	procInfo.Health = HealthHealthy
+	go stopInstance(previous)
+	pm.emit(name, EventRestarted, fmt.Sprintf("rolling restart replaced PID %d by PID %d", pid, procInfo.PID))
+	return nil
+}
+
+// startRolling starts the new instance of a process for a rolling restart.
+// It must be called with the locks of the process manager and the process
+// held.
+func (pm *ProcessManager) startRolling(procInfo *ProcessInfo) (instance, error) {
+	switch {
+	case procInfo.HealthCheck == "":
+		return instance{}, fmt.Errorf("process '%s' has no health check to tell when its new instance is ready", procInfo.Name)
+	case procInfo.schedule != nil:
+		return instance{}, fmt.Errorf("process '%s' runs on a schedule and cannot be restarted without downtime", procInfo.Name)
+	case procInfo.Status != ProcessStatusRunning:
+		return instance{}, fmt.Errorf("process '%s' is not running", procInfo.Name)
+	case procInfo.rolling:
+		return instance{}, fmt.Errorf("a rolling restart of process '%s' is in progress", procInfo.Name)
+	}
+
+	next, err := pm.startInstance(procInfo)
+	if err != nil {
+		return instance{}, err
+	}
+	procInfo.rolling = true
+	return next, nil
+}
+
+// waitHealthy runs a health check every second until it passes. It fails
+// when ctx is done because the instance it checks exited, or after
+// rollingTimeout.
+func waitHealthy(ctx context.Context, check string) error {
+	timeout := time.After(rollingTimeout)
+	for {
+		err := runHealthCheck(ctx, check)
+		if ctx.Err() != nil {
+			return errInstanceExited
+		}
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return errInstanceExited
+		case <-timeout:
+			return fmt.Errorf("the new instance did not pass its health check within %v: %v", rollingTimeout, err)
+		case <-time.After(healthCheckStartInterval):
+		}
+	}
+}
+
+// stopInstance asks the old instance of a process to stop and kills it if
+// it did not exit after stopTimeout
+func stopInstance(inst instance) {
+	if err := terminateProcess(inst.cmd); err != nil {
+		inst.cancel()
+		return
+	}
+	select {
+	case <-inst.ctx.Done():
+		// The instance exited
+	case <-time.After(stopTimeout):
+		inst.cancel()
+	}
+}
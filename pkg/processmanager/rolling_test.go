@@ -0,0 +1,168 @@
+package processmanager
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseListen(t *testing.T) {
+	tests := []struct {
+		listen  string
+		network string
+		address string
+		ok      bool
+	}{
+		{"tcp://127.0.0.1:8080", "tcp", "127.0.0.1:8080", true},
+		{"tcp://:8080", "tcp", ":8080", true},
+		{"unix:///run/web.sock", "unix", "/run/web.sock", true},
+		{"tcp://127.0.0.1", "", "", false},
+		{"unix://", "", "", false},
+		{"127.0.0.1:8080", "", "", false},
+	}
+	for _, test := range tests {
+		network, address, err := parseListen(test.listen)
+		if network != test.network || address != test.address || (err == nil) != test.ok {
+			t.Errorf("%s: expected %s %s, %v, got %s %s, %v", test.listen, test.network, test.address, test.ok, network, address, err)
+		}
+	}
+}
+
+// socketServer answers every connection on the socket passed as file
+// descriptor 3 with its PID and socket activation variables
+const socketServer = `exec python3 -c '
+import os, socket
+s = socket.socket(fileno=3)
+while True:
+    c, _ = s.accept()
+    c.sendall(("%d %s %s" % (os.getpid(), os.environ["LISTEN_PID"], os.environ["LISTEN_FDS"])).encode())
+    c.close()
+'`
+
+// ask connects to addr and returns what the server answers
+func ask(t *testing.T, addr string) string {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to connect to %s: %v", addr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	answer, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Failed to read the answer: %v", err)
+	}
+	return string(answer)
+}
+
+func TestRollingRestart(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("The socket server needs python3")
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	def := ProcessDefinition{Name: "web", Command: socketServer, Listen: "tcp://" + addr, HealthCheck: "tcp://" + addr}
+	if err := pm.StartProcessDefinition(def); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	old := waitHealth(t, pm, "web", HealthHealthy).PID
+	if got, want := ask(t, addr), fmt.Sprintf("%d %d 1", old, old); got != want {
+		t.Errorf("Expected the server to answer %q, got %q", want, got)
+	}
+
+	if err := pm.RollingRestart("web"); err != nil {
+		t.Fatalf("Rolling restart failed: %v", err)
+	}
+	info, _ := pm.GetProcessStatus("web")
+	if info.PID == old || info.Status != ProcessStatusRunning || info.Health != HealthHealthy {
+		t.Fatalf("Expected a new healthy instance, got PID %d, %s, %s", info.PID, info.Status, info.Health)
+	}
+
+	// Connections are accepted all along, by the new instance once the old
+	// one stopped. The old one may stop before it answered.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		got := ask(t, addr)
+		if strings.HasPrefix(got, fmt.Sprintf("%d ", info.PID)) {
+			break
+		} else if (got != "" && !strings.HasPrefix(got, fmt.Sprintf("%d ", old))) || time.Now().After(deadline) {
+			t.Fatalf("Expected the new instance to answer, got %q", got)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The socket is closed with the last process listening on it
+	if err := pm.DeleteProcess("web"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	deadline = time.Now().Add(10 * time.Second)
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the socket to be closed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRollingRestartErrors(t *testing.T) {
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	pm.StartProcess("sleeper", "sleep 30", false, 0, "", "")
+	pm.StartProcessDefinition(ProcessDefinition{Name: "job", Command: "true", Cron: "0 3 * * *", HealthCheck: "true"})
+	pm.StartProcessDefinition(ProcessDefinition{Name: "done", Command: "true", HealthCheck: "true"})
+	waitStatus(t, pm, "done", ProcessStatusCompleted)
+
+	tests := []struct {
+		name  string
+		error string
+	}{
+		{"unknown", "not found"},
+		{"sleeper", "has no health check"},
+		{"job", "runs on a schedule"},
+		{"done", "is not running"},
+	}
+	for _, test := range tests {
+		if err := pm.RollingRestart(test.name); err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("%s: expected an error with %q, got %v", test.name, test.error, err)
+		}
+	}
+}
+
+func TestRollingRestartKeepsOldInstance(t *testing.T) {
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	file := filepath.Join(t.TempDir(), "broken")
+	def := ProcessDefinition{Name: "web", Command: "test -f " + file + " && exit 1; sleep 30", HealthCheck: "test ! -f " + file}
+	if err := pm.StartProcessDefinition(def); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+	old := waitHealth(t, pm, "web", HealthHealthy).PID
+
+	// The new instance exits before it passes its health check
+	os.WriteFile(file, nil, 0644)
+	if err := pm.RollingRestart("web"); err == nil || !strings.Contains(err.Error(), "exited") {
+		t.Errorf("Expected the rolling restart to fail, got %v", err)
+	}
+	info, _ := pm.GetProcessStatus("web")
+	if info.PID != old || info.Status != ProcessStatusRunning {
+		t.Errorf("Expected the old instance to keep running, got PID %d, %s", info.PID, info.Status)
+	}
+}
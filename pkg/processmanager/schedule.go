@@ -0,0 +1,344 @@
+package processmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"time"
+)
+
+// OverlapPolicy says what happens when a scheduled run of a process is due
+// while the previous run still runs
+type OverlapPolicy string
+
+const (
+	// OverlapSkip skips the run that is due
+	OverlapSkip OverlapPolicy = "skip"
+	// OverlapQueue starts the run that is due once the previous run exits
+	OverlapQueue OverlapPolicy = "queue"
+	// OverlapKillPrevious kills the previous run to start the run that is due
+	OverlapKillPrevious OverlapPolicy = "kill-previous"
+)
+
+// maxCronRuns is the number of runs of a scheduled process that are kept
+const maxCronRuns = 20
+
+// ParseOverlapPolicy parses an overlap policy, where an empty string means
+// OverlapSkip
+func ParseOverlapPolicy(s string) (OverlapPolicy, error) {
+	switch policy := OverlapPolicy(s); policy {
+	case "":
+		return OverlapSkip, nil
+	case OverlapSkip, OverlapQueue, OverlapKillPrevious:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid overlap policy '%s', expected skip, queue or kill-previous", s)
+	}
+}
+
+// RunStatus is the outcome of a scheduled run of a process
+type RunStatus string
+
+const (
+	// RunRunning indicates the run did not end yet
+	RunRunning RunStatus = "running"
+	// RunCompleted indicates the run exited successfully
+	RunCompleted RunStatus = "completed"
+	// RunFailed indicates the run failed to start or exited with an error
+	RunFailed RunStatus = "failed"
+	// RunSkipped indicates the run did not start, see its error for why
+	RunSkipped RunStatus = "skipped"
+	// RunKilled indicates the run was killed to start the next run
+	RunKilled RunStatus = "killed"
+	// RunStopped indicates the process was stopped during the run
+	RunStopped RunStatus = "stopped"
+)
+
+// CronRun is a scheduled run of a process
+type CronRun struct {
+	Scheduled time.Time  `json:"scheduled"`
+	Start     *time.Time `json:"start,omitempty"`
+	End       *time.Time `json:"end,omitempty"`
+	Status    RunStatus  `json:"status"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// scheduleRun plans the next run of a scheduled process, a random delay of
+// up to Jitter seconds after the time its schedule gives. It must be called
+// with the locks of the process manager and the process held.
+func (pm *ProcessManager) scheduleRun(procInfo *ProcessInfo) {
+	scheduled := procInfo.schedule.Next(time.Now())
+	if scheduled.IsZero() {
+		procInfo.NextRun = nil
+		return
+	}
+	next := scheduled
+	if procInfo.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(procInfo.Jitter) * int64(time.Second))))
+	}
+	procInfo.NextRun = &next
+
+	procInfo.cronTimer = time.AfterFunc(time.Until(next), func() {
+		pm.runScheduled(procInfo, &next, scheduled)
+	})
+}
+
+// runScheduled starts the run planned for next, unless the process was
+// stopped or deleted in the meantime, and plans the run after it. The
+// overlap policy of the process decides what happens if the previous run
+// still runs.
+func (pm *ProcessManager) runScheduled(procInfo *ProcessInfo, next *time.Time, scheduled time.Time) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if pm.processes[procInfo.Name] != procInfo {
+		return
+	}
+
+	procInfo.mutex.Lock()
+	defer procInfo.mutex.Unlock()
+
+	if procInfo.NextRun != next {
+		return
+	}
+	procInfo.cronTimer = nil
+	pm.scheduleRun(procInfo)
+
+	if procInfo.Status == ProcessStatusRunning {
+		switch procInfo.Overlap {
+		case OverlapQueue:
+			if procInfo.queued != nil {
+				procInfo.recordRun(&CronRun{Scheduled: *procInfo.queued, Status: RunSkipped, Error: "a later run was queued"})
+			}
+			procInfo.queued = &scheduled
+			return
+		case OverlapKillPrevious:
+			procInfo.finishRun(RunKilled, "killed to start the next run")
+			procInfo.cancel()
+			_ = killProcess(procInfo.cmd)
+		default:
+			procInfo.recordRun(&CronRun{Scheduled: scheduled, Status: RunSkipped, Error: "the previous run is still running"})
+			return
+		}
+	}
+
+	pm.startRun(procInfo, scheduled)
+}
+
+// startRun starts a run of a scheduled process. It must be called with the
+// locks of the process manager and the process held.
+func (pm *ProcessManager) startRun(procInfo *ProcessInfo, scheduled time.Time) {
+	procInfo.cancelRestart()
+	run := &CronRun{Scheduled: scheduled}
+
+	if !pm.dependenciesReady(procInfo) {
+		run.Status = RunSkipped
+		run.Error = "the processes it requires are not ready"
+		procInfo.recordRun(run)
+		return
+	}
+
+	if err := pm.spawn(procInfo); err != nil {
+		procInfo.Error = err.Error()
+		run.Status = RunFailed
+		run.Error = err.Error()
+		procInfo.recordRun(run)
+		return
+	}
+
+	start := procInfo.StartTime
+	run.Start = &start
+	run.Status = RunRunning
+	procInfo.recordRun(run)
+	procInfo.current = run
+}
+
+// endRun records how the current run of a scheduled process exited and
+// starts the queued run, if any. It must be called with the locks of the
+// process manager and the process held.
+func (pm *ProcessManager) endRun(procInfo *ProcessInfo) {
+	status := RunCompleted
+	if procInfo.Status == ProcessStatusFailed {
+		status = RunFailed
+	}
+	procInfo.finishRun(status, procInfo.Error)
+
+	if procInfo.queued != nil {
+		scheduled := *procInfo.queued
+		procInfo.queued = nil
+		pm.startRun(procInfo, scheduled)
+		if procInfo.Status == ProcessStatusRunning {
+			return
+		}
+	}
+	if procInfo.cronTimer != nil {
+		procInfo.Status = ProcessStatusScheduled
+	}
+}
+
+// recordRun adds a run to the runs of a process, dropping the oldest beyond
+// maxCronRuns. It must be called with the lock of the process held.
+func (procInfo *ProcessInfo) recordRun(run *CronRun) {
+	procInfo.runs = append(procInfo.runs, run)
+	if len(procInfo.runs) > maxCronRuns {
+		procInfo.runs = procInfo.runs[len(procInfo.runs)-maxCronRuns:]
+	}
+}
+
+// finishRun ends the current run of a process, if any, with the given
+// status. It must be called with the lock of the process held.
+func (procInfo *ProcessInfo) finishRun(status RunStatus, message string) {
+	if procInfo.current == nil {
+		return
+	}
+	end := time.Now()
+	procInfo.current.End = &end
+	procInfo.current.Status = status
+	procInfo.current.Error = message
+	procInfo.current = nil
+}
+
+// cancelSchedule stops the planned and queued runs of a process and returns
+// whether a run was planned. It must be called with the lock of the process
+// held.
+func (procInfo *ProcessInfo) cancelSchedule() bool {
+	procInfo.queued = nil
+	if procInfo.cronTimer == nil {
+		return false
+	}
+	procInfo.cronTimer.Stop()
+	procInfo.cronTimer = nil
+	procInfo.NextRun = nil
+	return true
+}
+
+// lastRun returns a copy of the last run of a process, or nil if it did not
+// run yet. It must be called with the lock of the process held.
+func (procInfo *ProcessInfo) lastRun() *CronRun {
+	if len(procInfo.runs) == 0 {
+		return nil
+	}
+	run := *procInfo.runs[len(procInfo.runs)-1]
+	return &run
+}
+
+// scheduledProcess returns a process that runs on a cron schedule
+func (pm *ProcessManager) scheduledProcess(name string) (*ProcessInfo, error) {
+	procInfo, exists := pm.processes[name]
+	if !exists {
+		return nil, fmt.Errorf("process '%s' not found", name)
+	}
+	if procInfo.schedule == nil {
+		return nil, fmt.Errorf("process '%s' has no cron schedule", name)
+	}
+	return procInfo, nil
+}
+
+// ListRuns returns the last runs of a scheduled process, from old to new
+func (pm *ProcessManager) ListRuns(name string) ([]CronRun, error) {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	procInfo, err := pm.scheduledProcess(name)
+	if err != nil {
+		return nil, err
+	}
+
+	procInfo.mutex.Lock()
+	defer procInfo.mutex.Unlock()
+	runs := make([]CronRun, 0, len(procInfo.runs))
+	for _, run := range procInfo.runs {
+		runs = append(runs, *run)
+	}
+	return runs, nil
+}
+
+// UpcomingRuns returns the next count times the schedule of a process gives,
+// 5 if count is not positive. The actual runs may be later by the jitter of
+// the process.
+func (pm *ProcessManager) UpcomingRuns(name string, count int) ([]time.Time, error) {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	procInfo, err := pm.scheduledProcess(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if count <= 0 {
+		count = 5
+	}
+	var times []time.Time
+	for t := time.Now(); len(times) < count; {
+		if t = procInfo.schedule.Next(t); t.IsZero() {
+			break
+		}
+		times = append(times, t)
+	}
+	return times, nil
+}
+
+// killRun kills the run of a scheduled process that started cmd, which is
+// recorded as failed for the given reason
+func (pm *ProcessManager) killRun(procInfo *ProcessInfo, cmd *exec.Cmd, reason string) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+	procInfo.mutex.Lock()
+	defer procInfo.mutex.Unlock()
+
+	if procInfo.cmd != cmd || procInfo.Status != ProcessStatusRunning {
+		return
+	}
+	procInfo.killReason = reason
+	if err := killProcess(cmd); err != nil {
+		procInfo.killReason = ""
+	}
+}
+
+// FormatRuns formats the runs of a scheduled process based on the specified
+// format
+func FormatRuns(runs []CronRun, format string) (string, error) {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(runs, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal runs: %v", err)
+		}
+		return string(data), nil
+	default:
+		// Default to a simple text format
+		result := ""
+		for _, run := range runs {
+			result += fmt.Sprintf("Scheduled: %s, Status: %s", run.Scheduled.Format(time.RFC3339), run.Status)
+			if run.Start != nil && run.End != nil {
+				result += fmt.Sprintf(", Duration: %s", run.End.Sub(*run.Start).Round(time.Millisecond))
+			}
+			if run.Error != "" {
+				result += fmt.Sprintf(", Error: %s", run.Error)
+			}
+			result += "\n"
+		}
+		return result, nil
+	}
+}
+
+// FormatUpcomingRuns formats the upcoming runs of a scheduled process based
+// on the specified format
+func FormatUpcomingRuns(times []time.Time, format string) (string, error) {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(times, "", "  ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal upcoming runs: %v", err)
+		}
+		return string(data), nil
+	default:
+		// Default to a simple text format
+		result := ""
+		for _, t := range times {
+			result += t.Format(time.RFC3339) + "\n"
+		}
+		return result, nil
+	}
+}
@@ -0,0 +1,85 @@
+package processmanager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// LoadState starts the processes defined in the heroscript state file at
+// path, if it exists, and from then on saves the definitions of the managed
+// processes to it whenever a process is started or deleted, so the same
+// processes run again when the process manager restarts. It returns the
+// errors of the processes that could not be started.
+func (pm *ProcessManager) LoadState(path string) error {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	pm.stateFile = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %v", err)
+	}
+	if _, err := pm.apply(string(data), false); err != nil {
+		return fmt.Errorf("failed to restore processes: %v", err)
+	}
+	return nil
+}
+
+// saveState writes the definitions of the managed processes to the state
+// file, if there is one. It must be called with the lock of the process
+// manager held.
+func (pm *ProcessManager) saveState() {
+	if pm.stateFile == "" {
+		return
+	}
+	if err := writeState(pm.stateFile, pm.definitions()); err != nil {
+		fmt.Printf("Failed to save state file %s: %v\n", pm.stateFile, err)
+	}
+}
+
+// definitions returns the definitions of the managed processes sorted by
+// name. It must be called with the lock of the process manager held.
+func (pm *ProcessManager) definitions() []ProcessDefinition {
+	names := make([]string, 0, len(pm.processes))
+	for name := range pm.processes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	defs := make([]ProcessDefinition, 0, len(names))
+	for _, name := range names {
+		procInfo := pm.processes[name]
+		procInfo.mutex.Lock()
+		defs = append(defs, procInfo.definition())
+		procInfo.mutex.Unlock()
+	}
+	return defs
+}
+
+// writeState replaces the state file at path by the heroscript of the
+// definitions, so it is never left half written
+func writeState(path string, defs []ProcessDefinition) error {
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".tmp"
+	// The commands and environments of processes may hold secrets
+	if err := os.WriteFile(tmp, []byte(heroScript(defs)), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package processmanager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "processes.hero")
+
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	if err := pm.LoadState(path); err != nil {
+		t.Fatalf("Expected no error without a state file, got %v", err)
+	}
+	defs := []ProcessDefinition{
+		{Name: "web", Command: "sleep 30", Restart: RestartAlways, Env: map[string]string{"PORT": "8080"}},
+		{Name: "job", Command: "true", Cron: "0 3 * * *"},
+	}
+	for _, def := range defs {
+		if err := pm.StartProcessDefinition(def); err != nil {
+			t.Fatalf("Failed to start %s: %v", def.Name, err)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected the state file to be written: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the state file to be private, got mode %v", info.Mode().Perm())
+	}
+
+	// Deleting a process removes it from the state
+	if err := pm.DeleteProcess("job"); err != nil {
+		t.Fatalf("Failed to delete job: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "name:'web'") || strings.Contains(string(data), "name:'job'") {
+		t.Errorf("Expected only web in the state file, got:\n%s", data)
+	}
+
+	// Stopping the daemon keeps the state, a new one restores it
+	pm.StopAll()
+	restored := NewProcessManager("")
+	defer restored.StopAll()
+	if err := restored.LoadState(path); err != nil {
+		t.Fatalf("Failed to restore the state: %v", err)
+	}
+	web := waitStatus(t, restored, "web", ProcessStatusRunning)
+	if web.Command != "sleep 30" || web.RestartPolicy != RestartAlways || web.Env["PORT"] != "8080" {
+		t.Errorf("Expected web as defined, got command %q, restart %q, env %v", web.Command, web.RestartPolicy, web.Env)
+	}
+	if _, err := restored.GetProcessStatus("job"); err == nil {
+		t.Error("Expected the deleted process not to be restored")
+	}
+}
+
+func TestLoadStateErrors(t *testing.T) {
+	dir := t.TempDir()
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+
+	bad := filepath.Join(dir, "bad.hero")
+	os.WriteFile(bad, []byte("!!process.start name:'web'\n"), 0600)
+	if err := pm.LoadState(bad); err == nil || !strings.Contains(err.Error(), "failed to restore") {
+		t.Errorf("Expected an error restoring a process without a command, got %v", err)
+	}
+
+	if err := pm.LoadState(dir); err == nil || !strings.Contains(err.Error(), "failed to read") {
+		t.Errorf("Expected an error reading a directory as state file, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package processmanager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// inputTimeout is how long sending input may wait for a process that does
+// not read it
+const inputTimeout = 5 * time.Second
+
+// input returns the standard input of a running process started with Stdin
+func (pm *ProcessManager) input(name string) (*ProcessInfo, *os.File, error) {
+	pm.mutex.RLock()
+	procInfo, exists := pm.processes[name]
+	pm.mutex.RUnlock()
+	if !exists {
+		return nil, nil, fmt.Errorf("process '%s' not found", name)
+	}
+
+	procInfo.mutex.Lock()
+	defer procInfo.mutex.Unlock()
+	if !procInfo.Stdin {
+		return nil, nil, fmt.Errorf("process '%s' was not started with stdin", name)
+	}
+	if procInfo.Status != ProcessStatusRunning {
+		return nil, nil, fmt.Errorf("process '%s' is not running", name)
+	}
+	if procInfo.stdin == nil {
+		return nil, nil, fmt.Errorf("the input of process '%s' is closed", name)
+	}
+	return procInfo, procInfo.stdin, nil
+}
+
+// SendInput writes input to the standard input of a running process started
+// with Stdin. It fails if the process does not read the input in time.
+func (pm *ProcessManager) SendInput(name string, input []byte) error {
+	procInfo, stdin, err := pm.input(name)
+	if err != nil {
+		return err
+	}
+
+	// Writing may block, so it happens without the lock of the process
+	procInfo.inputMutex.Lock()
+	defer procInfo.inputMutex.Unlock()
+	if err := stdin.SetWriteDeadline(time.Now().Add(inputTimeout)); err != nil {
+		return fmt.Errorf("failed to send input: %v", err)
+	}
+	if _, err := stdin.Write(input); err != nil {
+		switch {
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			return fmt.Errorf("process '%s' does not read its input", name)
+		case errors.Is(err, os.ErrClosed):
+			return fmt.Errorf("the input of process '%s' is closed", name)
+		default:
+			return fmt.Errorf("failed to send input: %v", err)
+		}
+	}
+	return nil
+}
+
+// CloseInput closes the standard input of a running process started with
+// Stdin, so it reads the end of its input. It gets a new input when it is
+// started again.
+func (pm *ProcessManager) CloseInput(name string) error {
+	procInfo, stdin, err := pm.input(name)
+	if err != nil {
+		return err
+	}
+
+	procInfo.mutex.Lock()
+	if procInfo.stdin == stdin {
+		procInfo.stdin = nil
+	}
+	procInfo.mutex.Unlock()
+	return stdin.Close()
+}
@@ -0,0 +1,83 @@
+package processmanager
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitLogs waits until the logs of a process contain want
+func waitLogs(t *testing.T, pm *ProcessManager, name, want string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		logs, err := pm.GetProcessLogs(name, 20)
+		if err == nil && strings.Contains(logs, want) {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Expected the logs of %s to contain %q, got %q, %v", name, want, logs, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSendInput(t *testing.T) {
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	if err := pm.StartProcessDefinition(ProcessDefinition{Name: "cat", Command: "cat", Stdin: true}); err != nil {
+		t.Fatalf("Failed to start: %v", err)
+	}
+
+	if err := pm.SendInput("cat", []byte("hello\n")); err != nil {
+		t.Fatalf("Failed to send input: %v", err)
+	}
+	waitLogs(t, pm, "cat", "hello")
+
+	// Closing the input ends cat
+	if err := pm.CloseInput("cat"); err != nil {
+		t.Fatalf("Failed to close the input: %v", err)
+	}
+	waitStatus(t, pm, "cat", ProcessStatusCompleted)
+	if err := pm.SendInput("cat", []byte("late\n")); err == nil || !strings.Contains(err.Error(), "not running") {
+		t.Errorf("Expected an error sending input to a process that exited, got %v", err)
+	}
+
+	// A restarted process gets a new input
+	if err := pm.RestartProcess("cat"); err != nil {
+		t.Fatalf("Failed to restart: %v", err)
+	}
+	if err := pm.SendInput("cat", []byte("again\n")); err != nil {
+		t.Fatalf("Failed to send input after restarting: %v", err)
+	}
+	waitLogs(t, pm, "cat", "again")
+}
+
+func TestSendInputErrors(t *testing.T) {
+	pm := NewProcessManager("")
+	defer pm.StopAll()
+	pm.StartProcess("sleeper", "sleep 30", false, 0, "", "")
+	pm.StartProcessDefinition(ProcessDefinition{Name: "reader", Command: "sleep 30", Stdin: true})
+
+	tests := []struct {
+		name  string
+		error string
+	}{
+		{"unknown", "not found"},
+		{"sleeper", "not started with stdin"},
+	}
+	for _, test := range tests {
+		if err := pm.SendInput(test.name, []byte("x")); err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("%s: expected an error with %q, got %v", test.name, test.error, err)
+		}
+		if err := pm.CloseInput(test.name); err == nil || !strings.Contains(err.Error(), test.error) {
+			t.Errorf("%s: expected an error closing with %q, got %v", test.name, test.error, err)
+		}
+	}
+
+	if err := pm.CloseInput("reader"); err != nil {
+		t.Fatalf("Failed to close the input: %v", err)
+	}
+	if err := pm.SendInput("reader", []byte("x")); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Errorf("Expected an error sending to a closed input, got %v", err)
+	}
+}
@@ -0,0 +1,1076 @@
+package processmanager
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+	"github.com/freeflowuniverse/herolauncher/pkg/sshserver"
+)
+
+// ANSI color codes for terminal output
+const (
+	ColorReset  = "\033[0m"
+	ColorRed    = "\033[31m"
+	ColorGreen  = "\033[32m"
+	ColorYellow = "\033[33m"
+	ColorBlue   = "\033[34m"
+	ColorPurple = "\033[35m"
+	ColorCyan   = "\033[36m"
+	ColorWhite  = "\033[37m"
+	Bold        = "\033[1m"
+)
+
+// TelnetServer represents a telnet server for interacting with the process manager
+type TelnetServer struct {
+	processManager *ProcessManager
+	listener       net.Listener
+	sshListener    net.Listener
+	clients        map[net.Conn]bool
+	clientsMutex   sync.RWMutex
+	running        bool
+}
+
+// NewTelnetServer creates a new telnet server
+func NewTelnetServer(processManager *ProcessManager) *TelnetServer {
+	return &TelnetServer{
+		processManager: processManager,
+		clients:        make(map[net.Conn]bool),
+	}
+}
+
+// socketNetwork returns the network and address of the socket of a telnet
+// server: a tcp://host:port address, or else the path of a Unix domain
+// socket
+func socketNetwork(socketPath string) (network, address string) {
+	if address, ok := strings.CutPrefix(socketPath, "tcp://"); ok {
+		return "tcp", address
+	}
+	return "unix", socketPath
+}
+
+// Start starts the telnet server on the specified socket path, or on a TCP
+// address given as tcp://host:port
+func (ts *TelnetServer) Start(socketPath string) error {
+	network, address := socketNetwork(socketPath)
+
+	// Remove existing socket file if it exists
+	if network == "unix" {
+		if err := os.Remove(address); err != nil {
+			// Ignore error if the file doesn't exist
+			if !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove existing socket: %v", err)
+			}
+		}
+	}
+
+	// Create the socket
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on socket: %v", err)
+	}
+
+	ts.listener = listener
+	ts.running = true
+
+	// Accept connections in a goroutine
+	go ts.acceptConnections(listener)
+
+	return nil
+}
+
+// StartSSH starts the server over SSH on a TCP address, with the same
+// commands as over telnet. Users log in with a key of
+// config.AuthorizedKeysFile, or with the secret of the process manager as
+// password unless config.Password checks passwords otherwise, and are
+// authenticated when their session starts.
+func (ts *TelnetServer) StartSSH(address string, config sshserver.Config) error {
+	if config.Password == nil {
+		config.Password = func(user, password string) bool {
+			return password == ts.processManager.GetSecret()
+		}
+	}
+	listener, err := sshserver.Listen(address, config)
+	if err != nil {
+		return err
+	}
+
+	ts.sshListener = listener
+	ts.running = true
+
+	// Accept sessions in a goroutine
+	go ts.acceptConnections(listener)
+
+	return nil
+}
+
+// Stop stops the telnet server
+func (ts *TelnetServer) Stop() error {
+	if !ts.running {
+		return nil
+	}
+
+	ts.running = false
+
+	// Close the listener
+	if ts.listener != nil {
+		if err := ts.listener.Close(); err != nil {
+			return fmt.Errorf("failed to close listener: %v", err)
+		}
+	}
+	if ts.sshListener != nil {
+		if err := ts.sshListener.Close(); err != nil {
+			return fmt.Errorf("failed to close SSH listener: %v", err)
+		}
+	}
+
+	// Close all client connections
+	ts.clientsMutex.Lock()
+	for conn := range ts.clients {
+		conn.Close()
+		delete(ts.clients, conn)
+	}
+	ts.clientsMutex.Unlock()
+
+	return nil
+}
+
+// acceptConnections accepts incoming connections
+func (ts *TelnetServer) acceptConnections(listener net.Listener) {
+	for ts.running {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ts.running {
+				fmt.Printf("Failed to accept connection: %v\n", err)
+			}
+			continue
+		}
+
+		// Handle the connection in a goroutine
+		go ts.handleConnection(conn)
+	}
+}
+
+// handleConnection handles a client connection
+func (ts *TelnetServer) handleConnection(conn net.Conn) {
+	// Add client to the map
+	ts.clientsMutex.Lock()
+	ts.clients[conn] = false // Not authenticated yet
+	ts.clientsMutex.Unlock()
+
+	// Ensure client is removed when connection closes
+	defer func() {
+		conn.Close()
+		ts.clientsMutex.Lock()
+		delete(ts.clients, conn)
+		ts.clientsMutex.Unlock()
+	}()
+
+	// Welcome message
+	authenticated := false
+	if session, ok := conn.(*sshserver.Conn); ok {
+		// SSH sessions are authenticated by the SSH server
+		authenticated = true
+		ts.clientsMutex.Lock()
+		ts.clients[conn] = true
+		ts.clientsMutex.Unlock()
+		conn.Write([]byte(fmt.Sprintf(" ** Welcome %s: you are authenticated.\n", session.User())))
+	} else {
+		conn.Write([]byte(" ** Welcome: you are not authenticated, provide secret.\n"))
+	}
+
+	// Create a scanner for reading input
+	scanner := bufio.NewScanner(conn)
+	var heroscriptBuffer strings.Builder
+	var lastCommand string
+	commandHistory := []string{}
+	historyPos := 0
+	interactiveMode := false
+
+	// Process client input
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Check for Ctrl+C (ASCII value 3)
+		if line == "\x03" {
+			conn.Write([]byte("Goodbye!\n"))
+			return
+		}
+
+		// Check for arrow up (ANSI escape sequence for up arrow: "\x1b[A")
+		if line == "\x1b[A" && len(commandHistory) > 0 {
+			if historyPos > 0 {
+				historyPos--
+			}
+			if historyPos < len(commandHistory) {
+				conn.Write([]byte(commandHistory[historyPos]))
+				line = commandHistory[historyPos]
+			}
+		}
+
+		// Handle authentication
+		if !authenticated {
+			if line == ts.processManager.GetSecret() {
+				authenticated = true
+				ts.clientsMutex.Lock()
+				ts.clients[conn] = true // Mark as authenticated
+				ts.clientsMutex.Unlock()
+				conn.Write([]byte(" ** Welcome: you are authenticated.\n"))
+			} else {
+				conn.Write([]byte("Invalid secret. Try again or disconnect.\n"))
+			}
+			continue
+		}
+
+		// Handle quit/exit commands
+		if line == "!!quit" || line == "!!exit" || line == "q" {
+			conn.Write([]byte("Goodbye!\n"))
+			return
+		}
+
+		// Handle help command
+		if line == "!!help" || line == "h" || line == "?" {
+			helpText := ts.generateHelpText(interactiveMode)
+			conn.Write([]byte(helpText))
+			continue
+		}
+
+		// Handle interactive mode toggle
+		if line == "!!interactive" || line == "!!i" || line == "i" {
+			interactiveMode = !interactiveMode
+			if interactiveMode {
+				conn.Write([]byte(ColorGreen + "Interactive mode enabled. Using colors for output." + ColorReset + "\n"))
+			} else {
+				conn.Write([]byte("Interactive mode disabled. Plain text output.\n"))
+			}
+			continue
+		}
+
+		// Empty line executes previous command if there's no pending command
+		if line == "" {
+			if heroscriptBuffer.Len() > 0 {
+				// Execute pending command
+				lastCommand = heroscriptBuffer.String()
+				heroscriptBuffer.Reset()
+				if !ts.execute(conn, scanner, lastCommand, interactiveMode) {
+					return
+				}
+			} else if lastCommand != "" {
+				// Execute last command
+				if !ts.execute(conn, scanner, lastCommand, interactiveMode) {
+					return
+				}
+			}
+			continue
+		}
+
+		// Process heroscript commands
+		if (strings.HasPrefix(line, "!!") || strings.HasPrefix(line, "#")) && heroscriptBuffer.Len() > 0 {
+			// Execute previous heroscript if there's any
+			lastCommand = heroscriptBuffer.String()
+			heroscriptBuffer.Reset()
+			// Add to command history
+			commandHistory = append([]string{lastCommand}, commandHistory...)
+			if len(commandHistory) > 50 { // Limit history size
+				commandHistory = commandHistory[:50]
+			}
+			historyPos = 0
+			if !ts.execute(conn, scanner, lastCommand, interactiveMode) {
+				return
+			}
+		}
+
+		// Append the line to the heroscript buffer
+		heroscriptBuffer.WriteString(line + "\n")
+	}
+
+	// Execute any remaining heroscript
+	if authenticated && heroscriptBuffer.Len() > 0 {
+		result := ts.executeHeroscript(heroscriptBuffer.String(), interactiveMode)
+		lastCommand = heroscriptBuffer.String()
+		conn.Write([]byte(result))
+	}
+}
+
+// execute executes a heroscript and writes the result to the connection.
+// A single process.logs action with follow:true streams the logs, and a
+// single process.events action streams the events, until the client sends a
+// line. A single process.attach action passes lines to the input of a
+// process until the client detaches. It returns false if the connection is
+// gone.
+func (ts *TelnetServer) execute(conn net.Conn, scanner *bufio.Scanner, script string, interactive bool) bool {
+	pb, err := playbook.NewFromText(script)
+	if err == nil && len(pb.Actions) == 1 {
+		action := pb.Actions[0]
+		if action.Actor == "process" && action.Name == "logs" && action.Params != nil && action.Params.GetBool("follow") {
+			return ts.followLogs(conn, scanner, action, interactive)
+		}
+		if action.Actor == "process" && action.Name == "events" {
+			return ts.followEvents(conn, scanner, action, interactive)
+		}
+		if action.Actor == "process" && action.Name == "attach" && action.Params != nil {
+			return ts.attach(conn, scanner, action, interactive)
+		}
+	}
+	_, err = conn.Write([]byte(ts.executeHeroscript(script, interactive)))
+	return err == nil
+}
+
+// followLogs writes the last lines of the processes named in the action, or
+// of all processes, and then every new line until the client sends a line
+// or disconnects. With format:'json' every line is written as a JSON
+// object on its own line. It returns false if the connection is gone.
+func (ts *TelnetServer) followLogs(conn net.Conn, scanner *bufio.Scanner, action *playbook.Action, interactive bool) bool {
+	names := splitNames(action.Params.Get("name"))
+	lines := action.Params.GetIntDefault("lines", 0)
+	format := action.Params.Get("format")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Subscribe before writing the last lines so no line is missed in
+	// between
+	logs := ts.processManager.FollowLogs(ctx, names...)
+
+	var header strings.Builder
+	if interactive {
+		header.WriteString(ColorCyan + Bold + "**RESULT**" + ColorReset + "\n")
+	} else {
+		header.WriteString("**RESULT** \n")
+	}
+	if lines > 0 {
+		for _, name := range names {
+			last, err := ts.processManager.GetProcessLogs(name, lines)
+			if err != nil {
+				header.WriteString(fmt.Sprintf("Error getting logs: %v\n", err))
+				continue
+			}
+			for _, line := range strings.Split(last, "\n") {
+				if line != "" {
+					header.WriteString(formatLogLine(LogLine{Process: name, Line: line}, format, interactive))
+				}
+			}
+		}
+	}
+	if _, err := conn.Write([]byte(header.String())); err != nil {
+		return false
+	}
+
+	return follow(conn, scanner, logs, func(line LogLine) string {
+		return formatLogLine(line, format, interactive)
+	}, interactive)
+}
+
+// followEvents writes the events of the processes named in the action, or
+// of all processes, as they happen until the client sends a line or
+// disconnects. With format:'json' every event is written as a JSON object
+// on its own line. It returns false if the connection is gone.
+func (ts *TelnetServer) followEvents(conn net.Conn, scanner *bufio.Scanner, action *playbook.Action, interactive bool) bool {
+	var names []string
+	format := ""
+	if action.Params != nil {
+		names = splitNames(action.Params.Get("name"))
+		format = action.Params.Get("format")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	all := ts.processManager.Events(ctx)
+	events := all
+	if len(names) > 0 {
+		filtered := make(chan Event, cap(all))
+		go func() {
+			for event := range all {
+				if slices.Contains(names, event.Process) {
+					select {
+					case filtered <- event:
+					default:
+					}
+				}
+			}
+		}()
+		events = filtered
+	}
+
+	header := "**RESULT** \n"
+	if interactive {
+		header = ColorCyan + Bold + "**RESULT**" + ColorReset + "\n"
+	}
+	if _, err := conn.Write([]byte(header)); err != nil {
+		return false
+	}
+
+	return follow(conn, scanner, events, func(event Event) string {
+		return formatEvent(event, format, interactive)
+	}, interactive)
+}
+
+// attach writes the output and the events of the process named in the
+// action as they happen and sends every line the client sends to its input,
+// until the client sends !!detach or disconnects. It returns false if the
+// connection is gone.
+func (ts *TelnetServer) attach(conn net.Conn, scanner *bufio.Scanner, action *playbook.Action, interactive bool) bool {
+	header := "**RESULT** \n"
+	end := "**ENDRESULT**\n"
+	if interactive {
+		header = ColorCyan + Bold + "**RESULT**" + ColorReset + "\n"
+		end = ColorCyan + Bold + "**ENDRESULT**" + ColorReset + "\n"
+	}
+
+	name := action.Params.Get("name")
+	info, err := ts.processManager.GetProcessStatus(name)
+	switch {
+	case name == "":
+		err = fmt.Errorf("name parameter is required")
+	case err == nil && !info.Stdin:
+		err = fmt.Errorf("process '%s' was not started with stdin", name)
+	}
+	if err != nil {
+		_, err = conn.Write([]byte(header + fmt.Sprintf("Error attaching to process: %v\n", err) + end))
+		return err == nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logs := ts.processManager.FollowLogs(ctx, name)
+	events := ts.processManager.Events(ctx)
+
+	// Clients wait for this line before they send input
+	if _, err := conn.Write([]byte(header + fmt.Sprintf("Attached to '%s', send !!detach to detach\n", name))); err != nil {
+		return false
+	}
+
+	// The lines are read until the client detaches, after which the
+	// connection reads commands again
+	input := make(chan string)
+	go func() {
+		defer close(input)
+		for scanner.Scan() {
+			line := scanner.Text()
+			input <- line
+			if line == "!!detach" {
+				return
+			}
+		}
+	}()
+
+	for {
+		var out string
+		select {
+		case line, open := <-input:
+			if !open {
+				return false
+			}
+			if line == "!!detach" {
+				_, err := conn.Write([]byte(end))
+				return err == nil
+			}
+			if err := ts.processManager.SendInput(name, []byte(line+"\n")); err != nil {
+				out = fmt.Sprintf("Error sending input: %v\n", err)
+			}
+		case line := <-logs:
+			out = formatLogLine(line, "", interactive)
+		case event := <-events:
+			if event.Process == name {
+				out = formatEvent(event, "", interactive)
+			}
+		}
+		if out == "" {
+			continue
+		}
+		if _, err := conn.Write([]byte(out)); err != nil {
+			return false
+		}
+	}
+}
+
+// follow writes every item from items to the connection until the client
+// sends a line, which ends the result, or disconnects. It returns false if
+// the connection is gone.
+func follow[T any](conn net.Conn, scanner *bufio.Scanner, items <-chan T, format func(T) string, interactive bool) bool {
+	// Any line from the client stops following
+	stopped := make(chan bool, 1)
+	go func() {
+		stopped <- scanner.Scan()
+	}()
+
+	for {
+		select {
+		case open := <-stopped:
+			if !open {
+				return false
+			}
+			end := "**ENDRESULT**\n"
+			if interactive {
+				end = ColorCyan + Bold + "**ENDRESULT**" + ColorReset + "\n"
+			}
+			_, err := conn.Write([]byte(end))
+			return err == nil
+		case item := <-items:
+			if _, err := conn.Write([]byte(format(item))); err != nil {
+				return false
+			}
+		}
+	}
+}
+
+// formatLogLine formats a line of output of a process for the telnet
+// client, as text or with format json as a JSON object
+func formatLogLine(line LogLine, format string, interactive bool) string {
+	if format == "json" {
+		data, err := json.Marshal(line)
+		if err != nil {
+			return fmt.Sprintf("Error formatting log line: %v\n", err)
+		}
+		return string(data) + "\n"
+	}
+	if interactive {
+		return ColorBlue + "[" + line.Process + "]" + ColorReset + " " + line.Line + "\n"
+	}
+	return "[" + line.Process + "] " + line.Line + "\n"
+}
+
+// formatEvent formats an event of a process for the telnet client, as a
+// JSON object with format json
+func formatEvent(event Event, format string, interactive bool) string {
+	if format == "json" {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Sprintf("Error formatting event: %v\n", err)
+		}
+		return string(data) + "\n"
+	}
+	line := event.Time.Format(time.RFC3339) + " "
+	if interactive {
+		line += ColorBlue + "[" + event.Process + "]" + ColorReset + " " + Bold + string(event.Type) + ColorReset
+	} else {
+		line += "[" + event.Process + "] " + string(event.Type)
+	}
+	if event.Message != "" {
+		line += ": " + event.Message
+	}
+	return line + "\n"
+}
+
+// RunHeroscript runs the process actions of a heroscript like the telnet
+// server does for its clients, and returns their result without the result
+// markers. The lines of actions that fail are returned as an error, after
+// the other actions ran.
+func (pm *ProcessManager) RunHeroscript(script string) (string, error) {
+	result := NewTelnetServer(pm).executeHeroscript(script, false)
+	var body, failed []string
+	for _, line := range strings.Split(strings.TrimSuffix(result, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "**RESULT**"), strings.HasPrefix(line, "**ENDRESULT**"):
+		case strings.HasPrefix(line, "Error"), strings.HasPrefix(line, "Unknown"):
+			failed = append(failed, line)
+			body = append(body, line)
+		default:
+			body = append(body, line)
+		}
+	}
+	output := strings.Join(body, "\n")
+	if len(failed) > 0 {
+		return output, errors.New(strings.Join(failed, "; "))
+	}
+	return output, nil
+}
+
+// executeHeroscript executes a heroscript and returns the result
+func (ts *TelnetServer) executeHeroscript(script string, interactive bool) string {
+	// Parse the heroscript
+	pb, err := playbook.NewFromText(script)
+	if err != nil {
+		return fmt.Sprintf("Error parsing heroscript: %v\n", err)
+	}
+
+	// Find the job ID if any
+	var jobID string
+	for _, action := range pb.Actions {
+		if action.Params != nil {
+			jobID = action.Params.GetVerbatim("jobid")
+			if jobID == "" {
+				// Try alternative casing
+				jobID = action.Params.Get("jobId")
+			}
+			break
+		}
+	}
+
+	// Process each action
+	var result strings.Builder
+	if interactive {
+		result.WriteString(fmt.Sprintf(ColorCyan+Bold+"**RESULT** %s"+ColorReset+"\n", jobID))
+	} else {
+		result.WriteString(fmt.Sprintf("**RESULT** %s\n", jobID))
+	}
+
+	// Actions run in order, with their conditions and loops
+	err = pb.Execute(func(action *playbook.Action) (string, error) {
+		output := ts.executeAction(action)
+		// The end marker must start a line of its own, also after JSON
+		if !strings.HasSuffix(output, "\n") {
+			output += "\n"
+		}
+		result.WriteString(output)
+		return output, nil
+	})
+	if err != nil {
+		result.WriteString(fmt.Sprintf("Error: %v\n", err))
+	}
+
+	if interactive {
+		result.WriteString(ColorCyan + Bold + "**ENDRESULT**" + ColorReset + "\n")
+	} else {
+		result.WriteString("**ENDRESULT**\n")
+	}
+	return result.String()
+}
+
+// executeAction executes an action of a heroscript and returns its result
+func (ts *TelnetServer) executeAction(action *playbook.Action) string {
+	if action.Actor != "process" {
+		return fmt.Sprintf("Unknown actor: %s\n", action.Actor)
+	}
+
+	// Process the action based on its name
+	switch action.Name {
+	case "start":
+		return ts.handleProcessStart(action)
+	case "list":
+		return ts.handleProcessList(action)
+	case "delete":
+		return ts.handleProcessDelete(action)
+	case "status":
+		return ts.handleProcessStatus(action)
+	case "restart":
+		return ts.handleProcessRestart(action)
+	case "stop":
+		return ts.handleProcessStop(action)
+	case "logs":
+		return ts.handleProcessLogs(action)
+	case "logfiles":
+		return ts.handleProcessLogFiles(action)
+	case "runs":
+		return ts.handleProcessRuns(action)
+	case "schedule":
+		return ts.handleProcessSchedule(action)
+	case "send":
+		return ts.handleProcessSend(action)
+	case "export":
+		return ts.processManager.Export()
+	case "import":
+		return ts.handleProcessImport(action)
+	default:
+		return fmt.Sprintf("Unknown action: %s.%s\n", action.Actor, action.Name)
+	}
+}
+
+// splitNames splits a comma separated list of process names
+func splitNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// handleProcessStart handles the process.start action
+func (ts *TelnetServer) handleProcessStart(action *playbook.Action) string {
+	// Format the heroscript if in interactive mode
+	if action.Params != nil && action.Params.GetBool("interactive") {
+		return formatHeroscript(action.HeroScript())
+	}
+	def, err := parseDefinition(action.Params)
+	if err != nil {
+		return fmt.Sprintf("Error: %v\n", err)
+	}
+
+	err = ts.processManager.StartProcessDefinition(def)
+	if err != nil {
+		return fmt.Sprintf("Error starting process: %v\n", err)
+	}
+
+	return fmt.Sprintf("Process '%s' started successfully\n", def.Name)
+}
+
+// handleProcessList handles the process.list action
+func (ts *TelnetServer) handleProcessList(action *playbook.Action) string {
+	format := action.Params.Get("format")
+	processes := ts.processManager.ListProcesses()
+
+	result, err := FormatProcessList(processes, format)
+	if err != nil {
+		return fmt.Sprintf("Error formatting process list: %v\n", err)
+	}
+
+	return result
+}
+
+// handleProcessDelete handles the process.delete action
+func (ts *TelnetServer) handleProcessDelete(action *playbook.Action) string {
+	name := action.Params.Get("name")
+	if name == "" {
+		return "Error: name parameter is required\n"
+	}
+
+	err := ts.processManager.DeleteProcess(name)
+	if err != nil {
+		return fmt.Sprintf("Error deleting process: %v\n", err)
+	}
+
+	return fmt.Sprintf("Process '%s' deleted successfully\n", name)
+}
+
+// handleProcessStatus handles the process.status action
+func (ts *TelnetServer) handleProcessStatus(action *playbook.Action) string {
+	name := action.Params.Get("name")
+	if name == "" {
+		return "Error: name parameter is required\n"
+	}
+
+	format := action.Params.Get("format")
+
+	procInfo, err := ts.processManager.GetProcessStatus(name)
+	if err != nil {
+		return fmt.Sprintf("Error getting process status: %v\n", err)
+	}
+
+	result, err := FormatProcessInfo(procInfo, format)
+	if err != nil {
+		return fmt.Sprintf("Error formatting process info: %v\n", err)
+	}
+
+	return result
+}
+
+// handleProcessRestart handles the process.restart action
+func (ts *TelnetServer) handleProcessRestart(action *playbook.Action) string {
+	name := action.Params.Get("name")
+	if name == "" {
+		return "Error: name parameter is required\n"
+	}
+
+	if action.Params.GetBool("rolling") {
+		if err := ts.processManager.RollingRestart(name); err != nil {
+			return fmt.Sprintf("Error restarting process: %v\n", err)
+		}
+		return fmt.Sprintf("Process '%s' restarted without downtime\n", name)
+	}
+
+	err := ts.processManager.RestartProcess(name)
+	if err != nil {
+		return fmt.Sprintf("Error restarting process: %v\n", err)
+	}
+
+	return fmt.Sprintf("Process '%s' restarted successfully\n", name)
+}
+
+// handleProcessStop handles the process.stop action
+func (ts *TelnetServer) handleProcessStop(action *playbook.Action) string {
+	name := action.Params.Get("name")
+	if name == "" {
+		return "Error: name parameter is required\n"
+	}
+
+	err := ts.processManager.StopProcess(name)
+	if err != nil {
+		return fmt.Sprintf("Error stopping process: %v\n", err)
+	}
+
+	return fmt.Sprintf("Process '%s' stopped successfully\n", name)
+}
+
+// handleProcessSend handles the process.send action
+func (ts *TelnetServer) handleProcessSend(action *playbook.Action) string {
+	name := action.Params.Get("name")
+	if name == "" {
+		return "Error: name parameter is required\n"
+	}
+
+	input := action.Params.GetVerbatim("input")
+	if input != "" {
+		if action.Params.GetBoolDefault("newline", true) {
+			input += "\n"
+		}
+		if err := ts.processManager.SendInput(name, []byte(input)); err != nil {
+			return fmt.Sprintf("Error sending input: %v\n", err)
+		}
+	}
+	if action.Params.GetBool("eof") {
+		if err := ts.processManager.CloseInput(name); err != nil {
+			return fmt.Sprintf("Error closing input: %v\n", err)
+		}
+		return fmt.Sprintf("Input of process '%s' closed\n", name)
+	}
+	if input == "" {
+		return "Error: input or eof parameter is required\n"
+	}
+
+	return fmt.Sprintf("Input sent to process '%s'\n", name)
+}
+
+// handleProcessImport handles the process.import action
+func (ts *TelnetServer) handleProcessImport(action *playbook.Action) string {
+	file := action.Params.GetVerbatim("file")
+	if file == "" {
+		return "Error: file parameter is required\n"
+	}
+
+	started, err := ts.processManager.ImportFile(file, action.Params.GetBool("replace"))
+	if action.Params.Get("format") == "json" {
+		result := ImportResult{Started: started}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return fmt.Sprintf("Error formatting import result: %v\n", err)
+		}
+		return string(data) + "\n"
+	}
+
+	var result strings.Builder
+	for _, name := range started {
+		result.WriteString(fmt.Sprintf("Process '%s' started successfully\n", name))
+	}
+	if err != nil {
+		result.WriteString(fmt.Sprintf("Error importing processes: %v\n", err))
+	} else if len(started) == 0 {
+		result.WriteString("No processes changed\n")
+	}
+	return result.String()
+}
+
+// handleProcessLogs handles the process.logs action without follow
+func (ts *TelnetServer) handleProcessLogs(action *playbook.Action) string {
+	name := action.Params.Get("name")
+	if name == "" {
+		return "Error: name parameter is required\n"
+	}
+
+	var logs string
+	var err error
+	if file := action.Params.GetVerbatim("file"); file != "" {
+		// A current or rotated log file, entirely unless lines is given
+		logs, err = ts.processManager.ReadLogFile(name, file, action.Params.GetIntDefault("lines", 0))
+	} else {
+		logs, err = ts.processManager.GetProcessLogs(name, action.Params.GetIntDefault("lines", 20))
+	}
+	if err != nil {
+		return fmt.Sprintf("Error getting logs: %v\n", err)
+	}
+	if logs == "" {
+		return ""
+	}
+	return logs + "\n"
+}
+
+// handleProcessLogFiles handles the process.logfiles action
+func (ts *TelnetServer) handleProcessLogFiles(action *playbook.Action) string {
+	name := action.Params.Get("name")
+	if name == "" {
+		return "Error: name parameter is required\n"
+	}
+
+	files, err := ts.processManager.ListLogFiles(name)
+	if err != nil {
+		return fmt.Sprintf("Error listing log files: %v\n", err)
+	}
+
+	result, err := FormatLogFiles(files, action.Params.Get("format"))
+	if err != nil {
+		return fmt.Sprintf("Error formatting log files: %v\n", err)
+	}
+
+	return result
+}
+
+// handleProcessRuns handles the process.runs action
+func (ts *TelnetServer) handleProcessRuns(action *playbook.Action) string {
+	name := action.Params.Get("name")
+	if name == "" {
+		return "Error: name parameter is required\n"
+	}
+
+	runs, err := ts.processManager.ListRuns(name)
+	if err != nil {
+		return fmt.Sprintf("Error listing runs: %v\n", err)
+	}
+
+	result, err := FormatRuns(runs, action.Params.Get("format"))
+	if err != nil {
+		return fmt.Sprintf("Error formatting runs: %v\n", err)
+	}
+
+	return result
+}
+
+// handleProcessSchedule handles the process.schedule action
+func (ts *TelnetServer) handleProcessSchedule(action *playbook.Action) string {
+	name := action.Params.Get("name")
+	if name == "" {
+		return "Error: name parameter is required\n"
+	}
+
+	times, err := ts.processManager.UpcomingRuns(name, action.Params.GetIntDefault("count", 5))
+	if err != nil {
+		return fmt.Sprintf("Error getting upcoming runs: %v\n", err)
+	}
+
+	result, err := FormatUpcomingRuns(times, action.Params.Get("format"))
+	if err != nil {
+		return fmt.Sprintf("Error formatting upcoming runs: %v\n", err)
+	}
+
+	return result
+}
+
+// formatHeroscript formats heroscript with colors for interactive mode
+func formatHeroscript(script string) string {
+	lines := strings.Split(script, "\n")
+	var formatted strings.Builder
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			formatted.WriteString("\n")
+			continue
+		}
+
+		// Format comments
+		if strings.HasPrefix(line, "//") {
+			formatted.WriteString(ColorGreen + line + ColorReset + "\n")
+			continue
+		}
+
+		// Format action lines
+		if strings.HasPrefix(line, "!!!") {
+			parts := strings.SplitN(line, " ", 2)
+			if len(parts) > 0 {
+				formatted.WriteString(ColorPurple + Bold + parts[0] + ColorReset + " ")
+				if len(parts) > 1 {
+					formatted.WriteString(parts[1])
+				}
+				formatted.WriteString("\n")
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "!!") {
+			parts := strings.SplitN(line, " ", 2)
+			if len(parts) > 0 {
+				formatted.WriteString(ColorBlue + Bold + parts[0] + ColorReset + " ")
+				if len(parts) > 1 {
+					formatted.WriteString(parts[1])
+				}
+				formatted.WriteString("\n")
+			}
+			continue
+		}
+
+		if strings.HasPrefix(line, "!") {
+			parts := strings.SplitN(line, " ", 2)
+			if len(parts) > 0 {
+				formatted.WriteString(ColorYellow + Bold + parts[0] + ColorReset + " ")
+				if len(parts) > 1 {
+					formatted.WriteString(parts[1])
+				}
+				formatted.WriteString("\n")
+			}
+			continue
+		}
+
+		// Format parameter lines
+		if strings.Contains(line, ":") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 {
+				formatted.WriteString("    " + ColorCyan + parts[0] + ColorReset + ":" + ColorYellow + parts[1] + ColorReset + "\n")
+				continue
+			}
+		}
+
+		// Default formatting
+		formatted.WriteString(line + "\n")
+	}
+
+	return formatted.String()
+}
+
+// generateHelpText generates help text for available commands
+func (ts *TelnetServer) generateHelpText(interactive bool) string {
+	var helpText string
+	if interactive {
+		helpText = ColorCyan + Bold + "**RESULT**" + ColorReset + "\n" + Bold + "Available commands:" + ColorReset + "\n\n"
+	} else {
+		helpText = "**RESULT** \nAvailable commands:\n\n"
+	}
+
+	// Process commands
+	if interactive {
+		helpText += Bold + ColorBlue + "Process management commands:" + ColorReset + "\n"
+	} else {
+		helpText += "Process management commands:\n"
+	}
+	helpText += "  !!process.start name:'<name>' command:'<command>' [log:true|false] [deadline:<seconds>] [cron:'<schedule>'] [timezone:'<zone>'] [jitter:<seconds>] [overlap:'skip|queue|kill-previous'] [jobid:'<id>'] [restart:'never|on-failure|always'] [maxrestarts:<n>] [requires:'<name>,...'] [after:'<name>,...'] [check:'<command>|tcp://<address>|http://<url>'] [checkinterval:<seconds>] [checkretries:<n>] [env:'<KEY>=<value>,...'] [dir:'<directory>'] [umask:'<mode>'] [user:'<user>[:<group>]'] [stdin:true] [listen:'tcp://<host>:<port>|unix://<path>']\n"
+	helpText += "  !!process.list [format:'json']\n"
+	helpText += "  !!process.delete name:'<name>'\n"
+	helpText += "  !!process.status name:'<name>' [format:'json']\n"
+	helpText += "  !!process.restart name:'<name>' [rolling:true]\n"
+	helpText += "  !!process.stop name:'<name>'\n"
+	helpText += "  !!process.logs [name:'<name>[,<name>...]'] [lines:<n>] [follow:true [format:'json']] [file:'<log file>']\n"
+	helpText += "    With follow:true new lines are streamed until you send a line; without a name all processes are followed\n"
+	helpText += "  !!process.logfiles name:'<name>' [format:'json']\n"
+	helpText += "  !!process.runs name:'<name>' [format:'json']\n"
+	helpText += "  !!process.schedule name:'<name>' [count:<n>] [format:'json']\n"
+	helpText += "  !!process.send name:'<name>' [input:'<text>'] [newline:false] [eof:true]\n"
+	helpText += "  !!process.export\n"
+	helpText += "  !!process.import file:'<path>' [replace:true] [format:'json']\n"
+	helpText += "  !!process.attach name:'<name>'\n"
+	helpText += "    Your lines are sent to the input of the process and its output is shown until you send !!detach\n"
+	helpText += "  !!process.events [name:'<name>[,<name>...]'] [format:'json']\n"
+	helpText += "    Events like started, exited, crashed and health-failed are streamed until you send a line\n\n"
+
+	// Special commands
+	if interactive {
+		helpText += Bold + ColorBlue + "Special commands:" + ColorReset + "\n"
+	} else {
+		helpText += "Special commands:\n"
+	}
+	if interactive {
+		helpText += "  " + ColorGreen + "!!help" + ColorReset + ", " + ColorGreen + "?" + ColorReset + " or " + ColorGreen + "h" + ColorReset + " - Show this help text\n"
+		helpText += "  " + ColorGreen + "!!interactive" + ColorReset + " or " + ColorGreen + "!!i" + ColorReset + " - Toggle interactive mode with colors\n"
+	} else {
+		helpText += "  !!help, ? or h - Show this help text\n"
+		helpText += "  !!interactive or !!i - Toggle interactive mode with colors\n"
+	}
+	if interactive {
+		helpText += "  " + ColorGreen + "!!exit" + ColorReset + " or " + ColorGreen + "!!quit" + ColorReset + " or " + ColorGreen + "q" + ColorReset + " or " + ColorGreen + "Ctrl+C" + ColorReset + " - Close the connection\n"
+	} else {
+		helpText += "  !!exit or !!quit or q or Ctrl+C - Close the connection\n"
+	}
+	if interactive {
+		helpText += "  " + ColorGreen + "<empty line>" + ColorReset + " - Execute previous command or pending command\n"
+		helpText += "  " + ColorGreen + "Up arrow" + ColorReset + " - Navigate to previous commands\n\n"
+	} else {
+		helpText += "  <empty line> - Execute previous command or pending command\n"
+		helpText += "  Up arrow - Navigate to previous commands\n\n"
+	}
+	if interactive {
+		helpText += ColorCyan + Bold + "**ENDRESULT**" + ColorReset + "\n"
+	} else {
+		helpText += "**ENDRESULT**\n"
+	}
+
+	return helpText
+}
@@ -0,0 +1,54 @@
+package processmanager
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestSocketNetwork(t *testing.T) {
+	tests := []struct {
+		socket  string
+		network string
+		address string
+	}{
+		{"/tmp/processmanager.sock", "unix", "/tmp/processmanager.sock"},
+		{"tcp://127.0.0.1:9021", "tcp", "127.0.0.1:9021"},
+		{"tcp://[::1]:9021", "tcp", "[::1]:9021"},
+	}
+	for _, test := range tests {
+		network, address := socketNetwork(test.socket)
+		if network != test.network || address != test.address {
+			t.Errorf("%s: expected %s %s, got %s %s", test.socket, test.network, test.address, network, address)
+		}
+	}
+}
+
+// The default socket on Windows is a TCP address
+func TestTelnetServerOverTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	socket := "tcp://" + listener.Addr().String()
+	listener.Close()
+
+	pm := NewProcessManager("secret")
+	defer pm.StopAll()
+	server := NewTelnetServer(pm)
+	if err := server.Start(socket); err != nil {
+		t.Fatalf("Failed to start the server on %s: %v", socket, err)
+	}
+	defer server.Stop()
+
+	ctx := context.Background()
+	client := NewClient(socket, "secret")
+	defer client.Close()
+	if err := client.StartProcess(ctx, ProcessDefinition{Name: "sleeper", Command: "sleep 30"}); err != nil {
+		t.Fatalf("Failed to start a process over TCP: %v", err)
+	}
+	processes, err := client.ListProcesses(ctx)
+	if err != nil || len(processes) != 1 || processes[0].Name != "sleeper" {
+		t.Errorf("Expected the process to be listed, got %d processes, %v", len(processes), err)
+	}
+}
@@ -0,0 +1,168 @@
+package redisserver
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/redcon"
+)
+
+// blockingCommands are the commands that wait for a list to get an element
+var blockingCommands = map[string]bool{
+	"blpop": true, "brpop": true, "brpoplpush": true,
+}
+
+// waitKey identifies a list that clients are blocked on
+type waitKey struct {
+	db  int
+	key string
+}
+
+// parseTimeout parses the timeout of a blocking command in seconds, where
+// 0 means waiting forever
+func parseTimeout(arg []byte) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil {
+		return 0, errors.New("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, errors.New("ERR timeout is negative")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// rpoplpush removes the last element of the list src and adds it to the
+// head of the list dst
+func (s *Server) rpoplpush(src, dst string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	val, ok := s.listPop(src, false)
+	if !ok {
+		return "", false
+	}
+	s.listPush(dst, []string{val}, true)
+	return val, true
+}
+
+// signal wakes up the clients blocked on a list. It must be called with the
+// lock held.
+func (s *Server) signal(key string) {
+	for ready := range s.waiters[waitKey{s.index, key}] {
+		select {
+		case ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// waitList calls pop for each of the keys in order until one returns an
+// element. Until then it waits for pushes to the keys, for at most timeout
+// or forever when timeout is 0, and gives up when the client disconnects.
+func (s *Server) waitList(conn redcon.Conn, keys []string, timeout time.Duration, pop func(key string) (string, bool)) (string, string, bool) {
+	// The waiter is registered before the lists are checked so a push in
+	// between is not missed
+	ready := make(chan struct{}, 1)
+	s.mu.Lock()
+	for _, key := range keys {
+		wk := waitKey{s.index, key}
+		if s.waiters[wk] == nil {
+			s.waiters[wk] = make(map[chan struct{}]struct{})
+		}
+		s.waiters[wk][ready] = struct{}{}
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		for _, key := range keys {
+			wk := waitKey{s.index, key}
+			delete(s.waiters[wk], ready)
+			if len(s.waiters[wk]) == 0 {
+				delete(s.waiters, wk)
+			}
+		}
+		s.mu.Unlock()
+	}()
+
+	var closed <-chan struct{}
+	if c, ok := conn.Context().(*client); ok {
+		closed = c.closed
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		// A client that is gone must not take an element
+		select {
+		case <-closed:
+			return "", "", false
+		default:
+		}
+		for _, key := range keys {
+			if val, ok := pop(key); ok {
+				return key, val, true
+			}
+		}
+		select {
+		case <-ready:
+		case <-expired:
+			return "", "", false
+		case <-closed:
+			return "", "", false
+		}
+	}
+}
+
+// detach takes a connection over from redcon the first time it runs a
+// blocking command and returns true; the command is then run by
+// serveDetached. It returns false for a connection that is already
+// detached.
+func (s *Server) detach(conn redcon.Conn, cmd redcon.Command) bool {
+	c, ok := conn.Context().(*client)
+	if !ok || c.closed != nil {
+		return false
+	}
+	c.closed = make(chan struct{})
+	go s.serveDetached(conn.Detach(), c, cmd)
+	return true
+}
+
+// serveDetached serves the commands of a detached connection, starting with
+// the blocking command that detached it. Commands are read in the
+// background so a client that disconnects while it is blocked is noticed.
+func (s *Server) serveDetached(conn redcon.DetachedConn, c *client, cmd redcon.Command) {
+	defer func() {
+		conn.Close()
+		s.monitor.disconnect(conn)
+	}()
+
+	cmds := make(chan redcon.Command)
+	go func() {
+		defer close(c.closed)
+		for {
+			cmd, err := conn.ReadCommand()
+			if err != nil {
+				return
+			}
+			cmds <- cmd
+		}
+	}()
+
+	for {
+		s.handleCommand(conn, cmd)
+		if err := conn.Flush(); err != nil {
+			// Closing the connection stops the reader
+			conn.Close()
+		}
+		select {
+		case cmd = <-cmds:
+		case <-c.closed:
+			return
+		}
+	}
+}
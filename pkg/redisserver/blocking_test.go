@@ -0,0 +1,107 @@
+package redisserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestBLPop(t *testing.T) {
+	_, client := startTestServer(t, ServerConfig{})
+	other := dbClient(t, client, 0)
+	ctx := context.Background()
+
+	// An element that is there already is returned right away
+	client.RPush(ctx, "queue", "first")
+	if v, err := client.BLPop(ctx, time.Second, "empty", "queue").Result(); err != nil || fmt.Sprint(v) != "[queue first]" {
+		t.Errorf("Expected [queue first], got %v, %v", v, err)
+	}
+
+	// A blocked client gets the element pushed by another one
+	result := make(chan []string, 1)
+	go func() {
+		v, _ := client.BLPop(ctx, 5*time.Second, "empty", "queue").Result()
+		result <- v
+	}()
+	time.Sleep(100 * time.Millisecond)
+	other.RPush(ctx, "queue", "second")
+	select {
+	case v := <-result:
+		if fmt.Sprint(v) != "[queue second]" {
+			t.Errorf("Expected [queue second], got %v", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected BLPOP to return after the push")
+	}
+
+	// The connection serves other commands after it blocked
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Errorf("PING after BLPOP failed: %v", err)
+	}
+	other.RPush(ctx, "a", "1", "2")
+	if v, _ := client.BRPop(ctx, time.Second, "a").Result(); fmt.Sprint(v) != "[a 2]" {
+		t.Errorf("Expected BRPOP to take the last element, got %v", v)
+	}
+
+	started := time.Now()
+	if err := client.BLPop(ctx, 200*time.Millisecond, "empty").Err(); err != redis.Nil {
+		t.Errorf("Expected a nil reply on the timeout, got %v", err)
+	}
+	if waited := time.Since(started); waited < 200*time.Millisecond {
+		t.Errorf("Expected BLPOP to wait for the timeout, returned after %v", waited)
+	}
+}
+
+func TestBRPopLPush(t *testing.T) {
+	_, client := startTestServer(t, ServerConfig{})
+	other := dbClient(t, client, 0)
+	ctx := context.Background()
+
+	result := make(chan string, 1)
+	go func() {
+		v, _ := client.BRPopLPush(ctx, "src", "dst", 5*time.Second).Result()
+		result <- v
+	}()
+	time.Sleep(100 * time.Millisecond)
+	other.RPush(ctx, "src", "a", "b")
+	select {
+	case v := <-result:
+		if v != "b" {
+			t.Errorf("Expected b, got %q", v)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected BRPOPLPUSH to return after the push")
+	}
+	if v, _ := other.LRange(ctx, "dst", 0, -1).Result(); fmt.Sprint(v) != "[b]" {
+		t.Errorf("Expected dst to be [b], got %v", v)
+	}
+	if v, _ := other.RPopLPush(ctx, "src", "dst").Result(); v != "a" {
+		t.Errorf("Expected RPOPLPUSH to move a, got %q", v)
+	}
+	if err := other.RPopLPush(ctx, "src", "dst").Err(); err != redis.Nil {
+		t.Errorf("Expected a nil reply for an empty list, got %v", err)
+	}
+}
+
+func TestBLPopDisconnected(t *testing.T) {
+	_, client := startTestServer(t, ServerConfig{})
+	ctx := context.Background()
+
+	options := *client.Options()
+	options.PoolSize = 1
+	waiting := redis.NewClient(&options)
+	go waiting.BLPop(ctx, 0, "queue")
+	time.Sleep(100 * time.Millisecond)
+	waiting.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	// The element stays for the clients that are still connected
+	client.RPush(ctx, "queue", "job")
+	time.Sleep(100 * time.Millisecond)
+	if n, _ := client.LLen(ctx, "queue").Result(); n != 1 {
+		t.Errorf("Expected the element to stay in the list, got length %d", n)
+	}
+}
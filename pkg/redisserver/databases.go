@@ -0,0 +1,69 @@
+package redisserver
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/tidwall/redcon"
+)
+
+// errDBIndex is returned for a database that does not exist
+var errDBIndex = errors.New("ERR DB index is out of range")
+
+// flushdb removes all keys of the database
+func (s *Server) flushdb() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data = make(map[string]*entry)
+	s.logWrite("FLUSHDB")
+}
+
+// flushall removes the keys of all databases
+func (s *Server) flushall() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, db := range s.dbs {
+		db.data = make(map[string]*entry)
+	}
+	s.logWrite("FLUSHALL")
+}
+
+// swapdb swaps the keys of two databases, so connections that selected one
+// see the keys of the other
+func (s *Server) swapdb(a, b int) error {
+	if a < 0 || a >= len(s.dbs) || b < 0 || b >= len(s.dbs) {
+		return errDBIndex
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.dbs[a].data, s.dbs[b].data = s.dbs[b].data, s.dbs[a].data
+	s.logWrite("SWAPDB", strconv.Itoa(a), strconv.Itoa(b))
+	return nil
+}
+
+// selected returns the database selected by a connection. Only the
+// connection itself changes its selection, so it is read without locking.
+func (s *Server) selected(conn redcon.Conn) *Server {
+	if c, ok := conn.Context().(*client); ok {
+		return s.dbs[c.db]
+	}
+	return s
+}
+
+// selectDB makes a connection use another database
+func (s *Server) selectDB(conn redcon.Conn, index int) error {
+	if index < 0 || index >= len(s.dbs) {
+		return errDBIndex
+	}
+	c, ok := conn.Context().(*client)
+	if !ok {
+		return errors.New("ERR unknown client")
+	}
+	s.monitor.mu.Lock()
+	c.db = index
+	s.monitor.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,98 @@
+package redisserver
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// dbClient returns a client of the server that client is connected to,
+// using another database
+func dbClient(t *testing.T, client *redis.Client, db int) *redis.Client {
+	options := *client.Options()
+	options.DB = db
+	other := redis.NewClient(&options)
+	t.Cleanup(func() { other.Close() })
+	return other
+}
+
+func TestSelect(t *testing.T) {
+	server, client := startTestServer(t, ServerConfig{Databases: 4})
+	db1 := dbClient(t, client, 1)
+	ctx := context.Background()
+
+	client.Set(ctx, "key", "db0", 0)
+	db1.Set(ctx, "key", "db1", 0)
+	db1.Set(ctx, "other", "db1", 0)
+	if v, _ := client.Get(ctx, "key").Result(); v != "db0" {
+		t.Errorf("Expected db0 in database 0, got %q", v)
+	}
+	if v, _ := db1.Get(ctx, "key").Result(); v != "db1" {
+		t.Errorf("Expected db1 in database 1, got %q", v)
+	}
+	if n, _ := db1.DBSize(ctx).Result(); n != 2 {
+		t.Errorf("Expected 2 keys in database 1, got %d", n)
+	}
+	if err := client.Do(ctx, "SELECT", "4").Err(); err == nil {
+		t.Error("Expected an error for a database out of range")
+	}
+
+	// Data stored through the API of another database is seen by clients
+	db2, err := server.DB(2)
+	if err != nil {
+		t.Fatalf("DB failed: %v", err)
+	}
+	db2.Set("api", "db2", 0)
+	if v, _ := dbClient(t, client, 2).Get(ctx, "api").Result(); v != "db2" {
+		t.Errorf("Expected the key set through the API, got %q", v)
+	}
+
+	if err := client.Do(ctx, "SWAPDB", 0, 1).Err(); err != nil {
+		t.Fatalf("SWAPDB failed: %v", err)
+	}
+	if v, _ := client.Get(ctx, "key").Result(); v != "db1" {
+		t.Errorf("Expected database 0 to have the keys of database 1, got %q", v)
+	}
+
+	if err := client.FlushDB(ctx).Err(); err != nil {
+		t.Fatalf("FLUSHDB failed: %v", err)
+	}
+	if n, _ := client.DBSize(ctx).Result(); n != 0 {
+		t.Errorf("Expected database 0 to be empty, got %d keys", n)
+	}
+	if n, _ := db1.DBSize(ctx).Result(); n != 1 {
+		t.Errorf("Expected FLUSHDB to leave database 1 alone, got %d keys", n)
+	}
+	client.FlushAll(ctx)
+	if n, _ := db1.DBSize(ctx).Result(); n != 0 {
+		t.Errorf("Expected FLUSHALL to empty database 1, got %d keys", n)
+	}
+}
+
+func TestSelectAOF(t *testing.T) {
+	aofPath := filepath.Join(t.TempDir(), "appendonly.aof")
+	config := ServerConfig{AOFPath: aofPath, AOFSync: FsyncAlways}
+	ctx := context.Background()
+
+	_, client := startTestServer(t, config)
+	db3 := dbClient(t, client, 3)
+	client.Set(ctx, "a", "db0", 0)
+	db3.Set(ctx, "a", "db3", 0)
+	client.Set(ctx, "b", "db0", 0)
+	client.Do(ctx, "SWAPDB", "3", "5")
+
+	for i := 0; i < 2; i++ {
+		_, client = startTestServer(t, config)
+		if v, _ := client.Get(ctx, "b").Result(); v != "db0" {
+			t.Errorf("Expected b in database 0, got %q", v)
+		}
+		if v, _ := dbClient(t, client, 5).Get(ctx, "a").Result(); v != "db3" {
+			t.Errorf("Expected a in database 5, got %q", v)
+		}
+		if n, _ := dbClient(t, client, 3).DBSize(ctx).Result(); n != 0 {
+			t.Errorf("Expected database 3 to be empty, got %d keys", n)
+		}
+	}
+}
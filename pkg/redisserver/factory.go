@@ -3,6 +3,8 @@ package redisserver
 import (
 	"sync"
 	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/system/stats/metrics"
 )
 
 // entry represents a stored value. For strings, value is stored as a string.
@@ -12,16 +14,59 @@ type entry struct {
 	expiration time.Time // zero means no expiration
 }
 
+// DefaultDatabases is the number of logical databases when
+// ServerConfig.Databases is not set
+const DefaultDatabases = 16
+
 // Server holds the in-memory datastore and provides thread-safe access.
 // It implements a Redis-compatible server using redcon.
+//
+// A Server is one of the logical databases selected with SELECT. The
+// databases of a server share its lock, persistence and statistics;
+// NewServer returns database 0.
 type Server struct {
-	mu   sync.RWMutex
-	data map[string]*entry
+	*shared
+	index int
+	data  map[string]*entry
+}
+
+// shared is the state of a server shared by its databases
+type shared struct {
+	mu  sync.RWMutex
+	dbs []*Server
+	// persist is nil unless a snapshot or append-only file is configured
+	persist *persistence
+	// loading is set while the saved data is loaded
+	loading bool
+	monitor *monitor
+	// waiters are the clients blocked on a list, woken up by a push
+	waiters map[waitKey]map[chan struct{}]struct{}
 }
 
+// ServerConfig configures the addresses of the server and, optionally,
+// where its data is saved. With SnapshotPath set, the data is written to a
+// binary snapshot every SnapshotInterval. With AOFPath set, every write
+// command is appended to a file that is synced according to AOFSync.
+// Saved data is loaded when the server starts.
+//
+// Commands taking SlowlogThreshold or longer are kept in the slow log,
+// which holds SlowlogMaxLen entries; a negative threshold disables it. With
+// Metrics set, the numbers reported by INFO are also kept in that registry,
+// with names starting with "redisserver_".
 type ServerConfig struct {
 	TCPPort        string
 	UnixSocketPath string
+	// Databases is the number of logical databases, 16 by default
+	Databases int
+
+	SnapshotPath     string
+	SnapshotInterval time.Duration
+	AOFPath          string
+	AOFSync          FsyncPolicy
+
+	SlowlogThreshold time.Duration
+	SlowlogMaxLen    int
+	Metrics          *metrics.Registry
 }
 
 // NewCustomServer creates a new server instance with custom TCP port and Unix socket path.
@@ -32,9 +77,16 @@ func NewServer(config ServerConfig) *Server {
 		config.UnixSocketPath = "/tmp/redis.sock"
 	}
 
-	s := &Server{
-		data: make(map[string]*entry),
+	if config.Databases <= 0 {
+		config.Databases = DefaultDatabases
 	}
+
+	sh := &shared{monitor: newMonitor(config), waiters: make(map[waitKey]map[chan struct{}]struct{})}
+	for i := 0; i < config.Databases; i++ {
+		sh.dbs = append(sh.dbs, &Server{shared: sh, index: i, data: make(map[string]*entry)})
+	}
+	s := sh.dbs[0]
+	s.startPersistence(config)
 	go s.cleanupExpiredKeys()
 
 	// Start TCP server if port is provided
@@ -51,18 +103,33 @@ func NewServer(config ServerConfig) *Server {
 	return s
 }
 
+// DB returns the logical database with the given index
+func (s *Server) DB(index int) (*Server, error) {
+	if index < 0 || index >= len(s.dbs) {
+		return nil, errDBIndex
+	}
+	return s.dbs[index], nil
+}
+
 // cleanupExpiredKeys periodically removes expired keys.
 func (s *Server) cleanupExpiredKeys() {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 	for range ticker.C {
 		now := time.Now()
+		expired := 0
 		s.mu.Lock()
-		for k, ent := range s.data {
-			if !ent.expiration.IsZero() && now.After(ent.expiration) {
-				delete(s.data, k)
+		for _, db := range s.dbs {
+			for k, ent := range db.data {
+				if !ent.expiration.IsZero() && now.After(ent.expiration) {
+					delete(db.data, k)
+					db.logWrite("DEL", k)
+					expired++
+				}
 			}
 		}
 		s.mu.Unlock()
+		s.monitor.expire(expired)
+		s.updateMetrics()
 	}
 }
@@ -0,0 +1,89 @@
+package redisserver
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestHashCommands(t *testing.T) {
+	server, client := startTestServer(t, ServerConfig{})
+	ctx := context.Background()
+
+	if n, err := client.HSet(ctx, "user", "name", "alice", "email", "alice@example.com").Result(); err != nil || n != 2 {
+		t.Errorf("Expected HSET to add 2 fields, got %d, %v", n, err)
+	}
+	if err := client.HMSet(ctx, "user", "name", "bob", "age", "30").Err(); err != nil {
+		t.Fatalf("HMSET failed: %v", err)
+	}
+
+	var user struct {
+		Name  string `redis:"name"`
+		Email string `redis:"email"`
+		Age   int    `redis:"age"`
+	}
+	if err := client.HGetAll(ctx, "user").Scan(&user); err != nil {
+		t.Fatalf("HGETALL failed: %v", err)
+	}
+	if user.Name != "bob" || user.Email != "alice@example.com" || user.Age != 30 {
+		t.Errorf("Unexpected record %+v", user)
+	}
+	if hash, _ := client.HGetAll(ctx, "missing").Result(); len(hash) != 0 {
+		t.Errorf("Expected an empty hash for a missing key, got %v", hash)
+	}
+
+	values, err := client.HMGet(ctx, "user", "name", "missing", "age").Result()
+	if err != nil || fmt.Sprint(values) != "[bob <nil> 30]" {
+		t.Errorf("Expected [bob <nil> 30], got %v, %v", values, err)
+	}
+	vals, _ := client.HVals(ctx, "user").Result()
+	sort.Strings(vals)
+	if fmt.Sprint(vals) != "[30 alice@example.com bob]" {
+		t.Errorf("Unexpected HVALS %v", vals)
+	}
+
+	if ok, _ := client.HExists(ctx, "user", "email").Result(); !ok {
+		t.Error("Expected the email field to exist")
+	}
+	if ok, _ := client.HExists(ctx, "user", "phone").Result(); ok {
+		t.Error("Expected the phone field not to exist")
+	}
+
+	if n, _ := client.HIncrBy(ctx, "user", "age", 5).Result(); n != 35 {
+		t.Errorf("Expected age 35, got %d", n)
+	}
+	if n, _ := client.HIncrBy(ctx, "user", "visits", -2).Result(); n != -2 {
+		t.Errorf("Expected a new field to start at 0, got %d", n)
+	}
+	if err := client.HIncrBy(ctx, "user", "name", 1).Err(); err == nil {
+		t.Error("Expected HINCRBY to fail for a value that is not an integer")
+	}
+
+	// The API returns copies that the caller may change
+	hash := server.HGetAll("user")
+	hash["name"] = "changed"
+	if v, _ := server.HGet("user", "name"); v != "bob" {
+		t.Errorf("Expected the stored hash to be unchanged, got %q", v)
+	}
+	server.HMSet("api", map[string]string{"a": "1", "b": "2"})
+	if _, found := server.HMGet("api", []string{"a", "c"}); fmt.Sprint(found) != "[true false]" {
+		t.Errorf("Expected [true false], got %v", found)
+	}
+}
+
+func TestHashAOF(t *testing.T) {
+	aofPath := filepath.Join(t.TempDir(), "appendonly.aof")
+	config := ServerConfig{AOFPath: aofPath, AOFSync: FsyncAlways}
+	ctx := context.Background()
+
+	_, client := startTestServer(t, config)
+	client.HMSet(ctx, "hash", "a", "1", "b", "2")
+	client.HIncrBy(ctx, "hash", "a", 10)
+
+	_, client = startTestServer(t, config)
+	if hash, _ := client.HGetAll(ctx, "hash").Result(); fmt.Sprint(hash) != "map[a:11 b:2]" {
+		t.Errorf("Expected map[a:11 b:2], got %v", hash)
+	}
+}
@@ -3,10 +3,8 @@ package redisserver
 import (
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
+	"math"
 	"regexp"
-	"runtime"
 	"sort"
 	"strconv"
 	"time"
@@ -29,6 +27,15 @@ func (s *Server) set(key string, value interface{}, duration time.Duration) {
 		value:      value,
 		expiration: exp,
 	}
+
+	if str, ok := value.(string); ok {
+		s.logWrite("SET", key, str)
+		if !exp.IsZero() {
+			s.logWrite("PEXPIREAT", key, strconv.FormatInt(exp.UnixMilli(), 10))
+		}
+	} else {
+		log.Printf("Not persisting key %s of type %T", key, value)
+	}
 }
 
 // Get retrieves the value for a key if it exists and is not expired.
@@ -47,7 +54,11 @@ func (s *Server) get(key string) (interface{}, bool) {
 	if !ent.expiration.IsZero() && time.Now().After(ent.expiration) {
 		// Key has expired; remove it.
 		s.mu.Lock()
-		delete(s.data, key)
+		if ent, ok := s.data[key]; ok && s.expired(ent) {
+			delete(s.data, key)
+			s.logWrite("DEL", key)
+			s.monitor.expire(1)
+		}
 		s.mu.Unlock()
 		return nil, false
 	}
@@ -65,6 +76,7 @@ func (s *Server) del(key string) int {
 	defer s.mu.Unlock()
 	if _, ok := s.data[key]; ok {
 		delete(s.data, key)
+		s.logWrite("DEL", key)
 		return 1
 	}
 	return 0
@@ -183,14 +195,52 @@ func (s *Server) HSet(key, field, value string) int {
 
 // hset is the internal implementation of HSet
 func (s *Server) hset(key, field, value string) int {
+	return s.hmset(key, []string{field, value})
+}
+
+// HMSet sets the fields of the hash stored at key. It returns the number of
+// fields that are new.
+func (s *Server) HMSet(key string, fields map[string]string) int {
+	// Sorted, so the append-only file is the same for the same fields
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, 2*len(names))
+	for _, field := range names {
+		pairs = append(pairs, field, fields[field])
+	}
+	return s.hmset(key, pairs)
+}
+
+// hmset sets the fields of a hash given as field, value pairs
+func (s *Server) hmset(key string, pairs []string) int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	hash := s.writableHash(key)
+	added := 0
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if _, fieldExists := hash[pairs[i]]; !fieldExists {
+			added++
+		}
+		hash[pairs[i]] = pairs[i+1]
+	}
+	s.logWrite(append([]string{"HSET", key}, pairs...)...)
+	return added
+}
+
+// writableHash returns the hash stored at key to change it, replacing an
+// expired key or a value of another type by a new hash. It must be called
+// with the lock held.
+func (s *Server) writableHash(key string) map[string]string {
 	// Check if key exists and is not expired
 	ent, exists := s.data[key]
-	if exists && (!ent.expiration.IsZero() && time.Now().After(ent.expiration)) {
+	if exists && s.expired(ent) {
 		// Key exists but has expired, delete it
 		delete(s.data, key)
+		s.logWrite("DEL", key)
 		exists = false
 	}
 
@@ -212,16 +262,7 @@ func (s *Server) hset(key, field, value string) int {
 		hash = make(map[string]string)
 		s.data[key] = &entry{value: hash}
 	}
-
-	// Set the field in the hash
-	_, fieldExists := hash[field]
-	hash[field] = value
-
-	// Return 1 if field was added, 0 if it was updated
-	if fieldExists {
-		return 0
-	}
-	return 1
+	return hash
 }
 
 // HGet retrieves the value of a field in the hash stored at key.
@@ -247,18 +288,28 @@ func (s *Server) HDel(key string, fields []string) int {
 
 // hdel is the internal implementation of HDel
 func (s *Server) hdel(key string, fields []string) int {
-	hash, ok := s.getHash(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ent, exists := s.data[key]
+	if !exists || s.expired(ent) {
+		return 0
+	}
+	hash, ok := ent.value.(map[string]string)
 	if !ok {
 		return 0
 	}
-	count := 0
+	removed := []string{"HDEL", key}
 	for _, field := range fields {
 		if _, exists := hash[field]; exists {
 			delete(hash, field)
-			count++
+			removed = append(removed, field)
 		}
 	}
-	return count
+	if len(removed) > 2 {
+		s.logWrite(removed...)
+	}
+	return len(removed) - 2
 }
 
 // HKeys returns all field names in the hash stored at key.
@@ -293,6 +344,124 @@ func (s *Server) hlen(key string) int {
 	return len(hash)
 }
 
+// HGetAll returns a copy of the hash stored at key, which is empty when
+// the key does not exist.
+func (s *Server) HGetAll(key string) map[string]string {
+	return s.hgetall(key)
+}
+
+// hgetall is the internal implementation of HGetAll
+func (s *Server) hgetall(key string) map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]string)
+	if hash, ok := s.readableHash(key); ok {
+		for field, value := range hash {
+			result[field] = value
+		}
+	}
+	return result
+}
+
+// HMGet returns the values of fields in the hash stored at key, with found
+// telling for each field whether it exists.
+func (s *Server) HMGet(key string, fields []string) (values []string, found []bool) {
+	return s.hmget(key, fields)
+}
+
+// hmget is the internal implementation of HMGet
+func (s *Server) hmget(key string, fields []string) ([]string, []bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	values := make([]string, len(fields))
+	found := make([]bool, len(fields))
+	hash, _ := s.readableHash(key)
+	for i, field := range fields {
+		values[i], found[i] = hash[field]
+	}
+	return values, found
+}
+
+// HVals returns all values in the hash stored at key.
+func (s *Server) HVals(key string) []string {
+	return s.hvals(key)
+}
+
+// hvals is the internal implementation of HVals
+func (s *Server) hvals(key string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hash, _ := s.readableHash(key)
+	values := make([]string, 0, len(hash))
+	for _, value := range hash {
+		values = append(values, value)
+	}
+	return values
+}
+
+// HExists reports whether a field exists in the hash stored at key.
+func (s *Server) HExists(key, field string) bool {
+	return s.hexists(key, field)
+}
+
+// hexists is the internal implementation of HExists
+func (s *Server) hexists(key, field string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hash, _ := s.readableHash(key)
+	_, exists := hash[field]
+	return exists
+}
+
+// HIncrBy increments the integer value of a field in the hash stored at key
+// by increment. A field that does not exist is set to 0 first.
+func (s *Server) HIncrBy(key, field string, increment int64) (int64, error) {
+	return s.hincrby(key, field, increment)
+}
+
+// hincrby is the internal implementation of HIncrBy
+func (s *Server) hincrby(key, field string, increment int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A key of another type is replaced, like HSET does
+	var current int64
+	if hash, ok := s.readableHash(key); ok {
+		if v, exists := hash[field]; exists {
+			var err error
+			current, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("hash value is not an integer")
+			}
+		}
+	}
+	if (increment > 0 && current > math.MaxInt64-increment) || (increment < 0 && current < math.MinInt64-increment) {
+		return 0, fmt.Errorf("increment or decrement would overflow")
+	}
+	current += increment
+
+	value := strconv.FormatInt(current, 10)
+	s.writableHash(key)[field] = value
+	// The result is logged rather than the increment, like for INCR
+	s.logWrite("HSET", key, field, value)
+	return current, nil
+}
+
+// readableHash returns the hash stored at key if it exists, has not expired
+// and is a hash. It must be called with the lock held.
+func (s *Server) readableHash(key string) (map[string]string, bool) {
+	ent, exists := s.data[key]
+	if !exists || s.expired(ent) {
+		return nil, false
+	}
+	hash, ok := ent.value.(map[string]string)
+	return hash, ok
+}
+
 // Incr increments the integer value stored at key by one.
 // If the key does not exist, it is set to 0 before performing the operation.
 func (s *Server) Incr(key string) (int64, error) {
@@ -306,7 +475,7 @@ func (s *Server) incr(key string) (int64, error) {
 	var current int64
 	ent, exists := s.data[key]
 	if exists {
-		if !ent.expiration.IsZero() && time.Now().After(ent.expiration) {
+		if s.expired(ent) {
 			current = 0
 		} else {
 			switch v := ent.value.(type) {
@@ -330,12 +499,20 @@ func (s *Server) incr(key string) (int64, error) {
 	s.data[key] = &entry{
 		value: strconv.FormatInt(current, 10),
 	}
+	// The result is logged rather than the increment, which depends on
+	// whether the key had expired
+	s.logWrite("SET", key, strconv.FormatInt(current, 10))
 	return current, nil
 }
 
 // startRedisServer starts a Redis-compatible server on port 6378.
 // expire sets an expiration time for a key
 func (s *Server) expire(key string, duration time.Duration) bool {
+	return s.expireAt(key, time.Now().Add(duration))
+}
+
+// expireAt sets the time a key expires at
+func (s *Server) expireAt(key string, at time.Time) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -345,7 +522,8 @@ func (s *Server) expire(key string, duration time.Duration) bool {
 	}
 
 	// Set expiration time
-	item.expiration = time.Now().Add(duration)
+	item.expiration = at
+	s.logWrite("PEXPIREAT", key, strconv.FormatInt(at.UnixMilli(), 10))
 	return true
 }
 
@@ -375,142 +553,12 @@ func (s *Server) getTTL(key string) int64 {
 	return ttl
 }
 
-// scan returns a list of keys matching the pattern starting from cursor
-func (s *Server) scan(cursor int, pattern string, count int) (int, []string) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	// Get all keys
-	allKeys := make([]string, 0, len(s.data))
-	for k, item := range s.data {
-		// Skip expired keys
-		if !item.expiration.IsZero() && time.Now().After(item.expiration) {
-			continue
-		}
-
-		// Check if key matches pattern
-		if matched, _ := filepath.Match(pattern, k); matched {
-			allKeys = append(allKeys, k)
-		}
-	}
-
-	// Sort keys for consistent results
-	sort.Strings(allKeys)
-
-	// If cursor is beyond the end or there are no keys, return empty list
-	if cursor >= len(allKeys) || len(allKeys) == 0 {
-		return 0, []string{}
-	}
-
-	// Calculate end index
-	end := cursor + count
-	if end > len(allKeys) {
-		end = len(allKeys)
-	}
-
-	// Get keys for this iteration
-	keys := allKeys[cursor:end]
-
-	// Calculate next cursor
-	nextCursor := 0
-	if end < len(allKeys) {
-		nextCursor = end
-	}
-
-	return nextCursor, keys
-}
-
-// hscan iterates over fields in a hash that match a pattern
-func (s *Server) hscan(key string, cursor int, pattern string, count int) (int, []string, []string) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	// Get the hash
-	hash, ok := s.getHash(key)
-	if !ok {
-		return 0, []string{}, []string{}
-	}
-
-	// Get all fields
-	allFields := make([]string, 0, len(hash))
-	for field := range hash {
-		// Check if field matches pattern
-		if matched, _ := filepath.Match(pattern, field); matched {
-			allFields = append(allFields, field)
-		}
-	}
-
-	// Sort fields for consistent results
-	sort.Strings(allFields)
-
-	// If cursor is beyond the end or there are no fields, return empty lists
-	if cursor >= len(allFields) || len(allFields) == 0 {
-		return 0, []string{}, []string{}
-	}
-
-	// Calculate end index
-	end := cursor + count
-	if end > len(allFields) {
-		end = len(allFields)
-	}
-
-	// Get fields for this iteration
-	fields := allFields[cursor:end]
-
-	// Get corresponding values
-	values := make([]string, len(fields))
-	for i, field := range fields {
-		values[i] = hash[field]
-	}
-
-	// Calculate next cursor
-	nextCursor := 0
-	if end < len(allFields) {
-		nextCursor = end
-	}
-
-	return nextCursor, fields, values
-}
-
 // lpush adds one or more values to the head of a list
 func (s *Server) lpush(key string, values []string) int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Check if key exists and is not expired
-	ent, exists := s.data[key]
-	if exists && (!ent.expiration.IsZero() && time.Now().After(ent.expiration)) {
-		// Key exists but has expired, delete it
-		delete(s.data, key)
-		exists = false
-	}
-
-	var list []string
-	if exists {
-		// Try to cast to []string
-		if l, ok := ent.value.([]string); ok {
-			// Key exists and is a list
-			list = l
-		} else {
-			// Key exists but is not a list, overwrite it
-			list = []string{}
-			s.data[key] = &entry{value: list, expiration: ent.expiration}
-		}
-	} else {
-		// Key doesn't exist, create a new list
-		list = []string{}
-		s.data[key] = &entry{value: list}
-	}
-
-	// Add values to the head of the list
-	newList := make([]string, len(values)+len(list))
-	copy(newList, values)
-	copy(newList[len(values):], list)
-
-	// Update the list in the data store
-	s.data[key].value = newList
-
-	return len(newList)
+	return s.listPush(key, values, true)
 }
 
 // rpush adds one or more values to the tail of a list
@@ -518,11 +566,18 @@ func (s *Server) rpush(key string, values []string) int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.listPush(key, values, false)
+}
+
+// listPush adds values to the head or the tail of a list and wakes up the
+// clients blocked on it. It must be called with the lock held.
+func (s *Server) listPush(key string, values []string, head bool) int {
 	// Check if key exists and is not expired
 	ent, exists := s.data[key]
-	if exists && (!ent.expiration.IsZero() && time.Now().After(ent.expiration)) {
+	if exists && s.expired(ent) {
 		// Key exists but has expired, delete it
 		delete(s.data, key)
+		s.logWrite("DEL", key)
 		exists = false
 	}
 
@@ -543,11 +598,22 @@ func (s *Server) rpush(key string, values []string) int {
 		s.data[key] = &entry{value: list}
 	}
 
-	// Add values to the tail of the list
-	newList := append(list, values...)
+	var newList []string
+	if head {
+		// Add values to the head of the list
+		newList = make([]string, len(values)+len(list))
+		copy(newList, values)
+		copy(newList[len(values):], list)
+		s.logWrite(append([]string{"LPUSH", key}, values...)...)
+	} else {
+		// Add values to the tail of the list
+		newList = append(list, values...)
+		s.logWrite(append([]string{"RPUSH", key}, values...)...)
+	}
 
 	// Update the list in the data store
 	s.data[key].value = newList
+	s.signal(key)
 
 	return len(newList)
 }
@@ -557,33 +623,7 @@ func (s *Server) lpop(key string) (string, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Check if key exists and is not expired
-	ent, exists := s.data[key]
-	if !exists || (!ent.expiration.IsZero() && time.Now().After(ent.expiration)) {
-		// Key doesn't exist or has expired
-		if exists {
-			delete(s.data, key)
-		}
-		return "", false
-	}
-
-	// Try to cast to []string
-	list, ok := ent.value.([]string)
-	if !ok || len(list) == 0 {
-		return "", false
-	}
-
-	// Get the first element
-	val := list[0]
-
-	// Remove the first element from the list
-	if len(list) == 1 {
-		delete(s.data, key)
-	} else {
-		s.data[key].value = list[1:]
-	}
-
-	return val, true
+	return s.listPop(key, true)
 }
 
 // rpop removes and returns the last element of a list
@@ -591,12 +631,19 @@ func (s *Server) rpop(key string) (string, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.listPop(key, false)
+}
+
+// listPop removes and returns the first or the last element of a list. It
+// must be called with the lock held.
+func (s *Server) listPop(key string, head bool) (string, bool) {
 	// Check if key exists and is not expired
 	ent, exists := s.data[key]
-	if !exists || (!ent.expiration.IsZero() && time.Now().After(ent.expiration)) {
+	if !exists || s.expired(ent) {
 		// Key doesn't exist or has expired
 		if exists {
 			delete(s.data, key)
+			s.logWrite("DEL", key)
 		}
 		return "", false
 	}
@@ -607,14 +654,20 @@ func (s *Server) rpop(key string) (string, bool) {
 		return "", false
 	}
 
-	// Get the last element
-	val := list[len(list)-1]
-
-	// Remove the last element from the list
-	if len(list) == 1 {
+	var val string
+	if head {
+		// Remove the first element from the list
+		val, list = list[0], list[1:]
+		s.logWrite("LPOP", key)
+	} else {
+		// Remove the last element from the list
+		val, list = list[len(list)-1], list[:len(list)-1]
+		s.logWrite("RPOP", key)
+	}
+	if len(list) == 0 {
 		delete(s.data, key)
 	} else {
-		s.data[key].value = list[:len(list)-1]
+		s.data[key].value = list
 	}
 
 	return val, true
@@ -681,6 +734,135 @@ func (s *Server) lrange(key string, start, stop int) []string {
 	return list[start : stop+1]
 }
 
+// sadd adds one or more members to a set and returns the number of members
+// that were not in it yet
+func (s *Server) sadd(key string, members []string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Check if key exists and is not expired
+	ent, exists := s.data[key]
+	if exists && s.expired(ent) {
+		// Key exists but has expired, delete it
+		delete(s.data, key)
+		s.logWrite("DEL", key)
+		exists = false
+	}
+
+	var set map[string]struct{}
+	if exists {
+		if v, ok := ent.value.(map[string]struct{}); ok {
+			// Key exists and is a set
+			set = v
+		} else {
+			// Key exists but is not a set, overwrite it
+			set = make(map[string]struct{})
+			s.data[key] = &entry{value: set, expiration: ent.expiration}
+		}
+	} else {
+		// Key doesn't exist, create a new set
+		set = make(map[string]struct{})
+		s.data[key] = &entry{value: set}
+	}
+
+	added := []string{"SADD", key}
+	for _, member := range members {
+		if _, ok := set[member]; !ok {
+			set[member] = struct{}{}
+			added = append(added, member)
+		}
+	}
+	if len(added) > 2 {
+		s.logWrite(added...)
+	}
+	return len(added) - 2
+}
+
+// srem removes one or more members from a set and returns the number of
+// members that were removed
+func (s *Server) srem(key string, members []string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ent, exists := s.data[key]
+	if !exists || s.expired(ent) {
+		return 0
+	}
+	set, ok := ent.value.(map[string]struct{})
+	if !ok {
+		return 0
+	}
+
+	removed := []string{"SREM", key}
+	for _, member := range members {
+		if _, ok := set[member]; ok {
+			delete(set, member)
+			removed = append(removed, member)
+		}
+	}
+	if len(removed) > 2 {
+		s.logWrite(removed...)
+	}
+	// Like Redis, an empty set is removed
+	if len(set) == 0 {
+		delete(s.data, key)
+	}
+	return len(removed) - 2
+}
+
+// smembers returns the members of a set
+func (s *Server) smembers(key string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ent, exists := s.data[key]
+	if !exists || (!ent.expiration.IsZero() && time.Now().After(ent.expiration)) {
+		return []string{}
+	}
+	set, ok := ent.value.(map[string]struct{})
+	if !ok {
+		return []string{}
+	}
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	return members
+}
+
+// sismember returns whether a member is in a set
+func (s *Server) sismember(key, member string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ent, exists := s.data[key]
+	if !exists || (!ent.expiration.IsZero() && time.Now().After(ent.expiration)) {
+		return false
+	}
+	set, ok := ent.value.(map[string]struct{})
+	if !ok {
+		return false
+	}
+	_, ok = set[member]
+	return ok
+}
+
+// scard returns the number of members of a set
+func (s *Server) scard(key string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ent, exists := s.data[key]
+	if !exists || (!ent.expiration.IsZero() && time.Now().After(ent.expiration)) {
+		return 0
+	}
+	set, ok := ent.value.(map[string]struct{})
+	if !ok {
+		return 0
+	}
+	return len(set)
+}
+
 // getType returns the type of the value stored at key
 func (s *Server) getType(key string) string {
 	s.mu.RLock()
@@ -692,7 +874,17 @@ func (s *Server) getType(key string) string {
 		return "none"
 	}
 
-	switch v := item.value.(type) {
+	keyType := typeName(item.value)
+	if keyType == "none" {
+		// For debugging
+		log.Printf("Unknown type for key %s: %T", key, item.value)
+	}
+	return keyType
+}
+
+// typeName returns the Redis type of a stored value
+func typeName(value interface{}) string {
+	switch value.(type) {
 	case string:
 		return "string"
 	case map[string]string:
@@ -701,46 +893,13 @@ func (s *Server) getType(key string) string {
 		return "hash"
 	case []string:
 		return "list"
+	case map[string]struct{}:
+		return "set"
 	default:
-		// For debugging
-		log.Printf("Unknown type for key %s: %T", key, v)
 		return "none"
 	}
 }
 
-// getInfo returns information about the server for the INFO command
-func (s *Server) getInfo() string {
-	s.mu.RLock()
-	keyCount := len(s.data)
-	s.mu.RUnlock()
-
-	// Build the info string in Redis format
-	info := "# Server\r\n"
-	info += "redis_version:6.2.0\r\n"
-	info += "redis_mode:standalone\r\n"
-	info += "os:" + runtime.GOOS + "\r\n"
-	info += "arch_bits:" + strconv.Itoa(32<<(^uint(0)>>63)) + "\r\n"
-	info += "process_id:" + strconv.Itoa(os.Getpid()) + "\r\n"
-
-	info += "\r\n# Clients\r\n"
-	info += "connected_clients:1\r\n"
-
-	info += "\r\n# Memory\r\n"
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
-	info += "used_memory:" + strconv.FormatUint(m.Alloc, 10) + "\r\n"
-	info += "used_memory_human:" + humanizeBytes(m.Alloc) + "\r\n"
-
-	info += "\r\n# Stats\r\n"
-	info += "keyspace_hits:0\r\n"
-	info += "keyspace_misses:0\r\n"
-
-	info += "\r\n# Keyspace\r\n"
-	info += "db0:keys=" + strconv.Itoa(keyCount) + ",expires=0,avg_ttl=0\r\n"
-
-	return info
-}
-
 // exists checks if a key exists in the database
 func (s *Server) exists(keys []string) int {
 	s.mu.RLock()
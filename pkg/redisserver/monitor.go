@@ -0,0 +1,539 @@
+package redisserver
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/system/stats/metrics"
+	"github.com/tidwall/redcon"
+)
+
+// DefaultSlowlogThreshold is the duration from which a command is logged in
+// the slow log when ServerConfig.SlowlogThreshold is not set
+const DefaultSlowlogThreshold = 10 * time.Millisecond
+
+// DefaultSlowlogMaxLen is the number of entries the slow log keeps when
+// ServerConfig.SlowlogMaxLen is not set
+const DefaultSlowlogMaxLen = 128
+
+// Like Redis, the slow log keeps at most slowlogMaxArgs arguments of a
+// command and slowlogMaxArgLen bytes of each
+const (
+	slowlogMaxArgs   = 32
+	slowlogMaxArgLen = 128
+)
+
+// knownCommands are the commands counted by name in the metrics; others
+// are counted as "unknown" so clients cannot create unbounded label values
+var knownCommands = map[string]bool{
+	"ping": true, "set": true, "get": true, "del": true, "keys": true, "exists": true,
+	"type": true, "ttl": true, "expire": true, "incr": true, "info": true, "dbsize": true,
+	"hset": true, "hget": true, "hdel": true, "hkeys": true, "hlen": true,
+	"hmset": true, "hgetall": true, "hmget": true, "hvals": true, "hexists": true, "hincrby": true,
+	"lpush": true, "rpush": true, "lpop": true, "rpop": true, "llen": true, "lrange": true,
+	"sadd": true, "srem": true, "smembers": true, "sismember": true, "scard": true,
+	"scan": true, "hscan": true, "sscan": true, "client": true, "slowlog": true,
+	"select": true, "swapdb": true, "flushdb": true, "flushall": true,
+	"blpop": true, "brpop": true, "brpoplpush": true, "rpoplpush": true,
+}
+
+// Stats are the numbers reported by INFO
+type Stats struct {
+	UptimeSeconds        int64  `json:"uptime_seconds"`
+	ConnectedClients     int    `json:"connected_clients"`
+	TotalConnections     int64  `json:"total_connections_received"`
+	TotalCommands        int64  `json:"total_commands_processed"`
+	KeyspaceHits         int64  `json:"keyspace_hits"`
+	KeyspaceMisses       int64  `json:"keyspace_misses"`
+	ExpiredKeys          int64  `json:"expired_keys"`
+	Keys                 int    `json:"keys"`
+	UsedMemory           uint64 `json:"used_memory"`
+	SlowlogLen           int    `json:"slowlog_len"`
+	AOFEnabled           bool   `json:"aof_enabled"`
+	SnapshotEnabled      bool   `json:"snapshot_enabled"`
+	ChangesSinceLastSave uint64 `json:"changes_since_last_save"`
+	// Databases has the keyspace numbers of the databases that have keys
+	Databases []DatabaseStats `json:"databases"`
+}
+
+// DatabaseStats are the keyspace numbers of a logical database
+type DatabaseStats struct {
+	Index                  int   `json:"index"`
+	Keys                   int   `json:"keys"`
+	KeysWithExpiration     int   `json:"expires"`
+	AverageTTLMilliseconds int64 `json:"avg_ttl_ms"`
+}
+
+// monitor tracks the clients, counters and slow log of a server
+type monitor struct {
+	started          time.Time
+	slowlogThreshold time.Duration
+	slowlogMaxLen    int
+
+	connections atomic.Int64
+	commands    atomic.Int64
+	hits        atomic.Int64
+	misses      atomic.Int64
+	expired     atomic.Int64
+
+	mu          sync.Mutex
+	clients     map[int64]*client
+	lastID      int64
+	slowlog     []slowlogEntry
+	lastSlowlog int64
+
+	metrics *serverMetrics
+}
+
+// client is a connection to the server
+type client struct {
+	id          int64
+	addr        string
+	created     time.Time
+	name        string
+	db          int
+	lastCommand string
+	lastActive  time.Time
+	// closed is set when the connection is detached from redcon to run
+	// blocking commands and is closed when the connection is
+	closed chan struct{}
+}
+
+// slowlogEntry is a command that took longer than the slow log threshold
+type slowlogEntry struct {
+	id         int64
+	time       time.Time
+	duration   time.Duration
+	args       []string
+	clientAddr string
+	clientName string
+}
+
+// serverMetrics are the metrics of the server in a metrics registry
+type serverMetrics struct {
+	commands     *metrics.Metric
+	connections  *metrics.Metric
+	hits         *metrics.Metric
+	misses       *metrics.Metric
+	expired      *metrics.Metric
+	slowCommands *metrics.Metric
+	keys         *metrics.Metric
+	usedMemory   *metrics.Metric
+}
+
+// newMonitor creates the monitor of a server
+func newMonitor(config ServerConfig) *monitor {
+	m := &monitor{
+		started:          time.Now(),
+		slowlogThreshold: config.SlowlogThreshold,
+		slowlogMaxLen:    config.SlowlogMaxLen,
+		clients:          make(map[int64]*client),
+	}
+	if m.slowlogThreshold == 0 {
+		m.slowlogThreshold = DefaultSlowlogThreshold
+	}
+	if m.slowlogMaxLen <= 0 {
+		m.slowlogMaxLen = DefaultSlowlogMaxLen
+	}
+	if config.Metrics != nil {
+		m.metrics = &serverMetrics{
+			commands:     config.Metrics.Counter("redisserver_commands_total", "Commands handled by name.", "command"),
+			connections:  config.Metrics.Gauge("redisserver_active_connections", "Open client connections."),
+			hits:         config.Metrics.Counter("redisserver_keyspace_hits_total", "Lookups of keys that existed."),
+			misses:       config.Metrics.Counter("redisserver_keyspace_misses_total", "Lookups of keys that did not exist."),
+			expired:      config.Metrics.Counter("redisserver_expired_keys_total", "Keys removed because they expired."),
+			slowCommands: config.Metrics.Counter("redisserver_slow_commands_total", "Commands that took longer than the slow log threshold."),
+			keys:         config.Metrics.Gauge("redisserver_keys", "Keys in the datastore."),
+			usedMemory:   config.Metrics.Gauge("redisserver_used_memory_bytes", "Heap memory in use by the process."),
+		}
+	}
+	return m
+}
+
+// connect registers a new connection
+func (m *monitor) connect(conn redcon.Conn) {
+	m.mu.Lock()
+	m.lastID++
+	now := time.Now()
+	c := &client{id: m.lastID, addr: conn.RemoteAddr(), created: now, lastActive: now}
+	m.clients[c.id] = c
+	m.mu.Unlock()
+
+	conn.SetContext(c)
+	m.connections.Add(1)
+	if m.metrics != nil {
+		m.metrics.connections.Inc()
+	}
+}
+
+// disconnect removes a closed connection
+func (m *monitor) disconnect(conn redcon.Conn) {
+	c, ok := conn.Context().(*client)
+	if !ok {
+		return
+	}
+	m.mu.Lock()
+	delete(m.clients, c.id)
+	m.mu.Unlock()
+	if m.metrics != nil {
+		m.metrics.connections.Dec()
+	}
+}
+
+// commandDone counts a command and adds it to the slow log if it took
+// longer than the threshold
+func (m *monitor) commandDone(conn redcon.Conn, command string, args [][]byte, started time.Time) {
+	duration := time.Since(started)
+	m.commands.Add(1)
+	if m.metrics != nil {
+		if !knownCommands[command] {
+			command = "unknown"
+		}
+		m.metrics.commands.Inc(command)
+	}
+
+	c, _ := conn.Context().(*client)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c != nil {
+		c.lastCommand = command
+		c.lastActive = time.Now()
+	}
+	// Time spent waiting by blocking commands is not slowness
+	if m.slowlogThreshold < 0 || duration < m.slowlogThreshold || blockingCommands[command] {
+		return
+	}
+
+	m.lastSlowlog++
+	entry := slowlogEntry{id: m.lastSlowlog, time: started, duration: duration}
+	for i, arg := range args {
+		if i == slowlogMaxArgs-1 && len(args) > slowlogMaxArgs {
+			entry.args = append(entry.args, fmt.Sprintf("... (%d more arguments)", len(args)-i))
+			break
+		}
+		if len(arg) > slowlogMaxArgLen {
+			entry.args = append(entry.args, fmt.Sprintf("%s... (%d more bytes)", arg[:slowlogMaxArgLen], len(arg)-slowlogMaxArgLen))
+		} else {
+			entry.args = append(entry.args, string(arg))
+		}
+	}
+	if c != nil {
+		entry.clientAddr = c.addr
+		entry.clientName = c.name
+	}
+	// Newest entries come first
+	m.slowlog = append([]slowlogEntry{entry}, m.slowlog...)
+	if len(m.slowlog) > m.slowlogMaxLen {
+		m.slowlog = m.slowlog[:m.slowlogMaxLen]
+	}
+	if m.metrics != nil {
+		m.metrics.slowCommands.Inc()
+	}
+}
+
+// lookup counts a lookup of a key as a hit or a miss
+func (m *monitor) lookup(found bool) {
+	if found {
+		m.hits.Add(1)
+		if m.metrics != nil {
+			m.metrics.hits.Inc()
+		}
+	} else {
+		m.misses.Add(1)
+		if m.metrics != nil {
+			m.metrics.misses.Inc()
+		}
+	}
+}
+
+// expire counts keys removed because they expired
+func (m *monitor) expire(n int) {
+	if n == 0 {
+		return
+	}
+	m.expired.Add(int64(n))
+	if m.metrics != nil {
+		m.metrics.expired.Add(int64(n))
+	}
+}
+
+// Stats returns the numbers reported by INFO
+func (s *Server) Stats() Stats {
+	m := s.monitor
+	stats := Stats{
+		UptimeSeconds:    int64(time.Since(m.started).Seconds()),
+		TotalConnections: m.connections.Load(),
+		TotalCommands:    m.commands.Load(),
+		KeyspaceHits:     m.hits.Load(),
+		KeyspaceMisses:   m.misses.Load(),
+		ExpiredKeys:      m.expired.Load(),
+	}
+
+	m.mu.Lock()
+	stats.ConnectedClients = len(m.clients)
+	stats.SlowlogLen = len(m.slowlog)
+	m.mu.Unlock()
+
+	s.mu.RLock()
+	now := time.Now()
+	stats.Databases = []DatabaseStats{}
+	for _, db := range s.dbs {
+		dbStats := DatabaseStats{Index: db.index}
+		var ttl time.Duration
+		for _, ent := range db.data {
+			if ent.expiration.IsZero() {
+				dbStats.Keys++
+			} else if now.Before(ent.expiration) {
+				dbStats.Keys++
+				dbStats.KeysWithExpiration++
+				ttl += ent.expiration.Sub(now)
+			}
+		}
+		if dbStats.Keys == 0 {
+			continue
+		}
+		if dbStats.KeysWithExpiration > 0 {
+			dbStats.AverageTTLMilliseconds = ttl.Milliseconds() / int64(dbStats.KeysWithExpiration)
+		}
+		stats.Keys += dbStats.Keys
+		stats.Databases = append(stats.Databases, dbStats)
+	}
+	if p := s.persist; p != nil {
+		stats.AOFEnabled = p.aofPath != ""
+		stats.SnapshotEnabled = p.snapshotPath != ""
+		stats.ChangesSinceLastSave = p.dirty
+	}
+	s.mu.RUnlock()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats.UsedMemory = mem.HeapAlloc
+	return stats
+}
+
+// updateMetrics sets the gauges of the metrics that are not updated by
+// the commands themselves
+func (s *Server) updateMetrics() {
+	if s.monitor.metrics == nil {
+		return
+	}
+	stats := s.Stats()
+	s.monitor.metrics.keys.Set(int64(stats.Keys))
+	s.monitor.metrics.usedMemory.Set(int64(stats.UsedMemory))
+}
+
+// infoSections are the sections of INFO in the order they are written
+var infoSections = []string{"server", "clients", "memory", "persistence", "stats", "keyspace"}
+
+// info returns the INFO text of the given sections, or of all sections if
+// none are given
+func (s *Server) info(sections []string) string {
+	stats := s.Stats()
+	wanted := make(map[string]bool)
+	for _, section := range sections {
+		section = strings.ToLower(section)
+		if section == "all" || section == "everything" || section == "default" {
+			wanted = nil
+			break
+		}
+		wanted[section] = true
+	}
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if len(wanted) > 0 && !wanted[section] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(section[:1]) + section[1:] + "\r\n")
+		field := func(name string, value interface{}) {
+			fmt.Fprintf(&b, "%s:%v\r\n", name, value)
+		}
+		switch section {
+		case "server":
+			field("redis_version", "6.2.0")
+			field("redis_mode", "standalone")
+			field("os", runtime.GOOS)
+			field("arch_bits", 32<<(^uint(0)>>63))
+			field("process_id", os.Getpid())
+			field("uptime_in_seconds", stats.UptimeSeconds)
+			field("uptime_in_days", stats.UptimeSeconds/86400)
+		case "clients":
+			field("connected_clients", stats.ConnectedClients)
+		case "memory":
+			field("used_memory", stats.UsedMemory)
+			field("used_memory_human", humanizeBytes(stats.UsedMemory))
+		case "persistence":
+			field("loading", 0)
+			field("rdb_enabled", boolInt(stats.SnapshotEnabled))
+			field("rdb_changes_since_last_save", stats.ChangesSinceLastSave)
+			field("aof_enabled", boolInt(stats.AOFEnabled))
+		case "stats":
+			field("total_connections_received", stats.TotalConnections)
+			field("total_commands_processed", stats.TotalCommands)
+			field("expired_keys", stats.ExpiredKeys)
+			field("keyspace_hits", stats.KeyspaceHits)
+			field("keyspace_misses", stats.KeyspaceMisses)
+			field("slowlog_len", stats.SlowlogLen)
+		case "keyspace":
+			for _, db := range stats.Databases {
+				fmt.Fprintf(&b, "db%d:keys=%d,expires=%d,avg_ttl=%d\r\n", db.Index, db.Keys, db.KeysWithExpiration, db.AverageTTLMilliseconds)
+			}
+		}
+	}
+	return b.String()
+}
+
+// boolInt returns 1 for true and 0 for false, like INFO reports flags
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// dbsize returns the number of keys that have not expired
+func (s *Server) dbsize() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	count := 0
+	for _, ent := range s.data {
+		if ent.expiration.IsZero() || now.Before(ent.expiration) {
+			count++
+		}
+	}
+	return count
+}
+
+// clientCommand handles the CLIENT subcommands LIST, ID, GETNAME, SETNAME
+// and SETINFO
+func (s *Server) clientCommand(conn redcon.Conn, args [][]byte) {
+	if len(args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for 'client' command")
+		return
+	}
+	m := s.monitor
+	c, _ := conn.Context().(*client)
+	if c == nil {
+		conn.WriteError("ERR unknown client")
+		return
+	}
+
+	switch strings.ToLower(string(args[1])) {
+	case "list":
+		m.mu.Lock()
+		clients := make([]client, 0, len(m.clients))
+		for _, other := range m.clients {
+			clients = append(clients, *other)
+		}
+		m.mu.Unlock()
+		sort.Slice(clients, func(i, j int) bool { return clients[i].id < clients[j].id })
+
+		now := time.Now()
+		var b strings.Builder
+		for _, other := range clients {
+			fmt.Fprintf(&b, "id=%d addr=%s name=%s age=%d idle=%d db=%d cmd=%s\n",
+				other.id, other.addr, other.name,
+				int64(now.Sub(other.created).Seconds()), int64(now.Sub(other.lastActive).Seconds()),
+				other.db, other.lastCommand)
+		}
+		conn.WriteBulkString(b.String())
+	case "id":
+		conn.WriteInt64(c.id)
+	case "getname":
+		m.mu.Lock()
+		name := c.name
+		m.mu.Unlock()
+		if name == "" {
+			conn.WriteNull()
+			return
+		}
+		conn.WriteBulkString(name)
+	case "setname":
+		if len(args) != 3 {
+			conn.WriteError("ERR wrong number of arguments for 'client|setname' command")
+			return
+		}
+		name := string(args[2])
+		if strings.ContainsAny(name, " \n") {
+			conn.WriteError("ERR Client names cannot contain spaces, newlines or special characters.")
+			return
+		}
+		m.mu.Lock()
+		c.name = name
+		m.mu.Unlock()
+		conn.WriteString("OK")
+	case "setinfo":
+		// Sent by clients like go-redis on connect; the library name and
+		// version are not tracked
+		conn.WriteString("OK")
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(args[1]) + "'")
+	}
+}
+
+// slowlogCommand handles the SLOWLOG subcommands GET, LEN and RESET
+func (s *Server) slowlogCommand(conn redcon.Conn, args [][]byte) {
+	if len(args) < 2 {
+		conn.WriteError("ERR wrong number of arguments for 'slowlog' command")
+		return
+	}
+	m := s.monitor
+
+	switch strings.ToLower(string(args[1])) {
+	case "get":
+		count := 10
+		if len(args) > 2 {
+			n, err := strconv.Atoi(string(args[2]))
+			if err != nil || n < -1 {
+				conn.WriteError("ERR count should be greater than or equal to -1")
+				return
+			}
+			count = n
+		}
+		m.mu.Lock()
+		entries := m.slowlog
+		if count >= 0 && count < len(entries) {
+			entries = entries[:count]
+		}
+		entries = append([]slowlogEntry(nil), entries...)
+		m.mu.Unlock()
+
+		conn.WriteArray(len(entries))
+		for _, entry := range entries {
+			conn.WriteArray(6)
+			conn.WriteInt64(entry.id)
+			conn.WriteInt64(entry.time.Unix())
+			conn.WriteInt64(entry.duration.Microseconds())
+			conn.WriteArray(len(entry.args))
+			for _, arg := range entry.args {
+				conn.WriteBulkString(arg)
+			}
+			conn.WriteBulkString(entry.clientAddr)
+			conn.WriteBulkString(entry.clientName)
+		}
+	case "len":
+		m.mu.Lock()
+		n := len(m.slowlog)
+		m.mu.Unlock()
+		conn.WriteInt(n)
+	case "reset":
+		m.mu.Lock()
+		m.slowlog = nil
+		m.mu.Unlock()
+		conn.WriteString("OK")
+	default:
+		conn.WriteError("ERR unknown subcommand '" + string(args[1]) + "'")
+	}
+}
@@ -0,0 +1,90 @@
+package redisserver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/system/stats/metrics"
+)
+
+func TestInfo(t *testing.T) {
+	registry := metrics.NewRegistry()
+	server, client := startTestServer(t, ServerConfig{Metrics: registry})
+	ctx := context.Background()
+
+	client.Set(ctx, "a", "1", 0)
+	client.Set(ctx, "b", "2", time.Hour)
+	client.HSet(ctx, "hash", "field", "value")
+	client.Get(ctx, "a")
+	client.Get(ctx, "missing")
+
+	if n, err := client.DBSize(ctx).Result(); err != nil || n != 3 {
+		t.Errorf("Expected DBSIZE 3, got %d, %v", n, err)
+	}
+
+	info, err := client.Info(ctx).Result()
+	if err != nil {
+		t.Fatalf("INFO failed: %v", err)
+	}
+	for _, want := range []string{"# Server\r\n", "uptime_in_seconds:", "connected_clients:1\r\n", "used_memory:", "keyspace_hits:1\r\n", "keyspace_misses:1\r\n", "db0:keys=3,expires=1,"} {
+		if !strings.Contains(info, want) {
+			t.Errorf("Expected INFO to contain %q, got:\n%s", want, info)
+		}
+	}
+	info, _ = client.Info(ctx, "keyspace").Result()
+	if strings.Contains(info, "# Server") || !strings.Contains(info, "# Keyspace") {
+		t.Errorf("Expected only the keyspace section, got:\n%s", info)
+	}
+
+	stats := server.Stats()
+	if stats.Keys != 3 || stats.KeyspaceHits != 1 || stats.TotalCommands == 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if v := registry.Gauge("redisserver_active_connections", "").Value(); v != 1 {
+		t.Errorf("Expected 1 active connection in the metrics, got %d", v)
+	}
+	if v := registry.Counter("redisserver_commands_total", "", "command").Value("set"); v != 2 {
+		t.Errorf("Expected 2 SET commands in the metrics, got %d", v)
+	}
+}
+
+func TestClientAndSlowlog(t *testing.T) {
+	_, client := startTestServer(t, ServerConfig{SlowlogThreshold: time.Nanosecond, SlowlogMaxLen: 3})
+	ctx := context.Background()
+
+	if err := client.Do(ctx, "CLIENT", "SETNAME", "worker").Err(); err != nil {
+		t.Fatalf("CLIENT SETNAME failed: %v", err)
+	}
+	if name, _ := client.ClientGetName(ctx).Result(); name != "worker" {
+		t.Errorf("Expected the name worker, got %q", name)
+	}
+	list, err := client.ClientList(ctx).Result()
+	if err != nil || !strings.Contains(list, "name=worker") {
+		t.Errorf("Expected the client in CLIENT LIST, got %q, %v", list, err)
+	}
+
+	client.Do(ctx, "SLOWLOG", "RESET")
+	client.Set(ctx, "key", strings.Repeat("x", 200), 0)
+	entries, err := client.SlowLogGet(ctx, 10).Result()
+	if err != nil {
+		t.Fatalf("SLOWLOG GET failed: %v", err)
+	}
+	if len(entries) == 0 || !strings.EqualFold(entries[0].Args[0], "set") {
+		t.Fatalf("Expected the SET command first, got %+v", entries)
+	}
+	if arg := entries[0].Args[2]; !strings.HasSuffix(arg, "(72 more bytes)") {
+		t.Errorf("Expected the long argument to be cut off, got %q", arg)
+	}
+	if entries[0].ClientName != "worker" {
+		t.Errorf("Expected the client name in the entry, got %q", entries[0].ClientName)
+	}
+
+	for i := 0; i < 5; i++ {
+		client.Ping(ctx)
+	}
+	if n, _ := client.Do(ctx, "SLOWLOG", "LEN").Int(); n != 3 {
+		t.Errorf("Expected the slow log to keep 3 entries, got %d", n)
+	}
+}
@@ -0,0 +1,607 @@
+package redisserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/redcon"
+)
+
+// FsyncPolicy sets when the append-only file is synced to disk
+type FsyncPolicy string
+
+const (
+	// FsyncAlways syncs after every write command, so no acknowledged
+	// write is lost
+	FsyncAlways FsyncPolicy = "always"
+	// FsyncEverySec syncs once per second, losing at most a second of
+	// writes on a crash
+	FsyncEverySec FsyncPolicy = "everysec"
+	// FsyncNo leaves syncing to the operating system
+	FsyncNo FsyncPolicy = "no"
+)
+
+// DefaultSnapshotInterval is how often a snapshot is written when
+// ServerConfig.SnapshotInterval is not set
+const DefaultSnapshotInterval = 5 * time.Minute
+
+// aofRewriteMinSize is the size the append-only file must reach before it
+// is rewritten
+const aofRewriteMinSize = 1 << 20
+
+// snapshotVersion is the version of the snapshot format
+const snapshotVersion = 1
+
+// persistence holds the state of the snapshot and the append-only file.
+// Everything but the configuration is guarded by Server.mu.
+type persistence struct {
+	snapshotPath     string
+	snapshotInterval time.Duration
+	aofPath          string
+	aofSync          FsyncPolicy
+
+	aof    *os.File
+	writer *bufio.Writer
+	// aofSize is the size of the append-only file, aofBaseSize its size
+	// after the last rewrite
+	aofSize     int64
+	aofBaseSize int64
+	// aofDB is the database the next command in the append-only file
+	// applies to, as set by its last SELECT
+	aofDB int
+	// dirty counts the writes since the last snapshot
+	dirty uint64
+}
+
+// snapshot is the binary snapshot of the datastore
+type snapshot struct {
+	Version int
+	Entries []snapshotEntry
+}
+
+// snapshotEntry is a key of a snapshot. Items holds the elements of a list
+// or the members of a set.
+type snapshotEntry struct {
+	DB         int
+	Key        string
+	Type       string
+	String     string
+	Hash       map[string]string
+	Items      []string
+	Expiration time.Time
+}
+
+// startPersistence loads the data saved by a previous run and starts
+// writing the snapshot and the append-only file. Like Redis, the
+// append-only file is preferred over the snapshot when both exist. If the
+// saved data cannot be loaded, persistence is disabled so the files are
+// not overwritten.
+func (s *Server) startPersistence(config ServerConfig) {
+	if config.SnapshotPath == "" && config.AOFPath == "" {
+		return
+	}
+	p := &persistence{
+		snapshotPath:     config.SnapshotPath,
+		snapshotInterval: config.SnapshotInterval,
+		aofPath:          config.AOFPath,
+		aofSync:          config.AOFSync,
+	}
+	if p.snapshotInterval <= 0 {
+		p.snapshotInterval = DefaultSnapshotInterval
+	}
+	switch p.aofSync {
+	case FsyncAlways, FsyncEverySec, FsyncNo:
+	case "":
+		p.aofSync = FsyncEverySec
+	default:
+		log.Printf("Unknown fsync policy %q, using %q", p.aofSync, FsyncEverySec)
+		p.aofSync = FsyncEverySec
+	}
+
+	if err := s.load(p); err != nil {
+		log.Printf("Failed to load Redis data, persistence is disabled: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p.aofPath != "" {
+		if err := s.rewriteAOF(p); err != nil {
+			log.Printf("Failed to write the append-only file, persistence is disabled: %v", err)
+			return
+		}
+	}
+	s.persist = p
+	go s.persistLoop(p)
+}
+
+// load loads the append-only file, or the snapshot if there is none
+func (s *Server) load(p *persistence) error {
+	s.loading = true
+	defer func() { s.loading = false }()
+
+	if p.aofPath != "" {
+		if _, err := os.Stat(p.aofPath); err == nil {
+			return s.replayAOF(p.aofPath)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if p.snapshotPath != "" {
+		if _, err := os.Stat(p.snapshotPath); err == nil {
+			return s.loadSnapshot(p.snapshotPath)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// persistLoop syncs the append-only file, rewrites it when it has grown
+// and writes the snapshots
+func (s *Server) persistLoop(p *persistence) {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	lastSnapshot := time.Now()
+	for range ticker.C {
+		if p.aofPath != "" {
+			s.mu.Lock()
+			if err := p.flush(p.aofSync == FsyncEverySec); err != nil {
+				log.Printf("Failed to write the append-only file: %v", err)
+			}
+			if p.aofSize > aofRewriteMinSize && p.aofSize > 2*p.aofBaseSize {
+				if err := s.rewriteAOF(p); err != nil {
+					log.Printf("Failed to rewrite the append-only file: %v", err)
+				}
+			}
+			s.mu.Unlock()
+		}
+
+		if p.snapshotPath != "" && time.Since(lastSnapshot) >= p.snapshotInterval {
+			lastSnapshot = time.Now()
+			if err := s.saveSnapshot(p, false); err != nil {
+				log.Printf("Failed to write the snapshot: %v", err)
+			}
+		}
+	}
+}
+
+// Save writes a snapshot now. It returns an error if no snapshot path is
+// configured.
+func (s *Server) Save() error {
+	s.mu.RLock()
+	p := s.persist
+	s.mu.RUnlock()
+	if p == nil || p.snapshotPath == "" {
+		return errors.New("no snapshot path configured")
+	}
+	return s.saveSnapshot(p, true)
+}
+
+// Close writes a final snapshot and syncs the append-only file. The
+// server keeps serving commands, but should be stopped afterwards, as
+// later writes may not be saved.
+func (s *Server) Close() error {
+	s.mu.RLock()
+	p := s.persist
+	s.mu.RUnlock()
+	if p == nil {
+		return nil
+	}
+
+	var errs []error
+	if p.snapshotPath != "" {
+		errs = append(errs, s.saveSnapshot(p, false))
+	}
+	if p.aofPath != "" {
+		s.mu.Lock()
+		errs = append(errs, p.flush(true))
+		s.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// logWrite records a write command in the append-only file. It must be
+// called with s.mu locked, so commands are logged in the order they are
+// applied.
+func (s *Server) logWrite(args ...string) {
+	p := s.persist
+	if p == nil {
+		return
+	}
+	p.dirty++
+	if p.writer == nil {
+		return
+	}
+
+	var buf []byte
+	if s.index != p.aofDB {
+		buf = appendCommand(buf, []string{"SELECT", strconv.Itoa(s.index)})
+		p.aofDB = s.index
+	}
+	n, err := p.writer.Write(appendCommand(buf, args))
+	p.aofSize += int64(n)
+	if err == nil && p.aofSync == FsyncAlways {
+		err = p.flush(true)
+	}
+	if err != nil {
+		log.Printf("Failed to write the append-only file: %v", err)
+	}
+}
+
+// expired returns whether an entry has expired. While loading, entries
+// never expire, so commands are replayed as they were applied.
+func (s *Server) expired(ent *entry) bool {
+	return !s.loading && !ent.expiration.IsZero() && time.Now().After(ent.expiration)
+}
+
+// flush writes the buffered commands to the append-only file and, if sync
+// is set, syncs it to disk. It must be called with s.mu locked.
+func (p *persistence) flush(sync bool) error {
+	if p.writer == nil {
+		return nil
+	}
+	if err := p.writer.Flush(); err != nil {
+		return err
+	}
+	if sync {
+		return p.aof.Sync()
+	}
+	return nil
+}
+
+// appendCommand appends a command in the Redis protocol
+func appendCommand(b []byte, args []string) []byte {
+	b = redcon.AppendArray(b, len(args))
+	for _, arg := range args {
+		b = redcon.AppendBulkString(b, arg)
+	}
+	return b
+}
+
+// entryCommands returns the commands that recreate an entry
+func entryCommands(key string, ent *entry) [][]string {
+	var commands [][]string
+	switch v := ent.value.(type) {
+	case string:
+		commands = append(commands, []string{"SET", key, v})
+	case map[string]string:
+		for field, value := range v {
+			commands = append(commands, []string{"HSET", key, field, value})
+		}
+	case []string:
+		if len(v) > 0 {
+			commands = append(commands, append([]string{"RPUSH", key}, v...))
+		}
+	case map[string]struct{}:
+		if len(v) > 0 {
+			command := []string{"SADD", key}
+			for member := range v {
+				command = append(command, member)
+			}
+			commands = append(commands, command)
+		}
+	default:
+		log.Printf("Not persisting key %s of type %T", key, ent.value)
+		return nil
+	}
+	if len(commands) > 0 && !ent.expiration.IsZero() {
+		commands = append(commands, []string{"PEXPIREAT", key, strconv.FormatInt(ent.expiration.UnixMilli(), 10)})
+	}
+	return commands
+}
+
+// rewriteAOF replaces the append-only file by the commands that recreate
+// the current data. It must be called with s.mu locked.
+func (s *Server) rewriteAOF(p *persistence) error {
+	if err := p.flush(false); err != nil {
+		return err
+	}
+
+	var buf []byte
+	aofDB := 0
+	for _, db := range s.dbs {
+		for key, ent := range db.data {
+			if s.expired(ent) {
+				continue
+			}
+			if db.index != aofDB {
+				buf = appendCommand(buf, []string{"SELECT", strconv.Itoa(db.index)})
+				aofDB = db.index
+			}
+			for _, command := range entryCommands(key, ent) {
+				buf = appendCommand(buf, command)
+			}
+		}
+	}
+	if err := writeFileAtomic(p.aofPath, buf); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(p.aofPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if p.aof != nil {
+		p.aof.Close()
+	}
+	p.aof = f
+	p.writer = bufio.NewWriter(f)
+	p.aofSize = int64(len(buf))
+	p.aofBaseSize = p.aofSize
+	p.aofDB = aofDB
+	return nil
+}
+
+// replayAOF applies the commands of an append-only file. An incomplete
+// command at the end, left by a crash while writing it, is cut off.
+func (s *Server) replayAOF(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// Like the file itself, replaying starts in database 0
+	db := s.dbs[0]
+	packet := data
+	var args [][]byte
+	for len(packet) > 0 {
+		var complete bool
+		var leftover []byte
+		complete, args, _, leftover, err = redcon.ReadNextCommand(packet, args)
+		if err != nil {
+			return fmt.Errorf("%s at offset %d: %w", path, len(data)-len(packet), err)
+		}
+		if !complete {
+			break
+		}
+		command := make([]string, len(args))
+		for i, arg := range args {
+			command[i] = string(arg)
+		}
+		if db, err = db.replayCommand(command); err != nil {
+			return fmt.Errorf("%s at offset %d: %w", path, len(data)-len(packet), err)
+		}
+		packet = leftover
+	}
+
+	if len(packet) > 0 {
+		log.Printf("Cutting off an incomplete command of %d bytes at the end of %s", len(packet), path)
+		if err := os.Truncate(path, int64(len(data)-len(packet))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayCommand applies a command of the append-only file and returns the
+// database the next command applies to
+func (s *Server) replayCommand(args []string) (*Server, error) {
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+	switch strings.ToLower(args[0]) {
+	case "select":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("invalid command %q", args)
+		}
+		index, err := strconv.Atoi(args[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid command %q", args)
+		}
+		return s.DB(index)
+	case "flushdb":
+		s.flushdb()
+		return s, nil
+	case "flushall":
+		s.flushall()
+		return s, nil
+	case "swapdb":
+		if len(args) != 3 {
+			return nil, fmt.Errorf("invalid command %q", args)
+		}
+		a, errA := strconv.Atoi(args[1])
+		b, errB := strconv.Atoi(args[2])
+		if errA != nil || errB != nil {
+			return nil, fmt.Errorf("invalid command %q", args)
+		}
+		return s, s.swapdb(a, b)
+	}
+	return s, s.replayKeyCommand(args)
+}
+
+// replayKeyCommand applies a command of the append-only file that changes
+// a key
+func (s *Server) replayKeyCommand(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("invalid command %q", args)
+	}
+	key := args[1]
+	switch command := strings.ToLower(args[0]); command {
+	case "set":
+		if len(args) != 3 {
+			return fmt.Errorf("invalid command %q", args)
+		}
+		s.set(key, args[2], 0)
+	case "pexpireat":
+		if len(args) != 3 {
+			return fmt.Errorf("invalid command %q", args)
+		}
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid command %q", args)
+		}
+		s.expireAt(key, time.UnixMilli(ms))
+	case "del":
+		for _, key := range args[1:] {
+			s.del(key)
+		}
+	case "hset":
+		if len(args) < 4 || len(args)%2 != 0 {
+			return fmt.Errorf("invalid command %q", args)
+		}
+		for i := 2; i < len(args); i += 2 {
+			s.hset(key, args[i], args[i+1])
+		}
+	case "hdel":
+		s.hdel(key, args[2:])
+	case "lpush":
+		s.lpush(key, args[2:])
+	case "rpush":
+		s.rpush(key, args[2:])
+	case "lpop":
+		s.lpop(key)
+	case "rpop":
+		s.rpop(key)
+	case "sadd":
+		s.sadd(key, args[2:])
+	case "srem":
+		s.srem(key, args[2:])
+	default:
+		return fmt.Errorf("unknown command '%s'", command)
+	}
+	return nil
+}
+
+// saveSnapshot writes a snapshot of the data. Unless force is set, nothing
+// is written if the data did not change since the last snapshot.
+func (s *Server) saveSnapshot(p *persistence, force bool) error {
+	s.mu.RLock()
+	if !force && p.dirty == 0 {
+		s.mu.RUnlock()
+		return nil
+	}
+	snap := snapshot{Version: snapshotVersion}
+	for _, db := range s.dbs {
+		for key, ent := range db.data {
+			if s.expired(ent) {
+				continue
+			}
+			if e, ok := newSnapshotEntry(db.index, key, ent); ok {
+				snap.Entries = append(snap.Entries, e)
+			}
+		}
+	}
+	dirty := p.dirty
+	s.mu.RUnlock()
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&snap); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(p.snapshotPath, buf.Bytes()); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	p.dirty -= dirty
+	s.mu.Unlock()
+	return nil
+}
+
+// newSnapshotEntry returns the snapshot entry of a key, copying its value
+func newSnapshotEntry(db int, key string, ent *entry) (snapshotEntry, bool) {
+	e := snapshotEntry{DB: db, Key: key, Type: typeName(ent.value), Expiration: ent.expiration}
+	switch v := ent.value.(type) {
+	case string:
+		e.String = v
+	case map[string]string:
+		e.Hash = make(map[string]string, len(v))
+		for field, value := range v {
+			e.Hash[field] = value
+		}
+	case []string:
+		e.Items = append([]string(nil), v...)
+	case map[string]struct{}:
+		e.Items = make([]string, 0, len(v))
+		for member := range v {
+			e.Items = append(e.Items, member)
+		}
+	default:
+		log.Printf("Not persisting key %s of type %T", key, ent.value)
+		return e, false
+	}
+	return e, true
+}
+
+// loadSnapshot loads the data of a snapshot
+func (s *Server) loadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var snap snapshot
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&snap); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("%s: unsupported snapshot version %d", path, snap.Version)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range snap.Entries {
+		if e.DB < 0 || e.DB >= len(s.dbs) {
+			return fmt.Errorf("%s: key %s is in database %d, but there are only %d", path, e.Key, e.DB, len(s.dbs))
+		}
+		ent := &entry{expiration: e.Expiration}
+		switch e.Type {
+		case "string":
+			ent.value = e.String
+		case "hash":
+			ent.value = e.Hash
+			if e.Hash == nil {
+				ent.value = make(map[string]string)
+			}
+		case "list":
+			ent.value = e.Items
+		case "set":
+			set := make(map[string]struct{}, len(e.Items))
+			for _, member := range e.Items {
+				set[member] = struct{}{}
+			}
+			ent.value = set
+		default:
+			return fmt.Errorf("%s: unknown type %q of key %s", path, e.Type, e.Key)
+		}
+		s.dbs[e.DB].data[e.Key] = ent
+	}
+	return nil
+}
+
+// writeFileAtomic writes a file through a temporary file that is synced
+// and renamed, so a crash leaves either the old or the new file
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
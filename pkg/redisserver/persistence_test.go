@@ -0,0 +1,143 @@
+package redisserver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// writeTestData writes keys of every type, with some of them changed again
+func writeTestData(t *testing.T, client *redis.Client) {
+	ctx := context.Background()
+	client.Set(ctx, "string", "value", 0)
+	client.Set(ctx, "expiring", "value", time.Hour)
+	client.Set(ctx, "expired", "value", time.Second)
+	client.Incr(ctx, "counter")
+	client.Incr(ctx, "counter")
+	client.HSet(ctx, "hash", "a", "1")
+	client.HSet(ctx, "hash", "b", "2")
+	client.HDel(ctx, "hash", "a")
+	client.RPush(ctx, "list", "b", "c")
+	client.LPush(ctx, "list", "a")
+	client.RPush(ctx, "list", "d")
+	client.RPop(ctx, "list")
+	client.SAdd(ctx, "set", "x", "y", "z")
+	client.SRem(ctx, "set", "y")
+	client.Set(ctx, "deleted", "value", 0)
+	client.Del(ctx, "deleted")
+	if err := client.Expire(ctx, "hash", time.Hour).Err(); err != nil {
+		t.Fatalf("EXPIRE failed: %v", err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+}
+
+// checkTestData checks the data written by writeTestData
+func checkTestData(t *testing.T, client *redis.Client) {
+	ctx := context.Background()
+	if v, _ := client.Get(ctx, "string").Result(); v != "value" {
+		t.Errorf("Expected string to be value, got %q", v)
+	}
+	if ttl, _ := client.TTL(ctx, "expiring").Result(); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("Expected expiring to keep its TTL, got %v", ttl)
+	}
+	if n, _ := client.Exists(ctx, "expired", "deleted").Result(); n != 0 {
+		t.Errorf("Expected expired and deleted to be gone, got %d keys", n)
+	}
+	if v, _ := client.Get(ctx, "counter").Result(); v != "2" {
+		t.Errorf("Expected counter to be 2, got %q", v)
+	}
+	if fields, _ := client.HKeys(ctx, "hash").Result(); fmt.Sprint(fields) != "[b]" {
+		t.Errorf("Expected hash to only have b, got %v", fields)
+	}
+	if ttl, _ := client.TTL(ctx, "hash").Result(); ttl <= 0 {
+		t.Errorf("Expected hash to keep its TTL, got %v", ttl)
+	}
+	if list, _ := client.LRange(ctx, "list", 0, -1).Result(); fmt.Sprint(list) != "[a b c]" {
+		t.Errorf("Expected list to be [a b c], got %v", list)
+	}
+	members, _ := client.SMembers(ctx, "set").Result()
+	sort.Strings(members)
+	if fmt.Sprint(members) != "[x z]" {
+		t.Errorf("Expected set to be [x z], got %v", members)
+	}
+}
+
+func TestAOF(t *testing.T) {
+	aofPath := filepath.Join(t.TempDir(), "appendonly.aof")
+	config := ServerConfig{AOFPath: aofPath, AOFSync: FsyncAlways}
+
+	_, client := startTestServer(t, config)
+	writeTestData(t, client)
+
+	_, client = startTestServer(t, config)
+	checkTestData(t, client)
+
+	// The file is rewritten on startup, so the data survives another restart
+	_, client = startTestServer(t, config)
+	checkTestData(t, client)
+}
+
+func TestAOFTruncated(t *testing.T) {
+	aofPath := filepath.Join(t.TempDir(), "appendonly.aof")
+	complete := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n"
+	if err := os.WriteFile(aofPath, []byte(complete+"*3\r\n$3\r\nSET\r\n$5\r\nother"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client := startTestServer(t, ServerConfig{AOFPath: aofPath})
+	ctx := context.Background()
+	if v, _ := client.Get(ctx, "key").Result(); v != "value" {
+		t.Errorf("Expected the complete command to be replayed, got %q", v)
+	}
+	if n, _ := client.Exists(ctx, "other").Result(); n != 0 {
+		t.Error("Expected the incomplete command to be dropped")
+	}
+}
+
+func TestAOFCorrupt(t *testing.T) {
+	aofPath := filepath.Join(t.TempDir(), "appendonly.aof")
+	corrupt := []byte("*2\r\n$7\r\nUNKNOWN\r\n$3\r\nkey\r\n")
+	if err := os.WriteFile(aofPath, corrupt, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server starts without persistence and leaves the file alone
+	_, client := startTestServer(t, ServerConfig{AOFPath: aofPath, AOFSync: FsyncAlways})
+	client.Set(context.Background(), "key", "value", 0)
+	if data, _ := os.ReadFile(aofPath); string(data) != string(corrupt) {
+		t.Errorf("Expected the file to be left alone, got %q", data)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	snapshotPath := filepath.Join(t.TempDir(), "dump.hrdb")
+	config := ServerConfig{SnapshotPath: snapshotPath}
+
+	server, client := startTestServer(t, config)
+	writeTestData(t, client)
+	if err := server.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	_, client = startTestServer(t, config)
+	checkTestData(t, client)
+
+	// Without an append-only file the snapshot is loaded into a new one
+	aofPath := filepath.Join(t.TempDir(), "appendonly.aof")
+	_, client = startTestServer(t, ServerConfig{SnapshotPath: snapshotPath, AOFPath: aofPath})
+	checkTestData(t, client)
+	if info, err := os.Stat(aofPath); err != nil || info.Size() == 0 {
+		t.Errorf("Expected the append-only file to be written, got %v", err)
+	}
+
+	server, _ = startTestServer(t, ServerConfig{})
+	if err := server.Save(); err == nil {
+		t.Error("Expected Save to fail without a snapshot path")
+	}
+}
@@ -0,0 +1,35 @@
+// Package redistest starts in-memory Redis servers for the tests of the
+// packages that store their data in Redis
+package redistest
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/redisserver"
+	"github.com/redis/go-redis/v9"
+)
+
+// NewClient starts an in-memory Redis server on a unix socket in a temporary
+// directory and returns a client connected to it. The client and the server
+// are closed when the test is done.
+func NewClient(t testing.TB) *redis.Client {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "redis.sock")
+	srv := redisserver.NewServer(redisserver.ServerConfig{UnixSocketPath: socket})
+	t.Cleanup(func() { srv.Close() })
+
+	client := redis.NewClient(&redis.Options{Network: "unix", Addr: socket})
+	t.Cleanup(func() { client.Close() })
+	for i := 0; ; i++ {
+		if err := client.Ping(context.Background()).Err(); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatalf("Redis server did not start: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return client
+}
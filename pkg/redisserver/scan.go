@@ -0,0 +1,286 @@
+package redisserver
+
+import (
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errSyntax is returned for invalid SCAN options
+var errSyntax = errors.New("ERR syntax error")
+
+// scanOptions are the options of SCAN, HSCAN and SSCAN
+type scanOptions struct {
+	pattern string
+	count   int
+	// keyType limits SCAN to keys of a type, like "hash"
+	keyType string
+}
+
+// parseScanArgs parses the cursor and the MATCH, COUNT and, if allowed,
+// TYPE options of a scan command
+func parseScanArgs(args [][]byte, allowType bool) (uint64, scanOptions, error) {
+	options := scanOptions{pattern: "*", count: 10}
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return 0, options, errors.New("ERR invalid cursor")
+	}
+
+	for i := 1; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return 0, options, errSyntax
+		}
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			options.pattern = value
+		case "count":
+			options.count, err = strconv.Atoi(value)
+			if err != nil {
+				return 0, options, errors.New("ERR value is not an integer or out of range")
+			}
+			if options.count < 1 {
+				return 0, options, errSyntax
+			}
+		case "type":
+			if !allowType {
+				return 0, options, errSyntax
+			}
+			options.keyType = strings.ToLower(value)
+		default:
+			return 0, options, errSyntax
+		}
+	}
+	return cursor, options, nil
+}
+
+// scanPosition returns the position of a key, field or member in the order
+// SCAN, HSCAN and SSCAN iterate in. Cursors are positions rather than
+// indexes, so elements added or removed during an iteration do not make it
+// skip or repeat the others. Positions are never 0, the cursor that starts
+// and ends an iteration.
+func scanPosition(name string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	position := h.Sum64() >> 1
+	if position == 0 {
+		position = 1
+	}
+	return position
+}
+
+// scanPage returns the names from cursor on in iteration order, examining
+// count names, and the cursor to continue from, 0 when all were returned.
+// Like Redis, count limits the names examined rather than the ones
+// returned, so the caller filters them afterwards.
+func scanPage(names []string, cursor uint64, count int) ([]string, uint64) {
+	positions := make(map[string]uint64, len(names))
+	var remaining []string
+	for _, name := range names {
+		position := scanPosition(name)
+		if position >= cursor {
+			positions[name] = position
+			remaining = append(remaining, name)
+		}
+	}
+	sort.Slice(remaining, func(i, j int) bool {
+		pi, pj := positions[remaining[i]], positions[remaining[j]]
+		if pi != pj {
+			return pi < pj
+		}
+		return remaining[i] < remaining[j]
+	})
+
+	end := count
+	if end >= len(remaining) {
+		return remaining, 0
+	}
+	// Names at the same position are returned together, as the cursor
+	// cannot point between them
+	for end < len(remaining) && positions[remaining[end]] == positions[remaining[end-1]] {
+		end++
+	}
+	if end == len(remaining) {
+		return remaining, 0
+	}
+	return remaining[:end], positions[remaining[end]]
+}
+
+// scan returns the keys matching the options from cursor on, and the cursor
+// to continue from
+func (s *Server) scan(cursor uint64, options scanOptions) (uint64, []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	names := make([]string, 0, len(s.data))
+	for k, item := range s.data {
+		if !item.expiration.IsZero() && now.After(item.expiration) {
+			continue
+		}
+		names = append(names, k)
+	}
+
+	page, next := scanPage(names, cursor, options.count)
+	keys := make([]string, 0, len(page))
+	for _, k := range page {
+		if !globMatch(options.pattern, k) {
+			continue
+		}
+		if options.keyType != "" && typeName(s.data[k].value) != options.keyType {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return next, keys
+}
+
+// hscan returns the fields and values of a hash matching the options from
+// cursor on, and the cursor to continue from
+func (s *Server) hscan(key string, cursor uint64, options scanOptions) (uint64, []string, []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ent, ok := s.data[key]
+	if !ok || (!ent.expiration.IsZero() && time.Now().After(ent.expiration)) {
+		return 0, []string{}, []string{}
+	}
+	hash, ok := ent.value.(map[string]string)
+	if !ok {
+		return 0, []string{}, []string{}
+	}
+
+	names := make([]string, 0, len(hash))
+	for field := range hash {
+		names = append(names, field)
+	}
+	page, next := scanPage(names, cursor, options.count)
+	fields := make([]string, 0, len(page))
+	values := make([]string, 0, len(page))
+	for _, field := range page {
+		if globMatch(options.pattern, field) {
+			fields = append(fields, field)
+			values = append(values, hash[field])
+		}
+	}
+	return next, fields, values
+}
+
+// sscan returns the members of a set matching the options from cursor on,
+// and the cursor to continue from
+func (s *Server) sscan(key string, cursor uint64, options scanOptions) (uint64, []string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ent, ok := s.data[key]
+	if !ok || (!ent.expiration.IsZero() && time.Now().After(ent.expiration)) {
+		return 0, []string{}
+	}
+	set, ok := ent.value.(map[string]struct{})
+	if !ok {
+		return 0, []string{}
+	}
+
+	names := make([]string, 0, len(set))
+	for member := range set {
+		names = append(names, member)
+	}
+	page, next := scanPage(names, cursor, options.count)
+	members := make([]string, 0, len(page))
+	for _, member := range page {
+		if globMatch(options.pattern, member) {
+			members = append(members, member)
+		}
+	}
+	return next, members
+}
+
+// globMatch reports whether a string matches a Redis glob pattern, with
+// *, ?, character classes like [a-z] or [^0-9] and \ escaping the next
+// character. Unlike filepath.Match, * also matches slashes.
+func globMatch(pattern, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if globMatch(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			matched, rest := matchClass(pattern[1:], str[0])
+			if !matched {
+				return false
+			}
+			str = str[1:]
+			pattern = rest
+		default:
+			if pattern[0] == '\\' && len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(str) == 0
+}
+
+// matchClass matches a character against the class at the start of
+// pattern, just after its [, and returns the pattern after the class
+func matchClass(pattern string, c byte) (bool, string) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+	matched := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			if pattern[1] == c {
+				matched = true
+			}
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			pattern = pattern[3:]
+		default:
+			if pattern[0] == c {
+				matched = true
+			}
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		// Skip the closing ]
+		pattern = pattern[1:]
+	}
+	return matched != negate, pattern
+}
@@ -0,0 +1,177 @@
+package redisserver
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestClient(t *testing.T) *redis.Client {
+	_, client := startTestServer(t, ServerConfig{})
+	return client
+}
+
+// startTestServer starts a server on a new Unix socket and returns it with
+// a client connected to it
+func startTestServer(t *testing.T, config ServerConfig) (*Server, *redis.Client) {
+	config.UnixSocketPath = filepath.Join(t.TempDir(), "redis.sock")
+	server := NewServer(config)
+
+	client := redis.NewClient(&redis.Options{Network: "unix", Addr: config.UnixSocketPath})
+	t.Cleanup(func() { client.Close() })
+	for i := 0; ; i++ {
+		if err := client.Ping(context.Background()).Err(); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatalf("Redis server did not start: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return server, client
+}
+
+func TestScan(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	for i := 0; i < 100; i++ {
+		client.Set(ctx, fmt.Sprintf("mail:in:alice:work/%d:%d", i%3, i), "x", 0)
+	}
+	client.HSet(ctx, "mail:mailboxes:alice", "work", "1")
+
+	// Keys present during the whole iteration are returned exactly once,
+	// even when others are added and removed in between
+	seen := make(map[string]int)
+	var cursor uint64
+	for calls := 0; ; calls++ {
+		keys, next, err := client.Scan(ctx, cursor, "mail:in:alice:work/*", 7).Result()
+		if err != nil {
+			t.Fatalf("SCAN failed: %v", err)
+		}
+		if len(keys) > 7 {
+			t.Fatalf("Expected at most 7 keys per call, got %d", len(keys))
+		}
+		for _, key := range keys {
+			seen[key]++
+		}
+		if calls == 3 {
+			client.Del(ctx, "mail:in:alice:work/0:0")
+			for i := 100; i < 150; i++ {
+				client.Set(ctx, fmt.Sprintf("mail:in:alice:work/0:%d", i), "x", 0)
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+		if calls > 100 {
+			t.Fatal("SCAN did not finish")
+		}
+	}
+	for i := 1; i < 100; i++ {
+		key := fmt.Sprintf("mail:in:alice:work/%d:%d", i%3, i)
+		if seen[key] != 1 {
+			t.Errorf("Expected %s once, got it %d times", key, seen[key])
+		}
+	}
+	if seen["mail:mailboxes:alice"] != 0 {
+		t.Error("Expected MATCH to leave out other keys")
+	}
+
+	var hashes []string
+	iter := client.ScanType(ctx, 0, "*", 5, "hash").Iterator()
+	for iter.Next(ctx) {
+		hashes = append(hashes, iter.Val())
+	}
+	if err := iter.Err(); err != nil || len(hashes) != 1 || hashes[0] != "mail:mailboxes:alice" {
+		t.Errorf("Expected only the hash, got %v, %v", hashes, err)
+	}
+
+	if err := client.Do(ctx, "SCAN", "abc").Err(); err == nil {
+		t.Error("Expected an error for an invalid cursor")
+	}
+	if err := client.Do(ctx, "SCAN", "0", "COUNT", "0").Err(); err == nil {
+		t.Error("Expected an error for COUNT 0")
+	}
+}
+
+func TestHScanSScan(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	var want []string
+	for i := 0; i < 30; i++ {
+		field := fmt.Sprintf("field%02d", i)
+		client.HSet(ctx, "hash", field, "value-"+field)
+		client.SAdd(ctx, "set", field)
+		want = append(want, field)
+	}
+
+	var fields []string
+	iter := client.HScan(ctx, "hash", 0, "field[0-2]*", 4).Iterator()
+	for iter.Next(ctx) {
+		field := iter.Val()
+		if !iter.Next(ctx) || iter.Val() != "value-"+field {
+			t.Fatalf("Expected the value of %s", field)
+		}
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	if fmt.Sprint(fields) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, fields)
+	}
+
+	var members []string
+	iter = client.SScan(ctx, "set", 0, "field1?", 4).Iterator()
+	for iter.Next(ctx) {
+		members = append(members, iter.Val())
+	}
+	sort.Strings(members)
+	if fmt.Sprint(members) != fmt.Sprint(want[10:20]) {
+		t.Errorf("Expected %v, got %v", want[10:20], members)
+	}
+
+	if n, _ := client.SCard(ctx, "set").Result(); n != 30 {
+		t.Errorf("Expected 30 members, got %d", n)
+	}
+	if ok, _ := client.SIsMember(ctx, "set", "field05").Result(); !ok {
+		t.Error("Expected field05 to be a member")
+	}
+	if n, _ := client.SRem(ctx, "set", "field05", "missing").Result(); n != 1 {
+		t.Errorf("Expected 1 member to be removed, got %d", n)
+	}
+	if keyType, _ := client.Type(ctx, "set").Result(); keyType != "set" {
+		t.Errorf("Expected type set, got %s", keyType)
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		str     string
+		match   bool
+	}{
+		{"*", "", true},
+		{"mail:in:*", "mail:in:alice:work/old:3", true},
+		{"mail:in:alice:work/*", "mail:in:alice:work/old:3", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"*:3", "mail:in:alice:inbox:3", true},
+		{"*:3", "mail:in:alice:inbox:4", false},
+	}
+	for _, test := range tests {
+		if got := globMatch(test.pattern, test.str); got != test.match {
+			t.Errorf("globMatch(%q, %q) = %v, expected %v", test.pattern, test.str, got, test.match)
+		}
+	}
+}
@@ -22,392 +22,624 @@ func (s *Server) startRedisServer(addr string, networkType string) {
 	
 	// Use ListenAndServeNetwork to support both TCP and Unix sockets
 	err := redcon.ListenAndServeNetwork(netType, addr,
-		func(conn redcon.Conn, cmd redcon.Command) {
-			// Every command is expected to have at least one argument (the command name).
-			if len(cmd.Args) == 0 {
-				conn.WriteError("ERR empty command")
+		s.handleCommand,
+		// Accept connection: always allow.
+		func(conn redcon.Conn) bool {
+			s.monitor.connect(conn)
+			return true
+		},
+		// On connection close. Detached connections are removed when
+		// serveDetached is done with them.
+		func(conn redcon.Conn, err error) {
+			if c, ok := conn.Context().(*client); ok && c.closed != nil {
 				return
 			}
-			command := strings.ToLower(string(cmd.Args[0]))
-			switch command {
-			case "ping":
-				conn.WriteString("PONG")
-			case "set":
-				// Usage: SET key value [EX seconds]
-				if len(cmd.Args) < 3 {
-					conn.WriteError("ERR wrong number of arguments for 'set' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				value := string(cmd.Args[2])
-				duration := time.Duration(0)
-				// Check for an expiration option (only EX is supported here).
-				if len(cmd.Args) > 3 {
-					if strings.ToLower(string(cmd.Args[3])) == "ex" && len(cmd.Args) > 4 {
-						seconds, err := strconv.Atoi(string(cmd.Args[4]))
-						if err != nil {
-							conn.WriteError("ERR invalid expire time")
-							return
-						}
-						duration = time.Duration(seconds) * time.Second
-					}
-				}
-				s.set(key, value, duration)
-				conn.WriteString("OK")
-			case "get":
-				if len(cmd.Args) < 2 {
-					conn.WriteError("ERR wrong number of arguments for 'get' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				v, ok := s.get(key)
-				if !ok {
-					conn.WriteNull()
-					return
-				}
-				// Only string type is returned by GET.
-				switch val := v.(type) {
-				case string:
-					conn.WriteBulkString(val)
-				default:
-					conn.WriteError("WRONGTYPE Operation against a key holding the wrong kind of value")
-				}
-			case "del":
-				if len(cmd.Args) < 2 {
-					conn.WriteError("ERR wrong number of arguments for 'del' command")
-					return
-				}
-				count := 0
-				for i := 1; i < len(cmd.Args); i++ {
-					key := string(cmd.Args[i])
-					count += s.del(key)
-				}
-				conn.WriteInt(count)
-			case "keys":
-				if len(cmd.Args) < 2 {
-					conn.WriteError("ERR wrong number of arguments for 'keys' command")
-					return
-				}
-				pattern := string(cmd.Args[1])
-				keys := s.keys(pattern)
-				conn.WriteArray(len(keys))
-				for _, k := range keys {
-					conn.WriteBulkString(k)
-				}
-			case "hset":
-				// Usage: HSET key field value
-				if len(cmd.Args) < 4 {
-					conn.WriteError("ERR wrong number of arguments for 'hset' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				field := string(cmd.Args[2])
-				value := string(cmd.Args[3])
-				added := s.hset(key, field, value)
-				conn.WriteInt(added)
-			case "hget":
-				// Usage: HGET key field
-				if len(cmd.Args) < 3 {
-					conn.WriteError("ERR wrong number of arguments for 'hget' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				field := string(cmd.Args[2])
-				v, ok := s.hget(key, field)
-				if !ok {
-					conn.WriteNull()
-					return
-				}
-				conn.WriteBulkString(v)
-			case "hdel":
-				// Usage: HDEL key field [field ...]
-				if len(cmd.Args) < 3 {
-					conn.WriteError("ERR wrong number of arguments for 'hdel' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				fields := make([]string, 0, len(cmd.Args)-2)
-				for i := 2; i < len(cmd.Args); i++ {
-					fields = append(fields, string(cmd.Args[i]))
-				}
-				removed := s.hdel(key, fields)
-				conn.WriteInt(removed)
-			case "hkeys":
-				// Usage: HKEYS key
-				if len(cmd.Args) < 2 {
-					conn.WriteError("ERR wrong number of arguments for 'hkeys' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				fields := s.hkeys(key)
-				conn.WriteArray(len(fields))
-				for _, field := range fields {
-					conn.WriteBulkString(field)
-				}
-			case "hlen":
-				// Usage: HLEN key
-				if len(cmd.Args) < 2 {
-					conn.WriteError("ERR wrong number of arguments for 'hlen' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				length := s.hlen(key)
-				conn.WriteInt(length)
-			case "incr":
-				if len(cmd.Args) < 2 {
-					conn.WriteError("ERR wrong number of arguments for 'incr' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				newVal, err := s.incr(key)
-				if err != nil {
-					conn.WriteError("ERR " + err.Error())
-					return
-				}
-				conn.WriteInt64(newVal)
-			case "info":
-				// Return basic information about the server
-				info := s.getInfo()
-				conn.WriteBulkString(info)
-			case "type":
-				// Usage: TYPE key
-				if len(cmd.Args) < 2 {
-					conn.WriteError("ERR wrong number of arguments for 'type' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				keyType := s.getType(key)
-				conn.WriteBulkString(keyType)
-			case "ttl":
-				// Usage: TTL key
-				if len(cmd.Args) < 2 {
-					conn.WriteError("ERR wrong number of arguments for 'ttl' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				ttl := s.getTTL(key)
-				conn.WriteInt64(ttl)
-			case "exists":
-				// Usage: EXISTS key [key ...]
-				if len(cmd.Args) < 2 {
-					conn.WriteError("ERR wrong number of arguments for 'exists' command")
-					return
-				}
-				keys := make([]string, 0, len(cmd.Args)-1)
-				for i := 1; i < len(cmd.Args); i++ {
-					keys = append(keys, string(cmd.Args[i]))
-				}
-				count := s.exists(keys)
-				conn.WriteInt(count)
-			case "expire":
-				// Usage: EXPIRE key seconds
-				if len(cmd.Args) < 3 {
-					conn.WriteError("ERR wrong number of arguments for 'expire' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				seconds, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
-				if err != nil {
-					conn.WriteError("ERR value is not an integer or out of range")
-					return
-				}
-				success := s.expire(key, time.Duration(seconds)*time.Second)
-				if success {
-					conn.WriteInt(1)
-				} else {
-					conn.WriteInt(0)
-				}
-			case "scan":
-				// Usage: SCAN cursor [MATCH pattern] [COUNT count]
-				if len(cmd.Args) < 2 {
-					conn.WriteError("ERR wrong number of arguments for 'scan' command")
-					return
-				}
+			s.monitor.disconnect(conn)
+		},
+	)
+	if err != nil {
+		log.Printf("Error starting Redis server: %v", err)
+	}
+}
 
-				cursor := string(cmd.Args[1])
-				cursorInt, err := strconv.Atoi(cursor)
+// handleCommand handles a command of a client
+func (s *Server) handleCommand(conn redcon.Conn, cmd redcon.Command) {
+	// Every command is expected to have at least one argument (the command name).
+	if len(cmd.Args) == 0 {
+		conn.WriteError("ERR empty command")
+		return
+	}
+	command := strings.ToLower(string(cmd.Args[0]))
+	// Blocking commands run on a detached connection, which notices when
+	// the client disconnects while it waits
+	if blockingCommands[command] && s.detach(conn, cmd) {
+		return
+	}
+	defer s.monitor.commandDone(conn, command, cmd.Args, time.Now())
+	// Commands apply to the database selected by the connection
+	s = s.selected(conn)
+	switch command {
+	case "ping":
+		conn.WriteString("PONG")
+	case "set":
+		// Usage: SET key value [EX seconds]
+		if len(cmd.Args) < 3 {
+			conn.WriteError("ERR wrong number of arguments for 'set' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		value := string(cmd.Args[2])
+		duration := time.Duration(0)
+		// Check for an expiration option (only EX is supported here).
+		if len(cmd.Args) > 3 {
+			if strings.ToLower(string(cmd.Args[3])) == "ex" && len(cmd.Args) > 4 {
+				seconds, err := strconv.Atoi(string(cmd.Args[4]))
 				if err != nil {
-					conn.WriteError("ERR invalid cursor")
+					conn.WriteError("ERR invalid expire time")
 					return
 				}
+				duration = time.Duration(seconds) * time.Second
+			}
+		}
+		s.set(key, value, duration)
+		conn.WriteString("OK")
+	case "get":
+		if len(cmd.Args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'get' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		v, ok := s.get(key)
+		s.monitor.lookup(ok)
+		if !ok {
+			conn.WriteNull()
+			return
+		}
+		// Only string type is returned by GET.
+		switch val := v.(type) {
+		case string:
+			conn.WriteBulkString(val)
+		default:
+			conn.WriteError("WRONGTYPE Operation against a key holding the wrong kind of value")
+		}
+	case "del":
+		if len(cmd.Args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'del' command")
+			return
+		}
+		count := 0
+		for i := 1; i < len(cmd.Args); i++ {
+			key := string(cmd.Args[i])
+			count += s.del(key)
+		}
+		conn.WriteInt(count)
+	case "keys":
+		if len(cmd.Args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'keys' command")
+			return
+		}
+		pattern := string(cmd.Args[1])
+		keys := s.keys(pattern)
+		conn.WriteArray(len(keys))
+		for _, k := range keys {
+			conn.WriteBulkString(k)
+		}
+	case "hset", "hmset":
+		// Usage: HSET key field value [field value ...]
+		if len(cmd.Args) < 4 || len(cmd.Args)%2 != 0 {
+			conn.WriteError("ERR wrong number of arguments for '" + command + "' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		pairs := make([]string, 0, len(cmd.Args)-2)
+		for i := 2; i < len(cmd.Args); i++ {
+			pairs = append(pairs, string(cmd.Args[i]))
+		}
+		added := s.hmset(key, pairs)
+		if command == "hmset" {
+			conn.WriteString("OK")
+			return
+		}
+		conn.WriteInt(added)
+	case "hget":
+		// Usage: HGET key field
+		if len(cmd.Args) < 3 {
+			conn.WriteError("ERR wrong number of arguments for 'hget' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		field := string(cmd.Args[2])
+		v, ok := s.hget(key, field)
+		s.monitor.lookup(ok)
+		if !ok {
+			conn.WriteNull()
+			return
+		}
+		conn.WriteBulkString(v)
+	case "hdel":
+		// Usage: HDEL key field [field ...]
+		if len(cmd.Args) < 3 {
+			conn.WriteError("ERR wrong number of arguments for 'hdel' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		fields := make([]string, 0, len(cmd.Args)-2)
+		for i := 2; i < len(cmd.Args); i++ {
+			fields = append(fields, string(cmd.Args[i]))
+		}
+		removed := s.hdel(key, fields)
+		conn.WriteInt(removed)
+	case "hkeys":
+		// Usage: HKEYS key
+		if len(cmd.Args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'hkeys' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		fields := s.hkeys(key)
+		conn.WriteArray(len(fields))
+		for _, field := range fields {
+			conn.WriteBulkString(field)
+		}
+	case "hlen":
+		// Usage: HLEN key
+		if len(cmd.Args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'hlen' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		length := s.hlen(key)
+		conn.WriteInt(length)
+	case "hgetall":
+		// Usage: HGETALL key
+		if len(cmd.Args) != 2 {
+			conn.WriteError("ERR wrong number of arguments for 'hgetall' command")
+			return
+		}
+		hash := s.hgetall(string(cmd.Args[1]))
+		conn.WriteArray(2 * len(hash))
+		for field, value := range hash {
+			conn.WriteBulkString(field)
+			conn.WriteBulkString(value)
+		}
+	case "hmget":
+		// Usage: HMGET key field [field ...]
+		if len(cmd.Args) < 3 {
+			conn.WriteError("ERR wrong number of arguments for 'hmget' command")
+			return
+		}
+		fields := make([]string, 0, len(cmd.Args)-2)
+		for i := 2; i < len(cmd.Args); i++ {
+			fields = append(fields, string(cmd.Args[i]))
+		}
+		values, found := s.hmget(string(cmd.Args[1]), fields)
+		conn.WriteArray(len(values))
+		for i, value := range values {
+			if !found[i] {
+				conn.WriteNull()
+				continue
+			}
+			conn.WriteBulkString(value)
+		}
+	case "hvals":
+		// Usage: HVALS key
+		if len(cmd.Args) != 2 {
+			conn.WriteError("ERR wrong number of arguments for 'hvals' command")
+			return
+		}
+		values := s.hvals(string(cmd.Args[1]))
+		conn.WriteArray(len(values))
+		for _, value := range values {
+			conn.WriteBulkString(value)
+		}
+	case "hexists":
+		// Usage: HEXISTS key field
+		if len(cmd.Args) != 3 {
+			conn.WriteError("ERR wrong number of arguments for 'hexists' command")
+			return
+		}
+		if s.hexists(string(cmd.Args[1]), string(cmd.Args[2])) {
+			conn.WriteInt(1)
+		} else {
+			conn.WriteInt(0)
+		}
+	case "hincrby":
+		// Usage: HINCRBY key field increment
+		if len(cmd.Args) != 4 {
+			conn.WriteError("ERR wrong number of arguments for 'hincrby' command")
+			return
+		}
+		increment, err := strconv.ParseInt(string(cmd.Args[3]), 10, 64)
+		if err != nil {
+			conn.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+		newVal, err := s.hincrby(string(cmd.Args[1]), string(cmd.Args[2]), increment)
+		if err != nil {
+			conn.WriteError("ERR " + err.Error())
+			return
+		}
+		conn.WriteInt64(newVal)
+	case "incr":
+		if len(cmd.Args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'incr' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		newVal, err := s.incr(key)
+		if err != nil {
+			conn.WriteError("ERR " + err.Error())
+			return
+		}
+		conn.WriteInt64(newVal)
+	case "info":
+		// Usage: INFO [section ...]
+		sections := make([]string, 0, len(cmd.Args)-1)
+		for i := 1; i < len(cmd.Args); i++ {
+			sections = append(sections, string(cmd.Args[i]))
+		}
+		conn.WriteBulkString(s.info(sections))
+	case "dbsize":
+		conn.WriteInt(s.dbsize())
+	case "select":
+		// Usage: SELECT index
+		if len(cmd.Args) != 2 {
+			conn.WriteError("ERR wrong number of arguments for 'select' command")
+			return
+		}
+		index, err := strconv.Atoi(string(cmd.Args[1]))
+		if err != nil {
+			conn.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+		if err := s.selectDB(conn, index); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteString("OK")
+	case "swapdb":
+		// Usage: SWAPDB index1 index2
+		if len(cmd.Args) != 3 {
+			conn.WriteError("ERR wrong number of arguments for 'swapdb' command")
+			return
+		}
+		a, errA := strconv.Atoi(string(cmd.Args[1]))
+		b, errB := strconv.Atoi(string(cmd.Args[2]))
+		if errA != nil || errB != nil {
+			conn.WriteError("ERR invalid DB index")
+			return
+		}
+		if err := s.swapdb(a, b); err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		conn.WriteString("OK")
+	case "flushdb":
+		s.flushdb()
+		conn.WriteString("OK")
+	case "flushall":
+		s.flushall()
+		conn.WriteString("OK")
+	case "client":
+		// Usage: CLIENT LIST | ID | GETNAME | SETNAME name
+		s.clientCommand(conn, cmd.Args)
+	case "slowlog":
+		// Usage: SLOWLOG GET [count] | LEN | RESET
+		s.slowlogCommand(conn, cmd.Args)
+	case "type":
+		// Usage: TYPE key
+		if len(cmd.Args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'type' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		keyType := s.getType(key)
+		conn.WriteBulkString(keyType)
+	case "ttl":
+		// Usage: TTL key
+		if len(cmd.Args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'ttl' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		ttl := s.getTTL(key)
+		conn.WriteInt64(ttl)
+	case "exists":
+		// Usage: EXISTS key [key ...]
+		if len(cmd.Args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'exists' command")
+			return
+		}
+		keys := make([]string, 0, len(cmd.Args)-1)
+		for i := 1; i < len(cmd.Args); i++ {
+			keys = append(keys, string(cmd.Args[i]))
+		}
+		count := s.exists(keys)
+		conn.WriteInt(count)
+	case "expire":
+		// Usage: EXPIRE key seconds
+		if len(cmd.Args) < 3 {
+			conn.WriteError("ERR wrong number of arguments for 'expire' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		seconds, err := strconv.ParseInt(string(cmd.Args[2]), 10, 64)
+		if err != nil {
+			conn.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+		success := s.expire(key, time.Duration(seconds)*time.Second)
+		if success {
+			conn.WriteInt(1)
+		} else {
+			conn.WriteInt(0)
+		}
+	case "scan":
+		// Usage: SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+		if len(cmd.Args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'scan' command")
+			return
+		}
+		cursor, options, err := parseScanArgs(cmd.Args[1:], true)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
 
-				// Default values
-				pattern := "*"
-				count := 10
+		// Get matching keys
+		nextCursor, keys := s.scan(cursor, options)
 
-				// Parse optional arguments
-				for i := 2; i < len(cmd.Args); i++ {
-					arg := strings.ToLower(string(cmd.Args[i]))
-					if arg == "match" && i+1 < len(cmd.Args) {
-						pattern = string(cmd.Args[i+1])
-						i++
-					} else if arg == "count" && i+1 < len(cmd.Args) {
-						count, err = strconv.Atoi(string(cmd.Args[i+1]))
-						if err != nil {
-							conn.WriteError("ERR value is not an integer or out of range")
-							return
-						}
-						i++
-					}
-				}
+		// Write response
+		conn.WriteArray(2)
+		conn.WriteBulkString(strconv.FormatUint(nextCursor, 10))
+		conn.WriteArray(len(keys))
+		for _, key := range keys {
+			conn.WriteBulkString(key)
+		}
+	case "hscan":
+		// Usage: HSCAN key cursor [MATCH pattern] [COUNT count]
+		if len(cmd.Args) < 3 {
+			conn.WriteError("ERR wrong number of arguments for 'hscan' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		cursor, options, err := parseScanArgs(cmd.Args[2:], false)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
 
-				// Get matching keys
-				nextCursor, keys := s.scan(cursorInt, pattern, count)
+		// Get matching fields and values
+		nextCursor, fields, values := s.hscan(key, cursor, options)
 
-				// Write response
-				conn.WriteArray(2)
-				conn.WriteBulkString(strconv.Itoa(nextCursor))
-				conn.WriteArray(len(keys))
-				for _, key := range keys {
-					conn.WriteBulkString(key)
-				}
-			case "hscan":
-				// Usage: HSCAN key cursor [MATCH pattern] [COUNT count]
-				if len(cmd.Args) < 3 {
-					conn.WriteError("ERR wrong number of arguments for 'hscan' command")
-					return
-				}
+		// Write response
+		conn.WriteArray(2)
+		conn.WriteBulkString(strconv.FormatUint(nextCursor, 10))
 
-				key := string(cmd.Args[1])
-				cursor := string(cmd.Args[2])
-				cursorInt, err := strconv.Atoi(cursor)
-				if err != nil {
-					conn.WriteError("ERR invalid cursor")
-					return
-				}
+		// Write field-value pairs
+		conn.WriteArray(len(fields) * 2) // Each field has a corresponding value
+		for i := 0; i < len(fields); i++ {
+			conn.WriteBulkString(fields[i])
+			conn.WriteBulkString(values[i])
+		}
+	case "sscan":
+		// Usage: SSCAN key cursor [MATCH pattern] [COUNT count]
+		if len(cmd.Args) < 3 {
+			conn.WriteError("ERR wrong number of arguments for 'sscan' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		cursor, options, err := parseScanArgs(cmd.Args[2:], false)
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
 
-				// Default values
-				pattern := "*"
-				count := 10
+		nextCursor, members := s.sscan(key, cursor, options)
+		conn.WriteArray(2)
+		conn.WriteBulkString(strconv.FormatUint(nextCursor, 10))
+		conn.WriteArray(len(members))
+		for _, member := range members {
+			conn.WriteBulkString(member)
+		}
+	case "sadd", "srem":
+		// Usage: SADD key member [member ...]
+		if len(cmd.Args) < 3 {
+			conn.WriteError("ERR wrong number of arguments for '" + command + "' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		members := make([]string, 0, len(cmd.Args)-2)
+		for i := 2; i < len(cmd.Args); i++ {
+			members = append(members, string(cmd.Args[i]))
+		}
+		if command == "sadd" {
+			conn.WriteInt(s.sadd(key, members))
+		} else {
+			conn.WriteInt(s.srem(key, members))
+		}
+	case "smembers":
+		// Usage: SMEMBERS key
+		if len(cmd.Args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'smembers' command")
+			return
+		}
+		members := s.smembers(string(cmd.Args[1]))
+		conn.WriteArray(len(members))
+		for _, member := range members {
+			conn.WriteBulkString(member)
+		}
+	case "sismember":
+		// Usage: SISMEMBER key member
+		if len(cmd.Args) < 3 {
+			conn.WriteError("ERR wrong number of arguments for 'sismember' command")
+			return
+		}
+		if s.sismember(string(cmd.Args[1]), string(cmd.Args[2])) {
+			conn.WriteInt(1)
+		} else {
+			conn.WriteInt(0)
+		}
+	case "scard":
+		// Usage: SCARD key
+		if len(cmd.Args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'scard' command")
+			return
+		}
+		conn.WriteInt(s.scard(string(cmd.Args[1])))
+	case "lpush":
+		// Usage: LPUSH key value [value ...]
+		if len(cmd.Args) < 3 {
+			conn.WriteError("ERR wrong number of arguments for 'lpush' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		values := make([]string, len(cmd.Args)-2)
+		for i := 2; i < len(cmd.Args); i++ {
+			values[i-2] = string(cmd.Args[i])
+		}
+		length := s.lpush(key, values)
+		conn.WriteInt(length)
 
-				// Parse optional arguments
-				for i := 3; i < len(cmd.Args); i++ {
-					arg := strings.ToLower(string(cmd.Args[i]))
-					if arg == "match" && i+1 < len(cmd.Args) {
-						pattern = string(cmd.Args[i+1])
-						i++
-					} else if arg == "count" && i+1 < len(cmd.Args) {
-						count, err = strconv.Atoi(string(cmd.Args[i+1]))
-						if err != nil {
-							conn.WriteError("ERR value is not an integer or out of range")
-							return
-						}
-						i++
-					}
-				}
-
-				// Get matching fields and values
-				nextCursor, fields, values := s.hscan(key, cursorInt, pattern, count)
+	case "rpush":
+		// Usage: RPUSH key value [value ...]
+		if len(cmd.Args) < 3 {
+			conn.WriteError("ERR wrong number of arguments for 'rpush' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		values := make([]string, len(cmd.Args)-2)
+		for i := 2; i < len(cmd.Args); i++ {
+			values[i-2] = string(cmd.Args[i])
+		}
+		length := s.rpush(key, values)
+		conn.WriteInt(length)
 
-				// Write response
-				conn.WriteArray(2)
-				conn.WriteBulkString(strconv.Itoa(nextCursor))
+	case "lpop":
+		// Usage: LPOP key
+		if len(cmd.Args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'lpop' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		val, ok := s.lpop(key)
+		if !ok {
+			conn.WriteNull()
+			return
+		}
+		conn.WriteBulkString(val)
 
-				// Write field-value pairs
-				conn.WriteArray(len(fields) * 2) // Each field has a corresponding value
-				for i := 0; i < len(fields); i++ {
-					conn.WriteBulkString(fields[i])
-					conn.WriteBulkString(values[i])
-				}
-			case "lpush":
-				// Usage: LPUSH key value [value ...]
-				if len(cmd.Args) < 3 {
-					conn.WriteError("ERR wrong number of arguments for 'lpush' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				values := make([]string, len(cmd.Args)-2)
-				for i := 2; i < len(cmd.Args); i++ {
-					values[i-2] = string(cmd.Args[i])
-				}
-				length := s.lpush(key, values)
-				conn.WriteInt(length)
+	case "rpop":
+		// Usage: RPOP key
+		if len(cmd.Args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'rpop' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		val, ok := s.rpop(key)
+		if !ok {
+			conn.WriteNull()
+			return
+		}
+		conn.WriteBulkString(val)
 
-			case "rpush":
-				// Usage: RPUSH key value [value ...]
-				if len(cmd.Args) < 3 {
-					conn.WriteError("ERR wrong number of arguments for 'rpush' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				values := make([]string, len(cmd.Args)-2)
-				for i := 2; i < len(cmd.Args); i++ {
-					values[i-2] = string(cmd.Args[i])
-				}
-				length := s.rpush(key, values)
-				conn.WriteInt(length)
+	case "llen":
+		// Usage: LLEN key
+		if len(cmd.Args) < 2 {
+			conn.WriteError("ERR wrong number of arguments for 'llen' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		length := s.llen(key)
+		conn.WriteInt(length)
 
-			case "lpop":
-				// Usage: LPOP key
-				if len(cmd.Args) < 2 {
-					conn.WriteError("ERR wrong number of arguments for 'lpop' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				val, ok := s.lpop(key)
-				if !ok {
-					conn.WriteNull()
-					return
-				}
-				conn.WriteBulkString(val)
+	case "lrange":
+		// Usage: LRANGE key start stop
+		if len(cmd.Args) < 4 {
+			conn.WriteError("ERR wrong number of arguments for 'lrange' command")
+			return
+		}
+		key := string(cmd.Args[1])
+		start, err := strconv.Atoi(string(cmd.Args[2]))
+		if err != nil {
+			conn.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+		stop, err := strconv.Atoi(string(cmd.Args[3]))
+		if err != nil {
+			conn.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+		values := s.lrange(key, start, stop)
+		conn.WriteArray(len(values))
+		for _, val := range values {
+			conn.WriteBulkString(val)
+		}
 
-			case "rpop":
-				// Usage: RPOP key
-				if len(cmd.Args) < 2 {
-					conn.WriteError("ERR wrong number of arguments for 'rpop' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				val, ok := s.rpop(key)
-				if !ok {
-					conn.WriteNull()
-					return
-				}
-				conn.WriteBulkString(val)
+	case "rpoplpush":
+		// Usage: RPOPLPUSH source destination
+		if len(cmd.Args) != 3 {
+			conn.WriteError("ERR wrong number of arguments for 'rpoplpush' command")
+			return
+		}
+		val, ok := s.rpoplpush(string(cmd.Args[1]), string(cmd.Args[2]))
+		if !ok {
+			conn.WriteNull()
+			return
+		}
+		conn.WriteBulkString(val)
 
-			case "llen":
-				// Usage: LLEN key
-				if len(cmd.Args) < 2 {
-					conn.WriteError("ERR wrong number of arguments for 'llen' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				length := s.llen(key)
-				conn.WriteInt(length)
+	case "blpop", "brpop":
+		// Usage: BLPOP key [key ...] timeout
+		if len(cmd.Args) < 3 {
+			conn.WriteError("ERR wrong number of arguments for '" + command + "' command")
+			return
+		}
+		timeout, err := parseTimeout(cmd.Args[len(cmd.Args)-1])
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		keys := make([]string, len(cmd.Args)-2)
+		for i := range keys {
+			keys[i] = string(cmd.Args[i+1])
+		}
+		pop := s.rpop
+		if command == "blpop" {
+			pop = s.lpop
+		}
+		key, val, ok := s.waitList(conn, keys, timeout, pop)
+		if !ok {
+			// A null array, like Redis replies on a timeout
+			conn.WriteRaw([]byte("*-1\r\n"))
+			return
+		}
+		conn.WriteArray(2)
+		conn.WriteBulkString(key)
+		conn.WriteBulkString(val)
 
-			case "lrange":
-				// Usage: LRANGE key start stop
-				if len(cmd.Args) < 4 {
-					conn.WriteError("ERR wrong number of arguments for 'lrange' command")
-					return
-				}
-				key := string(cmd.Args[1])
-				start, err := strconv.Atoi(string(cmd.Args[2]))
-				if err != nil {
-					conn.WriteError("ERR value is not an integer or out of range")
-					return
-				}
-				stop, err := strconv.Atoi(string(cmd.Args[3]))
-				if err != nil {
-					conn.WriteError("ERR value is not an integer or out of range")
-					return
-				}
-				values := s.lrange(key, start, stop)
-				conn.WriteArray(len(values))
-				for _, val := range values {
-					conn.WriteBulkString(val)
-				}
+	case "brpoplpush":
+		// Usage: BRPOPLPUSH source destination timeout
+		if len(cmd.Args) != 4 {
+			conn.WriteError("ERR wrong number of arguments for 'brpoplpush' command")
+			return
+		}
+		timeout, err := parseTimeout(cmd.Args[3])
+		if err != nil {
+			conn.WriteError(err.Error())
+			return
+		}
+		dst := string(cmd.Args[2])
+		_, val, ok := s.waitList(conn, []string{string(cmd.Args[1])}, timeout, func(src string) (string, bool) {
+			return s.rpoplpush(src, dst)
+		})
+		if !ok {
+			conn.WriteNull()
+			return
+		}
+		conn.WriteBulkString(val)
 
-			default:
-				conn.WriteError("ERR unknown command '" + command + "'")
-			}
-		},
-		// Accept connection: always allow.
-		func(conn redcon.Conn) bool { return true },
-		// On connection close.
-		func(conn redcon.Conn, err error) {},
-	)
-	if err != nil {
-		log.Printf("Error starting Redis server: %v", err)
+	default:
+		conn.WriteError("ERR unknown command '" + command + "'")
 	}
 }
@@ -1,17 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/freeflowuniverse/herolauncher/pkg/mail"
-	"github.com/freeflowuniverse/herolauncher/pkg/smtp"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailblob"
+	"github.com/freeflowuniverse/herolauncher/pkg/smtpserver"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfslocal"
 )
 
 func main() {
@@ -22,19 +26,79 @@ func main() {
 	redisAddr := flag.String("redis-addr", "localhost:6378", "Redis server address")
 	redisPassword := flag.String("redis-password", "", "Redis server password")
 	redisDB := flag.Int("redis-db", 0, "Redis database number")
+	requireAuth := flag.Bool("require-auth", true, "Require AUTH as a mail user before sending")
+	useTLS := flag.Bool("tls", false, "Offer STARTTLS on -port and serve implicit TLS on -tls-port")
+	tlsPort := flag.Int("tls-port", 4650, "Implicit TLS (submission) port, 0 to disable")
+	certFile := flag.String("cert", "", "TLS certificate file (a self-signed certificate is generated if missing)")
+	keyFile := flag.String("key", "", "TLS key file")
+	insecureAuth := flag.Bool("insecure-auth", false, "With -tls, still accept AUTH on connections without TLS")
+	relay := flag.Bool("relay", false, "Deliver mail of authenticated users to remote domains")
+	relayAttempts := flag.Int("relay-attempts", 8, "Delivery attempts before a relayed message is bounced")
+	maxMessageSize := flag.Int("max-message-size", 10*1024*1024, "Maximum message size in bytes, advertised with SIZE")
+	connRate := flag.Int("conn-rate", 60, "Connections a client address may open per minute, 0 for no limit")
+	messageRate := flag.Int("message-rate", 200, "Messages a sender may send per hour, 0 for no limit")
+	blockAttachments := flag.String("block-attachments", "", "Comma-separated attachment extensions to reject, e.g. .exe,.bat")
+	blockKeywords := flag.String("block-keywords", "", "Comma-separated keywords that reject a message")
+	filterCommand := flag.String("filter-command", "", "External command that filters every message (see CommandFilter)")
+	filterURL := flag.String("filter-url", "", "JSON-RPC endpoint that filters every message")
+	filterMethod := flag.String("filter-method", "smtp.filter", "Method called on -filter-url")
+	verifySenders := flag.Bool("verify-senders", false, "Check SPF, DKIM and DMARC of received mail")
+	lmtpSocket := flag.String("lmtp-socket", "", "Unix socket to serve LMTP on for a mail gateway, e.g. /run/herolauncher/lmtp.sock")
+	attachmentDir := flag.String("attachment-dir", "", "Directory to store large attachments in instead of Redis, shared with the IMAP and POP3 servers")
+	attachmentThreshold := flag.Int("attachment-threshold", mailblob.DefaultThreshold, "Size in bytes above which attachments are stored in -attachment-dir")
+	authAction := flag.String("auth-action", "", "Action for mail failing DMARC: reject, quarantine, tag or none (default: the sender domain's policy)")
 	flag.Parse()
 
 	// Create SMTP server configuration
-	config := smtp.DefaultConfig()
+	config := smtpserver.DefaultConfig()
 	config.Host = *host
 	config.Port = *port
 	config.Domain = *domain
 	config.RedisAddr = *redisAddr
 	config.RedisPassword = *redisPassword
 	config.RedisDB = *redisDB
+	config.RequireAuth = *requireAuth
+	config.Relay = *relay
+	config.MaxMessageBytes = *maxMessageSize
+	config.ConnRateLimit = *connRate
+	config.MessageRateLimit = *messageRate
+	config.VerifySenders = *verifySenders
+	config.LMTPSocket = *lmtpSocket
+	if *blockAttachments != "" {
+		config.Filters = append(config.Filters, &smtpserver.AttachmentFilter{Extensions: strings.Split(*blockAttachments, ",")})
+	}
+	if *blockKeywords != "" {
+		config.Filters = append(config.Filters, &smtpserver.KeywordFilter{Keywords: strings.Split(*blockKeywords, ","), Reject: true})
+	}
+	if *filterCommand != "" {
+		config.Filters = append(config.Filters, &smtpserver.CommandFilter{Command: *filterCommand})
+	}
+	if *filterURL != "" {
+		config.Filters = append(config.Filters, &smtpserver.RPCFilter{URL: *filterURL, Method: *filterMethod})
+	}
+	if *attachmentDir != "" {
+		if err := os.MkdirAll(*attachmentDir, 0700); err != nil {
+			log.Fatalf("Failed to create attachment directory: %v", err)
+		}
+		fs, err := vfslocal.New(*attachmentDir)
+		if err != nil {
+			log.Fatalf("Failed to open attachment directory: %v", err)
+		}
+		config.Attachments = mailblob.NewStore(fs, *attachmentThreshold)
+	}
+	if *authAction != "" {
+		config.AuthPolicies = map[string]smtpserver.AuthPolicy{"*": {Action: *authAction}}
+	}
+	if *useTLS {
+		config.UseTLS = true
+		config.TLSPort = *tlsPort
+		config.CertFile = *certFile
+		config.KeyFile = *keyFile
+		config.AllowInsecureAuth = *insecureAuth
+	}
 
 	// Create SMTP server
-	server, err := smtp.NewServer(config)
+	server, err := smtpserver.NewServer(config)
 	if err != nil {
 		log.Fatalf("Failed to create SMTP server: %v", err)
 	}
@@ -46,6 +110,36 @@ func main() {
 			log.Fatalf("Failed to start SMTP server: %v", err)
 		}
 	}()
+	if config.UseTLS && config.TLSPort != 0 {
+		go func() {
+			if err := server.StartTLS(); err != nil {
+				log.Fatalf("Failed to start SMTP TLS listener: %v", err)
+			}
+		}()
+	}
+
+	if config.LMTPSocket != "" {
+		go func() {
+			if err := server.StartLMTP(); err != nil {
+				log.Fatalf("Failed to start LMTP server: %v", err)
+			}
+		}()
+	}
+
+	// Deliver the mail queued for remote domains
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if config.Relay {
+		relayConfig := smtpserver.DefaultRelayConfig()
+		relayConfig.Hostname = config.Domain
+		relayConfig.MaxAttempts = *relayAttempts
+		relayer := smtpserver.NewRelay(server.GetRedisClient(), relayConfig)
+		go func() {
+			if err := relayer.Run(ctx); err != nil && err != context.Canceled {
+				log.Printf("Error relaying emails: %v", err)
+			}
+		}()
+	}
 
 	// Create a processor for incoming emails
 	processor := func(email *mail.Email) error {
@@ -63,7 +157,7 @@ func main() {
 	// Process emails from the queue in a goroutine
 	go func() {
 		log.Println("Starting email processor")
-		if err := smtp.ProcessEmails(server.GetRedisClient(), processor, 5*time.Second); err != nil {
+		if err := smtpserver.ProcessEmails(server.GetRedisClient(), processor, 5*time.Second); err != nil {
 			log.Printf("Error processing emails: %v", err)
 		}
 	}()
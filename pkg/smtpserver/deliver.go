@@ -0,0 +1,166 @@
+package smtpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	netmail "net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	mailmodel "github.com/freeflowuniverse/herolauncher/pkg/mail"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailblob"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailrules"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailsearch"
+	"github.com/redis/go-redis/v9"
+)
+
+// deliver stores a message in the mailboxes of mail users, as
+// mail:in:<user>:<mailbox>:<uid> like the IMAP server reads them. The
+// delivery rules of every user decide the mailboxes, whether the message
+// is marked as read and where it is forwarded to. Quarantined messages go
+// to their quarantine mailbox regardless of the rules.
+func (s *Session) deliver(ctx context.Context, users []string, email *mailmodel.Email, data []byte) error {
+	msg := ruleMessage(s.from, s.to, data)
+	for _, user := range users {
+		result := mailrules.Result{Folders: []string{mailrules.Inbox}}
+		if email.Mailbox != "" {
+			result.Folders = []string{strings.Trim(strings.ToLower(email.Mailbox), "/")}
+		} else {
+			result = s.evaluateRules(user, msg)
+		}
+
+		for _, folder := range result.Folders {
+			key, stored, err := deliverToMailbox(ctx, s.redisClient, s.attachments, user, folder, email, result.Seen)
+			if err != nil {
+				log.Printf("ERROR: Failed to deliver email to %s: %v", user, err)
+				return err
+			}
+			log.Printf("Delivered email to %s as %s", user, key)
+			s.notifyWebhooks(user, folder, key, stored)
+		}
+		if len(result.Forward) == 0 {
+			continue
+		}
+		if !s.relay {
+			log.Printf("Not forwarding email of %s to %v: relaying is disabled", user, result.Forward)
+			continue
+		}
+		if _, err := QueueForRelay(ctx, s.redisClient, s.from, result.Forward, data, user); err != nil {
+			log.Printf("ERROR: Failed to queue email of %s for forwarding: %v", user, err)
+			return err
+		}
+	}
+	return nil
+}
+
+// evaluateRules runs the delivery rules of a user. Mail of users whose
+// rules cannot be loaded is kept in the inbox.
+func (s *Session) evaluateRules(user string, msg *mailrules.Message) mailrules.Result {
+	inbox := mailrules.Result{Folders: []string{mailrules.Inbox}}
+	script, err := s.users.Rules(user)
+	if err != nil {
+		log.Printf("ERROR: Failed to load the rules of %s: %v", user, err)
+		return inbox
+	}
+	rules, err := mailrules.Parse(script)
+	if err != nil {
+		log.Printf("ERROR: Invalid rules of %s: %v", user, err)
+		return inbox
+	}
+	return rules.Evaluate(msg)
+}
+
+// ruleMessage returns what delivery rules are evaluated against: the
+// header fields of the message, with the envelope sender and recipients
+// for a missing From or To
+func ruleMessage(from string, to []string, data []byte) *mailrules.Message {
+	msg := &mailrules.Message{
+		From:   from,
+		To:     to,
+		Header: make(map[string][]string),
+		Size:   int64(len(data)),
+	}
+	fields, _ := splitMessage(data)
+	for _, field := range fields {
+		name := textproto.CanonicalMIMEHeaderKey(field.name)
+		msg.Header[name] = append(msg.Header[name], field.value())
+	}
+
+	if addresses, err := netmail.ParseAddressList(first(msg.Header["From"])); err == nil && len(addresses) > 0 {
+		msg.From = addresses[0].Address
+	}
+	var recipients []string
+	for _, name := range []string{"To", "Cc"} {
+		for _, value := range msg.Header[name] {
+			addresses, err := netmail.ParseAddressList(value)
+			if err != nil {
+				continue
+			}
+			for _, address := range addresses {
+				recipients = append(recipients, address.Address)
+			}
+		}
+	}
+	if len(recipients) > 0 {
+		msg.To = recipients
+	}
+	msg.Subject = first(msg.Header["Subject"])
+	return msg
+}
+
+// deliverToMailbox stores a copy of an email in a mailbox of a user under
+// a new UID, the current time in seconds like the IMAP server uses, indexes
+// it for search and returns its key and the copy. With an attachment store
+// the large attachments of the copy are kept there.
+func deliverToMailbox(ctx context.Context, redisClient *redis.Client, attachments *mailblob.Store, user, mailbox string, email *mailmodel.Email, seen bool) (string, *mailmodel.Email, error) {
+	uid := uint32(time.Now().Unix())
+	var key string
+	for {
+		key = fmt.Sprintf("mail:in:%s:%s:%d", user, mailbox, uid)
+		exists, err := redisClient.Exists(ctx, key).Result()
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to check key %s: %w", key, err)
+		}
+		if exists == 0 {
+			break
+		}
+		uid++
+	}
+
+	stored := *email
+	stored.UID = uid
+	stored.Mailbox = mailbox
+	stored.InternalDate = time.Now().Unix()
+	stored.Flags = nil
+	if seen {
+		stored.Flags = []string{"\\Seen"}
+	}
+	if attachments != nil {
+		stored.Attachments = append([]mailmodel.Attachment(nil), email.Attachments...)
+		if err := attachments.Offload(user, &stored); err != nil {
+			return "", nil, fmt.Errorf("failed to store attachments: %w", err)
+		}
+	}
+	emailJSON, err := json.Marshal(&stored)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal email: %w", err)
+	}
+	if err := redisClient.Set(ctx, key, string(emailJSON), 0).Err(); err != nil {
+		return "", nil, fmt.Errorf("failed to store email: %w", err)
+	}
+	if err := mailsearch.NewIndex(redisClient).Add(user, key, emailJSON); err != nil {
+		log.Printf("ERROR: Failed to index email %s: %v", key, err)
+	}
+	return key, &stored, nil
+}
+
+// first returns the first of a list of values, or an empty string
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,382 @@
+package smtpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DKIM results (RFC 8601 section 2.7.1)
+const (
+	DKIMNone      = "none"
+	DKIMPass      = "pass"
+	DKIMFail      = "fail"
+	DKIMTempError = "temperror"
+	DKIMPermError = "permerror"
+)
+
+// DKIMResult is the result of verifying one DKIM signature
+type DKIMResult struct {
+	Domain   string `json:"domain"`
+	Selector string `json:"selector,omitempty"`
+	Result   string `json:"result"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// headerField is a header field of a message as it was received
+type headerField struct {
+	name string
+	// raw is the whole field, folded lines and the final CRLF included
+	raw string
+}
+
+// splitMessage splits a message into its header fields and its body. Lines
+// ending in a bare LF are read as ending in CRLF.
+func splitMessage(data []byte) ([]headerField, []byte) {
+	if !bytes.Contains(data, []byte("\r\n")) {
+		data = bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))
+	}
+
+	var fields []headerField
+	rest := data
+	for len(rest) > 0 {
+		end := bytes.Index(rest, []byte("\r\n"))
+		if end < 0 {
+			end = len(rest)
+		} else {
+			end += 2
+		}
+		line := string(rest[:end])
+		rest = rest[end:]
+
+		if line == "\r\n" {
+			break
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += line
+			continue
+		}
+		name, _, _ := strings.Cut(line, ":")
+		fields = append(fields, headerField{name: strings.TrimSpace(name), raw: line})
+	}
+	return fields, rest
+}
+
+// value returns the value of the field, unfolded
+func (f headerField) value() string {
+	_, value, _ := strings.Cut(f.raw, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	return strings.TrimSpace(value)
+}
+
+// parseTags parses a tag list like "v=1; a=rsa-sha256" (RFC 6376 section
+// 3.2), removing all whitespace from the values
+func parseTags(s string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(s, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed tag %q", strings.TrimSpace(part))
+		}
+		name = strings.TrimSpace(name)
+		if _, dup := tags[name]; dup {
+			return nil, fmt.Errorf("duplicate tag %q", name)
+		}
+		tags[name] = strings.Join(strings.Fields(value), "")
+	}
+	return tags, nil
+}
+
+// verifyDKIM verifies the DKIM signatures of a message
+func verifyDKIM(ctx context.Context, resolver dnsResolver, data []byte) []DKIMResult {
+	fields, body := splitMessage(data)
+	var results []DKIMResult
+	for _, field := range fields {
+		if strings.EqualFold(field.name, "DKIM-Signature") {
+			results = append(results, verifySignature(ctx, resolver, fields, body, field))
+		}
+	}
+	return results
+}
+
+// dkimError is a failed verification with its result and reason
+type dkimError struct {
+	result string
+	reason string
+}
+
+func (e *dkimError) Error() string {
+	return e.reason
+}
+
+func dkimPermError(format string, args ...interface{}) error {
+	return &dkimError{result: DKIMPermError, reason: fmt.Sprintf(format, args...)}
+}
+
+func dkimFail(format string, args ...interface{}) error {
+	return &dkimError{result: DKIMFail, reason: fmt.Sprintf(format, args...)}
+}
+
+// verifySignature verifies one DKIM-Signature field (RFC 6376 section 6)
+func verifySignature(ctx context.Context, resolver dnsResolver, fields []headerField, body []byte, signature headerField) DKIMResult {
+	result := DKIMResult{Result: DKIMPass}
+	err := func() error {
+		tags, err := parseTags(signature.value())
+		if err != nil {
+			return dkimPermError("%v", err)
+		}
+		result.Domain, result.Selector = strings.ToLower(tags["d"]), tags["s"]
+		for _, tag := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+			if tags[tag] == "" {
+				return dkimPermError("missing tag %s", tag)
+			}
+		}
+		if tags["v"] != "1" {
+			return dkimPermError("unsupported version %s", tags["v"])
+		}
+		if expires, ok := tags["x"]; ok {
+			if x, err := strconv.ParseInt(expires, 10, 64); err == nil && x < time.Now().Unix() {
+				return dkimPermError("signature expired")
+			}
+		}
+
+		signed := strings.Split(tags["h"], ":")
+		hasFrom := false
+		for _, name := range signed {
+			if strings.EqualFold(name, "From") {
+				hasFrom = true
+			}
+		}
+		if !hasFrom {
+			return dkimPermError("From is not signed")
+		}
+		if identity := tags["i"]; identity != "" {
+			domain := addressDomain(identity)
+			if domain != result.Domain && !strings.HasSuffix(domain, "."+result.Domain) {
+				return dkimPermError("identity %s is not in domain %s", identity, result.Domain)
+			}
+		}
+
+		keyType, hashName, _ := strings.Cut(strings.ToLower(tags["a"]), "-")
+		var newHash func() hash.Hash
+		var cryptoHash crypto.Hash
+		switch hashName {
+		case "sha256":
+			newHash, cryptoHash = sha256.New, crypto.SHA256
+		default:
+			// rsa-sha1 must not be accepted anymore (RFC 8301 section 3.1)
+			return dkimPermError("unsupported algorithm %s", tags["a"])
+		}
+
+		headerCanon, bodyCanon, _ := strings.Cut(strings.ToLower(tags["c"]), "/")
+		if headerCanon == "" {
+			headerCanon = "simple"
+		}
+		if bodyCanon == "" {
+			bodyCanon = "simple"
+		}
+		if headerCanon != "simple" && headerCanon != "relaxed" || bodyCanon != "simple" && bodyCanon != "relaxed" {
+			return dkimPermError("unsupported canonicalization %s", tags["c"])
+		}
+
+		// Body hash
+		canonBody := canonicalBody(body, bodyCanon == "relaxed")
+		if length, ok := tags["l"]; ok {
+			l, err := strconv.Atoi(length)
+			if err != nil || l < 0 {
+				return dkimPermError("invalid body length %s", length)
+			}
+			if l > len(canonBody) {
+				return dkimFail("body is shorter than the signed length")
+			}
+			canonBody = canonBody[:l]
+		}
+		bodyHash := newHash()
+		bodyHash.Write(canonBody)
+		expected, err := base64.StdEncoding.DecodeString(tags["bh"])
+		if err != nil {
+			return dkimPermError("invalid body hash")
+		}
+		if subtle.ConstantTimeCompare(bodyHash.Sum(nil), expected) != 1 {
+			return dkimFail("body hash did not verify")
+		}
+
+		// Header hash: the signed fields, then the signature without its
+		// b= value
+		headerHash := newHash()
+		used := make(map[int]bool)
+		for _, name := range signed {
+			for i := len(fields) - 1; i >= 0; i-- {
+				if !used[i] && strings.EqualFold(fields[i].name, strings.TrimSpace(name)) {
+					used[i] = true
+					headerHash.Write([]byte(canonicalHeader(fields[i].raw, headerCanon == "relaxed")))
+					break
+				}
+			}
+		}
+		unsigned := canonicalHeader(removeSignature(signature.raw), headerCanon == "relaxed")
+		headerHash.Write([]byte(strings.TrimSuffix(unsigned, "\r\n")))
+		sum := headerHash.Sum(nil)
+
+		sig, err := base64.StdEncoding.DecodeString(tags["b"])
+		if err != nil {
+			return dkimPermError("invalid signature encoding")
+		}
+		key, err := lookupDKIMKey(ctx, resolver, result.Selector, result.Domain)
+		if err != nil {
+			return err
+		}
+		switch pub := key.(type) {
+		case *rsa.PublicKey:
+			if keyType != "rsa" {
+				return dkimPermError("key type does not match algorithm %s", tags["a"])
+			}
+			if err := rsa.VerifyPKCS1v15(pub, cryptoHash, sum, sig); err != nil {
+				return dkimFail("signature did not verify")
+			}
+		case ed25519.PublicKey:
+			if keyType != "ed25519" || cryptoHash != crypto.SHA256 {
+				return dkimPermError("key type does not match algorithm %s", tags["a"])
+			}
+			if !ed25519.Verify(pub, sum, sig) {
+				return dkimFail("signature did not verify")
+			}
+		}
+		return nil
+	}()
+
+	var dkimErr *dkimError
+	if errors.As(err, &dkimErr) {
+		result.Result, result.Reason = dkimErr.result, dkimErr.reason
+	}
+	return result
+}
+
+// lookupDKIMKey fetches the public key of a selector (RFC 6376 section 3.6)
+func lookupDKIMKey(ctx context.Context, resolver dnsResolver, selector, domain string) (crypto.PublicKey, error) {
+	txts, err := resolver.LookupTXT(ctx, selector+"._domainkey."+domain)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, dkimPermError("no key for selector %s", selector)
+		}
+		return nil, &dkimError{result: DKIMTempError, reason: fmt.Sprintf("key lookup failed: %v", err)}
+	}
+	if len(txts) == 0 {
+		return nil, dkimPermError("no key for selector %s", selector)
+	}
+
+	tags, err := parseTags(strings.Join(txts, ""))
+	if err != nil {
+		return nil, dkimPermError("invalid key record: %v", err)
+	}
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		return nil, dkimPermError("invalid key version %s", v)
+	}
+	if tags["p"] == "" {
+		return nil, dkimPermError("key revoked")
+	}
+	raw, err := base64.StdEncoding.DecodeString(tags["p"])
+	if err != nil {
+		return nil, dkimPermError("invalid key encoding")
+	}
+
+	switch keyType := strings.ToLower(tags["k"]); keyType {
+	case "", "rsa":
+		if key, err := x509.ParsePKIXPublicKey(raw); err == nil {
+			if rsaKey, ok := key.(*rsa.PublicKey); ok {
+				return rsaKey, nil
+			}
+			return nil, dkimPermError("key is not an RSA key")
+		}
+		key, err := x509.ParsePKCS1PublicKey(raw)
+		if err != nil {
+			return nil, dkimPermError("invalid RSA key")
+		}
+		return key, nil
+	case "ed25519":
+		if len(raw) != ed25519.PublicKeySize {
+			return nil, dkimPermError("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(raw), nil
+	default:
+		return nil, dkimPermError("unsupported key type %s", keyType)
+	}
+}
+
+// removeSignature empties the b= tag of a DKIM-Signature field
+func removeSignature(raw string) string {
+	name, value, _ := strings.Cut(raw, ":")
+	parts := strings.Split(value, ";")
+	for i, part := range parts {
+		tag, _, ok := strings.Cut(part, "=")
+		if ok && strings.TrimSpace(tag) == "b" {
+			parts[i] = tag + "="
+			if strings.HasSuffix(part, "\r\n") && i == len(parts)-1 {
+				parts[i] += "\r\n"
+			}
+		}
+	}
+	return name + ":" + strings.Join(parts, ";")
+}
+
+// canonicalHeader canonicalizes a header field (RFC 6376 section 3.4.1 and
+// 3.4.2)
+func canonicalHeader(raw string, relaxed bool) string {
+	if !relaxed {
+		return raw
+	}
+	name, value, _ := strings.Cut(raw, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value + "\r\n"
+}
+
+// canonicalBody canonicalizes a message body (RFC 6376 section 3.4.3 and
+// 3.4.4)
+func canonicalBody(body []byte, relaxed bool) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	// A body ending in CRLF leaves an empty last element
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if relaxed {
+		for i, line := range lines {
+			words := strings.FieldsFunc(line, isWSP)
+			line = strings.Join(words, " ")
+			if len(words) > 0 && isWSP(rune(lines[i][0])) {
+				line = " " + line
+			}
+			lines[i] = line
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if relaxed {
+			return nil
+		}
+		return []byte("\r\n")
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// isWSP reports whether r is a space or a tab
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
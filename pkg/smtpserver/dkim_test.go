@@ -0,0 +1,316 @@
+package smtpserver
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const dkimTestMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: bob@example.org\r\n" +
+	"Subject:  Hello   there\r\n" +
+	"\r\n" +
+	"Hi Bob,  \r\n" +
+	"\r\n" +
+	"how are you?\r\n" +
+	"\r\n\r\n"
+
+// dkimSign signs a message the way a sending server would, with the
+// canonicalization c and the signing algorithm of key
+func dkimSign(t *testing.T, key crypto.Signer, c, message string) string {
+	t.Helper()
+	headerCanon, bodyCanon, _ := strings.Cut(c, "/")
+	fields, body := splitMessage([]byte(message))
+
+	bodyHash := sha256.Sum256(canonicalBody(body, bodyCanon == "relaxed"))
+	algorithm := "rsa-sha256"
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		algorithm = "ed25519-sha256"
+	}
+	signature := "DKIM-Signature: v=1; a=" + algorithm + "; c=" + c + "; d=example.com; s=test;\r\n" +
+		"\th=From:To:Subject; bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]) + "; b="
+
+	headerHash := sha256.New()
+	for _, field := range fields {
+		headerHash.Write([]byte(canonicalHeader(field.raw, headerCanon == "relaxed")))
+	}
+	unsigned := canonicalHeader(signature+"\r\n", headerCanon == "relaxed")
+	headerHash.Write([]byte(strings.TrimSuffix(unsigned, "\r\n")))
+
+	var sig []byte
+	var err error
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		sig, err = key.Sign(nil, headerHash.Sum(nil), crypto.Hash(0))
+	} else {
+		sig, err = key.Sign(rand.Reader, headerHash.Sum(nil), crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	return signature + base64.StdEncoding.EncodeToString(sig) + "\r\n" + message
+}
+
+// dkimKeyRecord returns the TXT record that publishes the public key of key
+func dkimKeyRecord(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+	if pub, ok := key.Public().(ed25519.PublicKey); ok {
+		return "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatalf("Failed to marshal the key: %v", err)
+	}
+	return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(der)
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{input: "", want: map[string]string{}},
+		{input: "v=1; a=rsa-sha256", want: map[string]string{"v": "1", "a": "rsa-sha256"}},
+		{input: " v = 1 ;a=rsa-sha256;", want: map[string]string{"v": "1", "a": "rsa-sha256"}},
+		{input: "b=dGVz\r\n\t dA==; h=From : To", want: map[string]string{"b": "dGVzdA==", "h": "From:To"}},
+		{input: "p=", want: map[string]string{"p": ""}},
+		{input: "v=1; v=2", wantErr: true},
+		{input: "v=1; bogus", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTags(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseTags(%q) = %v, want an error", tt.input, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTags(%q) failed: %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTags(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalHeader(t *testing.T) {
+	// The example of RFC 6376 section 3.4.5
+	tests := []struct {
+		raw     string
+		relaxed bool
+		want    string
+	}{
+		{raw: "A: X\r\n", want: "A: X\r\n"},
+		{raw: "B : Y\t\r\n\tZ  \r\n", want: "B : Y\t\r\n\tZ  \r\n"},
+		{raw: "A: X\r\n", relaxed: true, want: "a:X\r\n"},
+		{raw: "B : Y\t\r\n\tZ  \r\n", relaxed: true, want: "b:Y Z\r\n"},
+		{raw: "Subject:\t Hello \t  there\r\n", relaxed: true, want: "subject:Hello there\r\n"},
+	}
+
+	for _, tt := range tests {
+		if got := canonicalHeader(tt.raw, tt.relaxed); got != tt.want {
+			t.Errorf("canonicalHeader(%q, %v) = %q, want %q", tt.raw, tt.relaxed, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalBody(t *testing.T) {
+	tests := []struct {
+		body    string
+		relaxed bool
+		want    string
+	}{
+		// The example of RFC 6376 section 3.4.5
+		{body: " C \r\nD \t E\r\n\r\n\r\n", want: " C \r\nD \t E\r\n"},
+		{body: " C \r\nD \t E\r\n\r\n\r\n", relaxed: true, want: " C\r\nD E\r\n"},
+		// An empty body is a single CRLF in simple and nothing in relaxed
+		{body: "", want: "\r\n"},
+		{body: "\r\n\r\n", want: "\r\n"},
+		{body: "", relaxed: true, want: ""},
+		{body: " \r\n", relaxed: true, want: ""},
+		// A missing final CRLF is added
+		{body: "text", want: "text\r\n"},
+		{body: "text \t", relaxed: true, want: "text\r\n"},
+	}
+
+	for _, tt := range tests {
+		if got := string(canonicalBody([]byte(tt.body), tt.relaxed)); got != tt.want {
+			t.Errorf("canonicalBody(%q, %v) = %q, want %q", tt.body, tt.relaxed, got, tt.want)
+		}
+	}
+}
+
+func TestVerifyDKIM(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate an Ed25519 key: %v", err)
+	}
+	rsaKey := mustRSAKey(t)
+	keys := func(key crypto.Signer) *fakeResolver {
+		return &fakeResolver{txt: map[string][]string{"test._domainkey.example.com": {dkimKeyRecord(t, key)}}}
+	}
+
+	tests := []struct {
+		name     string
+		message  string
+		resolver *fakeResolver
+		want     string
+		reason   string
+	}{
+		{
+			name:     "ed25519 relaxed",
+			message:  dkimSign(t, edKey, "relaxed/relaxed", dkimTestMessage),
+			resolver: keys(edKey),
+			want:     DKIMPass,
+		},
+		{
+			name:     "rsa simple",
+			message:  dkimSign(t, rsaKey, "simple/simple", dkimTestMessage),
+			resolver: keys(rsaKey),
+			want:     DKIMPass,
+		},
+		{
+			name: "relaxed survives whitespace changes",
+			message: strings.Replace(strings.Replace(dkimSign(t, rsaKey, "relaxed/relaxed", dkimTestMessage),
+				"Subject:  Hello   there", "subject: Hello there", 1), "Hi Bob,  ", "Hi  Bob,", 1),
+			resolver: keys(rsaKey),
+			want:     DKIMPass,
+		},
+		{
+			name: "simple header is broken by whitespace changes",
+			message: strings.Replace(dkimSign(t, rsaKey, "simple/simple", dkimTestMessage),
+				"Subject:  Hello   there", "Subject: Hello there", 1),
+			resolver: keys(rsaKey),
+			want:     DKIMFail,
+			reason:   "signature did not verify",
+		},
+		{
+			name: "simple body is broken by whitespace changes",
+			message: strings.Replace(dkimSign(t, rsaKey, "simple/simple", dkimTestMessage),
+				"Hi Bob,  ", "Hi Bob,", 1),
+			resolver: keys(rsaKey),
+			want:     DKIMFail,
+			reason:   "body hash did not verify",
+		},
+		{
+			name:     "wrong key",
+			message:  dkimSign(t, rsaKey, "relaxed/relaxed", dkimTestMessage),
+			resolver: keys(mustRSAKey(t)),
+			want:     DKIMFail,
+			reason:   "signature did not verify",
+		},
+		{
+			name:     "key type does not match",
+			message:  dkimSign(t, rsaKey, "relaxed/relaxed", dkimTestMessage),
+			resolver: keys(edKey),
+			want:     DKIMPermError,
+		},
+		{
+			name:     "no key",
+			message:  dkimSign(t, edKey, "relaxed/relaxed", dkimTestMessage),
+			resolver: &fakeResolver{},
+			want:     DKIMPermError,
+			reason:   "no key for selector test",
+		},
+		{
+			name:    "key lookup fails",
+			message: dkimSign(t, edKey, "relaxed/relaxed", dkimTestMessage),
+			resolver: &fakeResolver{
+				fail: map[string]bool{"test._domainkey.example.com": true},
+			},
+			want: DKIMTempError,
+		},
+		{
+			name:    "revoked key",
+			message: dkimSign(t, edKey, "relaxed/relaxed", dkimTestMessage),
+			resolver: &fakeResolver{txt: map[string][]string{
+				"test._domainkey.example.com": {"v=DKIM1; k=ed25519; p="},
+			}},
+			want:   DKIMPermError,
+			reason: "key revoked",
+		},
+		{
+			name: "rsa-sha1",
+			message: "DKIM-Signature: v=1; a=rsa-sha1; d=example.com; s=test; h=From;\r\n" +
+				"\tbh=2jmj7l5rSw0yVb/vlWAYkK/YBwk=; b=dGVzdA==\r\n" + dkimTestMessage,
+			resolver: keys(rsaKey),
+			want:     DKIMPermError,
+			reason:   "unsupported algorithm rsa-sha1",
+		},
+		{
+			name: "From not signed",
+			message: "DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=test; h=To:Subject;\r\n" +
+				"\tbh=dGVzdA==; b=dGVzdA==\r\n" + dkimTestMessage,
+			resolver: keys(rsaKey),
+			want:     DKIMPermError,
+			reason:   "From is not signed",
+		},
+		{
+			name: "expired",
+			message: "DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=test; h=From; x=1000;\r\n" +
+				"\tbh=dGVzdA==; b=dGVzdA==\r\n" + dkimTestMessage,
+			resolver: keys(rsaKey),
+			want:     DKIMPermError,
+			reason:   "signature expired",
+		},
+		{
+			name: "missing tag",
+			message: "DKIM-Signature: v=1; a=rsa-sha256; d=example.com; h=From;\r\n" +
+				"\tbh=dGVzdA==; b=dGVzdA==\r\n" + dkimTestMessage,
+			resolver: keys(rsaKey),
+			want:     DKIMPermError,
+			reason:   "missing tag s",
+		},
+		{
+			name: "identity outside the domain",
+			message: "DKIM-Signature: v=1; a=rsa-sha256; d=example.com; s=test; h=From;\r\n" +
+				"\ti=alice@example.org; bh=dGVzdA==; b=dGVzdA==\r\n" + dkimTestMessage,
+			resolver: keys(rsaKey),
+			want:     DKIMPermError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := verifyDKIM(context.Background(), tt.resolver, []byte(tt.message))
+			if len(results) != 1 {
+				t.Fatalf("Got %d results, want 1", len(results))
+			}
+			got := results[0]
+			if got.Result != tt.want {
+				t.Errorf("Result = %s (%s), want %s", got.Result, got.Reason, tt.want)
+			}
+			if tt.reason != "" && got.Reason != tt.reason {
+				t.Errorf("Reason = %q, want %q", got.Reason, tt.reason)
+			}
+			if got.Domain != "example.com" {
+				t.Errorf("Domain = %q, want example.com", got.Domain)
+			}
+		})
+	}
+
+	if results := verifyDKIM(context.Background(), &fakeResolver{}, []byte(dkimTestMessage)); len(results) != 0 {
+		t.Errorf("An unsigned message has results %v", results)
+	}
+}
+
+// mustRSAKey generates an RSA key for signing test messages
+func mustRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate an RSA key: %v", err)
+	}
+	return key
+}
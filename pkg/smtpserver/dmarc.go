@@ -0,0 +1,141 @@
+package smtpserver
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// DMARC results and policies (RFC 7489)
+const (
+	DMARCNone      = "none"
+	DMARCPass      = "pass"
+	DMARCFail      = "fail"
+	DMARCTempError = "temperror"
+	DMARCPermError = "permerror"
+
+	DMARCPolicyNone       = "none"
+	DMARCPolicyQuarantine = "quarantine"
+	DMARCPolicyReject     = "reject"
+)
+
+// dmarcRecord is the published DMARC policy of a domain
+type dmarcRecord struct {
+	policy          string
+	subdomainPolicy string
+	strictDKIM      bool
+	strictSPF       bool
+	percent         int
+}
+
+// lookupDMARC fetches the DMARC record of a domain, returning nil if it has
+// none
+func lookupDMARC(ctx context.Context, resolver dnsResolver, domain string) (*dmarcRecord, string) {
+	txts, err := resolver.LookupTXT(ctx, "_dmarc."+domain)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, DMARCNone
+		}
+		return nil, DMARCTempError
+	}
+
+	for _, txt := range txts {
+		if !strings.HasPrefix(strings.TrimSpace(txt), "v=DMARC1") {
+			continue
+		}
+		tags, err := parseTags(txt)
+		if err != nil || tags["v"] != "DMARC1" {
+			return nil, DMARCPermError
+		}
+		record := &dmarcRecord{
+			policy:     strings.ToLower(tags["p"]),
+			strictDKIM: strings.EqualFold(tags["adkim"], "s"),
+			strictSPF:  strings.EqualFold(tags["aspf"], "s"),
+			percent:    100,
+		}
+		switch record.policy {
+		case DMARCPolicyNone, DMARCPolicyQuarantine, DMARCPolicyReject:
+		default:
+			return nil, DMARCPermError
+		}
+		record.subdomainPolicy = strings.ToLower(tags["sp"])
+		if record.subdomainPolicy == "" {
+			record.subdomainPolicy = record.policy
+		}
+		if pct, err := strconv.Atoi(tags["pct"]); err == nil && pct >= 0 && pct <= 100 {
+			record.percent = pct
+		}
+		return record, ""
+	}
+	return nil, DMARCNone
+}
+
+// organizationalDomain returns the domain a name was registered as, one
+// label below its public suffix, as in sap.de for mail.sap.de (RFC 7489
+// section 3.2)
+func organizationalDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	org, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		// The name is a public suffix itself
+		return domain
+	}
+	return org
+}
+
+// aligned checks if an authenticated domain is aligned with the From domain
+func aligned(domain, fromDomain string, strict bool) bool {
+	domain, fromDomain = strings.ToLower(domain), strings.ToLower(fromDomain)
+	if strict {
+		return domain == fromDomain
+	}
+	return organizationalDomain(domain) == organizationalDomain(fromDomain)
+}
+
+// checkDMARC evaluates the DMARC policy of the From domain against the SPF
+// and DKIM results. It returns the result and the policy to apply to a
+// failing message.
+func checkDMARC(ctx context.Context, resolver dnsResolver, fromDomain, spfResult, spfDomain string, dkim []DKIMResult) (string, string) {
+	if fromDomain == "" {
+		return DMARCPermError, ""
+	}
+
+	record, result := lookupDMARC(ctx, resolver, fromDomain)
+	subdomain := false
+	if record == nil && result == DMARCNone {
+		if org := organizationalDomain(fromDomain); org != fromDomain {
+			record, result = lookupDMARC(ctx, resolver, org)
+			subdomain = true
+		}
+	}
+	if record == nil {
+		return result, ""
+	}
+
+	if spfResult == SPFPass && aligned(spfDomain, fromDomain, record.strictSPF) {
+		return DMARCPass, record.policy
+	}
+	for _, signature := range dkim {
+		if signature.Result == DKIMPass && aligned(signature.Domain, fromDomain, record.strictDKIM) {
+			return DMARCPass, record.policy
+		}
+	}
+
+	policy := record.policy
+	if subdomain {
+		policy = record.subdomainPolicy
+	}
+	// Outside the sampled percentage the next less strict policy applies
+	if record.percent < 100 && rand.Intn(100) >= record.percent {
+		switch policy {
+		case DMARCPolicyReject:
+			policy = DMARCPolicyQuarantine
+		case DMARCPolicyQuarantine:
+			policy = DMARCPolicyNone
+		}
+	}
+	return DMARCFail, policy
+}
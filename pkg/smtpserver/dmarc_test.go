@@ -0,0 +1,169 @@
+package smtpserver
+
+import (
+	"context"
+	"testing"
+)
+
+func TestOrganizationalDomain(t *testing.T) {
+	tests := map[string]string{
+		"example.com":         "example.com",
+		"mail.example.com":    "example.com",
+		"Mail.Example.COM.":   "example.com",
+		"mail.sap.de":         "sap.de",
+		"a.b.example.co.uk":   "example.co.uk",
+		"example.co.uk":       "example.co.uk",
+		"mail.example.com.au": "example.com.au",
+		"alice.github.io":     "alice.github.io",
+		"com":                 "com",
+		"co.uk":               "co.uk",
+	}
+	for domain, want := range tests {
+		if got := organizationalDomain(domain); got != want {
+			t.Errorf("organizationalDomain(%q) = %q, want %q", domain, got, want)
+		}
+	}
+}
+
+func TestAligned(t *testing.T) {
+	tests := []struct {
+		domain, from string
+		strict       bool
+		want         bool
+	}{
+		{domain: "example.com", from: "example.com", want: true},
+		{domain: "example.com", from: "example.com", strict: true, want: true},
+		{domain: "Example.COM", from: "example.com", strict: true, want: true},
+		{domain: "mail.sap.de", from: "sap.de", want: true},
+		{domain: "mail.sap.de", from: "sap.de", strict: true, want: false},
+		{domain: "bounce.example.com", from: "news.example.com", want: true},
+		{domain: "example.net", from: "example.com", want: false},
+		{domain: "example.co.uk", from: "other.co.uk", want: false},
+	}
+	for _, tt := range tests {
+		if got := aligned(tt.domain, tt.from, tt.strict); got != tt.want {
+			t.Errorf("aligned(%q, %q, %v) = %v, want %v", tt.domain, tt.from, tt.strict, got, tt.want)
+		}
+	}
+}
+
+func TestLookupDMARC(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []string
+		fail    bool
+		want    *dmarcRecord
+		result  string
+	}{
+		{
+			name:    "reject",
+			records: []string{"v=DMARC1; p=reject"},
+			want:    &dmarcRecord{policy: "reject", subdomainPolicy: "reject", percent: 100},
+		},
+		{
+			name:    "all tags",
+			records: []string{"v=DMARC1; p=Quarantine; sp=none; adkim=s; aspf=s; pct=20; rua=mailto:d@example.com"},
+			want: &dmarcRecord{policy: "quarantine", subdomainPolicy: "none",
+				strictDKIM: true, strictSPF: true, percent: 20},
+		},
+		{
+			name:    "other records are skipped",
+			records: []string{"google-site-verification=abc", "v=DMARC1; p=none"},
+			want:    &dmarcRecord{policy: "none", subdomainPolicy: "none", percent: 100},
+		},
+		{
+			name:    "invalid pct is ignored",
+			records: []string{"v=DMARC1; p=none; pct=200"},
+			want:    &dmarcRecord{policy: "none", subdomainPolicy: "none", percent: 100},
+		},
+		{name: "invalid policy", records: []string{"v=DMARC1; p=drop"}, result: DMARCPermError},
+		{name: "invalid tags", records: []string{"v=DMARC1; p=none; p=reject"}, result: DMARCPermError},
+		{name: "no DMARC record", records: []string{"v=spf1 -all"}, result: DMARCNone},
+		{name: "no record", result: DMARCNone},
+		{name: "lookup fails", fail: true, result: DMARCTempError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver := &fakeResolver{txt: map[string][]string{}, fail: map[string]bool{"_dmarc.example.com": tt.fail}}
+			if tt.records != nil {
+				resolver.txt["_dmarc.example.com"] = tt.records
+			}
+			record, result := lookupDMARC(context.Background(), resolver, "example.com")
+			if result != tt.result {
+				t.Errorf("Result = %q, want %q", result, tt.result)
+			}
+			if tt.want == nil {
+				if record != nil {
+					t.Errorf("Record = %+v, want none", record)
+				}
+				return
+			}
+			if record == nil || *record != *tt.want {
+				t.Errorf("Record = %+v, want %+v", record, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDMARC(t *testing.T) {
+	resolver := &fakeResolver{
+		txt: map[string][]string{
+			"_dmarc.example.com": {"v=DMARC1; p=reject; sp=quarantine"},
+			"_dmarc.strict.net":  {"v=DMARC1; p=quarantine; adkim=s; aspf=s"},
+			"_dmarc.sampled.org": {"v=DMARC1; p=reject; pct=0"},
+			"_dmarc.open.org":    {"v=DMARC1; p=none"},
+		},
+		fail: map[string]bool{"_dmarc.broken.com": true},
+	}
+	pass := func(domain string) []DKIMResult {
+		return []DKIMResult{{Domain: domain, Result: DKIMPass}}
+	}
+
+	tests := []struct {
+		name       string
+		fromDomain string
+		spf        string
+		spfDomain  string
+		dkim       []DKIMResult
+		want       string
+		policy     string
+	}{
+		{name: "aligned SPF", fromDomain: "example.com", spf: SPFPass, spfDomain: "bounce.example.com",
+			want: DMARCPass, policy: "reject"},
+		{name: "aligned DKIM", fromDomain: "example.com", spf: SPFFail, spfDomain: "example.com",
+			dkim: pass("example.com"), want: DMARCPass, policy: "reject"},
+		{name: "unaligned SPF", fromDomain: "example.com", spf: SPFPass, spfDomain: "example.net",
+			want: DMARCFail, policy: "reject"},
+		{name: "unaligned DKIM", fromDomain: "example.com", spf: SPFNone,
+			dkim: pass("example.net"), want: DMARCFail, policy: "reject"},
+		{name: "failed DKIM", fromDomain: "example.com", spf: SPFNone,
+			dkim: []DKIMResult{{Domain: "example.com", Result: DKIMFail}}, want: DMARCFail, policy: "reject"},
+		{name: "subdomain policy", fromDomain: "news.example.com", spf: SPFFail, spfDomain: "news.example.com",
+			want: DMARCFail, policy: "quarantine"},
+		{name: "subdomain passes relaxed", fromDomain: "news.example.com", spf: SPFNone,
+			dkim: pass("example.com"), want: DMARCPass, policy: "reject"},
+		{name: "strict DKIM", fromDomain: "strict.net", spf: SPFNone,
+			dkim: pass("mail.strict.net"), want: DMARCFail, policy: "quarantine"},
+		{name: "strict SPF", fromDomain: "strict.net", spf: SPFPass, spfDomain: "mail.strict.net",
+			want: DMARCFail, policy: "quarantine"},
+		{name: "strict aligned", fromDomain: "strict.net", spf: SPFPass, spfDomain: "strict.net",
+			want: DMARCPass, policy: "quarantine"},
+		{name: "outside the sample", fromDomain: "sampled.org", spf: SPFFail, spfDomain: "sampled.org",
+			want: DMARCFail, policy: "quarantine"},
+		{name: "policy none", fromDomain: "open.org", spf: SPFFail, spfDomain: "open.org",
+			want: DMARCFail, policy: "none"},
+		{name: "no record", fromDomain: "example.net", spf: SPFFail, spfDomain: "example.net", want: DMARCNone},
+		{name: "lookup fails", fromDomain: "broken.com", spf: SPFFail, want: DMARCTempError},
+		{name: "no From", spf: SPFPass, spfDomain: "example.com", want: DMARCPermError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, policy := checkDMARC(context.Background(), resolver, tt.fromDomain, tt.spf, tt.spfDomain, tt.dkim)
+			if result != tt.want || policy != tt.policy {
+				t.Errorf("checkDMARC() = %q, %q, want %q, %q", result, policy, tt.want, tt.policy)
+			}
+		})
+	}
+}
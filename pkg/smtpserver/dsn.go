@@ -0,0 +1,122 @@
+package smtpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/redis/go-redis/v9"
+)
+
+// dsnRecipient is a recipient a message could not be delivered to
+type dsnRecipient struct {
+	Address string
+	// Status is the enhanced status code, like 5.1.1
+	Status string
+	// Diagnostic is the reply of the mail server, like "550 5.1.1 User unknown"
+	Diagnostic string
+}
+
+// enhancedCode matches an enhanced status code at the start of a reply
+var enhancedCode = regexp.MustCompile(`^([245])\.(\d{1,3})\.(\d{1,3})\b`)
+
+// dsnRecipientFor describes the error of delivering to a recipient. Replies
+// of mail servers keep their status, other errors are reported as 4.4.1,
+// no answer from the host.
+func dsnRecipientFor(address string, err error) dsnRecipient {
+	var replyErr *textproto.Error
+	if errors.As(err, &replyErr) {
+		status := enhancedCode.FindString(replyErr.Msg)
+		if status == "" {
+			status = fmt.Sprintf("%d.0.0", replyErr.Code/100)
+		}
+		return dsnRecipient{Address: address, Status: status, Diagnostic: fmt.Sprintf("%d %s", replyErr.Code, replyErr.Msg)}
+	}
+	var smtpErr *smtp.SMTPError
+	if errors.As(err, &smtpErr) {
+		status := fmt.Sprintf("%d.%d.%d", smtpErr.EnhancedCode[0], smtpErr.EnhancedCode[1], smtpErr.EnhancedCode[2])
+		return dsnRecipient{Address: address, Status: status, Diagnostic: fmt.Sprintf("%d %s %s", smtpErr.Code, status, smtpErr.Message)}
+	}
+	return dsnRecipient{Address: address, Status: "4.4.1", Diagnostic: err.Error()}
+}
+
+// buildDSN returns a delivery status notification (RFC 3464) telling the
+// sender of a message that it could not be delivered to recipients. The
+// notification holds the header of the message.
+func buildDSN(reportingMTA, sender string, arrival time.Time, original []byte, failed []dsnRecipient) []byte {
+	boundaryBytes := make([]byte, 12)
+	rand.Read(boundaryBytes)
+	boundary := "dsn-" + hex.EncodeToString(boundaryBytes)
+	daemon := "MAILER-DAEMON@" + reportingMTA
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: Mail Delivery System <%s>\r\n", daemon)
+	fmt.Fprintf(&b, "To: <%s>\r\n", sender)
+	b.WriteString("Subject: Undelivered Mail Returned to Sender\r\n")
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", boundary, reportingMTA)
+	b.WriteString("Auto-Submitted: auto-replied\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: multipart/report; report-type=delivery-status;\r\n\tboundary=\"%s\"\r\n\r\n", boundary)
+
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "This is the mail system at %s.\r\n\r\n", reportingMTA)
+	b.WriteString("Your message could not be delivered to the following recipients:\r\n\r\n")
+	for _, rcpt := range failed {
+		fmt.Fprintf(&b, "  %s: %s\r\n", rcpt.Address, rcpt.Diagnostic)
+	}
+	b.WriteString("\r\n")
+
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	b.WriteString("Content-Type: message/delivery-status\r\n\r\n")
+	fmt.Fprintf(&b, "Reporting-MTA: dns; %s\r\n", reportingMTA)
+	fmt.Fprintf(&b, "Arrival-Date: %s\r\n", arrival.Format(time.RFC1123Z))
+	for _, rcpt := range failed {
+		b.WriteString("\r\n")
+		fmt.Fprintf(&b, "Final-Recipient: rfc822; %s\r\n", rcpt.Address)
+		b.WriteString("Action: failed\r\n")
+		fmt.Fprintf(&b, "Status: %s\r\n", rcpt.Status)
+		fmt.Fprintf(&b, "Diagnostic-Code: smtp; %s\r\n", strings.ReplaceAll(rcpt.Diagnostic, "\n", " "))
+	}
+	b.WriteString("\r\n")
+
+	fields, _ := splitMessage(original)
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	b.WriteString("Content-Type: text/rfc822-headers\r\n\r\n")
+	for _, field := range fields {
+		b.WriteString(field.raw)
+	}
+	fmt.Fprintf(&b, "\r\n--%s--\r\n", boundary)
+	return b.Bytes()
+}
+
+// returnDSN sends a delivery status notification to the sender of a
+// message: into the inbox of the mail user that sent it, or else through
+// the relay queue with an empty sender, so notifications are never
+// returned themselves
+func returnDSN(ctx context.Context, redisClient *redis.Client, reportingMTA, user, sender string, dsn []byte) error {
+	if user != "" {
+		email, err := parseEmail("MAILER-DAEMON@"+reportingMTA, []string{sender}, dsn)
+		if err != nil {
+			return err
+		}
+		key, _, err := deliverToMailbox(ctx, redisClient, nil, user, "inbox", email, false)
+		if err != nil {
+			return err
+		}
+		log.Printf("Delivered delivery status notification to %s as %s", user, key)
+		return nil
+	}
+	_, err := QueueForRelay(ctx, redisClient, "", []string{sender}, dsn, "")
+	return err
+}
@@ -0,0 +1,200 @@
+package smtpserver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	netmail "net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailauth"
+)
+
+func TestDSNRecipientFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		status     string
+		diagnostic string
+	}{
+		{
+			name:       "reply with enhanced code",
+			err:        &textproto.Error{Code: 550, Msg: "5.1.1 User unknown"},
+			status:     "5.1.1",
+			diagnostic: "550 5.1.1 User unknown",
+		},
+		{
+			name:       "reply without enhanced code",
+			err:        &textproto.Error{Code: 452, Msg: "Mailbox full"},
+			status:     "4.0.0",
+			diagnostic: "452 Mailbox full",
+		},
+		{
+			name:       "SMTP error",
+			err:        &smtp.SMTPError{Code: 552, EnhancedCode: smtp.EnhancedCode{5, 2, 2}, Message: "Mailbox full"},
+			status:     "5.2.2",
+			diagnostic: "552 5.2.2 Mailbox full",
+		},
+		{
+			name:       "connection error",
+			err:        errors.New("connection refused"),
+			status:     "4.4.1",
+			diagnostic: "connection refused",
+		},
+	}
+	for _, tt := range tests {
+		got := dsnRecipientFor("bob@example.org", tt.err)
+		if got.Address != "bob@example.org" || got.Status != tt.status || got.Diagnostic != tt.diagnostic {
+			t.Errorf("%s: got %+v, want status %s and diagnostic %q", tt.name, got, tt.status, tt.diagnostic)
+		}
+	}
+}
+
+func TestBuildDSN(t *testing.T) {
+	arrival := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	failed := []dsnRecipient{
+		{Address: "bob@example.org", Status: "5.1.1", Diagnostic: "550 5.1.1 User unknown"},
+		{Address: "carol@example.org", Status: "4.4.1", Diagnostic: "connection\nrefused"},
+	}
+	dsn := buildDSN("mail.example.com", "alice@example.com", arrival, []byte(testMessage), failed)
+
+	msg, err := netmail.ReadMessage(strings.NewReader(string(dsn)))
+	if err != nil {
+		t.Fatalf("The notification is not a message: %v", err)
+	}
+	if from := msg.Header.Get("From"); !strings.Contains(from, "MAILER-DAEMON@mail.example.com") {
+		t.Errorf("From is %q", from)
+	}
+	if msg.Header.Get("Auto-Submitted") != "auto-replied" {
+		t.Error("The notification is not marked as auto-replied")
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" || params["report-type"] != "delivery-status" {
+		t.Fatalf("Content-Type is %q", msg.Header.Get("Content-Type"))
+	}
+
+	var types, bodies []string
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read a part: %v", err)
+		}
+		body, _ := io.ReadAll(part)
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		types = append(types, contentType)
+		bodies = append(bodies, string(body))
+	}
+	if got := strings.Join(types, ", "); got != "text/plain, message/delivery-status, text/rfc822-headers" {
+		t.Fatalf("The parts are %s", got)
+	}
+	if !strings.Contains(bodies[0], "bob@example.org: 550 5.1.1 User unknown") {
+		t.Errorf("The explanation does not list the recipients:\n%s", bodies[0])
+	}
+	for _, want := range []string{
+		"Reporting-MTA: dns; mail.example.com",
+		"Arrival-Date: Fri, 01 Mar 2024 12:00:00 +0000",
+		"Final-Recipient: rfc822; bob@example.org\r\nAction: failed\r\nStatus: 5.1.1\r\n",
+		"Final-Recipient: rfc822; carol@example.org\r\nAction: failed\r\nStatus: 4.4.1\r\nDiagnostic-Code: smtp; connection refused",
+	} {
+		if !strings.Contains(bodies[1], want) {
+			t.Errorf("The delivery status does not contain %q:\n%s", want, bodies[1])
+		}
+	}
+	if !strings.Contains(bodies[2], "Subject: Lunch") || strings.Contains(bodies[2], "lunch today") {
+		t.Errorf("The returned header is wrong:\n%s", bodies[2])
+	}
+}
+
+func TestReturnFailuresToUser(t *testing.T) {
+	s := newTestServer(t, nil)
+
+	// Unknown users are refused to clients that did not authenticate
+	c := s.dial()
+	c.Mail("carol@example.net", nil)
+	if err := c.Rcpt("nobody@example.com", nil); smtpCode(err) != 550 {
+		t.Errorf("RCPT to an unknown user returned %v, want a 550 error", err)
+	}
+
+	// A mail user is told with a notification in the inbox
+	err := send(s.login("alice"), "alice@example.com", []string{"bob@example.com", "nobody@example.com"}, testMessage)
+	if err != nil {
+		t.Fatalf("Sending to an unknown user failed: %v", err)
+	}
+	if inbox := s.mailbox("bob", "inbox"); len(inbox) != 1 {
+		t.Errorf("Bob's inbox has %d messages, want 1", len(inbox))
+	}
+	inbox := s.mailbox("alice", "inbox")
+	if len(inbox) != 1 || inbox[0].Subject() != "Undelivered Mail Returned to Sender" {
+		t.Fatalf("Alice's inbox has %d messages, want the notification", len(inbox))
+	}
+	if !strings.Contains(inbox[0].Message, "nobody@example.com: 550 5.1.1 No such user here") {
+		t.Errorf("The notification does not list the unknown user:\n%s", inbox[0].Message)
+	}
+
+	// Without any deliverable recipient the message is refused
+	c = s.login("alice")
+	if err := send(c, "alice@example.com", []string{"nobody@example.com"}, testMessage); smtpCode(err) != 550 {
+		t.Errorf("Sending to unknown users only returned %v, want a 550 error", err)
+	}
+}
+
+func TestReturnFailuresToVerifiedSenders(t *testing.T) {
+	s := newTestServer(t, func(config *Config) {
+		config.Relay = true
+		config.VerifySenders = true
+	})
+	s.backend().resolver = &fakeResolver{txt: map[string][]string{
+		"sender.test": {"v=spf1 ip4:127.0.0.1 -all"},
+		"forged.test": {"v=spf1 ip4:192.0.2.1 -all"},
+	}}
+	if err := s.backend().users.SetQuota("bob", mailauth.Quota{Storage: 10}); err != nil {
+		t.Fatalf("Failed to set the quota: %v", err)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		sender string
+		dsn    bool
+	}{
+		{sender: "carol@sender.test", dsn: true},
+		{sender: "mallory@forged.test", dsn: false},
+		{sender: "dave@unknown.test", dsn: false},
+	}
+	for _, tt := range tests {
+		s.client.Del(ctx, relayQueue)
+		if err := send(s.dial(), tt.sender, []string{"alice@example.com", "bob@example.com"}, testMessage); err != nil {
+			t.Fatalf("Sending from %s failed: %v", tt.sender, err)
+		}
+
+		ids, _ := s.client.LRange(ctx, relayQueue, 0, -1).Result()
+		if !tt.dsn {
+			if len(ids) != 0 {
+				t.Errorf("A notification was queued for %s", tt.sender)
+			}
+			continue
+		}
+		if len(ids) != 1 {
+			t.Fatalf("%d notifications were queued for %s, want 1", len(ids), tt.sender)
+		}
+		fields, _ := s.client.HGetAll(ctx, relayKey(ids[0])).Result()
+		if fields["from"] != "" || fields["to"] != `["`+tt.sender+`"]` {
+			t.Errorf("The notification is queued from %q to %s", fields["from"], fields["to"])
+		}
+		if !strings.Contains(fields["data"], "bob@example.com: 552 5.2.2 Mailbox full") {
+			t.Errorf("The notification does not report the full mailbox:\n%s", fields["data"])
+		}
+	}
+	if inbox := s.mailbox("alice", "inbox"); len(inbox) != len(tests) {
+		t.Errorf("Alice's inbox has %d messages, want %d", len(inbox), len(tests))
+	}
+}
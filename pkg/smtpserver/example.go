@@ -75,7 +75,7 @@ func Example() {
 			}
 
 			fmt.Printf("Email %s: From=%s, To=%v, Subject=%s\n",
-				mailID, email.From, email.To, email.Subject)
+				mailID, email.From(), email.To(), email.Subject())
 		}
 	}()
 
@@ -0,0 +1,387 @@
+package smtpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	netmail "net/mail"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// Filters see every message after DATA and before it is stored. They can
+// accept or reject it, add header fields, or replace it, e.g. to integrate
+// spam and virus scanners. Filters run in order, every filter sees the
+// changes of the ones before it, and the first to reject ends the chain.
+
+// Filter actions
+const (
+	FilterAccept = "accept"
+	FilterReject = "reject"
+)
+
+// FilterMessage is a received message as seen by a filter
+type FilterMessage struct {
+	From string   `json:"from"`
+	To   []string `json:"to"`
+	// User is the mail user that submitted the message, if the client
+	// authenticated
+	User string `json:"user,omitempty"`
+	IP   string `json:"ip,omitempty"`
+	// Data is the message with its header
+	Data []byte `json:"data"`
+}
+
+// FilterHeader is a header field added by a filter
+type FilterHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// FilterResult is the verdict of a filter, a nil result accepts the message
+// unchanged
+type FilterResult struct {
+	Action string `json:"action"`
+	// Reason is sent to the client when the message is rejected
+	Reason string `json:"reason,omitempty"`
+	// Headers are added at the top of the message
+	Headers []FilterHeader `json:"headers,omitempty"`
+	// Data replaces the message when set
+	Data []byte `json:"data,omitempty"`
+}
+
+// Filter inspects received messages
+type Filter interface {
+	Filter(ctx context.Context, msg *FilterMessage) (*FilterResult, error)
+}
+
+// FilterFunc is a function used as a Filter
+type FilterFunc func(ctx context.Context, msg *FilterMessage) (*FilterResult, error)
+
+// Filter calls f
+func (f FilterFunc) Filter(ctx context.Context, msg *FilterMessage) (*FilterResult, error) {
+	return f(ctx, msg)
+}
+
+// runFilters passes a message through the filters of the session and
+// returns it as changed by them. A rejected message gives a 550 error, a
+// failing filter a temporary error so the client tries again later.
+func (s *Session) runFilters(ctx context.Context, data []byte) ([]byte, error) {
+	if len(s.filters) == 0 {
+		return data, nil
+	}
+	msg := &FilterMessage{From: s.from, To: s.to, User: s.user, Data: data}
+	if s.ip != nil {
+		msg.IP = s.ip.String()
+	}
+
+	for _, filter := range s.filters {
+		result, err := filter.Filter(ctx, msg)
+		if err != nil {
+			log.Printf("ERROR: Filter %T failed: %v", filter, err)
+			return nil, &smtp.SMTPError{
+				Code:         451,
+				EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+				Message:      "Message could not be checked, try again later",
+			}
+		}
+		if result == nil {
+			continue
+		}
+
+		switch result.Action {
+		case FilterAccept, "":
+		case FilterReject:
+			reason := result.Reason
+			if reason == "" {
+				reason = "Message rejected"
+			}
+			log.Printf("Filter %T rejected mail from %s: %s", filter, s.from, reason)
+			return nil, &smtp.SMTPError{
+				Code:         550,
+				EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+				Message:      reason,
+			}
+		default:
+			log.Printf("ERROR: Filter %T returned unknown action %q", filter, result.Action)
+			return nil, &smtp.SMTPError{
+				Code:         451,
+				EnhancedCode: smtp.EnhancedCode{4, 3, 0},
+				Message:      "Message could not be checked, try again later",
+			}
+		}
+
+		if result.Data != nil {
+			msg.Data = result.Data
+		}
+		if len(result.Headers) > 0 {
+			var header bytes.Buffer
+			for _, field := range result.Headers {
+				fmt.Fprintf(&header, "%s: %s\r\n", field.Name, field.Value)
+			}
+			msg.Data = append(header.Bytes(), msg.Data...)
+		}
+	}
+	return msg.Data, nil
+}
+
+// AttachmentFilter rejects messages with attachments of blocked types
+type AttachmentFilter struct {
+	// Extensions are blocked file name extensions, like ".exe"
+	Extensions []string
+	// ContentTypes are blocked media types, like "application/x-msdownload"
+	ContentTypes []string
+}
+
+// Filter rejects the message if one of its parts is blocked
+func (f *AttachmentFilter) Filter(ctx context.Context, msg *FilterMessage) (*FilterResult, error) {
+	var blocked string
+	err := walkParts(msg.Data, func(header textHeader, body []byte) bool {
+		mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+		for _, contentType := range f.ContentTypes {
+			if strings.EqualFold(mediaType, contentType) {
+				blocked = mediaType
+				return false
+			}
+		}
+
+		filename := params["name"]
+		if _, dispositionParams, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil && dispositionParams["filename"] != "" {
+			filename = dispositionParams["filename"]
+		}
+		ext := strings.ToLower(path.Ext(filename))
+		for _, blockedExt := range f.Extensions {
+			if ext != "" && strings.EqualFold(ext, blockedExt) {
+				blocked = filename
+				return false
+			}
+		}
+		return true
+	})
+	// Messages that cannot be parsed have no attachments to block
+	if err != nil {
+		return nil, nil
+	}
+	if blocked != "" {
+		return &FilterResult{Action: FilterReject, Reason: fmt.Sprintf("Attachment %s is not allowed", blocked)}, nil
+	}
+	return nil, nil
+}
+
+// KeywordFilter looks for keywords in the subject and the text of messages
+type KeywordFilter struct {
+	// Keywords are matched case-insensitively
+	Keywords []string
+	// Reject rejects matching messages, otherwise they are flagged with the
+	// X-Spam-Flag and X-Keywords header fields
+	Reject bool
+}
+
+// Filter rejects or flags the message if it contains a keyword
+func (f *KeywordFilter) Filter(ctx context.Context, msg *FilterMessage) (*FilterResult, error) {
+	var text strings.Builder
+	walkParts(msg.Data, func(header textHeader, body []byte) bool {
+		if subject := header.Get("Subject"); subject != "" {
+			decoded, err := new(mime.WordDecoder).DecodeHeader(subject)
+			if err != nil {
+				decoded = subject
+			}
+			text.WriteString(decoded + "\n")
+		}
+		mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+		if mediaType == "" || strings.HasPrefix(mediaType, "text/") {
+			text.Write(body)
+			text.WriteString("\n")
+		}
+		return true
+	})
+
+	content := strings.ToLower(text.String())
+	var found []string
+	for _, keyword := range f.Keywords {
+		if keyword != "" && strings.Contains(content, strings.ToLower(keyword)) {
+			found = append(found, keyword)
+		}
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+	if f.Reject {
+		return &FilterResult{Action: FilterReject, Reason: "Message contains blocked content"}, nil
+	}
+	return &FilterResult{
+		Action: FilterAccept,
+		Headers: []FilterHeader{
+			{Name: "X-Spam-Flag", Value: "YES"},
+			{Name: "X-Keywords", Value: strings.Join(found, ", ")},
+		},
+	}, nil
+}
+
+// CommandFilter passes messages to an external command on its standard
+// input. Exit status 0 accepts the message, replaced by the output of the
+// command if it wrote any. Exit status 1 rejects it, with the first line
+// written to standard error as the reason. Any other status, or a timeout,
+// is a failure of the filter.
+type CommandFilter struct {
+	Command string
+	Args    []string
+	// Timeout limits the run time of the command, 30 seconds by default
+	Timeout time.Duration
+}
+
+// Filter runs the command
+func (f *CommandFilter) Filter(ctx context.Context, msg *FilterMessage) (*FilterResult, error) {
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, f.Command, f.Args...)
+	cmd.Stdin = bytes.NewReader(msg.Data)
+	cmd.Env = append(cmd.Environ(),
+		"SMTP_FROM="+msg.From,
+		"SMTP_TO="+strings.Join(msg.To, ","),
+		"SMTP_USER="+msg.User,
+		"SMTP_IP="+msg.IP,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		reason, _, _ := strings.Cut(strings.TrimSpace(stderr.String()), "\n")
+		return &FilterResult{Action: FilterReject, Reason: reason}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("filter command %s failed: %w", f.Command, err)
+	}
+	if stdout.Len() > 0 {
+		return &FilterResult{Action: FilterAccept, Data: stdout.Bytes()}, nil
+	}
+	return nil, nil
+}
+
+// RPCFilter calls a JSON-RPC 2.0 method over HTTP, such as an OpenRPC
+// service, with the FilterMessage as its single parameter. The result is
+// a FilterResult. The data of both is base64 encoded.
+type RPCFilter struct {
+	URL    string
+	Method string
+	// Timeout limits the call, 30 seconds by default
+	Timeout time.Duration
+}
+
+// Filter calls the method
+func (f *RPCFilter) Filter(ctx context.Context, msg *FilterMessage) (*FilterResult, error) {
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	request, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  f.Method,
+		"params":  []interface{}{msg},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.URL, bytes.NewReader(request))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("filter call %s failed: %w", f.Method, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("filter call %s failed: %s", f.Method, resp.Status)
+	}
+
+	var response struct {
+		Result *FilterResult `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("invalid response to filter call %s: %w", f.Method, err)
+	}
+	if response.Error != nil {
+		return nil, fmt.Errorf("filter call %s failed: %d %s", f.Method, response.Error.Code, response.Error.Message)
+	}
+	return response.Result, nil
+}
+
+// textHeader is the header of a message or a part
+type textHeader = netmail.Header
+
+// walkParts calls fn with the header and the decoded body of the message
+// and of each of its parts, depth first, until fn returns false
+func walkParts(data []byte, fn func(header textHeader, body []byte) bool) error {
+	msg, err := netmail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	_, err = walkPart(msg.Header, msg.Body, fn)
+	return err
+}
+
+func walkPart(header textHeader, body io.Reader, fn func(header textHeader, body []byte) bool) (bool, error) {
+	mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		var reader io.Reader = body
+		switch strings.ToLower(header.Get("Content-Transfer-Encoding")) {
+		case "base64":
+			reader = base64.NewDecoder(base64.StdEncoding, body)
+		case "quoted-printable":
+			reader = quotedprintable.NewReader(body)
+		}
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return false, err
+		}
+		return fn(header, content), nil
+	}
+
+	if !fn(header, nil) {
+		return false, nil
+	}
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if more, err := walkPart(textHeader(part.Header), part, fn); err != nil || !more {
+			return more, err
+		}
+	}
+}
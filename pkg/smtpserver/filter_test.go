@@ -0,0 +1,264 @@
+package smtpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+)
+
+const attachmentMessage = "From: carol@example.net\r\n" +
+	"To: bob@example.com\r\n" +
+	"Subject: Invoice\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=b1\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Please find the invoice attached.\r\n" +
+	"--b1\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Disposition: attachment; filename=\"invoice.exe\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"TVqQAAMAAAAEAAAA\r\n" +
+	"--b1--\r\n"
+
+func TestFilters(t *testing.T) {
+	var mu sync.Mutex
+	var seen *FilterMessage
+	s := newTestServer(t, func(config *Config) {
+		config.Filters = []Filter{
+			&KeywordFilter{Keywords: []string{"lottery"}},
+			&AttachmentFilter{Extensions: []string{".exe"}},
+			FilterFunc(func(ctx context.Context, msg *FilterMessage) (*FilterResult, error) {
+				mu.Lock()
+				copied := *msg
+				seen = &copied
+				mu.Unlock()
+				if msg.From == "broken@example.net" {
+					return nil, errors.New("scanner is down")
+				}
+				if strings.Contains(string(msg.Data), "Subject: Rewrite me") {
+					return &FilterResult{Data: []byte(strings.Replace(string(msg.Data), "Rewrite me", "Rewritten", 1))}, nil
+				}
+				return nil, nil
+			}),
+		}
+	})
+	lastSeen := func() *FilterMessage {
+		mu.Lock()
+		defer mu.Unlock()
+		return seen
+	}
+	c := s.dial()
+
+	// Accepted unchanged
+	if err := send(c, "carol@example.net", []string{"bob@example.com"}, testMessage); err != nil {
+		t.Fatalf("Sending a clean message failed: %v", err)
+	}
+	msg := lastSeen()
+	if msg.From != "carol@example.net" || len(msg.To) != 1 || msg.To[0] != "bob@example.com" || msg.IP != "127.0.0.1" {
+		t.Errorf("The filter saw from %q, to %v, IP %q", msg.From, msg.To, msg.IP)
+	}
+	if string(msg.Data) != testMessage {
+		t.Errorf("A clean message was changed before the last filter:\n%s", msg.Data)
+	}
+
+	// Flagged by the keyword filter, later filters see its header fields
+	lottery := strings.Replace(testMessage, "Lunch", "You won the lottery", 1)
+	if err := send(c, "carol@example.net", []string{"bob@example.com"}, lottery); err != nil {
+		t.Fatalf("Sending a flagged message failed: %v", err)
+	}
+	if data := string(lastSeen().Data); !strings.HasPrefix(data, "X-Spam-Flag: YES\r\nX-Keywords: lottery\r\n") {
+		t.Errorf("The flagged message does not start with the added fields:\n%s", data)
+	}
+
+	// Rejected by the attachment filter
+	err := send(c, "carol@example.net", []string{"bob@example.com"}, attachmentMessage)
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 550 || !strings.Contains(smtpErr.Message, "invoice.exe") {
+		t.Errorf("Sending a blocked attachment returned %v, want a 550 error naming it", err)
+	}
+
+	// A failing filter makes the client try again later
+	c.Reset()
+	if err := send(c, "broken@example.net", []string{"bob@example.com"}, testMessage); smtpCode(err) != 451 {
+		t.Errorf("A failing filter returned %v, want a 451 error", err)
+	}
+
+	// A filter replaces the message
+	c.Reset()
+	rewrite := strings.Replace(testMessage, "Lunch", "Rewrite me", 1)
+	if err := send(c, "carol@example.net", []string{"bob@example.com"}, rewrite); err != nil {
+		t.Fatalf("Sending a message to rewrite failed: %v", err)
+	}
+
+	var subjects []string
+	for _, email := range s.mailbox("bob", "inbox") {
+		subjects = append(subjects, email.Subject())
+	}
+	if got := strings.Join(subjects, ", "); got != "Lunch, Rewritten, You won the lottery" {
+		t.Errorf("Bob's inbox has %s", got)
+	}
+}
+
+func TestKeywordFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  *KeywordFilter
+		message string
+		action  string
+	}{
+		{name: "no match", filter: &KeywordFilter{Keywords: []string{"lottery"}}, message: testMessage},
+		{name: "subject", filter: &KeywordFilter{Keywords: []string{"LUNCH"}}, message: testMessage, action: FilterAccept},
+		{name: "body", filter: &KeywordFilter{Keywords: []string{"today"}, Reject: true}, message: testMessage, action: FilterReject},
+		{
+			name:    "encoded subject",
+			filter:  &KeywordFilter{Keywords: []string{"café"}, Reject: true},
+			message: "Subject: =?utf-8?q?caf=C3=A9?=\r\n\r\nHello\r\n",
+			action:  FilterReject,
+		},
+		{
+			name:    "attachments are not searched",
+			filter:  &KeywordFilter{Keywords: []string{"TVqQ"}, Reject: true},
+			message: attachmentMessage,
+		},
+	}
+	for _, tt := range tests {
+		result, err := tt.filter.Filter(context.Background(), &FilterMessage{Data: []byte(tt.message)})
+		if err != nil {
+			t.Errorf("%s: Filter failed: %v", tt.name, err)
+			continue
+		}
+		action := ""
+		if result != nil {
+			action = result.Action
+		}
+		if action != tt.action {
+			t.Errorf("%s: action %q, want %q", tt.name, action, tt.action)
+		}
+	}
+}
+
+func TestAttachmentFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *AttachmentFilter
+		reject bool
+	}{
+		{name: "extension", filter: &AttachmentFilter{Extensions: []string{".EXE"}}, reject: true},
+		{name: "content type", filter: &AttachmentFilter{ContentTypes: []string{"application/octet-stream"}}, reject: true},
+		{name: "other types", filter: &AttachmentFilter{Extensions: []string{".bat"}, ContentTypes: []string{"application/zip"}}},
+	}
+	for _, tt := range tests {
+		result, err := tt.filter.Filter(context.Background(), &FilterMessage{Data: []byte(attachmentMessage)})
+		if err != nil {
+			t.Errorf("%s: Filter failed: %v", tt.name, err)
+			continue
+		}
+		if rejected := result != nil && result.Action == FilterReject; rejected != tt.reject {
+			t.Errorf("%s: rejected = %v, want %v", tt.name, rejected, tt.reject)
+		}
+	}
+}
+
+func TestCommandFilter(t *testing.T) {
+	msg := &FilterMessage{From: "carol@example.net", To: []string{"bob@example.com"}, Data: []byte(testMessage)}
+	tests := []struct {
+		name   string
+		script string
+		action string
+		reason string
+		data   string
+		fails  bool
+	}{
+		{name: "accept", script: "cat >/dev/null"},
+		{name: "replace", script: `echo "X-Scanned-For: $SMTP_TO"; cat`, action: FilterAccept,
+			data: "X-Scanned-For: bob@example.com\n" + testMessage},
+		{name: "reject", script: `echo "Virus found in mail from $SMTP_FROM" >&2; echo more >&2; exit 1`,
+			action: FilterReject, reason: "Virus found in mail from carol@example.net"},
+		{name: "failure", script: "exit 2", fails: true},
+	}
+	for _, tt := range tests {
+		filter := &CommandFilter{Command: "sh", Args: []string{"-c", tt.script}}
+		result, err := filter.Filter(context.Background(), msg)
+		if tt.fails {
+			if err == nil {
+				t.Errorf("%s: the filter did not fail", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Filter failed: %v", tt.name, err)
+			continue
+		}
+		if tt.action == "" {
+			if result != nil {
+				t.Errorf("%s: result %+v, want none", tt.name, result)
+			}
+			continue
+		}
+		if result == nil || result.Action != tt.action || result.Reason != tt.reason || string(result.Data) != tt.data {
+			t.Errorf("%s: result %+v", tt.name, result)
+		}
+	}
+}
+
+func TestRPCFilter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		var request struct {
+			Method string          `json:"method"`
+			Params []FilterMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Params) != 1 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		switch request.Method {
+		case "scan":
+			action := FilterAccept
+			if strings.Contains(string(request.Params[0].Data), "lottery") {
+				action = FilterReject
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"jsonrpc": "2.0", "id": 1,
+				"result": FilterResult{Action: action, Reason: "Spam from " + request.Params[0].From},
+			})
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"jsonrpc": "2.0", "id": 1,
+				"error": map[string]interface{}{"code": -32601, "message": "Method not found"},
+			})
+		}
+	}))
+	defer server.Close()
+
+	filter := &RPCFilter{URL: server.URL, Method: "scan"}
+	msg := &FilterMessage{From: "carol@example.net", Data: []byte("Subject: You won the lottery\r\n\r\n")}
+	result, err := filter.Filter(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("Filter failed: %v", err)
+	}
+	if result.Action != FilterReject || result.Reason != "Spam from carol@example.net" {
+		t.Errorf("Result %+v, want a rejection", result)
+	}
+
+	if _, err := (&RPCFilter{URL: server.URL, Method: "unknown"}).Filter(context.Background(), msg); err == nil {
+		t.Error("An error response did not fail the filter")
+	}
+	if _, err := (&RPCFilter{URL: server.URL + "/missing", Method: "scan"}).Filter(context.Background(), msg); err == nil {
+		t.Error("A bad HTTP status did not fail the filter")
+	}
+}
@@ -0,0 +1,150 @@
+package smtpserver
+
+import (
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+// rateLimiter allows a number of events per period for every key, e.g. a
+// client address, as a token bucket that refills over the period
+type rateLimiter struct {
+	limit  int
+	period time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+// rateBucket holds the events a key has left
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a limiter allowing limit events per period, or
+// returns nil, which allows everything, if limit is not positive
+func newRateLimiter(limit int, period time.Duration) *rateLimiter {
+	if limit <= 0 || period <= 0 {
+		return nil
+	}
+	return &rateLimiter{limit: limit, period: period, buckets: make(map[string]*rateBucket)}
+}
+
+// allow takes an event for key, it returns false if key is over the limit
+func (l *rateLimiter) allow(key string) bool {
+	if l == nil {
+		return true
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Forget keys that are back at their full allowance
+	if len(l.buckets) > 4096 {
+		for k, b := range l.buckets {
+			if l.refill(b, now) >= float64(l.limit) {
+				delete(l.buckets, k)
+			}
+		}
+	}
+
+	b := l.buckets[key]
+	if b == nil {
+		b = &rateBucket{tokens: float64(l.limit), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refill returns the tokens of a bucket at now
+func (l *rateLimiter) refill(b *rateBucket, now time.Time) float64 {
+	tokens := b.tokens + float64(l.limit)*now.Sub(b.last).Seconds()/l.period.Seconds()
+	if tokens > float64(l.limit) {
+		tokens = float64(l.limit)
+	}
+	return tokens
+}
+
+// listen opens a TCP listener on addr of which the connections are rate
+// limited per client address
+func (s *Server) listen(addr string, plain bool) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if s.connLimiter == nil {
+		return listener, nil
+	}
+	return &limitListener{Listener: listener, limiter: s.connLimiter, plain: plain}, nil
+}
+
+// limitListener refuses connections from addresses that connect too often.
+// On plain listeners the client is told so with a 421 greeting, on TLS
+// listeners the connection is just closed.
+type limitListener struct {
+	net.Listener
+	limiter *rateLimiter
+	plain   bool
+}
+
+// Accept returns the next connection that is within the limit
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		host := remoteHost(conn)
+		if l.limiter.allow(host) {
+			return conn, nil
+		}
+		log.Printf("Refusing SMTP connection from %s: too many connections", host)
+		if l.plain {
+			io.WriteString(conn, "421 4.7.0 Too many connections from your address, try again later\r\n")
+		}
+		conn.Close()
+	}
+}
+
+// remoteHost returns the address of the client without the port
+func remoteHost(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// checkMessageRate rejects a message of a sender that sent too many. The
+// sender is the mail user when the client authenticated, the MAIL FROM
+// address otherwise and the client address for bounces.
+func (s *Session) checkMessageRate(from string) error {
+	sender := s.user
+	if sender == "" {
+		sender = strings.ToLower(from)
+	}
+	if sender == "" && s.ip != nil {
+		sender = s.ip.String()
+	}
+	if s.messageLimiter.allow(sender) {
+		return nil
+	}
+	log.Printf("Rejecting mail from %s: too many messages", sender)
+	return &smtp.SMTPError{
+		Code:         451,
+		EnhancedCode: smtp.EnhancedCode{4, 7, 1},
+		Message:      "Too many messages, try again later",
+	}
+}
@@ -0,0 +1,108 @@
+package smtpserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(2, time.Minute)
+	if !limiter.allow("a") || !limiter.allow("a") {
+		t.Fatal("The first two events were not allowed")
+	}
+	if limiter.allow("a") {
+		t.Error("The third event was allowed")
+	}
+	if !limiter.allow("b") {
+		t.Error("Another key was limited too")
+	}
+
+	// Half a period later one event is allowed again
+	limiter.buckets["a"].last = limiter.buckets["a"].last.Add(-30 * time.Second)
+	if !limiter.allow("a") {
+		t.Error("No event was allowed after half a period")
+	}
+	if limiter.allow("a") {
+		t.Error("More than one event was allowed after half a period")
+	}
+
+	unlimited := newRateLimiter(0, time.Minute)
+	for i := 0; i < 100; i++ {
+		if !unlimited.allow("a") {
+			t.Fatal("A limiter without limit refused an event")
+		}
+	}
+}
+
+func TestMessageSizeLimit(t *testing.T) {
+	s := newTestServer(t, func(config *Config) { config.MaxMessageBytes = 1024 })
+
+	c := s.dial()
+	if ok, size := c.Extension("SIZE"); !ok || size != "1024" {
+		t.Errorf("EHLO advertises SIZE %q, want 1024", size)
+	}
+	large := testMessage + strings.Repeat("All work and no play makes Jack a dull boy.\r\n", 30)
+	if err := send(c, "carol@example.net", []string{"bob@example.com"}, large); smtpCode(err) != 552 {
+		t.Errorf("Sending a message over the limit returned %v, want a 552 error", err)
+	}
+	if err := c.Reset(); err != nil {
+		t.Fatalf("RSET failed: %v", err)
+	}
+	if err := send(c, "carol@example.net", []string{"bob@example.com"}, testMessage); err != nil {
+		t.Fatalf("Sending a message within the limit failed: %v", err)
+	}
+	if inbox := s.mailbox("bob", "inbox"); len(inbox) != 1 {
+		t.Errorf("Bob's inbox has %d messages, want 1", len(inbox))
+	}
+}
+
+func TestConnectionRateLimit(t *testing.T) {
+	s := newTestServer(t, func(config *Config) { config.ConnRateLimit = 2 })
+
+	s.dial()
+	s.dial()
+	c, err := smtp.Dial(s.addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer c.Close()
+	// The client reads the greeting with its first command
+	err = c.Hello("client.example.net")
+	if err == nil {
+		t.Fatal("A third connection within the period was accepted")
+	}
+	if smtpCode(err) != 421 {
+		t.Errorf("The third connection got %v, want a 421 greeting", err)
+	}
+}
+
+func TestMessageRateLimit(t *testing.T) {
+	s := newTestServer(t, func(config *Config) { config.MessageRateLimit = 2 })
+
+	c := s.dial()
+	for i := 0; i < 2; i++ {
+		if err := send(c, "carol@example.net", []string{"bob@example.com"}, testMessage); err != nil {
+			t.Fatalf("Message %d failed: %v", i+1, err)
+		}
+	}
+	if err := c.Mail("Carol@example.net", nil); smtpCode(err) != 451 {
+		t.Errorf("A third message of the sender returned %v, want a 451 error", err)
+	}
+	if err := send(c, "dave@example.net", []string{"bob@example.com"}, testMessage); err != nil {
+		t.Errorf("Another sender was limited too: %v", err)
+	}
+
+	// An authenticated user is limited whatever the MAIL FROM address
+	alice := s.login("alice")
+	for i, from := range []string{"alice@example.com", "a@example.com"} {
+		if err := send(alice, from, []string{"bob@example.com"}, testMessage); err != nil {
+			t.Fatalf("Message %d of alice failed: %v", i+1, err)
+		}
+	}
+	if err := alice.Mail("b@example.com", nil); smtpCode(err) != 451 {
+		t.Errorf("A third message of alice returned %v, want a 451 error", err)
+	}
+}
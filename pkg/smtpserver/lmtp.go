@@ -0,0 +1,102 @@
+package smtpserver
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"github.com/emersion/go-smtp"
+	"golang.org/x/net/context"
+)
+
+// StartLMTP serves LMTP (RFC 2033) on the unix socket LMTPSocket, so a mail
+// gateway like Postfix can hand received mail to the mail store, with
+// lmtp:unix:<socket> as its transport. The gateway is trusted: it does not
+// authenticate, its senders are not verified and it may only deliver to
+// the mail users of Domain, which get their mail like over SMTP.
+func (s *Server) StartLMTP() error {
+	if s.config.LMTPSocket == "" {
+		return fmt.Errorf("no LMTP socket configured")
+	}
+	// Remove the socket left by an earlier run
+	if err := os.Remove(s.config.LMTPSocket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove old LMTP socket: %w", err)
+	}
+	listener, err := net.Listen("unix", s.config.LMTPSocket)
+	if err != nil {
+		log.Printf("ERROR: LMTP server failed to start: %v", err)
+		return err
+	}
+	// Let the gateway, running as another user, connect
+	if err := os.Chmod(s.config.LMTPSocket, 0666); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to set LMTP socket permissions: %w", err)
+	}
+
+	s.lmtpServer = smtp.NewServer(s.smtpServer.Backend)
+	s.lmtpServer.LMTP = true
+	s.lmtpServer.Domain = s.smtpServer.Domain
+	s.lmtpServer.ReadTimeout = s.smtpServer.ReadTimeout
+	s.lmtpServer.WriteTimeout = s.smtpServer.WriteTimeout
+	s.lmtpServer.MaxMessageBytes = s.smtpServer.MaxMessageBytes
+	s.lmtpServer.MaxRecipients = s.smtpServer.MaxRecipients
+	log.Printf("Starting LMTP server at %s", s.config.LMTPSocket)
+	return s.lmtpServer.Serve(listener)
+}
+
+// LMTPData handles the DATA command of LMTP, replying for every recipient
+// whether the message was delivered to the mail users it is routed to
+func (s *Session) LMTPData(r io.Reader, status smtp.StatusCollector) error {
+	log.Printf("LMTP DATA received from %s to %v", s.from, s.to)
+	data, err := io.ReadAll(r)
+	if err != nil {
+		log.Printf("ERROR: Failed to read email data: %v", err)
+		return err
+	}
+
+	ctx := context.Background()
+	data, err = s.runFilters(ctx, data)
+	if err != nil {
+		return err
+	}
+	email, err := parseEmail(s.from, s.to, data)
+	if err != nil {
+		log.Printf("ERROR: Failed to parse email: %v", err)
+		return err
+	}
+	if _, err := storeEmail(ctx, s.redisClient, email, nil, s.user); err != nil {
+		return err
+	}
+
+	// A user the message was delivered to for one recipient does not get
+	// it again for another. A recipient routed to several users fails only
+	// when the message fits the quota of none of them.
+	var delivered []string
+	for _, rcpt := range s.to {
+		var users []string
+		var quotaErr error
+		pending := false
+		for _, user := range s.routes[rcpt] {
+			if containsString(delivered, user) {
+				continue
+			}
+			pending = true
+			if err := s.checkQuota(user, int64(len(data))); err != nil {
+				quotaErr = err
+				continue
+			}
+			users = append(users, user)
+		}
+
+		var err error
+		if pending && len(users) == 0 {
+			err = quotaErr
+		} else if err = s.deliver(ctx, users, email, data); err == nil {
+			delivered = append(delivered, users...)
+		}
+		status.SetStatus(rcpt, err)
+	}
+	return nil
+}
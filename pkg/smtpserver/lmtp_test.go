@@ -0,0 +1,109 @@
+package smtpserver
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailauth"
+)
+
+// dialLMTP starts LMTP on the test server and connects to it as a mail
+// gateway
+func dialLMTP(t *testing.T, s *testServer) *smtp.Client {
+	t.Helper()
+	errs := make(chan error, 1)
+	go func() { errs <- s.StartLMTP() }()
+
+	var conn net.Conn
+	var err error
+	for i := 0; ; i++ {
+		if conn, err = net.Dial("unix", s.config.LMTPSocket); err == nil {
+			break
+		}
+		select {
+		case err := <-errs:
+			t.Fatalf("StartLMTP failed: %v", err)
+		default:
+		}
+		if i == 50 {
+			t.Fatalf("Failed to connect to the LMTP socket: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	c := smtp.NewClientLMTP(conn)
+	t.Cleanup(func() { c.Close() })
+	if err := c.Hello("gateway.example.com"); err != nil {
+		t.Fatalf("LHLO failed: %v", err)
+	}
+	return c
+}
+
+func TestLMTP(t *testing.T) {
+	s := newTestServer(t, func(config *Config) {
+		config.RequireAuth = true
+		config.LMTPSocket = filepath.Join(t.TempDir(), "lmtp.sock")
+	})
+	if err := s.backend().users.SetQuota("bob", mailauth.Quota{Storage: 10}); err != nil {
+		t.Fatalf("Failed to set the quota: %v", err)
+	}
+	if err := s.backend().users.SetAlias("lunch@example.com", []string{"alice"}); err != nil {
+		t.Fatalf("Failed to set the alias: %v", err)
+	}
+	c := dialLMTP(t, s)
+
+	// The gateway does not authenticate
+	if err := c.Mail("carol@example.net", nil); err != nil {
+		t.Fatalf("MAIL FROM failed: %v", err)
+	}
+	for _, rcpt := range []string{"alice@example.com", "bob@example.com", "lunch@example.com"} {
+		if err := c.Rcpt(rcpt, nil); err != nil {
+			t.Fatalf("RCPT TO %s failed: %v", rcpt, err)
+		}
+	}
+	// It may only deliver to the mail users
+	for _, rcpt := range []string{"nobody@example.com", "dave@example.net"} {
+		if err := c.Rcpt(rcpt, nil); smtpCode(err) != 550 {
+			t.Errorf("RCPT TO %s returned %v, want a 550 error", rcpt, err)
+		}
+	}
+
+	// Every recipient gets its own reply
+	statuses := make(map[string]int)
+	w, err := c.LMTPData(func(rcpt string, status *smtp.SMTPError) {
+		statuses[rcpt] = 250
+		if status != nil {
+			statuses[rcpt] = status.Code
+		}
+	})
+	if err != nil {
+		t.Fatalf("DATA failed: %v", err)
+	}
+	w.Write([]byte(testMessage))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Sending the message failed: %v", err)
+	}
+	want := map[string]int{"alice@example.com": 250, "bob@example.com": 552, "lunch@example.com": 250}
+	for rcpt, code := range want {
+		if statuses[rcpt] != code {
+			t.Errorf("The reply for %s is %d, want %d", rcpt, statuses[rcpt], code)
+		}
+	}
+
+	// Alice gets the message once, for her address and the alias
+	if inbox := s.mailbox("alice", "inbox"); len(inbox) != 1 || inbox[0].Subject() != "Lunch" {
+		t.Errorf("Alice's inbox has %d messages, want 1", len(inbox))
+	}
+	if inbox := s.mailbox("bob", "inbox"); len(inbox) != 0 {
+		t.Errorf("Bob's inbox has %d messages over his quota", len(inbox))
+	}
+}
+
+func TestStartLMTPWithoutSocket(t *testing.T) {
+	s := newTestServer(t, nil)
+	if err := s.StartLMTP(); err == nil {
+		t.Error("StartLMTP without a socket did not fail")
+	}
+}
@@ -247,7 +247,7 @@ func parseEmailManually(from string, to []string, data []byte) (*mailmodel.Email
 
 	// Debug output
 	fmt.Printf("DEBUG: Manually parsed email - Subject: %s, Body length: %d\n",
-		email.Subject, len(email.Message))
+		email.Subject(), len(email.Message))
 
 	return email, nil
 }
@@ -0,0 +1,442 @@
+package smtpserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Mail for remote recipients is queued for delivery on the list mail:relay,
+// with every delivery in a hash mail:relay:<id> holding the sender, the
+// recipients still to deliver to, the message and the attempts so far. A
+// Relay delivers the queued mail to the MX hosts of the recipients' domains,
+// retries temporary failures with exponential backoff and bounces the
+// message to the sender after the last attempt.
+
+// relayQueue is the list of deliveries waiting for the relay
+const relayQueue = "mail:relay"
+
+// relayKey returns the hash holding a delivery
+func relayKey(id string) string {
+	return fmt.Sprintf("mail:relay:%s", id)
+}
+
+// RelayConfig holds the configuration of the outbound relay
+type RelayConfig struct {
+	// Hostname is sent in EHLO and is the domain bounces are sent from
+	Hostname string
+	// Port is the SMTP port of the remote mail servers
+	Port int
+	// MaxAttempts is the number of delivery attempts before the message is
+	// bounced
+	MaxAttempts int
+	// RetryDelay is the wait before the first retry, doubled on every
+	// further attempt up to MaxRetryDelay
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// PollInterval is how often the queue is checked for due deliveries
+	PollInterval time.Duration
+	// Timeout limits a whole delivery to one mail server
+	Timeout time.Duration
+}
+
+// DefaultRelayConfig returns the default configuration of the relay
+func DefaultRelayConfig() RelayConfig {
+	return RelayConfig{
+		Hostname:      "localhost",
+		Port:          25,
+		MaxAttempts:   8,
+		RetryDelay:    5 * time.Minute,
+		MaxRetryDelay: 4 * time.Hour,
+		PollInterval:  10 * time.Second,
+		Timeout:       5 * time.Minute,
+	}
+}
+
+// Relay delivers queued mail to remote mail servers
+type Relay struct {
+	redisClient *redis.Client
+	config      RelayConfig
+	// lookupMX resolves the mail servers of a domain
+	lookupMX func(domain string) ([]*net.MX, error)
+}
+
+// NewRelay creates a relay delivering the mail queued in Redis
+func NewRelay(redisClient *redis.Client, config RelayConfig) *Relay {
+	return &Relay{
+		redisClient: redisClient,
+		config:      config,
+		lookupMX:    net.LookupMX,
+	}
+}
+
+// QueueForRelay queues a message for delivery to remote recipients and
+// returns the ID of the delivery. user is the mail user that sent it, who
+// receives the bounce if it cannot be delivered.
+func QueueForRelay(ctx context.Context, redisClient *redis.Client, from string, to []string, data []byte, user string) (string, error) {
+	idBytes := make([]byte, 12)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", fmt.Errorf("failed to generate delivery ID: %w", err)
+	}
+	id := hex.EncodeToString(idBytes)
+
+	recipients, err := json.Marshal(to)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal recipients: %w", err)
+	}
+	fields := map[string]string{
+		"from":     from,
+		"to":       string(recipients),
+		"data":     string(data),
+		"user":     user,
+		"attempts": "0",
+		"next":     "0",
+	}
+	for field, value := range fields {
+		if err := redisClient.HSet(ctx, relayKey(id), field, value).Err(); err != nil {
+			return "", fmt.Errorf("failed to store delivery: %w", err)
+		}
+	}
+	if err := redisClient.RPush(ctx, relayQueue, id).Err(); err != nil {
+		return "", fmt.Errorf("failed to queue delivery: %w", err)
+	}
+	log.Printf("Queued mail from %s to %v for relay with ID %s", from, to, id)
+	return id, nil
+}
+
+// delivery is a queued message
+type delivery struct {
+	id       string
+	from     string
+	to       []string
+	data     []byte
+	user     string
+	attempts int
+	next     int64
+}
+
+// loadDelivery reads a delivery from Redis
+func (r *Relay) loadDelivery(ctx context.Context, id string) (*delivery, error) {
+	fields := map[string]string{}
+	for _, field := range []string{"from", "to", "data", "user", "attempts", "next"} {
+		value, err := r.redisClient.HGet(ctx, relayKey(id), field).Result()
+		if err != nil && err != redis.Nil {
+			return nil, fmt.Errorf("failed to read delivery %s: %w", id, err)
+		}
+		fields[field] = value
+	}
+
+	d := &delivery{id: id, from: fields["from"], data: []byte(fields["data"]), user: fields["user"]}
+	if err := json.Unmarshal([]byte(fields["to"]), &d.to); err != nil {
+		return nil, fmt.Errorf("invalid recipients of delivery %s: %w", id, err)
+	}
+	d.attempts, _ = strconv.Atoi(fields["attempts"])
+	d.next, _ = strconv.ParseInt(fields["next"], 10, 64)
+	return d, nil
+}
+
+// Run delivers queued mail until the context is cancelled
+func (r *Relay) Run(ctx context.Context) error {
+	log.Printf("Starting mail relay, checking the queue every %v", r.config.PollInterval)
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.ProcessQueue(ctx); err != nil {
+			log.Printf("ERROR: Failed to process relay queue: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessQueue goes through the queue once, attempting the deliveries that
+// are due
+func (r *Relay) ProcessQueue(ctx context.Context) error {
+	length, err := r.redisClient.LLen(ctx, relayQueue).Result()
+	if err != nil {
+		return fmt.Errorf("failed to read queue length: %w", err)
+	}
+	for i := int64(0); i < length; i++ {
+		id, err := r.redisClient.LPop(ctx, relayQueue).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to take delivery from queue: %w", err)
+		}
+
+		d, err := r.loadDelivery(ctx, id)
+		if err != nil {
+			log.Printf("ERROR: Dropping delivery %s: %v", id, err)
+			continue
+		}
+		if d.next > time.Now().Unix() {
+			if err := r.redisClient.RPush(ctx, relayQueue, id).Err(); err != nil {
+				return fmt.Errorf("failed to requeue delivery %s: %w", id, err)
+			}
+			continue
+		}
+		if err := r.attempt(ctx, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attempt delivers a message to its remaining recipients, and then requeues
+// it for the recipients that failed temporarily or bounces it for those
+// that failed permanently
+func (r *Relay) attempt(ctx context.Context, d *delivery) error {
+	d.attempts++
+	log.Printf("Relaying %s from %s to %v, attempt %d", d.id, d.from, d.to, d.attempts)
+
+	byDomain := make(map[string][]string)
+	for _, rcpt := range d.to {
+		byDomain[addressDomain(rcpt)] = append(byDomain[addressDomain(rcpt)], rcpt)
+	}
+
+	var retry []string
+	failed := make(map[string]error)
+	for domain, rcpts := range byDomain {
+		for rcpt, err := range r.deliverDomain(domain, d.from, rcpts, d.data) {
+			switch {
+			case err == nil:
+				log.Printf("Relayed %s to %s", d.id, rcpt)
+			case isPermanent(err) || d.attempts >= r.config.MaxAttempts:
+				log.Printf("Giving up relaying %s to %s: %v", d.id, rcpt, err)
+				failed[rcpt] = err
+			default:
+				log.Printf("Relaying %s to %s failed, will retry: %v", d.id, rcpt, err)
+				retry = append(retry, rcpt)
+				failed[rcpt] = err
+			}
+		}
+	}
+
+	bounced := make(map[string]error)
+	for rcpt, err := range failed {
+		if !containsString(retry, rcpt) {
+			bounced[rcpt] = err
+		}
+	}
+	if len(bounced) > 0 {
+		if err := r.bounce(ctx, d, bounced); err != nil {
+			log.Printf("ERROR: Failed to bounce %s: %v", d.id, err)
+		}
+	}
+
+	if len(retry) == 0 {
+		return r.redisClient.Del(ctx, relayKey(d.id)).Err()
+	}
+
+	sort.Strings(retry)
+	recipients, _ := json.Marshal(retry)
+	fields := map[string]string{
+		"to":       string(recipients),
+		"attempts": strconv.Itoa(d.attempts),
+		"next":     strconv.FormatInt(time.Now().Add(r.retryDelay(d.attempts)).Unix(), 10),
+		"error":    failed[retry[0]].Error(),
+	}
+	for field, value := range fields {
+		if err := r.redisClient.HSet(ctx, relayKey(d.id), field, value).Err(); err != nil {
+			return fmt.Errorf("failed to update delivery %s: %w", d.id, err)
+		}
+	}
+	if err := r.redisClient.RPush(ctx, relayQueue, d.id).Err(); err != nil {
+		return fmt.Errorf("failed to requeue delivery %s: %w", d.id, err)
+	}
+	return nil
+}
+
+// retryDelay returns the wait after the given number of attempts
+func (r *Relay) retryDelay(attempts int) time.Duration {
+	delay := r.config.RetryDelay
+	for i := 1; i < attempts && delay < r.config.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if r.config.MaxRetryDelay > 0 && delay > r.config.MaxRetryDelay {
+		delay = r.config.MaxRetryDelay
+	}
+	return delay
+}
+
+// deliverDomain delivers a message to the recipients of one domain, trying
+// its mail servers in order of preference. It returns the result for every
+// recipient, nil if the message was accepted.
+func (r *Relay) deliverDomain(domain, from string, rcpts []string, data []byte) map[string]error {
+	results := make(map[string]error)
+	fail := func(err error) map[string]error {
+		for _, rcpt := range rcpts {
+			results[rcpt] = err
+		}
+		return results
+	}
+
+	hosts, err := r.mailServers(domain)
+	if err != nil {
+		return fail(err)
+	}
+	for _, host := range hosts {
+		var sent map[string]error
+		sent, err = r.send(host, from, rcpts, data)
+		if err == nil {
+			return sent
+		}
+		log.Printf("Relaying to %s via %s failed: %v", domain, host, err)
+		if isPermanent(err) {
+			break
+		}
+	}
+	return fail(err)
+}
+
+// mailServers returns the mail servers of a domain by preference. A domain
+// without MX records is its own mail server (RFC 5321 section 5.1).
+func (r *Relay) mailServers(domain string) ([]string, error) {
+	records, err := r.lookupMX(domain)
+	if isNotFound(err) || err == nil && len(records) == 0 {
+		return []string{domain}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("MX lookup for %s failed: %w", domain, err)
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Pref < records[j].Pref })
+	var hosts []string
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Host, ".")
+		if host == "" {
+			// A null MX announces the domain accepts no mail (RFC 7505)
+			return nil, &textproto.Error{Code: 556, Msg: fmt.Sprintf("Domain %s does not accept mail", domain)}
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// send delivers a message over one connection. It returns an error if the
+// server could not take the message at all, and otherwise the result for
+// every recipient.
+func (r *Relay) send(host, from string, rcpts []string, data []byte) (map[string]error, error) {
+	addr := net.JoinHostPort(host, strconv.Itoa(r.config.Port))
+	conn, err := net.DialTimeout("tcp", addr, r.config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(r.config.Timeout))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	defer c.Close()
+
+	if err := c.Hello(r.config.Hostname); err != nil {
+		return nil, err
+	}
+	// Mail servers rarely have certificates for their MX names, so TLS is
+	// used when offered without verifying the certificate
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host, InsecureSkipVerify: true}); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return nil, err
+	}
+
+	results := make(map[string]error)
+	var accepted []string
+	for _, rcpt := range rcpts {
+		if err := c.Rcpt(rcpt); err != nil {
+			results[rcpt] = err
+			continue
+		}
+		accepted = append(accepted, rcpt)
+	}
+	if len(accepted) == 0 {
+		c.Quit()
+		return results, nil
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	for _, rcpt := range accepted {
+		results[rcpt] = nil
+	}
+	c.Quit()
+	return results, nil
+}
+
+// bounce tells the sender that the message could not be delivered to some
+// recipients, with a delivery status notification in the inbox of the mail
+// user that sent it or else queued for the sender. Bounces are not bounced.
+func (r *Relay) bounce(ctx context.Context, d *delivery, failed map[string]error) error {
+	if d.from == "" {
+		log.Printf("Not bouncing %s, it has no sender", d.id)
+		return nil
+	}
+
+	var rcpts []string
+	for rcpt := range failed {
+		rcpts = append(rcpts, rcpt)
+	}
+	sort.Strings(rcpts)
+	var recipients []dsnRecipient
+	for _, rcpt := range rcpts {
+		recipients = append(recipients, dsnRecipientFor(rcpt, failed[rcpt]))
+	}
+
+	dsn := buildDSN(r.config.Hostname, d.from, time.Now(), d.data, recipients)
+	return returnDSN(ctx, r.redisClient, r.config.Hostname, d.user, d.from, dsn)
+}
+
+// isPermanent reports whether an error is a 5xx reply of a mail server
+func isPermanent(err error) bool {
+	var replyErr *textproto.Error
+	return errors.As(err, &replyErr) && replyErr.Code/100 == 5
+}
+
+// addressDomain returns the domain of an address in lower case
+func addressDomain(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.Trim(address[at+1:], "<> "))
+}
+
+// containsString reports whether a slice contains a string
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package smtpserver
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestRelay creates a relay for the queue of s that delivers all mail
+// to the mail server at addr
+func newTestRelay(t *testing.T, s *testServer, addr string, maxAttempts int) *Relay {
+	t.Helper()
+	host, portText, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("Invalid address %s: %v", addr, err)
+	}
+	port, _ := strconv.Atoi(portText)
+	relay := NewRelay(s.client, RelayConfig{
+		Hostname:      "mail.example.com",
+		Port:          port,
+		MaxAttempts:   maxAttempts,
+		RetryDelay:    time.Minute,
+		MaxRetryDelay: time.Hour,
+		PollInterval:  time.Second,
+		Timeout:       5 * time.Second,
+	})
+	relay.lookupMX = func(domain string) ([]*net.MX, error) {
+		return []*net.MX{{Host: host + ".", Pref: 10}}, nil
+	}
+	return relay
+}
+
+func TestRelay(t *testing.T) {
+	remote := newTestServer(t, func(config *Config) { config.Domain = "remote.test" })
+	s := newTestServer(t, func(config *Config) { config.Relay = true })
+	ctx := context.Background()
+
+	// Clients that did not authenticate may not relay
+	c := s.dial()
+	if err := c.Mail("mallory@example.net", nil); err != nil {
+		t.Fatalf("MAIL FROM failed: %v", err)
+	}
+	if err := c.Rcpt("bob@remote.test", nil); smtpCode(err) != 554 {
+		t.Errorf("Relaying without AUTH returned %v, want a 554 error", err)
+	}
+
+	err := send(s.login("alice"), "alice@example.com", []string{"bob@remote.test", "nobody@remote.test"}, testMessage)
+	if err != nil {
+		t.Fatalf("Sending to remote recipients failed: %v", err)
+	}
+	ids, err := s.client.LRange(ctx, relayQueue, 0, -1).Result()
+	if err != nil || len(ids) != 1 {
+		t.Fatalf("The relay queue holds %v (%v), want one delivery", ids, err)
+	}
+	if user, _ := s.client.HGet(ctx, relayKey(ids[0]), "user").Result(); user != "alice" {
+		t.Errorf("The delivery was queued for user %q, want alice", user)
+	}
+
+	if err := newTestRelay(t, s, remote.addr, 3).ProcessQueue(ctx); err != nil {
+		t.Fatalf("ProcessQueue failed: %v", err)
+	}
+	if inbox := remote.mailbox("bob", "inbox"); len(inbox) != 1 || inbox[0].Subject() != "Lunch" {
+		t.Errorf("Bob's remote inbox has %d messages, want the relayed one", len(inbox))
+	}
+
+	// The unknown recipient is bounced to the inbox of the sender
+	inbox := s.mailbox("alice", "inbox")
+	if len(inbox) != 1 || inbox[0].Subject() != "Undelivered Mail Returned to Sender" {
+		t.Fatalf("Alice's inbox has %d messages, want the bounce", len(inbox))
+	}
+	if !strings.Contains(inbox[0].Message, "nobody@remote.test") || strings.Contains(inbox[0].Message, "bob@remote.test") {
+		t.Errorf("The bounce does not list just the unknown recipient:\n%s", inbox[0].Message)
+	}
+	if n, _ := s.client.LLen(ctx, relayQueue).Result(); n != 0 {
+		t.Errorf("The relay queue holds %d deliveries after delivering", n)
+	}
+	if n, _ := s.client.Exists(ctx, relayKey(ids[0])).Result(); n != 0 {
+		t.Error("The delivery was not removed after delivering")
+	}
+}
+
+func TestRelayRetry(t *testing.T) {
+	s := newTestServer(t, func(config *Config) { config.Relay = true })
+	ctx := context.Background()
+
+	// An address nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	down := listener.Addr().String()
+	listener.Close()
+
+	if err := send(s.login("alice"), "alice@example.com", []string{"bob@remote.test"}, testMessage); err != nil {
+		t.Fatalf("Sending to a remote recipient failed: %v", err)
+	}
+	ids, _ := s.client.LRange(ctx, relayQueue, 0, -1).Result()
+	if len(ids) != 1 {
+		t.Fatalf("The relay queue holds %v, want one delivery", ids)
+	}
+	relay := newTestRelay(t, s, down, 2)
+
+	// The first attempt fails temporarily and is retried later
+	if err := relay.ProcessQueue(ctx); err != nil {
+		t.Fatalf("ProcessQueue failed: %v", err)
+	}
+	fields, _ := s.client.HGetAll(ctx, relayKey(ids[0])).Result()
+	if fields["attempts"] != "1" || fields["error"] == "" {
+		t.Errorf("After the first attempt the delivery is %v", fields)
+	}
+	next, _ := strconv.ParseInt(fields["next"], 10, 64)
+	if wait := time.Until(time.Unix(next, 0)); wait < 50*time.Second || wait > time.Minute {
+		t.Errorf("The retry is due in %v, want a minute", wait)
+	}
+	if len(s.mailbox("alice", "inbox")) != 0 {
+		t.Error("A temporary failure was bounced")
+	}
+
+	// Deliveries that are not due are left alone
+	if err := relay.ProcessQueue(ctx); err != nil {
+		t.Fatalf("ProcessQueue failed: %v", err)
+	}
+	if attempts, _ := s.client.HGet(ctx, relayKey(ids[0]), "attempts").Result(); attempts != "1" {
+		t.Errorf("A delivery that is not due was attempted, attempts = %s", attempts)
+	}
+
+	// The last attempt bounces the message
+	s.client.HSet(ctx, relayKey(ids[0]), "next", "0")
+	if err := relay.ProcessQueue(ctx); err != nil {
+		t.Fatalf("ProcessQueue failed: %v", err)
+	}
+	inbox := s.mailbox("alice", "inbox")
+	if len(inbox) != 1 || !strings.Contains(inbox[0].Message, "bob@remote.test") {
+		t.Fatalf("Alice's inbox has %d messages, want the bounce for bob@remote.test", len(inbox))
+	}
+	if n, _ := s.client.LLen(ctx, relayQueue).Result(); n != 0 {
+		t.Errorf("The relay queue holds %d deliveries after the last attempt", n)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	relay := &Relay{config: RelayConfig{RetryDelay: time.Minute, MaxRetryDelay: 10 * time.Minute}}
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+	for i, delay := range want {
+		if got := relay.retryDelay(i + 1); got != delay {
+			t.Errorf("retryDelay(%d) = %v, want %v", i+1, got, delay)
+		}
+	}
+}
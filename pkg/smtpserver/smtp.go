@@ -3,13 +3,21 @@ package smtpserver
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/emersion/go-sasl"
 	"github.com/emersion/go-smtp"
 	mailmodel "github.com/freeflowuniverse/herolauncher/pkg/mail"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailauth"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailblob"
 	"github.com/redis/go-redis/v9"
 	"golang.org/x/crypto/blake2b"
 	"golang.org/x/net/context"
@@ -28,6 +36,51 @@ type Config struct {
 	RedisAddr         string
 	RedisPassword     string
 	RedisDB           int
+	// RequireAuth rejects MAIL FROM until the client authenticated as one
+	// of the mail users stored in Redis
+	RequireAuth bool
+	// Relay queues mail for recipients outside Domain for delivery by a
+	// Relay, for authenticated users only
+	Relay bool
+	// VerifySenders checks SPF, DKIM and DMARC of mail from clients that
+	// did not authenticate and stores the results with the mail. Mail that
+	// fails DMARC is handled by the AuthPolicies of its From domain, with
+	// "*" for all other domains.
+	VerifySenders bool
+	AuthPolicies  map[string]AuthPolicy
+
+	// ConnRateLimit is the number of connections a client address may open
+	// per ConnRatePeriod, and MessageRateLimit the number of messages a
+	// sender may send per MessageRatePeriod. Zero means unlimited.
+	ConnRateLimit     int
+	ConnRatePeriod    time.Duration
+	MessageRateLimit  int
+	MessageRatePeriod time.Duration
+
+	// Filters check every message before it is stored, in order
+	Filters []Filter
+
+	// Attachments stores the large attachments of mail delivered to the
+	// mail users outside of Redis, nil keeps them in the email JSON
+	Attachments *mailblob.Store
+
+	// LMTPSocket is the unix socket StartLMTP serves LMTP on, for a mail
+	// gateway delivering to the mail users
+	LMTPSocket string
+
+	// UseTLS offers STARTTLS on Port, and implicit TLS on TLSPort when it
+	// is set, e.g. 465 for mail submission
+	UseTLS  bool
+	TLSPort int
+	// CertFile and KeyFile are the PEM encoded certificate and private key
+	CertFile string
+	KeyFile  string
+	// AutoGenerateCerts creates a self-signed certificate when the files are
+	// not given or do not exist yet, in CertDir when no file names are given
+	AutoGenerateCerts bool
+	CertDir           string
+	CertValidityDays  int
+	CertOrganization  string
 }
 
 // Server represents the SMTP server
@@ -35,6 +88,10 @@ type Server struct {
 	config      Config
 	smtpServer  *smtp.Server
 	redisClient *redis.Client
+	// connLimiter limits the connections per client address
+	connLimiter *rateLimiter
+	// lmtpServer serves LMTP once StartLMTP is called
+	lmtpServer *smtp.Server
 }
 
 // GetRedisClient returns the Redis client
@@ -45,13 +102,51 @@ func (s *Server) GetRedisClient() *redis.Client {
 // Backend implements the SMTP server backend
 type Backend struct {
 	redisClient *redis.Client
+	users       *mailauth.Store
+	requireAuth bool
+	domain      string
+	relay       bool
+	// verify, authPolicies and resolver check the senders of received mail
+	verify       bool
+	authPolicies map[string]AuthPolicy
+	resolver     dnsResolver
+	// messageLimiter limits the messages per sender
+	messageLimiter *rateLimiter
+	filters        []Filter
+	attachments    *mailblob.Store
 }
 
 // Session represents an SMTP session
 type Session struct {
 	from        string
 	to          []string
+	user        string
 	redisClient *redis.Client
+	users       *mailauth.Store
+	requireAuth bool
+	domain      string
+	relay       bool
+	// verify, authPolicies and resolver check the senders of received mail
+	verify       bool
+	authPolicies map[string]AuthPolicy
+	resolver     dnsResolver
+	// ip and helo identify the client
+	ip   net.IP
+	helo string
+	// messageLimiter limits the messages per sender
+	messageLimiter *rateLimiter
+	filters        []Filter
+	attachments    *mailblob.Store
+	// lmtp is set for sessions of a mail gateway over LMTP, routes maps
+	// their recipients to the mail users they are routed to
+	lmtp   bool
+	routes map[string][]string
+	// local are the mail users the recipients are routed to, remote the
+	// recipients outside the server's domain and unknown the recipients of
+	// the domain that are no mail user
+	local   []string
+	remote  []string
+	unknown []string
 }
 
 // NewServer creates a new SMTP server
@@ -73,10 +168,26 @@ func NewServer(config Config) (*Server, error) {
 		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
 	}
 	log.Printf("Successfully connected to Redis at %s", config.RedisAddr)
+	return newServer(config, redisClient)
+}
 
+// newServer creates an SMTP server storing mail with a connected Redis client
+func newServer(config Config, redisClient *redis.Client) (*Server, error) {
 	// Create backend
 	be := &Backend{
 		redisClient: redisClient,
+		users:       mailauth.NewStore(redisClient),
+		requireAuth: config.RequireAuth,
+		domain:      config.Domain,
+		relay:       config.Relay,
+
+		verify:       config.VerifySenders,
+		authPolicies: config.AuthPolicies,
+		resolver:     net.DefaultResolver,
+
+		messageLimiter: newRateLimiter(config.MessageRateLimit, config.MessageRatePeriod),
+		filters:        config.Filters,
+		attachments:    config.Attachments,
 	}
 
 	// Create SMTP server
@@ -88,22 +199,31 @@ func NewServer(config Config) (*Server, error) {
 	smtpServer.MaxMessageBytes = int64(config.MaxMessageBytes)
 	smtpServer.MaxRecipients = config.MaxRecipients
 	smtpServer.AllowInsecureAuth = config.AllowInsecureAuth
+	if config.UseTLS {
+		tlsConfig, err := loadTLSConfig(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to enable TLS: %w", err)
+		}
+		smtpServer.TLSConfig = tlsConfig
+	}
 
 	return &Server{
 		config:      config,
 		smtpServer:  smtpServer,
 		redisClient: redisClient,
+		connLimiter: newRateLimiter(config.ConnRateLimit, config.ConnRatePeriod),
 	}, nil
 }
 
 // Start starts the SMTP server
 func (s *Server) Start() error {
 	log.Printf("Starting SMTP server at %s with domain %s", s.smtpServer.Addr, s.smtpServer.Domain)
-	err := s.smtpServer.ListenAndServe()
+	listener, err := s.listen(s.smtpServer.Addr, true)
 	if err != nil {
 		log.Printf("ERROR: SMTP server failed to start: %v", err)
+		return err
 	}
-	return err
+	return s.smtpServer.Serve(listener)
 }
 
 // Stop stops the SMTP server
@@ -113,6 +233,11 @@ func (s *Server) Stop() error {
 	if err != nil {
 		log.Printf("ERROR: Failed to stop SMTP server: %v", err)
 	}
+	if s.lmtpServer != nil {
+		if lmtpErr := s.lmtpServer.Close(); lmtpErr != nil {
+			log.Printf("ERROR: Failed to stop LMTP server: %v", lmtpErr)
+		}
+	}
 	return err
 }
 
@@ -121,23 +246,149 @@ func (b *Backend) NewSession(c *smtp.Conn) (smtp.Session, error) {
 	log.Printf("New SMTP session from %s", c.Conn().RemoteAddr())
 	return &Session{
 		redisClient: b.redisClient,
+		users:       b.users,
+		requireAuth: b.requireAuth,
+		domain:      b.domain,
+		relay:       b.relay,
+
+		verify:       b.verify,
+		authPolicies: b.authPolicies,
+		resolver:     b.resolver,
+		ip:           remoteIP(c.Conn().RemoteAddr()),
+		helo:         c.Hostname(),
+		lmtp:         c.Server().LMTP,
+
+		messageLimiter: b.messageLimiter,
+		filters:        b.filters,
+		attachments:    b.attachments,
 	}, nil
 }
 
+// AuthMechanisms returns the SASL mechanisms offered with AUTH
+func (s *Session) AuthMechanisms() []string {
+	return []string{sasl.Plain}
+}
+
+// Auth handles the AUTH command, checking the credentials against the mail users
+func (s *Session) Auth(mech string) (sasl.Server, error) {
+	return sasl.NewPlainServer(func(identity, username, password string) error {
+		if identity != "" && identity != username {
+			return smtp.ErrAuthFailed
+		}
+		name, err := s.users.Authenticate(username, password)
+		if err == mailauth.ErrInvalidCredentials {
+			log.Printf("AUTH failed for user: %s", username)
+			return smtp.ErrAuthFailed
+		}
+		if err != nil {
+			log.Printf("ERROR: AUTH failed for user %s: %v", username, err)
+			return err
+		}
+		log.Printf("AUTH succeeded for user: %s", name)
+		s.user = name
+		return nil
+	}), nil
+}
+
 // Mail handles the MAIL FROM command
 func (s *Session) Mail(from string, opts *smtp.MailOptions) error {
 	log.Printf("MAIL FROM: %s", from)
+	if s.lmtp {
+		s.from = from
+		return nil
+	}
+	if s.requireAuth && s.user == "" {
+		return smtp.ErrAuthRequired
+	}
+	if err := s.checkMessageRate(from); err != nil {
+		return err
+	}
 	s.from = from
 	return nil
 }
 
-// Rcpt handles the RCPT TO command
+// Rcpt handles the RCPT TO command. Recipients are routed to mail users
+// by address, alias, distribution list or catch-all. Mail users whose
+// quota is used up are rejected, and so are unknown users and remote
+// recipients of clients that did not authenticate when relaying is enabled.
 func (s *Session) Rcpt(to string, opts *smtp.RcptOptions) error {
 	log.Printf("RCPT TO: %s", to)
+	users, err := s.users.Resolve(to, s.domain)
+	if err != nil {
+		log.Printf("ERROR: Failed to route %s: %v", to, err)
+		return err
+	}
+	for _, user := range users {
+		if err := s.checkQuota(user, 0); err != nil {
+			return err
+		}
+	}
+
+	// A mail gateway only delivers to the mail users
+	if s.lmtp {
+		if len(users) == 0 {
+			log.Printf("Rejecting LMTP delivery for unknown user %s", to)
+			return &smtp.SMTPError{
+				Code:         550,
+				EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+				Message:      "No such user here",
+			}
+		}
+		if s.routes == nil {
+			s.routes = make(map[string][]string)
+		}
+		s.routes[to] = users
+		s.to = append(s.to, to)
+		return nil
+	}
+
+	// Unknown users are refused, unless a mail user sends to them, who is
+	// told with a delivery status notification instead
+	if len(users) == 0 && strings.EqualFold(addressDomain(to), s.domain) {
+		if s.user == "" {
+			log.Printf("Rejecting mail for unknown user %s", to)
+			return &smtp.SMTPError{
+				Code:         550,
+				EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+				Message:      "No such user here",
+			}
+		}
+		s.unknown = append(s.unknown, to)
+	}
+	if len(users) == 0 && !strings.EqualFold(addressDomain(to), s.domain) {
+		if s.relay && s.user == "" {
+			log.Printf("Refusing to relay to %s for an unauthenticated client", to)
+			return &smtp.SMTPError{
+				Code:         554,
+				EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+				Message:      "Relay access denied",
+			}
+		}
+		s.remote = append(s.remote, to)
+	}
+	for _, user := range users {
+		if !containsString(s.local, user) {
+			s.local = append(s.local, user)
+		}
+	}
 	s.to = append(s.to, to)
 	return nil
 }
 
+// checkQuota rejects a message of size bytes for a mail user that is over quota
+func (s *Session) checkQuota(user string, size int64) error {
+	err := s.users.CheckQuota(user, 1, size)
+	if errors.Is(err, mailauth.ErrOverQuota) {
+		log.Printf("Rejecting mail for %s: %v", user, err)
+		return &smtp.SMTPError{
+			Code:         552,
+			EnhancedCode: smtp.EnhancedCode{5, 2, 2},
+			Message:      "Mailbox full",
+		}
+	}
+	return err
+}
+
 // Data handles the DATA command
 func (s *Session) Data(r io.Reader) error {
 	log.Printf("DATA command received from %s to %v", s.from, s.to)
@@ -150,6 +401,28 @@ func (s *Session) Data(r io.Reader) error {
 	}
 	log.Printf("Received %d bytes of email data", len(data))
 
+	// Recipients the message cannot be delivered to get a delivery status
+	// notification, unless there are no others, then the message is refused
+	failed, err := s.checkRecipients(int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	// Verify the sender of mail that was not submitted by a mail user, before
+	// filters change the message
+	ctx := context.Background()
+	var auth *AuthResults
+	var policy AuthPolicy
+	if s.verify && s.user == "" {
+		auth, policy = s.verifySender(ctx, data)
+	}
+
+	// Let the filters reject or change the message
+	data, err = s.runFilters(ctx, data)
+	if err != nil {
+		return err
+	}
+
 	// Convert to Unicode if needed
 	unicodeData := data
 	// We already read all data from r, so we can't read from it again
@@ -164,24 +437,133 @@ func (s *Session) Data(r io.Reader) error {
 		log.Printf("ERROR: Failed to parse email: %v", err)
 		return err
 	}
-	log.Printf("Successfully parsed email with subject: %s", email.Subject)
+	log.Printf("Successfully parsed email with subject: %s", email.Subject())
 
+	if auth != nil {
+		if err := applyAuthAction(email, auth, policy); err != nil {
+			return err
+		}
+	}
+
+	if _, err := storeEmail(ctx, s.redisClient, email, auth, s.user); err != nil {
+		return err
+	}
+
+	// File the message for the local recipients by their rules
+	if err := s.deliver(ctx, s.local, email, data); err != nil {
+		return err
+	}
+
+	// Queue the message for the remote recipients
+	if s.relay && len(s.remote) > 0 {
+		if _, err := QueueForRelay(ctx, s.redisClient, s.from, s.remote, data, s.user); err != nil {
+			log.Printf("ERROR: Failed to queue email for relay: %v", err)
+			return err
+		}
+	}
+
+	if len(failed) > 0 {
+		s.returnFailures(ctx, data, failed, auth)
+	}
+	return nil
+}
+
+// checkRecipients drops the mail users a message of size bytes does not fit
+// the quota of from the local recipients and returns the recipients that
+// cannot be delivered to. When no recipient is left, the message is refused.
+func (s *Session) checkRecipients(size int64) ([]dsnRecipient, error) {
+	var failed []dsnRecipient
+	for _, rcpt := range s.unknown {
+		failed = append(failed, dsnRecipient{Address: rcpt, Status: "5.1.1", Diagnostic: "550 5.1.1 No such user here"})
+	}
+
+	var local []string
+	var refusal error
+	for _, user := range s.local {
+		err := s.checkQuota(user, size)
+		var smtpErr *smtp.SMTPError
+		if errors.As(err, &smtpErr) {
+			failed = append(failed, dsnRecipientFor(user+"@"+s.domain, err))
+			refusal = err
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		local = append(local, user)
+	}
+	s.local = local
+
+	if len(s.local) == 0 && len(s.remote) == 0 && len(failed) > 0 {
+		if refusal == nil {
+			refusal = &smtp.SMTPError{
+				Code:         550,
+				EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+				Message:      "No such user here",
+			}
+		}
+		return nil, refusal
+	}
+	return failed, nil
+}
+
+// returnFailures sends the sender of a message a delivery status
+// notification for the recipients it could not be delivered to. Senders
+// that did not authenticate only get one when relaying is enabled and their
+// address was verified, so forged senders are not sent backscatter.
+func (s *Session) returnFailures(ctx context.Context, data []byte, failed []dsnRecipient, auth *AuthResults) {
+	if s.from == "" {
+		return
+	}
+	if s.user == "" && !s.relay {
+		log.Printf("Not returning a delivery status notification to %s: relaying is disabled", s.from)
+		return
+	}
+	if s.user == "" && !senderVerified(s.from, auth) {
+		log.Printf("Not returning a delivery status notification to %s: the sender is not verified", s.from)
+		return
+	}
+	dsn := buildDSN(s.domain, s.from, time.Now(), data, failed)
+	if err := returnDSN(ctx, s.redisClient, s.domain, s.user, s.from, dsn); err != nil {
+		log.Printf("ERROR: Failed to return a delivery status notification to %s: %v", s.from, err)
+	}
+}
+
+// senderVerified reports whether the MAIL FROM address of a message passed
+// SPF, or is in the domain of a From address that passed DMARC
+func senderVerified(from string, auth *AuthResults) bool {
+	if auth == nil {
+		return false
+	}
+	domain := addressDomain(from)
+	if auth.SPF == SPFPass && strings.EqualFold(auth.SPFDomain, domain) {
+		return true
+	}
+	return auth.DMARC == DMARCPass && aligned(domain, auth.FromDomain, false)
+}
+
+// storeEmail stores an email in Redis and adds it to the mail:out queue,
+// returning its ID. The results of verifying the sender are stored in the
+// email JSON as "auth".
+func storeEmail(ctx context.Context, redisClient *redis.Client, email *mailmodel.Email, auth *AuthResults, user string) (string, error) {
 	// Convert email to JSON
-	emailJSON, err := json.Marshal(email)
+	emailJSON, err := json.Marshal(struct {
+		*mailmodel.Email
+		Auth *AuthResults `json:"auth,omitempty"`
+	}{email, auth})
 	if err != nil {
 		fmt.Printf("Failed to marshal email: %v\n", err)
-		return err
+		return "", err
 	}
 
 	// Generate unique ID for the email using Blake2b-192 hash
-	ctx := context.Background()
 	log.Printf("Generating unique ID for email using Blake2b-192 hash")
 
 	// Create a Blake2b-192 hash (24 bytes) from the email JSON
 	hash, err := blake2b.New(24, nil)
 	if err != nil {
 		log.Printf("ERROR: Failed to create Blake2b hash: %v", err)
-		return err
+		return "", err
 	}
 
 	// Add timestamp to ensure uniqueness even for identical emails
@@ -192,7 +574,7 @@ func (s *Session) Data(r io.Reader) error {
 	_, err = hash.Write([]byte(hashInput))
 	if err != nil {
 		log.Printf("ERROR: Failed to write to hash: %v", err)
-		return err
+		return "", err
 	}
 
 	// Get the hash sum and convert to hex string
@@ -204,20 +586,20 @@ func (s *Session) Data(r io.Reader) error {
 
 	// Store email in Redis
 	log.Printf("Storing email in Redis with ID: %s", mailID)
-	if err := s.redisClient.HSet(ctx, mailID, "data", string(emailJSON)).Err(); err != nil {
+	if err := redisClient.HSet(ctx, mailID, "data", string(emailJSON), "user", user).Err(); err != nil {
 		log.Printf("ERROR: Failed to store email in Redis: %v", err)
-		return err
+		return "", err
 	}
 
 	// Add to mail queue
 	log.Printf("Adding email to mail:out queue")
-	if err := s.redisClient.RPush(ctx, "mail:out", mailID).Err(); err != nil {
+	if err := redisClient.RPush(ctx, "mail:out", mailID).Err(); err != nil {
 		log.Printf("ERROR: Failed to add email to queue: %v", err)
-		return err
+		return "", err
 	}
 
 	log.Printf("Email stored with ID: %s", mailID)
-	return nil
+	return mailID, nil
 }
 
 // Reset resets the session
@@ -225,6 +607,10 @@ func (s *Session) Reset() {
 	log.Printf("Resetting SMTP session")
 	s.from = ""
 	s.to = []string{}
+	s.local = nil
+	s.remote = nil
+	s.unknown = nil
+	s.routes = nil
 }
 
 // Logout handles the QUIT command
@@ -240,12 +626,21 @@ func DefaultConfig() Config {
 		Port:              25,
 		Domain:            "localhost",
 		AllowInsecureAuth: true,
+		RequireAuth:       true,
 		ReadTimeout:       10 * time.Second,
 		WriteTimeout:      10 * time.Second,
 		MaxMessageBytes:   10 * 1024 * 1024, // 10 MB
 		MaxRecipients:     50,
+		ConnRateLimit:     60,
+		ConnRatePeriod:    time.Minute,
+		MessageRateLimit:  200,
+		MessageRatePeriod: time.Hour,
 		RedisAddr:         "localhost:6379",
 		RedisPassword:     "",
 		RedisDB:           0,
+		AutoGenerateCerts: true,
+		CertDir:           filepath.Join(os.TempDir(), "herolauncher", "certificates"),
+		CertValidityDays:  365,
+		CertOrganization:  "HeroLauncher SMTP Server",
 	}
 }
@@ -0,0 +1,238 @@
+package smtpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/emersion/go-smtp"
+	mailmodel "github.com/freeflowuniverse/herolauncher/pkg/mail"
+	"github.com/freeflowuniverse/herolauncher/pkg/redisserver/redistest"
+	"github.com/redis/go-redis/v9"
+)
+
+const testMessage = "From: Alice <alice@example.com>\r\n" +
+	"To: Bob <bob@example.com>\r\n" +
+	"Subject: Lunch\r\n" +
+	"\r\n" +
+	"Shall we have lunch today?\r\n"
+
+// testServer is an SMTP server for the domain example.com on an in-memory
+// Redis server, with the mail users alice and bob
+type testServer struct {
+	*Server
+	t      *testing.T
+	client *redis.Client
+	addr   string
+}
+
+// newTestServer starts an SMTP server with the test configuration changed
+// by configure. DNS lookups to verify senders find nothing.
+func newTestServer(t *testing.T, configure func(config *Config)) *testServer {
+	t.Helper()
+	client := redistest.NewClient(t)
+
+	config := DefaultConfig()
+	config.Host = "127.0.0.1"
+	config.Domain = "example.com"
+	config.RequireAuth = false
+	config.CertDir = t.TempDir()
+	if configure != nil {
+		configure(&config)
+	}
+	server, err := newServer(config, client)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	backend := server.smtpServer.Backend.(*Backend)
+	backend.resolver = &fakeResolver{}
+	for _, user := range []string{"alice", "bob"} {
+		if err := backend.users.Add(user, "secret"); err != nil {
+			t.Fatalf("Failed to add user %s: %v", user, err)
+		}
+	}
+
+	listener, err := server.listen("127.0.0.1:0", true)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go server.smtpServer.Serve(listener)
+	t.Cleanup(func() { server.Stop() })
+	return &testServer{Server: server, t: t, client: client, addr: listener.Addr().String()}
+}
+
+// backend returns the backend of the sessions of the server
+func (s *testServer) backend() *Backend {
+	return s.smtpServer.Backend.(*Backend)
+}
+
+// dial connects to the server and says EHLO
+func (s *testServer) dial() *smtp.Client {
+	s.t.Helper()
+	c, err := smtp.Dial(s.addr)
+	if err != nil {
+		s.t.Fatalf("Failed to connect: %v", err)
+	}
+	s.t.Cleanup(func() { c.Close() })
+	if err := c.Hello("client.example.net"); err != nil {
+		s.t.Fatalf("EHLO failed: %v", err)
+	}
+	return c
+}
+
+// login connects to the server and authenticates as a mail user
+func (s *testServer) login(user string) *smtp.Client {
+	s.t.Helper()
+	c := s.dial()
+	if err := c.Auth(sasl.NewPlainClient("", user, "secret")); err != nil {
+		s.t.Fatalf("AUTH as %s failed: %v", user, err)
+	}
+	return c
+}
+
+// mailbox returns the messages in a mailbox of a mail user, by subject
+func (s *testServer) mailbox(user, mailbox string) []*mailmodel.Email {
+	s.t.Helper()
+	ctx := context.Background()
+	keys, err := s.client.Keys(ctx, "mail:in:"+user+":"+mailbox+":*").Result()
+	if err != nil {
+		s.t.Fatalf("Failed to list the mailbox %s of %s: %v", mailbox, user, err)
+	}
+	var emails []*mailmodel.Email
+	for _, key := range keys {
+		data, err := s.client.Get(ctx, key).Result()
+		if err != nil {
+			s.t.Fatalf("Failed to read %s: %v", key, err)
+		}
+		var email mailmodel.Email
+		if err := json.Unmarshal([]byte(data), &email); err != nil {
+			s.t.Fatalf("Failed to parse %s: %v", key, err)
+		}
+		emails = append(emails, &email)
+	}
+	sort.Slice(emails, func(i, j int) bool { return emails[i].Subject() < emails[j].Subject() })
+	return emails
+}
+
+// send sends a message over a connection
+func send(c *smtp.Client, from string, to []string, message string) error {
+	return c.SendMail(from, to, strings.NewReader(message))
+}
+
+// smtpCode returns the reply code of an error of the client, or 0
+func smtpCode(err error) int {
+	var smtpErr *smtp.SMTPError
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Code
+	}
+	return 0
+}
+
+func TestStartTLS(t *testing.T) {
+	s := newTestServer(t, func(config *Config) {
+		config.UseTLS = true
+		config.AllowInsecureAuth = false
+	})
+
+	for _, name := range []string{"smtp.crt", "smtp.key"} {
+		if _, err := os.Stat(filepath.Join(s.config.CertDir, name)); err != nil {
+			t.Errorf("The certificate was not generated: %v", err)
+		}
+	}
+
+	// Without TLS, STARTTLS is offered and AUTH is refused
+	c := s.dial()
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		t.Error("STARTTLS is not offered")
+	}
+	if ok, _ := c.Extension("AUTH"); ok {
+		t.Error("AUTH is offered without TLS")
+	}
+	if err := c.Auth(sasl.NewPlainClient("", "alice", "secret")); smtpCode(err) != 523 {
+		t.Errorf("AUTH without TLS returned %v, want a 523 error", err)
+	}
+
+	c, err := smtp.DialStartTLS(s.addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("STARTTLS failed: %v", err)
+	}
+	defer c.Close()
+	if _, ok := c.TLSConnectionState(); !ok {
+		t.Fatal("The connection is not encrypted after STARTTLS")
+	}
+	if err := c.Auth(sasl.NewPlainClient("", "alice", "secret")); err != nil {
+		t.Fatalf("AUTH after STARTTLS failed: %v", err)
+	}
+	if err := send(c, "alice@example.com", []string{"bob@example.com"}, testMessage); err != nil {
+		t.Fatalf("Sending over TLS failed: %v", err)
+	}
+	if inbox := s.mailbox("bob", "inbox"); len(inbox) != 1 || inbox[0].Subject() != "Lunch" {
+		t.Errorf("Bob's inbox has %d messages, want the one sent over TLS", len(inbox))
+	}
+}
+
+func TestImplicitTLS(t *testing.T) {
+	// Find a free port for the TLS listener
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	s := newTestServer(t, func(config *Config) {
+		config.UseTLS = true
+		config.TLSPort = port
+	})
+	errs := make(chan error, 1)
+	go func() { errs <- s.StartTLS() }()
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	var c *smtp.Client
+	for i := 0; ; i++ {
+		c, err = smtp.DialTLS(addr, &tls.Config{InsecureSkipVerify: true})
+		if err == nil {
+			break
+		}
+		select {
+		case err := <-errs:
+			t.Fatalf("StartTLS failed: %v", err)
+		default:
+		}
+		if i == 50 {
+			t.Fatalf("Failed to connect over TLS: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	defer c.Close()
+
+	if err := c.Auth(sasl.NewPlainClient("", "bob", "secret")); err != nil {
+		t.Fatalf("AUTH over TLS failed: %v", err)
+	}
+	if err := send(c, "bob@example.com", []string{"alice@example.com"}, testMessage); err != nil {
+		t.Fatalf("Sending over TLS failed: %v", err)
+	}
+	if inbox := s.mailbox("alice", "inbox"); len(inbox) != 1 {
+		t.Errorf("Alice's inbox has %d messages, want 1", len(inbox))
+	}
+}
+
+func TestStartTLSNotEnabled(t *testing.T) {
+	s := newTestServer(t, nil)
+	if err := s.StartTLS(); err == nil {
+		t.Error("StartTLS without UseTLS did not fail")
+	}
+	if ok, _ := s.dial().Extension("STARTTLS"); ok {
+		t.Error("STARTTLS is offered without UseTLS")
+	}
+}
@@ -0,0 +1,495 @@
+package smtpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// SPF results (RFC 7208 section 2.6)
+const (
+	SPFNone      = "none"
+	SPFNeutral   = "neutral"
+	SPFPass      = "pass"
+	SPFFail      = "fail"
+	SPFSoftFail  = "softfail"
+	SPFTempError = "temperror"
+	SPFPermError = "permerror"
+)
+
+// spfLookupLimit is the number of mechanisms and modifiers that cause DNS
+// lookups an SPF check may evaluate
+const spfLookupLimit = 10
+
+// spfVoidLookupLimit is the number of DNS lookups that find nothing an SPF
+// check may make (RFC 7208 section 4.6.4)
+const spfVoidLookupLimit = 2
+
+// dnsResolver resolves the DNS records used to verify senders, it is
+// implemented by *net.Resolver
+type dnsResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// spfChecker evaluates the SPF record of a domain for a connecting client
+type spfChecker struct {
+	resolver dnsResolver
+	ip       net.IP
+	sender   string
+	helo     string
+	lookups  int
+	voids    int
+}
+
+// checkSPF checks if ip may send mail for sender, the MAIL FROM address.
+// Without a sender the HELO name is checked, as postmaster@helo. It returns
+// the result and the domain that was checked.
+func checkSPF(ctx context.Context, resolver dnsResolver, ip net.IP, sender, helo string) (string, string) {
+	if sender == "" || !strings.Contains(sender, "@") {
+		sender = "postmaster@" + helo
+	}
+	domain := addressDomain(sender)
+	if domain == "" {
+		return SPFNone, ""
+	}
+	c := &spfChecker{resolver: resolver, ip: ip, sender: sender, helo: helo}
+	return c.check(ctx, domain), domain
+}
+
+// spfRecord returns the SPF record of a domain, or "" if it has none
+func (c *spfChecker) spfRecord(ctx context.Context, domain string) (string, string) {
+	txts, err := c.resolver.LookupTXT(ctx, domain)
+	if err != nil {
+		if isNotFound(err) {
+			return "", SPFNone
+		}
+		return "", SPFTempError
+	}
+
+	var records []string
+	for _, txt := range txts {
+		lower := strings.ToLower(txt)
+		if lower == "v=spf1" || strings.HasPrefix(lower, "v=spf1 ") {
+			records = append(records, txt)
+		}
+	}
+	switch len(records) {
+	case 0:
+		return "", SPFNone
+	case 1:
+		return records[0], ""
+	}
+	return "", SPFPermError
+}
+
+// check is check_host() of RFC 7208 section 4
+func (c *spfChecker) check(ctx context.Context, domain string) string {
+	record, result := c.spfRecord(ctx, domain)
+	if record == "" {
+		return result
+	}
+
+	var redirect string
+	for _, term := range strings.Fields(record)[1:] {
+		// Modifiers are name=value, only redirect is used
+		if name, value, ok := strings.Cut(term, "="); ok && !strings.ContainsAny(name, ":/") {
+			if strings.EqualFold(name, "redirect") {
+				redirect = value
+			}
+			continue
+		}
+
+		qualifier := SPFPass
+		switch term[0] {
+		case '+':
+			term = term[1:]
+		case '-':
+			qualifier, term = SPFFail, term[1:]
+		case '~':
+			qualifier, term = SPFSoftFail, term[1:]
+		case '?':
+			qualifier, term = SPFNeutral, term[1:]
+		}
+
+		match, err := c.match(ctx, domain, term)
+		if err != nil {
+			return err.Error()
+		}
+		if match {
+			return qualifier
+		}
+	}
+
+	if redirect != "" {
+		if !c.countLookup() {
+			return SPFPermError
+		}
+		target, err := c.expand(redirect, domain)
+		if err != nil {
+			return SPFPermError
+		}
+		result := c.check(ctx, target)
+		if result == SPFNone {
+			return SPFPermError
+		}
+		return result
+	}
+	return SPFNeutral
+}
+
+// spfError is a temperror or permerror that ends the check
+type spfError string
+
+func (e spfError) Error() string {
+	return string(e)
+}
+
+var (
+	errSPFTemp = spfError(SPFTempError)
+	errSPFPerm = spfError(SPFPermError)
+)
+
+// countLookup counts a term that causes DNS lookups, it returns false once
+// the limit is exceeded
+func (c *spfChecker) countLookup() bool {
+	c.lookups++
+	return c.lookups <= spfLookupLimit
+}
+
+// countVoid counts a DNS lookup that found nothing, it returns false once
+// the void lookup limit is exceeded
+func (c *spfChecker) countVoid() bool {
+	c.voids++
+	return c.voids <= spfVoidLookupLimit
+}
+
+// match evaluates a mechanism
+func (c *spfChecker) match(ctx context.Context, domain, term string) (bool, error) {
+	name, arg, _ := strings.Cut(term, ":")
+	if i := strings.Index(name, "/"); i >= 0 && arg == "" {
+		name, arg = name[:i], name[i:]
+	}
+	name = strings.ToLower(name)
+
+	switch name {
+	case "all":
+		return true, nil
+	case "ip4", "ip6":
+		return c.matchIP(arg)
+	case "include":
+		if !c.countLookup() {
+			return false, errSPFPerm
+		}
+		target, err := c.expand(arg, domain)
+		if err != nil || target == "" {
+			return false, errSPFPerm
+		}
+		switch c.check(ctx, target) {
+		case SPFPass:
+			return true, nil
+		case SPFTempError:
+			return false, errSPFTemp
+		case SPFPermError, SPFNone:
+			return false, errSPFPerm
+		}
+		return false, nil
+	case "a", "mx":
+		if !c.countLookup() {
+			return false, errSPFPerm
+		}
+		target, cidr4, cidr6, err := c.targetAndCIDR(arg, domain)
+		if err != nil {
+			return false, err
+		}
+		hosts := []string{target}
+		if name == "mx" {
+			records, err := c.resolver.LookupMX(ctx, target)
+			if err != nil && !isNotFound(err) {
+				return false, errSPFTemp
+			}
+			if len(records) == 0 && !c.countVoid() {
+				return false, errSPFPerm
+			}
+			hosts = hosts[:0]
+			for _, record := range records {
+				hosts = append(hosts, record.Host)
+			}
+			if len(hosts) > spfLookupLimit {
+				return false, errSPFPerm
+			}
+		}
+		for _, host := range hosts {
+			addrs, err := c.resolver.LookupIPAddr(ctx, host)
+			if err != nil && !isNotFound(err) {
+				return false, errSPFTemp
+			}
+			if len(addrs) == 0 && !c.countVoid() {
+				return false, errSPFPerm
+			}
+			for _, addr := range addrs {
+				if ipInCIDR(c.ip, addr.IP, cidr4, cidr6) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	case "ptr":
+		if !c.countLookup() {
+			return false, errSPFPerm
+		}
+		target, err := c.expand(arg, domain)
+		if err != nil {
+			return false, errSPFPerm
+		}
+		if target == "" {
+			target = domain
+		}
+		return c.matchPTR(ctx, target), nil
+	case "exists":
+		if !c.countLookup() {
+			return false, errSPFPerm
+		}
+		target, err := c.expand(arg, domain)
+		if err != nil || target == "" {
+			return false, errSPFPerm
+		}
+		addrs, err := c.resolver.LookupIPAddr(ctx, target)
+		if err != nil && !isNotFound(err) {
+			return false, errSPFTemp
+		}
+		if len(addrs) == 0 && !c.countVoid() {
+			return false, errSPFPerm
+		}
+		return len(addrs) > 0, nil
+	}
+	return false, errSPFPerm
+}
+
+// matchIP matches the client against an ip4 or ip6 argument
+func (c *spfChecker) matchIP(arg string) (bool, error) {
+	if !strings.Contains(arg, "/") {
+		ip := net.ParseIP(arg)
+		if ip == nil {
+			return false, errSPFPerm
+		}
+		return ip.Equal(c.ip), nil
+	}
+	_, network, err := net.ParseCIDR(arg)
+	if err != nil {
+		return false, errSPFPerm
+	}
+	return network.Contains(c.ip), nil
+}
+
+// matchPTR checks if a validated host name of the client is in target
+func (c *spfChecker) matchPTR(ctx context.Context, target string) bool {
+	names, err := c.resolver.LookupAddr(ctx, c.ip.String())
+	if err != nil {
+		return false
+	}
+	target = strings.ToLower(strings.TrimSuffix(target, "."))
+	for i, name := range names {
+		if i == spfLookupLimit {
+			break
+		}
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if name != target && !strings.HasSuffix(name, "."+target) {
+			continue
+		}
+		addrs, err := c.resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if addr.IP.Equal(c.ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// targetAndCIDR splits the argument of a and mx into the domain and the
+// prefix lengths for IPv4 and IPv6, e.g. "example.com/24//64"
+func (c *spfChecker) targetAndCIDR(arg, domain string) (string, int, int, error) {
+	cidr4, cidr6 := 32, 128
+	if i := strings.Index(arg, "//"); i >= 0 {
+		n, err := strconv.Atoi(arg[i+2:])
+		if err != nil || n < 0 || n > 128 {
+			return "", 0, 0, errSPFPerm
+		}
+		cidr6, arg = n, arg[:i]
+	}
+	if i := strings.LastIndex(arg, "/"); i >= 0 {
+		n, err := strconv.Atoi(arg[i+1:])
+		if err != nil || n < 0 || n > 32 {
+			return "", 0, 0, errSPFPerm
+		}
+		cidr4, arg = n, arg[:i]
+	}
+	target, err := c.expand(arg, domain)
+	if err != nil {
+		return "", 0, 0, errSPFPerm
+	}
+	if target == "" {
+		target = domain
+	}
+	return target, cidr4, cidr6, nil
+}
+
+// ipInCIDR checks if ip is in the network of addr with the prefix length
+// for its address family
+func ipInCIDR(ip, addr net.IP, cidr4, cidr6 int) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		addr4 := addr.To4()
+		if addr4 == nil {
+			return false
+		}
+		mask := net.CIDRMask(cidr4, 32)
+		return ip4.Mask(mask).Equal(addr4.Mask(mask))
+	}
+	if addr.To4() != nil {
+		return false
+	}
+	mask := net.CIDRMask(cidr6, 128)
+	return ip.Mask(mask).Equal(addr.Mask(mask))
+}
+
+// expand expands the macros in a domain spec (RFC 7208 section 7)
+func (c *spfChecker) expand(spec, domain string) (string, error) {
+	if !strings.Contains(spec, "%") {
+		return spec, nil
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(spec); i++ {
+		if spec[i] != '%' {
+			out.WriteByte(spec[i])
+			continue
+		}
+		if i+1 >= len(spec) {
+			return "", errors.New("macro at end of domain spec")
+		}
+		i++
+		switch spec[i] {
+		case '%':
+			out.WriteByte('%')
+			continue
+		case '_':
+			out.WriteByte(' ')
+			continue
+		case '-':
+			out.WriteString("%20")
+			continue
+		case '{':
+		default:
+			return "", fmt.Errorf("invalid macro %%%c", spec[i])
+		}
+
+		end := strings.IndexByte(spec[i:], '}')
+		if end < 0 {
+			return "", errors.New("unterminated macro")
+		}
+		macro := spec[i+1 : i+end]
+		i += end
+		value, err := c.macroValue(macro, domain)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(value)
+	}
+	return out.String(), nil
+}
+
+// macroValue returns the value of a macro like "ir" or "d2"
+func (c *spfChecker) macroValue(macro, domain string) (string, error) {
+	if macro == "" {
+		return "", errors.New("empty macro")
+	}
+
+	local, senderDomain, _ := strings.Cut(c.sender, "@")
+	var value string
+	switch macro[0] | 0x20 {
+	case 's':
+		value = c.sender
+	case 'l':
+		value = local
+	case 'o':
+		value = senderDomain
+	case 'd':
+		value = domain
+	case 'h':
+		value = c.helo
+	case 'i':
+		if ip4 := c.ip.To4(); ip4 != nil {
+			value = ip4.String()
+		} else {
+			// IPv6 is written as dot-separated nibbles
+			var nibbles []string
+			for _, b := range c.ip.To16() {
+				nibbles = append(nibbles, fmt.Sprintf("%x", b>>4), fmt.Sprintf("%x", b&0xf))
+			}
+			value = strings.Join(nibbles, ".")
+		}
+	case 'v':
+		value = "in-addr"
+		if c.ip.To4() == nil {
+			value = "ip6"
+		}
+	default:
+		return "", fmt.Errorf("unsupported macro %q", macro)
+	}
+
+	// Transformers: the number of parts to keep, reversal and delimiters
+	rest := macro[1:]
+	digits := 0
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		digits++
+	}
+	keep := 0
+	if digits > 0 {
+		keep, _ = strconv.Atoi(rest[:digits])
+		if keep == 0 {
+			return "", errors.New("macro keeps zero parts")
+		}
+	}
+	rest = rest[digits:]
+	reverse := false
+	if strings.HasPrefix(rest, "r") || strings.HasPrefix(rest, "R") {
+		reverse, rest = true, rest[1:]
+	}
+	delimiters := "."
+	if rest != "" {
+		if strings.Trim(rest, ".-+,/_=") != "" {
+			return "", fmt.Errorf("invalid macro delimiters %q", rest)
+		}
+		delimiters = rest
+	}
+	if keep == 0 && !reverse && delimiters == "." {
+		return value, nil
+	}
+
+	parts := strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(delimiters, r) })
+	if reverse {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+	if keep > 0 && keep < len(parts) {
+		parts = parts[len(parts)-keep:]
+	}
+	return strings.Join(parts, "."), nil
+}
+
+// isNotFound reports whether a DNS lookup failed because the name or the
+// record does not exist
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
@@ -0,0 +1,336 @@
+package smtpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeResolver answers DNS lookups from maps. Names that are not in a map
+// are not found, names in fail fail with a temporary error.
+type fakeResolver struct {
+	txt  map[string][]string
+	mx   map[string][]string
+	ip   map[string][]string
+	ptr  map[string][]string
+	fail map[string]bool
+}
+
+func (r *fakeResolver) lookup(records map[string][]string, name string) ([]string, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if r.fail[name] {
+		return nil, &net.DNSError{Err: "server failure", Name: name, IsTemporary: true}
+	}
+	values, ok := records[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return values, nil
+}
+
+func (r *fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return r.lookup(r.txt, name)
+}
+
+func (r *fakeResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	hosts, err := r.lookup(r.mx, name)
+	var records []*net.MX
+	for i, host := range hosts {
+		records = append(records, &net.MX{Host: host + ".", Pref: uint16(10 * (i + 1))})
+	}
+	return records, err
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, err := r.lookup(r.ip, host)
+	var addrs []net.IPAddr
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, err
+}
+
+func (r *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	return r.lookup(r.ptr, addr)
+}
+
+// spfChain publishes n domains d0 to d(n-1) of which each points to the
+// next with the given term, and a last domain that allows 192.0.2.10
+func spfChain(n int, term string) map[string][]string {
+	txt := make(map[string][]string)
+	for i := 0; i < n; i++ {
+		txt[fmt.Sprintf("d%d.example", i)] = []string{fmt.Sprintf("v=spf1 %sd%d.example", term, i+1)}
+	}
+	txt[fmt.Sprintf("d%d.example", n)] = []string{"v=spf1 ip4:192.0.2.10 -all"}
+	return txt
+}
+
+func TestCheckSPF(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolver *fakeResolver
+		ip       string
+		sender   string
+		helo     string
+		want     string
+	}{
+		{
+			name:     "no record",
+			resolver: &fakeResolver{txt: map[string][]string{"example.com": {"some other text"}}},
+			ip:       "192.0.2.10", sender: "alice@example.com",
+			want: SPFNone,
+		},
+		{
+			name:     "no domain",
+			resolver: &fakeResolver{},
+			ip:       "192.0.2.10", sender: "alice@example.com",
+			want: SPFNone,
+		},
+		{
+			name:     "ip4 pass",
+			resolver: &fakeResolver{txt: map[string][]string{"example.com": {"v=spf1 ip4:192.0.2.0/24 -all"}}},
+			ip:       "192.0.2.10", sender: "alice@example.com",
+			want: SPFPass,
+		},
+		{
+			name:     "ip4 fail",
+			resolver: &fakeResolver{txt: map[string][]string{"example.com": {"v=spf1 ip4:192.0.2.0/24 -all"}}},
+			ip:       "198.51.100.1", sender: "alice@example.com",
+			want: SPFFail,
+		},
+		{
+			name:     "softfail",
+			resolver: &fakeResolver{txt: map[string][]string{"example.com": {"v=spf1 ip4:192.0.2.0/24 ~all"}}},
+			ip:       "198.51.100.1", sender: "alice@example.com",
+			want: SPFSoftFail,
+		},
+		{
+			name:     "neutral without all",
+			resolver: &fakeResolver{txt: map[string][]string{"example.com": {"v=spf1 ip4:192.0.2.0/24"}}},
+			ip:       "198.51.100.1", sender: "alice@example.com",
+			want: SPFNeutral,
+		},
+		{
+			name:     "ip6",
+			resolver: &fakeResolver{txt: map[string][]string{"example.com": {"v=spf1 ip6:2001:db8::/32 -all"}}},
+			ip:       "2001:db8::cb01", sender: "alice@example.com",
+			want: SPFPass,
+		},
+		{
+			name: "two records",
+			resolver: &fakeResolver{txt: map[string][]string{"example.com": {
+				"v=spf1 -all", "v=spf1 +all",
+			}}},
+			ip: "192.0.2.10", sender: "alice@example.com",
+			want: SPFPermError,
+		},
+		{
+			name:     "unknown mechanism",
+			resolver: &fakeResolver{txt: map[string][]string{"example.com": {"v=spf1 bogus -all"}}},
+			ip:       "192.0.2.10", sender: "alice@example.com",
+			want: SPFPermError,
+		},
+		{
+			name: "temporary failure",
+			resolver: &fakeResolver{
+				txt:  map[string][]string{},
+				fail: map[string]bool{"example.com": true},
+			},
+			ip: "192.0.2.10", sender: "alice@example.com",
+			want: SPFTempError,
+		},
+		{
+			name: "a with prefix length",
+			resolver: &fakeResolver{
+				txt: map[string][]string{"example.com": {"v=spf1 a/24 -all"}},
+				ip:  map[string][]string{"example.com": {"192.0.2.1"}},
+			},
+			ip: "192.0.2.10", sender: "alice@example.com",
+			want: SPFPass,
+		},
+		{
+			name: "mx",
+			resolver: &fakeResolver{
+				txt: map[string][]string{"example.com": {"v=spf1 mx -all"}},
+				mx:  map[string][]string{"example.com": {"mx1.example.com", "mx2.example.com"}},
+				ip:  map[string][]string{"mx1.example.com": {"192.0.2.1"}, "mx2.example.com": {"192.0.2.10"}},
+			},
+			ip: "192.0.2.10", sender: "alice@example.com",
+			want: SPFPass,
+		},
+		{
+			name: "ptr",
+			resolver: &fakeResolver{
+				txt: map[string][]string{"example.com": {"v=spf1 ptr -all"}},
+				ptr: map[string][]string{"192.0.2.10": {"mail.example.com."}},
+				ip:  map[string][]string{"mail.example.com": {"192.0.2.10"}},
+			},
+			ip: "192.0.2.10", sender: "alice@example.com",
+			want: SPFPass,
+		},
+		{
+			name: "include",
+			resolver: &fakeResolver{txt: map[string][]string{
+				"example.com":      {"v=spf1 include:_spf.example.net -all"},
+				"_spf.example.net": {"v=spf1 ip4:192.0.2.10 -all"},
+			}},
+			ip: "192.0.2.10", sender: "alice@example.com",
+			want: SPFPass,
+		},
+		{
+			name: "include without record",
+			resolver: &fakeResolver{txt: map[string][]string{
+				"example.com": {"v=spf1 include:_spf.example.net -all"},
+			}},
+			ip: "192.0.2.10", sender: "alice@example.com",
+			want: SPFPermError,
+		},
+		{
+			name: "redirect",
+			resolver: &fakeResolver{txt: map[string][]string{
+				"example.com":      {"v=spf1 redirect=_spf.example.net"},
+				"_spf.example.net": {"v=spf1 ip4:192.0.2.10 -all"},
+			}},
+			ip: "198.51.100.1", sender: "alice@example.com",
+			want: SPFFail,
+		},
+		{
+			name: "exists with macros",
+			resolver: &fakeResolver{
+				txt: map[string][]string{"example.com": {"v=spf1 exists:%{ir}.%{l1r-}._spf.%{d} -all"}},
+				ip:  map[string][]string{"10.2.0.192.strong._spf.example.com": {"127.0.0.2"}},
+			},
+			ip: "192.0.2.10", sender: "strong-bad@example.com",
+			want: SPFPass,
+		},
+		{
+			name:     "helo without sender",
+			resolver: &fakeResolver{txt: map[string][]string{"mail.example.com": {"v=spf1 ip4:192.0.2.10 -all"}}},
+			ip:       "192.0.2.10", helo: "mail.example.com",
+			want: SPFPass,
+		},
+		{
+			name:     "ten includes",
+			resolver: &fakeResolver{txt: spfChain(10, "include:")},
+			ip:       "192.0.2.10", sender: "alice@d0.example",
+			want: SPFPass,
+		},
+		{
+			name:     "eleven includes",
+			resolver: &fakeResolver{txt: spfChain(11, "include:")},
+			ip:       "192.0.2.10", sender: "alice@d0.example",
+			want: SPFPermError,
+		},
+		{
+			name:     "ten redirects",
+			resolver: &fakeResolver{txt: spfChain(10, "redirect=")},
+			ip:       "192.0.2.10", sender: "alice@d0.example",
+			want: SPFPass,
+		},
+		{
+			name:     "eleven redirects",
+			resolver: &fakeResolver{txt: spfChain(11, "redirect=")},
+			ip:       "192.0.2.10", sender: "alice@d0.example",
+			want: SPFPermError,
+		},
+		{
+			name: "two void lookups",
+			resolver: &fakeResolver{txt: map[string][]string{
+				"example.com": {"v=spf1 a:none1.example.com a:none2.example.com ip4:192.0.2.10 -all"},
+			}},
+			ip: "192.0.2.10", sender: "alice@example.com",
+			want: SPFPass,
+		},
+		{
+			name: "three void lookups",
+			resolver: &fakeResolver{txt: map[string][]string{
+				"example.com": {"v=spf1 a:none1.example.com mx:none2.example.com exists:none3.example.com ip4:192.0.2.10 -all"},
+			}},
+			ip: "192.0.2.10", sender: "alice@example.com",
+			want: SPFPermError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := checkSPF(context.Background(), tt.resolver, net.ParseIP(tt.ip), tt.sender, tt.helo)
+			if got != tt.want {
+				t.Errorf("checkSPF() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSPFMacros(t *testing.T) {
+	// The examples of RFC 7208 section 7.4
+	tests := []struct {
+		spec string
+		ip   string
+		want string
+	}{
+		{spec: "%{s}", want: "strong-bad@email.example.com"},
+		{spec: "%{o}", want: "email.example.com"},
+		{spec: "%{d}", want: "email.example.com"},
+		{spec: "%{d4}", want: "email.example.com"},
+		{spec: "%{d3}", want: "email.example.com"},
+		{spec: "%{d2}", want: "example.com"},
+		{spec: "%{d1}", want: "com"},
+		{spec: "%{dr}", want: "com.example.email"},
+		{spec: "%{d2r}", want: "example.email"},
+		{spec: "%{l}", want: "strong-bad"},
+		{spec: "%{l-}", want: "strong.bad"},
+		{spec: "%{lr}", want: "strong-bad"},
+		{spec: "%{lr-}", want: "bad.strong"},
+		{spec: "%{l1r-}", want: "strong"},
+		{spec: "%{ir}.%{v}._spf.%{d2}", want: "3.2.0.192.in-addr._spf.example.com"},
+		{spec: "%{lr-}.lp._spf.%{d2}", want: "bad.strong.lp._spf.example.com"},
+		{spec: "%{lr-}.lp.%{ir}.%{v}._spf.%{d2}", want: "bad.strong.lp.3.2.0.192.in-addr._spf.example.com"},
+		{spec: "%{ir}.%{v}.%{l1r-}.lp._spf.%{d2}", want: "3.2.0.192.in-addr.strong.lp._spf.example.com"},
+		{spec: "%{d2}.trusted-domains.example.net", want: "example.com.trusted-domains.example.net"},
+		{
+			spec: "%{ir}.%{v}._spf.%{d2}",
+			ip:   "2001:db8::cb01",
+			want: "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com",
+		},
+		{spec: "%%%_%-", want: "% %20"},
+		{spec: "%{h}", want: "mx.example.org"},
+	}
+
+	for _, tt := range tests {
+		ip := tt.ip
+		if ip == "" {
+			ip = "192.0.2.3"
+		}
+		c := &spfChecker{ip: net.ParseIP(ip), sender: "strong-bad@email.example.com", helo: "mx.example.org"}
+		got, err := c.expand(tt.spec, "email.example.com")
+		if err != nil {
+			t.Errorf("expand(%q) failed: %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("expand(%q) = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"%", "%x", "%{d", "%{}", "%{x}", "%{d0}", "%{d2!}"} {
+		c := &spfChecker{ip: net.ParseIP("192.0.2.3"), sender: "strong-bad@email.example.com"}
+		if got, err := c.expand(spec, "email.example.com"); err == nil {
+			t.Errorf("expand(%q) = %q, want an error", spec, got)
+		}
+	}
+}
+
+func TestIsNotFound(t *testing.T) {
+	if !isNotFound(&net.DNSError{Err: "no such host", IsNotFound: true}) {
+		t.Error("A not found error was not recognized")
+	}
+	if isNotFound(&net.DNSError{Err: "server failure", IsTemporary: true}) {
+		t.Error("A temporary error was taken for not found")
+	}
+	if isNotFound(errors.New("no such host")) {
+		t.Error("A plain error was taken for not found")
+	}
+}
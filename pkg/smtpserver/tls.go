@@ -0,0 +1,69 @@
+package smtpserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/webdavserver"
+)
+
+// loadTLSConfig loads the certificate of the configuration, generating it
+// if allowed and needed
+func loadTLSConfig(config Config) (*tls.Config, error) {
+	certFile, keyFile, err := webdavserver.PrepareCertificate(config.certificateOptions())
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+	log.Printf("SMTP TLS enabled using certificates: %s, %s", certFile, keyFile)
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// StartTLS serves SMTP over implicit TLS on TLSPort, e.g. 465 for mail
+// submission. It can run next to Start, which offers STARTTLS on Port.
+func (s *Server) StartTLS() error {
+	if s.smtpServer.TLSConfig == nil {
+		return fmt.Errorf("TLS is not enabled, set UseTLS in the configuration")
+	}
+	if s.config.TLSPort == 0 {
+		return fmt.Errorf("no TLS port configured")
+	}
+
+	addr := fmt.Sprintf("%s:%d", s.config.Host, s.config.TLSPort)
+	listener, err := s.listen(addr, false)
+	if err != nil {
+		log.Printf("ERROR: SMTP TLS listener failed to start: %v", err)
+		return err
+	}
+	log.Printf("Starting SMTP server with implicit TLS at %s", addr)
+	return s.smtpServer.Serve(tls.NewListener(listener, s.smtpServer.TLSConfig))
+}
+
+// certificateOptions returns the options of the certificate, with the
+// defaults for the certificates directory and organization
+func (config Config) certificateOptions() webdavserver.CertificateOptions {
+	defaults := DefaultConfig()
+	certificate := webdavserver.CertificateOptions{
+		CertFile:     config.CertFile,
+		KeyFile:      config.KeyFile,
+		AutoGenerate: config.AutoGenerateCerts,
+		CertDir:      config.CertDir,
+		Name:         "smtp",
+		ValidityDays: config.CertValidityDays,
+		Organization: config.CertOrganization,
+	}
+	if certificate.CertDir == "" {
+		certificate.CertDir = defaults.CertDir
+	}
+	if certificate.Organization == "" {
+		certificate.Organization = defaults.CertOrganization
+	}
+	return certificate
+}
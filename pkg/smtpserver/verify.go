@@ -0,0 +1,137 @@
+package smtpserver
+
+import (
+	"context"
+	"log"
+	"net"
+	netmail "net/mail"
+	"strings"
+
+	"github.com/emersion/go-smtp"
+	mailmodel "github.com/freeflowuniverse/herolauncher/pkg/mail"
+)
+
+// Actions taken on mail that fails DMARC
+const (
+	AuthActionNone       = "none"
+	AuthActionReject     = "reject"
+	AuthActionQuarantine = "quarantine"
+	AuthActionTag        = "tag"
+)
+
+// AuthResults are the results of verifying the sender of a message, stored
+// with it as "auth"
+type AuthResults struct {
+	SPF         string       `json:"spf"`
+	SPFDomain   string       `json:"spf_domain,omitempty"`
+	DKIM        []DKIMResult `json:"dkim,omitempty"`
+	DMARC       string       `json:"dmarc"`
+	DMARCPolicy string       `json:"dmarc_policy,omitempty"`
+	FromDomain  string       `json:"from_domain,omitempty"`
+	// Action is the action taken on the message
+	Action string `json:"action,omitempty"`
+}
+
+// AuthPolicy is what to do with mail from a domain that fails DMARC
+type AuthPolicy struct {
+	// Action is one of the AuthAction constants. When empty, the policy the
+	// domain publishes is followed: reject, quarantine or none.
+	Action string
+	// Folder is the mailbox quarantined mail is put in, "Junk" by default
+	Folder string
+	// Tag is put in front of the subject of tagged mail, "[SPAM]" by default
+	Tag string
+}
+
+// policyFor returns the policy for mail from a domain: the policy of the
+// domain, else of its closest parent domain, else the "*" policy
+func policyFor(policies map[string]AuthPolicy, domain string) AuthPolicy {
+	domain = strings.ToLower(domain)
+	for d := domain; d != ""; {
+		if policy, ok := policies[d]; ok {
+			return policy
+		}
+		_, parent, ok := strings.Cut(d, ".")
+		if !ok {
+			break
+		}
+		d = parent
+	}
+	return policies["*"]
+}
+
+// action returns the action to take on a message with the given results
+func (p AuthPolicy) action(results *AuthResults) string {
+	if results.DMARC != DMARCFail {
+		return AuthActionNone
+	}
+	if p.Action != "" {
+		return p.Action
+	}
+	switch results.DMARCPolicy {
+	case DMARCPolicyReject:
+		return AuthActionReject
+	case DMARCPolicyQuarantine:
+		return AuthActionQuarantine
+	}
+	return AuthActionNone
+}
+
+// verifySender checks SPF, DKIM and DMARC for a message and decides on the
+// action to take
+func (s *Session) verifySender(ctx context.Context, data []byte) (*AuthResults, AuthPolicy) {
+	results := &AuthResults{}
+	results.SPF, results.SPFDomain = checkSPF(ctx, s.resolver, s.ip, s.from, s.helo)
+	results.DKIM = verifyDKIM(ctx, s.resolver, data)
+
+	fields, _ := splitMessage(data)
+	for _, field := range fields {
+		if !strings.EqualFold(field.name, "From") {
+			continue
+		}
+		if addresses, err := netmail.ParseAddressList(field.value()); err == nil && len(addresses) > 0 {
+			results.FromDomain = addressDomain(addresses[0].Address)
+		}
+		break
+	}
+	results.DMARC, results.DMARCPolicy = checkDMARC(ctx, s.resolver, results.FromDomain,
+		results.SPF, results.SPFDomain, results.DKIM)
+
+	policy := policyFor(s.authPolicies, results.FromDomain)
+	results.Action = policy.action(results)
+	if policy.Folder == "" {
+		policy.Folder = "Junk"
+	}
+	if policy.Tag == "" {
+		policy.Tag = "[SPAM]"
+	}
+	log.Printf("Sender verification for %s from %s: spf=%s dkim=%d signatures dmarc=%s action=%s",
+		results.FromDomain, s.ip, results.SPF, len(results.DKIM), results.DMARC, results.Action)
+	return results, policy
+}
+
+// applyAuthAction quarantines, tags or rejects a message as decided by
+// verifySender
+func applyAuthAction(email *mailmodel.Email, results *AuthResults, policy AuthPolicy) error {
+	switch results.Action {
+	case AuthActionReject:
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 7, 1},
+			Message:      "Message rejected by the DMARC policy of " + results.FromDomain,
+		}
+	case AuthActionQuarantine:
+		email.Mailbox = policy.Folder
+	case AuthActionTag:
+		email.SetSubject(policy.Tag + " " + email.Subject())
+	}
+	return nil
+}
+
+// remoteIP returns the IP address of a client connection
+func remoteIP(addr net.Addr) net.IP {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package smtpserver
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/emersion/go-smtp"
+	mailmodel "github.com/freeflowuniverse/herolauncher/pkg/mail"
+)
+
+func TestPolicyFor(t *testing.T) {
+	policies := map[string]AuthPolicy{
+		"example.com":      {Action: AuthActionTag},
+		"news.example.com": {Action: AuthActionNone},
+		"*":                {Action: AuthActionQuarantine},
+	}
+	tests := map[string]string{
+		"example.com":            AuthActionTag,
+		"Mail.Example.com":       AuthActionTag,
+		"news.example.com":       AuthActionNone,
+		"daily.news.example.com": AuthActionNone,
+		"example.net":            AuthActionQuarantine,
+		"":                       AuthActionQuarantine,
+	}
+	for domain, want := range tests {
+		if got := policyFor(policies, domain).Action; got != want {
+			t.Errorf("policyFor(%q) = %q, want %q", domain, got, want)
+		}
+	}
+	if got := policyFor(nil, "example.com"); got != (AuthPolicy{}) {
+		t.Errorf("policyFor without policies = %+v, want the zero policy", got)
+	}
+}
+
+func TestAuthPolicyAction(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  AuthPolicy
+		results AuthResults
+		want    string
+	}{
+		{name: "pass", results: AuthResults{DMARC: DMARCPass, DMARCPolicy: DMARCPolicyReject}, want: AuthActionNone},
+		{name: "no record", results: AuthResults{DMARC: DMARCNone}, want: AuthActionNone},
+		{name: "published reject", results: AuthResults{DMARC: DMARCFail, DMARCPolicy: DMARCPolicyReject}, want: AuthActionReject},
+		{name: "published quarantine", results: AuthResults{DMARC: DMARCFail, DMARCPolicy: DMARCPolicyQuarantine}, want: AuthActionQuarantine},
+		{name: "published none", results: AuthResults{DMARC: DMARCFail, DMARCPolicy: DMARCPolicyNone}, want: AuthActionNone},
+		{
+			name:    "configured action",
+			policy:  AuthPolicy{Action: AuthActionTag},
+			results: AuthResults{DMARC: DMARCFail, DMARCPolicy: DMARCPolicyReject},
+			want:    AuthActionTag,
+		},
+		{
+			name:    "configured action on pass",
+			policy:  AuthPolicy{Action: AuthActionReject},
+			results: AuthResults{DMARC: DMARCPass},
+			want:    AuthActionNone,
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.action(&tt.results); got != tt.want {
+			t.Errorf("%s: action() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestApplyAuthAction(t *testing.T) {
+	policy := AuthPolicy{Folder: "Junk", Tag: "[SPAM]"}
+
+	email := &mailmodel.Email{Mailbox: "INBOX"}
+	email.SetSubject("Hello")
+	if err := applyAuthAction(email, &AuthResults{Action: AuthActionQuarantine}, policy); err != nil {
+		t.Fatalf("Quarantine failed: %v", err)
+	}
+	if email.Mailbox != "Junk" || email.Subject() != "Hello" {
+		t.Errorf("Quarantined mail is in %q with subject %q", email.Mailbox, email.Subject())
+	}
+
+	email = &mailmodel.Email{Mailbox: "INBOX"}
+	email.SetSubject("Hello")
+	if err := applyAuthAction(email, &AuthResults{Action: AuthActionTag}, policy); err != nil {
+		t.Fatalf("Tag failed: %v", err)
+	}
+	if email.Mailbox != "INBOX" || email.Subject() != "[SPAM] Hello" {
+		t.Errorf("Tagged mail is in %q with subject %q", email.Mailbox, email.Subject())
+	}
+
+	err := applyAuthAction(email, &AuthResults{Action: AuthActionReject, FromDomain: "example.com"}, policy)
+	var smtpErr *smtp.SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 550 {
+		t.Errorf("Reject returned %v, want a 550 error", err)
+	}
+}
+
+func TestVerifySender(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate a key: %v", err)
+	}
+	resolver := &fakeResolver{txt: map[string][]string{
+		"example.com":                 {"v=spf1 ip4:192.0.2.0/24 -all"},
+		"_dmarc.example.com":          {"v=DMARC1; p=reject"},
+		"test._domainkey.example.com": {dkimKeyRecord(t, key)},
+	}}
+	signed := dkimSign(t, key, "relaxed/relaxed", dkimTestMessage)
+
+	tests := []struct {
+		name     string
+		ip       string
+		from     string
+		data     string
+		policies map[string]AuthPolicy
+		spf      string
+		dmarc    string
+		action   string
+	}{
+		{
+			name: "SPF pass", ip: "192.0.2.10", from: "alice@example.com", data: dkimTestMessage,
+			spf: SPFPass, dmarc: DMARCPass, action: AuthActionNone,
+		},
+		{
+			name: "DKIM pass", ip: "198.51.100.1", from: "bounce@example.net", data: signed,
+			spf: SPFNone, dmarc: DMARCPass, action: AuthActionNone,
+		},
+		{
+			name: "forged", ip: "198.51.100.1", from: "alice@example.com", data: dkimTestMessage,
+			spf: SPFFail, dmarc: DMARCFail, action: AuthActionReject,
+		},
+		{
+			name: "forged and tagged", ip: "198.51.100.1", from: "alice@example.com", data: dkimTestMessage,
+			policies: map[string]AuthPolicy{"*": {Action: AuthActionTag}},
+			spf:      SPFFail, dmarc: DMARCFail, action: AuthActionTag,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Session{
+				from:         tt.from,
+				ip:           net.ParseIP(tt.ip),
+				helo:         "mail.example.net",
+				resolver:     resolver,
+				authPolicies: tt.policies,
+			}
+			results, policy := s.verifySender(context.Background(), []byte(tt.data))
+			if results.SPF != tt.spf || results.DMARC != tt.dmarc || results.Action != tt.action {
+				t.Errorf("Results spf=%s dmarc=%s action=%s, want spf=%s dmarc=%s action=%s",
+					results.SPF, results.DMARC, results.Action, tt.spf, tt.dmarc, tt.action)
+			}
+			if results.FromDomain != "example.com" {
+				t.Errorf("FromDomain = %q, want example.com", results.FromDomain)
+			}
+			if policy.Folder != "Junk" || policy.Tag != "[SPAM]" {
+				t.Errorf("Policy defaults are %q and %q", policy.Folder, policy.Tag)
+			}
+		})
+	}
+}
+
+func TestSenderVerified(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		auth *AuthResults
+		want bool
+	}{
+		{name: "not verified", from: "alice@example.com", want: false},
+		{name: "SPF pass", from: "alice@example.com", auth: &AuthResults{SPF: SPFPass, SPFDomain: "example.com"}, want: true},
+		{name: "SPF pass for HELO", from: "alice@example.com", auth: &AuthResults{SPF: SPFPass, SPFDomain: "mail.example.net"}, want: false},
+		{name: "SPF fail", from: "alice@example.com", auth: &AuthResults{SPF: SPFFail, SPFDomain: "example.com"}, want: false},
+		{name: "DMARC pass", from: "bounce@news.example.com", auth: &AuthResults{SPF: SPFNone, DMARC: DMARCPass, FromDomain: "example.com"}, want: true},
+		{name: "DMARC pass for another domain", from: "alice@example.net", auth: &AuthResults{SPF: SPFNone, DMARC: DMARCPass, FromDomain: "example.com"}, want: false},
+		{name: "DMARC fail", from: "alice@example.com", auth: &AuthResults{SPF: SPFSoftFail, SPFDomain: "example.com", DMARC: DMARCFail, FromDomain: "example.com"}, want: false},
+	}
+	for _, tt := range tests {
+		if got := senderVerified(tt.from, tt.auth); got != tt.want {
+			t.Errorf("%s: senderVerified() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package smtpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	mailmodel "github.com/freeflowuniverse/herolauncher/pkg/mail"
+	"github.com/freeflowuniverse/herolauncher/pkg/mailauth"
+)
+
+// webhookClient calls the webhooks
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// WebhookEvent is what webhooks are called with, as JSON, when a message
+// is stored
+type WebhookEvent struct {
+	Event      string           `json:"event"`
+	User       string           `json:"user"`
+	Mailbox    string           `json:"mailbox"`
+	Key        string           `json:"key"`
+	Recipients []string         `json:"recipients"`
+	Email      *mailmodel.Email `json:"email"`
+}
+
+// notifyWebhooks calls the webhooks matching a message stored for a user in
+// the background, so slow endpoints do not hold up the client
+func (s *Session) notifyWebhooks(user, mailbox, key string, email *mailmodel.Email) {
+	hooks, err := s.users.Webhooks()
+	if err != nil {
+		log.Printf("ERROR: Failed to load webhooks: %v", err)
+		return
+	}
+	event := WebhookEvent{
+		Event:      "received",
+		User:       user,
+		Mailbox:    mailbox,
+		Key:        key,
+		Recipients: s.to,
+		Email:      email,
+	}
+	for _, hook := range hooks {
+		if hook.Matches(user, s.to, mailbox) {
+			go callWebhook(hook, event)
+		}
+	}
+}
+
+// callWebhook posts an event to a webhook. With a secret the body is signed
+// in the X-Webhook-Signature header as sha256=<hex HMAC-SHA256>.
+func callWebhook(hook mailauth.Webhook, event WebhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("ERROR: Failed to marshal webhook event: %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookClient.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("ERROR: Invalid webhook %s: %v", hook.ID, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", hook.ID)
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		log.Printf("ERROR: Webhook %s to %s failed: %v", hook.ID, hook.URL, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		log.Printf("ERROR: Webhook %s to %s failed: %s", hook.ID, hook.URL, resp.Status)
+		return
+	}
+	log.Printf("Called webhook %s for %s", hook.ID, event.Key)
+}
@@ -0,0 +1,436 @@
+// Package sshserver serves line based shells, like the telnet servers of the
+// processmanager and the handlerfactory, over SSH. Listen returns a
+// net.Listener whose connections are the shell sessions of authenticated
+// users, so a server that handles telnet connections handles SSH sessions
+// the same way.
+package sshserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// handshakeTimeout is how long a client may take to authenticate
+const handshakeTimeout = 30 * time.Second
+
+// Config configures an SSH server
+type Config struct {
+	// HostKeyFile is the private key of the server in PEM format, which is
+	// generated if the file does not exist
+	HostKeyFile string
+	// AuthorizedKeysFile lists the public keys that may log in, in the
+	// format of ~/.ssh/authorized_keys. It is read at every login, so keys
+	// can be added without a restart.
+	AuthorizedKeysFile string
+	// Password checks the password of a user, like the secret of a telnet
+	// server. Logging in with a password is disabled if it is nil.
+	Password func(user, password string) bool
+}
+
+// Conn is a shell session of an authenticated user. Sessions with a
+// terminal are read a line at a time, which is echoed and can be edited
+// with backspace, unless SetRaw makes them read the keys as they are typed,
+// and newlines are written as CRLF. A command given to ssh
+// is read as a line followed by an empty line, after which the session
+// ends. Deadlines are not supported.
+type Conn struct {
+	channel ssh.Channel
+	conn    *ssh.ServerConn
+	// input is what the session reads, the command followed by the channel
+	// or the channel
+	input *bufio.Reader
+	// terminal is set for sessions with a terminal, typed for those whose
+	// input is typed on it and lines for those that read it a line at a time
+	terminal, typed, lines bool
+	// line is the line that is being typed, and pending the lines that
+	// were typed and not read yet
+	line    []byte
+	pending []byte
+	once    sync.Once
+}
+
+// listener accepts the shell sessions of an SSH server
+type listener struct {
+	tcp      net.Listener
+	config   *ssh.ServerConfig
+	sessions chan *Conn
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// Listen starts an SSH server on a TCP address and returns a listener of the
+// shell sessions of its users, who log in with a key of the authorized keys
+// file or a password that config.Password accepts
+func Listen(address string, config Config) (net.Listener, error) {
+	if config.AuthorizedKeysFile == "" && config.Password == nil {
+		return nil, errors.New("no authorized keys file or password check to authenticate users with")
+	}
+	hostKey, err := loadHostKey(config.HostKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	serverConfig := &ssh.ServerConfig{}
+	serverConfig.AddHostKey(hostKey)
+	if config.AuthorizedKeysFile != "" {
+		serverConfig.PublicKeyCallback = func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			authorized, err := authorizedKeys(config.AuthorizedKeysFile)
+			if err != nil {
+				return nil, err
+			}
+			for _, authorizedKey := range authorized {
+				if bytes.Equal(authorizedKey.Marshal(), key.Marshal()) {
+					return &ssh.Permissions{Extensions: map[string]string{"key": ssh.FingerprintSHA256(key)}}, nil
+				}
+			}
+			return nil, fmt.Errorf("unknown public key for %s", meta.User())
+		}
+	}
+	if config.Password != nil {
+		serverConfig.PasswordCallback = func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if !config.Password(meta.User(), string(password)) {
+				return nil, fmt.Errorf("invalid password for %s", meta.User())
+			}
+			return &ssh.Permissions{Extensions: map[string]string{"password": string(password)}}, nil
+		}
+	}
+
+	tcp, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on TCP address: %v", err)
+	}
+	l := &listener{
+		tcp:      tcp,
+		config:   serverConfig,
+		sessions: make(chan *Conn),
+		closed:   make(chan struct{}),
+	}
+	go l.serve()
+	return l, nil
+}
+
+// Accept waits for the next shell session
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case session := <-l.sessions:
+		return session, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the server. Sessions that were accepted stay open.
+func (l *listener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return l.tcp.Close()
+}
+
+// Addr returns the address of the server
+func (l *listener) Addr() net.Addr {
+	return l.tcp.Addr()
+}
+
+// serve accepts the connections of the server
+func (l *listener) serve() {
+	for {
+		conn, err := l.tcp.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			fmt.Printf("Failed to accept SSH connection: %v\n", err)
+			continue
+		}
+		go l.handleConnection(conn)
+	}
+}
+
+// handleConnection authenticates a connection and serves its sessions
+func (l *listener) handleConnection(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, l.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go l.handleSession(serverConn, channel, channelRequests)
+	}
+}
+
+// handleSession waits for the request of a session for a shell or a
+// command, and passes the session to Accept
+func (l *listener) handleSession(serverConn *ssh.ServerConn, channel ssh.Channel, requests <-chan *ssh.Request) {
+	session := &Conn{channel: channel, conn: serverConn}
+	started := false
+	for request := range requests {
+		ok, start := false, false
+		switch {
+		case request.Type == "env" || request.Type == "window-change":
+			ok = true
+		case started:
+			// The session is read by its server already
+		case request.Type == "pty-req":
+			session.terminal, ok = true, true
+		case request.Type == "shell":
+			session.input = bufio.NewReader(channel)
+			session.typed, session.lines = session.terminal, session.terminal
+			ok, start = true, true
+		case request.Type == "exec":
+			var payload struct{ Command string }
+			if ssh.Unmarshal(request.Payload, &payload) != nil {
+				break
+			}
+			// The command is run at the empty line, after which the session
+			// ends
+			session.input = bufio.NewReader(strings.NewReader(strings.TrimRight(payload.Command, "\n") + "\n\n"))
+			ok, start = true, true
+		}
+		if request.WantReply {
+			request.Reply(ok, nil)
+		}
+		if start {
+			started = true
+			select {
+			case l.sessions <- session:
+			case <-l.closed:
+				channel.Close()
+				return
+			}
+		}
+	}
+}
+
+// User returns the name that the user logged in with
+func (c *Conn) User() string {
+	return c.conn.User()
+}
+
+// Password returns the password that the user logged in with, or "" if the
+// user logged in with a key
+func (c *Conn) Password() string {
+	return c.conn.Permissions.Extensions["password"]
+}
+
+// KeyFingerprint returns the SHA256 fingerprint of the key that the user
+// logged in with, or "" if the user logged in with a password
+func (c *Conn) KeyFingerprint() string {
+	return c.conn.Permissions.Extensions["key"]
+}
+
+// Terminal reports whether the input of the session is typed on a terminal,
+// which is the case for shells with a terminal
+func (c *Conn) Terminal() bool {
+	return c.typed
+}
+
+// SetRaw sets whether a session with a terminal reads the keys as they are
+// typed, without echoing them, for servers that edit the lines themselves,
+// or a line at a time
+func (c *Conn) SetRaw(raw bool) {
+	if c.typed {
+		c.lines = !raw
+	}
+}
+
+// Read reads the input of the session, a line at a time for terminals
+func (c *Conn) Read(p []byte) (int, error) {
+	if !c.lines {
+		return c.input.Read(p)
+	}
+	for len(c.pending) == 0 {
+		if err := c.readLine(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readLine reads a line that is typed on the terminal, which is echoed
+func (c *Conn) readLine() error {
+	for {
+		b, err := c.input.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case b == '\r' || b == '\n':
+			c.channel.Write([]byte("\r\n"))
+			c.pending = append(append(c.pending, c.line...), '\n')
+			c.line = c.line[:0]
+			return nil
+		case b == 0x7f || b == '\b':
+			if len(c.line) > 0 {
+				_, size := utf8.DecodeLastRune(c.line)
+				c.line = c.line[:len(c.line)-size]
+				c.channel.Write([]byte("\b \b"))
+			}
+		case b == 0x03:
+			// Ctrl+C is read as a line of itself, which ends the shells
+			c.channel.Write([]byte("^C\r\n"))
+			c.pending = append(c.pending, "\x03\n"...)
+			c.line = c.line[:0]
+			return nil
+		case b == 0x04:
+			// Ctrl+D ends the input on an empty line
+			if len(c.line) == 0 {
+				return io.EOF
+			}
+		case b == 0x15:
+			// Ctrl+U clears the line
+			for range utf8.RuneCount(c.line) {
+				c.channel.Write([]byte("\b \b"))
+			}
+			c.line = c.line[:0]
+		case b == 0x1b:
+			// Escape sequences, like the arrow keys, are ignored
+			c.skipEscape()
+		case b >= 0x20:
+			c.line = append(c.line, b)
+			c.channel.Write([]byte{b})
+		}
+	}
+}
+
+// skipEscape skips the rest of an escape sequence
+func (c *Conn) skipEscape() {
+	b, err := c.input.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return
+	}
+	for {
+		b, err := c.input.ReadByte()
+		if err != nil || (b >= 0x40 && b <= 0x7e) {
+			return
+		}
+	}
+}
+
+// Write writes to the session, with newlines as CRLF for terminals
+func (c *Conn) Write(p []byte) (int, error) {
+	if !c.terminal {
+		return c.channel.Write(p)
+	}
+	if _, err := c.channel.Write(bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close ends the session with exit status 0
+func (c *Conn) Close() error {
+	var err error
+	c.once.Do(func() {
+		c.channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+		err = c.channel.Close()
+	})
+	return err
+}
+
+// LocalAddr returns the address of the server
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the address of the client
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline is not supported, and does nothing
+func (c *Conn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+// SetReadDeadline is not supported, and does nothing
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline is not supported, and does nothing
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// loadHostKey reads the host key of the server, and generates it if the file
+// does not exist
+func loadHostKey(path string) (ssh.Signer, error) {
+	if path == "" {
+		return nil, errors.New("no host key file")
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate host key: %v", err)
+		}
+		block, err := ssh.MarshalPrivateKey(key, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate host key: %v", err)
+		}
+		data = pem.EncodeToMemory(block)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("failed to write host key: %v", err)
+		}
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			return nil, fmt.Errorf("failed to write host key: %v", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read host key: %v", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host key %s: %v", path, err)
+	}
+	return signer, nil
+}
+
+// authorizedKeys reads the public keys of an authorized keys file
+func authorizedKeys(path string) ([]ssh.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorized keys: %v", err)
+	}
+	var keys []ssh.PublicKey
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("invalid authorized key on line %d of %s: %v", i+1, path, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,168 @@
+package sshserver
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startTestServer starts a server on a free port that writes every line a
+// session reads back with its user, and returns its address
+func startTestServer(t *testing.T, config Config) string {
+	t.Helper()
+	if config.HostKeyFile == "" {
+		config.HostKeyFile = filepath.Join(t.TempDir(), "host_key")
+	}
+	l, err := Listen("127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				session := conn.(*Conn)
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					conn.Write([]byte(session.User() + ": " + scanner.Text() + "\n"))
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// dial logs in to a server
+func dial(t *testing.T, address, user string, auth ssh.AuthMethod) (*ssh.Client, error) {
+	t.Helper()
+	return ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+}
+
+func TestPasswordAndCommand(t *testing.T) {
+	address := startTestServer(t, Config{Password: func(user, password string) bool {
+		return password == "secret"
+	}})
+
+	if _, err := dial(t, address, "admin", ssh.Password("wrong")); err == nil {
+		t.Error("Expected a wrong password to be rejected")
+	}
+
+	client, err := dial(t, address, "admin", ssh.Password("secret"))
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The command is read as a line and an empty line, and the session ends
+	output, err := session.Output("!!vm.list")
+	if err != nil {
+		t.Fatalf("Command failed: %v", err)
+	}
+	if string(output) != "admin: !!vm.list\nadmin: \n" {
+		t.Errorf("Unexpected output %q", output)
+	}
+}
+
+func TestKeysAndTerminal(t *testing.T) {
+	dir := t.TempDir()
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized := "# admins\n\n" + string(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	keysFile := filepath.Join(dir, "authorized_keys")
+	os.WriteFile(keysFile, []byte(authorized), 0600)
+	hostKeyFile := filepath.Join(dir, "host_key")
+	address := startTestServer(t, Config{HostKeyFile: hostKeyFile, AuthorizedKeysFile: keysFile})
+
+	// The host key is generated and kept
+	if info, err := os.Stat(hostKeyFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected a host key file with mode 0600, got %v, %v", info, err)
+	}
+	if _, err := dial(t, address, "ops", ssh.Password("secret")); err == nil {
+		t.Error("Expected passwords to be rejected without a password check")
+	}
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	otherSigner, _ := ssh.NewSignerFromKey(other)
+	if _, err := dial(t, address, "ops", ssh.PublicKeys(otherSigner)); err == nil {
+		t.Error("Expected an unknown key to be rejected")
+	}
+
+	client, err := dial(t, address, "ops", ssh.PublicKeys(signer))
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+	stdin, _ := session.StdinPipe()
+	stdout, _ := session.StdoutPipe()
+	if err := session.Shell(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Typed lines are echoed and edited, escape sequences are ignored and
+	// newlines are written as CRLF
+	stdin.Write([]byte("!!vm.lisx\x7ft\x1b[A\r"))
+	stdin.Write([]byte("wrong\x15é\x7fok\r"))
+	stdin.Write([]byte{0x04})
+	output, _ := io.ReadAll(stdout)
+	expected := "!!vm.lisx\b \bt\r\nops: !!vm.list\r\nwrong\b \b\b \b\b \b\b \b\b \bé\b \bok\r\nops: ok\r\n"
+	if string(output) != expected {
+		t.Errorf("Expected %q, got %q", expected, output)
+	}
+	if err := session.Wait(); err != nil {
+		t.Errorf("Expected the session to end with status 0, got %v", err)
+	}
+}
+
+func TestListenErrors(t *testing.T) {
+	if _, err := Listen("127.0.0.1:0", Config{HostKeyFile: filepath.Join(t.TempDir(), "key")}); err == nil {
+		t.Error("Expected an error without a way to authenticate users")
+	}
+
+	invalid := filepath.Join(t.TempDir(), "invalid")
+	os.WriteFile(invalid, []byte("not a key"), 0600)
+	_, err := Listen("127.0.0.1:0", Config{HostKeyFile: invalid, Password: func(string, string) bool { return true }})
+	if err == nil || !strings.Contains(err.Error(), "invalid host key") {
+		t.Errorf("Expected an invalid host key error, got %v", err)
+	}
+
+	l, err := Listen("127.0.0.1:0", Config{HostKeyFile: filepath.Join(t.TempDir(), "key"), Password: func(string, string) bool { return true }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if _, err := l.Accept(); err != net.ErrClosed {
+		t.Errorf("Expected net.ErrClosed after Close, got %v", err)
+	}
+}
@@ -0,0 +1,427 @@
+package stats
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"time"
+)
+
+// defaultAlertInterval is how often the alert rules are evaluated when the
+// config does not say
+const defaultAlertInterval = 30 * time.Second
+
+// AlertState is the state of an alert
+type AlertState string
+
+const (
+	// AlertPending means the condition of the rule holds, but not yet for
+	// the duration of the rule
+	AlertPending AlertState = "pending"
+	// AlertFiring means the condition of the rule has held for the duration
+	// of the rule; its webhook and action were called
+	AlertFiring AlertState = "firing"
+	// AlertResolved means the condition of a firing rule no longer holds
+	AlertResolved AlertState = "resolved"
+)
+
+// AlertRule is a condition on the stats that raises an alert, like
+// "disk > 90" or "process < 1". The metrics are:
+//
+//   - cpu: CPU usage in percent
+//   - memory: memory in use in percent
+//   - disk: used space in percent of the disk mounted at Path, "/" by default
+//   - load1, load5, load15: load averages
+//   - uptime: seconds since the host booted, like "uptime < 600" to be
+//     alerted of a reboot
+//   - process: number of running processes named Process; processes using
+//     hardly any CPU and less than 1MB are not counted
+//
+// and those added with RegisterAlertMetric.
+type AlertRule struct {
+	Name      string  `json:"name"`
+	Metric    string  `json:"metric"`
+	Path      string  `json:"path,omitempty"`
+	Process   string  `json:"process,omitempty"`
+	Operator  string  `json:"operator"`
+	Threshold float64 `json:"threshold"`
+	// For is how many seconds the condition must hold before the alert
+	// fires; 0 fires at the first evaluation
+	For int `json:"for,omitempty"`
+	// Webhook is posted the alert as JSON when it fires and when it is
+	// resolved. With a secret the body is signed in the X-Webhook-Signature
+	// header as sha256=<hex HMAC-SHA256>.
+	Webhook string `json:"webhook,omitempty"`
+	Secret  string `json:"secret,omitempty"`
+	// Action is a heroscript that is run when the alert fires, with the
+	// RunAlertAction of the config
+	Action string `json:"action,omitempty"`
+}
+
+// Alert is the state of an alert rule whose condition holds or held
+type Alert struct {
+	Rule       string     `json:"rule"`
+	State      AlertState `json:"state"`
+	Value      float64    `json:"value"`
+	Message    string     `json:"message"`
+	Since      time.Time  `json:"since"`
+	FiredAt    *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// AlertMetric returns the value of a metric for a rule
+type AlertMetric func(rule AlertRule) (float64, error)
+
+// builtinAlertMetrics are the metrics of the stats of this package
+var builtinAlertMetrics = []string{"cpu", "memory", "disk", "load1", "load5", "load15", "uptime", "process"}
+
+// alertOperators are the operators that compare a metric with a threshold
+var alertOperators = map[string]func(value, threshold float64) bool{
+	">":  func(value, threshold float64) bool { return value > threshold },
+	">=": func(value, threshold float64) bool { return value >= threshold },
+	"<":  func(value, threshold float64) bool { return value < threshold },
+	"<=": func(value, threshold float64) bool { return value <= threshold },
+}
+
+// alertClient calls the webhooks of alerts
+var alertClient = &http.Client{Timeout: 10 * time.Second}
+
+// LoadAlertRules reads alert rules from a JSON file with an array of rules
+func LoadAlertRules(path string) ([]AlertRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert rules: %w", err)
+	}
+
+	var rules []AlertRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse alert rules in %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// RegisterAlertMetric adds a metric that alert rules can use, like the
+// status of processes that are managed elsewhere
+func (sm *StatsManager) RegisterAlertMetric(name string, metric AlertMetric) error {
+	if slices.Contains(builtinAlertMetrics, name) {
+		return fmt.Errorf("alert metric %s is built in", name)
+	}
+
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.alertMetrics[name] = metric
+	return nil
+}
+
+// AddAlertRule adds a rule that is evaluated from now on, replacing the rule
+// with the same name
+func (sm *StatsManager) AddAlertRule(rule AlertRule) error {
+	if err := sm.checkAlertRule(rule); err != nil {
+		return err
+	}
+
+	sm.alertsMutex.Lock()
+	defer sm.alertsMutex.Unlock()
+	for i, existing := range sm.alertRules {
+		if existing.Name == rule.Name {
+			sm.alertRules[i] = rule
+			// The alert of the old condition does not apply to the new one
+			delete(sm.alerts, rule.Name)
+			return nil
+		}
+	}
+	sm.alertRules = append(sm.alertRules, rule)
+	return nil
+}
+
+// checkAlertRule returns an error if a rule cannot be evaluated
+func (sm *StatsManager) checkAlertRule(rule AlertRule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("alert rule has no name")
+	}
+	if _, ok := alertOperators[rule.Operator]; !ok {
+		return fmt.Errorf("alert rule %s: operator must be >, >=, < or <=", rule.Name)
+	}
+	if rule.For < 0 {
+		return fmt.Errorf("alert rule %s: for must not be negative", rule.Name)
+	}
+
+	sm.mu.Lock()
+	_, registered := sm.alertMetrics[rule.Metric]
+	sm.mu.Unlock()
+	if !registered && !slices.Contains(builtinAlertMetrics, rule.Metric) {
+		return fmt.Errorf("alert rule %s: unknown metric %s", rule.Name, rule.Metric)
+	}
+	if rule.Metric == "process" && rule.Process == "" {
+		return fmt.Errorf("alert rule %s: process is required for the process metric", rule.Name)
+	}
+
+	if rule.Webhook != "" {
+		u, err := url.Parse(rule.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("alert rule %s: webhook URL '%s' must be an http or https URL", rule.Name, rule.Webhook)
+		}
+	}
+	if rule.Action != "" && sm.runAlertAction == nil {
+		return fmt.Errorf("alert rule %s: actions are not supported without RunAlertAction", rule.Name)
+	}
+	return nil
+}
+
+// RemoveAlertRule removes a rule and its alert
+func (sm *StatsManager) RemoveAlertRule(name string) error {
+	sm.alertsMutex.Lock()
+	defer sm.alertsMutex.Unlock()
+	for i, rule := range sm.alertRules {
+		if rule.Name == name {
+			sm.alertRules = slices.Delete(sm.alertRules, i, i+1)
+			delete(sm.alerts, name)
+			return nil
+		}
+	}
+	return fmt.Errorf("alert rule %s not found", name)
+}
+
+// AlertRules returns the alert rules in the order they were added
+func (sm *StatsManager) AlertRules() []AlertRule {
+	sm.alertsMutex.Lock()
+	defer sm.alertsMutex.Unlock()
+	return slices.Clone(sm.alertRules)
+}
+
+// Alerts returns the pending, firing and resolved alerts, ordered by rule. A
+// resolved alert is kept until its condition holds again.
+func (sm *StatsManager) Alerts() []Alert {
+	sm.alertsMutex.Lock()
+	defer sm.alertsMutex.Unlock()
+	alerts := make([]Alert, 0, len(sm.alerts))
+	for _, alert := range sm.alerts {
+		alerts = append(alerts, *alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].Rule < alerts[j].Rule
+	})
+	return alerts
+}
+
+// alertWorker evaluates the alert rules every interval until the
+// StatsManager is closed
+func (sm *StatsManager) alertWorker(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sm.ctx.Done():
+			return
+		case <-ticker.C:
+			sm.EvaluateAlerts()
+		}
+	}
+}
+
+// EvaluateAlerts evaluates the alert rules on the cached stats now, which
+// the StatsManager also does every AlertInterval
+func (sm *StatsManager) EvaluateAlerts() {
+	for _, rule := range sm.AlertRules() {
+		value, err := sm.alertValue(rule)
+		if err != nil {
+			// An alert keeps its state while its metric is not available
+			sm.logger.Printf("Error evaluating alert rule %s: %v", rule.Name, err)
+			continue
+		}
+		sm.updateAlert(rule, value, time.Now())
+	}
+}
+
+// alertValue returns the value of the metric of a rule
+func (sm *StatsManager) alertValue(rule AlertRule) (float64, error) {
+	switch rule.Metric {
+	case "cpu", "memory":
+		sysInfo, err := sm.GetSystemInfo()
+		if err != nil {
+			return 0, err
+		}
+		if rule.Metric == "cpu" {
+			return sysInfo.CPU.UsagePercent, nil
+		}
+		return sysInfo.Memory.UsedPercent, nil
+	case "disk":
+		diskStats, err := sm.GetDiskStats()
+		if err != nil {
+			return 0, err
+		}
+		path := rule.Path
+		if path == "" {
+			path = "/"
+		}
+		for _, disk := range diskStats.Disks {
+			if disk.Path == path {
+				return disk.UsedPercent, nil
+			}
+		}
+		return 0, fmt.Errorf("no disk mounted at %s", path)
+	case "load1", "load5", "load15":
+		loadInfo, err := sm.GetLoadInfo()
+		if err != nil {
+			return 0, err
+		}
+		switch rule.Metric {
+		case "load1":
+			return loadInfo.Load1, nil
+		case "load5":
+			return loadInfo.Load5, nil
+		}
+		return loadInfo.Load15, nil
+	case "uptime":
+		sysInfo, err := sm.GetSystemInfo()
+		if err != nil {
+			return 0, err
+		}
+		// The uptime of the cached info is as old as the cache
+		if sysInfo.Host.BootTime == 0 {
+			return 0, fmt.Errorf("boot time is not known")
+		}
+		return float64(time.Now().Unix() - int64(sysInfo.Host.BootTime)), nil
+	case "process":
+		processStats, err := sm.GetProcessStats(0)
+		if err != nil {
+			return 0, err
+		}
+		running := 0
+		for _, process := range processStats.Processes {
+			if process.Name == rule.Process {
+				running++
+			}
+		}
+		return float64(running), nil
+	}
+
+	sm.mu.Lock()
+	metric, ok := sm.alertMetrics[rule.Metric]
+	sm.mu.Unlock()
+	if !ok {
+		return 0, fmt.Errorf("unknown metric %s", rule.Metric)
+	}
+	return metric(rule)
+}
+
+// updateAlert moves the alert of a rule to its next state for the value of
+// its metric, and calls its webhook and action when it fires or is resolved
+func (sm *StatsManager) updateAlert(rule AlertRule, value float64, now time.Time) {
+	holds := alertOperators[rule.Operator](value, rule.Threshold)
+
+	sm.alertsMutex.Lock()
+	// The rule may have been removed or replaced while it was evaluated
+	i := slices.IndexFunc(sm.alertRules, func(r AlertRule) bool { return r.Name == rule.Name })
+	if i < 0 || sm.alertRules[i] != rule {
+		sm.alertsMutex.Unlock()
+		return
+	}
+
+	alert, ok := sm.alerts[rule.Name]
+	notify := false
+	switch {
+	case holds && (!ok || alert.State == AlertResolved):
+		alert = &Alert{
+			Rule:  rule.Name,
+			State: AlertPending,
+			Since: now,
+		}
+		sm.alerts[rule.Name] = alert
+		fallthrough
+	case holds && alert.State == AlertPending:
+		if now.Sub(alert.Since) >= time.Duration(rule.For)*time.Second {
+			alert.State = AlertFiring
+			alert.Since = now
+			alert.FiredAt = &now
+			notify = true
+		}
+	case !holds && ok && alert.State == AlertPending:
+		// The condition did not hold long enough
+		delete(sm.alerts, rule.Name)
+	case !holds && ok && alert.State == AlertFiring:
+		alert.State = AlertResolved
+		alert.Since = now
+		alert.ResolvedAt = &now
+		notify = true
+	}
+	if alert == nil || sm.alerts[rule.Name] != alert {
+		sm.alertsMutex.Unlock()
+		return
+	}
+	alert.Value = value
+	alert.Message = alertMessage(rule, value)
+	snapshot := *alert
+	sm.alertsMutex.Unlock()
+
+	if !notify {
+		return
+	}
+	sm.logger.Printf("Alert %s %s: %s", rule.Name, snapshot.State, snapshot.Message)
+	// Webhooks and actions are called in the background, so slow ones do
+	// not hold up the other rules
+	if rule.Webhook != "" {
+		go sm.callAlertWebhook(rule, snapshot)
+	}
+	if rule.Action != "" && snapshot.State == AlertFiring {
+		go func() {
+			if err := sm.runAlertAction(rule.Action); err != nil {
+				sm.logger.Printf("Error running action of alert %s: %v", rule.Name, err)
+			}
+		}()
+	}
+}
+
+// alertMessage describes the value of the metric of a rule and its
+// condition, like "disk / at 93.2 (> 90)"
+func alertMessage(rule AlertRule, value float64) string {
+	subject := rule.Metric
+	switch {
+	case rule.Metric == "disk" && rule.Path == "":
+		subject += " /"
+	case rule.Path != "":
+		subject += " " + rule.Path
+	case rule.Process != "":
+		subject += " " + rule.Process
+	}
+	return fmt.Sprintf("%s at %.1f (%s %g)", subject, value, rule.Operator, rule.Threshold)
+}
+
+// callAlertWebhook posts an alert to the webhook of its rule
+func (sm *StatsManager) callAlertWebhook(rule AlertRule, alert Alert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		sm.logger.Printf("Error marshaling alert %s: %v", rule.Name, err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, rule.Webhook, bytes.NewReader(body))
+	if err != nil {
+		sm.logger.Printf("Error creating webhook request for alert %s: %v", rule.Name, err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Alert-State", string(alert.State))
+	if rule.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(rule.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := alertClient.Do(req)
+	if err != nil {
+		sm.logger.Printf("Error calling webhook of alert %s: %v", rule.Name, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		sm.logger.Printf("Error calling webhook of alert %s: %s", rule.Name, resp.Status)
+	}
+}
@@ -0,0 +1,313 @@
+package stats
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newAlertTestManager returns a stats manager without Redis that fetches
+// the system, disk and process stats from fixed sources
+func newAlertTestManager() *StatsManager {
+	sm := newTestStatsManager(nil, "system", "disk", "process")
+	sm.Debug = true
+	sm.alerts = make(map[string]*Alert)
+	sm.alertMetrics = make(map[string]AlertMetric)
+	sm.collectors["system"].fetch = func() (interface{}, error) {
+		return &SystemInfo{CPU: CPUInfo{UsagePercent: 75}, Memory: MemoryInfo{UsedPercent: 40}}, nil
+	}
+	sm.collectors["disk"].fetch = func() (interface{}, error) {
+		return &DiskStats{Disks: []DiskInfo{{Path: "/", UsedPercent: 50}, {Path: "/data", UsedPercent: 93.25}}}, nil
+	}
+	sm.collectors["process"].fetch = func() (interface{}, error) {
+		return &ProcessStats{Processes: []ProcessInfo{{Name: "nginx"}, {Name: "nginx"}, {Name: "redis-server"}}}, nil
+	}
+	return sm
+}
+
+func TestAlertStates(t *testing.T) {
+	sm := newAlertTestManager()
+	rule := AlertRule{Name: "cpu", Metric: "cpu", Operator: ">", Threshold: 90, For: 60}
+	if err := sm.AddAlertRule(rule); err != nil {
+		t.Fatalf("Failed to add rule: %v", err)
+	}
+
+	start := time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		after time.Duration
+		value float64
+		// state is the state of the alert afterwards, "" for none
+		state AlertState
+		since time.Duration
+	}{
+		{0, 95, AlertPending, 0},
+		// The condition did not hold for long enough
+		{30 * time.Second, 80, "", 0},
+		{40 * time.Second, 95, AlertPending, 40 * time.Second},
+		{70 * time.Second, 95, AlertPending, 40 * time.Second},
+		{100 * time.Second, 96, AlertFiring, 100 * time.Second},
+		{130 * time.Second, 97, AlertFiring, 100 * time.Second},
+		{160 * time.Second, 50, AlertResolved, 160 * time.Second},
+		{190 * time.Second, 50, AlertResolved, 160 * time.Second},
+		// A resolved alert starts over when the condition holds again
+		{200 * time.Second, 95, AlertPending, 200 * time.Second},
+	}
+	for _, test := range tests {
+		sm.updateAlert(rule, test.value, start.Add(test.after))
+		alerts := sm.Alerts()
+		if test.state == "" {
+			if len(alerts) != 0 {
+				t.Errorf("after %v: expected no alert, got %+v", test.after, alerts)
+			}
+			continue
+		}
+		if len(alerts) != 1 {
+			t.Errorf("after %v: expected one alert, got %+v", test.after, alerts)
+			continue
+		}
+		alert := alerts[0]
+		if alert.State != test.state || !alert.Since.Equal(start.Add(test.since)) || alert.Value != test.value {
+			t.Errorf("after %v: expected %s since %v at %v, got %s since %v at %v", test.after, test.state,
+				test.since, test.value, alert.State, alert.Since.Sub(start), alert.Value)
+		}
+	}
+
+	alert := sm.Alerts()[0]
+	if alert.FiredAt != nil || alert.ResolvedAt != nil {
+		t.Errorf("Expected a new alert not to have fired, got %+v", alert)
+	}
+
+	// Without a duration an alert fires at once
+	now := sm.Alerts()[0].Since
+	instant := AlertRule{Name: "memory", Metric: "memory", Operator: ">=", Threshold: 90}
+	sm.AddAlertRule(instant)
+	sm.updateAlert(instant, 90, now)
+	alert = sm.Alerts()[1]
+	if alert.State != AlertFiring || alert.FiredAt == nil || !alert.FiredAt.Equal(now) {
+		t.Errorf("Expected the alert to fire at once, got %+v", alert)
+	}
+	sm.updateAlert(instant, 80, now.Add(time.Second))
+	alert = sm.Alerts()[1]
+	if alert.State != AlertResolved || !alert.FiredAt.Equal(now) || !alert.ResolvedAt.Equal(now.Add(time.Second)) {
+		t.Errorf("Expected the alert to be resolved, got %+v", alert)
+	}
+}
+
+func TestAlertNotifications(t *testing.T) {
+	type call struct {
+		state string
+		alert Alert
+	}
+	calls := make(chan call, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(body)
+		if r.Header.Get("X-Webhook-Signature") != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("Expected a valid signature, got %q", r.Header.Get("X-Webhook-Signature"))
+		}
+		var alert Alert
+		json.Unmarshal(body, &alert)
+		calls <- call{r.Header.Get("X-Alert-State"), alert}
+	}))
+	defer server.Close()
+
+	sm := newAlertTestManager()
+	actions := make(chan string, 10)
+	sm.runAlertAction = func(script string) error {
+		actions <- script
+		return nil
+	}
+	rule := AlertRule{Name: "disk", Metric: "disk", Path: "/data", Operator: ">", Threshold: 90,
+		Webhook: server.URL, Secret: "secret", Action: "!!process.restart name:'cleanup'"}
+	if err := sm.AddAlertRule(rule); err != nil {
+		t.Fatalf("Failed to add rule: %v", err)
+	}
+
+	now := time.Now()
+	sm.updateAlert(rule, 93.25, now)
+	select {
+	case c := <-calls:
+		if c.state != "firing" || c.alert.Rule != "disk" || c.alert.Message != "disk /data at 93.2 (> 90)" {
+			t.Errorf("Expected the webhook of the firing alert, got %+v", c)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the webhook to be called when the alert fires")
+	}
+	select {
+	case script := <-actions:
+		if script != rule.Action {
+			t.Errorf("Expected the action of the rule, got %q", script)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the action to run when the alert fires")
+	}
+
+	// An alert that keeps firing is not notified again, a resolved one is
+	// notified without its action
+	sm.updateAlert(rule, 95, now.Add(time.Second))
+	sm.updateAlert(rule, 50, now.Add(2*time.Second))
+	select {
+	case c := <-calls:
+		if c.state != "resolved" || c.alert.ResolvedAt == nil {
+			t.Errorf("Expected the webhook of the resolved alert, got %+v", c)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the webhook to be called when the alert is resolved")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(calls) != 0 || len(actions) != 0 {
+		t.Errorf("Expected no more notifications, got %d webhooks and %d actions", len(calls), len(actions))
+	}
+}
+
+func TestAlertRules(t *testing.T) {
+	sm := newAlertTestManager()
+	sm.RegisterAlertMetric("queue", func(rule AlertRule) (float64, error) { return 0, nil })
+
+	tests := []struct {
+		rule  AlertRule
+		error string
+	}{
+		{AlertRule{Metric: "cpu", Operator: ">"}, "has no name"},
+		{AlertRule{Name: "a", Metric: "cpu", Operator: "=="}, "operator must be"},
+		{AlertRule{Name: "a", Metric: "cpu", Operator: ">", For: -1}, "for must not be negative"},
+		{AlertRule{Name: "a", Metric: "temperature", Operator: ">"}, "unknown metric temperature"},
+		{AlertRule{Name: "a", Metric: "process", Operator: "<"}, "process is required"},
+		{AlertRule{Name: "a", Metric: "cpu", Operator: ">", Webhook: "ftp://example.com"}, "must be an http or https URL"},
+		{AlertRule{Name: "a", Metric: "cpu", Operator: ">", Webhook: "http://"}, "must be an http or https URL"},
+		{AlertRule{Name: "a", Metric: "cpu", Operator: ">", Action: "!!process.list"}, "without RunAlertAction"},
+		{AlertRule{Name: "a", Metric: "queue", Operator: ">", Webhook: "https://example.com/hook"}, ""},
+		{AlertRule{Name: "a", Metric: "process", Process: "nginx", Operator: "<"}, ""},
+	}
+	for _, test := range tests {
+		err := sm.AddAlertRule(test.rule)
+		if test.error == "" && err != nil {
+			t.Errorf("%+v: expected the rule to be added, got %v", test.rule, err)
+		} else if test.error != "" && (err == nil || !strings.Contains(err.Error(), test.error)) {
+			t.Errorf("%+v: expected an error with %q, got %v", test.rule, test.error, err)
+		}
+	}
+	if err := sm.RegisterAlertMetric("cpu", nil); err == nil {
+		t.Error("Expected an error registering a built-in metric")
+	}
+
+	// A rule with the same name replaces the rule and its alert
+	cpu := AlertRule{Name: "cpu", Metric: "cpu", Operator: ">", Threshold: 50}
+	sm.AddAlertRule(cpu)
+	sm.updateAlert(cpu, 75, time.Now())
+	replaced := AlertRule{Name: "cpu", Metric: "cpu", Operator: ">", Threshold: 80}
+	sm.AddAlertRule(replaced)
+	if rules := sm.AlertRules(); len(rules) != 2 || rules[1] != replaced {
+		t.Errorf("Expected the rule to be replaced, got %+v", rules)
+	}
+	if alerts := sm.Alerts(); len(alerts) != 0 {
+		t.Errorf("Expected the alert of the old rule to be gone, got %+v", alerts)
+	}
+	// Evaluating the old rule, which was replaced meanwhile, has no effect
+	sm.updateAlert(cpu, 75, time.Now())
+	if alerts := sm.Alerts(); len(alerts) != 0 {
+		t.Errorf("Expected no alert for the old rule, got %+v", alerts)
+	}
+
+	sm.updateAlert(replaced, 90, time.Now())
+	if err := sm.RemoveAlertRule("cpu"); err != nil {
+		t.Fatalf("Failed to remove rule: %v", err)
+	}
+	if len(sm.AlertRules()) != 1 || len(sm.Alerts()) != 0 {
+		t.Errorf("Expected the rule and its alert to be removed, got %+v and %+v", sm.AlertRules(), sm.Alerts())
+	}
+	if err := sm.RemoveAlertRule("cpu"); err == nil {
+		t.Error("Expected an error removing an unknown rule")
+	}
+}
+
+func TestEvaluateAlerts(t *testing.T) {
+	sm := newAlertTestManager()
+	queue := 12.0
+	sm.RegisterAlertMetric("queue", func(rule AlertRule) (float64, error) { return queue, nil })
+	rules := []AlertRule{
+		{Name: "cpu", Metric: "cpu", Operator: ">", Threshold: 70},
+		{Name: "memory", Metric: "memory", Operator: ">", Threshold: 70},
+		{Name: "data", Metric: "disk", Path: "/data", Operator: ">", Threshold: 90},
+		{Name: "missing disk", Metric: "disk", Path: "/missing", Operator: ">=", Threshold: 0},
+		{Name: "nginx", Metric: "process", Process: "nginx", Operator: "<", Threshold: 3},
+		{Name: "redis", Metric: "process", Process: "redis-server", Operator: "<", Threshold: 1},
+		{Name: "queue", Metric: "queue", Operator: ">", Threshold: 10},
+	}
+	for _, rule := range rules {
+		if err := sm.AddAlertRule(rule); err != nil {
+			t.Fatalf("Failed to add rule %s: %v", rule.Name, err)
+		}
+	}
+
+	sm.EvaluateAlerts()
+	var got []string
+	for _, alert := range sm.Alerts() {
+		got = append(got, alert.Rule+": "+alert.Message)
+	}
+	want := []string{
+		"cpu: cpu at 75.0 (> 70)",
+		"data: disk /data at 93.2 (> 90)",
+		"nginx: process nginx at 2.0 (< 3)",
+		"queue: queue at 12.0 (> 10)",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected alerts:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+
+	queue = 5
+	sm.EvaluateAlerts()
+	for _, alert := range sm.Alerts() {
+		if (alert.Rule == "queue") != (alert.State == AlertResolved) {
+			t.Errorf("Expected only the queue alert to be resolved, got %s %s", alert.Rule, alert.State)
+		}
+	}
+}
+
+func TestAlertMessage(t *testing.T) {
+	tests := []struct {
+		rule  AlertRule
+		value float64
+		want  string
+	}{
+		{AlertRule{Metric: "cpu", Operator: ">", Threshold: 90}, 93.25, "cpu at 93.2 (> 90)"},
+		{AlertRule{Metric: "disk", Operator: ">=", Threshold: 90.5}, 91, "disk / at 91.0 (>= 90.5)"},
+		{AlertRule{Metric: "disk", Path: "/data", Operator: ">", Threshold: 90}, 95, "disk /data at 95.0 (> 90)"},
+		{AlertRule{Metric: "process", Process: "nginx", Operator: "<", Threshold: 1}, 0, "process nginx at 0.0 (< 1)"},
+	}
+	for _, test := range tests {
+		if got := alertMessage(test.rule, test.value); got != test.want {
+			t.Errorf("Expected %q, got %q", test.want, got)
+		}
+	}
+}
+
+func TestLoadAlertRules(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "alerts.json")
+	os.WriteFile(path, []byte(`[{"name": "disk", "metric": "disk", "operator": ">", "threshold": 90, "for": 300}]`), 0644)
+	rules, err := LoadAlertRules(path)
+	want := AlertRule{Name: "disk", Metric: "disk", Operator: ">", Threshold: 90, For: 300}
+	if err != nil || len(rules) != 1 || rules[0] != want {
+		t.Errorf("Expected %+v, got %+v, %v", want, rules, err)
+	}
+
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`{"name": "disk"}`), 0644)
+	if _, err := LoadAlertRules(invalid); err == nil || !strings.Contains(err.Error(), "failed to parse alert rules") {
+		t.Errorf("Expected a parse error, got %v", err)
+	}
+	if _, err := LoadAlertRules(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
@@ -29,6 +29,7 @@ func main() {
 			"disk":     300 * time.Second, // Disk info expires after 5 minutes
 			"process":  60 * time.Second,  // Process info expires after 1 minute
 			"network":  30 * time.Second,  // Network info expires after 30 seconds
+			"gpu":      30 * time.Second,  // GPU info expires after 30 seconds
 			"hardware": 120 * time.Second, // Hardware stats expire after 2 minutes
 		},
 	}
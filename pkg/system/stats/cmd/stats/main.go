@@ -0,0 +1,170 @@
+// Command stats shows the stats of the system from the Redis cache of the
+// StatsManager, which HeroLauncher keeps filled, in the terminal.
+//
+// Usage:
+//
+//	stats top [-redis localhost:6379] [-interval 3s] [-n 15]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/system/stats"
+)
+
+const (
+	// barWidth is the number of characters of the usage bars
+	barWidth = 30
+	// maxInterfaces is the number of network interfaces that are shown
+	maxInterfaces = 5
+
+	clearScreen = "\033[H\033[2J"
+	hideCursor  = "\033[?25l"
+	showCursor  = "\033[?25h"
+)
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "top" {
+		fmt.Fprintln(os.Stderr, "Usage: stats top [-redis addr] [-interval duration] [-n processes]")
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("top", flag.ExitOnError)
+	redisAddr := flags.String("redis", "localhost:6379", "address of the Redis server with the stats cache")
+	interval := flags.Duration("interval", 3*time.Second, "how often the stats are refreshed")
+	count := flags.Int("n", 15, "number of processes to show")
+	flags.Parse(os.Args[2:])
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := top(ctx, *redisAddr, *interval, *count); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// top redraws the stats every interval until ctx is done
+func top(ctx context.Context, redisAddr string, interval time.Duration, count int) error {
+	// Only the stats that are shown are collected, on the interval, so they
+	// are fresh even without HeroLauncher filling the cache
+	config := stats.DefaultConfig()
+	config.RedisAddr = redisAddr
+	config.Logger = log.New(io.Discard, "", 0)
+	config.Collectors = map[string]stats.CollectorConfig{}
+	for _, statsType := range []string{"system", "process", "network", "load"} {
+		config.Collectors[statsType] = stats.CollectorConfig{Expiration: interval, Interval: interval}
+	}
+	for _, statsType := range []string{"disk", "root_disk", "hardware", "gpu", "ports"} {
+		config.Collectors[statsType] = stats.CollectorConfig{Disabled: true}
+	}
+
+	manager, err := stats.NewStatsManager(config)
+	if err != nil {
+		return err
+	}
+	defer manager.Close()
+
+	fmt.Print(hideCursor)
+	defer fmt.Print(showCursor)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		var screen strings.Builder
+		render(&screen, manager, interval, count)
+		fmt.Print(clearScreen + screen.String())
+
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// source is the part of the StatsManager that top shows
+type source interface {
+	GetLoadInfo() (*stats.LoadInfo, error)
+	GetSystemInfo() (*stats.SystemInfo, error)
+	GetNetworkStats() (*stats.NetworkStats, error)
+	GetProcessStats(limit int) (*stats.ProcessStats, error)
+}
+
+// render writes a screen of stats, with the errors of the stats that are not
+// available in their place
+func render(w io.Writer, manager source, interval time.Duration, count int) {
+	hostname, _ := os.Hostname()
+	fmt.Fprintf(w, "%s  %s  (every %v, Ctrl-C to quit)\n", hostname, time.Now().Format("2006-01-02 15:04:05"), interval)
+	if load, err := manager.GetLoadInfo(); err == nil {
+		fmt.Fprintf(w, "Load: %.2f %.2f %.2f\n", load.Load1, load.Load5, load.Load15)
+	}
+	fmt.Fprintln(w)
+
+	if info, err := manager.GetSystemInfo(); err != nil {
+		fmt.Fprintf(w, "System: %v\n", err)
+	} else {
+		fmt.Fprintf(w, "CPU     %s %5.1f%%  %d cores  %s\n", bar(info.CPU.UsagePercent), info.CPU.UsagePercent, info.CPU.Cores, info.CPU.ModelName)
+		memory := info.Memory
+		fmt.Fprintf(w, "Memory  %s %5.1f%%  %.1fGB/%.1fGB, %.1fGB available\n", bar(memory.UsedPercent), memory.UsedPercent, memory.Used, memory.Total, memory.Available)
+		if memory.Swap.Total > 0 {
+			fmt.Fprintf(w, "Swap    %s %5.1f%%  %.1fGB/%.1fGB\n", bar(memory.Swap.UsedPercent), memory.Swap.UsedPercent, memory.Swap.Used, memory.Swap.Total)
+		}
+	}
+	fmt.Fprintln(w)
+
+	if network, err := manager.GetNetworkStats(); err != nil {
+		fmt.Fprintf(w, "Network: %v\n", err)
+	} else {
+		fmt.Fprintf(w, "Network  up %s  down %s\n", network.UploadSpeed, network.DownloadSpeed)
+		interfaces := append([]stats.InterfaceStats{}, network.Interfaces...)
+		sort.Slice(interfaces, func(i, j int) bool {
+			return interfaces[i].UploadMbps+interfaces[i].DownloadMbps > interfaces[j].UploadMbps+interfaces[j].DownloadMbps
+		})
+		for i, iface := range interfaces {
+			if i == maxInterfaces {
+				break
+			}
+			fmt.Fprintf(w, "  %-12s up %10.3f Mbps  down %10.3f Mbps\n", truncate(iface.Name, 12), iface.UploadMbps, iface.DownloadMbps)
+		}
+	}
+	fmt.Fprintln(w)
+
+	processes, err := manager.GetProcessStats(count)
+	if err != nil {
+		fmt.Fprintf(w, "Processes: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "Processes: %d\n", processes.Total)
+	fmt.Fprintf(w, "%8s  %-24s %6s %9s  %s\n", "PID", "NAME", "CPU%", "MEM MB", "STATUS")
+	for _, proc := range processes.Processes {
+		fmt.Fprintf(w, "%8d  %-24s %6.1f %9.1f  %s\n", proc.PID, truncate(proc.Name, 24), proc.CPUPercent, proc.MemoryMB, proc.Status)
+	}
+}
+
+// bar returns a bar that is filled for percent
+func bar(percent float64) string {
+	filled := int(percent / 100 * barWidth)
+	filled = max(0, min(filled, barWidth))
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", barWidth-filled) + "]"
+}
+
+// truncate shortens s to n characters
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "~"
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/system/stats"
+)
+
+// fakeSource returns fixed stats, or errors for the stats that are nil
+type fakeSource struct {
+	load      *stats.LoadInfo
+	system    *stats.SystemInfo
+	network   *stats.NetworkStats
+	processes *stats.ProcessStats
+}
+
+var errNotCached = errors.New("not in cache")
+
+func (s fakeSource) GetLoadInfo() (*stats.LoadInfo, error) {
+	if s.load == nil {
+		return nil, errNotCached
+	}
+	return s.load, nil
+}
+
+func (s fakeSource) GetSystemInfo() (*stats.SystemInfo, error) {
+	if s.system == nil {
+		return nil, errNotCached
+	}
+	return s.system, nil
+}
+
+func (s fakeSource) GetNetworkStats() (*stats.NetworkStats, error) {
+	if s.network == nil {
+		return nil, errNotCached
+	}
+	return s.network, nil
+}
+
+func (s fakeSource) GetProcessStats(limit int) (*stats.ProcessStats, error) {
+	if s.processes == nil {
+		return nil, errNotCached
+	}
+	return s.processes, nil
+}
+
+func TestRender(t *testing.T) {
+	network := &stats.NetworkStats{UploadSpeed: "1.5 Mbps", DownloadSpeed: "512.0 Kbps"}
+	// Only the 5 busiest interfaces are shown
+	for i := 0; i < 6; i++ {
+		network.Interfaces = append(network.Interfaces, stats.InterfaceStats{Name: fmt.Sprintf("eth%d", i), UploadMbps: float64(i)})
+	}
+	network.Interfaces[5].Name = "a-very-long-interface"
+	source := fakeSource{
+		load: &stats.LoadInfo{Load1: 0.5, Load5: 0.25, Load15: 1},
+		system: &stats.SystemInfo{
+			CPU:    stats.CPUInfo{Cores: 8, ModelName: "AMD Ryzen 7", UsagePercent: 50},
+			Memory: stats.MemoryInfo{Total: 16, Used: 4, UsedPercent: 25, Available: 11.5, Swap: stats.SwapInfo{Total: 2, Used: 1, UsedPercent: 50}},
+		},
+		network: network,
+		processes: &stats.ProcessStats{Total: 321, Processes: []stats.ProcessInfo{
+			{PID: 42, Name: "herolauncher", CPUPercent: 12.5, MemoryMB: 80.25, Status: "running"},
+		}},
+	}
+
+	var screen strings.Builder
+	render(&screen, source, 3*time.Second, 15)
+	for _, want := range []string{
+		"(every 3s, Ctrl-C to quit)\nLoad: 0.50 0.25 1.00\n",
+		"CPU     [###############...............]  50.0%  8 cores  AMD Ryzen 7\n",
+		"Memory  [#######.......................]  25.0%  4.0GB/16.0GB, 11.5GB available\n",
+		"Swap    [###############...............]  50.0%  1.0GB/2.0GB\n",
+		"Network  up 1.5 Mbps  down 512.0 Kbps\n  a-very-long~ up      5.000 Mbps",
+		"Processes: 321\n",
+		"      42  herolauncher               12.5      80.2  running\n",
+	} {
+		if !strings.Contains(screen.String(), want) {
+			t.Errorf("Expected the screen to contain %q, got:\n%s", want, screen.String())
+		}
+	}
+	if strings.Contains(screen.String(), "eth0 ") {
+		t.Errorf("Expected the idle interface to be left out, got:\n%s", screen.String())
+	}
+
+	// Stats that are not available are shown with their error
+	screen.Reset()
+	render(&screen, fakeSource{}, time.Second, 15)
+	for _, want := range []string{"System: not in cache\n", "Network: not in cache\n", "Processes: not in cache\n"} {
+		if !strings.Contains(screen.String(), want) {
+			t.Errorf("Expected the screen to contain %q, got:\n%s", want, screen.String())
+		}
+	}
+	if strings.Contains(screen.String(), "Load:") || strings.Contains(screen.String(), "Swap") {
+		t.Errorf("Expected no load or swap, got:\n%s", screen.String())
+	}
+}
+
+func TestBar(t *testing.T) {
+	tests := []struct {
+		percent float64
+		filled  int
+	}{
+		{0, 0},
+		{50, 15},
+		{99, 29},
+		{100, 30},
+		{150, 30},
+		{-10, 0},
+	}
+	for _, test := range tests {
+		want := "[" + strings.Repeat("#", test.filled) + strings.Repeat(".", barWidth-test.filled) + "]"
+		if got := bar(test.percent); got != want {
+			t.Errorf("%v%%: expected %s, got %s", test.percent, want, got)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"nginx", 12, "nginx"},
+		{"exactly-12ch", 12, "exactly-12ch"},
+		{"systemd-resolved", 12, "systemd-res~"},
+		{"überlangername", 6, "überl~"},
+	}
+	for _, test := range tests {
+		if got := truncate(test.s, test.n); got != test.want {
+			t.Errorf("%q: expected %q, got %q", test.s, test.want, got)
+		}
+	}
+}
@@ -26,6 +26,7 @@ func main() {
 			"disk":     60 * time.Second,  // Disk info expires after 1 minute
 			"process":  15 * time.Second,  // Process info expires after 15 seconds
 			"network":  20 * time.Second,  // Network info expires after 20 seconds
+			"gpu":      20 * time.Second,  // GPU info expires after 20 seconds
 			"hardware": 60 * time.Second,  // Hardware stats expire after 1 minute
 		},
 	}
@@ -0,0 +1,284 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/heroscript/playbook"
+)
+
+// scheduleTick is how often the schedule checks which collectors are due
+const scheduleTick = time.Second
+
+// CollectorConfig configures the collector of a stats type. The zero value
+// is an enabled collector with the default expiration and no schedule.
+type CollectorConfig struct {
+	// Disabled collectors are not run and their stats cannot be read
+	Disabled bool
+	// Expiration is how long the stats are cached. If it is 0, built-in
+	// types use the ExpirationTimes of the Config.
+	Expiration time.Duration
+	// Interval fetches the stats in the background every interval, so they
+	// stay fresh without being read. Without it, stats are only fetched
+	// again when they are read after they expired.
+	Interval time.Duration
+}
+
+// CollectorInfo describes a collector, with its expiration and interval in
+// seconds
+type CollectorInfo struct {
+	Name       string `json:"name"`
+	Builtin    bool   `json:"builtin"`
+	Enabled    bool   `json:"enabled"`
+	Expiration int    `json:"expiration"`
+	Interval   int    `json:"interval"`
+}
+
+// collector is the Source of a stats type with its config
+type collector struct {
+	fetch   Source
+	builtin bool
+	config  CollectorConfig
+	// scheduled is when the stats were last queued by the schedule
+	scheduled time.Time
+}
+
+// builtinCollectors are the sources of the stats types of this package, in
+// the order in which they are first fetched
+var builtinCollectors = []struct {
+	statsType string
+	fetch     Source
+}{
+	{"system", func() (interface{}, error) { return GetSystemInfo() }},
+	{"disk", func() (interface{}, error) { return GetDiskStats() }},
+	{"process", func() (interface{}, error) { return GetProcessStats(0) }}, // Get all processes
+	{"root_disk", func() (interface{}, error) { return GetRootDiskInfo() }},
+	{"network", func() (interface{}, error) { return GetNetworkStats() }},
+	{"hardware", func() (interface{}, error) { return GetHardwareStatsJSON(), nil }},
+	{"gpu", func() (interface{}, error) { return GetGPUStats() }},
+	{"load", func() (interface{}, error) { return GetLoadInfo() }},
+	{"ports", func() (interface{}, error) { return GetPortStats() }},
+}
+
+// registerBuiltinCollectors adds the collectors of this package with their
+// config
+func (sm *StatsManager) registerBuiltinCollectors() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	for _, builtin := range builtinCollectors {
+		sm.collectors[builtin.statsType] = &collector{
+			fetch:   builtin.fetch,
+			builtin: true,
+			config:  sm.collectorConfigs[builtin.statsType],
+		}
+		sm.collectorOrder = append(sm.collectorOrder, builtin.statsType)
+	}
+}
+
+// RegisterCollector adds a stats type whose stats are fetched from src and
+// cached like the built-in types, and read with GetStats and GetAllStats.
+// The Collectors of the Config of the StatsManager override the config, so
+// collectors of other packages can also be disabled or scheduled there.
+func (sm *StatsManager) RegisterCollector(statsType string, src Source, config CollectorConfig) error {
+	sm.mu.Lock()
+	if c, ok := sm.collectors[statsType]; ok && c.builtin {
+		sm.mu.Unlock()
+		return fmt.Errorf("stats type %s is built in", statsType)
+	}
+	if override, ok := sm.collectorConfigs[statsType]; ok {
+		config = mergeCollectorConfig(config, override)
+	}
+	if _, ok := sm.collectors[statsType]; !ok {
+		sm.collectorOrder = append(sm.collectorOrder, statsType)
+	}
+	sm.collectors[statsType] = &collector{fetch: src, config: config}
+	sm.mu.Unlock()
+
+	// Fill the cache like for the built-in types
+	if !config.Disabled {
+		sm.queueUpdate(statsType)
+	}
+	return nil
+}
+
+// mergeCollectorConfig returns config with the settings of override, where
+// a zero expiration or interval keeps those of config
+func mergeCollectorConfig(config, override CollectorConfig) CollectorConfig {
+	config.Disabled = override.Disabled
+	if override.Expiration > 0 {
+		config.Expiration = override.Expiration
+	}
+	if override.Interval > 0 {
+		config.Interval = override.Interval
+	}
+	return config
+}
+
+// ConfigureCollector changes the config of the collector of a stats type,
+// like to enable or disable it
+func (sm *StatsManager) ConfigureCollector(statsType string, config CollectorConfig) error {
+	sm.mu.Lock()
+	c, ok := sm.collectors[statsType]
+	if !ok {
+		sm.mu.Unlock()
+		return fmt.Errorf("unknown stats type: %s", statsType)
+	}
+	wasDisabled := c.config.Disabled
+	c.config = config
+	sm.mu.Unlock()
+
+	// A collector that is enabled again fills the cache as at startup
+	if wasDisabled && !config.Disabled {
+		sm.queueUpdate(statsType)
+	}
+	return nil
+}
+
+// Collectors returns the collectors of the built-in and registered stats
+// types
+func (sm *StatsManager) Collectors() []CollectorInfo {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	infos := make([]CollectorInfo, 0, len(sm.collectorOrder))
+	for _, statsType := range sm.collectorOrder {
+		c := sm.collectors[statsType]
+		expiration := c.config.Expiration
+		if expiration <= 0 {
+			expiration = sm.Expiration[statsType]
+		}
+		infos = append(infos, CollectorInfo{
+			Name:       statsType,
+			Builtin:    c.builtin,
+			Enabled:    !c.config.Disabled,
+			Expiration: int(expiration.Seconds()),
+			Interval:   int(c.config.Interval.Seconds()),
+		})
+	}
+	return infos
+}
+
+// GetAllStats gets the stats of all enabled collectors with caching, as
+// their JSON by stats type. Stats that cannot be fetched are left out.
+func (sm *StatsManager) GetAllStats() map[string]json.RawMessage {
+	all := make(map[string]json.RawMessage)
+	for _, statsType := range sm.enabledStatsTypes() {
+		var data json.RawMessage
+		if err := sm.getFromCache(statsType, &data); err != nil {
+			sm.logger.Printf("Error getting %s stats: %v", statsType, err)
+			continue
+		}
+		all[statsType] = data
+	}
+	return all
+}
+
+// enabledStatsTypes returns the stats types whose collectors are enabled
+func (sm *StatsManager) enabledStatsTypes() []string {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	var statsTypes []string
+	for _, statsType := range sm.collectorOrder {
+		if !sm.collectors[statsType].config.Disabled {
+			statsTypes = append(statsTypes, statsType)
+		}
+	}
+	return statsTypes
+}
+
+// queueUpdate queues a fetch of the stats of a type unless the queue is
+// full
+func (sm *StatsManager) queueUpdate(statsType string) {
+	select {
+	case sm.updateQueue <- statsType:
+	default:
+		sm.logger.Printf("Update queue full, skipping %s stats update", statsType)
+	}
+}
+
+// scheduleWorker is a background goroutine that queues the updates of the
+// collectors with an interval
+func (sm *StatsManager) scheduleWorker() {
+	ticker := time.NewTicker(scheduleTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sm.ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, statsType := range sm.dueStatsTypes(now) {
+				sm.queueUpdate(statsType)
+			}
+		}
+	}
+}
+
+// dueStatsTypes returns the stats types whose interval passed since they
+// were last scheduled, and marks them scheduled at now
+func (sm *StatsManager) dueStatsTypes(now time.Time) []string {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	var due []string
+	for _, statsType := range sm.collectorOrder {
+		c := sm.collectors[statsType]
+		if c.config.Disabled || c.config.Interval <= 0 || now.Sub(c.scheduled) < c.config.Interval {
+			continue
+		}
+		c.scheduled = now
+		due = append(due, statsType)
+	}
+	return due
+}
+
+// ParseCollectorConfig parses the config of collectors from stats.collector
+// heroscript actions, with the expiration and interval in seconds:
+//
+//	!!stats.collector name:'gpu' enabled:false
+//	!!stats.collector name:'ports' interval:10 expiration:30
+func ParseCollectorConfig(script string) (map[string]CollectorConfig, error) {
+	pb, err := playbook.NewFromText(script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse heroscript: %w", err)
+	}
+	return collectorConfig(pb)
+}
+
+// collectorConfig returns the config of collectors of the stats.collector
+// actions of a playbook
+func collectorConfig(pb *playbook.PlayBook) (map[string]CollectorConfig, error) {
+	actions, err := pb.FindActions(0, "stats", "collector", playbook.ActionTypeUnknown)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find actions: %w", err)
+	}
+
+	configs := make(map[string]CollectorConfig, len(actions))
+	for _, action := range actions {
+		name := action.Params.Get("name")
+		if name == "" {
+			return nil, fmt.Errorf("name parameter is required in %s", strings.TrimSpace(action.HeroScript()))
+		}
+		expiration, _ := action.Params.GetInt("expiration")
+		interval, _ := action.Params.GetInt("interval")
+		configs[name] = CollectorConfig{
+			Disabled:   !action.Params.GetBoolDefault("enabled", true),
+			Expiration: time.Duration(expiration) * time.Second,
+			Interval:   time.Duration(interval) * time.Second,
+		}
+	}
+	return configs, nil
+}
+
+// LoadCollectorConfig reads the config of collectors from a heroscript file
+// of stats.collector actions, which can include other files
+func LoadCollectorConfig(path string) (map[string]CollectorConfig, error) {
+	pb, err := playbook.NewFromFile(path, 10)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read collector config: %w", err)
+	}
+	configs, err := collectorConfig(pb)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse collector config in %s: %w", path, err)
+	}
+	return configs, nil
+}
@@ -0,0 +1,193 @@
+package stats
+
+import (
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeCollectorConfig(t *testing.T) {
+	config := CollectorConfig{Expiration: 30 * time.Second, Interval: 10 * time.Second}
+	tests := []struct {
+		name     string
+		config   CollectorConfig
+		override CollectorConfig
+		want     CollectorConfig
+	}{
+		{"empty override", config, CollectorConfig{}, config},
+		{"expiration", config, CollectorConfig{Expiration: time.Minute},
+			CollectorConfig{Expiration: time.Minute, Interval: 10 * time.Second}},
+		{"interval", config, CollectorConfig{Interval: 5 * time.Second},
+			CollectorConfig{Expiration: 30 * time.Second, Interval: 5 * time.Second}},
+		{"both", config, CollectorConfig{Expiration: time.Minute, Interval: 5 * time.Second},
+			CollectorConfig{Expiration: time.Minute, Interval: 5 * time.Second}},
+		{"disabled", config, CollectorConfig{Disabled: true},
+			CollectorConfig{Disabled: true, Expiration: 30 * time.Second, Interval: 10 * time.Second}},
+		// The override decides whether the collector is enabled
+		{"enabled", CollectorConfig{Disabled: true}, CollectorConfig{}, CollectorConfig{}},
+		{"negative durations", config, CollectorConfig{Expiration: -time.Second, Interval: -time.Second}, config},
+		{"empty config", CollectorConfig{}, CollectorConfig{Interval: 5 * time.Second},
+			CollectorConfig{Interval: 5 * time.Second}},
+	}
+	for _, test := range tests {
+		if got := mergeCollectorConfig(test.config, test.override); got != test.want {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.want, got)
+		}
+	}
+}
+
+// newTestStatsManager returns a stats manager without Redis or background
+// workers, with collectors of the stats types in order
+func newTestStatsManager(configs map[string]CollectorConfig, order ...string) *StatsManager {
+	sm := &StatsManager{
+		updateQueue:      make(chan string, 100),
+		logger:           log.New(io.Discard, "", 0),
+		collectors:       make(map[string]*collector),
+		collectorConfigs: make(map[string]CollectorConfig),
+	}
+	for _, statsType := range order {
+		sm.collectors[statsType] = &collector{config: configs[statsType]}
+		sm.collectorOrder = append(sm.collectorOrder, statsType)
+	}
+	return sm
+}
+
+func TestDueStatsTypes(t *testing.T) {
+	sm := newTestStatsManager(map[string]CollectorConfig{
+		"fast":     {Interval: time.Second},
+		"slow":     {Interval: 5 * time.Second},
+		"disabled": {Disabled: true, Interval: time.Second},
+		"lazy":     {Expiration: time.Second},
+	}, "fast", "slow", "disabled", "lazy")
+
+	start := time.Date(2024, 5, 15, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		after time.Duration
+		want  string
+	}{
+		// Collectors that were never scheduled are due at once
+		{0, "fast slow"},
+		{500 * time.Millisecond, ""},
+		{time.Second, "fast"},
+		{2 * time.Second, "fast"},
+		{4 * time.Second, "fast"},
+		// Intervals count from when the collector was scheduled
+		{5 * time.Second, "fast slow"},
+		{9 * time.Second, "fast"},
+		{10 * time.Second, "fast slow"},
+		// Ticks that come late still schedule a collector once
+		{30 * time.Second, "fast slow"},
+	}
+	for _, test := range tests {
+		if got := strings.Join(sm.dueStatsTypes(start.Add(test.after)), " "); got != test.want {
+			t.Errorf("after %v: expected %q, got %q", test.after, test.want, got)
+		}
+	}
+
+	// A collector that is enabled again is scheduled like before
+	if err := sm.ConfigureCollector("disabled", CollectorConfig{Interval: time.Second}); err != nil {
+		t.Fatalf("Failed to configure collector: %v", err)
+	}
+	if got := strings.Join(sm.dueStatsTypes(start.Add(31*time.Second)), " "); got != "fast disabled" {
+		t.Errorf("Expected the enabled collector to be due, got %q", got)
+	}
+	if err := sm.ConfigureCollector("fast", CollectorConfig{Disabled: true}); err != nil {
+		t.Fatalf("Failed to configure collector: %v", err)
+	}
+	if got := strings.Join(sm.dueStatsTypes(start.Add(40*time.Second)), " "); got != "slow disabled" {
+		t.Errorf("Expected the disabled collector not to be due, got %q", got)
+	}
+}
+
+func TestRegisterCollectorOverride(t *testing.T) {
+	source := func() (interface{}, error) { return nil, nil }
+	tests := []struct {
+		name     string
+		config   CollectorConfig
+		override *CollectorConfig
+		want     CollectorConfig
+		// queued is whether the cache is filled at once
+		queued bool
+	}{
+		{"no override", CollectorConfig{Interval: 10 * time.Second}, nil,
+			CollectorConfig{Interval: 10 * time.Second}, true},
+		{"override interval", CollectorConfig{Expiration: time.Minute, Interval: 10 * time.Second}, &CollectorConfig{Interval: 5 * time.Second},
+			CollectorConfig{Expiration: time.Minute, Interval: 5 * time.Second}, true},
+		{"disabled by override", CollectorConfig{Interval: 10 * time.Second}, &CollectorConfig{Disabled: true},
+			CollectorConfig{Disabled: true, Interval: 10 * time.Second}, false},
+		{"enabled by override", CollectorConfig{Disabled: true}, &CollectorConfig{},
+			CollectorConfig{}, true},
+		{"disabled", CollectorConfig{Disabled: true}, nil,
+			CollectorConfig{Disabled: true}, false},
+	}
+	for _, test := range tests {
+		sm := newTestStatsManager(nil)
+		if test.override != nil {
+			sm.collectorConfigs["custom"] = *test.override
+		}
+		if err := sm.RegisterCollector("custom", source, test.config); err != nil {
+			t.Fatalf("%s: failed to register collector: %v", test.name, err)
+		}
+		if got := sm.collectors["custom"].config; got != test.want {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.want, got)
+		}
+		if queued := len(sm.updateQueue) == 1; queued != test.queued {
+			t.Errorf("%s: expected queued %v, got %v", test.name, test.queued, queued)
+		}
+	}
+
+	// Built-in collectors cannot be replaced
+	sm := newTestStatsManager(nil)
+	sm.registerBuiltinCollectors()
+	if err := sm.RegisterCollector("gpu", source, CollectorConfig{}); err == nil || err.Error() != "stats type gpu is built in" {
+		t.Errorf("Expected built-in collectors to be kept, got %v", err)
+	}
+}
+
+func TestParseCollectorConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   map[string]CollectorConfig
+		err    string
+	}{
+		{"empty", "", map[string]CollectorConfig{}, ""},
+		{"disabled", "!!stats.collector name:'gpu' enabled:false",
+			map[string]CollectorConfig{"gpu": {Disabled: true}}, ""},
+		{"enabled", "!!stats.collector name:'gpu' enabled:true",
+			map[string]CollectorConfig{"gpu": {}}, ""},
+		{"durations in seconds", "!!stats.collector name:'ports' interval:10 expiration:30",
+			map[string]CollectorConfig{"ports": {Expiration: 30 * time.Second, Interval: 10 * time.Second}}, ""},
+		{"several collectors", "!!stats.collector name:'gpu' enabled:false\n!!stats.collector name:'ports' interval:10",
+			map[string]CollectorConfig{"gpu": {Disabled: true}, "ports": {Interval: 10 * time.Second}}, ""},
+		// The last action of a collector applies
+		{"repeated collector", "!!stats.collector name:'gpu' enabled:false\n!!stats.collector name:'gpu' interval:5",
+			map[string]CollectorConfig{"gpu": {Interval: 5 * time.Second}}, ""},
+		{"other actions", "!!stats.alert name:'cpu'\n!!stats.collector name:'gpu' enabled:false",
+			map[string]CollectorConfig{"gpu": {Disabled: true}}, ""},
+		{"no name", "!!stats.collector interval:10", nil, "name parameter is required in !!stats.collector"},
+	}
+	for _, test := range tests {
+		got, err := ParseCollectorConfig(test.script)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected an error with %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed to parse: %v", test.name, err)
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.want, got)
+		}
+		for name, want := range test.want {
+			if got[name] != want {
+				t.Errorf("%s: expected %s to be %+v, got %+v", test.name, name, want, got[name])
+			}
+		}
+	}
+}
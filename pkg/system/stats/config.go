@@ -1,6 +1,7 @@
 package stats
 
 import (
+	"log"
 	"time"
 )
 
@@ -22,6 +23,24 @@ type Config struct {
 	
 	// Maximum queue size for update requests
 	QueueSize int
+
+	// Logger for the operations of the StatsManager, which logs to stdout
+	// if it is nil
+	Logger *log.Logger
+
+	// Collectors configures the collectors by stats type, to disable them
+	// or fetch them on an interval. It overrides ExpirationTimes and also
+	// applies to the collectors registered by other packages.
+	Collectors map[string]CollectorConfig
+
+	// Alert rules that are evaluated on the cached stats, every
+	// AlertInterval (default 30 seconds)
+	AlertRules    []AlertRule
+	AlertInterval time.Duration
+
+	// RunAlertAction runs the heroscript action of an alert rule that fires.
+	// Rules with an action are refused without it.
+	RunAlertAction func(script string) error
 }
 
 // DefaultConfig returns the default configuration for StatsManager
@@ -35,10 +54,14 @@ func DefaultConfig() *Config {
 			"disk":     300 * time.Second, // Disk info expires after 5 minutes
 			"process":  30 * time.Second,  // Process info expires after 30 seconds
 			"network":  30 * time.Second,  // Network info expires after 30 seconds
+			"gpu":      30 * time.Second,  // GPU info expires after 30 seconds
+			"load":     30 * time.Second,  // Load averages expire after 30 seconds
+			"ports":    30 * time.Second,  // Listening ports expire after 30 seconds
 			"hardware": 120 * time.Second, // Hardware stats expire after 2 minutes
 		},
 		Debug:          false,
 		DefaultTimeout: 60 * time.Second, // 1 minute default timeout
 		QueueSize:      100,
+		AlertInterval:  defaultAlertInterval,
 	}
 }
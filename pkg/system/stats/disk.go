@@ -16,9 +16,11 @@ type DiskInfo struct {
 	UsedPercent float64 `json:"used_percent"`
 }
 
-// DiskStats contains information about all disks
+// DiskStats contains information about all disks, with the IO and SMART
+// health of their devices
 type DiskStats struct {
-	Disks []DiskInfo `json:"disks"`
+	Disks   []DiskInfo    `json:"disks"`
+	Devices []DeviceStats `json:"devices"`
 }
 
 // GetDiskStats returns information about all disks
@@ -52,6 +54,12 @@ func GetDiskStats() (*DiskStats, error) {
 		})
 	}
 
+	// The space of the disks is reported even if their IO cannot be read
+	stats.Devices, err = GetDiskDevices()
+	if err != nil {
+		stats.Devices = []DeviceStats{}
+	}
+
 	return stats, nil
 }
 
@@ -0,0 +1,195 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// diskSampleInterval is how long the IO counters of the disks are watched
+// to measure their throughput
+const diskSampleInterval = 500 * time.Millisecond
+
+// DeviceStats contains the IO of a disk device, with its counters since
+// boot, and its SMART health if smartctl can read it
+type DeviceStats struct {
+	Name       string       `json:"name"`
+	ReadCount  uint64       `json:"read_count"`
+	WriteCount uint64       `json:"write_count"`
+	ReadBytes  uint64       `json:"read_bytes"`
+	WriteBytes uint64       `json:"write_bytes"`
+	ReadIOPS   float64      `json:"read_iops"`
+	WriteIOPS  float64      `json:"write_iops"`
+	ReadMBps   float64      `json:"read_mbps"`
+	WriteMBps  float64      `json:"write_mbps"`
+	Health     *SMARTHealth `json:"health,omitempty"`
+}
+
+// SMARTHealth is a summary of the SMART data of a disk. Values the disk does
+// not report are 0.
+type SMARTHealth struct {
+	Model        string  `json:"model"`
+	Serial       string  `json:"serial"`
+	Passed       bool    `json:"passed"`
+	Temperature  float64 `json:"temperature_c"`
+	PowerOnHours uint64  `json:"power_on_hours"`
+	// ReallocatedSectors is the number of bad sectors that an ATA disk
+	// replaced by spare ones, which grows on a failing disk
+	ReallocatedSectors uint64 `json:"reallocated_sectors"`
+	// PercentageUsed is the estimated part of the life of an NVMe disk that
+	// is used, and MediaErrors the number of unrecovered data errors
+	PercentageUsed uint64 `json:"percentage_used"`
+	MediaErrors    uint64 `json:"media_errors"`
+}
+
+// GetDiskDevices returns the IO of the disk devices, measured over half a
+// second, with the SMART health of those that smartctl can read. Partitions,
+// loop and RAM devices are left out on Linux.
+func GetDiskDevices() ([]DeviceStats, error) {
+	countersStart, err := disk.IOCounters()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disk IO counters: %w", err)
+	}
+	start := time.Now()
+
+	// Wait a short time to measure the difference
+	time.Sleep(diskSampleInterval)
+
+	countersEnd, err := disk.IOCounters()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disk IO counters: %w", err)
+	}
+	seconds := time.Since(start).Seconds()
+
+	devices := make([]DeviceStats, 0, len(countersEnd))
+	for name, counters := range countersEnd {
+		if !isDiskDevice(name) {
+			continue
+		}
+		device := DeviceStats{
+			Name:       name,
+			ReadCount:  counters.ReadCount,
+			WriteCount: counters.WriteCount,
+			ReadBytes:  counters.ReadBytes,
+			WriteBytes: counters.WriteBytes,
+		}
+		if before, ok := countersStart[name]; ok {
+			device.ReadIOPS = rate(before.ReadCount, counters.ReadCount, seconds)
+			device.WriteIOPS = rate(before.WriteCount, counters.WriteCount, seconds)
+			device.ReadMBps = math.Round(rate(before.ReadBytes, counters.ReadBytes, seconds)/(1024*1024)*1000) / 1000
+			device.WriteMBps = math.Round(rate(before.WriteBytes, counters.WriteBytes, seconds)/(1024*1024)*1000) / 1000
+		}
+		device.Health = getSMARTHealth(name)
+		devices = append(devices, device)
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Name < devices[j].Name
+	})
+	return devices, nil
+}
+
+// isDiskDevice reports whether a device with IO counters is a disk. On
+// Linux these are the devices in /sys/block other than loop and RAM devices.
+func isDiskDevice(name string) bool {
+	if runtime.GOOS != "linux" {
+		return true
+	}
+	if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") || strings.HasPrefix(name, "zram") {
+		return false
+	}
+	_, err := os.Stat(filepath.Join("/sys/block", name))
+	return err == nil
+}
+
+// rate returns the rate per second, rounded to 1 decimal place, of a counter
+// that went from before to after in seconds. A counter that was reset has
+// no rate.
+func rate(before, after uint64, seconds float64) float64 {
+	if after < before || seconds <= 0 {
+		return 0
+	}
+	return math.Round(float64(after-before)/seconds*10) / 10
+}
+
+// getSMARTHealth returns the SMART health of a disk device as read by
+// smartctl, or nil if smartctl is not installed or cannot read it, which
+// usually needs root
+func getSMARTHealth(name string) *SMARTHealth {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	// smartctl sets bits of its exit status for a failing disk, with its
+	// report on the output
+	out, _ := runTool("smartctl", "-j", "-H", "-A", "-i", "/dev/"+name)
+	if len(out) == 0 {
+		return nil
+	}
+	health, err := parseSmartctl(out)
+	if err != nil {
+		return nil
+	}
+	return health
+}
+
+// parseSmartctl parses the JSON report of smartctl -j -H -A -i. A report
+// without a SMART status, because the device could not be read, is an
+// error.
+func parseSmartctl(data []byte) (*SMARTHealth, error) {
+	var report struct {
+		ModelName    string `json:"model_name"`
+		SerialNumber string `json:"serial_number"`
+		SmartStatus  *struct {
+			Passed bool `json:"passed"`
+		} `json:"smart_status"`
+		Temperature struct {
+			Current float64 `json:"current"`
+		} `json:"temperature"`
+		PowerOnTime struct {
+			Hours uint64 `json:"hours"`
+		} `json:"power_on_time"`
+		ATASmartAttributes struct {
+			Table []struct {
+				ID  int `json:"id"`
+				Raw struct {
+					Value uint64 `json:"value"`
+				} `json:"raw"`
+			} `json:"table"`
+		} `json:"ata_smart_attributes"`
+		NVMeHealth struct {
+			PercentageUsed uint64 `json:"percentage_used"`
+			MediaErrors    uint64 `json:"media_errors"`
+		} `json:"nvme_smart_health_information_log"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse smartctl output: %w", err)
+	}
+	if report.SmartStatus == nil {
+		return nil, fmt.Errorf("smartctl reported no SMART status")
+	}
+
+	health := &SMARTHealth{
+		Model:          report.ModelName,
+		Serial:         report.SerialNumber,
+		Passed:         report.SmartStatus.Passed,
+		Temperature:    report.Temperature.Current,
+		PowerOnHours:   report.PowerOnTime.Hours,
+		PercentageUsed: report.NVMeHealth.PercentageUsed,
+		MediaErrors:    report.NVMeHealth.MediaErrors,
+	}
+	// Attribute 5 is the reallocated sectors count
+	for _, attribute := range report.ATASmartAttributes.Table {
+		if attribute.ID == 5 {
+			health.ReallocatedSectors = attribute.Raw.Value
+		}
+	}
+	return health, nil
+}
@@ -0,0 +1,109 @@
+package stats
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseSmartctl(t *testing.T) {
+	tests := []struct {
+		name   string
+		report string
+		want   SMARTHealth
+		err    string
+	}{
+		{
+			"ata",
+			`{
+				"model_name": "Samsung SSD 870 EVO 1TB",
+				"serial_number": "S6PTNM0T123456",
+				"smart_status": {"passed": true},
+				"temperature": {"current": 34},
+				"power_on_time": {"hours": 8123},
+				"ata_smart_attributes": {"table": [
+					{"id": 5, "name": "Reallocated_Sector_Ct", "raw": {"value": 3}},
+					{"id": 9, "name": "Power_On_Hours", "raw": {"value": 8123}}
+				]}
+			}`,
+			SMARTHealth{Model: "Samsung SSD 870 EVO 1TB", Serial: "S6PTNM0T123456", Passed: true,
+				Temperature: 34, PowerOnHours: 8123, ReallocatedSectors: 3},
+			"",
+		},
+		{
+			"nvme",
+			`{
+				"model_name": "WD_BLACK SN850X 2000GB",
+				"serial_number": "23123K800123",
+				"smart_status": {"passed": false},
+				"temperature": {"current": 41},
+				"power_on_time": {"hours": 1520},
+				"nvme_smart_health_information_log": {"percentage_used": 7, "media_errors": 2}
+			}`,
+			SMARTHealth{Model: "WD_BLACK SN850X 2000GB", Serial: "23123K800123", Temperature: 41,
+				PowerOnHours: 1520, PercentageUsed: 7, MediaErrors: 2},
+			"",
+		},
+		// smartctl reports devices it cannot read, like without root
+		{"no status", `{"smartctl": {"exit_status": 2}, "model_name": "QEMU HARDDISK"}`, SMARTHealth{}, "no SMART status"},
+		{"invalid", "Permission denied", SMARTHealth{}, "failed to parse smartctl output"},
+	}
+	for _, test := range tests {
+		health, err := parseSmartctl([]byte(test.report))
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected an error with %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed to parse: %v", test.name, err)
+		} else if *health != test.want {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.want, *health)
+		}
+	}
+}
+
+func TestRate(t *testing.T) {
+	tests := []struct {
+		before, after uint64
+		seconds       float64
+		want          float64
+	}{
+		{100, 150, 0.5, 100},
+		{0, 1, 3, 0.3},
+		{100, 100, 0.5, 0},
+		// A counter that was reset
+		{100, 50, 0.5, 0},
+		{0, 100, 0, 0},
+	}
+	for _, test := range tests {
+		if got := rate(test.before, test.after, test.seconds); got != test.want {
+			t.Errorf("%d to %d in %vs: expected %v, got %v", test.before, test.after, test.seconds, test.want, got)
+		}
+	}
+}
+
+func TestIsDiskDevice(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Only Linux leaves devices out")
+	}
+	for _, name := range []string{"loop0", "ram0", "zram0", "nosuchdisk"} {
+		if isDiskDevice(name) {
+			t.Errorf("Expected %s not to be a disk", name)
+		}
+	}
+
+	// The disks of this system, other than loop and RAM devices
+	entries, _ := os.ReadDir("/sys/block")
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") || strings.HasPrefix(name, "zram") {
+			continue
+		}
+		if !isDiskDevice(name) {
+			t.Errorf("Expected %s to be a disk", name)
+		}
+	}
+}
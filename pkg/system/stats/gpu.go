@@ -0,0 +1,346 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// toolTimeout is how long a tool that reports stats, like nvidia-smi, may
+// take
+const toolTimeout = 5 * time.Second
+
+// GPUInfo represents information about a GPU. Values a GPU or its driver
+// does not report are 0, like the temperature of Apple GPUs.
+type GPUInfo struct {
+	Index              int     `json:"index"`
+	Name               string  `json:"name"`
+	Vendor             string  `json:"vendor"`
+	UtilizationPercent float64 `json:"utilization_percent"`
+	MemoryTotal        float64 `json:"memory_total_gb"`
+	MemoryUsed         float64 `json:"memory_used_gb"`
+	Temperature        float64 `json:"temperature_c"`
+}
+
+// GPUStats contains information about all GPUs
+type GPUStats struct {
+	GPUs []GPUInfo `json:"gpus"`
+}
+
+// GetGPUStats returns information about the GPUs of the system: NVIDIA GPUs
+// through nvidia-smi, which reads NVML, AMD GPUs through sysfs on Linux and
+// Apple GPUs through system_profiler and ioreg on macOS. A system without
+// GPUs or their tools has none.
+func GetGPUStats() (*GPUStats, error) {
+	var collectors []func() ([]GPUInfo, error)
+	switch runtime.GOOS {
+	case "linux":
+		collectors = append(collectors, getNvidiaGPUs, getAMDGPUs)
+	case "darwin":
+		collectors = append(collectors, getAppleGPUs)
+	default:
+		collectors = append(collectors, getNvidiaGPUs)
+	}
+
+	stats := &GPUStats{
+		GPUs: []GPUInfo{},
+	}
+	var errs []error
+	for _, collect := range collectors {
+		gpus, err := collect()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, gpu := range gpus {
+			gpu.Index = len(stats.GPUs)
+			stats.GPUs = append(stats.GPUs, gpu)
+		}
+	}
+
+	// GPUs that were found are reported even if others could not be read
+	if len(stats.GPUs) == 0 && len(errs) > 0 {
+		return nil, fmt.Errorf("failed to get GPU stats: %w", errors.Join(errs...))
+	}
+	return stats, nil
+}
+
+// runTool runs a tool that reports stats and returns its output, or nil if
+// the tool is not installed. The output is also returned when the tool
+// fails, as some tools report in their exit status.
+func runTool(name string, args ...string) ([]byte, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), toolTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, path, args...).Output()
+	if err != nil {
+		return out, fmt.Errorf("failed to run %s: %w", name, err)
+	}
+	return out, nil
+}
+
+// getNvidiaGPUs returns the NVIDIA GPUs reported by nvidia-smi
+func getNvidiaGPUs() ([]GPUInfo, error) {
+	out, err := runTool("nvidia-smi",
+		"--query-gpu=name,utilization.gpu,memory.total,memory.used,temperature.gpu",
+		"--format=csv,noheader,nounits")
+	if err != nil || out == nil {
+		return nil, err
+	}
+	return parseNvidiaSMI(string(out)), nil
+}
+
+// parseNvidiaSMI parses the CSV lines of nvidia-smi --query-gpu, with the
+// memory in MiB. Values that are not supported, like "[N/A]", are 0.
+func parseNvidiaSMI(out string) []GPUInfo {
+	var gpus []GPUInfo
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) != 5 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		number := func(field string) float64 {
+			value, _ := strconv.ParseFloat(field, 64)
+			return value
+		}
+		gpus = append(gpus, GPUInfo{
+			Name:               fields[0],
+			Vendor:             "nvidia",
+			UtilizationPercent: number(fields[1]),
+			MemoryTotal:        roundGB(number(fields[2]) * 1024 * 1024),
+			MemoryUsed:         roundGB(number(fields[3]) * 1024 * 1024),
+			Temperature:        number(fields[4]),
+		})
+	}
+	return gpus
+}
+
+// amdVendorID is the PCI vendor ID of AMD in sysfs
+const amdVendorID = "0x1002"
+
+// getAMDGPUs returns the AMD GPUs of the amdgpu driver in sysfs
+func getAMDGPUs() ([]GPUInfo, error) {
+	return readAMDGPUs("/sys/class/drm")
+}
+
+// readAMDGPUs reads the AMD GPUs from the cards in a sysfs drm directory
+func readAMDGPUs(drm string) ([]GPUInfo, error) {
+	cards, err := filepath.Glob(filepath.Join(drm, "card[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var gpus []GPUInfo
+	for _, card := range cards {
+		// Skip the connectors of the cards, like card0-DP-1
+		if strings.Contains(filepath.Base(card), "-") {
+			continue
+		}
+		device := filepath.Join(card, "device")
+		if readSysfs(device, "vendor") != amdVendorID {
+			continue
+		}
+
+		gpu := GPUInfo{
+			Name:   readSysfs(device, "product_name"),
+			Vendor: "amd",
+		}
+		if gpu.Name == "" {
+			gpu.Name = "AMD GPU " + readSysfs(device, "device")
+		}
+		if busy, err := strconv.ParseFloat(readSysfs(device, "gpu_busy_percent"), 64); err == nil {
+			gpu.UtilizationPercent = busy
+		}
+		if total, err := strconv.ParseFloat(readSysfs(device, "mem_info_vram_total"), 64); err == nil {
+			gpu.MemoryTotal = roundGB(total)
+		}
+		if used, err := strconv.ParseFloat(readSysfs(device, "mem_info_vram_used"), 64); err == nil {
+			gpu.MemoryUsed = roundGB(used)
+		}
+		// The edge temperature in millidegrees
+		inputs, _ := filepath.Glob(filepath.Join(device, "hwmon", "hwmon*", "temp1_input"))
+		if len(inputs) > 0 {
+			if temp, err := strconv.ParseFloat(readSysfs(filepath.Dir(inputs[0]), "temp1_input"), 64); err == nil {
+				gpu.Temperature = temp / 1000
+			}
+		}
+		gpus = append(gpus, gpu)
+	}
+	return gpus, nil
+}
+
+// readSysfs returns the trimmed content of a sysfs file, or "" if it cannot
+// be read
+func readSysfs(dir, name string) string {
+	data, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// getAppleGPUs returns the GPUs reported by system_profiler, with the
+// utilization and memory in use reported by ioreg
+func getAppleGPUs() ([]GPUInfo, error) {
+	out, err := runTool("system_profiler", "SPDisplaysDataType", "-json")
+	if err != nil || out == nil {
+		return nil, err
+	}
+	gpus, err := parseSystemProfiler(out)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without ioreg the GPUs are reported without their usage
+	out, err = runTool("ioreg", "-r", "-d", "1", "-w", "0", "-c", "IOAccelerator")
+	if err == nil && out != nil {
+		for i, usage := range parseIORegUsage(string(out)) {
+			if i < len(gpus) {
+				gpus[i].UtilizationPercent = usage.UtilizationPercent
+				gpus[i].MemoryUsed = usage.MemoryUsed
+			}
+		}
+	}
+	return gpus, nil
+}
+
+// parseSystemProfiler parses the GPUs of system_profiler SPDisplaysDataType
+// -json. Apple silicon shares the memory of the system and reports no
+// memory of its GPU.
+func parseSystemProfiler(data []byte) ([]GPUInfo, error) {
+	var report struct {
+		Displays []struct {
+			Model      string `json:"sppci_model"`
+			Vendor     string `json:"spdisplays_vendor"`
+			VRAM       string `json:"spdisplays_vram"`
+			SharedVRAM string `json:"spdisplays_vram_shared"`
+		} `json:"SPDisplaysDataType"`
+	}
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse system_profiler output: %w", err)
+	}
+
+	var gpus []GPUInfo
+	for _, display := range report.Displays {
+		vram := display.VRAM
+		if vram == "" {
+			vram = display.SharedVRAM
+		}
+		gpus = append(gpus, GPUInfo{
+			Name:        display.Model,
+			Vendor:      appleGPUVendor(display.Vendor),
+			MemoryTotal: roundGB(parseMemorySize(vram)),
+		})
+	}
+	return gpus, nil
+}
+
+// appleGPUVendor returns the vendor of a GPU from the vendor that
+// system_profiler reports, like "sppci_vendor_Apple" or "Intel"
+func appleGPUVendor(vendor string) string {
+	vendor = strings.ToLower(strings.TrimPrefix(vendor, "sppci_vendor_"))
+	switch {
+	case strings.Contains(vendor, "nvidia"):
+		return "nvidia"
+	case strings.Contains(vendor, "amd"), strings.Contains(vendor, "ati"):
+		return "amd"
+	case strings.Contains(vendor, "intel"):
+		return "intel"
+	}
+	return "apple"
+}
+
+// parseMemorySize parses a size like "1536 MB" or "8 GB" into bytes
+func parseMemorySize(size string) float64 {
+	fields := strings.Fields(size)
+	if len(fields) != 2 {
+		return 0
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	switch strings.ToUpper(fields[1]) {
+	case "GB":
+		return value * 1024 * 1024 * 1024
+	case "MB":
+		return value * 1024 * 1024
+	}
+	return 0
+}
+
+var (
+	ioregUtilization = regexp.MustCompile(`"Device Utilization %"=(\d+)`)
+	ioregMemoryUsed  = regexp.MustCompile(`"In use system memory"=(\d+)`)
+)
+
+// parseIORegUsage parses the utilization and the memory in use of the
+// accelerators reported by ioreg -c IOAccelerator, in their order
+func parseIORegUsage(out string) []GPUInfo {
+	var usages []GPUInfo
+	for _, statistics := range strings.Split(out, `"PerformanceStatistics"`)[1:] {
+		var usage GPUInfo
+		if match := ioregUtilization.FindStringSubmatch(statistics); match != nil {
+			usage.UtilizationPercent, _ = strconv.ParseFloat(match[1], 64)
+		}
+		if match := ioregMemoryUsed.FindStringSubmatch(statistics); match != nil {
+			used, _ := strconv.ParseFloat(match[1], 64)
+			usage.MemoryUsed = roundGB(used)
+		}
+		usages = append(usages, usage)
+	}
+	return usages
+}
+
+// roundGB converts bytes to GB rounded to 1 decimal place
+func roundGB(bytes float64) float64 {
+	return math.Round(bytes/(1024*1024*1024)*10) / 10
+}
+
+// GetFormattedGPUInfo returns a formatted string with GPU information, a
+// line per GPU
+func GetFormattedGPUInfo() string {
+	gpuStats, err := GetGPUStats()
+	if err != nil {
+		return "Unknown"
+	}
+
+	return formatGPUInfo(gpuStats)
+}
+
+// formatGPUInfo formats the GPUs a line per GPU, or "None"
+func formatGPUInfo(gpuStats *GPUStats) string {
+	if len(gpuStats.GPUs) == 0 {
+		return "None"
+	}
+
+	lines := make([]string, 0, len(gpuStats.GPUs))
+	for _, gpu := range gpuStats.GPUs {
+		line := fmt.Sprintf("%s (%.0f%% used", gpu.Name, gpu.UtilizationPercent)
+		if gpu.MemoryTotal > 0 {
+			line += fmt.Sprintf(", %.1fGB/%.1fGB", gpu.MemoryUsed, gpu.MemoryTotal)
+		}
+		if gpu.Temperature > 0 {
+			line += fmt.Sprintf(", %.0f°C", gpu.Temperature)
+		}
+		lines = append(lines, line+")")
+	}
+	return strings.Join(lines, "\n")
+}
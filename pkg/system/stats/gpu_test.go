@@ -0,0 +1,152 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseNvidiaSMI(t *testing.T) {
+	out := "NVIDIA GeForce RTX 3080, 45, 10240, 2048, 61\n" +
+		"Tesla T4, 0, 15360, 0, [N/A]\n" +
+		"\n" +
+		"not, a, gpu\n"
+	want := []GPUInfo{
+		{Name: "NVIDIA GeForce RTX 3080", Vendor: "nvidia", UtilizationPercent: 45, MemoryTotal: 10, MemoryUsed: 2, Temperature: 61},
+		{Name: "Tesla T4", Vendor: "nvidia", MemoryTotal: 15},
+	}
+	if got := parseNvidiaSMI(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if got := parseNvidiaSMI(""); len(got) != 0 {
+		t.Errorf("Expected no GPUs, got %+v", got)
+	}
+}
+
+func TestReadAMDGPUs(t *testing.T) {
+	drm := t.TempDir()
+	write := func(path, content string) {
+		path = filepath.Join(drm, path)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content+"\n"), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	// An AMD card with all its files, one without a product name, a
+	// connector and a card of another vendor
+	write("card0/device/vendor", "0x1002")
+	write("card0/device/product_name", "Radeon RX 7900 XT")
+	write("card0/device/gpu_busy_percent", "37")
+	write("card0/device/mem_info_vram_total", "21474836480")
+	write("card0/device/mem_info_vram_used", "1073741824")
+	write("card0/device/hwmon/hwmon3/temp1_input", "52000")
+	write("card0-DP-1/device/vendor", "0x1002")
+	write("card1/device/vendor", "0x1002")
+	write("card1/device/device", "0x73bf")
+	write("card2/device/vendor", "0x8086")
+
+	gpus, err := readAMDGPUs(drm)
+	if err != nil {
+		t.Fatalf("Failed to read the GPUs: %v", err)
+	}
+	want := []GPUInfo{
+		{Name: "Radeon RX 7900 XT", Vendor: "amd", UtilizationPercent: 37, MemoryTotal: 20, MemoryUsed: 1, Temperature: 52},
+		{Name: "AMD GPU 0x73bf", Vendor: "amd"},
+	}
+	if !reflect.DeepEqual(gpus, want) {
+		t.Errorf("Expected %+v, got %+v", want, gpus)
+	}
+
+	if gpus, err := readAMDGPUs(filepath.Join(drm, "missing")); err != nil || len(gpus) != 0 {
+		t.Errorf("Expected no GPUs without cards, got %+v, %v", gpus, err)
+	}
+}
+
+func TestParseSystemProfiler(t *testing.T) {
+	data := []byte(`{"SPDisplaysDataType": [
+		{"sppci_model": "Apple M2 Pro", "spdisplays_vendor": "sppci_vendor_Apple"},
+		{"sppci_model": "AMD Radeon Pro 5500M", "spdisplays_vendor": "sppci_vendor_AMD", "spdisplays_vram": "8 GB"},
+		{"sppci_model": "Intel UHD Graphics 630", "spdisplays_vendor": "Intel", "spdisplays_vram_shared": "1536 MB"}
+	]}`)
+	want := []GPUInfo{
+		{Name: "Apple M2 Pro", Vendor: "apple"},
+		{Name: "AMD Radeon Pro 5500M", Vendor: "amd", MemoryTotal: 8},
+		{Name: "Intel UHD Graphics 630", Vendor: "intel", MemoryTotal: 1.5},
+	}
+	gpus, err := parseSystemProfiler(data)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if !reflect.DeepEqual(gpus, want) {
+		t.Errorf("Expected %+v, got %+v", want, gpus)
+	}
+
+	if _, err := parseSystemProfiler([]byte("not json")); err == nil {
+		t.Error("Expected an error for invalid output")
+	}
+}
+
+func TestParseMemorySize(t *testing.T) {
+	tests := []struct {
+		size string
+		want float64
+	}{
+		{"8 GB", 8 << 30},
+		{"1536 MB", 1536 << 20},
+		{"2 gb", 2 << 30},
+		{"512 KB", 0},
+		{"8GB", 0},
+		{"many GB", 0},
+		{"", 0},
+	}
+	for _, test := range tests {
+		if got := parseMemorySize(test.size); got != test.want {
+			t.Errorf("%q: expected %v, got %v", test.size, test.want, got)
+		}
+	}
+}
+
+func TestParseIORegUsage(t *testing.T) {
+	out := `+-o AGXAcceleratorG14X  <class AGXAcceleratorG14X>
+    {
+      "PerformanceStatistics" = {"In use system memory"=3221225472,"Device Utilization %"=23,"Renderer Utilization %"=20}
+    }
++-o AMDRadeonX6000  <class AMDRadeonX6000>
+    {
+      "PerformanceStatistics" = {"Device Utilization %"=5}
+    }
+`
+	want := []GPUInfo{
+		{UtilizationPercent: 23, MemoryUsed: 3},
+		{UtilizationPercent: 5},
+	}
+	if got := parseIORegUsage(out); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if got := parseIORegUsage("no accelerators"); len(got) != 0 {
+		t.Errorf("Expected no usage, got %+v", got)
+	}
+}
+
+func TestFormatGPUInfo(t *testing.T) {
+	tests := []struct {
+		gpus []GPUInfo
+		want string
+	}{
+		{nil, "None"},
+		{[]GPUInfo{{Name: "Apple M2 Pro", UtilizationPercent: 12}}, "Apple M2 Pro (12% used)"},
+		{
+			[]GPUInfo{
+				{Name: "RTX 3080", UtilizationPercent: 45, MemoryTotal: 10, MemoryUsed: 2.5, Temperature: 61},
+				{Name: "Tesla T4", MemoryTotal: 15},
+			},
+			"RTX 3080 (45% used, 2.5GB/10.0GB, 61°C)\nTesla T4 (0% used, 0.0GB/15.0GB)",
+		},
+	}
+	for _, test := range tests {
+		if got := formatGPUInfo(&GPUStats{GPUs: test.gpus}); got != test.want {
+			t.Errorf("Expected %q, got %q", test.want, got)
+		}
+	}
+}
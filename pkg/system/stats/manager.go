@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"slices"
 	"sync"
 	"time"
 
@@ -38,8 +39,26 @@ type StatsManager struct {
 	
 	// Logger for StatsManager operations
 	logger *log.Logger
+
+	// Collectors of the built-in and registered stats types, in the order
+	// they were added, and the config of the collectors from the Config
+	collectors       map[string]*collector
+	collectorOrder   []string
+	collectorConfigs map[string]CollectorConfig
+
+	// Alert rules, the alerts of the rules whose condition holds or held,
+	// and the metrics registered by other packages
+	alertRules     []AlertRule
+	alerts         map[string]*Alert
+	alertsMutex    sync.Mutex
+	alertMetrics   map[string]AlertMetric
+	runAlertAction func(script string) error
 }
 
+// Source fetches the stats of a stats type, like the resources of the
+// processes of the process manager
+type Source func() (interface{}, error)
+
 // NewStatsManager creates a new StatsManager with Redis connection
 func NewStatsManager(config *Config) (*StatsManager, error) {
 	// Use default config if nil is provided
@@ -65,7 +84,10 @@ func NewStatsManager(config *Config) (*StatsManager, error) {
 	ctx, cancel := context.WithCancel(ctx)
 
 	// Create logger
-	logger := log.New(os.Stdout, "[StatsManager] ", log.LstdFlags)
+	logger := config.Logger
+	if logger == nil {
+		logger = log.New(os.Stdout, "[StatsManager] ", log.LstdFlags)
+	}
 
 	// Create the manager
 	manager := &StatsManager{
@@ -77,11 +99,39 @@ func NewStatsManager(config *Config) (*StatsManager, error) {
 		cancel:         cancel,
 		defaultTimeout: config.DefaultTimeout,
 		logger:         logger,
+		collectors:       make(map[string]*collector),
+		collectorConfigs: config.Collectors,
+		alerts:         make(map[string]*Alert),
+		alertMetrics:   make(map[string]AlertMetric),
+		runAlertAction: config.RunAlertAction,
+	}
+
+	if manager.collectorConfigs == nil {
+		manager.collectorConfigs = make(map[string]CollectorConfig)
+	}
+	manager.registerBuiltinCollectors()
+
+	for _, rule := range config.AlertRules {
+		if err := manager.AddAlertRule(rule); err != nil {
+			cancel()
+			client.Close()
+			return nil, err
+		}
 	}
 
 	// Start the background goroutine for updates
 	go manager.updateWorker()
 
+	// Start the background goroutine for the collectors with an interval
+	go manager.scheduleWorker()
+
+	// Start the background goroutine for alerts
+	alertInterval := config.AlertInterval
+	if alertInterval <= 0 {
+		alertInterval = defaultAlertInterval
+	}
+	go manager.alertWorker(alertInterval)
+
 	// Initialize cache with first fetch
 	manager.initializeCache()
 
@@ -121,31 +171,11 @@ func (sm *StatsManager) updateWorker() {
 
 // fetchAndCacheStats fetches stats and caches them in Redis
 func (sm *StatsManager) fetchAndCacheStats(statsType string) {
-	var data interface{}
-	var err error
-	
 	sm.logger.Printf("Fetching %s stats", statsType)
 	startTime := time.Now()
 	
 	// Fetch the requested stats
-	switch statsType {
-	case "system":
-		data, err = GetSystemInfo()
-	case "disk":
-		data, err = GetDiskStats()
-	case "process":
-		data, err = GetProcessStats(0) // Get all processes
-	case "root_disk":
-		data, err = GetRootDiskInfo()
-	case "network":
-		data = GetNetworkSpeedResult()
-	case "hardware":
-		data = GetHardwareStatsJSON()
-	default:
-		sm.logger.Printf("Unknown stats type: %s", statsType)
-		return // Unknown stats type
-	}
-	
+	data, err := sm.fetch(statsType)
 	if err != nil {
 		// Log error but continue
 		sm.logger.Printf("Error fetching %s stats: %v", statsType, err)
@@ -161,7 +191,7 @@ func (sm *StatsManager) fetchAndCacheStats(statsType string) {
 	
 	// Cache in Redis
 	key := fmt.Sprintf("stats:%s", statsType)
-	err = sm.redisClient.Set(sm.ctx, key, jsonData, sm.Expiration[statsType]).Err()
+	err = sm.redisClient.Set(sm.ctx, key, jsonData, sm.expiration(statsType)).Err()
 	if err != nil {
 		sm.logger.Printf("Error caching %s stats: %v", statsType, err)
 		return
@@ -174,13 +204,68 @@ func (sm *StatsManager) fetchAndCacheStats(statsType string) {
 	sm.logger.Printf("Successfully cached %s stats in %v", statsType, time.Since(startTime))
 }
 
+// fetch fetches the stats of a type from its collector
+func (sm *StatsManager) fetch(statsType string) (interface{}, error) {
+	if err := sm.checkEnabled(statsType); err != nil {
+		return nil, err
+	}
+	sm.mu.Lock()
+	fetch := sm.collectors[statsType].fetch
+	sm.mu.Unlock()
+	return fetch()
+}
+
+// checkEnabled returns an error if a stats type has no collector or its
+// collector is disabled
+func (sm *StatsManager) checkEnabled(statsType string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	c, ok := sm.collectors[statsType]
+	if !ok {
+		return fmt.Errorf("unknown stats type: %s", statsType)
+	}
+	if c.config.Disabled {
+		return fmt.Errorf("stats type %s is disabled", statsType)
+	}
+	return nil
+}
+
+// expiration returns how long the stats of a type are cached
+func (sm *StatsManager) expiration(statsType string) time.Duration {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if c, ok := sm.collectors[statsType]; ok && c.config.Expiration > 0 {
+		return c.config.Expiration
+	}
+	return sm.Expiration[statsType]
+}
+
+// statsTypes returns the built-in and the registered stats types
+func (sm *StatsManager) statsTypes() []string {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return append([]string{}, sm.collectorOrder...)
+}
+
+// RegisterSource adds a stats type whose stats are fetched from source and
+// cached for expiration, like the built-in types. The stats are read with
+// GetStats.
+func (sm *StatsManager) RegisterSource(statsType string, src Source, expiration time.Duration) error {
+	return sm.RegisterCollector(statsType, src, CollectorConfig{Expiration: expiration})
+}
+
+// GetStats gets the stats of a registered stats type with caching and
+// unmarshals them into result
+func (sm *StatsManager) GetStats(statsType string, result interface{}) error {
+	return sm.getFromCache(statsType, result)
+}
+
 // initializeCache initializes the cache with initial values
 func (sm *StatsManager) initializeCache() {
 	sm.logger.Println("Initializing stats cache")
 	
-	// Queue initial fetches for all stats types
-	statsTypes := []string{"system", "disk", "process", "root_disk", "network", "hardware"}
-	for _, statsType := range statsTypes {
+	// Queue initial fetches for all enabled stats types
+	for _, statsType := range sm.enabledStatsTypes() {
 		sm.logger.Printf("Queueing initial fetch for %s stats", statsType)
 		sm.updateQueue <- statsType
 	}
@@ -188,6 +273,11 @@ func (sm *StatsManager) initializeCache() {
 
 // getFromCache gets stats from cache or triggers an update if expired
 func (sm *StatsManager) getFromCache(statsType string, result interface{}) error {
+	// The cache of a disabled collector may still hold its last stats
+	if err := sm.checkEnabled(statsType); err != nil {
+		return err
+	}
+
 	// In debug mode, fetch directly without caching
 	if sm.Debug {
 		sm.logger.Printf("Debug mode enabled, fetching %s stats directly", statsType)
@@ -222,7 +312,7 @@ func (sm *StatsManager) getFromCache(statsType string, result interface{}) error
 		fmt.Sscanf(lastUpdateStr, "%d", &lastUpdate)
 		
 		// If expired, queue an update for next time
-		expiration := sm.Expiration[statsType]
+		expiration := sm.expiration(statsType)
 		updateTime := time.Unix(lastUpdate, 0)
 		age := time.Since(updateTime)
 		
@@ -247,27 +337,8 @@ func (sm *StatsManager) getFromCache(statsType string, result interface{}) error
 
 // fetchDirect fetches stats directly without caching
 func (sm *StatsManager) fetchDirect(statsType string, result interface{}) error {
-	var data interface{}
-	var err error
-
 	// Fetch the requested stats
-	switch statsType {
-	case "system":
-		data, err = GetSystemInfo()
-	case "disk":
-		data, err = GetDiskStats()
-	case "process":
-		data, err = GetProcessStats(0) // Get all processes
-	case "root_disk":
-		data, err = GetRootDiskInfo()
-	case "network":
-		data = GetNetworkSpeedResult()
-	case "hardware":
-		data = GetHardwareStatsJSON()
-	default:
-		return fmt.Errorf("unknown stats type: %s", statsType)
-	}
-
+	data, err := sm.fetch(statsType)
 	if err != nil {
 		return err
 	}
@@ -283,27 +354,8 @@ func (sm *StatsManager) fetchDirect(statsType string, result interface{}) error
 
 // fetchDirectAndCache fetches stats directly and caches them
 func (sm *StatsManager) fetchDirectAndCache(statsType string, result interface{}) error {
-	var data interface{}
-	var err error
-
 	// Fetch the requested stats
-	switch statsType {
-	case "system":
-		data, err = GetSystemInfo()
-	case "disk":
-		data, err = GetDiskStats()
-	case "process":
-		data, err = GetProcessStats(0) // Get all processes
-	case "root_disk":
-		data, err = GetRootDiskInfo()
-	case "network":
-		data = GetNetworkSpeedResult()
-	case "hardware":
-		data = GetHardwareStatsJSON()
-	default:
-		return fmt.Errorf("unknown stats type: %s", statsType)
-	}
-
+	data, err := sm.fetch(statsType)
 	if err != nil {
 		return err
 	}
@@ -316,7 +368,7 @@ func (sm *StatsManager) fetchDirectAndCache(statsType string, result interface{}
 
 	// Cache in Redis
 	key := fmt.Sprintf("stats:%s", statsType)
-	err = sm.redisClient.Set(sm.ctx, key, jsonData, sm.Expiration[statsType]).Err()
+	err = sm.redisClient.Set(sm.ctx, key, jsonData, sm.expiration(statsType)).Err()
 	if err != nil {
 		return err
 	}
@@ -356,13 +408,14 @@ func (sm *StatsManager) waitForCachedData(statsType string, timeout time.Duratio
 
 // ClearCache clears all cached stats or a specific stats type
 func (sm *StatsManager) ClearCache(statsType string) error {
+	statsTypes := sm.statsTypes()
+
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
 	if statsType == "" {
 		// Clear all stats
 		sm.logger.Println("Clearing all cached stats")
-		statsTypes := []string{"system", "disk", "process", "root_disk", "network", "hardware"}
 		for _, t := range statsTypes {
 			key := fmt.Sprintf("stats:%s", t)
 			lastUpdateKey := fmt.Sprintf("stats:%s:last_update", t)
@@ -394,13 +447,11 @@ func (sm *StatsManager) ForceUpdate(statsType string) error {
 	}
 
 	// Fetch and cache directly
-	switch statsType {
-	case "system", "disk", "process", "root_disk", "network", "hardware":
-		sm.fetchAndCacheStats(statsType)
-		return nil
-	default:
+	if !slices.Contains(sm.statsTypes(), statsType) {
 		return fmt.Errorf("unknown stats type: %s", statsType)
 	}
+	sm.fetchAndCacheStats(statsType)
+	return nil
 }
 
 // GetSystemInfo gets system information with caching
@@ -460,6 +511,45 @@ func (sm *StatsManager) GetProcessStats(limit int) (*ProcessStats, error) {
 	return &result, nil
 }
 
+// GetGPUStats gets GPU statistics with caching
+func (sm *StatsManager) GetGPUStats() (*GPUStats, error) {
+	var result GPUStats
+
+	// Try to get from cache
+	err := sm.getFromCache("gpu", &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetLoadInfo gets the load averages with caching
+func (sm *StatsManager) GetLoadInfo() (*LoadInfo, error) {
+	var result LoadInfo
+
+	// Try to get from cache
+	err := sm.getFromCache("load", &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetPortStats gets the listening ports and connection states with caching
+func (sm *StatsManager) GetPortStats() (*PortStats, error) {
+	var result PortStats
+
+	// Try to get from cache
+	err := sm.getFromCache("ports", &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
 // GetTopProcesses gets top processes by CPU usage with caching
 func (sm *StatsManager) GetTopProcesses(n int) ([]ProcessInfo, error) {
 	stats, err := sm.GetProcessStats(n)
@@ -478,16 +568,25 @@ func (sm *StatsManager) GetNetworkSpeedResult() NetworkSpeedResult {
 	err := sm.getFromCache("network", &result)
 	if err != nil {
 		// Fallback to direct fetch on error
-		uploadSpeed, downloadSpeed := GetNetworkSpeed()
-		return NetworkSpeedResult{
-			UploadSpeed:   uploadSpeed,
-			DownloadSpeed: downloadSpeed,
-		}
+		return GetNetworkSpeedResult()
 	}
 
 	return result
 }
 
+// GetNetworkStats gets the stats of the network interfaces with caching
+func (sm *StatsManager) GetNetworkStats() (*NetworkStats, error) {
+	var result NetworkStats
+
+	// Try to get from cache
+	err := sm.getFromCache("network", &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
 // GetHardwareStats gets hardware statistics with caching
 func (sm *StatsManager) GetHardwareStats() map[string]interface{} {
 	var result map[string]interface{}
@@ -533,7 +632,7 @@ func (sm *StatsManager) GetFormattedMemoryInfo() string {
 		return "Unknown"
 	}
 
-	return fmt.Sprintf("%.1fGB (%.1fGB used)", sysInfo.Memory.Total, sysInfo.Memory.Used)
+	return formatMemoryInfo(sysInfo.Memory)
 }
 
 // GetFormattedDiskInfo gets formatted disk info with caching
@@ -553,6 +652,16 @@ func (sm *StatsManager) GetFormattedNetworkInfo() string {
 	return fmt.Sprintf("Up: %s\nDown: %s", netSpeed.UploadSpeed, netSpeed.DownloadSpeed)
 }
 
+// GetFormattedGPUInfo gets formatted GPU info with caching
+func (sm *StatsManager) GetFormattedGPUInfo() string {
+	gpuStats, err := sm.GetGPUStats()
+	if err != nil {
+		return "Unknown"
+	}
+
+	return formatGPUInfo(gpuStats)
+}
+
 // GetProcessStatsJSON gets process statistics in JSON format with caching
 func (sm *StatsManager) GetProcessStatsJSON(limit int) map[string]interface{} {
 	// Get process stats
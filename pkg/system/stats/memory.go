@@ -0,0 +1,132 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// cgroupNoLimit is above any memory limit of cgroup v1, which reports no
+// limit as a number close to the largest int64
+const cgroupNoLimit = 1 << 60
+
+// SwapInfo contains information about the swap space in GB
+type SwapInfo struct {
+	Total       float64 `json:"total_gb"`
+	Used        float64 `json:"used_gb"`
+	Free        float64 `json:"free_gb"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
+// CgroupMemoryInfo contains the memory in GB used by the cgroup this
+// process runs in, like the container, and its limit. Limit and UsedPercent
+// are 0 without a limit.
+type CgroupMemoryInfo struct {
+	Path        string  `json:"path"`
+	Used        float64 `json:"used_gb"`
+	Limit       float64 `json:"limit_gb"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
+// getMemoryInfo returns the memory of the system. Free is memory that is not
+// used at all, while Available also counts the caches that the kernel gives
+// up when programs need the memory.
+func getMemoryInfo() MemoryInfo {
+	memInfo := MemoryInfo{}
+	virtualMem, err := mem.VirtualMemory()
+	if err == nil {
+		memInfo.Total = float64(virtualMem.Total) / (1024 * 1024 * 1024) // Convert to GB
+		memInfo.Used = float64(virtualMem.Used) / (1024 * 1024 * 1024)
+		memInfo.Free = float64(virtualMem.Free) / (1024 * 1024 * 1024)
+		memInfo.UsedPercent = math.Round(virtualMem.UsedPercent*10) / 10
+		memInfo.Available = roundGB(float64(virtualMem.Available))
+		memInfo.Cached = roundGB(float64(virtualMem.Cached))
+		memInfo.Buffers = roundGB(float64(virtualMem.Buffers))
+		memInfo.Shared = roundGB(float64(virtualMem.Shared))
+	}
+
+	swapMem, err := mem.SwapMemory()
+	if err == nil {
+		memInfo.Swap = SwapInfo{
+			Total:       roundGB(float64(swapMem.Total)),
+			Used:        roundGB(float64(swapMem.Used)),
+			Free:        roundGB(float64(swapMem.Free)),
+			UsedPercent: math.Round(swapMem.UsedPercent*10) / 10,
+		}
+	}
+
+	memInfo.Cgroup = readCgroupMemory("/sys/fs/cgroup", "/proc/self/cgroup")
+	return memInfo
+}
+
+// readCgroupMemory reads the memory of the cgroup of this process from the
+// cgroup filesystem mounted at root, for cgroup v2 or the memory controller
+// of cgroup v1, or returns nil if there is none, like on other systems than
+// Linux
+func readCgroupMemory(root, procCgroup string) *CgroupMemoryInfo {
+	data, err := os.ReadFile(procCgroup)
+	if err != nil {
+		return nil
+	}
+
+	// Lines are hierarchy-ID:controllers:path, with an empty list of
+	// controllers for cgroup v2
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		switch {
+		case fields[0] == "0" && fields[1] == "":
+			dir := cgroupDir(root, fields[2])
+			return cgroupMemory(fields[2], readSysfs(dir, "memory.current"), readSysfs(dir, "memory.max"))
+		case strings.Contains(","+fields[1]+",", ",memory,"):
+			dir := cgroupDir(filepath.Join(root, "memory"), fields[2])
+			return cgroupMemory(fields[2], readSysfs(dir, "memory.usage_in_bytes"), readSysfs(dir, "memory.limit_in_bytes"))
+		}
+	}
+	return nil
+}
+
+// cgroupDir returns the directory of a cgroup below root. In a container the
+// cgroup of the container is usually mounted at root itself.
+func cgroupDir(root, path string) string {
+	dir := filepath.Join(root, path)
+	if _, err := os.Stat(dir); err != nil {
+		return root
+	}
+	return dir
+}
+
+// cgroupMemory returns the memory of a cgroup from the usage and the limit
+// in bytes of its files, where the limit "max" means none
+func cgroupMemory(path, usage, limit string) *CgroupMemoryInfo {
+	used, err := strconv.ParseFloat(usage, 64)
+	if err != nil {
+		return nil
+	}
+	info := &CgroupMemoryInfo{
+		Path: path,
+		Used: roundGB(used),
+	}
+	if max, err := strconv.ParseFloat(limit, 64); err == nil && max > 0 && max < cgroupNoLimit {
+		info.Limit = roundGB(max)
+		info.UsedPercent = math.Round(used/max*1000) / 10
+	}
+	return info
+}
+
+// formatMemoryInfo formats the memory, with the swap on a second line if the
+// system has swap
+func formatMemoryInfo(memory MemoryInfo) string {
+	line := fmt.Sprintf("%.1fGB (%.1fGB used, %.1fGB available)", memory.Total, memory.Used, memory.Available)
+	if memory.Swap.Total == 0 {
+		return line
+	}
+	return line + fmt.Sprintf("\nSwap: %.1fGB (%.1fGB used)", memory.Swap.Total, memory.Swap.Used)
+}
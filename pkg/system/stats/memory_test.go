@@ -0,0 +1,74 @@
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadCgroupMemory(t *testing.T) {
+	root := t.TempDir()
+	write := func(path, content string) {
+		path = filepath.Join(root, path)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	// cgroup v2 for a service, v2 mounted in a container, and the memory
+	// controller of cgroup v1 without a limit
+	write("v2/system.slice/web.service/memory.current", "536870912\n")
+	write("v2/system.slice/web.service/memory.max", "2147483648\n")
+	write("container/memory.current", "1073741824\n")
+	write("container/memory.max", "max\n")
+	write("v1/memory/docker/abc/memory.usage_in_bytes", "268435456\n")
+	write("v1/memory/docker/abc/memory.limit_in_bytes", "9223372036854771712\n")
+	write("broken/memory.current", "unknown\n")
+
+	tests := []struct {
+		name   string
+		root   string
+		cgroup string
+		want   *CgroupMemoryInfo
+	}{
+		{"v2", "v2", "0::/system.slice/web.service\n",
+			&CgroupMemoryInfo{Path: "/system.slice/web.service", Used: 0.5, Limit: 2, UsedPercent: 25}},
+		{"v2 in a container", "container", "0::/docker/abc\n",
+			&CgroupMemoryInfo{Path: "/docker/abc", Used: 1}},
+		{"v1", "v1", "12:cpuset:/docker/abc\n11:memory:/docker/abc\n1:name=systemd:/docker/abc\n",
+			&CgroupMemoryInfo{Path: "/docker/abc", Used: 0.3}},
+		{"v1 with combined controllers", "v1", "4:cpu,memory:/docker/abc\n",
+			&CgroupMemoryInfo{Path: "/docker/abc", Used: 0.3}},
+		{"v1 without memory controller", "v1", "12:cpuset:/docker/abc\n", nil},
+		{"unreadable usage", "broken", "0::/\n", nil},
+		{"empty", "v2", "", nil},
+	}
+	for _, test := range tests {
+		procCgroup := filepath.Join(t.TempDir(), "cgroup")
+		os.WriteFile(procCgroup, []byte(test.cgroup), 0644)
+		got := readCgroupMemory(filepath.Join(root, test.root), procCgroup)
+		if (got == nil) != (test.want == nil) || (got != nil && *got != *test.want) {
+			t.Errorf("%s: expected %+v, got %+v", test.name, test.want, got)
+		}
+	}
+
+	if got := readCgroupMemory(root, filepath.Join(root, "missing")); got != nil {
+		t.Errorf("Expected no cgroup without /proc/self/cgroup, got %+v", got)
+	}
+}
+
+func TestFormatMemoryInfo(t *testing.T) {
+	tests := []struct {
+		memory MemoryInfo
+		want   string
+	}{
+		{MemoryInfo{Total: 16, Used: 6.25, Available: 9.5}, "16.0GB (6.2GB used, 9.5GB available)"},
+		{MemoryInfo{Total: 16, Used: 6, Available: 9.5, Swap: SwapInfo{Total: 4, Used: 0.5}},
+			"16.0GB (6.0GB used, 9.5GB available)\nSwap: 4.0GB (0.5GB used)"},
+	}
+	for _, test := range tests {
+		if got := formatMemoryInfo(test.memory); got != test.want {
+			t.Errorf("Expected %q, got %q", test.want, got)
+		}
+	}
+}
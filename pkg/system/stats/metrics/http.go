@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// knownMethods are reported by name; other methods are counted as OTHER so
+// clients cannot create an unbounded number of label values
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodDelete: true, http.MethodOptions: true, http.MethodPatch: true,
+	"PROPFIND": true, "PROPPATCH": true, "MKCOL": true, "COPY": true, "MOVE": true,
+	"LOCK": true, "UNLOCK": true, "REPORT": true,
+}
+
+// HTTPMetrics counts the requests, transferred bytes, errors and open
+// connections of an HTTP server
+type HTTPMetrics struct {
+	requests      *Metric
+	errors        *Metric
+	bytesReceived *Metric
+	bytesSent     *Metric
+	connections   *Metric
+}
+
+// NewHTTPMetrics registers the metrics of an HTTP server in registry, with
+// names starting with namespace, e.g. "vfsdav_requests_total"
+func NewHTTPMetrics(registry *Registry, namespace string) *HTTPMetrics {
+	return &HTTPMetrics{
+		requests:      registry.Counter(namespace+"_requests_total", "Requests served by method.", "method"),
+		errors:        registry.Counter(namespace+"_errors_total", "Requests answered with a 4xx or 5xx status.", "method", "status"),
+		bytesReceived: registry.Counter(namespace+"_received_bytes_total", "Bytes read from request bodies."),
+		bytesSent:     registry.Counter(namespace+"_sent_bytes_total", "Bytes written to response bodies."),
+		connections:   registry.Gauge(namespace+"_active_connections", "Open client connections."),
+	}
+}
+
+// Handler wraps next to count every request it serves
+func (m *HTTPMetrics) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.Method
+		if !knownMethods[method] {
+			method = "OTHER"
+		}
+
+		body := &countingReader{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		m.requests.Inc(method)
+		if rw.status >= 400 {
+			m.errors.Inc(method, strconv.Itoa(rw.status))
+		}
+		m.bytesReceived.Add(body.n)
+		m.bytesSent.Add(rw.bytes)
+	})
+}
+
+// ConnState tracks the open connections; set it as http.Server.ConnState
+func (m *HTTPMetrics) ConnState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		m.connections.Inc()
+	case http.StateClosed, http.StateHijacked:
+		m.connections.Dec()
+	}
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// responseWriter records the status code and body size of a response
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
@@ -0,0 +1,243 @@
+// Package metrics provides a small registry of counters and gauges that
+// servers update while running. The registry is exposed in the Prometheus
+// text format, so it can be scraped by Prometheus or read by the stats subsystem.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Kind is the type of a metric
+type Kind string
+
+const (
+	// KindCounter is a value that only goes up, e.g. the number of requests served
+	KindCounter Kind = "counter"
+	// KindGauge is a value that goes up and down, e.g. the number of open connections
+	KindGauge Kind = "gauge"
+)
+
+// Default is the registry used by servers that are not given one
+var Default = NewRegistry()
+
+// Registry holds a set of metrics by name
+type Registry struct {
+	mu      sync.RWMutex
+	metrics map[string]*Metric
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		metrics: make(map[string]*Metric),
+	}
+}
+
+// Counter returns the counter with the given name, registering it on first
+// use. Every value of the counter has one value for each of the label names.
+func (r *Registry) Counter(name, help string, labelNames ...string) *Metric {
+	return r.register(name, help, KindCounter, labelNames)
+}
+
+// Gauge returns the gauge with the given name, registering it on first use
+func (r *Registry) Gauge(name, help string, labelNames ...string) *Metric {
+	return r.register(name, help, KindGauge, labelNames)
+}
+
+// register returns an existing metric or adds a new one. Registering the same
+// name with another kind or other labels is a programming error and panics.
+func (r *Registry) register(name, help string, kind Kind, labelNames []string) *Metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if metric, ok := r.metrics[name]; ok {
+		if metric.kind != kind || strings.Join(metric.labelNames, ",") != strings.Join(labelNames, ",") {
+			panic(fmt.Sprintf("metrics: %s is already registered as a %s with labels %v", name, metric.kind, metric.labelNames))
+		}
+		return metric
+	}
+
+	metric := &Metric{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		values:     make(map[string]*value),
+	}
+	r.metrics[name] = metric
+	return metric
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := make([]*Metric, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.mu.RUnlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, metric := range metrics {
+		metric.write(cw)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// Handler returns an HTTP handler serving the registry, to be mounted at /metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// Metric is a counter or gauge with a value per combination of label values
+type Metric struct {
+	name       string
+	help       string
+	kind       Kind
+	labelNames []string
+
+	mu     sync.RWMutex
+	values map[string]*value
+}
+
+// value is the current value of a metric for one set of label values
+type value struct {
+	labelValues []string
+	n           atomic.Int64
+}
+
+// Add adds delta to the value for the given label values
+func (m *Metric) Add(delta int64, labelValues ...string) {
+	m.value(labelValues).n.Add(delta)
+}
+
+// Inc adds one to the value for the given label values
+func (m *Metric) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+// Dec subtracts one from the value of a gauge
+func (m *Metric) Dec(labelValues ...string) {
+	m.Add(-1, labelValues...)
+}
+
+// Set replaces the value of a gauge
+func (m *Metric) Set(n int64, labelValues ...string) {
+	m.value(labelValues).n.Store(n)
+}
+
+// Value returns the current value for the given label values
+func (m *Metric) Value(labelValues ...string) int64 {
+	return m.value(labelValues).n.Load()
+}
+
+// value returns the value for the label values, creating it if needed
+func (m *Metric) value(labelValues []string) *value {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", m.name, len(m.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	m.mu.RLock()
+	v, ok := m.values[key]
+	m.mu.RUnlock()
+	if ok {
+		return v
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, ok := m.values[key]; ok {
+		return v
+	}
+	v = &value{labelValues: append([]string(nil), labelValues...)}
+	m.values[key] = v
+	return v
+}
+
+// write writes the metric with its values sorted by label values
+func (m *Metric) write(w *countingWriter) {
+	m.mu.RLock()
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	values := make([]*value, 0, len(keys))
+	sort.Strings(keys)
+	for _, key := range keys {
+		values = append(values, m.values[key])
+	}
+	m.mu.RUnlock()
+
+	if m.help != "" {
+		w.printf("# HELP %s %s\n", m.name, helpEscaper.Replace(m.help))
+	}
+	w.printf("# TYPE %s %s\n", m.name, m.kind)
+
+	// Metrics without labels always report a value, even before first use
+	if len(m.labelNames) == 0 && len(values) == 0 {
+		w.printf("%s 0\n", m.name)
+		return
+	}
+	for _, v := range values {
+		w.printf("%s%s %d\n", m.name, formatLabels(m.labelNames, v.labelValues), v.n.Load())
+	}
+}
+
+// formatLabels formats label pairs as {name="value",...}
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + labelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// helpEscaper and labelEscaper escape help texts and label values as
+// required by the text format
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+)
+
+// countingWriter counts the bytes written and keeps the first error
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+	w.err = err
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.Counter("test_requests_total", "Requests served.", "method")
+	connections := registry.Gauge("test_connections", "Open connections.")
+
+	requests.Inc("GET")
+	requests.Add(2, "PUT")
+	requests.Inc("GET")
+	connections.Inc()
+	connections.Inc()
+	connections.Dec()
+
+	if got := requests.Value("GET"); got != 2 {
+		t.Errorf("expected 2 GET requests, got %d", got)
+	}
+	if registry.Counter("test_requests_total", "Requests served.", "method") != requests {
+		t.Errorf("expected registering an existing counter to return it")
+	}
+
+	var out strings.Builder
+	if _, err := registry.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+	expected := `# HELP test_connections Open connections.
+# TYPE test_connections gauge
+test_connections 1
+# HELP test_requests_total Requests served.
+# TYPE test_requests_total counter
+test_requests_total{method="GET"} 2
+test_requests_total{method="PUT"} 2
+`
+	if out.String() != expected {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", out.String(), expected)
+	}
+
+	rec := httptest.NewRecorder()
+	registry.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != expected {
+		t.Errorf("unexpected response %d:\n%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRegistryMismatch(t *testing.T) {
+	registry := NewRegistry()
+	registry.Counter("test_total", "", "method")
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected registering a gauge with a counter name to panic")
+		}
+	}()
+	registry.Gauge("test_total", "")
+}
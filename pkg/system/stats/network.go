@@ -0,0 +1,132 @@
+package stats
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// networkSampleInterval is how long the network counters are watched to
+// measure the speeds
+const networkSampleInterval = 500 * time.Millisecond
+
+// InterfaceStats contains the counters of a network interface since boot and
+// its current speeds in Mbps
+type InterfaceStats struct {
+	Name            string  `json:"name"`
+	BytesSent       uint64  `json:"bytes_sent"`
+	BytesReceived   uint64  `json:"bytes_received"`
+	PacketsSent     uint64  `json:"packets_sent"`
+	PacketsReceived uint64  `json:"packets_received"`
+	ErrorsIn        uint64  `json:"errors_in"`
+	ErrorsOut       uint64  `json:"errors_out"`
+	DropsIn         uint64  `json:"drops_in"`
+	DropsOut        uint64  `json:"drops_out"`
+	UploadMbps      float64 `json:"upload_mbps"`
+	DownloadMbps    float64 `json:"download_mbps"`
+}
+
+// NetworkStats contains the stats of every network interface and their
+// totals. UploadSpeed and DownloadSpeed are the total speeds formatted like
+// in NetworkSpeedResult.
+type NetworkStats struct {
+	Interfaces    []InterfaceStats `json:"interfaces"`
+	Total         InterfaceStats   `json:"total"`
+	UploadMbps    float64          `json:"upload_mbps"`
+	DownloadMbps  float64          `json:"download_mbps"`
+	UploadSpeed   string           `json:"upload_speed"`
+	DownloadSpeed string           `json:"download_speed"`
+}
+
+// GetNetworkStats returns the counters of the network interfaces and their
+// speeds, measured over half a second
+func GetNetworkStats() (*NetworkStats, error) {
+	countersStart, err := net.IOCounters(true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network counters: %w", err)
+	}
+	start := time.Now()
+
+	// Wait a short time to measure the difference
+	time.Sleep(networkSampleInterval)
+
+	countersEnd, err := net.IOCounters(true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network counters: %w", err)
+	}
+	return networkStats(countersStart, countersEnd, time.Since(start).Seconds()), nil
+}
+
+// networkStats returns the stats of the interfaces with the counters at the
+// end, and their speeds from the counters seconds before
+func networkStats(countersStart, countersEnd []net.IOCountersStat, seconds float64) *NetworkStats {
+	previous := make(map[string]net.IOCountersStat, len(countersStart))
+	for _, counters := range countersStart {
+		previous[counters.Name] = counters
+	}
+
+	stats := &NetworkStats{
+		Interfaces: make([]InterfaceStats, 0, len(countersEnd)),
+		Total:      InterfaceStats{Name: "total"},
+	}
+	for _, counters := range countersEnd {
+		iface := InterfaceStats{
+			Name:            counters.Name,
+			BytesSent:       counters.BytesSent,
+			BytesReceived:   counters.BytesRecv,
+			PacketsSent:     counters.PacketsSent,
+			PacketsReceived: counters.PacketsRecv,
+			ErrorsIn:        counters.Errin,
+			ErrorsOut:       counters.Errout,
+			DropsIn:         counters.Dropin,
+			DropsOut:        counters.Dropout,
+		}
+		// An interface that just appeared has no speed yet
+		if before, ok := previous[counters.Name]; ok {
+			iface.UploadMbps = mbps(before.BytesSent, counters.BytesSent, seconds)
+			iface.DownloadMbps = mbps(before.BytesRecv, counters.BytesRecv, seconds)
+		}
+		stats.Interfaces = append(stats.Interfaces, iface)
+
+		stats.Total.BytesSent += iface.BytesSent
+		stats.Total.BytesReceived += iface.BytesReceived
+		stats.Total.PacketsSent += iface.PacketsSent
+		stats.Total.PacketsReceived += iface.PacketsReceived
+		stats.Total.ErrorsIn += iface.ErrorsIn
+		stats.Total.ErrorsOut += iface.ErrorsOut
+		stats.Total.DropsIn += iface.DropsIn
+		stats.Total.DropsOut += iface.DropsOut
+		stats.Total.UploadMbps += iface.UploadMbps
+		stats.Total.DownloadMbps += iface.DownloadMbps
+	}
+
+	stats.Total.UploadMbps = math.Round(stats.Total.UploadMbps*1000) / 1000
+	stats.Total.DownloadMbps = math.Round(stats.Total.DownloadMbps*1000) / 1000
+	stats.UploadMbps = stats.Total.UploadMbps
+	stats.DownloadMbps = stats.Total.DownloadMbps
+	stats.UploadSpeed = formatSpeed(stats.UploadMbps)
+	stats.DownloadSpeed = formatSpeed(stats.DownloadMbps)
+	return stats
+}
+
+// mbps returns the speed in megabits per second, rounded to 3 decimal
+// places, of a counter of bytes that went from before to after in seconds. A
+// counter that was reset has no speed.
+func mbps(before, after uint64, seconds float64) float64 {
+	if after < before || seconds <= 0 {
+		return 0
+	}
+	// Convert bytes to bits (*8) and to megabits (/1024/1024)
+	speed := float64(after-before) * 8 / 1024 / 1024 / seconds
+	return math.Round(speed*1000) / 1000
+}
+
+// formatSpeed formats a speed in Mbps with appropriate units
+func formatSpeed(mbps float64) string {
+	if mbps < 1 {
+		return fmt.Sprintf("%.1f Kbps", mbps*1024)
+	}
+	return fmt.Sprintf("%.1f Mbps", mbps)
+}
@@ -0,0 +1,84 @@
+package stats
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+func TestNetworkStats(t *testing.T) {
+	countersStart := []net.IOCountersStat{
+		{Name: "eth0", BytesSent: 1000, BytesRecv: 2000},
+		{Name: "lo", BytesSent: 5000, BytesRecv: 5000},
+	}
+	// Over 2 seconds eth0 sends 1 Mbps and receives 0.5 Mbps, the counters
+	// of lo are reset and wg0 appears
+	countersEnd := []net.IOCountersStat{
+		{Name: "eth0", BytesSent: 1000 + 262144, BytesRecv: 2000 + 131072, PacketsSent: 10, PacketsRecv: 20,
+			Errin: 1, Errout: 2, Dropin: 3, Dropout: 4},
+		{Name: "lo", BytesSent: 100, BytesRecv: 100},
+		{Name: "wg0", BytesSent: 50, BytesRecv: 60, PacketsSent: 1, PacketsRecv: 1},
+	}
+	want := &NetworkStats{
+		Interfaces: []InterfaceStats{
+			{Name: "eth0", BytesSent: 263144, BytesReceived: 133072, PacketsSent: 10, PacketsReceived: 20,
+				ErrorsIn: 1, ErrorsOut: 2, DropsIn: 3, DropsOut: 4, UploadMbps: 1, DownloadMbps: 0.5},
+			{Name: "lo", BytesSent: 100, BytesReceived: 100},
+			{Name: "wg0", BytesSent: 50, BytesReceived: 60, PacketsSent: 1, PacketsReceived: 1},
+		},
+		Total: InterfaceStats{Name: "total", BytesSent: 263294, BytesReceived: 133232, PacketsSent: 11, PacketsReceived: 21,
+			ErrorsIn: 1, ErrorsOut: 2, DropsIn: 3, DropsOut: 4, UploadMbps: 1, DownloadMbps: 0.5},
+		UploadMbps:    1,
+		DownloadMbps:  0.5,
+		UploadSpeed:   "1.0 Mbps",
+		DownloadSpeed: "512.0 Kbps",
+	}
+	if got := networkStats(countersStart, countersEnd, 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+
+	empty := networkStats(nil, nil, 0.5)
+	if len(empty.Interfaces) != 0 || empty.UploadSpeed != "0.0 Kbps" || empty.Total.Name != "total" {
+		t.Errorf("Expected no interfaces, got %+v", empty)
+	}
+}
+
+func TestMbps(t *testing.T) {
+	tests := []struct {
+		before, after uint64
+		seconds       float64
+		want          float64
+	}{
+		{0, 131072, 1, 1},
+		{0, 131072, 0.5, 2},
+		{0, 1000, 1, 0.008},
+		{5000, 5000, 1, 0},
+		// A counter that was reset
+		{5000, 100, 1, 0},
+		{0, 131072, 0, 0},
+	}
+	for _, test := range tests {
+		if got := mbps(test.before, test.after, test.seconds); got != test.want {
+			t.Errorf("%d to %d in %vs: expected %v, got %v", test.before, test.after, test.seconds, test.want, got)
+		}
+	}
+}
+
+func TestFormatSpeed(t *testing.T) {
+	tests := []struct {
+		mbps float64
+		want string
+	}{
+		{0, "0.0 Kbps"},
+		{0.5, "512.0 Kbps"},
+		{0.999, "1023.0 Kbps"},
+		{1, "1.0 Mbps"},
+		{12.345, "12.3 Mbps"},
+	}
+	for _, test := range tests {
+		if got := formatSpeed(test.mbps); got != test.want {
+			t.Errorf("%v: expected %q, got %q", test.mbps, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"syscall"
+
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// ListeningPort is a TCP port that listens for connections or a UDP port
+// that receives datagrams from anyone. PID and Process are empty for sockets
+// of processes that cannot be seen, which usually needs root.
+type ListeningPort struct {
+	Protocol string `json:"protocol"`
+	Address  string `json:"address"`
+	Port     uint32 `json:"port"`
+	PID      int32  `json:"pid"`
+	Process  string `json:"process"`
+	// Connections is the number of established TCP connections to the port
+	Connections int `json:"connections"`
+}
+
+// PortStats contains the listening ports of the system and the number of
+// TCP connections in each state, like ESTABLISHED or TIME_WAIT
+type PortStats struct {
+	Listening        []ListeningPort `json:"listening"`
+	ConnectionStates map[string]int  `json:"connection_states"`
+}
+
+// GetPortStats returns the listening TCP and UDP ports with the processes
+// that own them and the TCP connections per state
+func GetPortStats() (*PortStats, error) {
+	connections, err := net.Connections("inet")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connections: %w", err)
+	}
+	return portStats(connections, processName), nil
+}
+
+// portStats returns the port stats of connections, with the name of the
+// process of a PID from name
+func portStats(connections []net.ConnectionStat, name func(pid int32) string) *PortStats {
+	stats := &PortStats{
+		Listening:        []ListeningPort{},
+		ConnectionStates: make(map[string]int),
+	}
+
+	// The same port can be listed more than once, like for the workers of a
+	// server that share it
+	seen := make(map[ListeningPort]bool)
+	established := make(map[string]int)
+	names := make(map[int32]string)
+	for _, conn := range connections {
+		protocol := connectionProtocol(conn)
+		if conn.Type == syscall.SOCK_STREAM {
+			stats.ConnectionStates[conn.Status]++
+			if conn.Status == "ESTABLISHED" {
+				established[fmt.Sprintf("%s:%d", protocol, conn.Laddr.Port)]++
+			}
+		}
+
+		listening := (conn.Type == syscall.SOCK_STREAM && conn.Status == "LISTEN") ||
+			(conn.Type == syscall.SOCK_DGRAM && conn.Raddr.Port == 0)
+		if !listening {
+			continue
+		}
+		port := ListeningPort{
+			Protocol: protocol,
+			Address:  conn.Laddr.IP,
+			Port:     conn.Laddr.Port,
+			PID:      conn.Pid,
+		}
+		if seen[port] {
+			continue
+		}
+		seen[port] = true
+
+		if port.PID > 0 {
+			if _, ok := names[port.PID]; !ok {
+				names[port.PID] = name(port.PID)
+			}
+			port.Process = names[port.PID]
+		}
+		stats.Listening = append(stats.Listening, port)
+	}
+
+	for i := range stats.Listening {
+		port := &stats.Listening[i]
+		if port.Protocol == "tcp" || port.Protocol == "tcp6" {
+			port.Connections = established[fmt.Sprintf("%s:%d", port.Protocol, port.Port)]
+		}
+	}
+
+	sort.Slice(stats.Listening, func(i, j int) bool {
+		a, b := stats.Listening[i], stats.Listening[j]
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		if a.Protocol != b.Protocol {
+			return a.Protocol < b.Protocol
+		}
+		return a.Address < b.Address
+	})
+	return stats
+}
+
+// connectionProtocol returns the protocol of a connection: tcp, tcp6, udp or
+// udp6
+func connectionProtocol(conn net.ConnectionStat) string {
+	protocol := "tcp"
+	if conn.Type == syscall.SOCK_DGRAM {
+		protocol = "udp"
+	}
+	if conn.Family == syscall.AF_INET6 {
+		protocol += "6"
+	}
+	return protocol
+}
+
+// processName returns the name of a process, or "" if it is gone
+func processName(pid int32) string {
+	p, err := process.NewProcess(pid)
+	if err != nil {
+		return ""
+	}
+	name, err := p.Name()
+	if err != nil {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+func TestPortStats(t *testing.T) {
+	tcp := func(family uint32, ip string, port uint32, status string, remote uint32, pid int32) net.ConnectionStat {
+		return net.ConnectionStat{Family: family, Type: syscall.SOCK_STREAM, Laddr: net.Addr{IP: ip, Port: port},
+			Raddr: net.Addr{IP: "192.0.2.1", Port: remote}, Status: status, Pid: pid}
+	}
+	udp := func(ip string, port, remote uint32, pid int32) net.ConnectionStat {
+		return net.ConnectionStat{Family: syscall.AF_INET, Type: syscall.SOCK_DGRAM, Laddr: net.Addr{IP: ip, Port: port},
+			Raddr: net.Addr{Port: remote}, Status: "NONE", Pid: pid}
+	}
+	connections := []net.ConnectionStat{
+		tcp(syscall.AF_INET, "0.0.0.0", 80, "LISTEN", 0, 10),
+		// The same socket of another worker of the server
+		tcp(syscall.AF_INET, "0.0.0.0", 80, "LISTEN", 0, 10),
+		tcp(syscall.AF_INET6, "::", 80, "LISTEN", 0, 10),
+		tcp(syscall.AF_INET, "127.0.0.1", 6379, "LISTEN", 0, 20),
+		tcp(syscall.AF_INET, "10.0.0.2", 80, "ESTABLISHED", 50000, 10),
+		tcp(syscall.AF_INET, "10.0.0.2", 80, "ESTABLISHED", 50001, 10),
+		tcp(syscall.AF_INET6, "::1", 80, "ESTABLISHED", 50002, 10),
+		tcp(syscall.AF_INET, "10.0.0.2", 80, "TIME_WAIT", 50003, 0),
+		// A connection of a client is no listening port
+		tcp(syscall.AF_INET, "10.0.0.2", 45000, "ESTABLISHED", 443, 30),
+		// A DNS server, with a process that cannot be seen, and a
+		// connected UDP socket of a client
+		udp("0.0.0.0", 53, 0, 0),
+		udp("10.0.0.2", 40000, 53, 30),
+	}
+	calls := 0
+	name := func(pid int32) string {
+		calls++
+		return map[int32]string{10: "nginx", 20: "redis-server"}[pid]
+	}
+
+	want := &PortStats{
+		Listening: []ListeningPort{
+			{Protocol: "udp", Address: "0.0.0.0", Port: 53},
+			{Protocol: "tcp", Address: "0.0.0.0", Port: 80, PID: 10, Process: "nginx", Connections: 2},
+			{Protocol: "tcp6", Address: "::", Port: 80, PID: 10, Process: "nginx", Connections: 1},
+			{Protocol: "tcp", Address: "127.0.0.1", Port: 6379, PID: 20, Process: "redis-server"},
+		},
+		ConnectionStates: map[string]int{"LISTEN": 4, "ESTABLISHED": 4, "TIME_WAIT": 1},
+	}
+	if got := portStats(connections, name); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if calls != 2 {
+		t.Errorf("Expected the name of every process to be looked up once, got %d lookups", calls)
+	}
+
+	empty := portStats(nil, name)
+	if empty.Listening == nil || len(empty.Listening) != 0 || len(empty.ConnectionStates) != 0 {
+		t.Errorf("Expected no ports, got %+v", empty)
+	}
+}
+
+func TestConnectionProtocol(t *testing.T) {
+	tests := []struct {
+		family, kind uint32
+		want         string
+	}{
+		{syscall.AF_INET, syscall.SOCK_STREAM, "tcp"},
+		{syscall.AF_INET6, syscall.SOCK_STREAM, "tcp6"},
+		{syscall.AF_INET, syscall.SOCK_DGRAM, "udp"},
+		{syscall.AF_INET6, syscall.SOCK_DGRAM, "udp6"},
+	}
+	for _, test := range tests {
+		if got := connectionProtocol(net.ConnectionStat{Family: test.family, Type: test.kind}); got != test.want {
+			t.Errorf("Expected %s, got %s", test.want, got)
+		}
+	}
+}
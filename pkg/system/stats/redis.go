@@ -0,0 +1,33 @@
+package stats
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GetRedisInfo returns the fields reported by INFO of the Redis server the
+// stats are cached in, e.g. "connected_clients" or "used_memory". With the
+// embedded redisserver these are the same numbers as its metrics.
+func (sm *StatsManager) GetRedisInfo() (map[string]string, error) {
+	info, err := sm.redisClient.Info(sm.ctx).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %w", err)
+	}
+	return parseRedisInfo(info), nil
+}
+
+// parseRedisInfo parses the "name:value" lines of INFO, skipping the
+// section headers
+func parseRedisInfo(info string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok {
+			fields[name] = value
+		}
+	}
+	return fields
+}
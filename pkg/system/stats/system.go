@@ -7,15 +7,31 @@ import (
 	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/mem"
-	"github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
 )
 
-// SystemInfo contains information about the system's CPU and memory
+// SystemInfo contains information about the system's host, CPU, memory,
+// network and load
 type SystemInfo struct {
+	Host    HostInfo    `json:"host"`
 	CPU     CPUInfo     `json:"cpu"`
 	Memory  MemoryInfo  `json:"memory"`
 	Network NetworkInfo `json:"network"`
+	Load    LoadInfo    `json:"load"`
+}
+
+// HostInfo contains information about the host, with the time it booted as a
+// Unix timestamp and how long it runs in seconds
+type HostInfo struct {
+	Hostname        string `json:"hostname"`
+	OS              string `json:"os"`
+	Platform        string `json:"platform"`
+	PlatformVersion string `json:"platform_version"`
+	KernelVersion   string `json:"kernel_version"`
+	KernelArch      string `json:"kernel_arch"`
+	BootTime        uint64 `json:"boot_time"`
+	Uptime          uint64 `json:"uptime_seconds"`
 }
 
 // CPUInfo contains information about the CPU
@@ -25,26 +41,49 @@ type CPUInfo struct {
 	UsagePercent float64 `json:"usage_percent"`
 }
 
-// MemoryInfo contains information about the system memory
+// MemoryInfo contains information about the system memory in GB. Available
+// is the memory programs can still get, which is more than Free as it
+// includes Cached and Buffers. Cgroup is the memory of the cgroup of this
+// process on Linux, or nil.
 type MemoryInfo struct {
-	Total       float64 `json:"total_gb"`
-	Used        float64 `json:"used_gb"`
-	Free        float64 `json:"free_gb"`
-	UsedPercent float64 `json:"used_percent"`
+	Total       float64           `json:"total_gb"`
+	Used        float64           `json:"used_gb"`
+	Free        float64           `json:"free_gb"`
+	UsedPercent float64           `json:"used_percent"`
+	Available   float64           `json:"available_gb"`
+	Cached      float64           `json:"cached_gb"`
+	Buffers     float64           `json:"buffers_gb"`
+	Shared      float64           `json:"shared_gb"`
+	Swap        SwapInfo          `json:"swap"`
+	Cgroup      *CgroupMemoryInfo `json:"cgroup,omitempty"`
 }
 
-// NetworkInfo contains information about network usage
+// NetworkInfo contains information about network usage, with the bytes
+// sent and received by all interfaces since boot
 type NetworkInfo struct {
-	UploadSpeed   string `json:"upload_speed"`
-	DownloadSpeed string `json:"download_speed"`
-	BytesSent     uint64 `json:"bytes_sent"`
-	BytesReceived uint64 `json:"bytes_received"`
+	UploadSpeed   string  `json:"upload_speed"`
+	DownloadSpeed string  `json:"download_speed"`
+	UploadMbps    float64 `json:"upload_mbps"`
+	DownloadMbps  float64 `json:"download_mbps"`
+	BytesSent     uint64  `json:"bytes_sent"`
+	BytesReceived uint64  `json:"bytes_received"`
+}
+
+// LoadInfo contains the load averages of the system over 1, 5 and 15
+// minutes
+type LoadInfo struct {
+	Load1  float64 `json:"load1"`
+	Load5  float64 `json:"load5"`
+	Load15 float64 `json:"load15"`
 }
 
-// NetworkSpeedResult contains the upload and download speeds
+// NetworkSpeedResult contains the upload and download speeds, formatted and
+// in Mbps
 type NetworkSpeedResult struct {
-	UploadSpeed   string `json:"upload_speed"`
-	DownloadSpeed string `json:"download_speed"`
+	UploadSpeed   string  `json:"upload_speed"`
+	DownloadSpeed string  `json:"download_speed"`
+	UploadMbps    float64 `json:"upload_mbps"`
+	DownloadMbps  float64 `json:"download_mbps"`
 }
 
 // UptimeProvider defines an interface for getting system uptime
@@ -73,81 +112,111 @@ func GetSystemInfo() (*SystemInfo, error) {
 	}
 	
 	// Get memory info
-	memInfo := MemoryInfo{}
-	virtualMem, err := mem.VirtualMemory()
-	if err == nil {
-		memInfo.Total = float64(virtualMem.Total) / (1024 * 1024 * 1024) // Convert to GB
-		memInfo.Used = float64(virtualMem.Used) / (1024 * 1024 * 1024)
-		memInfo.Free = float64(virtualMem.Free) / (1024 * 1024 * 1024)
-		memInfo.UsedPercent = math.Round(virtualMem.UsedPercent*10) / 10
-	}
+	memInfo := getMemoryInfo()
 	
 	// Get network speed
-	uploadSpeed, downloadSpeed := GetNetworkSpeed()
+	netInfo := NetworkInfo{
+		UploadSpeed:   "Unknown",
+		DownloadSpeed: "Unknown",
+	}
+	if netStats, err := GetNetworkStats(); err == nil {
+		netInfo = NetworkInfo{
+			UploadSpeed:   netStats.UploadSpeed,
+			DownloadSpeed: netStats.DownloadSpeed,
+			UploadMbps:    netStats.UploadMbps,
+			DownloadMbps:  netStats.DownloadMbps,
+			BytesSent:     netStats.Total.BytesSent,
+			BytesReceived: netStats.Total.BytesReceived,
+		}
+	}
+	
+	// Get host and load info
+	hostInfo := HostInfo{}
+	if info, err := GetHostInfo(); err == nil {
+		hostInfo = *info
+	}
+	loadInfo := LoadInfo{}
+	if info, err := GetLoadInfo(); err == nil {
+		loadInfo = *info
+	}
 	
 	// Create and return the system info
 	return &SystemInfo{
-		CPU:    cpuInfo,
-		Memory: memInfo,
-		Network: NetworkInfo{
-			UploadSpeed:   uploadSpeed,
-			DownloadSpeed: downloadSpeed,
-		},
+		Host:    hostInfo,
+		CPU:     cpuInfo,
+		Memory:  memInfo,
+		Network: netInfo,
+		Load:    loadInfo,
 	}, nil
 }
 
-// GetNetworkSpeed returns the current network speed in Mbps
-func GetNetworkSpeed() (string, string) {
-	networkUpSpeed := "Unknown"
-	networkDownSpeed := "Unknown"
-
-	// Get initial network counters
-	countersStart, err := net.IOCounters(false)
-	if err != nil || len(countersStart) == 0 {
-		return networkUpSpeed, networkDownSpeed
-	}
-
-	// Wait a short time to measure the difference
-	time.Sleep(500 * time.Millisecond)
-
-	// Get updated network counters
-	countersEnd, err := net.IOCounters(false)
-	if err != nil || len(countersEnd) == 0 {
-		return networkUpSpeed, networkDownSpeed
+// GetHostInfo returns the hostname, OS, kernel, boot time and uptime of the
+// host
+func GetHostInfo() (*HostInfo, error) {
+	info, err := host.Info()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host info: %w", err)
 	}
 
-	// Calculate the difference in bytes
-	bytesSent := countersEnd[0].BytesSent - countersStart[0].BytesSent
-	bytesRecv := countersEnd[0].BytesRecv - countersStart[0].BytesRecv
+	return &HostInfo{
+		Hostname:        info.Hostname,
+		OS:              info.OS,
+		Platform:        info.Platform,
+		PlatformVersion: info.PlatformVersion,
+		KernelVersion:   info.KernelVersion,
+		KernelArch:      info.KernelArch,
+		BootTime:        info.BootTime,
+		Uptime:          info.Uptime,
+	}, nil
+}
 
-	// Convert to Mbps (megabits per second)
-	// 500ms = 0.5s, so multiply by 2 to get per second
-	// Then convert bytes to bits (*8) and to megabits (/1024/1024)
-	mbpsSent := float64(bytesSent) * 2 * 8 / 1024 / 1024
-	mbpsRecv := float64(bytesRecv) * 2 * 8 / 1024 / 1024
+// FormatUptime formats an uptime in seconds as days, hours and minutes
+func FormatUptime(seconds uint64) string {
+	days := seconds / (60 * 60 * 24)
+	hours := (seconds % (60 * 60 * 24)) / (60 * 60)
+	minutes := (seconds % (60 * 60)) / 60
+	return fmt.Sprintf("%d days, %d hours, %d minutes", days, hours, minutes)
+}
 
-	// Format the speeds with appropriate units
-	if mbpsSent < 1 {
-		networkUpSpeed = fmt.Sprintf("%.1f Kbps", mbpsSent*1024)
-	} else {
-		networkUpSpeed = fmt.Sprintf("%.1f Mbps", mbpsSent)
+// GetLoadInfo returns the load averages of the system
+func GetLoadInfo() (*LoadInfo, error) {
+	avg, err := load.Avg()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get load averages: %w", err)
 	}
 
-	if mbpsRecv < 1 {
-		networkDownSpeed = fmt.Sprintf("%.1f Kbps", mbpsRecv*1024)
-	} else {
-		networkDownSpeed = fmt.Sprintf("%.1f Mbps", mbpsRecv)
+	return &LoadInfo{
+		Load1:  avg.Load1,
+		Load5:  avg.Load5,
+		Load15: avg.Load15,
+	}, nil
+}
+
+// GetNetworkSpeed returns the current network speed, formatted in Kbps or
+// Mbps
+func GetNetworkSpeed() (string, string) {
+	netStats, err := GetNetworkStats()
+	if err != nil {
+		return "Unknown", "Unknown"
 	}
 
-	return networkUpSpeed, networkDownSpeed
+	return netStats.UploadSpeed, netStats.DownloadSpeed
 }
 
 // GetNetworkSpeedResult returns the network speed as a struct
 func GetNetworkSpeedResult() NetworkSpeedResult {
-	uploadSpeed, downloadSpeed := GetNetworkSpeed()
+	netStats, err := GetNetworkStats()
+	if err != nil {
+		return NetworkSpeedResult{
+			UploadSpeed:   "Unknown",
+			DownloadSpeed: "Unknown",
+		}
+	}
 	return NetworkSpeedResult{
-		UploadSpeed:   uploadSpeed,
-		DownloadSpeed: downloadSpeed,
+		UploadSpeed:   netStats.UploadSpeed,
+		DownloadSpeed: netStats.DownloadSpeed,
+		UploadMbps:    netStats.UploadMbps,
+		DownloadMbps:  netStats.DownloadMbps,
 	}
 }
 
@@ -168,7 +237,7 @@ func GetFormattedMemoryInfo() string {
 		return "Unknown"
 	}
 	
-	return fmt.Sprintf("%.1fGB (%.1fGB used)", sysInfo.Memory.Total, sysInfo.Memory.Used)
+	return formatMemoryInfo(sysInfo.Memory)
 }
 
 // GetFormattedNetworkInfo returns a formatted string with network information
@@ -189,6 +258,7 @@ func GetHardwareStats() map[string]interface{} {
 		"memory":  GetFormattedMemoryInfo(),
 		"disk":    GetFormattedDiskInfo(),
 		"network": GetFormattedNetworkInfo(),
+		"gpu":     GetFormattedGPUInfo(),
 	}
 	
 	return hardwareStats
@@ -212,6 +282,12 @@ func GetHardwareStatsJSON() map[string]interface{} {
 		}
 	}
 	
+	// GPUs are optional, so without them the list is empty
+	gpus := []GPUInfo{}
+	if gpuStats, err := GetGPUStats(); err == nil {
+		gpus = gpuStats.GPUs
+	}
+	
 	// Create the hardware stats map
 	hardwareStats := map[string]interface{}{
 		"cpu": map[string]interface{}{
@@ -224,6 +300,12 @@ func GetHardwareStatsJSON() map[string]interface{} {
 			"used_gb":      sysInfo.Memory.Used,
 			"free_gb":      sysInfo.Memory.Free,
 			"used_percent": sysInfo.Memory.UsedPercent,
+			"available_gb": sysInfo.Memory.Available,
+			"cached_gb":    sysInfo.Memory.Cached,
+			"buffers_gb":   sysInfo.Memory.Buffers,
+			"shared_gb":    sysInfo.Memory.Shared,
+			"swap":         sysInfo.Memory.Swap,
+			"cgroup":       sysInfo.Memory.Cgroup,
 		},
 		"disk": map[string]interface{}{
 			"total_gb":     diskInfo.Total,
@@ -234,7 +316,14 @@ func GetHardwareStatsJSON() map[string]interface{} {
 		"network": map[string]interface{}{
 			"upload_speed":   sysInfo.Network.UploadSpeed,
 			"download_speed": sysInfo.Network.DownloadSpeed,
+			"upload_mbps":    sysInfo.Network.UploadMbps,
+			"download_mbps":  sysInfo.Network.DownloadMbps,
+			"bytes_sent":     sysInfo.Network.BytesSent,
+			"bytes_received": sysInfo.Network.BytesReceived,
 		},
+		"gpu":  gpus,
+		"host": sysInfo.Host,
+		"load": sysInfo.Load,
 	}
 	
 	return hardwareStats
@@ -0,0 +1,57 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatUptime(t *testing.T) {
+	tests := []struct {
+		seconds uint64
+		want    string
+	}{
+		{0, "0 days, 0 hours, 0 minutes"},
+		{59, "0 days, 0 hours, 0 minutes"},
+		{3*60*60 + 25*60, "0 days, 3 hours, 25 minutes"},
+		{2*24*60*60 + 60*60 + 60, "2 days, 1 hours, 1 minutes"},
+		{400 * 24 * 60 * 60, "400 days, 0 hours, 0 minutes"},
+	}
+	for _, test := range tests {
+		if got := FormatUptime(test.seconds); got != test.want {
+			t.Errorf("%d: expected %q, got %q", test.seconds, test.want, got)
+		}
+	}
+}
+
+func TestUptimeAlert(t *testing.T) {
+	sm := newAlertTestManager()
+	bootTime := uint64(time.Now().Add(-5 * time.Minute).Unix())
+	sm.collectors["system"].fetch = func() (interface{}, error) {
+		return &SystemInfo{Host: HostInfo{BootTime: bootTime}}, nil
+	}
+	rebooted := AlertRule{Name: "rebooted", Metric: "uptime", Operator: "<", Threshold: 600}
+	if err := sm.AddAlertRule(rebooted); err != nil {
+		t.Fatalf("Failed to add rule: %v", err)
+	}
+
+	// The uptime counts from the boot time, not from when the stats were
+	// cached
+	sm.EvaluateAlerts()
+	alerts := sm.Alerts()
+	if len(alerts) != 1 || alerts[0].State != AlertFiring || alerts[0].Value < 300 || alerts[0].Value > 310 {
+		t.Fatalf("Expected the reboot to be alerted with an uptime of 300s, got %+v", alerts)
+	}
+
+	// An alert keeps its state while the boot time is not known
+	bootTime = 0
+	sm.EvaluateAlerts()
+	if alerts := sm.Alerts(); len(alerts) != 1 || alerts[0].State != AlertFiring {
+		t.Errorf("Expected the alert to keep firing, got %+v", alerts)
+	}
+
+	bootTime = uint64(time.Now().Add(-time.Hour).Unix())
+	sm.EvaluateAlerts()
+	if alerts := sm.Alerts(); len(alerts) != 1 || alerts[0].State != AlertResolved {
+		t.Errorf("Expected the alert to be resolved, got %+v", alerts)
+	}
+}
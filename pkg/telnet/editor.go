@@ -0,0 +1,418 @@
+package telnet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxHistory is the number of lines that the history of an Editor keeps
+const MaxHistory = 1000
+
+// ErrInterrupt is returned by ReadLine when Ctrl+C is pressed
+var ErrInterrupt = errors.New("interrupted")
+
+// CompleteFunc returns the completions of the text of a line up to the
+// cursor, and the byte offset in the text of the word that they replace
+type CompleteFunc func(text string) (candidates []string, start int)
+
+// Editor reads the lines that are typed on a terminal whose keys it reads as
+// they are typed, like a telnet client in character mode, an SSH session
+// with a terminal or a terminal in raw mode. It echoes the line and lets it
+// be edited with the arrow keys and the keys of readline: Ctrl+A/E/B/F and
+// Alt+B/F move the cursor, Ctrl+K/U/W and backspace delete, the arrows up
+// and down or Ctrl+P/N browse the history, Ctrl+R searches it and Ctrl+L
+// clears the screen. Newlines are written as \n.
+type Editor struct {
+	// Complete completes the word before the cursor when Tab is pressed, if
+	// it is set
+	Complete CompleteFunc
+	// Width returns the number of columns of the terminal, in which the
+	// completions are listed; 80 are used if it is nil
+	Width func() int
+
+	in      *bufio.Reader
+	out     io.Writer
+	history []string
+	// cr is set after a carriage return, which terminals can follow with a
+	// newline that is not a line of itself
+	cr bool
+}
+
+// NewEditor creates an editor of the lines read from in, which it echoes to
+// out
+func NewEditor(in io.Reader, out io.Writer) *Editor {
+	return &Editor{in: bufio.NewReader(in), out: out}
+}
+
+// History returns the lines that were entered, the oldest first
+func (e *Editor) History() []string {
+	return e.history
+}
+
+// SetHistory replaces the history, like with the lines of a history file
+func (e *Editor) SetHistory(history []string) {
+	if len(history) > MaxHistory {
+		history = history[len(history)-MaxHistory:]
+	}
+	e.history = history
+}
+
+// AddHistory adds a line to the history, unless it is empty or the last
+// line of the history. The history keeps the last MaxHistory lines.
+func (e *Editor) AddHistory(line string) {
+	if strings.TrimSpace(line) == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return
+	}
+	e.SetHistory(append(e.history, line))
+}
+
+// lineState is the line that is being edited
+type lineState struct {
+	prompt string
+	line   []rune
+	pos    int
+	// historyPos is the line of the history that is shown, len(history)
+	// for the line that was entered before browsing the history, which is
+	// kept in edited
+	historyPos int
+	edited     []rune
+	// searching is set while the history is searched for query, found is
+	// the line of the history that was found and failed is set if the query
+	// was not found
+	searching bool
+	query     []rune
+	found     int
+	failed    bool
+	// before is the line before the search, which Ctrl+G restores
+	before    []rune
+	beforePos int
+}
+
+// ReadLine reads a line after showing the prompt. It returns io.EOF for
+// Ctrl+D on an empty line or the end of the input, and ErrInterrupt for
+// Ctrl+C.
+func (e *Editor) ReadLine(prompt string) (string, error) {
+	s := &lineState{prompt: prompt, historyPos: len(e.history)}
+	lastTab := false
+
+	e.redraw(s)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			fmt.Fprint(e.out, "\n")
+			return "", err
+		}
+		// Terminals can send a carriage return and a newline for Enter
+		cr := e.cr
+		e.cr = r == '\r'
+		if r == '\n' && cr {
+			continue
+		}
+
+		if s.searching && e.searchKey(s, r) {
+			e.redraw(s)
+			continue
+		}
+
+		tab := false
+		switch r {
+		case '\r', '\n':
+			// The newline after a carriage return is skipped now if it was
+			// sent with it, so it is not read after the editor
+			if r == '\r' && e.in.Buffered() > 0 {
+				if next, _ := e.in.Peek(1); next[0] == '\n' {
+					e.in.ReadByte()
+					e.cr = false
+				}
+			}
+			fmt.Fprint(e.out, "\n")
+			return string(s.line), nil
+		case 3: // Ctrl+C
+			fmt.Fprint(e.out, "^C\n")
+			return "", ErrInterrupt
+		case 4: // Ctrl+D
+			if len(s.line) == 0 {
+				fmt.Fprint(e.out, "\n")
+				return "", io.EOF
+			}
+			if s.pos < len(s.line) {
+				s.line = append(s.line[:s.pos], s.line[s.pos+1:]...)
+			}
+		case 127, 8: // Backspace
+			if s.pos > 0 {
+				s.line = append(s.line[:s.pos-1], s.line[s.pos:]...)
+				s.pos--
+			}
+		case 1: // Ctrl+A
+			s.pos = 0
+		case 5: // Ctrl+E
+			s.pos = len(s.line)
+		case 2: // Ctrl+B
+			if s.pos > 0 {
+				s.pos--
+			}
+		case 6: // Ctrl+F
+			if s.pos < len(s.line) {
+				s.pos++
+			}
+		case 11: // Ctrl+K
+			s.line = s.line[:s.pos]
+		case 21: // Ctrl+U
+			s.line = append([]rune{}, s.line[s.pos:]...)
+			s.pos = 0
+		case 23: // Ctrl+W
+			start := wordStart(s.line, s.pos)
+			s.line = append(s.line[:start], s.line[s.pos:]...)
+			s.pos = start
+		case 12: // Ctrl+L
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case 16: // Ctrl+P
+			e.showHistory(s, s.historyPos-1)
+		case 14: // Ctrl+N
+			e.showHistory(s, s.historyPos+1)
+		case 18: // Ctrl+R
+			s.searching, s.query, s.found, s.failed = true, nil, len(e.history), false
+			s.before, s.beforePos = s.line, s.pos
+		case '\t':
+			tab = true
+			s.line, s.pos = e.completeLine(s.line, s.pos, lastTab)
+		case 27: // Escape sequences of the arrow, home, end and delete keys
+			switch e.readEscape() {
+			case "A":
+				e.showHistory(s, s.historyPos-1)
+			case "B":
+				e.showHistory(s, s.historyPos+1)
+			case "C":
+				if s.pos < len(s.line) {
+					s.pos++
+				}
+			case "D":
+				if s.pos > 0 {
+					s.pos--
+				}
+			case "H", "1~", "7~":
+				s.pos = 0
+			case "F", "4~", "8~":
+				s.pos = len(s.line)
+			case "3~":
+				if s.pos < len(s.line) {
+					s.line = append(s.line[:s.pos], s.line[s.pos+1:]...)
+				}
+			case "M-b", "1;5D", "1;3D":
+				s.pos = wordStart(s.line, s.pos)
+			case "M-f", "1;5C", "1;3C":
+				s.pos = wordEnd(s.line, s.pos)
+			}
+		default:
+			if unicode.IsPrint(r) {
+				s.line = append(s.line[:s.pos], append([]rune{r}, s.line[s.pos:]...)...)
+				s.pos++
+			}
+		}
+		lastTab = tab
+		e.redraw(s)
+	}
+}
+
+// redraw draws the prompt and the line, or the search, and puts the cursor
+// where it is on the line
+func (e *Editor) redraw(s *lineState) {
+	prompt := s.prompt
+	if s.searching {
+		prompt = "(reverse-i-search)`" + string(s.query) + "': "
+		if s.failed {
+			prompt = "(failed " + prompt[1:]
+		}
+	}
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(s.line))
+	if back := len(s.line) - s.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+// showHistory shows a line of the history, or the line that was entered
+// before browsing the history after its last line
+func (e *Editor) showHistory(s *lineState, i int) {
+	if i < 0 || i > len(e.history) {
+		return
+	}
+	if s.historyPos == len(e.history) {
+		s.edited = s.line
+	}
+	s.historyPos = i
+	if i == len(e.history) {
+		s.line = s.edited
+	} else {
+		s.line = []rune(e.history[i])
+	}
+	s.pos = len(s.line)
+}
+
+// searchKey handles a key while the history is searched, and reports
+// whether it did. Typed text is added to the query, which is searched in
+// older lines, Ctrl+R searches the next older line and Ctrl+G cancels the
+// search. Other keys end the search with the line that was found, which they
+// then edit, or run for Enter.
+func (e *Editor) searchKey(s *lineState, r rune) bool {
+	switch {
+	case r == 18: // Ctrl+R
+		if len(s.query) > 0 {
+			e.search(s, s.found-1)
+		}
+	case r == 7: // Ctrl+G
+		s.searching = false
+		s.line, s.pos = s.before, s.beforePos
+	case r == 127 || r == 8:
+		if len(s.query) > 0 {
+			s.query = s.query[:len(s.query)-1]
+		}
+		if len(s.query) > 0 {
+			e.search(s, len(e.history)-1)
+		} else {
+			s.line, s.pos, s.found, s.failed = s.before, s.beforePos, len(e.history), false
+		}
+	case unicode.IsPrint(r):
+		s.query = append(s.query, r)
+		e.search(s, min(s.found, len(e.history)-1))
+	default:
+		s.searching = false
+		if s.found < len(e.history) {
+			if s.historyPos == len(e.history) {
+				s.edited = s.before
+			}
+			s.historyPos = s.found
+		}
+		return false
+	}
+	return true
+}
+
+// search finds the query in the history from the line from back to the
+// oldest, and shows the line that has it with the cursor at the query
+func (e *Editor) search(s *lineState, from int) {
+	query := string(s.query)
+	for i := from; i >= 0; i-- {
+		if index := strings.LastIndex(e.history[i], query); index >= 0 {
+			s.found, s.failed = i, false
+			s.line = []rune(e.history[i])
+			s.pos = utf8.RuneCountInString(e.history[i][:index])
+			return
+		}
+	}
+	s.failed = true
+	fmt.Fprint(e.out, "\a")
+}
+
+// readEscape reads the rest of an escape sequence after the escape, like
+// [A for arrow up, [3~ for delete or [1;5C for Ctrl+arrow right, and returns
+// it without the [ or O. Alt with a key is returned as M- and the key.
+func (e *Editor) readEscape() string {
+	r, _, err := e.in.ReadRune()
+	if err != nil {
+		return ""
+	}
+	if r != '[' && r != 'O' {
+		return "M-" + string(r)
+	}
+	var seq strings.Builder
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return ""
+		}
+		seq.WriteRune(r)
+		if (r < '0' || r > '9') && r != ';' {
+			return seq.String()
+		}
+	}
+}
+
+// completeLine completes the word before the cursor with the longest common
+// prefix of its completions. When the word has that prefix already, a second
+// tab lists the completions.
+func (e *Editor) completeLine(line []rune, pos int, lastTab bool) ([]rune, int) {
+	if e.Complete == nil {
+		return line, pos
+	}
+	text := string(line[:pos])
+	candidates, start := e.Complete(text)
+	if len(candidates) == 0 {
+		fmt.Fprint(e.out, "\a")
+		return line, pos
+	}
+
+	// The completions can write the word differently, like vm as !!vm.
+	if prefix := commonPrefix(candidates); !strings.HasPrefix(text[start:], prefix) {
+		completed := []rune(text[:start] + prefix)
+		return append(completed, line[pos:]...), len(completed)
+	}
+	if !lastTab {
+		fmt.Fprint(e.out, "\a")
+		return line, pos
+	}
+
+	width := 80
+	if e.Width != nil {
+		width = e.Width()
+	}
+	fmt.Fprint(e.out, "\n"+columns(candidates, width))
+	return line, pos
+}
+
+// wordStart returns the start of the word before the cursor
+func wordStart(line []rune, pos int) int {
+	for pos > 0 && unicode.IsSpace(line[pos-1]) {
+		pos--
+	}
+	for pos > 0 && !unicode.IsSpace(line[pos-1]) {
+		pos--
+	}
+	return pos
+}
+
+// wordEnd returns the end of the word after the cursor
+func wordEnd(line []rune, pos int) int {
+	for pos < len(line) && unicode.IsSpace(line[pos]) {
+		pos++
+	}
+	for pos < len(line) && !unicode.IsSpace(line[pos]) {
+		pos++
+	}
+	return pos
+}
+
+// commonPrefix returns the longest common prefix of strings
+func commonPrefix(values []string) string {
+	prefix := values[0]
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+	return prefix
+}
+
+// columns lays out values in columns that fit the width, a line per row
+func columns(values []string, width int) string {
+	longest := 0
+	for _, value := range values {
+		longest = max(longest, len(value))
+	}
+	perRow := max(1, width/(longest+2))
+	var out strings.Builder
+	for i, value := range values {
+		out.WriteString(value)
+		if (i+1)%perRow == 0 || i == len(values)-1 {
+			out.WriteString("\n")
+		} else {
+			out.WriteString(strings.Repeat(" ", longest+2-len(value)))
+		}
+	}
+	return out.String()
+}
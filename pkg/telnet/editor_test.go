@@ -0,0 +1,147 @@
+package telnet
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// readLines reads the lines of the typed input with an editor, until its
+// end, and returns them with the output
+func readLines(t *testing.T, editor *Editor, input string) ([]string, string) {
+	t.Helper()
+	var out bytes.Buffer
+	editor.in.Reset(strings.NewReader(input))
+	editor.out = &out
+	var lines []string
+	for {
+		line, err := editor.ReadLine("> ")
+		if err == io.EOF {
+			return lines, out.String()
+		}
+		if err == ErrInterrupt {
+			line = "^C"
+		} else if err != nil {
+			t.Fatalf("ReadLine failed: %v", err)
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestEditing(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"plain", "!!vm.list\r", "!!vm.list"},
+		{"backspace over multi-byte runes", "café\x7f\x7fé\r", "caé"},
+		{"cursor and insert", "!!vm.lst\x1b[D\x1b[Di\r", "!!vm.list"},
+		{"home and end", "vm.list\x01!!\x05 name:a\r", "!!vm.list name:a"},
+		{"delete", "!!vmx.list\x1b[H\x1b[C\x1b[C\x1b[C\x1b[C\x1b[3~\r", "!!vm.list"},
+		{"kill to end", "!!vm.list name:a\x1b[D\x1b[D\x1b[D\x1b[D\x1b[D\x1b[D\x1b[D\x0b\r", "!!vm.list"},
+		{"kill to start", "wrong !!vm.list\x01\x1b[1;5C\x1b[C\x15\r", "!!vm.list"},
+		{"delete word", "!!vm.list name:a\x17\r", "!!vm.list "},
+		{"word moves", "b c\x1bb\x1bba \x1bf\x1bf d\r", "a b c d"},
+		{"control keys are ignored", "!!vm\x00.list\r", "!!vm.list"},
+		{"carriage return and newline", "one\r\ntwo\n\nthree\r", "one|two||three"},
+		{"interrupt", "half\x03whole\r", "^C|whole"},
+		{"end of input", "last", ""},
+		{"Ctrl+D deletes on a line", "xlast\x01\x04\r\x04", "last"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines, _ := readLines(t, NewEditor(nil, nil), test.input)
+			if got := strings.Join(lines, "|"); got != test.want {
+				t.Errorf("Expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestEcho(t *testing.T) {
+	_, out := readLines(t, NewEditor(nil, nil), "ab\x1b[D\x0c\r")
+	expected := "\r> \x1b[K\r> a\x1b[K\r> ab\x1b[K\r> ab\x1b[K\x1b[1D\x1b[H\x1b[2J\r> ab\x1b[K\x1b[1D\n\r> \x1b[K\n"
+	if out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	editor := NewEditor(nil, nil)
+	editor.SetHistory([]string{"!!vm.list", "!!vm.start name:a", "!!vm.stop name:a"})
+	editor.AddHistory("!!vm.stop name:a")
+	editor.AddHistory(" ")
+	if len(editor.History()) != 3 {
+		t.Fatalf("Expected duplicates and empty lines to be left out, got %q", editor.History())
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"up", "\x1b[A\r", "!!vm.stop name:a"},
+		{"up twice and down", "\x1b[A\x1b[A\x1b[A\x1b[B\r", "!!vm.start name:a"},
+		{"past the oldest", "\x10\x10\x10\x10\x10\r", "!!vm.list"},
+		{"back to the edited line", "!!job\x1b[A\x1b[A\x0e\x0e\r", "!!job"},
+		{"search", "\x12start\r", "!!vm.start name:a"},
+		{"search older", "\x12name\x12\r", "!!vm.start name:a"},
+		{"search and edit", "\x12list\x05 format:json\r", "!!vm.list format:json"},
+		{"search backspace", "\x12stopx\x7f\x7f\x7f\x7f\r", "!!vm.stop name:a"},
+		{"search not found", "\x12nothing\r", "!!vm.stop name:a"},
+		{"search cancelled", "!!job\x12list\x07.list\r", "!!job.list"},
+		{"history after search", "\x12list\x1b[B\r", "!!vm.start name:a"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lines, _ := readLines(t, editor, test.input)
+			if len(lines) != 1 || lines[0] != test.want {
+				t.Errorf("Expected %q, got %q", test.want, lines)
+			}
+		})
+	}
+
+	editor.SetHistory(make([]string, MaxHistory+10))
+	if len(editor.History()) != MaxHistory {
+		t.Errorf("Expected the history to keep %d lines, got %d", MaxHistory, len(editor.History()))
+	}
+}
+
+func TestSearchPrompt(t *testing.T) {
+	editor := NewEditor(nil, nil)
+	editor.SetHistory([]string{"!!vm.list"})
+	_, out := readLines(t, editor, "\x12vmx\x07\r")
+	for _, expected := range []string{
+		"\r(reverse-i-search)`vm': !!vm.list\x1b[K\x1b[7D",
+		"\a\r(failed reverse-i-search)`vmx': !!vm.list\x1b[K",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in %q", expected, out)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+	editor := NewEditor(nil, nil)
+	editor.Complete = func(text string) ([]string, int) {
+		start := strings.LastIndex(text, " ") + 1
+		var candidates []string
+		for _, candidate := range []string{"!!vm.list ", "!!vm.start ", "!!vm.stop "} {
+			if strings.HasPrefix(candidate, text[start:]) {
+				candidates = append(candidates, candidate)
+			}
+		}
+		return candidates, start
+	}
+	editor.Width = func() int { return 30 }
+
+	lines, out := readLines(t, editor, "!!vm.l\t\r!!vm.s\t\ta\t\r!!vm.x\t\r")
+	if got := strings.Join(lines, "|"); got != "!!vm.list |!!vm.start |!!vm.x" {
+		t.Errorf("Expected completed lines, got %q", got)
+	}
+	if !strings.Contains(out, "\r> !!vm.st\x1b[K\n!!vm.start   !!vm.stop \n\r> !!vm.st\x1b[K") {
+		t.Errorf("Expected the completions in columns, got %q", out)
+	}
+}
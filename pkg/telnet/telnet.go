@@ -0,0 +1,148 @@
+// Package telnet reads the input of interactive telnet and SSH sessions. It
+// filters the commands of the telnet protocol from the input of a connection
+// and switches telnet clients to character mode, in which an Editor reads
+// the lines that are typed with line editing, history and search.
+package telnet
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// Bytes of the telnet protocol
+const (
+	se   = 240 // end of subnegotiation
+	sb   = 250 // start of subnegotiation
+	will = 251
+	wont = 252
+	do   = 253
+	dont = 254
+	iac  = 255 // interpret as command
+
+	optionEcho            = 1
+	optionSuppressGoAhead = 3
+)
+
+// reader filters the commands of the telnet protocol from the input of a
+// connection
+type reader struct {
+	in *bufio.Reader
+	// cr is set after a carriage return, which clients can follow with a
+	// NUL that is not part of the input
+	cr bool
+}
+
+// NewReader returns a reader of the input of a telnet connection without
+// the commands of the protocol, like the answers of the client to
+// CharacterMode. Input without commands is read as it is, as no valid UTF-8
+// text has the byte that starts them.
+func NewReader(in io.Reader) io.Reader {
+	return &reader{in: bufio.NewReader(in)}
+}
+
+// Read reads the input without the commands of the protocol
+func (r *reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		// Return what was read when more input would have to be waited for
+		if n > 0 && r.in.Buffered() == 0 {
+			break
+		}
+		b, err := r.in.ReadByte()
+		if err != nil {
+			if n > 0 {
+				break
+			}
+			return 0, err
+		}
+		cr := r.cr
+		r.cr = b == '\r'
+		switch {
+		case b == 0 && cr:
+			// CR NUL is a carriage return
+		case b == iac:
+			escaped, err := r.skipCommand()
+			if err != nil {
+				return n, err
+			}
+			if escaped {
+				p[n] = iac
+				n++
+			}
+		default:
+			p[n] = b
+			n++
+		}
+	}
+	return n, nil
+}
+
+// skipCommand skips a command after its IAC, and reports whether it was an
+// escaped IAC byte of the input instead
+func (r *reader) skipCommand() (bool, error) {
+	command, err := r.in.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	switch command {
+	case iac:
+		return true, nil
+	case will, wont, do, dont:
+		_, err = r.in.ReadByte()
+	case sb:
+		// Subnegotiations end with IAC SE
+		var previous byte
+		for {
+			b, err := r.in.ReadByte()
+			if err != nil {
+				return false, err
+			}
+			if previous == iac && b == se {
+				break
+			}
+			if previous == iac && b == iac {
+				b = 0
+			}
+			previous = b
+		}
+	}
+	return false, err
+}
+
+// writer writes to a telnet client in character mode
+type writer struct {
+	out io.Writer
+}
+
+// NewWriter returns a writer to a telnet client in character mode, which
+// writes newlines as CRLF, as the terminal of the client does not, and
+// escapes the byte that starts commands
+func NewWriter(out io.Writer) io.Writer {
+	return &writer{out: out}
+}
+
+// Write writes to the client
+func (w *writer) Write(p []byte) (int, error) {
+	data := bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))
+	data = bytes.ReplaceAll(data, []byte{iac}, []byte{iac, iac})
+	if _, err := w.out.Write(data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// CharacterMode asks a telnet client to send the keys as they are typed and
+// to leave echoing them to the server, which an Editor does. The answers of
+// the client are filtered by NewReader.
+func CharacterMode(out io.Writer) error {
+	_, err := out.Write([]byte{iac, will, optionEcho, iac, will, optionSuppressGoAhead})
+	return err
+}
+
+// LineMode asks a telnet client to send the input a line at a time again,
+// which it echoes itself
+func LineMode(out io.Writer) error {
+	_, err := out.Write([]byte{iac, wont, optionEcho, iac, wont, optionSuppressGoAhead})
+	return err
+}
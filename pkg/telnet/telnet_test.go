@@ -0,0 +1,64 @@
+package telnet
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestReader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  string
+	}{
+		{"text", []byte("!!vm.list\n"), "!!vm.list\n"},
+		{"negotiation", []byte{iac, do, optionEcho, 'a', iac, dont, optionSuppressGoAhead, 'b'}, "ab"},
+		{"subnegotiation", []byte{'a', iac, sb, 31, 0, 80, iac, iac, 24, iac, se, 'b'}, "ab"},
+		{"escaped byte", []byte{'a', iac, iac, 'b'}, "a\xffb"},
+		{"other commands", []byte{'a', iac, 241, 'b'}, "ab"},
+		{"carriage return and NUL", []byte("one\r\x00two\r\nthree\x00"), "one\rtwo\r\nthree\x00"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Commands are filtered when they are split over reads too
+			for _, input := range []io.Reader{bytes.NewReader(test.input), iotest.OneByteReader(bytes.NewReader(test.input))} {
+				data, err := io.ReadAll(NewReader(input))
+				if err != nil {
+					t.Fatalf("Read failed: %v", err)
+				}
+				if string(data) != test.want {
+					t.Errorf("Expected %q, got %q", test.want, data)
+				}
+			}
+		})
+	}
+
+	// The input ends in a command that is cut off
+	data, err := io.ReadAll(NewReader(bytes.NewReader([]byte{'a', iac, sb, 31})))
+	if err != nil || string(data) != "a" {
+		t.Errorf("Expected the input before the command, got %q, %v", data, err)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var out bytes.Buffer
+	n, err := NewWriter(&out).Write([]byte("**RESULT**\nok \xff\n"))
+	if err != nil || n != 16 {
+		t.Fatalf("Expected 16 bytes to be written, got %d, %v", n, err)
+	}
+	if out.String() != "**RESULT**\r\nok \xff\xff\r\n" {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+}
+
+func TestModes(t *testing.T) {
+	var out bytes.Buffer
+	CharacterMode(&out)
+	LineMode(&out)
+	expected := []byte{iac, will, optionEcho, iac, will, optionSuppressGoAhead, iac, wont, optionEcho, iac, wont, optionSuppressGoAhead}
+	if !bytes.Equal(out.Bytes(), expected) {
+		t.Errorf("Expected %v, got %v", expected, out.Bytes())
+	}
+}
@@ -65,6 +65,17 @@ type VFSImplementation interface {
 	LinkRead(path string) (string, error)
 	LinkDelete(path string) error
 	
+	// Permission operations
+	Chmod(path string, mode uint32) error
+	Chown(path string, uid, gid uint32) error
+	
+	// Extended attribute operations; attributes are read through GetMetadata().Attributes
+	AttrSet(path, name, value string) error
+	AttrDelete(path, name string) error
+	
+	// Quota reports the storage used below path and the space still available
+	Quota(path string) (QuotaInfo, error)
+	
 	// Common operations
 	Exists(path string) bool
 	Get(path string) (FSEntry, error)
@@ -78,6 +89,14 @@ type VFSImplementation interface {
 	GetPath(entry FSEntry) (string, error)
 }
 
+// QuotaInfo describes the storage used by and available to a part of a VFS
+type QuotaInfo struct {
+	// Used is the number of bytes in use
+	Used int64
+	// Available is the number of bytes that can still be written, or -1 if unknown
+	Available int64
+}
+
 // ReadWriteSeeker combines io.Reader, io.Writer, and io.Seeker interfaces
 type ReadWriteSeeker interface {
 	io.Reader
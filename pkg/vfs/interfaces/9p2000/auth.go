@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// HostAllowlist restricts which remote hosts may connect to the 9p server
+type HostAllowlist struct {
+	nets []*net.IPNet
+}
+
+// ParseHostAllowlist parses a comma separated list of IP addresses and CIDR
+// ranges, e.g. "127.0.0.1,10.0.0.0/8,::1". A bare IP matches only that host.
+func ParseHostAllowlist(spec string) (*HostAllowlist, error) {
+	allowlist := &HostAllowlist{}
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address in allowlist: %s", item)
+			}
+			if ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR in allowlist: %s", item)
+		}
+		allowlist.nets = append(allowlist.nets, ipNet)
+	}
+
+	if len(allowlist.nets) == 0 {
+		return nil, fmt.Errorf("allowlist is empty")
+	}
+
+	return allowlist, nil
+}
+
+// Allowed returns true if the remote address is covered by the allowlist
+func (a *HostAllowlist) Allowed(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipNet := range a.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the allowlist in the same format accepted by ParseHostAllowlist
+func (a *HostAllowlist) String() string {
+	parts := make([]string, 0, len(a.nets))
+	for _, ipNet := range a.nets {
+		parts = append(parts, ipNet.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+// serveWithAllowlist accepts 9p connections on listenAddr and passes those
+// coming from hosts in the allowlist to handle; all other connections are
+// closed immediately
+func serveWithAllowlist(listenAddr string, allowlist *HostAllowlist, handle func(net.Conn) error) error {
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		if !allowlist.Allowed(conn.RemoteAddr()) {
+			log.Printf("Rejected 9p connection from %s: not in allowlist", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		log.Printf("Accepted 9p connection from %s", conn.RemoteAddr())
+		go func(conn net.Conn) {
+			defer conn.Close()
+			if err := handle(conn); err != nil {
+				log.Printf("9p connection from %s closed: %v", conn.RemoteAddr(), err)
+			}
+		}(conn)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestHostAllowlist(t *testing.T) {
+	allowlist, err := ParseHostAllowlist("127.0.0.1, 10.0.0.0/8,::1")
+	if err != nil {
+		t.Fatalf("Failed to parse allowlist: %v", err)
+	}
+
+	tests := []struct {
+		addr    string
+		allowed bool
+	}{
+		{"127.0.0.1:5640", true},
+		{"127.0.0.2:5640", false},
+		{"10.1.2.3:5640", true},
+		{"192.168.1.1:5640", false},
+		{"[::1]:5640", true},
+	}
+
+	for _, tt := range tests {
+		addr, err := net.ResolveTCPAddr("tcp", tt.addr)
+		if err != nil {
+			t.Fatalf("Failed to resolve %s: %v", tt.addr, err)
+		}
+		if got := allowlist.Allowed(addr); got != tt.allowed {
+			t.Errorf("Allowed(%s) = %v, want %v", tt.addr, got, tt.allowed)
+		}
+	}
+
+	if _, err := ParseHostAllowlist("not-an-ip"); err == nil {
+		t.Errorf("Expected error for invalid allowlist entry")
+	}
+	if _, err := ParseHostAllowlist(""); err == nil {
+		t.Errorf("Expected error for empty allowlist")
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"os"
 
 	"github.com/knusbaum/go9p/client"
@@ -11,12 +12,18 @@ import (
 func main() {
 	// Connect to the 9p server
 	fmt.Println("Connecting to 9p server...")
-	c, err := client.Dial("tcp", "localhost:9999", "glenda", "/")
+	conn, err := net.Dial("tcp", "localhost:9999")
 	if err != nil {
 		fmt.Printf("Failed to connect to server: %v\n", err)
 		os.Exit(1)
 		return
 	}
+	c, err := client.NewClient(conn, "glenda", "/")
+	if err != nil {
+		fmt.Printf("Failed to attach to server: %v\n", err)
+		os.Exit(1)
+		return
+	}
 	fmt.Println("Connected to server successfully!")
 
 	// Test listing the root directory
@@ -42,6 +49,16 @@ func main() {
 	}
 	fmt.Println("File created successfully!")
 
+	// Files returned by Create have no I/O unit in go9p, so writes to them
+	// send nothing; reopen the file to write to it
+	f.Close()
+	f, err = c.Open("/simple.txt", proto.Owrite)
+	if err != nil {
+		fmt.Printf("Failed to open file: %v\n", err)
+		os.Exit(1)
+		return
+	}
+
 	// Write to the file
 	content := []byte("Hello, 9p2000!")
 	fmt.Println("Writing to file...")
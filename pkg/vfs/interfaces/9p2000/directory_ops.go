@@ -56,24 +56,8 @@ func (d *VFSDBDir) Children() map[string]fs.FSNode {
 		entryPath := path.Join(d.path, name)
 		log.Printf("Processing entry %s (path: %s, isDir: %v)", name, entryPath, entry.IsDir())
 
-		// Create a stat for the entry
-		stat := proto.Stat{
-			Type:   0,
-			Dev:    0,
-			Qid: proto.Qid{
-				Qtype: uint8(metadata.Mode >> 24),
-				Vers:  0,
-				Uid:   uint64(metadata.ID),
-			},
-			Mode:   metadata.Mode,
-			Atime:  uint32(metadata.AccessedAt),
-			Mtime:  uint32(metadata.ModifiedAt),
-			Length: metadata.Size,
-			Name:   name,
-			Uid:    metadata.Owner,
-			Gid:    metadata.Group,
-			Muid:   metadata.Owner,
-		}
+		// Create a stat for the entry from its stored permissions and ownership
+		stat := statFromMetadata(metadata, name)
 
 		if entry.IsDir() {
 			// Create a directory node
@@ -164,6 +148,15 @@ func (d *VFSDBDir) DeleteChild(name string) error {
 	return nil
 }
 
+// WriteStat implements fs.FSNode.WriteStat, persisting mode and ownership changes
+func (d *VFSDBDir) WriteStat(s *proto.Stat) error {
+	if err := applyWriteStat(d.vfsImpl, d.path, s); err != nil {
+		log.Printf("Failed to update stat for directory %s: %v", d.path, err)
+		return err
+	}
+	return d.BaseNode.WriteStat(s)
+}
+
 // createVFSDBDir returns a function that creates a VFSDBDir
 func createVFSDBDir(vfsImpl vfs.VFSImplementation) func(fs *fs.FS, parent fs.Dir, user, name string, perm uint32, mode uint8) (fs.Dir, error) {
 	return func(fsys *fs.FS, parent fs.Dir, user, name string, perm uint32, mode uint8) (fs.Dir, error) {
@@ -208,6 +201,11 @@ func createVFSDBDir(vfsImpl vfs.VFSImplementation) func(fs *fs.FS, parent fs.Dir
 			}
 		} else {
 			log.Printf("Successfully created directory %s in vfsdb", dirPath)
+			
+			// Store the permissions requested by the client
+			if err := vfsImpl.Chmod(dirPath, perm&vfs.ModePermAll); err != nil {
+				log.Printf("Failed to set permissions on directory %s: %v", dirPath, err)
+			}
 		}
 		
 		// Now add the child to the parent in the 9p server structure
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+	"github.com/knusbaum/go9p"
+)
+
+// serveConn serves a single client connection. The dialect is chosen from the
+// client's first Tversion: 9P2000.L is handled natively on top of the VFS,
+// anything else is passed to the legacy 9P2000 server.
+func serveConn(conn net.Conn, vfsImpl vfs.VFSImplementation, legacy go9p.Srv) error {
+	first, err := readDotlMessage(conn, dotlMaxMsize)
+	if err != nil {
+		return err
+	}
+
+	if peekDotlVersion(first) {
+		log.Printf("Serving 9P2000.L to %s", conn.RemoteAddr())
+		return serveDotl(vfsImpl, conn, first)
+	}
+
+	// Replay the message already consumed before handing over the connection
+	log.Printf("Serving 9P2000 to %s", conn.RemoteAddr())
+	return go9p.ServeReadWriter(io.MultiReader(bytes.NewReader(first), conn), conn, legacy)
+}
@@ -0,0 +1,1155 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"io"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+)
+
+// dotlMaxMsize is the largest message size the 9P2000.L server negotiates
+const dotlMaxMsize = 1 << 20
+
+// Linux errno values reported in Rlerror
+const (
+	dotlEPERM      = 1
+	dotlENOENT     = 2
+	dotlEIO        = 5
+	dotlEBADF      = 9
+	dotlEACCES     = 13
+	dotlEEXIST     = 17
+	dotlENOTDIR    = 20
+	dotlEISDIR     = 21
+	dotlEINVAL     = 22
+	dotlERANGE     = 34
+	dotlENOSYS     = 38
+	dotlENOTEMPTY  = 39
+	dotlENODATA    = 61
+	dotlEOPNOTSUPP = 95
+)
+
+// Linux file mode type bits
+const (
+	dotlSIFDIR = 0040000
+	dotlSIFREG = 0100000
+	dotlSIFLNK = 0120000
+)
+
+// Linux open flags used by Tlopen and Tlcreate
+const (
+	dotlORdonly  = 00000000
+	dotlOAccMode = 00000003
+	dotlOTrunc   = 00001000
+	dotlOAppend  = 00002000
+)
+
+// Tsetattr valid bits
+const (
+	dotlSetattrMode = 0x00000001
+	dotlSetattrUID  = 0x00000002
+	dotlSetattrGID  = 0x00000004
+	dotlSetattrSize = 0x00000008
+)
+
+// Directory entry types used in Rreaddir
+const (
+	dotlDTDir = 4
+	dotlDTReg = 8
+	dotlDTLnk = 10
+)
+
+// dotlGetattrBasic is the set of Rgetattr fields this server fills in
+const dotlGetattrBasic = 0x000007ff
+
+// dotlATRemoveDir is the Tunlinkat flag requesting directory removal
+const dotlATRemoveDir = 0x200
+
+// dotlXattrUserPrefix is the only extended attribute namespace stored in the VFS
+const dotlXattrUserPrefix = "user."
+
+// dotlMagic is the filesystem type reported by Tstatfs (V9FS_MAGIC)
+const dotlMagic = 0x01021997
+
+// dotlError is an error carrying the Linux errno to report to the client
+type dotlError uint32
+
+func (e dotlError) Error() string {
+	return syscall.Errno(e).Error()
+}
+
+// dotlFid is the server side state of a client fid
+type dotlFid struct {
+	path  string
+	uid   uint32
+	open  bool
+	flags uint32
+
+	// dirents is a snapshot of the directory taken when it is opened
+	dirents []dotlDirent
+
+	// Extended attribute fids created by Txattrwalk or Txattrcreate
+	xattr      bool
+	xattrName  string
+	xattrData  []byte
+	xattrWrite bool
+	xattrFlags uint32
+}
+
+// dotlDirent is a directory entry returned by Treaddir
+type dotlDirent struct {
+	qid   dotlQid
+	dtype uint8
+	name  string
+}
+
+// dotlConn serves the 9P2000.L dialect on a single client connection.
+// Requests are handled one at a time in the order they arrive.
+type dotlConn struct {
+	vfsImpl vfs.VFSImplementation
+	rw      io.ReadWriter
+	msize   uint32
+	fids    map[uint32]*dotlFid
+}
+
+// serveDotl serves 9P2000.L on rw until the connection is closed. The first
+// message, usually the Tversion that selected this dialect, is passed in raw.
+func serveDotl(vfsImpl vfs.VFSImplementation, rw io.ReadWriter, first []byte) error {
+	c := &dotlConn{
+		vfsImpl: vfsImpl,
+		rw:      rw,
+		msize:   dotlMaxMsize,
+		fids:    make(map[uint32]*dotlFid),
+	}
+
+	msg := first
+	for {
+		if msg == nil {
+			var err error
+			msg, err = readDotlMessage(rw, c.msize)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+		}
+
+		msgType, tag, body := parseDotlHeader(msg)
+		reply, err := c.handle(msgType, body)
+		if err != nil {
+			reply = (&dotlEncoder{}).u32(dotlErrno(err)).buf
+			if err := writeDotlMessage(rw, dotlRlerror, tag, reply); err != nil {
+				return err
+			}
+		} else if err := writeDotlMessage(rw, msgType+1, tag, reply); err != nil {
+			return err
+		}
+		msg = nil
+	}
+}
+
+// handle dispatches a single T-message and returns the body of its R-message
+func (c *dotlConn) handle(msgType uint8, body []byte) ([]byte, error) {
+	d := &dotlDecoder{data: body}
+
+	var reply []byte
+	var err error
+	switch msgType {
+	case dotlTversion:
+		reply, err = c.version(d)
+	case dotlTauth:
+		err = dotlError(dotlEOPNOTSUPP)
+	case dotlTattach:
+		reply, err = c.attach(d)
+	case dotlTflush:
+		// Requests are handled sequentially, so there is never anything to flush
+		d.u16()
+	case dotlTwalk:
+		reply, err = c.walk(d)
+	case dotlTgetattr:
+		reply, err = c.getattr(d)
+	case dotlTsetattr:
+		err = c.setattr(d)
+	case dotlTstatfs:
+		reply, err = c.statfs(d)
+	case dotlTlopen:
+		reply, err = c.lopen(d)
+	case dotlTlcreate:
+		reply, err = c.lcreate(d)
+	case dotlTsymlink:
+		reply, err = c.symlink(d)
+	case dotlTreadlink:
+		reply, err = c.readlink(d)
+	case dotlTmkdir:
+		reply, err = c.mkdir(d)
+	case dotlTrename:
+		err = c.rename(d)
+	case dotlTrenameat:
+		err = c.renameat(d)
+	case dotlTunlinkat:
+		err = c.unlinkat(d)
+	case dotlTreaddir:
+		reply, err = c.readdir(d)
+	case dotlTread:
+		reply, err = c.read(d)
+	case dotlTwrite:
+		reply, err = c.write(d)
+	case dotlTclunk:
+		err = c.clunk(d)
+	case dotlTremove:
+		err = c.remove(d)
+	case dotlTfsync:
+		_, err = c.fid(d.u32())
+	case dotlTlock:
+		reply, err = c.lock(d)
+	case dotlTgetlock:
+		reply, err = c.getlock(d)
+	case dotlTxattrwalk:
+		reply, err = c.xattrwalk(d)
+	case dotlTxattrcreate:
+		err = c.xattrcreate(d)
+	case dotlTmknod, dotlTlink:
+		err = dotlError(dotlEOPNOTSUPP)
+	default:
+		log.Printf("Unsupported 9P2000.L message type %d", msgType)
+		err = dotlError(dotlENOSYS)
+	}
+
+	if err == nil && d.err != nil {
+		err = dotlError(dotlEINVAL)
+	}
+	return reply, err
+}
+
+// fid returns the state of an existing fid
+func (c *dotlConn) fid(fid uint32) (*dotlFid, error) {
+	f, ok := c.fids[fid]
+	if !ok {
+		return nil, dotlError(dotlEBADF)
+	}
+	return f, nil
+}
+
+func (c *dotlConn) version(d *dotlDecoder) ([]byte, error) {
+	msize := d.u32()
+	version := d.str()
+
+	if msize > dotlMaxMsize {
+		msize = dotlMaxMsize
+	}
+	c.msize = msize
+
+	// A new version request resets the session
+	c.fids = make(map[uint32]*dotlFid)
+
+	if version != dotlVersion {
+		version = "unknown"
+	}
+	return (&dotlEncoder{}).u32(msize).str(version).buf, nil
+}
+
+func (c *dotlConn) attach(d *dotlDecoder) ([]byte, error) {
+	fid := d.u32()
+	d.u32() // afid, authentication is handled by the host allowlist
+	uname := d.str()
+	aname := d.str()
+	nuname := d.u32()
+
+	entry, err := c.vfsImpl.RootGet()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("9P2000.L attach from user %q (%d), aname %q", uname, nuname, aname)
+	c.fids[fid] = &dotlFid{path: "/", uid: nuname}
+	return (&dotlEncoder{}).qid(dotlQidFor("/", entry)).buf, nil
+}
+
+func (c *dotlConn) walk(d *dotlDecoder) ([]byte, error) {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return nil, err
+	}
+	newfid := d.u32()
+	names := make([]string, d.u16())
+	for i := range names {
+		names[i] = d.str()
+	}
+	if d.err != nil {
+		return nil, dotlError(dotlEINVAL)
+	}
+
+	current := f.path
+	qids := make([]dotlQid, 0, len(names))
+	for _, name := range names {
+		next := path.Join(current, name)
+		entry, err := c.vfsImpl.Get(next)
+		if err != nil {
+			// Only a failure on the first element is an error, otherwise the
+			// client learns how far the walk got from the number of qids
+			if len(qids) == 0 {
+				return nil, err
+			}
+			break
+		}
+		qids = append(qids, dotlQidFor(next, entry))
+		current = next
+	}
+
+	if len(qids) == len(names) {
+		c.fids[newfid] = &dotlFid{path: current, uid: f.uid}
+	}
+
+	e := (&dotlEncoder{}).u16(uint16(len(qids)))
+	for _, qid := range qids {
+		e.qid(qid)
+	}
+	return e.buf, nil
+}
+
+func (c *dotlConn) getattr(d *dotlDecoder) ([]byte, error) {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return nil, err
+	}
+	d.u64() // request mask, all basic fields are always returned
+
+	entry, err := c.vfsImpl.Get(f.path)
+	if err != nil {
+		return nil, err
+	}
+	metadata := entry.GetMetadata()
+
+	nlink := uint64(1)
+	if entry.IsDir() {
+		nlink = 2
+	}
+
+	e := (&dotlEncoder{}).
+		u64(dotlGetattrBasic).
+		qid(dotlQidFor(f.path, entry)).
+		u32(dotlMode(entry)).
+		u32(metadata.UID).
+		u32(metadata.GID).
+		u64(nlink).
+		u64(0). // rdev
+		u64(metadata.Size).
+		u64(4096).
+		u64((metadata.Size + 511) / 512)
+	e.u64(uint64(metadata.AccessedAt)).u64(0)
+	e.u64(uint64(metadata.ModifiedAt)).u64(0)
+	e.u64(uint64(metadata.ModifiedAt)).u64(0)
+	e.u64(uint64(metadata.CreatedAt)).u64(0)
+	e.u64(0).u64(0) // gen, data_version
+	return e.buf, nil
+}
+
+func (c *dotlConn) setattr(d *dotlDecoder) error {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+	valid := d.u32()
+	mode := d.u32()
+	uid := d.u32()
+	gid := d.u32()
+	size := d.u64()
+	// atime and mtime are not stored separately by the VFS and are ignored
+	if d.err != nil {
+		return dotlError(dotlEINVAL)
+	}
+
+	if valid&dotlSetattrMode != 0 {
+		if err := c.vfsImpl.Chmod(f.path, mode&vfs.ModePermAll); err != nil {
+			return err
+		}
+	}
+
+	if valid&(dotlSetattrUID|dotlSetattrGID) != 0 {
+		entry, err := c.vfsImpl.Get(f.path)
+		if err != nil {
+			return err
+		}
+		metadata := entry.GetMetadata()
+		if valid&dotlSetattrUID == 0 {
+			uid = metadata.UID
+		}
+		if valid&dotlSetattrGID == 0 {
+			gid = metadata.GID
+		}
+		if err := c.vfsImpl.Chown(f.path, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	if valid&dotlSetattrSize != 0 {
+		return c.truncate(f.path, size)
+	}
+	return nil
+}
+
+// truncate resizes a file, padding it with zero bytes when it grows
+func (c *dotlConn) truncate(filePath string, size uint64) error {
+	if size == 0 {
+		return c.vfsImpl.FileWrite(filePath, []byte{})
+	}
+
+	data, err := c.vfsImpl.FileRead(filePath)
+	if err != nil {
+		return err
+	}
+	if uint64(len(data)) == size {
+		return nil
+	}
+
+	resized := make([]byte, size)
+	copy(resized, data)
+	return c.vfsImpl.FileWrite(filePath, resized)
+}
+
+func (c *dotlConn) statfs(d *dotlDecoder) ([]byte, error) {
+	if _, err := c.fid(d.u32()); err != nil {
+		return nil, err
+	}
+
+	// The VFS has no notion of capacity, so report a large, mostly free filesystem
+	const blocks = 1 << 30
+	return (&dotlEncoder{}).
+		u32(dotlMagic).
+		u32(4096).
+		u64(blocks).
+		u64(blocks).
+		u64(blocks).
+		u64(blocks).
+		u64(blocks).
+		u64(0).
+		u32(255).buf, nil
+}
+
+func (c *dotlConn) lopen(d *dotlDecoder) ([]byte, error) {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return nil, err
+	}
+	flags := d.u32()
+	if f.open {
+		return nil, dotlError(dotlEINVAL)
+	}
+
+	entry, err := c.vfsImpl.Get(f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.IsDir() {
+		if flags&dotlOAccMode != dotlORdonly {
+			return nil, dotlError(dotlEISDIR)
+		}
+		if f.dirents, err = c.listDir(f.path); err != nil {
+			return nil, err
+		}
+	} else if flags&dotlOTrunc != 0 && flags&dotlOAccMode != dotlORdonly {
+		if err := c.vfsImpl.FileWrite(f.path, []byte{}); err != nil {
+			return nil, err
+		}
+	}
+
+	f.open = true
+	f.flags = flags
+	return (&dotlEncoder{}).qid(dotlQidFor(f.path, entry)).u32(c.iounit()).buf, nil
+}
+
+func (c *dotlConn) lcreate(d *dotlDecoder) ([]byte, error) {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return nil, err
+	}
+	name := d.str()
+	flags := d.u32()
+	mode := d.u32()
+	gid := d.u32()
+	if d.err != nil {
+		return nil, dotlError(dotlEINVAL)
+	}
+
+	filePath, err := c.childPath(f.path, name)
+	if err != nil {
+		return nil, err
+	}
+	if c.vfsImpl.Exists(filePath) {
+		return nil, dotlError(dotlEEXIST)
+	}
+
+	if _, err := c.vfsImpl.FileCreate(filePath); err != nil {
+		return nil, err
+	}
+	entry, err := c.setupNewEntry(filePath, mode, f.uid, gid)
+	if err != nil {
+		return nil, err
+	}
+
+	// The directory fid now refers to the newly created, opened file
+	f.path = filePath
+	f.open = true
+	f.flags = flags
+	f.dirents = nil
+	return (&dotlEncoder{}).qid(dotlQidFor(filePath, entry)).u32(c.iounit()).buf, nil
+}
+
+func (c *dotlConn) symlink(d *dotlDecoder) ([]byte, error) {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return nil, err
+	}
+	name := d.str()
+	target := d.str()
+	gid := d.u32()
+	if d.err != nil {
+		return nil, dotlError(dotlEINVAL)
+	}
+
+	linkPath, err := c.childPath(f.path, name)
+	if err != nil {
+		return nil, err
+	}
+	if c.vfsImpl.Exists(linkPath) {
+		return nil, dotlError(dotlEEXIST)
+	}
+
+	entry, err := c.vfsImpl.LinkCreate(target, linkPath)
+	if err != nil {
+		return nil, err
+	}
+	c.applyOwnership(linkPath, f.uid, gid)
+	return (&dotlEncoder{}).qid(dotlQidFor(linkPath, entry)).buf, nil
+}
+
+func (c *dotlConn) readlink(d *dotlDecoder) ([]byte, error) {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := c.vfsImpl.LinkRead(f.path)
+	if err != nil {
+		return nil, err
+	}
+	return (&dotlEncoder{}).str(target).buf, nil
+}
+
+func (c *dotlConn) mkdir(d *dotlDecoder) ([]byte, error) {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return nil, err
+	}
+	name := d.str()
+	mode := d.u32()
+	gid := d.u32()
+	if d.err != nil {
+		return nil, dotlError(dotlEINVAL)
+	}
+
+	dirPath, err := c.childPath(f.path, name)
+	if err != nil {
+		return nil, err
+	}
+	if c.vfsImpl.Exists(dirPath) {
+		return nil, dotlError(dotlEEXIST)
+	}
+
+	if _, err := c.vfsImpl.DirCreate(dirPath); err != nil {
+		return nil, err
+	}
+	entry, err := c.setupNewEntry(dirPath, mode, f.uid, gid)
+	if err != nil {
+		return nil, err
+	}
+	return (&dotlEncoder{}).qid(dotlQidFor(dirPath, entry)).buf, nil
+}
+
+// setupNewEntry applies the requested mode and ownership to a freshly created entry
+func (c *dotlConn) setupNewEntry(entryPath string, mode, uid, gid uint32) (vfs.FSEntry, error) {
+	if err := c.vfsImpl.Chmod(entryPath, mode&vfs.ModePermAll); err != nil {
+		return nil, err
+	}
+	c.applyOwnership(entryPath, uid, gid)
+	return c.vfsImpl.Get(entryPath)
+}
+
+// applyOwnership gives a new entry to the creating user. Backends that cannot
+// change ownership (e.g. a local filesystem served without privileges) keep
+// their default owner.
+func (c *dotlConn) applyOwnership(entryPath string, uid, gid uint32) {
+	if uid == dotlNoFid {
+		return
+	}
+	if err := c.vfsImpl.Chown(entryPath, uid, gid); err != nil {
+		log.Printf("Could not set ownership of %s to %d:%d: %v", entryPath, uid, gid, err)
+	}
+}
+
+func (c *dotlConn) rename(d *dotlDecoder) error {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+	dir, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+	name := d.str()
+	if d.err != nil {
+		return dotlError(dotlEINVAL)
+	}
+
+	newPath, err := c.childPath(dir.path, name)
+	if err != nil {
+		return err
+	}
+	if err := c.move(f.path, newPath); err != nil {
+		return err
+	}
+	f.path = newPath
+	return nil
+}
+
+func (c *dotlConn) renameat(d *dotlDecoder) error {
+	oldDir, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+	oldName := d.str()
+	newDir, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+	newName := d.str()
+	if d.err != nil {
+		return dotlError(dotlEINVAL)
+	}
+
+	oldPath, err := c.childPath(oldDir.path, oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := c.childPath(newDir.path, newName)
+	if err != nil {
+		return err
+	}
+	return c.move(oldPath, newPath)
+}
+
+// move renames an entry with POSIX semantics, replacing an existing file or
+// empty directory at the destination
+func (c *dotlConn) move(oldPath, newPath string) error {
+	if oldPath == newPath {
+		return nil
+	}
+
+	src, err := c.vfsImpl.Get(oldPath)
+	if err != nil {
+		return err
+	}
+
+	if dst, err := c.vfsImpl.Get(newPath); err == nil {
+		if dst.IsDir() != src.IsDir() {
+			if dst.IsDir() {
+				return dotlError(dotlEISDIR)
+			}
+			return dotlError(dotlENOTDIR)
+		}
+		if dst.IsDir() {
+			children, err := c.vfsImpl.DirList(newPath)
+			if err != nil {
+				return err
+			}
+			if len(children) > 0 {
+				return dotlError(dotlENOTEMPTY)
+			}
+		}
+		if err := c.vfsImpl.Delete(newPath); err != nil {
+			return err
+		}
+	}
+
+	// Rename keeps the entry in its directory, Move is needed to change directory
+	if path.Dir(oldPath) == path.Dir(newPath) {
+		_, err = c.vfsImpl.Rename(oldPath, newPath)
+	} else {
+		_, err = c.vfsImpl.Move(oldPath, newPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Keep other fids pointing at the moved entry or its children valid
+	for _, f := range c.fids {
+		if f.path == oldPath {
+			f.path = newPath
+		} else if strings.HasPrefix(f.path, oldPath+"/") {
+			f.path = newPath + strings.TrimPrefix(f.path, oldPath)
+		}
+	}
+	return nil
+}
+
+func (c *dotlConn) unlinkat(d *dotlDecoder) error {
+	dir, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+	name := d.str()
+	flags := d.u32()
+	if d.err != nil {
+		return dotlError(dotlEINVAL)
+	}
+
+	entryPath, err := c.childPath(dir.path, name)
+	if err != nil {
+		return err
+	}
+	return c.unlink(entryPath, flags&dotlATRemoveDir != 0)
+}
+
+// unlink removes a file, symlink or empty directory
+func (c *dotlConn) unlink(entryPath string, removeDir bool) error {
+	if entryPath == "/" {
+		return dotlError(dotlEPERM)
+	}
+
+	entry, err := c.vfsImpl.Get(entryPath)
+	if err != nil {
+		return err
+	}
+
+	if entry.IsDir() {
+		if !removeDir {
+			return dotlError(dotlEISDIR)
+		}
+		children, err := c.vfsImpl.DirList(entryPath)
+		if err != nil {
+			return err
+		}
+		if len(children) > 0 {
+			return dotlError(dotlENOTEMPTY)
+		}
+	} else if removeDir {
+		return dotlError(dotlENOTDIR)
+	}
+
+	return c.vfsImpl.Delete(entryPath)
+}
+
+func (c *dotlConn) readdir(d *dotlDecoder) ([]byte, error) {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return nil, err
+	}
+	offset := d.u64()
+	count := d.u32()
+	if !f.open || f.dirents == nil {
+		return nil, dotlError(dotlEBADF)
+	}
+
+	// Rewinding to the start picks up changes made since the directory was opened
+	if offset == 0 {
+		if f.dirents, err = c.listDir(f.path); err != nil {
+			return nil, err
+		}
+	}
+
+	if count > c.iounit() {
+		count = c.iounit()
+	}
+
+	data := &dotlEncoder{}
+	for i := offset; i < uint64(len(f.dirents)); i++ {
+		dirent := f.dirents[i]
+		// qid[13] offset[8] type[1] name[s]
+		if uint32(len(data.buf)+13+8+1+2+len(dirent.name)) > count {
+			break
+		}
+		data.qid(dirent.qid).u64(i + 1).u8(dirent.dtype).str(dirent.name)
+	}
+
+	return (&dotlEncoder{}).bytes(data.buf).buf, nil
+}
+
+// listDir snapshots the entries of a directory, including "." and ".."
+func (c *dotlConn) listDir(dirPath string) ([]dotlDirent, error) {
+	self, err := c.vfsImpl.Get(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	parentPath := path.Dir(dirPath)
+	parent, err := c.vfsImpl.Get(parentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := c.vfsImpl.DirList(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dirents := []dotlDirent{
+		{qid: dotlQidFor(dirPath, self), dtype: dotlDTDir, name: "."},
+		{qid: dotlQidFor(parentPath, parent), dtype: dotlDTDir, name: ".."},
+	}
+	for _, entry := range entries {
+		name := entry.GetMetadata().Name
+		dirents = append(dirents, dotlDirent{
+			qid:   dotlQidFor(path.Join(dirPath, name), entry),
+			dtype: dotlDirentType(entry),
+			name:  name,
+		})
+	}
+	return dirents, nil
+}
+
+func (c *dotlConn) read(d *dotlDecoder) ([]byte, error) {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return nil, err
+	}
+	offset := d.u64()
+	count := d.u32()
+	if count > c.iounit() {
+		count = c.iounit()
+	}
+
+	var data []byte
+	switch {
+	case f.xattr && !f.xattrWrite:
+		data = f.xattrData
+	case f.open:
+		if data, err = c.vfsImpl.FileRead(f.path); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, dotlError(dotlEBADF)
+	}
+
+	if offset >= uint64(len(data)) {
+		return (&dotlEncoder{}).bytes(nil).buf, nil
+	}
+	end := offset + uint64(count)
+	if end > uint64(len(data)) {
+		end = uint64(len(data))
+	}
+	return (&dotlEncoder{}).bytes(data[offset:end]).buf, nil
+}
+
+func (c *dotlConn) write(d *dotlDecoder) ([]byte, error) {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return nil, err
+	}
+	offset := d.u64()
+	data := d.bytes(d.u32())
+	if d.err != nil {
+		return nil, dotlError(dotlEINVAL)
+	}
+
+	switch {
+	case f.xattrWrite:
+		// Attribute values are collected and stored when the fid is clunked
+		if offset != uint64(len(f.xattrData)) {
+			return nil, dotlError(dotlEINVAL)
+		}
+		f.xattrData = append(f.xattrData, data...)
+	case f.open && f.flags&dotlOAccMode != dotlORdonly:
+		if err := c.writeAt(f, offset, data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, dotlError(dotlEBADF)
+	}
+
+	return (&dotlEncoder{}).u32(uint32(len(data))).buf, nil
+}
+
+// writeAt writes data into a file at offset. The VFS only stores whole files,
+// so the file is read, patched and written back.
+func (c *dotlConn) writeAt(f *dotlFid, offset uint64, data []byte) error {
+	if f.flags&dotlOAppend != 0 {
+		return c.vfsImpl.FileConcatenate(f.path, data)
+	}
+
+	content, err := c.vfsImpl.FileRead(f.path)
+	if err != nil {
+		return err
+	}
+
+	end := offset + uint64(len(data))
+	if uint64(len(content)) < end {
+		extended := make([]byte, end)
+		copy(extended, content)
+		content = extended
+	}
+	copy(content[offset:], data)
+
+	return c.vfsImpl.FileWrite(f.path, content)
+}
+
+func (c *dotlConn) clunk(d *dotlDecoder) error {
+	fid := d.u32()
+	f, err := c.fid(fid)
+	if err != nil {
+		return err
+	}
+	delete(c.fids, fid)
+
+	if f.xattrWrite {
+		return c.commitXattr(f)
+	}
+	return nil
+}
+
+func (c *dotlConn) remove(d *dotlDecoder) error {
+	fid := d.u32()
+	f, err := c.fid(fid)
+	if err != nil {
+		return err
+	}
+	// The fid is clunked even if the remove fails
+	delete(c.fids, fid)
+
+	entry, err := c.vfsImpl.Get(f.path)
+	if err != nil {
+		return err
+	}
+	return c.unlink(f.path, entry.IsDir())
+}
+
+func (c *dotlConn) lock(d *dotlDecoder) ([]byte, error) {
+	if _, err := c.fid(d.u32()); err != nil {
+		return nil, err
+	}
+
+	// Locks are advisory and the VFS has no lock manager; always grant them
+	const lockSuccess = 0
+	return (&dotlEncoder{}).u8(lockSuccess).buf, nil
+}
+
+func (c *dotlConn) getlock(d *dotlDecoder) ([]byte, error) {
+	if _, err := c.fid(d.u32()); err != nil {
+		return nil, err
+	}
+	d.u8()
+	start := d.u64()
+	length := d.u64()
+	procID := d.u32()
+	clientID := d.str()
+
+	// No conflicting lock is ever held
+	const lockTypeUnlock = 2
+	return (&dotlEncoder{}).u8(lockTypeUnlock).u64(start).u64(length).u32(procID).str(clientID).buf, nil
+}
+
+func (c *dotlConn) xattrwalk(d *dotlDecoder) ([]byte, error) {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return nil, err
+	}
+	newfid := d.u32()
+	name := d.str()
+	if d.err != nil {
+		return nil, dotlError(dotlEINVAL)
+	}
+
+	entry, err := c.vfsImpl.Get(f.path)
+	if err != nil {
+		return nil, err
+	}
+	attrs := entry.GetMetadata().Attributes
+
+	var data []byte
+	if name == "" {
+		// An empty name lists all attributes as NUL terminated names
+		names := make([]string, 0, len(attrs))
+		for attrName := range attrs {
+			names = append(names, attrName)
+		}
+		sort.Strings(names)
+		for _, attrName := range names {
+			data = append(data, dotlXattrUserPrefix+attrName...)
+			data = append(data, 0)
+		}
+	} else {
+		if !strings.HasPrefix(name, dotlXattrUserPrefix) {
+			return nil, dotlError(dotlENODATA)
+		}
+		value, ok := attrs[strings.TrimPrefix(name, dotlXattrUserPrefix)]
+		if !ok {
+			return nil, dotlError(dotlENODATA)
+		}
+		data = []byte(value)
+	}
+
+	c.fids[newfid] = &dotlFid{path: f.path, uid: f.uid, xattr: true, xattrName: name, xattrData: data}
+	return (&dotlEncoder{}).u64(uint64(len(data))).buf, nil
+}
+
+func (c *dotlConn) xattrcreate(d *dotlDecoder) error {
+	f, err := c.fid(d.u32())
+	if err != nil {
+		return err
+	}
+	name := d.str()
+	size := d.u64()
+	flags := d.u32()
+	if d.err != nil {
+		return dotlError(dotlEINVAL)
+	}
+
+	// Only the user namespace is stored; security and ACL attributes are refused
+	if !strings.HasPrefix(name, dotlXattrUserPrefix) || name == dotlXattrUserPrefix {
+		return dotlError(dotlEOPNOTSUPP)
+	}
+	if size > uint64(c.msize) {
+		return dotlError(dotlERANGE)
+	}
+
+	// The fid becomes the attribute fid the value is written to
+	f.xattr = true
+	f.xattrWrite = true
+	f.xattrName = name
+	f.xattrData = make([]byte, 0, size)
+	f.xattrFlags = flags
+	return nil
+}
+
+// commitXattr stores the value written to an attribute fid. An empty value
+// created with XATTR_REPLACE is how Linux clients remove an attribute.
+func (c *dotlConn) commitXattr(f *dotlFid) error {
+	const (
+		xattrCreate  = 1
+		xattrReplace = 2
+	)
+
+	name := strings.TrimPrefix(f.xattrName, dotlXattrUserPrefix)
+	entry, err := c.vfsImpl.Get(f.path)
+	if err != nil {
+		return err
+	}
+	_, exists := entry.GetMetadata().Attribute(name)
+
+	if f.xattrFlags&xattrCreate != 0 && exists {
+		return dotlError(dotlEEXIST)
+	}
+	if f.xattrFlags&xattrReplace != 0 && !exists {
+		return dotlError(dotlENODATA)
+	}
+
+	if len(f.xattrData) == 0 && f.xattrFlags&xattrReplace != 0 {
+		return c.vfsImpl.AttrDelete(f.path, name)
+	}
+	return c.vfsImpl.AttrSet(f.path, name, string(f.xattrData))
+}
+
+// childPath joins a directory path and an entry name, rejecting names that
+// would escape the directory
+func (c *dotlConn) childPath(dirPath, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return "", dotlError(dotlEINVAL)
+	}
+	return path.Join(dirPath, name), nil
+}
+
+// iounit returns the largest payload that fits in a single read or write message
+func (c *dotlConn) iounit() uint32 {
+	// Rread has size[4] type[1] tag[2] count[4] before the data
+	return c.msize - dotlHeaderSize - 4
+}
+
+// dotlQidFor builds the qid of an entry. The qid path must be stable and unique
+// across backends, and not every backend assigns IDs, so it is derived from the path.
+func dotlQidFor(entryPath string, entry vfs.FSEntry) dotlQid {
+	hash := fnv.New64a()
+	hash.Write([]byte(entryPath))
+
+	qtype := dotlQTFILE
+	if entry.IsDir() {
+		qtype = dotlQTDIR
+	} else if entry.IsSymlink() {
+		qtype = dotlQTSYMLINK
+	}
+
+	return dotlQid{
+		Type:    qtype,
+		Version: uint32(entry.GetMetadata().ModifiedAt),
+		Path:    hash.Sum64(),
+	}
+}
+
+// dotlMode returns the Linux st_mode of an entry
+func dotlMode(entry vfs.FSEntry) uint32 {
+	mode := entry.GetMetadata().Permissions()
+	switch {
+	case entry.IsDir():
+		return mode | dotlSIFDIR
+	case entry.IsSymlink():
+		return mode | dotlSIFLNK
+	default:
+		return mode | dotlSIFREG
+	}
+}
+
+// dotlDirentType returns the readdir d_type of an entry
+func dotlDirentType(entry vfs.FSEntry) uint8 {
+	switch {
+	case entry.IsDir():
+		return dotlDTDir
+	case entry.IsSymlink():
+		return dotlDTLnk
+	default:
+		return dotlDTReg
+	}
+}
+
+// dotlErrno maps an error to the Linux errno reported in Rlerror
+func dotlErrno(err error) uint32 {
+	var dotlErr dotlError
+	if errors.As(err, &dotlErr) {
+		return uint32(dotlErr)
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return uint32(errno)
+	}
+
+	switch {
+	case errors.Is(err, vfs.ErrNotFound), errors.Is(err, os.ErrNotExist):
+		return dotlENOENT
+	case errors.Is(err, vfs.ErrAlreadyExists), errors.Is(err, os.ErrExist):
+		return dotlEEXIST
+	case errors.Is(err, vfs.ErrNotEmpty):
+		return dotlENOTEMPTY
+	case errors.Is(err, vfs.ErrNotDirectory):
+		return dotlENOTDIR
+	case errors.Is(err, vfs.ErrNotFile):
+		return dotlEISDIR
+	case errors.Is(err, vfs.ErrNotSymlink), errors.Is(err, vfs.ErrInvalidPath):
+		return dotlEINVAL
+	case errors.Is(err, vfs.ErrPermission):
+		return dotlEPERM
+	case errors.Is(err, os.ErrPermission):
+		return dotlEACCES
+	case errors.Is(err, vfs.ErrNoAttribute):
+		return dotlENODATA
+	case errors.Is(err, vfs.ErrNotImplemented):
+		return dotlEOPNOTSUPP
+	}
+
+	log.Printf("9P2000.L request failed: %v", err)
+	return dotlEIO
+}
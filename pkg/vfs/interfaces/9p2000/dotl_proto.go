@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 9P2000.L message types. Each R-message is its T-message plus one.
+const (
+	dotlTlerror      uint8 = 6
+	dotlRlerror      uint8 = 7
+	dotlTstatfs      uint8 = 8
+	dotlTlopen       uint8 = 12
+	dotlTlcreate     uint8 = 14
+	dotlTsymlink     uint8 = 16
+	dotlTmknod       uint8 = 18
+	dotlTrename      uint8 = 20
+	dotlTreadlink    uint8 = 22
+	dotlTgetattr     uint8 = 24
+	dotlTsetattr     uint8 = 26
+	dotlTxattrwalk   uint8 = 30
+	dotlTxattrcreate uint8 = 32
+	dotlTreaddir     uint8 = 40
+	dotlTfsync       uint8 = 50
+	dotlTlock        uint8 = 52
+	dotlTgetlock     uint8 = 54
+	dotlTlink        uint8 = 70
+	dotlTmkdir       uint8 = 72
+	dotlTrenameat    uint8 = 74
+	dotlTunlinkat    uint8 = 76
+	dotlTversion     uint8 = 100
+	dotlTauth        uint8 = 102
+	dotlTattach      uint8 = 104
+	dotlTflush       uint8 = 108
+	dotlTwalk        uint8 = 110
+	dotlTread        uint8 = 116
+	dotlTwrite       uint8 = 118
+	dotlTclunk       uint8 = 120
+	dotlTremove      uint8 = 122
+)
+
+// dotlVersion is the protocol version string negotiated by Linux v9fs clients
+const dotlVersion = "9P2000.L"
+
+// Qid types
+const (
+	dotlQTDIR     uint8 = 0x80
+	dotlQTSYMLINK uint8 = 0x02
+	dotlQTFILE    uint8 = 0x00
+)
+
+// dotlNoFid is the fid value meaning "no fid"
+const dotlNoFid uint32 = ^uint32(0)
+
+// dotlHeaderSize is the size of the size[4] type[1] tag[2] message header
+const dotlHeaderSize = 7
+
+// dotlQid identifies a file on the server
+type dotlQid struct {
+	Type    uint8
+	Version uint32
+	Path    uint64
+}
+
+// errShortMessage is returned when a message body ends before all fields were decoded
+var errShortMessage = errors.New("9p message too short")
+
+// dotlDecoder reads little-endian 9P fields from a message body. The first
+// decoding error is remembered and all later reads return zero values.
+type dotlDecoder struct {
+	data []byte
+	err  error
+}
+
+func (d *dotlDecoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.data) < n {
+		d.err = errShortMessage
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *dotlDecoder) u8() uint8 {
+	if b := d.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *dotlDecoder) u16() uint16 {
+	if b := d.take(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *dotlDecoder) u32() uint32 {
+	if b := d.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *dotlDecoder) u64() uint64 {
+	if b := d.take(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *dotlDecoder) str() string {
+	n := d.u16()
+	return string(d.take(int(n)))
+}
+
+func (d *dotlDecoder) bytes(n uint32) []byte {
+	return d.take(int(n))
+}
+
+// dotlEncoder builds a little-endian 9P message body
+type dotlEncoder struct {
+	buf []byte
+}
+
+func (e *dotlEncoder) u8(v uint8) *dotlEncoder {
+	e.buf = append(e.buf, v)
+	return e
+}
+
+func (e *dotlEncoder) u16(v uint16) *dotlEncoder {
+	e.buf = binary.LittleEndian.AppendUint16(e.buf, v)
+	return e
+}
+
+func (e *dotlEncoder) u32(v uint32) *dotlEncoder {
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+	return e
+}
+
+func (e *dotlEncoder) u64(v uint64) *dotlEncoder {
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, v)
+	return e
+}
+
+func (e *dotlEncoder) str(s string) *dotlEncoder {
+	e.u16(uint16(len(s)))
+	e.buf = append(e.buf, s...)
+	return e
+}
+
+func (e *dotlEncoder) qid(q dotlQid) *dotlEncoder {
+	return e.u8(q.Type).u32(q.Version).u64(q.Path)
+}
+
+func (e *dotlEncoder) bytes(b []byte) *dotlEncoder {
+	e.u32(uint32(len(b)))
+	e.buf = append(e.buf, b...)
+	return e
+}
+
+// readDotlMessage reads one raw message (including its header) from r
+func readDotlMessage(r io.Reader, msize uint32) ([]byte, error) {
+	var sizeBuf [4]byte
+	if _, err := io.ReadFull(r, sizeBuf[:]); err != nil {
+		return nil, err
+	}
+
+	size := binary.LittleEndian.Uint32(sizeBuf[:])
+	if size < dotlHeaderSize || size > msize {
+		return nil, fmt.Errorf("invalid 9p message size %d", size)
+	}
+
+	msg := make([]byte, size)
+	copy(msg, sizeBuf[:])
+	if _, err := io.ReadFull(r, msg[4:]); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// parseDotlHeader splits a raw message into its type, tag and body
+func parseDotlHeader(msg []byte) (msgType uint8, tag uint16, body []byte) {
+	return msg[4], binary.LittleEndian.Uint16(msg[5:7]), msg[dotlHeaderSize:]
+}
+
+// writeDotlMessage frames and writes a single message to w
+func writeDotlMessage(w io.Writer, msgType uint8, tag uint16, body []byte) error {
+	msg := make([]byte, dotlHeaderSize, dotlHeaderSize+len(body))
+	binary.LittleEndian.PutUint32(msg[0:4], uint32(dotlHeaderSize+len(body)))
+	msg[4] = msgType
+	binary.LittleEndian.PutUint16(msg[5:7], tag)
+	msg = append(msg, body...)
+
+	_, err := w.Write(msg)
+	return err
+}
+
+// peekDotlVersion reports whether a raw message is a Tversion requesting 9P2000.L
+func peekDotlVersion(msg []byte) bool {
+	if len(msg) < dotlHeaderSize {
+		return false
+	}
+
+	msgType, _, body := parseDotlHeader(msg)
+	if msgType != dotlTversion {
+		return false
+	}
+
+	d := &dotlDecoder{data: body}
+	d.u32()
+	version := d.str()
+	return d.err == nil && version == dotlVersion
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfsdb"
+)
+
+// dotlTestClient is a minimal 9P2000.L client used to drive the server in tests
+type dotlTestClient struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+// rpc sends a T-message and returns the type and body of the reply
+func (c *dotlTestClient) rpc(msgType uint8, body *dotlEncoder) (uint8, *dotlDecoder) {
+	c.t.Helper()
+	if err := writeDotlMessage(c.conn, msgType, 1, body.buf); err != nil {
+		c.t.Fatalf("Failed to send message %d: %v", msgType, err)
+	}
+	msg, err := readDotlMessage(c.conn, dotlMaxMsize)
+	if err != nil {
+		c.t.Fatalf("Failed to read reply to message %d: %v", msgType, err)
+	}
+	replyType, _, replyBody := parseDotlHeader(msg)
+	return replyType, &dotlDecoder{data: replyBody}
+}
+
+// ok sends a T-message and fails the test unless the matching R-message is returned
+func (c *dotlTestClient) ok(msgType uint8, body *dotlEncoder) *dotlDecoder {
+	c.t.Helper()
+	replyType, d := c.rpc(msgType, body)
+	if replyType != msgType+1 {
+		c.t.Fatalf("Message %d failed: reply %d, errno %d", msgType, replyType, d.u32())
+	}
+	return d
+}
+
+// lerror sends a T-message and returns the errno of the expected Rlerror
+func (c *dotlTestClient) lerror(msgType uint8, body *dotlEncoder) uint32 {
+	c.t.Helper()
+	replyType, d := c.rpc(msgType, body)
+	if replyType != dotlRlerror {
+		c.t.Fatalf("Message %d succeeded, expected an error", msgType)
+	}
+	return d.u32()
+}
+
+func TestDotlServer(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "vfsdb-dotl-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	vfsImpl, err := vfsdb.NewFromPath(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create VFS: %v", err)
+	}
+	defer vfsImpl.Destroy()
+
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		defer serverConn.Close()
+		msg, err := readDotlMessage(serverConn, dotlMaxMsize)
+		if err != nil || !peekDotlVersion(msg) {
+			return
+		}
+		serveDotl(vfsImpl, serverConn, msg)
+	}()
+
+	c := &dotlTestClient{t: t, conn: clientConn}
+
+	// Version negotiation
+	d := c.ok(dotlTversion, (&dotlEncoder{}).u32(8192).str(dotlVersion))
+	if msize, version := d.u32(), d.str(); msize != 8192 || version != dotlVersion {
+		t.Fatalf("Unexpected Rversion: %d %q", msize, version)
+	}
+
+	// Attach as uid 1000 to fid 0
+	if errno := c.lerror(dotlTauth, (&dotlEncoder{}).u32(1).str("user").str("").u32(1000)); errno != dotlEOPNOTSUPP {
+		t.Errorf("Expected EOPNOTSUPP for Tauth, got %d", errno)
+	}
+	d = c.ok(dotlTattach, (&dotlEncoder{}).u32(0).u32(dotlNoFid).str("user").str("").u32(1000))
+	if qid := (dotlQid{Type: d.u8()}); qid.Type != dotlQTDIR {
+		t.Errorf("Root qid is not a directory: %x", qid.Type)
+	}
+
+	t.Run("CreateWriteRead", func(t *testing.T) {
+		// Clone the root to fid 1 and create a file through it
+		c.ok(dotlTwalk, (&dotlEncoder{}).u32(0).u32(1).u16(0))
+		c.ok(dotlTlcreate, (&dotlEncoder{}).u32(1).str("hello.txt").u32(uint32(os.O_RDWR)).u32(0640).u32(100))
+
+		d := c.ok(dotlTwrite, (&dotlEncoder{}).u32(1).u64(0).bytes([]byte("hello world")))
+		if n := d.u32(); n != 11 {
+			t.Errorf("Expected 11 bytes written, got %d", n)
+		}
+		c.ok(dotlTwrite, (&dotlEncoder{}).u32(1).u64(6).bytes([]byte("there")))
+
+		d = c.ok(dotlTread, (&dotlEncoder{}).u32(1).u64(0).u32(100))
+		if data := d.bytes(d.u32()); !bytes.Equal(data, []byte("hello there")) {
+			t.Errorf("Unexpected file content: %q", data)
+		}
+		c.ok(dotlTclunk, (&dotlEncoder{}).u32(1))
+
+		// The new file has the requested mode and ownership
+		c.ok(dotlTwalk, (&dotlEncoder{}).u32(0).u32(2).u16(1).str("hello.txt"))
+		d = c.ok(dotlTgetattr, (&dotlEncoder{}).u32(2).u64(dotlGetattrBasic))
+		d.u64()
+		d.u8()
+		d.u32()
+		d.u64()
+		mode, uid, gid := d.u32(), d.u32(), d.u32()
+		if mode != dotlSIFREG|0640 || uid != 1000 || gid != 100 {
+			t.Errorf("Unexpected attributes: mode %o uid %d gid %d", mode, uid, gid)
+		}
+
+		// Truncate through Tsetattr
+		c.ok(dotlTsetattr, (&dotlEncoder{}).u32(2).u32(dotlSetattrSize|dotlSetattrMode).u32(0600).u32(0).u32(0).u64(5).u64(0).u64(0).u64(0).u64(0))
+		data, err := vfsImpl.FileRead("/hello.txt")
+		if err != nil || string(data) != "hello" {
+			t.Errorf("Unexpected content after truncate: %q, %v", data, err)
+		}
+		c.ok(dotlTclunk, (&dotlEncoder{}).u32(2))
+	})
+
+	t.Run("DirectoriesAndSymlinks", func(t *testing.T) {
+		c.ok(dotlTmkdir, (&dotlEncoder{}).u32(0).str("docs").u32(0755).u32(0))
+		if errno := c.lerror(dotlTmkdir, (&dotlEncoder{}).u32(0).str("docs").u32(0755).u32(0)); errno != dotlEEXIST {
+			t.Errorf("Expected EEXIST, got %d", errno)
+		}
+
+		c.ok(dotlTsymlink, (&dotlEncoder{}).u32(0).str("link").str("hello.txt").u32(0))
+		c.ok(dotlTwalk, (&dotlEncoder{}).u32(0).u32(3).u16(1).str("link"))
+		d := c.ok(dotlTreadlink, (&dotlEncoder{}).u32(3))
+		if target := d.str(); target != "hello.txt" {
+			t.Errorf("Unexpected symlink target: %q", target)
+		}
+		c.ok(dotlTclunk, (&dotlEncoder{}).u32(3))
+
+		c.ok(dotlTrenameat, (&dotlEncoder{}).u32(0).str("hello.txt").u32(0).str("greeting.txt"))
+
+		// List the root directory
+		c.ok(dotlTwalk, (&dotlEncoder{}).u32(0).u32(4).u16(0))
+		c.ok(dotlTlopen, (&dotlEncoder{}).u32(4).u32(uint32(os.O_RDONLY)))
+		d = c.ok(dotlTreaddir, (&dotlEncoder{}).u32(4).u64(0).u32(4096))
+		entries := &dotlDecoder{data: d.bytes(d.u32())}
+		names := map[string]uint8{}
+		for len(entries.data) > 0 {
+			entries.u8()
+			entries.u32()
+			entries.u64()
+			entries.u64()
+			dtype := entries.u8()
+			names[entries.str()] = dtype
+		}
+		c.ok(dotlTclunk, (&dotlEncoder{}).u32(4))
+		if names["docs"] != dotlDTDir || names["link"] != dotlDTLnk || names["greeting.txt"] != dotlDTReg {
+			t.Errorf("Unexpected directory listing: %v", names)
+		}
+		if _, ok := names["hello.txt"]; ok {
+			t.Errorf("Renamed file still listed")
+		}
+
+		if errno := c.lerror(dotlTunlinkat, (&dotlEncoder{}).u32(0).str("docs").u32(0)); errno != dotlEISDIR {
+			t.Errorf("Expected EISDIR, got %d", errno)
+		}
+		c.ok(dotlTunlinkat, (&dotlEncoder{}).u32(0).str("docs").u32(dotlATRemoveDir))
+		if vfsImpl.Exists("/docs") {
+			t.Errorf("Directory was not removed")
+		}
+	})
+
+	t.Run("ExtendedAttributes", func(t *testing.T) {
+		c.ok(dotlTwalk, (&dotlEncoder{}).u32(0).u32(5).u16(1).str("greeting.txt"))
+
+		// Set user.color=blue
+		c.ok(dotlTwalk, (&dotlEncoder{}).u32(5).u32(6).u16(0))
+		c.ok(dotlTxattrcreate, (&dotlEncoder{}).u32(6).str("user.color").u64(4).u32(0))
+		c.ok(dotlTwrite, (&dotlEncoder{}).u32(6).u64(0).bytes([]byte("blue")))
+		c.ok(dotlTclunk, (&dotlEncoder{}).u32(6))
+
+		// Read it back
+		d := c.ok(dotlTxattrwalk, (&dotlEncoder{}).u32(5).u32(7).str("user.color"))
+		if size := d.u64(); size != 4 {
+			t.Errorf("Unexpected attribute size %d", size)
+		}
+		d = c.ok(dotlTread, (&dotlEncoder{}).u32(7).u64(0).u32(100))
+		if value := d.bytes(d.u32()); string(value) != "blue" {
+			t.Errorf("Unexpected attribute value %q", value)
+		}
+		c.ok(dotlTclunk, (&dotlEncoder{}).u32(7))
+
+		// List attributes
+		d = c.ok(dotlTxattrwalk, (&dotlEncoder{}).u32(5).u32(7).str(""))
+		d.u64()
+		d = c.ok(dotlTread, (&dotlEncoder{}).u32(7).u64(0).u32(100))
+		if list := d.bytes(d.u32()); string(list) != "user.color\x00" {
+			t.Errorf("Unexpected attribute list %q", list)
+		}
+		c.ok(dotlTclunk, (&dotlEncoder{}).u32(7))
+
+		// Only the user namespace is supported
+		if errno := c.lerror(dotlTxattrcreate, (&dotlEncoder{}).u32(5).str("security.selinux").u64(4).u32(0)); errno != dotlEOPNOTSUPP {
+			t.Errorf("Expected EOPNOTSUPP, got %d", errno)
+		}
+		if errno := c.lerror(dotlTxattrwalk, (&dotlEncoder{}).u32(5).u32(7).str("user.missing")); errno != dotlENODATA {
+			t.Errorf("Expected ENODATA, got %d", errno)
+		}
+		c.ok(dotlTclunk, (&dotlEncoder{}).u32(5))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if errno := c.lerror(dotlTwalk, (&dotlEncoder{}).u32(0).u32(8).u16(1).str("missing")); errno != dotlENOENT {
+			t.Errorf("Expected ENOENT, got %d", errno)
+		}
+		if errno := c.lerror(dotlTgetattr, (&dotlEncoder{}).u32(99).u64(dotlGetattrBasic)); errno != dotlEBADF {
+			t.Errorf("Expected EBADF, got %d", errno)
+		}
+	})
+}
+
+func TestPeekDotlVersion(t *testing.T) {
+	encode := func(msgType uint8, version string) []byte {
+		var buf bytes.Buffer
+		writeDotlMessage(&buf, msgType, 0xffff, (&dotlEncoder{}).u32(8192).str(version).buf)
+		return buf.Bytes()
+	}
+
+	if !peekDotlVersion(encode(dotlTversion, "9P2000.L")) {
+		t.Errorf("9P2000.L Tversion not detected")
+	}
+	if peekDotlVersion(encode(dotlTversion, "9P2000")) {
+		t.Errorf("9P2000 Tversion detected as 9P2000.L")
+	}
+	if peekDotlVersion(encode(dotlTattach, "9P2000.L")) {
+		t.Errorf("Non-version message detected as 9P2000.L")
+	}
+}
@@ -151,16 +151,8 @@ func (f *VFSDBFile) Stat() proto.Stat {
 		return f.BaseFile.Stat()
 	}
 
-	// Update the stat with the file metadata
-	metadata := entry.GetMetadata()
-	stat := f.BaseFile.Stat()
-	stat.Length = metadata.Size
-	stat.Atime = uint32(metadata.AccessedAt)
-	stat.Mtime = uint32(metadata.ModifiedAt)
-	stat.Mode = metadata.Mode
-	stat.Name = path.Base(f.path)
-	stat.Uid = metadata.Owner
-	stat.Gid = metadata.Group
+	// Build the stat from the stored file metadata
+	stat := statFromMetadata(entry.GetMetadata(), path.Base(f.path))
 
 	log.Printf("DEBUG: File %s stat: Mode=%o, Owner=%s, Group=%s", 
 		f.path, stat.Mode, stat.Uid, stat.Gid)
@@ -168,6 +160,15 @@ func (f *VFSDBFile) Stat() proto.Stat {
 	return stat
 }
 
+// WriteStat implements fs.FSNode.WriteStat, persisting mode and ownership changes
+func (f *VFSDBFile) WriteStat(s *proto.Stat) error {
+	if err := applyWriteStat(f.vfsImpl, f.path, s); err != nil {
+		log.Printf("Failed to update stat for file %s: %v", f.path, err)
+		return err
+	}
+	return f.BaseFile.WriteStat(s)
+}
+
 // createVFSDBFile returns a function that creates a VFSDBFile
 func createVFSDBFile(vfsImpl vfs.VFSImplementation) func(fs *fs.FS, parent fs.Dir, user, name string, perm uint32, mode uint8) (fs.File, error) {
 	return func(fsys *fs.FS, parent fs.Dir, user, name string, perm uint32, mode uint8) (fs.File, error) {
@@ -211,6 +212,11 @@ func createVFSDBFile(vfsImpl vfs.VFSImplementation) func(fs *fs.FS, parent fs.Di
 			return nil, fmt.Errorf("failed to create file in vfsdb: %w", err)
 		}
 		
+		// Store the permissions requested by the client
+		if err := vfsImpl.Chmod(filePath, perm&vfs.ModePermAll); err != nil {
+			log.Printf("Failed to set permissions on file %s: %v", filePath, err)
+		}
+		
 		log.Printf("Successfully created file %s", filePath)
 		return file, nil
 	}
@@ -7,6 +7,7 @@ toolchain go1.24.1
 require (
 	github.com/freeflowuniverse/herolauncher v0.0.0-20250315180128-b9a3b6627b56
 	github.com/knusbaum/go9p v1.18.0
+	github.com/stretchr/testify v1.10.0
 )
 
 require (
@@ -16,8 +17,7 @@ require (
 	github.com/emersion/go-sasl v0.0.0-20220912192320-0145f2c60ead // indirect
 	github.com/fhs/mux9p v0.3.1 // indirect
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
-	github.com/stretchr/testify v1.10.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
-replace github.com/knusbaum/go9p => ../../../../../knusbaum/go9p
+replace github.com/freeflowuniverse/herolauncher => ../../../..
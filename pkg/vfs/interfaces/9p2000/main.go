@@ -3,12 +3,12 @@ package main
 import (
 	"flag"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfsdb"
-	"github.com/knusbaum/go9p"
 	"github.com/knusbaum/go9p/fs"
 )
 
@@ -17,11 +17,19 @@ func main() {
 	var listenAddr string
 	var dbPath string
 	var verbose bool
+	var allow string
 	flag.StringVar(&listenAddr, "listen", "0.0.0.0:9999", "Address to listen on")
 	flag.StringVar(&dbPath, "db", "./vfsdb", "Path to database")
 	flag.BoolVar(&verbose, "verbose", true, "Enable verbose logging")
+	flag.StringVar(&allow, "allow", "127.0.0.1,::1", "Comma separated IPs/CIDRs allowed to connect (use 0.0.0.0/0,::/0 to allow everyone)")
 	flag.Parse()
 	
+	// Parse the host allowlist
+	allowlist, err := ParseHostAllowlist(allow)
+	if err != nil {
+		log.Fatalf("Invalid allowlist: %v", err)
+	}
+	
 	// Set up logging
 	if verbose {
 		log.SetFlags(log.LstdFlags | log.Lshortfile)
@@ -34,9 +42,16 @@ func main() {
 	}
 	defer vfsImpl.Destroy()
 
+	// Report the stored permissions of the root directory
+	rootEntry, err := vfsImpl.RootGet()
+	if err != nil {
+		log.Fatalf("Failed to get root directory: %v", err)
+	}
+	rootPerm := rootEntry.GetMetadata().Permissions()
+
 	// Create a new 9p filesystem
 	// Use "nobody" as the default user for better compatibility with Linux 9p mounts
-	fsys, root := fs.NewFS("nobody", "nobody", 0777,
+	fsys, root := fs.NewFS("nobody", "nobody", rootPerm,
 		fs.WithCreateFile(createVFSDBFile(vfsImpl)),
 		fs.WithCreateDir(createVFSDBDir(vfsImpl)),
 		fs.WithRemoveFile(removeVFSDBFile(vfsImpl)),
@@ -45,13 +60,16 @@ func main() {
 
 	// Start serving the 9p filesystem with enhanced logging
 	log.Printf("Starting 9p server on %s with root directory: %s", listenAddr, root.Stat().Name)
-	log.Printf("Server configuration: verbose=%v", verbose)
-	log.Printf("IMPORTANT: When mounting this 9p filesystem from Linux, use: mount -t 9p -o version=9p2000,trans=tcp,uname=nobody <server-ip>:9999 /mnt/myvfs")
+	log.Printf("Server configuration: verbose=%v, allow=%s", verbose, allowlist)
+	log.Printf("IMPORTANT: When mounting this 9p filesystem from Linux, use: mount -t 9p -o version=9p2000.L,trans=tcp,port=9999 <server-ip> /mnt/myvfs")
 	log.Printf("For debugging, you can add ,debug=0x8000 to the mount options")
 	
 	go func() {
 		log.Printf("Server listening on %s", listenAddr)
-		if err := go9p.Serve(listenAddr, fsys.Server()); err != nil {
+		handle := func(conn net.Conn) error {
+			return serveConn(conn, vfsImpl, fsys.Server())
+		}
+		if err := serveWithAllowlist(listenAddr, allowlist, handle); err != nil {
 			log.Fatalf("Failed to serve: %v", err)
 		}
 	}()
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"net"
 	"os"
 	"testing"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"github.com/knusbaum/go9p"
 	"github.com/knusbaum/go9p/fs"
 	"github.com/knusbaum/go9p/client"
+	"github.com/knusbaum/go9p/proto"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -47,10 +49,14 @@ func TestVFSDB9P(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 
 	// Connect a client to the server
-	conn, err := client.Dial("tcp", addr, "user", "")
+	netConn, err := net.Dial("tcp", addr)
 	if err != nil {
 		t.Fatalf("Failed to connect to server: %v", err)
 	}
+	conn, err := client.NewClient(netConn, "user", "")
+	if err != nil {
+		t.Fatalf("Failed to attach to server: %v", err)
+	}
 	// No need to close the client as it doesn't have a Close method
 
 	// Test basic operations
@@ -71,6 +77,11 @@ func TestVFSDB9P(t *testing.T) {
 	testFileName := "/testdir/testfile_" + time.Now().Format("20060102150405")
 	f, err := conn.Create(testFileName, 0644)
 	assert.NoError(t, err, "Failed to create file")
+	// Files returned by Create have no I/O unit in go9p, so writes to them
+	// send nothing; reopen the file to write to it
+	f.Close()
+	f, err = conn.Open(testFileName, proto.Owrite)
+	assert.NoError(t, err, "Failed to open file for writing")
 
 	// Write to the file
 	testData := []byte("Hello, 9p2000!")
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log"
+	"os/user"
+	"strconv"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+	"github.com/knusbaum/go9p/proto"
+)
+
+// statFromMetadata builds a 9p stat from VFS metadata, reporting the entry's
+// stored permission bits and ownership
+func statFromMetadata(metadata *vfs.Metadata, name string) proto.Stat {
+	mode := metadata.Permissions()
+	if metadata.IsDir() {
+		mode |= proto.DMDIR
+	}
+
+	owner := ownerName(metadata)
+	return proto.Stat{
+		Type: 0,
+		Dev:  0,
+		Qid: proto.Qid{
+			// go9p has no QT constants, the type is the top byte of the mode
+			Qtype: uint8(mode >> 24),
+			Vers:  uint32(metadata.ModifiedAt),
+			Uid:   uint64(metadata.ID),
+		},
+		Mode:   mode,
+		Atime:  uint32(metadata.AccessedAt),
+		Mtime:  uint32(metadata.ModifiedAt),
+		Length: metadata.Size,
+		Name:   name,
+		Uid:    owner,
+		Gid:    groupName(metadata),
+		Muid:   owner,
+	}
+}
+
+// ownerName returns the owner name of an entry, falling back to the numeric uid
+func ownerName(metadata *vfs.Metadata) string {
+	if metadata.Owner != "" {
+		return metadata.Owner
+	}
+	return strconv.FormatUint(uint64(metadata.UID), 10)
+}
+
+// groupName returns the group name of an entry, falling back to the numeric gid
+func groupName(metadata *vfs.Metadata) string {
+	if metadata.Group != "" {
+		return metadata.Group
+	}
+	return strconv.FormatUint(uint64(metadata.GID), 10)
+}
+
+// applyWriteStat persists the mode and ownership fields of a 9p wstat request
+// to the VFS. Fields set to their "don't touch" value are left unchanged.
+func applyWriteStat(vfsImpl vfs.VFSImplementation, path string, s *proto.Stat) error {
+	if s.Mode != ^uint32(0) {
+		log.Printf("Changing mode of %s to %o", path, s.Mode&vfs.ModePermAll)
+		if err := vfsImpl.Chmod(path, s.Mode&vfs.ModePermAll); err != nil {
+			return err
+		}
+	}
+
+	if s.Uid == "" && s.Gid == "" {
+		return nil
+	}
+
+	entry, err := vfsImpl.Get(path)
+	if err != nil {
+		return err
+	}
+	metadata := entry.GetMetadata()
+
+	uid, gid := metadata.UID, metadata.GID
+	if s.Uid != "" {
+		if id, ok := lookupID(s.Uid, false); ok {
+			uid = id
+		}
+	}
+	if s.Gid != "" {
+		if id, ok := lookupID(s.Gid, true); ok {
+			gid = id
+		}
+	}
+
+	if uid == metadata.UID && gid == metadata.GID {
+		return nil
+	}
+
+	log.Printf("Changing ownership of %s to %d:%d", path, uid, gid)
+	return vfsImpl.Chown(path, uid, gid)
+}
+
+// lookupID resolves a 9p user or group name to a numeric ID. Numeric names are
+// used as-is, other names are resolved through the host user database.
+func lookupID(name string, group bool) (uint32, bool) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), true
+	}
+
+	var idStr string
+	if group {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return 0, false
+		}
+		idStr = g.Gid
+	} else {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return 0, false
+		}
+		idStr = u.Uid
+	}
+
+	id, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(id), true
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+	vfsftp "github.com/freeflowuniverse/herolauncher/pkg/vfs/interfaces/ftp"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfsdb"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfslocal"
+)
+
+func main() {
+	// Parse command line flags
+	listenAddr := flag.String("listen", ":2121", "Address to listen on")
+	dbPath := flag.String("db", "", "Path to a vfsdb database to serve")
+	rootDir := flag.String("dir", "", "Local directory to serve (instead of -db)")
+	users := flag.String("users", "", "Comma separated virtual users as name:password[:root[:ro]] (required)")
+	certFile := flag.String("cert", "", "TLS certificate file, enables FTPS (AUTH TLS)")
+	keyFile := flag.String("key", "", "TLS key file")
+	requireTLS := flag.Bool("require-tls", false, "Refuse logins and transfers without TLS")
+	pasvPorts := flag.String("pasv-ports", "", "Passive port range, e.g. 30000-30100 (default: any port)")
+	publicIP := flag.String("public-ip", "", "IP address announced in PASV replies when behind NAT")
+	debug := flag.Bool("debug", false, "Log every FTP command")
+	flag.Parse()
+
+	if *users == "" || (*dbPath == "") == (*rootDir == "") {
+		fmt.Println("Error: -users and exactly one of -db or -dir are required")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// Set up the users
+	parsedUsers, err := vfsftp.ParseUsers(*users)
+	if err != nil {
+		log.Fatalf("Invalid users: %v", err)
+	}
+	userStore := vfsftp.NewUserStore()
+	for _, user := range parsedUsers {
+		if err := userStore.Add(user); err != nil {
+			log.Fatalf("Invalid user %s: %v", user.Name, err)
+		}
+	}
+
+	// Create the VFS backend
+	var vfsImpl vfs.VFSImplementation
+	if *dbPath != "" {
+		vfsImpl, err = vfsdb.NewFromPath(*dbPath)
+		log.Printf("Serving vfsdb database at %s", *dbPath)
+	} else {
+		vfsImpl, err = vfslocal.New(*rootDir)
+		log.Printf("Serving local directory %s", *rootDir)
+	}
+	if err != nil {
+		log.Fatalf("Failed to create VFS: %v", err)
+	}
+	defer vfsImpl.Destroy()
+
+	config := vfsftp.Config{
+		Addr:       *listenAddr,
+		PublicIP:   *publicIP,
+		RequireTLS: *requireTLS,
+		Debug:      *debug,
+	}
+
+	if *pasvPorts != "" {
+		if _, err := fmt.Sscanf(*pasvPorts, "%d-%d", &config.PassivePortStart, &config.PassivePortEnd); err != nil {
+			log.Fatalf("Invalid passive port range %q: %v", *pasvPorts, err)
+		}
+	}
+
+	if *certFile != "" {
+		cert, err := tls.LoadX509KeyPair(*certFile, *keyFile)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate: %v", err)
+		}
+		config.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	} else if *requireTLS {
+		log.Fatalf("-require-tls needs -cert and -key")
+	}
+
+	server := vfsftp.NewServer(vfsImpl, userStore, config)
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != vfsftp.ErrServerClosed {
+			log.Fatalf("FTP server failed: %v", err)
+		}
+	}()
+
+	// Wait for interrupt signal
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+	log.Println("Shutting down...")
+	server.Close()
+}
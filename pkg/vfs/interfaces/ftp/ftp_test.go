@@ -0,0 +1,257 @@
+package vfsftp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfsdb"
+)
+
+// testClient is a minimal FTP client for driving the server in tests
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	text *textproto.Conn
+}
+
+func dialTestClient(t *testing.T, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	c := &testClient{t: t, conn: conn, text: textproto.NewConn(conn)}
+	c.expect(220)
+	return c
+}
+
+// cmd sends a command and checks the reply code
+func (c *testClient) cmd(code int, format string, args ...interface{}) string {
+	c.t.Helper()
+	if _, err := c.text.Cmd(format, args...); err != nil {
+		c.t.Fatalf("Failed to send %q: %v", format, err)
+	}
+	return c.expect(code)
+}
+
+func (c *testClient) expect(code int) string {
+	c.t.Helper()
+	_, msg, err := c.text.ReadResponse(code)
+	if err != nil {
+		c.t.Fatalf("Unexpected reply: %v", err)
+	}
+	return msg
+}
+
+// startTLS upgrades the control connection with AUTH TLS
+func (c *testClient) startTLS() {
+	c.t.Helper()
+	c.cmd(234, "AUTH TLS")
+	c.conn = tls.Client(c.conn, &tls.Config{InsecureSkipVerify: true})
+	c.text = textproto.NewConn(c.conn)
+}
+
+// data opens a passive data connection, runs the command and returns what was received
+func (c *testClient) data(upload []byte, format string, args ...interface{}) []byte {
+	c.t.Helper()
+	msg := c.cmd(229, "EPSV")
+	port := strings.TrimSuffix(msg[strings.Index(msg, "|||")+3:], "|)")
+
+	host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+	dataConn, err := net.Dial("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		c.t.Fatalf("Failed to open data connection: %v", err)
+	}
+	if _, ok := c.conn.(*tls.Conn); ok {
+		dataConn = tls.Client(dataConn, &tls.Config{InsecureSkipVerify: true})
+	}
+
+	c.cmd(150, format, args...)
+
+	var received []byte
+	if upload != nil {
+		dataConn.Write(upload)
+	} else {
+		received, _ = io.ReadAll(dataConn)
+	}
+	dataConn.Close()
+	c.expect(226)
+	return received
+}
+
+func newTestServer(t *testing.T, config Config) (*Server, string, func()) {
+	t.Helper()
+	tempDir, err := os.MkdirTemp("", "vfsftp-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+
+	vfsImpl, err := vfsdb.NewFromPath(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to create VFS: %v", err)
+	}
+	if _, err := vfsImpl.DirCreate("/scans"); err != nil {
+		t.Fatalf("Failed to create user root: %v", err)
+	}
+
+	users := NewUserStore()
+	users.Add(User{Name: "scanner", Password: "secret", Root: "/scans"})
+	users.Add(User{Name: "guest", Password: "guest", Root: "/scans", ReadOnly: true})
+
+	server := NewServer(vfsImpl, users, config)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go server.Serve(listener)
+
+	return server, listener.Addr().String(), func() {
+		server.Close()
+		vfsImpl.Destroy()
+		os.RemoveAll(tempDir)
+	}
+}
+
+func TestFTPServer(t *testing.T) {
+	server, addr, cleanup := newTestServer(t, Config{})
+	defer cleanup()
+
+	c := dialTestClient(t, addr)
+
+	// Commands other than login require authentication
+	c.cmd(530, "PWD")
+	c.cmd(331, "USER scanner")
+	c.cmd(530, "PASS wrong")
+	c.cmd(331, "USER scanner")
+	c.cmd(230, "PASS secret")
+	c.cmd(200, "TYPE I")
+
+	// Upload, list and download a file
+	c.data([]byte("scanned page"), "STOR page1.pdf")
+	if listing := string(c.data(nil, "LIST")); !strings.Contains(listing, "page1.pdf") || !strings.HasPrefix(listing, "-") {
+		t.Errorf("Unexpected listing: %q", listing)
+	}
+	if content := string(c.data(nil, "RETR page1.pdf")); content != "scanned page" {
+		t.Errorf("Unexpected content: %q", content)
+	}
+	if size := c.cmd(213, "SIZE page1.pdf"); size != "12" {
+		t.Errorf("Unexpected size: %s", size)
+	}
+
+	// The upload landed below the user's root in the VFS
+	data, err := server.vfsImpl.FileRead("/scans/page1.pdf")
+	if err != nil || string(data) != "scanned page" {
+		t.Errorf("File not stored in the VFS: %q, %v", data, err)
+	}
+
+	// Directories, renames and deletes
+	c.cmd(257, "MKD archive")
+	c.cmd(250, "CWD archive")
+	c.cmd(257, "PWD")
+	c.cmd(250, "CDUP")
+	c.cmd(350, "RNFR page1.pdf")
+	c.cmd(250, "RNTO archive/page1.pdf")
+	if names := string(c.data(nil, "NLST archive")); strings.TrimSpace(names) != "page1.pdf" {
+		t.Errorf("Unexpected name list: %q", names)
+	}
+	c.cmd(550, "RMD archive")
+	c.cmd(250, "DELE archive/page1.pdf")
+	c.cmd(250, "RMD archive")
+
+	// Users cannot escape their root
+	c.cmd(250, "CWD ../..")
+	if pwd := c.cmd(257, "PWD"); !strings.HasPrefix(pwd, `"/"`) {
+		t.Errorf("Escaped user root: %s", pwd)
+	}
+	c.cmd(221, "QUIT")
+
+	// Read only users may not write
+	guest := dialTestClient(t, addr)
+	guest.cmd(331, "USER guest")
+	guest.cmd(230, "PASS guest")
+	guest.cmd(550, "MKD forbidden")
+	guest.cmd(550, "DELE anything")
+	guest.cmd(221, "QUIT")
+}
+
+func TestFTPServerTLS(t *testing.T) {
+	_, addr, cleanup := newTestServer(t, Config{TLSConfig: testTLSConfig(t), RequireTLS: true})
+	defer cleanup()
+
+	c := dialTestClient(t, addr)
+	c.cmd(530, "USER scanner")
+	c.startTLS()
+	c.cmd(331, "USER scanner")
+	c.cmd(230, "PASS secret")
+	c.cmd(200, "PBSZ 0")
+	c.cmd(534, "PROT C")
+	c.cmd(200, "PROT P")
+
+	c.data([]byte("secret scan"), "STOR secure.pdf")
+	if content := string(c.data(nil, "RETR secure.pdf")); content != "secret scan" {
+		t.Errorf("Unexpected content: %q", content)
+	}
+	c.cmd(221, "QUIT")
+}
+
+func TestParseUsers(t *testing.T) {
+	users, err := ParseUsers("scanner:secret:/scans, guest:guest:/pub:ro,admin:pw")
+	if err != nil {
+		t.Fatalf("Failed to parse users: %v", err)
+	}
+	if len(users) != 3 {
+		t.Fatalf("Expected 3 users, got %d", len(users))
+	}
+	if users[0].Root != "/scans" || users[0].ReadOnly {
+		t.Errorf("Unexpected first user: %+v", users[0])
+	}
+	if !users[1].ReadOnly {
+		t.Errorf("Expected guest to be read only")
+	}
+	if users[2].Root != "/" {
+		t.Errorf("Expected default root, got %s", users[2].Root)
+	}
+
+	for _, spec := range []string{"nopassword", "a:b:/c:rw", ":pw"} {
+		if _, err := ParseUsers(spec); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+// testTLSConfig returns a TLS config with a self-signed certificate
+func testTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+}
@@ -0,0 +1,175 @@
+// Package vfsftp provides an FTP/FTPS server that exposes a VFS implementation
+package vfsftp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+)
+
+// Config holds the FTP server settings
+type Config struct {
+	// Addr is the control connection address, e.g. ":2121"
+	Addr string
+
+	// PassivePortStart and PassivePortEnd limit the ports used for passive
+	// data connections. When zero, the operating system picks a port.
+	PassivePortStart int
+	PassivePortEnd   int
+
+	// PublicIP is announced in PASV replies. Set it when the server is behind
+	// NAT; by default the local address of the control connection is used.
+	PublicIP string
+
+	// TLSConfig enables explicit FTPS (AUTH TLS) when set
+	TLSConfig *tls.Config
+	// RequireTLS refuses logins on connections that have not upgraded to TLS
+	RequireTLS bool
+
+	// IdleTimeout closes control connections without activity
+	IdleTimeout time.Duration
+
+	// Debug logs every command received
+	Debug bool
+}
+
+// Server is an FTP server backed by a VFS implementation
+type Server struct {
+	vfsImpl vfs.VFSImplementation
+	users   *UserStore
+	config  Config
+
+	mu       sync.Mutex
+	listener net.Listener
+	sessions map[*session]struct{}
+	closed   bool
+
+	// nextPassivePort is the next port tried from the passive range
+	nextPassivePort int
+}
+
+// NewServer creates a new FTP server serving vfsImpl to the given users
+func NewServer(vfsImpl vfs.VFSImplementation, users *UserStore, config Config) *Server {
+	if config.Addr == "" {
+		config.Addr = ":21"
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = 5 * time.Minute
+	}
+
+	return &Server{
+		vfsImpl:         vfsImpl,
+		users:           users,
+		config:          config,
+		sessions:        make(map[*session]struct{}),
+		nextPassivePort: config.PassivePortStart,
+	}
+}
+
+// ListenAndServe listens on the configured address and serves FTP clients
+func (s *Server) ListenAndServe() error {
+	listener, err := net.Listen("tcp", s.config.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts control connections on listener until the server is closed
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	log.Printf("FTP server listening on %s (TLS: %v)", listener.Addr(), s.config.TLSConfig != nil)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		sess := newSession(s, conn)
+		s.mu.Lock()
+		s.sessions[sess] = struct{}{}
+		s.mu.Unlock()
+
+		go func() {
+			sess.serve()
+			s.mu.Lock()
+			delete(s.sessions, sess)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Addr returns the address the server is listening on, or nil if it is not running
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Close stops the server and closes all client connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for sess := range s.sessions {
+		sess.close()
+	}
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+// ErrServerClosed is returned by Serve after Close has been called
+var ErrServerClosed = errors.New("ftp: server closed")
+
+// listenPassive opens a listener for a passive data connection on the
+// configured port range
+func (s *Server) listenPassive(ip net.IP) (net.Listener, error) {
+	start, end := s.config.PassivePortStart, s.config.PassivePortEnd
+	if start <= 0 || end < start {
+		return net.Listen("tcp", net.JoinHostPort(ip.String(), "0"))
+	}
+
+	count := end - start + 1
+	for i := 0; i < count; i++ {
+		s.mu.Lock()
+		port := s.nextPassivePort
+		s.nextPassivePort++
+		if s.nextPassivePort > end {
+			s.nextPassivePort = start
+		}
+		s.mu.Unlock()
+
+		listener, err := net.Listen("tcp", net.JoinHostPort(ip.String(), fmt.Sprint(port)))
+		if err == nil {
+			return listener, nil
+		}
+	}
+	return nil, fmt.Errorf("no free passive port in range %d-%d", start, end)
+}
@@ -0,0 +1,746 @@
+package vfsftp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+)
+
+// dataTimeout bounds how long the server waits for a client to open a data connection
+const dataTimeout = 30 * time.Second
+
+// session is a single FTP control connection
+type session struct {
+	server *Server
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+
+	tls       bool
+	protected bool // PROT P, data connections use TLS
+
+	userName string
+	user     *User
+	cwd      string
+
+	passive    net.Listener
+	renameFrom string
+	restOffset int64
+}
+
+// newSession creates a session for an accepted control connection
+func newSession(server *Server, conn net.Conn) *session {
+	return &session{
+		server: server,
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+		writer: bufio.NewWriter(conn),
+		cwd:    "/",
+	}
+}
+
+// close closes the control and any pending data connection
+func (s *session) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.passive != nil {
+		s.passive.Close()
+		s.passive = nil
+	}
+	s.conn.Close()
+}
+
+// reply sends a single line response
+func (s *session) reply(code int, format string, args ...interface{}) {
+	fmt.Fprintf(s.writer, "%d %s\r\n", code, fmt.Sprintf(format, args...))
+	s.writer.Flush()
+}
+
+// replyLines sends a multi-line response
+func (s *session) replyLines(code int, first string, lines []string, last string) {
+	fmt.Fprintf(s.writer, "%d-%s\r\n", code, first)
+	for _, line := range lines {
+		fmt.Fprintf(s.writer, " %s\r\n", line)
+	}
+	fmt.Fprintf(s.writer, "%d %s\r\n", code, last)
+	s.writer.Flush()
+}
+
+// serve reads and handles commands until the client quits or the connection drops
+func (s *session) serve() {
+	defer s.close()
+
+	log.Printf("FTP connection from %s", s.conn.RemoteAddr())
+	s.reply(220, "herolauncher FTP server ready")
+
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(s.server.config.IdleTimeout))
+		line, err := s.reader.ReadString('\n')
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("FTP connection from %s closed: %v", s.conn.RemoteAddr(), err)
+			}
+			return
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		command, arg, _ := strings.Cut(line, " ")
+		command = strings.ToUpper(command)
+
+		if s.server.config.Debug {
+			if command == "PASS" {
+				log.Printf("FTP %s: PASS ****", s.conn.RemoteAddr())
+			} else {
+				log.Printf("FTP %s: %s", s.conn.RemoteAddr(), line)
+			}
+		}
+
+		if !s.handle(command, arg) {
+			return
+		}
+	}
+}
+
+// handle executes a single command. It returns false when the session should end.
+func (s *session) handle(command, arg string) bool {
+	// Commands allowed before login
+	switch command {
+	case "USER":
+		s.cmdUser(arg)
+		return true
+	case "PASS":
+		s.cmdPass(arg)
+		return true
+	case "AUTH":
+		return s.cmdAuth(arg)
+	case "PBSZ":
+		s.cmdPbsz(arg)
+		return true
+	case "PROT":
+		s.cmdProt(arg)
+		return true
+	case "FEAT":
+		s.cmdFeat()
+		return true
+	case "SYST":
+		s.reply(215, "UNIX Type: L8")
+		return true
+	case "NOOP":
+		s.reply(200, "OK")
+		return true
+	case "OPTS":
+		s.cmdOpts(arg)
+		return true
+	case "QUIT":
+		s.reply(221, "Goodbye")
+		return false
+	}
+
+	if s.user == nil {
+		s.reply(530, "Please login with USER and PASS")
+		return true
+	}
+
+	switch command {
+	case "PWD", "XPWD":
+		s.reply(257, "%s is the current directory", quotePath(s.cwd))
+	case "CWD", "XCWD":
+		s.cmdCwd(arg)
+	case "CDUP", "XCUP":
+		s.cmdCwd("..")
+	case "TYPE":
+		s.cmdType(arg)
+	case "MODE":
+		s.cmdSingleOption(arg, "S", "Mode")
+	case "STRU":
+		s.cmdSingleOption(arg, "F", "Structure")
+	case "PASV":
+		s.cmdPasv(false)
+	case "EPSV":
+		s.cmdPasv(true)
+	case "PORT", "EPRT":
+		s.reply(502, "Active mode is not supported, use passive mode")
+	case "LIST":
+		s.cmdList(arg, true)
+	case "NLST":
+		s.cmdList(arg, false)
+	case "RETR":
+		s.cmdRetr(arg)
+	case "STOR":
+		s.cmdStor(arg, false)
+	case "APPE":
+		s.cmdStor(arg, true)
+	case "REST":
+		s.cmdRest(arg)
+	case "ALLO":
+		s.reply(202, "No storage allocation necessary")
+	case "SIZE":
+		s.cmdSize(arg)
+	case "MDTM":
+		s.cmdMdtm(arg)
+	case "DELE":
+		s.cmdDele(arg)
+	case "MKD", "XMKD":
+		s.cmdMkd(arg)
+	case "RMD", "XRMD":
+		s.cmdRmd(arg)
+	case "RNFR":
+		s.cmdRnfr(arg)
+	case "RNTO":
+		s.cmdRnto(arg)
+	default:
+		s.reply(502, "Command %s not implemented", command)
+	}
+	return true
+}
+
+func (s *session) cmdUser(arg string) {
+	if s.server.config.RequireTLS && !s.tls {
+		s.reply(530, "TLS is required, use AUTH TLS first")
+		return
+	}
+	s.userName = arg
+	s.user = nil
+	s.reply(331, "Password required for %s", arg)
+}
+
+func (s *session) cmdPass(arg string) {
+	if s.userName == "" {
+		s.reply(503, "Login with USER first")
+		return
+	}
+
+	user, ok := s.server.users.Authenticate(s.userName, arg)
+	if !ok {
+		log.Printf("FTP login failed for %q from %s", s.userName, s.conn.RemoteAddr())
+		// Slow down password guessing
+		time.Sleep(time.Second)
+		s.reply(530, "Login incorrect")
+		return
+	}
+
+	if !s.server.vfsImpl.Exists(user.Root) {
+		log.Printf("FTP root %s of user %s does not exist", user.Root, user.Name)
+		s.reply(530, "Home directory not available")
+		return
+	}
+
+	s.user = user
+	s.cwd = "/"
+	log.Printf("FTP user %s logged in from %s", user.Name, s.conn.RemoteAddr())
+	s.reply(230, "User %s logged in", user.Name)
+}
+
+func (s *session) cmdAuth(arg string) bool {
+	if s.server.config.TLSConfig == nil {
+		s.reply(502, "TLS is not configured")
+		return true
+	}
+	if s.tls {
+		s.reply(503, "TLS is already active")
+		return true
+	}
+	if mode := strings.ToUpper(arg); mode != "TLS" && mode != "SSL" && mode != "TLS-C" {
+		s.reply(504, "Unsupported security mechanism %s", arg)
+		return true
+	}
+
+	s.reply(234, "AUTH TLS successful")
+
+	tlsConn := tls.Server(s.conn, s.server.config.TLSConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		log.Printf("FTP TLS handshake with %s failed: %v", s.conn.RemoteAddr(), err)
+		return false
+	}
+
+	s.mu.Lock()
+	s.conn = tlsConn
+	s.reader = bufio.NewReader(tlsConn)
+	s.writer = bufio.NewWriter(tlsConn)
+	s.mu.Unlock()
+	s.tls = true
+
+	// A new security context requires a new login
+	s.user = nil
+	s.userName = ""
+	return true
+}
+
+func (s *session) cmdPbsz(arg string) {
+	if !s.tls {
+		s.reply(503, "PBSZ requires AUTH TLS first")
+		return
+	}
+	s.reply(200, "PBSZ=0")
+}
+
+func (s *session) cmdProt(arg string) {
+	if !s.tls {
+		s.reply(503, "PROT requires AUTH TLS first")
+		return
+	}
+
+	switch strings.ToUpper(arg) {
+	case "P":
+		s.protected = true
+		s.reply(200, "Protection level set to Private")
+	case "C":
+		if s.server.config.RequireTLS {
+			s.reply(534, "Data connections must be protected")
+			return
+		}
+		s.protected = false
+		s.reply(200, "Protection level set to Clear")
+	default:
+		s.reply(504, "Unsupported protection level %s", arg)
+	}
+}
+
+func (s *session) cmdFeat() {
+	features := []string{"EPSV", "PASV", "SIZE", "MDTM", "REST STREAM", "UTF8"}
+	if s.server.config.TLSConfig != nil {
+		features = append(features, "AUTH TLS", "PBSZ", "PROT")
+	}
+	s.replyLines(211, "Features:", features, "End")
+}
+
+func (s *session) cmdOpts(arg string) {
+	if strings.EqualFold(arg, "UTF8 ON") {
+		s.reply(200, "UTF8 mode enabled")
+		return
+	}
+	s.reply(501, "Option not supported")
+}
+
+func (s *session) cmdType(arg string) {
+	switch strings.ToUpper(arg) {
+	case "A", "A N":
+		// Files are always transferred unchanged; ASCII mode is accepted for compatibility
+		s.reply(200, "Type set to A")
+	case "I", "L 8":
+		s.reply(200, "Type set to I")
+	default:
+		s.reply(504, "Unsupported type %s", arg)
+	}
+}
+
+func (s *session) cmdSingleOption(arg, supported, name string) {
+	if strings.ToUpper(arg) != supported {
+		s.reply(504, "%s %s not supported", name, arg)
+		return
+	}
+	s.reply(200, "%s set to %s", name, supported)
+}
+
+func (s *session) cmdCwd(arg string) {
+	target := s.resolve(arg)
+	entry, err := s.server.vfsImpl.Get(s.vfsPath(target))
+	if err != nil || !entry.IsDir() {
+		s.reply(550, "%s: no such directory", arg)
+		return
+	}
+	s.cwd = target
+	s.reply(250, "Directory changed to %s", target)
+}
+
+func (s *session) cmdPasv(extended bool) {
+	s.mu.Lock()
+	if s.passive != nil {
+		s.passive.Close()
+		s.passive = nil
+	}
+	s.mu.Unlock()
+
+	localIP := s.conn.LocalAddr().(*net.TCPAddr).IP
+	listener, err := s.server.listenPassive(localIP)
+	if err != nil {
+		log.Printf("FTP passive listen failed: %v", err)
+		s.reply(425, "Cannot open passive connection")
+		return
+	}
+
+	s.mu.Lock()
+	s.passive = listener
+	s.mu.Unlock()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	if extended {
+		s.reply(229, "Entering Extended Passive Mode (|||%d|)", port)
+		return
+	}
+
+	ip := localIP.To4()
+	if s.server.config.PublicIP != "" {
+		ip = net.ParseIP(s.server.config.PublicIP).To4()
+	}
+	if ip == nil {
+		s.reply(425, "PASV requires IPv4, use EPSV")
+		return
+	}
+	s.reply(227, "Entering Passive Mode (%d,%d,%d,%d,%d,%d)", ip[0], ip[1], ip[2], ip[3], port>>8, port&0xff)
+}
+
+// openData accepts the client's data connection on the passive listener
+func (s *session) openData() (net.Conn, error) {
+	s.mu.Lock()
+	listener := s.passive
+	s.passive = nil
+	s.mu.Unlock()
+
+	if listener == nil {
+		return nil, errors.New("no passive connection, use PASV or EPSV first")
+	}
+	defer listener.Close()
+
+	if s.server.config.RequireTLS && !s.protected {
+		return nil, errors.New("data connections must be protected, use PROT P")
+	}
+
+	if tcpListener, ok := listener.(*net.TCPListener); ok {
+		tcpListener.SetDeadline(time.Now().Add(dataTimeout))
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the client on the control connection may use the data connection
+	controlIP := s.conn.RemoteAddr().(*net.TCPAddr).IP
+	dataIP := conn.RemoteAddr().(*net.TCPAddr).IP
+	if !controlIP.Equal(dataIP) {
+		conn.Close()
+		return nil, fmt.Errorf("data connection from %s does not match control connection", dataIP)
+	}
+
+	if s.protected {
+		tlsConn := tls.Server(conn, s.server.config.TLSConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+	return conn, nil
+}
+
+// transfer runs fn on a freshly opened data connection and reports the result
+func (s *session) transfer(fn func(conn net.Conn) error) {
+	s.reply(150, "Opening data connection")
+
+	conn, err := s.openData()
+	if err != nil {
+		log.Printf("FTP data connection failed: %v", err)
+		s.reply(425, "Cannot open data connection")
+		return
+	}
+
+	err = fn(conn)
+	if closeErr := conn.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("FTP transfer failed: %v", err)
+		s.reply(426, "Transfer aborted: %v", err)
+		return
+	}
+	s.reply(226, "Transfer complete")
+}
+
+func (s *session) cmdList(arg string, long bool) {
+	// Ignore ls style options such as "-la" that many clients send
+	if strings.HasPrefix(arg, "-") {
+		_, arg, _ = strings.Cut(arg, " ")
+	}
+
+	target := s.vfsPath(s.resolve(arg))
+	entry, err := s.server.vfsImpl.Get(target)
+	if err != nil {
+		s.reply(550, "%s: no such file or directory", arg)
+		return
+	}
+
+	entries := []vfs.FSEntry{entry}
+	if entry.IsDir() {
+		if entries, err = s.server.vfsImpl.DirList(target); err != nil {
+			s.reply(550, "Cannot list directory")
+			return
+		}
+	}
+
+	s.transfer(func(conn net.Conn) error {
+		w := bufio.NewWriter(conn)
+		for _, e := range entries {
+			if long {
+				fmt.Fprintf(w, "%s\r\n", formatListLine(e.GetMetadata()))
+			} else {
+				fmt.Fprintf(w, "%s\r\n", e.GetMetadata().Name)
+			}
+		}
+		return w.Flush()
+	})
+}
+
+func (s *session) cmdRetr(arg string) {
+	target := s.vfsPath(s.resolve(arg))
+	offset := s.takeRestOffset()
+
+	data, err := s.server.vfsImpl.FileRead(target)
+	if err != nil {
+		s.reply(550, "%s: cannot read file", arg)
+		return
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	s.transfer(func(conn net.Conn) error {
+		_, err := conn.Write(data[offset:])
+		return err
+	})
+}
+
+func (s *session) cmdStor(arg string, appendData bool) {
+	if !s.writable() {
+		return
+	}
+	target := s.vfsPath(s.resolve(arg))
+	offset := s.takeRestOffset()
+
+	if entry, err := s.server.vfsImpl.Get(target); err == nil && entry.IsDir() {
+		s.reply(550, "%s is a directory", arg)
+		return
+	}
+
+	s.transfer(func(conn net.Conn) error {
+		// The VFS stores whole files, so the upload is collected before writing
+		data, err := io.ReadAll(conn)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case appendData:
+			return s.server.vfsImpl.FileConcatenate(target, data)
+		case offset > 0:
+			existing, err := s.server.vfsImpl.FileRead(target)
+			if err != nil {
+				return err
+			}
+			if int64(len(existing)) < offset {
+				return fmt.Errorf("restart offset %d beyond end of file", offset)
+			}
+			return s.server.vfsImpl.FileWrite(target, append(existing[:offset], data...))
+		default:
+			return s.server.vfsImpl.FileWrite(target, data)
+		}
+	})
+}
+
+func (s *session) cmdRest(arg string) {
+	offset, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || offset < 0 {
+		s.reply(501, "Invalid restart offset")
+		return
+	}
+	s.restOffset = offset
+	s.reply(350, "Restarting at %d", offset)
+}
+
+// takeRestOffset returns and clears the offset set by REST
+func (s *session) takeRestOffset() int64 {
+	offset := s.restOffset
+	s.restOffset = 0
+	return offset
+}
+
+func (s *session) cmdSize(arg string) {
+	entry, err := s.server.vfsImpl.Get(s.vfsPath(s.resolve(arg)))
+	if err != nil || entry.IsDir() {
+		s.reply(550, "%s: no such file", arg)
+		return
+	}
+	s.reply(213, "%d", entry.GetMetadata().Size)
+}
+
+func (s *session) cmdMdtm(arg string) {
+	entry, err := s.server.vfsImpl.Get(s.vfsPath(s.resolve(arg)))
+	if err != nil {
+		s.reply(550, "%s: no such file", arg)
+		return
+	}
+	modified := time.Unix(entry.GetMetadata().ModifiedAt, 0).UTC()
+	s.reply(213, "%s", modified.Format("20060102150405"))
+}
+
+func (s *session) cmdDele(arg string) {
+	if !s.writable() {
+		return
+	}
+	target := s.vfsPath(s.resolve(arg))
+	entry, err := s.server.vfsImpl.Get(target)
+	if err != nil || entry.IsDir() {
+		s.reply(550, "%s: no such file", arg)
+		return
+	}
+	if err := s.server.vfsImpl.Delete(target); err != nil {
+		s.reply(550, "Cannot delete %s", arg)
+		return
+	}
+	s.reply(250, "Deleted %s", arg)
+}
+
+func (s *session) cmdMkd(arg string) {
+	if !s.writable() {
+		return
+	}
+	virtual := s.resolve(arg)
+	if _, err := s.server.vfsImpl.DirCreate(s.vfsPath(virtual)); err != nil {
+		s.reply(550, "Cannot create %s", arg)
+		return
+	}
+	s.reply(257, "%s created", quotePath(virtual))
+}
+
+func (s *session) cmdRmd(arg string) {
+	if !s.writable() {
+		return
+	}
+	virtual := s.resolve(arg)
+	if virtual == "/" {
+		s.reply(550, "Cannot remove the root directory")
+		return
+	}
+
+	target := s.vfsPath(virtual)
+	children, err := s.server.vfsImpl.DirList(target)
+	if err != nil {
+		s.reply(550, "%s: no such directory", arg)
+		return
+	}
+	if len(children) > 0 {
+		s.reply(550, "%s: directory not empty", arg)
+		return
+	}
+	if err := s.server.vfsImpl.Delete(target); err != nil {
+		s.reply(550, "Cannot remove %s", arg)
+		return
+	}
+	s.reply(250, "Removed %s", arg)
+}
+
+func (s *session) cmdRnfr(arg string) {
+	if !s.writable() {
+		return
+	}
+	target := s.vfsPath(s.resolve(arg))
+	if !s.server.vfsImpl.Exists(target) {
+		s.reply(550, "%s: no such file or directory", arg)
+		return
+	}
+	s.renameFrom = target
+	s.reply(350, "Ready for RNTO")
+}
+
+func (s *session) cmdRnto(arg string) {
+	if !s.writable() {
+		return
+	}
+	from := s.renameFrom
+	s.renameFrom = ""
+	if from == "" {
+		s.reply(503, "Use RNFR first")
+		return
+	}
+
+	to := s.vfsPath(s.resolve(arg))
+	var err error
+	if path.Dir(from) == path.Dir(to) {
+		_, err = s.server.vfsImpl.Rename(from, to)
+	} else {
+		_, err = s.server.vfsImpl.Move(from, to)
+	}
+	if err != nil {
+		s.reply(550, "Rename failed: %v", err)
+		return
+	}
+	s.reply(250, "Renamed")
+}
+
+// writable checks that the logged in user may modify the filesystem
+func (s *session) writable() bool {
+	if s.user.ReadOnly {
+		s.reply(550, "Permission denied")
+		return false
+	}
+	return true
+}
+
+// resolve turns a client path into a clean virtual path relative to the user's root
+func (s *session) resolve(arg string) string {
+	if arg == "" {
+		return s.cwd
+	}
+	if !strings.HasPrefix(arg, "/") {
+		arg = path.Join(s.cwd, arg)
+	}
+	// Cleaning a rooted path removes any ".." that would escape the user's root
+	return path.Clean("/" + arg)
+}
+
+// vfsPath maps a virtual path to the VFS path below the user's root
+func (s *session) vfsPath(virtual string) string {
+	return path.Join(s.user.Root, virtual)
+}
+
+// quotePath quotes a path for 257 replies, doubling embedded quotes
+func quotePath(p string) string {
+	return `"` + strings.ReplaceAll(p, `"`, `""`) + `"`
+}
+
+// formatListLine formats an entry like "ls -l", which is what FTP clients parse
+func formatListLine(metadata *vfs.Metadata) string {
+	kind := byte('-')
+	if metadata.IsDir() {
+		kind = 'd'
+	} else if metadata.FileType == vfs.FileTypeSymlink {
+		kind = 'l'
+	}
+
+	perms := []byte("rwxrwxrwx")
+	mode := metadata.Permissions()
+	for i := range perms {
+		if mode&(1<<uint(8-i)) == 0 {
+			perms[i] = '-'
+		}
+	}
+
+	owner, group := metadata.Owner, metadata.Group
+	if owner == "" {
+		owner = strconv.FormatUint(uint64(metadata.UID), 10)
+	}
+	if group == "" {
+		group = strconv.FormatUint(uint64(metadata.GID), 10)
+	}
+
+	modified := time.Unix(metadata.ModifiedAt, 0)
+	stamp := modified.Format("Jan _2 15:04")
+	if time.Since(modified) > 180*24*time.Hour || modified.After(time.Now().Add(time.Hour)) {
+		stamp = modified.Format("Jan _2  2006")
+	}
+
+	return fmt.Sprintf("%c%s 1 %s %s %d %s %s", kind, perms, owner, group, metadata.Size, stamp, metadata.Name)
+}
@@ -0,0 +1,103 @@
+package vfsftp
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+// User is a virtual FTP account. Users do not need to exist on the host; each
+// one is confined to its own subtree of the VFS.
+type User struct {
+	Name     string
+	Password string
+	// Root is the VFS directory the user sees as "/"
+	Root string
+	// ReadOnly users may list and download but not change anything
+	ReadOnly bool
+}
+
+// UserStore holds the virtual users allowed to log in
+type UserStore struct {
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+// NewUserStore creates an empty user store
+func NewUserStore() *UserStore {
+	return &UserStore{
+		users: make(map[string]*User),
+	}
+}
+
+// Add adds or replaces a user
+func (s *UserStore) Add(user User) error {
+	if user.Name == "" {
+		return fmt.Errorf("user name cannot be empty")
+	}
+	if user.Root == "" {
+		user.Root = "/"
+	}
+	user.Root = path.Clean("/" + user.Root)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.Name] = &user
+	return nil
+}
+
+// Remove deletes a user
+func (s *UserStore) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, name)
+}
+
+// Authenticate returns the user if the name and password match
+func (s *UserStore) Authenticate(name, password string) (*User, bool) {
+	s.mu.RLock()
+	user, ok := s.users[name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+		return nil, false
+	}
+	return user, true
+}
+
+// ParseUsers parses a comma separated list of users in the form
+// name:password[:root[:ro]], e.g. "scanner:secret:/scans,guest:guest:/pub:ro"
+func ParseUsers(spec string) ([]User, error) {
+	var users []User
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) < 2 || len(parts) > 4 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid user definition %q, expected name:password[:root[:ro]]", item)
+		}
+
+		user := User{Name: parts[0], Password: parts[1], Root: "/"}
+		if len(parts) > 2 && parts[2] != "" {
+			user.Root = parts[2]
+		}
+		if len(parts) > 3 {
+			if parts[3] != "ro" {
+				return nil, fmt.Errorf("invalid user flag %q for %s, only \"ro\" is supported", parts[3], parts[0])
+			}
+			user.ReadOnly = true
+		}
+		users = append(users, user)
+	}
+
+	return users, nil
+}
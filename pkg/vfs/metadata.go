@@ -6,32 +6,46 @@ import (
 
 // Metadata represents common metadata for files and directories
 type Metadata struct {
-	ID          uint32   // Unique identifier
-	Name        string   // Name of the file or directory
-	FileType    FileType // Type of the entry (file, directory, symlink)
-	Size        uint64   // Size in bytes
-	CreatedAt   int64    // Unix timestamp of creation time
-	ModifiedAt  int64    // Unix timestamp of last modification time
-	AccessedAt  int64    // Unix timestamp of last access time
-	Mode        uint32   // File permissions
-	Owner       string   // Owner of the file
-	Group       string   // Group of the file
+	ID         uint32            // Unique identifier
+	Name       string            // Name of the file or directory
+	FileType   FileType          // Type of the entry (file, directory, symlink)
+	Size       uint64            // Size in bytes
+	CreatedAt  int64             // Unix timestamp of creation time
+	ModifiedAt int64             // Unix timestamp of last modification time
+	AccessedAt int64             // Unix timestamp of last access time
+	Mode       uint32            // File permissions
+	Owner      string            // Owner of the file
+	Group      string            // Group of the file
+	UID        uint32            // Numeric user ID of the owner
+	GID        uint32            // Numeric group ID of the owner
+	Attributes map[string]string // Extended attributes (xattrs / dead properties)
 }
 
+// Permission bit masks used in Metadata.Mode
+const (
+	ModePerm    uint32 = 0777  // Unix permission bits (rwxrwxrwx)
+	ModeSticky  uint32 = 01000 // Sticky bit
+	ModeSetgid  uint32 = 02000 // Set-group-ID bit
+	ModeSetuid  uint32 = 04000 // Set-user-ID bit
+	ModePermAll uint32 = 07777 // Permission bits including setuid, setgid and sticky
+)
+
 // NewMetadata creates a new Metadata instance with default values
 func NewMetadata(id uint32, name string, fileType FileType) *Metadata {
 	now := time.Now().Unix()
 	return &Metadata{
-		ID:          id,
-		Name:        name,
-		FileType:    fileType,
-		Size:        0,
-		CreatedAt:   now,
-		ModifiedAt:  now,
-		AccessedAt:  now,
-		Mode:        0644, // Default file permissions
-		Owner:       "user",
-		Group:       "user",
+		ID:         id,
+		Name:       name,
+		FileType:   fileType,
+		Size:       0,
+		CreatedAt:  now,
+		ModifiedAt: now,
+		AccessedAt: now,
+		Mode:       0644, // Default file permissions
+		Owner:      "user",
+		Group:      "user",
+		UID:        0,
+		GID:        0,
 	}
 }
 
@@ -74,3 +88,42 @@ func (m *Metadata) IsFile() bool {
 func (m *Metadata) IsSymlink() bool {
 	return m.FileType == FileTypeSymlink
 }
+
+// Permissions returns the permission bits of the mode, including setuid, setgid and sticky
+func (m *Metadata) Permissions() uint32 {
+	return m.Mode & ModePermAll
+}
+
+// SetPermissions replaces the permission bits of the mode, keeping any other bits intact
+func (m *Metadata) SetPermissions(mode uint32) {
+	m.Mode = (m.Mode &^ ModePermAll) | (mode & ModePermAll)
+}
+
+// SetOwnership updates the numeric owner and group IDs
+func (m *Metadata) SetOwnership(uid, gid uint32) {
+	m.UID = uid
+	m.GID = gid
+}
+
+// Attribute returns the value of an extended attribute and whether it is set
+func (m *Metadata) Attribute(name string) (string, bool) {
+	value, ok := m.Attributes[name]
+	return value, ok
+}
+
+// SetAttribute sets an extended attribute, allocating the map if needed
+func (m *Metadata) SetAttribute(name, value string) {
+	if m.Attributes == nil {
+		m.Attributes = make(map[string]string)
+	}
+	m.Attributes[name] = value
+}
+
+// DeleteAttribute removes an extended attribute, returning false if it was not set
+func (m *Metadata) DeleteAttribute(name string) bool {
+	if _, ok := m.Attributes[name]; !ok {
+		return false
+	}
+	delete(m.Attributes, name)
+	return true
+}
@@ -17,6 +17,7 @@ var (
 	ErrNotSymlink     = errors.New("not a symlink")
 	ErrInvalidPath    = errors.New("invalid path")
 	ErrPermission     = errors.New("permission denied")
+	ErrNoAttribute    = errors.New("attribute not found")
 )
 
 // JoinPath joins path elements with proper handling of leading/trailing slashes
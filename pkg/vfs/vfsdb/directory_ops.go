@@ -396,6 +396,9 @@ func (fs *DatabaseVFS) directoryCopy(srcParent *DirectoryEntry, srcName, dstName
 				Mode:       e.metadata.Mode,
 				Owner:      e.metadata.Owner,
 				Group:      e.metadata.Group,
+				UID:        e.metadata.UID,
+				GID:        e.metadata.GID,
+				Attributes: copyAttributes(e.metadata.Attributes),
 			},
 			parentID: dstParent.metadata.ID,
 			children: []uint32{},
@@ -450,6 +453,9 @@ func (fs *DatabaseVFS) directoryCopy(srcParent *DirectoryEntry, srcName, dstName
 				Mode:       e.metadata.Mode,
 				Owner:      e.metadata.Owner,
 				Group:      e.metadata.Group,
+				UID:        e.metadata.UID,
+				GID:        e.metadata.GID,
+				Attributes: copyAttributes(e.metadata.Attributes),
 			},
 			parentID: dstParent.metadata.ID,
 			chunkIDs: []uint32{},
@@ -491,6 +497,9 @@ func (fs *DatabaseVFS) directoryCopy(srcParent *DirectoryEntry, srcName, dstName
 				Mode:       e.metadata.Mode,
 				Owner:      e.metadata.Owner,
 				Group:      e.metadata.Group,
+				UID:        e.metadata.UID,
+				GID:        e.metadata.GID,
+				Attributes: copyAttributes(e.metadata.Attributes),
 			},
 			target:   e.target,
 			parentID: dstParent.metadata.ID,
@@ -595,3 +604,15 @@ func (fs *DatabaseVFS) directoryMove(srcParent *DirectoryEntry, srcName, dstName
 
 	return srcEntry, nil
 }
+
+// copyAttributes returns a copy of an extended attribute map
+func copyAttributes(attrs map[string]string) map[string]string {
+	if len(attrs) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(attrs))
+	for name, value := range attrs {
+		copied[name] = value
+	}
+	return copied
+}
@@ -4,11 +4,23 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"sort"
 	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
 )
 
 // Version byte for the encoding format
-const encodingVersion byte = 1
+const encodingVersion byte = 3
+
+// Previous encoding versions which can still be decoded
+const (
+	encodingVersionLegacy byte = 1 // no numeric owner IDs and no attributes
+	encodingVersionOwner  byte = 2 // numeric owner IDs but no attributes
+)
+
+// isSupportedVersion returns true if entries with the given version byte can be decoded
+func isSupportedVersion(version byte) bool {
+	return version >= encodingVersionLegacy && version <= encodingVersion
+}
 
 // encodeMetadata encodes the common metadata structure
 func encodeMetadata(metadata *vfs.Metadata, buf *bytes.Buffer) error {
@@ -44,6 +56,28 @@ func encodeMetadata(metadata *vfs.Metadata, buf *bytes.Buffer) error {
 	binary.Write(buf, binary.LittleEndian, uint16(len(groupBytes)))
 	buf.Write(groupBytes)
 	
+	// Write numeric owner and group IDs
+	binary.Write(buf, binary.LittleEndian, metadata.UID)
+	binary.Write(buf, binary.LittleEndian, metadata.GID)
+	
+	// Write extended attributes sorted by name so the encoding is deterministic
+	names := make([]string, 0, len(metadata.Attributes))
+	for name := range metadata.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	
+	binary.Write(buf, binary.LittleEndian, uint16(len(names)))
+	for _, name := range names {
+		nameBytes := []byte(name)
+		binary.Write(buf, binary.LittleEndian, uint16(len(nameBytes)))
+		buf.Write(nameBytes)
+		
+		valueBytes := []byte(metadata.Attributes[name])
+		binary.Write(buf, binary.LittleEndian, uint32(len(valueBytes)))
+		buf.Write(valueBytes)
+	}
+	
 	return nil
 }
 
@@ -134,7 +168,7 @@ func decodeEntryType(data []byte) (vfs.FileType, error) {
 	}
 	
 	// Check version
-	if data[0] != encodingVersion {
+	if !isSupportedVersion(data[0]) {
 		return vfs.FileTypeUnknown, errors.New("unsupported encoding version")
 	}
 	
@@ -227,6 +261,47 @@ func decodeMetadata(data []byte, offset int) (*vfs.Metadata, int, error) {
 	metadata.Group = string(data[offset : offset+int(groupLen)])
 	offset += int(groupLen)
 	
+	// Read numeric owner and group IDs (not present in the legacy format)
+	if data[0] != encodingVersionLegacy {
+		if len(data) < offset+8 {
+			return nil, 0, errors.New("corrupt metadata bytes")
+		}
+		metadata.UID = binary.LittleEndian.Uint32(data[offset:])
+		offset += 4
+		metadata.GID = binary.LittleEndian.Uint32(data[offset:])
+		offset += 4
+	}
+	
+	// Read extended attributes (only present since version 3)
+	if data[0] >= encodingVersion {
+		if len(data) < offset+2 {
+			return nil, 0, errors.New("corrupt metadata bytes")
+		}
+		attrCount := binary.LittleEndian.Uint16(data[offset:])
+		offset += 2
+		
+		for i := 0; i < int(attrCount); i++ {
+			if len(data) < offset+2 {
+				return nil, 0, errors.New("corrupt metadata bytes")
+			}
+			nameLen := int(binary.LittleEndian.Uint16(data[offset:]))
+			offset += 2
+			if len(data) < offset+nameLen+4 {
+				return nil, 0, errors.New("corrupt metadata bytes")
+			}
+			name := string(data[offset : offset+nameLen])
+			offset += nameLen
+			
+			valueLen := int(binary.LittleEndian.Uint32(data[offset:]))
+			offset += 4
+			if len(data) < offset+valueLen {
+				return nil, 0, errors.New("corrupt metadata bytes")
+			}
+			metadata.SetAttribute(name, string(data[offset:offset+valueLen]))
+			offset += valueLen
+		}
+	}
+	
 	return metadata, offset, nil
 }
 
@@ -237,7 +312,7 @@ func decodeDirectory(data []byte, fs *DatabaseVFS) (*DirectoryEntry, error) {
 	}
 	
 	// Check version
-	if data[0] != encodingVersion {
+	if !isSupportedVersion(data[0]) {
 		return nil, errors.New("unsupported encoding version")
 	}
 	
@@ -291,7 +366,7 @@ func decodeFile(data []byte, fs *DatabaseVFS) (*FileEntry, error) {
 	}
 	
 	// Check version
-	if data[0] != encodingVersion {
+	if !isSupportedVersion(data[0]) {
 		return nil, errors.New("unsupported encoding version")
 	}
 	
@@ -345,7 +420,7 @@ func decodeSymlink(data []byte, fs *DatabaseVFS) (*SymlinkEntry, error) {
 	}
 	
 	// Check version
-	if data[0] != encodingVersion {
+	if !isSupportedVersion(data[0]) {
 		return nil, errors.New("unsupported encoding version")
 	}
 	
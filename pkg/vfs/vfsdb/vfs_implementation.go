@@ -359,6 +359,106 @@ func (fs *DatabaseVFS) LinkDelete(path string) error {
 	return fs.Delete(path)
 }
 
+// Chmod changes the permission bits of a filesystem entry
+func (fs *DatabaseVFS) Chmod(path string, mode uint32) error {
+	path = vfs.FixPath(path)
+
+	entry, err := fs.getEntry(path)
+	if err != nil {
+		return err
+	}
+
+	entry.GetMetadata().SetPermissions(mode)
+
+	return fs.SaveEntry(entry)
+}
+
+// Chown changes the numeric owner and group of a filesystem entry
+func (fs *DatabaseVFS) Chown(path string, uid, gid uint32) error {
+	path = vfs.FixPath(path)
+
+	entry, err := fs.getEntry(path)
+	if err != nil {
+		return err
+	}
+
+	entry.GetMetadata().SetOwnership(uid, gid)
+
+	return fs.SaveEntry(entry)
+}
+
+// AttrSet sets an extended attribute on a filesystem entry
+func (fs *DatabaseVFS) AttrSet(path, name, value string) error {
+	path = vfs.FixPath(path)
+
+	entry, err := fs.getEntry(path)
+	if err != nil {
+		return err
+	}
+
+	entry.GetMetadata().SetAttribute(name, value)
+
+	return fs.SaveEntry(entry)
+}
+
+// AttrDelete removes an extended attribute from a filesystem entry
+func (fs *DatabaseVFS) AttrDelete(path, name string) error {
+	path = vfs.FixPath(path)
+
+	entry, err := fs.getEntry(path)
+	if err != nil {
+		return err
+	}
+
+	if !entry.GetMetadata().DeleteAttribute(name) {
+		return vfs.ErrNoAttribute
+	}
+
+	return fs.SaveEntry(entry)
+}
+
+// Quota reports the total size of the files below path. The space left
+// depends on the database backend, so it is reported as unknown.
+func (fs *DatabaseVFS) Quota(path string) (vfs.QuotaInfo, error) {
+	path = vfs.FixPath(path)
+
+	entry, err := fs.getEntry(path)
+	if err != nil {
+		return vfs.QuotaInfo{}, err
+	}
+
+	used, err := fs.usage(entry)
+	if err != nil {
+		return vfs.QuotaInfo{}, err
+	}
+	return vfs.QuotaInfo{Used: used, Available: -1}, nil
+}
+
+// usage returns the total size of a file or of the files below a directory
+func (fs *DatabaseVFS) usage(entry vfs.FSEntry) (int64, error) {
+	dir, ok := entry.(*DirectoryEntry)
+	if !ok {
+		if entry.IsFile() {
+			return int64(entry.GetMetadata().Size), nil
+		}
+		return 0, nil
+	}
+
+	var total int64
+	for _, childID := range dir.children {
+		child, err := fs.LoadEntry(childID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to load child entry: %w", err)
+		}
+		size, err := fs.usage(child)
+		if err != nil {
+			return 0, err
+		}
+		total += size
+	}
+	return total, nil
+}
+
 // Exists checks if a path exists
 func (fs *DatabaseVFS) Exists(path string) bool {
 	path = vfs.FixPath(path)
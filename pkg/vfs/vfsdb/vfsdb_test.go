@@ -351,4 +351,107 @@ func TestDatabaseVFS(t *testing.T) {
 			t.Errorf("File ID mismatch after encoding/decoding")
 		}
 	})
+
+	// Test permission operations
+	t.Run("PermissionOperations", func(t *testing.T) {
+		if _, err := fs.FileCreate("/perm.txt"); err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+
+		if err := fs.Chmod("/perm.txt", 0640); err != nil {
+			t.Fatalf("Failed to chmod file: %v", err)
+		}
+
+		if err := fs.Chown("/perm.txt", 1000, 100); err != nil {
+			t.Fatalf("Failed to chown file: %v", err)
+		}
+
+		entry, err := fs.Get("/perm.txt")
+		if err != nil {
+			t.Fatalf("Failed to get file: %v", err)
+		}
+
+		metadata := entry.GetMetadata()
+		if metadata.Permissions() != 0640 {
+			t.Errorf("Mode mismatch: got %o, want 640", metadata.Permissions())
+		}
+		if metadata.UID != 1000 || metadata.GID != 100 {
+			t.Errorf("Ownership mismatch: got %d:%d, want 1000:100", metadata.UID, metadata.GID)
+		}
+
+		// Legacy entries without numeric IDs must still decode
+		fileEntry := entry.(*FileEntry)
+		data, err := encodeFile(fileEntry)
+		if err != nil {
+			t.Fatalf("Failed to encode file: %v", err)
+		}
+		legacy := append([]byte{}, data...)
+		legacy[0] = encodingVersionLegacy
+		// Strip the trailing uid/gid and empty attribute count from the metadata section
+		metaEnd := len(data) - 4 - 2 - 4*len(fileEntry.chunkIDs)
+		legacy = append(legacy[:metaEnd-10], legacy[metaEnd:]...)
+
+		decoded, err := decodeFile(legacy, fs)
+		if err != nil {
+			t.Fatalf("Failed to decode legacy file: %v", err)
+		}
+		if decoded.metadata.Permissions() != 0640 || decoded.metadata.UID != 0 {
+			t.Errorf("Legacy decode mismatch: mode %o uid %d", decoded.metadata.Permissions(), decoded.metadata.UID)
+		}
+	})
+
+	// Test extended attributes
+	t.Run("AttributeOperations", func(t *testing.T) {
+		_, err := fs.FileCreate("/attr.txt")
+		if err != nil {
+			t.Fatalf("Failed to create file: %v", err)
+		}
+
+		if err := fs.AttrSet("/attr.txt", "mime_type", "text/plain"); err != nil {
+			t.Fatalf("Failed to set attribute: %v", err)
+		}
+
+		entry, err := fs.Get("/attr.txt")
+		if err != nil {
+			t.Fatalf("Failed to get file: %v", err)
+		}
+		if value, ok := entry.GetMetadata().Attribute("mime_type"); !ok || value != "text/plain" {
+			t.Errorf("Attribute mismatch: got %q, %v", value, ok)
+		}
+
+		if err := fs.AttrDelete("/attr.txt", "mime_type"); err != nil {
+			t.Fatalf("Failed to delete attribute: %v", err)
+		}
+		if err := fs.AttrDelete("/attr.txt", "mime_type"); err != vfs.ErrNoAttribute {
+			t.Errorf("Expected ErrNoAttribute, got %v", err)
+		}
+	})
+	t.Run("QuotaOperations", func(t *testing.T) {
+		if _, err := fs.DirCreate("/quota"); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if _, err := fs.DirCreate("/quota/sub"); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		for path, content := range map[string]string{"/quota/a.txt": "12345", "/quota/sub/b.txt": "1234567890"} {
+			if _, err := fs.FileCreate(path); err != nil {
+				t.Fatalf("Failed to create file: %v", err)
+			}
+			if err := fs.FileWrite(path, []byte(content)); err != nil {
+				t.Fatalf("Failed to write file: %v", err)
+			}
+		}
+
+		quota, err := fs.Quota("/quota")
+		if err != nil {
+			t.Fatalf("Failed to get quota: %v", err)
+		}
+		if quota.Used != 15 || quota.Available != -1 {
+			t.Errorf("Unexpected quota: %+v", quota)
+		}
+
+		if _, err := fs.Quota("/missing"); err == nil {
+			t.Errorf("Expected error for missing path")
+		}
+	})
 }
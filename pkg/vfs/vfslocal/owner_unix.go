@@ -0,0 +1,17 @@
+//go:build !windows
+
+package vfslocal
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the numeric owner and group of a file
+func fileOwner(info os.FileInfo) (uid, gid uint32, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return stat.Uid, stat.Gid, true
+}
@@ -0,0 +1,13 @@
+//go:build windows
+
+package vfslocal
+
+import (
+	"os"
+)
+
+// fileOwner returns the numeric owner and group of a file; Windows has no
+// POSIX ownership so this always reports that no owner is available
+func fileOwner(info os.FileInfo) (uid, gid uint32, ok bool) {
+	return 0, 0, false
+}
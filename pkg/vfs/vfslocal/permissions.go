@@ -0,0 +1,73 @@
+package vfslocal
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"sync"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+)
+
+// nameCache caches uid/gid to name lookups, which can be slow on some systems
+var nameCache sync.Map
+
+// toFileMode converts VFS permission bits to an os.FileMode
+func toFileMode(mode uint32) os.FileMode {
+	fileMode := os.FileMode(mode & vfs.ModePerm)
+	if mode&vfs.ModeSetuid != 0 {
+		fileMode |= os.ModeSetuid
+	}
+	if mode&vfs.ModeSetgid != 0 {
+		fileMode |= os.ModeSetgid
+	}
+	if mode&vfs.ModeSticky != 0 {
+		fileMode |= os.ModeSticky
+	}
+	return fileMode
+}
+
+// fromFileMode converts an os.FileMode to VFS permission bits
+func fromFileMode(fileMode os.FileMode) uint32 {
+	mode := uint32(fileMode.Perm())
+	if fileMode&os.ModeSetuid != 0 {
+		mode |= vfs.ModeSetuid
+	}
+	if fileMode&os.ModeSetgid != 0 {
+		mode |= vfs.ModeSetgid
+	}
+	if fileMode&os.ModeSticky != 0 {
+		mode |= vfs.ModeSticky
+	}
+	return mode
+}
+
+// lookupUserName returns the user name for a uid, falling back to the numeric ID
+func lookupUserName(uid uint32) string {
+	key := "u" + strconv.FormatUint(uint64(uid), 10)
+	if name, ok := nameCache.Load(key); ok {
+		return name.(string)
+	}
+
+	name := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	nameCache.Store(key, name)
+	return name
+}
+
+// lookupGroupName returns the group name for a gid, falling back to the numeric ID
+func lookupGroupName(gid uint32) string {
+	key := "g" + strconv.FormatUint(uint64(gid), 10)
+	if name, ok := nameCache.Load(key); ok {
+		return name.(string)
+	}
+
+	name := strconv.FormatUint(uint64(gid), 10)
+	if g, err := user.LookupGroupId(name); err == nil {
+		name = g.Name
+	}
+	nameCache.Store(key, name)
+	return name
+}
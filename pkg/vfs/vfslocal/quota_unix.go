@@ -0,0 +1,23 @@
+//go:build !windows
+
+package vfslocal
+
+import (
+	"syscall"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+)
+
+// filesystemQuota reports the usage of the filesystem containing absPath
+func filesystemQuota(absPath string) (vfs.QuotaInfo, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(absPath, &stat); err != nil {
+		return vfs.QuotaInfo{}, err
+	}
+
+	blockSize := int64(stat.Bsize)
+	return vfs.QuotaInfo{
+		Used:      (int64(stat.Blocks) - int64(stat.Bfree)) * blockSize,
+		Available: int64(stat.Bavail) * blockSize,
+	}, nil
+}
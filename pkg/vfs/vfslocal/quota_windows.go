@@ -0,0 +1,12 @@
+//go:build windows
+
+package vfslocal
+
+import (
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+)
+
+// filesystemQuota is not supported on Windows
+func filesystemQuota(absPath string) (vfs.QuotaInfo, error) {
+	return vfs.QuotaInfo{}, vfs.ErrNotImplemented
+}
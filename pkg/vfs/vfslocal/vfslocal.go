@@ -73,7 +73,7 @@ func (l *LocalVFS) getMetadataFromFileInfo(info os.FileInfo, path string) *vfs.M
 		fileType = vfs.FileTypeSymlink
 	}
 	
-	return &vfs.Metadata{
+	metadata := &vfs.Metadata{
 		ID:         0, // Local filesystem doesn't use IDs
 		Name:       info.Name(),
 		FileType:   fileType,
@@ -81,7 +81,18 @@ func (l *LocalVFS) getMetadataFromFileInfo(info os.FileInfo, path string) *vfs.M
 		CreatedAt:  info.ModTime().Unix(), // Use ModTime for CreatedAt as os.FileInfo doesn't provide creation time
 		ModifiedAt: info.ModTime().Unix(),
 		AccessedAt: time.Now().Unix(), // Use current time as AccessedAt
+		Mode:       fromFileMode(info.Mode()),
 	}
+	
+	// Fill in ownership where the platform provides it
+	if uid, gid, ok := fileOwner(info); ok {
+		metadata.UID = uid
+		metadata.GID = gid
+		metadata.Owner = lookupUserName(uid)
+		metadata.Group = lookupGroupName(gid)
+	}
+	
+	return metadata
 }
 
 // createFSEntry creates an FSEntry from a file path
@@ -94,6 +105,11 @@ func (l *LocalVFS) createFSEntry(absPath string) (vfs.FSEntry, error) {
 	relPath := l.getRelPath(absPath)
 	metadata := l.getMetadataFromFileInfo(info, relPath)
 	
+	// Extended attributes cannot be set on symlinks themselves, so only read them for files and directories
+	if info.Mode()&os.ModeSymlink == 0 {
+		metadata.Attributes = readAttributes(absPath)
+	}
+	
 	if info.IsDir() {
 		return &DirectoryEntry{
 			metadata: metadata,
@@ -314,6 +330,51 @@ func (l *LocalVFS) DirDelete(path string) error {
 	return os.RemoveAll(absPath)
 }
 
+// Chmod changes the permission bits of a filesystem entry
+func (l *LocalVFS) Chmod(path string, mode uint32) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	
+	absPath := l.getAbsPath(path)
+	return os.Chmod(absPath, toFileMode(mode))
+}
+
+// Chown changes the numeric owner and group of a filesystem entry
+func (l *LocalVFS) Chown(path string, uid, gid uint32) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	
+	absPath := l.getAbsPath(path)
+	return os.Lchown(absPath, int(uid), int(gid))
+}
+
+// AttrSet sets an extended attribute on a filesystem entry
+func (l *LocalVFS) AttrSet(path, name, value string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	
+	absPath := l.getAbsPath(path)
+	return setAttribute(absPath, name, value)
+}
+
+// AttrDelete removes an extended attribute from a filesystem entry
+func (l *LocalVFS) AttrDelete(path, name string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	
+	absPath := l.getAbsPath(path)
+	return removeAttribute(absPath, name)
+}
+
+// Quota reports the usage of the filesystem holding path. The numbers are
+// those of the whole filesystem, not only of the files below path.
+func (l *LocalVFS) Quota(path string) (vfs.QuotaInfo, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	
+	return filesystemQuota(l.getAbsPath(path))
+}
+
 // Exists checks if a path exists
 func (l *LocalVFS) Exists(path string) bool {
 	l.mu.RLock()
@@ -0,0 +1,78 @@
+//go:build linux
+
+package vfslocal
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"syscall"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+)
+
+// xattrPrefix is the namespace used for VFS attributes; unprivileged users may only use user.*
+const xattrPrefix = "user."
+
+// readAttributes returns the user extended attributes of a file
+func readAttributes(absPath string) map[string]string {
+	size, err := syscall.Listxattr(absPath, nil)
+	if err != nil || size <= 0 {
+		return nil
+	}
+
+	list := make([]byte, size)
+	size, err = syscall.Listxattr(absPath, list)
+	if err != nil {
+		return nil
+	}
+
+	var attrs map[string]string
+	for _, name := range bytes.Split(list[:size], []byte{0}) {
+		if !bytes.HasPrefix(name, []byte(xattrPrefix)) {
+			continue
+		}
+
+		value, err := getxattr(absPath, string(name))
+		if err != nil {
+			continue
+		}
+		if attrs == nil {
+			attrs = make(map[string]string)
+		}
+		attrs[strings.TrimPrefix(string(name), xattrPrefix)] = value
+	}
+	return attrs
+}
+
+// getxattr reads a single extended attribute value
+func getxattr(absPath, name string) (string, error) {
+	size, err := syscall.Getxattr(absPath, name, nil)
+	if err != nil {
+		return "", err
+	}
+	if size == 0 {
+		return "", nil
+	}
+
+	value := make([]byte, size)
+	size, err = syscall.Getxattr(absPath, name, value)
+	if err != nil {
+		return "", err
+	}
+	return string(value[:size]), nil
+}
+
+// setAttribute stores a user extended attribute on a file
+func setAttribute(absPath, name, value string) error {
+	return syscall.Setxattr(absPath, xattrPrefix+name, []byte(value), 0)
+}
+
+// removeAttribute removes a user extended attribute from a file
+func removeAttribute(absPath, name string) error {
+	err := syscall.Removexattr(absPath, xattrPrefix+name)
+	if errors.Is(err, syscall.ENODATA) {
+		return vfs.ErrNoAttribute
+	}
+	return err
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package vfslocal
+
+import "github.com/freeflowuniverse/herolauncher/pkg/vfs"
+
+// readAttributes returns the user extended attributes of a file; not supported on this platform
+func readAttributes(absPath string) map[string]string {
+	return nil
+}
+
+// setAttribute stores a user extended attribute on a file; not supported on this platform
+func setAttribute(absPath, name, value string) error {
+	return vfs.ErrNotImplemented
+}
+
+// removeAttribute removes a user extended attribute from a file; not supported on this platform
+func removeAttribute(absPath, name string) error {
+	return vfs.ErrNotImplemented
+}
@@ -37,6 +37,15 @@ func (n *NestedVFS) AddVFS(prefix string, impl vfs.VFSImplementation) error {
 	return nil
 }
 
+// GetVFS returns the VFS implementation mounted at exactly the given prefix
+func (n *NestedVFS) GetVFS(prefix string) (vfs.VFSImplementation, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	impl, ok := n.vfsMap[prefix]
+	return impl, ok
+}
+
 // findVFS finds the appropriate VFS implementation for a given path
 func (n *NestedVFS) findVFS(path string) (vfs.VFSImplementation, string, error) {
 	n.mu.RLock()
@@ -271,6 +280,89 @@ func (n *NestedVFS) DirDelete(path string) error {
 	return impl.DirDelete(relPath)
 }
 
+// Chmod changes the permission bits of a filesystem entry
+func (n *NestedVFS) Chmod(path string, mode uint32) error {
+	// The nested root is synthetic and has no permissions of its own
+	if path == "" || path == "/" {
+		return vfs.ErrPermission
+	}
+
+	impl, relPath, err := n.findVFS(path)
+	if err != nil {
+		return err
+	}
+	return impl.Chmod(relPath, mode)
+}
+
+// Chown changes the numeric owner and group of a filesystem entry
+func (n *NestedVFS) Chown(path string, uid, gid uint32) error {
+	// The nested root is synthetic and has no owner of its own
+	if path == "" || path == "/" {
+		return vfs.ErrPermission
+	}
+
+	impl, relPath, err := n.findVFS(path)
+	if err != nil {
+		return err
+	}
+	return impl.Chown(relPath, uid, gid)
+}
+
+// AttrSet sets an extended attribute on a filesystem entry
+func (n *NestedVFS) AttrSet(path, name, value string) error {
+	if path == "" || path == "/" {
+		return vfs.ErrPermission
+	}
+
+	impl, relPath, err := n.findVFS(path)
+	if err != nil {
+		return err
+	}
+	return impl.AttrSet(relPath, name, value)
+}
+
+// AttrDelete removes an extended attribute from a filesystem entry
+func (n *NestedVFS) AttrDelete(path, name string) error {
+	if path == "" || path == "/" {
+		return vfs.ErrPermission
+	}
+
+	impl, relPath, err := n.findVFS(path)
+	if err != nil {
+		return err
+	}
+	return impl.AttrDelete(relPath, name)
+}
+
+// Quota reports the storage used below path. For the root, the usage of all
+// mounted VFS implementations is added up and the available space is unknown.
+func (n *NestedVFS) Quota(path string) (vfs.QuotaInfo, error) {
+	if path == "" || path == "/" {
+		n.mu.RLock()
+		impls := make([]vfs.VFSImplementation, 0, len(n.vfsMap))
+		for _, impl := range n.vfsMap {
+			impls = append(impls, impl)
+		}
+		n.mu.RUnlock()
+
+		info := vfs.QuotaInfo{Available: -1}
+		for _, impl := range impls {
+			quota, err := impl.Quota("/")
+			if err != nil {
+				return vfs.QuotaInfo{}, err
+			}
+			info.Used += quota.Used
+		}
+		return info, nil
+	}
+
+	impl, relPath, err := n.findVFS(path)
+	if err != nil {
+		return vfs.QuotaInfo{}, err
+	}
+	return impl.Quota(relPath)
+}
+
 // Exists checks if a path exists
 func (n *NestedVFS) Exists(path string) bool {
 	// Root always exists
@@ -174,6 +174,15 @@ func TestNestedVFSEdgeCases(t *testing.T) {
 		assert.Error(t, err)
 	})
 
+	t.Run("GetVFS", func(t *testing.T) {
+		impl, ok := nestedVFS.GetVFS("/config")
+		assert.True(t, ok)
+		assert.Equal(t, vfs2, impl)
+
+		_, ok = nestedVFS.GetVFS("/data/sub")
+		assert.False(t, ok)
+	})
+
 	t.Run("ResourceForkFiles", func(t *testing.T) {
 		// Test special handling for macOS resource fork files
 		assert.True(t, nestedVFS.Exists("/._resource"))
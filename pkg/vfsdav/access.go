@@ -0,0 +1,53 @@
+package vfsdav
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// modifiedPath is a path a request modifies
+type modifiedPath struct {
+	name string
+	// recursive is set when the members of a collection are modified as well
+	recursive bool
+}
+
+// modifiedPaths returns the paths modified by a request, which is empty for
+// read requests
+func modifiedPaths(r *http.Request) ([]modifiedPath, error) {
+	name := normalizePath(r.URL.Path)
+
+	switch r.Method {
+	case http.MethodPut, "MKCOL", "PROPPATCH", "LOCK":
+		return []modifiedPath{{name: name}}, nil
+	case http.MethodDelete:
+		return []modifiedPath{{name: name, recursive: true}}, nil
+	case "COPY", "MOVE":
+		dest, err := destinationPath(r)
+		if err != nil {
+			return nil, err
+		}
+		paths := []modifiedPath{{name: dest, recursive: true}}
+		if r.Method == "MOVE" {
+			paths = append(paths, modifiedPath{name: name, recursive: true})
+		}
+		return paths, nil
+	}
+	return nil, nil
+}
+
+// checkWritable rejects modifications of read-only paths. If recursive is
+// set, the members of name must not be read-only either.
+func (s *Server) checkWritable(name string, recursive bool) error {
+	if s.options.ReadOnly {
+		return &httpError{http.StatusForbidden, fmt.Errorf("server is read-only")}
+	}
+
+	for _, prefix := range s.options.ReadOnlyPaths {
+		prefix = normalizePath(prefix)
+		if name == prefix || isDescendant(name, prefix) || (recursive && isDescendant(prefix, name)) {
+			return &httpError{http.StatusForbidden, fmt.Errorf("%s is read-only", name)}
+		}
+	}
+	return nil
+}
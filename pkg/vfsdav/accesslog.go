@@ -0,0 +1,128 @@
+package vfsdav
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AccessLogEntry describes a request served by the server
+type AccessLogEntry struct {
+	Time       time.Time
+	Method     string
+	Path       string
+	Status     int
+	Bytes      int64
+	Duration   time.Duration
+	RemoteAddr string
+	UserAgent  string
+}
+
+// Fields returns the entry as a map with snake_case keys, as written by the
+// JSON and Redis access loggers
+func (e AccessLogEntry) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"time":        e.Time.UTC().Format(time.RFC3339Nano),
+		"method":      e.Method,
+		"path":        e.Path,
+		"status":      e.Status,
+		"bytes":       e.Bytes,
+		"duration_ms": float64(e.Duration) / float64(time.Millisecond),
+		"remote_addr": e.RemoteAddr,
+		"user_agent":  e.UserAgent,
+	}
+}
+
+// AccessLogger receives an entry for every request served
+type AccessLogger interface {
+	Log(entry AccessLogEntry)
+}
+
+// AccessLoggerFunc adapts a function to the AccessLogger interface
+type AccessLoggerFunc func(entry AccessLogEntry)
+
+// Log calls f(entry)
+func (f AccessLoggerFunc) Log(entry AccessLogEntry) {
+	f(entry)
+}
+
+// jsonAccessLogger writes one JSON object per line
+type jsonAccessLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONAccessLogger returns an access logger writing JSON lines to w, e.g.
+// os.Stdout or an opened log file
+func NewJSONAccessLogger(w io.Writer) AccessLogger {
+	return &jsonAccessLogger{w: w}
+}
+
+func (l *jsonAccessLogger) Log(entry AccessLogEntry) {
+	line, err := json.Marshal(entry.Fields())
+	if err != nil {
+		log.Printf("Failed to encode access log entry: %v", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to write access log entry: %v", err)
+	}
+}
+
+// redisAccessLogger appends entries to a Redis stream
+type redisAccessLogger struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+}
+
+// NewRedisAccessLogger returns an access logger adding entries to a Redis
+// stream with XADD. When maxLen is positive, the stream is trimmed to about
+// that many entries.
+func NewRedisAccessLogger(client *redis.Client, stream string, maxLen int64) AccessLogger {
+	return &redisAccessLogger{client: client, stream: stream, maxLen: maxLen}
+}
+
+func (l *redisAccessLogger) Log(entry AccessLogEntry) {
+	args := &redis.XAddArgs{
+		Stream: l.stream,
+		Values: entry.Fields(),
+	}
+	if l.maxLen > 0 {
+		args.MaxLen = l.maxLen
+		args.Approx = true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := l.client.XAdd(ctx, args).Err(); err != nil {
+		log.Printf("Failed to add access log entry to Redis stream %s: %v", l.stream, err)
+	}
+}
+
+// logRequest serves a request through next and reports it to the access logger
+func (s *Server) logRequest(w http.ResponseWriter, r *http.Request, next func(http.ResponseWriter, *http.Request)) {
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	next(sw, r)
+
+	s.options.AccessLog.Log(AccessLogEntry{
+		Time:       start,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Status:     sw.status,
+		Bytes:      sw.bytes,
+		Duration:   time.Since(start),
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+	})
+}
@@ -0,0 +1,191 @@
+package vfsdav
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// CompressionOptions configures the compression of GET and PROPFIND responses
+type CompressionOptions struct {
+	// MinSize is the smallest response body worth compressing, in bytes
+	MinSize int
+	// ExcludedContentTypes lists media types that are already compressed.
+	// Entries ending in "/" exclude a whole top-level type, e.g. "video/".
+	ExcludedContentTypes []string
+	// GzipLevel and BrotliLevel are the compression levels of both encodings
+	GzipLevel   int
+	BrotliLevel int
+}
+
+// DefaultCompressionOptions returns compression options skipping small
+// responses and common archive and media formats
+func DefaultCompressionOptions() CompressionOptions {
+	return CompressionOptions{
+		MinSize: 1024,
+		ExcludedContentTypes: []string{"video/", "audio/",
+			"image/jpeg", "image/png", "image/gif", "image/webp", "image/avif", "image/heic",
+			"application/zip", "application/gzip", "application/x-gzip", "application/x-bzip2", "application/x-xz",
+			"application/x-7z-compressed", "application/x-rar-compressed", "application/zstd", "application/pdf",
+			"font/woff", "font/woff2"},
+		GzipLevel:   gzip.DefaultCompression,
+		BrotliLevel: brotli.DefaultCompression,
+	}
+}
+
+// negotiateEncoding picks brotli or gzip from an Accept-Encoding header,
+// preferring brotli when both are acceptable
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "br" && coding != "gzip" {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ || (q == bestQ && coding == "br") {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// excluded reports whether responses of a content type are sent uncompressed
+func (c *CompressionOptions) excluded(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, excluded := range c.ExcludedContentTypes {
+		if mediaType == excluded || (strings.HasSuffix(excluded, "/") && strings.HasPrefix(mediaType, excluded)) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap returns a writer compressing the response to r, or nil when the
+// response should not be compressed. The writer must be closed.
+func (c *CompressionOptions) wrap(w http.ResponseWriter, r *http.Request) *compressWriter {
+	// Partial content refers to the bytes of the uncompressed file
+	if (r.Method != http.MethodGet && r.Method != "PROPFIND") || r.Header.Get("Range") != "" {
+		return nil
+	}
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return nil
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	return &compressWriter{ResponseWriter: w, options: c, encoding: encoding}
+}
+
+// compressWriter compresses a response body once its headers show it is
+// worth it. Bodies of unknown length are buffered until MinSize is reached.
+type compressWriter struct {
+	http.ResponseWriter
+	options  *CompressionOptions
+	encoding string
+
+	status  int
+	pending bool
+	buf     []byte
+	encoder io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = code
+
+	header := w.Header()
+	if (code != http.StatusOK && code != http.StatusMultiStatus) ||
+		header.Get("Content-Encoding") != "" || w.options.excluded(header.Get("Content-Type")) {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if length := header.Get("Content-Length"); length != "" {
+		if n, err := strconv.Atoi(length); err == nil && n < w.options.MinSize {
+			w.ResponseWriter.WriteHeader(code)
+			return
+		}
+		w.startCompression()
+		return
+	}
+	w.pending = true
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.pending {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.options.MinSize {
+			return len(p), nil
+		}
+		w.pending = false
+		w.startCompression()
+		if _, err := w.encoder.Write(w.buf); err != nil {
+			return 0, err
+		}
+		w.buf = nil
+		return len(p), nil
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// startCompression sends the headers of a compressed response
+func (w *compressWriter) startCompression() {
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", w.encoding)
+	// The compressed body is a different representation of the resource
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	if w.encoding == "br" {
+		w.encoder = brotli.NewWriterLevel(w.ResponseWriter, w.options.BrotliLevel)
+		return
+	}
+	encoder, err := gzip.NewWriterLevel(w.ResponseWriter, w.options.GzipLevel)
+	if err != nil {
+		encoder = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.encoder = encoder
+}
+
+// Close flushes the compressed body, or sends a buffered body that stayed
+// below MinSize uncompressed
+func (w *compressWriter) Close() error {
+	if w.pending {
+		w.pending = false
+		w.Header().Set("Content-Length", strconv.Itoa(len(w.buf)))
+		w.ResponseWriter.WriteHeader(w.status)
+		_, err := w.ResponseWriter.Write(w.buf)
+		return err
+	}
+	if w.encoder != nil {
+		return w.encoder.Close()
+	}
+	return nil
+}
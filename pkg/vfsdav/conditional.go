@@ -0,0 +1,70 @@
+package vfsdav
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkConditions evaluates the If-Match, If-None-Match and
+// If-Unmodified-Since headers of a request modifying the resource at name.
+// Conditional GET and HEAD requests are handled by http.ServeContent in go-webdav.
+func (s *Server) checkConditions(r *http.Request, name string) error {
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	ifUnmodifiedSince := r.Header.Get("If-Unmodified-Since")
+	if ifMatch == "" && ifNoneMatch == "" && ifUnmodifiedSince == "" {
+		return nil
+	}
+
+	exists := false
+	var etag string
+	var modTime time.Time
+	if entry, err := s.vfsImpl.Get(name); err == nil && s.vfsImpl.Exists(name) {
+		exists = true
+		metadata := entry.GetMetadata()
+		if !entry.IsDir() {
+			etag = strconv.Quote(generateETag(metadata))
+		}
+		modTime = time.Unix(metadata.ModifiedAt, 0)
+	}
+
+	failed := &httpError{http.StatusPreconditionFailed, fmt.Errorf("precondition failed for %s", name)}
+	if ifMatch != "" {
+		if !exists || !etagMatches(ifMatch, etag, false) {
+			return failed
+		}
+	} else if ifUnmodifiedSince != "" && exists {
+		if since, err := http.ParseTime(ifUnmodifiedSince); err == nil && modTime.After(since) {
+			return failed
+		}
+	}
+	if ifNoneMatch != "" && exists && etagMatches(ifNoneMatch, etag, true) {
+		return failed
+	}
+	return nil
+}
+
+// etagMatches reports whether one of the entity tags in a list header matches
+// etag. "*" matches any existing resource. Weak tags only match when weak
+// comparison is allowed.
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if etag != "" && candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package vfsdav
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures Cross-Origin Resource Sharing, which browser based
+// file managers need to talk to the server directly
+type CORSOptions struct {
+	// AllowedOrigins lists the origins allowed to make requests. "*" allows
+	// any origin and "https://*.example.com" any subdomain of example.com.
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read
+	ExposedHeaders []string
+	// AllowCredentials allows cookies and Authorization headers. The request
+	// origin is then echoed instead of "*".
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache preflight results
+	MaxAge time.Duration
+}
+
+// DefaultCORSOptions returns CORS options allowing any origin to use all WebDAV methods
+func DefaultCORSOptions() CORSOptions {
+	return CORSOptions{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "HEAD", "PUT", "PATCH", "POST", "DELETE", "OPTIONS", "PROPFIND", "PROPPATCH", "MKCOL", "MOVE", "COPY", "LOCK", "UNLOCK"},
+		AllowedHeaders: []string{"Content-Type", "Depth", "Destination", "Overwrite", "User-Agent", "X-File-Size", "X-Requested-With",
+			"If-Modified-Since", "X-File-Name", "Cache-Control", "Authorization", "If", "Lock-Token", "Timeout"},
+		ExposedHeaders: []string{"DAV", "Content-Length", "Allow", "Lock-Token", "ETag"},
+		MaxAge:         24 * time.Hour,
+	}
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for a request
+// origin, or an empty string if the origin is not allowed
+func (c *CORSOptions) allowOrigin(origin string) string {
+	for _, allowed := range c.AllowedOrigins {
+		switch {
+		case allowed == "*":
+			if c.AllowCredentials {
+				return origin
+			}
+			return "*"
+		case strings.EqualFold(allowed, origin):
+			return origin
+		case strings.Contains(allowed, "://*."):
+			scheme, domain, _ := strings.Cut(allowed, "://*")
+			if strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(strings.ToLower(origin), strings.ToLower(domain)) {
+				return origin
+			}
+		}
+	}
+	return ""
+}
+
+// handle sets the CORS headers of a response. It returns true if the request
+// was a preflight request and has been answered.
+func (c *CORSOptions) handle(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+
+	header := w.Header()
+	header.Add("Vary", "Origin")
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+	allowed := c.allowOrigin(origin)
+	if allowed == "" {
+		if preflight {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return preflight
+	}
+
+	header.Set("Access-Control-Allow-Origin", allowed)
+	if c.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if !preflight {
+		if len(c.ExposedHeaders) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+		}
+		return false
+	}
+
+	header.Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+	header.Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+	if c.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
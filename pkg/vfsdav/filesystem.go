@@ -0,0 +1,309 @@
+package vfsdav
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-webdav"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+)
+
+// Ensure FileSystem implements webdav.FileSystem
+var _ webdav.FileSystem = (*FileSystem)(nil)
+
+// FileSystem implements the webdav.FileSystem interface using a vfs.VFSImplementation
+type FileSystem struct {
+	vfsImpl vfs.VFSImplementation
+}
+
+// NewFileSystem creates a new WebDAV filesystem backed by the given VFS implementation
+func NewFileSystem(vfsImpl vfs.VFSImplementation) *FileSystem {
+	return &FileSystem{
+		vfsImpl: vfsImpl,
+	}
+}
+
+// Open opens the file at the specified path for reading
+func (fs *FileSystem) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	name = normalizePath(name)
+
+	entry, err := fs.get(name)
+	if err != nil {
+		return nil, err
+	}
+	if entry.IsDir() {
+		return nil, webdav.NewHTTPError(http.StatusMethodNotAllowed, vfs.ErrNotFile)
+	}
+
+	data, err := fs.vfsImpl.FileRead(name)
+	if err != nil {
+		return nil, webdav.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	// A seekable reader lets go-webdav serve Range and conditional GET requests
+	return readSeekNopCloser{bytes.NewReader(data)}, nil
+}
+
+// readSeekNopCloser adds a no-op Close to an io.ReadSeeker
+type readSeekNopCloser struct {
+	io.ReadSeeker
+}
+
+func (readSeekNopCloser) Close() error {
+	return nil
+}
+
+// Stat returns information about the file or directory at the specified path
+func (fs *FileSystem) Stat(ctx context.Context, name string) (*webdav.FileInfo, error) {
+	name = normalizePath(name)
+
+	entry, err := fs.get(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return entryToFileInfo(entry, name), nil
+}
+
+// ReadDir returns the entries of a directory, descending into subdirectories if recursive is set
+func (fs *FileSystem) ReadDir(ctx context.Context, name string, recursive bool) ([]webdav.FileInfo, error) {
+	name = normalizePath(name)
+
+	entry, err := fs.get(name)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.IsDir() {
+		return nil, webdav.NewHTTPError(http.StatusBadRequest, vfs.ErrNotDirectory)
+	}
+
+	result := []webdav.FileInfo{*entryToFileInfo(entry, name)}
+	if err := fs.readDir(name, recursive, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// readDir appends the children of a directory to result
+func (fs *FileSystem) readDir(name string, recursive bool, result *[]webdav.FileInfo) error {
+	entries, err := fs.vfsImpl.DirList(name)
+	if err != nil {
+		return webdav.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	for _, child := range entries {
+		childPath := path.Join(name, child.GetMetadata().Name)
+		*result = append(*result, *entryToFileInfo(child, childPath))
+
+		if recursive && child.IsDir() {
+			if err := fs.readDir(childPath, recursive, result); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Create creates or updates a file
+func (fs *FileSystem) Create(ctx context.Context, name string, body io.ReadCloser) (fileInfo *webdav.FileInfo, created bool, err error) {
+	name = normalizePath(name)
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, false, err
+	}
+
+	existing, err := fs.vfsImpl.Get(name)
+	exists := err == nil
+	if exists && existing.IsDir() {
+		return nil, false, webdav.NewHTTPError(http.StatusConflict, fmt.Errorf("cannot create file, path is a directory: %s", name))
+	}
+
+	if !exists {
+		// WebDAV requires the parent collection to exist
+		if parent, err := fs.vfsImpl.Get(path.Dir(name)); err != nil || !parent.IsDir() {
+			return nil, false, webdav.NewHTTPError(http.StatusConflict, fmt.Errorf("parent collection does not exist: %s", path.Dir(name)))
+		}
+		if _, err := fs.vfsImpl.FileCreate(name); err != nil {
+			return nil, false, webdav.NewHTTPError(http.StatusInternalServerError, fmt.Errorf("failed to create file: %w", err))
+		}
+	}
+
+	if err := fs.vfsImpl.FileWrite(name, data); err != nil {
+		return nil, false, webdav.NewHTTPError(http.StatusInternalServerError, fmt.Errorf("failed to write file: %w", err))
+	}
+
+	entry, err := fs.vfsImpl.Get(name)
+	if err != nil {
+		return nil, false, webdav.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	return entryToFileInfo(entry, name), !exists, nil
+}
+
+// RemoveAll removes a file or directory
+func (fs *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	name = normalizePath(name)
+
+	if _, err := fs.get(name); err != nil {
+		return err
+	}
+
+	return fs.vfsImpl.Delete(name)
+}
+
+// Mkdir creates a directory
+func (fs *FileSystem) Mkdir(ctx context.Context, name string) error {
+	name = normalizePath(name)
+
+	if fs.vfsImpl.Exists(name) {
+		return webdav.NewHTTPError(http.StatusMethodNotAllowed, vfs.ErrAlreadyExists)
+	}
+	if parent, err := fs.vfsImpl.Get(path.Dir(name)); err != nil || !parent.IsDir() {
+		return webdav.NewHTTPError(http.StatusConflict, fmt.Errorf("parent collection does not exist: %s", path.Dir(name)))
+	}
+
+	if _, err := fs.vfsImpl.DirCreate(name); err != nil {
+		return webdav.NewHTTPError(http.StatusInternalServerError, fmt.Errorf("failed to create directory: %w", err))
+	}
+	return nil
+}
+
+// Copy copies a file or directory
+func (fs *FileSystem) Copy(ctx context.Context, name, dest string, options *webdav.CopyOptions) (created bool, err error) {
+	name = normalizePath(name)
+	dest = normalizePath(dest)
+
+	created, err = fs.prepareDestination(name, dest, options != nil && options.NoOverwrite)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := fs.vfsImpl.Copy(name, dest); err != nil {
+		return false, webdav.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return created, nil
+}
+
+// Move moves a file or directory
+func (fs *FileSystem) Move(ctx context.Context, name, dest string, options *webdav.MoveOptions) (created bool, err error) {
+	name = normalizePath(name)
+	dest = normalizePath(dest)
+
+	created, err = fs.prepareDestination(name, dest, options != nil && options.NoOverwrite)
+	if err != nil {
+		return false, err
+	}
+
+	if path.Dir(name) == path.Dir(dest) {
+		_, err = fs.vfsImpl.Rename(name, dest)
+	} else {
+		_, err = fs.vfsImpl.Move(name, dest)
+	}
+	if err != nil {
+		return false, webdav.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return created, nil
+}
+
+// prepareDestination checks the source and parent of a copy or move and
+// removes an existing destination when overwriting is allowed. It returns
+// whether the destination is newly created.
+func (fs *FileSystem) prepareDestination(name, dest string, noOverwrite bool) (bool, error) {
+	if _, err := fs.get(name); err != nil {
+		return false, err
+	}
+	if name == dest {
+		return false, webdav.NewHTTPError(http.StatusForbidden, fmt.Errorf("source and destination are the same"))
+	}
+	if parent, err := fs.vfsImpl.Get(path.Dir(dest)); err != nil || !parent.IsDir() {
+		return false, webdav.NewHTTPError(http.StatusConflict, fmt.Errorf("parent collection does not exist: %s", path.Dir(dest)))
+	}
+
+	if !fs.vfsImpl.Exists(dest) {
+		return true, nil
+	}
+	if noOverwrite {
+		return false, webdav.NewHTTPError(http.StatusPreconditionFailed, vfs.ErrAlreadyExists)
+	}
+	if err := fs.vfsImpl.Delete(dest); err != nil {
+		return false, webdav.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return false, nil
+}
+
+// get returns the entry at name, mapping a missing entry to 404
+func (fs *FileSystem) get(name string) (vfs.FSEntry, error) {
+	entry, err := fs.vfsImpl.Get(name)
+	if err != nil {
+		if !fs.vfsImpl.Exists(name) {
+			return nil, webdav.NewHTTPError(http.StatusNotFound, vfs.ErrNotFound)
+		}
+		return nil, webdav.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return entry, nil
+}
+
+// entryToFileInfo converts a vfs.FSEntry to a webdav.FileInfo
+func entryToFileInfo(entry vfs.FSEntry, name string) *webdav.FileInfo {
+	metadata := entry.GetMetadata()
+
+	info := &webdav.FileInfo{
+		Path:    name,
+		IsDir:   entry.IsDir(),
+		ModTime: time.Unix(metadata.ModifiedAt, 0),
+	}
+	if !info.IsDir {
+		info.Size = int64(metadata.Size)
+		info.MIMEType = getMIMEType(metadata.Name)
+		info.ETag = generateETag(metadata)
+	}
+	return info
+}
+
+// normalizePath ensures the path is properly formatted for VFS operations
+func normalizePath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// getMIMEType returns a MIME type based on the file extension
+func getMIMEType(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".html", ".htm":
+		return "text/html"
+	case ".css":
+		return "text/css"
+	case ".js":
+		return "application/javascript"
+	case ".json":
+		return "application/json"
+	case ".png":
+		return "image/png"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".gif":
+		return "image/gif"
+	case ".svg":
+		return "image/svg+xml"
+	case ".pdf":
+		return "application/pdf"
+	case ".txt":
+		return "text/plain"
+	case ".md":
+		return "text/markdown"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// generateETag generates a simple ETag for a file
+func generateETag(metadata *vfs.Metadata) string {
+	return fmt.Sprintf("%d-%d-%d", metadata.ID, metadata.Size, metadata.ModifiedAt)
+}
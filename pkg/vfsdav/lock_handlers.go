@@ -0,0 +1,252 @@
+package vfsdav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// supportedLock is the value of the supportedlock property
+const supportedLock = "<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>" +
+	"<D:lockentry><D:lockscope><D:shared/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>"
+
+// lockInfo is the body of a LOCK request creating a new lock
+type lockInfo struct {
+	XMLName   xml.Name `xml:"DAV: lockinfo"`
+	LockScope struct {
+		Exclusive *struct{} `xml:"DAV: exclusive"`
+		Shared    *struct{} `xml:"DAV: shared"`
+	} `xml:"DAV: lockscope"`
+	LockType struct {
+		Write *struct{} `xml:"DAV: write"`
+	} `xml:"DAV: locktype"`
+	Owner *rawXML `xml:"DAV: owner"`
+}
+
+// httpError is an error answered with a specific status code
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+// serveError writes err as a plain text response
+func serveError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		code = httpErr.code
+	}
+	http.Error(w, err.Error(), code)
+}
+
+// serveLock creates or refreshes a lock
+func (s *Server) serveLock(w http.ResponseWriter, r *http.Request) error {
+	name := normalizePath(r.URL.Path)
+	timeout := parseTimeout(r.Header.Get("Timeout"))
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// A LOCK without a body refreshes the lock given in the If header
+	if len(bytes.TrimSpace(body)) == 0 {
+		tokens := ifTokens(r.Header.Get("If"))
+		if len(tokens) != 1 {
+			return &httpError{http.StatusBadRequest, fmt.Errorf("lock refresh requires exactly one lock token")}
+		}
+		lock, err := s.locks.Refresh(name, tokens[0], timeout)
+		if err != nil {
+			return &httpError{http.StatusPreconditionFailed, err}
+		}
+		return writeLockResponse(w, lock, http.StatusOK)
+	}
+
+	var info lockInfo
+	if err := xml.Unmarshal(body, &info); err != nil {
+		return &httpError{http.StatusBadRequest, fmt.Errorf("invalid LOCK body: %w", err)}
+	}
+	if info.LockType.Write == nil {
+		return &httpError{http.StatusBadRequest, fmt.Errorf("only write locks are supported")}
+	}
+	if (info.LockScope.Exclusive == nil) == (info.LockScope.Shared == nil) {
+		return &httpError{http.StatusBadRequest, fmt.Errorf("lock scope must be exclusive or shared")}
+	}
+
+	depth, err := parseDepth(r.Header.Get("Depth"), -1)
+	if err != nil {
+		return err
+	}
+	if depth == 1 {
+		return &httpError{http.StatusBadRequest, fmt.Errorf("locks must have depth 0 or infinity")}
+	}
+
+	lock := Lock{
+		Root:      name,
+		Exclusive: info.LockScope.Exclusive != nil,
+		Infinite:  depth < 0,
+		Timeout:   timeout,
+	}
+	if info.Owner != nil {
+		lock.Owner = string(*info.Owner)
+	}
+
+	// Locking an unmapped URL creates an empty resource
+	created := false
+	if !s.vfsImpl.Exists(name) {
+		if parent, err := s.vfsImpl.Get(path.Dir(name)); err != nil || !parent.IsDir() {
+			return &httpError{http.StatusConflict, fmt.Errorf("parent collection does not exist: %s", path.Dir(name))}
+		}
+		created = true
+	}
+
+	lock, err = s.locks.Create(lock)
+	if err != nil {
+		if errors.Is(err, ErrLocked) {
+			return &httpError{http.StatusLocked, err}
+		}
+		return err
+	}
+
+	if created {
+		if _, err := s.vfsImpl.FileCreate(name); err != nil {
+			s.locks.Unlock(name, lock.Token)
+			return fmt.Errorf("failed to create locked resource: %w", err)
+		}
+	}
+
+	w.Header().Set("Lock-Token", "<"+lock.Token+">")
+	if created {
+		return writeLockResponse(w, lock, http.StatusCreated)
+	}
+	return writeLockResponse(w, lock, http.StatusOK)
+}
+
+// serveUnlock removes the lock given in the Lock-Token header
+func (s *Server) serveUnlock(w http.ResponseWriter, r *http.Request) error {
+	name := normalizePath(r.URL.Path)
+
+	token := strings.TrimSpace(r.Header.Get("Lock-Token"))
+	if !strings.HasPrefix(token, "<") || !strings.HasSuffix(token, ">") {
+		return &httpError{http.StatusBadRequest, fmt.Errorf("missing or invalid Lock-Token header")}
+	}
+
+	if err := s.locks.Unlock(name, token[1:len(token)-1]); err != nil {
+		return &httpError{http.StatusConflict, err}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// confirmLocks checks that the request submitted the tokens of the locks on
+// the resource at name and, if recursive is set, on its members
+func (s *Server) confirmLocks(r *http.Request, name string, recursive bool) error {
+	if err := s.locks.Confirm(name, recursive, ifTokens(r.Header.Get("If"))); err != nil {
+		return &httpError{http.StatusLocked, fmt.Errorf("%s: %w", name, err)}
+	}
+	return nil
+}
+
+// destinationPath returns the path of the Destination header of a COPY or MOVE request
+func destinationPath(r *http.Request) (string, error) {
+	dest, err := url.Parse(r.Header.Get("Destination"))
+	if err != nil || dest.Path == "" {
+		return "", &httpError{http.StatusBadRequest, fmt.Errorf("missing or invalid Destination header")}
+	}
+	return normalizePath(dest.Path), nil
+}
+
+// ifTokens returns the lock tokens submitted in the state lists of an If header
+func ifTokens(header string) []string {
+	var tokens []string
+	inList := false
+	for header != "" {
+		switch header[0] {
+		case '(':
+			inList = true
+		case ')':
+			inList = false
+		case '<':
+			end := strings.IndexByte(header, '>')
+			if end < 0 {
+				return tokens
+			}
+			if inList {
+				tokens = append(tokens, header[1:end])
+			}
+			header = header[end:]
+		case '[':
+			// Skip entity tags, they may contain '<' or '('
+			end := strings.IndexByte(header, ']')
+			if end < 0 {
+				return tokens
+			}
+			header = header[end:]
+		}
+		header = header[1:]
+	}
+	return tokens
+}
+
+// parseTimeout parses a Timeout header such as "Second-3600" or "Infinite,
+// Second-4100000000". Zero means the default timeout.
+func parseTimeout(header string) time.Duration {
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimSpace(value)
+		if value == "Infinite" {
+			return MaxLockTimeout
+		}
+		if seconds, ok := strings.CutPrefix(value, "Second-"); ok {
+			if n, err := strconv.ParseInt(seconds, 10, 64); err == nil && n > 0 {
+				return time.Duration(min(n, int64(MaxLockTimeout/time.Second))) * time.Second
+			}
+		}
+	}
+	return 0
+}
+
+// writeLockResponse answers a LOCK request with the lockdiscovery property of the lock
+func writeLockResponse(w http.ResponseWriter, lock Lock, code int) error {
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(code)
+	_, err := fmt.Fprintf(w, `%s<D:prop xmlns:D="DAV:"><D:lockdiscovery>%s</D:lockdiscovery></D:prop>`,
+		xml.Header, lockDiscovery([]Lock{lock}))
+	return err
+}
+
+// lockDiscovery returns the value of the lockdiscovery property for locks
+func lockDiscovery(locks []Lock) string {
+	var b strings.Builder
+	for _, lock := range locks {
+		scope, depth := "shared", "0"
+		if lock.Exclusive {
+			scope = "exclusive"
+		}
+		if lock.Infinite {
+			depth = "infinity"
+		}
+
+		fmt.Fprintf(&b, "<D:activelock><D:locktype><D:write/></D:locktype><D:lockscope><D:%s/></D:lockscope><D:depth>%s</D:depth>", scope, depth)
+		if lock.Owner != "" {
+			b.WriteString("<D:owner>" + lock.Owner + "</D:owner>")
+		}
+		fmt.Fprintf(&b, "<D:timeout>Second-%d</D:timeout>", int64(lock.Timeout/time.Second))
+		b.WriteString("<D:locktoken><D:href>" + xmlEscape(lock.Token) + "</D:href></D:locktoken>")
+		b.WriteString("<D:lockroot>")
+		writeHref(&b, lock.Root)
+		b.WriteString("</D:lockroot></D:activelock>")
+	}
+	return b.String()
+}
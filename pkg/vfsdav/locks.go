@@ -0,0 +1,220 @@
+package vfsdav
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLockTimeout is used when a client does not ask for a timeout
+	DefaultLockTimeout = time.Hour
+	// MaxLockTimeout caps the timeout of a lock, including "Infinite" requests
+	MaxLockTimeout = 24 * time.Hour
+)
+
+var (
+	// ErrLocked is returned when a resource is locked by a lock whose token was not submitted
+	ErrLocked = errors.New("resource is locked")
+	// ErrNoSuchLock is returned when a lock token does not match a lock on the resource
+	ErrNoSuchLock = errors.New("no such lock")
+)
+
+// Lock is a WebDAV write lock
+type Lock struct {
+	// Token is the opaquelocktoken URI identifying the lock
+	Token string
+	// Root is the path of the locked resource
+	Root string
+	// Exclusive locks conflict with every other lock, shared locks only with exclusive ones
+	Exclusive bool
+	// Infinite locks also cover all members of a collection
+	Infinite bool
+	// Owner is the XML content of the owner element supplied by the client
+	Owner   string
+	Timeout time.Duration
+	Expires time.Time
+}
+
+// covers reports whether the lock applies to the resource at name
+func (l *Lock) covers(name string) bool {
+	return name == l.Root || (l.Infinite && isDescendant(name, l.Root))
+}
+
+// LockManager keeps track of the WebDAV locks of a server in memory
+type LockManager struct {
+	mu    sync.Mutex
+	locks map[string]*Lock
+	now   func() time.Time
+}
+
+// NewLockManager creates an empty lock manager
+func NewLockManager() *LockManager {
+	return &LockManager{
+		locks: make(map[string]*Lock),
+		now:   time.Now,
+	}
+}
+
+// Create adds a new lock. It fails with ErrLocked if the lock conflicts with
+// an existing lock on the resource, one of its parents or, for infinite
+// locks, one of its members.
+func (m *LockManager) Create(lock Lock) (Lock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.purge()
+
+	for _, existing := range m.locks {
+		overlaps := existing.covers(lock.Root) || (lock.Infinite && isDescendant(existing.Root, lock.Root))
+		if overlaps && (lock.Exclusive || existing.Exclusive) {
+			return Lock{}, ErrLocked
+		}
+	}
+
+	token, err := newLockToken()
+	if err != nil {
+		return Lock{}, err
+	}
+	lock.Token = token
+	lock.Timeout = clampLockTimeout(lock.Timeout)
+	lock.Expires = m.now().Add(lock.Timeout)
+
+	m.locks[token] = &lock
+	return lock, nil
+}
+
+// Refresh extends the timeout of the lock with the given token, which must
+// apply to the resource at name
+func (m *LockManager) Refresh(name, token string, timeout time.Duration) (Lock, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.purge()
+
+	lock, ok := m.locks[token]
+	if !ok || !lock.covers(name) {
+		return Lock{}, ErrNoSuchLock
+	}
+	lock.Timeout = clampLockTimeout(timeout)
+	lock.Expires = m.now().Add(lock.Timeout)
+	return *lock, nil
+}
+
+// Unlock removes the lock with the given token, which must apply to the resource at name
+func (m *LockManager) Unlock(name, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.purge()
+
+	lock, ok := m.locks[token]
+	if !ok || !lock.covers(name) {
+		return ErrNoSuchLock
+	}
+	delete(m.locks, token)
+	return nil
+}
+
+// Locks returns the active locks that apply to the resource at name
+func (m *LockManager) Locks(name string) []Lock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.purge()
+
+	var result []Lock
+	for _, lock := range m.locks {
+		if lock.covers(name) {
+			result = append(result, *lock)
+		}
+	}
+	return result
+}
+
+// Confirm checks that a request submitting tokens may modify the resource at
+// name and, if recursive is set, its members. Exclusive locks require their
+// own token, shared locks require the token of any of the shared locks.
+func (m *LockManager) Confirm(name string, recursive bool, tokens []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.purge()
+
+	submitted := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		submitted[token] = true
+	}
+
+	// Shared locks are grouped by the resource they are rooted at
+	shared := make(map[string]bool)
+	for _, lock := range m.locks {
+		if !lock.covers(name) && !(recursive && isDescendant(lock.Root, name)) {
+			continue
+		}
+		if lock.Exclusive {
+			if !submitted[lock.Token] {
+				return ErrLocked
+			}
+			continue
+		}
+		shared[lock.Root] = shared[lock.Root] || submitted[lock.Token]
+	}
+
+	for _, ok := range shared {
+		if !ok {
+			return ErrLocked
+		}
+	}
+	return nil
+}
+
+// Remove drops all locks rooted at name or below it, e.g. after the resource was deleted
+func (m *LockManager) Remove(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for token, lock := range m.locks {
+		if lock.Root == name || isDescendant(lock.Root, name) {
+			delete(m.locks, token)
+		}
+	}
+}
+
+// purge removes expired locks; the caller must hold m.mu
+func (m *LockManager) purge() {
+	now := m.now()
+	for token, lock := range m.locks {
+		if now.After(lock.Expires) {
+			delete(m.locks, token)
+		}
+	}
+}
+
+// clampLockTimeout applies the default and maximum lock timeouts
+func clampLockTimeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return DefaultLockTimeout
+	}
+	if timeout > MaxLockTimeout {
+		return MaxLockTimeout
+	}
+	return timeout
+}
+
+// newLockToken generates an opaquelocktoken URI based on a random UUID
+func newLockToken() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("opaquelocktoken:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// isDescendant reports whether name is strictly below parent
+func isDescendant(name, parent string) bool {
+	if parent == "/" {
+		return name != "/"
+	}
+	return strings.HasPrefix(name, parent+"/")
+}
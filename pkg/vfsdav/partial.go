@@ -0,0 +1,101 @@
+package vfsdav
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// servePartialPut writes a PUT request with a Content-Range header. The range
+// must start at 0, replacing the file, or at the current end of the file,
+// appending to it, so interrupted uploads can be resumed chunk by chunk.
+func (s *Server) servePartialPut(w http.ResponseWriter, r *http.Request) error {
+	name := normalizePath(r.URL.Path)
+
+	start, end, err := parseContentRange(r.Header.Get("Content-Range"))
+	if err != nil {
+		return &httpError{http.StatusBadRequest, err}
+	}
+
+	var size int64
+	exists := s.vfsImpl.Exists(name)
+	if exists {
+		entry, err := s.vfsImpl.Get(name)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return &httpError{http.StatusConflict, fmt.Errorf("cannot write to a directory: %s", name)}
+		}
+		size = int64(entry.GetMetadata().Size)
+	}
+	if start != 0 && start != size {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return &httpError{http.StatusRequestedRangeNotSatisfiable, fmt.Errorf("range must start at 0 or at the end of the file (%d bytes)", size)}
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != end-start+1 {
+		return &httpError{http.StatusBadRequest, fmt.Errorf("body has %d bytes, Content-Range expects %d", len(data), end-start+1)}
+	}
+
+	if !exists {
+		// WebDAV requires the parent collection to exist
+		if parent, err := s.vfsImpl.Get(path.Dir(name)); err != nil || !parent.IsDir() {
+			return &httpError{http.StatusConflict, fmt.Errorf("parent collection does not exist: %s", path.Dir(name))}
+		}
+		if _, err := s.vfsImpl.FileCreate(name); err != nil {
+			return fmt.Errorf("failed to create file: %w", err)
+		}
+	}
+
+	if start == 0 {
+		err = s.vfsImpl.FileWrite(name, data)
+	} else {
+		err = s.vfsImpl.FileConcatenate(name, data)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	return nil
+}
+
+// parseContentRange parses a header like "bytes 0-1023/4096" or "bytes 0-1023/*"
+func parseContentRange(header string) (start, end int64, err error) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	byteRange, total, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	first, last, ok := strings.Cut(byteRange, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+
+	start, err1 := strconv.ParseInt(first, 10, 64)
+	end, err2 := strconv.ParseInt(last, 10, 64)
+	if err1 != nil || err2 != nil || start < 0 || end < start {
+		return 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+	}
+	if total != "*" {
+		if n, err := strconv.ParseInt(total, 10, 64); err != nil || n <= end {
+			return 0, 0, fmt.Errorf("invalid Content-Range %q", header)
+		}
+	}
+	return start, end, nil
+}
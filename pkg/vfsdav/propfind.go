@@ -0,0 +1,164 @@
+package vfsdav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-webdav"
+)
+
+// propfindRequest is the body of a PROPFIND request
+type propfindRequest struct {
+	XMLName  xml.Name   `xml:"DAV: propfind"`
+	AllProp  *struct{}  `xml:"DAV: allprop"`
+	PropName *struct{}  `xml:"DAV: propname"`
+	Prop     *propNames `xml:"DAV: prop"`
+}
+
+// propNames lists the properties requested by name
+type propNames struct {
+	Names []struct {
+		XMLName xml.Name
+	} `xml:",any"`
+}
+
+// servePropfind answers PROPFIND requests. vfsdav handles them itself rather
+// than through go-webdav so that it can report lock discovery and dead properties.
+func (s *Server) servePropfind(w http.ResponseWriter, r *http.Request) error {
+	name := normalizePath(r.URL.Path)
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var request propfindRequest
+	if len(bytes.TrimSpace(body)) == 0 {
+		request.AllProp = &struct{}{}
+	} else if err := xml.Unmarshal(body, &request); err != nil {
+		return &httpError{http.StatusBadRequest, fmt.Errorf("invalid PROPFIND body: %w", err)}
+	}
+
+	depth, err := parseDepth(r.Header.Get("Depth"), -1)
+	if err != nil {
+		return err
+	}
+
+	if !s.vfsImpl.Exists(name) {
+		return &httpError{http.StatusNotFound, fmt.Errorf("not found: %s", name)}
+	}
+	info, err := s.fs.Stat(r.Context(), name)
+	if err != nil {
+		return err
+	}
+
+	infos := []webdav.FileInfo{*info}
+	if info.IsDir && depth != 0 {
+		if infos, err = s.fs.ReadDir(r.Context(), name, depth < 0); err != nil {
+			return err
+		}
+	}
+
+	// Quota properties are expensive and only reported when asked for by name
+	quota := false
+	if request.Prop != nil {
+		for _, requested := range request.Prop.Names {
+			quota = quota || (requested.XMLName.Space == davNamespace && strings.HasPrefix(requested.XMLName.Local, "quota-"))
+		}
+	}
+
+	responses := make([]davResponse, 0, len(infos))
+	for i := range infos {
+		responses = append(responses, propfindResponse(&request, infos[i].Path, s.properties(&infos[i], quota)))
+	}
+	return writeMultiStatus(w, responses)
+}
+
+// propfindResponse selects the properties asked for by request
+func propfindResponse(request *propfindRequest, name string, props []davProp) davResponse {
+	resp := davResponse{Path: name, Props: make(map[int][]davProp)}
+
+	switch {
+	case request.PropName != nil:
+		for _, prop := range props {
+			resp.Props[http.StatusOK] = append(resp.Props[http.StatusOK], davProp{Name: prop.Name})
+		}
+	case request.Prop != nil:
+		for _, requested := range request.Prop.Names {
+			code, prop := http.StatusNotFound, davProp{Name: requested.XMLName}
+			for _, candidate := range props {
+				if candidate.Name == requested.XMLName {
+					code, prop = http.StatusOK, candidate
+					break
+				}
+			}
+			resp.Props[code] = append(resp.Props[code], prop)
+		}
+	default:
+		resp.Props[http.StatusOK] = props
+	}
+	return resp
+}
+
+// properties returns the properties of a resource. The RFC 4331 quota
+// properties of collections are only included if quota is set.
+func (s *Server) properties(info *webdav.FileInfo, quota bool) []davProp {
+	davName := func(local string) xml.Name { return xml.Name{Space: davNamespace, Local: local} }
+
+	resourceType := ""
+	if info.IsDir {
+		resourceType = "<D:collection/>"
+	}
+	props := []davProp{{Name: davName("resourcetype"), Value: resourceType}}
+
+	if !info.ModTime.IsZero() {
+		props = append(props, davProp{Name: davName("getlastmodified"), Value: info.ModTime.UTC().Format(http.TimeFormat)})
+	}
+	if !info.IsDir {
+		props = append(props, davProp{Name: davName("getcontentlength"), Value: strconv.FormatInt(info.Size, 10)})
+		if info.MIMEType != "" {
+			props = append(props, davProp{Name: davName("getcontenttype"), Value: xmlEscape(info.MIMEType)})
+		}
+		if info.ETag != "" {
+			props = append(props, davProp{Name: davName("getetag"), Value: xmlEscape(strconv.Quote(info.ETag))})
+		}
+	}
+
+	props = append(props,
+		davProp{Name: davName("supportedlock"), Value: supportedLock},
+		davProp{Name: davName("lockdiscovery"), Value: lockDiscovery(s.locks.Locks(normalizePath(info.Path)))},
+	)
+
+	if quota && info.IsDir {
+		if usage, err := s.vfsImpl.Quota(normalizePath(info.Path)); err == nil {
+			props = append(props, davProp{Name: davName("quota-used-bytes"), Value: strconv.FormatInt(usage.Used, 10)})
+			if usage.Available >= 0 {
+				props = append(props, davProp{Name: davName("quota-available-bytes"), Value: strconv.FormatInt(usage.Available, 10)})
+			}
+		}
+	}
+
+	if entry, err := s.vfsImpl.Get(normalizePath(info.Path)); err == nil {
+		props = append(props, deadProps(entry.GetMetadata())...)
+	}
+	return props
+}
+
+// parseDepth parses a Depth header, returning -1 for infinity
+func parseDepth(value string, defaultDepth int) (int, error) {
+	switch value {
+	case "":
+		return defaultDepth, nil
+	case "0":
+		return 0, nil
+	case "1":
+		return 1, nil
+	case "infinity":
+		return -1, nil
+	}
+	return 0, &httpError{http.StatusBadRequest, fmt.Errorf("invalid Depth header %q", value)}
+}
@@ -0,0 +1,213 @@
+package vfsdav
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+)
+
+// deadPropPrefix prefixes the VFS attributes holding dead properties. The
+// rest of the attribute name is the property name in Clark notation,
+// e.g. "webdav.{urn:schemas-microsoft-com:}Win32FileAttributes".
+const deadPropPrefix = "webdav."
+
+// liveProps are the properties computed by the server, which PROPPATCH may not change
+var liveProps = map[string]bool{
+	"resourcetype":          true,
+	"getlastmodified":       true,
+	"getcontentlength":      true,
+	"getcontenttype":        true,
+	"getetag":               true,
+	"supportedlock":         true,
+	"lockdiscovery":         true,
+	"quota-used-bytes":      true,
+	"quota-available-bytes": true,
+}
+
+// propertyUpdate is the body of a PROPPATCH request. The set and remove
+// instructions are kept in document order, as they must be applied in order.
+type propertyUpdate struct {
+	XMLName      xml.Name `xml:"DAV: propertyupdate"`
+	Instructions []struct {
+		XMLName xml.Name
+		Prop    struct {
+			Props []propValue `xml:",any"`
+		} `xml:"DAV: prop"`
+	} `xml:",any"`
+}
+
+// propValue is a property element with its content
+type propValue struct {
+	Name  xml.Name
+	Value rawXML
+}
+
+// UnmarshalXML implements xml.Unmarshaler
+func (p *propValue) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	p.Name = start.Name
+	return p.Value.UnmarshalXML(d, start)
+}
+
+// propPatch is a single property change
+type propPatch struct {
+	name   xml.Name
+	value  string
+	remove bool
+}
+
+// servePropPatch stores dead properties in the VFS attributes of a resource.
+// Either all changes are applied or none.
+func (s *Server) servePropPatch(w http.ResponseWriter, r *http.Request) error {
+	name := normalizePath(r.URL.Path)
+
+	if !s.vfsImpl.Exists(name) {
+		return &httpError{http.StatusNotFound, fmt.Errorf("not found: %s", name)}
+	}
+	entry, err := s.vfsImpl.Get(name)
+	if err != nil {
+		return err
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	var update propertyUpdate
+	if err := xml.Unmarshal(body, &update); err != nil {
+		return &httpError{http.StatusBadRequest, fmt.Errorf("invalid PROPPATCH body: %w", err)}
+	}
+
+	var patches []propPatch
+	for _, instruction := range update.Instructions {
+		if instruction.XMLName.Space != davNamespace || (instruction.XMLName.Local != "set" && instruction.XMLName.Local != "remove") {
+			continue
+		}
+		for _, prop := range instruction.Prop.Props {
+			patches = append(patches, propPatch{
+				name:   prop.Name,
+				value:  string(prop.Value),
+				remove: instruction.XMLName.Local == "remove",
+			})
+		}
+	}
+
+	resp := davResponse{Path: name, Props: make(map[int][]davProp)}
+	failed := false
+	for _, patch := range patches {
+		if patch.name.Space == davNamespace && liveProps[patch.name.Local] {
+			resp.Props[http.StatusForbidden] = append(resp.Props[http.StatusForbidden], davProp{Name: patch.name})
+			failed = true
+		}
+	}
+
+	if !failed {
+		if failedPatch, code := s.applyPropPatches(name, entry.GetMetadata(), patches); failedPatch != nil {
+			resp.Props[code] = append(resp.Props[code], davProp{Name: failedPatch.name})
+			failed = true
+		}
+	}
+
+	for _, patch := range patches {
+		if !failed {
+			resp.Props[http.StatusOK] = append(resp.Props[http.StatusOK], davProp{Name: patch.name})
+		} else if !hasProp(resp.Props, patch.name) {
+			resp.Props[http.StatusFailedDependency] = append(resp.Props[http.StatusFailedDependency], davProp{Name: patch.name})
+		}
+	}
+	return writeMultiStatus(w, []davResponse{resp})
+}
+
+// applyPropPatches applies the patches in order. If one fails, the changes
+// made so far are rolled back and the failed patch is returned with the
+// status to report for it.
+func (s *Server) applyPropPatches(name string, metadata *vfs.Metadata, patches []propPatch) (*propPatch, int) {
+	for i := range patches {
+		patch := &patches[i]
+		key := deadPropKey(patch.name)
+
+		var err error
+		if patch.remove {
+			if err = s.vfsImpl.AttrDelete(name, key); errors.Is(err, vfs.ErrNoAttribute) {
+				err = nil
+			}
+		} else {
+			err = s.vfsImpl.AttrSet(name, key, patch.value)
+		}
+		if err == nil {
+			continue
+		}
+
+		// Restore the attributes touched before the failure
+		for _, applied := range patches[:i] {
+			key := deadPropKey(applied.name)
+			if original, ok := metadata.Attribute(key); ok {
+				s.vfsImpl.AttrSet(name, key, original)
+			} else {
+				s.vfsImpl.AttrDelete(name, key)
+			}
+		}
+
+		if errors.Is(err, vfs.ErrNotImplemented) || errors.Is(err, vfs.ErrPermission) {
+			return patch, http.StatusForbidden
+		}
+		return patch, http.StatusInternalServerError
+	}
+	return nil, 0
+}
+
+// deadProps returns the dead properties stored in the attributes of a resource
+func deadProps(metadata *vfs.Metadata) []davProp {
+	var keys []string
+	for key := range metadata.Attributes {
+		if strings.HasPrefix(key, deadPropPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var props []davProp
+	for _, key := range keys {
+		name, ok := parseDeadPropKey(key)
+		if !ok {
+			continue
+		}
+		props = append(props, davProp{Name: name, Value: metadata.Attributes[key]})
+	}
+	return props
+}
+
+// deadPropKey returns the attribute name storing a dead property
+func deadPropKey(name xml.Name) string {
+	return deadPropPrefix + "{" + name.Space + "}" + name.Local
+}
+
+// parseDeadPropKey returns the property name stored in an attribute name
+func parseDeadPropKey(key string) (xml.Name, bool) {
+	clark, ok := strings.CutPrefix(key, deadPropPrefix+"{")
+	if !ok {
+		return xml.Name{}, false
+	}
+	end := strings.LastIndexByte(clark, '}')
+	if end < 0 || end == len(clark)-1 {
+		return xml.Name{}, false
+	}
+	return xml.Name{Space: clark[:end], Local: clark[end+1:]}, true
+}
+
+// hasProp reports whether a property is already listed in props
+func hasProp(props map[int][]davProp, name xml.Name) bool {
+	for _, list := range props {
+		for _, prop := range list {
+			if prop.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
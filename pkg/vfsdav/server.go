@@ -0,0 +1,356 @@
+// Package vfsdav provides a WebDAV server backed by a VFS implementation,
+// built on the go-webdav library
+package vfsdav
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/emersion/go-webdav"
+	"github.com/freeflowuniverse/herolauncher/pkg/system/stats/metrics"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+	"github.com/freeflowuniverse/herolauncher/pkg/webdavserver"
+)
+
+// Server represents a WebDAV server that uses a VFS implementation as its backend
+type Server struct {
+	vfsImpl    vfs.VFSImplementation
+	fs         *FileSystem
+	handler    *webdav.Handler
+	locks      *LockManager
+	options    Options
+	bandwidth  *bucket
+	limiter    *requestLimiter
+	homes      *homes
+	metrics    *metrics.HTTPMetrics
+	addr       string
+	httpServer *http.Server
+}
+
+// TLSOptions configures the certificate used by ListenAndServeTLS
+type TLSOptions struct {
+	// CertFile and KeyFile are the PEM encoded certificate and private key
+	CertFile string
+	KeyFile  string
+
+	// AutoGenerateCerts creates a self-signed certificate when the files are
+	// not given or do not exist yet
+	AutoGenerateCerts bool
+	// CertDir is where generated certificates are stored when no file names are given
+	CertDir          string
+	CertValidityDays int
+	CertOrganization string
+}
+
+// DefaultTLSOptions returns TLS options that auto-generate a self-signed certificate
+func DefaultTLSOptions() TLSOptions {
+	return TLSOptions{
+		AutoGenerateCerts: true,
+		CertDir:           filepath.Join(os.TempDir(), "herolauncher", "certificates"),
+		CertValidityDays:  365,
+		CertOrganization:  "HeroLauncher VFS WebDAV Server",
+	}
+}
+
+// Options configures the optional behaviour of a Server
+type Options struct {
+	// CORS configures cross-origin requests; nil disables CORS headers
+	CORS *CORSOptions
+
+	// ReadOnly rejects every request that would modify the VFS
+	ReadOnly bool
+	// ReadOnlyPaths lists path prefixes that may not be modified, e.g.
+	// "/public" to expose a read-only share next to writable directories
+	ReadOnlyPaths []string
+
+	// BandwidthLimit caps the combined upload and download rate of all
+	// clients in bytes per second; 0 means unlimited
+	BandwidthLimit int64
+	// ConnectionBandwidthLimit caps the transfer rate of each connection in
+	// bytes per second; 0 means unlimited
+	ConnectionBandwidthLimit int64
+	// RequestsPerSecond limits the request rate of each client address,
+	// allowing bursts of RequestBurst requests; 0 means unlimited
+	RequestsPerSecond float64
+	RequestBurst      int
+
+	// Compression compresses GET and PROPFIND responses with brotli or gzip
+	// when the client accepts it; nil disables compression
+	Compression *CompressionOptions
+
+	// AccessLog receives an entry for every request served; nil disables the access log
+	AccessLog AccessLogger
+
+	// Metrics receives request, traffic, error and connection counters; nil
+	// disables metrics. When MetricsPath is set, e.g. to "/metrics", the
+	// registry is served at that path instead of the file of that name.
+	Metrics     *metrics.Registry
+	MetricsPath string
+}
+
+// DefaultOptions returns the options used by NewServer
+func DefaultOptions() Options {
+	cors := DefaultCORSOptions()
+	return Options{
+		CORS: &cors,
+	}
+}
+
+// NewServer creates a new WebDAV server with the given VFS implementation
+func NewServer(vfsImpl vfs.VFSImplementation, addr string) *Server {
+	return NewServerWithOptions(vfsImpl, addr, DefaultOptions())
+}
+
+// NewServerWithOptions creates a new WebDAV server with the given VFS implementation and options
+func NewServerWithOptions(vfsImpl vfs.VFSImplementation, addr string, options Options) *Server {
+	fs := NewFileSystem(vfsImpl)
+	server := &Server{
+		vfsImpl: vfsImpl,
+		fs:      fs,
+		handler: &webdav.Handler{
+			FileSystem: fs,
+		},
+		locks:   NewLockManager(),
+		options: options,
+		addr:    addr,
+	}
+	if options.BandwidthLimit > 0 {
+		server.bandwidth = newBucket(float64(options.BandwidthLimit), float64(options.BandwidthLimit))
+	}
+	if options.RequestsPerSecond > 0 {
+		server.limiter = newRequestLimiter(options.RequestsPerSecond, options.RequestBurst)
+	}
+	if options.Metrics != nil {
+		server.metrics = metrics.NewHTTPMetrics(options.Metrics, "vfsdav")
+	}
+	return server
+}
+
+// Handler returns the HTTP handler for the WebDAV server
+func (s *Server) Handler() http.Handler {
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.options.AccessLog != nil {
+			s.logRequest(w, r, s.serve)
+			return
+		}
+		s.serve(w, r)
+	})
+	if s.metrics == nil {
+		return handler
+	}
+
+	handler = s.metrics.Handler(handler)
+	metricsHandler := s.options.Metrics.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.options.MetricsPath != "" && r.URL.Path == s.options.MetricsPath {
+			metricsHandler.ServeHTTP(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// serve applies rate limits, CORS and authentication before handling a WebDAV request
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if s.limiter != nil && !s.limiter.allow(r) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	// Preflight requests are answered without reaching the WebDAV handler
+	if s.options.CORS != nil && s.options.CORS.handle(w, r) {
+		return
+	}
+
+	// A multi-user server passes the request on to the server of the user's home
+	target := s
+	if s.homes != nil {
+		home, err := s.homes.serverFor(w, r)
+		if err != nil {
+			serveError(w, err)
+			return
+		}
+		target = home
+	}
+
+	w, r = s.throttleRequest(w, r)
+	if s.options.Compression != nil {
+		if cw := s.options.Compression.wrap(w, r); cw != nil {
+			defer cw.Close()
+			w = cw
+		}
+	}
+	if err := target.serveDAV(w, r); err != nil {
+		serveError(w, err)
+	}
+}
+
+// serveDAV handles locking, PROPFIND, PROPPATCH and partial PUT itself and
+// passes everything else on to go-webdav once access and locks have been checked
+func (s *Server) serveDAV(w http.ResponseWriter, r *http.Request) error {
+	name := normalizePath(r.URL.Path)
+
+	targets, err := modifiedPaths(r)
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		if err := s.checkWritable(target.name, target.recursive); err != nil {
+			return err
+		}
+		// Creating or refreshing a lock is checked against the existing locks by serveLock
+		if r.Method == "LOCK" {
+			continue
+		}
+		if err := s.confirmLocks(r, target.name, target.recursive); err != nil {
+			return err
+		}
+	}
+	if len(targets) > 0 {
+		if err := s.checkConditions(r, name); err != nil {
+			return err
+		}
+	}
+
+	switch r.Method {
+	case http.MethodOptions:
+		s.handler.ServeHTTP(&optionsWriter{ResponseWriter: w}, r)
+		return nil
+	case "PROPFIND":
+		return s.servePropfind(w, r)
+	case "PROPPATCH":
+		return s.servePropPatch(w, r)
+	case "LOCK":
+		return s.serveLock(w, r)
+	case "UNLOCK":
+		return s.serveUnlock(w, r)
+	case http.MethodPut:
+		if r.Header.Get("Content-Range") != "" {
+			return s.servePartialPut(w, r)
+		}
+	}
+
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+	s.handler.ServeHTTP(sw, r)
+
+	// Locks do not follow a resource that was moved or deleted
+	if (r.Method == http.MethodDelete || r.Method == "MOVE") && sw.status < 300 {
+		s.locks.Remove(name)
+	}
+	return nil
+}
+
+// optionsWriter advertises WebDAV class 2 in the OPTIONS response of go-webdav
+type optionsWriter struct {
+	http.ResponseWriter
+}
+
+func (w *optionsWriter) WriteHeader(code int) {
+	if w.Header().Get("DAV") != "" {
+		w.Header().Set("DAV", "1, 2, 3")
+	}
+	if allow := w.Header().Get("Allow"); allow != "" {
+		w.Header().Set("Allow", allow+", LOCK, UNLOCK")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// statusWriter records the status code and body size of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.status = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// connState counts the open connections when metrics are enabled
+func (s *Server) connState(conn net.Conn, state http.ConnState) {
+	if s.metrics != nil {
+		s.metrics.ConnState(conn, state)
+	}
+}
+
+// ListenAndServe starts the WebDAV server over plain HTTP
+func (s *Server) ListenAndServe() error {
+	s.httpServer = &http.Server{
+		Addr:        s.addr,
+		Handler:     s.Handler(),
+		ConnContext: s.connContext,
+		ConnState:   s.connState,
+	}
+
+	log.Printf("Starting WebDAV server with HTTP on %s", s.addr)
+	return s.httpServer.ListenAndServe()
+}
+
+// ListenAndServeTLS starts the WebDAV server over HTTPS. Depending on the
+// options, an existing certificate is used or a self-signed one is generated.
+func (s *Server) ListenAndServeTLS(options TLSOptions) error {
+	certFile, keyFile, err := webdavserver.PrepareCertificate(options.certificateOptions())
+	if err != nil {
+		return err
+	}
+
+	s.httpServer = &http.Server{
+		Addr:        s.addr,
+		Handler:     s.Handler(),
+		ConnContext: s.connContext,
+		ConnState:   s.connState,
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+
+	log.Printf("Starting WebDAV server with HTTPS on %s using certificates: %s, %s", s.addr, certFile, keyFile)
+	return s.httpServer.ListenAndServeTLS(certFile, keyFile)
+}
+
+// Shutdown gracefully stops a server started with ListenAndServe or ListenAndServeTLS
+func (s *Server) Shutdown() error {
+	if s.httpServer == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.httpServer.Shutdown(ctx)
+}
+
+// certificateOptions returns the options of the certificate, with the
+// defaults for the certificates directory and organization
+func (options TLSOptions) certificateOptions() webdavserver.CertificateOptions {
+	defaults := DefaultTLSOptions()
+	certificate := webdavserver.CertificateOptions{
+		CertFile:     options.CertFile,
+		KeyFile:      options.KeyFile,
+		AutoGenerate: options.AutoGenerateCerts,
+		CertDir:      options.CertDir,
+		Name:         "vfsdav",
+		ValidityDays: options.CertValidityDays,
+		Organization: options.CertOrganization,
+	}
+	if certificate.CertDir == "" {
+		certificate.CertDir = defaults.CertDir
+	}
+	if certificate.Organization == "" {
+		certificate.Organization = defaults.CertOrganization
+	}
+	return certificate
+}
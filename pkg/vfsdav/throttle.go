@@ -0,0 +1,226 @@
+package vfsdav
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// throttleChunkSize is the largest amount of data transferred between two
+// bandwidth checks, which keeps throttled transfers smooth
+const throttleChunkSize = 32 * 1024
+
+// rateLimiterIdleTimeout is how long the request limiter of an idle client is kept
+const rateLimiterIdleTimeout = 5 * time.Minute
+
+// bucket is a token bucket refilled at a fixed rate
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newBucket creates a full bucket
+func newBucket(rate, burst float64) *bucket {
+	return &bucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// refill adds the tokens accumulated since the last call; the caller must hold b.mu
+func (b *bucket) refill() {
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// reserve takes n tokens and returns how long to wait until they are available
+func (b *bucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// allow takes a single token if one is available
+func (b *bucket) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// idleSince returns when the bucket was last used
+func (b *bucket) idleSince() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.last
+}
+
+// throttle limits the bandwidth of a single transfer to all of its buckets
+type throttle struct {
+	ctx     context.Context
+	buckets []*bucket
+}
+
+// wait blocks until n bytes may be transferred or the request is cancelled
+func (t *throttle) wait(n int) error {
+	var delay time.Duration
+	for _, b := range t.buckets {
+		delay = max(delay, b.reserve(n))
+	}
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-t.ctx.Done():
+		return t.ctx.Err()
+	}
+}
+
+// throttledReader limits the bandwidth of a request body
+type throttledReader struct {
+	io.ReadCloser
+	throttle *throttle
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if werr := r.throttle.wait(n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// throttledWriter limits the bandwidth of a response body
+type throttledWriter struct {
+	http.ResponseWriter
+	throttle *throttle
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), throttleChunkSize)]
+		if err := w.throttle.wait(len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// connBucketKey is the context key of the per-connection bandwidth bucket
+type connBucketKey struct{}
+
+// connContext attaches a bandwidth bucket to each connection accepted by the server
+func (s *Server) connContext(ctx context.Context, conn net.Conn) context.Context {
+	if s.options.ConnectionBandwidthLimit <= 0 {
+		return ctx
+	}
+	limit := float64(s.options.ConnectionBandwidthLimit)
+	return context.WithValue(ctx, connBucketKey{}, newBucket(limit, limit))
+}
+
+// throttleRequest wraps the request body and response writer with the
+// configured bandwidth limits. Without a connection bucket, which is the case
+// when Handler is mounted in another HTTP server, the connection limit is
+// applied per request.
+func (s *Server) throttleRequest(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request) {
+	var buckets []*bucket
+	if s.bandwidth != nil {
+		buckets = append(buckets, s.bandwidth)
+	}
+	if limit := s.options.ConnectionBandwidthLimit; limit > 0 {
+		connBucket, ok := r.Context().Value(connBucketKey{}).(*bucket)
+		if !ok {
+			connBucket = newBucket(float64(limit), float64(limit))
+		}
+		buckets = append(buckets, connBucket)
+	}
+	if len(buckets) == 0 {
+		return w, r
+	}
+
+	t := &throttle{ctx: r.Context(), buckets: buckets}
+	if r.Body != nil {
+		r.Body = &throttledReader{ReadCloser: r.Body, throttle: t}
+	}
+	return &throttledWriter{ResponseWriter: w, throttle: t}, r
+}
+
+// requestLimiter limits the request rate of each client address
+type requestLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	clients   map[string]*bucket
+	lastPrune time.Time
+}
+
+// newRequestLimiter creates a limiter allowing rate requests per second with the given burst
+func newRequestLimiter(rate float64, burst int) *requestLimiter {
+	if burst < 1 {
+		burst = max(1, int(rate))
+	}
+	return &requestLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		clients:   make(map[string]*bucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// allow reports whether a request from r's client may be served
+func (l *requestLimiter) allow(r *http.Request) bool {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+
+	l.mu.Lock()
+	if time.Since(l.lastPrune) > rateLimiterIdleTimeout {
+		for addr, b := range l.clients {
+			if time.Since(b.idleSince()) > rateLimiterIdleTimeout {
+				delete(l.clients, addr)
+			}
+		}
+		l.lastPrune = time.Now()
+	}
+	b, ok := l.clients[client]
+	if !ok {
+		b = newBucket(l.rate, l.burst)
+		l.clients[client] = b
+	}
+	l.mu.Unlock()
+
+	return b.allow()
+}
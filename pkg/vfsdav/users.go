@@ -0,0 +1,176 @@
+package vfsdav
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfsnested"
+)
+
+// HomePrefix is the directory of the nested VFS holding the user homes
+const HomePrefix = "/home"
+
+// User is a WebDAV account of a multi-user server
+type User struct {
+	Name     string
+	Password string
+}
+
+// UserStore holds the users allowed to log in to a multi-user server
+type UserStore struct {
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+// NewUserStore creates an empty user store
+func NewUserStore() *UserStore {
+	return &UserStore{
+		users: make(map[string]*User),
+	}
+}
+
+// Add adds or replaces a user. The name is used as the home directory name,
+// so it may not contain a slash.
+func (s *UserStore) Add(user User) error {
+	if user.Name == "" || user.Name == "." || user.Name == ".." || strings.Contains(user.Name, "/") {
+		return fmt.Errorf("invalid user name %q", user.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.Name] = &user
+	return nil
+}
+
+// Remove deletes a user
+func (s *UserStore) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, name)
+}
+
+// Authenticate returns the user if the name and password match
+func (s *UserStore) Authenticate(name, password string) (*User, bool) {
+	s.mu.RLock()
+	user, ok := s.users[name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+		return nil, false
+	}
+	return user, true
+}
+
+// ParseUsers parses a comma separated list of users in the form
+// name:password, e.g. "alice:secret,bob:hunter2"
+func ParseUsers(spec string) ([]User, error) {
+	var users []User
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		name, password, ok := strings.Cut(item, ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid user definition %q, expected name:password", item)
+		}
+		users = append(users, User{Name: name, Password: password})
+	}
+
+	return users, nil
+}
+
+// HomeFunc creates the VFS backing the home directory of a user. It is called
+// on the first login of a user whose home is not mounted yet.
+type HomeFunc func(user string) (vfs.VFSImplementation, error)
+
+// homes serves every user from their own home directory
+type homes struct {
+	mu      sync.Mutex
+	nested  *vfsnested.NestedVFS
+	users   *UserStore
+	newHome HomeFunc
+	addr    string
+	options Options
+	servers map[string]*Server
+}
+
+// NewMultiUserServer creates a WebDAV server requiring HTTP Basic
+// authentication, where each user only sees their home directory. Homes are
+// the VFS implementations mounted at /home/<user> of nested, so users can be
+// mapped to different backends. A user without a mount gets one created by
+// newHome on first login; newHome may be nil when all homes are mounted upfront.
+func NewMultiUserServer(nested *vfsnested.NestedVFS, users *UserStore, newHome HomeFunc, addr string, options Options) *Server {
+	server := NewServerWithOptions(nested, addr, options)
+
+	// Rate limits, CORS, throttling, compression, the access log and metrics
+	// are applied once by the multi-user server, not again by each home
+	homeOptions := options
+	homeOptions.CORS = nil
+	homeOptions.BandwidthLimit = 0
+	homeOptions.ConnectionBandwidthLimit = 0
+	homeOptions.RequestsPerSecond = 0
+	homeOptions.Compression = nil
+	homeOptions.AccessLog = nil
+	homeOptions.Metrics = nil
+
+	server.homes = &homes{
+		nested:  nested,
+		users:   users,
+		newHome: newHome,
+		addr:    addr,
+		options: homeOptions,
+		servers: make(map[string]*Server),
+	}
+	return server
+}
+
+// serverFor authenticates a request and returns the server of the user's home
+func (h *homes) serverFor(w http.ResponseWriter, r *http.Request) (*Server, error) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="WebDAV Server"`)
+		return nil, &httpError{http.StatusUnauthorized, fmt.Errorf("authentication required")}
+	}
+	user, ok := h.users.Authenticate(name, password)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="WebDAV Server"`)
+		return nil, &httpError{http.StatusUnauthorized, fmt.Errorf("invalid credentials")}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if server, ok := h.servers[user.Name]; ok {
+		return server, nil
+	}
+
+	homeDir := path.Join(HomePrefix, user.Name)
+	impl, ok := h.nested.GetVFS(homeDir)
+	if !ok {
+		if h.newHome == nil {
+			return nil, &httpError{http.StatusForbidden, fmt.Errorf("no home directory for user %s", user.Name)}
+		}
+		var err error
+		if impl, err = h.newHome(user.Name); err != nil {
+			return nil, fmt.Errorf("failed to create home directory for user %s: %w", user.Name, err)
+		}
+		if err := h.nested.AddVFS(homeDir, impl); err != nil {
+			return nil, err
+		}
+	}
+
+	server := NewServerWithOptions(impl, h.addr, h.options)
+	h.servers[user.Name] = server
+	return server, nil
+}
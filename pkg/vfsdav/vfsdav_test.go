@@ -0,0 +1,729 @@
+package vfsdav
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/emersion/go-webdav"
+	"github.com/freeflowuniverse/herolauncher/pkg/system/stats/metrics"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfsdb"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfslocal"
+	"github.com/freeflowuniverse/herolauncher/pkg/vfs/vfsnested"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupTestServer creates a vfsdav server on a temporary local directory
+func setupTestServer(t *testing.T) (*Server, string) {
+	tempDir, err := os.MkdirTemp("", "vfsdav-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(tempDir) })
+
+	vfsImpl, err := vfslocal.New(tempDir)
+	require.NoError(t, err)
+
+	return NewServer(vfsImpl, "127.0.0.1:0"), tempDir
+}
+
+func TestVFSDavBasicOperations(t *testing.T) {
+	server, tempDir := setupTestServer(t)
+	httpServer := httptest.NewServer(server.Handler())
+	defer httpServer.Close()
+
+	client, err := webdav.NewClient(http.DefaultClient, httpServer.URL)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// Create a collection and a file inside it
+	require.NoError(t, client.Mkdir(ctx, "/docs"))
+	writer, err := client.Create(ctx, "/docs/hello.txt")
+	require.NoError(t, err)
+	_, err = writer.Write([]byte("Hello, WebDAV!"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "docs", "hello.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "Hello, WebDAV!", string(data))
+
+	// Read it back
+	reader, err := client.Open(ctx, "/docs/hello.txt")
+	require.NoError(t, err)
+	content, err := io.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "Hello, WebDAV!", string(content))
+
+	// List the collection
+	infos, err := client.ReadDir(ctx, "/docs", false)
+	require.NoError(t, err)
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Path)
+	}
+	assert.Contains(t, names, "/docs/hello.txt")
+
+	// Move, then delete
+	require.NoError(t, client.Move(ctx, "/docs/hello.txt", "/docs/moved.txt", nil))
+	_, err = client.Stat(ctx, "/docs/hello.txt")
+	assert.Error(t, err)
+	require.NoError(t, client.RemoveAll(ctx, "/docs/moved.txt"))
+	_, err = os.Stat(filepath.Join(tempDir, "docs", "moved.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Creating a file in a missing collection is a conflict
+	writer, err = client.Create(ctx, "/missing/file.txt")
+	require.NoError(t, err)
+	writer.Write([]byte("x"))
+	assert.Error(t, writer.Close())
+}
+
+func TestVFSDavTLS(t *testing.T) {
+	_, tempDir := setupTestServer(t)
+
+	// Reserve a free port for the server
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	vfsImpl, err := vfslocal.New(tempDir)
+	require.NoError(t, err)
+	server := NewServer(vfsImpl, addr)
+	defer server.Shutdown()
+
+	options := DefaultTLSOptions()
+	options.CertDir = filepath.Join(tempDir, "certs")
+	go server.ListenAndServeTLS(options)
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	}
+
+	// Wait for the certificate to be generated and the server to start
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		req, _ := http.NewRequest("PROPFIND", "https://"+addr+"/", strings.NewReader(""))
+		req.Header.Set("Depth", "0")
+		if resp, err = httpClient.Do(req); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+
+	assert.FileExists(t, filepath.Join(tempDir, "certs", "vfsdav.crt"))
+	assert.FileExists(t, filepath.Join(tempDir, "certs", "vfsdav.key"))
+}
+
+func TestListenAndServeTLSRequiresFiles(t *testing.T) {
+	server, _ := setupTestServer(t)
+	err := server.ListenAndServeTLS(TLSOptions{CertFile: "/nonexistent.crt", KeyFile: "/nonexistent.key"})
+	assert.ErrorContains(t, err, "auto-generation is disabled")
+}
+
+func TestVFSDavLocking(t *testing.T) {
+	server, tempDir := setupTestServer(t)
+	httpServer := httptest.NewServer(server.Handler())
+	defer httpServer.Close()
+
+	do := func(method, name, body string, headers map[string]string) (*http.Response, string) {
+		req, err := http.NewRequest(method, httpServer.URL+name, strings.NewReader(body))
+		require.NoError(t, err)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(data)
+	}
+
+	// The server advertises class 2
+	resp, _ := do(http.MethodOptions, "/", "", nil)
+	assert.Contains(t, resp.Header.Get("DAV"), "2")
+	assert.Contains(t, resp.Header.Get("Allow"), "LOCK")
+
+	// Locking an unmapped URL creates an empty file
+	lockBody := `<?xml version="1.0"?><a:lockinfo xmlns:a="DAV:"><a:lockscope><a:exclusive/></a:lockscope>` +
+		`<a:locktype><a:write/></a:locktype><a:owner><a:href>mailto:office@example.com</a:href></a:owner></a:lockinfo>`
+	resp, body := do("LOCK", "/report.docx", lockBody, map[string]string{"Timeout": "Second-600"})
+	require.Equal(t, http.StatusCreated, resp.StatusCode, body)
+	assert.FileExists(t, filepath.Join(tempDir, "report.docx"))
+	lockToken := resp.Header.Get("Lock-Token")
+	require.True(t, strings.HasPrefix(lockToken, "<opaquelocktoken:"), lockToken)
+	assert.Contains(t, body, "<D:timeout>Second-600</D:timeout>")
+	assert.Contains(t, body, `<href xmlns="DAV:">mailto:office@example.com</href>`)
+
+	// A second exclusive lock conflicts
+	resp, _ = do("LOCK", "/report.docx", lockBody, nil)
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+
+	// Writes require the lock token
+	resp, _ = do(http.MethodPut, "/report.docx", "draft", nil)
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+	resp, _ = do(http.MethodDelete, "/report.docx", "", nil)
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+	resp, _ = do(http.MethodPut, "/report.docx", "draft", map[string]string{"If": "(" + lockToken + ")"})
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// The lock is reported by PROPFIND
+	resp, body = do("PROPFIND", "/report.docx", "", map[string]string{"Depth": "0"})
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(t, body, strings.Trim(lockToken, "<>"))
+	assert.Contains(t, body, "<D:supportedlock>")
+
+	// Refresh, then unlock
+	resp, body = do("LOCK", "/report.docx", "", map[string]string{"If": "(" + lockToken + ")", "Timeout": "Second-1200"})
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, body, "<D:timeout>Second-1200</D:timeout>")
+	resp, _ = do("UNLOCK", "/report.docx", "", map[string]string{"Lock-Token": "<opaquelocktoken:unknown>"})
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	resp, _ = do("UNLOCK", "/report.docx", "", map[string]string{"Lock-Token": lockToken})
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	resp, _ = do(http.MethodPut, "/report.docx", "final", nil)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// An infinite lock on a collection protects new members
+	require.NoError(t, os.Mkdir(filepath.Join(tempDir, "shared"), 0755))
+	resp, _ = do("LOCK", "/shared", lockBody, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _ = do(http.MethodPut, "/shared/new.txt", "x", nil)
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+	resp, _ = do("MOVE", "/report.docx", "", map[string]string{"Destination": httpServer.URL + "/shared/report.docx"})
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+}
+
+func TestIfTokens(t *testing.T) {
+	tokens := ifTokens(`<http://localhost/file> (<opaquelocktoken:a> ["etag<x>"]) (Not <opaquelocktoken:b>)`)
+	assert.Equal(t, []string{"opaquelocktoken:a", "opaquelocktoken:b"}, tokens)
+	assert.Empty(t, ifTokens(""))
+}
+
+func TestVFSDavDeadProperties(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "vfsdav-props-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	vfsImpl, err := vfsdb.NewFromPath(tempDir)
+	require.NoError(t, err)
+	defer vfsImpl.Destroy()
+	_, err = vfsImpl.FileCreate("/notes.txt")
+	require.NoError(t, err)
+
+	httpServer := httptest.NewServer(NewServer(vfsImpl, "127.0.0.1:0").Handler())
+	defer httpServer.Close()
+
+	do := func(method, body string) (int, string) {
+		req, err := http.NewRequest(method, httpServer.URL+"/notes.txt", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/xml")
+		req.Header.Set("Depth", "0")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(data)
+	}
+
+	// Set two properties and remove one that does not exist
+	code, body := do("PROPPATCH", `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:example:">`+
+		`<D:set><D:prop><Z:author>Ann &amp; Bob</Z:author><Z:tags><Z:tag>draft</Z:tag></Z:tags></D:prop></D:set>`+
+		`<D:remove><D:prop><Z:missing/></D:prop></D:remove></D:propertyupdate>`)
+	require.Equal(t, http.StatusMultiStatus, code)
+	assert.Contains(t, body, "HTTP/1.1 200 OK")
+	assert.NotContains(t, body, "424")
+
+	// The properties are stored in the VFS and returned by PROPFIND
+	entry, err := vfsImpl.Get("/notes.txt")
+	require.NoError(t, err)
+	value, ok := entry.GetMetadata().Attribute("webdav.{urn:example:}author")
+	assert.True(t, ok)
+	assert.Equal(t, "Ann &amp; Bob", value)
+
+	_, body = do("PROPFIND", `<D:propfind xmlns:D="DAV:"><D:prop><author xmlns="urn:example:"/><D:getetag/><other xmlns="urn:example:"/></D:prop></D:propfind>`)
+	assert.Contains(t, body, `<author xmlns="urn:example:">Ann &amp; Bob</author>`)
+	assert.Contains(t, body, `<other xmlns="urn:example:"/></D:prop><D:status>HTTP/1.1 404 Not Found`)
+	_, body = do("PROPFIND", "")
+	assert.Contains(t, body, `<tags xmlns="urn:example:"><tag xmlns="urn:example:">draft</tag></tags>`)
+
+	// Live properties are protected and the whole update fails
+	code, body = do("PROPPATCH", `<D:propertyupdate xmlns:D="DAV:"><D:set><D:prop><D:getetag>x</D:getetag>`+
+		`<Z:color xmlns:Z="urn:example:">red</Z:color></D:prop></D:set></D:propertyupdate>`)
+	require.Equal(t, http.StatusMultiStatus, code)
+	assert.Contains(t, body, "HTTP/1.1 403 Forbidden")
+	assert.Contains(t, body, "HTTP/1.1 424 Failed Dependency")
+	entry, err = vfsImpl.Get("/notes.txt")
+	require.NoError(t, err)
+	_, ok = entry.GetMetadata().Attribute("webdav.{urn:example:}color")
+	assert.False(t, ok)
+
+	// Remove a property
+	code, _ = do("PROPPATCH", `<D:propertyupdate xmlns:D="DAV:"><D:remove><D:prop><author xmlns="urn:example:"/></D:prop></D:remove></D:propertyupdate>`)
+	require.Equal(t, http.StatusMultiStatus, code)
+	_, body = do("PROPFIND", "")
+	assert.NotContains(t, body, "author")
+}
+
+func TestVFSDavCORS(t *testing.T) {
+	_, tempDir := setupTestServer(t)
+	vfsImpl, err := vfslocal.New(tempDir)
+	require.NoError(t, err)
+
+	options := DefaultOptions()
+	options.CORS.AllowedOrigins = []string{"https://files.example.com", "https://*.example.org"}
+	options.CORS.AllowCredentials = true
+	handler := NewServerWithOptions(vfsImpl, "127.0.0.1:0", options).Handler()
+
+	request := func(method, origin string, preflight bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if preflight {
+			req.Header.Set("Access-Control-Request-Method", "PROPFIND")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Preflight from an allowed origin
+	rec := request(http.MethodOptions, "https://files.example.com", true)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "https://files.example.com", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Contains(t, rec.Header().Get("Access-Control-Allow-Methods"), "PROPFIND")
+	assert.Equal(t, "86400", rec.Header().Get("Access-Control-Max-Age"))
+	assert.Empty(t, rec.Header().Get("DAV"))
+
+	// Wildcard subdomains
+	rec = request("PROPFIND", "https://app.example.org", false)
+	assert.Equal(t, http.StatusMultiStatus, rec.Code)
+	assert.Equal(t, "https://app.example.org", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, rec.Header().Get("Access-Control-Expose-Headers"), "Lock-Token")
+
+	// Other origins get no CORS headers and failing preflights
+	rec = request(http.MethodOptions, "https://evil.example.net", true)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+	rec = request("PROPFIND", "https://evilexample.org", false)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+
+	// Plain WebDAV OPTIONS requests are not preflights
+	rec = request(http.MethodOptions, "", false)
+	assert.Contains(t, rec.Header().Get("DAV"), "2")
+
+	// CORS can be disabled
+	handler = NewServerWithOptions(vfsImpl, "127.0.0.1:0", Options{}).Handler()
+	rec = request("PROPFIND", "https://files.example.com", false)
+	assert.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestVFSDavReadOnly(t *testing.T) {
+	_, tempDir := setupTestServer(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(tempDir, "public", "docs"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "public", "docs", "manual.txt"), []byte("manual"), 0644))
+	require.NoError(t, os.Mkdir(filepath.Join(tempDir, "upload"), 0755))
+
+	vfsImpl, err := vfslocal.New(tempDir)
+	require.NoError(t, err)
+
+	do := func(handler http.Handler, method, name string, headers map[string]string) int {
+		body := ""
+		if method == http.MethodPut {
+			body = "data"
+		}
+		req := httptest.NewRequest(method, name, strings.NewReader(body))
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	options := DefaultOptions()
+	options.ReadOnlyPaths = []string{"/public/docs"}
+	handler := NewServerWithOptions(vfsImpl, "127.0.0.1:0", options).Handler()
+
+	// Reads are allowed everywhere
+	assert.Equal(t, http.StatusOK, do(handler, http.MethodGet, "/public/docs/manual.txt", nil))
+	assert.Equal(t, http.StatusMultiStatus, do(handler, "PROPFIND", "/public/docs", map[string]string{"Depth": "1"}))
+
+	// Writes below the read-only prefix are rejected
+	assert.Equal(t, http.StatusForbidden, do(handler, http.MethodPut, "/public/docs/manual.txt", nil))
+	assert.Equal(t, http.StatusForbidden, do(handler, http.MethodDelete, "/public/docs/manual.txt", nil))
+	assert.Equal(t, http.StatusForbidden, do(handler, "MKCOL", "/public/docs/new", nil))
+	assert.Equal(t, http.StatusForbidden, do(handler, "COPY", "/upload", map[string]string{"Destination": "/public/docs/upload"}))
+	assert.Equal(t, http.StatusForbidden, do(handler, "MOVE", "/public/docs/manual.txt", map[string]string{"Destination": "/upload/manual.txt"}))
+
+	// Deleting a parent would remove the read-only share too
+	assert.Equal(t, http.StatusForbidden, do(handler, http.MethodDelete, "/public", nil))
+
+	// Other paths stay writable
+	assert.Equal(t, http.StatusCreated, do(handler, http.MethodPut, "/upload/file.txt", nil))
+	assert.Equal(t, http.StatusCreated, do(handler, "COPY", "/public/docs/manual.txt", map[string]string{"Destination": "/upload/manual.txt"}))
+
+	// A read-only server rejects every write
+	handler = NewServerWithOptions(vfsImpl, "127.0.0.1:0", Options{ReadOnly: true}).Handler()
+	assert.Equal(t, http.StatusForbidden, do(handler, http.MethodPut, "/upload/file.txt", nil))
+	assert.Equal(t, http.StatusForbidden, do(handler, "LOCK", "/upload/file.txt", nil))
+	assert.Equal(t, http.StatusOK, do(handler, http.MethodGet, "/upload/file.txt", nil))
+}
+
+func TestVFSDavRateLimits(t *testing.T) {
+	_, tempDir := setupTestServer(t)
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "large.bin"), make([]byte, 150*1024), 0644))
+	vfsImpl, err := vfslocal.New(tempDir)
+	require.NoError(t, err)
+
+	// Requests above the rate of a client are rejected
+	handler := NewServerWithOptions(vfsImpl, "127.0.0.1:0", Options{RequestsPerSecond: 1, RequestBurst: 2}).Handler()
+	codes := make([]int, 3)
+	for i := range codes {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/large.bin", nil))
+		codes[i] = rec.Code
+	}
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, codes)
+
+	// Downloads are throttled to the bandwidth limit: the first 100KB pass
+	// as a burst, the remaining 50KB take about half a second
+	httpServer := httptest.NewServer(NewServerWithOptions(vfsImpl, "127.0.0.1:0", Options{ConnectionBandwidthLimit: 100 * 1024}).Handler())
+	defer httpServer.Close()
+
+	start := time.Now()
+	resp, err := http.Get(httpServer.URL + "/large.bin")
+	require.NoError(t, err)
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Len(t, data, 150*1024)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+}
+
+func TestVFSDavAccessLog(t *testing.T) {
+	_, tempDir := setupTestServer(t)
+	vfsImpl, err := vfslocal.New(tempDir)
+	require.NoError(t, err)
+
+	var buf strings.Builder
+	handler := NewServerWithOptions(vfsImpl, "127.0.0.1:0", Options{AccessLog: NewJSONAccessLogger(&buf)}).Handler()
+
+	req := httptest.NewRequest(http.MethodPut, "/hello.txt", strings.NewReader("hello"))
+	req.Header.Set("User-Agent", "test-agent")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello.txt", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing.txt", nil))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 3)
+
+	var entries []map[string]interface{}
+	for _, line := range lines {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		entries = append(entries, entry)
+	}
+	assert.Equal(t, "PUT", entries[0]["method"])
+	assert.Equal(t, "/hello.txt", entries[0]["path"])
+	assert.Equal(t, float64(http.StatusCreated), entries[0]["status"])
+	assert.Equal(t, "test-agent", entries[0]["user_agent"])
+	assert.Equal(t, float64(http.StatusOK), entries[1]["status"])
+	assert.Equal(t, float64(5), entries[1]["bytes"])
+	assert.Equal(t, float64(http.StatusNotFound), entries[2]["status"])
+	assert.Contains(t, entries[2], "duration_ms")
+	assert.NotEmpty(t, entries[2]["remote_addr"])
+}
+
+func TestVFSDavQuota(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "vfsdav-quota-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	vfsImpl, err := vfsdb.NewFromPath(tempDir)
+	require.NoError(t, err)
+	defer vfsImpl.Destroy()
+	_, err = vfsImpl.FileCreate("/data.bin")
+	require.NoError(t, err)
+	require.NoError(t, vfsImpl.FileWrite("/data.bin", make([]byte, 1234)))
+
+	handler := NewServer(vfsImpl, "127.0.0.1:0").Handler()
+	propfind := func(body string) string {
+		req := httptest.NewRequest("PROPFIND", "/", strings.NewReader(body))
+		req.Header.Set("Depth", "0")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusMultiStatus, rec.Code)
+		return rec.Body.String()
+	}
+
+	body := propfind(`<D:propfind xmlns:D="DAV:"><D:prop><D:quota-used-bytes/><D:quota-available-bytes/></D:prop></D:propfind>`)
+	assert.Contains(t, body, "<D:quota-used-bytes>1234</D:quota-used-bytes>")
+	// vfsdb does not know the available space
+	assert.Contains(t, body, "<D:quota-available-bytes/></D:prop><D:status>HTTP/1.1 404 Not Found")
+
+	// Quota is not part of allprop
+	assert.NotContains(t, propfind(""), "quota-used-bytes")
+}
+
+func TestVFSDavRangeAndConditionals(t *testing.T) {
+	_, tempDir := setupTestServer(t)
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "data.txt"), []byte("0123456789"), 0644))
+	vfsImpl, err := vfslocal.New(tempDir)
+	require.NoError(t, err)
+	handler := NewServer(vfsImpl, "127.0.0.1:0").Handler()
+
+	do := func(method, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/data.txt", strings.NewReader(body))
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Partial content
+	rec := do(http.MethodGet, "", map[string]string{"Range": "bytes=2-5"})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "2345", rec.Body.String())
+	assert.Equal(t, "bytes 2-5/10", rec.Header().Get("Content-Range"))
+
+	etag := do(http.MethodHead, "", nil).Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	// Conditional reads
+	assert.Equal(t, http.StatusNotModified, do(http.MethodGet, "", map[string]string{"If-None-Match": etag}).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "", map[string]string{"If-None-Match": `"other"`}).Code)
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	assert.Equal(t, http.StatusNotModified, do(http.MethodGet, "", map[string]string{"If-Modified-Since": future}).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, do(http.MethodGet, "", map[string]string{"If-Match": `"other"`}).Code)
+
+	// Conditional writes
+	assert.Equal(t, http.StatusPreconditionFailed, do(http.MethodPut, "new", map[string]string{"If-Match": `"other"`}).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, do(http.MethodPut, "new", map[string]string{"If-None-Match": "*"}).Code)
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	assert.Equal(t, http.StatusPreconditionFailed, do(http.MethodDelete, "", map[string]string{"If-Unmodified-Since": past}).Code)
+	assert.Equal(t, http.StatusNoContent, do(http.MethodPut, "new", map[string]string{"If-Match": etag}).Code)
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "data.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+}
+
+func TestVFSDavMultiUser(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "vfsdav-homes-")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(tempDir) })
+
+	users := NewUserStore()
+	require.NoError(t, users.Add(User{Name: "alice", Password: "secret"}))
+	require.NoError(t, users.Add(User{Name: "bob", Password: "hunter2"}))
+	assert.Error(t, users.Add(User{Name: "../root", Password: "x"}))
+
+	// Bob's home lives in a database, alice gets a local directory on first login
+	bobHome, err := vfsdb.NewFromPath(filepath.Join(tempDir, "bob.db"))
+	require.NoError(t, err)
+	nested := vfsnested.New()
+	require.NoError(t, nested.AddVFS("/home/bob", bobHome))
+
+	var created []string
+	newHome := func(user string) (vfs.VFSImplementation, error) {
+		created = append(created, user)
+		dir := filepath.Join(tempDir, "home", user)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		return vfslocal.New(dir)
+	}
+	handler := NewMultiUserServer(nested, users, newHome, "127.0.0.1:0", DefaultOptions()).Handler()
+
+	do := func(user, password, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Authentication is required
+	rec := do("", "", http.MethodGet, "/", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Header().Get("WWW-Authenticate"), "Basic")
+	assert.Equal(t, http.StatusUnauthorized, do("alice", "wrong", http.MethodGet, "/", "").Code)
+
+	// Each user writes to their own home
+	assert.Equal(t, http.StatusCreated, do("alice", "secret", http.MethodPut, "/notes.txt", "alice's notes").Code)
+	assert.Equal(t, http.StatusCreated, do("bob", "hunter2", http.MethodPut, "/notes.txt", "bob's notes").Code)
+	assert.Equal(t, []string{"alice"}, created)
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "home", "alice", "notes.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "alice's notes", string(data))
+	data, err = bobHome.FileRead("/notes.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "bob's notes", string(data))
+
+	rec = do("alice", "secret", http.MethodGet, "/notes.txt", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "alice's notes", rec.Body.String())
+
+	// The home is created only once
+	do("alice", "secret", http.MethodGet, "/notes.txt", "")
+	assert.Equal(t, []string{"alice"}, created)
+}
+
+func TestVFSDavMetrics(t *testing.T) {
+	_, tempDir := setupTestServer(t)
+	vfsImpl, err := vfslocal.New(tempDir)
+	require.NoError(t, err)
+
+	registry := metrics.NewRegistry()
+	options := DefaultOptions()
+	options.Metrics = registry
+	options.MetricsPath = "/metrics"
+	handler := NewServerWithOptions(vfsImpl, "127.0.0.1:0", options).Handler()
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusCreated, do(http.MethodPut, "/file.txt", "hello").Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/file.txt", "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/missing.txt", "").Code)
+
+	rec := do(http.MethodGet, "/metrics", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, `vfsdav_requests_total{method="GET"} 2`)
+	assert.Contains(t, body, `vfsdav_requests_total{method="PUT"} 1`)
+	assert.Contains(t, body, `vfsdav_errors_total{method="GET",status="404"} 1`)
+	assert.Contains(t, body, "vfsdav_received_bytes_total 5")
+	assert.Contains(t, body, "# TYPE vfsdav_active_connections gauge")
+}
+
+func TestVFSDavCompression(t *testing.T) {
+	_, tempDir := setupTestServer(t)
+	text := strings.Repeat("compressible text ", 500)
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "large.txt"), []byte(text), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "small.txt"), []byte("tiny"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tempDir, "photo.jpg"), []byte(text), 0644))
+	vfsImpl, err := vfslocal.New(tempDir)
+	require.NoError(t, err)
+
+	options := DefaultOptions()
+	compression := DefaultCompressionOptions()
+	options.Compression = &compression
+	handler := NewServerWithOptions(vfsImpl, "127.0.0.1:0", options).Handler()
+
+	do := func(method, target, encoding string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Accept-Encoding", encoding)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// gzip
+	rec := do(http.MethodGet, "/large.txt", "gzip, deflate", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	assert.Contains(t, rec.Header().Get("Vary"), "Accept-Encoding")
+	assert.True(t, strings.HasPrefix(rec.Header().Get("ETag"), `W/"`))
+	assert.Less(t, rec.Body.Len(), len(text))
+	gz, err := gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	data, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Equal(t, text, string(data))
+
+	// brotli is preferred
+	rec = do(http.MethodGet, "/large.txt", "gzip, br", nil)
+	assert.Equal(t, "br", rec.Header().Get("Content-Encoding"))
+	data, err = io.ReadAll(brotli.NewReader(rec.Body))
+	require.NoError(t, err)
+	assert.Equal(t, text, string(data))
+
+	// Small files, excluded types, ranges and clients without support are sent as is
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"small":    do(http.MethodGet, "/small.txt", "gzip", nil),
+		"jpeg":     do(http.MethodGet, "/photo.jpg", "gzip", nil),
+		"range":    do(http.MethodGet, "/large.txt", "gzip", map[string]string{"Range": "bytes=0-9"}),
+		"identity": do(http.MethodGet, "/large.txt", "identity", nil),
+		"rejected": do(http.MethodGet, "/large.txt", "gzip;q=0", nil),
+	} {
+		assert.Empty(t, rec.Header().Get("Content-Encoding"), name)
+	}
+
+	// PROPFIND listings
+	for i := 0; i < 50; i++ {
+		require.NoError(t, os.WriteFile(filepath.Join(tempDir, fmt.Sprintf("file-%02d.txt", i)), []byte("x"), 0644))
+	}
+	rec = do("PROPFIND", "/", "gzip", map[string]string{"Depth": "1"})
+	assert.Equal(t, http.StatusMultiStatus, rec.Code)
+	assert.Equal(t, "gzip", rec.Header().Get("Content-Encoding"))
+	gz, err = gzip.NewReader(rec.Body)
+	require.NoError(t, err)
+	data, err = io.ReadAll(gz)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "file-49.txt")
+}
+
+func TestVFSDavPartialPut(t *testing.T) {
+	server, tempDir := setupTestServer(t)
+	handler := server.Handler()
+
+	put := func(contentRange, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/upload.bin", strings.NewReader(body))
+		req.Header.Set("Content-Range", contentRange)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusCreated, put("bytes 0-4/10", "01234").Code)
+	// Chunks must continue at the end of the file
+	rec := put("bytes 7-9/10", "789")
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rec.Code)
+	assert.Equal(t, "bytes */5", rec.Header().Get("Content-Range"))
+	assert.Equal(t, http.StatusBadRequest, put("bytes 5-9/10", "56").Code)
+	assert.Equal(t, http.StatusNoContent, put("bytes 5-9/10", "56789").Code)
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "upload.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+
+	// Starting over at 0 replaces the file
+	assert.Equal(t, http.StatusNoContent, put("bytes 0-2/*", "abc").Code)
+	data, err = os.ReadFile(filepath.Join(tempDir, "upload.bin"))
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(data))
+}
@@ -0,0 +1,136 @@
+package vfsdav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// davNamespace is the XML namespace of the WebDAV elements
+const davNamespace = "DAV:"
+
+// rawXML holds the content of an XML element. It is re-encoded when decoded
+// so that it no longer depends on namespace prefixes declared by the
+// enclosing request document and can be embedded in any response.
+type rawXML string
+
+// UnmarshalXML implements xml.Unmarshaler
+func (v *rawXML) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var buf bytes.Buffer
+	enc := xml.NewEncoder(&buf)
+
+	for depth := 0; ; {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			// Namespace declarations are regenerated by the encoder
+			attrs := t.Attr[:0]
+			for _, attr := range t.Attr {
+				if attr.Name.Space != "xmlns" && !(attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+					attrs = append(attrs, attr)
+				}
+			}
+			t.Attr = attrs
+			tok = t
+		case xml.EndElement:
+			if depth == 0 {
+				if err := enc.Flush(); err != nil {
+					return err
+				}
+				*v = rawXML(buf.String())
+				return nil
+			}
+			depth--
+		case xml.ProcInst, xml.Directive:
+			continue
+		}
+
+		if err := enc.EncodeToken(tok); err != nil {
+			return err
+		}
+	}
+}
+
+// davProp is a property of a resource with its XML content
+type davProp struct {
+	Name  xml.Name
+	Value string
+}
+
+// davResponse is a response element of a multistatus document
+type davResponse struct {
+	Path string
+	// Props maps a status code to the properties reported with it
+	Props map[int][]davProp
+}
+
+// writeProp appends a property element to b
+func writeProp(b *strings.Builder, prop davProp) {
+	name := prop.Name.Local
+	if prop.Name.Space == davNamespace {
+		name = "D:" + name
+		b.WriteString("<" + name)
+	} else {
+		b.WriteString("<" + name + ` xmlns="` + xmlEscape(prop.Name.Space) + `"`)
+	}
+
+	if prop.Value == "" {
+		b.WriteString("/>")
+		return
+	}
+	b.WriteString(">" + prop.Value + "</" + name + ">")
+}
+
+// writeMultiStatus sends a 207 Multi-Status response
+func writeMultiStatus(w http.ResponseWriter, responses []davResponse) error {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<D:multistatus xmlns:D="DAV:">`)
+	for _, resp := range responses {
+		b.WriteString("<D:response>")
+		writeHref(&b, resp.Path)
+		codes := make([]int, 0, len(resp.Props))
+		for code := range resp.Props {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			props := resp.Props[code]
+			b.WriteString("<D:propstat><D:prop>")
+			for _, prop := range props {
+				writeProp(&b, prop)
+			}
+			b.WriteString("</D:prop>")
+			fmt.Fprintf(&b, "<D:status>HTTP/1.1 %d %s</D:status>", code, http.StatusText(code))
+			b.WriteString("</D:propstat>")
+		}
+		b.WriteString("</D:response>")
+	}
+	b.WriteString("</D:multistatus>")
+
+	w.Header().Set("Content-Type", `application/xml; charset="utf-8"`)
+	w.WriteHeader(http.StatusMultiStatus)
+	_, err := w.Write([]byte(b.String()))
+	return err
+}
+
+// writeHref appends an href element for the given path to b
+func writeHref(b *strings.Builder, name string) {
+	b.WriteString("<D:href>" + xmlEscape((&url.URL{Path: name}).EscapedPath()) + "</D:href>")
+}
+
+// xmlEscape escapes s for use in XML text and attribute values
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
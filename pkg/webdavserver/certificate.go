@@ -0,0 +1,69 @@
+package webdavserver
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// CertificateOptions configures the certificate resolved by PrepareCertificate
+type CertificateOptions struct {
+	// CertFile and KeyFile are the PEM encoded certificate and private key
+	CertFile string
+	KeyFile  string
+
+	// AutoGenerate creates a self-signed certificate when the files are not
+	// given or do not exist yet
+	AutoGenerate bool
+	// CertDir is where generated certificates are stored when no file names
+	// are given, as Name.crt and Name.key
+	CertDir      string
+	Name         string
+	ValidityDays int
+	Organization string
+}
+
+// PrepareCertificate resolves the certificate files to use, generating a
+// self-signed certificate if allowed and needed. A certificate generated
+// before in CertDir is reused.
+func PrepareCertificate(options CertificateOptions) (certFile, keyFile string, err error) {
+	certFile, keyFile = options.CertFile, options.KeyFile
+
+	if fileExists(certFile) && fileExists(keyFile) {
+		return certFile, keyFile, nil
+	}
+	if !options.AutoGenerate {
+		return "", "", fmt.Errorf("certificate files not found at %q and %q and auto-generation is disabled", certFile, keyFile)
+	}
+
+	if certFile == "" || keyFile == "" {
+		if options.CertDir == "" || options.Name == "" {
+			return "", "", fmt.Errorf("no certificate files and no certificates directory given")
+		}
+		if err := os.MkdirAll(options.CertDir, 0755); err != nil {
+			return "", "", fmt.Errorf("failed to create certificates directory: %w", err)
+		}
+		if certFile == "" {
+			certFile = filepath.Join(options.CertDir, options.Name+".crt")
+		}
+		if keyFile == "" {
+			keyFile = filepath.Join(options.CertDir, options.Name+".key")
+		}
+
+		// Reuse a previously generated certificate
+		if fileExists(certFile) && fileExists(keyFile) {
+			return certFile, keyFile, nil
+		}
+	}
+
+	validityDays := options.ValidityDays
+	if validityDays <= 0 {
+		validityDays = 365
+	}
+	if err := GenerateCertificate(certFile, keyFile, options.Organization, validityDays); err != nil {
+		return "", "", fmt.Errorf("failed to generate certificates: %w", err)
+	}
+	log.Printf("Generated self-signed certificate at %s and %s", certFile, keyFile)
+	return certFile, keyFile, nil
+}
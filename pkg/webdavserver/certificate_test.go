@@ -0,0 +1,62 @@
+package webdavserver
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrepareCertificate(t *testing.T) {
+	dir := t.TempDir()
+	options := CertificateOptions{AutoGenerate: true, CertDir: filepath.Join(dir, "certificates"), Name: "test", Organization: "Test"}
+
+	// A certificate is generated in the certificates directory
+	certFile, keyFile, err := PrepareCertificate(options)
+	if err != nil {
+		t.Fatalf("Failed to prepare certificate: %v", err)
+	}
+	if certFile != filepath.Join(dir, "certificates", "test.crt") || keyFile != filepath.Join(dir, "certificates", "test.key") {
+		t.Errorf("Unexpected certificate files %s, %s", certFile, keyFile)
+	}
+	if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		t.Fatalf("Failed to load generated certificate: %v", err)
+	}
+
+	// and reused afterwards
+	generated, _ := os.ReadFile(certFile)
+	if _, _, err := PrepareCertificate(options); err != nil {
+		t.Fatalf("Failed to prepare certificate again: %v", err)
+	}
+	if reused, _ := os.ReadFile(certFile); string(reused) != string(generated) {
+		t.Error("Expected the generated certificate to be reused")
+	}
+
+	// Existing files are used as they are
+	existing := CertificateOptions{CertFile: certFile, KeyFile: keyFile}
+	if gotCert, gotKey, err := PrepareCertificate(existing); err != nil || gotCert != certFile || gotKey != keyFile {
+		t.Errorf("Expected the existing files, got %s, %s, %v", gotCert, gotKey, err)
+	}
+
+	// Missing files are generated at the given paths
+	named := CertificateOptions{CertFile: filepath.Join(dir, "named.crt"), KeyFile: filepath.Join(dir, "named.key"), AutoGenerate: true}
+	if gotCert, _, err := PrepareCertificate(named); err != nil || gotCert != named.CertFile {
+		t.Errorf("Expected a certificate at %s, got %s, %v", named.CertFile, gotCert, err)
+	}
+
+	tests := []struct {
+		name    string
+		options CertificateOptions
+		want    string
+	}{
+		{"missing files", CertificateOptions{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: filepath.Join(dir, "missing.key")}, "auto-generation is disabled"},
+		{"no files", CertificateOptions{}, "auto-generation is disabled"},
+		{"no directory", CertificateOptions{AutoGenerate: true, Name: "test"}, "no certificates directory"},
+	}
+	for _, test := range tests {
+		if _, _, err := PrepareCertificate(test.options); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", test.name, test.want, err)
+		}
+	}
+}
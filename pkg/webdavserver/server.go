@@ -21,6 +21,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/freeflowuniverse/herolauncher/pkg/system/stats/metrics"
 	"golang.org/x/net/webdav"
 )
 
@@ -42,6 +43,10 @@ type Config struct {
 	AutoGenerateCerts   bool
 	CertValidityDays    int
 	CertOrganization    string
+	// Metrics receives request, traffic, error and connection counters when
+	// set; MetricsPath, e.g. "/metrics", serves the registry
+	Metrics             *metrics.Registry
+	MetricsPath         string
 }
 
 // Server represents the WebDAV server
@@ -135,8 +140,8 @@ func (s *Server) Start() error {
 	// Create a mux to handle the WebDAV requests
 	mux := http.NewServeMux()
 
-	// Register the WebDAV handler at the base path
-	mux.HandleFunc(s.config.BasePath, func(w http.ResponseWriter, r *http.Request) {
+	// Create the WebDAV handler for the base path
+	var davHandler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Enhanced debug logging
 		s.debugLog("Received request: %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
 		s.debugLog("Request Protocol: %s", r.Proto)
@@ -292,6 +297,20 @@ func (s *Server) Start() error {
 		}
 	})
 
+	// Count requests, traffic and connections if metrics are enabled
+	if s.config.Metrics != nil {
+		httpMetrics := metrics.NewHTTPMetrics(s.config.Metrics, "webdavserver")
+		davHandler = httpMetrics.Handler(davHandler)
+		s.httpServer.ConnState = httpMetrics.ConnState
+		if s.config.MetricsPath != "" {
+			mux.Handle(s.config.MetricsPath, s.config.Metrics.Handler())
+			log.Printf("Serving metrics at %s", s.config.MetricsPath)
+		}
+	}
+
+	// Register the WebDAV handler at the base path
+	mux.Handle(s.config.BasePath, davHandler)
+
 	// Set the mux as the HTTP server handler
 	s.httpServer.Handler = mux
 
@@ -419,6 +438,11 @@ func fileExists(filename string) bool {
 	return err == nil && !info.IsDir()
 }
 
+// GenerateCertificate creates a self-signed TLS certificate and key valid for localhost
+func GenerateCertificate(certFile, keyFile, organization string, validityDays int) error {
+	return generateCertificate(certFile, keyFile, organization, validityDays, func(string, ...interface{}) {})
+}
+
 // generateCertificate creates a self-signed TLS certificate and key
 func generateCertificate(certFile, keyFile, organization string, validityDays int, debugLog func(format string, args ...interface{})) error {
 	debugLog("Generating self-signed certificate: certFile=%s, keyFile=%s, organization=%s, validityDays=%d", 